@@ -0,0 +1,110 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"errors"
+	"syscall/js"
+)
+
+func main() {
+	js.Global().Set("goguardml", js.ValueOf(map[string]any{
+		"load": js.FuncOf(func(_ js.Value, args []js.Value) any {
+			if len(args) != 1 {
+				return jsError(errors.New("load: want a Uint8Array"))
+			}
+			data := make([]byte, args[0].Get("length").Int())
+			js.CopyBytesToGo(data, args[0])
+			m, err := loadModel(data)
+			if err != nil {
+				return jsError(err)
+			}
+			return m.js()
+		}),
+	}))
+	// Keep the functions callable.
+	select {}
+}
+
+// js returns the JavaScript object of m.
+func (m *model) js() js.Value {
+	return js.ValueOf(map[string]any{
+		"score": js.FuncOf(func(_ js.Value, args []js.Value) any {
+			if len(args) != 1 {
+				return jsError(errors.New("score: want a sample"))
+			}
+			r, err := m.score(floats(args[0]))
+			if err != nil {
+				return jsError(err)
+			}
+			return r.js()
+		}),
+		"scoreBatch": js.FuncOf(func(_ js.Value, args []js.Value) any {
+			if len(args) != 1 {
+				return jsError(errors.New("scoreBatch: want an array of samples"))
+			}
+			samples := make([][]float64, args[0].Length())
+			for i := range samples {
+				samples[i] = floats(args[0].Index(i))
+			}
+			results, err := m.scoreBatch(samples)
+			if err != nil {
+				return jsError(err)
+			}
+			out := make([]any, len(results))
+			for i, r := range results {
+				out[i] = r.js()
+			}
+			return js.ValueOf(out)
+		}),
+		"explain": js.FuncOf(func(_ js.Value, args []js.Value) any {
+			if len(args) != 1 {
+				return jsError(errors.New("explain: want a sample"))
+			}
+			contributions, err := m.explain(floats(args[0]))
+			if err != nil {
+				return jsError(err)
+			}
+			out := make([]any, len(contributions))
+			for i, c := range contributions {
+				out[i] = map[string]any{"feature": c.Feature, "contribution": c.Contribution}
+			}
+			return js.ValueOf(out)
+		}),
+		"threshold": js.FuncOf(func(js.Value, []js.Value) any {
+			return m.f.Threshold()
+		}),
+		"setThreshold": js.FuncOf(func(_ js.Value, args []js.Value) any {
+			if len(args) != 1 || args[0].Type() != js.TypeNumber {
+				return jsError(errors.New("setThreshold: want a number"))
+			}
+			m.f.SetThreshold(args[0].Float())
+			return js.Undefined()
+		}),
+		"features": js.FuncOf(func(js.Value, []js.Value) any {
+			out := make([]any, len(m.names))
+			for i, name := range m.names {
+				out[i] = name
+			}
+			return js.ValueOf(out)
+		}),
+	})
+}
+
+func (r result) js() js.Value {
+	return js.ValueOf(map[string]any{"score": r.Score, "anomaly": r.Anomaly})
+}
+
+// floats converts an Array or typed array of numbers.
+func floats(v js.Value) []float64 {
+	out := make([]float64, v.Length())
+	for i := range out {
+		out[i] = v.Index(i).Float()
+	}
+	return out
+}
+
+// jsError returns err as a JavaScript Error.
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
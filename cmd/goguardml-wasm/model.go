@@ -0,0 +1,96 @@
+// Command goguardml-wasm exposes Isolation Forest scoring to JavaScript,
+// for in-browser scoring and WebAssembly-based proxies. Build it with Go
+// or TinyGo:
+//
+//	GOOS=js GOARCH=wasm go build -o goguardml.wasm ./cmd/goguardml-wasm
+//	tinygo build -target wasm -o goguardml.wasm ./cmd/goguardml-wasm
+//
+// and load it with the wasm_exec.js of the same toolchain. It defines a
+// global goguardml object:
+//
+//	const model = goguardml.load(bytes)  // Uint8Array of a saved model
+//	model.score([0.1, 3, 250])           // {score: 0.42, anomaly: false}
+//	model.scoreBatch([[...], [...]])     // an array of the same
+//	model.explain([0.1, 3, 250])         // [{feature: "bytes", contribution: 0.61}, ...]
+//	model.threshold()                    // 0.62
+//	model.setThreshold(0.7)
+//	model.features()                     // ["packets", "bytes", ...], if recorded
+//
+// Go functions cannot throw: on failure, functions return a JavaScript
+// Error instead, so callers check the result with instanceof Error.
+package main
+
+import (
+	"fmt"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+// model is a loaded model and the names of its features.
+type model struct {
+	f     *iforest.IsolationForest
+	names []string
+}
+
+// result is the score of a sample.
+type result struct {
+	Score   float64
+	Anomaly bool
+}
+
+// contribution is a feature's share of a score.
+type contribution struct {
+	Feature      string
+	Contribution float64
+}
+
+// loadModel loads a model saved by Save or SaveFlat.
+func loadModel(data []byte) (*model, error) {
+	f := iforest.New()
+	if err := f.Load(data); err != nil {
+		return nil, fmt.Errorf("load model: %w", err)
+	}
+	return &model{f: f, names: f.Metadata().FeatureNames}, nil
+}
+
+func (m *model) score(sample []float64) (result, error) {
+	v, err := m.f.PredictOne(sample)
+	if err != nil {
+		return result{}, err
+	}
+	return result{Score: v, Anomaly: v >= m.f.Threshold()}, nil
+}
+
+func (m *model) scoreBatch(samples [][]float64) ([]result, error) {
+	scores, err := m.f.Predict(samples)
+	if err != nil {
+		return nil, err
+	}
+	threshold := m.f.Threshold()
+	results := make([]result, len(scores))
+	for i, v := range scores {
+		results[i] = result{Score: v, Anomaly: v >= threshold}
+	}
+	return results, nil
+}
+
+// explain returns the contributions of the features of sample to its
+// score, highest first, with features named by index if unnamed.
+func (m *model) explain(sample []float64) ([]contribution, error) {
+	e, err := m.f.Explain(sample)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]contribution, len(e.Top))
+	for i, c := range e.Top {
+		out[i] = contribution{Feature: m.feature(c.Index), Contribution: c.Contribution}
+	}
+	return out, nil
+}
+
+func (m *model) feature(i int) string {
+	if i < len(m.names) {
+		return m.names[i]
+	}
+	return fmt.Sprintf("feature_%d", i)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func savedModel(t *testing.T, opts ...iforest.Option) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	data := make([][]float64, 300)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), rng.NormFloat64()}
+	}
+	f := iforest.New(append([]iforest.Option{iforest.WithTrees(20), iforest.WithSeed(1)}, opts...)...)
+	require.NoError(t, f.Fit(data))
+	model, err := f.Save()
+	require.NoError(t, err)
+	return model
+}
+
+func TestModel(t *testing.T) {
+	m, err := loadModel(savedModel(t, iforest.WithFeatureNames([]string{"packets", "bytes"})))
+	require.NoError(t, err)
+
+	normal, err := m.score([]float64{0, 0})
+	require.NoError(t, err)
+	assert.False(t, normal.Anomaly)
+	outlier, err := m.score([]float64{8, -8})
+	require.NoError(t, err)
+	assert.True(t, outlier.Anomaly)
+
+	batch, err := m.scoreBatch([][]float64{{0, 0}, {8, -8}})
+	require.NoError(t, err)
+	assert.Equal(t, []result{normal, outlier}, batch)
+
+	contributions, err := m.explain([]float64{8, 0})
+	require.NoError(t, err)
+	require.NotEmpty(t, contributions)
+	assert.Equal(t, "packets", contributions[0].Feature)
+
+	_, err = m.score([]float64{1})
+	assert.Error(t, err)
+	_, err = m.scoreBatch([][]float64{{1, 2, 3}})
+	assert.Error(t, err)
+}
+
+func TestModelWithoutNames(t *testing.T) {
+	m, err := loadModel(savedModel(t, iforest.WithQuantization(8)))
+	require.NoError(t, err)
+	contributions, err := m.explain([]float64{0, 9})
+	require.NoError(t, err)
+	require.NotEmpty(t, contributions)
+	assert.Equal(t, "feature_1", contributions[0].Feature)
+
+	_, err = loadModel([]byte("not a model"))
+	assert.ErrorContains(t, err, "load model")
+}
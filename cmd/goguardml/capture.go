@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/pcap"
+	"github.com/hed1ad/goguardml/pkg/io/snapshot"
+)
+
+func newCaptureCmd() *cobra.Command {
+	var (
+		iface     string
+		modelPath string
+		shadow    string
+		handover  string
+		warmup    time.Duration
+		algo      string
+		out       string
+		snaplen   int32
+		promisc   bool
+		duration  time.Duration
+		threshold float64
+		format    string
+		gf        grafanaFlags
+		sev       severityFlags
+		snap      snapshotFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Capture live traffic and extract features or score packets",
+		Long: "Capture packets from a network interface. Without --model, packet " +
+			"features are written as CSV for later training. With --model, each " +
+			"packet is scored and results are written as JSON Lines. Needs a " +
+			"build with libpcap, without the nopcap tag.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			// Feature vectors are recycled once written, so a long capture
+			// does not allocate one per packet.
+			pool := guardio.NewSamplePool(pcap.NumFeatures, 0)
+			reader, err := pcap.NewLiveReader(iface, snaplen, promisc, pcapTimeout, pcap.WithSamplePool(pool))
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if duration > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, duration)
+				defer cancel()
+			}
+
+			if modelPath == "" {
+				samples, err := reader.Stream(ctx)
+				if err != nil {
+					return err
+				}
+				return writeFeatures(cmd, out, samples, pool)
+			}
+
+			d, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+			if t, ok := d.(detectors.Thresholder); ok && cmd.Flags().Changed("threshold") {
+				t.SetThreshold(threshold)
+			}
+			var sh *detectors.Shadow
+			if shadow != "" {
+				sd, err := loadDetector(shadow, algo)
+				if err != nil {
+					return err
+				}
+				sh = detectors.NewShadow(d, sd)
+				d = sh
+			}
+			if handover != "" {
+				if shadow != "" {
+					return errors.New("--shadow and --handover are exclusive")
+				}
+				candidate, err := loadDetector(handover, algo)
+				if err != nil {
+					return err
+				}
+				h := detectors.NewHandover(d, detectors.WithWarmup(warmup), detectors.WithHandoverHandler(func(r detectors.HandoverReport) {
+					printHandover(cmd, r)
+				}))
+				if err := h.Stage(candidate); err != nil {
+					return err
+				}
+				d = h
+			}
+			samples, err := reader.StreamSamples(ctx)
+			if err != nil {
+				return err
+			}
+			if err := scoreStream(ctx, cmd, d, out, format, gf, sev, snap, samples, pool); err != nil {
+				return err
+			}
+			if sh != nil {
+				printShadowStats(cmd, sh.Stats())
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&iface, "iface", "", "network interface to capture from")
+	cmd.Flags().StringVar(&modelPath, "model", "", "trained model file (omit to only extract features)")
+	cmd.Flags().StringVar(&shadow, "shadow", "", "candidate model file scoring the same packets for comparison, without emitting results")
+	cmd.Flags().StringVar(&handover, "handover", "", "candidate model file warming up on the same packets, then calibrated and put in service")
+	cmd.Flags().DurationVar(&warmup, "warmup", 10*time.Minute, "how long the --handover candidate warms up before taking over")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&out, "out", "", "output file (default stdout)")
+	cmd.Flags().StringVar(&format, "format", "jsonl", resultFormatUsage+" (with --model)")
+	cmd.Flags().Int32Var(&snaplen, "snaplen", 65535, "maximum bytes captured per packet")
+	cmd.Flags().BoolVar(&promisc, "promisc", false, "enable promiscuous mode")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "stop after this long (0 = until interrupted)")
+	cmd.Flags().Float64Var(&threshold, "threshold", 0, "override the model's anomaly threshold")
+	gf.register(cmd)
+	sev.register(cmd)
+	snap.register(cmd)
+	_ = cmd.MarkFlagRequired("iface")
+
+	return cmd
+}
+
+// printShadowStats reports how a shadow model compared with the live one.
+func printShadowStats(cmd *cobra.Command, st detectors.ShadowStats) {
+	fmt.Fprintf(cmd.ErrOrStderr(),
+		"shadow: %d samples, %.2f%% agreement (%d flagged by both, %d by live only, %d by shadow only), mean score difference %.4f, correlation %.4f, %d errors\n",
+		st.Samples, 100*st.AgreementRate(), st.BothAnomalous, st.LiveOnly, st.ShadowOnly, st.MeanAbsDiff, st.Correlation, st.Errors)
+}
+
+// printHandover reports a candidate model taking over.
+func printHandover(cmd *cobra.Command, r detectors.HandoverReport) {
+	fmt.Fprintf(cmd.ErrOrStderr(),
+		"handover: candidate in service after %d samples in %s, threshold %.4f calibrated to %.2f%% anomalies\n",
+		r.Samples, r.Completed.Sub(r.Started).Round(time.Second), r.Threshold, 100*r.TargetRate)
+}
+
+// snapshotFlags are the flags saving the samples around incidents.
+type snapshotFlags struct {
+	dir     string
+	trigger float64
+	before  time.Duration
+	after   time.Duration
+}
+
+func (f *snapshotFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.dir, "snapshot-dir", "", "save the samples around incidents, when the anomaly rate over a minute crosses --snapshot-trigger, to JSON Lines files in this directory")
+	cmd.Flags().Float64Var(&f.trigger, "snapshot-trigger", 0.2, "anomaly rate starting an incident snapshot")
+	cmd.Flags().DurationVar(&f.before, "snapshot-before", 5*time.Minute, "how far back incident snapshots start")
+	cmd.Flags().DurationVar(&f.after, "snapshot-after", 5*time.Minute, "how long incident snapshots go on once the anomaly rate is back below the trigger")
+}
+
+// wrap returns w, also snapshotting incidents if --snapshot-dir is set.
+func (f *snapshotFlags) wrap(cmd *cobra.Command, w guardio.Writer) (guardio.Writer, error) {
+	if f.dir == "" {
+		return w, nil
+	}
+	sw, err := snapshot.New(f.dir,
+		snapshot.WithTrigger(f.trigger),
+		snapshot.WithBefore(f.before),
+		snapshot.WithAfter(f.after),
+		snapshot.WithIncidentHandler(func(i snapshot.Incident) {
+			fmt.Fprintf(cmd.ErrOrStderr(), "incident: %d samples (%d anomalies) from %s to %s saved to %s\n",
+				i.Samples, i.Anomalies, i.Start.Format(time.RFC3339), i.End.Format(time.RFC3339), i.Path)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return guardio.MultiWriter(w, sw), nil
+}
+
+// pcapTimeout is the read timeout for live capture handles.
+const pcapTimeout = 500 * time.Millisecond
+
+// writeFeatures writes raw feature vectors as CSV with a header row and
+// returns each vector to pool once written.
+func writeFeatures(cmd *cobra.Command, path string, samples <-chan []float64, pool *guardio.SamplePool) error {
+	dst := cmd.OutOrStdout()
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		dst = file
+	}
+
+	w := csv.NewWriter(dst)
+	if err := w.Write(pcap.NewFeatureExtractor().FeatureNames()); err != nil {
+		return err
+	}
+
+	record := make([]string, 0, 8)
+	for sample := range samples {
+		record = record[:0]
+		for _, v := range sample {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		pool.Put(sample)
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// scoreStream scores samples as they arrive and writes results stamped
+// with each sample's capture time and sequence number, returning each
+// sample to pool once its result is written. Samples the detector rejects
+// are counted and reported on stderr.
+func scoreStream(ctx context.Context, cmd *cobra.Command, d detectors.StreamDetector, path, format string, gf grafanaFlags, sev severityFlags, snap snapshotFlags, samples <-chan guardio.Sample, pool *guardio.SamplePool) error {
+	out, err := newResultWriter(cmd, path, format)
+	if err != nil {
+		return err
+	}
+	w, err := gf.wrap(out)
+	if err == nil {
+		w, err = sev.wrap(w)
+	}
+	if err == nil {
+		// Snapshots keep every result, whatever the severity filter drops.
+		w, err = snap.wrap(cmd, w)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer w.Close()
+
+	features, queue := guardio.SplitSamples(ctx, samples)
+
+	var (
+		rejected  int
+		rejectErr error
+	)
+	if r, ok := d.(detectors.RejectReporter); ok {
+		r.SetRejectHandler(func(rej detectors.Rejection) {
+			if rejected == 0 {
+				rejectErr = rej.Err
+			}
+			rejected++
+			queue.Match(rej.Sample)
+			pool.Put(rej.Sample)
+		})
+		defer r.SetRejectHandler(nil)
+	}
+
+	scores := make(chan detectors.Score, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.PredictStream(ctx, features, scores)
+	}()
+
+	for score := range scores {
+		result := guardio.Result{
+			Timestamp: time.Now().Unix(),
+			Score:     score.Value,
+			IsAnomaly: score.IsAnomaly,
+			Features:  score.Features,
+		}
+		if sample, ok := queue.Match(score.Features); ok {
+			result.Timestamp = sample.Time.Unix()
+			result.Seq = sample.Seq
+		}
+		err := w.Write(result)
+		pool.Put(score.Features)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The handler has run for the last time once PredictStream returns.
+	if err := <-errCh; err != nil && ctx.Err() == nil {
+		return fmt.Errorf("stream: %w", err)
+	}
+	if rejected > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "%d samples rejected (first: %v)\n", rejected, rejectErr)
+	}
+	return nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/bundle"
+	"github.com/hed1ad/goguardml/pkg/data"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/autoencoder"
+	"github.com/hed1ad/goguardml/pkg/detectors/copod"
+	"github.com/hed1ad/goguardml/pkg/detectors/dbscan"
+	"github.com/hed1ad/goguardml/pkg/detectors/eif"
+	"github.com/hed1ad/goguardml/pkg/detectors/entropy"
+	"github.com/hed1ad/goguardml/pkg/detectors/hbos"
+	"github.com/hed1ad/goguardml/pkg/detectors/holtwinters"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/detectors/iqr"
+	"github.com/hed1ad/goguardml/pkg/detectors/knn"
+	"github.com/hed1ad/goguardml/pkg/detectors/matrixprofile"
+	"github.com/hed1ad/goguardml/pkg/detectors/mcd"
+	"github.com/hed1ad/goguardml/pkg/detectors/sr"
+	"github.com/hed1ad/goguardml/pkg/detectors/zscore"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/accesslog"
+	"github.com/hed1ad/goguardml/pkg/io/csv"
+	"github.com/hed1ad/goguardml/pkg/io/parquet"
+	"github.com/hed1ad/goguardml/pkg/io/pcap"
+)
+
+// detectorOptions holds hyperparameters shared by training commands.
+type detectorOptions struct {
+	trees         int
+	sampleSize    int
+	contamination float64
+	seed          int64
+	// excludeConstant keeps features that are constant in the training
+	// data out of splits.
+	excludeConstant bool
+	// quantize is the width split values are quantized to, 0 for none.
+	quantize int
+	// featureWeights biases splits toward features, nil for equal weights.
+	featureWeights []float64
+	// bins is the HBOS bin count per feature, 0 to choose it per feature.
+	bins int
+	// neighbors is the k-NN neighbor count.
+	neighbors int
+	// epochs is the number of autoencoder training passes.
+	epochs int
+	// extensionLevel is the extended isolation forest extension level,
+	// negative for the full level.
+	extensionLevel int
+	// robust measures z-scores from the median and MAD.
+	robust bool
+	// fenceFactor is the IQR fence distance in interquartile ranges.
+	fenceFactor float64
+	// eps is the DBSCAN neighborhood radius, 0 to choose it from the data.
+	eps float64
+	// minPoints is the DBSCAN core point neighborhood size.
+	minPoints int
+	// window is the matrix profile subsequence length, the spectral
+	// residual window or the entropy window, 0 for the algorithm's default.
+	window int
+	// season is the Holt-Winters season length.
+	season int
+	// fields are the indices of the features whose entropy is tracked,
+	// nil for all.
+	fields []int
+	// timeWindow is the duration of entropy time windows, 0 for windows
+	// of a number of events; timeField the feature holding event times.
+	timeWindow time.Duration
+	timeField  int
+
+	// Model card fields.
+	dataSource   string
+	featureNames []string
+}
+
+// newDetector creates an untrained detector for the named algorithm.
+func newDetector(algo string, o detectorOptions) (detectors.StreamDetector, error) {
+	switch algo {
+	case "iforest":
+		f := iforest.New(
+			iforest.WithTrees(o.trees),
+			iforest.WithSampleSize(o.sampleSize),
+			iforest.WithContamination(o.contamination),
+			iforest.WithSeed(o.seed),
+			iforest.WithExcludeConstant(o.excludeConstant),
+			iforest.WithQuantization(o.quantize),
+			iforest.WithFeatureWeights(o.featureWeights),
+			iforest.WithDataSource(o.dataSource),
+			iforest.WithFeatureNames(o.featureNames),
+			iforest.WithSigningKey(modelKey),
+		)
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case "hbos":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		h := hbos.New(
+			hbos.WithBins(o.bins),
+			hbos.WithContamination(o.contamination),
+			hbos.WithDataSource(o.dataSource),
+			hbos.WithFeatureNames(o.featureNames),
+		)
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	case "knn":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		d := knn.New(
+			knn.WithK(o.neighbors),
+			knn.WithContamination(o.contamination),
+			knn.WithDataSource(o.dataSource),
+			knn.WithFeatureNames(o.featureNames),
+		)
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case "autoencoder":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		a := autoencoder.New(
+			autoencoder.WithEpochs(o.epochs),
+			autoencoder.WithSeed(o.seed),
+			autoencoder.WithContamination(o.contamination),
+			autoencoder.WithDataSource(o.dataSource),
+			autoencoder.WithFeatureNames(o.featureNames),
+		)
+		if err := a.Validate(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	case "mcd":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		m := mcd.New(
+			mcd.WithSeed(o.seed),
+			mcd.WithContamination(o.contamination),
+			mcd.WithDataSource(o.dataSource),
+			mcd.WithFeatureNames(o.featureNames),
+		)
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case "copod":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		c := copod.New(
+			copod.WithContamination(o.contamination),
+			copod.WithDataSource(o.dataSource),
+			copod.WithFeatureNames(o.featureNames),
+		)
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "eif":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		e := eif.New(
+			eif.WithTrees(o.trees),
+			eif.WithSampleSize(o.sampleSize),
+			eif.WithExtensionLevel(o.extensionLevel),
+			eif.WithSeed(o.seed),
+			eif.WithContamination(o.contamination),
+			eif.WithDataSource(o.dataSource),
+			eif.WithFeatureNames(o.featureNames),
+		)
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "zscore":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		method := zscore.Standard
+		if o.robust {
+			method = zscore.Robust
+		}
+		z := zscore.New(
+			zscore.WithMethod(method),
+			zscore.WithContamination(o.contamination),
+			zscore.WithDataSource(o.dataSource),
+			zscore.WithFeatureNames(o.featureNames),
+		)
+		if err := z.Validate(); err != nil {
+			return nil, err
+		}
+		return z, nil
+	case "iqr":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		// The threshold stays at the fences rather than following
+		// --contamination, so flags keep their plain reading.
+		q := iqr.New(
+			iqr.WithFenceFactor(o.fenceFactor),
+			iqr.WithDataSource(o.dataSource),
+			iqr.WithFeatureNames(o.featureNames),
+		)
+		if err := q.Validate(); err != nil {
+			return nil, err
+		}
+		return q, nil
+	case "dbscan":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		// As with iqr, the threshold stays at eps: most training points
+		// score 0, leaving nothing for --contamination to calibrate.
+		d := dbscan.New(
+			dbscan.WithEps(o.eps),
+			dbscan.WithMinPoints(o.minPoints),
+			dbscan.WithDataSource(o.dataSource),
+			dbscan.WithFeatureNames(o.featureNames),
+		)
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case "matrixprofile":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		opts := []matrixprofile.Option{
+			matrixprofile.WithContamination(o.contamination),
+			matrixprofile.WithSeed(o.seed),
+			matrixprofile.WithDataSource(o.dataSource),
+			matrixprofile.WithFeatureNames(o.featureNames),
+		}
+		if o.window > 0 {
+			opts = append(opts, matrixprofile.WithWindow(o.window))
+		}
+		m := matrixprofile.New(opts...)
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case "sr":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		opts := []sr.Option{
+			sr.WithContamination(o.contamination),
+			sr.WithDataSource(o.dataSource),
+			sr.WithFeatureNames(o.featureNames),
+		}
+		if o.window > 0 {
+			opts = append(opts, sr.WithWindow(o.window))
+		}
+		r := sr.New(opts...)
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case "holtwinters":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		h := holtwinters.New(
+			holtwinters.WithSeason(o.season),
+			holtwinters.WithContamination(o.contamination),
+			holtwinters.WithDataSource(o.dataSource),
+			holtwinters.WithFeatureNames(o.featureNames),
+		)
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	case "entropy":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		// As with iqr, the threshold stays at its default of three robust
+		// deviations: entropies of overlapping windows are too correlated
+		// for --contamination to calibrate.
+		opts := []entropy.Option{
+			entropy.WithFields(o.fields...),
+			entropy.WithDataSource(o.dataSource),
+			entropy.WithFeatureNames(o.featureNames),
+		}
+		if o.window > 0 {
+			opts = append(opts, entropy.WithWindow(o.window))
+		}
+		if o.timeWindow != 0 {
+			opts = append(opts, entropy.WithTimeWindow(o.timeField, o.timeWindow))
+		}
+		e := entropy.New(opts...)
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algo)
+	}
+}
+
+// loadDetector reads a saved model of the named algorithm from disk.
+// Bundles (.tar.gz or .tgz) carry their own algorithm and threshold.
+func loadDetector(path, algo string) (detectors.StreamDetector, error) {
+	if isBundle(path) {
+		b, err := bundle.Import(path)
+		if err != nil {
+			return nil, fmt.Errorf("load bundle %s: %w", path, err)
+		}
+		d, err := emptyDetector(b.Manifest.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Load(d); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	if algo == "iforest" {
+		f, err := iforest.OpenMapped(path, iforest.WithSigningKey(modelKey))
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, iforest.ErrNotFlat) {
+			return nil, fmt.Errorf("load model %s: %w", path, err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d, err := emptyDetector(algo)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.LoadFrom(file); err != nil {
+		return nil, fmt.Errorf("load model %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// emptyDetector returns an untrained detector to load a model into.
+func emptyDetector(algo string) (detectors.StreamDetector, error) {
+	switch algo {
+	case "iforest":
+		return iforest.New(iforest.WithSigningKey(modelKey)), nil
+	case "hbos":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return hbos.New(), nil
+	case "knn":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return knn.New(), nil
+	case "autoencoder":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return autoencoder.New(), nil
+	case "mcd":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return mcd.New(), nil
+	case "copod":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return copod.New(), nil
+	case "eif":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return eif.New(), nil
+	case "zscore":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return zscore.New(), nil
+	case "iqr":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return iqr.New(), nil
+	case "dbscan":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return dbscan.New(), nil
+	case "matrixprofile":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return matrixprofile.New(), nil
+	case "sr":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return sr.New(), nil
+	case "holtwinters":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return holtwinters.New(), nil
+	case "entropy":
+		if modelKey != nil {
+			return nil, errUnsigned(algo)
+		}
+		return entropy.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algo)
+	}
+}
+
+// errUnsigned reports an algorithm whose models cannot be signed, so
+// --model-key could not be honored.
+func errUnsigned(algo string) error {
+	return fmt.Errorf("%s models cannot be signed; run without --model-key", algo)
+}
+
+// isBundle reports whether path names a model bundle.
+func isBundle(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// modelKey is the key models are signed and verified with, from the root
+// command's --model-key flag; nil without one.
+var modelKey []byte
+
+// CSV input settings from the root command's flags.
+var (
+	// strictInput makes CSV readers fail on malformed rows instead of
+	// skipping them.
+	strictInput bool
+	// raggedInput is the policy for CSV rows with missing or extra fields.
+	raggedInput string
+)
+
+// raggedOptions returns the CSV reader options for a --ragged value.
+// Padded fields are filled with the running column mean, as the detectors
+// do not accept NaN.
+func raggedOptions(policy string) ([]csv.Option, error) {
+	switch policy {
+	case "reject":
+		return []csv.Option{csv.WithRagged(csv.RaggedReject)}, nil
+	case "truncate":
+		return []csv.Option{csv.WithRagged(csv.RaggedTruncate)}, nil
+	case "pad":
+		return []csv.Option{csv.WithRagged(csv.RaggedPad), csv.WithImputer(data.NewMeanImputer())}, nil
+	default:
+		return nil, fmt.Errorf("unknown --ragged policy %q (want reject, truncate or pad)", policy)
+	}
+}
+
+// openReader opens a data file, choosing the reader by file extension:
+// .log files are HTTP access logs. A directory or glob pattern opens every
+// matching input file, read concurrently as one input.
+func openReader(path string, header bool) (guardio.Reader, error) {
+	paths, err := inputFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 1 {
+		return openFile(paths[0], header)
+	}
+	readers := make([]guardio.Reader, 0, len(paths))
+	for _, p := range paths {
+		r, err := openFile(p, header)
+		if err != nil {
+			guardio.NewMultiReader(readers).Close()
+			return nil, err
+		}
+		readers = append(readers, r)
+	}
+	return guardio.NewMultiReader(readers), nil
+}
+
+// inputKinds maps the extensions of the files a directory input reads to
+// their format.
+var inputKinds = map[string]string{
+	".pcap": "pcap", ".pcapng": "pcap", ".cap": "pcap",
+	".log":     "log",
+	".csv":     "csv",
+	".parquet": "parquet",
+}
+
+// inputFiles expands an input path: a directory to its input files, in
+// name order, and a glob pattern that is not itself a file to its matches.
+// The files must all have the same format.
+func inputFiles(path string) ([]string, error) {
+	var paths []string
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if _, ok := inputKinds[strings.ToLower(filepath.Ext(e.Name()))]; ok && !e.IsDir() {
+				paths = append(paths, filepath.Join(path, e.Name()))
+			}
+		}
+	case err != nil && strings.ContainsAny(path, "*?["):
+		if paths, err = filepath.Glob(path); err != nil {
+			return nil, err
+		}
+	default:
+		return []string{path}, nil
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files in %s", path)
+	}
+	kind := inputKinds[strings.ToLower(filepath.Ext(paths[0]))]
+	for _, p := range paths[1:] {
+		if inputKinds[strings.ToLower(filepath.Ext(p))] != kind {
+			return nil, fmt.Errorf("%s mixes input formats: %s and %s", path, filepath.Base(paths[0]), filepath.Base(p))
+		}
+	}
+	return paths, nil
+}
+
+// openFile opens one input file, picking the reader by extension.
+func openFile(path string, header bool) (guardio.Reader, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcap", ".pcapng", ".cap":
+		return pcap.NewFileReader(path)
+	case ".log":
+		return accesslog.NewFileReader(path)
+	case ".parquet":
+		return parquet.NewReader(path)
+	default:
+		ragged, err := raggedOptions(raggedInput)
+		if err != nil {
+			return nil, err
+		}
+		opts := append([]csv.Option{csv.WithHeader(header), csv.WithStrict(strictInput)}, ragged...)
+		return csv.NewReader(path, opts...)
+	}
+}
+
+// readAll reads the complete dataset from path, with feature names from
+// the CSV header row or the PCAP or access log feature extractor when
+// available.
+func readAll(path string, header bool) ([][]float64, []string, error) {
+	r, err := openReader(path, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	data, err := r.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	if s, ok := r.(interface{ Skipped() int }); ok && s.Skipped() > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed rows in %s (use --strict to fail instead)\n", s.Skipped(), path)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("no samples read from %s", path)
+	}
+
+	if m, ok := r.(*guardio.MultiReader); ok {
+		r = m.Readers()[0]
+	}
+	var names []string
+	switch r := r.(type) {
+	case interface{ Headers() []string }:
+		names = r.Headers()
+	case *pcap.Reader:
+		names = pcap.NewFeatureExtractor().FeatureNames()
+	case *accesslog.Reader:
+		names = accesslog.FeatureNames
+	}
+	return data, names, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+func newDriftCmd() *cobra.Command {
+	var (
+		modelPath    string
+		algo         string
+		input        string
+		header       bool
+		asJSON       bool
+		psiThreshold float64
+		ksAlpha      float64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare live data against the model's training distribution",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			model, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+			data, names, err := readAll(input, header)
+			if err != nil {
+				return err
+			}
+
+			report, err := detectors.Drift(model, data,
+				stats.WithPSIThreshold(psiThreshold),
+				stats.WithKSAlpha(ksAlpha),
+			)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			return printDrift(cmd, report, names)
+		},
+	}
+
+	cmd.Flags().StringVar(&modelPath, "model", "model.bin", "trained model file")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&input, "input", "", "live data (CSV)")
+	cmd.Flags().BoolVar(&header, "header", true, "CSV input has a header row")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the full report as JSON")
+	cmd.Flags().Float64Var(&psiThreshold, "psi-threshold", stats.DefaultPSIThreshold, "PSI above which a feature is drifted")
+	cmd.Flags().Float64Var(&ksAlpha, "ks-alpha", stats.DefaultKSAlpha, "significance level of the KS test")
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+// printDrift writes a table of per-feature drift, most drifted first.
+func printDrift(cmd *cobra.Command, report stats.DriftReport, names []string) error {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "%d of %d features drifted over %d samples\n\n", len(report.Drifted), len(report.Features), report.Samples)
+
+	order := append([]int(nil), report.Drifted...)
+	for _, fd := range report.Features {
+		if !fd.Drifted {
+			order = append(order, fd.Index)
+		}
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEATURE\tPSI\tKS\tTRAIN MEAN\tLIVE MEAN\tDRIFTED")
+	for _, i := range order {
+		fd := report.Features[i]
+		name := fmt.Sprintf("f%d", i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4g\t%.4g\t%t\n", name, fd.PSI, fd.KS, fd.TrainingMean, fd.LiveMean, fd.Drifted)
+	}
+	return tw.Flush()
+}
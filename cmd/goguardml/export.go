@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/bundle"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/export/pmml"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+)
+
+func newExportCmd() *cobra.Command {
+	var (
+		modelPath string
+		algo      string
+		train     string
+		header    bool
+		files     []string
+		format    string
+		out       string
+		epsilon   float64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Bundle a trained model with its configuration, feature names and calibration data",
+		Long: "Export writes a .tar.gz bundle that every command accepting --model can load.\n" +
+			"With --train, the bundle also records the training score distribution so\n" +
+			"thresholds can be recalibrated without retraining.\n\n" +
+			"With --format pmml, it writes the model as a PMML 4.4 document instead, for\n" +
+			"scoring engines that consume PMML; --train then only supplies feature names.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			d, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+			switch format {
+			case "bundle":
+			case "pmml":
+				if !cmd.Flags().Changed("out") {
+					out = "model.pmml"
+				}
+				return exportPMML(cmd, d, train, header, out, epsilon)
+			default:
+				return fmt.Errorf("unknown export format %q (want bundle or pmml)", format)
+			}
+
+			if epsilon > 0 {
+				return errors.New("--epsilon is only supported with --format pmml")
+			}
+			var opts []bundle.Option
+			if train != "" {
+				data, names, err := readAll(train, header)
+				if err != nil {
+					return err
+				}
+				scores, err := d.Predict(data)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, bundle.WithCalibrationScores(scores))
+				if names != nil {
+					opts = append(opts, bundle.WithFeatureNames(names))
+				}
+			}
+			for _, path := range files {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, bundle.WithFile(filepath.Base(path), data))
+			}
+
+			b, err := bundle.New(d, algo, opts...)
+			if err != nil {
+				return err
+			}
+			if err := b.Export(out); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Exported %s model to %s\n", algo, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&modelPath, "model", "model.bin", "trained model file")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&train, "train", "", "training data, to record feature names and threshold calibration scores")
+	cmd.Flags().BoolVar(&header, "header", true, "CSV training data has a header row")
+	cmd.Flags().StringSliceVar(&files, "file", nil, "extra file to include, e.g. a preprocessing config (repeatable)")
+	cmd.Flags().StringVar(&format, "format", "bundle", "output format: bundle or pmml")
+	cmd.Flags().StringVar(&out, "out", "model.tar.gz", "output file (model.pmml by default with --format pmml)")
+	cmd.Flags().Float64Var(&epsilon, "epsilon", 0, "add differential privacy noise of this budget to PMML record counts (0 disables)")
+
+	return cmd
+}
+
+// exportPMML writes d as a PMML document to out, naming the features after
+// the header of the training data, if given, and perturbing its record
+// counts with noise of budget epsilon, if positive.
+func exportPMML(cmd *cobra.Command, d detectors.Detector, train string, header bool, out string, epsilon float64) error {
+	var opts []pmml.Option
+	if epsilon > 0 {
+		noise, err := privacy.NewNoise(epsilon)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, pmml.WithNoise(noise))
+	}
+	if train != "" {
+		_, names, err := readAll(train, header)
+		if err != nil {
+			return err
+		}
+		if names != nil {
+			opts = append(opts, pmml.WithFeatureNames(names))
+		}
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := pmml.Export(f, d, opts...); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Exported PMML model to %s\n", out)
+	return nil
+}
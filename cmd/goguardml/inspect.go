@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func newInspectCmd() *cobra.Command {
+	var (
+		modelPath string
+		algo      string
+		asJSON    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Show the model card of a trained model",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			model, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+			card, ok := detectors.MetadataOf(model)
+			if !ok {
+				return fmt.Errorf("%s models do not record metadata", algo)
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(card)
+			}
+			return printModelCard(cmd, card)
+		},
+	}
+
+	cmd.Flags().StringVar(&modelPath, "model", "model.bin", "trained model file")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the model card as JSON")
+
+	return cmd
+}
+
+// printModelCard writes the model card as aligned key-value pairs.
+func printModelCard(cmd *cobra.Command, card detectors.ModelCard) error {
+	if card.TrainedAt.IsZero() {
+		fmt.Fprintln(cmd.OutOrStdout(), "No model card: the model was saved before metadata was recorded.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Trained at:\t%s\n", card.TrainedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Data source:\t%s\n", card.DataSource)
+	fmt.Fprintf(tw, "Rows:\t%d\n", card.Rows)
+	fmt.Fprintf(tw, "Features:\t%d\n", card.Features)
+	if len(card.FeatureNames) > 0 {
+		fmt.Fprintf(tw, "Feature names:\t%s\n", strings.Join(card.FeatureNames, ", "))
+	}
+	fmt.Fprintf(tw, "Data hash:\t%s\n", card.DataHash)
+	fmt.Fprintf(tw, "Library version:\t%s\n", card.LibraryVersion)
+
+	keys := make([]string, 0, len(card.Hyperparameters))
+	for k := range card.Hyperparameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		fmt.Fprintln(tw, "Hyperparameters:\t")
+	}
+	for _, k := range keys {
+		fmt.Fprintf(tw, "  %s:\t%s\n", k, card.Hyperparameters[k])
+	}
+	return tw.Flush()
+}
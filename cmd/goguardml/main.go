@@ -0,0 +1,69 @@
+// Package main implements the goguardml command-line tool.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// version is set at build time via -ldflags.
+var version = "dev"
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func newRootCmd() *cobra.Command {
+	var (
+		workers      int
+		modelKeyFile string
+	)
+
+	root := &cobra.Command{
+		Use:           "goguardml",
+		Short:         "Unsupervised anomaly detection for network traffic and logs",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			detectors.SetDefaultWorkers(workers)
+			if modelKeyFile == "" {
+				return nil
+			}
+			key, err := os.ReadFile(modelKeyFile)
+			if err != nil {
+				return err
+			}
+			if modelKey = bytes.TrimSpace(key); len(modelKey) == 0 {
+				return fmt.Errorf("model key file %s is empty", modelKeyFile)
+			}
+			return nil
+		},
+	}
+	root.PersistentFlags().IntVar(&workers, "workers", 0, "goroutines for training and scoring (0 = GOMAXPROCS)")
+	root.PersistentFlags().StringVar(&modelKeyFile, "model-key", "", "file with a key to sign saved models and require valid signatures on loaded ones (HMAC-SHA256)")
+	root.PersistentFlags().BoolVar(&strictInput, "strict", false, "fail on malformed CSV rows instead of skipping them")
+	root.PersistentFlags().StringVar(&raggedInput, "ragged", "reject", "CSV rows with missing or extra fields: reject, truncate, or pad (with column means)")
+
+	root.AddCommand(
+		newTrainCmd(),
+		newPredictCmd(),
+		newServeCmd(),
+		newCaptureCmd(),
+		newReportCmd(),
+		newDriftCmd(),
+		newInspectCmd(),
+		newExportCmd(),
+		newRunCmd(),
+	)
+
+	return root
+}
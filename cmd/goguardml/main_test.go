@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// execute runs the goguardml command with args, returning what it wrote
+// to standard output.
+func execute(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	t.Cleanup(func() { modelKey = nil })
+	var out bytes.Buffer
+	root := newRootCmd()
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs(args)
+	err := root.Execute()
+	return out.String(), err
+}
+
+// writeFlows writes a CSV of n normal flows and a last, far outlying one
+// to a file in dir, returning its path.
+func writeFlows(t *testing.T, dir string, n int) string {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	b.WriteString("packets,bytes\n")
+	for range n {
+		packets := 10 + rng.NormFloat64()
+		fmt.Fprintf(&b, "%g,%g\n", packets, packets*(500+10*rng.NormFloat64()))
+	}
+	b.WriteString("900,20\n")
+	path := filepath.Join(dir, "flows.csv")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o600))
+	return path
+}
+
+// readResults decodes JSON Lines results.
+func readResults(t *testing.T, out string) []guardio.Result {
+	t.Helper()
+	var results []guardio.Result
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		var r guardio.Result
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &r), sc.Text())
+		results = append(results, r)
+	}
+	require.NoError(t, sc.Err())
+	return results
+}
+
+func TestFlags(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFlows(t, dir, 200)
+	model := filepath.Join(dir, "model.bin")
+	_, err := execute(t, "train", "--input", input, "--out", model, "--trees", "10")
+	require.NoError(t, err)
+	emptyKey := filepath.Join(dir, "empty.key")
+	require.NoError(t, os.WriteFile(emptyKey, []byte("\n"), 0o600))
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"unknown command", []string{"fit"}, `unknown command "fit"`},
+		{"unknown flag", []string{"train", "--input", input, "--depth", "3"}, "unknown flag: --depth"},
+		{"missing input", []string{"train"}, `required flag(s) "input" not set`},
+		{"invalid int", []string{"train", "--input", input, "--trees", "many"}, `invalid argument "many" for "--trees"`},
+		{"unknown algorithm", []string{"train", "--input", input, "--algo", "svm"}, `unknown algorithm "svm"`},
+		{"invalid hyperparameter", []string{"train", "--input", input, "--trees", "0"}, "WithTrees"},
+		{"time field without window", []string{"train", "--input", input, "--algo", "entropy", "--time-field", "0"}, "--time-field and --time-window go together"},
+		{"flat and proto", []string{"train", "--input", input, "--out", filepath.Join(dir, "x.bin"), "--trees", "10", "--flat", "--proto"}, "--flat and --proto are exclusive"},
+		{"unknown feature weight", []string{"train", "--input", input, "--feature-weight", "ttl=2"}, `--feature-weight: no feature "ttl"`},
+		{"unknown ragged policy", []string{"--ragged", "skip", "predict", "--model", model, "--input", input}, `unknown --ragged policy "skip"`},
+		{"empty model key", []string{"--model-key", emptyKey, "predict", "--model", model, "--input", input}, "is empty"},
+		{"unknown format", []string{"predict", "--model", model, "--input", input, "--format", "xml"}, `unknown --format "xml"`},
+		{"min severity without bands", []string{"predict", "--model", model, "--input", input, "--min-severity", "high"}, "--min-severity needs --severity-bands"},
+		{"missing model", []string{"predict", "--model", filepath.Join(dir, "none.bin"), "--input", input}, "none.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execute(t, tt.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "x.bin"), "a rejected save leaves no file")
+}
+
+func TestTrainPredict(t *testing.T) {
+	tests := []struct {
+		algo string
+		args []string
+	}{
+		{"iforest", []string{"--trees", "50"}},
+		{"iforest", []string{"--trees", "50", "--flat"}},
+		{"zscore", nil},
+		{"knn", []string{"--neighbors", "5"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{tt.algo}, tt.args...), " "), func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeFlows(t, dir, 300)
+			model := filepath.Join(dir, "model.bin")
+
+			out, err := execute(t, append([]string{"train", "--input", input, "--out", model, "--algo", tt.algo, "--contamination", "0.01"}, tt.args...)...)
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("Trained %s on 301 samples, model written to %s\n", tt.algo, model), out)
+
+			out, err = execute(t, "predict", "--model", model, "--algo", tt.algo, "--input", input)
+			require.NoError(t, err)
+			results := readResults(t, out)
+			require.Len(t, results, 301)
+			outlier := results[300]
+			assert.True(t, outlier.IsAnomaly)
+			assert.Equal(t, []float64{900, 20}, outlier.Features)
+			var flagged int
+			for _, r := range results[:300] {
+				assert.Less(t, r.Score, outlier.Score)
+				if r.IsAnomaly {
+					flagged++
+				}
+			}
+			assert.Less(t, flagged, 15, "about 1% of the normal flows are flagged")
+
+			out, err = execute(t, "predict", "--model", model, "--algo", tt.algo, "--input", input, "--top", "1", "--explain")
+			require.NoError(t, err)
+			top := readResults(t, out)
+			require.Len(t, top, 1)
+			assert.Equal(t, uint64(301), top[0].Seq)
+			assert.Equal(t, outlier.Score, top[0].Score)
+			require.NotNil(t, top[0].Explanation)
+			assert.Equal(t, []string{"packets", "bytes"}, top[0].FeatureNames)
+		})
+	}
+}
+
+func TestTrainPredictSigned(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFlows(t, dir, 200)
+	model := filepath.Join(dir, "model.bin")
+	key := filepath.Join(dir, "model.key")
+	require.NoError(t, os.WriteFile(key, []byte("secret\n"), 0o600))
+	other := filepath.Join(dir, "other.key")
+	require.NoError(t, os.WriteFile(other, []byte("other\n"), 0o600))
+
+	_, err := execute(t, "--model-key", key, "train", "--input", input, "--out", model, "--trees", "10")
+	require.NoError(t, err)
+	out, err := execute(t, "--model-key", key, "predict", "--model", model, "--input", input)
+	require.NoError(t, err)
+	assert.Len(t, readResults(t, out), 201)
+
+	_, err = execute(t, "--model-key", other, "predict", "--model", model, "--input", input)
+	assert.Error(t, err, "a model signed with another key is rejected")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/grafana"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+	"github.com/hed1ad/goguardml/pkg/io/protobuf"
+)
+
+func newPredictCmd() *cobra.Command {
+	var (
+		modelPath string
+		algo      string
+		input     string
+		out       string
+		header    bool
+		threshold float64
+		explain   bool
+		nearest   bool
+		format    string
+		topK      int
+		gf        grafanaFlags
+		sev       severityFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "predict",
+		Short: "Score a CSV or PCAP file with a trained model",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			d, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+			if t, ok := d.(detectors.Thresholder); ok && cmd.Flags().Changed("threshold") {
+				t.SetThreshold(threshold)
+			}
+
+			data, names, err := readAll(input, header)
+			if err != nil {
+				return err
+			}
+
+			// Interrupting a long batch abandons it.
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// ranked holds every sample, or with --top the most anomalous.
+			var ranked []detectors.Ranked
+			if topK > 0 {
+				if ranked, err = detectors.PredictTopK(ctx, d, data, topK); err != nil {
+					return err
+				}
+			} else {
+				scores, err := d.PredictContext(ctx, data)
+				if err != nil {
+					return err
+				}
+				limit := detectors.ThresholdOf(d)
+				ranked = make([]detectors.Ranked, len(scores))
+				for i, score := range scores {
+					ranked[i] = detectors.Ranked{Index: i, Score: score, IsAnomaly: score >= limit, Features: data[i]}
+				}
+			}
+
+			w, err := newResultWriter(cmd, out, format)
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+			if w, err = gf.wrap(w); err != nil {
+				return err
+			}
+			if w, err = sev.wrap(w); err != nil {
+				return err
+			}
+
+			now := time.Now().Unix()
+			results := make([]guardio.Result, len(ranked))
+			for i, r := range ranked {
+				results[i] = guardio.Result{
+					Timestamp: now,
+					Score:     r.Score,
+					IsAnomaly: r.IsAnomaly,
+					Features:  r.Features,
+				}
+				if topK > 0 {
+					// Seq numbers the samples of the input from 1.
+					results[i].Seq = uint64(r.Index + 1)
+				}
+				if (explain || nearest) && results[i].IsAnomaly {
+					exp, err := detectors.Explain(d, r.Features)
+					if err != nil {
+						return err
+					}
+					if nearest {
+						cf, err := detectors.ExplainCounterfactual(d, r.Features)
+						if err != nil {
+							return err
+						}
+						exp.Counterfactual = &cf
+					}
+					results[i].Explanation = &exp
+					results[i].FeatureNames = names
+				}
+			}
+			return w.WriteAll(results)
+		},
+	}
+
+	cmd.Flags().StringVar(&modelPath, "model", "model.bin", "trained model file")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&input, "input", "", "data to score (.csv, .pcap, .parquet or access .log)")
+	cmd.Flags().StringVar(&out, "out", "", "output file (default stdout)")
+	cmd.Flags().StringVar(&format, "format", "jsonl", resultFormatUsage)
+	cmd.Flags().BoolVar(&header, "header", true, "CSV input has a header row")
+	cmd.Flags().Float64Var(&threshold, "threshold", 0, "override the model's anomaly threshold")
+	cmd.Flags().BoolVar(&explain, "explain", false, "attach feature attributions to anomalies")
+	cmd.Flags().IntVar(&topK, "top", 0, "write only this many of the most anomalous samples, highest score first, with seq set to their row number")
+	cmd.Flags().BoolVar(&nearest, "counterfactual", false, "also suggest the nearest normal variant of each anomaly (implies --explain)")
+	gf.register(cmd)
+	sev.register(cmd)
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+// resultFormatUsage describes the --format flag of commands writing results.
+const resultFormatUsage = "result format: jsonl, or proto for size-delimited goguardml.v1.Result messages"
+
+// newResultWriter writes results in format to the named file, or to the
+// command output if empty.
+func newResultWriter(cmd *cobra.Command, path, format string) (guardio.Writer, error) {
+	switch format {
+	case "jsonl":
+		if path == "" {
+			return jsonl.NewWriter(nopCloser{cmd.OutOrStdout()}), nil
+		}
+		return jsonl.NewFileWriter(path)
+	case "proto":
+		if path == "" {
+			return protobuf.NewWriter(nopCloser{cmd.OutOrStdout()}), nil
+		}
+		return protobuf.NewFileWriter(path)
+	default:
+		return nil, fmt.Errorf("unknown --format %q (want jsonl or proto)", format)
+	}
+}
+
+// grafanaFlags are the flags posting anomalies to Grafana as annotations.
+type grafanaFlags struct {
+	url       string
+	tokenFile string
+}
+
+func (g *grafanaFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&g.url, "grafana", "", "also post anomalies as annotations to the Grafana at this URL")
+	cmd.Flags().StringVar(&g.tokenFile, "grafana-token-file", "", "file holding the Grafana service account token")
+}
+
+// wrap returns w, also posting anomalies to Grafana if --grafana is set.
+func (g *grafanaFlags) wrap(w guardio.Writer) (guardio.Writer, error) {
+	if g.url == "" {
+		return w, nil
+	}
+	var opts []grafana.Option
+	if g.tokenFile != "" {
+		token, err := os.ReadFile(g.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grafana.WithToken(string(bytes.TrimSpace(token))))
+	}
+	gw, err := grafana.NewWriter(g.url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return guardio.MultiWriter(w, gw), nil
+}
+
+// severityFlags are the flags grading results by severity.
+type severityFlags struct {
+	bands string
+	min   string
+}
+
+func (f *severityFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.bands, "severity-bands", "", "grade results info to critical by the lowest low, medium, high and critical scores, e.g. 0.55,0.6,0.7,0.8")
+	cmd.Flags().StringVar(&f.min, "min-severity", "", "write only results at least this severe: info, low, medium, high or critical (needs --severity-bands)")
+}
+
+// wrap returns w, grading results first if --severity-bands is set and
+// dropping those below --min-severity.
+func (f *severityFlags) wrap(w guardio.Writer) (guardio.Writer, error) {
+	if f.bands == "" {
+		if f.min != "" {
+			return nil, errors.New("--min-severity needs --severity-bands")
+		}
+		return w, nil
+	}
+	bands, err := detectors.ParseSeverityBands(f.bands)
+	if err != nil {
+		return nil, err
+	}
+	if f.min != "" {
+		least, err := detectors.ParseSeverity(f.min)
+		if err != nil {
+			return nil, err
+		}
+		w = guardio.MinSeverity(w, least)
+	}
+	return guardio.WithSeverity(w, bands), nil
+}
+
+// nopCloser prevents a writer from closing the underlying stream.
+type nopCloser struct {
+	io.Writer
+}
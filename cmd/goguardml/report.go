@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+	"github.com/hed1ad/goguardml/pkg/report"
+)
+
+func newReportCmd() *cobra.Command {
+	var (
+		input     string
+		modelPath string
+		algo      string
+		train     string
+		header    bool
+		format    string
+		out       string
+		title     string
+		top       int
+		epsilon   float64
+		scrubKey  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Render an HTML or Markdown report from scored results",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			results, err := jsonl.ReadResults(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("read %s: %w", input, err)
+			}
+
+			if scrubKey != "" {
+				s, err := loadScrubber(scrubKey)
+				if err != nil {
+					return err
+				}
+				for i := range results {
+					results[i].Metadata = s.Metadata(results[i].Metadata)
+				}
+			}
+
+			var model detectors.Detector
+			if modelPath != "" {
+				if model, err = loadDetector(modelPath, algo); err != nil {
+					return err
+				}
+			}
+
+			opts := []report.Option{report.WithTopN(top)}
+			if title != "" {
+				opts = append(opts, report.WithTitle(title))
+			}
+			if train != "" {
+				data, names, err := readAll(train, header)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, report.WithTrainingData(data), report.WithFeatureNames(names))
+			}
+			if epsilon > 0 {
+				noise, err := privacy.NewNoise(epsilon)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, report.WithNoise(noise))
+			}
+
+			if format == "" {
+				format = "md"
+				if ext := strings.ToLower(filepath.Ext(out)); ext == ".html" || ext == ".htm" {
+					format = "html"
+				}
+			}
+
+			w := cmd.OutOrStdout()
+			if out != "" {
+				file, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			return report.New(results, model, opts...).Write(w, format)
+		},
+	}
+
+	cmd.Flags().StringVar(&input, "input", "", "scored results (JSON Lines from predict)")
+	cmd.Flags().StringVar(&modelPath, "model", "", "trained model, for threshold, importances and explanations")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&train, "train", "", "training data to compare feature distributions against")
+	cmd.Flags().BoolVar(&header, "header", true, "CSV training data has a header row")
+	cmd.Flags().StringVar(&format, "format", "", "report format: html or md (default from --out extension, else md)")
+	cmd.Flags().StringVar(&out, "out", "", "output file (default stdout)")
+	cmd.Flags().StringVar(&title, "title", "", "report title")
+	cmd.Flags().IntVar(&top, "top", 20, "number of top anomalies to list")
+	cmd.Flags().Float64Var(&epsilon, "epsilon", 0, "add differential privacy noise of this budget to counts and histograms (0 disables)")
+	cmd.Flags().StringVar(&scrubKey, "scrub-key-file", "", "file holding the key to pseudonymize identifiers in result metadata with")
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+// loadScrubber returns a Scrubber keyed with the contents of the file at
+// path, surrounding whitespace trimmed.
+func loadScrubber(path string) (*privacy.Scrubber, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return privacy.NewScrubber([]byte(strings.TrimSpace(string(key))))
+}
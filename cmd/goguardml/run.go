@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/pipeline"
+)
+
+func newRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run <pipeline.yaml>",
+		Short: "Run a detection pipeline described by a YAML config",
+		Long: "Run a pipeline end to end from a YAML config: read the input, " +
+			"preprocess it, train or load the detector, apply the threshold " +
+			"and write results to every output. Pipelines on live capture run " +
+			"until interrupted. See pkg/pipeline for the config format.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			cfg, err := pipeline.LoadConfig(args[0])
+			if err != nil {
+				return err
+			}
+			p, err := pipeline.New(cfg, pipeline.WithStdout(cmd.OutOrStdout()), pipeline.WithSigningKey(modelKey))
+			if err != nil {
+				return err
+			}
+			if err := p.Run(ctx); err != nil {
+				return err
+			}
+			st := p.Stats()
+			fmt.Fprintf(cmd.ErrOrStderr(), "%d samples, %d anomalies", st.Samples, st.Anomalies)
+			if st.Rejected > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), ", %d rejected", st.Rejected)
+			}
+			fmt.Fprintln(cmd.ErrOrStderr())
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/audit"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/feedback"
+	"github.com/hed1ad/goguardml/pkg/history"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/server"
+)
+
+func newServeCmd() *cobra.Command {
+	var (
+		modelPath string
+		algo      string
+		addr      string
+		jobDir    string
+		jobTTL    time.Duration
+		keysFile  string
+		certFile  string
+		keyFile   string
+		clientCA  string
+		inFlight  int
+		queue     int
+		timeout   time.Duration
+
+		auditLog         string
+		auditRate        float64
+		auditAnomalyRate float64
+
+		feedbackLog            string
+		feedbackAggressiveness float64
+		historyFile            string
+		historyRetention       time.Duration
+		ui                     bool
+		severityBands          string
+		telemetry              time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a trained model over HTTP",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			d, err := loadDetector(modelPath, algo)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "Serving %s on %s\n", modelPath, addr)
+			opts := []server.Option{
+				server.WithAddr(addr),
+				server.WithJobDir(jobDir),
+				server.WithJobOpener(openJobFile),
+				server.WithJobTTL(jobTTL),
+				server.WithTLS(certFile, keyFile),
+				server.WithClientCA(clientCA),
+				server.WithConcurrency(inFlight, queue),
+				server.WithRequestTimeout(timeout),
+			}
+			if severityBands != "" {
+				bands, err := detectors.ParseSeverityBands(severityBands)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, server.WithSeverityBands(bands))
+			}
+			if telemetry > 0 {
+				opts = append(opts, server.WithTelemetry(telemetry))
+			}
+			if ui {
+				opts = append(opts, server.WithDashboard())
+				fmt.Fprintf(cmd.ErrOrStderr(), "Dashboard at http://%s/ui/\n", dashboardHost(addr))
+			}
+			if keysFile != "" {
+				keys, err := readAPIKeys(keysFile)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, server.WithAPIKeys(keys...))
+			}
+			if auditLog != "" {
+				sink, err := audit.OpenJSONL(auditLog)
+				if err != nil {
+					return err
+				}
+				logger := audit.New(sink,
+					audit.WithSampleRate(auditRate),
+					audit.WithAnomalySampleRate(auditAnomalyRate),
+				)
+				defer logger.Close()
+				opts = append(opts, server.WithAuditLog(logger))
+			}
+
+			if feedbackLog != "" {
+				store, err := feedback.OpenJSONL(feedbackLog, 0)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				adapter := feedback.New(store, feedback.Single(d), feedback.WithAggressiveness(feedbackAggressiveness))
+				opts = append(opts, server.WithFeedback(adapter))
+			}
+
+			if historyFile != "" {
+				h, err := history.Open(historyFile, history.WithRetention(historyRetention))
+				if err != nil {
+					return err
+				}
+				defer h.Close()
+				go compactHistory(ctx, h, cmd.ErrOrStderr())
+				opts = append(opts, server.WithHistory(h))
+			}
+
+			return server.New(d, opts...).ListenAndServe(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&modelPath, "model", "model.bin", "trained model file")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "algorithm of the trained model")
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "listen address")
+	cmd.Flags().StringVar(&keysFile, "api-keys-file", "", "file of API keys, one \"name key [requests/sec]\" per line")
+	cmd.Flags().StringVar(&certFile, "tls-cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&keyFile, "tls-key", "", "TLS private key file")
+	cmd.Flags().StringVar(&clientCA, "client-ca", "", "CA bundle for verifying client certificates (enables mTLS)")
+	cmd.Flags().IntVar(&inFlight, "max-inflight", runtime.GOMAXPROCS(0), "maximum concurrent scoring requests")
+	cmd.Flags().IntVar(&queue, "max-queue", 4*runtime.GOMAXPROCS(0), "maximum queued scoring requests before returning 429")
+	cmd.Flags().DurationVar(&timeout, "request-timeout", 30*time.Second, "deadline for scoring requests, including queueing")
+	cmd.Flags().StringVar(&auditLog, "audit-log", "", "append a JSON Lines record of every prediction to this file")
+	cmd.Flags().Float64Var(&auditRate, "audit-sample-rate", 1, "fraction of normal predictions recorded in the audit log")
+	cmd.Flags().Float64Var(&auditAnomalyRate, "audit-anomaly-sample-rate", 1, "fraction of anomalous predictions recorded in the audit log")
+	cmd.Flags().StringVar(&feedbackLog, "feedback", "", "accept analyst feedback at /v1/feedback, stored in this JSON Lines file, and adapt the threshold to it")
+	cmd.Flags().Float64Var(&feedbackAggressiveness, "feedback-aggressiveness", 0.25, "fraction of the way to the best threshold for the feedback each adjustment moves")
+	cmd.Flags().StringVar(&historyFile, "history", "", "record the scores of samples with an entity in this file and serve them at /v1/history")
+	cmd.Flags().DurationVar(&historyRetention, "history-retention", 30*24*time.Hour, "how long the score history keeps scores (0 keeps them all)")
+	cmd.Flags().StringVar(&severityBands, "severity-bands", "", "grade results info to critical by the lowest low, medium, high and critical scores, e.g. 0.55,0.6,0.7,0.8")
+	cmd.Flags().DurationVar(&telemetry, "telemetry", 0, "report model internals, such as isolation forest path lengths, per period of this length in /admin/stats (0 = off)")
+	cmd.Flags().BoolVar(&ui, "ui", false, "serve a live monitoring dashboard at /ui/")
+	cmd.Flags().StringVar(&jobDir, "job-dir", filepath.Join(os.TempDir(), "goguardml-jobs"), "directory for batch job uploads and results")
+	cmd.Flags().DurationVar(&jobTTL, "job-ttl", 24*time.Hour, "how long finished batch jobs and their results are kept (0 keeps them until deleted)")
+
+	return cmd
+}
+
+// dashboardHost returns the host to browse the dashboard at for a listen
+// address: localhost when it listens on every interface.
+func dashboardHost(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
+
+// historyCompaction is how often serve drops expired scores from the
+// history file.
+const historyCompaction = time.Hour
+
+// compactHistory compacts h at startup and every historyCompaction until
+// ctx is done.
+func compactHistory(ctx context.Context, h *history.Store, stderr io.Writer) {
+	ticker := time.NewTicker(historyCompaction)
+	defer ticker.Stop()
+	for {
+		if err := h.Compact(); err != nil {
+			fmt.Fprintf(stderr, "Warning: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// openJobFile opens CSV, PCAP and Parquet files submitted as batch jobs,
+// honoring --strict and --ragged for CSV files.
+func openJobFile(name string) (guardio.Reader, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".pcap", ".pcapng", ".cap", ".parquet":
+		return openReader(name, true)
+	default:
+		return nil, server.ErrUnsupportedFormat
+	}
+}
+
+// readAPIKeys parses an API key file. Blank lines and lines starting with #
+// are ignored; other lines hold a name, a key, and an optional rate limit.
+func readAPIKeys(path string) ([]server.APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []server.APIKey
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"name key [requests/sec]\"", path, i+1)
+		}
+
+		key := server.APIKey{Name: fields[0], Key: fields[1]}
+		if len(fields) == 3 {
+			if key.RateLimit, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid rate limit: %w", path, i+1, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
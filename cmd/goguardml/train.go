@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/io/dataset"
+)
+
+func newTrainCmd() *cobra.Command {
+	var (
+		input   string
+		algo    string
+		out     string
+		header  bool
+		source  string
+		flat    bool
+		proto   bool
+		label   string
+		dedup   bool
+		rows    int
+		weights map[string]string
+		fields  []string
+		timeCol string
+		opts    detectorOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "train",
+		Short: "Train a detector on a CSV or PCAP file",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if (timeCol == "") != (opts.timeWindow == 0) {
+				return errors.New("--time-field and --time-window go together")
+			}
+			// Reject bad hyperparameters before reading a possibly large input.
+			if _, err := newDetector(algo, opts); err != nil {
+				return err
+			}
+
+			data, names, err := readAll(input, header)
+			if err != nil {
+				return err
+			}
+			var labels []float64
+			if label != "" {
+				if data, names, labels, err = splitLabels(data, names, label); err != nil {
+					return err
+				}
+			}
+			data, labels = preprocess(data, labels, dedup, rows, opts.seed)
+			if len(weights) > 0 && len(data) > 0 {
+				if opts.featureWeights, err = featureWeights(weights, names, len(data[0])); err != nil {
+					return err
+				}
+			}
+			if len(fields) > 0 && len(data) > 0 {
+				if opts.fields, err = fieldIndices("--fields", fields, names, len(data[0])); err != nil {
+					return err
+				}
+			}
+			if timeCol != "" && len(data) > 0 {
+				col, err := fieldIndices("--time-field", []string{timeCol}, names, len(data[0]))
+				if err != nil {
+					return err
+				}
+				opts.timeField = col[0]
+			}
+			opts.featureNames = names
+			opts.dataSource = source
+			if opts.dataSource == "" {
+				opts.dataSource = input
+			}
+
+			d, err := newDetector(algo, opts)
+			if err != nil {
+				return err
+			}
+			fit := d.Fit
+			if labels != nil {
+				fit = func(data [][]float64) error { return detectors.FitLabeled(d, data, labels) }
+			}
+			if err := fit(data); err != nil {
+				return fmt.Errorf("train: %w", err)
+			}
+			if c, ok := d.(interface{ ConstantFeatures() []int }); ok {
+				warnConstant(cmd.ErrOrStderr(), c.ConstantFeatures(), names, opts.excludeConstant)
+			}
+
+			if flat && proto {
+				return errors.New("--flat and --proto are exclusive")
+			}
+			save := func(w io.Writer) error { return d.SaveTo(w) }
+			if proto {
+				ps, ok := d.(interface{ SaveProto() ([]byte, error) })
+				if !ok {
+					return fmt.Errorf("%s does not support the protobuf model format", algo)
+				}
+				save = writeBytes(ps.SaveProto)
+			}
+			if flat {
+				fs, ok := d.(interface{ SaveFlat() ([]byte, error) })
+				if !ok {
+					return fmt.Errorf("%s does not support the flat model format", algo)
+				}
+				save = writeBytes(fs.SaveFlat)
+			}
+			if err := writeFile(out, save); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Trained %s on %d samples, model written to %s\n", algo, len(data), out)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&input, "input", "", "training data (.csv, .pcap, .parquet or access .log)")
+	cmd.Flags().StringVar(&algo, "algo", "iforest", "detection algorithm: iforest, eif, hbos, knn, autoencoder, mcd, copod, zscore, iqr, dbscan, entropy (categorical fields of events, such as addresses and ports), or matrixprofile, sr or holtwinters (a single-column time series)")
+	cmd.Flags().StringVar(&out, "out", "model.bin", "output model file")
+	cmd.Flags().BoolVar(&header, "header", true, "CSV input has a header row")
+	cmd.Flags().StringVar(&source, "source", "", "description of the training data for the model card (default the input path)")
+	cmd.Flags().StringVar(&label, "label-column", "", "CSV column labeling samples 1 for confirmed anomalies, 0 for known normal and -1 for unlabeled; anomalies are left out of training and set the threshold")
+	cmd.Flags().BoolVar(&dedup, "dedup", false, "drop rows that repeat an earlier row before training")
+	cmd.Flags().IntVar(&rows, "max-rows", 0, "train on a random sample of this many rows, stratified by --label-column when given (0 uses all rows)")
+	cmd.Flags().BoolVar(&flat, "flat", false, "write the flat model format, which is memory-mapped when loaded")
+	cmd.Flags().BoolVar(&proto, "proto", false, "write the model as a goguardml.v1.Model protobuf message, readable outside Go")
+	cmd.Flags().IntVar(&opts.trees, "trees", 100, "number of trees")
+	cmd.Flags().IntVar(&opts.sampleSize, "sample-size", 256, "subsample size per tree")
+	cmd.Flags().Float64Var(&opts.contamination, "contamination", 0.1, "expected proportion of anomalies (iqr flags values outside its fences and dbscan noise instead)")
+	cmd.Flags().Int64Var(&opts.seed, "seed", 42, "random seed")
+	cmd.Flags().IntVar(&opts.extensionLevel, "extension-level", -1, "eif features per split hyperplane minus one: 0 splits on one feature like iforest (negative uses all features)")
+	cmd.Flags().IntVar(&opts.neighbors, "neighbors", 10, "knn nearest training points compared per sample")
+	cmd.Flags().IntVar(&opts.epochs, "epochs", 50, "autoencoder passes over the training data")
+	cmd.Flags().BoolVar(&opts.robust, "robust", false, "zscore measures features from their median and MAD instead of mean and standard deviation")
+	cmd.Flags().Float64Var(&opts.fenceFactor, "fence-factor", 1.5, "iqr fence distance beyond the quartiles, in interquartile ranges (3 for far out values)")
+	cmd.Flags().Float64Var(&opts.eps, "eps", 0, "dbscan neighborhood radius in standard deviations (0 chooses it from the data)")
+	cmd.Flags().IntVar(&opts.minPoints, "min-points", 5, "dbscan training points, itself included, a core point has within --eps")
+	cmd.Flags().IntVar(&opts.window, "window", 0, "matrixprofile subsequence length, sr window or entropy window, in samples (0 for the default: 32 for matrixprofile, 64 for sr, 1000 for entropy)")
+	cmd.Flags().IntVar(&opts.season, "season", 24, "holtwinters season length, in samples (24 for a daily rhythm in hourly samples)")
+	cmd.Flags().StringSliceVar(&fields, "fields", nil, "entropy fields tracked, by column name or index, e.g. src_ip,dst_port (default every column)")
+	cmd.Flags().StringVar(&timeCol, "time-field", "", "entropy column holding event times in seconds, by name or index; with --time-window")
+	cmd.Flags().DurationVar(&opts.timeWindow, "time-window", 0, "entropy window duration, e.g. 10s, instead of --window events; also flags changes in the event rate")
+	cmd.Flags().IntVar(&opts.bins, "bins", 0, "hbos bins per feature (0 chooses a count per feature from its spread)")
+	cmd.Flags().IntVar(&opts.quantize, "quantize", 0, "quantize split values to 8 or 16 bits for smaller models (0 keeps full precision)")
+	cmd.Flags().StringToStringVar(&weights, "feature-weight", nil, "split weight of a feature by column name or index, e.g. dst_port=3,ttl=0.5; unlisted features weigh 1 and 0 keeps a feature out of splits")
+	cmd.Flags().BoolVar(&opts.excludeConstant, "exclude-constant", false, "do not split on features that are constant in the training data")
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+// preprocess removes duplicate rows when dedup is set, then downsamples
+// to maxRows with seed when it is positive, keeping labels, if any, in step
+// and each label's share of the rows.
+func preprocess(data [][]float64, labels []float64, dedup bool, maxRows int, seed int64) ([][]float64, []float64) {
+	keep := func(idx []int) {
+		data = dataset.Take(data, idx)
+		if labels != nil {
+			labels = dataset.Take(labels, idx)
+		}
+	}
+	if dedup {
+		keep(dataset.DedupIndices(data))
+	}
+	if maxRows > 0 {
+		if labels != nil {
+			keep(dataset.StratifiedIndices(labels, maxRows, seed))
+		} else {
+			keep(dataset.SampleIndices(len(data), maxRows, seed))
+		}
+	}
+	return data, labels
+}
+
+// warnConstant reports features that were constant in the training data,
+// by name when the input had a header.
+func warnConstant(w io.Writer, constant []int, names []string, excluded bool) {
+	if len(constant) == 0 {
+		return
+	}
+	labels := make([]string, len(constant))
+	for i, j := range constant {
+		labels[i] = strconv.Itoa(j)
+		if j < len(names) {
+			labels[i] = names[j]
+		}
+	}
+	hint := " (use --exclude-constant to keep them out of splits)"
+	if excluded {
+		hint = ", excluded from splits"
+	}
+	fmt.Fprintf(w, "Warning: constant in the training data: %s%s\n", strings.Join(labels, ", "), hint)
+}
+
+// featureWeights resolves --feature-weight entries, keyed by column name
+// or index, to a weight per feature, 1 for those not listed.
+func featureWeights(spec map[string]string, names []string, n int) ([]float64, error) {
+	weights := make([]float64, n)
+	for j := range weights {
+		weights[j] = 1
+	}
+	for key, value := range spec {
+		j := slices.Index(names, key)
+		if j < 0 {
+			var err error
+			if j, err = strconv.Atoi(key); err != nil || j < 0 || j >= n {
+				return nil, fmt.Errorf("--feature-weight: no feature %q", key)
+			}
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("--feature-weight: invalid weight %q for %s", value, key)
+		}
+		weights[j] = w
+	}
+	return weights, nil
+}
+
+// fieldIndices resolves the entries of flag, column names or indices, to
+// feature indices.
+func fieldIndices(flag string, spec, names []string, n int) ([]int, error) {
+	fields := make([]int, len(spec))
+	for i, key := range spec {
+		j := slices.Index(names, key)
+		if j < 0 {
+			var err error
+			if j, err = strconv.Atoi(key); err != nil || j < 0 || j >= n {
+				return nil, fmt.Errorf("%s: no feature %q", flag, key)
+			}
+		}
+		fields[i] = j
+	}
+	return fields, nil
+}
+
+// splitLabels removes the named label column from data, returning it
+// separately.
+func splitLabels(data [][]float64, names []string, column string) ([][]float64, []string, []float64, error) {
+	col := slices.Index(names, column)
+	if col < 0 {
+		return nil, nil, nil, fmt.Errorf("no label column %q in the input header", column)
+	}
+	labels := make([]float64, len(data))
+	rows := make([][]float64, len(data))
+	for i, row := range data {
+		labels[i] = row[col]
+		rows[i] = slices.Delete(slices.Clone(row), col, col+1)
+	}
+	return rows, slices.Delete(slices.Clone(names), col, col+1), labels, nil
+}
+
+// writeBytes returns a function writing the output of save.
+func writeBytes(save func() ([]byte, error)) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		data, err := save()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+}
+
+// writeFile creates path with owner-only permissions and fills it with
+// write, removing it if write fails.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+// Package audit records scoring decisions for traceability.
+//
+// A Logger appends one Record per prediction to a Sink, with the model
+// version, threshold, score, and a hash of the input, so every automated
+// decision can later be tied to the model and data that produced it.
+// Normal and anomalous predictions can be sampled at different rates.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Record is one audited prediction.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Source names the entry point, e.g. "http" or "route".
+	Source string `json:"source,omitempty"`
+	// Route is the router key the sample was scored under, if any.
+	Route string `json:"route,omitempty"`
+	// Client identifies the caller, e.g. the API key name.
+	Client string `json:"client,omitempty"`
+	// Model is the version of the scoring model, see ModelVersion.
+	Model     string  `json:"model"`
+	Threshold float64 `json:"threshold"`
+	Score     float64 `json:"score"`
+	IsAnomaly bool    `json:"is_anomaly"`
+	// InputHash is the SHA-256 of the input features, see HashInput.
+	InputHash string `json:"input_hash"`
+}
+
+// Sink stores audit records. Implement it to write to a database; files
+// are supported by JSONLSink.
+type Sink interface {
+	// Write stores a single record.
+	Write(rec Record) error
+
+	// Close releases resources.
+	Close() error
+}
+
+// Stats holds audit counters.
+type Stats struct {
+	// Logged is the number of records written.
+	Logged uint64 `json:"logged"`
+	// Sampled is the number of predictions skipped by sampling.
+	Sampled uint64 `json:"sampled"`
+	// Errors is the number of records the sink failed to write.
+	Errors uint64 `json:"errors"`
+}
+
+// Logger samples predictions and writes them to a Sink.
+// It is safe for concurrent use.
+type Logger struct {
+	sink        Sink
+	normalRate  float64
+	anomalyRate float64
+	now         func() time.Time
+	sample      func() float64
+
+	logged  atomic.Uint64
+	sampled atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// Option configures a Logger.
+type Option func(*Logger)
+
+// WithSampleRate sets the fraction of normal predictions recorded, in [0, 1].
+func WithSampleRate(rate float64) Option {
+	return func(l *Logger) {
+		l.normalRate = rate
+	}
+}
+
+// WithAnomalySampleRate sets the fraction of anomalous predictions
+// recorded, in [0, 1]. Every anomaly is recorded by default.
+func WithAnomalySampleRate(rate float64) Option {
+	return func(l *Logger) {
+		l.anomalyRate = rate
+	}
+}
+
+// New creates a Logger that records every prediction to sink.
+func New(sink Sink, opts ...Option) *Logger {
+	l := &Logger{
+		sink:        sink,
+		normalRate:  1,
+		anomalyRate: 1,
+		now:         time.Now,
+		sample:      rand.Float64,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Log records rec, subject to sampling. Time and InputHash are filled in
+// from the current time and input when unset.
+func (l *Logger) Log(rec Record, input []float64) error {
+	rate := l.normalRate
+	if rec.IsAnomaly {
+		rate = l.anomalyRate
+	}
+	if rate < 1 && l.sample() >= rate {
+		l.sampled.Add(1)
+		return nil
+	}
+
+	if rec.Time.IsZero() {
+		rec.Time = l.now().UTC()
+	}
+	if rec.InputHash == "" {
+		rec.InputHash = HashInput(input)
+	}
+
+	if err := l.sink.Write(rec); err != nil {
+		l.errors.Add(1)
+		return err
+	}
+	l.logged.Add(1)
+	return nil
+}
+
+// Stats returns a snapshot of the audit counters.
+func (l *Logger) Stats() Stats {
+	return Stats{
+		Logged:  l.logged.Load(),
+		Sampled: l.sampled.Load(),
+		Errors:  l.errors.Load(),
+	}
+}
+
+// Close closes the sink.
+func (l *Logger) Close() error {
+	return l.sink.Close()
+}
+
+// HashInput returns the hex SHA-256 of the features' IEEE 754 bits in
+// little-endian order, so identical inputs hash identically across hosts.
+func HashInput(features []float64) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, v := range features {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// modelVersionLen is the number of hex digits kept in model versions.
+const modelVersionLen = 16
+
+// ModelVersion identifies a trained model by the SHA-256 of its serialized
+// form, truncated to 16 hex digits. Retraining or reloading a different
+// model changes the version.
+func ModelVersion(d detectors.Detector) (string, error) {
+	if d == nil {
+		return "", errors.New("no model")
+	}
+	data, err := d.Save()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:modelVersionLen], nil
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+// memorySink collects records in memory.
+type memorySink struct {
+	records []Record
+	err     error
+}
+
+func (m *memorySink) Write(rec Record) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, rec)
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+func TestLoggerSampling(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		draw       float64
+		isAnomaly  bool
+		wantLogged bool
+	}{
+		{name: "default logs normal", draw: 0.99, wantLogged: true},
+		{name: "default logs anomaly", draw: 0.99, isAnomaly: true, wantLogged: true},
+		{name: "normal below rate", opts: []Option{WithSampleRate(0.1)}, draw: 0.05, wantLogged: true},
+		{name: "normal above rate", opts: []Option{WithSampleRate(0.1)}, draw: 0.5},
+		{name: "normal rate does not apply to anomalies", opts: []Option{WithSampleRate(0)}, draw: 0.5, isAnomaly: true, wantLogged: true},
+		{name: "anomaly above rate", opts: []Option{WithAnomalySampleRate(0.2)}, draw: 0.5, isAnomaly: true},
+		{name: "zero rate", opts: []Option{WithSampleRate(0)}, draw: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			l := New(sink, tt.opts...)
+			l.sample = func() float64 { return tt.draw }
+
+			require.NoError(t, l.Log(Record{Score: 0.7, IsAnomaly: tt.isAnomaly}, []float64{1, 2}))
+
+			stats := l.Stats()
+			if tt.wantLogged {
+				require.Len(t, sink.records, 1)
+				assert.Equal(t, Stats{Logged: 1}, stats)
+			} else {
+				assert.Empty(t, sink.records)
+				assert.Equal(t, Stats{Sampled: 1}, stats)
+			}
+		})
+	}
+}
+
+func TestLoggerFillsRecord(t *testing.T) {
+	sink := &memorySink{}
+	l := New(sink)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Log(Record{Model: "abc", Threshold: 0.6, Score: 0.7, IsAnomaly: true}, []float64{1, 2}))
+	require.Len(t, sink.records, 1)
+	rec := sink.records[0]
+	assert.Equal(t, now, rec.Time)
+	assert.Equal(t, HashInput([]float64{1, 2}), rec.InputHash)
+	assert.Equal(t, "abc", rec.Model)
+
+	sink.err = errors.New("disk full")
+	assert.Error(t, l.Log(Record{}, nil))
+	assert.Equal(t, Stats{Logged: 1, Errors: 1}, l.Stats())
+}
+
+func TestHashInput(t *testing.T) {
+	a := HashInput([]float64{1, 2, 3})
+	assert.Len(t, a, 64)
+	assert.Equal(t, a, HashInput([]float64{1, 2, 3}))
+	assert.NotEqual(t, a, HashInput([]float64{1, 2, 3.0000001}))
+	assert.NotEqual(t, a, HashInput([]float64{3, 2, 1}))
+}
+
+func TestModelVersion(t *testing.T) {
+	_, err := ModelVersion(iforest.New())
+	assert.Error(t, err, "untrained models cannot be versioned")
+
+	data := [][]float64{{0, 0}, {1, 1}, {0, 1}, {1, 0}, {0.5, 0.5}}
+	a := iforest.New(iforest.WithTrees(5), iforest.WithSeed(1))
+	require.NoError(t, a.Fit(data))
+	b := iforest.New(iforest.WithTrees(5), iforest.WithSeed(2))
+	require.NoError(t, b.Fit(data))
+
+	va, err := ModelVersion(a)
+	require.NoError(t, err)
+	assert.Len(t, va, 16)
+	again, err := ModelVersion(a)
+	require.NoError(t, err)
+	assert.Equal(t, va, again)
+
+	vb, err := ModelVersion(b)
+	require.NoError(t, err)
+	assert.NotEqual(t, va, vb)
+}
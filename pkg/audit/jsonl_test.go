@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenJSONLAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	for i := range 2 {
+		sink, err := OpenJSONL(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Write(Record{Model: "m", Score: float64(i)}))
+		require.NoError(t, sink.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var scores []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		scores = append(scores, rec.Score)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []float64{0, 1}, scores)
+}
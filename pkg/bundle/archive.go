@@ -0,0 +1,185 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Archive entry names.
+const (
+	manifestName    = "manifest.json"
+	modelName       = "model.bin"
+	calibrationName = "calibration.json"
+	filesDir        = "files/"
+)
+
+// maxEntryBytes limits the size of a single archive entry on import.
+const maxEntryBytes = 1 << 30
+
+// entry is a file in the archive.
+type entry struct {
+	name string
+	data []byte
+}
+
+// Export writes the bundle to the named file.
+func (b *Bundle) Export(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := b.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Write writes the bundle as a gzipped tar archive to w.
+func (b *Bundle) Write(w io.Writer) error {
+	manifest := b.Manifest
+	manifest.Files = make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		if err := validName(name); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+	sort.Strings(manifest.Files)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	entries := []entry{
+		{manifestName, manifestData},
+		{modelName, b.Model},
+	}
+	if b.Calibration != nil {
+		data, err := json.Marshal(b.Calibration)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{calibrationName, data})
+	}
+	for _, name := range manifest.Files {
+		entries = append(entries, entry{filesDir + name, b.Files[name]})
+	}
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.name,
+			Mode:    0o600,
+			Size:    int64(len(e.data)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Import reads a bundle from the named file.
+func Import(filename string) (*Bundle, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Read reads a bundle written by Write and verifies the model checksum.
+func Read(r io.Reader) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	defer gz.Close()
+
+	b := &Bundle{Files: make(map[string][]byte)}
+	var haveManifest, haveModel bool
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxEntryBytes {
+			return nil, fmt.Errorf("%w: entry %s too large", ErrFormat, hdr.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxEntryBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		switch name := hdr.Name; {
+		case name == manifestName:
+			if err := json.Unmarshal(data, &b.Manifest); err != nil {
+				return nil, fmt.Errorf("%w: manifest: %v", ErrFormat, err)
+			}
+			haveManifest = true
+		case name == modelName:
+			b.Model = data
+			haveModel = true
+		case name == calibrationName:
+			b.Calibration = &Calibration{}
+			if err := json.Unmarshal(data, b.Calibration); err != nil {
+				return nil, fmt.Errorf("%w: calibration: %v", ErrFormat, err)
+			}
+		case strings.HasPrefix(name, filesDir):
+			file := strings.TrimPrefix(name, filesDir)
+			if err := validName(file); err != nil {
+				return nil, err
+			}
+			b.Files[file] = data
+		}
+	}
+
+	if !haveManifest || !haveModel {
+		return nil, fmt.Errorf("%w: missing %s or %s", ErrFormat, manifestName, modelName)
+	}
+	if b.Manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%w: format version %d is newer than %d", ErrFormat, b.Manifest.FormatVersion, FormatVersion)
+	}
+	if checksum(b.Model) != b.Manifest.ModelSHA256 {
+		return nil, ErrChecksum
+	}
+	return b, nil
+}
+
+// validName rejects extra file names that are empty or escape the files
+// directory.
+func validName(name string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") || name == ".." {
+		return fmt.Errorf("bundle: invalid file name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestExportImport(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithFeatureNames([]string{"a", "b", "c"}))
+	data := generateTestData(300, 3)
+	require.NoError(t, f.Fit(data))
+	scores, err := f.Predict(data)
+	require.NoError(t, err)
+
+	b, err := New(f, "iforest",
+		WithCalibrationScores(scores),
+		WithFile("pipeline.yaml", []byte("scale: standard\n")),
+	)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "model.tar.gz")
+	require.NoError(t, b.Export(path))
+
+	got, err := Import(path)
+	require.NoError(t, err)
+	assert.Equal(t, b.Model, got.Model)
+	assert.Equal(t, b.Calibration, got.Calibration)
+	assert.Equal(t, []byte("scale: standard\n"), got.Files["pipeline.yaml"])
+	assert.Equal(t, []string{"pipeline.yaml"}, got.Manifest.Files)
+	assert.Equal(t, b.Manifest.FeatureNames, got.Manifest.FeatureNames)
+	assert.Equal(t, b.Manifest.Card.DataHash, got.Manifest.Card.DataHash)
+
+	loaded := iforest.New()
+	require.NoError(t, got.Load(loaded))
+	want, err := f.Predict(data[:10])
+	require.NoError(t, err)
+	have, err := loaded.Predict(data[:10])
+	require.NoError(t, err)
+	assert.Equal(t, want, have)
+	assert.Equal(t, f.Threshold(), loaded.Threshold())
+}
+
+func TestReadErrors(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(5))
+	require.NoError(t, f.Fit(generateTestData(50, 2)))
+	b, err := New(f, "iforest")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		wantErr error
+	}{
+		{
+			name:    "not gzip",
+			archive: func(*testing.T) []byte { return []byte("model bytes") },
+			wantErr: ErrFormat,
+		},
+		{
+			name: "tampered model",
+			archive: func(t *testing.T) []byte {
+				tampered := *b
+				tampered.Model = append([]byte{0}, b.Model...)
+				var buf bytes.Buffer
+				require.NoError(t, tampered.Write(&buf))
+				return buf.Bytes()
+			},
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "newer format",
+			archive: func(t *testing.T) []byte {
+				newer := *b
+				newer.Manifest.FormatVersion = FormatVersion + 1
+				var buf bytes.Buffer
+				require.NoError(t, newer.Write(&buf))
+				return buf.Bytes()
+			},
+			wantErr: ErrFormat,
+		},
+		{
+			name: "missing model",
+			archive: func(t *testing.T) []byte {
+				return writeArchive(t, map[string][]byte{manifestName: []byte(`{"format_version": 1}`)})
+			},
+			wantErr: ErrFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.archive(t)))
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"pipeline.yaml", "config/scaler.json"} {
+		assert.NoError(t, validName(name), name)
+	}
+	for _, name := range []string{"", "/etc/passwd", "..", "../x", "a/../../x", "a//b"} {
+		assert.Error(t, validName(name), name)
+	}
+}
+
+// writeArchive builds a gzipped tar archive from raw entries.
+func writeArchive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
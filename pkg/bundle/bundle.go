@@ -0,0 +1,201 @@
+// Package bundle packages a trained model with everything needed to score
+// with it elsewhere.
+//
+// A bundle is a gzipped tar archive holding the serialized model, a JSON
+// manifest (algorithm, configuration, feature names, threshold, model
+// card), the training score distribution used to calibrate thresholds, and
+// any extra files such as a preprocessing configuration.
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// FormatVersion is the version of the bundle layout written by Export.
+const FormatVersion = 1
+
+// calibrationPoints is the number of training score quantiles kept for
+// threshold calibration: every 0.1% from 0 to 100%.
+const calibrationPoints = 1001
+
+// Bundle errors.
+var (
+	ErrChecksum = errors.New("bundle: model checksum mismatch")
+	ErrFormat   = errors.New("bundle: unsupported format")
+)
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Algorithm names the detector type, e.g. "iforest".
+	Algorithm string `json:"algorithm"`
+	// Config holds the hyperparameters the model was trained with.
+	Config map[string]string `json:"config,omitempty"`
+	// FeatureNames lists the model's inputs in order.
+	FeatureNames []string `json:"feature_names,omitempty"`
+	// Threshold is the anomaly threshold to apply when scoring.
+	Threshold float64 `json:"threshold"`
+	// Card is the model card recorded at training time, if any.
+	Card *detectors.ModelCard `json:"card,omitempty"`
+	// ModelSHA256 is the hex SHA-256 of the serialized model.
+	ModelSHA256    string `json:"model_sha256"`
+	LibraryVersion string `json:"library_version"`
+	// Files lists the names of the extra files in the bundle.
+	Files []string `json:"files,omitempty"`
+}
+
+// Calibration is the distribution of training scores, kept so thresholds
+// can be recalibrated for a different contamination without retraining.
+type Calibration struct {
+	// Samples is the number of training scores summarized.
+	Samples int `json:"samples"`
+	// Quantiles are the training scores at every 0.1% from 0 to 100%.
+	Quantiles []float64 `json:"quantiles"`
+}
+
+// NewCalibration summarizes training scores.
+func NewCalibration(scores []float64) (*Calibration, error) {
+	if len(scores) == 0 {
+		return nil, errors.New("bundle: no calibration scores")
+	}
+
+	sorted := make([]float64, len(scores))
+	copy(sorted, scores)
+	sort.Float64s(sorted)
+
+	c := &Calibration{Samples: len(sorted), Quantiles: make([]float64, calibrationPoints)}
+	last := float64(len(sorted) - 1)
+	for i := range c.Quantiles {
+		c.Quantiles[i] = sorted[int(last*float64(i)/(calibrationPoints-1))]
+	}
+	return c, nil
+}
+
+// Threshold returns the score above which the given fraction of training
+// samples fall.
+func (c *Calibration) Threshold(contamination float64) float64 {
+	q := 1 - math.Min(1, math.Max(0, contamination))
+	pos := q * float64(len(c.Quantiles)-1)
+	i := int(pos)
+	if i >= len(c.Quantiles)-1 {
+		return c.Quantiles[len(c.Quantiles)-1]
+	}
+	frac := pos - float64(i)
+	return c.Quantiles[i] + frac*(c.Quantiles[i+1]-c.Quantiles[i])
+}
+
+// Bundle is a model with its manifest, calibration data, and extra files.
+type Bundle struct {
+	Manifest Manifest
+	// Model is the serialized detector, as returned by Detector.Save.
+	Model []byte
+	// Calibration is the training score distribution, if recorded.
+	Calibration *Calibration
+	// Files holds extra content by name, such as preprocessing configuration.
+	Files map[string][]byte
+}
+
+// Option configures a Bundle created by New.
+type Option func(*Bundle) error
+
+// WithFeatureNames sets the feature names, overriding those in the model card.
+func WithFeatureNames(names []string) Option {
+	return func(b *Bundle) error {
+		b.Manifest.FeatureNames = names
+		return nil
+	}
+}
+
+// WithConfig sets the configuration, overriding the model card's
+// hyperparameters.
+func WithConfig(config map[string]string) Option {
+	return func(b *Bundle) error {
+		b.Manifest.Config = config
+		return nil
+	}
+}
+
+// WithCalibrationScores records the distribution of training scores.
+func WithCalibrationScores(scores []float64) Option {
+	return func(b *Bundle) error {
+		c, err := NewCalibration(scores)
+		if err != nil {
+			return err
+		}
+		b.Calibration = c
+		return nil
+	}
+}
+
+// WithFile adds an extra file, e.g. the preprocessing pipeline applied to
+// raw data before scoring.
+func WithFile(name string, data []byte) Option {
+	return func(b *Bundle) error {
+		if err := validName(name); err != nil {
+			return err
+		}
+		b.Files[name] = data
+		return nil
+	}
+}
+
+// New bundles the trained detector d of the named algorithm. The
+// threshold, feature names, and configuration are taken from d when it
+// implements detectors.Thresholder and detectors.Describer.
+func New(d detectors.Detector, algorithm string, opts ...Option) (*Bundle, error) {
+	model, err := d.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		Manifest: Manifest{
+			FormatVersion:  FormatVersion,
+			CreatedAt:      time.Now().UTC(),
+			Algorithm:      algorithm,
+			Threshold:      detectors.ThresholdOf(d),
+			ModelSHA256:    checksum(model),
+			LibraryVersion: detectors.LibraryVersion(),
+		},
+		Model: model,
+		Files: make(map[string][]byte),
+	}
+	if card, ok := detectors.MetadataOf(d); ok && !card.TrainedAt.IsZero() {
+		b.Manifest.Card = &card
+		b.Manifest.Config = card.Hyperparameters
+		b.Manifest.FeatureNames = card.FeatureNames
+	}
+
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Load restores the bundled model into d and applies the bundle threshold.
+// d must be an untrained detector of the bundle's algorithm.
+func (b *Bundle) Load(d detectors.Detector) error {
+	if err := d.Load(b.Model); err != nil {
+		return fmt.Errorf("bundle: load %s model: %w", b.Manifest.Algorithm, err)
+	}
+	if t, ok := d.(detectors.Thresholder); ok {
+		t.SetThreshold(b.Manifest.Threshold)
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package bundle
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestNew(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithFeatureNames([]string{"a", "b"}))
+	data := generateTestData(200, 2)
+	require.NoError(t, f.Fit(data))
+	f.SetThreshold(0.61)
+
+	b, err := New(f, "iforest")
+	require.NoError(t, err)
+	assert.Equal(t, FormatVersion, b.Manifest.FormatVersion)
+	assert.Equal(t, "iforest", b.Manifest.Algorithm)
+	assert.Equal(t, 0.61, b.Manifest.Threshold)
+	assert.Equal(t, []string{"a", "b"}, b.Manifest.FeatureNames)
+	assert.Equal(t, "10", b.Manifest.Config["trees"])
+	require.NotNil(t, b.Manifest.Card)
+	assert.Equal(t, 200, b.Manifest.Card.Rows)
+	assert.Nil(t, b.Calibration)
+
+	b, err = New(f, "iforest", WithFeatureNames([]string{"x", "y"}), WithConfig(map[string]string{"k": "v"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, b.Manifest.FeatureNames)
+	assert.Equal(t, map[string]string{"k": "v"}, b.Manifest.Config)
+
+	_, err = New(iforest.New(), "iforest")
+	assert.Error(t, err, "untrained models cannot be bundled")
+
+	_, err = New(f, "iforest", WithFile("../escape", nil))
+	assert.Error(t, err)
+}
+
+func TestLoadAppliesThreshold(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10))
+	require.NoError(t, f.Fit(generateTestData(100, 2)))
+
+	b, err := New(f, "iforest")
+	require.NoError(t, err)
+	b.Manifest.Threshold = 0.75
+
+	loaded := iforest.New()
+	require.NoError(t, b.Load(loaded))
+	assert.Equal(t, 0.75, loaded.Threshold())
+
+	assert.Error(t, (&Bundle{Model: []byte("garbage")}).Load(iforest.New()))
+}
+
+func TestCalibrationThreshold(t *testing.T) {
+	scores := make([]float64, 1000)
+	for i := range scores {
+		scores[i] = float64(i) / 1000
+	}
+	c, err := NewCalibration(scores)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, c.Samples)
+
+	tests := []struct {
+		contamination float64
+		want          float64
+	}{
+		{contamination: 0, want: 0.999},
+		{contamination: 0.1, want: 0.899},
+		{contamination: 0.5, want: 0.4995},
+		{contamination: 1, want: 0},
+		{contamination: 2, want: 0},
+	}
+	for _, tt := range tests {
+		assert.InDelta(t, tt.want, c.Threshold(tt.contamination), 0.002, "contamination %v", tt.contamination)
+	}
+
+	_, err = NewCalibration(nil)
+	assert.Error(t, err)
+}
+
+func generateTestData(n, features int) [][]float64 {
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = make([]float64, features)
+		for j := range data[i] {
+			data[i][j] = rand.NormFloat64()
+		}
+	}
+	return data
+}
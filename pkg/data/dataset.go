@@ -0,0 +1,187 @@
+// Package data provides a contiguous in-memory dataset.
+//
+// A Dataset stores all feature values in one row-major backing array
+// instead of one slice per row, so large datasets cost a single allocation
+// and rows are laid out next to each other in memory.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Dataset is a matrix of samples (rows) by features (columns) with
+// optional feature names, labels, and timestamps.
+type Dataset struct {
+	values []float64
+	rows   int
+	cols   int
+
+	names      []string
+	labels     []float64
+	timestamps []time.Time
+}
+
+// New creates a zero-filled dataset with the given shape.
+func New(rows, cols int) *Dataset {
+	return &Dataset{values: make([]float64, rows*cols), rows: rows, cols: cols}
+}
+
+// FromValues wraps a row-major backing array without copying it.
+// len(values) must be a multiple of cols.
+func FromValues(values []float64, cols int) (*Dataset, error) {
+	if cols <= 0 {
+		return nil, errors.New("data: features must be positive")
+	}
+	if len(values)%cols != 0 {
+		return nil, fmt.Errorf("data: %d values do not fill rows of %d features", len(values), cols)
+	}
+	return &Dataset{values: values, rows: len(values) / cols, cols: cols}, nil
+}
+
+// FromRows copies rows into a new dataset. Every row must have the same
+// number of features.
+func FromRows(rows [][]float64) (*Dataset, error) {
+	if len(rows) == 0 {
+		return &Dataset{}, nil
+	}
+
+	cols := len(rows[0])
+	d := New(len(rows), cols)
+	for i, row := range rows {
+		if len(row) != cols {
+			return nil, fmt.Errorf("data: row %d has %d features, expected %d", i, len(row), cols)
+		}
+		copy(d.values[i*cols:], row)
+	}
+	return d, nil
+}
+
+// Len returns the number of samples.
+func (d *Dataset) Len() int {
+	return d.rows
+}
+
+// Features returns the number of features per sample.
+func (d *Dataset) Features() int {
+	return d.cols
+}
+
+// Values returns the row-major backing array. Changes to it are visible
+// in the dataset.
+func (d *Dataset) Values() []float64 {
+	return d.values
+}
+
+// Row returns sample i as a view into the backing array.
+func (d *Dataset) Row(i int) []float64 {
+	return d.values[i*d.cols : (i+1)*d.cols : (i+1)*d.cols]
+}
+
+// Rows returns every sample as a view into the backing array, for APIs
+// that take [][]float64. Only the slice headers are allocated.
+func (d *Dataset) Rows() [][]float64 {
+	rows := make([][]float64, d.rows)
+	for i := range rows {
+		rows[i] = d.Row(i)
+	}
+	return rows
+}
+
+// At returns feature j of sample i.
+func (d *Dataset) At(i, j int) float64 {
+	return d.values[i*d.cols+j]
+}
+
+// Set sets feature j of sample i.
+func (d *Dataset) Set(i, j int, v float64) {
+	d.values[i*d.cols+j] = v
+}
+
+// Column returns a copy of feature j across all samples.
+func (d *Dataset) Column(j int) []float64 {
+	col := make([]float64, d.rows)
+	for i := range col {
+		col[i] = d.values[i*d.cols+j]
+	}
+	return col
+}
+
+// Append adds a sample, copying it. The first sample appended to an empty
+// dataset sets the number of features. Datasets with labels or timestamps
+// cannot be appended to, since the new sample would have neither.
+func (d *Dataset) Append(row []float64) error {
+	if d.labels != nil || d.timestamps != nil {
+		return errors.New("data: cannot append to a dataset with labels or timestamps")
+	}
+	if d.rows == 0 && d.cols == 0 {
+		d.cols = len(row)
+	}
+	if len(row) != d.cols {
+		return fmt.Errorf("data: row has %d features, expected %d", len(row), d.cols)
+	}
+	d.values = append(d.values, row...)
+	d.rows++
+	return nil
+}
+
+// Slice returns samples [i, j) as a dataset sharing the backing array.
+// Labels and timestamps are sliced along with the values.
+func (d *Dataset) Slice(i, j int) *Dataset {
+	s := &Dataset{
+		values: d.values[i*d.cols : j*d.cols : j*d.cols],
+		rows:   j - i,
+		cols:   d.cols,
+		names:  d.names,
+	}
+	if d.labels != nil {
+		s.labels = d.labels[i:j:j]
+	}
+	if d.timestamps != nil {
+		s.timestamps = d.timestamps[i:j:j]
+	}
+	return s
+}
+
+// FeatureNames returns the feature names, or nil if unset.
+func (d *Dataset) FeatureNames() []string {
+	return d.names
+}
+
+// SetFeatureNames names the features.
+func (d *Dataset) SetFeatureNames(names []string) error {
+	if names != nil && len(names) != d.cols {
+		return fmt.Errorf("data: %d names for %d features", len(names), d.cols)
+	}
+	d.names = names
+	return nil
+}
+
+// Labels returns one label per sample, e.g. 1 for known anomalies, or nil.
+func (d *Dataset) Labels() []float64 {
+	return d.labels
+}
+
+// SetLabels sets one label per sample.
+func (d *Dataset) SetLabels(labels []float64) error {
+	if labels != nil && len(labels) != d.rows {
+		return fmt.Errorf("data: %d labels for %d samples", len(labels), d.rows)
+	}
+	d.labels = labels
+	return nil
+}
+
+// Timestamps returns one timestamp per sample, or nil.
+func (d *Dataset) Timestamps() []time.Time {
+	return d.timestamps
+}
+
+// SetTimestamps sets one timestamp per sample.
+func (d *Dataset) SetTimestamps(ts []time.Time) error {
+	if ts != nil && len(ts) != d.rows {
+		return fmt.Errorf("data: %d timestamps for %d samples", len(ts), d.rows)
+	}
+	d.timestamps = ts
+	return nil
+}
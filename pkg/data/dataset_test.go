@@ -0,0 +1,89 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]float64
+		wantLen int
+		wantErr bool
+	}{
+		{name: "empty", rows: nil, wantLen: 0},
+		{name: "rectangular", rows: [][]float64{{1, 2}, {3, 4}, {5, 6}}, wantLen: 3},
+		{name: "ragged", rows: [][]float64{{1, 2}, {3}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := FromRows(tt.rows)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLen, d.Len())
+			for i, row := range tt.rows {
+				assert.Equal(t, row, d.Row(i))
+			}
+		})
+	}
+}
+
+func TestDatasetViews(t *testing.T) {
+	d, err := FromValues([]float64{1, 2, 3, 4, 5, 6}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.Len())
+	assert.Equal(t, 3, d.Features())
+
+	assert.Equal(t, []float64{4, 5, 6}, d.Row(1))
+	assert.Equal(t, 6.0, d.At(1, 2))
+	assert.Equal(t, []float64{2, 5}, d.Column(1))
+
+	// Rows share the backing array.
+	d.Rows()[0][0] = 10
+	assert.Equal(t, 10.0, d.At(0, 0))
+	d.Set(1, 0, 40)
+	assert.Equal(t, 40.0, d.Values()[3])
+
+	// Appending to a row view must not overwrite the next row.
+	_ = append(d.Row(0), 99)
+	assert.Equal(t, 40.0, d.At(1, 0))
+
+	_, err = FromValues([]float64{1, 2, 3}, 2)
+	assert.Error(t, err)
+	_, err = FromValues(nil, 0)
+	assert.Error(t, err)
+}
+
+func TestDatasetAppendAndSlice(t *testing.T) {
+	d := &Dataset{}
+	require.NoError(t, d.Append([]float64{1, 2}))
+	require.NoError(t, d.Append([]float64{3, 4}))
+	require.NoError(t, d.Append([]float64{5, 6}))
+	assert.Error(t, d.Append([]float64{7}))
+	assert.Equal(t, 3, d.Len())
+
+	ts := []time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(3, 0)}
+	require.NoError(t, d.SetTimestamps(ts))
+	require.NoError(t, d.SetLabels([]float64{0, 1, 0}))
+	require.NoError(t, d.SetFeatureNames([]string{"a", "b"}))
+	assert.Error(t, d.Append([]float64{7, 8}), "labels would go out of sync")
+
+	s := d.Slice(1, 3)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, []float64{3, 4}, s.Row(0))
+	assert.Equal(t, []float64{1, 0}, s.Labels())
+	assert.Equal(t, ts[1:], s.Timestamps())
+	assert.Equal(t, []string{"a", "b"}, s.FeatureNames())
+
+	assert.Error(t, d.SetFeatureNames([]string{"a"}))
+	assert.Error(t, d.SetLabels([]float64{1}))
+	assert.Error(t, d.SetTimestamps(ts[:1]))
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"math"
+	"sync"
+)
+
+// Imputer fills in missing values, marked as NaN, in place.
+type Imputer interface {
+	Impute(row []float64)
+}
+
+// ImputerFunc adapts a function to the Imputer interface.
+type ImputerFunc func(row []float64)
+
+// Impute calls fn(row).
+func (fn ImputerFunc) Impute(row []float64) {
+	fn(row)
+}
+
+// ConstantImputer replaces missing values with v.
+func ConstantImputer(v float64) Imputer {
+	return ImputerFunc(func(row []float64) {
+		for j, x := range row {
+			if math.IsNaN(x) {
+				row[j] = v
+			}
+		}
+	})
+}
+
+// MeanImputer replaces missing values with the running mean of the values
+// seen so far in the same column, or 0 before any. It learns from every
+// row it is given, so it suits streams where the column means are not
+// known in advance. A MeanImputer is safe for concurrent use.
+type MeanImputer struct {
+	mu    sync.Mutex
+	sum   []float64
+	count []float64
+}
+
+// NewMeanImputer creates a MeanImputer with no observations.
+func NewMeanImputer() *MeanImputer {
+	return &MeanImputer{}
+}
+
+// Impute fills the missing values of row and records the others.
+func (m *MeanImputer) Impute(row []float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n := len(row) - len(m.sum); n > 0 {
+		m.sum = append(m.sum, make([]float64, n)...)
+		m.count = append(m.count, make([]float64, n)...)
+	}
+	for j, x := range row {
+		if !math.IsNaN(x) {
+			m.sum[j] += x
+			m.count[j]++
+		}
+	}
+	for j, x := range row {
+		if math.IsNaN(x) {
+			row[j] = 0
+			if m.count[j] > 0 {
+				row[j] = m.sum[j] / m.count[j]
+			}
+		}
+	}
+}
+
+// Means returns the current mean of each column seen so far.
+func (m *MeanImputer) Means() []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	means := make([]float64, len(m.sum))
+	for j := range means {
+		if m.count[j] > 0 {
+			means[j] = m.sum[j] / m.count[j]
+		}
+	}
+	return means
+}
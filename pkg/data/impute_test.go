@@ -0,0 +1,37 @@
+package data
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantImputer(t *testing.T) {
+	row := []float64{1, math.NaN(), 3, math.NaN()}
+	ConstantImputer(-1).Impute(row)
+	assert.Equal(t, []float64{1, -1, 3, -1}, row)
+}
+
+func TestMeanImputer(t *testing.T) {
+	nan := math.NaN()
+	m := NewMeanImputer()
+
+	rows := [][]float64{
+		{nan, 10},
+		{2, 20},
+		{4, nan},
+		{nan, nan, 7},
+	}
+	want := [][]float64{
+		{0, 10},
+		{2, 20},
+		{4, 15},
+		{3, 15, 7},
+	}
+	for i, row := range rows {
+		m.Impute(row)
+		assert.Equal(t, want[i], row, "row %d", i)
+	}
+	assert.Equal(t, []float64{3, 15, 7}, m.Means())
+}
@@ -0,0 +1,699 @@
+// Package autoencoder implements a reconstruction-error anomaly detector.
+//
+// An autoencoder is a small neural network trained to reproduce its input
+// through a narrower bottleneck layer, which forces it to learn how
+// features vary together. Samples that break the learned relationships,
+// such as bytes that do not match the packet count, reconstruct poorly and
+// score high even when every feature is individually common. This
+// complements the Isolation Forest, whose axis-parallel splits see
+// correlated features one at a time.
+//
+// The network is a multilayer perceptron with tanh hidden layers, mirrored
+// around the bottleneck and trained with Adam on standardized features
+// using gonum. It is meant to be lightweight: a few thousand parameters
+// trained on a CPU in seconds.
+package autoencoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// layer sizes, epochs, batch size, learning rate or contamination.
+var ErrInvalidOption = fmt.Errorf("autoencoder: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of samples reconstructed at once, and between
+// context checks.
+const scoreChunk = 1024
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// Autoencoder is a reconstruction-error detector. It is safe for
+// concurrent use.
+type Autoencoder struct {
+	mu sync.RWMutex
+
+	// Configuration
+	encoder       []int
+	epochs        int
+	batchSize     int
+	learningRate  float64
+	seed          int64
+	contamination float64
+	threshold     float64
+	workers       int
+	explainTop    int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	mean []float64
+	std  []float64
+	net  network
+	// layers are the encoder widths trained with, encoder or the default.
+	layers []int
+	// scale is the mean reconstruction error of the training data, which
+	// scores 0.5.
+	scale       float64
+	loss        []float64
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures an Autoencoder.
+type Option func(*Autoencoder)
+
+// WithLayers sets the widths of the encoder layers, the last being the
+// bottleneck; the decoder mirrors them. The default has two layers of
+// half and a quarter of the features, rounded up. The bottleneck should
+// be narrower than the number of features, or the network can learn to
+// copy its input.
+func WithLayers(widths ...int) Option {
+	return func(a *Autoencoder) {
+		a.encoder = slices.Clone(widths)
+	}
+}
+
+// WithEpochs sets the number of passes over the training data, 50 by
+// default.
+func WithEpochs(n int) Option {
+	return func(a *Autoencoder) {
+		a.epochs = n
+	}
+}
+
+// WithBatchSize sets the number of samples per optimization step, 64 by
+// default.
+func WithBatchSize(n int) Option {
+	return func(a *Autoencoder) {
+		a.batchSize = n
+	}
+}
+
+// WithLearningRate sets the Adam step size, 0.005 by default.
+func WithLearningRate(rate float64) Option {
+	return func(a *Autoencoder) {
+		a.learningRate = rate
+	}
+}
+
+// WithSeed sets the random seed of weight initialization and batch order,
+// for reproducible training.
+func WithSeed(seed int64) Option {
+	return func(a *Autoencoder) {
+		a.seed = seed
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(a *Autoencoder) {
+		a.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to score batches. n <= 0,
+// the default, uses detectors.DefaultWorkers at each call.
+func WithWorkers(n int) Option {
+	return func(a *Autoencoder) {
+		a.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(a *Autoencoder) {
+		a.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(a *Autoencoder) {
+		a.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new Autoencoder.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(a *Autoencoder) {
+		a.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(a *Autoencoder) {
+		a.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(a *Autoencoder) {
+		a.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained Autoencoder with the given options.
+func New(opts ...Option) *Autoencoder {
+	a := &Autoencoder{
+		epochs:        50,
+		batchSize:     64,
+		learningRate:  0.005,
+		seed:          42,
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.workers = max(a.workers, 0)
+	a.explainTop = max(a.explainTop, 0)
+	return a
+}
+
+// Validate checks the layer sizes, epochs, batch size, learning rate and
+// contamination set by the options.
+func (a *Autoencoder) Validate() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.validate()
+}
+
+func (a *Autoencoder) validate() error {
+	var errs []error
+	for _, w := range a.encoder {
+		if w < 1 {
+			errs = append(errs, fmt.Errorf("%w: WithLayers(%v): widths must be positive", ErrInvalidOption, a.encoder))
+			break
+		}
+	}
+	if a.epochs < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithEpochs(%d): must be positive", ErrInvalidOption, a.epochs))
+	}
+	if a.batchSize < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithBatchSize(%d): must be positive", ErrInvalidOption, a.batchSize))
+	}
+	if !(a.learningRate > 0) || math.IsInf(a.learningRate, 0) {
+		errs = append(errs, fmt.Errorf("%w: WithLearningRate(%g): must be positive", ErrInvalidOption, a.learningRate))
+	}
+	if !(a.contamination >= 0 && a.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, a.contamination))
+	}
+	if a.severity != nil {
+		if err := a.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// defaultLayers returns the default encoder widths for dim features.
+func defaultLayers(dim int) []int {
+	return []int{max(1, (dim+1)/2), max(1, (dim+3)/4)}
+}
+
+// Fit standardizes data and trains the network to reconstruct it.
+// Features must be finite.
+func (a *Autoencoder) Fit(data [][]float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if a.featureNames != nil && len(a.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(a.featureNames), nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	a.mean, a.std = standardization(data)
+	x := mat.NewDense(len(data), nFeatures, nil)
+	for i, row := range data {
+		a.standardize(row, x.RawRowView(i))
+	}
+
+	a.layers = a.encoder
+	if a.layers == nil {
+		a.layers = defaultLayers(nFeatures)
+	}
+	rng := rand.New(rand.NewSource(a.seed))
+	a.net = newNetwork(nFeatures, a.layers, rng)
+	opt := newAdam(a.net, a.learningRate)
+	order := make([]int, len(data))
+	for i := range order {
+		order[i] = i
+	}
+	batchSize := min(a.batchSize, len(data))
+	batch := mat.NewDense(batchSize, nFeatures, nil)
+	a.loss = make([]float64, a.epochs)
+	for epoch := range a.epochs {
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		var sum float64
+		for lo := 0; lo < len(order); lo += batchSize {
+			rows := order[lo:min(lo+batchSize, len(order))]
+			b := batch
+			if len(rows) < batchSize {
+				b = batch.Slice(0, len(rows), 0, nFeatures).(*mat.Dense)
+			}
+			for i, row := range rows {
+				copy(b.RawRowView(i), x.RawRowView(row))
+			}
+			sum += opt.train(a.net, b) * float64(len(rows))
+		}
+		a.loss[epoch] = sum / float64(len(order))
+	}
+	a.scale = 1
+	a.trained = true
+
+	// Score the training data once, for the score scale, the threshold and
+	// the feature importances.
+	raw := make([]float64, len(data))
+	importances := make([]float64, nFeatures)
+	var mu sync.Mutex
+	detectors.ParallelFor(len(data), detectors.Workers(a.workers), scoreChunk, func(lo, hi int) {
+		shares := make([]float64, nFeatures)
+		for c := lo; c < hi; c += scoreChunk {
+			chunk := data[c:min(c+scoreChunk, hi)]
+			errs := a.residuals(chunk)
+			for i := range chunk {
+				row := errs.RawRowView(i)
+				raw[c+i] = mean(row)
+				for j, e := range row {
+					shares[j] += e
+				}
+			}
+		}
+		mu.Lock()
+		for j, s := range shares {
+			importances[j] += s
+		}
+		mu.Unlock()
+	})
+	if m := mean(raw); m > 0 {
+		a.scale = m
+	}
+	a.importances = normalize(importances)
+
+	if a.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(raw), 100)
+		for _, r := range raw {
+			est.Add(a.score(r))
+		}
+		a.threshold = est.Quantile(1 - a.contamination)
+	}
+	a.card = a.modelCard(data)
+	return nil
+}
+
+// standardization returns the mean and standard deviation of each feature
+// of data. Constant features get a deviation of 1.
+func standardization(data [][]float64) (means, std []float64) {
+	n := len(data[0])
+	means, std = make([]float64, n), make([]float64, n)
+	column := make([]float64, len(data))
+	for j := range n {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		means[j], std[j] = stats.MeanStd(column)
+		if !(std[j] > 0) {
+			std[j] = 1
+		}
+	}
+	return means, std
+}
+
+// standardize writes the standardized sample to dst. Values that are not
+// finite are replaced with the training mean. The caller holds the read
+// lock.
+func (a *Autoencoder) standardize(sample, dst []float64) {
+	for j, v := range sample {
+		dst[j] = 0
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			dst[j] = stats.Standardize(v, a.mean[j], a.std[j])
+		}
+	}
+}
+
+// reconstruct returns the standardized samples and their reconstructions.
+// The caller holds the read lock.
+func (a *Autoencoder) reconstruct(samples [][]float64) (x, out *mat.Dense) {
+	x = mat.NewDense(len(samples), len(a.mean), nil)
+	for i, s := range samples {
+		a.standardize(s, x.RawRowView(i))
+	}
+	acts := a.net.forward(x)
+	return x, acts[len(acts)-1]
+}
+
+// residuals returns the squared reconstruction error of every feature of
+// samples, in standard deviations. Features that are not finite cannot be
+// reconstructed and have an infinite error. The caller holds the read
+// lock.
+func (a *Autoencoder) residuals(samples [][]float64) *mat.Dense {
+	x, out := a.reconstruct(samples)
+	out.Sub(out, x)
+	out.MulElem(out, out)
+	for i, s := range samples {
+		row := out.RawRowView(i)
+		for j, v := range s {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				row[j] = math.Inf(1)
+			}
+		}
+	}
+	return out
+}
+
+// mean returns the mean of values.
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// score maps a raw reconstruction error to [0, 1]: 0.5 for the mean
+// training sample, rising toward 1 as reconstructions get worse.
+func (a *Autoencoder) score(raw float64) float64 {
+	return 1 - math.Exp2(-raw/a.scale)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (a *Autoencoder) Predict(data [][]float64) ([]float64, error) {
+	return a.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (a *Autoencoder) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(a.mean) {
+			return nil, fmt.Errorf("sample %d: %w", i, a.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(a.workers), scoreChunk, func(lo, hi int) {
+		for c := lo; c < hi; c += scoreChunk {
+			if ctx.Err() != nil {
+				return
+			}
+			chunk := data[c:min(c+scoreChunk, hi)]
+			errs := a.residuals(chunk)
+			for i := range chunk {
+				scores[c+i] = a.score(mean(errs.RawRowView(i)))
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (a *Autoencoder) PredictOne(sample []float64) (float64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(a.mean) {
+		return 0, a.dimensionError(sample)
+	}
+	errs := a.residuals([][]float64{sample})
+	return a.score(mean(errs.RawRowView(0))), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (a *Autoencoder) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(a.mean)}
+}
+
+// PredictStream scores samples from input against the network weights and
+// feature standardization until input is closed.
+func (a *Autoencoder) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	a.mu.RLock()
+	if !a.trained {
+		a.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := a.onReject
+	a.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, a.streamScore)
+}
+
+// streamScore scores a streamed sample against the network weights and
+// feature standardization.
+func (a *Autoencoder) streamScore(sample []float64) (detectors.Score, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if len(sample) != len(a.mean) {
+		return detectors.Score{}, a.dimensionError(sample)
+	}
+	errs := a.residuals([][]float64{sample}).RawRowView(0)
+	score := a.score(mean(errs))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= a.threshold,
+		Features:  sample,
+	}
+	if a.explainTop > 0 {
+		exp := a.explain(sample, errs)
+		result.Explanation = &exp
+	}
+	if a.severity != nil {
+		result.Severity = a.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*Autoencoder)(nil)
+	_ detectors.Thresholder    = (*Autoencoder)(nil)
+	_ detectors.RejectReporter = (*Autoencoder)(nil)
+	_ detectors.Explainer      = (*Autoencoder)(nil)
+	_ detectors.Describer      = (*Autoencoder)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the network weights and feature standardization to.
+func (a *Autoencoder) SetRejectHandler(fn detectors.RejectFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onReject = fn
+}
+
+// Reconstruct returns the network's reconstruction of sample: the values
+// it expected given the sample's other features. Features that are not
+// finite are reconstructed from the training mean.
+func (a *Autoencoder) Reconstruct(sample []float64) ([]float64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(sample) != len(a.mean) {
+		return nil, a.dimensionError(sample)
+	}
+	_, out := a.reconstruct([][]float64{sample})
+	rec := slices.Clone(out.RawRowView(0))
+	for j, v := range rec {
+		rec[j] = v*a.std[j] + a.mean[j]
+	}
+	return rec, nil
+}
+
+// FeatureImportances returns each feature's share of the reconstruction
+// error of the training data: the features the network models least
+// well. It returns nil if the detector is not trained.
+func (a *Autoencoder) FeatureImportances() []float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// its reconstruction error.
+func (a *Autoencoder) Explain(sample []float64) (detectors.Explanation, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(a.mean) {
+		return detectors.Explanation{}, a.dimensionError(sample)
+	}
+	return a.explain(sample, a.residuals([][]float64{sample}).RawRowView(0)), nil
+}
+
+// explain explains a sample of the right width from its reconstruction
+// errors. The caller holds the read lock.
+func (a *Autoencoder) explain(sample, errs []float64) detectors.Explanation {
+	score := a.score(mean(errs))
+	contributions := normalize(slices.Clone(errs))
+	topK := a.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, nil, topK),
+	}
+}
+
+// Loss returns the mean reconstruction error of the training data at each
+// epoch of the last Fit, in squared standard deviations, to check that
+// training converged. Loaded models have no history.
+func (a *Autoencoder) Loss() []float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.loss)
+}
+
+// Metadata returns the model card recorded by Fit.
+func (a *Autoencoder) Metadata() detectors.ModelCard {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	card := a.card
+	card.FeatureNames = slices.Clone(a.card.FeatureNames)
+	if a.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(a.card.Hyperparameters))
+		for k, v := range a.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (a *Autoencoder) modelCard(data [][]float64) detectors.ModelCard {
+	layers := make([]string, len(a.layers))
+	for i, w := range a.layers {
+		layers[i] = strconv.Itoa(w)
+	}
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   a.dataSource,
+		Rows:         len(data),
+		Features:     len(a.mean),
+		FeatureNames: slices.Clone(a.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "autoencoder",
+			"layers":        strings.Join(layers, ","),
+			"epochs":        strconv.Itoa(a.epochs),
+			"batch_size":    strconv.Itoa(a.batchSize),
+			"learning_rate": strconv.FormatFloat(a.learningRate, 'g', -1, 64),
+			"seed":          strconv.FormatInt(a.seed, 10),
+			"contamination": strconv.FormatFloat(a.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(a.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (a *Autoencoder) Trained() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (a *Autoencoder) Threshold() float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (a *Autoencoder) SetThreshold(t float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.threshold = t
+}
@@ -0,0 +1,148 @@
+package autoencoder
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// flowData returns n samples of four correlated features: packets, bytes
+// about 500 per packet, the duration growing with the packets, and an
+// independent port class.
+func flowData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		packets := 1 + rng.Float64()*99
+		data[i] = []float64{
+			packets,
+			packets * (500 + 20*rng.NormFloat64()),
+			packets/10 + 0.3*rng.NormFloat64(),
+			float64(rng.Intn(3)),
+		}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*Autoencoder, Option]{
+		Algorithm:        "autoencoder",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Options:          []Option{WithEpochs(5)},
+		Configured:       []Option{WithLayers(3, 2)},
+		Invalid:          []Option{WithLayers(4, 0), WithEpochs(0), WithBatchSize(0), WithLearningRate(0), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            flowData(1000, 6),
+		Probe:            append(flowData(20, 7), []float64{10, 45000, 1, 2}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	a := New(WithEpochs(30))
+	require.NoError(t, a.Fit(flowData(2000, 1)))
+
+	loss := a.Loss()
+	require.Len(t, loss, 30)
+	assert.Less(t, loss[29], loss[0]/2, "training converges")
+
+	scores, err := a.Predict([][]float64{
+		{50, 25000, 5, 1},
+		{10, 5000, 1, 0},
+		// Every value is common, but not together.
+		{90, 5000, 9, 1},
+		{10, 45000, 1, 2},
+		{50, 25000, math.NaN(), 1},
+	})
+	require.NoError(t, err)
+	assert.Less(t, scores[0], a.Threshold())
+	assert.Less(t, scores[1], a.Threshold())
+	for i, s := range scores[2:] {
+		assert.Greater(t, s, a.Threshold(), "sample %d", i+2)
+	}
+	assert.Equal(t, 1.0, scores[4], "features that are not finite cannot be reconstructed")
+
+	// Contamination sets the threshold to flag about 10% of training.
+	trainScores, err := a.Predict(flowData(2000, 2))
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range trainScores {
+		if s >= a.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 200, flagged, 60)
+
+	one, err := a.PredictOne([]float64{90, 5000, 9, 1})
+	require.NoError(t, err)
+	assert.InDelta(t, scores[2], one, 1e-12)
+}
+
+func TestDeterministic(t *testing.T) {
+	data := flowData(500, 3)
+	a, b := New(WithEpochs(5), WithSeed(7)), New(WithEpochs(5), WithSeed(7))
+	require.NoError(t, a.Fit(data))
+	require.NoError(t, b.Fit(data))
+	assert.Equal(t, a.Loss(), b.Loss())
+
+	c := New(WithEpochs(5), WithSeed(8))
+	require.NoError(t, c.Fit(data))
+	assert.NotEqual(t, a.Loss(), c.Loss())
+}
+
+func TestErrors(t *testing.T) {
+	a := New()
+	_, err := a.Reconstruct([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	require.NoError(t, New(WithEpochs(1)).Fit(flowData(10, 1)), "batches larger than the data")
+}
+
+func TestExplain(t *testing.T) {
+	a := New(WithEpochs(30))
+	require.NoError(t, a.Fit(flowData(1000, 5)))
+
+	// Bytes far too high for the packets.
+	sample := []float64{10, 45000, 1, 1}
+	exp, err := a.Explain(sample)
+	require.NoError(t, err)
+	score, err := a.PredictOne(sample)
+	require.NoError(t, err)
+	assert.InDelta(t, score, exp.Score, 1e-12)
+
+	rec, err := a.Reconstruct(sample)
+	require.NoError(t, err)
+	require.Len(t, rec, 4)
+	assert.Less(t, rec[1], 40000.0, "the reconstruction pulls bytes toward what the rest implies")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := flowData(10000, 1)
+	a := New(WithEpochs(10))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	a := New(WithEpochs(5))
+	a.Fit(flowData(5000, 1))
+	samples := flowData(10000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Predict(samples)
+	}
+}
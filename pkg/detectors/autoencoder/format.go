@@ -0,0 +1,162 @@
+package autoencoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved autoencoder models.
+var saveFormat = container.Format{Name: "autoencoder", Model: "autoencoder", Magic: "GGAESAVE", Version: 1}
+
+// savedModel holds the options and the network weights and feature
+// standardization of a saved autoencoder model.
+type savedModel struct {
+	Layers        []int
+	Epochs        int
+	BatchSize     int
+	LearningRate  float64
+	Seed          int64
+	Contamination float64
+	Threshold     float64
+	Scale         float64
+	Mean          []float64
+	Std           []float64
+	Network       []savedLayer
+	Importances   []float64
+	Card          container.Card
+}
+
+// savedLayer is the serialized form of layer, its weights in row-major
+// order.
+type savedLayer struct {
+	Inputs  int
+	Outputs int
+	Weights []float64
+	Bias    []float64
+}
+
+// Save serializes the network weights and feature standardization.
+func (a *Autoencoder) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := a.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (a *Autoencoder) SaveTo(w io.Writer) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Layers:        a.layers,
+		Epochs:        a.epochs,
+		BatchSize:     a.batchSize,
+		LearningRate:  a.learningRate,
+		Seed:          a.seed,
+		Contamination: a.contamination,
+		Threshold:     a.threshold,
+		Scale:         a.scale,
+		Mean:          a.mean,
+		Std:           a.std,
+		Network:       make([]savedLayer, len(a.net)),
+		Importances:   a.importances,
+		Card:          container.NewCard(a.card),
+	}
+	for l, ly := range a.net {
+		in, out := ly.weights.Dims()
+		m.Network[l] = savedLayer{Inputs: in, Outputs: out, Weights: ly.weights.RawMatrix().Data, Bias: ly.bias}
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the network weights and feature standardization written by
+// Save.
+func (a *Autoencoder) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	net, err := m.network()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.layers, a.epochs, a.batchSize = m.Layers, m.Epochs, m.BatchSize
+	a.learningRate, a.seed = m.LearningRate, m.Seed
+	a.contamination, a.threshold = m.Contamination, m.Threshold
+	a.scale = m.Scale
+	a.mean, a.std = m.Mean, m.Std
+	a.net = net
+	a.loss = nil
+	a.importances = m.Importances
+	a.card = m.Card.ModelCard()
+	a.featureNames = a.card.FeatureNames
+	a.trained = true
+	return nil
+}
+
+// LoadFrom restores the network weights and feature standardization read
+// from r.
+func (a *Autoencoder) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return a.Load(data)
+}
+
+// network validates and returns the saved network: layers chained from
+// and back to the features, with finite parameters.
+func (m *savedModel) network() (network, error) {
+	dim := len(m.Mean)
+	switch {
+	case dim == 0:
+		return nil, errors.New("autoencoder: model has no features")
+	case len(m.Std) != dim:
+		return nil, fmt.Errorf("autoencoder: %d deviations for %d features", len(m.Std), dim)
+	case len(m.Network) < 2:
+		return nil, errors.New("autoencoder: model has no encoder")
+	case !(m.Scale > 0) || math.IsInf(m.Scale, 0):
+		return nil, fmt.Errorf("autoencoder: invalid error scale %g", m.Scale)
+	}
+	for j, s := range m.Std {
+		if !(s > 0) || math.IsInf(s, 0) {
+			return nil, fmt.Errorf("autoencoder: feature %d: invalid deviation %g", j, s)
+		}
+	}
+
+	net := make(network, len(m.Network))
+	width := dim
+	for l, s := range m.Network {
+		if s.Inputs != width || s.Outputs < 1 || len(s.Weights) != s.Inputs*s.Outputs || len(s.Bias) != s.Outputs {
+			return nil, fmt.Errorf("autoencoder: layer %d: invalid shape", l)
+		}
+		for _, v := range append(s.Weights[:len(s.Weights):len(s.Weights)], s.Bias...) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("autoencoder: layer %d: non-finite parameter", l)
+			}
+		}
+		net[l] = layer{weights: mat.NewDense(s.Inputs, s.Outputs, s.Weights), bias: s.Bias, linear: l == len(m.Network)-1}
+		width = s.Outputs
+	}
+	if width != dim {
+		return nil, fmt.Errorf("autoencoder: network outputs %d features, expected %d", width, dim)
+	}
+	return net, nil
+}
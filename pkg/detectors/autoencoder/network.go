@@ -0,0 +1,163 @@
+package autoencoder
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// layer is a fully connected layer: out = act(in·weights + bias), with
+// tanh activations for hidden layers and none for the output layer.
+type layer struct {
+	weights *mat.Dense
+	bias    []float64
+	linear  bool
+}
+
+// network is the stack of encoder and decoder layers.
+type network []layer
+
+// newNetwork returns a network mapping dim features through the encoder
+// widths and back, its weights drawn with Glorot initialization.
+func newNetwork(dim int, encoder []int, rng *rand.Rand) network {
+	widths := []int{dim}
+	widths = append(widths, encoder...)
+	for i := len(encoder) - 2; i >= 0; i-- {
+		widths = append(widths, encoder[i])
+	}
+	widths = append(widths, dim)
+
+	net := make(network, len(widths)-1)
+	for l := range net {
+		in, out := widths[l], widths[l+1]
+		limit := math.Sqrt(6 / float64(in+out))
+		w := make([]float64, in*out)
+		for i := range w {
+			w[i] = (2*rng.Float64() - 1) * limit
+		}
+		net[l] = layer{weights: mat.NewDense(in, out, w), bias: make([]float64, out), linear: l == len(net)-1}
+	}
+	return net
+}
+
+// forward returns the activations of every layer for the rows of x, the
+// last being the reconstruction.
+func (net network) forward(x *mat.Dense) []*mat.Dense {
+	acts := make([]*mat.Dense, len(net))
+	in := x
+	for l, ly := range net {
+		var out mat.Dense
+		out.Mul(in, ly.weights)
+		rows, _ := out.Dims()
+		for i := 0; i < rows; i++ {
+			row := out.RawRowView(i)
+			for j := range row {
+				row[j] += ly.bias[j]
+				if !ly.linear {
+					row[j] = math.Tanh(row[j])
+				}
+			}
+		}
+		acts[l] = &out
+		in = &out
+	}
+	return acts
+}
+
+// adam holds the moment estimates of the Adam optimizer for every
+// parameter of a network, laid out like the network.
+type adam struct {
+	rate         float64
+	beta1, beta2 float64
+	epsilon      float64
+	step         int
+
+	mw, vw []*mat.Dense
+	mb, vb [][]float64
+	// gradW and gradB hold the gradients of the current step.
+	gradW []*mat.Dense
+	gradB [][]float64
+}
+
+func newAdam(net network, rate float64) *adam {
+	a := &adam{rate: rate, beta1: 0.9, beta2: 0.999, epsilon: 1e-8}
+	for _, ly := range net {
+		r, c := ly.weights.Dims()
+		a.mw = append(a.mw, mat.NewDense(r, c, nil))
+		a.vw = append(a.vw, mat.NewDense(r, c, nil))
+		a.gradW = append(a.gradW, mat.NewDense(r, c, nil))
+		a.mb = append(a.mb, make([]float64, c))
+		a.vb = append(a.vb, make([]float64, c))
+		a.gradB = append(a.gradB, make([]float64, c))
+	}
+	return a
+}
+
+// train takes one optimization step on the mean squared reconstruction
+// error of the rows of x and returns that error before the step.
+func (a *adam) train(net network, x *mat.Dense) float64 {
+	acts := net.forward(x)
+	rows, dim := x.Dims()
+
+	// Gradient of the loss with respect to the output.
+	delta := new(mat.Dense)
+	delta.Sub(acts[len(acts)-1], x)
+	var loss float64
+	for i := 0; i < rows; i++ {
+		for _, v := range delta.RawRowView(i) {
+			loss += v * v
+		}
+	}
+	delta.Scale(2/float64(rows*dim), delta)
+
+	for l := len(net) - 1; l >= 0; l-- {
+		ly := net[l]
+		if !ly.linear {
+			// tanh'(z) = 1 - tanh(z)^2
+			out := acts[l]
+			for i := 0; i < rows; i++ {
+				d, o := delta.RawRowView(i), out.RawRowView(i)
+				for j := range d {
+					d[j] *= 1 - o[j]*o[j]
+				}
+			}
+		}
+		in := x
+		if l > 0 {
+			in = acts[l-1]
+		}
+		a.gradW[l].Mul(in.T(), delta)
+		gb := a.gradB[l]
+		clear(gb)
+		for i := 0; i < rows; i++ {
+			for j, v := range delta.RawRowView(i) {
+				gb[j] += v
+			}
+		}
+		if l > 0 {
+			next := new(mat.Dense)
+			next.Mul(delta, ly.weights.T())
+			delta = next
+		}
+	}
+
+	a.step++
+	c1 := 1 - math.Pow(a.beta1, float64(a.step))
+	c2 := 1 - math.Pow(a.beta2, float64(a.step))
+	for l, ly := range net {
+		update(ly.weights.RawMatrix().Data, a.gradW[l].RawMatrix().Data, a.mw[l].RawMatrix().Data, a.vw[l].RawMatrix().Data, a, c1, c2)
+		update(ly.bias, a.gradB[l], a.mb[l], a.vb[l], a, c1, c2)
+	}
+	return loss / float64(rows*dim)
+}
+
+// update applies an Adam step to params given their gradients and moment
+// estimates, with c1 and c2 the bias corrections of the step.
+func update(params, grad, m, v []float64, a *adam, c1, c2 float64) {
+	for i, g := range grad {
+		m[i] = a.beta1*m[i] + (1-a.beta1)*g
+		v[i] = a.beta2*v[i] + (1-a.beta2)*g*g
+		params[i] -= a.rate * (m[i] / c1) / (math.Sqrt(v[i]/c2) + a.epsilon)
+	}
+}
@@ -0,0 +1,584 @@
+// Package copod implements COPOD, the Copula-Based Outlier Detector of Li
+// et al. (2020).
+//
+// COPOD models the joint distribution of the features with an empirical
+// copula: each feature's training values give its empirical cumulative
+// distribution, and a sample's value of the feature its tail probability,
+// the chance that a training value is at least as extreme. A sample scores
+// by the sum over features of the negative log of its tail probabilities,
+// taken on the left, on the right and on the side the feature is skewed
+// toward. The detector has no hyperparameters, is deterministic, trains
+// with one sort per feature and scores with one binary search per feature.
+//
+// The per-feature tail probabilities explain each score directly: a
+// feature with a tail probability of 0.001 is more extreme than all but
+// 0.1% of training. Explain reports their share of the score, and
+// WithTailProbabilities adds them to the Metadata of streamed scores.
+package copod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// contamination.
+var ErrInvalidOption = fmt.Errorf("copod: %w", detectors.ErrInvalidOption)
+
+// MetadataKey is the Score.Metadata key of the per-feature tail
+// probabilities added by WithTailProbabilities, a []float64.
+const MetadataKey = "tail_probabilities"
+
+// scoreChunk is the number of samples scored between context checks.
+const scoreChunk = 4096
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// COPOD is a copula-based outlier detector. It is safe for concurrent use.
+type COPOD struct {
+	mu sync.RWMutex
+
+	// Configuration
+	contamination float64
+	threshold     float64
+	workers       int
+	explainTop    int
+	withTails     bool
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	sorted [][]float64
+	// leftSkewed holds the features whose training values are skewed to
+	// the left, whose skewness-corrected tail is the left one.
+	leftSkewed []bool
+	// scale is the mean raw score of the training data, which scores 0.5.
+	scale       float64
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures a COPOD.
+type Option func(*COPOD)
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(c2 *COPOD) {
+		c2.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(c *COPOD) {
+		c.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(c *COPOD) {
+		c.explainTop = k
+	}
+}
+
+// WithTailProbabilities makes PredictStream add each feature's tail
+// probability to the Metadata of every score, under MetadataKey.
+func WithTailProbabilities() Option {
+	return func(c *COPOD) {
+		c.withTails = true
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(c *COPOD) {
+		c.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new COPOD.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(c *COPOD) {
+		c.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(c *COPOD) {
+		c.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(c *COPOD) {
+		c.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained COPOD with the given options.
+func New(opts ...Option) *COPOD {
+	c := &COPOD{
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.workers = max(c.workers, 0)
+	c.explainTop = max(c.explainTop, 0)
+	return c
+}
+
+// Validate checks the contamination set by the options.
+func (c *COPOD) Validate() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.validate()
+}
+
+func (c *COPOD) validate() error {
+	var errs []error
+	if !(c.contamination >= 0 && c.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, c.contamination))
+	}
+	if c.severity != nil {
+		if err := c.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit builds the empirical distribution of each feature of data. Features
+// must be finite.
+func (c *COPOD) Fit(data [][]float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if c.featureNames != nil && len(c.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(c.featureNames), nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	workers := detectors.Workers(c.workers)
+	sorted := make([][]float64, nFeatures)
+	leftSkewed := make([]bool, nFeatures)
+	detectors.ParallelFor(nFeatures, workers, 1, func(lo, hi int) {
+		for j := lo; j < hi; j++ {
+			column := make([]float64, len(data))
+			for i, row := range data {
+				column[i] = row[j]
+			}
+			slices.Sort(column)
+			sorted[j] = column
+			leftSkewed[j] = skewness(column) < 0
+		}
+	})
+	c.sorted = sorted
+	c.leftSkewed = leftSkewed
+	c.scale = 1
+	c.trained = true
+
+	// Score the training data once, for the score scale, the threshold and
+	// the feature importances.
+	raw := make([]float64, len(data))
+	importances := make([]float64, nFeatures)
+	var mu sync.Mutex
+	detectors.ParallelFor(len(data), workers, scoreChunk, func(lo, hi int) {
+		dims := make([]float64, nFeatures)
+		shares := make([]float64, nFeatures)
+		for i := lo; i < hi; i++ {
+			raw[i] = c.raw(data[i], dims, nil)
+			for j, d := range dims {
+				shares[j] += d
+			}
+		}
+		mu.Lock()
+		for j, s := range shares {
+			importances[j] += s
+		}
+		mu.Unlock()
+	})
+	var sum float64
+	for _, r := range raw {
+		sum += r
+	}
+	if mean := sum / float64(len(raw)); mean > 0 {
+		c.scale = mean
+	}
+	c.importances = normalize(importances)
+
+	if c.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(raw), 100)
+		for _, r := range raw {
+			est.Add(c.score(r))
+		}
+		c.threshold = est.Quantile(1 - c.contamination)
+	}
+	c.card = c.modelCard(data)
+	return nil
+}
+
+// skewness returns the sample skewness of values.
+func skewness(values []float64) float64 {
+	n := float64(len(values))
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= n
+	var m2, m3 float64
+	for _, v := range values {
+		d := v - mean
+		m2 += d * d
+		m3 += d * d * d
+	}
+	m2, m3 = m2/n, m3/n
+	if m2 == 0 {
+		return 0
+	}
+	return m3 / math.Pow(m2, 1.5)
+}
+
+// tails returns the left and right tail probabilities of value v of
+// feature j: the share of training values at most and at least v, counting
+// v itself as one more, so both lie in (0, 1]. Values that are not finite
+// are beyond every training value on both sides. The caller holds the read
+// lock.
+func (c *COPOD) tails(j int, v float64) (left, right float64) {
+	sorted := c.sorted[j]
+	n := float64(len(sorted) + 1)
+	if math.IsNaN(v) {
+		return 1 / n, 1 / n
+	}
+	atMost := sort.Search(len(sorted), func(i int) bool { return sorted[i] > v })
+	atLeast := len(sorted) - sort.SearchFloat64s(sorted, v)
+	return float64(atMost+1) / n, float64(atLeast+1) / n
+}
+
+// raw returns the raw score of sample, writing each feature's score to
+// dims and, if probs is not nil, its tail probability to probs. The caller
+// holds the read lock.
+func (c *COPOD) raw(sample, dims, probs []float64) float64 {
+	var sumLeft, sumRight, sumSkew float64
+	for j, v := range sample {
+		left, right := c.tails(j, v)
+		l, r := -math.Log(left), -math.Log(right)
+		skew := r
+		if c.leftSkewed[j] {
+			skew = l
+		}
+		sumLeft += l
+		sumRight += r
+		sumSkew += skew
+		dims[j] = max(l, r)
+		if probs != nil {
+			probs[j] = min(left, right)
+		}
+	}
+	return max((sumLeft+sumRight)/2, sumSkew)
+}
+
+// score maps a raw score to [0, 1): 0.5 for the mean training sample,
+// rising toward 1 as samples get more extreme.
+func (c *COPOD) score(raw float64) float64 {
+	return 1 - math.Exp2(-raw/c.scale)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values.
+func normalize(values []float64) []float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if sum > 0 {
+		for i := range values {
+			values[i] /= sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (c *COPOD) Predict(data [][]float64) ([]float64, error) {
+	return c.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every few thousand
+// samples.
+func (c *COPOD) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(c.sorted) {
+			return nil, fmt.Errorf("sample %d: %w", i, c.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(c.workers), scoreChunk, func(lo, hi int) {
+		dims := make([]float64, len(c.sorted))
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			scores[i] = c.score(c.raw(data[i], dims, nil))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (c *COPOD) PredictOne(sample []float64) (float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(c.sorted) {
+		return 0, c.dimensionError(sample)
+	}
+	return c.score(c.raw(sample, make([]float64, len(sample)), nil)), nil
+}
+
+// TailProbabilities returns each feature's tail probability for sample:
+// the share of training values at least as extreme on the nearer side.
+func (c *COPOD) TailProbabilities(sample []float64) ([]float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(sample) != len(c.sorted) {
+		return nil, c.dimensionError(sample)
+	}
+	probs := make([]float64, len(sample))
+	c.raw(sample, make([]float64, len(sample)), probs)
+	return probs, nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (c *COPOD) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(c.sorted)}
+}
+
+// PredictStream scores samples from input against the sorted training
+// values of each feature until input is closed.
+func (c *COPOD) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	c.mu.RLock()
+	if !c.trained {
+		c.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := c.onReject
+	c.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, c.streamScore)
+}
+
+// streamScore scores a streamed sample against the sorted training values
+// of each feature.
+func (c *COPOD) streamScore(sample []float64) (detectors.Score, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(sample) != len(c.sorted) {
+		return detectors.Score{}, c.dimensionError(sample)
+	}
+	dims := make([]float64, len(sample))
+	var probs []float64
+	if c.withTails {
+		probs = make([]float64, len(sample))
+	}
+	score := c.score(c.raw(sample, dims, probs))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= c.threshold,
+		Features:  sample,
+	}
+	if c.withTails {
+		result.Metadata = map[string]any{MetadataKey: probs}
+	}
+	if c.explainTop > 0 {
+		exp := c.explain(sample, score, dims)
+		result.Explanation = &exp
+	}
+	if c.severity != nil {
+		result.Severity = c.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*COPOD)(nil)
+	_ detectors.Thresholder    = (*COPOD)(nil)
+	_ detectors.RejectReporter = (*COPOD)(nil)
+	_ detectors.Explainer      = (*COPOD)(nil)
+	_ detectors.Describer      = (*COPOD)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the sorted training values of each feature to.
+func (c *COPOD) SetRejectHandler(fn detectors.RejectFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReject = fn
+}
+
+// FeatureImportances returns each feature's share of the per-feature
+// scores of the training data. It returns nil if the detector is not
+// trained.
+func (c *COPOD) FeatureImportances() []float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// the negative log tail probabilities. The typical range of each feature
+// is the central 95% of its training values.
+func (c *COPOD) Explain(sample []float64) (detectors.Explanation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(c.sorted) {
+		return detectors.Explanation{}, c.dimensionError(sample)
+	}
+	dims := make([]float64, len(sample))
+	score := c.score(c.raw(sample, dims, nil))
+	return c.explain(sample, score, dims), nil
+}
+
+// explain explains a sample of the right width from its score and
+// per-feature scores. The caller holds the read lock.
+func (c *COPOD) explain(sample []float64, score float64, dims []float64) detectors.Explanation {
+	contributions := normalize(slices.Clone(dims))
+	typical := make([]detectors.Range, len(sample))
+	for j, sorted := range c.sorted {
+		last := len(sorted) - 1
+		typical[j] = detectors.Range{Low: sorted[last*25/1000], High: sorted[last*975/1000]}
+	}
+	topK := c.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, typical, topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (c *COPOD) Metadata() detectors.ModelCard {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	card := c.card
+	card.FeatureNames = slices.Clone(c.card.FeatureNames)
+	if c.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(c.card.Hyperparameters))
+		for k, v := range c.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (c *COPOD) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   c.dataSource,
+		Rows:         len(data),
+		Features:     len(c.sorted),
+		FeatureNames: slices.Clone(c.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "copod",
+			"contamination": strconv.FormatFloat(c.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(c.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (c *COPOD) Trained() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (c *COPOD) Threshold() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (c *COPOD) SetThreshold(t float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.threshold = t
+}
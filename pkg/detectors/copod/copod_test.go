@@ -0,0 +1,144 @@
+package copod
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// skewedData returns n samples of three features: a standard normal, a
+// right-skewed exponential and a left-skewed negated exponential.
+func skewedData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), rng.ExpFloat64(), -rng.ExpFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*COPOD, Option]{
+		Algorithm:        "copod",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithTailProbabilities()},
+		Invalid:          []Option{WithContamination(1), WithSeverityBands(detectors.SeverityBands{Medium: 2})},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            skewedData(1000, 6),
+		Probe:            append(skewedData(20, 7), []float64{0, 12, -0.7}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	c := New()
+	require.NoError(t, c.Fit(skewedData(2000, 1)))
+
+	scores, err := c.Predict([][]float64{
+		{0, 0.7, -0.7},
+		{5, 3, -0.7},
+		{0, 12, -0.7},
+		{0, 0.7, -12},
+		{math.NaN(), 0.7, -0.7},
+	})
+	require.NoError(t, err)
+	for _, s := range scores {
+		assert.GreaterOrEqual(t, s, 0.0)
+		assert.Less(t, s, 1.0)
+	}
+	assert.Less(t, scores[0], c.Threshold(), "a typical sample is normal")
+	for i, s := range scores[1:] {
+		assert.Greater(t, s, c.Threshold(), "sample %d", i+1)
+	}
+
+	// Contamination sets the threshold to flag about 10% of training.
+	trainScores, err := c.Predict(skewedData(2000, 2))
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range trainScores {
+		if s >= c.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 200, flagged, 40)
+
+	one, err := c.PredictOne([]float64{5, 3, -0.7})
+	require.NoError(t, err)
+	assert.Equal(t, scores[1], one)
+
+	// COPOD has no randomness: refitting with any number of workers
+	// reproduces every score.
+	again := New(WithWorkers(1))
+	require.NoError(t, again.Fit(skewedData(2000, 1)))
+	assert.Equal(t, c.Threshold(), again.Threshold())
+	repeated, err := again.Predict(skewedData(2000, 2))
+	require.NoError(t, err)
+	assert.Equal(t, trainScores, repeated)
+}
+
+func TestTailProbabilities(t *testing.T) {
+	c := New()
+	require.NoError(t, c.Fit(skewedData(999, 3)))
+	assert.False(t, c.leftSkewed[1])
+	assert.True(t, c.leftSkewed[2])
+
+	probs, err := c.TailProbabilities([]float64{100, -1, math.NaN()})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.001, 0.001, 0.001}, probs, "values beyond every training value")
+
+	probs, err = c.TailProbabilities([]float64{0, 0.7, -0.7})
+	require.NoError(t, err)
+	for j, p := range probs {
+		assert.Greater(t, p, 0.2, "feature %d", j)
+		assert.LessOrEqual(t, p, 0.5+1e-3, "feature %d", j)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := New().TailProbabilities([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+}
+
+func TestExplain(t *testing.T) {
+	c := New()
+	require.NoError(t, c.Fit(skewedData(1000, 4)))
+
+	sample := []float64{0, 9, -0.7}
+	exp, err := c.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Less(t, exp.Top[0].Typical.High, 9.0, "the typical range excludes the odd value")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := skewedData(100000, 1)
+	c := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	c := New()
+	c.Fit(skewedData(100000, 1))
+	samples := skewedData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Predict(samples)
+	}
+}
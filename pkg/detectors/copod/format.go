@@ -0,0 +1,109 @@
+package copod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved COPOD models.
+var saveFormat = container.Format{Name: "copod", Model: "COPOD", Magic: "GGCPSAVE", Version: 1}
+
+// savedModel holds the options and the sorted training values of each
+// feature of a saved COPOD model.
+type savedModel struct {
+	Contamination float64
+	Threshold     float64
+	Scale         float64
+	// Sorted holds the sorted training values of each feature.
+	Sorted      [][]float64
+	LeftSkewed  []bool
+	Importances []float64
+	Card        container.Card
+}
+
+// Save serializes the sorted training values of each feature.
+func (c *COPOD) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (c *COPOD) SaveTo(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Contamination: c.contamination,
+		Threshold:     c.threshold,
+		Scale:         c.scale,
+		Sorted:        c.sorted,
+		LeftSkewed:    c.leftSkewed,
+		Importances:   c.importances,
+		Card:          container.NewCard(c.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the sorted training values of each feature written by Save.
+func (c *COPOD) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contamination, c.threshold = m.Contamination, m.Threshold
+	c.scale = m.Scale
+	c.sorted, c.leftSkewed = m.Sorted, m.LeftSkewed
+	c.importances = m.Importances
+	c.card = m.Card.ModelCard()
+	c.featureNames = c.card.FeatureNames
+	c.trained = true
+	return nil
+}
+
+// LoadFrom restores the sorted training values of each feature read from r.
+func (c *COPOD) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.Load(data)
+}
+
+// validate checks the saved distributions.
+func (m *savedModel) validate() error {
+	if len(m.Sorted) == 0 {
+		return errors.New("copod: model has no features")
+	}
+	if len(m.LeftSkewed) != len(m.Sorted) {
+		return fmt.Errorf("copod: %d skew flags for %d features", len(m.LeftSkewed), len(m.Sorted))
+	}
+	if !(m.Scale > 0) || math.IsInf(m.Scale, 0) {
+		return fmt.Errorf("copod: invalid score scale %g", m.Scale)
+	}
+	for j, sorted := range m.Sorted {
+		if len(sorted) == 0 || !slices.IsSorted(sorted) {
+			return fmt.Errorf("copod: feature %d: invalid distribution", j)
+		}
+	}
+	return nil
+}
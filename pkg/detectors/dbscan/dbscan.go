@@ -0,0 +1,741 @@
+// Package dbscan implements a DBSCAN clustering-based anomaly detector.
+//
+// Fit clusters the training data with DBSCAN: points with at least
+// MinPoints training points, themselves included, within eps are core
+// points, and core points within eps of each other share a cluster.
+// Training points within eps of a core point join its cluster; the rest
+// are noise. A sample scores by its distance to the nearest core point,
+// d/(d+eps): 0 on a core point and 0.5, the default threshold, at eps,
+// so that a sample is flagged exactly when DBSCAN would call it noise.
+// Cluster tells which cluster a sample falls in.
+//
+// Most training points are core points and score 0, so there is no
+// contamination to calibrate the threshold with: eps and MinPoints decide
+// what is noise. SetThreshold moves the boundary instead: t flags samples
+// beyond eps·t/(1-t), so 1/3 flags those beyond half of eps.
+//
+// The detector suits data whose normal behavior forms distinct groups,
+// such as traffic of several protocols, where a sample between groups is
+// as odd as one far from all of them. Features are standardized by their
+// training mean and standard deviation first, and eps is measured in
+// standard deviations; by default it is chosen from the data. Core points
+// are indexed with a KD-tree, which degrades toward a linear scan as the
+// number of features grows past about 20.
+package dbscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/kdtree"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// eps or minimum points.
+var ErrInvalidOption = fmt.Errorf("dbscan: %w", detectors.ErrInvalidOption)
+
+// Noise is the cluster of samples farther than eps from every core point.
+const Noise = -1
+
+// scoreChunk is the number of samples scored between context checks.
+const scoreChunk = 1024
+
+// leafSize is the number of core points below which the index stops
+// splitting.
+const leafSize = 16
+
+// autoEpsQuantile is the quantile of the training points' distances to
+// their MinPoints-th nearest point that eps defaults to: about 95% of the
+// training data ends up core points.
+const autoEpsQuantile = 0.95
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// DBSCAN is a clustering-based detector. It is safe for concurrent use.
+type DBSCAN struct {
+	mu sync.RWMutex
+
+	// Configuration
+	eps          float64
+	minPoints    int
+	threshold    float64
+	workers      int
+	explainTop   int
+	severity     *detectors.SeverityBands
+	onReject     detectors.RejectFunc
+	dataSource   string
+	featureNames []string
+
+	// Trained model
+	mean []float64
+	std  []float64
+	// radius is eps, chosen by Fit if it was not set.
+	radius float64
+	// core indexes the standardized core points; labels holds the cluster
+	// of each.
+	core   *kdtree.Tree
+	labels []int
+	// sizes is the number of training points in each cluster, and ranges
+	// the range of each feature over its core points.
+	sizes       []int
+	ranges      [][]detectors.Range
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures a DBSCAN.
+type Option func(*DBSCAN)
+
+// WithEps sets the neighborhood radius, in standard deviations of the
+// standardized features. 0, the default, makes Fit choose it from the
+// training data: the 95th percentile of the distances of training points
+// to their MinPoints-th nearest point, themselves included.
+func WithEps(eps float64) Option {
+	return func(d *DBSCAN) {
+		d.eps = eps
+	}
+}
+
+// WithMinPoints sets the number of training points, itself included, a
+// point needs within eps to be a core point, 5 by default. Larger values
+// need denser clusters and leave more of the training data noise.
+func WithMinPoints(n int) Option {
+	return func(d *DBSCAN) {
+		d.minPoints = n
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(d *DBSCAN) {
+		d.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(d *DBSCAN) {
+		d.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(d *DBSCAN) {
+		d.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new DBSCAN.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(d *DBSCAN) {
+		d.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(d *DBSCAN) {
+		d.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(d *DBSCAN) {
+		d.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained DBSCAN with the given options.
+func New(opts ...Option) *DBSCAN {
+	d := &DBSCAN{
+		minPoints: 5,
+		threshold: 0.5,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.workers = max(d.workers, 0)
+	d.explainTop = max(d.explainTop, 0)
+	return d
+}
+
+// Validate checks the eps and minimum points set by the options.
+func (d *DBSCAN) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validate()
+}
+
+func (d *DBSCAN) validate() error {
+	var errs []error
+	if !(d.eps >= 0) || math.IsInf(d.eps, 0) {
+		errs = append(errs, fmt.Errorf("%w: WithEps(%g): must be positive, or 0 to choose from the data", ErrInvalidOption, d.eps))
+	}
+	if d.minPoints < 2 {
+		errs = append(errs, fmt.Errorf("%w: WithMinPoints(%d): need at least 2", ErrInvalidOption, d.minPoints))
+	}
+	if d.severity != nil {
+		if err := d.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit standardizes and clusters data, keeping its core points. Features
+// must be finite, there must be at least WithMinPoints rows, and at least
+// one of them must be a core point.
+func (d *DBSCAN) Fit(data [][]float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if d.featureNames != nil && len(d.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(d.featureNames), nFeatures)
+	}
+	if len(data) < d.minPoints {
+		return fmt.Errorf("%d training rows for clusters of %d points: need at least as many rows", len(data), d.minPoints)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	mean, std := standardization(data)
+	points := make([][]float64, len(data))
+	for i, row := range data {
+		points[i] = standardize(row, mean, std, make([]float64, nFeatures))
+	}
+	all := kdtree.Build(points, nFeatures, leafSize)
+	workers := detectors.Workers(d.workers)
+
+	radius := d.eps
+	if radius == 0 {
+		radius = autoEps(all, points, d.minPoints, workers)
+		if !(radius > 0) {
+			return fmt.Errorf("most training points coincide with %d others: set WithEps", d.minPoints-1)
+		}
+	}
+
+	// Core points have MinPoints points within eps, themselves included.
+	r2 := radius * radius
+	isCore := make([]bool, len(points))
+	detectors.ParallelFor(len(points), workers, scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		for i := lo; i < hi; i++ {
+			all.Within(points[i], r2, i, &h)
+			isCore[i] = len(h)+1 >= d.minPoints
+		}
+	})
+
+	// Core points within eps of each other share a cluster.
+	parent := make([]int, len(points))
+	for i := range parent {
+		parent[i] = i
+	}
+	var h kdtree.Neighbors
+	var corePoints [][]float64
+	for i, p := range points {
+		if !isCore[i] {
+			continue
+		}
+		corePoints = append(corePoints, p)
+		all.Within(p, r2, i, &h)
+		for _, n := range h {
+			if isCore[n.Row] {
+				union(parent, i, n.Row)
+			}
+		}
+	}
+	if len(corePoints) == 0 {
+		return fmt.Errorf("no training point has %d points within eps %g: raise WithEps or lower WithMinPoints", d.minPoints, radius)
+	}
+
+	// Number clusters in order of their first core point.
+	cluster := make(map[int]int)
+	labels := make([]int, 0, len(corePoints))
+	for i := range points {
+		if !isCore[i] {
+			continue
+		}
+		root := find(parent, i)
+		c, ok := cluster[root]
+		if !ok {
+			c = len(cluster)
+			cluster[root] = c
+		}
+		labels = append(labels, c)
+	}
+
+	d.mean, d.std = mean, std
+	d.radius = radius
+	d.core = kdtree.Build(corePoints, nFeatures, leafSize)
+	d.labels = labels
+	d.ranges = d.clusterRanges(len(cluster))
+	d.trained = true
+
+	// Measure the training data once, for the cluster sizes and the
+	// feature importances. Border points join the cluster of their nearest
+	// core point.
+	nearest := make([]int, len(points))
+	member := make([]bool, len(points))
+	importances := make([]float64, nFeatures)
+	var mu sync.Mutex
+	detectors.ParallelFor(len(points), workers, scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		shares := make([]float64, nFeatures)
+		for i := lo; i < hi; i++ {
+			d.core.Search(points[i], 1, -1, &h)
+			nearest[i], member[i] = h[0].Row, h[0].Dist <= r2
+			d.addShares(points[i], h[0].Row, shares)
+		}
+		mu.Lock()
+		for j, s := range shares {
+			importances[j] += s
+		}
+		mu.Unlock()
+	})
+	d.sizes = make([]int, len(cluster))
+	for i, row := range nearest {
+		if member[i] {
+			d.sizes[labels[row]]++
+		}
+	}
+	d.importances = normalize(importances)
+
+	d.card = d.modelCard(data)
+	return nil
+}
+
+// autoEps returns the autoEpsQuantile of the distances of points, indexed
+// by t, to their minPoints-th nearest point, themselves included.
+func autoEps(t *kdtree.Tree, points [][]float64, minPoints, workers int) float64 {
+	dist := make([]float64, len(points))
+	detectors.ParallelFor(len(points), workers, scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		for i := lo; i < hi; i++ {
+			t.Search(points[i], minPoints-1, i, &h)
+			// The heap holds the farthest neighbor first.
+			dist[i] = math.Sqrt(h[0].Dist)
+		}
+	})
+	slices.Sort(dist)
+	return dist[int(autoEpsQuantile*float64(len(dist)-1))]
+}
+
+// find returns the root of the set of i, halving paths on the way.
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// union merges the sets of i and j.
+func union(parent []int, i, j int) {
+	if a, b := find(parent, i), find(parent, j); a != b {
+		parent[max(a, b)] = min(a, b)
+	}
+}
+
+// clusterRanges returns the range of each feature over the core points of
+// each of n clusters. The caller holds the write lock.
+func (d *DBSCAN) clusterRanges(n int) [][]detectors.Range {
+	ranges := make([][]detectors.Range, n)
+	for c := range ranges {
+		ranges[c] = make([]detectors.Range, len(d.mean))
+		for j := range ranges[c] {
+			ranges[c][j] = detectors.Range{Low: math.Inf(1), High: math.Inf(-1)}
+		}
+	}
+	for row, c := range d.labels {
+		for j, v := range d.unstandardize(d.core.Point(row)) {
+			ranges[c][j].Low = min(ranges[c][j].Low, v)
+			ranges[c][j].High = max(ranges[c][j].High, v)
+		}
+	}
+	return ranges
+}
+
+// standardization returns the mean and standard deviation of each feature
+// of data. Constant features get a deviation of 1.
+func standardization(data [][]float64) (mean, std []float64) {
+	n := len(data[0])
+	mean, std = make([]float64, n), make([]float64, n)
+	column := make([]float64, len(data))
+	for j := range n {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		mean[j], std[j] = stats.MeanStd(column)
+		if !(std[j] > 0) {
+			std[j] = 1
+		}
+	}
+	return mean, std
+}
+
+// standardize writes sample, standardized by mean and std, to dst and
+// returns it. NaN values are placed infinitely far from every training
+// point.
+func standardize(sample, mean, std, dst []float64) []float64 {
+	for j, v := range sample {
+		if math.IsNaN(v) {
+			v = math.Inf(1)
+		}
+		dst[j] = stats.Standardize(v, mean[j], std[j])
+	}
+	return dst
+}
+
+// unstandardize returns the features of standardized point p.
+func (d *DBSCAN) unstandardize(p []float64) []float64 {
+	out := make([]float64, len(p))
+	for j, v := range p {
+		out[j] = v*d.std[j] + d.mean[j]
+	}
+	return out
+}
+
+// addShares adds to shares each feature's part of the squared distance of
+// q to core point row. The caller holds the read lock.
+func (d *DBSCAN) addShares(q []float64, row int, shares []float64) {
+	point := d.core.Point(row)
+	for j, v := range q {
+		diff := v - point[j]
+		shares[j] += diff * diff
+	}
+}
+
+// score maps the squared distance to the nearest core point to d/(d+eps):
+// 0 on a core point, 0.5 at eps, rising toward 1 beyond.
+func (d *DBSCAN) score(dist2 float64) float64 {
+	if math.IsInf(dist2, 1) {
+		return 1
+	}
+	dist := math.Sqrt(dist2)
+	return dist / (dist + d.radius)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (d *DBSCAN) Predict(data [][]float64) ([]float64, error) {
+	return d.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (d *DBSCAN) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(d.mean) {
+			return nil, fmt.Errorf("sample %d: %w", i, d.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		q := make([]float64, len(d.mean))
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			d.core.Search(standardize(data[i], d.mean, d.std, q), 1, -1, &h)
+			scores[i] = d.score(h[0].Dist)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (d *DBSCAN) PredictOne(sample []float64) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return 0, d.dimensionError(sample)
+	}
+	_, n := d.query(sample)
+	return d.score(n.Dist), nil
+}
+
+// query returns the standardized sample and its nearest core point. The
+// caller holds the read lock.
+func (d *DBSCAN) query(sample []float64) ([]float64, kdtree.Neighbor) {
+	q := standardize(sample, d.mean, d.std, make([]float64, len(sample)))
+	h := make(kdtree.Neighbors, 0, 1)
+	d.core.Search(q, 1, -1, &h)
+	return q, h[0]
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (d *DBSCAN) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(d.mean)}
+}
+
+// Cluster returns the training cluster sample falls in: that of its
+// nearest core point if it lies within eps of it, Noise otherwise.
+// Clusters are numbered from 0 in the order of the training data.
+func (d *DBSCAN) Cluster(sample []float64) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return 0, d.dimensionError(sample)
+	}
+	_, n := d.query(sample)
+	if !(n.Dist <= d.radius*d.radius) {
+		return Noise, nil
+	}
+	return d.labels[n.Row], nil
+}
+
+// Clusters returns the number of training points in each cluster, border
+// points included and noise left out, or nil if the detector is not
+// trained.
+func (d *DBSCAN) Clusters() []int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.sizes)
+}
+
+// Eps returns the neighborhood radius the detector was trained with, in
+// standard deviations: that of WithEps, or the one Fit chose. It returns
+// 0 if the detector is not trained.
+func (d *DBSCAN) Eps() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.radius
+}
+
+// PredictStream scores samples from input against the standardized core
+// points and their clusters until input is closed.
+func (d *DBSCAN) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	d.mu.RLock()
+	if !d.trained {
+		d.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := d.onReject
+	d.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, d.streamScore)
+}
+
+// streamScore scores a streamed sample against the standardized core points
+// and their clusters.
+func (d *DBSCAN) streamScore(sample []float64) (detectors.Score, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(sample) != len(d.mean) {
+		return detectors.Score{}, d.dimensionError(sample)
+	}
+	q, n := d.query(sample)
+	score := d.score(n.Dist)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= d.threshold,
+		Features:  sample,
+	}
+	if d.explainTop > 0 {
+		exp := d.explain(sample, q, n)
+		result.Explanation = &exp
+	}
+	if d.severity != nil {
+		result.Severity = d.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*DBSCAN)(nil)
+	_ detectors.Thresholder    = (*DBSCAN)(nil)
+	_ detectors.RejectReporter = (*DBSCAN)(nil)
+	_ detectors.Explainer      = (*DBSCAN)(nil)
+	_ detectors.Describer      = (*DBSCAN)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the standardized core points and their clusters to.
+func (d *DBSCAN) SetRejectHandler(fn detectors.RejectFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReject = fn
+}
+
+// FeatureImportances returns each feature's share of the squared
+// distances of training points to their nearest core points: the features
+// that set border and noise points apart weigh more. It returns nil if the
+// detector is not trained.
+func (d *DBSCAN) FeatureImportances() []float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// the squared distance to its nearest core point. The typical range of
+// each feature is its range over the core points of that point's cluster.
+func (d *DBSCAN) Explain(sample []float64) (detectors.Explanation, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return detectors.Explanation{}, d.dimensionError(sample)
+	}
+	q, n := d.query(sample)
+	return d.explain(sample, q, n), nil
+}
+
+// explain explains a sample of the right width from its standardized form
+// and nearest core point. The caller holds the read lock.
+func (d *DBSCAN) explain(sample, q []float64, n kdtree.Neighbor) detectors.Explanation {
+	contributions := make([]float64, len(sample))
+	d.addShares(q, n.Row, contributions)
+	normalize(contributions)
+	topK := d.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         d.score(n.Dist),
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, d.ranges[d.labels[n.Row]], topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (d *DBSCAN) Metadata() detectors.ModelCard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	card := d.card
+	card.FeatureNames = slices.Clone(d.card.FeatureNames)
+	if d.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(d.card.Hyperparameters))
+		for k, v := range d.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (d *DBSCAN) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   d.dataSource,
+		Rows:         len(data),
+		Features:     len(d.mean),
+		FeatureNames: slices.Clone(d.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":  "dbscan",
+			"eps":        strconv.FormatFloat(d.radius, 'g', -1, 64),
+			"min_points": strconv.Itoa(d.minPoints),
+			"clusters":   strconv.Itoa(len(d.sizes)),
+			"threshold":  strconv.FormatFloat(d.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (d *DBSCAN) Trained() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (d *DBSCAN) Threshold() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (d *DBSCAN) SetThreshold(t float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = t
+}
@@ -0,0 +1,154 @@
+package dbscan
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// groupData returns n samples of two features in three groups, centered
+// on (0, 0), (10, 0) and (0, 1000): the second feature on a much wider
+// scale than the first.
+func groupData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	centers := [][2]float64{{0, 0}, {10, 0}, {0, 1000}}
+	data := make([][]float64, n)
+	for i := range data {
+		c := centers[i%len(centers)]
+		data[i] = []float64{c[0] + rng.NormFloat64(), c[1] + 100*rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*DBSCAN, Option]{
+		Algorithm:        "dbscan",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithMinPoints(8)},
+		Invalid:          []Option{WithEps(-1), WithMinPoints(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            groupData(1500, 6),
+		Probe:            append(groupData(20, 7), []float64{5, 0}, []float64{0, 500}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	d := New()
+	require.NoError(t, d.Fit(groupData(3000, 1)))
+	assert.Greater(t, d.Eps(), 0.0)
+
+	// Besides the three groups, a few points on their fringes may be dense
+	// enough to form small clusters of their own.
+	sizes := slices.Sorted(slices.Values(d.Clusters()))
+	require.GreaterOrEqual(t, len(sizes), 3)
+	total := 0
+	for _, n := range sizes {
+		total += n
+	}
+	for _, n := range sizes[len(sizes)-3:] {
+		assert.InDelta(t, 1000, n, 50)
+	}
+	assert.Less(t, 3000-total, 150, "little of the training data is noise")
+
+	scores, err := d.Predict([][]float64{
+		{0, 0},
+		{10, 50},
+		{0, 1000},
+		{5, 0},
+		{0, 500},
+		{30, 0},
+		{math.NaN(), 0},
+	})
+	require.NoError(t, err)
+	for i, s := range scores[:3] {
+		assert.Less(t, s, d.Threshold(), "group %d is normal", i)
+	}
+	for i, s := range scores[3:] {
+		assert.GreaterOrEqual(t, s, d.Threshold(), "sample %d", i+3)
+	}
+	assert.Equal(t, 1.0, scores[6], "NaN features are infinitely far")
+
+	seen := map[int]bool{}
+	for _, sample := range [][]float64{{0, 0}, {10, 50}, {0, 1000}} {
+		c, err := d.Cluster(sample)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, c, 0)
+		seen[c] = true
+	}
+	assert.Len(t, seen, 3, "each group is its own cluster")
+	c, err := d.Cluster([]float64{5, 0})
+	require.NoError(t, err)
+	assert.Equal(t, Noise, c, "between groups is noise")
+
+	one, err := d.PredictOne([]float64{5, 0})
+	require.NoError(t, err)
+	assert.Equal(t, scores[3], one)
+
+	// A radius wider than the gap between groups merges them.
+	wide := New(WithEps(4))
+	require.NoError(t, wide.Fit(groupData(3000, 1)))
+	assert.Len(t, wide.Clusters(), 1)
+	assert.Equal(t, 4.0, wide.Eps())
+}
+
+func TestErrors(t *testing.T) {
+	d := New()
+	_, err := d.Cluster([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	assert.Nil(t, d.Clusters())
+
+	assert.Error(t, d.Fit(groupData(4, 1)), "need at least MinPoints rows")
+	assert.Error(t, New(WithEps(1e-9)).Fit(groupData(20, 1)), "no core points")
+	assert.Error(t, d.Fit([][]float64{{1, 1}, {1, 1}, {1, 1}, {1, 1}, {1, 1}, {2, 2}}), "automatic eps of 0")
+	assert.False(t, d.Trained())
+}
+
+func TestExplain(t *testing.T) {
+	d := New()
+	require.NoError(t, d.Fit(groupData(3000, 4)))
+
+	sample := []float64{-8, 0}
+	exp, err := d.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 0, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Greater(t, exp.Top[0].Typical.Low, -8.0, "the range of the nearest cluster excludes the odd value")
+
+	nan, err := d.Explain([]float64{0, math.NaN()})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 1}, nan.Contributions)
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := groupData(10000, 1)
+	d := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New()
+	d.Fit(groupData(100000, 1))
+	samples := groupData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
@@ -0,0 +1,148 @@
+package dbscan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/kdtree"
+)
+
+// saveFormat frames saved DBSCAN models.
+var saveFormat = container.Format{Name: "dbscan", Model: "DBSCAN", Magic: "GGDBSAVE", Version: 1}
+
+// savedModel holds the options and the standardized core points and their
+// clusters of a saved DBSCAN model.
+type savedModel struct {
+	Eps       float64
+	MinPoints int
+	Radius    float64
+	Threshold float64
+	Mean      []float64
+	Std       []float64
+	// Core are the standardized core points in training order, one after
+	// the other, and Labels their clusters. The index and the ranges of
+	// the clusters are rebuilt on Load.
+	Core        []float64
+	Labels      []int
+	Sizes       []int
+	Importances []float64
+	Card        container.Card
+}
+
+// Save serializes the standardized core points and their clusters.
+func (d *DBSCAN) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (d *DBSCAN) SaveTo(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Eps:         d.eps,
+		MinPoints:   d.minPoints,
+		Radius:      d.radius,
+		Threshold:   d.threshold,
+		Mean:        d.mean,
+		Std:         d.std,
+		Core:        make([]float64, 0, d.core.Len()*len(d.mean)),
+		Labels:      d.labels,
+		Sizes:       d.sizes,
+		Importances: d.importances,
+		Card:        container.NewCard(d.card),
+	}
+	for row := range d.core.Len() {
+		m.Core = append(m.Core, d.core.Point(row)...)
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the standardized core points and their clusters written by
+// Save, rebuilding the index and the cluster ranges.
+func (d *DBSCAN) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	dim := len(m.Mean)
+	points := make([][]float64, len(m.Core)/dim)
+	for i := range points {
+		points[i] = m.Core[i*dim : (i+1)*dim]
+	}
+	core := kdtree.Build(points, dim, leafSize)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.eps, d.minPoints, d.radius = m.Eps, m.MinPoints, m.Radius
+	d.threshold = m.Threshold
+	d.mean, d.std = m.Mean, m.Std
+	d.core, d.labels, d.sizes = core, m.Labels, m.Sizes
+	d.ranges = d.clusterRanges(len(m.Sizes))
+	d.importances = m.Importances
+	d.card = m.Card.ModelCard()
+	d.featureNames = d.card.FeatureNames
+	d.trained = true
+	return nil
+}
+
+// LoadFrom restores the standardized core points and their clusters read
+// from r.
+func (d *DBSCAN) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Load(data)
+}
+
+// validate checks the saved standardized core points and their clusters.
+func (m *savedModel) validate() error {
+	dim := len(m.Mean)
+	switch {
+	case dim == 0:
+		return errors.New("dbscan: model has no features")
+	case len(m.Std) != dim:
+		return fmt.Errorf("dbscan: %d deviations for %d features", len(m.Std), dim)
+	case len(m.Core) == 0 || len(m.Core)%dim != 0:
+		return fmt.Errorf("dbscan: %d values do not hold points of %d features", len(m.Core), dim)
+	case len(m.Labels) != len(m.Core)/dim:
+		return fmt.Errorf("dbscan: %d cluster labels for %d core points", len(m.Labels), len(m.Core)/dim)
+	case m.MinPoints < 2 || !(m.Eps >= 0):
+		return errors.New("dbscan: invalid hyperparameters")
+	case !(m.Radius > 0) || math.IsInf(m.Radius, 0):
+		return fmt.Errorf("dbscan: invalid eps %g", m.Radius)
+	}
+	for _, c := range m.Labels {
+		if c < 0 || c >= len(m.Sizes) {
+			return fmt.Errorf("dbscan: cluster %d out of %d", c, len(m.Sizes))
+		}
+	}
+	for j, s := range m.Std {
+		if !(s > 0) || math.IsInf(s, 0) {
+			return fmt.Errorf("dbscan: feature %d: invalid deviation %g", j, s)
+		}
+	}
+	for _, v := range m.Core {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("dbscan: model has non-finite points")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package dbscan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLoadClusters(t *testing.T) {
+	d := New(WithMinPoints(8))
+	require.NoError(t, d.Fit(groupData(1500, 6)))
+	saved, err := d.Save()
+	require.NoError(t, err)
+
+	loaded := New()
+	require.NoError(t, loaded.Load(saved))
+	assert.Equal(t, d.Clusters(), loaded.Clusters())
+	assert.Equal(t, d.Eps(), loaded.Eps())
+}
@@ -1,7 +1,15 @@
 // Package detectors provides unsupervised anomaly detection algorithms.
 package detectors
 
-import "context"
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
 
 // Detector is the common interface for all anomaly detection algorithms.
 type Detector interface {
@@ -13,6 +21,10 @@ type Detector interface {
 	// Scores are normalized to [0, 1] where higher values indicate anomalies.
 	Predict(data [][]float64) ([]float64, error)
 
+	// PredictContext is Predict, returning ctx.Err() without scores if ctx
+	// is done before the batch is scored.
+	PredictContext(ctx context.Context, data [][]float64) ([]float64, error)
+
 	// PredictOne returns the anomaly score for a single sample.
 	PredictOne(sample []float64) (float64, error)
 
@@ -21,6 +33,13 @@ type Detector interface {
 
 	// Load deserializes a trained model from bytes.
 	Load(data []byte) error
+
+	// SaveTo writes the trained model to w in the format of Save, without
+	// first building it in memory where the format allows.
+	SaveTo(w io.Writer) error
+
+	// LoadFrom reads a model written by Save or SaveTo from r.
+	LoadFrom(r io.Reader) error
 }
 
 // StreamDetector extends Detector with streaming capabilities.
@@ -28,9 +47,247 @@ type StreamDetector interface {
 	Detector
 
 	// PredictStream processes samples from a channel and outputs scores.
+	// It closes output when it returns; callers must not close it too.
+	// Samples that cannot be scored are passed to the reject handler of a
+	// RejectReporter, if set, and skipped. Each Score's Features is the
+	// input sample itself, not a copy.
 	PredictStream(ctx context.Context, input <-chan []float64, output chan<- Score) error
 }
 
+// DatasetDetector is implemented by detectors that work on contiguous
+// datasets directly, without a slice per row.
+type DatasetDetector interface {
+	// FitDataset trains the detector on ds.
+	FitDataset(ds *data.Dataset) error
+
+	// PredictDataset returns anomaly scores for the samples in ds.
+	PredictDataset(ds *data.Dataset) ([]float64, error)
+}
+
+// FitDataset trains d on ds, using row views for detectors that do not
+// implement DatasetDetector.
+func FitDataset(d Detector, ds *data.Dataset) error {
+	if dd, ok := d.(DatasetDetector); ok {
+		return dd.FitDataset(ds)
+	}
+	return d.Fit(ds.Rows())
+}
+
+// PredictDataset scores the samples in ds with d, using row views for
+// detectors that do not implement DatasetDetector.
+func PredictDataset(d Detector, ds *data.Dataset) ([]float64, error) {
+	if dd, ok := d.(DatasetDetector); ok {
+		return dd.PredictDataset(ds)
+	}
+	return d.Predict(ds.Rows())
+}
+
+// Rejection is a streamed sample that could not be scored.
+type Rejection struct {
+	Sample []float64
+	Err    error
+}
+
+// RejectFunc receives rejected samples in input order. It runs on the
+// streaming goroutine, so a slow handler slows the stream down.
+type RejectFunc func(Rejection)
+
+// RejectReporter is implemented by stream detectors that report samples
+// they cannot score, such as samples of the wrong dimension, instead of
+// silently dropping them.
+type RejectReporter interface {
+	// SetRejectHandler sets the handler called for every rejected sample.
+	// A nil handler drops rejected samples. The handler applies to streams
+	// started afterwards.
+	SetRejectHandler(fn RejectFunc)
+}
+
+// Thresholder is implemented by detectors with an adjustable anomaly threshold.
+type Thresholder interface {
+	// Threshold returns the current anomaly threshold.
+	Threshold() float64
+
+	// SetThreshold updates the anomaly threshold.
+	SetThreshold(t float64)
+}
+
+// Refitter is implemented by detectors that can be retrained while in
+// service.
+type Refitter interface {
+	// Refit trains a new model on data and swaps it in atomically. Scoring
+	// continues on the current model until the new one is ready.
+	Refit(data [][]float64) error
+}
+
+// Sequential is implemented by detectors that score the samples of a
+// batch as a sequence, each in the context of those before it, such as
+// time series detectors continuing the training series. Splitting a batch
+// into several Predict calls would restart every part from the training
+// data and change its scores, so helpers that split batches, such as
+// PredictTopK, score these in one call.
+type Sequential interface {
+	// Sequential reports whether batches are scored as sequences.
+	Sequential() bool
+}
+
+// IsSequential reports whether d scores batches as sequences.
+func IsSequential(d Detector) bool {
+	s, ok := d.(Sequential)
+	return ok && s.Sequential()
+}
+
+// ThresholdOf returns the threshold of d if it implements Thresholder,
+// otherwise the default threshold.
+func ThresholdOf(d Detector) float64 {
+	if t, ok := d.(Thresholder); ok {
+		return t.Threshold()
+	}
+	return DefaultConfig().Threshold
+}
+
+// ErrNoProfile is returned by Drift for detectors that do not record a
+// training profile, or models saved before one was recorded.
+var ErrNoProfile = errors.New("detector has no training profile")
+
+// Profiler is implemented by detectors that keep the distribution of their
+// training data, so live data can be checked for drift.
+type Profiler interface {
+	// TrainingProfile returns the per-feature training distribution, or nil
+	// if none was recorded.
+	TrainingProfile() *stats.Profile
+}
+
+// Drift compares live data against the training profile of d.
+func Drift(d Detector, live [][]float64, opts ...stats.CompareOption) (stats.DriftReport, error) {
+	p, ok := d.(Profiler)
+	if !ok {
+		return stats.DriftReport{}, ErrNoProfile
+	}
+	profile := p.TrainingProfile()
+	if profile == nil {
+		return stats.DriftReport{}, ErrNoProfile
+	}
+	return profile.Compare(live, opts...)
+}
+
+// ErrNotExplainable is returned by Explain for detectors that do not
+// implement Explainer.
+var ErrNotExplainable = errors.New("detector does not support explanations")
+
+// Explainer is implemented by detectors that can attribute scores to features.
+type Explainer interface {
+	// FeatureImportances returns the global importance of each feature,
+	// normalized to sum to 1. It returns nil if the detector is not trained.
+	FeatureImportances() []float64
+
+	// Explain returns the per-feature contributions to the score of sample.
+	Explain(sample []float64) (Explanation, error)
+}
+
+// Explanation attributes an anomaly score to the input features.
+type Explanation struct {
+	// Score is the anomaly score of the explained sample.
+	Score float64 `json:"score"`
+	// Contributions holds one non-negative value per feature, normalized to
+	// sum to 1. Higher values contributed more to the score.
+	Contributions []float64 `json:"contributions,omitempty"`
+	// Top lists the most contributing features, highest first.
+	Top []FeatureContribution `json:"top,omitempty"`
+	// Counterfactual is the nearest normal variant of the sample, if requested.
+	Counterfactual *Counterfactual `json:"counterfactual,omitempty"`
+}
+
+// FeatureContribution describes one feature's part in an explanation.
+type FeatureContribution struct {
+	// Index is the position of the feature in the sample.
+	Index int `json:"index"`
+	// Contribution is the feature's share of the score.
+	Contribution float64 `json:"contribution"`
+	// Value is the feature's value in the explained sample.
+	Value float64 `json:"value"`
+	// Typical is the range of the feature in training data, if known.
+	Typical *Range `json:"typical,omitempty"`
+}
+
+// CounterfactualExplainer is implemented by detectors that can suggest how
+// an anomalous sample would have to change to be scored as normal.
+type CounterfactualExplainer interface {
+	// Counterfactual searches for a small change to sample that brings its
+	// score under the anomaly threshold.
+	Counterfactual(sample []float64) (Counterfactual, error)
+}
+
+// Counterfactual is a "nearest normal" variant of an anomalous sample.
+type Counterfactual struct {
+	// Found reports whether the search brought the score under the threshold.
+	Found bool `json:"found"`
+	// Score is the score of the modified sample.
+	Score float64 `json:"score"`
+	// Changes lists the modified features in the order they were changed.
+	Changes []FeatureChange `json:"changes,omitempty"`
+}
+
+// FeatureChange is a single feature modification in a Counterfactual.
+type FeatureChange struct {
+	Index int     `json:"index"`
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+}
+
+// ExplainCounterfactual returns the counterfactual of sample from d, or
+// ErrNotExplainable if d does not implement CounterfactualExplainer.
+func ExplainCounterfactual(d Detector, sample []float64) (Counterfactual, error) {
+	if c, ok := d.(CounterfactualExplainer); ok {
+		return c.Counterfactual(sample)
+	}
+	return Counterfactual{}, ErrNotExplainable
+}
+
+// Range is a closed interval of feature values.
+type Range struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// TopContributions returns the k features with the highest contributions,
+// highest first. Features that contributed nothing are omitted. typical may
+// be nil; otherwise it holds one range per feature.
+func TopContributions(contributions, sample []float64, typical []Range, k int) []FeatureContribution {
+	order := make([]int, len(contributions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return contributions[order[a]] > contributions[order[b]]
+	})
+
+	top := make([]FeatureContribution, 0, min(k, len(order)))
+	for _, i := range order {
+		if len(top) == k || contributions[i] <= 0 {
+			break
+		}
+		fc := FeatureContribution{Index: i, Contribution: contributions[i]}
+		if i < len(sample) {
+			fc.Value = sample[i]
+		}
+		if i < len(typical) {
+			r := typical[i]
+			fc.Typical = &r
+		}
+		top = append(top, fc)
+	}
+	return top
+}
+
+// Explain returns the explanation of sample from d, or ErrNotExplainable
+// if d does not implement Explainer.
+func Explain(d Detector, sample []float64) (Explanation, error) {
+	if e, ok := d.(Explainer); ok {
+		return e.Explain(sample)
+	}
+	return Explanation{}, ErrNotExplainable
+}
+
 // Score represents an anomaly detection result.
 type Score struct {
 	// Value is the anomaly score in [0, 1].
@@ -41,6 +298,11 @@ type Score struct {
 	Features []float64
 	// Metadata contains additional information.
 	Metadata map[string]any
+	// Explanation attributes the score to features when explanations are enabled.
+	Explanation *Explanation
+	// Severity grades the score when severity bands are set, SeverityNone
+	// otherwise.
+	Severity Severity
 }
 
 // Config holds common configuration for detectors.
@@ -0,0 +1,78 @@
+package detectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+)
+
+func TestTopContributions(t *testing.T) {
+	contributions := []float64{0.1, 0.6, 0, 0.3}
+	sample := []float64{1, 2, 3, 4}
+	typical := []Range{{0, 1}, {0, 1}, {0, 1}, {3, 5}}
+
+	tests := []struct {
+		name      string
+		typical   []Range
+		k         int
+		wantIndex []int
+	}{
+		{name: "top two", typical: typical, k: 2, wantIndex: []int{1, 3}},
+		{name: "zero contributions omitted", typical: typical, k: 10, wantIndex: []int{1, 3, 0}},
+		{name: "without typical ranges", k: 1, wantIndex: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := TopContributions(contributions, sample, tt.typical, tt.k)
+			indices := make([]int, len(top))
+			for i, fc := range top {
+				indices[i] = fc.Index
+				assert.Equal(t, sample[fc.Index], fc.Value)
+				assert.Equal(t, contributions[fc.Index], fc.Contribution)
+				if tt.typical == nil {
+					assert.Nil(t, fc.Typical)
+				} else {
+					assert.Equal(t, tt.typical[fc.Index], *fc.Typical)
+				}
+			}
+			assert.Equal(t, tt.wantIndex, indices)
+		})
+	}
+}
+
+// constant is a Detector that does not implement Explainer.
+type constant struct{ Detector }
+
+func TestExplainNotSupported(t *testing.T) {
+	_, err := Explain(constant{}, []float64{1})
+	assert.ErrorIs(t, err, ErrNotExplainable)
+}
+
+func TestDriftWithoutProfile(t *testing.T) {
+	_, err := Drift(constant{}, [][]float64{{1}})
+	assert.ErrorIs(t, err, ErrNoProfile)
+}
+
+// rowRecorder records the rows it is fitted on.
+type rowRecorder struct {
+	Detector
+	rows [][]float64
+}
+
+func (r *rowRecorder) Fit(rows [][]float64) error {
+	r.rows = rows
+	return nil
+}
+
+func TestFitDatasetFallback(t *testing.T) {
+	ds, err := data.FromRows([][]float64{{1, 2}, {3, 4}})
+	require.NoError(t, err)
+
+	r := &rowRecorder{}
+	require.NoError(t, FitDataset(r, ds))
+	assert.Equal(t, [][]float64{{1, 2}, {3, 4}}, r.rows)
+}
@@ -0,0 +1,632 @@
+// Package eif implements the Extended Isolation Forest of Hariri et al.
+// (2019).
+//
+// An Isolation Forest splits on one feature at a time, so its splits are
+// parallel to the axes and its scores show rectangular artifacts: along
+// correlated features, regions no training sample comes near can score as
+// normal as the data itself, because each feature's value on its own is
+// common. The Extended Isolation Forest splits with random hyperplanes
+// instead, whose normals point in any direction, and its scores follow
+// the shape of the data.
+//
+// WithExtensionLevel sets how many features a hyperplane involves: all of
+// them by default, one at level 0, which gives axis-parallel splits like
+// the Isolation Forest. Features are standardized by their training mean
+// and standard deviation first, so no feature dominates the hyperplanes
+// by its unit.
+package eif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// tree count, sample size or contamination.
+var ErrInvalidOption = fmt.Errorf("eif: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of samples scored between context checks.
+const scoreChunk = 1024
+
+// importanceRows is the number of training rows, evenly spread, whose
+// explanations make up the feature importances.
+const importanceRows = 1024
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// EIF is an Extended Isolation Forest. It is safe for concurrent use.
+type EIF struct {
+	mu sync.RWMutex
+
+	// Configuration
+	nTrees        int
+	sampleSize    int
+	extension     int
+	seed          int64
+	contamination float64
+	threshold     float64
+	workers       int
+	explainTop    int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	mean  []float64
+	std   []float64
+	trees []*tree
+	// avgPathLength is c(n) of the samples each tree was grown from.
+	avgPathLength float64
+	importances   []float64
+	card          detectors.ModelCard
+	trained       bool
+}
+
+// Option configures an EIF.
+type Option func(*EIF)
+
+// WithTrees sets the number of trees, 100 by default.
+func WithTrees(n int) Option {
+	return func(e *EIF) {
+		e.nTrees = n
+	}
+}
+
+// WithSampleSize sets the number of training rows each tree is grown
+// from, 256 by default.
+func WithSampleSize(n int) Option {
+	return func(e *EIF) {
+		e.sampleSize = n
+	}
+}
+
+// WithExtensionLevel sets the number of features each hyperplane involves,
+// minus one. Level 0 splits on one feature at a time, like the Isolation
+// Forest; negative levels, the default, involve every feature. Fit fails
+// for levels of the number of features or more.
+func WithExtensionLevel(level int) Option {
+	return func(e *EIF) {
+		e.extension = level
+	}
+}
+
+// WithSeed sets the random seed, 42 by default. Training is reproducible
+// for a seed, whatever the number of workers.
+func WithSeed(seed int64) Option {
+	return func(e *EIF) {
+		e.seed = seed
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(e *EIF) {
+		e.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(e *EIF) {
+		e.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(e *EIF) {
+		e.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(e *EIF) {
+		e.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new EIF.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(e *EIF) {
+		e.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(e *EIF) {
+		e.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(e *EIF) {
+		e.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained EIF with the given options.
+func New(opts ...Option) *EIF {
+	e := &EIF{
+		nTrees:        100,
+		sampleSize:    256,
+		extension:     -1,
+		seed:          42,
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.workers = max(e.workers, 0)
+	e.explainTop = max(e.explainTop, 0)
+	return e
+}
+
+// Validate checks the tree count, sample size and contamination set by the
+// options.
+func (e *EIF) Validate() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.validate()
+}
+
+func (e *EIF) validate() error {
+	var errs []error
+	if e.nTrees < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithTrees(%d): need at least one tree", ErrInvalidOption, e.nTrees))
+	}
+	if e.sampleSize < 2 {
+		errs = append(errs, fmt.Errorf("%w: WithSampleSize(%d): need at least two samples per tree", ErrInvalidOption, e.sampleSize))
+	}
+	if !(e.contamination >= 0 && e.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, e.contamination))
+	}
+	if e.severity != nil {
+		if err := e.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit standardizes data and grows the forest. Features must be finite.
+func (e *EIF) Fit(data [][]float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if e.featureNames != nil && len(e.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(e.featureNames), nFeatures)
+	}
+	if e.extension >= nFeatures {
+		return fmt.Errorf("%w: WithExtensionLevel(%d): must be below the %d features", ErrInvalidOption, e.extension, nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	e.mean, e.std = standardization(data)
+	rows := make([][]float64, len(data))
+	for i, row := range data {
+		rows[i] = e.standardize(row, make([]float64, nFeatures))
+	}
+	extension := e.extension
+	if extension < 0 {
+		extension = nFeatures - 1
+	}
+	sampleSize := min(e.sampleSize, len(data))
+	maxDepth := depthLimit(sampleSize)
+
+	// Each tree draws from its own generator seeded from the forest's, so
+	// the forest does not depend on the number of workers.
+	rng := rand.New(rand.NewSource(e.seed))
+	seeds := make([]int64, e.nTrees)
+	for i := range seeds {
+		seeds[i] = rng.Int63()
+	}
+	workers := detectors.Workers(e.workers)
+	e.trees = make([]*tree, e.nTrees)
+	detectors.ParallelFor(e.nTrees, workers, 1, func(lo, hi int) {
+		rng := rand.New(rand.NewSource(0))
+		perm := make([]int, len(rows))
+		for i := range perm {
+			perm[i] = i
+		}
+		for t := lo; t < hi; t++ {
+			rng.Seed(seeds[t])
+			// A partial Fisher-Yates shuffle draws the rows of the tree.
+			for i := 0; i < sampleSize; i++ {
+				j := i + rng.Intn(len(perm)-i)
+				perm[i], perm[j] = perm[j], perm[i]
+			}
+			e.trees[t] = buildTree(rows, slices.Clone(perm[:sampleSize]), maxDepth, extension, rng)
+		}
+	})
+	e.avgPathLength = averagePathLength(float64(sampleSize))
+	e.trained = true
+
+	if e.contamination > 0 {
+		scores := make([]float64, len(rows))
+		detectors.ParallelFor(len(rows), workers, scoreChunk, func(lo, hi int) {
+			for i := lo; i < hi; i += scoreChunk {
+				end := min(i+scoreChunk, hi)
+				e.scoreRows(rows[i:end], scores[i:end])
+			}
+		})
+		est := stats.NewQuantileEstimator(len(scores), 100)
+		est.AddAll(scores)
+		e.threshold = est.Quantile(1 - e.contamination)
+	}
+
+	step := max(1, len(rows)/importanceRows)
+	importances := make([]float64, nFeatures)
+	shares := make([]float64, nFeatures)
+	for i := 0; i < len(rows); i += step {
+		clear(shares)
+		for _, t := range e.trees {
+			t.addShares(rows[i], shares)
+		}
+		for j, s := range normalize(shares) {
+			importances[j] += s
+		}
+	}
+	e.importances = normalize(importances)
+	e.card = e.modelCard(data)
+	return nil
+}
+
+// standardization returns the mean and standard deviation of each feature
+// of data. Constant features get a deviation of 1.
+func standardization(data [][]float64) (mean, std []float64) {
+	n := len(data[0])
+	mean, std = make([]float64, n), make([]float64, n)
+	column := make([]float64, len(data))
+	for j := range n {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		mean[j], std[j] = stats.MeanStd(column)
+		if !(std[j] > 0) {
+			std[j] = 1
+		}
+	}
+	return mean, std
+}
+
+// standardize writes the standardized sample to dst and returns it. The
+// caller holds the read lock.
+func (e *EIF) standardize(sample, dst []float64) []float64 {
+	for j, v := range sample {
+		dst[j] = stats.Standardize(v, e.mean[j], e.std[j])
+	}
+	return dst
+}
+
+// score returns the anomaly score of standardized sample x,
+// 2^(-E[h(x)]/c(n)): near 1 for samples isolated in a few splits, below
+// 0.5 for samples deep inside the data. The caller holds the read lock.
+func (e *EIF) score(x []float64) float64 {
+	var sum float64
+	for _, t := range e.trees {
+		sum += t.pathLength(x)
+	}
+	return e.scoreOf(sum)
+}
+
+// scoreRows writes the scores of the standardized rows to scores. It walks
+// one tree at a time through all rows, so each tree stays in cache. The
+// caller holds the read lock.
+func (e *EIF) scoreRows(rows [][]float64, scores []float64) {
+	clear(scores)
+	for _, t := range e.trees {
+		for i, x := range rows {
+			scores[i] += t.pathLength(x)
+		}
+	}
+	for i, sum := range scores {
+		scores[i] = e.scoreOf(sum)
+	}
+}
+
+// scoreOf returns the score of a sample whose path lengths sum to sum.
+func (e *EIF) scoreOf(sum float64) float64 {
+	if e.avgPathLength == 0 {
+		return 0.5
+	}
+	return math.Exp2(-sum / float64(len(e.trees)) / e.avgPathLength)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (e *EIF) Predict(data [][]float64) ([]float64, error) {
+	return e.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (e *EIF) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(e.mean) {
+			return nil, fmt.Errorf("sample %d: %w", i, e.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(e.workers), scoreChunk, func(lo, hi int) {
+		dim := len(e.mean)
+		values := make([]float64, scoreChunk*dim)
+		rows := make([][]float64, scoreChunk)
+		for i := lo; i < hi; i += scoreChunk {
+			if ctx.Err() != nil {
+				return
+			}
+			end := min(i+scoreChunk, hi)
+			for k := i; k < end; k++ {
+				rows[k-i] = e.standardize(data[k], values[(k-i)*dim:(k-i+1)*dim])
+			}
+			e.scoreRows(rows[:end-i], scores[i:end])
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (e *EIF) PredictOne(sample []float64) (float64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(e.mean) {
+		return 0, e.dimensionError(sample)
+	}
+	return e.score(e.standardize(sample, make([]float64, len(sample)))), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (e *EIF) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(e.mean)}
+}
+
+// PredictStream scores samples from input against the extended isolation
+// trees and feature standardization until input is closed.
+func (e *EIF) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	e.mu.RLock()
+	if !e.trained {
+		e.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := e.onReject
+	e.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, e.streamScore)
+}
+
+// streamScore scores a streamed sample against the extended isolation trees
+// and feature standardization.
+func (e *EIF) streamScore(sample []float64) (detectors.Score, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(sample) != len(e.mean) {
+		return detectors.Score{}, e.dimensionError(sample)
+	}
+	x := e.standardize(sample, make([]float64, len(sample)))
+	score := e.score(x)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= e.threshold,
+		Features:  sample,
+	}
+	if e.explainTop > 0 {
+		exp := e.explain(sample, x, score)
+		result.Explanation = &exp
+	}
+	if e.severity != nil {
+		result.Severity = e.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*EIF)(nil)
+	_ detectors.Thresholder    = (*EIF)(nil)
+	_ detectors.RejectReporter = (*EIF)(nil)
+	_ detectors.Explainer      = (*EIF)(nil)
+	_ detectors.Describer      = (*EIF)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the extended isolation trees and feature standardization
+// to.
+func (e *EIF) SetRejectHandler(fn detectors.RejectFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onReject = fn
+}
+
+// FeatureImportances returns each feature's mean contribution to the
+// explanations of training rows. It returns nil if the detector is not
+// trained.
+func (e *EIF) FeatureImportances() []float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.importances)
+}
+
+// Explain attributes the score of sample to its features by their part of
+// its distance from the hyperplanes on its paths through the forest:
+// splits the sample lies far from, which isolate it, weigh the most. The
+// typical range of each feature is its training mean plus or minus two
+// standard deviations.
+func (e *EIF) Explain(sample []float64) (detectors.Explanation, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(e.mean) {
+		return detectors.Explanation{}, e.dimensionError(sample)
+	}
+	x := e.standardize(sample, make([]float64, len(sample)))
+	return e.explain(sample, x, e.score(x)), nil
+}
+
+// explain explains a sample of the right width from its standardized form
+// and score. The caller holds the read lock.
+func (e *EIF) explain(sample, x []float64, score float64) detectors.Explanation {
+	contributions := make([]float64, len(sample))
+	for _, t := range e.trees {
+		t.addShares(x, contributions)
+	}
+	normalize(contributions)
+
+	typical := make([]detectors.Range, len(sample))
+	for j := range typical {
+		typical[j] = detectors.Range{Low: e.mean[j] - 2*e.std[j], High: e.mean[j] + 2*e.std[j]}
+	}
+	topK := e.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, typical, topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (e *EIF) Metadata() detectors.ModelCard {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	card := e.card
+	card.FeatureNames = slices.Clone(e.card.FeatureNames)
+	if e.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(e.card.Hyperparameters))
+		for k, v := range e.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (e *EIF) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   e.dataSource,
+		Rows:         len(data),
+		Features:     len(e.mean),
+		FeatureNames: slices.Clone(e.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":       "eif",
+			"trees":           strconv.Itoa(e.nTrees),
+			"sample_size":     strconv.Itoa(e.sampleSize),
+			"extension_level": strconv.Itoa(e.extension),
+			"seed":            strconv.FormatInt(e.seed, 10),
+			"contamination":   strconv.FormatFloat(e.contamination, 'g', -1, 64),
+			"threshold":       strconv.FormatFloat(e.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (e *EIF) Trained() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (e *EIF) Threshold() float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (e *EIF) SetThreshold(t float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.threshold = t
+}
@@ -0,0 +1,160 @@
+package eif
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// correlatedData returns n samples of three features: two strongly
+// correlated, y close to x, and an independent one on a much wider scale.
+func correlatedData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		x := rng.NormFloat64()
+		data[i] = []float64{x, x + 0.1*rng.NormFloat64(), 1000 * rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*EIF, Option]{
+		Algorithm:        "eif",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Options:          []Option{WithTrees(20)},
+		Configured:       []Option{WithExtensionLevel(1)},
+		Invalid:          []Option{WithTrees(0), WithSampleSize(1), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            correlatedData(1000, 6),
+		Probe:            append(correlatedData(20, 7), []float64{0, 0, 6000}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	e := New()
+	require.NoError(t, e.Fit(correlatedData(2000, 1)))
+
+	scores, err := e.Predict([][]float64{
+		{0, 0, 0},
+		{1.5, 1.5, 0},
+		{1.5, -1.5, 0},
+		{0, 0, 6000},
+		{9, 9, 0},
+	})
+	require.NoError(t, err)
+	for _, s := range scores {
+		assert.Greater(t, s, 0.0)
+		assert.Less(t, s, 1.0)
+	}
+	assert.Less(t, scores[0], e.Threshold(), "the center is normal")
+	assert.Less(t, scores[1], e.Threshold(), "along the correlation is normal")
+	for i, s := range scores[2:] {
+		assert.Greater(t, s, e.Threshold(), "sample %d", i+2)
+	}
+
+	// Contamination sets the threshold to flag about 10% of training.
+	trainScores, err := e.Predict(correlatedData(2000, 2))
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range trainScores {
+		if s >= e.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 200, flagged, 50)
+
+	one, err := e.PredictOne([]float64{1.5, -1.5, 0})
+	require.NoError(t, err)
+	assert.Equal(t, scores[2], one)
+
+	// Trees are seeded independently of the number of workers.
+	serial := New(WithWorkers(1))
+	require.NoError(t, serial.Fit(correlatedData(2000, 1)))
+	again, err := serial.Predict(correlatedData(2000, 2))
+	require.NoError(t, err)
+	assert.Equal(t, trainScores, again)
+}
+
+func TestExtensionLevel(t *testing.T) {
+	// Off the diagonal, both features are common on their own: axis-parallel
+	// splits barely tell the sample from the data, hyperplanes do.
+	data := correlatedData(4000, 3)
+	offDiagonal := []float64{1, -1, 0}
+	gap := func(level int) float64 {
+		e := New(WithExtensionLevel(level))
+		require.NoError(t, e.Fit(data))
+		off, err := e.PredictOne(offDiagonal)
+		require.NoError(t, err)
+		return off - e.Threshold()
+	}
+	axis, extended := gap(0), gap(-1)
+	assert.Greater(t, extended, 0.0, "hyperplanes flag the sample")
+	assert.Greater(t, extended, axis)
+
+	e := New(WithExtensionLevel(0))
+	require.NoError(t, e.Fit(data))
+	for _, tr := range e.trees {
+		for p := 0; p < len(tr.normals)/tr.dim; p++ {
+			nonzero := 0
+			for _, v := range tr.normals[p*tr.dim : (p+1)*tr.dim] {
+				if v != 0 {
+					nonzero++
+				}
+			}
+			require.Equal(t, 1, nonzero, "level 0 splits on one feature")
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	assert.ErrorIs(t, New(WithExtensionLevel(3)).Fit(correlatedData(20, 1)), ErrInvalidOption)
+}
+
+func TestExplain(t *testing.T) {
+	e := New()
+	require.NoError(t, e.Fit(correlatedData(1000, 4)))
+
+	sample := []float64{0, 0, 7000}
+	exp, err := e.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 2, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Less(t, exp.Top[0].Typical.High, 7000.0, "the typical range excludes the odd value")
+
+	nan, err := e.Explain([]float64{0, math.NaN(), 0})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 1, 0}, nan.Contributions)
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := correlatedData(100000, 1)
+	e := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	e := New()
+	e.Fit(correlatedData(100000, 1))
+	samples := correlatedData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Predict(samples)
+	}
+}
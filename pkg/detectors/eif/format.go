@@ -0,0 +1,192 @@
+package eif
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved Extended Isolation Forest models.
+var saveFormat = container.Format{Name: "eif", Model: "Extended Isolation Forest", Magic: "GGEFSAVE", Version: 1}
+
+// savedModel holds the options and the extended isolation trees and feature
+// standardization of a saved Extended Isolation Forest model.
+type savedModel struct {
+	Trees         int
+	SampleSize    int
+	Extension     int
+	Seed          int64
+	Contamination float64
+	Threshold     float64
+	AvgPathLength float64
+	Mean          []float64
+	Std           []float64
+	Forest        []savedTree
+	Importances   []float64
+	Card          container.Card
+}
+
+// savedTree is the serialized form of tree: the children, hyperplane and
+// size of each node, one after the other.
+type savedTree struct {
+	Left, Right, Plane, Size []int32
+	Normals, Points          []float64
+}
+
+// Save serializes the extended isolation trees and feature standardization.
+func (e *EIF) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (e *EIF) SaveTo(w io.Writer) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Trees:         e.nTrees,
+		SampleSize:    e.sampleSize,
+		Extension:     e.extension,
+		Seed:          e.seed,
+		Contamination: e.contamination,
+		Threshold:     e.threshold,
+		AvgPathLength: e.avgPathLength,
+		Mean:          e.mean,
+		Std:           e.std,
+		Forest:        make([]savedTree, len(e.trees)),
+		Importances:   e.importances,
+		Card:          container.NewCard(e.card),
+	}
+	for i, t := range e.trees {
+		s := savedTree{Normals: t.normals, Points: t.points}
+		for _, nd := range t.nodes {
+			s.Left = append(s.Left, nd.left)
+			s.Right = append(s.Right, nd.right)
+			s.Plane = append(s.Plane, nd.plane)
+			s.Size = append(s.Size, nd.size)
+		}
+		m.Forest[i] = s
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the extended isolation trees and feature standardization
+// written by Save.
+func (e *EIF) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	trees := make([]*tree, len(m.Forest))
+	for i, s := range m.Forest {
+		t, err := s.tree(len(m.Mean))
+		if err != nil {
+			return fmt.Errorf("eif: tree %d: %w", i, err)
+		}
+		trees[i] = t
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nTrees, e.sampleSize, e.extension, e.seed = m.Trees, m.SampleSize, m.Extension, m.Seed
+	e.contamination, e.threshold = m.Contamination, m.Threshold
+	e.avgPathLength = m.AvgPathLength
+	e.mean, e.std = m.Mean, m.Std
+	e.trees = trees
+	e.importances = m.Importances
+	e.card = m.Card.ModelCard()
+	e.featureNames = e.card.FeatureNames
+	e.trained = true
+	return nil
+}
+
+// LoadFrom restores the extended isolation trees and feature
+// standardization read from r.
+func (e *EIF) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return e.Load(data)
+}
+
+// validate checks the saved extended isolation trees and feature
+// standardization.
+func (m *savedModel) validate() error {
+	dim := len(m.Mean)
+	switch {
+	case dim == 0:
+		return errors.New("eif: model has no features")
+	case len(m.Std) != dim:
+		return fmt.Errorf("eif: %d deviations for %d features", len(m.Std), dim)
+	case len(m.Forest) == 0:
+		return errors.New("eif: model has no trees")
+	case !(m.AvgPathLength >= 0) || math.IsInf(m.AvgPathLength, 0):
+		return fmt.Errorf("eif: invalid average path length %g", m.AvgPathLength)
+	}
+	for j, s := range m.Std {
+		if !(s > 0) || math.IsInf(s, 0) {
+			return fmt.Errorf("eif: feature %d: invalid deviation %g", j, s)
+		}
+	}
+	return nil
+}
+
+// tree validates and returns the saved tree of dim features. Children
+// must follow their parent, as Fit stores them, so walks end.
+func (s *savedTree) tree(dim int) (*tree, error) {
+	n := len(s.Left)
+	if n == 0 || len(s.Right) != n || len(s.Plane) != n || len(s.Size) != n {
+		return nil, errors.New("inconsistent node arrays")
+	}
+	if len(s.Normals) != len(s.Points) || len(s.Normals)%dim != 0 {
+		return nil, errors.New("inconsistent hyperplanes")
+	}
+	planes := int32(len(s.Normals) / dim)
+	t := &tree{dim: dim, nodes: make([]node, n), normals: s.Normals, points: s.Points}
+	for i := range t.nodes {
+		nd := node{left: s.Left[i], right: s.Right[i], plane: s.Plane[i], size: s.Size[i]}
+		nd.expected = averagePathLength(float64(nd.size))
+		if nd.left >= 0 {
+			self := int32(i)
+			if nd.left <= self || nd.right <= self || int(nd.left) >= n || int(nd.right) >= n || nd.plane < 0 || nd.plane >= planes {
+				return nil, fmt.Errorf("node %d: invalid split", i)
+			}
+		} else if nd.size < 0 {
+			return nil, fmt.Errorf("node %d: invalid size %d", i, nd.size)
+		}
+		t.nodes[i] = nd
+	}
+	for _, v := range s.Normals {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, errors.New("non-finite hyperplane")
+		}
+	}
+	for _, v := range s.Points {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, errors.New("non-finite hyperplane")
+		}
+	}
+	t.offsets = make([]float64, planes)
+	for p := range t.offsets {
+		off := p * dim
+		t.offsets[p] = offset(t.normals[off:off+dim], t.points[off:off+dim])
+	}
+	return t, nil
+}
@@ -0,0 +1,203 @@
+package eif
+
+import (
+	"math"
+	"math/rand"
+)
+
+// tree is an extended isolation tree. Nodes are stored in depth-first
+// order, the root first, and every split owns a hyperplane: dim values of
+// normals and of points from plane*dim, and the offset normal·point.
+type tree struct {
+	dim     int
+	nodes   []node
+	normals []float64
+	points  []float64
+	offsets []float64
+}
+
+// node is a node of a tree. Leaves have a negative left.
+type node struct {
+	left, right int32
+	// plane indexes the hyperplane of a split.
+	plane int32
+	// size is the number of training rows that reached a leaf, and
+	// expected c(size), the depth they would have been isolated at below
+	// it.
+	size     int32
+	expected float64
+}
+
+// builder grows one tree over standardized rows.
+type builder struct {
+	t         *tree
+	data      [][]float64
+	rng       *rand.Rand
+	maxDepth  int
+	extension int
+	lo, hi    []float64
+}
+
+// buildTree grows a tree over the rows of data listed in idx, reordering
+// idx in place. Hyperplane normals have extension+1 nonzero components.
+func buildTree(data [][]float64, idx []int, maxDepth, extension int, rng *rand.Rand) *tree {
+	dim := len(data[0])
+	b := &builder{
+		// A tree over n samples has at most 2n-1 nodes.
+		t:         &tree{dim: dim, nodes: make([]node, 0, 2*len(idx))},
+		data:      data,
+		rng:       rng,
+		maxDepth:  maxDepth,
+		extension: extension,
+		lo:        make([]float64, dim),
+		hi:        make([]float64, dim),
+	}
+	b.build(idx, 0)
+	return b.t
+}
+
+// build grows the subtree over the rows in idx and returns its index.
+func (b *builder) build(idx []int, depth int) int32 {
+	self := int32(len(b.t.nodes))
+	b.t.nodes = append(b.t.nodes, node{left: -1, right: -1, size: int32(len(idx)), expected: averagePathLength(float64(len(idx)))})
+	if depth >= b.maxDepth || len(idx) <= 1 {
+		return self
+	}
+
+	// The bounding box of the rows.
+	copy(b.lo, b.data[idx[0]])
+	copy(b.hi, b.data[idx[0]])
+	for _, i := range idx[1:] {
+		for j, v := range b.data[i] {
+			b.lo[j] = min(b.lo[j], v)
+			b.hi[j] = max(b.hi[j], v)
+		}
+	}
+	flat := true
+	for j := range b.lo {
+		if b.lo[j] != b.hi[j] {
+			flat = false
+			break
+		}
+	}
+	if flat {
+		return self
+	}
+
+	// A random normal, with all but extension+1 components zeroed, through
+	// a random point of the box.
+	dim := b.t.dim
+	plane := int32(len(b.t.normals) / dim)
+	for j := 0; j < dim; j++ {
+		b.t.normals = append(b.t.normals, b.rng.NormFloat64())
+		b.t.points = append(b.t.points, b.lo[j]+b.rng.Float64()*(b.hi[j]-b.lo[j]))
+	}
+	normal := b.t.normals[int(plane)*dim:]
+	if zeroed := dim - 1 - b.extension; zeroed > 0 {
+		for _, j := range b.rng.Perm(dim)[:zeroed] {
+			normal[j] = 0
+		}
+	}
+	b.t.offsets = append(b.t.offsets, offset(normal[:dim], b.t.points[int(plane)*dim:]))
+
+	// Partition in place: rows on the negative side end up in idx[:lo].
+	lo, hi := 0, len(idx)
+	for lo < hi {
+		if b.t.side(plane, b.data[idx[lo]]) <= 0 {
+			lo++
+		} else {
+			hi--
+			idx[lo], idx[hi] = idx[hi], idx[lo]
+		}
+	}
+	left := b.build(idx[:lo], depth+1)
+	right := b.build(idx[lo:], depth+1)
+	b.t.nodes[self].left, b.t.nodes[self].right, b.t.nodes[self].plane = left, right, plane
+	return self
+}
+
+// offset returns normal·point, skipping the zero components of normal.
+func offset(normal, point []float64) float64 {
+	var d float64
+	for j, n := range normal {
+		if n != 0 {
+			d += n * point[j]
+		}
+	}
+	return d
+}
+
+// side returns the signed distance of x from hyperplane plane, scaled by
+// the norm of its normal. Components of the normal that are zero are
+// skipped, so a NaN feature only affects the splits it takes part in; at
+// those the distance is NaN, and x goes right.
+func (t *tree) side(plane int32, x []float64) float64 {
+	off := int(plane) * t.dim
+	return offset(t.normals[off:off+t.dim], x) - t.offsets[plane]
+}
+
+// pathLength returns the depth at which x is isolated, with the expected
+// depth of the rows left in its leaf added.
+func (t *tree) pathLength(x []float64) float64 {
+	i, depth := int32(0), 0
+	for {
+		nd := &t.nodes[i]
+		if nd.left < 0 {
+			return float64(depth) + nd.expected
+		}
+		if t.side(nd.plane, x) <= 0 {
+			i = nd.left
+		} else {
+			i = nd.right
+		}
+		depth++
+	}
+}
+
+// addShares adds to shares each feature's part of the distance of x from
+// the hyperplanes on its path through t. Splits x lies far from decide its
+// path more clearly and weigh more. NaN features get an infinite share.
+func (t *tree) addShares(x, shares []float64) {
+	i := int32(0)
+	for {
+		nd := &t.nodes[i]
+		if nd.left < 0 {
+			return
+		}
+		off := int(nd.plane) * t.dim
+		for j, n := range t.normals[off : off+t.dim] {
+			switch {
+			case n == 0:
+			case math.IsNaN(x[j]):
+				shares[j] = math.Inf(1)
+			default:
+				shares[j] += math.Abs(n * (x[j] - t.points[off+j]))
+			}
+		}
+		if t.side(nd.plane, x) <= 0 {
+			i = nd.left
+		} else {
+			i = nd.right
+		}
+	}
+}
+
+// averagePathLength returns the average path length of an unsuccessful
+// search in a binary search tree of n keys, c(n) in the isolation forest
+// literature.
+func averagePathLength(n float64) float64 {
+	if n <= 1 {
+		return 0
+	}
+	// c(n) = 2*H(n-1) - 2*(n-1)/n, with H(n) ≈ ln(n) + Euler's constant.
+	return 2*(math.Log(n-1)+0.5772156649) - 2*(n-1)/n
+}
+
+// depthLimit returns the height limit of trees grown from sampleSize
+// samples: ceil(log2(sampleSize)).
+func depthLimit(sampleSize int) int {
+	if sampleSize <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(sampleSize))))
+}
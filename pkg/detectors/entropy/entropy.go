@@ -0,0 +1,677 @@
+// Package entropy implements a Shannon entropy detector for volumetric
+// attacks.
+//
+// Samples are events in time order, such as packets or flows, whose
+// selected fields hold categorical values: a source address encoded as a
+// number, a destination port. The detector slides a window over the last
+// WithWindow events and tracks the Shannon entropy of each selected field
+// over it, how evenly its values are spread. Attacks that change the mix
+// of traffic move it sharply: a flood from a few sources or at one port
+// collapses the entropy of that field, while a spoofed or distributed
+// flood, or a scan across many ports, makes it spike. Each event scores
+// by the field whose entropy strays farthest from its training median,
+// in robust deviations, so that three deviations score 0.5, the default
+// threshold.
+//
+// A window of a fixed number of events measures the mix of traffic, not
+// its rate: a flood that keeps the usual mix only arrives faster, and
+// its windows look like any other. With WithTimeWindow the window holds
+// the events of a fixed duration instead, by a timestamp field of each
+// event, and its volume, the log2 of the number of events in it, is
+// scored alongside the entropies, so such a flood stands out too.
+//
+// Predict scores a batch as the continuation of the training events, so
+// its first windows begin with the last training events; batches do not
+// change the model. PredictStream carries its own window from one sample
+// to the next. All NaN values of a field count as one value.
+package entropy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// window, time window or contamination.
+var ErrInvalidOption = fmt.Errorf("entropy: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of events scored between context checks.
+const scoreChunk = 1024
+
+// clip is the number of robust deviations from the median entropy that
+// scores 0.5.
+const clip = 3
+
+// madScale makes the median absolute deviation of normal data estimate
+// its standard deviation; meanADScale does the same for the mean absolute
+// deviation.
+const (
+	madScale    = 1.4826
+	meanADScale = 1.2533
+)
+
+// minSpread is the smallest deviation of the entropy of a field, in bits,
+// so a field whose entropy hardly varied in training is not flagged for
+// differences no one would notice.
+const minSpread = 0.01
+
+// Entropy is a sliding-window Shannon entropy detector. It is safe for
+// concurrent use.
+type Entropy struct {
+	mu sync.RWMutex
+
+	// Configuration
+	window int
+	// span is the duration of time windows, 0 for windows of window
+	// events; timeField is the feature holding the time of each event.
+	span          time.Duration
+	timeField     int
+	fields        []int
+	contamination float64
+	threshold     float64
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	features int
+	// selected are the fields tracked: fields, or every feature if none
+	// were set.
+	selected []int
+	// median and spread are the median entropy of each selected field
+	// over the training windows and its robust deviation, followed, for
+	// time windows, by those of the volume.
+	median, spread []float64
+	// tail holds the selected fields of the training events still in the
+	// window after the last one, which begin the windows of the first
+	// events of a batch: the last window-1, or those of the last span.
+	// tailTimes holds their times, for time windows.
+	tail      []float64
+	tailTimes []float64
+	card      detectors.ModelCard
+	trained   bool
+}
+
+// Option configures an Entropy.
+type Option func(*Entropy)
+
+// WithWindow sets the number of events, the scored one last, whose
+// entropy is measured, 1000 by default. Windows should hold enough events
+// for the usual variety of each field to show, and few enough that an
+// attack soon fills them; at least 2.
+func WithWindow(n int) Option {
+	return func(e *Entropy) {
+		e.window = n
+	}
+}
+
+// WithTimeWindow measures entropies over the events of the last d, the
+// scored one included, instead of over the last WithWindow events. The
+// time of each event, in seconds, is its feature field, such as a capture
+// timestamp, which is not itself tracked. Each window's volume, the log2
+// of the number of events in it, is tracked with the entropies, so floods
+// that raise the rate of events without changing their mix are flagged.
+// Times must not decrease and the training events must span at least two
+// windows. Batches and streams continue the training events unless their
+// first event is older than the last training event, as when scoring the
+// training data again; their windows then start empty.
+func WithTimeWindow(field int, d time.Duration) Option {
+	return func(e *Entropy) {
+		e.timeField, e.span = field, d
+	}
+}
+
+// WithFields sets the indices of the features whose entropy is tracked,
+// such as those of the source address and destination port. By default
+// every feature is.
+func WithFields(fields ...int) Option {
+	return func(e *Entropy) {
+		e.fields = slices.Clone(fields)
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0 by
+// default. Above 0, Fit sets the threshold to flag that fraction of the
+// training events instead of keeping it at three deviations.
+func WithContamination(c float64) Option {
+	return func(e *Entropy) {
+		e.contamination = c
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(e *Entropy) {
+		e.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new Entropy.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(e *Entropy) {
+		e.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(e *Entropy) {
+		e.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// their number does not match the data.
+func WithFeatureNames(names []string) Option {
+	return func(e *Entropy) {
+		e.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained Entropy with the given options.
+func New(opts ...Option) *Entropy {
+	e := &Entropy{
+		window:    1000,
+		threshold: 0.5,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Validate checks the window, time window and contamination set by the
+// options.
+func (e *Entropy) Validate() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.validate()
+}
+
+func (e *Entropy) validate() error {
+	var errs []error
+	if e.window < 2 {
+		errs = append(errs, fmt.Errorf("%w: WithWindow(%d): must be at least 2", ErrInvalidOption, e.window))
+	}
+	if e.span < 0 || e.span > 0 && e.timeField < 0 {
+		errs = append(errs, fmt.Errorf("%w: WithTimeWindow(%d, %v): needs a field index and a positive duration", ErrInvalidOption, e.timeField, e.span))
+	}
+	for _, j := range e.fields {
+		if j < 0 {
+			errs = append(errs, fmt.Errorf("%w: WithFields: negative index %d", ErrInvalidOption, j))
+		}
+		if e.span > 0 && j == e.timeField {
+			errs = append(errs, fmt.Errorf("%w: WithFields: %d is the time field", ErrInvalidOption, j))
+		}
+	}
+	if !(e.contamination >= 0 && e.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, e.contamination))
+	}
+	if e.severity != nil {
+		if err := e.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit measures the entropy of the selected fields over every window of
+// the training events, in time order, for their medians and deviations.
+// The events must span at least two windows. Windows are only measured
+// once full: from the window-th event on, or from the first event a span
+// after the first.
+func (e *Entropy) Fit(data [][]float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if e.featureNames != nil && len(e.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(e.featureNames), nFeatures)
+	}
+	timed := e.span > 0
+	if timed && e.timeField >= nFeatures {
+		return fmt.Errorf("time field %d of %d features", e.timeField, nFeatures)
+	}
+	selected := e.fields
+	if len(selected) == 0 {
+		selected = make([]int, 0, nFeatures)
+		for j := range nFeatures {
+			if !timed || j != e.timeField {
+				selected = append(selected, j)
+			}
+		}
+		if len(selected) == 0 {
+			return errors.New("no fields besides the time field")
+		}
+	}
+	for _, j := range selected {
+		if j >= nFeatures {
+			return fmt.Errorf("field %d of %d features", j, nFeatures)
+		}
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+	}
+	if !timed && len(data) < 2*e.window {
+		return fmt.Errorf("%d training events for windows of %d: need at least two windows", len(data), e.window)
+	}
+	if timed {
+		first, last := data[0][e.timeField], data[len(data)-1][e.timeField]
+		if !(last-first >= 2*e.span.Seconds()) {
+			return fmt.Errorf("training events from %g to %g seconds for windows of %v: need at least two windows", first, last, e.span)
+		}
+	}
+
+	// The entropies, and volumes, of every full window.
+	nStats := len(selected)
+	if timed {
+		nStats++
+	}
+	entropies := make([][]float64, nStats)
+	w := newWindow(e.window, e.span.Seconds(), len(selected))
+	values := make([]float64, len(selected))
+	h := make([]float64, nStats)
+	for i, row := range data {
+		if err := w.push(e.timeOf(row), pick(row, selected, values)); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if !w.full() {
+			continue
+		}
+		w.entropies(h)
+		for f := range entropies {
+			entropies[f] = append(entropies[f], h[f])
+		}
+	}
+	nWindows := len(entropies[0])
+	e.median = make([]float64, nStats)
+	e.spread = make([]float64, nStats)
+	for f, hs := range entropies {
+		e.median[f], e.spread[f] = robust(slices.Clone(hs))
+	}
+	e.features = nFeatures
+	e.selected = slices.Clone(selected)
+	e.tail, e.tailTimes = nil, nil
+	for _, row := range data[len(data)-e.tailLen(data):] {
+		e.tail = append(e.tail, pick(row, selected, values)...)
+		if timed {
+			e.tailTimes = append(e.tailTimes, e.timeOf(row))
+		}
+	}
+	e.trained = true
+
+	if e.contamination > 0 {
+		est := stats.NewQuantileEstimator(nWindows, 100)
+		for i := range nWindows {
+			for f := range h {
+				h[f] = entropies[f][i]
+			}
+			est.Add(e.score(h))
+		}
+		e.threshold = est.Quantile(1 - e.contamination)
+	}
+	e.card = e.modelCard(data)
+	return nil
+}
+
+// timeOf returns the time of event row for time windows, 0 otherwise.
+func (e *Entropy) timeOf(row []float64) float64 {
+	if e.span == 0 {
+		return 0
+	}
+	return row[e.timeField]
+}
+
+// tailLen returns the number of last training events that stay in the
+// window after the last one: window-1, or those less than span before
+// it.
+func (e *Entropy) tailLen(data [][]float64) int {
+	if e.span == 0 {
+		return e.window - 1
+	}
+	last := data[len(data)-1][e.timeField]
+	n := 1
+	for n < len(data) && data[len(data)-1-n][e.timeField] > last-e.span.Seconds() {
+		n++
+	}
+	return n
+}
+
+// pick writes the values of the given fields of row to dst and returns
+// it.
+func pick(row []float64, fields []int, dst []float64) []float64 {
+	for f, j := range fields {
+		dst[f] = row[j]
+	}
+	return dst
+}
+
+// robust returns the median of values and their scaled median absolute
+// deviation from it, or the scaled mean absolute deviation if that is
+// zero, and at least minSpread. It reorders values.
+func robust(values []float64) (median, spread float64) {
+	median = stats.Select(values, len(values)/2)
+	var mean float64
+	for i, v := range values {
+		values[i] = math.Abs(v - median)
+		mean += values[i]
+	}
+	mean /= float64(len(values))
+	spread = madScale * stats.Select(values, len(values)/2)
+	if spread == 0 {
+		spread = meanADScale * mean
+	}
+	return median, max(spread, minSpread)
+}
+
+// deviation returns the deviation of entropy h of selected field f from
+// its median, in robust deviations: negative for a collapse, positive for
+// a spike.
+func (e *Entropy) deviation(f int, h float64) float64 {
+	return (h - e.median[f]) / e.spread[f]
+}
+
+// score maps the entropies of a window to [0, 1] by the field deviating
+// most: 0.5 at three deviations, rising toward 1 beyond.
+func (e *Entropy) score(h []float64) float64 {
+	var z float64
+	for f, v := range h {
+		z = max(z, math.Abs(e.deviation(f, v)))
+	}
+	return z / (z + clip)
+}
+
+// Predict returns anomaly scores for the given samples.
+func (e *Entropy) Predict(data [][]float64) ([]float64, error) {
+	return e.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, scoring the batch in order and checking ctx
+// every thousand samples.
+func (e *Entropy) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	scores := make([]float64, len(data))
+	err := e.slide(ctx, data, func(i int, h []float64) {
+		scores[i] = e.score(h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// Entropies returns, for each sample, the entropy in bits of each
+// selected field over the window ending at it, continuing the training
+// events as Predict does. For time windows each also holds the volume of
+// the window last, the log2 of the number of events in it.
+func (e *Entropy) Entropies(data [][]float64) ([][]float64, error) {
+	out := make([][]float64, len(data))
+	err := e.slide(context.Background(), data, func(i int, h []float64) {
+		out[i] = slices.Clone(h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// slide runs a window over data, continuing the training events, and
+// calls fn with the index of each sample and the entropies of the window
+// ending at it, which fn must not keep.
+func (e *Entropy) slide(ctx context.Context, data [][]float64, fn func(i int, h []float64)) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != e.features {
+			return fmt.Errorf("sample %d: %w", i, e.dimensionError(sample))
+		}
+	}
+	var w *window
+	values := make([]float64, len(e.selected))
+	h := make([]float64, len(e.median))
+	for i, sample := range data {
+		if i == 0 {
+			w = e.newWindow(sample)
+		}
+		if i%scoreChunk == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := w.push(e.timeOf(sample), pick(sample, e.selected, values)); err != nil {
+			return fmt.Errorf("sample %d: %w", i, err)
+		}
+		fn(i, w.entropies(h))
+	}
+	return nil
+}
+
+// newWindow returns a window holding the training tail, for a batch or
+// stream starting with sample; empty for time windows if sample is older
+// than the tail. The caller holds the read lock.
+func (e *Entropy) newWindow(sample []float64) *window {
+	w := newWindow(e.window, e.span.Seconds(), len(e.selected))
+	if e.span > 0 && e.timeOf(sample) < e.tailTimes[len(e.tailTimes)-1] {
+		return w
+	}
+	for i := 0; i < len(e.tail); i += len(e.selected) {
+		var t float64
+		if e.span > 0 {
+			t = e.tailTimes[i/len(e.selected)]
+		}
+		// The tail was checked by Fit or Load.
+		_ = w.push(t, e.tail[i:i+len(e.selected)])
+	}
+	return w
+}
+
+// PredictOne returns the anomaly score of sample as the event right after
+// the training events.
+func (e *Entropy) PredictOne(sample []float64) (float64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != e.features {
+		return 0, e.dimensionError(sample)
+	}
+	w := e.newWindow(sample)
+	if err := w.push(e.timeOf(sample), pick(sample, e.selected, make([]float64, len(e.selected)))); err != nil {
+		return 0, err
+	}
+	return e.score(w.entropies(make([]float64, len(e.median)))), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (e *Entropy) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: e.features}
+}
+
+// Baseline returns the indices of the fields tracked, and the median
+// entropy in bits of each over the training windows and its robust
+// deviation. For time windows median and spread hold those of the volume
+// last.
+func (e *Entropy) Baseline() (fields []int, median, spread []float64) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.selected), slices.Clone(e.median), slices.Clone(e.spread)
+}
+
+// stream is the state PredictStream carries between samples: the window
+// and the training tail it was started from.
+type stream struct {
+	from   []float64
+	window *window
+	values []float64
+	h      []float64
+}
+
+// PredictStream scores samples from input, each the next event after the
+// training events, until input is closed.
+func (e *Entropy) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	e.mu.RLock()
+	if !e.trained {
+		e.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := e.onReject
+	e.mu.RUnlock()
+
+	var st stream
+	return streamer.Run(ctx, input, output, reject, func(sample []float64) (detectors.Score, error) {
+		return e.streamScore(&st, sample)
+	})
+}
+
+// streamScore scores one streamed sample under one read lock, sliding the
+// stream's window, which it starts afresh from the training tail on the
+// first sample and again if the model changed since.
+func (e *Entropy) streamScore(st *stream, sample []float64) (detectors.Score, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(sample) != e.features {
+		return detectors.Score{}, e.dimensionError(sample)
+	}
+	if st.window == nil || &st.from[0] != &e.tail[0] {
+		st.from = e.tail
+		st.window = e.newWindow(sample)
+		st.values = make([]float64, len(e.selected))
+		st.h = make([]float64, len(e.median))
+	}
+	if err := st.window.push(e.timeOf(sample), pick(sample, e.selected, st.values)); err != nil {
+		return detectors.Score{}, err
+	}
+	score := e.score(st.window.entropies(st.h))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= e.threshold,
+		Features:  sample,
+	}
+	if e.severity != nil {
+		result.Severity = e.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*Entropy)(nil)
+	_ detectors.Thresholder    = (*Entropy)(nil)
+	_ detectors.RejectReporter = (*Entropy)(nil)
+	_ detectors.Describer      = (*Entropy)(nil)
+	_ detectors.Sequential     = (*Entropy)(nil)
+)
+
+// Sequential reports true: a batch is scored as the continuation of the
+// training events, so it must not be split.
+func (e *Entropy) Sequential() bool {
+	return true
+}
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the entropy baselines of the tracked fields to. Rejected
+// samples do not advance the series.
+func (e *Entropy) SetRejectHandler(fn detectors.RejectFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onReject = fn
+}
+
+// Metadata returns the model card recorded by Fit.
+func (e *Entropy) Metadata() detectors.ModelCard {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	card := e.card
+	card.FeatureNames = slices.Clone(e.card.FeatureNames)
+	if e.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(e.card.Hyperparameters))
+		for k, v := range e.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (e *Entropy) modelCard(data [][]float64) detectors.ModelCard {
+	fields := make([]string, len(e.selected))
+	for f, j := range e.selected {
+		fields[f] = strconv.Itoa(j)
+	}
+	card := detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   e.dataSource,
+		Rows:         len(data),
+		Features:     e.features,
+		FeatureNames: slices.Clone(e.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "entropy",
+			"window":        strconv.Itoa(e.window),
+			"fields":        strings.Join(fields, ","),
+			"contamination": strconv.FormatFloat(e.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(e.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+	if e.span > 0 {
+		delete(card.Hyperparameters, "window")
+		card.Hyperparameters["time_window"] = e.span.String()
+		card.Hyperparameters["time_field"] = strconv.Itoa(e.timeField)
+	}
+	return card
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (e *Entropy) Trained() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (e *Entropy) Threshold() float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (e *Entropy) SetThreshold(t float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.threshold = t
+}
@@ -0,0 +1,266 @@
+package entropy
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// size is the window of the detectors under test.
+const size = 200
+
+// ports are the destination ports of normal traffic, most used first.
+var ports = []float64{443, 443, 443, 80, 80, 53, 22, 8080}
+
+// traffic returns n events of normal traffic, [source, port, bytes]: one
+// of 300 sources, most often one of the first, to one of the usual ports.
+func traffic(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		src := math.Floor(300 * rng.Float64() * rng.Float64())
+		data[i] = []float64{src, ports[rng.Intn(len(ports))], float64(rng.Intn(1500))}
+	}
+	return data
+}
+
+// flood makes data[lo:hi] packets from one source to port 80: the
+// entropy of sources collapses.
+func flood(data [][]float64, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		data[i] = []float64{7, 80, 60}
+	}
+}
+
+// scan makes data[lo:hi] probes of one port after another: the entropy of
+// ports spikes.
+func scan(data [][]float64, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		data[i] = []float64{12, float64(1000 + i), 60}
+	}
+}
+
+func TestDetector(t *testing.T) {
+	probe := traffic(1000, 7)
+	flood(probe, 500, 700)
+	detectortest.Suite[*Entropy, Option]{
+		Algorithm:        "entropy",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		Options:          []Option{WithWindow(size)},
+		Configured:       []Option{WithFields(0, 1)},
+		Invalid:          []Option{WithWindow(1), WithFields(-1), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            traffic(2000, 6),
+		Probe:            probe,
+		AcceptsNonFinite: true,
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	d := New(WithWindow(size), WithFields(0, 1))
+	require.NoError(t, d.Fit(traffic(5000, 1)))
+
+	live := traffic(3000, 2)
+	flood(live, 1000, 1200)
+	scan(live, 2000, 2200)
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+
+	flagged := 0
+	for i, s := range scores[:1000] {
+		if s >= d.Threshold() {
+			flagged++
+			t.Logf("normal event %d scores %g", i, s)
+		}
+	}
+	assert.Less(t, flagged, 20, "normal traffic is rarely flagged")
+	assert.Greater(t, scores[1150], d.Threshold(), "the flood is flagged")
+	assert.Greater(t, scores[2150], d.Threshold(), "the scan is flagged")
+
+	// The flood collapses the entropy of sources, the scan raises that of
+	// ports.
+	fields, median, _ := d.Baseline()
+	assert.Equal(t, []int{0, 1}, fields)
+	h, err := d.Entropies(live)
+	require.NoError(t, err)
+	assert.Less(t, h[1150][0], median[0]-1)
+	assert.Greater(t, h[2150][1], median[1]+1)
+
+	one, err := d.PredictOne(live[0])
+	require.NoError(t, err)
+	assert.Equal(t, scores[0], one)
+
+	assert.Equal(t, "0,1", d.Metadata().Hyperparameters["fields"])
+}
+
+func TestPredictTopK(t *testing.T) {
+	// A flood spanning the chunks PredictTopK scores other detectors in:
+	// the windows after the boundary still hold its start.
+	d := New(WithWindow(size), WithFields(0, 1))
+	require.NoError(t, d.Fit(traffic(2000, 1)))
+	live := traffic(70000, 2)
+	flood(live, 65436, 65636)
+
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+	top, err := detectors.PredictTopK(context.Background(), d, live, 50)
+	require.NoError(t, err)
+	require.Len(t, top, 50)
+	for _, r := range top {
+		assert.Equal(t, scores[r.Index], r.Score, "sample %d scores as in one batch", r.Index)
+	}
+	assert.Equal(t, 65635, top[0].Index, "the flood fills the window at its end")
+}
+
+func TestEntropy(t *testing.T) {
+	w := newWindow(4, 0, 1)
+	for _, v := range []float64{1, 2, 3, 4} {
+		require.NoError(t, w.push(0, []float64{v}))
+	}
+	h := make([]float64, 1)
+	assert.InDelta(t, 2, w.entropies(h)[0], 1e-12, "four values equally likely")
+	for range 4 {
+		require.NoError(t, w.push(0, []float64{math.NaN()}))
+	}
+	assert.InDelta(t, 0, w.entropies(h)[0], 1e-12, "NaNs count as one value")
+	require.NoError(t, w.push(0, []float64{0}))
+	require.NoError(t, w.push(0, []float64{math.Copysign(0, -1)}))
+	assert.InDelta(t, 1, w.entropies(h)[0], 1e-12, "zeros count alike")
+
+	// A window of 2 seconds holds the events of times in (t-2, t].
+	w = newWindow(0, 2, 1)
+	h = make([]float64, 2)
+	for i, v := range []float64{1, 2, 3, 4} {
+		require.NoError(t, w.push(float64(i), []float64{v}))
+	}
+	assert.Equal(t, 2, w.len())
+	assert.InDelta(t, 1, w.entropies(h)[1], 1e-12, "the volume is log2 of the count")
+	require.NoError(t, w.push(3, []float64{4}))
+	assert.Equal(t, []float64{2, 3, 3}, w.times[w.first:])
+	assert.Error(t, w.push(2.5, []float64{1}), "times must not decrease")
+	assert.Error(t, w.push(math.NaN(), []float64{1}))
+}
+
+// timed returns n events of normal traffic, [time, source, port, bytes],
+// arriving at rate events per second on average from start.
+func timed(n int, start, rate float64, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := traffic(n, seed)
+	t := start
+	for i, row := range data {
+		t += rng.ExpFloat64() / rate
+		data[i] = append([]float64{t}, row...)
+	}
+	return data
+}
+
+func TestTimeWindow(t *testing.T) {
+	train := timed(20000, 0, 100, 1)
+	d := New(WithTimeWindow(0, 2*time.Second), WithFields(1, 2))
+	require.NoError(t, d.Fit(train))
+	counted := New(WithWindow(size), WithFields(1, 2))
+	require.NoError(t, counted.Fit(train))
+
+	// A flood of ten times the usual rate with the usual mix of sources
+	// and ports: only the volume of time windows shows it.
+	last := train[len(train)-1][0]
+	live := timed(3000, last, 100, 2)
+	live = append(live, timed(2000, live[len(live)-1][0], 1000, 3)...)
+	end := len(live) - 1
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range scores[:3000] {
+		if s >= d.Threshold() {
+			flagged++
+		}
+	}
+	assert.Less(t, flagged, 60, "normal traffic is rarely flagged")
+	assert.Greater(t, scores[end], d.Threshold(), "the flood is flagged")
+	counts, err := counted.Predict(live)
+	require.NoError(t, err)
+	assert.Less(t, counts[end], counted.Threshold(), "windows of a count hide the rate")
+
+	fields, median, _ := d.Baseline()
+	assert.Equal(t, []int{1, 2}, fields)
+	require.Len(t, median, 3)
+	assert.InDelta(t, math.Log2(200), median[2], 0.2, "about 200 events a window")
+	h, err := d.Entropies(live)
+	require.NoError(t, err)
+	assert.Greater(t, h[end][2], median[2]+2)
+
+	one, err := d.PredictOne(live[0])
+	require.NoError(t, err)
+	assert.Equal(t, scores[0], one)
+	assert.Equal(t, "2s", d.Metadata().Hyperparameters["time_window"])
+
+	saved, err := d.Save()
+	require.NoError(t, err)
+	loaded := New()
+	require.NoError(t, loaded.Load(saved))
+	got, err := loaded.Predict(live)
+	require.NoError(t, err)
+	assert.Equal(t, scores, got)
+
+	again, err := d.Predict(train)
+	require.NoError(t, err, "a batch older than the training events starts afresh")
+	flagged = 0
+	// The first windows of the batch hold few events.
+	for _, s := range again[400:] {
+		if s >= d.Threshold() {
+			flagged++
+		}
+	}
+	assert.Less(t, flagged, 400)
+	_, err = d.Predict([][]float64{live[1], live[0]})
+	assert.Error(t, err, "times must not decrease")
+	assert.Error(t, New(WithTimeWindow(0, 2*time.Minute)).Fit(train), "training spans less than two windows")
+	assert.ErrorIs(t, New(WithTimeWindow(0, time.Second), WithFields(0, 1)).Validate(), ErrInvalidOption)
+	assert.ErrorIs(t, New(WithTimeWindow(-1, time.Second)).Validate(), ErrInvalidOption)
+}
+
+func TestContamination(t *testing.T) {
+	d := New(WithWindow(size), WithFields(0, 1), WithContamination(0.05))
+	require.NoError(t, d.Fit(traffic(5000, 3)))
+	assert.Less(t, d.Threshold(), 0.5)
+	assert.Positive(t, d.Threshold())
+}
+
+func TestErrors(t *testing.T) {
+	assert.Error(t, New(WithWindow(size)).Fit(traffic(2*size-1, 1)), "need two windows")
+	assert.Error(t, New(WithWindow(size), WithFields(3)).Fit(traffic(500, 1)))
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := traffic(20000, 1)
+	d := New(WithFields(0, 1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New(WithFields(0, 1))
+	d.Fit(traffic(20000, 1))
+	samples := traffic(10000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
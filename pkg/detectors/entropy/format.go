@@ -0,0 +1,146 @@
+package entropy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved entropy models.
+var saveFormat = container.Format{Name: "entropy", Model: "entropy", Magic: "GGENSAVE", Version: 1}
+
+// savedModel holds the options and the entropy baselines of the tracked
+// fields of a saved entropy model.
+type savedModel struct {
+	Window        int
+	Contamination float64
+	Threshold     float64
+	Features      int
+	// Fields are the indices of the fields tracked; Median and Spread
+	// their baselines.
+	Fields         []int
+	Median, Spread []float64
+	// Tail is the selected fields of the training events that begin the
+	// windows of a batch.
+	Tail []float64
+	Card container.Card
+	// Span is the duration of time windows, 0 for windows of Window
+	// events; TimeField the feature holding event times and TailTimes
+	// the times of the tail events. Median and Spread then end with the
+	// baseline of the volume.
+	Span      time.Duration
+	TimeField int
+	TailTimes []float64
+}
+
+// Save serializes the entropy baselines of the tracked fields.
+func (e *Entropy) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (e *Entropy) SaveTo(w io.Writer) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Window:        e.window,
+		Contamination: e.contamination,
+		Threshold:     e.threshold,
+		Features:      e.features,
+		Fields:        e.selected,
+		Median:        e.median,
+		Spread:        e.spread,
+		Tail:          e.tail,
+		Card:          container.NewCard(e.card),
+		Span:          e.span,
+		TimeField:     e.timeField,
+		TailTimes:     e.tailTimes,
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the entropy baselines of the tracked fields written by
+// Save.
+func (e *Entropy) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.window = m.Window
+	e.contamination, e.threshold = m.Contamination, m.Threshold
+	e.features = m.Features
+	e.selected, e.fields = m.Fields, m.Fields
+	e.median, e.spread = m.Median, m.Spread
+	e.tail = m.Tail
+	e.span, e.timeField, e.tailTimes = m.Span, m.TimeField, m.TailTimes
+	e.card = m.Card.ModelCard()
+	e.featureNames = e.card.FeatureNames
+	e.trained = true
+	return nil
+}
+
+// LoadFrom restores the entropy baselines of the tracked fields read from
+// r.
+func (e *Entropy) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return e.Load(data)
+}
+
+// validate checks the saved entropy baselines of the tracked fields.
+func (m *savedModel) validate() error {
+	timed := m.Span > 0
+	stats, tail := len(m.Fields), m.Window-1
+	if timed {
+		stats, tail = stats+1, len(m.TailTimes)
+	}
+	switch {
+	case m.Window < 2 || m.Features < 1 || len(m.Fields) == 0 || m.Span < 0:
+		return errors.New("entropy: invalid hyperparameters")
+	case len(m.Median) != stats || len(m.Spread) != stats:
+		return fmt.Errorf("entropy: %d baselines for %d fields", len(m.Median), len(m.Fields))
+	case len(m.Tail) != tail*len(m.Fields):
+		return fmt.Errorf("entropy: %d tail values for %d events", len(m.Tail), tail)
+	case timed && (m.TimeField < 0 || m.TimeField >= m.Features || len(m.TailTimes) == 0):
+		return fmt.Errorf("entropy: time field %d of %d features", m.TimeField, m.Features)
+	}
+	for _, j := range m.Fields {
+		if j < 0 || j >= m.Features || timed && j == m.TimeField {
+			return fmt.Errorf("entropy: field %d of %d features", j, m.Features)
+		}
+	}
+	for i, t := range m.TailTimes {
+		if math.IsNaN(t) || math.IsInf(t, 0) || i > 0 && t < m.TailTimes[i-1] {
+			return errors.New("entropy: invalid tail times")
+		}
+	}
+	for f := range m.Median {
+		if math.IsNaN(m.Median[f]) || math.IsInf(m.Median[f], 0) || !(m.Spread[f] > 0) || math.IsInf(m.Spread[f], 0) {
+			return errors.New("entropy: invalid baseline")
+		}
+	}
+	return nil
+}
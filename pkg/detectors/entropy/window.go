@@ -0,0 +1,186 @@
+package entropy
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// refresh is the number of values a counter takes or drops after which
+// its sum is recomputed from the counts, so rounding errors do not
+// accumulate over long streams.
+const refresh = 4096
+
+// nanKey is the key of every NaN, which all count as one value.
+var nanKey = math.Float64bits(math.NaN())
+
+// key returns the value v counts as: its bits, with both zeros and all
+// NaNs taken alike.
+func key(v float64) uint64 {
+	switch {
+	case v == 0:
+		return 0
+	case v != v:
+		return nanKey
+	}
+	return math.Float64bits(v)
+}
+
+// xlogx returns n·log2(n), 0 for 0.
+func xlogx(n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return float64(n) * math.Log2(float64(n))
+}
+
+// counter counts the values of one field in a window and keeps the sum of
+// c·log2(c) over their counts, from which the entropy follows.
+type counter struct {
+	counts map[uint64]int
+	sum    float64
+	steps  int
+}
+
+func newCounter() *counter {
+	return &counter{counts: make(map[uint64]int)}
+}
+
+// add counts one more k, or one fewer for delta -1.
+func (c *counter) add(k uint64, delta int) {
+	n := c.counts[k]
+	c.sum += xlogx(n+delta) - xlogx(n)
+	if n+delta == 0 {
+		delete(c.counts, k)
+	} else {
+		c.counts[k] = n + delta
+	}
+	if c.steps++; c.steps == refresh {
+		// Summed in key order, so equal windows have equal entropies
+		// whatever the order of the map.
+		c.sum, c.steps = 0, 0
+		for _, k := range slices.Sorted(maps.Keys(c.counts)) {
+			c.sum += xlogx(c.counts[k])
+		}
+	}
+}
+
+// entropy returns the Shannon entropy, in bits, of the values of a window
+// of n.
+func (c *counter) entropy(n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return max(math.Log2(float64(n))-c.sum/float64(n), 0)
+}
+
+// window slides over a stream of samples and keeps the entropy of each
+// of their fields over the last size samples or, for a time window, over
+// the samples of the last span seconds.
+type window struct {
+	size     int
+	span     float64
+	counters []*counter
+	// keys holds the keys of the fields of the samples in the window,
+	// one per counter, oldest first from sample first on; times holds the
+	// time of each sample of a time window.
+	keys  []uint64
+	times []float64
+	first int
+	// start is the time of the first sample pushed.
+	start float64
+}
+
+// newWindow returns a window over the last size samples, or over the
+// last span seconds if span is positive.
+func newWindow(size int, span float64, fields int) *window {
+	w := &window{
+		size:     size,
+		span:     span,
+		counters: make([]*counter, fields),
+	}
+	if span <= 0 {
+		w.keys = make([]uint64, 0, (size+1)*fields)
+	}
+	for f := range w.counters {
+		w.counters[f] = newCounter()
+	}
+	return w
+}
+
+// timed reports whether w is a time window.
+func (w *window) timed() bool {
+	return w.span > 0
+}
+
+// len returns the number of samples in the window.
+func (w *window) len() int {
+	return len(w.keys)/len(w.counters) - w.first
+}
+
+// push slides the window over a sample with the given field values at
+// time t, dropping the samples it leaves behind. t is ignored unless w is
+// a time window, whose times must not decrease.
+func (w *window) push(t float64, values []float64) error {
+	if w.timed() {
+		switch {
+		case math.IsNaN(t) || math.IsInf(t, 0):
+			return fmt.Errorf("invalid time %g", t)
+		case len(w.times) > 0 && t < w.times[len(w.times)-1]:
+			return fmt.Errorf("time %g before %g: times must not decrease", t, w.times[len(w.times)-1])
+		case len(w.times) == 0:
+			w.start = t
+		}
+		w.times = append(w.times, t)
+	}
+	for f, v := range values {
+		k := key(v)
+		w.keys = append(w.keys, k)
+		w.counters[f].add(k, 1)
+	}
+	for w.timed() && w.times[w.first] <= t-w.span || !w.timed() && w.len() > w.size {
+		w.drop()
+	}
+	return nil
+}
+
+// drop removes the oldest sample, compacting the storage once half of it
+// is dropped samples.
+func (w *window) drop() {
+	fields := len(w.counters)
+	for f, k := range w.keys[w.first*fields : (w.first+1)*fields] {
+		w.counters[f].add(k, -1)
+	}
+	w.first++
+	if n := w.len(); w.first >= n {
+		w.keys = append(w.keys[:0], w.keys[w.first*fields:]...)
+		if w.timed() {
+			w.times = append(w.times[:0], w.times[w.first:]...)
+		}
+		w.first = 0
+	}
+}
+
+// full reports whether the window covers as much as it can hold: size
+// samples, or span seconds since the first sample.
+func (w *window) full() bool {
+	if w.timed() {
+		return len(w.times) > 0 && w.times[len(w.times)-1]-w.start >= w.span
+	}
+	return w.len() == w.size
+}
+
+// entropies writes the entropy of each field in the window to dst and
+// returns it. For a time window dst holds one more value, the volume of
+// the window: log2 of the number of samples in it.
+func (w *window) entropies(dst []float64) []float64 {
+	n := w.len()
+	for f, c := range w.counters {
+		dst[f] = c.entropy(n)
+	}
+	if w.timed() {
+		dst[len(w.counters)] = math.Log2(float64(n))
+	}
+	return dst
+}
@@ -0,0 +1,46 @@
+package detectors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors shared by detector implementations, so callers can branch on
+// them with errors.Is whatever the algorithm. Implementations return them
+// directly or wrap them with their own sentinels and context.
+var (
+	// ErrNotTrained is returned for scoring, explaining or saving a
+	// detector before it has been fitted or loaded.
+	ErrNotTrained = errors.New("model not trained")
+
+	// ErrDimensionMismatch is matched by every *DimensionError.
+	ErrDimensionMismatch = errors.New("feature count mismatch")
+
+	// ErrInvalidOption is matched by option errors of every detector,
+	// such as *iforest.OptionError. Detectors return them from Validate
+	// and, before training, from Fit.
+	ErrInvalidOption = errors.New("invalid option")
+
+	// ErrModelVersion is returned by Load for models written in a format
+	// version this build does not read.
+	ErrModelVersion = errors.New("unsupported model format version")
+
+	// ErrChecksum is returned by Load for models whose content does not
+	// match their checksum: the file was corrupted or truncated.
+	ErrChecksum = errors.New("model checksum mismatch")
+)
+
+// DimensionError reports a sample with a different number of features
+// than the model was trained on.
+type DimensionError struct {
+	Got, Want int
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("sample has %d features, model expects %d", e.Got, e.Want)
+}
+
+// Unwrap makes errors.Is(err, ErrDimensionMismatch) hold.
+func (e *DimensionError) Unwrap() error {
+	return ErrDimensionMismatch
+}
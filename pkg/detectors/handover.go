@@ -0,0 +1,320 @@
+package detectors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// HandoverReport describes a completed handover.
+type HandoverReport struct {
+	// Started and Completed bound the warm-up.
+	Started   time.Time `json:"started"`
+	Completed time.Time `json:"completed"`
+	// Samples is the number of live samples the candidate scored while
+	// warming up.
+	Samples int `json:"samples"`
+	// LiveAnomalyRate is the share of those samples the outgoing detector
+	// flagged.
+	LiveAnomalyRate float64 `json:"live_anomaly_rate"`
+	// TargetRate is the anomaly rate the threshold was calibrated to.
+	TargetRate float64 `json:"target_rate"`
+	// Threshold is the candidate's calibrated threshold.
+	Threshold float64 `json:"threshold"`
+}
+
+// HandoverStatus describes a candidate warming up.
+type HandoverStatus struct {
+	Started time.Time `json:"started"`
+	Samples int       `json:"samples"`
+	// Errors counts samples the candidate could not score.
+	Errors int `json:"errors"`
+}
+
+// HandoverOption configures a Handover.
+type HandoverOption func(*Handover)
+
+// WithWarmup sets how long a candidate shadow-scores live traffic before
+// it takes over, 10 minutes by default.
+func WithWarmup(d time.Duration) HandoverOption {
+	return func(h *Handover) {
+		h.warmup = d
+	}
+}
+
+// WithWarmupSamples sets how many live samples a candidate must score
+// before it takes over, however long that takes, 1000 by default.
+func WithWarmupSamples(n int) HandoverOption {
+	return func(h *Handover) {
+		h.minSamples = n
+	}
+}
+
+// WithTargetRate calibrates candidates to flag this share of the warm-up
+// samples. By default they are calibrated to the share the outgoing
+// detector flagged, so a handover does not change the alert volume.
+func WithTargetRate(rate float64) HandoverOption {
+	return func(h *Handover) {
+		h.targetRate = rate
+	}
+}
+
+// WithHandoverHandler sets a function called with the report of every
+// completed handover. It runs on the streaming goroutine.
+func WithHandoverHandler(fn func(HandoverReport)) HandoverOption {
+	return func(h *Handover) {
+		h.onHandover = fn
+	}
+}
+
+// Handover is a StreamDetector whose model can be replaced while it
+// streams, without pausing the stream or an alert spike from an
+// uncalibrated threshold. A candidate passed to Stage first shadow-scores
+// live samples for the warm-up period; its threshold is then calibrated
+// on those scores and it atomically replaces the live detector, between
+// two samples.
+//
+// PredictStream scores samples one at a time with PredictOne, so
+// stream-only features of the live detector, such as the explanations and
+// severities of iforest.WithExplanations and WithSeverityBands, are not
+// applied. Batch calls go to the live detector and do not warm up
+// candidates.
+type Handover struct {
+	live atomic.Pointer[Detector]
+
+	warmup     time.Duration
+	minSamples int
+	targetRate float64
+	onHandover func(HandoverReport)
+	now        func() time.Time
+
+	mu       sync.Mutex
+	staged   *candidate
+	onReject RejectFunc
+}
+
+// candidate is a detector warming up.
+type candidate struct {
+	d       Detector
+	status  HandoverStatus
+	scores  *stats.QuantileEstimator
+	flagged int // by the live detector
+}
+
+// NewHandover returns a Handover streaming with live until a candidate
+// takes over.
+func NewHandover(live Detector, opts ...HandoverOption) *Handover {
+	h := &Handover{
+		warmup:     10 * time.Minute,
+		minSamples: 1000,
+		targetRate: -1,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.live.Store(&live)
+	return h
+}
+
+// ErrNotCalibratable is returned by Stage for candidates without an
+// adjustable threshold.
+var ErrNotCalibratable = errors.New("candidate has no adjustable threshold")
+
+// Stage starts warming up candidate, replacing any candidate still
+// warming up. It is safe to call while streaming.
+func (h *Handover) Stage(d Detector) error {
+	if _, ok := d.(Thresholder); !ok {
+		return ErrNotCalibratable
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.staged = &candidate{
+		d:      d,
+		status: HandoverStatus{Started: h.now()},
+		scores: stats.NewQuantileEstimator(-1, 0),
+	}
+	return nil
+}
+
+// Staged returns the status of the candidate warming up, if any.
+func (h *Handover) Staged() (HandoverStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.staged == nil {
+		return HandoverStatus{}, false
+	}
+	return h.staged.status, true
+}
+
+// Live returns the detector currently scoring.
+func (h *Handover) Live() Detector {
+	return *h.live.Load()
+}
+
+// PredictStream scores samples with the live detector until input is
+// closed or ctx is done, warming up and handing over to staged
+// candidates on the way. Samples the live detector cannot score go to
+// the reject handler. The output channel is closed when PredictStream
+// returns.
+func (h *Handover) PredictStream(ctx context.Context, input <-chan []float64, output chan<- Score) error {
+	defer close(output)
+	for {
+		var sample []float64
+		select {
+		case s, ok := <-input:
+			if !ok {
+				return nil
+			}
+			sample = s
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		live := h.Live()
+		v, err := live.PredictOne(sample)
+		if err != nil {
+			h.mu.Lock()
+			fn := h.onReject
+			h.mu.Unlock()
+			if fn != nil {
+				fn(Rejection{Sample: sample, Err: err})
+			}
+			continue
+		}
+		score := Score{Value: v, IsAnomaly: v >= ThresholdOf(live), Features: sample}
+		h.warm(sample, score)
+
+		select {
+		case output <- score:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// warm scores sample with the staged candidate, if any, and hands over
+// once its warm-up is complete.
+func (h *Handover) warm(sample []float64, live Score) {
+	h.mu.Lock()
+	c := h.staged
+	h.mu.Unlock()
+	if c == nil {
+		return
+	}
+	v, err := c.d.PredictOne(sample)
+
+	h.mu.Lock()
+	if h.staged != c {
+		// Replaced by Stage meanwhile.
+		h.mu.Unlock()
+		return
+	}
+	if err != nil {
+		c.status.Errors++
+	} else {
+		c.scores.Add(v)
+		c.status.Samples++
+		if live.IsAnomaly {
+			c.flagged++
+		}
+	}
+	now := h.now()
+	if c.status.Samples < h.minSamples || now.Sub(c.status.Started) < h.warmup {
+		h.mu.Unlock()
+		return
+	}
+	h.staged = nil
+	h.mu.Unlock()
+
+	report := c.calibrate(h.targetRate)
+	report.Completed = now
+	h.live.Store(&c.d)
+	if h.onHandover != nil {
+		h.onHandover(report)
+	}
+}
+
+// calibrate sets the threshold of the candidate so it flags targetRate
+// of its warm-up scores, or the live detector's rate if negative.
+func (c *candidate) calibrate(targetRate float64) HandoverReport {
+	n := c.status.Samples
+	report := HandoverReport{
+		Started:         c.status.Started,
+		Samples:         n,
+		LiveAnomalyRate: float64(c.flagged) / float64(n),
+		TargetRate:      targetRate,
+	}
+	if targetRate < 0 {
+		report.TargetRate = report.LiveAnomalyRate
+	}
+	threshold := c.scores.Quantile(1 - report.TargetRate)
+	if report.TargetRate == 0 {
+		// Flag nothing the warm-up saw, not the highest score.
+		threshold = math.Nextafter(threshold, math.Inf(1))
+	}
+	c.d.(Thresholder).SetThreshold(threshold)
+	report.Threshold = threshold
+	return report
+}
+
+var (
+	_ StreamDetector = (*Handover)(nil)
+	_ RejectReporter = (*Handover)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples the live
+// detector cannot score to.
+func (h *Handover) SetRejectHandler(fn RejectFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onReject = fn
+}
+
+var _ Thresholder = (*Handover)(nil)
+
+// Threshold returns the live detector's threshold.
+func (h *Handover) Threshold() float64 {
+	return ThresholdOf(h.Live())
+}
+
+// SetThreshold sets the live detector's threshold, if it has an
+// adjustable one. A later handover replaces it with the candidate's
+// calibrated threshold.
+func (h *Handover) SetThreshold(t float64) {
+	if th, ok := h.Live().(Thresholder); ok {
+		th.SetThreshold(t)
+	}
+}
+
+// Fit trains the live detector.
+func (h *Handover) Fit(data [][]float64) error { return h.Live().Fit(data) }
+
+// Predict scores data with the live detector.
+func (h *Handover) Predict(data [][]float64) ([]float64, error) { return h.Live().Predict(data) }
+
+// PredictContext scores data with the live detector.
+func (h *Handover) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	return h.Live().PredictContext(ctx, data)
+}
+
+// PredictOne scores sample with the live detector.
+func (h *Handover) PredictOne(sample []float64) (float64, error) { return h.Live().PredictOne(sample) }
+
+// Save serializes the live detector.
+func (h *Handover) Save() ([]byte, error) { return h.Live().Save() }
+
+// Load loads a model into the live detector.
+func (h *Handover) Load(data []byte) error { return h.Live().Load(data) }
+
+// SaveTo writes the live detector to w.
+func (h *Handover) SaveTo(w io.Writer) error { return h.Live().SaveTo(w) }
+
+// LoadFrom reads a model into the live detector from r.
+func (h *Handover) LoadFrom(r io.Reader) error { return h.Live().LoadFrom(r) }
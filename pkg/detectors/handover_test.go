@@ -0,0 +1,93 @@
+package detectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandover(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	live := &linear{scale: 1, threshold: 9}
+	var reports []HandoverReport
+	h := NewHandover(live,
+		WithWarmup(time.Minute),
+		WithWarmupSamples(10),
+		WithHandoverHandler(func(r HandoverReport) { reports = append(reports, r) }))
+	h.now = func() time.Time { return now }
+
+	// The candidate scores ten times higher; uncalibrated, its threshold
+	// would flag everything.
+	candidate := &linear{scale: 10, threshold: 0.5}
+	require.NoError(t, h.Stage(candidate))
+
+	var samples [][]float64
+	for i := range 10 {
+		samples = append(samples, []float64{float64(i)})
+	}
+	scores := stream(t, h, samples...)
+	require.Len(t, scores, 10)
+	assert.True(t, scores[9].IsAnomaly)
+	assert.False(t, scores[8].IsAnomaly)
+	status, ok := h.Staged()
+	require.True(t, ok, "the warm-up period has not elapsed")
+	assert.Equal(t, 10, status.Samples)
+	assert.Same(t, live, h.Live())
+
+	now = now.Add(time.Minute)
+	stream(t, h, []float64{5})
+	_, ok = h.Staged()
+	assert.False(t, ok)
+	assert.Same(t, candidate, h.Live())
+	require.Len(t, reports, 1)
+	r := reports[0]
+	assert.Equal(t, 11, r.Samples)
+	assert.InDelta(t, 1.0/11, r.LiveAnomalyRate, 1e-12)
+	assert.Equal(t, r.LiveAnomalyRate, r.TargetRate)
+	assert.Equal(t, candidate.Threshold(), r.Threshold)
+	assert.Equal(t, now, r.Completed)
+
+	// The candidate flags about the live detector's share of the warm-up
+	// rather than all of it.
+	scores = stream(t, h, samples...)
+	require.Len(t, scores, 10)
+	assert.True(t, scores[9].IsAnomaly)
+	assert.False(t, scores[7].IsAnomaly)
+	assert.Equal(t, 90.0, scores[9].Value)
+}
+
+func TestHandoverTargetRate(t *testing.T) {
+	h := NewHandover(&linear{scale: 1, threshold: 100}, WithWarmup(0), WithWarmupSamples(4), WithTargetRate(0))
+	candidate := &linear{scale: 1}
+	require.NoError(t, h.Stage(candidate))
+	stream(t, h, []float64{1}, []float64{2}, []float64{3}, []float64{4})
+	assert.Same(t, candidate, h.Live())
+	assert.Greater(t, candidate.Threshold(), 4.0, "a zero rate flags none of the warm-up")
+	assert.Less(t, candidate.Threshold(), 4.0001)
+}
+
+func TestHandoverRejects(t *testing.T) {
+	h := NewHandover(&linear{scale: 1, threshold: 1}, WithWarmup(0), WithWarmupSamples(2))
+	var rejected []Rejection
+	h.SetRejectHandler(func(r Rejection) { rejected = append(rejected, r) })
+	require.NoError(t, h.Stage(&linear{scale: 2}))
+
+	scores := stream(t, h, []float64{}, []float64{1})
+	assert.Len(t, scores, 1)
+	assert.Len(t, rejected, 1)
+	status, ok := h.Staged()
+	require.True(t, ok)
+	assert.Equal(t, 1, status.Samples, "rejected samples do not warm up candidates")
+}
+
+// fixed has no adjustable threshold.
+type fixed struct{ Detector }
+
+func TestHandoverStageNeedsThreshold(t *testing.T) {
+	h := NewHandover(&linear{})
+	assert.ErrorIs(t, h.Stage(fixed{}), ErrNotCalibratable)
+	_, ok := h.Staged()
+	assert.False(t, ok)
+}
@@ -0,0 +1,124 @@
+package hbos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved HBOS models.
+var saveFormat = container.Format{Name: "hbos", Model: "HBOS", Magic: "GGHBSAVE", Version: 1}
+
+// savedModel holds the options and the histograms of a saved HBOS model.
+type savedModel struct {
+	Bins          int
+	MaxBins       int
+	Contamination float64
+	Threshold     float64
+	Scale         float64
+	Histograms    []savedHistogram
+	Importances   []float64
+	Card          container.Card
+}
+
+// savedHistogram is the serialized form of histogram.
+type savedHistogram struct {
+	Low     float64
+	Width   float64
+	Penalty []float64
+	Outside float64
+}
+
+// Save serializes the histograms.
+func (h *HBOS) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := h.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (h *HBOS) SaveTo(w io.Writer) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Bins:          h.bins,
+		MaxBins:       h.maxBins,
+		Contamination: h.contamination,
+		Threshold:     h.threshold,
+		Scale:         h.scale,
+		Histograms:    make([]savedHistogram, len(h.hists)),
+		Importances:   h.importances,
+		Card:          container.NewCard(h.card),
+	}
+	for j, hist := range h.hists {
+		m.Histograms[j] = savedHistogram{Low: hist.low, Width: hist.width, Penalty: hist.penalty, Outside: hist.outside}
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the histograms written by Save.
+func (h *HBOS) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	hists, err := m.histograms()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bins, h.maxBins = m.Bins, m.MaxBins
+	h.contamination, h.threshold = m.Contamination, m.Threshold
+	h.scale = m.Scale
+	h.hists = hists
+	h.importances = m.Importances
+	h.card = m.Card.ModelCard()
+	h.featureNames = h.card.FeatureNames
+	h.trained = true
+	return nil
+}
+
+// LoadFrom restores the histograms read from r.
+func (h *HBOS) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return h.Load(data)
+}
+
+// histograms validates and returns the saved histograms.
+func (m *savedModel) histograms() ([]histogram, error) {
+	if len(m.Histograms) == 0 {
+		return nil, errors.New("hbos: model has no features")
+	}
+	if !(m.Scale > 0) || math.IsInf(m.Scale, 0) {
+		return nil, fmt.Errorf("hbos: invalid score scale %g", m.Scale)
+	}
+	hists := make([]histogram, len(m.Histograms))
+	for j, s := range m.Histograms {
+		// Features never finite in training have no bins, constant ones a
+		// single bin of zero width.
+		valid := s.Width == 0 && len(s.Penalty) <= 1 ||
+			s.Width > 0 && !math.IsInf(s.Width, 0) && len(s.Penalty) > 0
+		if !valid {
+			return nil, fmt.Errorf("hbos: feature %d: invalid histogram", j)
+		}
+		hists[j] = histogram{low: s.Low, width: s.Width, penalty: s.Penalty, outside: s.Outside}
+	}
+	return hists, nil
+}
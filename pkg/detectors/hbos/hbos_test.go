@@ -0,0 +1,158 @@
+package hbos
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// normalData returns n samples of three features: a standard normal, a
+// wide uniform and a feature taking few values.
+func normalData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), rng.Float64() * 1000, float64(rng.Intn(3))}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*HBOS, Option]{
+		Algorithm:        "hbos",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithContamination(0.05)},
+		Invalid:          []Option{WithBins(-1), WithMaxBins(0), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            normalData(1000, 6),
+		Probe:            append(normalData(20, 7), []float64{8, 500, 1}),
+		AcceptsNonFinite: true,
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	h := New()
+	require.NoError(t, h.Fit(normalData(2000, 1)))
+
+	scores, err := h.Predict([][]float64{
+		{0, 500, 1},
+		{8, 500, 1},
+		{0, 5000, 1},
+		{0, 500, 7},
+		{math.NaN(), 500, 1},
+	})
+	require.NoError(t, err)
+	for _, s := range scores {
+		assert.GreaterOrEqual(t, s, 0.0)
+		assert.Less(t, s, 1.0)
+	}
+	assert.Less(t, scores[0], h.Threshold(), "a typical sample is normal")
+	for i, s := range scores[1:] {
+		assert.Greater(t, s, h.Threshold(), "sample %d", i+1)
+	}
+
+	// Contamination sets the threshold to flag about 10% of training.
+	trainScores, err := h.Predict(normalData(2000, 1))
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range trainScores {
+		if s >= h.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 200, flagged, 40)
+
+	one, err := h.PredictOne([]float64{8, 500, 1})
+	require.NoError(t, err)
+	assert.Equal(t, scores[1], one)
+}
+
+func TestBins(t *testing.T) {
+	h := New()
+	data := normalData(5000, 2)
+	for _, row := range data {
+		row[2] = 4 // constant
+	}
+	require.NoError(t, h.Fit(data))
+	bins := h.Bins()
+	require.Len(t, bins, 3)
+	assert.Greater(t, bins[0], 10)
+	assert.Greater(t, bins[1], 10)
+	assert.NotEqual(t, bins[0], bins[1], "bin counts follow each feature's spread")
+	assert.Equal(t, 1, bins[2])
+
+	score, err := h.PredictOne([]float64{0, 500, 5})
+	require.NoError(t, err)
+	assert.Greater(t, score, h.Threshold(), "any other value of a constant feature is rare")
+
+	fixed := New(WithBins(7))
+	require.NoError(t, fixed.Fit(normalData(500, 3)))
+	assert.Equal(t, []int{7, 7, 7}, fixed.Bins())
+
+	capped := New(WithMaxBins(4))
+	require.NoError(t, capped.Fit(normalData(500, 3)))
+	for _, b := range capped.Bins() {
+		assert.LessOrEqual(t, b, 4)
+	}
+	assert.Nil(t, New().Bins())
+
+	// Spreads so wide that the Freedman-Diaconis count overflows.
+	wide := normalData(500, 4)
+	wide[0][0], wide[1][0] = -math.MaxFloat64, math.MaxFloat64
+	wide[2][1], wide[3][1] = -1e300, 1e300
+	h = New()
+	require.NoError(t, h.Fit(wide))
+	scores, err := h.Predict(wide[:4])
+	require.NoError(t, err)
+	for _, score := range scores {
+		assert.False(t, math.IsNaN(score) || math.IsInf(score, 0))
+	}
+	saved, err := h.Save()
+	require.NoError(t, err)
+	require.NoError(t, New().Load(saved), "bin widths stay finite")
+	assert.Equal(t, 256, binCount([]float64{-math.MaxFloat64, 0, 1, 2, math.MaxFloat64}, 256))
+	assert.Equal(t, 256, binCount([]float64{-1e300, 0, 1e-300, 2e-300, 1e300}, 256))
+}
+
+func TestExplain(t *testing.T) {
+	h := New()
+	require.NoError(t, h.Fit(normalData(1000, 5)))
+
+	exp, err := h.Explain([]float64{0, 5000, 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	importances := h.FeatureImportances()
+	assert.Less(t, importances[2], importances[1], "few-valued features are rarely rare")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := normalData(10000, 1)
+	h := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Fit(data)
+	}
+}
+
+func BenchmarkPredictOne(b *testing.B) {
+	h := New()
+	h.Fit(normalData(5000, 1))
+	sample := []float64{0.5, 300, 2}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.PredictOne(sample)
+	}
+}
@@ -0,0 +1,125 @@
+package holtwinters
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved Holt-Winters models.
+var saveFormat = container.Format{Name: "holtwinters", Model: "Holt-Winters", Magic: "GGHWSAVE", Version: 1}
+
+// savedModel holds the options and the smoothing weights and the state the
+// training series ends in of a saved Holt-Winters model.
+type savedModel struct {
+	Season             int
+	Alpha, Beta, Gamma float64
+	// Fixed reports smoothing weights set by WithSmoothing rather than
+	// chosen by Fit.
+	Fixed         bool
+	Contamination float64
+	Threshold     float64
+	Sigma         float64
+	// Level, Trend, Seasonal and Phase are the state after the last
+	// training value.
+	Level, Trend float64
+	Seasonal     []float64
+	Phase        int
+	Card         container.Card
+}
+
+// Save serializes the smoothing weights and the state the training series
+// ends in.
+func (d *HoltWinters) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (d *HoltWinters) SaveTo(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Season:        d.season,
+		Alpha:         d.weights.alpha,
+		Beta:          d.weights.beta,
+		Gamma:         d.weights.gamma,
+		Fixed:         d.fixed,
+		Contamination: d.contamination,
+		Threshold:     d.threshold,
+		Sigma:         d.sigma,
+		Level:         d.state.level,
+		Trend:         d.state.trend,
+		Seasonal:      d.state.seasonal,
+		Phase:         d.state.phase,
+		Card:          container.NewCard(d.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the smoothing weights and the state the training series
+// ends in written by Save.
+func (d *HoltWinters) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.season = m.Season
+	d.weights = smoothing{alpha: m.Alpha, beta: m.Beta, gamma: m.Gamma}
+	d.fixed = m.Fixed
+	d.contamination, d.threshold = m.Contamination, m.Threshold
+	d.sigma = m.Sigma
+	d.state = state{level: m.Level, trend: m.Trend, seasonal: m.Seasonal, phase: m.Phase}
+	d.card = m.Card.ModelCard()
+	d.featureNames = d.card.FeatureNames
+	d.trained = true
+	return nil
+}
+
+// LoadFrom restores the smoothing weights and the state the training series
+// ends in read from r.
+func (d *HoltWinters) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Load(data)
+}
+
+// validate checks the saved smoothing weights and the state the training
+// series ends in.
+func (m *savedModel) validate() error {
+	switch {
+	case m.Season < 2 || len(m.Seasonal) != m.Season || m.Phase < 0 || m.Phase >= m.Season:
+		return errors.New("holtwinters: invalid season")
+	case !(m.Alpha > 0 && m.Alpha <= 1 && m.Beta >= 0 && m.Beta <= 1 && m.Gamma >= 0 && m.Gamma <= 1):
+		return errors.New("holtwinters: invalid smoothing weights")
+	case !(m.Sigma > 0) || math.IsInf(m.Sigma, 0):
+		return fmt.Errorf("holtwinters: invalid residual scale %g", m.Sigma)
+	}
+	for _, v := range append([]float64{m.Level, m.Trend}, m.Seasonal...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("holtwinters: model has non-finite values")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,537 @@
+// Package holtwinters implements a seasonal Holt-Winters forecasting
+// detector for univariate time series.
+//
+// Samples are the values of a series in time order, one feature per row.
+// The detector keeps an additive Holt-Winters model of the series, a
+// level, a trend and a seasonal component for each position in a season
+// of WithSeason values, forecasts each value from the values before it,
+// and scores the residual of the value from its forecast. Metrics with a
+// strong daily or weekly rhythm, whose peaks a detector comparing values
+// alone would flag every day, score by how far they stray from the peak
+// expected at that time instead. Residuals beyond three residual scales
+// update the model as if they were on that bound, so an anomaly does not
+// drag the forecasts of the values after it.
+//
+// Fit estimates the initial state from the first two seasons of the
+// training series and runs the model over the rest. Unless WithSmoothing
+// fixes them, the smoothing weights are those of a grid that forecast the
+// training series best. The residuals of the training series after its
+// first two seasons set the residual scale and the threshold.
+//
+// Predict scores a batch as the continuation of the training series, from
+// the state the training series left; batches do not change the model.
+// PredictStream carries its own state from one sample to the next.
+// Non-finite values score 1 and advance the state with their forecast.
+package holtwinters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// season, smoothing weights or contamination.
+var ErrInvalidOption = fmt.Errorf("holtwinters: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of values scored between context checks.
+const scoreChunk = 1024
+
+// madScale makes the median absolute deviation of normal residuals
+// estimate their standard deviation; meanADScale does the same for the
+// mean absolute deviation.
+const (
+	madScale    = 1.4826
+	meanADScale = 1.2533
+)
+
+// The smoothing weights Fit chooses from unless WithSmoothing fixes them.
+var (
+	alphas = []float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.7, 0.9}
+	betas  = []float64{0, 0.01, 0.05, 0.1, 0.2}
+	gammas = []float64{0.05, 0.1, 0.2, 0.3, 0.5}
+)
+
+// HoltWinters is a seasonal forecasting detector. It is safe for
+// concurrent use.
+type HoltWinters struct {
+	mu sync.RWMutex
+
+	// Configuration
+	season        int
+	weights       smoothing
+	fixed         bool
+	contamination float64
+	threshold     float64
+	workers       int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	// state is the model after the last training value.
+	state state
+	// sigma is the scale of the training residuals.
+	sigma   float64
+	card    detectors.ModelCard
+	trained bool
+}
+
+// Option configures a HoltWinters.
+type Option func(*HoltWinters)
+
+// WithSeason sets the number of values in a season, 24 by default: a
+// daily rhythm in hourly values. Use 168 for a weekly rhythm in hourly
+// values, or 1440 for a daily one in values per minute. It must be at
+// least 2.
+func WithSeason(n int) Option {
+	return func(d *HoltWinters) {
+		d.season = n
+	}
+}
+
+// WithSmoothing fixes the weights given to the newest value when updating
+// the level (alpha, in (0, 1]), the trend (beta, in [0, 1]) and the
+// seasonal components (gamma, in [0, 1]). Higher weights follow changes
+// faster and forget the past sooner. By default Fit chooses them.
+func WithSmoothing(alpha, beta, gamma float64) Option {
+	return func(d *HoltWinters) {
+		d.weights = smoothing{alpha: alpha, beta: beta, gamma: gamma}
+		d.fixed = true
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.01 by
+// default. Fit sets the threshold to flag that fraction of the training
+// values it scores; 0 keeps the threshold, at which a value three
+// residual scales from its forecast scores.
+func WithContamination(c float64) Option {
+	return func(d *HoltWinters) {
+		d.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines Fit tries smoothing weights
+// on. n <= 0, the default, uses detectors.DefaultWorkers at each call.
+func WithWorkers(n int) Option {
+	return func(d *HoltWinters) {
+		d.workers = n
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(d *HoltWinters) {
+		d.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new HoltWinters.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(d *HoltWinters) {
+		d.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(d *HoltWinters) {
+		d.dataSource = source
+	}
+}
+
+// WithFeatureNames records the name of the series in the model card. Fit
+// fails unless exactly one name is given.
+func WithFeatureNames(names []string) Option {
+	return func(d *HoltWinters) {
+		d.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained HoltWinters with the given options.
+func New(opts ...Option) *HoltWinters {
+	d := &HoltWinters{
+		season:        24,
+		contamination: 0.01,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.workers = max(d.workers, 0)
+	return d
+}
+
+// Validate checks the season, smoothing weights and contamination set by
+// the options.
+func (d *HoltWinters) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validate()
+}
+
+func (d *HoltWinters) validate() error {
+	var errs []error
+	if d.season < 2 {
+		errs = append(errs, fmt.Errorf("%w: WithSeason(%d): must be at least 2", ErrInvalidOption, d.season))
+	}
+	if w := d.weights; d.fixed && !(w.alpha > 0 && w.alpha <= 1 && w.beta >= 0 && w.beta <= 1 && w.gamma >= 0 && w.gamma <= 1) {
+		errs = append(errs, fmt.Errorf("%w: WithSmoothing(%g, %g, %g): alpha must be in (0, 1], beta and gamma in [0, 1]", ErrInvalidOption, w.alpha, w.beta, w.gamma))
+	}
+	if !(d.contamination >= 0 && d.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, d.contamination))
+	}
+	if d.severity != nil {
+		if err := d.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit trains the model on the training series, one value per row in time
+// order. Values must be finite and the series at least three seasons
+// long: two to estimate the initial state from and one to score.
+func (d *HoltWinters) Fit(data [][]float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	if nFeatures := len(data[0]); nFeatures != 1 {
+		return fmt.Errorf("training data has %d features: Holt-Winters forecasts a single series", nFeatures)
+	}
+	if d.featureNames != nil && len(d.featureNames) != 1 {
+		return fmt.Errorf("%d feature names for 1 feature", len(d.featureNames))
+	}
+	if len(data) < 3*d.season {
+		return fmt.Errorf("%d training values for seasons of %d: need at least three seasons", len(data), d.season)
+	}
+	values := make([]float64, len(data))
+	for i, row := range data {
+		if len(row) != 1 {
+			return fmt.Errorf("row %d has %d features, expected 1", i, len(row))
+		}
+		if math.IsNaN(row[0]) || math.IsInf(row[0], 0) {
+			return fmt.Errorf("row %d feature 0 is not finite", i)
+		}
+		values[i] = row[0]
+	}
+
+	weights := d.weights
+	if !d.fixed {
+		weights = d.choose(values)
+	}
+	// The residual scale comes from unclipped residuals, which then
+	// clip the residuals of the model kept. Both skip the season after
+	// the two the initial state is estimated from.
+	season := d.season
+	residuals := make([]float64, len(values)-season)
+	st := initial(values, season)
+	st.filter(values[season:], weights, 0, residuals)
+	sigma := scale(slices.Clone(residuals[season:]))
+	st = initial(values, season)
+	st.filter(values[season:], weights, sigma, residuals)
+	// Values near the float64 limits overflow the forecasts, which would
+	// leave a model Load rejects.
+	if !st.finite() || math.IsInf(sigma, 0) {
+		return errors.New("training values too large to forecast: the smoothed state overflows")
+	}
+	d.weights, d.sigma, d.state = weights, sigma, st
+	d.trained = true
+
+	if d.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(residuals)-season, 100)
+		for _, r := range residuals[season:] {
+			est.Add(d.score(r))
+		}
+		d.threshold = est.Quantile(1 - d.contamination)
+	}
+	d.card = d.modelCard(data)
+	return nil
+}
+
+// choose returns the smoothing weights of the grid whose unclipped
+// one-step forecasts of values, after their first two seasons, have the
+// least mean absolute error.
+func (d *HoltWinters) choose(values []float64) smoothing {
+	var grid []smoothing
+	for _, a := range alphas {
+		for _, b := range betas {
+			for _, g := range gammas {
+				grid = append(grid, smoothing{alpha: a, beta: b, gamma: g})
+			}
+		}
+	}
+	season := d.season
+	errs := make([]float64, len(grid))
+	detectors.ParallelFor(len(grid), detectors.Workers(d.workers), 1, func(lo, hi int) {
+		residuals := make([]float64, len(values)-season)
+		for i := lo; i < hi; i++ {
+			st := initial(values, season)
+			st.filter(values[season:], grid[i], 0, residuals)
+			for _, r := range residuals[season:] {
+				errs[i] += math.Abs(r)
+			}
+			if math.IsNaN(errs[i]) {
+				errs[i] = math.Inf(1)
+			}
+		}
+	})
+	return grid[slices.Index(errs, slices.Min(errs))]
+}
+
+// scale returns the scaled median absolute value of residuals, or the
+// scaled mean absolute value if the median is zero, and a tiny positive
+// scale if both are. It reorders residuals.
+func scale(residuals []float64) float64 {
+	var mean float64
+	for i, r := range residuals {
+		residuals[i] = math.Abs(r)
+		mean += residuals[i]
+	}
+	mean /= float64(len(residuals))
+	if mad := stats.Select(residuals, len(residuals)/2); mad > 0 {
+		return madScale * mad
+	}
+	if mean > 0 {
+		return meanADScale * mean
+	}
+	return math.SmallestNonzeroFloat64
+}
+
+// score maps a residual to [0, 1]: 0.5 at three residual scales from the
+// forecast, rising toward 1 beyond.
+func (d *HoltWinters) score(r float64) float64 {
+	r = math.Abs(r)
+	if math.IsInf(r, 1) {
+		return 1
+	}
+	return r / (r + clip*d.sigma)
+}
+
+// Predict returns anomaly scores for the given samples.
+func (d *HoltWinters) Predict(data [][]float64) ([]float64, error) {
+	return d.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, scoring the batch in order and checking ctx
+// every thousand samples.
+func (d *HoltWinters) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != 1 {
+			return nil, fmt.Errorf("sample %d: %w", i, d.dimensionError(sample))
+		}
+	}
+	st := d.state.clone()
+	scores := make([]float64, len(data))
+	for i, sample := range data {
+		if i%scoreChunk == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		scores[i] = d.score(st.update(sample[0], d.weights, d.sigma))
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score of sample as the value right after
+// the training series.
+func (d *HoltWinters) PredictOne(sample []float64) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != 1 {
+		return 0, d.dimensionError(sample)
+	}
+	return d.score(sample[0] - d.state.forecast(1)), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (d *HoltWinters) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: 1}
+}
+
+// Forecast returns the forecasts of the h values after the training
+// series.
+func (d *HoltWinters) Forecast(h int) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	out := make([]float64, max(h, 0))
+	for i := range out {
+		out[i] = d.state.forecast(i + 1)
+	}
+	return out, nil
+}
+
+// Smoothing returns the level, trend and seasonal smoothing weights, as
+// set by WithSmoothing or chosen by Fit.
+func (d *HoltWinters) Smoothing() (alpha, beta, gamma float64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.weights.alpha, d.weights.beta, d.weights.gamma
+}
+
+// stream is the state PredictStream carries between samples and the
+// seasonal components of the model it was started from.
+type stream struct {
+	from  []float64
+	state state
+}
+
+// PredictStream scores samples from input, each the next value of the
+// series that continues the training series, until input is closed.
+func (d *HoltWinters) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	d.mu.RLock()
+	if !d.trained {
+		d.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := d.onReject
+	d.mu.RUnlock()
+
+	var st stream
+	return streamer.Run(ctx, input, output, reject, func(sample []float64) (detectors.Score, error) {
+		return d.streamScore(&st, sample)
+	})
+}
+
+// streamScore scores one streamed sample under one read lock, advancing
+// the stream's state, which it copies from the model on the first sample
+// and again if the model changed since.
+func (d *HoltWinters) streamScore(st *stream, sample []float64) (detectors.Score, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(sample) != 1 {
+		return detectors.Score{}, d.dimensionError(sample)
+	}
+	if st.from == nil || &st.from[0] != &d.state.seasonal[0] {
+		st.from = d.state.seasonal
+		st.state = d.state.clone()
+	}
+	score := d.score(st.state.update(sample[0], d.weights, d.sigma))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= d.threshold,
+		Features:  sample,
+	}
+	if d.severity != nil {
+		result.Severity = d.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*HoltWinters)(nil)
+	_ detectors.Thresholder    = (*HoltWinters)(nil)
+	_ detectors.RejectReporter = (*HoltWinters)(nil)
+	_ detectors.Describer      = (*HoltWinters)(nil)
+	_ detectors.Sequential     = (*HoltWinters)(nil)
+)
+
+// Sequential reports true: a batch is scored as the continuation of the
+// training series, so it must not be split.
+func (d *HoltWinters) Sequential() bool {
+	return true
+}
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the smoothing weights and the state the training series
+// ends in to. Rejected samples do not advance the series.
+func (d *HoltWinters) SetRejectHandler(fn detectors.RejectFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReject = fn
+}
+
+// Metadata returns the model card recorded by Fit.
+func (d *HoltWinters) Metadata() detectors.ModelCard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	card := d.card
+	card.FeatureNames = slices.Clone(d.card.FeatureNames)
+	if d.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(d.card.Hyperparameters))
+		for k, v := range d.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (d *HoltWinters) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   d.dataSource,
+		Rows:         len(data),
+		Features:     1,
+		FeatureNames: slices.Clone(d.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "holtwinters",
+			"season":        strconv.Itoa(d.season),
+			"alpha":         strconv.FormatFloat(d.weights.alpha, 'g', -1, 64),
+			"beta":          strconv.FormatFloat(d.weights.beta, 'g', -1, 64),
+			"gamma":         strconv.FormatFloat(d.weights.gamma, 'g', -1, 64),
+			"contamination": strconv.FormatFloat(d.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(d.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (d *HoltWinters) Trained() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (d *HoltWinters) Threshold() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (d *HoltWinters) SetThreshold(t float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = t
+}
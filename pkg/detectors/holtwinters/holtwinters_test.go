@@ -0,0 +1,161 @@
+package holtwinters
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// period is the season of the series of wave: a day of hourly values.
+const period = 24
+
+// expected returns the noiseless value of the series of wave at time t.
+func expected(t int) float64 {
+	return 1000 + 0.1*float64(t) + 100*math.Sin(2*math.Pi*float64(t)/period)
+}
+
+// wave returns n values of a noisy, slowly rising sine wave of the given
+// period, starting at time start, one per row.
+func wave(start, n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{expected(start+i) + rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	probe := wave(1000, 300, 7)
+	probe[150][0] += 15
+	detectortest.Suite[*HoltWinters, Option]{
+		Algorithm:        "holtwinters",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		Options:          []Option{WithSeason(period)},
+		Configured:       []Option{WithSmoothing(0.3, 0.01, 0.2)},
+		Invalid:          []Option{WithSeason(1), WithSmoothing(0, 0.1, 1.5), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            wave(0, 1000, 6),
+		Probe:            probe,
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	d := New(WithSeason(period))
+	require.NoError(t, d.Fit(wave(0, 1000, 1)))
+
+	live := wave(1000, 500, 2)
+	live[200][0] += 15
+	live[350][0] -= 15
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+
+	flagged, peaks := 0, 0
+	for i, s := range scores {
+		if i != 200 && i != 350 && s >= d.Threshold() {
+			flagged++
+		}
+		if (1000+i)%period == period/4 && s >= d.Threshold() {
+			peaks++
+		}
+	}
+	assert.Less(t, flagged, 15, "the normal values are rarely flagged")
+	assert.Less(t, peaks, 2, "the daily peaks are expected")
+	assert.Greater(t, scores[200], d.Threshold(), "the spike is flagged")
+	assert.Greater(t, scores[350], d.Threshold(), "the dip is flagged")
+	for _, s := range scores[201:205] {
+		assert.Less(t, s, d.Threshold(), "the spike does not drag the forecasts after it")
+	}
+
+	// The first value follows the training series.
+	one, err := d.PredictOne(live[0])
+	require.NoError(t, err)
+	assert.Equal(t, scores[0], one)
+
+	// A NaN scores 1 and leaves the values after it unflagged.
+	holed := wave(1000, 50, 2)
+	holed[20] = []float64{math.NaN()}
+	withNaN, err := d.Predict(holed)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, withNaN[20])
+	assert.Less(t, withNaN[21], d.Threshold())
+}
+
+func TestForecast(t *testing.T) {
+	d := New(WithSeason(period))
+	require.NoError(t, d.Fit(wave(0, 1000, 3)))
+
+	forecast, err := d.Forecast(2 * period)
+	require.NoError(t, err)
+	require.Len(t, forecast, 2*period)
+	for i, f := range forecast {
+		assert.InDelta(t, expected(1000+i), f, 3, "forecast %d", i)
+	}
+
+	alpha, beta, gamma := d.Smoothing()
+	assert.Contains(t, alphas, alpha)
+	assert.Contains(t, betas, beta)
+	assert.Contains(t, gammas, gamma)
+
+	fixed := New(WithSeason(period), WithSmoothing(0.4, 0.05, 0.15))
+	require.NoError(t, fixed.Fit(wave(0, 1000, 3)))
+	alpha, beta, gamma = fixed.Smoothing()
+	assert.Equal(t, []float64{0.4, 0.05, 0.15}, []float64{alpha, beta, gamma})
+	assert.Equal(t, "0.4", fixed.Metadata().Hyperparameters["alpha"])
+}
+
+func TestErrors(t *testing.T) {
+	d := New()
+	_, err := d.Forecast(1)
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	assert.Error(t, d.Fit([][]float64{{1, 2}, {3, 4}}), "one series only")
+	assert.Error(t, d.Fit(wave(0, 3*24-1, 1)), "need three seasons")
+}
+
+func TestFitOverflow(t *testing.T) {
+	// The state overflows on values near the float64 limits; Fit rejects
+	// them rather than keep a model Load would reject.
+	d := New(WithSeason(period))
+	require.NoError(t, d.Fit(wave(0, 1000, 9)))
+	saved, err := d.Save()
+	require.NoError(t, err)
+
+	extremes := wave(0, 1000, 9)
+	extremes[0][0], extremes[1][0] = math.MaxFloat64, -math.MaxFloat64
+	assert.Error(t, d.Fit(extremes))
+	again, err := d.Save()
+	require.NoError(t, err)
+	assert.Equal(t, saved, again, "a failed Fit keeps the model")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := wave(0, 5000, 1)
+	d := New(WithSeason(period))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New(WithSeason(period))
+	d.Fit(wave(0, 5000, 1))
+	samples := wave(5000, 10000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
@@ -0,0 +1,103 @@
+package holtwinters
+
+import (
+	"math"
+	"slices"
+)
+
+// clip is the number of residual scales beyond which a residual is
+// clipped before it updates the state, and at which it scores 0.5.
+const clip = 3
+
+// smoothing holds the weights given to the newest value in the level,
+// trend and seasonal updates.
+type smoothing struct {
+	alpha, beta, gamma float64
+}
+
+// state is additive Holt-Winters state: the level and trend of the series
+// and the seasonal component of each position in the season, phase being
+// that of the next value.
+type state struct {
+	level, trend float64
+	seasonal     []float64
+	phase        int
+}
+
+// initial estimates the state from the first two seasons of values: the
+// level is the mean of the first, the trend the change in mean to the
+// second per value, and the seasonal components the departures of the
+// first season's values from its mean. The state is that after the first
+// season, so filtering resumes at values[season].
+func initial(values []float64, season int) state {
+	var first, second float64
+	for i := range season {
+		first += values[i]
+		second += values[season+i]
+	}
+	first /= float64(season)
+	second /= float64(season)
+	s := state{
+		level:    first,
+		trend:    (second - first) / float64(season),
+		seasonal: make([]float64, season),
+	}
+	for i := range season {
+		s.seasonal[i] = values[i] - first
+	}
+	return s
+}
+
+// clone returns a copy of s that shares no memory with it.
+func (s state) clone() state {
+	s.seasonal = slices.Clone(s.seasonal)
+	return s
+}
+
+// finite reports whether the level, trend and seasonal components of s
+// are all finite.
+func (s *state) finite() bool {
+	for _, v := range append([]float64{s.level, s.trend}, s.seasonal...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// forecast returns the forecast of the value h steps ahead, 1 for the
+// next.
+func (s *state) forecast(h int) float64 {
+	return s.level + float64(h)*s.trend + s.seasonal[(s.phase+h-1)%len(s.seasonal)]
+}
+
+// update advances the state by the value v and returns the residual of v
+// from its forecast. Residuals beyond clip·sigma update the state as if
+// they were on that bound, so an anomaly does not drag the forecasts of
+// the values after it; sigma 0 leaves them unclipped. A NaN or infinite v
+// scores as an infinite residual and updates the state with the forecast.
+func (s *state) update(v float64, w smoothing, sigma float64) float64 {
+	f := s.forecast(1)
+	r := v - f
+	x := v
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		r, x = math.Inf(1), f
+	case sigma > 0 && math.Abs(r) > clip*sigma:
+		x = f + math.Copysign(clip*sigma, r)
+	}
+	season := s.seasonal[s.phase]
+	level := w.alpha*(x-season) + (1-w.alpha)*(s.level+s.trend)
+	s.trend = w.beta*(level-s.level) + (1-w.beta)*s.trend
+	s.level = level
+	s.seasonal[s.phase] = w.gamma*(x-level) + (1-w.gamma)*season
+	s.phase = (s.phase + 1) % len(s.seasonal)
+	return r
+}
+
+// filter runs the state over values and writes their residuals to dst.
+func (s *state) filter(values []float64, w smoothing, sigma float64, dst []float64) {
+	for i, v := range values {
+		dst[i] = s.update(v, w, sigma)
+	}
+}
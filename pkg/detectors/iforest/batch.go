@@ -0,0 +1,128 @@
+package iforest
+
+import "math"
+
+// batchBlockSize is the number of samples scored together against each
+// tree. A block's samples and running path lengths stay in cache while a
+// tree is traversed for all of them.
+const batchBlockSize = 256
+
+// flatNode is a tree node in the compiled forest.
+type flatNode struct {
+	// feature is the split feature, or -1 for leaves.
+	feature int32
+	// left indexes the left child in flatForest.nodes; the right child
+	// follows it, so the next node is chosen without a branch.
+	left int32
+	// value is the split value of internal nodes. For leaves it is the
+	// full path length credited to samples reaching the leaf: its depth
+	// plus the expected path length of the remaining isolation.
+	value float64
+}
+
+// flatForest stores all trees in one contiguous slice for scoring. It is
+// compiled from the pointer-based trees after Fit and Load.
+type flatForest struct {
+	nodes []flatNode
+	// sizes holds the number of training samples reaching each node. It is
+	// not used for scoring, only to rebuild trees from a flat model.
+	sizes []uint32
+	roots []int32
+}
+
+// compileForest flattens trees into a flatForest.
+func compileForest(trees []*iTree) *flatForest {
+	ff := &flatForest{roots: make([]int32, len(trees))}
+	for i, tree := range trees {
+		ff.roots[i] = int32(len(ff.nodes))
+		ff.nodes = append(ff.nodes, flatNode{})
+		ff.sizes = append(ff.sizes, 0)
+		ff.fill(ff.roots[i], tree.root, 0)
+	}
+	return ff
+}
+
+// fill stores n at idx and appends its subtree, children in adjacent pairs.
+func (ff *flatForest) fill(idx int32, n *node, depth int) {
+	ff.sizes[idx] = uint32(n.size)
+	if n.left == nil || n.right == nil {
+		ff.nodes[idx] = flatNode{
+			feature: -1,
+			value:   float64(depth) + averagePathLength(float64(n.size)),
+		}
+		return
+	}
+
+	left := int32(len(ff.nodes))
+	ff.nodes = append(ff.nodes, flatNode{}, flatNode{})
+	ff.sizes = append(ff.sizes, 0, 0)
+	ff.nodes[idx] = flatNode{feature: int32(n.splitFeature), left: left, value: n.splitValue}
+	ff.fill(left, n.left, depth+1)
+	ff.fill(left+1, n.right, depth+1)
+}
+
+// leaf returns the path length credited to sample by the tree at root.
+// The loop runs at most maxTreeDepth times: Fit never grows deeper trees
+// and Load rejects them.
+func (ff *flatForest) leaf(root int32, sample []float64) float64 {
+	nodes := ff.nodes
+	n := &nodes[root]
+	for n.feature >= 0 {
+		n = &nodes[n.left+b2i(sample[n.feature] >= n.value)]
+	}
+	return n.value
+}
+
+// pathLength returns the total path length of sample over all trees.
+func (ff *flatForest) pathLength(sample []float64) float64 {
+	var total float64
+	for _, root := range ff.roots {
+		total += ff.leaf(root, sample)
+	}
+	return total
+}
+
+// scoreBatch writes the anomaly score of the n samples returned by row to
+// scores. Samples are processed in blocks, tree by tree, so each tree's
+// nodes are loaded into cache once per block rather than once per sample.
+func (ff *flatForest) scoreBatch(n int, row func(i int) []float64, scores []float64, avgPathLength float64) {
+	norm := float64(len(ff.roots)) * avgPathLength
+
+	var (
+		totals [batchBlockSize]float64
+		rows   [batchBlockSize][]float64
+	)
+	for start := 0; start < n; start += batchBlockSize {
+		block := rows[:min(batchBlockSize, n-start)]
+		for i := range block {
+			block[i] = row(start + i)
+		}
+		acc := totals[:len(block)]
+		clear(acc)
+
+		for _, root := range ff.roots {
+			for i, sample := range block {
+				acc[i] += ff.leaf(root, sample)
+			}
+		}
+
+		for i, total := range acc {
+			scores[start+i] = score(total, norm)
+		}
+	}
+}
+
+// score converts a total path length into an anomaly score:
+// 2^(-avgPath / c(n)), where norm is the number of trees times c(n).
+func score(totalPath, norm float64) float64 {
+	return math.Pow(2, -totalPath/norm)
+}
+
+// b2i converts b to 0 or 1; the compiler emits a flag set rather than a
+// branch, avoiding mispredictions on random split outcomes.
+func b2i(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package iforest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// treePathLength is the reference path length computed on the pointer-based tree.
+func treePathLength(sample []float64, n *node, depth int) float64 {
+	if n.left == nil && n.right == nil {
+		return float64(depth) + averagePathLength(float64(n.size))
+	}
+	if sample[n.splitFeature] < n.splitValue {
+		return treePathLength(sample, n.left, depth+1)
+	}
+	return treePathLength(sample, n.right, depth+1)
+}
+
+func TestScoreBatch(t *testing.T) {
+	f := New(WithTrees(50), WithSeed(3))
+	require.NoError(t, f.Fit(generateTestData(1000, 4)))
+
+	tests := []struct {
+		name    string
+		samples int
+	}{
+		{name: "empty", samples: 0},
+		{name: "partial block", samples: batchBlockSize / 2},
+		{name: "exact block", samples: batchBlockSize},
+		{name: "several blocks", samples: 3*batchBlockSize + 17},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := generateTestData(tt.samples, 4)
+			scores, err := f.Predict(data)
+			require.NoError(t, err)
+			require.Len(t, scores, tt.samples)
+
+			for i, sample := range data {
+				var total float64
+				for _, tree := range f.trees {
+					total += treePathLength(sample, tree.root, 0)
+				}
+				want := math.Pow(2, -total/float64(len(f.trees))/f.avgPathLength)
+				assert.InDelta(t, want, scores[i], 1e-12)
+
+				one, err := f.PredictOne(sample)
+				require.NoError(t, err)
+				assert.Equal(t, scores[i], one, "batch and single scores match exactly")
+			}
+		})
+	}
+}
+
+func TestCompileForestAfterLoad(t *testing.T) {
+	f := New(WithTrees(20))
+	require.NoError(t, f.Fit(generateTestData(300, 3)))
+	data, err := f.Save()
+	require.NoError(t, err)
+
+	loaded := New()
+	require.NoError(t, loaded.Load(data))
+	assert.Equal(t, f.flat, loaded.flat)
+}
+
+func BenchmarkPredictLarge(b *testing.B) {
+	f := New(WithTrees(100), WithSampleSize(256))
+	f.Fit(generateTestData(5000, 10))
+	testData := generateTestData(100000, 10)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Predict(testData)
+	}
+}
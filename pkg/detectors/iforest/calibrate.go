@@ -0,0 +1,140 @@
+package iforest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// calibrationChunk is the number of samples scored at a time while
+// calibrating the threshold, so calibration holds the scores of one chunk
+// rather than of every training sample.
+const calibrationChunk = 1 << 16
+
+// calibrationCompression is the t-digest compression used once more
+// scores have been seen than are kept exactly. It is above the default
+// for accuracy in the upper tail, where thresholds lie.
+const calibrationCompression = 200
+
+// ErrModelReplaced is returned by Calibrate when the model it was
+// calibrating is replaced before it is done.
+var ErrModelReplaced = errors.New("model replaced during calibration")
+
+// modelID identifies a trained model. Every Fit, Refit and Load compiles
+// new trees, so their addresses change with the model; the old ones cannot
+// be reused while a modelID holds them.
+type modelID struct {
+	flat  *flatForest
+	quant *quantForest
+}
+
+// id returns the identity of the current model. The caller holds the
+// lock.
+func (f *IsolationForest) id() modelID {
+	return modelID{flat: f.flat, quant: f.quant}
+}
+
+// newCalibration returns an estimator of the training score quantiles:
+// exact for up to one chunk of samples, a t-digest beyond that.
+func newCalibration() *stats.QuantileEstimator {
+	return stats.NewQuantileEstimator(calibrationChunk, calibrationCompression)
+}
+
+// calibrate returns the threshold flagging the contamination fraction of
+// the n samples returned by row. The caller holds the lock.
+func (f *IsolationForest) calibrate(n int, row func(i int) []float64) float64 {
+	est := newCalibration()
+	scores := make([]float64, min(n, calibrationChunk))
+	for lo := 0; lo < n; lo += calibrationChunk {
+		hi := min(lo+calibrationChunk, n)
+		// Without a deadline scoring cannot fail.
+		_ = f.scoreRows(context.Background(), hi-lo, func(i int) []float64 { return row(lo + i) }, scores[:hi-lo])
+		est.AddAll(scores[:hi-lo])
+	}
+	return est.Quantile(1 - f.contamination)
+}
+
+// Calibrate sets the threshold to flag the contamination fraction of the
+// samples read from the channel until it is closed, as Fit does for the
+// training data. It scores samples in chunks and summarizes their scores
+// as it goes, so a stream far larger than memory, such as a Reader's
+// Stream, can calibrate a forest fitted on a sample of it. Up to 65536
+// samples the threshold is exact; beyond that it is a t-digest estimate.
+//
+// Each chunk is scored under the read lock, so scoring calls are not held
+// up while Calibrate waits for samples. Every chunk must be scored by the
+// same model, though, and the threshold is only set on that model: if Fit,
+// Refit or Load replaces it meanwhile, Calibrate fails with
+// ErrModelReplaced.
+//
+// Calibrate returns ctx.Err() if ctx is done first, and an error for
+// samples of the wrong width or an empty stream; the threshold is then
+// left unchanged. It needs a trained model and a positive contamination.
+func (f *IsolationForest) Calibrate(ctx context.Context, samples <-chan []float64) error {
+	f.mu.RLock()
+	trained, contamination, model := f.trained, f.contamination, f.id()
+	f.mu.RUnlock()
+	if !trained {
+		return detectors.ErrNotTrained
+	}
+	if contamination <= 0 {
+		return errors.New("calibration needs a positive contamination")
+	}
+
+	est := newCalibration()
+	chunk := make([][]float64, 0, calibrationChunk)
+	score := func() error {
+		f.mu.RLock()
+		if f.id() != model {
+			f.mu.RUnlock()
+			return ErrModelReplaced
+		}
+		scores, err := f.predict(ctx, chunk)
+		f.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("calibrating on samples from %d: %w", est.Count()+1, err)
+		}
+		est.AddAll(scores)
+		chunk = chunk[:0]
+		return nil
+	}
+	for {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				if len(chunk) > 0 {
+					if err := score(); err != nil {
+						return err
+					}
+				}
+				if est.Count() == 0 {
+					return errors.New("no samples to calibrate on")
+				}
+				threshold := est.Quantile(1 - contamination)
+				f.mu.Lock()
+				if f.id() != model {
+					f.mu.Unlock()
+					return ErrModelReplaced
+				}
+				f.threshold = threshold
+				if f.card.Hyperparameters != nil {
+					f.card.Hyperparameters["threshold"] = strconv.FormatFloat(threshold, 'g', -1, 64)
+				}
+				f.mu.Unlock()
+				return nil
+			}
+			chunk = append(chunk, sample)
+			if len(chunk) == calibrationChunk {
+				if err := score(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
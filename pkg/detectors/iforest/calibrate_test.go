@@ -0,0 +1,91 @@
+package iforest
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feed returns a closed channel holding data.
+func feed(data [][]float64) <-chan []float64 {
+	ch := make(chan []float64, len(data))
+	for _, row := range data {
+		ch <- row
+	}
+	close(ch)
+	return ch
+}
+
+func TestFitThresholdIsExactPercentile(t *testing.T) {
+	data := generateTestData(1000, 3)
+	f := New(WithTrees(20), WithSeed(1), WithContamination(0.1))
+	require.NoError(t, f.Fit(data))
+
+	scores, err := f.Predict(data)
+	require.NoError(t, err)
+	slices.Sort(scores)
+	assert.Equal(t, scores[899], f.Threshold(), "index (n-1)*0.9, rounded down")
+}
+
+func TestCalibrate(t *testing.T) {
+	train := generateTestData(1000, 2)
+	f := New(WithTrees(20), WithSeed(1), WithContamination(0.05))
+	require.NoError(t, f.Fit(train))
+	fitted := f.Threshold()
+
+	require.NoError(t, f.Calibrate(context.Background(), feed(train)))
+	assert.Equal(t, fitted, f.Threshold(), "the same data gives Fit's threshold")
+
+	// More samples than are kept exactly: estimated from a t-digest.
+	stream := generateTestData(3*calibrationChunk, 2)
+	require.NoError(t, f.Calibrate(context.Background(), feed(stream)))
+	scores, err := f.Predict(stream)
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range scores {
+		if s >= f.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 0.05, float64(flagged)/float64(len(stream)), 0.002)
+	assert.Equal(t, strconv.FormatFloat(f.Threshold(), 'g', -1, 64), f.Metadata().Hyperparameters["threshold"])
+}
+
+func TestCalibrateErrors(t *testing.T) {
+	train := generateTestData(200, 2)
+	f := New(WithTrees(10), WithSeed(1))
+	require.NoError(t, f.Fit(train))
+	threshold := f.Threshold()
+
+	assert.Error(t, New().Calibrate(context.Background(), feed(train)), "untrained")
+	assert.ErrorContains(t, f.Calibrate(context.Background(), feed(nil)), "no samples")
+	assert.ErrorContains(t, f.Calibrate(context.Background(), feed([][]float64{{1, 2}, {1}})), "sample 1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, f.Calibrate(ctx, make(chan []float64)), context.Canceled)
+	assert.Equal(t, threshold, f.Threshold(), "failed calibration keeps the threshold")
+
+	t.Run("model replaced", func(t *testing.T) {
+		samples := make(chan []float64)
+		done := make(chan error)
+		go func() { done <- f.Calibrate(context.Background(), samples) }()
+		// The chunk is scored before the sample after it is received.
+		for _, row := range generateTestData(calibrationChunk+1, 2) {
+			samples <- row
+		}
+		require.NoError(t, f.Fit(generateTestData(300, 2)))
+		refitted := f.Threshold()
+		close(samples)
+		assert.ErrorIs(t, <-done, ErrModelReplaced)
+		assert.Equal(t, refitted, f.Threshold())
+	})
+
+	none := New(WithTrees(10), WithContamination(0))
+	require.NoError(t, none.Fit(train))
+	assert.ErrorContains(t, none.Calibrate(context.Background(), feed(train)), "contamination")
+}
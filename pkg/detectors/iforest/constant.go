@@ -0,0 +1,78 @@
+package iforest
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrConstantData is returned by Fit when every feature takes a single
+// value across the training data, so no tree can split and every sample
+// would get the same score.
+var ErrConstantData = errors.New("iforest: every feature is constant in the training data")
+
+// ErrNoWeightedFeatures is returned by Fit when every feature with a
+// positive weight (see WithFeatureWeights) is constant in the training
+// data.
+var ErrNoWeightedFeatures = errors.New("iforest: every feature with a positive weight is constant in the training data")
+
+// WithExcludeConstant sets whether trees only split on features that vary
+// in the training data. By default a tree may pick a constant feature,
+// which ends the branch in a leaf and shortens paths for normal and
+// anomalous samples alike; with many constant columns this flattens the
+// scores. Excluding them changes the trees grown for a given seed, so it
+// is off by default to keep existing trainings reproducible.
+//
+// Either way Fit records the constant features; see ConstantFeatures.
+func WithExcludeConstant(exclude bool) Option {
+	return func(f *IsolationForest) {
+		f.excludeConstant = exclude
+	}
+}
+
+// ConstantFeatures returns the indices of the features that were constant
+// in the data of the last Fit, in increasing order; Fit on a single row
+// records none. A sample that differs from the training value in such a
+// feature is not isolated any faster for it, so callers typically warn
+// about them or drop the columns.
+func (f *IsolationForest) ConstantFeatures() []int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return slices.Clone(f.constant)
+}
+
+// constantFeatures returns the indices of the columns of data holding a
+// single value. data has at least one row, all of width nFeatures.
+func constantFeatures(data [][]float64, nFeatures int) []int {
+	var constant []int
+	for j := 0; j < nFeatures; j++ {
+		v := data[0][j]
+		same := true
+		for _, row := range data[1:] {
+			if row[j] != v {
+				same = false
+				break
+			}
+		}
+		if same {
+			constant = append(constant, j)
+		}
+	}
+	return constant
+}
+
+// splitFeatures returns the features trees may split on: all of them but
+// those with a zero weight and, when excluding constant features, the
+// constant ones.
+func (f *IsolationForest) splitFeatures(nFeatures int, constant []int) []int {
+	features := make([]int, 0, nFeatures)
+	for j := 0; j < nFeatures; j++ {
+		if f.excludeConstant && slices.Contains(constant, j) {
+			continue
+		}
+		if f.featureWeights != nil && f.featureWeights[j] == 0 {
+			continue
+		}
+		features = append(features, j)
+	}
+	return features
+}
@@ -0,0 +1,137 @@
+package iforest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withConstantColumns returns n rows whose features listed in constant are
+// fixed at 1 and the others are normally distributed.
+func withConstantColumns(n, features int, constant ...int) [][]float64 {
+	rng := rand.New(rand.NewSource(3))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = make([]float64, features)
+		for j := range data[i] {
+			data[i][j] = rng.NormFloat64()
+		}
+		for _, j := range constant {
+			data[i][j] = 1
+		}
+	}
+	return data
+}
+
+// splitsOn reports whether any tree of f splits on feature.
+func splitsOn(f *IsolationForest, feature int) bool {
+	var walk func(n *node) bool
+	walk = func(n *node) bool {
+		if n == nil || n.left == nil {
+			return false
+		}
+		return n.splitFeature == feature || walk(n.left) || walk(n.right)
+	}
+	for _, tree := range f.trees {
+		if walk(tree.root) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConstantFeatures(t *testing.T) {
+	data := withConstantColumns(200, 5, 1, 3)
+
+	tests := []struct {
+		name    string
+		exclude bool
+	}{
+		{name: "kept", exclude: false},
+		{name: "excluded", exclude: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(WithTrees(50), WithExcludeConstant(tt.exclude))
+			assert.Nil(t, f.ConstantFeatures())
+			require.NoError(t, f.Fit(data))
+			assert.Equal(t, []int{1, 3}, f.ConstantFeatures())
+
+			for _, j := range []int{1, 3} {
+				assert.False(t, splitsOn(f, j), "a constant feature never splits")
+			}
+		})
+	}
+
+	t.Run("excluding grows deeper trees", func(t *testing.T) {
+		// Picking a kept constant feature ends the branch early.
+		excluded := New(WithTrees(50), WithExcludeConstant(true))
+		require.NoError(t, excluded.Fit(data))
+		kept := New(WithTrees(50))
+		require.NoError(t, kept.Fit(data))
+		assert.Greater(t, totalNodes(excluded), totalNodes(kept))
+	})
+
+	t.Run("excluded constant features sharpen scores", func(t *testing.T) {
+		data := withConstantColumns(256, 10, 0, 1, 2, 3, 4, 5, 6, 7)
+		outlier := []float64{1, 1, 1, 1, 1, 1, 1, 1, 6, -6}
+
+		score := func(opts ...Option) float64 {
+			f := New(append([]Option{WithTrees(100)}, opts...)...)
+			require.NoError(t, f.Fit(data))
+			s, err := f.PredictOne(outlier)
+			require.NoError(t, err)
+			return s
+		}
+		assert.Greater(t, score(WithExcludeConstant(true)), score())
+	})
+
+	t.Run("all constant", func(t *testing.T) {
+		f := New(WithExcludeConstant(true))
+		err := f.Fit(withConstantColumns(50, 2, 0, 1))
+		assert.ErrorIs(t, err, ErrConstantData)
+		assert.False(t, f.Trained())
+
+		g := New()
+		require.NoError(t, g.Fit([][]float64{{4, 2}}), "a single row is not treated as constant")
+		assert.Nil(t, g.ConstantFeatures())
+	})
+
+	t.Run("survives save and refit", func(t *testing.T) {
+		f := New(WithTrees(10), WithExcludeConstant(true))
+		require.NoError(t, f.Fit(data))
+		assert.Equal(t, "true", f.Metadata().Hyperparameters["exclude_constant"])
+
+		saved, err := f.Save()
+		require.NoError(t, err)
+		flat, err := f.SaveFlat()
+		require.NoError(t, err)
+		for _, model := range [][]byte{saved, flat} {
+			g := New()
+			require.NoError(t, g.Load(model))
+			assert.Equal(t, []int{1, 3}, g.ConstantFeatures())
+		}
+
+		require.NoError(t, f.Refit(withConstantColumns(100, 5, 4)))
+		assert.Equal(t, []int{4}, f.ConstantFeatures())
+		assert.False(t, splitsOn(f, 4))
+	})
+}
+
+// totalNodes counts the nodes of every tree in f.
+func totalNodes(f *IsolationForest) int {
+	var count func(n *node) int
+	count = func(n *node) int {
+		if n == nil {
+			return 0
+		}
+		return 1 + count(n.left) + count(n.right)
+	}
+	total := 0
+	for _, tree := range f.trees {
+		total += count(tree.root)
+	}
+	return total
+}
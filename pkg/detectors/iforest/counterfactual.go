@@ -0,0 +1,166 @@
+package iforest
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// maxCounterfactualCandidates caps the values tried per feature at each
+// step of the counterfactual search.
+const maxCounterfactualCandidates = 64
+
+var _ detectors.CounterfactualExplainer = (*IsolationForest)(nil)
+
+// Counterfactual greedily searches for the nearest normal variant of sample.
+//
+// Candidate values for each feature are the forest's split points for that
+// feature and the feature's typical training range. At each step, if a
+// single change brings the score under the threshold, the smallest such
+// change (relative to the feature's typical range) is taken and the search
+// ends; otherwise the change that lowers the score most is taken. Each
+// feature is changed at most once.
+func (f *IsolationForest) Counterfactual(sample []float64) (detectors.Counterfactual, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return detectors.Counterfactual{}, detectors.ErrNotTrained
+	}
+	if len(sample) != f.nFeatures {
+		return detectors.Counterfactual{}, f.dimensionError(sample)
+	}
+
+	current := make([]float64, len(sample))
+	copy(current, sample)
+	score, err := f.predictOne(current)
+	if err != nil {
+		return detectors.Counterfactual{}, err
+	}
+
+	result := detectors.Counterfactual{Score: score}
+	if score < f.threshold {
+		result.Found = true
+		return result, nil
+	}
+
+	candidates := f.counterfactualCandidates()
+	changed := make([]bool, f.nFeatures)
+
+	for step := 0; step < f.nFeatures; step++ {
+		bestFeature, bestValue, bestScore := -1, 0.0, score
+		crossFeature, crossValue, crossScore, crossDist := -1, 0.0, 0.0, math.Inf(1)
+
+		for j := range current {
+			if changed[j] {
+				continue
+			}
+			original := current[j]
+			scale := f.featureScale(j)
+			for _, c := range candidates[j] {
+				if c == original {
+					continue
+				}
+				current[j] = c
+				s, err := f.predictOne(current)
+				if err != nil {
+					return detectors.Counterfactual{}, err
+				}
+
+				if s < f.threshold {
+					if dist := math.Abs(c-original) / scale; dist < crossDist {
+						crossFeature, crossValue, crossScore, crossDist = j, c, s, dist
+					}
+				} else if s < bestScore {
+					bestFeature, bestValue, bestScore = j, c, s
+				}
+			}
+			current[j] = original
+		}
+
+		if crossFeature >= 0 {
+			bestFeature, bestValue, bestScore = crossFeature, crossValue, crossScore
+		}
+		if bestFeature < 0 {
+			break
+		}
+
+		result.Changes = append(result.Changes, detectors.FeatureChange{
+			Index: bestFeature,
+			From:  current[bestFeature],
+			To:    bestValue,
+		})
+		current[bestFeature] = bestValue
+		changed[bestFeature] = true
+		score = bestScore
+
+		if score < f.threshold {
+			result.Found = true
+			break
+		}
+	}
+
+	result.Score = score
+	return result, nil
+}
+
+// counterfactualCandidates returns the values tried for each feature: both
+// sides of every split point, plus the typical range and its midpoint.
+func (f *IsolationForest) counterfactualCandidates() [][]float64 {
+	splits := make([][]float64, f.nFeatures)
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.left == nil || n.right == nil {
+			return
+		}
+		splits[n.splitFeature] = append(splits[n.splitFeature], n.splitValue)
+		walk(n.left)
+		walk(n.right)
+	}
+	for _, tree := range f.treeSet() {
+		walk(tree.root)
+	}
+
+	candidates := make([][]float64, f.nFeatures)
+	for j, values := range splits {
+		sort.Float64s(values)
+		values = thin(values, maxCounterfactualCandidates/2)
+
+		var c []float64
+		for _, v := range values {
+			// Samples below the split go left, so the split value itself
+			// goes right and the next smaller float goes left.
+			c = append(c, math.Nextafter(v, math.Inf(-1)), v)
+		}
+		if j < len(f.typical) {
+			r := f.typical[j]
+			c = append(c, r.Low, (r.Low+r.High)/2, r.High)
+		}
+		candidates[j] = c
+	}
+	return candidates
+}
+
+// featureScale returns the width of the typical range of feature j, used to
+// compare changes across features with different units.
+func (f *IsolationForest) featureScale(j int) float64 {
+	if j < len(f.typical) {
+		if w := f.typical[j].High - f.typical[j].Low; w > 0 {
+			return w
+		}
+	}
+	return 1
+}
+
+// thin returns at most n values evenly spaced through sorted.
+func thin(sorted []float64, n int) []float64 {
+	if len(sorted) <= n {
+		return sorted
+	}
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = sorted[i*(len(sorted)-1)/(n-1)]
+	}
+	return out
+}
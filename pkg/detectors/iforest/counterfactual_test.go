@@ -0,0 +1,67 @@
+package iforest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestCounterfactual(t *testing.T) {
+	tests := []struct {
+		name    string
+		sample  []float64
+		allowed []int // features the search may change; nil means none
+	}{
+		{name: "already normal", sample: []float64{0, 0, 0, 0}},
+		{name: "single feature off", sample: []float64{0, 15}, allowed: []int{1}},
+		{name: "all features off", sample: []float64{4, 4, 4, 4}, allowed: []int{0, 1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(WithTrees(100), WithContamination(0.05), WithSeed(42))
+			require.NoError(t, f.Fit(generateTestData(500, len(tt.sample))))
+
+			cf, err := detectors.ExplainCounterfactual(f, tt.sample)
+			require.NoError(t, err)
+			assert.True(t, cf.Found)
+			assert.Less(t, cf.Score, f.Threshold())
+
+			changed := make([]int, 0, len(cf.Changes))
+			modified := append([]float64(nil), tt.sample...)
+			for _, c := range cf.Changes {
+				assert.Equal(t, tt.sample[c.Index], c.From)
+				changed = append(changed, c.Index)
+				modified[c.Index] = c.To
+			}
+			if tt.allowed == nil {
+				assert.Empty(t, changed)
+			} else {
+				assert.NotEmpty(t, changed)
+				assert.Subset(t, tt.allowed, changed)
+			}
+
+			score, err := f.PredictOne(modified)
+			require.NoError(t, err)
+			assert.Equal(t, cf.Score, score)
+		})
+	}
+}
+
+func TestCounterfactualErrors(t *testing.T) {
+	_, err := New().Counterfactual([]float64{1})
+	assert.Error(t, err)
+
+	f := New(WithTrees(10))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	_, err = f.Counterfactual([]float64{1})
+	assert.Error(t, err)
+}
+
+func TestThin(t *testing.T) {
+	assert.Equal(t, []float64{1, 2}, thin([]float64{1, 2}, 4))
+	assert.Equal(t, []float64{0, 5, 10}, thin([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 3))
+}
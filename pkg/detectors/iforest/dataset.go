@@ -0,0 +1,53 @@
+package iforest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var _ detectors.DatasetDetector = (*IsolationForest)(nil)
+
+// FitDataset trains the forest on ds. The dataset's feature names are
+// recorded in the model card unless WithFeatureNames was given. Like Fit,
+// it copies the samples first unless WithCopyData(false) was given.
+func (f *IsolationForest) FitDataset(ds *data.Dataset) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := f.featureNames
+	if names == nil {
+		names = ds.FeatureNames()
+	}
+	next, err := f.fitNext(ds.Rows(), names)
+	if err != nil {
+		return err
+	}
+	return f.swap(next)
+}
+
+// PredictDataset returns anomaly scores for the samples in ds, reading
+// them in place from the dataset's backing array.
+func (f *IsolationForest) PredictDataset(ds *data.Dataset) ([]float64, error) {
+	defer f.telemetry.report()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if ds.Len() > 0 && ds.Features() != f.nFeatures {
+		return nil, fmt.Errorf("dataset: %w", &detectors.DimensionError{Got: ds.Features(), Want: f.nFeatures})
+	}
+
+	scores := make([]float64, ds.Len())
+	// Without a deadline scoring cannot fail.
+	_ = f.scoreRows(context.Background(), ds.Len(), ds.Row, scores)
+	if f.scoreStats != nil {
+		f.scoreStats.AddAll(scores, f.threshold)
+	}
+	f.telemetry.observe(f, ds.Len(), ds.Row)
+	return scores, nil
+}
@@ -0,0 +1,39 @@
+package iforest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestDataset(t *testing.T) {
+	rows := generateTestData(600, 3)
+	ds, err := data.FromRows(rows)
+	require.NoError(t, err)
+	require.NoError(t, ds.SetFeatureNames([]string{"a", "b", "c"}))
+
+	f := New(WithTrees(20), WithSeed(1))
+	require.NoError(t, detectors.FitDataset(f, ds))
+	assert.Equal(t, []string{"a", "b", "c"}, f.Metadata().FeatureNames)
+
+	legacy := New(WithTrees(20), WithSeed(1))
+	require.NoError(t, legacy.Fit(rows))
+
+	want, err := legacy.Predict(rows)
+	require.NoError(t, err)
+	got, err := detectors.PredictDataset(f, ds)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "dataset and slice paths score identically")
+
+	wide, err := data.FromRows([][]float64{{1, 2, 3, 4}})
+	require.NoError(t, err)
+	_, err = f.PredictDataset(wide)
+	assert.Error(t, err)
+
+	_, err = New().PredictDataset(ds)
+	assert.Error(t, err)
+}
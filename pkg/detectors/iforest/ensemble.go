@@ -0,0 +1,61 @@
+package iforest
+
+import "github.com/hed1ad/goguardml/pkg/detectors"
+
+var _ detectors.TreeEnsemble = (*IsolationForest)(nil)
+
+// Ensemble describes the trained trees, for exporting the model, for
+// instance to PMML. Leaf values are the path lengths credited to samples
+// reaching them. Quantized models are described with their dequantized
+// split values and saturated leaf sizes, so they score the same.
+func (f *IsolationForest) Ensemble() (detectors.Ensemble, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return detectors.Ensemble{}, detectors.ErrNotTrained
+	}
+
+	var convert func(n *node, depth int) *detectors.TreeNode
+	convert = func(n *node, depth int) *detectors.TreeNode {
+		if n.left == nil || n.right == nil {
+			return &detectors.TreeNode{
+				Feature: -1,
+				Samples: n.size,
+				Value:   float64(depth) + averagePathLength(float64(n.size)),
+			}
+		}
+		return &detectors.TreeNode{
+			Feature: n.splitFeature,
+			Split:   n.splitValue,
+			Left:    convert(n.left, depth+1),
+			Right:   convert(n.right, depth+1),
+			Samples: n.size,
+		}
+	}
+
+	trees := f.treeSet()
+	e := detectors.Ensemble{
+		Kind:       detectors.EnsembleIsolationForest,
+		Features:   f.nFeatures,
+		Trees:      make([]*detectors.TreeNode, len(trees)),
+		SampleSize: f.effectiveSampleSize(),
+		Threshold:  f.threshold,
+	}
+	for i, tree := range trees {
+		e.Trees[i] = convert(tree.root, 0)
+	}
+	return e, nil
+}
+
+// effectiveSampleSize returns the number of samples the trees were grown
+// from: the configured sample size, or fewer if the training data had
+// fewer rows. Only the normalizing path length derived from it is stored,
+// so it is recovered from that.
+func (f *IsolationForest) effectiveSampleSize() int {
+	n := max(f.sampleSize, 2)
+	for n > 2 && averagePathLength(float64(n)) > f.avgPathLength {
+		n--
+	}
+	return n
+}
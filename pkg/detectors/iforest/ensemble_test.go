@@ -0,0 +1,67 @@
+package iforest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// ensembleScore scores sample from the description of a forest.
+func ensembleScore(e detectors.Ensemble, sample []float64) float64 {
+	var total float64
+	for _, n := range e.Trees {
+		for n.Feature >= 0 {
+			if sample[n.Feature] >= n.Split {
+				n = n.Right
+			} else {
+				n = n.Left
+			}
+		}
+		total += n.Value
+	}
+	return math.Pow(2, -total/float64(len(e.Trees))/averagePathLength(float64(e.SampleSize)))
+}
+
+func TestEnsemble(t *testing.T) {
+	_, err := New().Ensemble()
+	assert.Error(t, err)
+
+	data := quantData(300)
+	f := New(WithTrees(10), WithSampleSize(128), WithSeed(2))
+	require.NoError(t, f.Fit(data))
+	e, err := f.Ensemble()
+	require.NoError(t, err)
+	assert.Equal(t, detectors.EnsembleIsolationForest, e.Kind)
+	assert.Equal(t, 4, e.Features)
+	assert.Equal(t, 128, e.SampleSize)
+	assert.Equal(t, f.Threshold(), e.Threshold)
+	require.Len(t, e.Trees, 10)
+	assert.Equal(t, 128, e.Trees[0].Samples)
+
+	scores, err := f.Predict(data[:20])
+	require.NoError(t, err)
+	for i, sample := range data[:20] {
+		assert.InDelta(t, scores[i], ensembleScore(e, sample), 1e-12)
+	}
+
+	// Models loaded from the flat format, which keeps no pointer trees,
+	// describe the same trees.
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+	g := New()
+	require.NoError(t, g.Load(flat))
+	loaded, err := g.Ensemble()
+	require.NoError(t, err)
+	assert.Equal(t, e, loaded)
+
+	// The sample size is capped by the training rows.
+	small := New(WithTrees(2), WithSampleSize(256), WithSeed(2))
+	require.NoError(t, small.Fit(data[:50]))
+	e, err = small.Ensemble()
+	require.NoError(t, err)
+	assert.Equal(t, 50, e.SampleSize)
+}
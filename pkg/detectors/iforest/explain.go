@@ -0,0 +1,241 @@
+package iforest
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// maxDIFFISamples caps the training samples visited when computing global
+// DIFFI importances and typical ranges during Fit.
+const maxDIFFISamples = 8192
+
+// defaultExplainTop is the number of top features listed by Explain when
+// WithExplanations is not set.
+const defaultExplainTop = 5
+
+// typicalPercentile bounds the central range of training values reported
+// as typical: from the 5th to the 95th percentile.
+const typicalPercentile = 5
+
+var _ detectors.Explainer = (*IsolationForest)(nil)
+
+// FeatureImportances returns global DIFFI importances computed on the
+// training data: how much more each feature drives isolation of outliers
+// than of inliers. Models saved before DIFFI was recorded fall back to
+// weighting each split by the fraction of training samples it acts on.
+// The result sums to 1.
+func (f *IsolationForest) FeatureImportances() []float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return nil
+	}
+	if f.importances != nil {
+		out := make([]float64, len(f.importances))
+		copy(out, f.importances)
+		return out
+	}
+
+	importances := make([]float64, f.nFeatures)
+	for _, tree := range f.treeSet() {
+		total := float64(tree.root.size)
+		var walk func(n *node)
+		walk = func(n *node) {
+			if n.left == nil || n.right == nil {
+				return
+			}
+			importances[n.splitFeature] += float64(n.size) / total
+			walk(n.left)
+			walk(n.right)
+		}
+		walk(tree.root)
+	}
+
+	return normalize(importances)
+}
+
+// Explain returns local DIFFI attributions for sample (Carletti et al.,
+// "Interpretable Anomaly Detection with DIFFI"). Splits along the sample's
+// isolation path are credited with their induced imbalance, weighted by the
+// inverse path length, and averaged per feature.
+func (f *IsolationForest) Explain(sample []float64) (detectors.Explanation, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	return f.explain(sample)
+}
+
+// explain is Explain for callers holding the read lock.
+func (f *IsolationForest) explain(sample []float64) (detectors.Explanation, error) {
+	if len(sample) != f.nFeatures {
+		return detectors.Explanation{}, f.dimensionError(sample)
+	}
+
+	score, err := f.predictOne(sample)
+	if err != nil {
+		return detectors.Explanation{}, err
+	}
+
+	var acc diffiAccumulator
+	acc.reset(f.nFeatures)
+	f.accumulateDIFFI(sample, &acc)
+	contributions := normalize(acc.importances())
+
+	topK := f.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, f.typical, topK),
+	}, nil
+}
+
+// diffiAccumulator sums cumulative feature importances and split counts.
+type diffiAccumulator struct {
+	cfi   []float64
+	count []float64
+}
+
+func (a *diffiAccumulator) reset(nFeatures int) {
+	a.cfi = make([]float64, nFeatures)
+	a.count = make([]float64, nFeatures)
+}
+
+// importances returns the cumulative importance of each feature divided by
+// the number of splits on it, so frequently chosen features are not favored.
+func (a *diffiAccumulator) importances() []float64 {
+	out := make([]float64, len(a.cfi))
+	for j := range out {
+		if a.count[j] > 0 {
+			out[j] = a.cfi[j] / a.count[j]
+		}
+	}
+	return out
+}
+
+// accumulateDIFFI adds the contributions of sample's path in every tree.
+func (f *IsolationForest) accumulateDIFFI(sample []float64, acc *diffiAccumulator) {
+	var path []*node
+	for _, tree := range f.treeSet() {
+		path = path[:0]
+		for n := tree.root; n.left != nil && n.right != nil; {
+			path = append(path, n)
+			if sample[n.splitFeature] < n.splitValue {
+				n = n.left
+			} else {
+				n = n.right
+			}
+		}
+		if len(path) == 0 {
+			continue
+		}
+
+		weight := 1 / float64(len(path))
+		for _, n := range path {
+			acc.cfi[n.splitFeature] += weight * inducedImbalance(n)
+			acc.count[n.splitFeature]++
+		}
+	}
+}
+
+// inducedImbalance is DIFFI's λ: how unevenly a split divides the node's
+// training samples, scaled to [0.5, 1] for useful splits. Splits that leave
+// one side empty isolate nothing and score 0.
+func inducedImbalance(n *node) float64 {
+	left, right := float64(n.left.size), float64(n.right.size)
+	if left == 0 || right == 0 {
+		return 0
+	}
+	total := left + right
+	minRatio := math.Ceil(total/2) / total
+	maxRatio := (total - 1) / total
+	if maxRatio <= minRatio {
+		return 1
+	}
+	ratio := math.Max(left, right) / total
+	return 0.5 + 0.5*(ratio-minRatio)/(maxRatio-minRatio)
+}
+
+// fitDIFFI computes global DIFFI importances from the training data: the
+// average importance among predicted outliers divided by that among inliers.
+func (f *IsolationForest) fitDIFFI(data [][]float64) ([]float64, error) {
+	step := diffiStep(len(data))
+
+	var outliers, inliers diffiAccumulator
+	outliers.reset(f.nFeatures)
+	inliers.reset(f.nFeatures)
+
+	for i := 0; i < len(data); i += step {
+		score, err := f.predictOne(data[i])
+		if err != nil {
+			return nil, err
+		}
+		if score >= f.threshold {
+			f.accumulateDIFFI(data[i], &outliers)
+		} else {
+			f.accumulateDIFFI(data[i], &inliers)
+		}
+	}
+
+	out, in := outliers.importances(), inliers.importances()
+	for j := range out {
+		if in[j] > 0 {
+			out[j] /= in[j]
+		}
+	}
+	return normalize(out), nil
+}
+
+// diffiStep returns the stride that visits at most maxDIFFISamples of n samples.
+func diffiStep(n int) int {
+	return max(1, (n+maxDIFFISamples-1)/maxDIFFISamples)
+}
+
+// typicalRanges returns the central range of each feature over every
+// step-th sample.
+func typicalRanges(data [][]float64, step int) []detectors.Range {
+	if len(data) == 0 {
+		return nil
+	}
+
+	ranges := make([]detectors.Range, len(data[0]))
+	column := make([]float64, 0, (len(data)+step-1)/step)
+	for j := range ranges {
+		column = column[:0]
+		for i := 0; i < len(data); i += step {
+			column = append(column, data[i][j])
+		}
+		sort.Float64s(column)
+
+		last := len(column) - 1
+		ranges[j] = detectors.Range{
+			Low:  column[last*typicalPercentile/100],
+			High: column[last*(100-typicalPercentile)/100],
+		}
+	}
+	return ranges
+}
+
+// normalize scales values in place to sum to 1 and returns them.
+func normalize(values []float64) []float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if sum == 0 {
+		return values
+	}
+	for i := range values {
+		values[i] /= sum
+	}
+	return values
+}
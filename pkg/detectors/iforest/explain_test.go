@@ -0,0 +1,160 @@
+package iforest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestFeatureImportances(t *testing.T) {
+	f := New(WithTrees(50), WithSeed(42))
+	assert.Nil(t, f.FeatureImportances())
+
+	require.NoError(t, f.Fit(generateTestData(500, 4)))
+
+	importances := f.FeatureImportances()
+	require.Len(t, importances, 4)
+
+	var sum float64
+	for _, v := range importances {
+		assert.Greater(t, v, 0.0)
+		sum += v
+	}
+	assert.InDelta(t, 1.0, sum, 1e-9)
+}
+
+func TestExplain(t *testing.T) {
+	f := New(WithTrees(100), WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(500, 4)))
+
+	tests := []struct {
+		name    string
+		sample  []float64
+		want    int
+		wantErr bool
+	}{
+		{name: "outlier in first feature", sample: []float64{12, 0, 0, 0}, want: 0},
+		{name: "outlier in third feature", sample: []float64{0, 0, -12, 0}, want: 2},
+		{name: "wrong dimensionality", sample: []float64{0, 0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := detectors.Explain(f, tt.sample)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, exp.Contributions, 4)
+
+			score, err := f.PredictOne(tt.sample)
+			require.NoError(t, err)
+			assert.Equal(t, score, exp.Score)
+
+			top := 0
+			for i, c := range exp.Contributions {
+				if c > exp.Contributions[top] {
+					top = i
+				}
+			}
+			assert.Equal(t, tt.want, top)
+		})
+	}
+}
+
+func TestExplainUntrained(t *testing.T) {
+	_, err := New().Explain([]float64{1, 2})
+	assert.Error(t, err)
+}
+
+func TestExplainAfterLoad(t *testing.T) {
+	original := New(WithTrees(20), WithSeed(1))
+	require.NoError(t, original.Fit(generateTestData(200, 3)))
+
+	data, err := original.Save()
+	require.NoError(t, err)
+
+	loaded := New()
+	require.NoError(t, loaded.Load(data))
+	assert.Equal(t, original.FeatureImportances(), loaded.FeatureImportances())
+}
+
+func TestInducedImbalance(t *testing.T) {
+	leaf := func(size int) *node { return &node{size: size} }
+
+	tests := []struct {
+		name        string
+		left, right int
+		want        float64
+	}{
+		{name: "balanced split", left: 5, right: 5, want: 0.5},
+		{name: "isolates one sample", left: 1, right: 9, want: 1},
+		{name: "two samples", left: 1, right: 1, want: 1},
+		{name: "empty side", left: 0, right: 10, want: 0},
+		{name: "partial imbalance", left: 3, right: 7, want: 0.75},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &node{left: leaf(tt.left), right: leaf(tt.right), size: tt.left + tt.right}
+			assert.InDelta(t, tt.want, inducedImbalance(n), 1e-9)
+		})
+	}
+}
+
+func TestGlobalDIFFI(t *testing.T) {
+	// Outliers differ from inliers only in feature 1.
+	data := generateTestData(1000, 3)
+	for i := 0; i < 50; i++ {
+		data[i][1] = 8 + float64(i%5)
+	}
+
+	f := New(WithTrees(100), WithContamination(0.05), WithSeed(7))
+	require.NoError(t, f.Fit(data))
+
+	importances := f.FeatureImportances()
+	require.Len(t, importances, 3)
+	assert.Greater(t, importances[1], importances[0])
+	assert.Greater(t, importances[1], importances[2])
+}
+
+func TestFeatureImportancesWithoutDIFFI(t *testing.T) {
+	f := New(WithTrees(20), WithSeed(3))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+	// Models saved before global DIFFI was recorded have no importances.
+	f.importances = nil
+
+	importances := f.FeatureImportances()
+	require.Len(t, importances, 3)
+	var sum float64
+	for _, v := range importances {
+		sum += v
+	}
+	assert.InDelta(t, 1.0, sum, 1e-9)
+}
+
+func TestPredictStreamExplanations(t *testing.T) {
+	f := New(WithTrees(50), WithSeed(42), WithExplanations(2))
+	require.NoError(t, f.Fit(generateTestData(300, 3)))
+
+	input := make(chan []float64, 1)
+	output := make(chan detectors.Score, 1)
+	input <- []float64{0, 0, 15}
+	close(input)
+
+	require.NoError(t, f.PredictStream(context.Background(), input, output))
+
+	score := <-output
+	require.NotNil(t, score.Explanation)
+	assert.Equal(t, score.Value, score.Explanation.Score)
+	require.Len(t, score.Explanation.Top, 2)
+	assert.Equal(t, 2, score.Explanation.Top[0].Index)
+	require.NotNil(t, score.Explanation.Top[0].Typical)
+	assert.Less(t, score.Explanation.Top[0].Typical.Low, score.Explanation.Top[0].Typical.High)
+}
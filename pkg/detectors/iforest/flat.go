@@ -0,0 +1,285 @@
+package iforest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// The flat model format stores the compiled forest as fixed-size records
+// that can be used in place, without decoding, from a memory-mapped file:
+//
+//	header  magic "GGIFFLAT", version uint32, reserved uint32,
+//	        node count uint64, root count uint64, trailer length uint64
+//	nodes   per node: feature int32, left int32, value float64
+//	sizes   per node: training samples reaching it, uint32
+//	roots   per tree: root node index, uint32
+//	trailer gob: sampleSize, contamination, threshold, avgPathLength,
+//	        nFeatures, then the Save trailer (attributions, typical
+//	        ranges, profile, model card)
+//
+// All integers are little-endian. The header is a multiple of 8 bytes, so
+// nodes are 8-byte aligned whenever the file is.
+const (
+	flatMagic      = "GGIFFLAT"
+	flatVersion    = 1
+	flatHeaderSize = 40
+	flatNodeSize   = 16
+)
+
+// ErrNotFlat is returned when opening a file that is not a flat model.
+var ErrNotFlat = errors.New("not a flat isolation forest model")
+
+// hostLittleEndian reports whether flat records can be used in place.
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// isFlat reports whether data starts with the flat model header.
+func isFlat(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(flatMagic))
+}
+
+// SaveFlat serializes the trained model in the flat format. Load reads it
+// like Save output; OpenMapped maps it without copying the trees to the
+// heap.
+func (f *IsolationForest) SaveFlat() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if f.quant != nil {
+		return nil, errors.New("quantized models cannot be saved in the flat format")
+	}
+
+	var trailer bytes.Buffer
+	enc := gob.NewEncoder(&trailer)
+	for _, v := range []any{f.sampleSize, f.contamination, f.threshold, f.avgPathLength, f.nFeatures} {
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.encodeTrailer(enc); err != nil {
+		return nil, err
+	}
+
+	ff := f.flat
+	nodes, roots := len(ff.nodes), len(ff.roots)
+	size := flatHeaderSize + nodes*(flatNodeSize+4) + roots*4 + trailer.Len()
+	buf := make([]byte, 0, size)
+
+	le := binary.LittleEndian
+	buf = append(buf, flatMagic...)
+	buf = le.AppendUint32(buf, flatVersion)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint64(buf, uint64(nodes))
+	buf = le.AppendUint64(buf, uint64(roots))
+	buf = le.AppendUint64(buf, uint64(trailer.Len()))
+	for _, n := range ff.nodes {
+		buf = le.AppendUint32(buf, uint32(n.feature))
+		buf = le.AppendUint32(buf, uint32(n.left))
+		buf = le.AppendUint64(buf, math.Float64bits(n.value))
+	}
+	for _, s := range ff.sizes {
+		buf = le.AppendUint32(buf, s)
+	}
+	for _, r := range ff.roots {
+		buf = le.AppendUint32(buf, uint32(r))
+	}
+	return append(buf, trailer.Bytes()...), nil
+}
+
+// loadFlat loads a flat model. If inPlace is set and the records can be
+// used as they are, the forest references data, which must then stay
+// unchanged while the model is in use; otherwise the records are copied.
+// The caller holds the write lock.
+func (f *IsolationForest) loadFlat(data []byte, inPlace bool) error {
+	if !isFlat(data) {
+		return ErrNotFlat
+	}
+	if err := f.unsigned("flat"); err != nil {
+		return err
+	}
+	if len(data) < flatHeaderSize {
+		return errors.New("truncated flat model header")
+	}
+	le := binary.LittleEndian
+	if v := le.Uint32(data[8:]); v != flatVersion {
+		return fmt.Errorf("flat model: %w %d (this build reads up to %d)", ErrUnsupportedVersion, v, flatVersion)
+	}
+	nNodes, nRoots, trailerLen := le.Uint64(data[16:]), le.Uint64(data[24:]), le.Uint64(data[32:])
+
+	// Bound the counts before multiplying so the size check cannot overflow.
+	rest := uint64(len(data) - flatHeaderSize)
+	if nNodes > rest/(flatNodeSize+4) || nRoots > rest/4 || trailerLen > rest ||
+		nNodes*(flatNodeSize+4)+nRoots*4+trailerLen != rest {
+		return errors.New("flat model size does not match its header")
+	}
+	if nNodes > math.MaxInt32 || nRoots == 0 {
+		return errors.New("invalid node or tree count in flat model")
+	}
+
+	n, r := int(nNodes), int(nRoots)
+	body := data[flatHeaderSize:]
+	nodeBytes, body := body[:n*flatNodeSize], body[n*flatNodeSize:]
+	sizeBytes, body := body[:n*4], body[n*4:]
+	rootBytes, trailer := body[:r*4], body[r*4:]
+
+	ff := &flatForest{}
+	if inPlace && hostLittleEndian && n > 0 &&
+		uintptr(unsafe.Pointer(&nodeBytes[0]))%unsafe.Alignof(flatNode{}) == 0 {
+		ff.nodes = unsafe.Slice((*flatNode)(unsafe.Pointer(&nodeBytes[0])), n)
+		ff.sizes = unsafe.Slice((*uint32)(unsafe.Pointer(&sizeBytes[0])), n)
+		ff.roots = unsafe.Slice((*int32)(unsafe.Pointer(&rootBytes[0])), r)
+	} else {
+		ff.nodes = make([]flatNode, n)
+		for i := range ff.nodes {
+			rec := nodeBytes[i*flatNodeSize:]
+			ff.nodes[i] = flatNode{
+				feature: int32(le.Uint32(rec)),
+				left:    int32(le.Uint32(rec[4:])),
+				value:   math.Float64frombits(le.Uint64(rec[8:])),
+			}
+		}
+		ff.sizes = make([]uint32, n)
+		for i := range ff.sizes {
+			ff.sizes[i] = le.Uint32(sizeBytes[i*4:])
+		}
+		ff.roots = make([]int32, r)
+		for i := range ff.roots {
+			ff.roots[i] = int32(le.Uint32(rootBytes[i*4:]))
+		}
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(trailer))
+	var (
+		sampleSize, nFeatures    int
+		contamination, threshold float64
+		avgPathLength            float64
+	)
+	for _, v := range []any{&sampleSize, &contamination, &threshold, &avgPathLength, &nFeatures} {
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("flat model trailer: %w", err)
+		}
+	}
+	if err := ff.validate(nFeatures); err != nil {
+		return err
+	}
+	var m savedModel
+	if err := decodeTrailer(dec, &m); err != nil {
+		return err
+	}
+
+	f.nTrees = len(ff.roots)
+	f.sampleSize = sampleSize
+	f.contamination = contamination
+	f.threshold = threshold
+	f.avgPathLength = avgPathLength
+	f.nFeatures = nFeatures
+	f.flat = ff
+	f.quant = nil
+	// Pointer trees are only needed for explanations and Save; they are
+	// rebuilt from the flat records on first use.
+	f.trees = nil
+	f.decompile = sync.Once{}
+	f.importances = m.Importances
+	f.typical = m.typicalRanges()
+	f.profile = m.Profile.profile()
+	f.card = m.Card.ModelCard()
+	f.constant = m.Constant
+	f.maxDepth = depthLimit(f.sampleSize)
+	f.trained = true
+	return nil
+}
+
+// validate checks that every tree is well formed, so traversal of
+// untrusted records cannot index out of range, loop, or exceed
+// maxTreeDepth, and that no node is shared, which would make rebuilding
+// the trees exponential.
+func (ff *flatForest) validate(nFeatures int) error {
+	type entry struct {
+		idx   int32
+		depth int
+	}
+	var stack []entry
+	seen := make([]bool, len(ff.nodes))
+	for _, root := range ff.roots {
+		if root < 0 || int(root) >= len(ff.nodes) {
+			return errors.New("invalid root index in flat model")
+		}
+		stack = append(stack[:0], entry{root, 0})
+		for len(stack) > 0 {
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[e.idx] {
+				return errors.New("shared node in flat model")
+			}
+			seen[e.idx] = true
+			n := ff.nodes[e.idx]
+			if n.feature < 0 {
+				continue
+			}
+			if int(n.feature) >= nFeatures {
+				return errors.New("invalid split feature in flat model")
+			}
+			// Children always follow their parent, which rules out cycles.
+			if n.left <= e.idx || int(n.left)+1 >= len(ff.nodes) {
+				return errors.New("invalid node index in flat model")
+			}
+			if e.depth >= maxTreeDepth {
+				return fmt.Errorf("tree deeper than %d in flat model", maxTreeDepth)
+			}
+			stack = append(stack, entry{n.left, e.depth + 1}, entry{n.left + 1, e.depth + 1})
+		}
+	}
+	return nil
+}
+
+// decompile rebuilds pointer-based trees from the flat records.
+func (ff *flatForest) decompile() []*iTree {
+	var build func(idx int32) *node
+	build = func(idx int32) *node {
+		fn := ff.nodes[idx]
+		n := &node{size: int(ff.sizes[idx])}
+		if fn.feature < 0 {
+			return n
+		}
+		n.splitFeature = int(fn.feature)
+		n.splitValue = fn.value
+		n.left = build(fn.left)
+		n.right = build(fn.left + 1)
+		return n
+	}
+
+	trees := make([]*iTree, len(ff.roots))
+	for i, root := range ff.roots {
+		trees[i] = &iTree{root: build(root)}
+	}
+	return trees
+}
+
+// treeSet returns the pointer-based trees, rebuilding them from the flat
+// records on first use after loadFlat, or from the quantized forest. The
+// caller holds at least the read lock.
+func (f *IsolationForest) treeSet() []*iTree {
+	f.decompile.Do(func() {
+		switch {
+		case f.trees != nil:
+		case f.flat != nil:
+			f.trees = f.flat.decompile()
+		case f.quant != nil:
+			f.trees = f.quant.decompile()
+		}
+	})
+	return f.trees
+}
@@ -0,0 +1,102 @@
+package iforest
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveFlat(t *testing.T) {
+	data := generateTestData(300, 4)
+	f := New(WithTrees(20), WithSeed(3), WithFeatureNames([]string{"a", "b", "c", "d"}))
+	require.NoError(t, f.Fit(data))
+
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+
+	loaded := New()
+	require.NoError(t, loaded.Load(flat))
+	assert.Nil(t, loaded.trees, "trees are rebuilt lazily")
+
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	got, err := loaded.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, f.Threshold(), loaded.Threshold())
+	assert.Equal(t, f.Metadata(), loaded.Metadata())
+	assert.Equal(t, f.TrainingProfile(), loaded.TrainingProfile())
+
+	wantExp, err := f.Explain(data[0])
+	require.NoError(t, err)
+	gotExp, err := loaded.Explain(data[0])
+	require.NoError(t, err)
+	assert.Equal(t, wantExp, gotExp)
+
+	// Rebuilt trees match the originals, so Save output is identical.
+	want2, err := f.Save()
+	require.NoError(t, err)
+	got2, err := loaded.Save()
+	require.NoError(t, err)
+	assert.Equal(t, want2, got2)
+}
+
+func TestSaveFlatUntrained(t *testing.T) {
+	_, err := New().SaveFlat()
+	assert.Error(t, err)
+}
+
+func TestLoadFlatValidation(t *testing.T) {
+	f := New(WithTrees(2), WithSampleSize(8))
+	require.NoError(t, f.Fit(generateTestData(50, 2)))
+	valid, err := f.SaveFlat()
+	require.NoError(t, err)
+
+	le := binary.LittleEndian
+	// node returns the byte offset of node i's record.
+	node := func(i int) int { return flatHeaderSize + i*flatNodeSize }
+	// modify returns a copy of the valid model changed by fn.
+	modify := func(fn func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		fn(b)
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "truncated header", data: valid[:20], wantErr: "truncated"},
+		{name: "truncated body", data: valid[:len(valid)-1], wantErr: "size does not match"},
+		{name: "version", data: modify(func(b []byte) { le.PutUint32(b[8:], 99) }), wantErr: "version 99"},
+		{name: "node count", data: modify(func(b []byte) { le.PutUint64(b[16:], 1<<62) }), wantErr: "size does not match"},
+		{name: "split feature", data: modify(func(b []byte) { le.PutUint32(b[node(0):], 7) }), wantErr: "invalid split feature"},
+		{name: "cycle", data: modify(func(b []byte) { le.PutUint32(b[node(0)+4:], 0) }), wantErr: "invalid node index"},
+		{name: "child out of range", data: modify(func(b []byte) { le.PutUint32(b[node(0)+4:], 1<<30) }), wantErr: "invalid node index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().Load(tt.data)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFlatValidateSharedNode(t *testing.T) {
+	ff := &flatForest{
+		nodes: []flatNode{
+			{feature: 0, left: 1},
+			{feature: 0, left: 3},
+			{feature: 0, left: 3},
+			{feature: -1},
+			{feature: -1},
+		},
+		sizes: make([]uint32, 5),
+		roots: []int32{0},
+	}
+	assert.ErrorContains(t, ff.validate(1), "shared node")
+}
@@ -0,0 +1,337 @@
+package iforest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// Save writes models in a versioned container:
+//
+//	magic    "GGIFSAVE"
+//	version  uint32, little-endian
+//	flags    uint32, little-endian: saveSigned
+//	body     goguardml.v1.Model Protocol Buffers message
+//	checksum SHA-256 of everything before it
+//	hmac     HMAC-SHA256 of everything before the checksum, if signed
+//
+// Load verifies the checksum, and the HMAC if the model has a signing key
+// (see WithSigningKey), before decoding the body. The body is the message
+// SaveProto writes, so programs outside Go read saved models with the
+// schema in proto/goguardml/v1/goguardml.proto once they strip the 16
+// bytes of header and the trailer.
+//
+// The body is built from savedModel and the saved* types it refers to.
+// They are kept apart from the in-memory types, so refactoring those
+// cannot change the format, and the schema follows rules that keep stored
+// models loadable across releases and architectures:
+//
+//   - fields may be added under new numbers, and decode as zero values
+//     from older files; readers skip fields they do not know
+//   - fields are never renumbered, retyped or reused
+//
+// A change that breaks these rules must bump saveVersion. Load rejects
+// versions newer than it knows with ErrUnsupportedVersion.
+//
+// Older releases wrote a gob-encoded savedModel as the body: version 2
+// with the same flags and trailer, version 1 with neither, unverified.
+// Models saved before the container existed (format 0) are a bare gob
+// stream of the same values. Load still reads all of them.
+const (
+	saveMagic   = "GGIFSAVE"
+	saveVersion = 3
+
+	// saveSigned flags containers ending with an HMAC.
+	saveSigned = 1 << 0
+)
+
+// ErrUnsupportedVersion is returned by Load for models written in a newer
+// format than this build reads. It is detectors.ErrModelVersion.
+var ErrUnsupportedVersion = detectors.ErrModelVersion
+
+// savedModel is the body of a saved model.
+type savedModel struct {
+	SampleSize    int
+	Contamination float64
+	Threshold     float64
+	AvgPathLength float64
+	Features      int
+	// Trees holds each tree's nodes in preorder. Quantized models store
+	// Quantized instead.
+	Trees       [][]savedNode
+	Quantized   *savedQuantForest
+	Importances []float64
+	Typical     []savedRange
+	Profile     *savedProfile
+	Card        container.Card
+	Constant    []int
+}
+
+// savedRange is the serialized form of detectors.Range.
+type savedRange struct {
+	Low  float64
+	High float64
+}
+
+// savedProfile is the serialized form of stats.Profile.
+type savedProfile struct {
+	Samples  int
+	Features []savedFeatureProfile
+}
+
+// savedFeatureProfile is the serialized form of stats.FeatureProfile.
+type savedFeatureProfile struct {
+	Mean      float64
+	StdDev    float64
+	Edges     []float64
+	Fractions []float64
+	Quantiles []float64
+}
+
+// isSaved reports whether data starts with the versioned container header.
+func isSaved(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(saveMagic))
+}
+
+// encodeSaved writes the container. The caller holds at least the read
+// lock.
+func (f *IsolationForest) encodeSaved() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.writeSaved(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeSaved writes the container to w. The caller holds at least the
+// read lock.
+func (f *IsolationForest) writeSaved(w io.Writer) error {
+	var flags uint32
+	if f.signingKey != nil {
+		flags |= saveSigned
+	}
+	header := binary.LittleEndian.AppendUint32([]byte(saveMagic), saveVersion)
+	header = binary.LittleEndian.AppendUint32(header, flags)
+	body := f.marshalProto()
+
+	sums := f.newSums(flags)
+	sums.Write(header)
+	sums.Write(body)
+	for _, p := range [][]byte{header, body, sums.trailer()} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saved returns the serialized form of the model. The caller holds at
+// least the read lock.
+func (f *IsolationForest) saved() savedModel {
+	m := savedModel{
+		SampleSize:    f.sampleSize,
+		Contamination: f.contamination,
+		Threshold:     f.threshold,
+		AvgPathLength: f.avgPathLength,
+		Features:      f.nFeatures,
+		Importances:   f.importances,
+		Typical:       newSavedRanges(f.typical),
+		Profile:       newSavedProfile(f.profile),
+		Card:          container.NewCard(f.card),
+		Constant:      f.constant,
+	}
+	if f.quant != nil {
+		m.Quantized = f.quant.saved()
+	} else {
+		m.Trees = flattenTrees(f.treeSet())
+	}
+	return m
+}
+
+// loadSaved reads the container. Its trailer is verified before the body
+// is decoded, and f is only modified once the whole model has been decoded
+// and validated. The caller holds the write lock.
+func (f *IsolationForest) loadSaved(data []byte) error {
+	if err := f.verifySaved(data); err != nil {
+		return err
+	}
+	return f.readSaved(bytes.NewReader(data))
+}
+
+// readSaved reads the container from r, like loadSaved but verifying the
+// trailer only once the body has been read. Version 2 bodies are decoded
+// as they are read; version 3 bodies are read whole first.
+func (f *IsolationForest) readSaved(r byteReader) error {
+	header := make([]byte, len(saveMagic)+4)
+	if err := readHeader(r, header); err != nil {
+		return err
+	}
+	version := binary.LittleEndian.Uint32(header[len(saveMagic):])
+	if version == 1 {
+		if err := f.unsigned("version 1"); err != nil {
+			return err
+		}
+		var m savedModel
+		if err := gob.NewDecoder(r).Decode(&m); err != nil {
+			return fmt.Errorf("decode model: %w", err)
+		}
+		return f.restore(&m)
+	}
+	if version != 2 && version != saveVersion {
+		return fmt.Errorf("%w %d (this build reads up to %d)", ErrUnsupportedVersion, version, saveVersion)
+	}
+	header = append(header, 0, 0, 0, 0)
+	if err := readHeader(r, header[len(header)-4:]); err != nil {
+		return err
+	}
+	sums := f.newSums(binary.LittleEndian.Uint32(header[len(header)-4:]))
+	sums.Write(header)
+
+	var m savedModel
+	if version == 2 {
+		if err := gob.NewDecoder(&hashingReader{r, sums}).Decode(&m); err != nil {
+			return fmt.Errorf("decode model: %w", err)
+		}
+		trailer := make([]byte, sums.size())
+		if _, err := io.ReadFull(r, trailer); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%w: truncated trailer", ErrChecksum)
+			}
+			return err
+		}
+		if err := f.checkTrailer(sums, trailer); err != nil {
+			return err
+		}
+		return f.restore(&m)
+	}
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	end := len(rest) - sums.size()
+	if end < 0 {
+		return fmt.Errorf("%w: truncated trailer", ErrChecksum)
+	}
+	sums.Write(rest[:end])
+	if err := f.checkTrailer(sums, rest[end:]); err != nil {
+		return err
+	}
+	if m, err = unmarshalProto(rest[:end]); err != nil {
+		return err
+	}
+	return f.restore(&m)
+}
+
+// readHeader reads len(header) bytes of the container header.
+func readHeader(r io.Reader, header []byte) error {
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("truncated model header")
+		}
+		return err
+	}
+	return nil
+}
+
+// restore replaces the model with m, once it has been validated. The
+// caller holds the write lock.
+func (f *IsolationForest) restore(m *savedModel) error {
+	var (
+		trees []*iTree
+		flat  *flatForest
+		quant *quantForest
+		err   error
+	)
+	switch {
+	case m.Quantized != nil:
+		if quant, err = m.Quantized.forest(m.Features); err != nil {
+			return err
+		}
+	case len(m.Trees) == 0:
+		return errors.New("model has no trees")
+	default:
+		if trees, err = unflattenTrees(m.Trees); err != nil {
+			return err
+		}
+		if m.Features <= maxSplitFeature(trees) {
+			return fmt.Errorf("model uses features beyond its %d", m.Features)
+		}
+		flat = compileForest(trees)
+	}
+
+	if quant != nil {
+		f.nTrees = len(quant.roots)
+	} else {
+		f.nTrees = len(trees)
+	}
+	f.sampleSize = m.SampleSize
+	f.contamination = m.Contamination
+	f.threshold = m.Threshold
+	f.avgPathLength = m.AvgPathLength
+	f.nFeatures = m.Features
+	f.trees = trees
+	f.flat = flat
+	f.quant = quant
+	f.decompile = sync.Once{}
+	f.importances = m.Importances
+	f.typical = m.typicalRanges()
+	f.profile = m.Profile.profile()
+	f.card = m.Card.ModelCard()
+	f.constant = m.Constant
+	f.maxDepth = depthLimit(f.sampleSize)
+	f.trained = true
+	return nil
+}
+
+func newSavedRanges(ranges []detectors.Range) []savedRange {
+	if ranges == nil {
+		return nil
+	}
+	out := make([]savedRange, len(ranges))
+	for i, r := range ranges {
+		out[i] = savedRange(r)
+	}
+	return out
+}
+
+func (m *savedModel) typicalRanges() []detectors.Range {
+	if m.Typical == nil {
+		return nil
+	}
+	out := make([]detectors.Range, len(m.Typical))
+	for i, r := range m.Typical {
+		out[i] = detectors.Range(r)
+	}
+	return out
+}
+
+func newSavedProfile(p *stats.Profile) *savedProfile {
+	if p == nil {
+		return nil
+	}
+	s := &savedProfile{Samples: p.Samples, Features: make([]savedFeatureProfile, len(p.Features))}
+	for i, fp := range p.Features {
+		s.Features[i] = savedFeatureProfile(fp)
+	}
+	return s
+}
+
+func (s *savedProfile) profile() *stats.Profile {
+	if s == nil {
+		return nil
+	}
+	p := &stats.Profile{Samples: s.Samples, Features: make([]stats.FeatureProfile, len(s.Features))}
+	for i, fp := range s.Features {
+		p.Features[i] = stats.FeatureProfile(fp)
+	}
+	return p
+}
@@ -0,0 +1,252 @@
+package iforest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var update = flag.Bool("update", false, "rewrite the current-format golden models in testdata")
+
+// golden holds probe samples and the scores the golden models give them.
+type golden struct {
+	Threshold float64     `json:"threshold"`
+	Samples   [][]float64 `json:"samples"`
+	Scores    []float64   `json:"scores"`
+}
+
+// The golden models were trained once, on amd64, and are checked in: each
+// release must load every one of them, on every architecture, with the
+// recorded scores. model-v0.gob predates the versioned format,
+// model-v1.bin its checksums and model-v2.bin its Protocol Buffers body;
+// none can be written any longer, and -update regenerates the current
+// formats from the first.
+func TestGoldenModels(t *testing.T) {
+	if *update {
+		f := New()
+		legacy, err := os.ReadFile(filepath.Join("testdata", "model-v0.gob"))
+		require.NoError(t, err)
+		require.NoError(t, f.Load(legacy))
+
+		saved, err := f.Save()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join("testdata", "model-v3.bin"), saved, 0o644))
+		flat, err := f.SaveFlat()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join("testdata", "model-flat-v1.bin"), flat, 0o644))
+		proto, err := f.SaveProto()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join("testdata", "model-proto-v1.bin"), proto, 0o644))
+	}
+
+	raw, err := os.ReadFile(filepath.Join("testdata", "golden.json"))
+	require.NoError(t, err)
+	var want golden
+	require.NoError(t, json.Unmarshal(raw, &want))
+
+	for _, name := range []string{"model-v0.gob", "model-v1.bin", "model-v2.bin", "model-v3.bin", "model-flat-v1.bin", "model-proto-v1.bin"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			require.NoError(t, err)
+
+			f := New()
+			require.NoError(t, f.Load(data))
+			assert.Equal(t, want.Threshold, f.Threshold())
+			assert.Equal(t, 20, f.nTrees)
+			assert.Equal(t, []string{"a", "b", "c"}, f.Metadata().FeatureNames)
+			assert.Equal(t, "golden", f.Metadata().DataSource)
+			require.NotNil(t, f.TrainingProfile())
+			assert.Len(t, f.TrainingProfile().Features, 3)
+
+			scores, err := f.Predict(want.Samples)
+			require.NoError(t, err)
+			// Allow for fused multiply-add on other architectures.
+			assert.InDeltaSlice(t, want.Scores, scores, 1e-12)
+
+			explanation, err := f.Explain(want.Samples[3])
+			require.NoError(t, err)
+			assert.NotNil(t, explanation.Top[0].Typical, "typical ranges survive")
+
+			streamed := New()
+			require.NoError(t, streamed.LoadFrom(iotest.OneByteReader(bytes.NewReader(data))))
+			scores, err = streamed.Predict(want.Samples)
+			require.NoError(t, err)
+			assert.InDeltaSlice(t, want.Scores, scores, 1e-12)
+			assert.Equal(t, f.Metadata(), streamed.Metadata())
+		})
+	}
+}
+
+func TestSaveFormat(t *testing.T) {
+	f := New(WithTrees(5))
+	require.NoError(t, f.Fit(generateTestData(50, 2)))
+	saved, err := f.Save()
+	require.NoError(t, err)
+	assert.True(t, isSaved(saved))
+
+	t.Run("newer version", func(t *testing.T) {
+		future := append([]byte(nil), saved...)
+		binary.LittleEndian.PutUint32(future[len(saveMagic):], saveVersion+1)
+		assert.ErrorIs(t, New().Load(future), ErrUnsupportedVersion)
+		assert.ErrorIs(t, New().Load(future), detectors.ErrModelVersion)
+
+		flat, err := f.SaveFlat()
+		require.NoError(t, err)
+		binary.LittleEndian.PutUint32(flat[len(flatMagic):], flatVersion+1)
+		assert.ErrorIs(t, New().Load(flat), ErrUnsupportedVersion)
+	})
+
+	t.Run("failed load leaves the model untouched", func(t *testing.T) {
+		g := New()
+		assert.Error(t, g.Load(saved[:len(saved)/2]))
+		assert.Error(t, g.Load(saved[:len(saveMagic)+2]))
+		assert.False(t, g.Trained())
+	})
+}
+
+// legacyModel writes f in format 0, as releases before the versioned
+// format did, with the feature count nFeatures.
+func legacyModel(t *testing.T, f *IsolationForest, nFeatures int) []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for _, v := range []any{f.nTrees, f.sampleSize, f.contamination, f.threshold, f.avgPathLength, flattenTrees(f.trees), nFeatures} {
+		require.NoError(t, enc.Encode(v))
+	}
+	return buf.Bytes()
+}
+
+func TestLoadLegacy(t *testing.T) {
+	data := generateTestData(50, 2)
+	f := New(WithTrees(5))
+	require.NoError(t, f.Fit(data))
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+
+	g := New()
+	require.NoError(t, g.Load(legacyModel(t, f, 2)))
+	got, err := g.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	t.Run("failed load leaves the model untouched", func(t *testing.T) {
+		other := New(WithTrees(5), WithSeed(7))
+		require.NoError(t, other.Fit(generateTestData(50, 2)))
+
+		// Splits on the second feature of a model claiming one would
+		// index past the samples Predict accepts.
+		assert.ErrorContains(t, g.Load(legacyModel(t, other, 1)), "features beyond")
+		saved := legacyModel(t, other, 2)
+		assert.Error(t, g.Load(saved[:len(saved)/2]))
+
+		got, err := g.Predict(data)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+// gobContainer writes f in format version 2, with a gob body, as releases
+// before the Protocol Buffers body did.
+func gobContainer(t *testing.T, f *IsolationForest) []byte {
+	t.Helper()
+	var flags uint32
+	if f.signingKey != nil {
+		flags |= saveSigned
+	}
+	buf := bytes.NewBuffer(binary.LittleEndian.AppendUint32([]byte(saveMagic), 2))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, flags))
+	m := f.saved()
+	require.NoError(t, gob.NewEncoder(buf).Encode(&m))
+	sums := f.newSums(flags)
+	sums.Write(buf.Bytes())
+	return append(buf.Bytes(), sums.trailer()...)
+}
+
+func TestLoadVersion2(t *testing.T) {
+	key := []byte("secret")
+	data := generateTestData(50, 2)
+	f := New(WithTrees(5), WithSigningKey(key))
+	require.NoError(t, f.Fit(data))
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	saved := gobContainer(t, f)
+
+	g := New(WithSigningKey(key))
+	require.NoError(t, g.Load(saved))
+	got, err := g.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, f.Metadata(), g.Metadata())
+
+	g = New(WithSigningKey(key))
+	require.NoError(t, g.LoadFrom(iotest.OneByteReader(bytes.NewReader(saved))))
+	got, err = g.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	resaved, err := g.Save()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(saveVersion), binary.LittleEndian.Uint32(resaved[len(saveMagic):]), "models are saved in the current version")
+
+	corrupt := append([]byte(nil), saved...)
+	corrupt[len(corrupt)/2] ^= 0x40
+	assert.ErrorIs(t, New().Load(corrupt), ErrChecksum)
+	assert.ErrorIs(t, New(WithSigningKey([]byte("other"))).Load(saved), ErrSignature)
+	assert.ErrorIs(t, New(WithSigningKey([]byte("other"))).LoadFrom(bytes.NewReader(saved)), ErrSignature)
+}
+
+func TestSaveToLoadFrom(t *testing.T) {
+	f := New(WithTrees(5))
+	assert.Error(t, f.SaveTo(io.Discard), "untrained")
+	data := generateTestData(50, 2)
+	require.NoError(t, f.Fit(data))
+
+	saved, err := f.Save()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, f.SaveTo(&buf))
+	assert.Equal(t, saved, buf.Bytes(), "SaveTo writes the Save format")
+
+	g := New()
+	require.NoError(t, g.LoadFrom(&buf))
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	got, err := g.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	t.Run("failed load leaves the model untouched", func(t *testing.T) {
+		g := New()
+		assert.Error(t, g.LoadFrom(bytes.NewReader(saved[:len(saved)/2])))
+		assert.Error(t, g.LoadFrom(bytes.NewReader(saved[:len(saveMagic)+2])))
+		assert.Error(t, g.LoadFrom(bytes.NewReader(nil)))
+		assert.False(t, g.Trained())
+	})
+
+	t.Run("read errors", func(t *testing.T) {
+		boom := errors.New("boom")
+		r := io.MultiReader(bytes.NewReader(saved[:len(saveMagic)+1]), iotest.ErrReader(boom))
+		assert.ErrorIs(t, New().LoadFrom(r), boom)
+		assert.ErrorIs(t, New().LoadFrom(iotest.ErrReader(boom)), boom)
+	})
+
+	t.Run("write errors", func(t *testing.T) {
+		assert.Error(t, f.SaveTo(errWriter{}))
+	})
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
@@ -2,32 +2,64 @@
 package iforest
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"slices"
 	"sync"
 
 	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+	"github.com/hed1ad/goguardml/pkg/pb"
+	"github.com/hed1ad/goguardml/pkg/stats"
 )
 
 // IsolationForest implements unsupervised anomaly detection using isolation trees.
 type IsolationForest struct {
-	mu sync.RWMutex
+	mu      sync.RWMutex
+	refitMu sync.Mutex // serializes Refit calls
 
 	// Configuration
-	nTrees        int
-	sampleSize    int
-	contamination float64
-	threshold     float64
-	maxDepth      int
-	rng           *rand.Rand
+	nTrees          int
+	sampleSize      int
+	contamination   float64
+	threshold       float64
+	maxDepth        int
+	explainTop      int
+	severity        *detectors.SeverityBands
+	workers         int
+	copyData        bool
+	excludeConstant bool
+	featureWeights  []float64
+	quantBits       int
+	signingKey      []byte
+	onReject        detectors.RejectFunc
+	scoreStats      *stats.ScoreStats
+	telemetry       telemetry
+	seed            int64
+	rng             *rand.Rand
+	dataSource      string
+	featureNames    []string
 
 	// Trained model
-	trees   []*iTree
-	trained bool
+	trees       []*iTree
+	flat        *flatForest  // trees compiled for scoring
+	quant       *quantForest // replaces flat and trees when quantized
+	decompile   sync.Once    // rebuilds trees from flat or quant
+	unmap       func() error
+	nFeatures   int
+	constant    []int             // features constant in training data
+	importances []float64         // global DIFFI importances
+	typical     []detectors.Range // central range of each feature in training data
+	profile     *stats.Profile    // per-feature training distribution
+	card        detectors.ModelCard
+	trained     bool
 
 	// Statistics from training
 	avgPathLength float64
@@ -48,8 +80,8 @@ type node struct {
 	left  *node
 	right *node
 
-	// Leaf information
-	size int // number of samples that reached this leaf
+	// size is the number of training samples that reached this node
+	size int
 }
 
 // Option configures an IsolationForest.
@@ -79,41 +111,321 @@ func WithContamination(c float64) Option {
 // WithSeed sets the random seed for reproducibility.
 func WithSeed(seed int64) Option {
 	return func(f *IsolationForest) {
+		f.seed = seed
 		f.rng = rand.New(rand.NewSource(seed))
 	}
 }
 
-// New creates a new IsolationForest with the given options.
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(f *IsolationForest) {
+		f.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(f *IsolationForest) {
+		f.severity = &b
+	}
+}
+
+// WithWorkers sets the number of goroutines used to build trees, score
+// batches and score streams. n <= 0, the default, uses
+// detectors.DefaultWorkers at each call.
+func WithWorkers(n int) Option {
+	return func(f *IsolationForest) {
+		f.workers = n
+	}
+}
+
+// WithCopyData sets whether Fit copies the training data before use. It
+// does by default, which protects training from callers that modify or
+// reuse their rows concurrently. Disable it to train on very large inputs
+// without the extra memory; data must then stay unchanged until Fit
+// returns.
+func WithCopyData(enabled bool) Option {
+	return func(f *IsolationForest) {
+		f.copyData = enabled
+	}
+}
+
+// WithRejectHandler makes PredictStream pass samples it cannot score, such
+// as samples of the wrong dimension, to fn instead of dropping them.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(f *IsolationForest) {
+		f.onReject = fn
+	}
+}
+
+// WithScoreStats records every score returned by Predict, PredictOne and
+// PredictStream in s.
+func WithScoreStats(s *stats.ScoreStats) Option {
+	return func(f *IsolationForest) {
+		f.scoreStats = s
+	}
+}
+
+// ErrInvalidOption is matched by every *OptionError. It wraps
+// detectors.ErrInvalidOption, which matches too.
+var ErrInvalidOption = fmt.Errorf("iforest: %w", detectors.ErrInvalidOption)
+
+// OptionError reports an option set to a value the forest cannot train
+// with.
+type OptionError struct {
+	// Option is the name of the option function, such as "WithTrees".
+	Option string
+	Value  any
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("iforest: %s(%v): %s", e.Option, e.Value, e.Reason)
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidOption) hold.
+func (e *OptionError) Unwrap() error {
+	return ErrInvalidOption
+}
+
+// New creates a new IsolationForest with the given options. Options
+// without a meaningful negative value, such as WithWorkers and
+// WithExplanations, are clamped to zero; other invalid values are kept and
+// reported by Validate and Fit.
 func New(opts ...Option) *IsolationForest {
 	f := &IsolationForest{
 		nTrees:        100,
 		sampleSize:    256,
 		contamination: 0.1,
 		threshold:     0.5,
+		seed:          42,
 		rng:           rand.New(rand.NewSource(42)),
+		copyData:      true,
 	}
 
 	for _, opt := range opts {
 		opt(f)
 	}
 
-	// Max depth based on sample size
-	f.maxDepth = int(math.Ceil(math.Log2(float64(f.sampleSize))))
+	f.workers = max(f.workers, 0)
+	f.explainTop = max(f.explainTop, 0)
+	f.maxDepth = depthLimit(f.sampleSize)
 
 	return f
 }
 
+// Validate reports every option set to an invalid value, joining the
+// *OptionError of each. Call it right after New to catch misconfiguration
+// at startup; Fit returns the same error before training.
+func (f *IsolationForest) Validate() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.validate()
+}
+
+func (f *IsolationForest) validate() error {
+	var errs []error
+	if f.nTrees < 1 {
+		errs = append(errs, &OptionError{Option: "WithTrees", Value: f.nTrees, Reason: "need at least one tree"})
+	}
+	if f.sampleSize < 2 {
+		errs = append(errs, &OptionError{Option: "WithSampleSize", Value: f.sampleSize, Reason: "need at least two samples per tree"})
+	}
+	if !(f.contamination >= 0 && f.contamination < 1) {
+		errs = append(errs, &OptionError{Option: "WithContamination", Value: f.contamination, Reason: "must be in [0, 1)"})
+	}
+	if f.quantBits != 0 && f.quantBits != 8 && f.quantBits != 16 {
+		errs = append(errs, &OptionError{Option: "WithQuantization", Value: f.quantBits, Reason: "must be 0, 8 or 16"})
+	}
+	if err := validateWeights(f.featureWeights); err != nil {
+		errs = append(errs, err)
+	}
+	if f.severity != nil {
+		if err := f.severity.Validate(); err != nil {
+			errs = append(errs, &OptionError{Option: "WithSeverityBands", Value: *f.severity, Reason: "bounds must not decrease"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// maxTreeDepth bounds the depth of any tree, including trees read by Load,
+// so traversal and the recursive tree walks stay bounded on malformed or
+// hostile model files.
+const maxTreeDepth = 32
+
+// depthLimit returns the height limit of trees grown from sampleSize
+// samples: ceil(log2(sampleSize)), the average depth of an unsuccessful
+// search, capped at maxTreeDepth.
+func depthLimit(sampleSize int) int {
+	if sampleSize <= 1 {
+		return 0
+	}
+	return min(int(math.Ceil(math.Log2(float64(sampleSize)))), maxTreeDepth)
+}
+
 // Fit trains the Isolation Forest on the provided data.
+//
+// Fit holds the model exclusively while it trains: scoring calls made in
+// the meantime wait for it to return. To retrain a model that is in
+// service, use Refit. If training fails, the current model stays in place.
+//
+// Aliasing: by default Fit copies data before training (see WithCopyData),
+// so callers may reuse or modify their rows while Fit runs. Either way the
+// trained model holds no reference to data once Fit returns.
 func (f *IsolationForest) Fit(data [][]float64) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	next, err := f.fitNext(data, f.featureNames)
+	if err != nil {
+		return err
+	}
+	return f.swap(next)
+}
+
+var _ detectors.Refitter = (*IsolationForest)(nil)
+
+// Refit trains a new model on data and swaps it in atomically. Unlike Fit
+// it does not block scoring while training: Predict, PredictOne, Explain
+// and PredictStream keep using the current model until the new one is
+// complete, and every score, with its anomaly flag and explanation, comes
+// from exactly one of the two models. A stream switches models between
+// samples. If training fails, the current model stays in place.
+//
+// The new model takes its threshold from the contamination setting, like
+// Fit; with contamination 0 the current threshold is kept. A model opened
+// with OpenMapped is unmapped once the new one has replaced it. Concurrent
+// Refit calls are serialized.
+func (f *IsolationForest) Refit(data [][]float64) error {
+	f.refitMu.Lock()
+	defer f.refitMu.Unlock()
+
+	f.mu.Lock()
+	next := f.cloneConfig()
+	f.mu.Unlock()
+
+	if err := next.fit(data, next.featureNames); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.swap(next)
+}
+
+// cloneConfig returns an untrained forest with f's configuration, seeded
+// from f's generator so successive refits grow different forests. The
+// caller holds the write lock.
+func (f *IsolationForest) cloneConfig() *IsolationForest {
+	return &IsolationForest{
+		nTrees:          f.nTrees,
+		sampleSize:      f.sampleSize,
+		contamination:   f.contamination,
+		threshold:       f.threshold,
+		maxDepth:        f.maxDepth,
+		explainTop:      f.explainTop,
+		severity:        f.severity,
+		workers:         f.workers,
+		copyData:        f.copyData,
+		excludeConstant: f.excludeConstant,
+		featureWeights:  f.featureWeights,
+		quantBits:       f.quantBits,
+		seed:            f.seed,
+		rng:             rand.New(rand.NewSource(f.rng.Int63())),
+		dataSource:      f.dataSource,
+		featureNames:    f.featureNames,
+	}
+}
+
+// fitNext trains a forest with f's configuration on data, drawing from
+// f's own generator so a seeded forest grows the same trees whether it is
+// fitted once or again. f is left as it is, so a failed fit leaves the
+// current model in place. The caller holds the write lock.
+func (f *IsolationForest) fitNext(data [][]float64, names []string) (*IsolationForest, error) {
+	next := f.cloneConfig()
+	next.rng = f.rng
+	if err := next.fit(data, names); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// swap replaces f's trained model with next's, releasing the mapping of a
+// model opened with OpenMapped. The caller holds the write lock.
+func (f *IsolationForest) swap(next *IsolationForest) error {
+	err := f.release()
+	f.trees = next.trees
+	f.flat = next.flat
+	f.quant = next.quant
+	f.decompile = sync.Once{}
+	f.nFeatures = next.nFeatures
+	f.constant = next.constant
+	f.importances = next.importances
+	f.typical = next.typical
+	f.profile = next.profile
+	f.card = next.card
+	f.threshold = next.threshold
+	f.avgPathLength = next.avgPathLength
+	f.trained = true
+	return err
+}
+
+// release releases the mapping of a model opened with OpenMapped, once
+// its trees no longer reference it. The caller holds the write lock;
+// readers hold the read lock while using the mapping, so none can still
+// be using it.
+func (f *IsolationForest) release() error {
+	if f.unmap == nil {
+		return nil
+	}
+	unmap := f.unmap
+	f.unmap = nil
+	return unmap()
+}
+
+// fit trains on data, recording names in the model card. It fills in f
+// as it goes, so it is only called on a forest nothing else uses yet,
+// such as one from cloneConfig.
+func (f *IsolationForest) fit(data [][]float64, names []string) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
 	if len(data) == 0 {
 		return errors.New("empty training data")
 	}
 
 	nSamples := len(data)
 	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if names != nil && len(names) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(names), nFeatures)
+	}
+	if f.featureWeights != nil && len(f.featureWeights) != nFeatures {
+		return fmt.Errorf("%d feature weights for %d features", len(f.featureWeights), nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+	}
+	// A single row says nothing about which features vary.
+	var constant []int
+	if nSamples > 1 {
+		constant = constantFeatures(data, nFeatures)
+	}
+	if len(constant) == nFeatures {
+		return ErrConstantData
+	}
+	features := f.splitFeatures(nFeatures, constant)
+	if !slices.ContainsFunc(features, func(j int) bool { return !slices.Contains(constant, j) }) {
+		return ErrNoWeightedFeatures
+	}
+	if f.copyData {
+		data = cloneRows(data)
+	}
 
 	// Adjust sample size if needed
 	sampleSize := f.sampleSize
@@ -121,151 +433,245 @@ func (f *IsolationForest) Fit(data [][]float64) error {
 		sampleSize = nSamples
 	}
 
-	// Build trees
+	// Build trees. Each tree draws from its own generator seeded from f.rng,
+	// so the forest does not depend on the number of workers.
+	seeds := make([]int64, f.nTrees)
+	for i := range seeds {
+		seeds[i] = f.rng.Int63()
+	}
 	f.trees = make([]*iTree, f.nTrees)
-	for i := 0; i < f.nTrees; i++ {
-		// Sample without replacement
-		indices := f.rng.Perm(nSamples)[:sampleSize]
-		sample := make([][]float64, sampleSize)
-		for j, idx := range indices {
-			sample[j] = data[idx]
+	detectors.ParallelFor(f.nTrees, detectors.Workers(f.workers), 1, func(lo, hi int) {
+		rng := rand.New(rand.NewSource(0))
+		s := newSampler(nSamples, sampleSize)
+		for i := lo; i < hi; i++ {
+			rng.Seed(seeds[i])
+			f.trees[i] = f.buildTree(data, s.draw(rng), features, rng)
 		}
-
-		f.trees[i] = f.buildTree(sample, nFeatures, 0)
-	}
+	})
 
 	// Calculate average path length for normalization
 	f.avgPathLength = averagePathLength(float64(sampleSize))
+	f.flat = compileForest(f.trees)
+	f.quant = nil
+	f.decompile = sync.Once{}
+	f.nFeatures = nFeatures
+	f.constant = constant
 	f.trained = true
+	// Quantize before setting the threshold, so it matches the scores of
+	// the quantized trees. The pointer trees are kept for the statistics
+	// below.
+	if f.quantBits > 0 {
+		q, err := quantize(f.flat, nFeatures, f.quantBits)
+		if err != nil {
+			return err
+		}
+		f.quant, f.flat = q, nil
+	}
 
 	// Set threshold based on contamination
 	if f.contamination > 0 {
-		scores, _ := f.predict(data)
-		f.threshold = percentile(scores, 100*(1-f.contamination))
+		f.threshold = f.calibrate(len(data), func(i int) []float64 { return data[i] })
+	}
+
+	importances, err := f.fitDIFFI(data)
+	if err != nil {
+		return err
+	}
+	f.importances = importances
+	f.typical = typicalRanges(data, diffiStep(len(data)))
+
+	profile, err := stats.NewProfile(strided(data, diffiStep(len(data))), profileBins)
+	if err != nil {
+		return err
+	}
+	f.profile = profile
+	f.card = f.modelCard(data, names)
+	if f.quant != nil {
+		// Rebuilt from the quantized trees if explanations need them.
+		f.trees = nil
+		f.decompile = sync.Once{}
 	}
 
 	return nil
 }
 
-// buildTree recursively builds an isolation tree.
-func (f *IsolationForest) buildTree(data [][]float64, nFeatures, depth int) *iTree {
-	return &iTree{
-		root: f.buildNode(data, nFeatures, depth),
+// cloneRows deep-copies data into one contiguous block.
+func cloneRows(data [][]float64) [][]float64 {
+	total := 0
+	for _, row := range data {
+		total += len(row)
+	}
+	values := make([]float64, 0, total)
+	rows := make([][]float64, len(data))
+	for i, row := range data {
+		start := len(values)
+		values = append(values, row...)
+		rows[i] = values[start:len(values):len(values)]
+	}
+	return rows
+}
+
+// buildTree builds an isolation tree over the rows of data listed in idx,
+// reordering idx in place. Splits are drawn from features.
+func (f *IsolationForest) buildTree(data [][]float64, idx []int, features []int, rng *rand.Rand) *iTree {
+	b := &treeBuilder{
+		f:        f,
+		rng:      rng,
+		data:     data,
+		features: features,
+		cum:      cumulativeWeights(f.featureWeights, features),
+		// A tree over n samples has at most 2n-1 nodes.
+		slab: make([]node, 0, 2*len(idx)),
+	}
+	return &iTree{root: b.build(idx, 0)}
+}
+
+// treeBuilder grows one isolation tree. Nodes are carved from a slab
+// rather than allocated one by one.
+type treeBuilder struct {
+	f        *IsolationForest
+	rng      *rand.Rand
+	data     [][]float64
+	features []int
+	cum      []float64 // cumulative weights of features, nil if equal
+	slab     []node
+}
+
+// newNode returns a node from the slab. A full slab is replaced, not grown,
+// so nodes already handed out keep their addresses.
+func (b *treeBuilder) newNode(n node) *node {
+	if len(b.slab) == cap(b.slab) {
+		b.slab = make([]node, 0, max(cap(b.slab), 16))
 	}
+	b.slab = append(b.slab, n)
+	return &b.slab[len(b.slab)-1]
 }
 
-func (f *IsolationForest) buildNode(data [][]float64, nFeatures, depth int) *node {
-	n := len(data)
+// build grows the subtree over the rows in idx, partitioning idx in place.
+func (b *treeBuilder) build(idx []int, depth int) *node {
+	n := len(idx)
 
 	// Terminal conditions
-	if depth >= f.maxDepth || n <= 1 {
-		return &node{size: n}
+	if depth >= b.f.maxDepth || n <= 1 {
+		return b.newNode(node{size: n})
 	}
 
 	// Random feature and split value
-	feature := f.rng.Intn(nFeatures)
+	feature := drawFeature(b.features, b.cum, b.rng)
 
 	// Find min/max for this feature
-	minVal, maxVal := data[0][feature], data[0][feature]
-	for _, row := range data[1:] {
-		if row[feature] < minVal {
-			minVal = row[feature]
+	minVal, maxVal := b.data[idx[0]][feature], b.data[idx[0]][feature]
+	for _, i := range idx[1:] {
+		v := b.data[i][feature]
+		if v < minVal {
+			minVal = v
 		}
-		if row[feature] > maxVal {
-			maxVal = row[feature]
+		if v > maxVal {
+			maxVal = v
 		}
 	}
 
 	// If all values are the same, return leaf
 	if minVal == maxVal {
-		return &node{size: n}
+		return b.newNode(node{size: n})
 	}
 
 	// Random split value
-	splitValue := minVal + f.rng.Float64()*(maxVal-minVal)
+	splitValue := minVal + b.rng.Float64()*(maxVal-minVal)
 
-	// Partition data
-	var leftData, rightData [][]float64
-	for _, row := range data {
-		if row[feature] < splitValue {
-			leftData = append(leftData, row)
+	// Partition in place: rows below the split end up in idx[:lo].
+	lo, hi := 0, n
+	for lo < hi {
+		if b.data[idx[lo]][feature] < splitValue {
+			lo++
 		} else {
-			rightData = append(rightData, row)
+			hi--
+			idx[lo], idx[hi] = idx[hi], idx[lo]
 		}
 	}
 
-	return &node{
-		splitFeature: feature,
-		splitValue:   splitValue,
-		left:         f.buildNode(leftData, nFeatures, depth+1),
-		right:        f.buildNode(rightData, nFeatures, depth+1),
-	}
+	nd := b.newNode(node{splitFeature: feature, splitValue: splitValue, size: n})
+	nd.left = b.build(idx[:lo], depth+1)
+	nd.right = b.build(idx[lo:], depth+1)
+	return nd
 }
 
 // Predict returns anomaly scores for the given samples.
 func (f *IsolationForest) Predict(data [][]float64) ([]float64, error) {
+	return f.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, abandoning the batch with ctx.Err() once ctx
+// is done. Scoring workers check ctx every few thousand samples.
+func (f *IsolationForest) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	defer f.telemetry.report()
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
 	if !f.trained {
-		return nil, errors.New("model not trained")
+		return nil, detectors.ErrNotTrained
 	}
 
-	return f.predict(data)
+	scores, err := f.predict(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if f.scoreStats != nil {
+		f.scoreStats.AddAll(scores, f.threshold)
+	}
+	f.telemetry.observe(f, len(data), func(i int) []float64 { return data[i] })
+	return scores, nil
 }
 
-func (f *IsolationForest) predict(data [][]float64) ([]float64, error) {
-	scores := make([]float64, len(data))
-
+func (f *IsolationForest) predict(ctx context.Context, data [][]float64) ([]float64, error) {
 	for i, sample := range data {
-		score, err := f.predictOne(sample)
-		if err != nil {
-			return nil, err
+		if len(sample) != f.nFeatures {
+			return nil, fmt.Errorf("sample %d: %w", i, f.dimensionError(sample))
 		}
-		scores[i] = score
 	}
-
+	scores := make([]float64, len(data))
+	if err := f.scoreRows(ctx, len(data), func(i int) []float64 { return data[i] }, scores); err != nil {
+		return nil, err
+	}
 	return scores, nil
 }
 
 // PredictOne returns the anomaly score for a single sample.
 func (f *IsolationForest) PredictOne(sample []float64) (float64, error) {
+	defer f.telemetry.report()
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
 	if !f.trained {
-		return 0, errors.New("model not trained")
+		return 0, detectors.ErrNotTrained
 	}
 
-	return f.predictOne(sample)
+	score, err := f.predictOne(sample)
+	if err != nil {
+		return 0, err
+	}
+	if f.scoreStats != nil {
+		f.scoreStats.Add(score, score >= f.threshold)
+	}
+	f.telemetry.observe(f, 1, func(int) []float64 { return sample })
+	return score, nil
 }
 
 func (f *IsolationForest) predictOne(sample []float64) (float64, error) {
-	// Average path length across all trees
-	var totalPath float64
-	for _, tree := range f.trees {
-		totalPath += pathLength(sample, tree.root, 0)
+	if len(sample) != f.nFeatures {
+		return 0, f.dimensionError(sample)
 	}
-	avgPath := totalPath / float64(len(f.trees))
-
 	// Anomaly score: 2^(-avgPath / c(n))
 	// Higher score = more anomalous
-	score := math.Pow(2, -avgPath/f.avgPathLength)
-
-	return score, nil
-}
-
-// pathLength calculates the path length for a sample in a tree.
-func pathLength(sample []float64, n *node, currentDepth int) float64 {
-	if n.left == nil && n.right == nil {
-		// Leaf node: add expected path length for remaining isolation
-		return float64(currentDepth) + averagePathLength(float64(n.size))
+	if q := f.quant; q != nil {
+		return score(q.pathLength(sample), float64(len(q.roots))*f.avgPathLength), nil
 	}
+	return score(f.flat.pathLength(sample), float64(len(f.flat.roots))*f.avgPathLength), nil
+}
 
-	if sample[n.splitFeature] < n.splitValue {
-		return pathLength(sample, n.left, currentDepth+1)
-	}
-	return pathLength(sample, n.right, currentDepth+1)
+// dimensionError reports a sample with the wrong number of features.
+func (f *IsolationForest) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: f.nFeatures}
 }
 
 // averagePathLength returns the average path length of unsuccessful search in BST.
@@ -279,13 +685,28 @@ func averagePathLength(n float64) float64 {
 }
 
 // PredictStream processes samples from a channel.
+// The output channel is closed when PredictStream returns, so callers
+// must not close it themselves: closing it twice panics. Samples that
+// cannot be scored are passed to the reject handler, if any, and skipped.
+// Each Score's Features is the input sample itself, not a copy, so pooled
+// samples can be returned once the score has been consumed.
 func (f *IsolationForest) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
 	f.mu.RLock()
 	if !f.trained {
 		f.mu.RUnlock()
-		return errors.New("model not trained")
+		return detectors.ErrNotTrained
 	}
+	reject := f.onReject
 	f.mu.RUnlock()
+	if reject == nil {
+		reject = func(detectors.Rejection) {}
+	}
+
+	if workers := detectors.Workers(f.workers); workers > 1 {
+		return f.predictStreamParallel(ctx, input, output, reject, workers)
+	}
 
 	for {
 		select {
@@ -296,17 +717,14 @@ func (f *IsolationForest) PredictStream(ctx context.Context, input <-chan []floa
 				return nil
 			}
 
-			score, err := f.PredictOne(sample)
+			result, err := f.streamScore(sample)
 			if err != nil {
+				reject(detectors.Rejection{Sample: sample, Err: err})
 				continue
 			}
 
 			select {
-			case output <- detectors.Score{
-				Value:     score,
-				IsAnomaly: score >= f.threshold,
-				Features:  sample,
-			}:
+			case output <- result:
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -314,71 +732,316 @@ func (f *IsolationForest) PredictStream(ctx context.Context, input <-chan []floa
 	}
 }
 
-// Save serializes the trained model.
-func (f *IsolationForest) Save() ([]byte, error) {
+// streamScore scores one streamed sample. The score, anomaly flag and
+// explanation are computed under one read lock, so they always come from
+// the same model even if Refit or SetThreshold runs concurrently.
+func (f *IsolationForest) streamScore(sample []float64) (detectors.Score, error) {
+	defer f.telemetry.report()
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
 	if !f.trained {
-		return nil, errors.New("model not trained")
+		return detectors.Score{}, detectors.ErrNotTrained
+	}
+	score, err := f.predictOne(sample)
+	if err != nil {
+		return detectors.Score{}, err
 	}
 
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= f.threshold,
+		Features:  sample,
+	}
+	if f.scoreStats != nil {
+		f.scoreStats.Add(score, result.IsAnomaly)
+	}
+	f.telemetry.observe(f, 1, func(int) []float64 { return sample })
+	if f.explainTop > 0 {
+		if exp, err := f.explain(sample); err == nil {
+			result.Explanation = &exp
+		}
+	}
+	if f.severity != nil {
+		result.Severity = f.severity.Grade(score)
+	}
+	return result, nil
+}
 
-	if err := enc.Encode(f.nTrees); err != nil {
-		return nil, err
+var _ detectors.RejectReporter = (*IsolationForest)(nil)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score to. It applies to streams started afterwards.
+func (f *IsolationForest) SetRejectHandler(fn detectors.RejectFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onReject = fn
+}
+
+// Save serializes the trained model in the versioned format described in
+// format.go: the Protocol Buffers message of SaveProto in a container
+// with a checksum and, with WithSigningKey, a signature.
+func (f *IsolationForest) Save() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return nil, detectors.ErrNotTrained
 	}
-	if err := enc.Encode(f.sampleSize); err != nil {
-		return nil, err
+	return f.encodeSaved()
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (f *IsolationForest) SaveTo(w io.Writer) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return detectors.ErrNotTrained
 	}
-	if err := enc.Encode(f.contamination); err != nil {
-		return nil, err
+	return f.writeSaved(w)
+}
+
+// encodeTrailer writes the fields saved after the trees: attributions,
+// typical ranges, training profile, model card and constant features.
+func (f *IsolationForest) encodeTrailer(enc *gob.Encoder) error {
+	if err := enc.Encode(f.importances); err != nil {
+		return err
 	}
-	if err := enc.Encode(f.threshold); err != nil {
-		return nil, err
+	if err := enc.Encode(newSavedRanges(f.typical)); err != nil {
+		return err
 	}
-	if err := enc.Encode(f.avgPathLength); err != nil {
-		return nil, err
+	if err := enc.Encode(newSavedProfile(f.profile)); err != nil {
+		return err
 	}
-	if err := enc.Encode(f.trees); err != nil {
-		return nil, err
+	if err := enc.Encode(container.NewCard(f.card)); err != nil {
+		return err
 	}
+	return enc.Encode(f.constant)
+}
 
-	return buf.Bytes(), nil
+// decodeTrailer reads the fields written by encodeTrailer into m. Older
+// models end before some of them; those are left empty.
+func decodeTrailer(dec *gob.Decoder, m *savedModel) error {
+	for _, v := range []any{&m.Importances, &m.Typical, &m.Profile, &m.Card, &m.Constant} {
+		if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	return nil
 }
 
-// Load deserializes a trained model.
+// Load deserializes a trained model saved by Save, SaveFlat or SaveProto,
+// including models saved by releases before the format was versioned. A
+// model opened with OpenMapped is unmapped once the new one has loaded;
+// if loading fails the mapping is kept.
 func (f *IsolationForest) Load(data []byte) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
+	var err error
+	switch {
+	case isSaved(data):
+		err = f.loadSaved(data)
+	case isFlat(data):
+		err = f.loadFlat(data, false)
+	case pb.IsModel(data):
+		err = f.loadProto(data)
+	default:
+		err = f.loadLegacy(data)
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.release(); err != nil {
+		return err
+	}
+	return f.quantizeModel()
+}
 
-	if err := dec.Decode(&f.nTrees); err != nil {
+// LoadFrom reads a model written by Save, SaveTo, SaveFlat or SaveProto
+// from r. Gob models of earlier releases in the format of Save are decoded
+// as they are read; the other formats are read whole, then loaded like
+// Load does.
+func (f *IsolationForest) LoadFrom(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(saveMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
 		return err
 	}
-	if err := dec.Decode(&f.sampleSize); err != nil {
+	if !isSaved(magic) {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return f.Load(data)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.readSaved(br); err != nil {
 		return err
 	}
-	if err := dec.Decode(&f.contamination); err != nil {
+	if err := f.release(); err != nil {
 		return err
 	}
-	if err := dec.Decode(&f.threshold); err != nil {
+	return f.quantizeModel()
+}
+
+// loadLegacy reads format 0: a bare gob stream of nTrees, sampleSize,
+// contamination, threshold, avgPathLength, the trees, nFeatures and the
+// trailer, where older models end early. The model is only replaced once
+// all of it has decoded and validated, like restore does. The caller holds
+// the write lock.
+func (f *IsolationForest) loadLegacy(data []byte) error {
+	if err := f.unsigned("version 0"); err != nil {
 		return err
 	}
-	if err := dec.Decode(&f.avgPathLength); err != nil {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+
+	// The tree count is implied by the trees.
+	var (
+		m      savedModel
+		nTrees int
+	)
+	for _, v := range []any{&nTrees, &m.SampleSize, &m.Contamination, &m.Threshold, &m.AvgPathLength, &m.Trees} {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+
+	// Models saved before the feature count was recorded end here;
+	// fall back to the highest feature index used by a split.
+	if err := dec.Decode(&m.Features); errors.Is(err, io.EOF) {
+		trees, err := unflattenTrees(m.Trees)
+		if err != nil {
+			return err
+		}
+		m.Features = maxSplitFeature(trees) + 1
+	} else if err != nil {
 		return err
 	}
-	if err := dec.Decode(&f.trees); err != nil {
+	if err := decodeTrailer(dec, &m); err != nil {
 		return err
 	}
+	return f.restore(&m)
+}
 
-	f.maxDepth = int(math.Ceil(math.Log2(float64(f.sampleSize))))
-	f.trained = true
+// savedNode is the serialized form of a tree node.
+// Children are referenced by index into the tree's node slice; leaves use -1.
+type savedNode struct {
+	Feature int
+	Value   float64
+	Left    int
+	Right   int
+	Size    int
+}
 
-	return nil
+// flattenTrees converts trees into a gob-encodable representation.
+func flattenTrees(trees []*iTree) [][]savedNode {
+	flat := make([][]savedNode, len(trees))
+	for i, tree := range trees {
+		flat[i] = flattenNode(tree.root, nil)
+	}
+	return flat
+}
+
+func flattenNode(n *node, nodes []savedNode) []savedNode {
+	idx := len(nodes)
+	nodes = append(nodes, savedNode{
+		Feature: n.splitFeature,
+		Value:   n.splitValue,
+		Left:    -1,
+		Right:   -1,
+		Size:    n.size,
+	})
+	if n.left == nil || n.right == nil {
+		return nodes
+	}
+
+	nodes[idx].Left = len(nodes)
+	nodes = flattenNode(n.left, nodes)
+	nodes[idx].Right = len(nodes)
+	return flattenNode(n.right, nodes)
+}
+
+// unflattenTrees rebuilds trees from their serialized representation.
+func unflattenTrees(flat [][]savedNode) ([]*iTree, error) {
+	trees := make([]*iTree, len(flat))
+	for i, nodes := range flat {
+		if len(nodes) == 0 {
+			return nil, errors.New("empty tree in model")
+		}
+		root, err := unflattenNode(nodes, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		trees[i] = &iTree{root: root}
+	}
+	return trees, nil
+}
+
+func unflattenNode(nodes []savedNode, idx, depth int) (*node, error) {
+	if idx < 0 || idx >= len(nodes) {
+		return nil, errors.New("invalid node index in model")
+	}
+	if depth > maxTreeDepth {
+		return nil, fmt.Errorf("tree deeper than %d in model", maxTreeDepth)
+	}
+
+	sn := nodes[idx]
+	n := &node{
+		splitFeature: sn.Feature,
+		splitValue:   sn.Value,
+		size:         sn.Size,
+	}
+	if sn.Left < 0 || sn.Right < 0 {
+		return n, nil
+	}
+	if sn.Left <= idx || sn.Right <= idx {
+		return nil, errors.New("invalid node index in model")
+	}
+	if sn.Feature < 0 || sn.Feature > math.MaxInt32 {
+		return nil, errors.New("invalid split feature in model")
+	}
+
+	var err error
+	if n.left, err = unflattenNode(nodes, sn.Left, depth+1); err != nil {
+		return nil, err
+	}
+	if n.right, err = unflattenNode(nodes, sn.Right, depth+1); err != nil {
+		return nil, err
+	}
+	// Older models only recorded sizes at leaves.
+	if n.size == 0 {
+		n.size = n.left.size + n.right.size
+	}
+	return n, nil
+}
+
+// maxSplitFeature returns the highest feature index used by any split, or -1.
+func maxSplitFeature(trees []*iTree) int {
+	highest := -1
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.left == nil || n.right == nil {
+			return
+		}
+		highest = max(highest, n.splitFeature)
+		walk(n.left)
+		walk(n.right)
+	}
+	for _, tree := range trees {
+		walk(tree.root)
+	}
+	return highest
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (f *IsolationForest) Trained() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.trained
 }
 
 // Threshold returns the current anomaly threshold.
@@ -394,23 +1057,3 @@ func (f *IsolationForest) SetThreshold(t float64) {
 	defer f.mu.Unlock()
 	f.threshold = t
 }
-
-// percentile calculates the p-th percentile of the data.
-func percentile(data []float64, p float64) float64 {
-	if len(data) == 0 {
-		return 0
-	}
-
-	sorted := make([]float64, len(data))
-	copy(sorted, data)
-
-	// Simple insertion sort for small arrays
-	for i := 1; i < len(sorted); i++ {
-		for j := i; j > 0 && sorted[j] < sorted[j-1]; j-- {
-			sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
-		}
-	}
-
-	idx := int(float64(len(sorted)-1) * p / 100)
-	return sorted[idx]
-}
@@ -2,6 +2,10 @@ package iforest
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
 	"math/rand"
 	"testing"
 
@@ -9,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
 )
 
 func TestNewIsolationForest(t *testing.T) {
@@ -42,6 +47,64 @@ func TestNewIsolationForest(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		options []string
+	}{
+		{name: "defaults"},
+		{name: "zero contamination", opts: []Option{WithContamination(0)}},
+		{name: "zero trees", opts: []Option{WithTrees(0)}, options: []string{"WithTrees"}},
+		{name: "negative trees", opts: []Option{WithTrees(-5)}, options: []string{"WithTrees"}},
+		{name: "zero sample size", opts: []Option{WithSampleSize(0)}, options: []string{"WithSampleSize"}},
+		{name: "contamination one", opts: []Option{WithContamination(1)}, options: []string{"WithContamination"}},
+		{name: "negative contamination", opts: []Option{WithContamination(-0.1)}, options: []string{"WithContamination"}},
+		{name: "NaN contamination", opts: []Option{WithContamination(math.NaN())}, options: []string{"WithContamination"}},
+		{
+			name:    "decreasing severity bands",
+			opts:    []Option{WithSeverityBands(detectors.SeverityBands{Low: 0.7, Medium: 0.6})},
+			options: []string{"WithSeverityBands"},
+		},
+		{
+			name:    "several",
+			opts:    []Option{WithTrees(-1), WithSampleSize(1)},
+			options: []string{"WithTrees", "WithSampleSize"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(tt.opts...)
+			err := f.Validate()
+			if tt.options == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrInvalidOption)
+			assert.ErrorIs(t, err, detectors.ErrInvalidOption)
+			var got []string
+			for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var optErr *OptionError
+				require.ErrorAs(t, e, &optErr)
+				got = append(got, optErr.Option)
+			}
+			assert.Equal(t, tt.options, got)
+
+			assert.ErrorIs(t, f.Fit(generateTestData(50, 2)), ErrInvalidOption,
+				"Fit fails before training")
+			assert.False(t, f.trained)
+		})
+	}
+}
+
+func TestNewClampsOptions(t *testing.T) {
+	f := New(WithWorkers(-3), WithExplanations(-1))
+	assert.Equal(t, 0, f.workers)
+	assert.Equal(t, 0, f.explainTop)
+}
+
 func TestFit(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -63,6 +126,16 @@ func TestFit(t *testing.T) {
 			data:    generateTestData(100, 5),
 			wantErr: false,
 		},
+		{
+			name:    "no features",
+			data:    [][]float64{{}, {}},
+			wantErr: true,
+		},
+		{
+			name:    "ragged rows",
+			data:    [][]float64{{1, 2, 3}, {4, 5}},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -81,6 +154,78 @@ func TestFit(t *testing.T) {
 	}
 }
 
+func TestRefit(t *testing.T) {
+	f := New(WithTrees(20), WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+	far := []float64{10, 10, 10}
+	before, err := f.PredictOne(far)
+	require.NoError(t, err)
+
+	t.Run("failed refit keeps the model", func(t *testing.T) {
+		assert.Error(t, f.Refit(nil))
+		assert.Error(t, f.Refit([][]float64{{1, 2, 3}, {1, 2}}))
+		got, err := f.PredictOne(far)
+		require.NoError(t, err)
+		assert.Equal(t, before, got)
+	})
+
+	t.Run("refit swaps the model", func(t *testing.T) {
+		shifted := generateTestData(200, 3)
+		for _, row := range shifted {
+			for j := range row {
+				row[j] += 10
+			}
+		}
+		require.NoError(t, f.Refit(shifted))
+		after, err := f.PredictOne(far)
+		require.NoError(t, err)
+		assert.Less(t, after, before, "the shifted data makes far normal")
+		assert.True(t, f.Trained())
+	})
+}
+
+func TestRefitWhileStreaming(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			data := generateTestData(300, 3)
+			f := New(WithTrees(10), WithWorkers(workers), WithExplanations(2))
+			require.NoError(t, f.Fit(data))
+
+			input := make(chan []float64)
+			output := make(chan detectors.Score)
+			errCh := make(chan error, 1)
+			go func() { errCh <- f.PredictStream(context.Background(), input, output) }()
+			go func() {
+				defer close(input)
+				for range 5 {
+					for _, sample := range data {
+						input <- sample
+					}
+				}
+			}()
+			refitErr := make(chan error, 1)
+			go func() {
+				var err error
+				for range 3 {
+					err = errors.Join(err, f.Refit(data))
+				}
+				refitErr <- err
+			}()
+
+			var n int
+			for score := range output {
+				n++
+				require.NotNil(t, score.Explanation)
+				assert.Equal(t, score.Value, score.Explanation.Score,
+					"score and explanation come from the same model")
+			}
+			require.NoError(t, <-errCh)
+			require.NoError(t, <-refitErr)
+			assert.Equal(t, 5*len(data), n)
+		})
+	}
+}
+
 func TestPredict(t *testing.T) {
 	// Train on normal data
 	trainData := generateTestData(500, 5)
@@ -116,10 +261,19 @@ func TestPredict(t *testing.T) {
 		}
 	})
 
+	t.Run("wrong dimension", func(t *testing.T) {
+		_, err := f.Predict([][]float64{{1, 2, 3, 4, 5}, {1, 2, 3}})
+		assert.ErrorContains(t, err, "sample 1: sample has 3 features, model expects 5")
+		assert.ErrorIs(t, err, detectors.ErrDimensionMismatch)
+		var dimErr *detectors.DimensionError
+		require.ErrorAs(t, err, &dimErr)
+		assert.Equal(t, detectors.DimensionError{Got: 3, Want: 5}, *dimErr)
+	})
+
 	t.Run("predict before fit", func(t *testing.T) {
 		untrained := New()
 		_, err := untrained.Predict(trainData)
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, detectors.ErrNotTrained)
 	})
 }
 
@@ -132,6 +286,9 @@ func TestPredictOne(t *testing.T) {
 	require.NoError(t, err)
 	assert.GreaterOrEqual(t, score, 0.0)
 	assert.LessOrEqual(t, score, 1.0)
+
+	_, err = f.PredictOne([]float64{0.5, 0.5})
+	assert.ErrorContains(t, err, "sample has 2 features, model expects 3")
 }
 
 func TestPredictStream(t *testing.T) {
@@ -171,6 +328,60 @@ func TestPredictStream(t *testing.T) {
 	}
 
 	assert.Len(t, results, len(testSamples))
+	for _, score := range results {
+		assert.Equal(t, detectors.SeverityNone, score.Severity, "ungraded without bands")
+	}
+}
+
+func TestPredictStreamSeverity(t *testing.T) {
+	f := New(WithTrees(20), WithSeed(42), WithSeverityBands(detectors.SeverityBands{Low: 0.5, Medium: 0.6, High: 0.65, Critical: 0.7}))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+	input := make(chan []float64, 2)
+	output := make(chan detectors.Score, 2)
+	input <- []float64{0, 0, 0}
+	input <- []float64{100, 100, 100}
+	close(input)
+	require.NoError(t, f.PredictStream(context.Background(), input, output))
+
+	var got []detectors.Severity
+	for score := range output {
+		got = append(got, score.Severity)
+	}
+	assert.Equal(t, []detectors.Severity{detectors.SeverityInfo, detectors.SeverityCritical}, got)
+}
+
+func TestPredictStreamRejects(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			f := New(WithTrees(20), WithSeed(42), WithWorkers(workers))
+			require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+			var rejected []detectors.Rejection
+			f.SetRejectHandler(func(r detectors.Rejection) {
+				rejected = append(rejected, r)
+			})
+
+			input := make(chan []float64, 4)
+			output := make(chan detectors.Score, 4)
+			input <- []float64{0.5, 0.5, 0.5}
+			input <- []float64{1, 2}
+			input <- []float64{0.3, 0.3, 0.3}
+			input <- []float64{1, 2, 3, 4}
+			close(input)
+			require.NoError(t, f.PredictStream(context.Background(), input, output))
+
+			var scored int
+			for range output {
+				scored++
+			}
+			assert.Equal(t, 2, scored)
+			require.Len(t, rejected, 2)
+			assert.Equal(t, []float64{1, 2}, rejected[0].Sample)
+			assert.ErrorContains(t, rejected[0].Err, "sample has 2 features, model expects 3")
+			assert.Equal(t, []float64{1, 2, 3, 4}, rejected[1].Sample)
+		})
+	}
 }
 
 func TestSaveLoad(t *testing.T) {
@@ -212,6 +423,152 @@ func TestThreshold(t *testing.T) {
 	assert.Equal(t, 0.7, f.Threshold())
 }
 
+func TestBuildTree(t *testing.T) {
+	data := generateTestData(500, 4)
+	idx := make([]int, 0, 200)
+	for i := 0; i < len(data); i += 2 {
+		idx = append(idx, i)
+	}
+	rows := append([]int(nil), idx...)
+
+	f := New(WithSampleSize(len(idx)))
+	tree := f.buildTree(data, idx, []int{0, 1, 2, 3}, rand.New(rand.NewSource(1)))
+
+	assert.ElementsMatch(t, rows, idx, "idx is only reordered")
+	assert.Equal(t, len(rows), tree.root.size)
+
+	// Every row must reach a leaf, and leaf sizes must match the rows
+	// reaching them.
+	reached := make(map[*node]int)
+	for _, i := range rows {
+		n := tree.root
+		for n.left != nil {
+			if data[i][n.splitFeature] < n.splitValue {
+				n = n.left
+			} else {
+				n = n.right
+			}
+		}
+		reached[n]++
+	}
+	for leaf, count := range reached {
+		assert.Equal(t, leaf.size, count)
+	}
+}
+
+func TestCopyData(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "default copies"},
+		{name: "in place", opts: []Option{WithCopyData(false)}},
+	}
+
+	probe := generateTestData(50, 3)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := generateTestData(400, 3)
+			names := []string{"a", "b", "c"}
+			f := New(append(tt.opts, WithTrees(20), WithFeatureNames(names))...)
+			require.NoError(t, f.Fit(data))
+
+			before, err := f.Predict(probe)
+			require.NoError(t, err)
+			saved, err := f.Save()
+			require.NoError(t, err)
+
+			// The trained model must not alias the caller's rows or names.
+			for _, row := range data {
+				for j := range row {
+					row[j] = math.Inf(1)
+				}
+			}
+			names[0] = "changed"
+
+			after, err := f.Predict(probe)
+			require.NoError(t, err)
+			assert.Equal(t, before, after)
+			resaved, err := f.Save()
+			require.NoError(t, err)
+			assert.Equal(t, saved, resaved)
+			assert.Equal(t, []string{"a", "b", "c"}, f.Metadata().FeatureNames)
+		})
+	}
+}
+
+func TestCloneRows(t *testing.T) {
+	data := [][]float64{{1, 2}, {3}, {}, {4, 5, 6}}
+	clone := cloneRows(data)
+	assert.Equal(t, data, clone)
+
+	clone[1] = append(clone[1], 9)
+	assert.Equal(t, []float64{4, 5, 6}, clone[3], "rows are capped, appends do not overwrite neighbours")
+	data[0][0] = 7
+	assert.Equal(t, 1.0, clone[0][0])
+}
+
+func TestDepthLimit(t *testing.T) {
+	tests := []struct {
+		sampleSize int
+		want       int
+	}{
+		{sampleSize: 0, want: 0},
+		{sampleSize: 1, want: 0},
+		{sampleSize: 2, want: 1},
+		{sampleSize: 256, want: 8},
+		{sampleSize: 257, want: 9},
+		{sampleSize: math.MaxInt, want: min(bits.UintSize-1, maxTreeDepth)},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, depthLimit(tt.sampleSize), "sampleSize=%d", tt.sampleSize)
+	}
+}
+
+func TestUnflattenTreesValidation(t *testing.T) {
+	// chain builds a tree whose left spine is depth splits deep.
+	chain := func(depth int) []savedNode {
+		var nodes []savedNode
+		for i := 0; i < depth; i++ {
+			idx := len(nodes)
+			nodes = append(nodes,
+				savedNode{Feature: 0, Left: idx + 2, Right: idx + 1},
+				savedNode{Left: -1, Right: -1, Size: 1})
+		}
+		return append(nodes, savedNode{Left: -1, Right: -1, Size: 1})
+	}
+
+	tests := []struct {
+		name    string
+		nodes   []savedNode
+		wantErr string
+	}{
+		{name: "at depth cap", nodes: chain(maxTreeDepth)},
+		{name: "too deep", nodes: chain(maxTreeDepth + 1), wantErr: "tree deeper than"},
+		{name: "negative feature", nodes: []savedNode{
+			{Feature: -1, Left: 1, Right: 2},
+			{Left: -1, Right: -1},
+			{Left: -1, Right: -1},
+		}, wantErr: "invalid split feature"},
+		{name: "cycle", nodes: []savedNode{
+			{Feature: 0, Left: 0, Right: 1},
+			{Left: -1, Right: -1},
+		}, wantErr: "invalid node index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unflattenTrees([][]savedNode{tt.nodes})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func BenchmarkFit(b *testing.B) {
 	data := generateTestData(10000, 10)
 	f := New(WithTrees(100), WithSampleSize(256))
@@ -261,3 +618,19 @@ func generateTestData(n, features int) [][]float64 {
 	}
 	return data
 }
+
+func TestScoreStats(t *testing.T) {
+	s := stats.NewScoreStats()
+	f := New(WithTrees(10), WithSeed(42), WithScoreStats(s))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	assert.Zero(t, s.Snapshot().Count, "training scores are not recorded")
+
+	_, err := f.Predict(generateTestData(10, 3))
+	require.NoError(t, err)
+	_, err = f.PredictOne([]float64{100, 100, 100})
+	require.NoError(t, err)
+
+	snap := s.Snapshot()
+	assert.Equal(t, uint64(11), snap.Count)
+	assert.GreaterOrEqual(t, snap.Anomalies, uint64(1))
+}
@@ -0,0 +1,150 @@
+package iforest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Errors returned by Load for models that fail verification.
+var (
+	// ErrChecksum reports a model whose content does not match its
+	// checksum: the file was corrupted or truncated. It is
+	// detectors.ErrChecksum.
+	ErrChecksum = detectors.ErrChecksum
+	// ErrSignature reports a model without a valid HMAC under the signing
+	// key: it was not signed, signed with another key, or tampered with.
+	ErrSignature = errors.New("model signature missing or invalid")
+)
+
+// WithSigningKey makes Save sign models with an HMAC-SHA256 under key, and
+// Load reject models not signed with it with ErrSignature. Only the Save
+// format can be signed: with a key, Load also rejects models saved with
+// SaveFlat or SaveProto, or by releases before signing existed.
+func WithSigningKey(key []byte) Option {
+	return func(f *IsolationForest) {
+		if len(key) > 0 {
+			f.signingKey = append([]byte(nil), key...)
+		}
+	}
+}
+
+// unsigned returns ErrSignature if f requires signed models, for loading a
+// model in a format that cannot be signed.
+func (f *IsolationForest) unsigned(format string) error {
+	if f.signingKey == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s models are not signed", ErrSignature, format)
+}
+
+// containerSums computes the trailer of a saved container from the bytes
+// written to it.
+type containerSums struct {
+	signed bool
+	sum    hash.Hash
+	mac    hash.Hash // nil unless signed and f has a key
+}
+
+func (f *IsolationForest) newSums(flags uint32) *containerSums {
+	s := &containerSums{signed: flags&saveSigned != 0, sum: sha256.New()}
+	if s.signed && f.signingKey != nil {
+		s.mac = hmac.New(sha256.New, f.signingKey)
+	}
+	return s
+}
+
+func (s *containerSums) Write(p []byte) (int, error) {
+	s.sum.Write(p)
+	if s.mac != nil {
+		s.mac.Write(p)
+	}
+	return len(p), nil
+}
+
+// size returns the length of the trailer.
+func (s *containerSums) size() int {
+	if s.signed {
+		return 2 * sha256.Size
+	}
+	return sha256.Size
+}
+
+// trailer returns the trailer of a container being written.
+func (s *containerSums) trailer() []byte {
+	t := s.sum.Sum(nil)
+	if s.mac != nil {
+		t = s.mac.Sum(t)
+	}
+	return t
+}
+
+// checkTrailer verifies the trailer of a container read with sums.
+func (f *IsolationForest) checkTrailer(sums *containerSums, trailer []byte) error {
+	if !bytes.Equal(trailer[:sha256.Size], sums.sum.Sum(nil)) {
+		return ErrChecksum
+	}
+	if f.signingKey == nil {
+		return nil
+	}
+	if sums.mac == nil {
+		return fmt.Errorf("%w: model is not signed", ErrSignature)
+	}
+	if !hmac.Equal(trailer[sha256.Size:], sums.mac.Sum(nil)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// verifySaved verifies the trailer of a version 2 or later container held
+// in memory. Other versions and malformed headers are left for readSaved
+// to report.
+func (f *IsolationForest) verifySaved(data []byte) error {
+	headerSize := len(saveMagic) + 8
+	if len(data) < headerSize {
+		return nil
+	}
+	if v := binary.LittleEndian.Uint32(data[len(saveMagic):]); v < 2 || v > saveVersion {
+		return nil
+	}
+	sums := f.newSums(binary.LittleEndian.Uint32(data[len(saveMagic)+4:]))
+	end := len(data) - sums.size()
+	if end < headerSize {
+		return fmt.Errorf("%w: truncated trailer", ErrChecksum)
+	}
+	sums.Write(data[:end])
+	return f.checkTrailer(sums, data[end:])
+}
+
+// byteReader is what gob decodes from without reading ahead.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// hashingReader copies what is read through it to w.
+type hashingReader struct {
+	r byteReader
+	w io.Writer
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.w.Write(p[:n])
+	return n, err
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.w.Write([]byte{b})
+	}
+	return b, err
+}
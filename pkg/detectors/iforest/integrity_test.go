@@ -0,0 +1,85 @@
+package iforest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func savedModelBytes(t *testing.T, opts ...Option) []byte {
+	t.Helper()
+	f := New(append([]Option{WithTrees(5), WithSeed(1)}, opts...)...)
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	saved, err := f.Save()
+	require.NoError(t, err)
+	return saved
+}
+
+func TestChecksum(t *testing.T) {
+	saved := savedModelBytes(t)
+	require.NoError(t, New().Load(saved))
+
+	corrupt := append([]byte(nil), saved...)
+	corrupt[len(corrupt)/2] ^= 0x40
+	g := New()
+	assert.ErrorIs(t, g.Load(corrupt), ErrChecksum)
+	assert.False(t, g.Trained())
+
+	assert.ErrorIs(t, New().Load(saved[:len(saved)-1]), ErrChecksum)
+	assert.ErrorIs(t, New().Load(saved[:len(saveMagic)+9]), ErrChecksum)
+
+	// Streaming loads verify once the body is decoded.
+	badTrailer := append([]byte(nil), saved...)
+	badTrailer[len(badTrailer)-1] ^= 1
+	assert.ErrorIs(t, g.LoadFrom(bytes.NewReader(badTrailer)), ErrChecksum)
+	assert.ErrorIs(t, g.LoadFrom(bytes.NewReader(saved[:len(saved)-1])), ErrChecksum)
+	assert.False(t, g.Trained())
+	require.NoError(t, g.LoadFrom(bytes.NewReader(saved)))
+}
+
+func TestSigningKey(t *testing.T) {
+	key := []byte("secret")
+	signed := savedModelBytes(t, WithSigningKey(key))
+	unsigned := savedModelBytes(t)
+	assert.Equal(t, byte(saveSigned), signed[len(saveMagic)+4])
+	assert.Equal(t, byte(0), unsigned[len(saveMagic)+4])
+
+	require.NoError(t, New(WithSigningKey(key)).Load(signed))
+	require.NoError(t, New(WithSigningKey(key)).LoadFrom(bytes.NewReader(signed)))
+	require.NoError(t, New().Load(signed), "without a key only the checksum is verified")
+
+	assert.ErrorIs(t, New(WithSigningKey([]byte("other"))).Load(signed), ErrSignature)
+	assert.ErrorIs(t, New(WithSigningKey([]byte("other"))).LoadFrom(bytes.NewReader(signed)), ErrSignature)
+	assert.ErrorIs(t, New(WithSigningKey(key)).Load(unsigned), ErrSignature)
+	assert.ErrorIs(t, New(WithSigningKey(key)).LoadFrom(bytes.NewReader(unsigned)), ErrSignature)
+
+	// Changing the model and fixing up its checksum does not get past the
+	// signature.
+	tampered := append([]byte(nil), signed...)
+	end := len(tampered) - 2*sha256.Size
+	tampered[end-1] ^= 1
+	sum := sha256.Sum256(tampered[:end])
+	copy(tampered[end:], sum[:])
+	require.NoError(t, New().verifySaved(tampered))
+	assert.ErrorIs(t, New(WithSigningKey(key)).Load(tampered), ErrSignature)
+
+	// Formats that cannot be signed are rejected when a key is set.
+	f := New(WithTrees(5), WithSeed(1))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+	assert.ErrorIs(t, New(WithSigningKey(key)).Load(flat), ErrSignature)
+	proto, err := f.SaveProto()
+	require.NoError(t, err)
+	assert.ErrorIs(t, New(WithSigningKey(key)).Load(proto), ErrSignature)
+	for _, name := range []string{"model-v0.gob", "model-v1.bin"} {
+		old, err := os.ReadFile(filepath.Join("testdata", name))
+		require.NoError(t, err)
+		assert.ErrorIs(t, New(WithSigningKey(key)).Load(old), ErrSignature, name)
+	}
+}
@@ -0,0 +1,64 @@
+package iforest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var _ detectors.SemiSupervised = (*IsolationForest)(nil)
+
+// FitSemiSupervised trains the forest on partially labeled data, with one
+// label per sample: detectors.LabelAnomaly for confirmed anomalies,
+// detectors.LabelNormal for known normal samples, anything else for
+// unlabeled ones.
+//
+// Confirmed anomalies are kept out of the tree samples, so the trees
+// isolate them as quickly as any other unseen outlier instead of learning
+// them as a dense region. If there are any, they also set the threshold:
+// the one flagging them with the best F1 score against the other samples
+// (see detectors.LabelThreshold), overriding the contamination setting.
+// Without labeled anomalies the threshold follows the contamination, as in
+// Fit, computed over the normal and unlabeled samples.
+//
+// The model card records the number of labeled samples of each kind.
+func (f *IsolationForest) FitSemiSupervised(data [][]float64, labels []float64) error {
+	if len(labels) != len(data) {
+		return fmt.Errorf("%d labels for %d samples", len(labels), len(data))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	train, anomalies := detectors.SplitLabeled(data, labels)
+	if len(train) == 0 {
+		return detectors.ErrNoNormalData
+	}
+	next, err := f.fitNext(train, f.featureNames)
+	if err != nil {
+		return err
+	}
+
+	if len(anomalies) > 0 {
+		scores, err := next.predict(context.Background(), data)
+		if err != nil {
+			return err
+		}
+		if t, ok := detectors.LabelThreshold(scores, labels); ok {
+			next.threshold = t
+			next.card.Hyperparameters["threshold"] = strconv.FormatFloat(t, 'g', -1, 64)
+		}
+	}
+
+	normals := 0
+	for _, l := range labels {
+		if l == detectors.LabelNormal {
+			normals++
+		}
+	}
+	next.card.Hyperparameters["labeled_anomalies"] = strconv.Itoa(len(anomalies))
+	next.card.Hyperparameters["labeled_normals"] = strconv.Itoa(normals)
+	return f.swap(next)
+}
@@ -0,0 +1,83 @@
+package iforest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// withIncidents returns normal traffic followed by a tight cluster of n
+// anomalous samples, with labels marking the first labeled of them.
+func withIncidents(n, labeled int) ([][]float64, []float64) {
+	rng := rand.New(rand.NewSource(7))
+	var data [][]float64
+	var labels []float64
+	for range 500 {
+		data = append(data, []float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()})
+		labels = append(labels, detectors.LabelUnknown)
+	}
+	for i := range n {
+		data = append(data, []float64{6 + rng.NormFloat64()*0.05, 6 + rng.NormFloat64()*0.05, 6})
+		if i < labeled {
+			labels = append(labels, detectors.LabelAnomaly)
+		} else {
+			labels = append(labels, detectors.LabelUnknown)
+		}
+	}
+	return data, labels
+}
+
+func TestFitSemiSupervised(t *testing.T) {
+	data, labels := withIncidents(40, 30)
+	incident := []float64{6, 6, 6}
+
+	unsupervised := New(WithTrees(100), WithSeed(1), WithContamination(0.02))
+	require.NoError(t, unsupervised.Fit(data))
+	masked, err := unsupervised.PredictOne(incident)
+	require.NoError(t, err)
+
+	f := New(WithTrees(100), WithSeed(1), WithContamination(0.02))
+	require.NoError(t, f.FitSemiSupervised(data, labels))
+	score, err := f.PredictOne(incident)
+	require.NoError(t, err)
+	assert.Greater(t, score, masked, "labeled incidents no longer mask each other")
+	assert.Greater(t, score, f.Threshold())
+
+	scores, err := f.Predict(data[:500])
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range scores {
+		if s >= f.Threshold() {
+			flagged++
+		}
+	}
+	assert.Less(t, flagged, 10, "few normal samples flagged")
+
+	card := f.Metadata()
+	assert.Equal(t, "30", card.Hyperparameters["labeled_anomalies"])
+	assert.Equal(t, "0", card.Hyperparameters["labeled_normals"])
+	assert.Equal(t, 510, card.Rows)
+}
+
+func TestFitSemiSupervisedWithoutAnomalies(t *testing.T) {
+	data, labels := withIncidents(0, 0)
+	labels[0], labels[1] = detectors.LabelNormal, detectors.LabelNormal
+
+	f := New(WithTrees(20), WithSeed(1), WithContamination(0.1))
+	require.NoError(t, f.FitSemiSupervised(data, labels))
+	g := New(WithTrees(20), WithSeed(1), WithContamination(0.1))
+	require.NoError(t, g.Fit(data))
+	assert.Equal(t, g.Threshold(), f.Threshold(), "threshold from the contamination")
+	assert.Equal(t, "2", f.Metadata().Hyperparameters["labeled_normals"])
+}
+
+func TestFitSemiSupervisedErrors(t *testing.T) {
+	f := New(WithTrees(10))
+	assert.ErrorContains(t, f.FitSemiSupervised([][]float64{{1}, {2}}, []float64{0}), "1 labels for 2 samples")
+	assert.ErrorIs(t, f.FitSemiSupervised([][]float64{{1}, {2}}, []float64{1, 1}), detectors.ErrNoNormalData)
+	assert.False(t, f.Trained())
+}
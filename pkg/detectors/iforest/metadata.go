@@ -0,0 +1,71 @@
+package iforest
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var _ detectors.Describer = (*IsolationForest)(nil)
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(f *IsolationForest) {
+		f.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(f *IsolationForest) {
+		f.featureNames = append([]string(nil), names...)
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (f *IsolationForest) Metadata() detectors.ModelCard {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	card := f.card
+	card.FeatureNames = append([]string(nil), f.card.FeatureNames...)
+	if f.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(f.card.Hyperparameters))
+		for k, v := range f.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data with the given feature names.
+func (f *IsolationForest) modelCard(data [][]float64, names []string) detectors.ModelCard {
+	card := detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   f.dataSource,
+		Rows:         len(data),
+		Features:     f.nFeatures,
+		FeatureNames: append([]string(nil), names...),
+		Hyperparameters: map[string]string{
+			"algorithm":        "iforest",
+			"trees":            strconv.Itoa(f.nTrees),
+			"sample_size":      strconv.Itoa(f.sampleSize),
+			"contamination":    strconv.FormatFloat(f.contamination, 'g', -1, 64),
+			"threshold":        strconv.FormatFloat(f.threshold, 'g', -1, 64),
+			"seed":             strconv.FormatInt(f.seed, 10),
+			"exclude_constant": strconv.FormatBool(f.excludeConstant),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+	if f.featureWeights != nil {
+		card.Hyperparameters["feature_weights"] = formatWeights(f.featureWeights)
+	}
+	if f.quantBits > 0 {
+		card.Hyperparameters["quantization"] = strconv.Itoa(f.quantBits)
+	}
+	return card
+}
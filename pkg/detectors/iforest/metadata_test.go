@@ -0,0 +1,54 @@
+package iforest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestMetadata(t *testing.T) {
+	f := New(
+		WithTrees(10),
+		WithSeed(7),
+		WithDataSource("flows.csv"),
+		WithFeatureNames([]string{"bytes", "packets", "duration"}),
+	)
+	assert.Zero(t, f.Metadata())
+
+	data := generateTestData(100, 3)
+	before := time.Now().UTC()
+	require.NoError(t, f.Fit(data))
+
+	card := f.Metadata()
+	assert.False(t, card.TrainedAt.Before(before))
+	assert.Equal(t, "flows.csv", card.DataSource)
+	assert.Equal(t, 100, card.Rows)
+	assert.Equal(t, 3, card.Features)
+	assert.Equal(t, []string{"bytes", "packets", "duration"}, card.FeatureNames)
+	assert.Equal(t, "10", card.Hyperparameters["trees"])
+	assert.Equal(t, "7", card.Hyperparameters["seed"])
+	assert.Equal(t, detectors.HashData(data), card.DataHash)
+	assert.NotEmpty(t, card.LibraryVersion)
+
+	card.Hyperparameters["trees"] = "changed"
+	assert.Equal(t, "10", f.Metadata().Hyperparameters["trees"], "Metadata returns a copy")
+
+	saved, err := f.Save()
+	require.NoError(t, err)
+	again, err := f.Save()
+	require.NoError(t, err)
+	assert.Equal(t, saved, again, "saved models are reproducible")
+
+	loaded := New()
+	require.NoError(t, loaded.Load(saved))
+	assert.Equal(t, f.Metadata(), loaded.Metadata())
+}
+
+func TestMetadataFeatureNameMismatch(t *testing.T) {
+	f := New(WithTrees(10), WithFeatureNames([]string{"a", "b"}))
+	assert.Error(t, f.Fit(generateTestData(50, 3)))
+}
@@ -0,0 +1,60 @@
+package iforest
+
+import (
+	"errors"
+	"os"
+)
+
+// OpenMapped opens a model saved with SaveFlat by memory-mapping the file.
+// The trees are scored straight from the mapping, so the model needs
+// almost no heap and opening it does not decode the trees; pages are
+// shared between processes mapping the same file. The file must not be
+// modified while the model is open. Call Close to release the mapping.
+//
+// OpenMapped returns ErrNotFlat for files in the Save format. Where the
+// platform cannot map files, or the records cannot be used in place, the
+// trees are copied to the heap instead.
+func OpenMapped(path string, opts ...Option) (*IsolationForest, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := New(opts...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.loadFlat(data, true); err != nil {
+		return nil, errors.Join(err, unmap())
+	}
+	f.unmap = unmap
+	return f, nil
+}
+
+// Close releases the mapping of a model opened with OpenMapped. The model
+// is left untrained and must not be used afterwards. Close is a no-op for
+// other models, including mapped ones that Fit, Refit or Load has since
+// replaced.
+func (f *IsolationForest) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unmap == nil {
+		return nil
+	}
+	unmap := f.unmap
+	f.unmap = nil
+	f.trained = false
+	f.flat = nil
+	f.quant = nil
+	f.trees = nil
+	return unmap()
+}
+
+// readFile is the fallback for platforms without mmap.
+func readFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package iforest
+
+// mapFile reads path into memory; this platform has no mmap support.
+func mapFile(path string) ([]byte, func() error, error) {
+	return readFile(path)
+}
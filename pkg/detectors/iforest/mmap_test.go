@@ -0,0 +1,118 @@
+package iforest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMapped(t *testing.T) {
+	data := generateTestData(200, 3)
+	f := New(WithTrees(10))
+	require.NoError(t, f.Fit(data))
+
+	dir := t.TempDir()
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+	path := filepath.Join(dir, "model.flat")
+	require.NoError(t, os.WriteFile(path, flat, 0o600))
+
+	mapped, err := OpenMapped(path)
+	require.NoError(t, err)
+
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	got, err := mapped.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	require.NoError(t, mapped.Close())
+	_, err = mapped.PredictOne(data[0])
+	assert.Error(t, err, "closed models are untrained")
+	assert.NoError(t, mapped.Close(), "Close is idempotent")
+}
+
+func TestRefitMapped(t *testing.T) {
+	data := generateTestData(200, 3)
+	f := New(WithTrees(10))
+	require.NoError(t, f.Fit(data))
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "model.flat")
+	require.NoError(t, os.WriteFile(path, flat, 0o600))
+
+	mapped, err := OpenMapped(path)
+	require.NoError(t, err)
+	require.NoError(t, mapped.Refit(data))
+	assert.Nil(t, mapped.unmap, "the mapping is released by the swap")
+
+	_, err = mapped.Predict(data)
+	require.NoError(t, err)
+	assert.NoError(t, mapped.Close(), "refitted models stay open")
+	assert.True(t, mapped.Trained())
+}
+
+func TestReplaceMapped(t *testing.T) {
+	data := generateTestData(200, 3)
+	f := New(WithTrees(10))
+	require.NoError(t, f.Fit(data))
+	flat, err := f.SaveFlat()
+	require.NoError(t, err)
+	saved, err := f.Save()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "model.flat")
+	require.NoError(t, os.WriteFile(path, flat, 0o600))
+
+	replace := map[string]func(*IsolationForest) error{
+		"Fit":  func(m *IsolationForest) error { return m.Fit(data) },
+		"Load": func(m *IsolationForest) error { return m.Load(saved) },
+		"LoadFrom": func(m *IsolationForest) error {
+			return m.LoadFrom(bytes.NewReader(saved))
+		},
+	}
+	for name, fn := range replace {
+		t.Run(name, func(t *testing.T) {
+			mapped, err := OpenMapped(path)
+			require.NoError(t, err)
+			require.NoError(t, fn(mapped))
+			assert.Nil(t, mapped.unmap, "the mapping is released")
+
+			require.NoError(t, mapped.Close())
+			assert.True(t, mapped.Trained(), "Close leaves the new model alone")
+			_, err = mapped.Predict(data)
+			assert.NoError(t, err)
+		})
+	}
+
+	t.Run("failed Load", func(t *testing.T) {
+		mapped, err := OpenMapped(path)
+		require.NoError(t, err)
+		require.Error(t, mapped.Load(saved[:len(saved)/2]))
+		assert.NotNil(t, mapped.unmap, "the mapping is kept")
+		require.NoError(t, mapped.Close())
+	})
+}
+
+func TestOpenMappedErrors(t *testing.T) {
+	f := New(WithTrees(5))
+	require.NoError(t, f.Fit(generateTestData(50, 2)))
+	saved, err := f.Save()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	gobPath := filepath.Join(dir, "model.bin")
+	require.NoError(t, os.WriteFile(gobPath, saved, 0o600))
+	emptyPath := filepath.Join(dir, "empty.bin")
+	require.NoError(t, os.WriteFile(emptyPath, nil, 0o600))
+
+	_, err = OpenMapped(gobPath)
+	assert.ErrorIs(t, err, ErrNotFlat)
+	_, err = OpenMapped(emptyPath)
+	assert.ErrorIs(t, err, ErrNotFlat)
+	_, err = OpenMapped(filepath.Join(dir, "missing.bin"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
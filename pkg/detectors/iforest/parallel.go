@@ -0,0 +1,160 @@
+package iforest
+
+import (
+	"context"
+	"math/rand"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// parallelChunk is the smallest number of samples worth scoring on a
+// separate goroutine.
+const parallelChunk = 4 * batchBlockSize
+
+// cancelCheckRows is the number of samples a worker scores between checks
+// for cancellation.
+const cancelCheckRows = 16 * batchBlockSize
+
+// sampler draws row indices without replacement using a sparse
+// Fisher-Yates shuffle: only the displaced positions of the virtual
+// permutation 0..n-1 are stored, so memory is O(sample size) rather than
+// O(rows) per worker.
+type sampler struct {
+	n      int
+	moved  map[int]int
+	sample []int
+}
+
+func newSampler(n, size int) *sampler {
+	return &sampler{n: n, moved: make(map[int]int, size), sample: make([]int, size)}
+}
+
+// at returns the value at position i of the virtual permutation.
+func (s *sampler) at(i int) int {
+	if v, ok := s.moved[i]; ok {
+		return v
+	}
+	return i
+}
+
+// draw returns a uniform sample of rows. The slice is reused by the next
+// draw.
+func (s *sampler) draw(rng *rand.Rand) []int {
+	clear(s.moved)
+	for j := range s.sample {
+		k := j + rng.Intn(s.n-j)
+		s.sample[j] = s.at(k)
+		s.moved[k] = s.at(j)
+	}
+	return s.sample
+}
+
+// scoreRows scores the n samples returned by row into scores, splitting
+// large batches across workers. Workers stop early once ctx is done, and
+// scoreRows then returns ctx.Err().
+func (f *IsolationForest) scoreRows(ctx context.Context, n int, row func(i int) []float64, scores []float64) error {
+	detectors.ParallelFor(n, detectors.Workers(f.workers), parallelChunk, func(lo, hi int) {
+		for start := lo; start < hi; start += cancelCheckRows {
+			if ctx.Err() != nil {
+				return
+			}
+			end := min(start+cancelCheckRows, hi)
+			at := func(i int) []float64 { return row(start + i) }
+			if f.quant != nil {
+				f.quant.scoreBatch(end-start, at, scores[start:end], f.avgPathLength)
+			} else {
+				f.flat.scoreBatch(end-start, at, scores[start:end], f.avgPathLength)
+			}
+		}
+	})
+	return ctx.Err()
+}
+
+// streamJob is a sample being scored by a stream worker. Its result is
+// delivered on done, which is recycled once the score has been sent.
+type streamJob struct {
+	sample []float64
+	done   chan streamResult
+}
+
+type streamResult struct {
+	score  detectors.Score
+	sample []float64
+	err    error
+}
+
+// predictStreamParallel is PredictStream with samples scored by workers
+// goroutines. Scores and rejections are emitted in input order: result channels are
+// queued in the order samples arrive and drained in that order.
+func (f *IsolationForest) predictStreamParallel(ctx context.Context, input <-chan []float64, output chan<- detectors.Score, reject detectors.RejectFunc, workers int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// free bounds the samples in flight and recycles result channels, so
+	// steady streaming does not allocate.
+	free := make(chan chan streamResult, 2*workers)
+	for range cap(free) {
+		free <- make(chan streamResult, 1)
+	}
+	jobs := make(chan streamJob, workers)
+	queue := make(chan chan streamResult, cap(free))
+
+	for range workers {
+		go func() {
+			for job := range jobs {
+				score, err := f.streamScore(job.sample)
+				job.done <- streamResult{score: score, sample: job.sample, err: err}
+			}
+		}()
+	}
+
+	// Dispatch samples in arrival order.
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for {
+			var sample []float64
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok := <-input:
+				if !ok {
+					return
+				}
+				sample = s
+			}
+
+			var done chan streamResult
+			select {
+			case <-ctx.Done():
+				return
+			case done = <-free:
+			}
+			// Workers never block, so the job send only waits for one to
+			// finish; queue has room for every channel taken from free.
+			jobs <- streamJob{sample: sample, done: done}
+			queue <- done
+		}
+	}()
+
+	for done := range queue {
+		var res streamResult
+		select {
+		case res = <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		free <- done
+		if res.err != nil {
+			reject(detectors.Rejection{Sample: res.sample, Err: res.err})
+			continue
+		}
+
+		select {
+		case output <- res.score:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
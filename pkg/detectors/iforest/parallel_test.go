@@ -0,0 +1,152 @@
+package iforest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestSampler(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := newSampler(50, 20)
+
+	counts := make([]int, 50)
+	for range 500 {
+		seen := make(map[int]bool)
+		for _, i := range s.draw(rng) {
+			assert.False(t, seen[i], "drawn twice")
+			assert.True(t, i >= 0 && i < 50)
+			seen[i] = true
+			counts[i]++
+		}
+	}
+	// Each row is drawn with probability 20/50, about 200 times in 500 draws.
+	for i, c := range counts {
+		assert.InDelta(t, 200, c, 60, "row %d", i)
+	}
+
+	all := newSampler(5, 5)
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4}, all.draw(rng))
+}
+
+func TestWorkersDoNotChangeResults(t *testing.T) {
+	data := generateTestData(3000, 4)
+
+	var want []float64
+	for _, workers := range []int{1, 3, 8} {
+		f := New(WithTrees(30), WithSeed(5), WithWorkers(workers))
+		require.NoError(t, f.Fit(data))
+		scores, err := f.Predict(data)
+		require.NoError(t, err)
+
+		if want == nil {
+			want = scores
+			continue
+		}
+		assert.Equal(t, want, scores, "workers=%d", workers)
+	}
+}
+
+func TestPredictStreamParallel(t *testing.T) {
+	data := generateTestData(500, 3)
+	f := New(WithTrees(20), WithWorkers(4))
+	require.NoError(t, f.Fit(data))
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+
+	input := make(chan []float64)
+	output := make(chan detectors.Score)
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.PredictStream(context.Background(), input, output) }()
+	go func() {
+		defer close(input)
+		for _, sample := range data {
+			input <- sample
+		}
+	}()
+
+	var got []float64
+	for score := range output {
+		got = append(got, score.Value)
+		assert.Equal(t, score.Value >= f.Threshold(), score.IsAnomaly)
+	}
+	require.NoError(t, <-errCh)
+	assert.Equal(t, want, got, "scores keep input order")
+}
+
+func TestPredictStreamParallelCancel(t *testing.T) {
+	f := New(WithTrees(10), WithWorkers(4))
+	require.NoError(t, f.Fit(generateTestData(100, 2)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	input := make(chan []float64)
+	output := make(chan detectors.Score)
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.PredictStream(ctx, input, output) }()
+
+	input <- []float64{0, 0}
+	<-output
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+	_, open := <-output
+	assert.False(t, open)
+}
+
+func TestPredictContext(t *testing.T) {
+	f := New(WithTrees(10), WithWorkers(4), WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+	data := generateTestData(3*cancelCheckRows, 3)
+
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	got, err := f.PredictContext(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = f.PredictContext(ctx, data)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, got)
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = f.PredictContext(ctx, data)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// The scaling benchmarks document speedup with the number of workers; run
+// them with -cpu to compare machine sizes.
+func BenchmarkFitWorkers(b *testing.B) {
+	data := generateTestData(100000, 10)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			f := New(WithTrees(100), WithWorkers(workers))
+			for i := 0; i < b.N; i++ {
+				_ = f.Fit(data)
+			}
+		})
+	}
+}
+
+func BenchmarkPredictWorkers(b *testing.B) {
+	f := New(WithTrees(100))
+	require.NoError(b, f.Fit(generateTestData(5000, 10)))
+	data := generateTestData(100000, 10)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			f.workers = workers
+			for i := 0; i < b.N; i++ {
+				_, _ = f.Predict(data)
+			}
+		})
+	}
+}
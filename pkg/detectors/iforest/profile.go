@@ -0,0 +1,33 @@
+package iforest
+
+import (
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// profileBins is the number of histogram bins per feature in the training
+// profile.
+const profileBins = 10
+
+var _ detectors.Profiler = (*IsolationForest)(nil)
+
+// TrainingProfile returns the per-feature distribution of the training
+// data, or nil for untrained models and models saved before profiles were
+// recorded.
+func (f *IsolationForest) TrainingProfile() *stats.Profile {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.profile
+}
+
+// strided returns every step-th row of data.
+func strided(data [][]float64, step int) [][]float64 {
+	if step <= 1 {
+		return data
+	}
+	out := make([][]float64, 0, (len(data)+step-1)/step)
+	for i := 0; i < len(data); i += step {
+		out = append(out, data[i])
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package iforest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestTrainingProfile(t *testing.T) {
+	f := New(WithTrees(20), WithSeed(42))
+	assert.Nil(t, f.TrainingProfile())
+
+	require.NoError(t, f.Fit(generateTestData(1000, 3)))
+	profile := f.TrainingProfile()
+	require.NotNil(t, profile)
+	assert.Equal(t, 1000, profile.Samples)
+	assert.Len(t, profile.Features, 3)
+
+	data, err := f.Save()
+	require.NoError(t, err)
+	loaded := New()
+	require.NoError(t, loaded.Load(data))
+	assert.Equal(t, profile, loaded.TrainingProfile())
+
+	live := generateTestData(1000, 3)
+	for _, row := range live {
+		row[1] += 3
+	}
+	report, err := detectors.Drift(loaded, live)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, report.Drifted)
+}
+
+func TestStrided(t *testing.T) {
+	data := [][]float64{{0}, {1}, {2}, {3}, {4}}
+	assert.Equal(t, data, strided(data, 1))
+	assert.Equal(t, [][]float64{{0}, {2}, {4}}, strided(data, 2))
+	assert.Equal(t, [][]float64{{0}, {3}}, strided(data, 3))
+}
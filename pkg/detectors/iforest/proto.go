@@ -0,0 +1,210 @@
+package iforest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+// SaveProto serializes the trained model as a bare goguardml.v1.Model
+// Protocol Buffers message (proto/goguardml/v1/goguardml.proto), readable
+// outside Go: the body of the Save format, without its checksum or
+// signature. Load reads it like Save output.
+func (f *IsolationForest) SaveProto() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	return f.marshalProto(), nil
+}
+
+// marshalProto encodes the model as a goguardml.v1.Model. The caller holds
+// at least the read lock.
+func (f *IsolationForest) marshalProto() []byte {
+	m := f.saved()
+	return pb.MarshalModel(pb.ModelFieldIsolationForest, func(e *pb.Encoder) {
+		encodeProto(e, &m)
+	})
+}
+
+// encodeProto writes the fields of a goguardml.v1.IsolationForest.
+func encodeProto(e *pb.Encoder, m *savedModel) {
+	e.Int(1, int64(m.SampleSize))
+	e.Double(2, m.Contamination)
+	e.Double(3, m.Threshold)
+	e.Double(4, m.AvgPathLength)
+	e.Int(5, int64(m.Features))
+	for _, tree := range m.Trees {
+		e.Message(6, func(e *pb.Encoder) {
+			feature := make([]int, len(tree))
+			value := make([]float64, len(tree))
+			left := make([]int, len(tree))
+			right := make([]int, len(tree))
+			size := make([]int, len(tree))
+			for i, n := range tree {
+				feature[i], value[i], left[i], right[i], size[i] = n.Feature, n.Value, n.Left, n.Right, n.Size
+			}
+			e.Ints(1, feature)
+			e.Doubles(2, value)
+			e.Ints(3, left)
+			e.Ints(4, right)
+			e.Ints(5, size)
+		})
+	}
+	if q := m.Quantized; q != nil {
+		e.Message(7, func(e *pb.Encoder) {
+			e.Int(1, int64(q.Bits))
+			e.Int(2, int64(q.Trees))
+			e.Doubles(3, q.Offset)
+			e.Doubles(4, q.Scale)
+			e.RawBytes(5, q.Nodes)
+		})
+	}
+	e.Doubles(8, m.Importances)
+	for _, r := range m.Typical {
+		e.Message(9, func(e *pb.Encoder) { pb.EncodeRange(e, detectors.Range(r)) })
+	}
+	if p := m.Profile.profile(); p != nil {
+		e.Message(10, func(e *pb.Encoder) { pb.EncodeProfile(e, p) })
+	}
+	e.Message(11, func(e *pb.Encoder) { pb.EncodeModelCard(e, m.Card.ModelCard()) })
+	e.Ints(12, m.Constant)
+}
+
+// loadProto reads a bare goguardml.v1.Model. The caller holds the write
+// lock.
+func (f *IsolationForest) loadProto(data []byte) error {
+	if err := f.unsigned("protobuf"); err != nil {
+		return err
+	}
+	m, err := unmarshalProto(data)
+	if err != nil {
+		return err
+	}
+	return f.restore(&m)
+}
+
+// unmarshalProto decodes a goguardml.v1.Model holding an isolation forest.
+func unmarshalProto(data []byte) (savedModel, error) {
+	var (
+		m     savedModel
+		found bool
+	)
+	d := pb.NewDecoder(data)
+	for d.Next() {
+		switch d.Field() {
+		case pb.ModelFieldSchema:
+			if s := d.String(); s != pb.ModelSchema {
+				return savedModel{}, fmt.Errorf("unsupported model schema %q", s)
+			}
+		case pb.ModelFieldIsolationForest:
+			if err := decodeProto(d.Message(), &m); err != nil {
+				return savedModel{}, fmt.Errorf("decode model: %w", err)
+			}
+			found = true
+		default:
+			return savedModel{}, errors.New("model is not an isolation forest")
+		}
+	}
+	if err := d.Err(); err != nil {
+		return savedModel{}, fmt.Errorf("decode model: %w", err)
+	}
+	if !found {
+		return savedModel{}, errors.New("model is not an isolation forest")
+	}
+	return m, nil
+}
+
+// decodeProto reads the fields of a goguardml.v1.IsolationForest into m.
+func decodeProto(d *pb.Decoder, m *savedModel) error {
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			m.SampleSize = int(d.Int())
+		case 2:
+			m.Contamination = d.Double()
+		case 3:
+			m.Threshold = d.Double()
+		case 4:
+			m.AvgPathLength = d.Double()
+		case 5:
+			m.Features = int(d.Int())
+		case 6:
+			tree, err := decodeProtoTree(d.Message())
+			if err != nil {
+				return err
+			}
+			m.Trees = append(m.Trees, tree)
+		case 7:
+			q := &savedQuantForest{}
+			qd := d.Message()
+			for qd.Next() {
+				switch qd.Field() {
+				case 1:
+					q.Bits = int(qd.Int())
+				case 2:
+					q.Trees = int(qd.Int())
+				case 3:
+					q.Offset = qd.Doubles(q.Offset)
+				case 4:
+					q.Scale = qd.Doubles(q.Scale)
+				case 5:
+					q.Nodes = append([]byte(nil), qd.RawBytes()...)
+				}
+			}
+			d.Adopt(qd)
+			m.Quantized = q
+		case 8:
+			m.Importances = d.Doubles(m.Importances)
+		case 9:
+			m.Typical = append(m.Typical, savedRange(pb.DecodeRange(d)))
+		case 10:
+			m.Profile = newSavedProfile(pb.DecodeProfile(d))
+		case 11:
+			m.Card = container.NewCard(pb.DecodeModelCard(d))
+		case 12:
+			m.Constant = d.Ints(m.Constant)
+		}
+	}
+	return d.Err()
+}
+
+// decodeProtoTree reads a goguardml.v1.Tree, whose fields must have one
+// entry per node.
+func decodeProtoTree(d *pb.Decoder) ([]savedNode, error) {
+	var (
+		feature, left, right, size []int
+		value                      []float64
+	)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			feature = d.Ints(feature)
+		case 2:
+			value = d.Doubles(value)
+		case 3:
+			left = d.Ints(left)
+		case 4:
+			right = d.Ints(right)
+		case 5:
+			size = d.Ints(size)
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, err
+	}
+	n := len(feature)
+	if len(value) != n || len(left) != n || len(right) != n || len(size) != n {
+		return nil, errors.New("tree fields differ in length")
+	}
+	nodes := make([]savedNode, n)
+	for i := range nodes {
+		nodes[i] = savedNode{Feature: feature[i], Value: value[i], Left: left[i], Right: right[i], Size: size[i]}
+	}
+	return nodes, nil
+}
@@ -0,0 +1,84 @@
+package iforest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+func TestSaveProto(t *testing.T) {
+	data := quantData(1000)
+	for _, bits := range []int{0, 8} {
+		f := New(WithTrees(30), WithSeed(3), WithQuantization(bits), WithFeatureNames([]string{"a", "b", "c", "d"}))
+		require.NoError(t, f.Fit(data))
+		want, err := f.Predict(data)
+		require.NoError(t, err)
+
+		saved, err := f.SaveProto()
+		require.NoError(t, err)
+		assert.True(t, pb.IsModel(saved))
+
+		g := New()
+		require.NoError(t, g.Load(saved))
+		got, err := g.Predict(data)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "bits %d", bits)
+		assert.Equal(t, f.Threshold(), g.Threshold())
+		assert.Equal(t, f.Metadata(), g.Metadata())
+		assert.Equal(t, f.TrainingProfile(), g.TrainingProfile())
+		assert.Equal(t, f.Quantized(), g.Quantized())
+
+		wantExp, err := f.Explain(data[0])
+		require.NoError(t, err)
+		gotExp, err := g.Explain(data[0])
+		require.NoError(t, err)
+		assert.Equal(t, wantExp, gotExp)
+
+		// Saving is reproducible.
+		again, err := g.SaveProto()
+		require.NoError(t, err)
+		assert.Equal(t, saved, again)
+	}
+
+	_, err := New().SaveProto()
+	assert.Error(t, err)
+}
+
+func TestLoadCorruptProto(t *testing.T) {
+	f := New(WithTrees(5), WithSeed(1))
+	require.NoError(t, f.Fit(quantData(200)))
+	saved, err := f.SaveProto()
+	require.NoError(t, err)
+
+	other := pb.MarshalModel(pb.ModelFieldIsolationForest+1, func(*pb.Encoder) {})
+	tests := map[string][]byte{
+		"truncated":   saved[:len(saved)/2],
+		"no trees":    pb.MarshalModel(pb.ModelFieldIsolationForest, func(e *pb.Encoder) { e.Int(5, 4) }),
+		"other model": other,
+		"uneven trees": pb.MarshalModel(pb.ModelFieldIsolationForest, func(e *pb.Encoder) {
+			e.Int(5, 1)
+			e.Message(6, func(e *pb.Encoder) { e.Ints(1, []int{0, 0}) })
+		}),
+		"bad feature": pb.MarshalModel(pb.ModelFieldIsolationForest, func(e *pb.Encoder) {
+			e.Int(5, 1)
+			e.Message(6, func(e *pb.Encoder) {
+				e.Ints(1, []int{3, 0, 0})
+				e.Doubles(2, []float64{1, 0, 0})
+				e.Ints(3, []int{1, -1, -1})
+				e.Ints(4, []int{2, -1, -1})
+				e.Ints(5, []int{2, 1, 1})
+			})
+		}),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := New()
+			assert.Error(t, g.Load(data))
+			_, err := g.PredictOne([]float64{0, 0, 0, 0})
+			assert.Error(t, err, "model left untrained")
+		})
+	}
+}
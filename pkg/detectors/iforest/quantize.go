@@ -0,0 +1,388 @@
+package iforest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// WithQuantization stores the trees with split values quantized to bits,
+// 8 or 16, per feature, and leaf sizes saturated to the same width, both
+// in memory and in the Save format. A quantized node takes 8 bytes instead
+// of the 20 of the compiled forest plus the pointer tree Fit keeps for
+// explanations, which is rebuilt on demand instead; Save output shrinks by
+// about as much. Scores move slightly: a split value is off by at most
+// half a quantization step, 1/510 of the feature's split range with 8
+// bits and 1/131070 with 16. Zero, the default, keeps full precision.
+//
+// Fit and Load quantize the model they produce. Quantized models cannot be
+// written with SaveFlat.
+func WithQuantization(bits int) Option {
+	return func(f *IsolationForest) {
+		f.quantBits = bits
+	}
+}
+
+// quantNode is a tree node of a quantized forest.
+type quantNode struct {
+	// left indexes the left child in quantForest.nodes; the right child
+	// follows it.
+	left int32
+	// feature is the split feature, or -1 for leaves.
+	feature int16
+	// code is the quantized split value of internal nodes, or the
+	// saturated number of training samples reaching a leaf.
+	code uint16
+}
+
+// quantForest is a compiled forest with quantized split values. Its nodes
+// are laid out like those of the flatForest it is made from.
+type quantForest struct {
+	bits  int
+	nodes []quantNode
+	roots []int32
+	// A split code c of feature j stands for offset[j] + c*scale[j].
+	offset, scale []float64
+	// leafPath[s] is the expected path length of the remaining isolation
+	// of s samples.
+	leafPath []float64
+}
+
+// quantize compiles ff into a quantized forest of nFeatures features.
+func quantize(ff *flatForest, nFeatures, bits int) (*quantForest, error) {
+	if nFeatures > math.MaxInt16+1 {
+		return nil, fmt.Errorf("cannot quantize a model of %d features", nFeatures)
+	}
+	levels := float64(uint64(1)<<bits - 1)
+
+	lo := make([]float64, nFeatures)
+	hi := make([]float64, nFeatures)
+	for j := range lo {
+		lo[j], hi[j] = math.Inf(1), math.Inf(-1)
+	}
+	for _, n := range ff.nodes {
+		if n.feature >= 0 {
+			lo[n.feature] = math.Min(lo[n.feature], n.value)
+			hi[n.feature] = math.Max(hi[n.feature], n.value)
+		}
+	}
+	q := &quantForest{
+		bits:   bits,
+		nodes:  make([]quantNode, len(ff.nodes)),
+		roots:  append([]int32(nil), ff.roots...),
+		offset: make([]float64, nFeatures),
+		scale:  make([]float64, nFeatures),
+	}
+	for j := range lo {
+		if lo[j] > hi[j] {
+			continue // never split on
+		}
+		q.offset[j] = lo[j]
+		q.scale[j] = (hi[j] - lo[j]) / levels
+	}
+
+	maxSize := uint16(0)
+	for i, n := range ff.nodes {
+		if n.feature < 0 {
+			size := uint16(min(uint64(ff.sizes[i]), uint64(levels)))
+			q.nodes[i] = quantNode{feature: -1, code: size}
+			maxSize = max(maxSize, size)
+			continue
+		}
+		code := uint16(0)
+		if s := q.scale[n.feature]; s > 0 {
+			code = uint16(math.Round((n.value - q.offset[n.feature]) / s))
+		}
+		q.nodes[i] = quantNode{left: n.left, feature: int16(n.feature), code: code}
+	}
+	q.setLeafPaths(maxSize)
+	return q, nil
+}
+
+func (q *quantForest) setLeafPaths(maxSize uint16) {
+	q.leafPath = make([]float64, int(maxSize)+1)
+	for s := range q.leafPath {
+		q.leafPath[s] = averagePathLength(float64(s))
+	}
+}
+
+// split returns the split value of an internal node.
+func (q *quantForest) split(n quantNode) float64 {
+	return q.offset[n.feature] + float64(n.code)*q.scale[n.feature]
+}
+
+// leaf returns the path length credited to sample by the tree at root.
+func (q *quantForest) leaf(root int32, sample []float64) float64 {
+	nodes := q.nodes
+	n := &nodes[root]
+	depth := 0
+	for n.feature >= 0 {
+		n = &nodes[n.left+b2i(sample[n.feature] >= q.split(*n))]
+		depth++
+	}
+	return float64(depth) + q.leafPath[n.code]
+}
+
+// pathLength returns the total path length of sample over all trees.
+func (q *quantForest) pathLength(sample []float64) float64 {
+	var total float64
+	for _, root := range q.roots {
+		total += q.leaf(root, sample)
+	}
+	return total
+}
+
+// scoreBatch is flatForest.scoreBatch for quantized forests.
+func (q *quantForest) scoreBatch(n int, row func(i int) []float64, scores []float64, avgPathLength float64) {
+	norm := float64(len(q.roots)) * avgPathLength
+
+	var (
+		totals [batchBlockSize]float64
+		rows   [batchBlockSize][]float64
+	)
+	for start := 0; start < n; start += batchBlockSize {
+		block := rows[:min(batchBlockSize, n-start)]
+		for i := range block {
+			block[i] = row(start + i)
+		}
+		acc := totals[:len(block)]
+		clear(acc)
+
+		for _, root := range q.roots {
+			for i, sample := range block {
+				acc[i] += q.leaf(root, sample)
+			}
+		}
+
+		for i, total := range acc {
+			scores[start+i] = score(total, norm)
+		}
+	}
+}
+
+// decompile rebuilds pointer-based trees with the dequantized split
+// values, for explanations and counterfactuals. Internal node sizes are
+// the sums of their leaves'.
+func (q *quantForest) decompile() []*iTree {
+	var build func(idx int32) *node
+	build = func(idx int32) *node {
+		qn := q.nodes[idx]
+		if qn.feature < 0 {
+			return &node{size: int(qn.code)}
+		}
+		n := &node{splitFeature: int(qn.feature), splitValue: q.split(qn)}
+		n.left = build(qn.left)
+		n.right = build(qn.left + 1)
+		n.size = n.left.size + n.right.size
+		return n
+	}
+
+	trees := make([]*iTree, len(q.roots))
+	for i, root := range q.roots {
+		trees[i] = &iTree{root: build(root)}
+	}
+	return trees
+}
+
+// savedQuantForest is the serialized form of a quantized forest. Nodes
+// holds the trees one after the other, each node in preorder as its split
+// feature, uint16 with 0xFFFF for leaves, followed by its code in bits/8
+// bytes, little-endian.
+type savedQuantForest struct {
+	Bits   int
+	Trees  int
+	Offset []float64
+	Scale  []float64
+	Nodes  []byte
+}
+
+const quantLeaf = 0xFFFF
+
+func (q *quantForest) saved() *savedQuantForest {
+	width := 2 + q.bits/8
+	s := &savedQuantForest{
+		Bits:   q.bits,
+		Trees:  len(q.roots),
+		Offset: q.offset,
+		Scale:  q.scale,
+		Nodes:  make([]byte, 0, len(q.nodes)*width),
+	}
+	le := binary.LittleEndian
+	var write func(idx int32)
+	write = func(idx int32) {
+		n := q.nodes[idx]
+		feature := uint16(quantLeaf)
+		if n.feature >= 0 {
+			feature = uint16(n.feature)
+		}
+		s.Nodes = le.AppendUint16(s.Nodes, feature)
+		if q.bits == 8 {
+			s.Nodes = append(s.Nodes, byte(n.code))
+		} else {
+			s.Nodes = le.AppendUint16(s.Nodes, n.code)
+		}
+		if n.feature >= 0 {
+			write(n.left)
+			write(n.left + 1)
+		}
+	}
+	for _, root := range q.roots {
+		write(root)
+	}
+	return s
+}
+
+// forest rebuilds the quantized forest of a model with nFeatures
+// features, validating it like unflattenTrees.
+func (s *savedQuantForest) forest(nFeatures int) (*quantForest, error) {
+	if s.Bits != 8 && s.Bits != 16 {
+		return nil, fmt.Errorf("quantized model: unsupported width %d", s.Bits)
+	}
+	if s.Trees < 1 {
+		return nil, errors.New("model has no trees")
+	}
+	if len(s.Offset) != nFeatures || len(s.Scale) != nFeatures {
+		return nil, errors.New("quantized model: scales do not match its features")
+	}
+	for j := range s.Offset {
+		if math.IsNaN(s.Offset[j]) || math.IsInf(s.Offset[j], 0) || !(s.Scale[j] >= 0) || math.IsInf(s.Scale[j], 0) {
+			return nil, errors.New("quantized model: invalid scale")
+		}
+	}
+
+	width := 2 + s.Bits/8
+	if len(s.Nodes)%width != 0 || len(s.Nodes)/width > math.MaxInt32 {
+		return nil, errors.New("quantized model: truncated nodes")
+	}
+	q := &quantForest{
+		bits:   s.Bits,
+		nodes:  make([]quantNode, 0, len(s.Nodes)/width),
+		roots:  make([]int32, 0, s.Trees),
+		offset: s.Offset,
+		scale:  s.Scale,
+	}
+	le := binary.LittleEndian
+	pos := 0
+	maxSize := uint16(0)
+	// read decodes the next node into idx, then its subtree.
+	var read func(idx int32, depth int) error
+	read = func(idx int32, depth int) error {
+		if pos+width > len(s.Nodes) {
+			return errors.New("quantized model: truncated tree")
+		}
+		feature := le.Uint16(s.Nodes[pos:])
+		code := uint16(s.Nodes[pos+2])
+		if s.Bits == 16 {
+			code = le.Uint16(s.Nodes[pos+2:])
+		}
+		pos += width
+
+		if feature == quantLeaf {
+			q.nodes[idx] = quantNode{feature: -1, code: code}
+			maxSize = max(maxSize, code)
+			return nil
+		}
+		if int(feature) >= nFeatures {
+			return errors.New("quantized model: invalid split feature")
+		}
+		if depth >= maxTreeDepth {
+			return fmt.Errorf("tree deeper than %d in quantized model", maxTreeDepth)
+		}
+		left := int32(len(q.nodes))
+		q.nodes = append(q.nodes, quantNode{}, quantNode{})
+		q.nodes[idx] = quantNode{left: left, feature: int16(feature), code: code}
+		if err := read(left, depth+1); err != nil {
+			return err
+		}
+		return read(left+1, depth+1)
+	}
+	for range s.Trees {
+		root := int32(len(q.nodes))
+		q.nodes = append(q.nodes, quantNode{})
+		q.roots = append(q.roots, root)
+		if err := read(root, 0); err != nil {
+			return nil, err
+		}
+	}
+	if pos != len(s.Nodes) {
+		return nil, errors.New("quantized model: trailing nodes")
+	}
+	q.setLeafPaths(maxSize)
+	return q, nil
+}
+
+// quantizeModel replaces the compiled forest and the pointer trees with a
+// quantized forest, if quantization is on and the model is not quantized
+// yet. The caller holds the write lock.
+func (f *IsolationForest) quantizeModel() error {
+	if f.quantBits == 0 || f.quant != nil {
+		return nil
+	}
+	q, err := quantize(f.treeFlat(), f.nFeatures, f.quantBits)
+	if err != nil {
+		return err
+	}
+	f.quant = q
+	f.flat = nil
+	f.trees = nil
+	return nil
+}
+
+// treeFlat returns the compiled forest, compiling the pointer trees if
+// needed. The caller holds at least the read lock.
+func (f *IsolationForest) treeFlat() *flatForest {
+	if f.flat != nil {
+		return f.flat
+	}
+	return compileForest(f.treeSet())
+}
+
+// Quantized returns the width split values are quantized to, 0 if the
+// model keeps full precision.
+func (f *IsolationForest) Quantized() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.quant == nil {
+		return 0
+	}
+	return f.quant.bits
+}
+
+// MemorySize returns the approximate number of bytes the trained model
+// holds: its trees, in every form currently built, and its training
+// statistics. It makes forests a manager.Sizer.
+func (f *IsolationForest) MemorySize() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var size int64
+	if ff := f.flat; ff != nil && f.unmap == nil {
+		size += int64(len(ff.nodes))*int64(unsafe.Sizeof(flatNode{})) + int64(len(ff.sizes))*4 + int64(len(ff.roots))*4
+	}
+	if q := f.quant; q != nil {
+		size += int64(len(q.nodes))*int64(unsafe.Sizeof(quantNode{})) + int64(len(q.roots))*4 +
+			int64(len(q.offset)+len(q.scale)+len(q.leafPath))*8
+	}
+	if f.trees != nil {
+		var count func(n *node) int64
+		count = func(n *node) int64 {
+			if n == nil {
+				return 0
+			}
+			return 1 + count(n.left) + count(n.right)
+		}
+		for _, tree := range f.trees {
+			size += count(tree.root) * int64(unsafe.Sizeof(node{}))
+		}
+	}
+	size += int64(len(f.importances)+len(f.constant)) * 8
+	size += int64(len(f.typical)) * int64(unsafe.Sizeof(f.typical[0]))
+	if f.profile != nil {
+		for _, fp := range f.profile.Features {
+			size += int64(16 + 8*(len(fp.Edges)+len(fp.Fractions)+len(fp.Quantiles)))
+		}
+	}
+	return size
+}
@@ -0,0 +1,219 @@
+package iforest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quantData returns normal samples with a few outliers, on features of
+// very different scales.
+func quantData(n int) [][]float64 {
+	rng := rand.New(rand.NewSource(5))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), 1000 + 50*rng.NormFloat64(), rng.ExpFloat64() * 1e-3, float64(rng.Intn(5))}
+		if i%50 == 0 {
+			data[i][0] += 6
+			data[i][1] -= 400
+		}
+	}
+	return data
+}
+
+func TestQuantizationAccuracy(t *testing.T) {
+	train, test := quantData(2000), quantData(500)
+	full := New(WithTrees(100), WithSeed(9))
+	require.NoError(t, full.Fit(train))
+	want, err := full.Predict(test)
+	require.NoError(t, err)
+
+	tests := []struct {
+		bits         int
+		maxDelta     float64
+		maxFlagDelta int
+	}{
+		{bits: 16, maxDelta: 0.002, maxFlagDelta: 2},
+		{bits: 8, maxDelta: 0.03, maxFlagDelta: 10},
+	}
+	for _, tt := range tests {
+		q := New(WithTrees(100), WithSeed(9), WithQuantization(tt.bits))
+		require.NoError(t, q.Fit(train))
+		assert.Equal(t, tt.bits, q.Quantized())
+		got, err := q.Predict(test)
+		require.NoError(t, err)
+
+		var maxDelta, sumDelta float64
+		flags := 0
+		for i := range want {
+			d := math.Abs(got[i] - want[i])
+			maxDelta = math.Max(maxDelta, d)
+			sumDelta += d
+			if (got[i] >= q.Threshold()) != (want[i] >= full.Threshold()) {
+				flags++
+			}
+		}
+		t.Logf("%d bits: max delta %.5f, mean delta %.6f, %d flags changed", tt.bits, maxDelta, sumDelta/float64(len(want)), flags)
+		assert.Less(t, maxDelta, tt.maxDelta, "%d bits", tt.bits)
+		assert.LessOrEqual(t, flags, tt.maxFlagDelta, "%d bits", tt.bits)
+		assert.InDelta(t, full.Threshold(), q.Threshold(), tt.maxDelta, "%d bits", tt.bits)
+
+		for i, sample := range test[:20] {
+			v, err := q.PredictOne(sample)
+			require.NoError(t, err)
+			assert.Equal(t, got[i], v, "PredictOne matches Predict")
+		}
+	}
+}
+
+func TestQuantizationSize(t *testing.T) {
+	data := quantData(2000)
+	full := New(WithTrees(100), WithSeed(9))
+	require.NoError(t, full.Fit(data))
+	q := New(WithTrees(100), WithSeed(9), WithQuantization(8))
+	require.NoError(t, q.Fit(data))
+
+	assert.Less(t, 4*q.MemorySize(), full.MemorySize())
+
+	fullModel, err := full.Save()
+	require.NoError(t, err)
+	qModel, err := q.Save()
+	require.NoError(t, err)
+	t.Logf("memory %d -> %d bytes, saved %d -> %d bytes", full.MemorySize(), q.MemorySize(), len(fullModel), len(qModel))
+	assert.Less(t, 3*len(qModel), len(fullModel))
+}
+
+func TestQuantizedSaveLoad(t *testing.T) {
+	data := quantData(500)
+	for _, bits := range []int{8, 16} {
+		q := New(WithTrees(20), WithSeed(3), WithQuantization(bits))
+		require.NoError(t, q.Fit(data))
+		want, err := q.Predict(data)
+		require.NoError(t, err)
+		model, err := q.Save()
+		require.NoError(t, err)
+
+		// Any forest loads a quantized model as it was saved.
+		loaded := New()
+		require.NoError(t, loaded.Load(model))
+		assert.Equal(t, bits, loaded.Quantized())
+		got, err := loaded.Predict(data)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+		assert.Equal(t, q.Threshold(), loaded.Threshold())
+		assert.Equal(t, q.Metadata(), loaded.Metadata())
+
+		resaved, err := loaded.Save()
+		require.NoError(t, err)
+		assert.Equal(t, model, resaved)
+
+		_, err = q.SaveFlat()
+		assert.Error(t, err)
+	}
+}
+
+func TestQuantizeOnLoad(t *testing.T) {
+	data := quantData(500)
+	full := New(WithTrees(20), WithSeed(3))
+	require.NoError(t, full.Fit(data))
+
+	saved, err := full.Save()
+	require.NoError(t, err)
+	flat, err := full.SaveFlat()
+	require.NoError(t, err)
+	for name, model := range map[string][]byte{"save": saved, "flat": flat} {
+		q := New(WithQuantization(16))
+		require.NoError(t, q.Load(model), name)
+		assert.Equal(t, 16, q.Quantized(), name)
+		want, _ := full.PredictOne(data[0])
+		got, err := q.PredictOne(data[0])
+		require.NoError(t, err)
+		assert.InDelta(t, want, got, 0.002, name)
+	}
+}
+
+func TestQuantizedExplanations(t *testing.T) {
+	data := quantData(500)
+	q := New(WithTrees(50), WithSeed(3), WithQuantization(8))
+	require.NoError(t, q.Fit(data))
+
+	importances := q.FeatureImportances()
+	require.Len(t, importances, 4)
+	e, err := q.Explain([]float64{8, 500, 0, 2})
+	require.NoError(t, err)
+	assert.NotEmpty(t, e.Contributions)
+	var sum float64
+	for _, c := range e.Contributions {
+		sum += c
+	}
+	assert.InDelta(t, 1, sum, 1e-9, "explained by the rebuilt trees")
+
+	// Refit keeps quantizing.
+	require.NoError(t, q.Refit(data))
+	assert.Equal(t, 8, q.Quantized())
+}
+
+func TestQuantizationOption(t *testing.T) {
+	err := New(WithQuantization(12)).Validate()
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.ErrorContains(t, err, "WithQuantization(12)")
+	assert.Zero(t, New().Quantized())
+}
+
+func TestQuantizedFitFailureKeepsModel(t *testing.T) {
+	data := quantData(200)
+	f := New(WithTrees(10), WithSeed(1), WithQuantization(8), WithContamination(0.05))
+	require.NoError(t, f.Fit(data))
+	want, err := f.Predict(data)
+	require.NoError(t, err)
+	threshold := f.Threshold()
+
+	// Too many features to quantize: Fit fails after growing the trees.
+	wide := make([][]float64, 2)
+	for i := range wide {
+		wide[i] = make([]float64, math.MaxInt16+2)
+		for j := range wide[i] {
+			wide[i][j] = float64(i + j)
+		}
+	}
+	assert.ErrorContains(t, f.Fit(wide), "cannot quantize")
+
+	got, err := f.Predict(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, threshold, f.Threshold())
+	assert.Equal(t, 4, f.Metadata().Features)
+}
+
+func TestLoadCorruptQuantized(t *testing.T) {
+	valid := &savedQuantForest{Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{1},
+		Nodes: []byte{0, 0, 3, 0xFF, 0xFF, 1, 0xFF, 0xFF, 2}}
+	forest, err := valid.forest(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, forest.leaf(0, []float64{2}), "left leaf of one sample at depth 1")
+	assert.InDelta(t, 1+averagePathLength(2), forest.leaf(0, []float64{3}), 1e-12)
+
+	tests := map[string]savedQuantForest{
+		"width":     {Bits: 4, Trees: 1, Offset: []float64{0}, Scale: []float64{1}, Nodes: valid.Nodes},
+		"scales":    {Bits: 8, Trees: 1, Offset: []float64{0, 0}, Scale: []float64{1}, Nodes: valid.Nodes},
+		"nan scale": {Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{math.NaN()}, Nodes: valid.Nodes},
+		"truncated": {Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{1}, Nodes: valid.Nodes[:6]},
+		"trailing":  {Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{1}, Nodes: append(valid.Nodes[:9:9], 0xFF, 0xFF, 0)},
+		"feature":   {Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{1}, Nodes: []byte{1, 0, 3, 0xFF, 0xFF, 1, 0xFF, 0xFF, 2}},
+		"no trees":  {Bits: 8, Offset: []float64{0}, Scale: []float64{1}},
+	}
+	for name, s := range tests {
+		_, err := s.forest(1)
+		assert.Error(t, err, name)
+	}
+
+	deep := savedQuantForest{Bits: 8, Trees: 1, Offset: []float64{0}, Scale: []float64{1}}
+	for range maxTreeDepth + 1 {
+		deep.Nodes = append(deep.Nodes, 0, 0, 1)
+	}
+	_, err = deep.forest(1)
+	assert.ErrorContains(t, err, "deeper")
+}
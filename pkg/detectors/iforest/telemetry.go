@@ -0,0 +1,177 @@
+package iforest
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Telemetry reported by the Isolation Forest.
+const (
+	// MetricAvgPathLength is the mean path length of the period's samples
+	// per tree, including the expected length below their leaves. It falls
+	// as more samples isolate quickly.
+	MetricAvgPathLength = "avg_path_length"
+
+	// MetricMaxDepthRate is the share of tree traversals ending at the
+	// depth limit, in leaves trees stopped growing without isolating their
+	// training samples.
+	MetricMaxDepthRate = "max_depth_rate"
+
+	// HistogramLeafDepth counts tree traversals by the depth of the leaf
+	// they end in, from the root at 0 to the depth limit.
+	HistogramLeafDepth = "leaf_depth"
+)
+
+var _ detectors.TelemetryReporter = (*IsolationForest)(nil)
+
+// SetTelemetryHandler reports the telemetry of the samples scored by
+// Predict, PredictOne, PredictDataset and PredictStream to fn once per
+// interval: MetricAvgPathLength, MetricMaxDepthRate and
+// HistogramLeafDepth. Collecting walks every tree once more per sample.
+// fn is called on the scoring goroutine, after the model is released.
+func (f *IsolationForest) SetTelemetryHandler(interval time.Duration, fn detectors.TelemetryFunc) {
+	f.telemetry.set(interval, fn)
+}
+
+// telemetry accumulates the current period and holds completed ones
+// until they are reported.
+type telemetry struct {
+	on atomic.Bool
+
+	mu       sync.Mutex
+	interval time.Duration
+	fn       detectors.TelemetryFunc
+	now      func() time.Time
+	start    time.Time
+	samples  int
+	pathSum  float64
+	depths   []uint64
+	visits   uint64
+	atLimit  uint64
+	done     []detectors.Telemetry
+}
+
+func (t *telemetry) set(interval time.Duration, fn detectors.TelemetryFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.now == nil {
+		t.now = time.Now
+	}
+	t.interval, t.fn = interval, fn
+	t.reset(t.now())
+	t.done = nil
+	t.on.Store(fn != nil)
+}
+
+// reset starts a period at now. The caller holds t.mu.
+func (t *telemetry) reset(now time.Time) {
+	t.start = now
+	t.samples, t.pathSum, t.visits, t.atLimit = 0, 0, 0, 0
+	t.depths = nil
+}
+
+// observe walks the n samples returned by row through the trees of f, if
+// telemetry is on. The caller holds the read lock of f.
+func (t *telemetry) observe(f *IsolationForest, n int, row func(i int) []float64) {
+	if !t.on.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range n {
+		sample := row(i)
+		var path float64
+		trees := f.walk(sample, func(depth int, leafPath float64) {
+			path += leafPath
+			if depth >= len(t.depths) {
+				t.depths = append(t.depths, make([]uint64, depth+1-len(t.depths))...)
+			}
+			t.depths[depth]++
+			if depth >= f.maxDepth {
+				t.atLimit++
+			}
+		})
+		if trees == 0 {
+			continue
+		}
+		t.samples++
+		t.visits += uint64(trees)
+		t.pathSum += path / float64(trees)
+	}
+
+	now := t.now()
+	if now.Sub(t.start) < t.interval {
+		return
+	}
+	report := detectors.Telemetry{
+		Start:      t.start,
+		End:        now,
+		Samples:    t.samples,
+		Metrics:    map[string]float64{},
+		Histograms: map[string][]uint64{HistogramLeafDepth: t.depths},
+	}
+	if t.samples > 0 {
+		report.Metrics[MetricAvgPathLength] = t.pathSum / float64(t.samples)
+		report.Metrics[MetricMaxDepthRate] = float64(t.atLimit) / float64(t.visits)
+	}
+	t.done = append(t.done, report)
+	t.reset(now)
+}
+
+// report passes completed periods to the handler. It is deferred by
+// scoring methods before they take the read lock, so the handler runs
+// once it is released and may call back into the forest.
+func (t *telemetry) report() {
+	if !t.on.Load() {
+		return
+	}
+	t.mu.Lock()
+	done, fn := t.done, t.fn
+	t.done = nil
+	t.mu.Unlock()
+	for _, r := range done {
+		fn(r)
+	}
+}
+
+// walk calls visit with the depth and path length of the leaf sample
+// reaches in each tree, and returns the number of trees.
+func (f *IsolationForest) walk(sample []float64, visit func(depth int, path float64)) int {
+	if q := f.quant; q != nil {
+		for _, root := range q.roots {
+			visit(q.leafDepth(root, sample))
+		}
+		return len(q.roots)
+	}
+	for _, root := range f.flat.roots {
+		visit(f.flat.leafDepth(root, sample))
+	}
+	return len(f.flat.roots)
+}
+
+// leafDepth is leaf, also returning the depth of the leaf.
+func (ff *flatForest) leafDepth(root int32, sample []float64) (int, float64) {
+	nodes := ff.nodes
+	n := &nodes[root]
+	depth := 0
+	for n.feature >= 0 {
+		n = &nodes[n.left+b2i(sample[n.feature] >= n.value)]
+		depth++
+	}
+	return depth, n.value
+}
+
+// leafDepth is leaf, also returning the depth of the leaf.
+func (q *quantForest) leafDepth(root int32, sample []float64) (int, float64) {
+	nodes := q.nodes
+	n := &nodes[root]
+	depth := 0
+	for n.feature >= 0 {
+		n = &nodes[n.left+b2i(sample[n.feature] >= q.split(*n))]
+		depth++
+	}
+	return depth, float64(depth) + q.leafPath[n.code]
+}
@@ -0,0 +1,75 @@
+package iforest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func TestTelemetry(t *testing.T) {
+	f := New(WithTrees(20), WithSampleSize(64), WithSeed(1))
+	train := generateTestData(500, 2)
+	require.NoError(t, f.Fit(train))
+
+	now := time.Unix(1700000000, 0)
+	f.telemetry.now = func() time.Time { return now }
+	var reports []detectors.Telemetry
+	f.SetTelemetryHandler(time.Minute, func(r detectors.Telemetry) {
+		reports = append(reports, r)
+		// The forest is released before the handler runs.
+		f.SetThreshold(f.Threshold())
+	})
+
+	_, err := f.Predict(train[:100])
+	require.NoError(t, err)
+	assert.Empty(t, reports, "the period has not ended")
+
+	now = now.Add(time.Minute)
+	_, err = f.PredictOne(train[100])
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	normal := reports[0]
+	assert.Equal(t, 101, normal.Samples)
+	assert.Equal(t, now, normal.End)
+	depths := normal.Histograms[HistogramLeafDepth]
+	require.Len(t, depths, f.maxDepth+1, "normal samples reach the depth limit")
+	var visits uint64
+	for _, n := range depths {
+		visits += n
+	}
+	assert.Equal(t, uint64(101*20), visits)
+	assert.InDelta(t, float64(depths[f.maxDepth])/float64(visits), normal.Metrics[MetricMaxDepthRate], 1e-12)
+
+	// Outliers isolate early: shorter paths and fewer traversals reaching
+	// the depth limit.
+	outliers := make([][]float64, 50)
+	for i := range outliers {
+		outliers[i] = []float64{50 + float64(i), -50}
+	}
+	input := make(chan []float64, len(outliers))
+	for _, s := range outliers {
+		input <- s
+	}
+	close(input)
+	output := make(chan detectors.Score, len(outliers))
+	require.NoError(t, f.PredictStream(context.Background(), input, output))
+	now = now.Add(time.Minute)
+	_, err = f.PredictOne(outliers[0])
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	drifted := reports[1]
+	assert.Equal(t, 51, drifted.Samples)
+	assert.Less(t, drifted.Metrics[MetricAvgPathLength], normal.Metrics[MetricAvgPathLength])
+	assert.Less(t, drifted.Metrics[MetricMaxDepthRate], normal.Metrics[MetricMaxDepthRate])
+
+	f.SetTelemetryHandler(0, nil)
+	now = now.Add(time.Hour)
+	_, err = f.Predict(train[:10])
+	require.NoError(t, err)
+	assert.Len(t, reports, 2, "a nil handler stops collecting")
+}
@@ -0,0 +1,87 @@
+package iforest
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithFeatureWeights biases the features trees split on: each split picks
+// feature j with probability proportional to weights[j] instead of
+// uniformly, so features known to matter, such as ports over TTLs, isolate
+// samples sooner and count for more in the scores. A weight of 0 keeps a
+// feature out of splits, like WithExcludeConstant does for constant ones.
+//
+// Weights must be finite and non-negative with at least one positive, and
+// Fit rejects weights whose length differs from the number of features.
+// The default, nil, weights features equally and grows the same trees as
+// before for a given seed.
+func WithFeatureWeights(weights []float64) Option {
+	return func(f *IsolationForest) {
+		f.featureWeights = slices.Clone(weights)
+	}
+}
+
+// FeatureWeights returns the split weights set with WithFeatureWeights, or
+// nil if features are weighted equally.
+func (f *IsolationForest) FeatureWeights() []float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return slices.Clone(f.featureWeights)
+}
+
+// validateWeights reports weights WithFeatureWeights cannot train with.
+func validateWeights(weights []float64) *OptionError {
+	if weights == nil {
+		return nil
+	}
+	positive := false
+	for _, w := range weights {
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			return &OptionError{Option: "WithFeatureWeights", Value: weights, Reason: "weights must be finite and non-negative"}
+		}
+		positive = positive || w > 0
+	}
+	if !positive {
+		return &OptionError{Option: "WithFeatureWeights", Value: weights, Reason: "need at least one positive weight"}
+	}
+	return nil
+}
+
+// cumulativeWeights returns the running sums of the weights of features,
+// for drawing them by weight, or nil if features are weighted equally.
+func cumulativeWeights(weights []float64, features []int) []float64 {
+	if weights == nil {
+		return nil
+	}
+	cum := make([]float64, len(features))
+	total := 0.0
+	for i, j := range features {
+		total += weights[j]
+		cum[i] = total
+	}
+	return cum
+}
+
+// drawFeature picks one of features, uniformly or, given their cumulative
+// weights, with probability proportional to their weight.
+func drawFeature(features []int, cum []float64, rng *rand.Rand) int {
+	if cum == nil {
+		return features[rng.Intn(len(features))]
+	}
+	r := rng.Float64() * cum[len(cum)-1]
+	i := sort.Search(len(cum), func(i int) bool { return cum[i] > r })
+	return features[min(i, len(features)-1)]
+}
+
+// formatWeights formats weights for the model card.
+func formatWeights(weights []float64) string {
+	parts := make([]string, len(weights))
+	for i, w := range weights {
+		parts[i] = strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,80 @@
+package iforest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitCounts counts the splits on each feature across the forest.
+func splitCounts(f *IsolationForest) []int {
+	counts := make([]int, f.nFeatures)
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n == nil || n.left == nil {
+			return
+		}
+		counts[n.splitFeature]++
+		walk(n.left)
+		walk(n.right)
+	}
+	for _, tree := range f.trees {
+		walk(tree.root)
+	}
+	return counts
+}
+
+func TestFeatureWeights(t *testing.T) {
+	data := generateTestData(500, 3)
+
+	f := New(WithTrees(50), WithSeed(1), WithFeatureWeights([]float64{8, 1, 1}))
+	require.NoError(t, f.Fit(data))
+	counts := splitCounts(f)
+	total := counts[0] + counts[1] + counts[2]
+	assert.InDelta(t, 0.8, float64(counts[0])/float64(total), 0.05)
+	assert.Equal(t, []float64{8, 1, 1}, f.FeatureWeights())
+	assert.Equal(t, "8,1,1", f.Metadata().Hyperparameters["feature_weights"])
+
+	// A zero weight keeps the feature out of splits, so deviating in it
+	// alone does not raise the score.
+	f = New(WithTrees(50), WithSeed(1), WithFeatureWeights([]float64{1, 1, 0}))
+	require.NoError(t, f.Fit(data))
+	assert.Zero(t, splitCounts(f)[2])
+	normal, err := f.PredictOne([]float64{0, 0, 0})
+	require.NoError(t, err)
+	ignored, err := f.PredictOne([]float64{0, 0, 50})
+	require.NoError(t, err)
+	assert.Equal(t, normal, ignored)
+	outlier, err := f.PredictOne([]float64{50, 0, 0})
+	require.NoError(t, err)
+	assert.Greater(t, outlier, normal)
+
+	// Refit keeps the weights.
+	require.NoError(t, f.Refit(data))
+	assert.Zero(t, splitCounts(f)[2])
+
+	// nil weights grow the same trees as the default.
+	plain, again := New(WithTrees(5), WithSeed(3)), New(WithTrees(5), WithSeed(3), WithFeatureWeights(nil))
+	require.NoError(t, plain.Fit(data))
+	require.NoError(t, again.Fit(data))
+	assert.Equal(t, splitCounts(plain), splitCounts(again))
+}
+
+func TestFeatureWeightsErrors(t *testing.T) {
+	for _, weights := range [][]float64{{1, -1}, {1, math.NaN()}, {math.Inf(1), 1}, {0, 0}} {
+		err := New(WithFeatureWeights(weights)).Validate()
+		assert.ErrorIs(t, err, ErrInvalidOption, "%v", weights)
+	}
+
+	data := generateTestData(100, 2)
+	assert.ErrorContains(t, New(WithFeatureWeights([]float64{1, 1, 1})).Fit(data), "3 feature weights for 2 features")
+
+	for _, row := range data {
+		row[1] = 7
+	}
+	err := New(WithFeatureWeights([]float64{0, 1})).Fit(data)
+	assert.ErrorIs(t, err, ErrNoWeightedFeatures)
+	assert.NoError(t, New(WithFeatureWeights([]float64{1, 0})).Fit(data))
+}
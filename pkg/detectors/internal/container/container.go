@@ -0,0 +1,131 @@
+// Package container reads and writes the versioned container detectors
+// save their models in, and the serialized form of the model card every
+// saved model carries:
+//
+//	magic    eight bytes naming the detector, such as "GGHBSAVE"
+//	version  uint32, little-endian
+//	body     gob-encoded model
+//	checksum SHA-256 of everything before it
+//
+// Bodies follow the rules of the Isolation Forest format: fields may be
+// added, and are never renamed, retyped or reused. A change that breaks
+// these rules must bump the version; Read rejects versions other than the
+// one it reads with detectors.ErrModelVersion.
+//
+// Detectors load a model by reading the whole container, then checking
+// the decoded body can score samples, and only then replacing their own
+// state: a Load that fails leaves the detector as it was. LoadFrom reads r
+// whole and loads it like Load, and Save and SaveTo write the same bytes.
+package container
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Format identifies the saved models of one detector.
+type Format struct {
+	// Name prefixes errors, such as "hbos".
+	Name string
+	// Model names the detector in errors, such as "HBOS".
+	Model   string
+	Magic   string
+	Version uint32
+}
+
+// Write writes body to w in the container.
+func (f Format) Write(w io.Writer, body any) error {
+	sum := sha256.New()
+	mw := io.MultiWriter(w, sum)
+	if _, err := mw.Write(binary.LittleEndian.AppendUint32([]byte(f.Magic), f.Version)); err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(mw).Encode(body); err != nil {
+		return err
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// Read decodes the body of the container data into body. The checksum is
+// verified before anything is decoded; it returns errors wrapping
+// detectors.ErrModelVersion and detectors.ErrChecksum.
+func (f Format) Read(data []byte, body any) error {
+	header := len(f.Magic) + 4
+	if len(data) < header+sha256.Size || !bytes.HasPrefix(data, []byte(f.Magic)) {
+		return fmt.Errorf("%s: not a saved %s model", f.Name, f.Model)
+	}
+	if version := binary.LittleEndian.Uint32(data[len(f.Magic):]); version != f.Version {
+		return fmt.Errorf("%s: %w %d (this build reads up to %d)", f.Name, detectors.ErrModelVersion, version, f.Version)
+	}
+	content, trailer := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if sum := sha256.Sum256(content); !bytes.Equal(sum[:], trailer) {
+		return fmt.Errorf("%s: %w", f.Name, detectors.ErrChecksum)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(content[header:])).Decode(body); err != nil {
+		return fmt.Errorf("%s: decode model: %w", f.Name, err)
+	}
+	return nil
+}
+
+// Card is the serialized form of a model card. Hyperparameters are stored
+// sorted by name, since gob encodes maps in random order and saved models
+// should be byte-for-byte reproducible.
+type Card struct {
+	TrainedAt       time.Time
+	DataSource      string
+	Rows            int
+	Features        int
+	FeatureNames    []string
+	Hyperparameters [][2]string
+	LibraryVersion  string
+	DataHash        string
+}
+
+// NewCard returns the serialized form of card.
+func NewCard(card detectors.ModelCard) Card {
+	c := Card{
+		TrainedAt:      card.TrainedAt,
+		DataSource:     card.DataSource,
+		Rows:           card.Rows,
+		Features:       card.Features,
+		FeatureNames:   card.FeatureNames,
+		LibraryVersion: card.LibraryVersion,
+		DataHash:       card.DataHash,
+	}
+	for k, v := range card.Hyperparameters {
+		c.Hyperparameters = append(c.Hyperparameters, [2]string{k, v})
+	}
+	sort.Slice(c.Hyperparameters, func(i, j int) bool {
+		return c.Hyperparameters[i][0] < c.Hyperparameters[j][0]
+	})
+	return c
+}
+
+// ModelCard returns the model card c is the serialized form of.
+func (c Card) ModelCard() detectors.ModelCard {
+	card := detectors.ModelCard{
+		TrainedAt:      c.TrainedAt,
+		DataSource:     c.DataSource,
+		Rows:           c.Rows,
+		Features:       c.Features,
+		FeatureNames:   c.FeatureNames,
+		LibraryVersion: c.LibraryVersion,
+		DataHash:       c.DataHash,
+	}
+	if len(c.Hyperparameters) > 0 {
+		card.Hyperparameters = make(map[string]string, len(c.Hyperparameters))
+		for _, kv := range c.Hyperparameters {
+			card.Hyperparameters[kv[0]] = kv[1]
+		}
+	}
+	return card
+}
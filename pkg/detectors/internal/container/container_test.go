@@ -0,0 +1,75 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var format = Format{Name: "test", Model: "test", Magic: "GGTTSAVE", Version: 3}
+
+type body struct {
+	Values []float64
+	Card   Card
+}
+
+func TestReadWrite(t *testing.T) {
+	card := detectors.ModelCard{
+		TrainedAt:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		DataSource:      "flows.csv",
+		Rows:            100,
+		Features:        2,
+		FeatureNames:    []string{"a", "b"},
+		Hyperparameters: map[string]string{"k": "5", "algorithm": "test", "z": "1"},
+		LibraryVersion:  "v1",
+		DataHash:        "abc",
+	}
+	in := body{Values: []float64{1, 2.5}, Card: NewCard(card)}
+	assert.Equal(t, "algorithm", in.Card.Hyperparameters[0][0], "hyperparameters are sorted")
+
+	var buf bytes.Buffer
+	require.NoError(t, format.Write(&buf, &in))
+	saved := buf.Bytes()
+	assert.True(t, bytes.HasPrefix(saved, []byte("GGTTSAVE\x03\x00\x00\x00")))
+
+	var out body
+	require.NoError(t, format.Read(saved, &out))
+	assert.Equal(t, in, out)
+	assert.Equal(t, card, out.Card.ModelCard())
+
+	buf.Reset()
+	require.NoError(t, format.Write(&buf, &in))
+	assert.Equal(t, saved, buf.Bytes(), "writing is reproducible")
+}
+
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, format.Write(&buf, &body{Values: []float64{1}}))
+	saved := buf.Bytes()
+	var out body
+
+	corrupt := bytes.Clone(saved)
+	corrupt[len(corrupt)/2] ^= 0xff
+	err := format.Read(corrupt, &out)
+	assert.ErrorIs(t, err, detectors.ErrChecksum)
+	assert.ErrorContains(t, err, "test: ")
+
+	newer := bytes.Clone(saved)
+	binary.LittleEndian.PutUint32(newer[len(format.Magic):], format.Version+1)
+	err = format.Read(newer, &out)
+	assert.ErrorIs(t, err, detectors.ErrModelVersion)
+	assert.ErrorContains(t, err, "test: ")
+
+	other := format
+	other.Magic = "GGXXSAVE"
+	assert.EqualError(t, other.Read(saved, &out), "test: not a saved test model")
+	assert.Error(t, format.Read([]byte("not a model"), &out))
+	assert.ErrorIs(t, format.Read(saved[:len(saved)-1], &out), detectors.ErrChecksum)
+	assert.Nil(t, out.Values)
+}
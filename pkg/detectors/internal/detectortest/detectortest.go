@@ -0,0 +1,457 @@
+// Package detectortest checks the behavior every detector built on the
+// container and streamer packages shares: the errors of untrained and
+// misused detectors, streaming in step with Predict, explanations, and
+// saving and loading. Each detector package runs a Suite from its tests
+// and keeps its own tests for what is specific to its algorithm.
+package detectortest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Detector is the interface the checked detectors implement.
+type Detector interface {
+	detectors.StreamDetector
+	detectors.Thresholder
+	detectors.RejectReporter
+	detectors.Describer
+	// Trained reports whether the detector can score samples.
+	Trained() bool
+	// Validate reports options set to invalid values.
+	Validate() error
+}
+
+// Suite describes a detector of type D with options of type O.
+type Suite[D Detector, O any] struct {
+	// Algorithm is the name the model card records.
+	Algorithm string
+	// New returns a detector configured with opts, such as the package's
+	// New.
+	New func(opts ...O) D
+	// WithFeatureNames and WithDataSource are the package's options.
+	WithFeatureNames func(names []string) O
+	WithDataSource   func(source string) O
+	// WithExplanations, if set, is the package's option attaching
+	// explanations to streamed anomalies.
+	WithExplanations func(top int) O
+
+	// Options configure every detector the suite builds, such as the window
+	// the training data needs.
+	Options []O
+	// Configured are options away from their defaults that saved models
+	// must keep.
+	Configured []O
+	// Invalid are options Validate rejects with ErrInvalidOption.
+	Invalid          []O
+	ErrInvalidOption error
+
+	// Train is the training data. Probe holds samples scored after
+	// training, some of them anomalous: the last one unless the detector
+	// is sequential.
+	Train, Probe [][]float64
+	// AcceptsNonFinite is set for detectors that train on NaN and
+	// infinite values.
+	AcceptsNonFinite bool
+	// LargeValues checks that training on values whose squares overflow
+	// saves a model that loads, and that training on values near the
+	// float64 limits does too, or fails.
+	LargeValues bool
+
+	// WriteEmpty writes a model whose body passes the container checks
+	// but holds nothing, such as saveFormat.Write(w, &savedModel{}).
+	WriteEmpty func(w io.Writer) error
+}
+
+// Run runs the checks as subtests of t.
+func (s Suite[D, O]) Run(t *testing.T) {
+	t.Run("Untrained", s.untrained)
+	t.Run("Fit", s.fit)
+	t.Run("Predict", s.predict)
+	t.Run("PredictStream", s.predictStream)
+	if _, ok := any(s.new()).(detectors.Explainer); ok {
+		t.Run("Explain", s.explain)
+	}
+	t.Run("SaveLoad", s.saveLoad)
+	if s.LargeValues {
+		t.Run("SaveLoadLargeValues", s.saveLoadLargeValues)
+	}
+	t.Run("LoadErrors", s.loadErrors)
+}
+
+// new returns a detector with the suite's options and opts.
+func (s Suite[D, O]) new(opts ...O) D {
+	return s.New(append(slices.Clone(s.Options), opts...)...)
+}
+
+// trained returns a detector with the suite's options and opts fit to
+// data.
+func (s Suite[D, O]) trained(t *testing.T, data [][]float64, opts ...O) D {
+	t.Helper()
+	d := s.new(opts...)
+	require.NoError(t, d.Fit(data))
+	return d
+}
+
+// dim returns the number of features of the training data.
+func (s Suite[D, O]) dim() int {
+	return len(s.Train[0])
+}
+
+// row returns a sample of n features, all v.
+func row(n int, v float64) []float64 {
+	r := make([]float64, n)
+	for j := range r {
+		r[j] = v
+	}
+	return r
+}
+
+func (s Suite[D, O]) untrained(t *testing.T) {
+	sample := s.Probe[0]
+	tests := []struct {
+		name string
+		call func(d D) error
+	}{
+		{"Predict", func(d D) error { _, err := d.Predict([][]float64{sample}); return err }},
+		{"PredictOne", func(d D) error { _, err := d.PredictOne(sample); return err }},
+		{"Save", func(d D) error { _, err := d.Save(); return err }},
+		{"SaveTo", func(d D) error { return d.SaveTo(io.Discard) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := s.new()
+			assert.ErrorIs(t, tt.call(d), detectors.ErrNotTrained)
+			assert.False(t, d.Trained())
+		})
+	}
+	if e, ok := any(s.new()).(detectors.Explainer); ok {
+		t.Run("Explain", func(t *testing.T) {
+			_, err := e.Explain(sample)
+			assert.ErrorIs(t, err, detectors.ErrNotTrained)
+		})
+	}
+}
+
+func (s Suite[D, O]) fit(t *testing.T) {
+	n := s.dim()
+	tests := []struct {
+		name string
+		d    D
+		data [][]float64
+		// nonFinite marks data only detectors without AcceptsNonFinite
+		// reject.
+		nonFinite bool
+	}{
+		{"no data", s.new(), nil, false},
+		{"empty rows", s.new(), [][]float64{{}, {}}, false},
+		{"ragged rows", s.new(), append(slices.Clone(s.Train), row(n+1, 1)), false},
+		{"feature names mismatch", s.new(s.WithFeatureNames(make([]string, n+1))), s.Train, false},
+		{"NaN value", s.new(), append(slices.Clone(s.Train), row(n, math.NaN())), true},
+		{"infinite value", s.new(), append(slices.Clone(s.Train), row(n, math.Inf(1))), true},
+	}
+	for _, tt := range tests {
+		if tt.nonFinite && s.AcceptsNonFinite {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.d.Fit(tt.data))
+			assert.False(t, tt.d.Trained())
+		})
+	}
+
+	t.Run("invalid options", func(t *testing.T) {
+		err := s.new(s.Invalid...).Validate()
+		assert.ErrorIs(t, err, s.ErrInvalidOption)
+		assert.ErrorIs(t, err, detectors.ErrInvalidOption)
+		assert.NoError(t, s.new().Validate())
+	})
+
+	t.Run("failed refit keeps the model", func(t *testing.T) {
+		d := s.trained(t, s.Train)
+		want, err := d.Predict(s.Probe)
+		require.NoError(t, err)
+		require.Error(t, d.Fit(nil))
+		got, err := d.Predict(s.Probe)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func (s Suite[D, O]) predict(t *testing.T) {
+	d := s.trained(t, s.Train)
+	scores, err := d.Predict(s.Probe)
+	require.NoError(t, err)
+	require.Len(t, scores, len(s.Probe))
+
+	t.Run("PredictContext", func(t *testing.T) {
+		got, err := d.PredictContext(context.Background(), s.Probe)
+		require.NoError(t, err)
+		assert.Equal(t, scores, got)
+	})
+
+	if !detectors.IsSequential(d) {
+		t.Run("PredictOne", func(t *testing.T) {
+			for i, sample := range s.Probe {
+				score, err := d.PredictOne(sample)
+				require.NoError(t, err)
+				assert.Equal(t, scores[i], score, "sample %d", i)
+			}
+		})
+	}
+
+	t.Run("wrong dimension", func(t *testing.T) {
+		_, err := d.Predict([][]float64{s.Probe[0], row(s.dim()+1, 1)})
+		var dim *detectors.DimensionError
+		require.ErrorAs(t, err, &dim)
+		assert.Equal(t, detectors.DimensionError{Got: s.dim() + 1, Want: s.dim()}, *dim)
+		assert.ErrorIs(t, err, detectors.ErrDimensionMismatch)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := d.PredictContext(ctx, s.Probe)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func (s Suite[D, O]) predictStream(t *testing.T) {
+	var opts []O
+	if s.WithExplanations != nil {
+		opts = append(opts, s.WithExplanations(2))
+	}
+	d := s.trained(t, s.Train, opts...)
+	var rejected []detectors.Rejection
+	d.SetRejectHandler(func(r detectors.Rejection) {
+		rejected = append(rejected, r)
+	})
+	want, err := d.Predict(s.Probe)
+	require.NoError(t, err)
+
+	// A sample of the wrong width is rejected without disturbing the
+	// others, or the window of sequential detectors.
+	wrong := row(s.dim()+1, 1)
+	input := make(chan []float64, len(s.Probe)+1)
+	output := make(chan detectors.Score, len(s.Probe)+1)
+	for i, sample := range s.Probe {
+		if i == len(s.Probe)/2 {
+			input <- wrong
+		}
+		input <- sample
+	}
+	close(input)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, d.PredictStream(ctx, input, output))
+
+	// PredictStream closes output when it returns.
+	var got []float64
+	anomalies := 0
+	for score := range output {
+		got = append(got, score.Value)
+		assert.Equal(t, score.Value >= d.Threshold(), score.IsAnomaly)
+		if !score.IsAnomaly {
+			continue
+		}
+		anomalies++
+		if s.WithExplanations != nil {
+			require.NotNil(t, score.Explanation)
+			assert.LessOrEqual(t, len(score.Explanation.Top), 2)
+		}
+	}
+	assert.InDeltaSlice(t, want, got, 1e-9, "streaming scores like Predict")
+	assert.Positive(t, anomalies)
+	require.Len(t, rejected, 1)
+	assert.Equal(t, wrong, rejected[0].Sample)
+	assert.ErrorIs(t, rejected[0].Err, detectors.ErrDimensionMismatch)
+}
+
+func (s Suite[D, O]) explain(t *testing.T) {
+	d := s.trained(t, s.Train)
+	e := any(d).(detectors.Explainer)
+
+	importances := e.FeatureImportances()
+	require.Len(t, importances, s.dim())
+	assert.InDelta(t, 1, sum(importances), 1e-9)
+
+	for i, sample := range s.Probe {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			exp, err := e.Explain(sample)
+			require.NoError(t, err)
+			score, err := d.PredictOne(sample)
+			require.NoError(t, err)
+			assert.Equal(t, score, exp.Score)
+			require.Len(t, exp.Contributions, s.dim())
+			if total := sum(exp.Contributions); total != 0 {
+				// Samples no feature makes unusual may have none.
+				assert.InDelta(t, 1, total, 1e-9)
+			}
+			for _, c := range exp.Top {
+				assert.Equal(t, exp.Contributions[c.Index], c.Contribution)
+			}
+		})
+	}
+
+	_, err := e.Explain(row(s.dim()+1, 1))
+	assert.ErrorIs(t, err, detectors.ErrDimensionMismatch)
+}
+
+// sum returns the sum of values.
+func sum(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func (s Suite[D, O]) saveLoad(t *testing.T) {
+	names := make([]string, s.dim())
+	for j := range names {
+		names[j] = fmt.Sprintf("f%d", j)
+	}
+	opts := append([]O{s.WithDataSource("train.csv"), s.WithFeatureNames(names)}, s.Configured...)
+	d := s.trained(t, s.Train, opts...)
+	saved, err := d.Save()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, d.SaveTo(&buf))
+	assert.Equal(t, saved, buf.Bytes(), "Save and SaveTo agree")
+
+	loaded := s.new()
+	require.NoError(t, loaded.LoadFrom(bytes.NewReader(saved)))
+	assert.True(t, loaded.Trained())
+	assert.Equal(t, d.Threshold(), loaded.Threshold())
+	assert.Equal(t, d.Metadata(), loaded.Metadata())
+	assert.Equal(t, s.Algorithm, loaded.Metadata().Hyperparameters["algorithm"])
+	assert.Equal(t, "train.csv", loaded.Metadata().DataSource)
+	assert.Equal(t, names, loaded.Metadata().FeatureNames)
+
+	want, err := d.Predict(s.Probe)
+	require.NoError(t, err)
+	got, err := loaded.Predict(s.Probe)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	if e, ok := any(d).(detectors.Explainer); ok {
+		anomaly := s.Probe[len(s.Probe)-1]
+		wantExp, err := e.Explain(anomaly)
+		require.NoError(t, err)
+		gotExp, err := any(loaded).(detectors.Explainer).Explain(anomaly)
+		require.NoError(t, err)
+		assert.Equal(t, wantExp, gotExp)
+	}
+
+	again, err := loaded.Save()
+	require.NoError(t, err)
+	assert.Equal(t, saved, again, "saving is reproducible")
+}
+
+func (s Suite[D, O]) saveLoadLargeValues(t *testing.T) {
+	// Squaring values above 1e154 overflows, and so do differences of
+	// values near the float64 limits; neither may leave a model that Load
+	// rejects.
+	spike := clone(s.Train)
+	spike[len(spike)/2][0] = 1e160
+	extremes := clone(s.Train)
+	last := s.dim() - 1
+	extremes[0][last], extremes[1][last] = math.MaxFloat64, -math.MaxFloat64
+
+	tests := []struct {
+		name    string
+		data    [][]float64
+		mayFail bool
+	}{
+		{"spike", spike, false},
+		{"extremes", extremes, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := s.new()
+			err := d.Fit(tt.data)
+			if err != nil && tt.mayFail {
+				assert.False(t, d.Trained())
+				return
+			}
+			require.NoError(t, err)
+			saved, err := d.Save()
+			require.NoError(t, err)
+			loaded := s.new()
+			require.NoError(t, loaded.Load(saved))
+
+			want, err := d.Predict(tt.data[:10])
+			require.NoError(t, err)
+			got, err := loaded.Predict(tt.data[:10])
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+// clone returns a deep copy of data.
+func clone(data [][]float64) [][]float64 {
+	out := make([][]float64, len(data))
+	for i, r := range data {
+		out[i] = slices.Clone(r)
+	}
+	return out
+}
+
+func (s Suite[D, O]) loadErrors(t *testing.T) {
+	saved, err := s.trained(t, s.Train).Save()
+	require.NoError(t, err)
+	var empty bytes.Buffer
+	require.NoError(t, s.WriteEmpty(&empty))
+
+	truncated := saved[:len(saved)/2]
+	corrupt := bytes.Clone(saved)
+	corrupt[len(corrupt)/2] ^= 0xff
+	newer := bytes.Clone(saved)
+	newer[8]++
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, nil},
+		{"truncated", truncated, nil},
+		{"corrupt", corrupt, detectors.ErrChecksum},
+		{"newer version", newer, detectors.ErrModelVersion},
+		{"empty body", empty.Bytes(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := s.new()
+			err := d.Load(tt.data)
+			require.Error(t, err)
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			}
+			assert.False(t, d.Trained())
+		})
+	}
+
+	t.Run("failed load keeps the model", func(t *testing.T) {
+		d := s.trained(t, s.Train)
+		want, err := d.Predict(s.Probe)
+		require.NoError(t, err)
+		require.Error(t, d.Load(corrupt))
+		got, err := d.Predict(s.Probe)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
@@ -0,0 +1,251 @@
+// Package kdtree indexes points for nearest neighbor and radius queries,
+// for the detectors that compare samples to their training points.
+package kdtree
+
+import "math"
+
+// Tree indexes points for nearest neighbor and radius queries. Nodes
+// split their points at the median of the dimension of widest spread;
+// leaves hold up to leafSize points. Points are stored flat, reordered so
+// every node's points are contiguous.
+type Tree struct {
+	dim    int
+	points []float64
+	// rows maps points, in tree order, to the rows of data they were built
+	// from, and pos rows to points.
+	rows  []int
+	pos   []int
+	nodes []kdNode
+}
+
+// kdNode covers points [lo, hi). Inner nodes split them at value along
+// dimension split: the points of left are at most value, those of right
+// at least. Leaves have a negative split.
+type kdNode struct {
+	lo, hi      int
+	split       int
+	value       float64
+	left, right int32
+}
+
+// Build indexes data, whose rows all have dim values.
+func Build(data [][]float64, dim, leafSize int) *Tree {
+	order := make([]int, len(data))
+	for i := range order {
+		order[i] = i
+	}
+	t := &Tree{dim: dim}
+	if len(data) > 0 {
+		t.build(data, order, 0, len(order), max(leafSize, 1))
+	}
+	t.points = make([]float64, 0, len(data)*dim)
+	for _, i := range order {
+		t.points = append(t.points, data[i]...)
+	}
+	t.rows = order
+	t.pos = make([]int, len(order))
+	for p, i := range order {
+		t.pos[i] = p
+	}
+	return t
+}
+
+// Len returns the number of points indexed.
+func (t *Tree) Len() int {
+	return len(t.rows)
+}
+
+// Point returns the point of row.
+func (t *Tree) Point(row int) []float64 {
+	p := t.pos[row]
+	return t.points[p*t.dim : (p+1)*t.dim]
+}
+
+// build adds the node of order[lo:hi] and its children, returning its
+// index.
+func (t *Tree) build(data [][]float64, order []int, lo, hi, leafSize int) int32 {
+	id := int32(len(t.nodes))
+	t.nodes = append(t.nodes, kdNode{lo: lo, hi: hi, split: -1, left: -1, right: -1})
+	if hi-lo <= leafSize {
+		return id
+	}
+
+	split, spread := 0, -1.0
+	for d := 0; d < t.dim; d++ {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, i := range order[lo:hi] {
+			low, high = min(low, data[i][d]), max(high, data[i][d])
+		}
+		if high-low > spread {
+			split, spread = d, high-low
+		}
+	}
+	if spread == 0 {
+		// All points coincide.
+		return id
+	}
+
+	mid := lo + (hi-lo)/2
+	selectNth(data, order[lo:hi], mid-lo, split)
+	value := data[order[mid]][split]
+	left := t.build(data, order, lo, mid, leafSize)
+	right := t.build(data, order, mid, hi, leafSize)
+	t.nodes[id] = kdNode{lo: lo, hi: hi, split: split, value: value, left: left, right: right}
+	return id
+}
+
+// selectNth reorders order so that order[n] holds the row with the n-th
+// smallest value of dimension d, with no greater value before it and no
+// smaller one after it.
+func selectNth(data [][]float64, order []int, n, d int) {
+	lo, hi := 0, len(order)-1
+	for lo < hi {
+		pivot := data[order[lo+(hi-lo)/2]][d]
+		i, j := lo, hi
+		for i <= j {
+			for data[order[i]][d] < pivot {
+				i++
+			}
+			for data[order[j]][d] > pivot {
+				j--
+			}
+			if i <= j {
+				order[i], order[j] = order[j], order[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// Neighbor is a point found by a query: the row of data it was built
+// from, at squared distance Dist.
+type Neighbor struct {
+	Dist float64
+	Row  int
+}
+
+// Neighbors holds the points found by a query. Search keeps them as a
+// max-heap, farthest first; Within in tree order.
+type Neighbors []Neighbor
+
+// offer adds n if fewer than k points are held or it is nearer than the
+// farthest.
+func (h *Neighbors) offer(n Neighbor, k int) {
+	s := *h
+	if len(s) < k {
+		s = append(s, n)
+		for i := len(s) - 1; i > 0; {
+			parent := (i - 1) / 2
+			if s[parent].Dist >= s[i].Dist {
+				break
+			}
+			s[parent], s[i] = s[i], s[parent]
+			i = parent
+		}
+		*h = s
+		return
+	}
+	if n.Dist >= s[0].Dist {
+		return
+	}
+	s[0] = n
+	for i := 0; ; {
+		largest, l, r := i, 2*i+1, 2*i+2
+		if l < len(s) && s[l].Dist > s[largest].Dist {
+			largest = l
+		}
+		if r < len(s) && s[r].Dist > s[largest].Dist {
+			largest = r
+		}
+		if largest == i {
+			break
+		}
+		s[i], s[largest] = s[largest], s[i]
+		i = largest
+	}
+}
+
+// Search collects into h the k points nearest q, leaving out the point of
+// row exclude, or none if it is negative.
+func (t *Tree) Search(q []float64, k, exclude int, h *Neighbors) {
+	*h = (*h)[:0]
+	if len(t.nodes) > 0 {
+		t.visit(0, q, k, exclude, h)
+	}
+}
+
+func (t *Tree) visit(id int32, q []float64, k, exclude int, h *Neighbors) {
+	node := &t.nodes[id]
+	if node.split < 0 {
+		for p := node.lo; p < node.hi; p++ {
+			if t.rows[p] == exclude {
+				continue
+			}
+			h.offer(Neighbor{Dist: t.dist(q, p), Row: t.rows[p]}, k)
+		}
+		return
+	}
+	diff := q[node.split] - node.value
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+	t.visit(near, q, k, exclude, h)
+	// The far side is at least |diff| away along the split dimension.
+	if len(*h) < k || diff*diff < (*h)[0].Dist {
+		t.visit(far, q, k, exclude, h)
+	}
+}
+
+// Within collects into h the points within squared distance r2 of q,
+// leaving out the point of row exclude, or none if it is negative.
+func (t *Tree) Within(q []float64, r2 float64, exclude int, h *Neighbors) {
+	*h = (*h)[:0]
+	if len(t.nodes) > 0 {
+		t.within(0, q, r2, exclude, h)
+	}
+}
+
+func (t *Tree) within(id int32, q []float64, r2 float64, exclude int, h *Neighbors) {
+	node := &t.nodes[id]
+	if node.split < 0 {
+		for p := node.lo; p < node.hi; p++ {
+			if t.rows[p] == exclude {
+				continue
+			}
+			if d := t.dist(q, p); d <= r2 {
+				*h = append(*h, Neighbor{Dist: d, Row: t.rows[p]})
+			}
+		}
+		return
+	}
+	diff := q[node.split] - node.value
+	// Points of left are at most value along the split dimension, those of
+	// right at least.
+	if diff <= 0 || diff*diff <= r2 {
+		t.within(node.left, q, r2, exclude, h)
+	}
+	if diff >= 0 || diff*diff <= r2 {
+		t.within(node.right, q, r2, exclude, h)
+	}
+}
+
+// dist returns the squared distance of q to the point at position p.
+func (t *Tree) dist(q []float64, p int) float64 {
+	point := t.points[p*t.dim : (p+1)*t.dim]
+	var sum float64
+	for d, v := range q {
+		diff := v - point[d]
+		sum += diff * diff
+	}
+	return sum
+}
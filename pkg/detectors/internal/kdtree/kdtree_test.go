@@ -0,0 +1,91 @@
+package kdtree
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := make([][]float64, 3000)
+	for i := range points {
+		points[i] = []float64{rng.NormFloat64(), rng.NormFloat64(), float64(rng.Intn(4))}
+	}
+	// Duplicates and coinciding points must not confuse the partitioning.
+	for i := 0; i < 200; i++ {
+		points[i] = []float64{0, 0, 1}
+	}
+	tree := Build(points, 3, 8)
+
+	var h Neighbors
+	for q := 0; q < 100; q++ {
+		query := []float64{rng.NormFloat64() * 2, rng.NormFloat64() * 2, float64(rng.Intn(5))}
+		exclude := -1
+		if q%2 == 0 {
+			exclude = rng.Intn(len(points))
+			query = points[exclude]
+		}
+		tree.Search(query, 7, exclude, &h)
+
+		var want []float64
+		for i, p := range points {
+			if i == exclude {
+				continue
+			}
+			var d float64
+			for j := range p {
+				d += (p[j] - query[j]) * (p[j] - query[j])
+			}
+			want = append(want, d)
+		}
+		slices.Sort(want)
+
+		got := make([]float64, len(h))
+		for i, n := range h {
+			got[i] = n.Dist
+			assert.Equal(t, points[n.Row], tree.Point(n.Row))
+			assert.NotEqual(t, exclude, n.Row)
+		}
+		slices.Sort(got)
+		require.Equal(t, want[:7], got, "query %d", q)
+	}
+}
+
+func TestWithinMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	points := make([][]float64, 2000)
+	for i := range points {
+		points[i] = []float64{rng.NormFloat64(), rng.NormFloat64()}
+	}
+	tree := Build(points, 2, 8)
+
+	var h Neighbors
+	for q := 0; q < 100; q++ {
+		query := []float64{rng.NormFloat64() * 2, rng.NormFloat64() * 2}
+		exclude := -1
+		if q%2 == 0 {
+			exclude = rng.Intn(len(points))
+			query = points[exclude]
+		}
+		r2 := rng.Float64() * 0.5
+		tree.Within(query, r2, exclude, &h)
+
+		want := []int{}
+		for i, p := range points {
+			dx, dy := p[0]-query[0], p[1]-query[1]
+			if i != exclude && dx*dx+dy*dy <= r2 {
+				want = append(want, i)
+			}
+		}
+		got := make([]int, len(h))
+		for i, n := range h {
+			got[i] = n.Row
+		}
+		slices.Sort(got)
+		require.Equal(t, want, got, "query %d", q)
+	}
+}
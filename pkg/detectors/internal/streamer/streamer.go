@@ -0,0 +1,42 @@
+// Package streamer runs the scoring loop of the detectors' PredictStream.
+package streamer
+
+import (
+	"context"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Run scores the samples of input in order with score and sends the
+// results to output, until input is closed or ctx is done, when it
+// returns ctx.Err(). Samples score fails on are passed to reject, if not
+// nil, and skipped. Run does not close output: PredictStream does, once
+// Run returns.
+//
+// score should take the detector's read lock once per sample, so the
+// score, anomaly flag and explanation come from the same model, and set
+// each Score's Features to the sample itself rather than a copy.
+func Run(ctx context.Context, input <-chan []float64, output chan<- detectors.Score, reject detectors.RejectFunc, score func([]float64) (detectors.Score, error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sample, ok := <-input:
+			if !ok {
+				return nil
+			}
+			result, err := score(sample)
+			if err != nil {
+				if reject != nil {
+					reject(detectors.Rejection{Sample: sample, Err: err})
+				}
+				continue
+			}
+			select {
+			case output <- result:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
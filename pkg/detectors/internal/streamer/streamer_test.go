@@ -0,0 +1,62 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+var errNegative = errors.New("negative sample")
+
+func score(sample []float64) (detectors.Score, error) {
+	if sample[0] < 0 {
+		return detectors.Score{}, errNegative
+	}
+	return detectors.Score{Value: sample[0]}, nil
+}
+
+func TestRun(t *testing.T) {
+	input := make(chan []float64, 3)
+	output := make(chan detectors.Score, 3)
+	input <- []float64{1}
+	input <- []float64{-1}
+	input <- []float64{2}
+	close(input)
+
+	var rejected []detectors.Rejection
+	reject := func(r detectors.Rejection) { rejected = append(rejected, r) }
+	require.NoError(t, Run(context.Background(), input, output, reject, score))
+	close(output)
+
+	var values []float64
+	for s := range output {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []float64{1, 2}, values)
+	require.Len(t, rejected, 1)
+	assert.Equal(t, []float64{-1}, rejected[0].Sample)
+	assert.ErrorIs(t, rejected[0].Err, errNegative)
+
+	// Without a reject func failing samples are skipped.
+	input = make(chan []float64, 1)
+	input <- []float64{-1}
+	close(input)
+	require.NoError(t, Run(context.Background(), input, output, nil, score))
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	input := make(chan []float64, 1)
+	input <- []float64{1}
+
+	// The output is never read, so Run blocks sending until canceled.
+	done := make(chan error)
+	go func() { done <- Run(ctx, input, make(chan detectors.Score), nil, score) }()
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
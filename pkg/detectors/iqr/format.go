@@ -0,0 +1,107 @@
+package iqr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved IQR models.
+var saveFormat = container.Format{Name: "iqr", Model: "IQR", Magic: "GGIQSAVE", Version: 1}
+
+// savedModel holds the options and the quartiles of the features of a saved
+// IQR model.
+type savedModel struct {
+	FenceFactor   float64
+	Contamination float64
+	Threshold     float64
+	Q1            []float64
+	Q3            []float64
+	Importances   []float64
+	Card          container.Card
+}
+
+// Save serializes the quartiles of the features.
+func (q *IQR) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := q.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (q *IQR) SaveTo(w io.Writer) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		FenceFactor:   q.factor,
+		Contamination: q.contamination,
+		Threshold:     q.threshold,
+		Q1:            q.q1,
+		Q3:            q.q3,
+		Importances:   q.importances,
+		Card:          container.NewCard(q.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the quartiles of the features written by Save.
+func (q *IQR) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.factor = m.FenceFactor
+	q.contamination, q.threshold = m.Contamination, m.Threshold
+	q.q1, q.q3 = m.Q1, m.Q3
+	q.importances = m.Importances
+	q.card = m.Card.ModelCard()
+	q.featureNames = q.card.FeatureNames
+	q.trained = true
+	return nil
+}
+
+// LoadFrom restores the quartiles of the features read from r.
+func (q *IQR) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return q.Load(data)
+}
+
+// validate checks the saved quartiles of the features.
+func (m *savedModel) validate() error {
+	dim := len(m.Q1)
+	switch {
+	case dim == 0:
+		return errors.New("iqr: model has no features")
+	case len(m.Q3) != dim:
+		return fmt.Errorf("iqr: %d third quartiles for %d features", len(m.Q3), dim)
+	case !(m.FenceFactor > 0) || math.IsInf(m.FenceFactor, 0):
+		return fmt.Errorf("iqr: invalid fence factor %g", m.FenceFactor)
+	}
+	for j := range m.Q1 {
+		if q1, q3 := m.Q1[j], m.Q3[j]; math.IsInf(q1, 0) || math.IsInf(q3, 0) || !(q1 <= q3) {
+			return fmt.Errorf("iqr: feature %d: invalid quartiles", j)
+		}
+	}
+	return nil
+}
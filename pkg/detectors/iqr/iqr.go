@@ -0,0 +1,571 @@
+// Package iqr implements an interquartile-range fence detector.
+//
+// Each feature gets Tukey's fences from its training quartiles: from
+// Q1 - k·IQR to Q3 + k·IQR, with the fence factor k 1.5 by default. A
+// sample scores by its feature farthest beyond the quartiles, measured in
+// interquartile ranges, so that a score of at least 0.5, the default
+// threshold, means exactly that some feature lies on or outside its fence.
+// Every flag comes
+// with the rule that raised it: Violations lists the features outside
+// their fences, their values and the fences they crossed.
+//
+// Features with a zero interquartile range, where most training values
+// are equal, have both fences at that value: any other value is outside.
+package iqr
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// fence factor or contamination.
+var ErrInvalidOption = fmt.Errorf("iqr: %w", detectors.ErrInvalidOption)
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// IQR is an interquartile-range fence detector. It is safe for concurrent
+// use.
+type IQR struct {
+	mu sync.RWMutex
+
+	// Configuration
+	factor        float64
+	contamination float64
+	threshold     float64
+	explainTop    int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	q1, q3      []float64
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures an IQR.
+type Option func(*IQR)
+
+// WithFenceFactor sets how many interquartile ranges beyond the quartiles
+// the fences lie, 1.5 by default. Tukey called values beyond 3 far out.
+func WithFenceFactor(k float64) Option {
+	return func(q *IQR) {
+		q.factor = k
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0 by
+// default. Above 0, Fit sets the threshold to flag that fraction of the
+// training data instead of keeping it at the fences.
+func WithContamination(c float64) Option {
+	return func(q *IQR) {
+		q.contamination = c
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(q *IQR) {
+		q.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(q *IQR) {
+		q.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new IQR.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(q *IQR) {
+		q.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(q *IQR) {
+		q.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card and in
+// violations. Fit fails if the training data has a different number of
+// features.
+func WithFeatureNames(names []string) Option {
+	return func(q *IQR) {
+		q.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained IQR with the given options.
+func New(opts ...Option) *IQR {
+	q := &IQR{
+		factor:    1.5,
+		threshold: 0.5,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.explainTop = max(q.explainTop, 0)
+	return q
+}
+
+// Validate checks the fence factor and contamination set by the options.
+func (q *IQR) Validate() error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.validate()
+}
+
+func (q *IQR) validate() error {
+	var errs []error
+	if !(q.factor > 0) || math.IsInf(q.factor, 0) {
+		errs = append(errs, fmt.Errorf("%w: WithFenceFactor(%g): must be positive", ErrInvalidOption, q.factor))
+	}
+	if !(q.contamination >= 0 && q.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, q.contamination))
+	}
+	if q.severity != nil {
+		if err := q.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit computes the quartiles of each feature of data. Features must be
+// finite.
+func (q *IQR) Fit(data [][]float64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if q.featureNames != nil && len(q.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(q.featureNames), nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	q.q1, q.q3 = make([]float64, nFeatures), make([]float64, nFeatures)
+	column := make([]float64, len(data))
+	for j := range q.q1 {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		slices.Sort(column)
+		q.q1[j], q.q3[j] = quantile(column, 0.25), quantile(column, 0.75)
+	}
+	q.trained = true
+
+	// Score the training data once, for the threshold and the feature
+	// importances.
+	scores := make([]float64, len(data))
+	importances := make([]float64, nFeatures)
+	dist := make([]float64, nFeatures)
+	for i, row := range data {
+		scores[i] = q.score(q.distances(row, dist))
+		for j, d := range normalize(dist) {
+			importances[j] += d
+		}
+	}
+	q.importances = normalize(importances)
+
+	if q.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(scores), 100)
+		est.AddAll(scores)
+		q.threshold = est.Quantile(1 - q.contamination)
+	}
+	q.card = q.modelCard(data)
+	return nil
+}
+
+// quantile returns the p-quantile of sorted, interpolating linearly
+// between the values around it.
+func quantile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[i]
+	}
+	frac := pos - float64(i)
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+
+// distances writes the distance of each feature of sample beyond its
+// quartiles, in interquartile ranges, to dst and returns it. Values
+// between the quartiles are at 0; NaN, and any value outside the
+// quartiles of a feature whose interquartile range is zero, are infinitely
+// far. The caller holds the read lock.
+func (q *IQR) distances(sample, dst []float64) []float64 {
+	for j, v := range sample {
+		beyond := max(q.q1[j]-v, v-q.q3[j], 0)
+		iqr := q.q3[j] - q.q1[j]
+		switch {
+		case math.IsNaN(v):
+			dst[j] = math.Inf(1)
+		case beyond == 0:
+			dst[j] = 0
+		case iqr > 0:
+			dst[j] = beyond / iqr
+		default:
+			dst[j] = math.Inf(1)
+		}
+	}
+	return dst
+}
+
+// score maps the largest distance m in dist to m/(m+k): 0 between the
+// quartiles, 0.5 on a fence, toward 1 far beyond.
+func (q *IQR) score(dist []float64) float64 {
+	m := slices.Max(dist)
+	if math.IsInf(m, 1) {
+		return 1
+	}
+	return m / (m + q.factor)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (q *IQR) Predict(data [][]float64) ([]float64, error) {
+	return q.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, scoring the batch in order and checking ctx
+// every 4096 samples.
+func (q *IQR) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(q.q1) {
+			return nil, fmt.Errorf("sample %d: %w", i, q.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	dist := make([]float64, len(q.q1))
+	for i, sample := range data {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		scores[i] = q.score(q.distances(sample, dist))
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (q *IQR) PredictOne(sample []float64) (float64, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(q.q1) {
+		return 0, q.dimensionError(sample)
+	}
+	return q.score(q.distances(sample, make([]float64, len(sample)))), nil
+}
+
+// Violation is a feature of a sample on or outside its fence.
+type Violation struct {
+	// Feature is the index of the feature, and Name its name if feature
+	// names were given.
+	Feature int     `json:"feature"`
+	Name    string  `json:"name,omitempty"`
+	Value   float64 `json:"value"`
+	// Fence is the range the value was expected in.
+	Fence detectors.Range `json:"fence"`
+	// IQRs is the distance of the value beyond the quartiles, in
+	// interquartile ranges: at least the fence factor. It is infinite for
+	// NaN values and for features whose interquartile range is zero.
+	IQRs float64 `json:"iqrs"`
+}
+
+// String describes v, such as "bytes = 9000 outside [10, 450] (20.1×IQR)".
+func (v Violation) String() string {
+	name := v.Name
+	if name == "" {
+		name = "feature " + strconv.Itoa(v.Feature)
+	}
+	return fmt.Sprintf("%s = %g outside [%g, %g] (%.3g×IQR)", name, v.Value, v.Fence.Low, v.Fence.High, v.IQRs)
+}
+
+// Violations returns the features of sample on or outside their fences,
+// farthest first: those that make it score at least 0.5. It returns none
+// for samples within every fence.
+func (q *IQR) Violations(sample []float64) ([]Violation, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(sample) != len(q.q1) {
+		return nil, q.dimensionError(sample)
+	}
+	dist := q.distances(sample, make([]float64, len(sample)))
+	fences := q.fences()
+	var out []Violation
+	for j, d := range dist {
+		if !(d >= q.factor) {
+			continue
+		}
+		v := Violation{Feature: j, Value: sample[j], Fence: fences[j], IQRs: d}
+		if q.featureNames != nil {
+			v.Name = q.featureNames[j]
+		}
+		out = append(out, v)
+	}
+	slices.SortStableFunc(out, func(a, b Violation) int {
+		return cmp.Compare(b.IQRs, a.IQRs)
+	})
+	return out, nil
+}
+
+// Fences returns the fence of each feature, or nil if the detector is not
+// trained.
+func (q *IQR) Fences() []detectors.Range {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return nil
+	}
+	return q.fences()
+}
+
+// fences returns the fence of each feature. The caller holds the read
+// lock.
+func (q *IQR) fences() []detectors.Range {
+	out := make([]detectors.Range, len(q.q1))
+	for j := range out {
+		iqr := q.q3[j] - q.q1[j]
+		out[j] = detectors.Range{Low: q.q1[j] - q.factor*iqr, High: q.q3[j] + q.factor*iqr}
+	}
+	return out
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (q *IQR) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(q.q1)}
+}
+
+// PredictStream scores samples from input against the quartiles of the
+// features until input is closed.
+func (q *IQR) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	q.mu.RLock()
+	if !q.trained {
+		q.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := q.onReject
+	q.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, q.streamScore)
+}
+
+// streamScore scores a streamed sample against the quartiles of the
+// features.
+func (q *IQR) streamScore(sample []float64) (detectors.Score, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if len(sample) != len(q.q1) {
+		return detectors.Score{}, q.dimensionError(sample)
+	}
+	dist := q.distances(sample, make([]float64, len(sample)))
+	score := q.score(dist)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= q.threshold,
+		Features:  sample,
+	}
+	if q.explainTop > 0 {
+		exp := q.explain(sample, score, dist)
+		result.Explanation = &exp
+	}
+	if q.severity != nil {
+		result.Severity = q.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*IQR)(nil)
+	_ detectors.Thresholder    = (*IQR)(nil)
+	_ detectors.RejectReporter = (*IQR)(nil)
+	_ detectors.Explainer      = (*IQR)(nil)
+	_ detectors.Describer      = (*IQR)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the quartiles of the features to.
+func (q *IQR) SetRejectHandler(fn detectors.RejectFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onReject = fn
+}
+
+// FeatureImportances returns each feature's mean share of the distances of
+// training rows beyond the quartiles. It returns nil if the detector is
+// not trained.
+func (q *IQR) FeatureImportances() []float64 {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return slices.Clone(q.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// its distances beyond the quartiles. The typical range of each feature is
+// its fence.
+func (q *IQR) Explain(sample []float64) (detectors.Explanation, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if !q.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(q.q1) {
+		return detectors.Explanation{}, q.dimensionError(sample)
+	}
+	dist := q.distances(sample, make([]float64, len(sample)))
+	return q.explain(sample, q.score(dist), dist), nil
+}
+
+// explain explains a sample of the right width from its score and
+// distances. The caller holds the read lock.
+func (q *IQR) explain(sample []float64, score float64, dist []float64) detectors.Explanation {
+	contributions := normalize(slices.Clone(dist))
+	topK := q.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, q.fences(), topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (q *IQR) Metadata() detectors.ModelCard {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	card := q.card
+	card.FeatureNames = slices.Clone(q.card.FeatureNames)
+	if q.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(q.card.Hyperparameters))
+		for k, v := range q.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (q *IQR) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   q.dataSource,
+		Rows:         len(data),
+		Features:     len(q.q1),
+		FeatureNames: slices.Clone(q.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "iqr",
+			"fence_factor":  strconv.FormatFloat(q.factor, 'g', -1, 64),
+			"contamination": strconv.FormatFloat(q.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(q.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (q *IQR) Trained() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (q *IQR) Threshold() float64 {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (q *IQR) SetThreshold(t float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.threshold = t
+}
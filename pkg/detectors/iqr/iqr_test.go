@@ -0,0 +1,133 @@
+package iqr
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// uniformData returns n samples of three features, uniform on [0, 4),
+// [100, 200) and [-1000, 1000).
+func uniformData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{4 * rng.Float64(), 100 + 100*rng.Float64(), 2000*rng.Float64() - 1000}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*IQR, Option]{
+		Algorithm:        "iqr",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithFenceFactor(3), WithContamination(0.05)},
+		Invalid:          []Option{WithFenceFactor(0), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            uniformData(1000, 6),
+		Probe:            append(uniformData(20, 7), []float64{6.5, 150, 0}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	q := New()
+	require.NoError(t, q.Fit(uniformData(2000, 1)))
+	assert.Equal(t, 0.5, q.Threshold(), "the threshold stays at the fences")
+
+	// Quartiles near 1 and 3: fences near -2 and 6.
+	scores, err := q.Predict([][]float64{
+		{2, 150, 0},
+		{3.5, 190, -900},
+		{6.5, 150, 0},
+		{2, 150, 4500},
+		{math.NaN(), 150, 0},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, scores[0], "between the quartiles")
+	assert.Greater(t, scores[1], 0.0)
+	assert.Less(t, scores[1], q.Threshold(), "beyond the quartiles, within the fences")
+	for i, s := range scores[2:] {
+		assert.Greater(t, s, q.Threshold(), "sample %d", i+2)
+	}
+	assert.Equal(t, 1.0, scores[4], "NaN features are infinitely far")
+
+	one, err := q.PredictOne([]float64{6.5, 150, 0})
+	require.NoError(t, err)
+	assert.Equal(t, scores[2], one)
+
+	// Contamination calibrates the threshold on training instead.
+	c := New(WithContamination(0.1))
+	require.NoError(t, c.Fit(uniformData(2000, 1)))
+	assert.Greater(t, c.Threshold(), 0.0)
+	assert.Less(t, c.Threshold(), 0.5)
+}
+
+func TestFences(t *testing.T) {
+	q := New(WithFeatureNames([]string{"a", "b"}), WithFenceFactor(1))
+	require.NoError(t, q.Fit([][]float64{{1, 7}, {2, 7}, {3, 7}, {4, 7}, {5, 8}}))
+	assert.Equal(t, []detectors.Range{{Low: 0, High: 6}, {Low: 7, High: 7}}, q.Fences())
+
+	// On the fence scores exactly the threshold.
+	score, err := q.PredictOne([]float64{6, 7})
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, score)
+
+	violations, err := q.Violations([]float64{6, 7})
+	require.NoError(t, err)
+	require.Len(t, violations, 1)
+	assert.Equal(t, "a = 6 outside [0, 6] (1×IQR)", violations[0].String())
+
+	violations, err = q.Violations([]float64{12, 8})
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+	assert.Equal(t, "b", violations[0].Name, "a zero IQR makes any departure infinite")
+	assert.True(t, math.IsInf(violations[0].IQRs, 1))
+	assert.Equal(t, Violation{Feature: 0, Name: "a", Value: 12, Fence: detectors.Range{Low: 0, High: 6}, IQRs: 4}, violations[1])
+
+	violations, err = q.Violations([]float64{3, 7})
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestErrors(t *testing.T) {
+	q := New()
+	_, err := q.Violations([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	assert.Nil(t, q.Fences())
+}
+
+func TestExplain(t *testing.T) {
+	q := New()
+	require.NoError(t, q.Fit(uniformData(1000, 4)))
+
+	sample := []float64{2, 400, 0}
+	exp, err := q.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Equal(t, q.Fences()[1], *exp.Top[0].Typical, "the typical range is the fence")
+	assert.Equal(t, []float64{0, 1, 0}, exp.Contributions)
+}
+
+func BenchmarkPredict(b *testing.B) {
+	q := New()
+	q.Fit(uniformData(100000, 1))
+	samples := uniformData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Predict(samples)
+	}
+}
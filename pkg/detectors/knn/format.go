@@ -0,0 +1,139 @@
+package knn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/kdtree"
+)
+
+// saveFormat frames saved k-NN models.
+var saveFormat = container.Format{Name: "knn", Model: "k-NN", Magic: "GGKNSAVE", Version: 1}
+
+// savedModel holds the options and the standardized training points of a
+// saved k-NN model.
+type savedModel struct {
+	K             int
+	Method        uint8
+	LeafSize      int
+	Contamination float64
+	Threshold     float64
+	Scale         float64
+	Mean          []float64
+	Std           []float64
+	// Points are the standardized training points in training order, one
+	// after the other. The index is rebuilt on Load.
+	Points      []float64
+	Importances []float64
+	Card        container.Card
+}
+
+// Save serializes the standardized training points.
+func (d *KNN) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (d *KNN) SaveTo(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		K:             d.k,
+		Method:        uint8(d.method),
+		LeafSize:      d.leafSize,
+		Contamination: d.contamination,
+		Threshold:     d.threshold,
+		Scale:         d.scale,
+		Mean:          d.mean,
+		Std:           d.std,
+		Points:        make([]float64, 0, d.tree.Len()*len(d.mean)),
+		Importances:   d.importances,
+		Card:          container.NewCard(d.card),
+	}
+	for row := range d.tree.Len() {
+		m.Points = append(m.Points, d.tree.Point(row)...)
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the standardized training points written by Save,
+// rebuilding the neighbor index.
+func (d *KNN) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	dim := len(m.Mean)
+	points := make([][]float64, len(m.Points)/dim)
+	for i := range points {
+		points[i] = m.Points[i*dim : (i+1)*dim]
+	}
+	tree := kdtree.Build(points, dim, m.LeafSize)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.k, d.method, d.leafSize = m.K, Method(m.Method), m.LeafSize
+	d.contamination, d.threshold = m.Contamination, m.Threshold
+	d.scale = m.Scale
+	d.mean, d.std = m.Mean, m.Std
+	d.tree = tree
+	d.importances = m.Importances
+	d.card = m.Card.ModelCard()
+	d.featureNames = d.card.FeatureNames
+	d.trained = true
+	return nil
+}
+
+// LoadFrom restores the standardized training points read from r.
+func (d *KNN) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Load(data)
+}
+
+// validate checks the saved standardized training points.
+func (m *savedModel) validate() error {
+	dim := len(m.Mean)
+	switch {
+	case dim == 0:
+		return errors.New("knn: model has no features")
+	case len(m.Std) != dim:
+		return fmt.Errorf("knn: %d deviations for %d features", len(m.Std), dim)
+	case len(m.Points)%dim != 0 || len(m.Points)/dim <= m.K:
+		return fmt.Errorf("knn: %d values do not hold more than %d points of %d features", len(m.Points), m.K, dim)
+	case m.K < 1 || m.LeafSize < 1 || Method(m.Method) > MaxDistance:
+		return errors.New("knn: invalid hyperparameters")
+	case !(m.Scale > 0) || math.IsInf(m.Scale, 0):
+		return fmt.Errorf("knn: invalid score scale %g", m.Scale)
+	}
+	for j, s := range m.Std {
+		if !(s > 0) || math.IsInf(s, 0) {
+			return fmt.Errorf("knn: feature %d: invalid deviation %g", j, s)
+		}
+	}
+	for _, v := range m.Points {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("knn: model has non-finite points")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,674 @@
+// Package knn implements a k-nearest-neighbor distance anomaly detector.
+//
+// A sample scores by its distance to the k nearest training points: the
+// mean distance by default, or that of the k-th nearest with MaxDistance.
+// Samples far from everything seen in training score high. Features are
+// standardized by their training mean and standard deviation first, so
+// each weighs the same whatever its unit. The detector is a simple,
+// explainable baseline: every score comes with the training points that
+// justify it (Neighbors), and each feature's share of the distance.
+//
+// Training points are kept and indexed with a KD-tree, so a query visits
+// a few leaves of the tree instead of every point. The tree degrades
+// toward a linear scan as the number of features grows past about 20.
+package knn
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/kdtree"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid k,
+// leaf size, scoring method or contamination.
+var ErrInvalidOption = fmt.Errorf("knn: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of samples scored between context checks.
+const scoreChunk = 1024
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// Method combines the distances to the k nearest training points into a
+// raw score.
+type Method uint8
+
+// Methods.
+const (
+	// MeanDistance averages the distances, which is less sensitive to
+	// the choice of k.
+	MeanDistance Method = iota
+	// MaxDistance takes the distance to the k-th nearest point.
+	MaxDistance
+)
+
+// String returns the name of m.
+func (m Method) String() string {
+	switch m {
+	case MeanDistance:
+		return "mean"
+	case MaxDistance:
+		return "max"
+	default:
+		return fmt.Sprintf("Method(%d)", m)
+	}
+}
+
+// KNN is a k-nearest-neighbor distance detector. It is safe for
+// concurrent use.
+type KNN struct {
+	mu sync.RWMutex
+
+	// Configuration
+	k             int
+	method        Method
+	leafSize      int
+	contamination float64
+	threshold     float64
+	workers       int
+	explainTop    int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	mean []float64
+	std  []float64
+	tree *kdtree.Tree
+	// scale is the mean raw score of the training data, which scores 0.5.
+	scale       float64
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures a KNN.
+type Option func(*KNN)
+
+// WithK sets the number of nearest training points a sample is compared
+// with, 10 by default. Larger values smooth scores over the training data
+// and cost more per query.
+func WithK(k int) Option {
+	return func(d *KNN) {
+		d.k = k
+	}
+}
+
+// WithMethod sets how the distances to the nearest points are combined,
+// MeanDistance by default.
+func WithMethod(m Method) Option {
+	return func(d *KNN) {
+		d.method = m
+	}
+}
+
+// WithLeafSize sets the number of training points below which the index
+// stops splitting, 16 by default.
+func WithLeafSize(n int) Option {
+	return func(d *KNN) {
+		d.leafSize = n
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(d *KNN) {
+		d.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(d *KNN) {
+		d.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(d *KNN) {
+		d.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(d *KNN) {
+		d.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new KNN.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(d *KNN) {
+		d.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(d *KNN) {
+		d.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(d *KNN) {
+		d.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained KNN with the given options.
+func New(opts ...Option) *KNN {
+	d := &KNN{
+		k:             10,
+		leafSize:      16,
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.workers = max(d.workers, 0)
+	d.explainTop = max(d.explainTop, 0)
+	return d
+}
+
+// Validate checks the k, leaf size, scoring method and contamination set by
+// the options.
+func (d *KNN) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validate()
+}
+
+func (d *KNN) validate() error {
+	var errs []error
+	if d.k < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithK(%d): need at least one neighbor", ErrInvalidOption, d.k))
+	}
+	if d.method > MaxDistance {
+		errs = append(errs, fmt.Errorf("%w: WithMethod(%d): unknown method", ErrInvalidOption, d.method))
+	}
+	if d.leafSize < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithLeafSize(%d): must be positive", ErrInvalidOption, d.leafSize))
+	}
+	if !(d.contamination >= 0 && d.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, d.contamination))
+	}
+	if d.severity != nil {
+		if err := d.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit standardizes and indexes data. Training points are scored against
+// the others, leaving themselves out, for the score scale and threshold.
+// Features must be finite and there must be more rows than WithK.
+func (d *KNN) Fit(data [][]float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if d.featureNames != nil && len(d.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(d.featureNames), nFeatures)
+	}
+	if len(data) <= d.k {
+		return fmt.Errorf("%d training rows for %d neighbors: need more rows than neighbors", len(data), d.k)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	d.mean, d.std = standardization(data)
+	points := make([][]float64, len(data))
+	for i, row := range data {
+		points[i] = d.standardize(row, make([]float64, nFeatures))
+	}
+	d.tree = kdtree.Build(points, nFeatures, d.leafSize)
+	d.scale = 1
+	d.trained = true
+
+	// Score the training data once, for the score scale, the threshold and
+	// the feature importances.
+	raw := make([]float64, len(data))
+	importances := make([]float64, nFeatures)
+	var mu sync.Mutex
+	detectors.ParallelFor(len(points), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		shares := make([]float64, nFeatures)
+		for i := lo; i < hi; i++ {
+			d.tree.Search(points[i], d.k, i, &h)
+			raw[i] = d.raw(h)
+			d.addShares(points[i], h, shares)
+		}
+		mu.Lock()
+		for j, s := range shares {
+			importances[j] += s
+		}
+		mu.Unlock()
+	})
+	var sum float64
+	for _, r := range raw {
+		sum += r
+	}
+	if mean := sum / float64(len(raw)); mean > 0 {
+		d.scale = mean
+	}
+	d.importances = normalize(importances)
+
+	if d.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(raw), 100)
+		for _, r := range raw {
+			est.Add(d.score(r))
+		}
+		d.threshold = est.Quantile(1 - d.contamination)
+	}
+	d.card = d.modelCard(data)
+	return nil
+}
+
+// standardization returns the mean and standard deviation of each feature
+// of data. Constant features get a deviation of 1.
+func standardization(data [][]float64) (mean, std []float64) {
+	n := len(data[0])
+	mean, std = make([]float64, n), make([]float64, n)
+	column := make([]float64, len(data))
+	for j := range n {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		mean[j], std[j] = stats.MeanStd(column)
+		if !(std[j] > 0) {
+			std[j] = 1
+		}
+	}
+	return mean, std
+}
+
+// standardize writes the standardized sample to dst and returns it. NaN
+// values are placed infinitely far from every training point. The caller
+// holds the read lock.
+func (d *KNN) standardize(sample, dst []float64) []float64 {
+	for j, v := range sample {
+		if math.IsNaN(v) {
+			v = math.Inf(1)
+		}
+		dst[j] = stats.Standardize(v, d.mean[j], d.std[j])
+	}
+	return dst
+}
+
+// raw combines the squared distances of the neighbors found for a sample.
+func (d *KNN) raw(h kdtree.Neighbors) float64 {
+	if d.method == MaxDistance {
+		// The heap holds the farthest neighbor first.
+		return math.Sqrt(h[0].Dist)
+	}
+	var sum float64
+	for _, n := range h {
+		sum += math.Sqrt(n.Dist)
+	}
+	return sum / float64(len(h))
+}
+
+// addShares adds to shares each feature's part of the squared distances of
+// q to its neighbors. The caller holds the read lock.
+func (d *KNN) addShares(q []float64, h kdtree.Neighbors, shares []float64) {
+	for _, n := range h {
+		point := d.tree.Point(n.Row)
+		for j, v := range q {
+			diff := v - point[j]
+			shares[j] += diff * diff
+		}
+	}
+}
+
+// score maps a raw score to [0, 1]: 0.5 for the mean training sample,
+// rising toward 1 as samples get farther from the training data.
+func (d *KNN) score(raw float64) float64 {
+	return 1 - math.Exp2(-raw/d.scale)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (d *KNN) Predict(data [][]float64) ([]float64, error) {
+	return d.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (d *KNN) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(d.mean) {
+			return nil, fmt.Errorf("sample %d: %w", i, d.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		var h kdtree.Neighbors
+		q := make([]float64, len(d.mean))
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			d.tree.Search(d.standardize(data[i], q), d.k, -1, &h)
+			scores[i] = d.score(d.raw(h))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (d *KNN) PredictOne(sample []float64) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return 0, d.dimensionError(sample)
+	}
+	_, h := d.query(sample)
+	return d.score(d.raw(h)), nil
+}
+
+// query returns the standardized sample and its nearest training points.
+// The caller holds the read lock.
+func (d *KNN) query(sample []float64) ([]float64, kdtree.Neighbors) {
+	q := d.standardize(sample, make([]float64, len(sample)))
+	h := make(kdtree.Neighbors, 0, d.k)
+	d.tree.Search(q, d.k, -1, &h)
+	return q, h
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (d *KNN) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(d.mean)}
+}
+
+// PredictStream scores samples from input against the standardized training
+// points until input is closed.
+func (d *KNN) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	d.mu.RLock()
+	if !d.trained {
+		d.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := d.onReject
+	d.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, d.streamScore)
+}
+
+// streamScore scores a streamed sample against the standardized training
+// points.
+func (d *KNN) streamScore(sample []float64) (detectors.Score, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(sample) != len(d.mean) {
+		return detectors.Score{}, d.dimensionError(sample)
+	}
+	q, h := d.query(sample)
+	score := d.score(d.raw(h))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= d.threshold,
+		Features:  sample,
+	}
+	if d.explainTop > 0 {
+		exp := d.explain(sample, q, h)
+		result.Explanation = &exp
+	}
+	if d.severity != nil {
+		result.Severity = d.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*KNN)(nil)
+	_ detectors.Thresholder    = (*KNN)(nil)
+	_ detectors.RejectReporter = (*KNN)(nil)
+	_ detectors.Explainer      = (*KNN)(nil)
+	_ detectors.Describer      = (*KNN)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the standardized training points to.
+func (d *KNN) SetRejectHandler(fn detectors.RejectFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReject = fn
+}
+
+// Neighbor is a training point near an explained sample.
+type Neighbor struct {
+	// Row is the index of the point in the training data.
+	Row int `json:"row"`
+	// Distance is the distance to the sample, in standard deviations.
+	Distance float64 `json:"distance"`
+	// Features are the point's features.
+	Features []float64 `json:"features"`
+}
+
+// Neighbors returns the k training points nearest sample, nearest first:
+// the points its score is measured against.
+func (d *KNN) Neighbors(sample []float64) ([]Neighbor, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return nil, d.dimensionError(sample)
+	}
+	_, h := d.query(sample)
+	slices.SortStableFunc(h, func(a, b kdtree.Neighbor) int {
+		return cmp.Compare(a.Dist, b.Dist)
+	})
+	out := make([]Neighbor, len(h))
+	for i, n := range h {
+		out[i] = Neighbor{Row: n.Row, Distance: math.Sqrt(n.Dist), Features: d.unstandardize(d.tree.Point(n.Row))}
+	}
+	return out, nil
+}
+
+// unstandardize returns the features of standardized point p.
+func (d *KNN) unstandardize(p []float64) []float64 {
+	out := make([]float64, len(p))
+	for j, v := range p {
+		out[j] = v*d.std[j] + d.mean[j]
+	}
+	return out
+}
+
+// FeatureImportances returns each feature's share of the squared
+// distances of training points to their neighbors: the features that
+// most separate the training data weigh more. It returns nil if the
+// detector is not trained.
+func (d *KNN) FeatureImportances() []float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// the squared distances to its nearest training points. The typical range
+// of each feature is its range among those points.
+func (d *KNN) Explain(sample []float64) (detectors.Explanation, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(d.mean) {
+		return detectors.Explanation{}, d.dimensionError(sample)
+	}
+	q, h := d.query(sample)
+	return d.explain(sample, q, h), nil
+}
+
+// explain explains a sample of the right width from its standardized form
+// and neighbors. The caller holds the read lock.
+func (d *KNN) explain(sample, q []float64, h kdtree.Neighbors) detectors.Explanation {
+	contributions := make([]float64, len(sample))
+	d.addShares(q, h, contributions)
+	normalize(contributions)
+
+	typical := make([]detectors.Range, len(sample))
+	for j := range typical {
+		typical[j] = detectors.Range{Low: math.Inf(1), High: math.Inf(-1)}
+	}
+	for _, n := range h {
+		for j, v := range d.unstandardize(d.tree.Point(n.Row)) {
+			typical[j].Low = min(typical[j].Low, v)
+			typical[j].High = max(typical[j].High, v)
+		}
+	}
+	topK := d.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         d.score(d.raw(h)),
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, typical, topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (d *KNN) Metadata() detectors.ModelCard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	card := d.card
+	card.FeatureNames = slices.Clone(d.card.FeatureNames)
+	if d.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(d.card.Hyperparameters))
+		for k, v := range d.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (d *KNN) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   d.dataSource,
+		Rows:         len(data),
+		Features:     len(d.mean),
+		FeatureNames: slices.Clone(d.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "knn",
+			"k":             strconv.Itoa(d.k),
+			"method":        d.method.String(),
+			"leaf_size":     strconv.Itoa(d.leafSize),
+			"contamination": strconv.FormatFloat(d.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(d.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (d *KNN) Trained() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (d *KNN) Threshold() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (d *KNN) SetThreshold(t float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = t
+}
@@ -0,0 +1,141 @@
+package knn
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// clusterData returns n samples of three features in two clusters, the
+// third feature on a much wider scale than the others.
+func clusterData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		center := 0.0
+		if i%2 == 1 {
+			center = 10
+		}
+		data[i] = []float64{center + rng.NormFloat64(), center + rng.NormFloat64(), 1000 * rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*KNN, Option]{
+		Algorithm:        "knn",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithK(4), WithMethod(MaxDistance)},
+		Invalid:          []Option{WithK(0), WithMethod(7), WithLeafSize(0), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            clusterData(1000, 6),
+		Probe:            append(clusterData(20, 7), []float64{0, 0, 8000}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	for _, method := range []Method{MeanDistance, MaxDistance} {
+		t.Run(method.String(), func(t *testing.T) {
+			d := New(WithMethod(method))
+			require.NoError(t, d.Fit(clusterData(2000, 1)))
+
+			scores, err := d.Predict([][]float64{
+				{0, 0, 0},
+				{10, 10, 500},
+				{5, 5, 0},
+				{0, 10, 0},
+				{0, 0, 8000},
+				{math.NaN(), 0, 0},
+			})
+			require.NoError(t, err)
+			assert.Less(t, scores[0], d.Threshold(), "cluster centers are normal")
+			assert.Less(t, scores[1], d.Threshold())
+			for i, s := range scores[2:] {
+				assert.Greater(t, s, d.Threshold(), "sample %d", i+2)
+			}
+			assert.Equal(t, 1.0, scores[5], "NaN features are infinitely far")
+
+			// Contamination sets the threshold to flag about 10% of training.
+			trainScores, err := d.Predict(clusterData(2000, 2))
+			require.NoError(t, err)
+			flagged := 0
+			for _, s := range trainScores {
+				if s >= d.Threshold() {
+					flagged++
+				}
+			}
+			assert.InDelta(t, 200, flagged, 60)
+
+			one, err := d.PredictOne([]float64{5, 5, 0})
+			require.NoError(t, err)
+			assert.Equal(t, scores[2], one)
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	d := New()
+	_, err := d.Neighbors([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	assert.Error(t, d.Fit(clusterData(10, 1)), "need more rows than neighbors")
+}
+
+func TestExplain(t *testing.T) {
+	data := clusterData(1000, 4)
+	d := New(WithK(5))
+	require.NoError(t, d.Fit(data))
+
+	sample := []float64{0, 6, 0}
+	exp, err := d.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Less(t, exp.Top[0].Typical.High, 6.0, "the neighbors' range excludes the odd value")
+
+	neighbors, err := d.Neighbors(sample)
+	require.NoError(t, err)
+	require.Len(t, neighbors, 5)
+	for i, n := range neighbors {
+		assert.InDeltaSlice(t, data[n.Row], n.Features, 1e-9)
+		if i > 0 {
+			assert.GreaterOrEqual(t, n.Distance, neighbors[i-1].Distance)
+		}
+	}
+
+	nan, err := d.Explain([]float64{0, math.NaN(), 0})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 1, 0}, nan.Contributions)
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := clusterData(10000, 1)
+	d := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New()
+	d.Fit(clusterData(100000, 1))
+	samples := clusterData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
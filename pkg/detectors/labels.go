@@ -0,0 +1,140 @@
+package detectors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Labels of partially labeled training samples, as in data.Dataset labels.
+// Any label other than LabelNormal and LabelAnomaly, including NaN, marks
+// an unlabeled sample.
+const (
+	LabelUnknown = -1.0
+	LabelNormal  = 0.0
+	LabelAnomaly = 1.0
+)
+
+// SemiSupervised is implemented by detectors that can train on partially
+// labeled data: known normal samples, confirmed anomalies, and unlabeled
+// samples.
+type SemiSupervised interface {
+	// FitSemiSupervised trains the detector on data with one label per
+	// sample.
+	FitSemiSupervised(data [][]float64, labels []float64) error
+}
+
+// ErrNoNormalData is returned when training with labels leaves no sample
+// that is not a known anomaly.
+var ErrNoNormalData = errors.New("detectors: every training sample is a labeled anomaly")
+
+// FitLabeled trains d on partially labeled data. Detectors implementing
+// SemiSupervised train their own way. Others are fit on the samples not
+// labeled anomalous and, if they have an adjustable threshold and there
+// are labeled anomalies, get the threshold LabelThreshold selects.
+func FitLabeled(d Detector, data [][]float64, labels []float64) error {
+	if len(labels) != len(data) {
+		return fmt.Errorf("detectors: %d labels for %d samples", len(labels), len(data))
+	}
+	if s, ok := d.(SemiSupervised); ok {
+		return s.FitSemiSupervised(data, labels)
+	}
+
+	train, anomalies := SplitLabeled(data, labels)
+	if len(train) == 0 {
+		return ErrNoNormalData
+	}
+	if err := d.Fit(train); err != nil {
+		return err
+	}
+	th, ok := d.(Thresholder)
+	if !ok || len(anomalies) == 0 {
+		return nil
+	}
+	scores, err := d.Predict(data)
+	if err != nil {
+		return err
+	}
+	if t, ok := LabelThreshold(scores, labels); ok {
+		th.SetThreshold(t)
+	}
+	return nil
+}
+
+// SplitLabeled separates the samples labeled anomalous from the others.
+// The rows are not copied.
+func SplitLabeled(data [][]float64, labels []float64) (rest, anomalies [][]float64) {
+	rest = make([][]float64, 0, len(data))
+	for i, row := range data {
+		if labels[i] == LabelAnomaly {
+			anomalies = append(anomalies, row)
+		} else {
+			rest = append(rest, row)
+		}
+	}
+	return rest, anomalies
+}
+
+// LabelThreshold selects an anomaly threshold from the scores of partially
+// labeled samples: the one flagging labeled anomalies with the best F1
+// score, counting every other sample, labeled normal or not, as normal.
+// The threshold is placed halfway between the lowest anomaly it flags and
+// the highest score below it, for a margin on both sides. It reports false
+// if no sample is labeled anomalous.
+func LabelThreshold(scores, labels []float64) (float64, bool) {
+	type scored struct {
+		v       float64
+		anomaly bool
+	}
+	samples := make([]scored, 0, len(scores))
+	positives := 0
+	for i, v := range scores {
+		if math.IsNaN(v) {
+			continue
+		}
+		anomaly := labels[i] == LabelAnomaly
+		if anomaly {
+			positives++
+		}
+		samples = append(samples, scored{v, anomaly})
+	}
+	if positives == 0 {
+		return 0, false
+	}
+	// Highest scores first; at equal scores, normal samples first, so a
+	// threshold at a score counts all samples with it as flagged.
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].v != samples[j].v {
+			return samples[i].v > samples[j].v
+		}
+		return !samples[i].anomaly && samples[j].anomaly
+	})
+
+	var (
+		bestF1 = -1.0
+		best   int
+		tp, fp int
+	)
+	for i, s := range samples {
+		if s.anomaly {
+			tp++
+		} else {
+			fp++
+		}
+		// Only cut between distinct scores, after an anomaly.
+		if !s.anomaly || (i+1 < len(samples) && samples[i+1].v == s.v) {
+			continue
+		}
+		f1 := 2 * float64(tp) / float64(2*tp+fp+(positives-tp))
+		if f1 > bestF1 {
+			bestF1, best = f1, i
+		}
+	}
+
+	t := samples[best].v
+	if best+1 < len(samples) {
+		t = (t + samples[best+1].v) / 2
+	}
+	return t, true
+}
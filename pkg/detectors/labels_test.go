@@ -0,0 +1,97 @@
+package detectors
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelThreshold(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		scores []float64
+		labels []float64
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "separable",
+			scores: []float64{0.3, 0.4, 0.5, 0.8, 0.9},
+			labels: []float64{LabelUnknown, LabelNormal, LabelUnknown, LabelAnomaly, LabelAnomaly},
+			want:   0.65,
+			wantOK: true,
+		},
+		{
+			name:   "a normal sample among the anomalies",
+			scores: []float64{0.3, 0.7, 0.75, 0.8, 0.9},
+			labels: []float64{LabelUnknown, LabelAnomaly, LabelNormal, LabelAnomaly, LabelAnomaly},
+			want:   0.5,
+			wantOK: true,
+		},
+		{
+			name:   "an outlying anomaly is left out",
+			scores: []float64{0.2, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9},
+			labels: []float64{LabelAnomaly, nan, nan, nan, nan, LabelAnomaly, LabelAnomaly},
+			want:   0.75,
+			wantOK: true,
+		},
+		{
+			name:   "tied scores count as flagged",
+			scores: []float64{0.5, 0.8, 0.8},
+			labels: []float64{LabelUnknown, LabelNormal, LabelAnomaly},
+			want:   0.65,
+			wantOK: true,
+		},
+		{
+			name:   "lowest score anomalous",
+			scores: []float64{0.5, 0.6},
+			labels: []float64{LabelAnomaly, LabelAnomaly},
+			want:   0.5,
+			wantOK: true,
+		},
+		{
+			name:   "no labeled anomaly",
+			scores: []float64{0.5, 0.6},
+			labels: []float64{LabelNormal, LabelUnknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LabelThreshold(tt.scores, tt.labels)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.InDelta(t, tt.want, got, 1e-12)
+		})
+	}
+}
+
+func TestFitLabeledFallback(t *testing.T) {
+	l := &labeledRecorder{linear: linear{scale: 1, threshold: 0.5}}
+	data := [][]float64{{0.1}, {0.2}, {0.9}, {0.3}}
+	labels := []float64{LabelNormal, LabelUnknown, LabelAnomaly, math.NaN()}
+
+	require.NoError(t, FitLabeled(l, data, labels))
+	assert.Equal(t, [][]float64{{0.1}, {0.2}, {0.3}}, l.rows, "labeled anomalies are not trained on")
+	assert.InDelta(t, 0.6, l.threshold, 1e-12)
+
+	assert.ErrorContains(t, FitLabeled(l, data, labels[:2]), "2 labels for 4 samples")
+	assert.ErrorIs(t, FitLabeled(l, data[2:3], labels[2:3]), ErrNoNormalData)
+
+	// Without labeled anomalies the threshold is left alone.
+	l.threshold = 0.5
+	require.NoError(t, FitLabeled(l, data[:2], labels[:2]))
+	assert.Equal(t, 0.5, l.threshold)
+}
+
+// labeledRecorder records the rows it is fitted on.
+type labeledRecorder struct {
+	linear
+	rows [][]float64
+}
+
+func (l *labeledRecorder) Fit(rows [][]float64) error {
+	l.rows = rows
+	return nil
+}
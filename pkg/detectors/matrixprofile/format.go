@@ -0,0 +1,116 @@
+package matrixprofile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved matrix profile models.
+var saveFormat = container.Format{Name: "matrixprofile", Model: "matrix profile", Magic: "GGMPSAVE", Version: 1}
+
+// savedModel holds the options and the training series of a saved matrix
+// profile model.
+type savedModel struct {
+	Window        int
+	Fraction      float64
+	Seed          int64
+	Contamination float64
+	Threshold     float64
+	Offset        float64
+	Scale         float64
+	// Series is the training series, centered by Offset. The window
+	// statistics are recomputed on Load.
+	Series []float64
+	Card   container.Card
+}
+
+// Save serializes the training series.
+func (d *MatrixProfile) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (d *MatrixProfile) SaveTo(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Window:        d.window,
+		Fraction:      d.fraction,
+		Seed:          d.seed,
+		Contamination: d.contamination,
+		Threshold:     d.threshold,
+		Offset:        d.offset,
+		Scale:         d.scale,
+		Series:        d.train.values,
+		Card:          container.NewCard(d.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the training series written by Save, recomputing the window
+// statistics.
+func (d *MatrixProfile) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	train := newWindows(m.Series, m.Window)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.window, d.fraction, d.seed = m.Window, m.Fraction, m.Seed
+	d.contamination, d.threshold = m.Contamination, m.Threshold
+	d.offset, d.scale = m.Offset, m.Scale
+	d.train = train
+	d.card = m.Card.ModelCard()
+	d.featureNames = d.card.FeatureNames
+	d.trained = true
+	return nil
+}
+
+// LoadFrom restores the training series read from r.
+func (d *MatrixProfile) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Load(data)
+}
+
+// validate checks the saved training series.
+func (m *savedModel) validate() error {
+	switch {
+	case m.Window < 3 || !(m.Fraction > 0 && m.Fraction <= 1):
+		return errors.New("matrixprofile: invalid hyperparameters")
+	case len(m.Series) < 2*m.Window:
+		return fmt.Errorf("matrixprofile: %d values do not hold two windows of %d", len(m.Series), m.Window)
+	case !(m.Scale > 0) || math.IsInf(m.Scale, 0):
+		return fmt.Errorf("matrixprofile: invalid score scale %g", m.Scale)
+	case math.IsNaN(m.Offset) || math.IsInf(m.Offset, 0):
+		return fmt.Errorf("matrixprofile: invalid offset %g", m.Offset)
+	}
+	for _, v := range m.Series {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("matrixprofile: model has non-finite values")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,584 @@
+// Package matrixprofile implements a matrix profile discord detector for
+// univariate time series.
+//
+// Samples are the values of a series in time order, one feature per row.
+// Each value is scored by the window of the last WithWindow values ending
+// at it: by the distance from that window, z-normalized, to the most
+// similar window of the training series. Odd shapes stand out even when
+// every value lies in its usual range, such as a latency spike with a
+// slow instead of a sharp recovery, or a request rate that stops
+// following its daily curve. Because windows are z-normalized, a series
+// that keeps its shape at a new level or scale is not flagged; pair the
+// detector with a point-wise one for that.
+//
+// Fit computes the matrix profile of the training series, the distance of
+// each window to its nearest other window, visiting the diagonals of the
+// distance matrix in random order as in SCRIMP; WithFraction stops after
+// a share of them for an approximate profile in a share of the time. The
+// profile sets the score scale and threshold. Scoring follows the STOMP
+// recurrence: as the window slides by one value, its dot products with
+// all training windows are updated in time proportional to the length of
+// the training series, which is what scoring a value costs. Train on the
+// shortest series that covers normal behavior, such as a few seasons.
+//
+// Predict scores a batch as the continuation of the training series, so
+// its first values complete windows that begin with the last training
+// values; batches do not change the model. PredictStream carries its own
+// window from one sample to the next. Discords finds the most unusual
+// windows of a series.
+package matrixprofile
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// window, fraction or contamination.
+var ErrInvalidOption = fmt.Errorf("matrixprofile: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of values scored between context checks, and
+// the fewest a worker scores: each computes its first dot products afresh.
+const scoreChunk = 1024
+
+// MatrixProfile is a matrix profile discord detector. It is safe for
+// concurrent use.
+type MatrixProfile struct {
+	mu sync.RWMutex
+
+	// Configuration
+	window        int
+	fraction      float64
+	seed          int64
+	contamination float64
+	threshold     float64
+	workers       int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	// offset is the mean of the training series, subtracted from every
+	// value to keep dot products of windows far from zero precise.
+	offset float64
+	train  *windows
+	// scale is the mean distance of training windows to their nearest
+	// neighbor, which scores 0.5.
+	scale   float64
+	card    detectors.ModelCard
+	trained bool
+}
+
+// Option configures a MatrixProfile.
+type Option func(*MatrixProfile)
+
+// WithWindow sets the length of the windows compared, 32 by default: about
+// the length of the shapes to tell apart, such as an hour of minutely
+// values. Each unusual stretch of values raises the scores of the windows
+// that contain it.
+func WithWindow(m int) Option {
+	return func(d *MatrixProfile) {
+		d.window = m
+	}
+}
+
+// WithFraction sets the share of the diagonals of the training distance
+// matrix Fit visits, 1 by default for the exact matrix profile. Smaller
+// values train in proportionally less time, overestimating some distances
+// and so the threshold; scoring is unaffected.
+func WithFraction(f float64) Option {
+	return func(d *MatrixProfile) {
+		d.fraction = f
+	}
+}
+
+// WithSeed sets the seed of the order the diagonals are visited in, 42 by
+// default. It only matters with WithFraction below 1.
+func WithSeed(seed int64) Option {
+	return func(d *MatrixProfile) {
+		d.seed = seed
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.01 by
+// default. Fit sets the threshold to flag that fraction of the training
+// windows; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(d *MatrixProfile) {
+		d.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(d *MatrixProfile) {
+		d.workers = n
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(d *MatrixProfile) {
+		d.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new MatrixProfile.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(d *MatrixProfile) {
+		d.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(d *MatrixProfile) {
+		d.dataSource = source
+	}
+}
+
+// WithFeatureNames records the name of the series in the model card. Fit
+// fails unless exactly one name is given.
+func WithFeatureNames(names []string) Option {
+	return func(d *MatrixProfile) {
+		d.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained MatrixProfile with the given options.
+func New(opts ...Option) *MatrixProfile {
+	d := &MatrixProfile{
+		window:        32,
+		fraction:      1,
+		seed:          42,
+		contamination: 0.01,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.workers = max(d.workers, 0)
+	return d
+}
+
+// Validate checks the window, fraction and contamination set by the
+// options.
+func (d *MatrixProfile) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validate()
+}
+
+func (d *MatrixProfile) validate() error {
+	var errs []error
+	if d.window < 3 {
+		errs = append(errs, fmt.Errorf("%w: WithWindow(%d): need at least 3 values to compare shapes", ErrInvalidOption, d.window))
+	}
+	if !(d.fraction > 0 && d.fraction <= 1) {
+		errs = append(errs, fmt.Errorf("%w: WithFraction(%g): must be in (0, 1]", ErrInvalidOption, d.fraction))
+	}
+	if !(d.contamination >= 0 && d.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, d.contamination))
+	}
+	if d.severity != nil {
+		if err := d.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// exclusion returns the number of windows on either side of a window that
+// overlap it too much to count as its neighbors, the trivial matches.
+func exclusion(m int) int {
+	return (m + 3) / 4
+}
+
+// Fit computes the matrix profile of the training series, one value per
+// row in time order. Values must be finite and the series at least two
+// windows long.
+func (d *MatrixProfile) Fit(data [][]float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	if nFeatures := len(data[0]); nFeatures != 1 {
+		return fmt.Errorf("training data has %d features: the matrix profile scores a single series", nFeatures)
+	}
+	if d.featureNames != nil && len(d.featureNames) != 1 {
+		return fmt.Errorf("%d feature names for 1 feature", len(d.featureNames))
+	}
+	if len(data) < 2*d.window {
+		return fmt.Errorf("%d training values for windows of %d: need at least two windows", len(data), d.window)
+	}
+	values := make([]float64, len(data))
+	for i, row := range data {
+		if len(row) != 1 {
+			return fmt.Errorf("row %d has %d features, expected 1", i, len(row))
+		}
+		if math.IsNaN(row[0]) || math.IsInf(row[0], 0) {
+			return fmt.Errorf("row %d feature 0 is not finite", i)
+		}
+		values[i] = row[0]
+	}
+
+	offset, _ := meanStd(values)
+	for i := range values {
+		values[i] -= offset
+	}
+	train := newWindows(values, d.window)
+
+	// Visit a random share of the diagonals beyond the trivial matches.
+	var diagonals []int
+	for k := exclusion(d.window); k < train.len(); k++ {
+		diagonals = append(diagonals, k)
+	}
+	rng := rand.New(rand.NewSource(d.seed))
+	rng.Shuffle(len(diagonals), func(i, j int) { diagonals[i], diagonals[j] = diagonals[j], diagonals[i] })
+	diagonals = diagonals[:max(1, int(math.Ceil(d.fraction*float64(len(diagonals)))))]
+	best := make([]float64, train.len())
+	for i := range best {
+		best[i] = math.Inf(-1)
+	}
+	var mu sync.Mutex
+	detectors.ParallelFor(len(diagonals), detectors.Workers(d.workers), 16, func(lo, hi int) {
+		part := train.selfJoin(diagonals[lo:hi])
+		mu.Lock()
+		for i, corr := range part {
+			best[i] = max(best[i], corr)
+		}
+		mu.Unlock()
+	})
+	profile := train.distances(best, exclusion(d.window))
+
+	var sum float64
+	var finite int
+	for _, p := range profile {
+		if !math.IsInf(p, 1) {
+			sum += p
+			finite++
+		}
+	}
+	d.offset = offset
+	d.train = train
+	d.scale = 1
+	if mean := sum / float64(max(finite, 1)); mean > 0 {
+		d.scale = mean
+	}
+	d.trained = true
+
+	if d.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(profile), 100)
+		for _, p := range profile {
+			est.Add(d.score(p))
+		}
+		d.threshold = est.Quantile(1 - d.contamination)
+	}
+	d.card = d.modelCard(data)
+	return nil
+}
+
+// score maps a window distance to [0, 1]: 0.5 for the mean training
+// window, rising toward 1 for windows unlike any in training.
+func (d *MatrixProfile) score(dist float64) float64 {
+	return 1 - math.Exp2(-dist/d.scale)
+}
+
+// tail returns the last m-1 training values, centered, which precede the
+// first value of a batch.
+func (d *MatrixProfile) tail() []float64 {
+	return d.train.values[len(d.train.values)-(d.window-1):]
+}
+
+// join returns the distance of the window ending at each value of values,
+// which follow the centered values of prefix, to its nearest training
+// window, and that window's start. It returns ctx.Err() if ctx is done
+// first. The caller holds the read lock.
+func (d *MatrixProfile) join(ctx context.Context, prefix, values []float64) ([]float64, []int, error) {
+	dist, nearest := make([]float64, len(values)), make([]int, len(values))
+	detectors.ParallelFor(len(values), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		// The window before lo ends with prefix and values[:lo].
+		before := make([]float64, 0, d.window-1)
+		for i := lo - (d.window - 1); i < lo; i++ {
+			if i < 0 {
+				before = append(before, prefix[len(prefix)+i])
+			} else {
+				before = append(before, values[i]-d.offset)
+			}
+		}
+		c := newCursor(d.train, d.offset, before)
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			dist[i], nearest[i] = c.push(values[i])
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return dist, nearest, nil
+}
+
+// Predict returns anomaly scores for the given samples.
+func (d *MatrixProfile) Predict(data [][]float64) ([]float64, error) {
+	return d.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (d *MatrixProfile) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	values := make([]float64, len(data))
+	for i, sample := range data {
+		if len(sample) != 1 {
+			return nil, fmt.Errorf("sample %d: %w", i, d.dimensionError(sample))
+		}
+		values[i] = sample[0]
+	}
+	dist, _, err := d.join(ctx, d.tail(), values)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range dist {
+		dist[i] = d.score(v)
+	}
+	return dist, nil
+}
+
+// PredictOne returns the anomaly score of sample as the value right after
+// the training series.
+func (d *MatrixProfile) PredictOne(sample []float64) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != 1 {
+		return 0, d.dimensionError(sample)
+	}
+	dist, _ := newCursor(d.train, d.offset, d.tail()).push(sample[0])
+	return d.score(dist), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (d *MatrixProfile) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: 1}
+}
+
+// Discord is an unusual window of a series.
+type Discord struct {
+	// Start is the index in the series of the first value of the window.
+	Start int `json:"start"`
+	// Distance is the distance of the z-normalized window to the most
+	// similar training window, which starts at Neighbor in the training
+	// series.
+	Distance float64 `json:"distance"`
+	Neighbor int     `json:"neighbor"`
+	// Score is the anomaly score of the window's last value.
+	Score float64 `json:"score"`
+}
+
+// Discords returns up to k windows of series least like any training
+// window, most unusual first, none overlapping another. Windows with NaN
+// values are left out.
+func (d *MatrixProfile) Discords(series []float64, k int) ([]Discord, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(series) < d.window || k <= 0 {
+		return nil, nil
+	}
+	prefix := make([]float64, d.window-1)
+	for i, v := range series[:d.window-1] {
+		prefix[i] = v - d.offset
+	}
+	dist, nearest, err := d.join(context.Background(), prefix, series[d.window-1:])
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []Discord
+	for i, dd := range dist {
+		if !math.IsInf(dd, 1) {
+			candidates = append(candidates, Discord{Start: i, Distance: dd, Neighbor: nearest[i], Score: d.score(dd)})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b Discord) int {
+		return cmp.Compare(b.Distance, a.Distance)
+	})
+	var out []Discord
+	for _, c := range candidates {
+		if len(out) == k {
+			break
+		}
+		if !slices.ContainsFunc(out, func(o Discord) bool { return abs(o.Start-c.Start) < d.window }) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// PredictStream scores samples from input, each the next value of the
+// series that continues the training series, until input is closed.
+func (d *MatrixProfile) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	d.mu.RLock()
+	if !d.trained {
+		d.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := d.onReject
+	d.mu.RUnlock()
+
+	var c *cursor
+	return streamer.Run(ctx, input, output, reject, func(sample []float64) (detectors.Score, error) {
+		return d.streamScore(&c, sample)
+	})
+}
+
+// streamScore scores one streamed sample under one read lock, advancing
+// the stream's cursor, which it creates on the first sample and again if
+// the model changed since.
+func (d *MatrixProfile) streamScore(c **cursor, sample []float64) (detectors.Score, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(sample) != 1 {
+		return detectors.Score{}, d.dimensionError(sample)
+	}
+	if *c == nil || (*c).train != d.train {
+		*c = newCursor(d.train, d.offset, d.tail())
+	}
+	dist, _ := (*c).push(sample[0])
+	score := d.score(dist)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= d.threshold,
+		Features:  sample,
+	}
+	if d.severity != nil {
+		result.Severity = d.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*MatrixProfile)(nil)
+	_ detectors.Thresholder    = (*MatrixProfile)(nil)
+	_ detectors.RejectReporter = (*MatrixProfile)(nil)
+	_ detectors.Describer      = (*MatrixProfile)(nil)
+	_ detectors.Sequential     = (*MatrixProfile)(nil)
+)
+
+// Sequential reports true: a batch is scored as the continuation of the
+// training series, so it must not be split.
+func (d *MatrixProfile) Sequential() bool {
+	return true
+}
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the training series to. Rejected samples do not advance the
+// series.
+func (d *MatrixProfile) SetRejectHandler(fn detectors.RejectFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReject = fn
+}
+
+// Metadata returns the model card recorded by Fit.
+func (d *MatrixProfile) Metadata() detectors.ModelCard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	card := d.card
+	card.FeatureNames = slices.Clone(d.card.FeatureNames)
+	if d.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(d.card.Hyperparameters))
+		for k, v := range d.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (d *MatrixProfile) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   d.dataSource,
+		Rows:         len(data),
+		Features:     1,
+		FeatureNames: slices.Clone(d.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "matrixprofile",
+			"window":        strconv.Itoa(d.window),
+			"fraction":      strconv.FormatFloat(d.fraction, 'g', -1, 64),
+			"seed":          strconv.FormatInt(d.seed, 10),
+			"contamination": strconv.FormatFloat(d.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(d.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (d *MatrixProfile) Trained() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (d *MatrixProfile) Threshold() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (d *MatrixProfile) SetThreshold(t float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = t
+}
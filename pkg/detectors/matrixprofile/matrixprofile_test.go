@@ -0,0 +1,162 @@
+package matrixprofile
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// period is the period of the series of wave.
+const period = 50
+
+// wave returns n values of a noisy sine wave of the given period around
+// 1000, starting at time start, one per row.
+func wave(start, n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		t := float64(start + i)
+		data[i] = []float64{1000 + 100*math.Sin(2*math.Pi*t/period) + rng.NormFloat64()}
+	}
+	return data
+}
+
+// flatten holds the values of data[lo:hi] at the value of data[lo]: each
+// within the usual range, but not the usual shape.
+func flatten(data [][]float64, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		data[i] = []float64{data[lo][0]}
+	}
+}
+
+func TestDetector(t *testing.T) {
+	probe := wave(1000, 300, 7)
+	flatten(probe, 150, 180)
+	detectortest.Suite[*MatrixProfile, Option]{
+		Algorithm:        "matrixprofile",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		Options:          []Option{WithWindow(period)},
+		Configured:       []Option{WithFraction(0.5)},
+		Invalid:          []Option{WithWindow(2), WithFraction(0), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            wave(0, 1000, 6),
+		Probe:            probe,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	d := New(WithWindow(period))
+	require.NoError(t, d.Fit(wave(0, 2000, 1)))
+
+	live := wave(2000, 500, 2)
+	flatten(live, 200, 230)
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+
+	flagged := 0
+	for i, s := range scores {
+		inAnomaly := i >= 200 && i < 230+period
+		if !inAnomaly && s >= d.Threshold() {
+			flagged++
+		}
+	}
+	assert.Less(t, flagged, 20, "the normal stretches are rarely flagged")
+	peak := 0.0
+	for _, s := range scores[200 : 230+period] {
+		peak = max(peak, s)
+	}
+	assert.Greater(t, peak, d.Threshold(), "the flattened stretch is flagged")
+	assert.Less(t, scores[100], d.Threshold())
+
+	// The first values complete windows begun by the training series.
+	one, err := d.PredictOne(live[0])
+	require.NoError(t, err)
+	assert.Equal(t, scores[0], one)
+
+	// A NaN spoils the windows holding it, and only those.
+	holed := wave(2000, 500, 2)
+	clean, err := d.Predict(holed)
+	require.NoError(t, err)
+	holed[100] = []float64{math.NaN()}
+	withNaN, err := d.Predict(holed)
+	require.NoError(t, err)
+	for i := 100; i < 100+period; i++ {
+		assert.Equal(t, 1.0, withNaN[i])
+	}
+	assert.InDeltaSlice(t, clean[100+period:], withNaN[100+period:], 1e-9)
+	assert.Equal(t, clean[:100], withNaN[:100])
+}
+
+func TestFraction(t *testing.T) {
+	data := wave(0, 1500, 3)
+	exact := New(WithWindow(period))
+	require.NoError(t, exact.Fit(data))
+	approx := New(WithWindow(period), WithFraction(0.2))
+	require.NoError(t, approx.Fit(data))
+
+	// Visiting fewer diagonals can only miss nearest neighbors.
+	assert.GreaterOrEqual(t, approx.Threshold(), exact.Threshold()-1e-9)
+	assert.InDelta(t, exact.Threshold(), approx.Threshold(), 0.1)
+}
+
+func TestDiscords(t *testing.T) {
+	d := New(WithWindow(period))
+	require.NoError(t, d.Fit(wave(0, 2000, 4)))
+
+	rows := wave(0, 600, 5)
+	flatten(rows, 300, 330)
+	series := make([]float64, len(rows))
+	for i, r := range rows {
+		series[i] = r[0]
+	}
+	discords, err := d.Discords(series, 3)
+	require.NoError(t, err)
+	require.Len(t, discords, 3)
+	assert.InDelta(t, 300, discords[0].Start, period, "the top discord overlaps the flattened stretch")
+	for i, disc := range discords[1:] {
+		assert.GreaterOrEqual(t, discords[i].Distance, disc.Distance)
+		assert.GreaterOrEqual(t, abs(disc.Start-discords[0].Start), period, "discords do not overlap")
+	}
+	score, err := d.PredictOne([]float64{series[0]})
+	require.NoError(t, err)
+	assert.Greater(t, discords[0].Score, score)
+}
+
+func TestErrors(t *testing.T) {
+	d := New()
+	_, err := d.Discords([]float64{1}, 1)
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	assert.Error(t, d.Fit([][]float64{{1, 2}, {3, 4}}), "one series only")
+	assert.Error(t, d.Fit(wave(0, 63, 1)), "need two windows")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := wave(0, 5000, 1)
+	d := New(WithWindow(period))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New(WithWindow(period))
+	d.Fit(wave(0, 5000, 1))
+	samples := wave(5000, 10000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
@@ -0,0 +1,220 @@
+package matrixprofile
+
+import (
+	"math"
+	"slices"
+)
+
+// refresh is the number of steps along a diagonal, or of values pushed to
+// a cursor, after which dot products are computed afresh instead of
+// updated, so rounding errors do not accumulate over long series.
+const refresh = 4096
+
+// windows is a series and the statistics of its windows of length m.
+type windows struct {
+	values    []float64
+	m         int
+	mean, std []float64
+	// inv is 1/(√m·std) of each window, 0 for constant windows, so that
+	// the correlation of two windows is (qt - m·mean·mean')·inv·inv'.
+	inv []float64
+	// firstFlat and lastFlat are the first and last constant windows, -1
+	// if there are none.
+	firstFlat, lastFlat int
+}
+
+// newWindows computes the window statistics of values.
+func newWindows(values []float64, m int) *windows {
+	w := &windows{values: values, m: m, firstFlat: -1, lastFlat: -1}
+	n := len(values) - m + 1
+	w.mean, w.std, w.inv = make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range w.mean {
+		w.mean[i], w.std[i] = meanStd(values[i : i+m])
+		if flat(w.mean[i], w.std[i]) {
+			if w.firstFlat < 0 {
+				w.firstFlat = i
+			}
+			w.lastFlat = i
+			continue
+		}
+		w.inv[i] = 1 / (math.Sqrt(float64(m)) * w.std[i])
+	}
+	return w
+}
+
+// len returns the number of windows.
+func (w *windows) len() int {
+	return len(w.mean)
+}
+
+// meanStd returns the mean and standard deviation of values.
+func meanStd(values []float64) (mean, std float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		std += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(std / float64(len(values)))
+}
+
+// dot returns the dot product of a and b, which have the same length.
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i, v := range a {
+		sum += v * b[i]
+	}
+	return sum
+}
+
+// flat reports whether a window of deviation std about mean is constant,
+// up to rounding.
+func flat(mean, std float64) bool {
+	return std <= 1e-8*(1+math.Abs(mean))
+}
+
+// Constant windows cannot be z-normalized. Two of them are taken to be at
+// distance 0, and a constant window to be √m from any other, the distance
+// of windows with a correlation of flatCorr.
+const flatCorr = 0.5
+
+// distance returns the Euclidean distance between two z-normalized windows
+// of length m with correlation corr.
+func distance(corr float64, m int) float64 {
+	return math.Sqrt(2 * float64(m) * (1 - min(corr, 1)))
+}
+
+// selfJoin returns, for each window of w, its highest correlation with the
+// windows it is paired with along the given diagonals, or -Inf if none
+// reaches it. Diagonal k pairs window i with window i+k. Pairs involving
+// constant windows count as uncorrelated; distances applies the rule for
+// them.
+func (w *windows) selfJoin(diagonals []int) []float64 {
+	best := make([]float64, w.len())
+	for i := range best {
+		best[i] = math.Inf(-1)
+	}
+	m, x := w.m, w.values
+	fm := float64(m)
+	for _, k := range diagonals {
+		var qt float64
+		for i := 0; i+k < w.len(); i++ {
+			j := i + k
+			if i%refresh == 0 {
+				qt = dot(x[i:i+m], x[j:j+m])
+			} else {
+				qt += x[i+m-1]*x[j+m-1] - x[i-1]*x[j-1]
+			}
+			corr := (qt - fm*w.mean[i]*w.mean[j]) * w.inv[i] * w.inv[j]
+			best[i] = max(best[i], corr)
+			best[j] = max(best[j], corr)
+		}
+	}
+	return best
+}
+
+// distances turns the correlations of selfJoin, over all diagonals from
+// excl on, into the matrix profile of w in place: the distance of each
+// window to its nearest window at least excl away.
+func (w *windows) distances(best []float64, excl int) []float64 {
+	for i, corr := range best {
+		// Is there a constant window at least excl away?
+		flatNear := w.firstFlat >= 0 && (w.firstFlat <= i-excl || w.lastFlat >= i+excl)
+		switch {
+		case w.inv[i] == 0 && flatNear:
+			best[i] = 0
+		case w.inv[i] == 0:
+			best[i] = math.Sqrt(float64(w.m))
+		case math.IsInf(corr, -1) && !flatNear:
+			best[i] = math.Inf(1)
+		case flatNear:
+			best[i] = distance(max(corr, flatCorr), w.m)
+		default:
+			best[i] = distance(corr, w.m)
+		}
+	}
+	return best
+}
+
+// cursor compares the windows of a series, as its values arrive, with the
+// training windows. The dot products of the current window with every
+// training window are updated from those of the previous one as the
+// window slides, the STOMP recurrence, in time proportional to the number
+// of training windows.
+type cursor struct {
+	train  *windows
+	offset float64
+	// window holds the last m values pushed, centered by offset.
+	window []float64
+	qt     []float64
+	// valid reports whether qt holds the dot products of the previous
+	// window; steps counts the updates since they were computed afresh.
+	valid bool
+	steps int
+}
+
+// newCursor returns a cursor whose window ends with prefix, m-1 values
+// already centered by offset, so the next value pushed completes it.
+func newCursor(train *windows, offset float64, prefix []float64) *cursor {
+	return &cursor{
+		train:  train,
+		offset: offset,
+		window: slices.Clone(prefix),
+		qt:     make([]float64, train.len()),
+	}
+}
+
+// push slides the window by v and returns the distance of the new window
+// to its nearest training window, and that window's start. Windows with
+// NaN values are infinitely far from every training window, nearest -1.
+func (c *cursor) push(v float64) (float64, int) {
+	t, m := c.train, c.train.m
+	v -= c.offset
+	var old float64
+	if len(c.window) == m {
+		old = c.window[0]
+		copy(c.window, c.window[1:])
+		c.window[m-1] = v
+	} else {
+		c.window = append(c.window, v)
+	}
+	if slices.ContainsFunc(c.window, math.IsNaN) {
+		c.valid = false
+		return math.Inf(1), -1
+	}
+
+	x := t.values
+	if c.valid && c.steps < refresh {
+		for b := len(c.qt) - 1; b > 0; b-- {
+			c.qt[b] = c.qt[b-1] - old*x[b-1] + v*x[b+m-1]
+		}
+		c.qt[0] = dot(c.window, x[:m])
+		c.steps++
+	} else {
+		for b := range c.qt {
+			c.qt[b] = dot(c.window, x[b:b+m])
+		}
+		c.valid, c.steps = true, 0
+	}
+
+	mean, std := meanStd(c.window)
+	if flat(mean, std) {
+		if t.firstFlat >= 0 {
+			return 0, t.firstFlat
+		}
+		return math.Sqrt(float64(m)), 0
+	}
+	best, nearest := math.Inf(-1), -1
+	mm, inv := float64(m)*mean, 1/(math.Sqrt(float64(m))*std)
+	for b, qt := range c.qt {
+		if corr := (qt - mm*t.mean[b]) * t.inv[b]; corr > best {
+			best, nearest = corr, b
+		}
+	}
+	best *= inv
+	if t.firstFlat >= 0 && flatCorr > best {
+		best, nearest = flatCorr, t.firstFlat
+	}
+	return distance(best, m), nearest
+}
@@ -0,0 +1,99 @@
+package matrixprofile
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// naiveDistance z-normalizes two windows and returns their Euclidean
+// distance.
+func naiveDistance(a, b []float64) float64 {
+	za, zb := znorm(a), znorm(b)
+	switch {
+	case za == nil && zb == nil:
+		return 0
+	case za == nil || zb == nil:
+		return math.Sqrt(float64(len(a)))
+	}
+	var sum float64
+	for i := range za {
+		sum += (za[i] - zb[i]) * (za[i] - zb[i])
+	}
+	return math.Sqrt(sum)
+}
+
+func znorm(w []float64) []float64 {
+	mean, std := meanStd(w)
+	if flat(mean, std) {
+		return nil
+	}
+	out := make([]float64, len(w))
+	for i, v := range w {
+		out[i] = (v - mean) / std
+	}
+	return out
+}
+
+func randomWalk(n int, rng *rand.Rand) []float64 {
+	x := make([]float64, n)
+	for i := 1; i < n; i++ {
+		x[i] = x[i-1] + rng.NormFloat64()
+	}
+	// A constant stretch exercises flat windows.
+	for i := n / 2; i < n/2+12; i++ {
+		x[i] = x[n/2]
+	}
+	return x
+}
+
+func TestSelfJoinMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const m = 8
+	x := randomWalk(300, rng)
+	w := newWindows(x, m)
+	var diagonals []int
+	for k := exclusion(m); k < w.len(); k++ {
+		diagonals = append(diagonals, k)
+	}
+	got := w.distances(w.selfJoin(diagonals), exclusion(m))
+
+	for i := range got {
+		want := math.Inf(1)
+		for j := 0; j < w.len(); j++ {
+			if j-i >= exclusion(m) || i-j >= exclusion(m) {
+				want = min(want, naiveDistance(x[i:i+m], x[j:j+m]))
+			}
+		}
+		require.InDelta(t, want, got[i], 1e-6, "window %d", i)
+	}
+}
+
+func TestCursorMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	const m = 8
+	train := randomWalk(200, rng)
+	w := newWindows(train, m)
+	series := randomWalk(100, rng)
+	series[40] = math.NaN()
+
+	c := newCursor(w, 0, series[:m-1])
+	for end := m - 1; end < len(series); end++ {
+		dist, nearest := c.push(series[end])
+		window := series[end-m+1 : end+1]
+		if end >= 40 && end < 40+m {
+			assert.True(t, math.IsInf(dist, 1), "window ending at %d holds NaN", end)
+			assert.Equal(t, -1, nearest)
+			continue
+		}
+		want := math.Inf(1)
+		for b := 0; b < w.len(); b++ {
+			want = min(want, naiveDistance(window, train[b:b+m]))
+		}
+		require.InDelta(t, want, dist, 1e-6, "window ending at %d", end)
+		assert.InDelta(t, want, naiveDistance(window, train[nearest:nearest+m]), 1e-6, "nearest window")
+	}
+}
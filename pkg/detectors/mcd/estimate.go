@@ -0,0 +1,236 @@
+package mcd
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// FastMCD search parameters: initial subsets are drawn from at most
+// searchRows rows and improved with two C-steps each; the keepBest best
+// take two more on all rows, and the best of those is iterated to
+// convergence, for at most refineSteps C-steps.
+const (
+	searchRows  = 1000
+	keepBest    = 10
+	refineSteps = 30
+)
+
+// estimate is a location and scatter estimate with the precision matrix
+// distances are measured with.
+type estimate struct {
+	loc    []float64
+	cov    *mat.SymDense
+	prec   []float64
+	logDet float64
+}
+
+// newEstimate returns the mean and covariance of rows of x, with ridge
+// added to the variances, or false if the covariance is singular.
+func newEstimate(x [][]float64, rows []int, ridge float64) (estimate, bool) {
+	p := len(x[0])
+	loc := make([]float64, p)
+	for _, i := range rows {
+		for j, v := range x[i] {
+			loc[j] += v
+		}
+	}
+	for j := range loc {
+		loc[j] /= float64(len(rows))
+	}
+	cov := mat.NewSymDense(p, nil)
+	z := make([]float64, p)
+	for _, i := range rows {
+		for j, v := range x[i] {
+			z[j] = v - loc[j]
+		}
+		cov.SymRankOne(cov, 1, mat.NewVecDense(p, z))
+	}
+	cov.ScaleSym(1/float64(len(rows)), cov)
+	for j := 0; j < p; j++ {
+		cov.SetSym(j, j, cov.At(j, j)+ridge)
+	}
+	return fromCovariance(loc, cov)
+}
+
+// fromCovariance completes an estimate from its location and covariance,
+// or returns false if the covariance is singular.
+func fromCovariance(loc []float64, cov *mat.SymDense) (estimate, bool) {
+	var chol mat.Cholesky
+	if !chol.Factorize(cov) {
+		return estimate{}, false
+	}
+	var inv mat.SymDense
+	if err := chol.InverseTo(&inv); err != nil {
+		return estimate{}, false
+	}
+	p := len(loc)
+	prec := make([]float64, p*p)
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			prec[i*p+j] = inv.At(i, j)
+		}
+	}
+	return estimate{loc: loc, cov: cov, prec: prec, logDet: chol.LogDet()}, true
+}
+
+// dist returns the squared Mahalanobis distance of sample, using z as
+// scratch space of the sample's length.
+func (e *estimate) dist(sample, z []float64) float64 {
+	for j, v := range sample {
+		z[j] = v - e.loc[j]
+	}
+	p := len(z)
+	var d float64
+	for i, zi := range z {
+		row := e.prec[i*p : (i+1)*p]
+		var s float64
+		for j, zj := range z {
+			s += row[j] * zj
+		}
+		d += zi * s
+	}
+	return d
+}
+
+// cStep returns the estimate of the h rows of x nearest e, which has a
+// covariance determinant no larger than e's.
+func cStep(x [][]float64, e estimate, h int, ridge float64) (estimate, bool) {
+	dist := make([]float64, len(x))
+	z := make([]float64, len(e.loc))
+	for i, row := range x {
+		dist[i] = e.dist(row, z)
+	}
+	order := make([]int, len(x))
+	for i := range order {
+		order[i] = i
+	}
+	nearest(order, dist, h)
+	return newEstimate(x, order[:h], ridge)
+}
+
+// nearest reorders order so that its first h rows are those of smallest
+// dist, in no particular order.
+func nearest(order []int, dist []float64, h int) {
+	lo, hi := 0, len(order)-1
+	for lo < hi {
+		pivot := dist[order[lo+(hi-lo)/2]]
+		i, j := lo, hi
+		for i <= j {
+			for dist[order[i]] < pivot {
+				i++
+			}
+			for dist[order[j]] > pivot {
+				j--
+			}
+			if i <= j {
+				order[i], order[j] = order[j], order[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case h-1 <= j:
+			hi = j
+		case h-1 >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// converge applies C-steps to e, an estimate of h rows of x, until its
+// determinant stops decreasing, for at most steps steps.
+func converge(x [][]float64, e estimate, h, steps int, ridge float64) estimate {
+	for range steps {
+		next, ok := cStep(x, e, h, ridge)
+		if !ok || !(next.logDet < e.logDet) {
+			break
+		}
+		converged := e.logDet-next.logDet < 1e-12
+		e = next
+		if converged {
+			break
+		}
+	}
+	return e
+}
+
+// fastMCD returns the raw Minimum Covariance Determinant estimate of x:
+// the mean and covariance of the h rows whose covariance has the smallest
+// determinant, searched with the FastMCD algorithm of Rousseeuw and Van
+// Driessen from trials random subsets of p+1 rows.
+func fastMCD(x [][]float64, h, trials, workers int, rng *rand.Rand, ridge float64) (estimate, bool) {
+	n, p := len(x), len(x[0])
+
+	// Search on a subsample of large inputs, with a proportional h.
+	sub := x
+	subH := h
+	if n > searchRows {
+		sub = make([][]float64, searchRows)
+		for i, r := range rng.Perm(n)[:searchRows] {
+			sub[i] = x[r]
+		}
+		subH = max(p+1, int(math.Ceil(float64(h)*searchRows/float64(n))))
+	}
+
+	// Draw every initial subset first, so results do not depend on the
+	// number of workers.
+	starts := make([][]int, trials)
+	for t := range starts {
+		starts[t] = rng.Perm(len(sub))[:min(p+1, len(sub))]
+	}
+	candidates := make([]estimate, trials)
+	found := make([]bool, trials)
+	detectors.ParallelFor(trials, workers, 1, func(lo, hi int) {
+		for t := lo; t < hi; t++ {
+			// The estimate of p+1 rows is not comparable with those of h
+			// rows: always take the first C-steps.
+			e, ok := newEstimate(sub, starts[t], ridge)
+			for step := 0; ok && step < 2; step++ {
+				e, ok = cStep(sub, e, subH, ridge)
+			}
+			candidates[t], found[t] = e, ok
+		}
+	})
+	var best []estimate
+	for t, e := range candidates {
+		if found[t] {
+			best = append(best, e)
+		}
+	}
+	if len(best) == 0 {
+		return estimate{}, false
+	}
+	slices.SortStableFunc(best, func(a, b estimate) int {
+		return cmp.Compare(a.logDet, b.logDet)
+	})
+	best = best[:min(keepBest, len(best))]
+
+	refined := make([]estimate, len(best))
+	detectors.ParallelFor(len(best), workers, 1, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			refined[i] = best[i]
+			e, ok := best[i], true
+			for step := 0; ok && step < 2; step++ {
+				if e, ok = cStep(x, e, h, ridge); ok {
+					refined[i] = e
+				}
+			}
+		}
+	})
+	result := refined[0]
+	for _, e := range refined[1:] {
+		if e.logDet < result.logDet {
+			result = e
+		}
+	}
+	result = converge(x, result, h, refineSteps, ridge)
+	return result, true
+}
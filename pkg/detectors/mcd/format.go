@@ -0,0 +1,144 @@
+package mcd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved MCD models.
+var saveFormat = container.Format{Name: "mcd", Model: "MCD", Magic: "GGMCSAVE", Version: 1}
+
+// savedModel holds the options and the robust location and covariance of a
+// saved MCD model.
+type savedModel struct {
+	SupportFraction float64
+	Trials          int
+	Ridge           float64
+	Seed            int64
+	Contamination   float64
+	Threshold       float64
+	Center          []float64
+	Spread          []float64
+	Location        []float64
+	// Covariance is in row-major order, in standardized units.
+	Covariance  []float64
+	Support     int
+	Importances []float64
+	Card        container.Card
+}
+
+// Save serializes the robust location and covariance.
+func (m *MCD) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (m *MCD) SaveTo(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.trained {
+		return detectors.ErrNotTrained
+	}
+	p := len(m.center)
+	s := savedModel{
+		SupportFraction: m.supportFraction,
+		Trials:          m.trials,
+		Ridge:           m.ridge,
+		Seed:            m.seed,
+		Contamination:   m.contamination,
+		Threshold:       m.threshold,
+		Center:          m.center,
+		Spread:          m.spread,
+		Location:        m.est.loc,
+		Covariance:      make([]float64, 0, p*p),
+		Support:         m.support,
+		Importances:     m.importances,
+		Card:            container.NewCard(m.card),
+	}
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			s.Covariance = append(s.Covariance, m.est.cov.At(i, j))
+		}
+	}
+
+	return saveFormat.Write(w, &s)
+}
+
+// Load restores the robust location and covariance written by Save.
+func (m *MCD) Load(data []byte) error {
+	var s savedModel
+	if err := saveFormat.Read(data, &s); err != nil {
+		return err
+	}
+	est, err := s.estimate()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.supportFraction, m.trials, m.ridge, m.seed = s.SupportFraction, s.Trials, s.Ridge, s.Seed
+	m.contamination, m.threshold = s.Contamination, s.Threshold
+	m.center, m.spread = s.Center, s.Spread
+	m.est = est
+	m.chi = distuv.ChiSquared{K: float64(len(s.Center))}
+	m.support = s.Support
+	m.importances = s.Importances
+	m.card = s.Card.ModelCard()
+	m.featureNames = m.card.FeatureNames
+	m.trained = true
+	return nil
+}
+
+// LoadFrom restores the robust location and covariance read from r.
+func (m *MCD) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.Load(data)
+}
+
+// estimate validates the saved estimate and recomputes its precision.
+func (s *savedModel) estimate() (estimate, error) {
+	p := len(s.Center)
+	switch {
+	case p == 0:
+		return estimate{}, errors.New("mcd: model has no features")
+	case len(s.Spread) != p || len(s.Location) != p || len(s.Covariance) != p*p:
+		return estimate{}, fmt.Errorf("mcd: estimate does not match %d features", p)
+	}
+	for j, v := range s.Spread {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return estimate{}, fmt.Errorf("mcd: feature %d: invalid spread %g", j, v)
+		}
+		if c, l := s.Center[j], s.Location[j]; math.IsNaN(c+l) || math.IsInf(c+l, 0) {
+			return estimate{}, fmt.Errorf("mcd: feature %d: invalid location", j)
+		}
+	}
+	cov := mat.NewSymDense(p, nil)
+	for i := 0; i < p; i++ {
+		for j := i; j < p; j++ {
+			cov.SetSym(i, j, s.Covariance[i*p+j])
+		}
+	}
+	est, ok := fromCovariance(s.Location, cov)
+	if !ok {
+		return estimate{}, errors.New("mcd: covariance is not positive definite")
+	}
+	return est, nil
+}
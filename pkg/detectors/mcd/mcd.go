@@ -0,0 +1,767 @@
+// Package mcd implements a robust covariance anomaly detector, in the
+// style of an elliptic envelope.
+//
+// Training fits one multivariate Gaussian to the data with the Minimum
+// Covariance Determinant estimator: the mean and covariance of the half of
+// the data that is most tightly concentrated, found with FastMCD, then
+// corrected for consistency and reweighted. Unlike the plain mean and
+// covariance, the estimate is not dragged toward the anomalies it should
+// detect. Samples score by their squared Mahalanobis distance to the
+// estimate, mapped through the chi-square distribution: a sample scoring
+// 0.99 is farther out than 99% of Gaussian data would be.
+//
+// Scoring costs one quadratic form per sample, far cheaper than a forest,
+// and the model suits data that is roughly Gaussian, such as most host
+// and service metrics. Multimodal data, such as traffic mixing distinct
+// services, is better served by the other detectors.
+package mcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// support fraction, trial count, regularization or contamination.
+var ErrInvalidOption = fmt.Errorf("mcd: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of samples scored between context checks.
+const scoreChunk = 4096
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// reweightQuantile is the chi-square quantile beyond which training rows
+// are left out of the reweighted estimate.
+const reweightQuantile = 0.975
+
+// MCD is a Minimum Covariance Determinant detector. It is safe for
+// concurrent use.
+type MCD struct {
+	mu sync.RWMutex
+
+	// Configuration
+	supportFraction float64
+	trials          int
+	ridge           float64
+	seed            int64
+	contamination   float64
+	threshold       float64
+	workers         int
+	explainTop      int
+	severity        *detectors.SeverityBands
+	onReject        detectors.RejectFunc
+	dataSource      string
+	featureNames    []string
+
+	// Trained model. Features are standardized by their median and median
+	// absolute deviation before estimation.
+	center  []float64
+	spread  []float64
+	est     estimate
+	chi     distuv.ChiSquared
+	support int
+
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures an MCD.
+type Option func(*MCD)
+
+// WithSupportFraction sets the fraction of the training data the raw
+// estimate is computed from. Zero, the default, uses the most robust
+// value, about half of the data. Higher values are more efficient on
+// clean data and tolerate fewer anomalies in it.
+func WithSupportFraction(f float64) Option {
+	return func(m *MCD) {
+		m.supportFraction = f
+	}
+}
+
+// WithTrials sets the number of random starts of the FastMCD search, 100
+// by default.
+func WithTrials(n int) Option {
+	return func(m *MCD) {
+		m.trials = n
+	}
+}
+
+// WithRegularization sets the ridge added to the variances of the
+// standardized features, 1e-6 by default, which keeps the covariance
+// invertible when features are constant or collinear.
+func WithRegularization(ridge float64) Option {
+	return func(m *MCD) {
+		m.ridge = ridge
+	}
+}
+
+// WithSeed sets the random seed of the FastMCD search, for reproducible
+// training.
+func WithSeed(seed int64) Option {
+	return func(m *MCD) {
+		m.seed = seed
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(m *MCD) {
+		m.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(m *MCD) {
+		m.workers = n
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(m *MCD) {
+		m.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(m *MCD) {
+		m.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new MCD.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(m *MCD) {
+		m.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(m *MCD) {
+		m.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(m *MCD) {
+		m.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained MCD with the given options.
+func New(opts ...Option) *MCD {
+	m := &MCD{
+		trials:        100,
+		ridge:         1e-6,
+		seed:          42,
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.workers = max(m.workers, 0)
+	m.explainTop = max(m.explainTop, 0)
+	return m
+}
+
+// Validate checks the support fraction, trial count, regularization and
+// contamination set by the options.
+func (m *MCD) Validate() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.validate()
+}
+
+func (m *MCD) validate() error {
+	var errs []error
+	if !(m.supportFraction >= 0 && m.supportFraction <= 1) {
+		errs = append(errs, fmt.Errorf("%w: WithSupportFraction(%g): must be in [0, 1]", ErrInvalidOption, m.supportFraction))
+	}
+	if m.trials < 1 {
+		errs = append(errs, fmt.Errorf("%w: WithTrials(%d): need at least one", ErrInvalidOption, m.trials))
+	}
+	if !(m.ridge >= 0) || math.IsInf(m.ridge, 0) {
+		errs = append(errs, fmt.Errorf("%w: WithRegularization(%g): must not be negative", ErrInvalidOption, m.ridge))
+	}
+	if !(m.contamination >= 0 && m.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, m.contamination))
+	}
+	if m.severity != nil {
+		if err := m.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit estimates the robust location and covariance of data. Features must
+// be finite and there must be more rows than features.
+func (m *MCD) Fit(data [][]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	p := len(data[0])
+	if p == 0 {
+		return errors.New("training data has no features")
+	}
+	if m.featureNames != nil && len(m.featureNames) != p {
+		return fmt.Errorf("%d feature names for %d features", len(m.featureNames), p)
+	}
+	if len(data) <= p {
+		return fmt.Errorf("%d training rows for %d features: need more rows than features", len(data), p)
+	}
+	for i, row := range data {
+		if len(row) != p {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), p)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	center, spread := robustScale(data)
+	x := make([][]float64, len(data))
+	for i, row := range data {
+		x[i] = make([]float64, p)
+		for j, v := range row {
+			x[i][j] = (v - center[j]) / spread[j]
+		}
+	}
+	n := len(x)
+	h := (n + p + 1) / 2
+	if m.supportFraction > 0 {
+		h = int(math.Ceil(m.supportFraction * float64(n)))
+	}
+	h = min(n, max(h, p+1))
+
+	workers := detectors.Workers(m.workers)
+	raw, ok := fastMCD(x, h, m.trials, workers, rand.New(rand.NewSource(m.seed)), m.ridge)
+	if !ok {
+		return errors.New("mcd: covariance is singular; raise WithRegularization")
+	}
+	chi := distuv.ChiSquared{K: float64(p)}
+
+	// Reestimate from the rows within the envelope of the raw estimate.
+	est := consistent(x, raw, chi, workers)
+	var inliers []int
+	cutoff := chi.Quantile(reweightQuantile)
+	for i, d := range distances(x, &est, workers) {
+		if d <= cutoff {
+			inliers = append(inliers, i)
+		}
+	}
+	if len(inliers) > p {
+		if reweighted, ok := newEstimate(x, inliers, m.ridge); ok {
+			// Gaussian data truncated at the cutoff has its covariance
+			// shrunk by a known factor.
+			truncated := distuv.ChiSquared{K: float64(p + 2)}.CDF(cutoff) / reweightQuantile
+			est = scaled(reweighted, 1/truncated)
+		}
+	}
+
+	m.center, m.spread = center, spread
+	m.est = est
+	m.chi = chi
+	m.support = len(inliers)
+	m.trained = true
+
+	// Score the training data once, for the threshold and the feature
+	// importances.
+	importances := make([]float64, p)
+	scores := make([]float64, n)
+	var mu sync.Mutex
+	detectors.ParallelFor(n, workers, scoreChunk, func(lo, hi int) {
+		z := make([]float64, p)
+		shares := make([]float64, p)
+		contributions := make([]float64, p)
+		for i := lo; i < hi; i++ {
+			d := m.contributions(data[i], z, contributions)
+			scores[i] = m.score(d)
+			for j, c := range contributions {
+				shares[j] += c
+			}
+		}
+		mu.Lock()
+		for j, s := range shares {
+			importances[j] += s
+		}
+		mu.Unlock()
+	})
+	m.importances = normalize(importances)
+
+	if m.contamination > 0 {
+		q := stats.NewQuantileEstimator(n, 100)
+		for _, s := range scores {
+			q.Add(s)
+		}
+		m.threshold = q.Quantile(1 - m.contamination)
+	}
+	m.card = m.modelCard(data)
+	return nil
+}
+
+// consistent returns e with its covariance scaled so the median squared
+// distance of the rows of x matches that of Gaussian data: the most
+// concentrated rows underestimate the spread otherwise.
+func consistent(x [][]float64, e estimate, chi distuv.ChiSquared, workers int) estimate {
+	return scaled(e, median(distances(x, &e, workers))/chi.Quantile(0.5))
+}
+
+// scaled returns e with its covariance scaled by factor.
+func scaled(e estimate, factor float64) estimate {
+	if !(factor > 0) || math.IsInf(factor, 0) {
+		return e
+	}
+	var cov mat.SymDense
+	cov.ScaleSym(factor, e.cov)
+	if corrected, ok := fromCovariance(e.loc, &cov); ok {
+		return corrected
+	}
+	return e
+}
+
+// robustScale returns the median and median absolute deviation of each
+// feature of data. Features with no deviation are scaled by 1.
+func robustScale(data [][]float64) (center, spread []float64) {
+	p := len(data[0])
+	center, spread = make([]float64, p), make([]float64, p)
+	column := make([]float64, len(data))
+	for j := 0; j < p; j++ {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		center[j] = median(column)
+		for i, row := range data {
+			column[i] = math.Abs(row[j] - center[j])
+		}
+		spread[j] = median(column)
+		if !(spread[j] > 0) {
+			spread[j] = 1
+		}
+	}
+	return center, spread
+}
+
+// median returns the median of values, reordering them.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// distances returns the squared distances of the rows of x to e.
+func distances(x [][]float64, e *estimate, workers int) []float64 {
+	dist := make([]float64, len(x))
+	detectors.ParallelFor(len(x), workers, scoreChunk, func(lo, hi int) {
+		z := make([]float64, len(e.loc))
+		for i := lo; i < hi; i++ {
+			dist[i] = e.dist(x[i], z)
+		}
+	})
+	return dist
+}
+
+// distance returns the squared Mahalanobis distance of sample, using z as
+// scratch space of the sample's length. Samples with features that are not
+// finite are infinitely far. The caller holds the read lock.
+func (m *MCD) distance(sample, z []float64) float64 {
+	for j, v := range sample {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return math.Inf(1)
+		}
+		z[j] = (v - m.center[j]) / m.spread[j]
+	}
+	return m.est.dist(z, z)
+}
+
+// contributions writes each feature's part of the squared distance of
+// sample to dst and returns the distance: its deviation from the location
+// times its row of the precision matrix times the deviations, or 0 where
+// that is negative. Features that are not finite take the whole distance.
+// The caller holds the read lock.
+func (m *MCD) contributions(sample, z, dst []float64) float64 {
+	p := len(sample)
+	finite := true
+	for j, v := range sample {
+		dst[j] = 0
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			dst[j] = math.Inf(1)
+			finite = false
+			continue
+		}
+		z[j] = (v-m.center[j])/m.spread[j] - m.est.loc[j]
+	}
+	if !finite {
+		return math.Inf(1)
+	}
+	var d float64
+	for i, zi := range z {
+		row := m.est.prec[i*p : (i+1)*p]
+		var s float64
+		for j, zj := range z {
+			s += row[j] * zj
+		}
+		d += zi * s
+		dst[i] = max(0, zi*s)
+	}
+	return d
+}
+
+// score maps a squared distance to the probability that a Gaussian sample
+// is nearer.
+func (m *MCD) score(dist float64) float64 {
+	return m.chi.CDF(dist)
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (m *MCD) Predict(data [][]float64) ([]float64, error) {
+	return m.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every few thousand
+// samples.
+func (m *MCD) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(m.center) {
+			return nil, fmt.Errorf("sample %d: %w", i, m.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(m.workers), scoreChunk, func(lo, hi int) {
+		z := make([]float64, len(m.center))
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			scores[i] = m.score(m.distance(data[i], z))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (m *MCD) PredictOne(sample []float64) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(m.center) {
+		return 0, m.dimensionError(sample)
+	}
+	return m.score(m.distance(sample, make([]float64, len(sample)))), nil
+}
+
+// Distance returns the Mahalanobis distance of sample to the robust
+// location, in standard deviations along the covariance.
+func (m *MCD) Distance(sample []float64) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(m.center) {
+		return 0, m.dimensionError(sample)
+	}
+	return math.Sqrt(m.distance(sample, make([]float64, len(sample)))), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (m *MCD) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(m.center)}
+}
+
+// PredictStream scores samples from input against the robust location and
+// covariance until input is closed.
+func (m *MCD) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	m.mu.RLock()
+	if !m.trained {
+		m.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := m.onReject
+	m.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, m.streamScore)
+}
+
+// streamScore scores a streamed sample against the robust location and
+// covariance.
+func (m *MCD) streamScore(sample []float64) (detectors.Score, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(sample) != len(m.center) {
+		return detectors.Score{}, m.dimensionError(sample)
+	}
+	score := m.score(m.distance(sample, make([]float64, len(sample))))
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= m.threshold,
+		Features:  sample,
+	}
+	if m.explainTop > 0 {
+		exp := m.explain(sample)
+		result.Explanation = &exp
+	}
+	if m.severity != nil {
+		result.Severity = m.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*MCD)(nil)
+	_ detectors.Thresholder    = (*MCD)(nil)
+	_ detectors.RejectReporter = (*MCD)(nil)
+	_ detectors.Explainer      = (*MCD)(nil)
+	_ detectors.Describer      = (*MCD)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the robust location and covariance to.
+func (m *MCD) SetRejectHandler(fn detectors.RejectFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onReject = fn
+}
+
+// FeatureImportances returns each feature's share of the squared
+// distances of the training data. It returns nil if the detector is not
+// trained.
+func (m *MCD) FeatureImportances() []float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// its squared distance. The typical range of each feature is the robust
+// location plus or minus two standard deviations.
+func (m *MCD) Explain(sample []float64) (detectors.Explanation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(m.center) {
+		return detectors.Explanation{}, m.dimensionError(sample)
+	}
+	return m.explain(sample), nil
+}
+
+// explain explains a sample of the right width. The caller holds the read
+// lock.
+func (m *MCD) explain(sample []float64) detectors.Explanation {
+	contributions := make([]float64, len(sample))
+	dist := m.contributions(sample, make([]float64, len(sample)), contributions)
+	normalize(contributions)
+
+	loc, cov := m.location(), m.covariance()
+	typical := make([]detectors.Range, len(sample))
+	for j := range typical {
+		sd := math.Sqrt(cov[j][j])
+		typical[j] = detectors.Range{Low: loc[j] - 2*sd, High: loc[j] + 2*sd}
+	}
+	topK := m.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         m.score(dist),
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, typical, topK),
+	}
+}
+
+// Location returns the robust location of the training data, nil if the
+// detector is not trained.
+func (m *MCD) Location() []float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.trained {
+		return nil
+	}
+	return m.location()
+}
+
+// Covariance returns the robust covariance matrix of the training data,
+// nil if the detector is not trained.
+func (m *MCD) Covariance() [][]float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.trained {
+		return nil
+	}
+	return m.covariance()
+}
+
+// location returns the location in the units of the features. The caller
+// holds the read lock.
+func (m *MCD) location() []float64 {
+	loc := make([]float64, len(m.center))
+	for j, v := range m.est.loc {
+		loc[j] = m.center[j] + m.spread[j]*v
+	}
+	return loc
+}
+
+// covariance returns the covariance in the units of the features. The
+// caller holds the read lock.
+func (m *MCD) covariance() [][]float64 {
+	p := len(m.center)
+	cov := make([][]float64, p)
+	for i := range cov {
+		cov[i] = make([]float64, p)
+		for j := range cov[i] {
+			cov[i][j] = m.est.cov.At(i, j) * m.spread[i] * m.spread[j]
+		}
+	}
+	return cov
+}
+
+// Support returns the number of training rows within the envelope, which
+// the final estimate was computed from.
+func (m *MCD) Support() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.support
+}
+
+// Metadata returns the model card recorded by Fit.
+func (m *MCD) Metadata() detectors.ModelCard {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	card := m.card
+	card.FeatureNames = slices.Clone(m.card.FeatureNames)
+	if m.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(m.card.Hyperparameters))
+		for k, v := range m.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (m *MCD) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   m.dataSource,
+		Rows:         len(data),
+		Features:     len(m.center),
+		FeatureNames: slices.Clone(m.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":        "mcd",
+			"support_fraction": strconv.FormatFloat(m.supportFraction, 'g', -1, 64),
+			"trials":           strconv.Itoa(m.trials),
+			"regularization":   strconv.FormatFloat(m.ridge, 'g', -1, 64),
+			"seed":             strconv.FormatInt(m.seed, 10),
+			"contamination":    strconv.FormatFloat(m.contamination, 'g', -1, 64),
+			"threshold":        strconv.FormatFloat(m.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (m *MCD) Trained() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (m *MCD) Threshold() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (m *MCD) SetThreshold(t float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.threshold = t
+}
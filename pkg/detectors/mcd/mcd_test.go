@@ -0,0 +1,165 @@
+package mcd
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// metricData returns n samples of three Gaussian metrics: CPU around 40,
+// memory correlated with it, and latency in milliseconds.
+func metricData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		cpu := 40 + 10*rng.NormFloat64()
+		data[i] = []float64{cpu, 2*cpu + 5*rng.NormFloat64(), 200 + 30*rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	detectortest.Suite[*MCD, Option]{
+		Algorithm:        "mcd",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		WithExplanations: WithExplanations,
+		Configured:       []Option{WithSupportFraction(0.75), WithSeed(3)},
+		Invalid:          []Option{WithSupportFraction(2), WithTrials(0), WithRegularization(-1), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            metricData(1000, 6),
+		Probe:            append(metricData(20, 7), []float64{40, 80, 400}),
+		LargeValues:      true,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	m := New()
+	require.NoError(t, m.Fit(metricData(2000, 1)))
+
+	scores, err := m.Predict([][]float64{
+		{40, 80, 200},
+		{50, 100, 230},
+		// Each value is common, but the memory does not match the CPU.
+		{25, 110, 200},
+		{40, 80, 400},
+		{40, 80, math.NaN()},
+	})
+	require.NoError(t, err)
+	assert.Less(t, scores[0], m.Threshold())
+	assert.Less(t, scores[1], m.Threshold())
+	for i, s := range scores[2:] {
+		assert.Greater(t, s, 0.999, "sample %d", i+2)
+	}
+	assert.Equal(t, 1.0, scores[4])
+
+	// Contamination sets the threshold to flag about 10% of training.
+	trainScores, err := m.Predict(metricData(2000, 2))
+	require.NoError(t, err)
+	flagged := 0
+	for _, s := range trainScores {
+		if s >= m.Threshold() {
+			flagged++
+		}
+	}
+	assert.InDelta(t, 200, flagged, 50)
+	// Scores follow the chi-square distribution on Gaussian data.
+	assert.InDelta(t, 0.9, m.Threshold(), 0.02)
+
+	one, err := m.PredictOne([]float64{25, 110, 200})
+	require.NoError(t, err)
+	assert.Equal(t, scores[2], one)
+
+	d, err := m.Distance([]float64{40, 80, 200})
+	require.NoError(t, err)
+	assert.Less(t, d, 1.0)
+}
+
+func TestRobustToOutliers(t *testing.T) {
+	data := metricData(1000, 3)
+	// A fifth of the training data is a far cluster.
+	for i := 0; i < 200; i++ {
+		data[i] = []float64{95, 50, 900}
+	}
+	m := New()
+	require.NoError(t, m.Fit(data))
+
+	loc := m.Location()
+	assert.InDelta(t, 40, loc[0], 2)
+	assert.InDelta(t, 80, loc[1], 4)
+	assert.InDelta(t, 200, loc[2], 6)
+	cov := m.Covariance()
+	assert.InDelta(t, 100, cov[0][0], 25)
+	assert.InDelta(t, 200, cov[0][1], 50, "the correlation of CPU and memory is kept")
+	assert.InDelta(t, 800, m.Support(), 30)
+
+	score, err := m.PredictOne([]float64{95, 50, 900})
+	require.NoError(t, err)
+	assert.Greater(t, score, 0.999, "the cluster does not mask itself")
+}
+
+func TestConstantFeature(t *testing.T) {
+	data := metricData(500, 4)
+	for _, row := range data {
+		row[2] = 7
+	}
+	m := New()
+	require.NoError(t, m.Fit(data))
+	normal, err := m.PredictOne([]float64{40, 80, 7})
+	require.NoError(t, err)
+	other, err := m.PredictOne([]float64{40, 80, 8})
+	require.NoError(t, err)
+	assert.Less(t, normal, m.Threshold())
+	assert.Greater(t, other, m.Threshold(), "any other value of a constant feature is rare")
+}
+
+func TestErrors(t *testing.T) {
+	m := New()
+	assert.Nil(t, m.Location())
+	assert.Error(t, m.Fit([][]float64{{1, 2}, {3, 4}}), "need more rows than features")
+}
+
+func TestExplain(t *testing.T) {
+	m := New()
+	require.NoError(t, m.Fit(metricData(1000, 6)))
+
+	sample := []float64{40, 80, 400}
+	exp, err := m.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 2, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.InDelta(t, 140, exp.Top[0].Typical.Low, 15)
+	assert.InDelta(t, 260, exp.Top[0].Typical.High, 15)
+	score, err := m.PredictOne(sample)
+	require.NoError(t, err)
+	assert.InDelta(t, score, exp.Score, 1e-12)
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := metricData(10000, 1)
+	m := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Fit(data)
+	}
+}
+
+func BenchmarkPredictOne(b *testing.B) {
+	m := New()
+	m.Fit(metricData(5000, 1))
+	sample := []float64{45, 90, 210}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.PredictOne(sample)
+	}
+}
@@ -0,0 +1,85 @@
+package detectors
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"runtime/debug"
+	"time"
+)
+
+// modulePath is the import path of this library, used to find its version
+// in the build information of the running binary.
+const modulePath = "github.com/hed1ad/goguardml"
+
+// ModelCard describes how a model was trained. Detectors store it with the
+// model so saved files can be traced back to their training run.
+type ModelCard struct {
+	// TrainedAt is when Fit completed.
+	TrainedAt time.Time `json:"trained_at"`
+	// DataSource describes the training data, e.g. a file name or query.
+	DataSource string `json:"data_source,omitempty"`
+	// Rows is the number of training samples.
+	Rows int `json:"rows"`
+	// Features is the number of features per sample.
+	Features int `json:"features"`
+	// FeatureNames names the features, if known.
+	FeatureNames []string `json:"feature_names,omitempty"`
+	// Hyperparameters holds the detector configuration used for training.
+	Hyperparameters map[string]string `json:"hyperparameters,omitempty"`
+	// LibraryVersion is the goguardml version that trained the model.
+	LibraryVersion string `json:"library_version"`
+	// DataHash is the SHA-256 of the training data, see HashData.
+	DataHash string `json:"data_hash"`
+}
+
+// Describer is implemented by detectors that carry a model card.
+type Describer interface {
+	// Metadata returns the model card. It is the zero value for untrained
+	// models and models saved before cards were recorded.
+	Metadata() ModelCard
+}
+
+// MetadataOf returns the model card of d, if it implements Describer.
+func MetadataOf(d Detector) (ModelCard, bool) {
+	if desc, ok := d.(Describer); ok {
+		return desc.Metadata(), true
+	}
+	return ModelCard{}, false
+}
+
+// HashData returns the hex SHA-256 of data. Each row is hashed as its
+// length followed by its values' IEEE 754 bits, little-endian, so the hash
+// is stable across hosts and distinguishes different row splits.
+func HashData(data [][]float64) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, row := range data {
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(row)))
+		h.Write(buf[:])
+		for _, v := range row {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LibraryVersion returns the goguardml module version compiled into the
+// running binary, or "(devel)" when it cannot be determined.
+func LibraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
@@ -0,0 +1,38 @@
+package detectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashData(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  [][]float64
+		equal bool
+	}{
+		{name: "same data", a: [][]float64{{1, 2}, {3, 4}}, b: [][]float64{{1, 2}, {3, 4}}, equal: true},
+		{name: "different value", a: [][]float64{{1, 2}, {3, 4}}, b: [][]float64{{1, 2}, {3, 5}}},
+		{name: "different row order", a: [][]float64{{1, 2}, {3, 4}}, b: [][]float64{{3, 4}, {1, 2}}},
+		{name: "different row split", a: [][]float64{{1, 2}, {3, 4}}, b: [][]float64{{1}, {2, 3, 4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := HashData(tt.a), HashData(tt.b)
+			assert.Len(t, a, 64)
+			if tt.equal {
+				assert.Equal(t, a, b)
+			} else {
+				assert.NotEqual(t, a, b)
+			}
+		})
+	}
+}
+
+func TestMetadataOf(t *testing.T) {
+	_, ok := MetadataOf(constant{})
+	assert.False(t, ok)
+	assert.NotEmpty(t, LibraryVersion())
+}
@@ -0,0 +1,59 @@
+package detectors
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultWorkers holds the SetDefaultWorkers override; 0 means automatic.
+var defaultWorkers atomic.Int64
+
+// DefaultWorkers returns the number of goroutines detectors use for
+// training and scoring when not configured per detector: the value set
+// with SetDefaultWorkers, or runtime.GOMAXPROCS otherwise.
+func DefaultWorkers() int {
+	if n := defaultWorkers.Load(); n > 0 {
+		return int(n)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// SetDefaultWorkers sets the package-wide worker count. n <= 0 restores
+// the automatic GOMAXPROCS-based default.
+func SetDefaultWorkers(n int) {
+	defaultWorkers.Store(int64(max(n, 0)))
+}
+
+// Workers resolves a per-detector worker setting: n itself if positive,
+// DefaultWorkers otherwise.
+func Workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return DefaultWorkers()
+}
+
+// ParallelFor calls fn on consecutive ranges [lo, hi) covering [0, n),
+// using up to workers goroutines. Ranges hold at least minChunk items, so
+// small inputs run on the calling goroutine without any overhead.
+func ParallelFor(n, workers, minChunk int, fn func(lo, hi int)) {
+	chunks := min(workers, n/max(minChunk, 1))
+	if chunks <= 1 {
+		if n > 0 {
+			fn(0, n)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(chunks)
+	for c := 0; c < chunks; c++ {
+		lo, hi := n*c/chunks, n*(c+1)/chunks
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,68 @@
+package detectors
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkers(t *testing.T) {
+	t.Cleanup(func() { SetDefaultWorkers(0) })
+
+	assert.Equal(t, runtime.GOMAXPROCS(0), DefaultWorkers())
+	assert.Equal(t, 3, Workers(3))
+	assert.Equal(t, DefaultWorkers(), Workers(0))
+
+	SetDefaultWorkers(5)
+	assert.Equal(t, 5, DefaultWorkers())
+	assert.Equal(t, 5, Workers(-1))
+	assert.Equal(t, 2, Workers(2), "per-detector settings win")
+
+	SetDefaultWorkers(-1)
+	assert.Equal(t, runtime.GOMAXPROCS(0), DefaultWorkers())
+}
+
+func TestParallelFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		n          int
+		workers    int
+		minChunk   int
+		wantRanges int
+	}{
+		{name: "empty", n: 0, workers: 4, minChunk: 1, wantRanges: 0},
+		{name: "below min chunk", n: 10, workers: 4, minChunk: 16, wantRanges: 1},
+		{name: "one worker", n: 100, workers: 1, minChunk: 1, wantRanges: 1},
+		{name: "limited by chunk", n: 100, workers: 8, minChunk: 30, wantRanges: 3},
+		{name: "limited by workers", n: 100, workers: 4, minChunk: 1, wantRanges: 4},
+		{name: "zero min chunk", n: 5, workers: 8, minChunk: 0, wantRanges: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				ranges int
+			)
+			seen := make([]int, tt.n)
+			ParallelFor(tt.n, tt.workers, tt.minChunk, func(lo, hi int) {
+				mu.Lock()
+				defer mu.Unlock()
+				ranges++
+				if tt.wantRanges > 1 {
+					assert.GreaterOrEqual(t, hi-lo, tt.minChunk)
+				}
+				for i := lo; i < hi; i++ {
+					seen[i]++
+				}
+			})
+
+			assert.Equal(t, tt.wantRanges, ranges)
+			for i, c := range seen {
+				assert.Equal(t, 1, c, "index %d", i)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package detectors_test
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// portable are the packages that must build for WebAssembly, with Go and
+// TinyGo, along with everything in this module they import.
+var portable = []string{
+	"pkg/detectors",
+	"pkg/detectors/iforest",
+	"pkg/detectors/hbos",
+	"pkg/detectors/knn",
+	"pkg/detectors/copod",
+	"pkg/detectors/eif",
+	"pkg/detectors/zscore",
+	"pkg/detectors/iqr",
+	"pkg/detectors/dbscan",
+	"pkg/detectors/matrixprofile",
+	"pkg/detectors/holtwinters",
+	"pkg/detectors/entropy",
+	"pkg/stats",
+	"pkg/data",
+}
+
+// unportable are the imports WebAssembly targets lack or TinyGo does not
+// support.
+var unportable = map[string]bool{
+	"C":           true,
+	"net":         true,
+	"net/http":    true,
+	"os/exec":     true,
+	"os/signal":   true,
+	"plugin":      true,
+	"runtime/cgo": true,
+}
+
+func TestPortableToWasm(t *testing.T) {
+	const module = "github.com/hed1ad/goguardml/"
+	root, err := filepath.Abs("../..")
+	require.NoError(t, err)
+
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled = "js", "wasm", false
+
+	seen := map[string]bool{}
+	queue := append([]string(nil), portable...)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		pkg, err := ctx.ImportDir(filepath.Join(root, dir), 0)
+		require.NoError(t, err, dir)
+		assert.Empty(t, pkg.CgoFiles, "%s uses cgo", dir)
+		for _, imp := range pkg.Imports {
+			assert.False(t, unportable[imp], "%s imports %s", dir, imp)
+			if rel, ok := strings.CutPrefix(imp, module); ok {
+				queue = append(queue, rel)
+			}
+		}
+	}
+}
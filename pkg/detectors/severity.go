@@ -0,0 +1,145 @@
+package detectors
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// Severity grades how urgent an anomaly score is, for routing alerts
+// beyond the binary IsAnomaly.
+type Severity uint8
+
+// Severities from least to most urgent. SeverityNone marks scores that
+// were not graded.
+const (
+	SeverityNone Severity = iota
+	SeverityInfo
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = [...]string{"", "info", "low", "medium", "high", "critical"}
+
+// String returns the name of s, "" for SeverityNone.
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseSeverity returns the severity named name, case-insensitively.
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severityNames {
+		if n != "" && strings.EqualFold(n, name) {
+			return Severity(s), nil
+		}
+	}
+	return SeverityNone, fmt.Errorf("unknown severity %q (want info, low, medium, high or critical)", name)
+}
+
+// MarshalText encodes s as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name; empty text is SeverityNone.
+func (s *Severity) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*s = SeverityNone
+		return nil
+	}
+	v, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// SeverityBands grades scores by the lowest score of each band: a score
+// of at least Critical is critical, of at least High high, and so on down
+// to info below Low. Bounds must not decrease.
+type SeverityBands struct {
+	Low      float64 `json:"low"`
+	Medium   float64 `json:"medium"`
+	High     float64 `json:"high"`
+	Critical float64 `json:"critical"`
+}
+
+// ErrInvalidBands is returned for severity bands with decreasing bounds.
+var ErrInvalidBands = errors.New("severity bands must not decrease")
+
+// Validate returns ErrInvalidBands if the bounds decrease.
+func (b SeverityBands) Validate() error {
+	if b.Low > b.Medium || b.Medium > b.High || b.High > b.Critical {
+		return fmt.Errorf("%w: %g, %g, %g, %g", ErrInvalidBands, b.Low, b.Medium, b.High, b.Critical)
+	}
+	return nil
+}
+
+// Grade returns the severity of score.
+func (b SeverityBands) Grade(score float64) Severity {
+	switch {
+	case score >= b.Critical:
+		return SeverityCritical
+	case score >= b.High:
+		return SeverityHigh
+	case score >= b.Medium:
+		return SeverityMedium
+	case score >= b.Low:
+		return SeverityLow
+	default:
+		return SeverityInfo
+	}
+}
+
+// PercentileBands returns the bands whose bounds are the given quantiles,
+// in [0, 1], of reference scores, such as the training or calibration
+// scores of a detector. With quantiles 0.9, 0.99, 0.999 and 0.9999, one
+// reference score in ten is at least low and one in ten thousand
+// critical.
+func PercentileBands(scores []float64, low, medium, high, critical float64) (SeverityBands, error) {
+	if len(scores) == 0 {
+		return SeverityBands{}, errors.New("percentile bands need reference scores")
+	}
+	qs := []float64{low, medium, high, critical}
+	for i, q := range qs {
+		if q < 0 || q > 1 || (i > 0 && q < qs[i-1]) {
+			return SeverityBands{}, fmt.Errorf("%w: quantiles %g, %g, %g, %g", ErrInvalidBands, low, medium, high, critical)
+		}
+	}
+	est := stats.NewQuantileEstimator(len(scores), 0)
+	est.AddAll(scores)
+	return SeverityBands{
+		Low:      est.Quantile(low),
+		Medium:   est.Quantile(medium),
+		High:     est.Quantile(high),
+		Critical: est.Quantile(critical),
+	}, nil
+}
+
+// ParseSeverityBands parses the four bounds of severity bands, low to
+// critical, separated by commas, such as "0.55,0.6,0.7,0.8".
+func ParseSeverityBands(spec string) (SeverityBands, error) {
+	fields := strings.Split(spec, ",")
+	if len(fields) != 4 {
+		return SeverityBands{}, fmt.Errorf("severity bands %q: want 4 comma-separated bounds, low to critical", spec)
+	}
+	var v [4]float64
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return SeverityBands{}, fmt.Errorf("severity bands %q: %w", spec, err)
+		}
+		v[i] = x
+	}
+	b := SeverityBands{Low: v[0], Medium: v[1], High: v[2], Critical: v[3]}
+	return b, b.Validate()
+}
@@ -0,0 +1,68 @@
+package detectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverityBandsGrade(t *testing.T) {
+	b := SeverityBands{Low: 0.5, Medium: 0.6, High: 0.7, Critical: 0.8}
+	require.NoError(t, b.Validate())
+	for score, want := range map[float64]Severity{
+		0.1: SeverityInfo, 0.5: SeverityLow, 0.65: SeverityMedium, 0.7: SeverityHigh, 0.8: SeverityCritical, 1: SeverityCritical,
+	} {
+		assert.Equal(t, want, b.Grade(score), "score %g", score)
+	}
+	assert.ErrorIs(t, SeverityBands{Low: 0.6, Medium: 0.5}.Validate(), ErrInvalidBands)
+}
+
+func TestPercentileBands(t *testing.T) {
+	scores := make([]float64, 1000)
+	for i := range scores {
+		scores[i] = float64(i) / 1000
+	}
+	b, err := PercentileBands(scores, 0.5, 0.9, 0.99, 0.999)
+	require.NoError(t, err)
+	assert.Equal(t, SeverityBands{Low: 0.499, Medium: 0.899, High: 0.989, Critical: 0.998}, b)
+
+	_, err = PercentileBands(scores, 0.9, 0.5, 0.99, 0.999)
+	assert.ErrorIs(t, err, ErrInvalidBands)
+	_, err = PercentileBands(nil, 0.5, 0.9, 0.99, 0.999)
+	assert.Error(t, err)
+}
+
+func TestParseSeverityBands(t *testing.T) {
+	b, err := ParseSeverityBands("0.55, 0.6,0.7,0.8")
+	require.NoError(t, err)
+	assert.Equal(t, SeverityBands{Low: 0.55, Medium: 0.6, High: 0.7, Critical: 0.8}, b)
+	_, err = ParseSeverityBands("0.5,0.6")
+	assert.Error(t, err)
+	_, err = ParseSeverityBands("0.5,0.6,x,0.8")
+	assert.Error(t, err)
+	_, err = ParseSeverityBands("0.9,0.6,0.7,0.8")
+	assert.ErrorIs(t, err, ErrInvalidBands)
+}
+
+func TestSeverityText(t *testing.T) {
+	s, err := ParseSeverity("HIGH")
+	require.NoError(t, err)
+	assert.Equal(t, SeverityHigh, s)
+	_, err = ParseSeverity("urgent")
+	assert.Error(t, err)
+
+	b, err := json.Marshal(struct {
+		S Severity `json:"s"`
+	}{SeverityCritical})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"s":"critical"}`, string(b))
+
+	var v struct {
+		S Severity `json:"s"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"s":"low"}`), &v))
+	assert.Equal(t, SeverityLow, v.S)
+	assert.Error(t, json.Unmarshal([]byte(`{"s":"urgent"}`), &v))
+}
@@ -0,0 +1,243 @@
+package detectors
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// ShadowStats compares the scores of a shadow detector with those of the
+// live detector on the same samples.
+type ShadowStats struct {
+	// Samples is the number of samples both detectors scored.
+	Samples uint64 `json:"samples"`
+	// Agreements is the number of samples both flagged or both passed.
+	Agreements uint64 `json:"agreements"`
+	// BothAnomalous is the number of samples both flagged.
+	BothAnomalous uint64 `json:"both_anomalous"`
+	// LiveOnly is the number of samples only the live detector flagged.
+	LiveOnly uint64 `json:"live_only"`
+	// ShadowOnly is the number of samples only the shadow detector
+	// flagged.
+	ShadowOnly uint64 `json:"shadow_only"`
+	// Errors is the number of samples the shadow detector could not score.
+	Errors uint64 `json:"errors"`
+	// MeanAbsDiff and MaxAbsDiff summarize the absolute differences of
+	// the scores.
+	MeanAbsDiff float64 `json:"mean_abs_diff"`
+	MaxAbsDiff  float64 `json:"max_abs_diff"`
+	// Correlation is the Pearson correlation of the scores, 0 until both
+	// vary.
+	Correlation float64 `json:"correlation"`
+}
+
+// AgreementRate returns the share of samples the detectors agreed on, 1
+// before any sample.
+func (s ShadowStats) AgreementRate() float64 {
+	if s.Samples == 0 {
+		return 1
+	}
+	return float64(s.Agreements) / float64(s.Samples)
+}
+
+// Divergence is a sample the live and shadow detectors disagreed on.
+type Divergence struct {
+	Features []float64
+	Live     Score
+	// Shadow is the shadow detector's score; its Features is nil.
+	Shadow Score
+}
+
+// ShadowOption configures a Shadow.
+type ShadowOption func(*Shadow)
+
+// WithShadowThreshold overrides the shadow detector's own threshold, to
+// compare thresholds as well as models.
+func WithShadowThreshold(t float64) ShadowOption {
+	return func(s *Shadow) {
+		s.threshold = t
+		s.useModel = false
+	}
+}
+
+// WithDivergenceHandler sets a function called with every sample the
+// detectors disagreed on. It runs on the streaming goroutine before the
+// live score is emitted, so the sample is still valid, and must not keep
+// it.
+func WithDivergenceHandler(fn func(Divergence)) ShadowOption {
+	return func(s *Shadow) {
+		s.onDivergence = fn
+	}
+}
+
+// Shadow is a live StreamDetector with a shadow detector attached, for
+// validating a candidate model on production traffic before cutting over
+// to it. It behaves as the live detector; in PredictStream the shadow
+// detector scores every sample the live detector scored as well, and
+// only the comparison of the two is recorded. Shadow scores are never
+// emitted.
+//
+// The shadow detector scores each sample before its live score is
+// emitted, since pooled samples may be recycled once it is consumed, so
+// a slow shadow detector slows the stream down.
+type Shadow struct {
+	StreamDetector
+	shadow Detector
+
+	threshold    float64
+	useModel     bool
+	onDivergence func(Divergence)
+
+	mu    sync.Mutex
+	stats ShadowStats
+	// Running sums for the score correlation and differences.
+	sumLive, sumShadow, sumLive2, sumShadow2, sumProduct, sumAbsDiff float64
+}
+
+// NewShadow attaches the trained shadow detector to the live one.
+func NewShadow(live StreamDetector, shadow Detector, opts ...ShadowOption) *Shadow {
+	s := &Shadow{StreamDetector: live, shadow: shadow, useModel: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Live returns the live detector.
+func (s *Shadow) Live() StreamDetector {
+	return s.StreamDetector
+}
+
+// ShadowDetector returns the shadow detector.
+func (s *Shadow) ShadowDetector() Detector {
+	return s.shadow
+}
+
+func (s *Shadow) shadowThreshold() float64 {
+	if s.useModel {
+		return ThresholdOf(s.shadow)
+	}
+	return s.threshold
+}
+
+// PredictStream streams the live detector's scores, scoring every sample
+// with the shadow detector too. The output channel is closed when
+// PredictStream returns.
+func (s *Shadow) PredictStream(ctx context.Context, input <-chan []float64, output chan<- Score) error {
+	defer close(output)
+
+	live := make(chan Score, cap(output))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.StreamDetector.PredictStream(ctx, input, live)
+	}()
+
+	for score := range live {
+		s.compare(score)
+		select {
+		case output <- score:
+		case <-ctx.Done():
+			// Let the live stream wind down before returning.
+			for range live {
+			}
+			<-errCh
+			return ctx.Err()
+		}
+	}
+	return <-errCh
+}
+
+// compare scores the sample of a live score with the shadow detector and
+// records the outcome.
+func (s *Shadow) compare(live Score) {
+	v, err := s.shadow.PredictOne(live.Features)
+	if err != nil {
+		s.mu.Lock()
+		s.stats.Errors++
+		s.mu.Unlock()
+		return
+	}
+	shadow := Score{Value: v, IsAnomaly: v >= s.shadowThreshold()}
+
+	s.mu.Lock()
+	st := &s.stats
+	st.Samples++
+	switch {
+	case live.IsAnomaly && shadow.IsAnomaly:
+		st.Agreements++
+		st.BothAnomalous++
+	case live.IsAnomaly:
+		st.LiveOnly++
+	case shadow.IsAnomaly:
+		st.ShadowOnly++
+	default:
+		st.Agreements++
+	}
+	diff := math.Abs(live.Value - v)
+	st.MaxAbsDiff = math.Max(st.MaxAbsDiff, diff)
+	s.sumAbsDiff += diff
+	s.sumLive += live.Value
+	s.sumShadow += v
+	s.sumLive2 += live.Value * live.Value
+	s.sumShadow2 += v * v
+	s.sumProduct += live.Value * v
+	s.mu.Unlock()
+
+	if live.IsAnomaly != shadow.IsAnomaly && s.onDivergence != nil {
+		s.onDivergence(Divergence{Features: live.Features, Live: live, Shadow: shadow})
+	}
+}
+
+// Stats returns a snapshot of the comparison so far. It is safe to call
+// while streaming.
+func (s *Shadow) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	if st.Samples == 0 {
+		return st
+	}
+	n := float64(st.Samples)
+	st.MeanAbsDiff = s.sumAbsDiff / n
+	covariance := s.sumProduct/n - (s.sumLive/n)*(s.sumShadow/n)
+	varLive := s.sumLive2/n - (s.sumLive/n)*(s.sumLive/n)
+	varShadow := s.sumShadow2/n - (s.sumShadow/n)*(s.sumShadow/n)
+	if varLive > 0 && varShadow > 0 {
+		st.Correlation = math.Max(-1, math.Min(1, covariance/math.Sqrt(varLive*varShadow)))
+	}
+	return st
+}
+
+// ResetStats clears the comparison, for instance after changing the
+// shadow detector's threshold.
+func (s *Shadow) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = ShadowStats{}
+	s.sumLive, s.sumShadow, s.sumLive2, s.sumShadow2, s.sumProduct, s.sumAbsDiff = 0, 0, 0, 0, 0, 0
+}
+
+var _ RejectReporter = (*Shadow)(nil)
+
+// SetRejectHandler sets the reject handler of the live detector, if it
+// reports rejected samples.
+func (s *Shadow) SetRejectHandler(fn RejectFunc) {
+	if r, ok := s.StreamDetector.(RejectReporter); ok {
+		r.SetRejectHandler(fn)
+	}
+}
+
+var _ Thresholder = (*Shadow)(nil)
+
+// Threshold returns the live detector's threshold.
+func (s *Shadow) Threshold() float64 {
+	return ThresholdOf(s.StreamDetector)
+}
+
+// SetThreshold sets the live detector's threshold, if it has an
+// adjustable one.
+func (s *Shadow) SetThreshold(t float64) {
+	if th, ok := s.StreamDetector.(Thresholder); ok {
+		th.SetThreshold(t)
+	}
+}
@@ -0,0 +1,174 @@
+package detectors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linear scores a sample with its first feature times scale, and rejects
+// samples without features.
+type linear struct {
+	scale     float64
+	threshold float64
+	onReject  RejectFunc
+}
+
+func (l *linear) Fit([][]float64) error { return nil }
+func (l *linear) Predict(data [][]float64) ([]float64, error) {
+	scores := make([]float64, len(data))
+	for i, sample := range data {
+		v, err := l.PredictOne(sample)
+		if err != nil {
+			return nil, err
+		}
+		scores[i] = v
+	}
+	return scores, nil
+}
+func (l *linear) PredictOne(sample []float64) (float64, error) {
+	if len(sample) == 0 {
+		return 0, errors.New("empty sample")
+	}
+	return sample[0] * l.scale, nil
+}
+func (l *linear) Save() ([]byte, error)          { return nil, nil }
+func (l *linear) Load([]byte) error              { return nil }
+func (l *linear) SaveTo(io.Writer) error         { return nil }
+func (l *linear) LoadFrom(io.Reader) error       { return nil }
+func (l *linear) Threshold() float64             { return l.threshold }
+func (l *linear) SetThreshold(t float64)         { l.threshold = t }
+func (l *linear) SetRejectHandler(fn RejectFunc) { l.onReject = fn }
+func (l *linear) PredictStream(ctx context.Context, input <-chan []float64, output chan<- Score) error {
+	defer close(output)
+	for {
+		var sample []float64
+		select {
+		case s, ok := <-input:
+			if !ok {
+				return nil
+			}
+			sample = s
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		v, err := l.PredictOne(sample)
+		if err != nil {
+			if l.onReject != nil {
+				l.onReject(Rejection{Sample: sample, Err: err})
+			}
+			continue
+		}
+		select {
+		case output <- Score{Value: v, IsAnomaly: v >= l.threshold, Features: sample}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *linear) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.Predict(data)
+}
+
+func stream(t *testing.T, d StreamDetector, samples ...[]float64) []Score {
+	t.Helper()
+	input := make(chan []float64, len(samples))
+	for _, s := range samples {
+		input <- s
+	}
+	close(input)
+	output := make(chan Score, len(samples))
+	require.NoError(t, d.PredictStream(context.Background(), input, output))
+	var scores []Score
+	for s := range output {
+		scores = append(scores, s)
+	}
+	return scores
+}
+
+func TestShadow(t *testing.T) {
+	live := &linear{scale: 1, threshold: 0.5}
+	var divergences []Divergence
+	s := NewShadow(live, &linear{scale: 0.5, threshold: 0.3}, WithDivergenceHandler(func(d Divergence) {
+		divergences = append(divergences, d)
+	}))
+	var rejected int
+	s.SetRejectHandler(func(Rejection) { rejected++ })
+
+	scores := stream(t, s, []float64{0.2}, []float64{0.7}, []float64{0.9}, []float64{}, []float64{0.55})
+	require.Len(t, scores, 4)
+	for i, want := range []float64{0.2, 0.7, 0.9, 0.55} {
+		assert.Equal(t, want, scores[i].Value, "live scores are emitted unchanged")
+	}
+	assert.Equal(t, 1, rejected, "rejections go to the live detector's handler")
+
+	st := s.Stats()
+	assert.Equal(t, uint64(4), st.Samples)
+	assert.Equal(t, uint64(3), st.Agreements)
+	assert.Equal(t, uint64(2), st.BothAnomalous, "0.7 and 0.9")
+	assert.Equal(t, uint64(1), st.LiveOnly, "0.55 scores 0.275 in the shadow")
+	assert.Zero(t, st.ShadowOnly)
+	assert.InDelta(t, 0.75, st.AgreementRate(), 1e-12)
+	assert.InDelta(t, 0.45, st.MaxAbsDiff, 1e-12)
+	assert.InDelta(t, (0.1+0.35+0.45+0.275)/4, st.MeanAbsDiff, 1e-12)
+	assert.InDelta(t, 1, st.Correlation, 1e-9, "the shadow scores are proportional")
+
+	require.Len(t, divergences, 1)
+	assert.Equal(t, []float64{0.55}, divergences[0].Features)
+	assert.True(t, divergences[0].Live.IsAnomaly)
+	assert.False(t, divergences[0].Shadow.IsAnomaly)
+
+	s.ResetStats()
+	assert.Equal(t, ShadowStats{}, s.Stats())
+	assert.Equal(t, 1.0, s.Stats().AgreementRate())
+}
+
+func TestShadowThreshold(t *testing.T) {
+	live := &linear{scale: 1, threshold: 0.5}
+	s := NewShadow(live, &linear{scale: 1, threshold: 0.5}, WithShadowThreshold(0.1))
+	stream(t, s, []float64{0.2}, []float64{0.6})
+	st := s.Stats()
+	assert.Equal(t, uint64(1), st.ShadowOnly)
+	assert.Equal(t, uint64(1), st.BothAnomalous)
+
+	assert.Equal(t, 0.5, ThresholdOf(s))
+	s.SetThreshold(0.8)
+	assert.Equal(t, 0.8, live.threshold, "thresholds are the live detector's")
+	assert.Same(t, live, s.Live())
+}
+
+func TestShadowErrors(t *testing.T) {
+	// A shadow expecting scaled input: failing is not the live stream's
+	// problem.
+	s := NewShadow(&linear{scale: 1, threshold: 0.5}, &failing{})
+	scores := stream(t, s, []float64{0.2}, []float64{0.7})
+	assert.Len(t, scores, 2)
+	assert.Equal(t, uint64(2), s.Stats().Errors)
+	assert.Zero(t, s.Stats().Samples)
+}
+
+func TestShadowCancel(t *testing.T) {
+	s := NewShadow(&linear{scale: 1, threshold: 0.5}, &linear{scale: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	input := make(chan []float64)
+	output := make(chan Score)
+	done := make(chan error)
+	go func() { done <- s.PredictStream(ctx, input, output) }()
+	input <- []float64{0.1}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	_, open := <-output
+	assert.False(t, open)
+}
+
+type failing struct{ linear }
+
+func (failing) PredictOne([]float64) (float64, error) { return 0, errors.New("dimension mismatch") }
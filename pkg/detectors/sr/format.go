@@ -0,0 +1,106 @@
+package sr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved spectral residual models.
+var saveFormat = container.Format{Name: "sr", Model: "spectral residual", Magic: "GGSRSAVE", Version: 1}
+
+// savedModel holds the options and the last window of the training series
+// of a saved spectral residual model.
+type savedModel struct {
+	Window        int
+	Extend        int
+	Contamination float64
+	Threshold     float64
+	Scale         float64
+	// Tail is the last Window-1 training values.
+	Tail []float64
+	Card container.Card
+}
+
+// Save serializes the last window of the training series.
+func (d *SR) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (d *SR) SaveTo(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Window:        d.window,
+		Extend:        d.extend,
+		Contamination: d.contamination,
+		Threshold:     d.threshold,
+		Scale:         d.scale,
+		Tail:          d.tail,
+		Card:          container.NewCard(d.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the last window of the training series written by Save.
+func (d *SR) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.window, d.extend = m.Window, m.Extend
+	d.contamination, d.threshold = m.Contamination, m.Threshold
+	d.scale, d.tail = m.Scale, m.Tail
+	d.card = m.Card.ModelCard()
+	d.featureNames = d.card.FeatureNames
+	d.trained = true
+	return nil
+}
+
+// LoadFrom restores the last window of the training series read from r.
+func (d *SR) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Load(data)
+}
+
+// validate checks the saved last window of the training series.
+func (m *savedModel) validate() error {
+	switch {
+	case m.Window <= saliencyWindow || m.Extend < 0:
+		return errors.New("sr: invalid hyperparameters")
+	case len(m.Tail) != m.Window-1:
+		return fmt.Errorf("sr: %d training values for windows of %d", len(m.Tail), m.Window)
+	case !(m.Scale > 0) || math.IsInf(m.Scale, 0):
+		return fmt.Errorf("sr: invalid score scale %g", m.Scale)
+	}
+	for _, v := range m.Tail {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("sr: model has non-finite values")
+		}
+	}
+	return nil
+}
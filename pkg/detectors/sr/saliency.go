@@ -0,0 +1,119 @@
+package sr
+
+import (
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+const (
+	// amplitudeWindow is the number of log amplitudes, the current one
+	// included, averaged to estimate the expected spectrum.
+	amplitudeWindow = 3
+	// saliencyWindow is the number of saliency values, the current one
+	// included, averaged to compare the last value's with.
+	saliencyWindow = 21
+	// gradientPoints is the number of values the slope the window is
+	// extended along is averaged over.
+	gradientPoints = 5
+	// tiny is the amplitude below which a frequency is taken to be absent.
+	tiny = 1e-8
+)
+
+// saliency computes spectral residual saliency maps of windows of a fixed
+// length. It keeps its buffers between calls and is not safe for
+// concurrent use.
+type saliency struct {
+	fft      *fourier.CmplxFFT
+	extend   int
+	seq      []complex128
+	coef     []complex128
+	logAmp   []float64
+	residual []float64
+	saliency []float64
+}
+
+// newSaliency returns a saliency for windows of window values, extended by
+// extend estimated values before the transform.
+func newSaliency(window, extend int) *saliency {
+	n := window + extend
+	return &saliency{
+		fft:      fourier.NewCmplxFFT(n),
+		extend:   extend,
+		seq:      make([]complex128, n),
+		coef:     make([]complex128, n),
+		logAmp:   make([]float64, n),
+		residual: make([]float64, n),
+		saliency: make([]float64, n),
+	}
+}
+
+// score returns the raw spectral residual score of the last value of
+// window: how far its saliency departs from the mean saliency of the
+// values before it, relative to that mean.
+func (s *saliency) score(window []float64) float64 {
+	n := len(window)
+	for i, v := range window {
+		s.seq[i] = complex(v, 0)
+	}
+	// The saliency of the last values of a window is unreliable, so the
+	// window is extended past its end, along the average slope to the value
+	// before the last from the values before it. The last value is left
+	// out so that an anomaly there does not carry into the extension.
+	m := min(gradientPoints, n-2)
+	var slope float64
+	for i := 1; i <= m; i++ {
+		slope += (window[n-2] - window[n-2-i]) / float64(i)
+	}
+	next := window[n-1-m] + slope
+	for i := n; i < len(s.seq); i++ {
+		s.seq[i] = complex(next, 0)
+	}
+
+	// Keep the phase of every frequency but replace its log amplitude by
+	// the residual from the trailing average of the log amplitudes: what
+	// stands out of the usual spectrum.
+	s.fft.Coefficients(s.coef, s.seq)
+	for i, c := range s.coef {
+		if a := cmplx.Abs(c); a > tiny {
+			s.logAmp[i] = math.Log(a)
+		} else {
+			s.logAmp[i] = 0
+		}
+	}
+	trailingMean(s.residual, s.logAmp, amplitudeWindow)
+	for i, c := range s.coef {
+		a := cmplx.Abs(c)
+		if a <= tiny {
+			s.coef[i] = 0
+			continue
+		}
+		s.coef[i] = c * complex(math.Exp(s.logAmp[i]-s.residual[i])/a, 0)
+	}
+	s.fft.Sequence(s.seq, s.coef)
+	for i, c := range s.seq {
+		s.saliency[i] = cmplx.Abs(c) / float64(len(s.seq))
+	}
+
+	var mean float64
+	lo := max(0, n-saliencyWindow)
+	for _, v := range s.saliency[lo:n] {
+		mean += v
+	}
+	mean /= float64(n - lo)
+	return math.Abs(s.saliency[n-1]-mean) / max(mean, tiny)
+}
+
+// trailingMean writes to dst the mean of the k values of src ending at each
+// index, or of all values up to it for the first k-1.
+func trailingMean(dst, src []float64, k int) {
+	var sum float64
+	for i, v := range src {
+		sum += v
+		if i >= k {
+			sum -= src[i-k]
+		}
+		dst[i] = sum / float64(min(i+1, k))
+	}
+}
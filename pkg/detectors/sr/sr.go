@@ -0,0 +1,474 @@
+// Package sr implements the Spectral Residual (SR) detector for univariate
+// time series.
+//
+// Samples are the values of a series in time order, one feature per row.
+// Each value is scored on the window of the last WithWindow values ending
+// at it: the window's spectrum is flattened by subtracting from each log
+// amplitude the average of its neighbors, and transformed back into a
+// saliency map in which the parts of the series that do not follow its
+// usual rhythm stand out. A value scores by how far its saliency departs
+// from the mean saliency of the values before it. The method, from
+// "Time-Series Anomaly Detection Service at Microsoft" (Ren et al., 2019),
+// needs no model of normal behavior: training only sets the score scale
+// and threshold, so a short series is enough, and scoring a value costs
+// one transform of the window forward and back.
+//
+// Predict scores a batch as the continuation of the training series, so
+// its first values are scored on windows that begin with the last
+// training values; batches do not change the model. PredictStream carries
+// its own window from one sample to the next. Non-finite values score 1
+// and are replaced by the value before them in the windows of later ones.
+package sr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// window, estimated points or contamination.
+var ErrInvalidOption = fmt.Errorf("sr: %w", detectors.ErrInvalidOption)
+
+// scoreChunk is the number of values scored between context checks.
+const scoreChunk = 1024
+
+// SR is a spectral residual detector. It is safe for concurrent use.
+type SR struct {
+	mu sync.RWMutex
+
+	// Configuration
+	window        int
+	extend        int
+	contamination float64
+	threshold     float64
+	workers       int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	// tail holds the last window-1 training values, which begin the
+	// windows of the first values of a batch.
+	tail []float64
+	// scale is the mean raw score of the training values, which scores
+	// 0.5.
+	scale   float64
+	card    detectors.ModelCard
+	trained bool
+}
+
+// Option configures an SR.
+type Option func(*SR)
+
+// WithWindow sets the number of values, the scored one last, whose
+// spectrum is analyzed, 64 by default. It must exceed 21, the number of
+// saliency values a value is compared with; longer windows cover slower
+// rhythms and cost more per value.
+func WithWindow(n int) Option {
+	return func(d *SR) {
+		d.window = n
+	}
+}
+
+// WithEstimatedPoints sets the number of values the window is extended by,
+// along the slope of its last values, before the transform, 5 by default.
+// The transform treats a window as periodic, so without them the values
+// it ends with, those scored, would be compared with those it starts
+// with.
+func WithEstimatedPoints(n int) Option {
+	return func(d *SR) {
+		d.extend = n
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.01 by
+// default. Fit sets the threshold to flag that fraction of the training
+// values; 0 keeps the threshold.
+func WithContamination(c float64) Option {
+	return func(d *SR) {
+		d.contamination = c
+	}
+}
+
+// WithWorkers sets the number of goroutines used to train and score
+// batches. n <= 0, the default, uses detectors.DefaultWorkers at each
+// call.
+func WithWorkers(n int) Option {
+	return func(d *SR) {
+		d.workers = n
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(d *SR) {
+		d.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new SR.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(d *SR) {
+		d.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(d *SR) {
+		d.dataSource = source
+	}
+}
+
+// WithFeatureNames records the name of the series in the model card. Fit
+// fails unless exactly one name is given.
+func WithFeatureNames(names []string) Option {
+	return func(d *SR) {
+		d.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained SR with the given options.
+func New(opts ...Option) *SR {
+	d := &SR{
+		window:        64,
+		extend:        5,
+		contamination: 0.01,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.workers = max(d.workers, 0)
+	return d
+}
+
+// Validate checks the window, estimated points and contamination set by the
+// options.
+func (d *SR) Validate() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validate()
+}
+
+func (d *SR) validate() error {
+	var errs []error
+	if d.window <= saliencyWindow {
+		errs = append(errs, fmt.Errorf("%w: WithWindow(%d): need more than %d values", ErrInvalidOption, d.window, saliencyWindow))
+	}
+	if d.extend < 0 {
+		errs = append(errs, fmt.Errorf("%w: WithEstimatedPoints(%d): must not be negative", ErrInvalidOption, d.extend))
+	}
+	if !(d.contamination >= 0 && d.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, d.contamination))
+	}
+	if d.severity != nil {
+		if err := d.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit scores the training series, one value per row in time order, for
+// the score scale and threshold, and keeps its last values. Values must be
+// finite and the series at least two windows long.
+func (d *SR) Fit(data [][]float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	if nFeatures := len(data[0]); nFeatures != 1 {
+		return fmt.Errorf("training data has %d features: spectral residual scores a single series", nFeatures)
+	}
+	if d.featureNames != nil && len(d.featureNames) != 1 {
+		return fmt.Errorf("%d feature names for 1 feature", len(d.featureNames))
+	}
+	if len(data) < 2*d.window {
+		return fmt.Errorf("%d training values for windows of %d: need at least two windows", len(data), d.window)
+	}
+	values := make([]float64, len(data))
+	for i, row := range data {
+		if len(row) != 1 {
+			return fmt.Errorf("row %d has %d features, expected 1", i, len(row))
+		}
+		if math.IsNaN(row[0]) || math.IsInf(row[0], 0) {
+			return fmt.Errorf("row %d feature 0 is not finite", i)
+		}
+		values[i] = row[0]
+	}
+
+	// Score every value with a full window.
+	raw := make([]float64, len(values)-d.window+1)
+	detectors.ParallelFor(len(raw), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		sal := newSaliency(d.window, d.extend)
+		for i := lo; i < hi; i++ {
+			raw[i] = sal.score(values[i : i+d.window])
+		}
+	})
+	var sum float64
+	for _, r := range raw {
+		sum += r
+	}
+	d.tail = slices.Clone(values[len(values)-(d.window-1):])
+	d.scale = 1
+	if mean := sum / float64(len(raw)); mean > 0 {
+		d.scale = mean
+	}
+	d.trained = true
+
+	if d.contamination > 0 {
+		est := stats.NewQuantileEstimator(len(raw), 100)
+		for _, r := range raw {
+			est.Add(d.score(r))
+		}
+		d.threshold = est.Quantile(1 - d.contamination)
+	}
+	d.card = d.modelCard(data)
+	return nil
+}
+
+// score maps a raw score to [0, 1]: 0.5 for the mean training value,
+// rising toward 1 as a value stands out more.
+func (d *SR) score(raw float64) float64 {
+	return 1 - math.Exp2(-raw/d.scale)
+}
+
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Predict returns anomaly scores for the given samples.
+func (d *SR) Predict(data [][]float64) ([]float64, error) {
+	return d.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, with workers checking ctx every thousand
+// samples.
+func (d *SR) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	// The series scored: the training tail, then the batch with
+	// non-finite values replaced by the value before them.
+	series := slices.Grow(slices.Clone(d.tail), len(data))
+	for i, sample := range data {
+		if len(sample) != 1 {
+			return nil, fmt.Errorf("sample %d: %w", i, d.dimensionError(sample))
+		}
+		v := sample[0]
+		if !finite(v) {
+			v = series[len(series)-1]
+		}
+		series = append(series, v)
+	}
+
+	scores := make([]float64, len(data))
+	detectors.ParallelFor(len(data), detectors.Workers(d.workers), scoreChunk, func(lo, hi int) {
+		sal := newSaliency(d.window, d.extend)
+		for i := lo; i < hi; i++ {
+			if (i-lo)%scoreChunk == 0 && ctx.Err() != nil {
+				return
+			}
+			if !finite(data[i][0]) {
+				scores[i] = 1
+				continue
+			}
+			scores[i] = d.score(sal.score(series[i : i+d.window]))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score of sample as the value right after
+// the training series.
+func (d *SR) PredictOne(sample []float64) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != 1 {
+		return 0, d.dimensionError(sample)
+	}
+	if !finite(sample[0]) {
+		return 1, nil
+	}
+	window := append(slices.Clone(d.tail), sample[0])
+	return d.score(newSaliency(d.window, d.extend).score(window)), nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (d *SR) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: 1}
+}
+
+// stream is the state PredictStream carries between samples: the last
+// values of the series and the model they were started from.
+type stream struct {
+	tail   []float64
+	window []float64
+	sal    *saliency
+}
+
+// PredictStream scores samples from input, each the next value of the
+// series that continues the training series, until input is closed.
+func (d *SR) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	d.mu.RLock()
+	if !d.trained {
+		d.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := d.onReject
+	d.mu.RUnlock()
+
+	var st stream
+	return streamer.Run(ctx, input, output, reject, func(sample []float64) (detectors.Score, error) {
+		return d.streamScore(&st, sample)
+	})
+}
+
+// streamScore scores one streamed sample under one read lock, sliding the
+// stream's window, which it starts afresh from the training tail on the
+// first sample and again if the model changed since.
+func (d *SR) streamScore(st *stream, sample []float64) (detectors.Score, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(sample) != 1 {
+		return detectors.Score{}, d.dimensionError(sample)
+	}
+	if st.sal == nil || &st.tail[0] != &d.tail[0] {
+		st.tail = d.tail
+		st.window = append(slices.Clone(d.tail), 0)
+		st.sal = newSaliency(d.window, d.extend)
+	}
+	v := sample[0]
+	if !finite(v) {
+		v = st.window[len(st.window)-2]
+	}
+	st.window[len(st.window)-1] = v
+
+	score := 1.0
+	if finite(sample[0]) {
+		score = d.score(st.sal.score(st.window))
+	}
+	copy(st.window, st.window[1:])
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= d.threshold,
+		Features:  sample,
+	}
+	if d.severity != nil {
+		result.Severity = d.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*SR)(nil)
+	_ detectors.Thresholder    = (*SR)(nil)
+	_ detectors.RejectReporter = (*SR)(nil)
+	_ detectors.Describer      = (*SR)(nil)
+	_ detectors.Sequential     = (*SR)(nil)
+)
+
+// Sequential reports true: a batch is scored as the continuation of the
+// training series, so it must not be split.
+func (d *SR) Sequential() bool {
+	return true
+}
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the last window of the training series to. Rejected samples
+// do not advance the series.
+func (d *SR) SetRejectHandler(fn detectors.RejectFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReject = fn
+}
+
+// Metadata returns the model card recorded by Fit.
+func (d *SR) Metadata() detectors.ModelCard {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	card := d.card
+	card.FeatureNames = slices.Clone(d.card.FeatureNames)
+	if d.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(d.card.Hyperparameters))
+		for k, v := range d.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (d *SR) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   d.dataSource,
+		Rows:         len(data),
+		Features:     1,
+		FeatureNames: slices.Clone(d.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":        "sr",
+			"window":           strconv.Itoa(d.window),
+			"estimated_points": strconv.Itoa(d.extend),
+			"contamination":    strconv.FormatFloat(d.contamination, 'g', -1, 64),
+			"threshold":        strconv.FormatFloat(d.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (d *SR) Trained() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (d *SR) Threshold() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (d *SR) SetThreshold(t float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = t
+}
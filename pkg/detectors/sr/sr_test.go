@@ -0,0 +1,113 @@
+package sr
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// period is the period of the series of wave.
+const period = 50
+
+// wave returns n values of a noisy sine wave of the given period around
+// 1000, starting at time start, one per row.
+func wave(start, n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		t := float64(start + i)
+		data[i] = []float64{1000 + 100*math.Sin(2*math.Pi*t/period) + rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	probe := wave(1000, 300, 7)
+	probe[150][0] += 40
+	detectortest.Suite[*SR, Option]{
+		Algorithm:        "sr",
+		New:              New,
+		WithFeatureNames: WithFeatureNames,
+		WithDataSource:   WithDataSource,
+		Configured:       []Option{WithEstimatedPoints(3)},
+		Invalid:          []Option{WithWindow(21), WithEstimatedPoints(-1), WithContamination(1)},
+		ErrInvalidOption: ErrInvalidOption,
+		Train:            wave(0, 1000, 6),
+		Probe:            probe,
+		WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+	}.Run(t)
+}
+
+func TestFitPredict(t *testing.T) {
+	d := New()
+	require.NoError(t, d.Fit(wave(0, 2000, 1)))
+
+	live := wave(2000, 500, 2)
+	live[200][0] += 40
+	live[350][0] -= 40
+	scores, err := d.Predict(live)
+	require.NoError(t, err)
+
+	flagged := 0
+	for i, s := range scores {
+		if i != 200 && i != 350 && s >= d.Threshold() {
+			flagged++
+		}
+	}
+	assert.Less(t, flagged, 20, "the normal values are rarely flagged")
+	assert.Greater(t, scores[200], d.Threshold(), "the spike is flagged")
+	assert.Greater(t, scores[350], d.Threshold(), "the dip is flagged")
+	assert.Less(t, scores[100], d.Threshold())
+
+	// The first values complete windows begun by the training series.
+	one, err := d.PredictOne(live[0])
+	require.NoError(t, err)
+	assert.Equal(t, scores[0], one)
+
+	// A NaN scores 1, and the values after it see the value before it in
+	// its place.
+	holed := wave(2000, 500, 2)
+	holed[100] = []float64{math.NaN()}
+	withNaN, err := d.Predict(holed)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, withNaN[100])
+	filled := wave(2000, 500, 2)
+	filled[100] = filled[99]
+	want, err := d.Predict(filled)
+	require.NoError(t, err)
+	assert.Equal(t, want[101:], withNaN[101:])
+	assert.Equal(t, want[:100], withNaN[:100])
+}
+
+func TestErrors(t *testing.T) {
+	d := New()
+	assert.Error(t, d.Fit([][]float64{{1, 2}, {3, 4}}), "one series only")
+	assert.Error(t, d.Fit(wave(0, 127, 1)), "need two windows")
+}
+
+func BenchmarkFit(b *testing.B) {
+	data := wave(0, 5000, 1)
+	d := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Fit(data)
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	d := New()
+	d.Fit(wave(0, 5000, 1))
+	samples := wave(5000, 10000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Predict(samples)
+	}
+}
@@ -0,0 +1,32 @@
+package detectors
+
+import "time"
+
+// Telemetry describes a detector's internals over the samples it scored
+// in one period. Shifts in these internals are often the earliest sign
+// that a model no longer fits the data, before its scores or anomaly rate
+// move.
+type Telemetry struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Samples int       `json:"samples"`
+	// Metrics holds named values specific to the algorithm, such as
+	// iforest.MetricAvgPathLength.
+	Metrics map[string]float64 `json:"metrics"`
+	// Histograms holds named distributions, counts per bucket, such as
+	// iforest.HistogramLeafDepth.
+	Histograms map[string][]uint64 `json:"histograms,omitempty"`
+}
+
+// TelemetryFunc receives the telemetry of each period.
+type TelemetryFunc func(Telemetry)
+
+// TelemetryReporter is implemented by detectors that report their
+// internals periodically.
+type TelemetryReporter interface {
+	// SetTelemetryHandler starts collecting telemetry from scored samples
+	// and passes it to fn once per interval. A period ends with the first
+	// sample scored after the interval, so idle detectors do not report.
+	// A nil fn stops collecting.
+	SetTelemetryHandler(interval time.Duration, fn TelemetryFunc)
+}
@@ -0,0 +1,161 @@
+package detectors
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"slices"
+)
+
+// topKChunk is the number of rows PredictTopK scores at a time, bounding
+// the memory used by scores beyond the K kept.
+const topKChunk = 1 << 16
+
+// Ranked is a sample ranked among the most anomalous of its input.
+type Ranked struct {
+	// Index is the position of the sample in its input, from 0.
+	Index     int
+	Score     float64
+	IsAnomaly bool
+	Features  []float64
+	Metadata  map[string]any
+}
+
+// TopK keeps the k highest scored of the samples added to it, in a heap
+// of k entries whose root is the lowest kept. Of samples with the same
+// score, the earliest added are kept. It is not safe for concurrent use.
+type TopK struct {
+	k    int
+	h    rankHeap
+	seen int
+}
+
+// NewTopK creates a TopK keeping k samples.
+func NewTopK(k int) *TopK {
+	return &TopK{k: max(k, 0), h: make(rankHeap, 0, min(max(k, 0), 1024))}
+}
+
+// Add offers r and reports whether it is among the top k so far.
+func (t *TopK) Add(r Ranked) bool {
+	t.seen++
+	if t.h.Len() < t.k {
+		heap.Push(&t.h, r)
+		return true
+	}
+	if t.k == 0 || !outranks(r, t.h[0]) {
+		return false
+	}
+	t.h[0] = r
+	heap.Fix(&t.h, 0)
+	return true
+}
+
+// Threshold returns the score a sample must exceed to enter the top k, and
+// false while fewer than k samples have been added.
+func (t *TopK) Threshold() (float64, bool) {
+	if t.k == 0 || t.h.Len() < t.k {
+		return 0, false
+	}
+	return t.h[0].Score, true
+}
+
+// Seen returns the number of samples added.
+func (t *TopK) Seen() int {
+	return t.seen
+}
+
+// Len returns the number of samples kept, at most k.
+func (t *TopK) Len() int {
+	return t.h.Len()
+}
+
+// Results returns the samples kept, highest score first, ties by index.
+func (t *TopK) Results() []Ranked {
+	results := slices.Clone(t.h)
+	slices.SortFunc(results, func(a, b Ranked) int {
+		if outranks(a, b) {
+			return -1
+		}
+		if outranks(b, a) {
+			return 1
+		}
+		return 0
+	})
+	return results
+}
+
+// outranks reports whether a ranks above b: a higher score, or the same
+// score and an earlier index. NaN scores rank below all others.
+func outranks(a, b Ranked) bool {
+	if c := cmp.Compare(a.Score, b.Score); c != 0 {
+		return c > 0
+	}
+	return a.Index < b.Index
+}
+
+// rankHeap is a heap of ranked samples with the lowest ranked at the
+// root.
+type rankHeap []Ranked
+
+func (h rankHeap) Len() int           { return len(h) }
+func (h rankHeap) Less(i, j int) bool { return outranks(h[j], h[i]) }
+func (h rankHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *rankHeap) Push(x any)        { *h = append(*h, x.(Ranked)) }
+
+func (h *rankHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// PredictTopK scores data with d and returns the k most anomalous samples,
+// highest score first, with their indices in data. Rows are scored in
+// chunks, so only the scores of one chunk and the k kept are held at once,
+// except for Sequential detectors, which score data in one call so every
+// sample is scored in the context of all those before it. It stops with
+// ctx.Err() once ctx is done.
+func PredictTopK(ctx context.Context, d Detector, data [][]float64, k int) ([]Ranked, error) {
+	threshold := ThresholdOf(d)
+	top := NewTopK(k)
+	chunk := topKChunk
+	if IsSequential(d) {
+		chunk = max(len(data), 1)
+	}
+	for lo := 0; lo < len(data); lo += chunk {
+		hi := min(lo+chunk, len(data))
+		scores, err := d.PredictContext(ctx, data[lo:hi])
+		if err != nil {
+			return nil, err
+		}
+		for i, score := range scores {
+			top.Add(Ranked{Index: lo + i, Score: score, IsAnomaly: score >= threshold, Features: data[lo+i]})
+		}
+	}
+	return top.Results(), nil
+}
+
+// TopKStream reads scores, such as those of PredictStream, until the
+// channel is closed and returns the k highest, highest first, with their
+// positions in the stream. On cancellation it returns the top k of the
+// scores read so far with ctx.Err().
+func TopKStream(ctx context.Context, scores <-chan Score, k int) ([]Ranked, error) {
+	top := NewTopK(k)
+	for {
+		select {
+		case s, ok := <-scores:
+			if !ok {
+				return top.Results(), nil
+			}
+			top.Add(Ranked{
+				Index:     top.Seen(),
+				Score:     s.Value,
+				IsAnomaly: s.IsAnomaly,
+				Features:  s.Features,
+				Metadata:  s.Metadata,
+			})
+		case <-ctx.Done():
+			return top.Results(), ctx.Err()
+		}
+	}
+}
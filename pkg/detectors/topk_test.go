@@ -0,0 +1,98 @@
+package detectors
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopK(t *testing.T) {
+	top := NewTopK(3)
+	_, full := top.Threshold()
+	assert.False(t, full)
+
+	for i, score := range []float64{0.2, 0.9, 0.5, math.NaN(), 0.9, 0.1, 0.7, 0.5} {
+		top.Add(Ranked{Index: i, Score: score})
+	}
+	assert.Equal(t, 8, top.Seen())
+	assert.Equal(t, 3, top.Len())
+	threshold, full := top.Threshold()
+	assert.True(t, full)
+	assert.Equal(t, 0.7, threshold)
+
+	var got []int
+	for _, r := range top.Results() {
+		got = append(got, r.Index)
+	}
+	assert.Equal(t, []int{1, 4, 6}, got, "highest first, ties by index")
+
+	assert.False(t, top.Add(Ranked{Index: 8, Score: 0.7}), "a later tie does not displace")
+	assert.True(t, top.Add(Ranked{Index: 9, Score: 0.8}))
+
+	none := NewTopK(0)
+	assert.False(t, none.Add(Ranked{Score: 1}))
+	assert.Empty(t, none.Results())
+}
+
+func TestPredictTopK(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([][]float64, topKChunk+5000)
+	for i := range data {
+		data[i] = []float64{rng.Float64()}
+	}
+	d := &linear{scale: 1, threshold: 0.9999}
+
+	top, err := PredictTopK(context.Background(), d, data, 50)
+	require.NoError(t, err)
+	require.Len(t, top, 50)
+
+	// The same as sorting every score.
+	order := make([]int, len(data))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(data[b][0], data[a][0]) })
+	for i, r := range top {
+		assert.Equal(t, order[i], r.Index)
+		assert.Equal(t, data[r.Index][0], r.Score)
+		assert.Equal(t, r.Score >= 0.9999, r.IsAnomaly)
+		assert.Same(t, &data[r.Index][0], &r.Features[0])
+	}
+
+	all, err := PredictTopK(context.Background(), d, data[:10], 100)
+	require.NoError(t, err)
+	assert.Len(t, all, 10)
+
+	_, err = PredictTopK(context.Background(), d, [][]float64{{1}, {}}, 1)
+	assert.Error(t, err)
+}
+
+func TestTopKStream(t *testing.T) {
+	scores := make(chan Score, 10)
+	for i, v := range []float64{0.3, 0.8, 0.1, 0.6} {
+		scores <- Score{Value: v, IsAnomaly: v > 0.5, Metadata: map[string]any{"n": i}}
+	}
+	close(scores)
+
+	top, err := TopKStream(context.Background(), scores, 2)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, Ranked{Index: 1, Score: 0.8, IsAnomaly: true, Metadata: map[string]any{"n": 1}}, top[0])
+	assert.Equal(t, 3, top[1].Index)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	open := make(chan Score)
+	go func() {
+		open <- Score{Value: 0.4}
+		cancel()
+	}()
+	top, err = TopKStream(ctx, open, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, top, 1)
+}
@@ -0,0 +1,48 @@
+package detectors
+
+// Kinds of tree ensembles, deciding how the outputs of their trees combine
+// into a score.
+const (
+	// EnsembleIsolationForest scores a sample 2^(-h/c(n)), where h is the
+	// mean of the tree outputs, the path lengths isolating the sample, and
+	// c(n) the average path length of unsuccessful searches in a binary
+	// search tree of n = SampleSize nodes.
+	EnsembleIsolationForest = "isolation_forest"
+)
+
+// TreeNode is a node of a binary decision tree, as described by
+// TreeEnsemble detectors.
+type TreeNode struct {
+	// Feature is the split feature of internal nodes, -1 for leaves.
+	Feature int
+	// Split is the split value of internal nodes: samples whose Feature is
+	// at least Split go Right, the others, NaN included, Left.
+	Split       float64
+	Left, Right *TreeNode
+	// Samples is the number of training samples reaching the node.
+	Samples int
+	// Value is the output of leaves.
+	Value float64
+}
+
+// Ensemble describes the trained trees of a tree ensemble, for exporting
+// models to other formats.
+type Ensemble struct {
+	// Kind is one of the Ensemble constants.
+	Kind string
+	// Features is the number of features per sample.
+	Features int
+	Trees    []*TreeNode
+	// SampleSize is the number of training samples each tree was grown
+	// from.
+	SampleSize int
+	// Threshold is the score at or above which samples are anomalous.
+	Threshold float64
+}
+
+// TreeEnsemble is implemented by detectors made of decision trees that can
+// describe them.
+type TreeEnsemble interface {
+	// Ensemble returns the trained trees. It fails for untrained models.
+	Ensemble() (Ensemble, error)
+}
@@ -0,0 +1,107 @@
+package zscore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/container"
+)
+
+// saveFormat frames saved z-score models.
+var saveFormat = container.Format{Name: "zscore", Model: "z-score", Magic: "GGZSSAVE", Version: 1}
+
+// savedModel holds the options and the centers and spreads of the features
+// of a saved z-score model.
+type savedModel struct {
+	Method        uint8
+	Contamination float64
+	Threshold     float64
+	Center        []float64
+	Spread        []float64
+	Importances   []float64
+	Card          container.Card
+}
+
+// Save serializes the centers and spreads of the features.
+func (z *ZScore) Save() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveTo writes the model to w in the format of Save.
+func (z *ZScore) SaveTo(w io.Writer) error {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if !z.trained {
+		return detectors.ErrNotTrained
+	}
+	m := savedModel{
+		Method:        uint8(z.method),
+		Contamination: z.contamination,
+		Threshold:     z.threshold,
+		Center:        z.center,
+		Spread:        z.spread,
+		Importances:   z.importances,
+		Card:          container.NewCard(z.card),
+	}
+
+	return saveFormat.Write(w, &m)
+}
+
+// Load restores the centers and spreads of the features written by Save.
+func (z *ZScore) Load(data []byte) error {
+	var m savedModel
+	if err := saveFormat.Read(data, &m); err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.method = Method(m.Method)
+	z.contamination, z.threshold = m.Contamination, m.Threshold
+	z.center, z.spread = m.Center, m.Spread
+	z.importances = m.Importances
+	z.card = m.Card.ModelCard()
+	z.featureNames = z.card.FeatureNames
+	z.trained = true
+	return nil
+}
+
+// LoadFrom restores the centers and spreads of the features read from r.
+func (z *ZScore) LoadFrom(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return z.Load(data)
+}
+
+// validate checks the saved centers and spreads of the features.
+func (m *savedModel) validate() error {
+	dim := len(m.Center)
+	switch {
+	case dim == 0:
+		return errors.New("zscore: model has no features")
+	case len(m.Spread) != dim:
+		return fmt.Errorf("zscore: %d spreads for %d features", len(m.Spread), dim)
+	case Method(m.Method) > Robust:
+		return fmt.Errorf("zscore: unknown method %d", m.Method)
+	}
+	for j := range m.Center {
+		if c, s := m.Center[j], m.Spread[j]; math.IsNaN(c) || math.IsInf(c, 0) || !(s >= 0) || math.IsInf(s, 0) {
+			return fmt.Errorf("zscore: feature %d: invalid center or spread", j)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,560 @@
+// Package zscore implements a per-feature z-score anomaly detector.
+//
+// Each feature is measured in spreads from its training center: standard
+// deviations from the mean, or, with the Robust method, scaled median
+// absolute deviations from the median, which a minority of outliers in
+// the training data cannot drag. A sample scores by its most extreme
+// feature. The detector knows nothing of interactions between features,
+// which makes it the honest baseline other detectors should beat, and
+// needs a single training row, which makes it the fallback when there is
+// too little data to train anything else.
+package zscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/streamer"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ErrInvalidOption matches the errors of Validate and Fit for an invalid
+// method or contamination.
+var ErrInvalidOption = fmt.Errorf("zscore: %w", detectors.ErrInvalidOption)
+
+// madScale makes the median absolute deviation of normal data estimate
+// its standard deviation.
+const madScale = 1.4826
+
+// meanADScale makes the mean absolute deviation of normal data estimate
+// its standard deviation.
+const meanADScale = 1.2533
+
+// defaultExplainTop is the number of top features Explain lists unless
+// WithExplanations sets it.
+const defaultExplainTop = 5
+
+// Method selects the center and spread features are measured with.
+type Method uint8
+
+// Methods.
+const (
+	// Standard measures features in standard deviations from their mean.
+	Standard Method = iota
+	// Robust measures features in median absolute deviations from their
+	// median, scaled to match the standard deviation of normal data. When
+	// most of a feature's values are equal, so that its median absolute
+	// deviation is zero, the scaled mean absolute deviation is used.
+	Robust
+)
+
+// String returns the name of m.
+func (m Method) String() string {
+	switch m {
+	case Standard:
+		return "standard"
+	case Robust:
+		return "robust"
+	default:
+		return fmt.Sprintf("Method(%d)", m)
+	}
+}
+
+// ZScore is a per-feature z-score detector. It is safe for concurrent use.
+type ZScore struct {
+	mu sync.RWMutex
+
+	// Configuration
+	method        Method
+	contamination float64
+	threshold     float64
+	explainTop    int
+	severity      *detectors.SeverityBands
+	onReject      detectors.RejectFunc
+	dataSource    string
+	featureNames  []string
+
+	// Trained model
+	center []float64
+	// spread is zero for features constant in training.
+	spread      []float64
+	importances []float64
+	card        detectors.ModelCard
+	trained     bool
+}
+
+// Option configures a ZScore.
+type Option func(*ZScore)
+
+// WithMethod sets the center and spread features are measured with,
+// Standard by default.
+func WithMethod(m Method) Option {
+	return func(z *ZScore) {
+		z.method = m
+	}
+}
+
+// WithContamination sets the expected proportion of anomalies, 0.1 by
+// default. Fit sets the threshold to flag that fraction of the training
+// data; 0 keeps the threshold, as does training on fewer than 1/c rows,
+// too few to place it.
+func WithContamination(c float64) Option {
+	return func(z *ZScore) {
+		z.contamination = c
+	}
+}
+
+// WithExplanations makes PredictStream attach an explanation with the top k
+// contributing features to every score.
+func WithExplanations(k int) Option {
+	return func(z *ZScore) {
+		z.explainTop = k
+	}
+}
+
+// WithSeverityBands makes PredictStream grade every score with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(z *ZScore) {
+		z.severity = &b
+	}
+}
+
+// WithRejectHandler sets the handler of samples PredictStream rejects, like
+// SetRejectHandler on the new ZScore.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(z *ZScore) {
+		z.onReject = fn
+	}
+}
+
+// WithDataSource records a description of the training data, such as a
+// file name, in the model card.
+func WithDataSource(source string) Option {
+	return func(z *ZScore) {
+		z.dataSource = source
+	}
+}
+
+// WithFeatureNames records feature names in the model card. Fit fails if
+// the training data has a different number of features.
+func WithFeatureNames(names []string) Option {
+	return func(z *ZScore) {
+		z.featureNames = slices.Clone(names)
+	}
+}
+
+// New creates an untrained ZScore with the given options.
+func New(opts ...Option) *ZScore {
+	z := &ZScore{
+		contamination: 0.1,
+		threshold:     0.5,
+	}
+	for _, opt := range opts {
+		opt(z)
+	}
+	z.explainTop = max(z.explainTop, 0)
+	return z
+}
+
+// Validate checks the method and contamination set by the options.
+func (z *ZScore) Validate() error {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.validate()
+}
+
+func (z *ZScore) validate() error {
+	var errs []error
+	if z.method > Robust {
+		errs = append(errs, fmt.Errorf("%w: WithMethod(%d): unknown method", ErrInvalidOption, z.method))
+	}
+	if !(z.contamination >= 0 && z.contamination < 1) {
+		errs = append(errs, fmt.Errorf("%w: WithContamination(%g): must be in [0, 1)", ErrInvalidOption, z.contamination))
+	}
+	if z.severity != nil {
+		if err := z.severity.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: WithSeverityBands: %v", ErrInvalidOption, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fit measures the center and spread of each feature of data. Features
+// must be finite; a single row is enough.
+func (z *ZScore) Fit(data [][]float64) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.validate(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty training data")
+	}
+	nFeatures := len(data[0])
+	if nFeatures == 0 {
+		return errors.New("training data has no features")
+	}
+	if z.featureNames != nil && len(z.featureNames) != nFeatures {
+		return fmt.Errorf("%d feature names for %d features", len(z.featureNames), nFeatures)
+	}
+	for i, row := range data {
+		if len(row) != nFeatures {
+			return fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("row %d feature %d is not finite", i, j)
+			}
+		}
+	}
+
+	z.center, z.spread = make([]float64, nFeatures), make([]float64, nFeatures)
+	column := make([]float64, len(data))
+	for j := range z.center {
+		for i, row := range data {
+			column[i] = row[j]
+		}
+		if z.method == Robust {
+			z.center[j], z.spread[j] = robust(column)
+		} else {
+			z.center[j], z.spread[j] = stats.MeanStd(column)
+		}
+	}
+	z.trained = true
+
+	// Score the training data once, for the threshold and the feature
+	// importances.
+	scores := make([]float64, len(data))
+	importances := make([]float64, nFeatures)
+	dev := make([]float64, nFeatures)
+	for i, row := range data {
+		scores[i] = z.score(z.deviations(row, dev))
+		for j, d := range normalize(dev) {
+			importances[j] += d
+		}
+	}
+	z.importances = normalize(importances)
+
+	if z.contamination > 0 && float64(len(data))*z.contamination >= 1 {
+		est := stats.NewQuantileEstimator(len(scores), 100)
+		est.AddAll(scores)
+		z.threshold = est.Quantile(1 - z.contamination)
+	}
+	z.card = z.modelCard(data)
+	return nil
+}
+
+// robust returns the median and the scaled median absolute deviation of
+// values, or the scaled mean absolute deviation if the median absolute
+// deviation is zero. It reorders values. The deviations are halved so
+// they stay finite for finite values, and the spread is capped at the
+// largest float64.
+func robust(values []float64) (center, spread float64) {
+	center = median(values)
+	for i, v := range values {
+		values[i] = math.Abs(v/2 - center/2)
+	}
+	if mad := median(values); mad > 0 {
+		return center, min(2*madScale*mad, math.MaxFloat64)
+	}
+	return center, min(2*meanADScale*stats.Mean(values), math.MaxFloat64)
+}
+
+// median returns the median of values, sorting them.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return values[n/2-1]/2 + values[n/2]/2
+}
+
+// deviations writes the absolute z-score of each feature of sample to dst
+// and returns it. Any departure from a feature constant in training, and
+// NaN, is infinitely far. The caller holds the read lock.
+func (z *ZScore) deviations(sample, dst []float64) []float64 {
+	for j, v := range sample {
+		switch {
+		case math.IsNaN(v):
+			dst[j] = math.Inf(1)
+		case z.spread[j] > 0:
+			dst[j] = math.Abs(stats.Standardize(v, z.center[j], z.spread[j]))
+		case v != z.center[j]:
+			dst[j] = math.Inf(1)
+		default:
+			dst[j] = 0
+		}
+	}
+	return dst
+}
+
+// score returns the probability that as many independent standard normal
+// values as there are features all lie closer to zero than the largest of
+// dev: 0.5 for a sample as extreme as the median of such normal samples,
+// near 1 for samples far out on any feature.
+func (z *ZScore) score(dev []float64) float64 {
+	return math.Pow(math.Erf(slices.Max(dev)/math.Sqrt2), float64(len(dev)))
+}
+
+// normalize scales values to sum to 1, leaving all-zero values. Infinite
+// values share the whole sum.
+func normalize(values []float64) []float64 {
+	var sum float64
+	inf := 0
+	for _, v := range values {
+		sum += v
+		if math.IsInf(v, 1) {
+			inf++
+		}
+	}
+	for i, v := range values {
+		switch {
+		case inf > 0 && math.IsInf(v, 1):
+			values[i] = 1 / float64(inf)
+		case inf > 0:
+			values[i] = 0
+		case sum > 0:
+			values[i] = v / sum
+		}
+	}
+	return values
+}
+
+// Predict returns anomaly scores for the given samples.
+func (z *ZScore) Predict(data [][]float64) ([]float64, error) {
+	return z.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, scoring the batch in order and checking ctx
+// every 4096 samples.
+func (z *ZScore) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if !z.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	for i, sample := range data {
+		if len(sample) != len(z.center) {
+			return nil, fmt.Errorf("sample %d: %w", i, z.dimensionError(sample))
+		}
+	}
+	scores := make([]float64, len(data))
+	dev := make([]float64, len(z.center))
+	for i, sample := range data {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		scores[i] = z.score(z.deviations(sample, dev))
+	}
+	return scores, nil
+}
+
+// PredictOne returns the anomaly score for a single sample.
+func (z *ZScore) PredictOne(sample []float64) (float64, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if !z.trained {
+		return 0, detectors.ErrNotTrained
+	}
+	if len(sample) != len(z.center) {
+		return 0, z.dimensionError(sample)
+	}
+	return z.score(z.deviations(sample, make([]float64, len(sample)))), nil
+}
+
+// ZScores returns the signed z-score of each feature of sample: its
+// distance from the training center in spreads, negative below it.
+// Departures from features constant in training are infinite.
+func (z *ZScore) ZScores(sample []float64) ([]float64, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if !z.trained {
+		return nil, detectors.ErrNotTrained
+	}
+	if len(sample) != len(z.center) {
+		return nil, z.dimensionError(sample)
+	}
+	out := z.deviations(sample, make([]float64, len(sample)))
+	for j, v := range sample {
+		if v < z.center[j] {
+			out[j] = -out[j]
+		}
+	}
+	return out, nil
+}
+
+// dimensionError reports a sample with the wrong number of features.
+func (z *ZScore) dimensionError(sample []float64) error {
+	return &detectors.DimensionError{Got: len(sample), Want: len(z.center)}
+}
+
+// PredictStream scores samples from input against the centers and spreads
+// of the features until input is closed.
+func (z *ZScore) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	z.mu.RLock()
+	if !z.trained {
+		z.mu.RUnlock()
+		return detectors.ErrNotTrained
+	}
+	reject := z.onReject
+	z.mu.RUnlock()
+
+	return streamer.Run(ctx, input, output, reject, z.streamScore)
+}
+
+// streamScore scores a streamed sample against the centers and spreads of
+// the features.
+func (z *ZScore) streamScore(sample []float64) (detectors.Score, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if len(sample) != len(z.center) {
+		return detectors.Score{}, z.dimensionError(sample)
+	}
+	dev := z.deviations(sample, make([]float64, len(sample)))
+	score := z.score(dev)
+	result := detectors.Score{
+		Value:     score,
+		IsAnomaly: score >= z.threshold,
+		Features:  sample,
+	}
+	if z.explainTop > 0 {
+		exp := z.explain(sample, score, dev)
+		result.Explanation = &exp
+	}
+	if z.severity != nil {
+		result.Severity = z.severity.Grade(score)
+	}
+	return result, nil
+}
+
+var (
+	_ detectors.StreamDetector = (*ZScore)(nil)
+	_ detectors.Thresholder    = (*ZScore)(nil)
+	_ detectors.RejectReporter = (*ZScore)(nil)
+	_ detectors.Explainer      = (*ZScore)(nil)
+	_ detectors.Describer      = (*ZScore)(nil)
+)
+
+// SetRejectHandler sets the handler PredictStream passes samples it cannot
+// score against the centers and spreads of the features to.
+func (z *ZScore) SetRejectHandler(fn detectors.RejectFunc) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.onReject = fn
+}
+
+// FeatureImportances returns each feature's mean share of the absolute
+// z-scores of the training data. It returns nil if the detector is not
+// trained.
+func (z *ZScore) FeatureImportances() []float64 {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return slices.Clone(z.importances)
+}
+
+// Explain attributes the score of sample to its features by their share of
+// its absolute z-scores. The typical range of each feature is its center
+// plus or minus two spreads.
+func (z *ZScore) Explain(sample []float64) (detectors.Explanation, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if !z.trained {
+		return detectors.Explanation{}, detectors.ErrNotTrained
+	}
+	if len(sample) != len(z.center) {
+		return detectors.Explanation{}, z.dimensionError(sample)
+	}
+	dev := z.deviations(sample, make([]float64, len(sample)))
+	return z.explain(sample, z.score(dev), dev), nil
+}
+
+// explain explains a sample of the right width from its score and absolute
+// z-scores. The caller holds the read lock.
+func (z *ZScore) explain(sample []float64, score float64, dev []float64) detectors.Explanation {
+	contributions := normalize(slices.Clone(dev))
+	typical := make([]detectors.Range, len(sample))
+	for j := range typical {
+		typical[j] = detectors.Range{Low: z.center[j] - 2*z.spread[j], High: z.center[j] + 2*z.spread[j]}
+	}
+	topK := z.explainTop
+	if topK <= 0 {
+		topK = defaultExplainTop
+	}
+	return detectors.Explanation{
+		Score:         score,
+		Contributions: contributions,
+		Top:           detectors.TopContributions(contributions, sample, typical, topK),
+	}
+}
+
+// Metadata returns the model card recorded by Fit.
+func (z *ZScore) Metadata() detectors.ModelCard {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	card := z.card
+	card.FeatureNames = slices.Clone(z.card.FeatureNames)
+	if z.card.Hyperparameters != nil {
+		card.Hyperparameters = make(map[string]string, len(z.card.Hyperparameters))
+		for k, v := range z.card.Hyperparameters {
+			card.Hyperparameters[k] = v
+		}
+	}
+	return card
+}
+
+// modelCard describes a Fit on data.
+func (z *ZScore) modelCard(data [][]float64) detectors.ModelCard {
+	return detectors.ModelCard{
+		TrainedAt:    time.Now().UTC(),
+		DataSource:   z.dataSource,
+		Rows:         len(data),
+		Features:     len(z.center),
+		FeatureNames: slices.Clone(z.featureNames),
+		Hyperparameters: map[string]string{
+			"algorithm":     "zscore",
+			"method":        z.method.String(),
+			"contamination": strconv.FormatFloat(z.contamination, 'g', -1, 64),
+			"threshold":     strconv.FormatFloat(z.threshold, 'g', -1, 64),
+		},
+		LibraryVersion: detectors.LibraryVersion(),
+		DataHash:       detectors.HashData(data),
+	}
+}
+
+// Trained reports whether the model has been fitted or loaded.
+func (z *ZScore) Trained() bool {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.trained
+}
+
+// Threshold returns the current anomaly threshold.
+func (z *ZScore) Threshold() float64 {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.threshold
+}
+
+// SetThreshold updates the anomaly threshold.
+func (z *ZScore) SetThreshold(t float64) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.threshold = t
+}
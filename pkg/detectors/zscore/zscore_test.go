@@ -0,0 +1,160 @@
+package zscore
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/internal/detectortest"
+)
+
+// normalData returns n samples of three normal features on different
+// scales.
+func normalData(n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), 100 + 10*rng.NormFloat64(), 1000 * rng.NormFloat64()}
+	}
+	return data
+}
+
+func TestDetector(t *testing.T) {
+	for _, method := range []Method{Standard, Robust} {
+		t.Run(method.String(), func(t *testing.T) {
+			detectortest.Suite[*ZScore, Option]{
+				Algorithm:        "zscore",
+				New:              New,
+				WithFeatureNames: WithFeatureNames,
+				WithDataSource:   WithDataSource,
+				WithExplanations: WithExplanations,
+				Options:          []Option{WithMethod(method)},
+				Configured:       []Option{WithContamination(0.05)},
+				Invalid:          []Option{WithMethod(7), WithContamination(-1)},
+				ErrInvalidOption: ErrInvalidOption,
+				Train:            normalData(1000, 6),
+				Probe:            append(normalData(20, 7), []float64{6, 100, 0}),
+				LargeValues:      true,
+				WriteEmpty:       func(w io.Writer) error { return saveFormat.Write(w, &savedModel{}) },
+			}.Run(t)
+		})
+	}
+}
+
+func TestFitPredict(t *testing.T) {
+	for _, method := range []Method{Standard, Robust} {
+		t.Run(method.String(), func(t *testing.T) {
+			z := New(WithMethod(method))
+			require.NoError(t, z.Fit(normalData(2000, 1)))
+
+			scores, err := z.Predict([][]float64{
+				{0, 100, 0},
+				{6, 100, 0},
+				{0, 40, 0},
+				{0, 100, -6000},
+				{math.NaN(), 100, 0},
+			})
+			require.NoError(t, err)
+			assert.Less(t, scores[0], z.Threshold(), "the center is normal")
+			for i, s := range scores[1:] {
+				assert.Greater(t, s, z.Threshold(), "sample %d", i+1)
+			}
+			assert.Equal(t, 1.0, scores[4], "NaN features are infinitely far")
+
+			// Contamination sets the threshold to flag about 10% of training.
+			trainScores, err := z.Predict(normalData(2000, 2))
+			require.NoError(t, err)
+			flagged := 0
+			for _, s := range trainScores {
+				if s >= z.Threshold() {
+					flagged++
+				}
+			}
+			assert.InDelta(t, 200, flagged, 40)
+
+			one, err := z.PredictOne([]float64{6, 100, 0})
+			require.NoError(t, err)
+			assert.Equal(t, scores[1], one)
+		})
+	}
+}
+
+func TestScores(t *testing.T) {
+	z := New()
+	require.NoError(t, z.Fit([][]float64{{-1, 5}, {1, 5}}))
+
+	zs, err := z.ZScores([]float64{-3, 5})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{-3, 0}, zs)
+	zs, err = z.ZScores([]float64{0, 6})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, math.Inf(1)}, zs, "any departure from a constant feature")
+
+	// One standard deviation on one of two features: both normal values
+	// within one deviation, P(|Z| < 1)^2.
+	score, err := z.PredictOne([]float64{1, 5})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.6827*0.6827, score, 1e-4)
+	assert.Equal(t, 0.5, z.Threshold(), "two rows are too few to place the threshold")
+}
+
+func TestRobust(t *testing.T) {
+	// A fifth of the training data sits far out: it drags the mean and
+	// inflates the deviation, not the median and MAD.
+	data := normalData(1000, 3)
+	for _, row := range data[:200] {
+		row[0] += 50
+	}
+	probe := []float64{8, 100, 0}
+	for method, flagged := range map[Method]bool{Standard: false, Robust: true} {
+		z := New(WithMethod(method), WithContamination(0))
+		require.NoError(t, z.Fit(data))
+		zs, err := z.ZScores(probe)
+		require.NoError(t, err)
+		assert.Equal(t, flagged, zs[0] > 4, "%v: z-score %g", method, zs[0])
+	}
+
+	// Mostly equal values fall back to the mean absolute deviation.
+	z := New(WithMethod(Robust))
+	require.NoError(t, z.Fit([][]float64{{1}, {1}, {1}, {1}, {5}}))
+	assert.InDelta(t, 1.2533*0.8, z.spread[0], 1e-12)
+}
+
+func TestErrors(t *testing.T) {
+	z := New()
+	_, err := z.ZScores([]float64{1})
+	assert.ErrorIs(t, err, detectors.ErrNotTrained)
+	require.NoError(t, z.Fit(normalData(1, 1)), "one row is enough")
+}
+
+func TestExplain(t *testing.T) {
+	z := New()
+	require.NoError(t, z.Fit(normalData(1000, 4)))
+
+	sample := []float64{0, 160, 0}
+	exp, err := z.Explain(sample)
+	require.NoError(t, err)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Less(t, exp.Top[0].Typical.High, 160.0, "the typical range excludes the odd value")
+
+	nan, err := z.Explain([]float64{0, math.NaN(), 0})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 1, 0}, nan.Contributions)
+}
+
+func BenchmarkPredict(b *testing.B) {
+	z := New()
+	z.Fit(normalData(100000, 1))
+	samples := normalData(100000, 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.Predict(samples)
+	}
+}
@@ -0,0 +1,233 @@
+package drift
+
+import "math"
+
+// ADWINOption configures an ADWIN.
+type ADWINOption func(*ADWIN)
+
+// WithDelta sets the confidence of ADWIN's test, 0.002 by default: the
+// bound on the probability of reporting a change in a stream whose mean
+// did not change. Lower values report fewer false changes and take longer
+// to report true ones. Values outside (0, 1) keep the default.
+func WithDelta(delta float64) ADWINOption {
+	return func(a *ADWIN) {
+		if delta > 0 && delta < 1 {
+			a.delta = delta
+		}
+	}
+}
+
+// WithMaxBuckets sets the number of buckets of each size ADWIN keeps, 5
+// by default. More buckets place the cuts it tests more finely, at the
+// cost of memory and time logarithmic in the window width. Values below
+// 2 keep the default.
+func WithMaxBuckets(m int) ADWINOption {
+	return func(a *ADWIN) {
+		if m >= 2 {
+			a.maxBuckets = m
+		}
+	}
+}
+
+// WithClock sets the number of values ADWIN adds between tests for a
+// change, 32 by default. Testing less often saves time and delays
+// detection by up to that many values. Values below 1 keep the default.
+func WithClock(n int) ADWINOption {
+	return func(a *ADWIN) {
+		if n >= 1 {
+			a.clock = n
+		}
+	}
+}
+
+// WithMinWindow sets the fewest values on either side of a cut ADWIN
+// tests, 5 by default. Values below 1 keep the default.
+func WithMinWindow(n int) ADWINOption {
+	return func(a *ADWIN) {
+		if n >= 1 {
+			a.minWindow = n
+		}
+	}
+}
+
+// bucket summarizes consecutive values: their count, sum and sum of
+// squared deviations from their mean.
+type bucket struct {
+	n          float64
+	total, ssd float64
+}
+
+// merge returns the summary of the values of b and c.
+func (b bucket) merge(c bucket) bucket {
+	d := b.total/b.n - c.total/c.n
+	return bucket{
+		n:     b.n + c.n,
+		total: b.total + c.total,
+		ssd:   b.ssd + c.ssd + b.n*c.n/(b.n+c.n)*d*d,
+	}
+}
+
+// ADWIN is the ADaptive WINdowing change detector of Bifet and Gavaldà,
+// "Learning from Time-Changing Data with Adaptive Windowing" (2007). It
+// keeps a window of the most recent values and drops its older part
+// whenever the means of the two parts differ by more than chance allows
+// at the confidence of WithDelta, which it reports as a change. The
+// window thus grows while the stream is stable and shrinks to the values
+// since the last change, so its mean tracks the current one.
+//
+// The window is summarized by an exponential histogram, buckets of 1, 2,
+// 4, ... values, with at most WithMaxBuckets of each size, so memory and
+// the time to test a window of W values grow with log W.
+//
+// ADWIN is not safe for concurrent use; a Monitor serializes its calls.
+type ADWIN struct {
+	delta      float64
+	maxBuckets int
+	clock      int
+	minWindow  int
+
+	// levels[i] holds the buckets of 2^i values, oldest first.
+	levels [][]bucket
+	width  float64
+	total  float64
+	ssd    float64
+	// ticks counts the values added since the last test.
+	ticks int
+}
+
+// NewADWIN returns an empty ADWIN.
+func NewADWIN(opts ...ADWINOption) *ADWIN {
+	a := &ADWIN{
+		delta:      0.002,
+		maxBuckets: 5,
+		clock:      32,
+		minWindow:  5,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Add adds x to the window and reports whether that revealed a change, in
+// which case the values before it have been dropped. NaN and infinite
+// values are ignored.
+func (a *ADWIN) Add(x float64) bool {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return false
+	}
+	if a.width > 0 {
+		d := x - a.total/a.width
+		a.ssd += a.width / (a.width + 1) * d * d
+	}
+	a.width++
+	a.total += x
+	a.insert(bucket{n: 1, total: x})
+
+	a.ticks++
+	if a.ticks < a.clock {
+		return false
+	}
+	a.ticks = 0
+	changed := false
+	for a.width > float64(2*a.minWindow) && a.cut() {
+		a.dropOldest()
+		changed = true
+	}
+	return changed
+}
+
+// insert adds b as the newest bucket of 1 value, merging the two oldest
+// buckets of any size that then exceeds the maximum count into one of the
+// next size.
+func (a *ADWIN) insert(b bucket) {
+	if len(a.levels) == 0 {
+		a.levels = append(a.levels, nil)
+	}
+	a.levels[0] = append(a.levels[0], b)
+	for i := 0; i < len(a.levels) && len(a.levels[i]) > a.maxBuckets; i++ {
+		merged := a.levels[i][0].merge(a.levels[i][1])
+		a.levels[i] = append(a.levels[i][:0], a.levels[i][2:]...)
+		if i+1 == len(a.levels) {
+			a.levels = append(a.levels, nil)
+		}
+		a.levels[i+1] = append(a.levels[i+1], merged)
+	}
+}
+
+// cut reports whether the window splits, at a bucket boundary, into an
+// older and a newer part whose means differ significantly.
+func (a *ADWIN) cut() bool {
+	variance := a.ssd / a.width
+	// The confidence is shared among the cuts tested.
+	dd := math.Log(2 * math.Log(a.width) / a.delta)
+	least := float64(a.minWindow)
+	var n0, total0 float64
+	for i := len(a.levels) - 1; i >= 0; i-- {
+		for _, b := range a.levels[i] {
+			n0 += b.n
+			total0 += b.total
+			n1 := a.width - n0
+			if n1 < least {
+				return false
+			}
+			if n0 < least {
+				continue
+			}
+			m := 1/(n0-least+1) + 1/(n1-least+1)
+			eps := math.Sqrt(2*m*variance*dd) + 2.0/3*dd*m
+			if math.Abs(total0/n0-(a.total-total0)/n1) > eps {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropOldest removes the oldest bucket from the window.
+func (a *ADWIN) dropOldest() {
+	top := len(a.levels) - 1
+	b := a.levels[top][0]
+	a.levels[top] = a.levels[top][1:]
+	for len(a.levels) > 0 && len(a.levels[len(a.levels)-1]) == 0 {
+		a.levels = a.levels[:len(a.levels)-1]
+	}
+	a.width -= b.n
+	a.total -= b.total
+	if a.width == 0 {
+		a.total, a.ssd = 0, 0
+		return
+	}
+	d := b.total/b.n - a.total/a.width
+	a.ssd = max(a.ssd-b.ssd-b.n*a.width/(b.n+a.width)*d*d, 0)
+}
+
+// Mean returns the mean of the window, 0 if it is empty.
+func (a *ADWIN) Mean() float64 {
+	if a.width == 0 {
+		return 0
+	}
+	return a.total / a.width
+}
+
+// Variance returns the variance of the window, 0 if it is empty.
+func (a *ADWIN) Variance() float64 {
+	if a.width == 0 {
+		return 0
+	}
+	return a.ssd / a.width
+}
+
+// Width returns the number of values in the window.
+func (a *ADWIN) Width() int {
+	return int(a.width)
+}
+
+// Reset empties the window.
+func (a *ADWIN) Reset() {
+	a.levels = nil
+	a.width, a.total, a.ssd = 0, 0, 0
+	a.ticks = 0
+}
+
+var _ Detector = (*ADWIN)(nil)
@@ -0,0 +1,97 @@
+package drift
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestADWINStable(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a := NewADWIN()
+	values := make([]float64, 10000)
+	changes := 0
+	for i := range values {
+		values[i] = 5 + rng.NormFloat64()
+		if a.Add(values[i]) {
+			changes++
+		}
+	}
+	assert.Zero(t, changes, "a stable stream does not change")
+	assert.Equal(t, len(values), a.Width())
+
+	// The histogram summarizes the window exactly.
+	var mean, variance float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+	assert.InDelta(t, mean, a.Mean(), 1e-9)
+	assert.InDelta(t, variance, a.Variance(), 1e-9)
+	assert.Less(t, len(a.levels), 14, "buckets grow logarithmically")
+}
+
+func TestADWINChange(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	a := NewADWIN()
+	for range 5000 {
+		assert.False(t, a.Add(rng.NormFloat64()))
+	}
+	a.Add(math.NaN())
+	assert.Equal(t, 5000, a.Width(), "NaN is ignored")
+
+	detected := -1
+	for i := range 2000 {
+		if a.Add(1+rng.NormFloat64()) && detected < 0 {
+			detected = i
+		}
+	}
+	assert.GreaterOrEqual(t, detected, 0, "a shift of one deviation is detected")
+	assert.Less(t, detected, 300, "and soon")
+	assert.Less(t, a.Width(), 2500, "the old values are dropped")
+	assert.InDelta(t, 1, a.Mean(), 0.2)
+
+	a.Reset()
+	assert.Zero(t, a.Width())
+	assert.Zero(t, a.Mean())
+}
+
+func TestADWINDelta(t *testing.T) {
+	// A stricter test detects later.
+	detect := func(delta float64) int {
+		rng := rand.New(rand.NewSource(3))
+		a := NewADWIN(WithDelta(delta), WithClock(1))
+		for range 2000 {
+			a.Add(rng.NormFloat64())
+		}
+		for i := range 2000 {
+			if a.Add(0.5 + rng.NormFloat64()) {
+				return i
+			}
+		}
+		return -1
+	}
+	loose, strict := detect(0.1), detect(1e-6)
+	assert.GreaterOrEqual(t, loose, 0)
+	assert.GreaterOrEqual(t, strict, loose)
+}
+
+func BenchmarkADWIN(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 4096)
+	for i := range values {
+		values[i] = rng.NormFloat64()
+	}
+	a := NewADWIN()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Add(values[i%len(values)])
+	}
+}
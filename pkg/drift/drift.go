@@ -0,0 +1,231 @@
+// Package drift detects changes in the distribution of streams of values,
+// such as the scores of a detector or the features it scores, so that a
+// model whose data has moved on can be retrained.
+//
+// A Detector is a sequential change test of one stream of values: ADWIN,
+// or the Page-Hinkley test. A Monitor runs one Detector per feature of a
+// stream of samples, or on a stream of scores, and signals every change
+// it detects to its handlers and on its Changes channel; Watch attaches
+// one to a StreamDetector's scores. Typical use, to retrain as soon as
+// the scores of the live detector drift:
+//
+//	m := drift.NewMonitor(func() drift.Detector { return drift.NewADWIN() },
+//		drift.WithChangeHandler(func(c drift.Change) {
+//			go retrainer.RunOnce(ctx)
+//		}),
+//	)
+//	go m.RunScores(ctx, scores)
+package drift
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Detector is a sequential test for a change in the distribution of a
+// stream of values.
+type Detector interface {
+	// Add observes the next value and reports whether the values so far
+	// reveal a change.
+	Add(x float64) bool
+	// Mean returns the detector's estimate of the current mean of the
+	// stream.
+	Mean() float64
+	// Reset forgets every value observed.
+	Reset()
+}
+
+// Change is a change a Monitor detected.
+type Change struct {
+	// Feature is the index of the feature that changed, 0 for scores.
+	Feature int `json:"feature"`
+	// Sample is the number of samples the Monitor had observed when it
+	// detected the change, the one revealing it included.
+	Sample uint64 `json:"sample"`
+	// Before and After are the detector's estimates of the mean of the
+	// feature just before and just after the change was detected.
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
+// Option configures a Monitor.
+type Option func(*Monitor)
+
+// WithChangeHandler adds a function called with every change detected. It
+// runs on the goroutine that added the sample, before Add returns, so a
+// slow handler slows the stream down; start long work, such as
+// retraining, on another goroutine. Repeated options accumulate.
+func WithChangeHandler(fn func(Change)) Option {
+	return func(m *Monitor) {
+		m.handlers = append(m.handlers, fn)
+	}
+}
+
+// WithChangeBuffer sets the capacity of the Changes channel, 16 by
+// default.
+func WithChangeBuffer(n int) Option {
+	return func(m *Monitor) {
+		m.buffer = n
+	}
+}
+
+// Monitor watches a stream of samples, with one Detector per feature, for
+// changes in their distribution. It is safe for concurrent use; samples
+// are observed in the order their Add calls take its lock.
+type Monitor struct {
+	factory  func() Detector
+	handlers []func(Change)
+	buffer   int
+	changes  chan Change
+
+	mu        sync.Mutex
+	detectors []Detector
+	samples   uint64
+
+	detected, dropped atomic.Uint64
+}
+
+// NewMonitor returns a Monitor creating the detector of each feature with
+// factory. The number of features is set by the first sample.
+func NewMonitor(factory func() Detector, opts ...Option) *Monitor {
+	m := &Monitor{factory: factory, buffer: 16}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.changes = make(chan Change, max(m.buffer, 0))
+	return m
+}
+
+// Add observes a sample and returns the changes it revealed, in feature
+// order. Samples of a different dimension than the first are rejected
+// with a *detectors.DimensionError. NaN and infinite features are skipped.
+func (m *Monitor) Add(sample []float64) ([]Change, error) {
+	changes, err := m.observe(sample)
+	for _, c := range changes {
+		m.signal(c)
+	}
+	return changes, err
+}
+
+// observe runs the detectors on sample under the lock.
+func (m *Monitor) observe(sample []float64) ([]Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.detectors == nil {
+		m.detectors = make([]Detector, len(sample))
+		for j := range m.detectors {
+			m.detectors[j] = m.factory()
+		}
+	}
+	if len(sample) != len(m.detectors) {
+		return nil, &detectors.DimensionError{Got: len(sample), Want: len(m.detectors)}
+	}
+	m.samples++
+
+	var changes []Change
+	for j, v := range sample {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		d := m.detectors[j]
+		before := d.Mean()
+		if d.Add(v) {
+			changes = append(changes, Change{Feature: j, Sample: m.samples, Before: before, After: d.Mean()})
+		}
+	}
+	return changes, nil
+}
+
+// AddScore observes a score, as the single feature of a sample, on a
+// Monitor watching scores only.
+func (m *Monitor) AddScore(score float64) []Change {
+	changes, _ := m.Add([]float64{score})
+	return changes
+}
+
+// signal passes c to the handlers and the Changes channel, dropping it
+// from the channel if it is full.
+func (m *Monitor) signal(c Change) {
+	m.detected.Add(1)
+	for _, fn := range m.handlers {
+		fn(c)
+	}
+	select {
+	case m.changes <- c:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// Changes returns the channel every change detected is sent on. Changes
+// arriving while it is full are dropped from it, not from the handlers;
+// Stats counts them. The channel is never closed.
+func (m *Monitor) Changes() <-chan Change {
+	return m.changes
+}
+
+// Run observes the samples from in until it is closed, returning nil, or
+// ctx is done, returning ctx.Err(). Samples Add rejects are skipped.
+func (m *Monitor) Run(ctx context.Context, in <-chan []float64) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sample, ok := <-in:
+			if !ok {
+				return nil
+			}
+			m.Add(sample)
+		}
+	}
+}
+
+// RunScores observes the values of the scores from in until it is
+// closed, returning nil, or ctx is done, returning ctx.Err().
+func (m *Monitor) RunScores(ctx context.Context, in <-chan detectors.Score) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case score, ok := <-in:
+			if !ok {
+				return nil
+			}
+			m.AddScore(score.Value)
+		}
+	}
+}
+
+// Stats counts what a Monitor observed.
+type Stats struct {
+	// Samples is the number of samples observed.
+	Samples uint64 `json:"samples"`
+	// Changes is the number of changes detected.
+	Changes uint64 `json:"changes"`
+	// Dropped is the number of changes the Changes channel was too full
+	// to take.
+	Dropped uint64 `json:"dropped"`
+}
+
+// Stats returns the counts of the samples observed and changes detected.
+func (m *Monitor) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Stats{Samples: m.samples, Changes: m.detected.Load(), Dropped: m.dropped.Load()}
+}
+
+// Reset forgets every sample observed, along with the number of features.
+// Changes already on the channel stay there.
+func (m *Monitor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.detectors = nil
+	m.samples = 0
+	m.detected.Store(0)
+	m.dropped.Store(0)
+}
@@ -0,0 +1,101 @@
+package drift
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+func newADWIN() Detector {
+	return NewADWIN()
+}
+
+func TestMonitor(t *testing.T) {
+	var handled []Change
+	m := NewMonitor(newADWIN, WithChangeHandler(func(c Change) {
+		handled = append(handled, c)
+	}))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := range 6000 {
+		shift := 0.0
+		if i >= 3000 {
+			shift = 2
+		}
+		changes, err := m.Add([]float64{rng.NormFloat64(), 10 + shift + rng.NormFloat64()})
+		require.NoError(t, err)
+		for _, c := range changes {
+			assert.Equal(t, uint64(i+1), c.Sample)
+		}
+	}
+
+	require.NotEmpty(t, handled)
+	for _, c := range handled {
+		assert.Equal(t, 1, c.Feature, "only the second feature changed")
+	}
+	first := handled[0]
+	assert.Greater(t, first.Sample, uint64(3000))
+	assert.Less(t, first.Sample, uint64(3300))
+	assert.Greater(t, first.After, first.Before)
+
+	select {
+	case c := <-m.Changes():
+		assert.Equal(t, first, c)
+	default:
+		t.Fatal("no change on the channel")
+	}
+	stats := m.Stats()
+	assert.Equal(t, uint64(6000), stats.Samples)
+	assert.Equal(t, uint64(len(handled)), stats.Changes)
+
+	_, err := m.Add([]float64{1})
+	var dim *detectors.DimensionError
+	require.ErrorAs(t, err, &dim)
+	assert.Equal(t, detectors.DimensionError{Got: 1, Want: 2}, *dim)
+
+	m.Reset()
+	_, err = m.Add([]float64{1})
+	assert.NoError(t, err, "Reset forgets the dimension")
+	assert.Equal(t, uint64(1), m.Stats().Samples)
+}
+
+func TestMonitorDropped(t *testing.T) {
+	m := NewMonitor(newADWIN, WithChangeBuffer(0))
+	for i := range 4000 {
+		m.AddScore(float64(i / 1000))
+	}
+	stats := m.Stats()
+	assert.Positive(t, stats.Changes)
+	assert.Equal(t, stats.Changes, stats.Dropped, "nothing receives the changes")
+}
+
+func TestRunScores(t *testing.T) {
+	m := NewMonitor(newADWIN)
+	scores := make(chan detectors.Score, 4000)
+	rng := rand.New(rand.NewSource(2))
+	for i := range 4000 {
+		v := 0.3 + 0.05*rng.NormFloat64()
+		if i >= 2000 {
+			v += 0.3
+		}
+		scores <- detectors.Score{Value: v}
+	}
+	close(scores)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, m.RunScores(ctx, scores))
+	require.NotEmpty(t, m.Changes())
+	c := <-m.Changes()
+	assert.Zero(t, c.Feature)
+	assert.InDelta(t, 0.3, c.Before, 0.05)
+
+	cancel()
+	assert.ErrorIs(t, m.Run(ctx, make(chan []float64)), context.Canceled)
+}
@@ -0,0 +1,127 @@
+package drift
+
+import "math"
+
+// Direction is the direction of the changes in mean a PageHinkley
+// detects.
+type Direction int
+
+const (
+	// Both detects increases and decreases.
+	Both Direction = iota
+	// Increase detects increases only, such as rising anomaly scores.
+	Increase
+	// Decrease detects decreases only.
+	Decrease
+)
+
+// PageHinkleyOption configures a PageHinkley.
+type PageHinkleyOption func(*PageHinkley)
+
+// WithDirection sets the direction of the changes detected, Both by
+// default.
+func WithDirection(d Direction) PageHinkleyOption {
+	return func(p *PageHinkley) {
+		p.direction = d
+	}
+}
+
+// WithMinSamples sets the number of values observed since the last
+// change, or the start, before a change can be reported, 30 by default,
+// so the mean the test compares with has settled.
+func WithMinSamples(n int) PageHinkleyOption {
+	return func(p *PageHinkley) {
+		p.minSamples = max(n, 1)
+	}
+}
+
+// PageHinkley is the Page-Hinkley test for a change in the mean of a
+// stream. It accumulates the departures of the values from their running
+// mean, less a tolerance delta, and reports a change once the sum has
+// risen more than lambda above its minimum; decreases are tested alike.
+// Delta is the smallest change in mean worth detecting, in the units of
+// the values, and lambda trades detection delay for false alarms: larger
+// values report fewer changes, later. It needs constant memory and time
+// per value, unlike ADWIN, but detects only changes in mean of at least
+// about delta.
+//
+// After a change the test restarts from the value that revealed it.
+// PageHinkley is not safe for concurrent use; a Monitor serializes its
+// calls.
+type PageHinkley struct {
+	delta, lambda float64
+	direction     Direction
+	minSamples    int
+
+	n    int
+	mean float64
+	// up and down are the cumulative departures tested for an increase
+	// and a decrease; upMin and downMax their extremes so far.
+	up, upMin     float64
+	down, downMax float64
+}
+
+// NewPageHinkley returns a Page-Hinkley test with tolerance delta and
+// threshold lambda, both positive. For anomaly scores in [0, 1], a delta
+// of 0.05 and a lambda of 5 are reasonable starting points.
+func NewPageHinkley(delta, lambda float64, opts ...PageHinkleyOption) *PageHinkley {
+	p := &PageHinkley{
+		delta:      max(delta, 0),
+		lambda:     lambda,
+		minSamples: 30,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Add observes x and reports whether the values since the last change
+// reveal one. NaN and infinite values are ignored.
+func (p *PageHinkley) Add(x float64) bool {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return false
+	}
+	p.n++
+	p.mean += (x - p.mean) / float64(p.n)
+	p.up += x - p.mean - p.delta
+	p.upMin = min(p.upMin, p.up)
+	p.down += x - p.mean + p.delta
+	p.downMax = max(p.downMax, p.down)
+
+	if p.n < p.minSamples || !(p.Statistic() > p.lambda) {
+		return false
+	}
+	p.Reset()
+	p.Add(x)
+	return true
+}
+
+// Statistic returns the test statistic, the largest rise of the
+// cumulative departures tested above their extreme, which a change must
+// take above lambda.
+func (p *PageHinkley) Statistic() float64 {
+	switch p.direction {
+	case Increase:
+		return p.up - p.upMin
+	case Decrease:
+		return p.downMax - p.down
+	default:
+		return max(p.up-p.upMin, p.downMax-p.down)
+	}
+}
+
+// Mean returns the mean of the values since the last change, 0 before
+// any.
+func (p *PageHinkley) Mean() float64 {
+	return p.mean
+}
+
+// Reset forgets every value observed.
+func (p *PageHinkley) Reset() {
+	p.n, p.mean = 0, 0
+	p.up, p.upMin = 0, 0
+	p.down, p.downMax = 0, 0
+}
+
+var _ Detector = (*PageHinkley)(nil)
@@ -0,0 +1,68 @@
+package drift
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// detectAfter feeds p 2000 values of mean 0 then up to 2000 of mean
+// shift, all with deviation 0.1, and returns the index among the latter
+// of the first change, -1 if none, and the number of changes before.
+func detectAfter(p *PageHinkley, shift float64, seed int64) (int, int) {
+	rng := rand.New(rand.NewSource(seed))
+	early := 0
+	for range 2000 {
+		if p.Add(0.1 * rng.NormFloat64()) {
+			early++
+		}
+	}
+	for i := range 2000 {
+		if p.Add(shift + 0.1*rng.NormFloat64()) {
+			return i, early
+		}
+	}
+	return -1, early
+}
+
+func TestPageHinkley(t *testing.T) {
+	up, early := detectAfter(NewPageHinkley(0.01, 5), 0.2, 1)
+	assert.Zero(t, early, "a stable stream does not change")
+	assert.GreaterOrEqual(t, up, 0, "an increase is detected")
+	assert.Less(t, up, 50, "and soon")
+
+	down, _ := detectAfter(NewPageHinkley(0.01, 5), -0.2, 1)
+	assert.GreaterOrEqual(t, down, 0, "a decrease is detected")
+
+	down, _ = detectAfter(NewPageHinkley(0.01, 5, WithDirection(Increase)), -0.2, 1)
+	assert.Equal(t, -1, down, "only increases are tested")
+	up, _ = detectAfter(NewPageHinkley(0.01, 5, WithDirection(Decrease)), 0.2, 1)
+	assert.Equal(t, -1, up, "only decreases are tested")
+
+	small, _ := detectAfter(NewPageHinkley(0.01, 5), 0.005, 1)
+	assert.Equal(t, -1, small, "changes below delta are tolerated")
+
+	late, _ := detectAfter(NewPageHinkley(0.01, 20), 0.2, 1)
+	assert.Greater(t, late, up, "a larger lambda detects later")
+}
+
+func TestPageHinkleyRestart(t *testing.T) {
+	p := NewPageHinkley(0.01, 1, WithMinSamples(10))
+	for range 100 {
+		assert.False(t, p.Add(0))
+	}
+	p.Add(math.NaN())
+	assert.Zero(t, p.Statistic(), "NaN is ignored")
+
+	changed := false
+	for !changed {
+		changed = p.Add(1)
+	}
+	assert.Equal(t, 1.0, p.Mean(), "the test restarts from the value revealing the change")
+	assert.Zero(t, p.Statistic())
+
+	p.Reset()
+	assert.Zero(t, p.Mean())
+}
@@ -0,0 +1,80 @@
+package drift
+
+import (
+	"context"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Watched is a StreamDetector whose scores a Monitor watches for drift.
+// It behaves as the detector it wraps; every score it produces through
+// Predict, PredictContext or PredictStream is also added to the Monitor,
+// in order, so a change in the score distribution reaches the Monitor's
+// handlers and channel.
+type Watched struct {
+	detectors.StreamDetector
+	monitor *Monitor
+}
+
+// Watch wraps d so that m watches its scores. m should watch nothing
+// else.
+func Watch(d detectors.StreamDetector, m *Monitor) *Watched {
+	return &Watched{StreamDetector: d, monitor: m}
+}
+
+// Detector returns the wrapped detector.
+func (w *Watched) Detector() detectors.StreamDetector {
+	return w.StreamDetector
+}
+
+// Monitor returns the Monitor watching the scores.
+func (w *Watched) Monitor() *Monitor {
+	return w.monitor
+}
+
+// Predict scores data with the wrapped detector and adds the scores to
+// the Monitor.
+func (w *Watched) Predict(data [][]float64) ([]float64, error) {
+	return w.PredictContext(context.Background(), data)
+}
+
+// PredictContext is Predict, abandoning the batch once ctx is done.
+func (w *Watched) PredictContext(ctx context.Context, data [][]float64) ([]float64, error) {
+	scores, err := w.StreamDetector.PredictContext(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scores {
+		w.monitor.AddScore(s)
+	}
+	return scores, nil
+}
+
+// PredictStream streams the wrapped detector's scores, adding each to the
+// Monitor before it is emitted. The output channel is closed when
+// PredictStream returns.
+func (w *Watched) PredictStream(ctx context.Context, input <-chan []float64, output chan<- detectors.Score) error {
+	defer close(output)
+
+	scores := make(chan detectors.Score, cap(output))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.StreamDetector.PredictStream(ctx, input, scores)
+	}()
+
+	for score := range scores {
+		w.monitor.AddScore(score.Value)
+		select {
+		case output <- score:
+		case <-ctx.Done():
+			// Let the wrapped stream wind down before returning.
+			for range scores {
+			}
+			<-errCh
+			return ctx.Err()
+		}
+	}
+	return <-errCh
+}
+
+var _ detectors.StreamDetector = (*Watched)(nil)
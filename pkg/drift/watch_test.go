@@ -0,0 +1,75 @@
+package drift
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/zscore"
+)
+
+// samples returns n samples of two normal features, shifted by shift
+// from the sample at index from on.
+func samples(n, from int, shift float64, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), rng.NormFloat64()}
+		if i >= from {
+			data[i][0] += shift
+		}
+	}
+	return data
+}
+
+func TestWatch(t *testing.T) {
+	d := zscore.New()
+	require.NoError(t, d.Fit(samples(2000, 0, 0, 1)))
+
+	var changes []Change
+	m := NewMonitor(func() Detector {
+		return NewPageHinkley(0.05, 5, WithDirection(Increase))
+	}, WithChangeHandler(func(c Change) {
+		changes = append(changes, c)
+	}))
+	w := Watch(d, m)
+	assert.Same(t, d, w.Detector())
+	assert.Same(t, m, w.Monitor())
+
+	live := samples(1000, 500, 3, 2)
+	input := make(chan []float64, len(live))
+	output := make(chan detectors.Score, len(live))
+	for _, s := range live {
+		input <- s
+	}
+	close(input)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, w.PredictStream(ctx, input, output))
+
+	want, err := d.Predict(live)
+	require.NoError(t, err)
+	var got []float64
+	for s := range output {
+		got = append(got, s.Value)
+	}
+	assert.Equal(t, want, got, "scores pass through unchanged")
+
+	require.NotEmpty(t, changes, "the rise in scores is detected")
+	assert.Greater(t, changes[0].Sample, uint64(500))
+	assert.Less(t, changes[0].Sample, uint64(550))
+	assert.Greater(t, changes[0].After, changes[0].Before)
+	assert.Equal(t, uint64(len(live)), m.Stats().Samples)
+
+	// Batches are watched too.
+	scores, err := w.Predict(live[:10])
+	require.NoError(t, err)
+	assert.Equal(t, want[:10], scores)
+	assert.Equal(t, uint64(len(live)+10), m.Stats().Samples)
+}
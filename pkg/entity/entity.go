@@ -0,0 +1,516 @@
+// Package entity keeps a small detector per entity, such as a user, a
+// host or an IP address, for baselining each one's behavior against its
+// own history.
+//
+// A Store creates entities as samples arrive for them. Each entity first
+// learns: its samples are buffered until there are enough, then a model
+// of its own is trained on them, and its later samples are scored by it.
+// The number of entities is bounded, evicting the least recently seen
+// when a new one would exceed it, and entities not seen within a TTL can
+// be expired, so a process can follow a large, changing population.
+// Models, and the samples of entities still learning, are saved and
+// restored in bulk with Save and Load.
+//
+// Typical use:
+//
+//	s := entity.New(
+//		func(string) detectors.Detector {
+//			return iforest.New(iforest.WithTrees(25), iforest.WithSampleSize(64))
+//		},
+//		entity.WithMinSamples(200),
+//		entity.WithMaxEntities(100000),
+//		entity.WithTTL(7*24*time.Hour),
+//	)
+//	score, scored, err := s.Observe(user, features)
+package entity
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/internal/lru"
+)
+
+// Store errors.
+var (
+	// ErrUnknownEntity is returned for entities the Store does not hold.
+	ErrUnknownEntity = errors.New("entity: unknown entity")
+	// ErrLearning is returned by Score for entities without a model yet.
+	ErrLearning = errors.New("entity: entity still learning")
+)
+
+// Factory creates the untrained detector of an entity.
+type Factory func(entity string) detectors.Detector
+
+// Reasons an entity is evicted.
+const (
+	// ReasonExpired is the reason of entities not seen within the TTL.
+	ReasonExpired = "expired"
+	// ReasonCapacity is the reason of entities evicted to make room for a
+	// new one.
+	ReasonCapacity = "capacity"
+)
+
+// Eviction is an entity the Store dropped on its own.
+type Eviction struct {
+	Entity string
+	// Detector is the entity's model, nil if it was still learning.
+	Detector detectors.Detector
+	// Reason is ReasonExpired or ReasonCapacity.
+	Reason string
+}
+
+// Stats holds the counters of a Store.
+type Stats struct {
+	// Entities is the number of entities held, Trained how many of them
+	// have a model.
+	Entities int `json:"entities"`
+	Trained  int `json:"trained"`
+	// Trainings and TrainErrors count the models trained and the
+	// trainings that failed.
+	Trainings   uint64 `json:"trainings"`
+	TrainErrors uint64 `json:"train_errors"`
+	// Evictions is the number of entities evicted or expired.
+	Evictions uint64 `json:"evictions"`
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMinSamples sets how many samples an entity learns from before its
+// model is trained. Defaults to 100.
+func WithMinSamples(n int) Option {
+	return func(s *Store) {
+		s.minSamples = n
+	}
+}
+
+// WithMaxEntities bounds the number of entities held at once. Defaults to
+// 10000.
+func WithMaxEntities(n int) Option {
+	return func(s *Store) {
+		s.maxEntities = n
+	}
+}
+
+// WithTTL sets how long an entity may go unseen before Expire drops it.
+// Zero, the default, keeps entities until they are evicted.
+func WithTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = d
+	}
+}
+
+// WithEvictHandler sets a function called with every entity the Store
+// evicts or expires, for instance to archive its model. It is called
+// without locks held.
+func WithEvictHandler(fn func(Eviction)) Option {
+	return func(s *Store) {
+		s.onEvict = fn
+	}
+}
+
+// Store holds a detector per entity. It is safe for concurrent use;
+// samples of different entities are learned and scored in parallel.
+type Store struct {
+	factory     Factory
+	minSamples  int
+	maxEntities int
+	ttl         time.Duration
+	onEvict     func(Eviction)
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entities *lru.List[string, *entity] // most recently seen first
+
+	trainings   atomic.Uint64
+	trainErrors atomic.Uint64
+	evictions   atomic.Uint64
+}
+
+// entity is the state of one entity. Its lastSeen is guarded by the
+// Store's lock, its model and samples by its own.
+type entity struct {
+	key      string
+	lastSeen time.Time
+
+	mu      sync.Mutex
+	model   detectors.Detector // nil while learning
+	samples [][]float64
+}
+
+// New creates an empty Store creating the models of entities with
+// factory.
+func New(factory Factory, opts ...Option) *Store {
+	s := &Store{
+		factory:     factory,
+		minSamples:  100,
+		maxEntities: 10000,
+		now:         time.Now,
+		entities:    lru.New[string, *entity](),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.minSamples = max(s.minSamples, 2)
+	s.maxEntities = max(s.maxEntities, 1)
+	return s
+}
+
+// Observe scores sample with the model of key, creating the entity if
+// needed. While the entity is still learning, sample is buffered instead,
+// and the model is trained once there are enough samples; scored reports
+// whether sample was scored. A training failure, such as on samples that
+// are all alike, is returned and the entity starts learning afresh.
+func (s *Store) Observe(key string, sample []float64) (score detectors.Score, scored bool, err error) {
+	e, evicted := s.get(key)
+	s.evicted(evicted, ReasonCapacity)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.model != nil {
+		score, err = s.score(e, sample)
+		return score, err == nil, err
+	}
+	e.samples = append(e.samples, slices.Clone(sample))
+	if len(e.samples) < s.minSamples {
+		return detectors.Score{}, false, nil
+	}
+	return detectors.Score{}, false, s.train(e)
+}
+
+// Score scores sample with the model of key without learning from it. It
+// returns an error wrapping ErrUnknownEntity or ErrLearning for entities
+// without a model.
+func (s *Store) Score(key string, sample []float64) (detectors.Score, error) {
+	s.mu.Lock()
+	e, ok := s.entities.Get(key)
+	if ok {
+		e.lastSeen = s.now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return detectors.Score{}, fmt.Errorf("%w %q", ErrUnknownEntity, key)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.model == nil {
+		return detectors.Score{}, fmt.Errorf("%w %q", ErrLearning, key)
+	}
+	return s.score(e, sample)
+}
+
+// score scores sample with the model of e. The caller holds e.mu.
+func (s *Store) score(e *entity, sample []float64) (detectors.Score, error) {
+	v, err := e.model.PredictOne(sample)
+	if err != nil {
+		return detectors.Score{}, fmt.Errorf("entity: %s: %w", e.key, err)
+	}
+	return detectors.Score{
+		Value:     v,
+		IsAnomaly: v >= detectors.ThresholdOf(e.model),
+		Features:  sample,
+		Metadata:  map[string]any{"entity": e.key},
+	}, nil
+}
+
+// Train trains the model of key, still learning, on the samples buffered
+// so far without waiting for enough of them. It needs at least two.
+func (s *Store) Train(key string) error {
+	s.mu.Lock()
+	e, ok := s.entities.Peek(key)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownEntity, key)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) < 2 {
+		return fmt.Errorf("entity: %s: %d samples, need at least 2", key, len(e.samples))
+	}
+	return s.train(e)
+}
+
+// train trains a model of e on its samples, which it then drops. The
+// caller holds e.mu.
+func (s *Store) train(e *entity) error {
+	samples := e.samples
+	e.samples = nil
+	model := s.factory(e.key)
+	if err := model.Fit(samples); err != nil {
+		s.trainErrors.Add(1)
+		return fmt.Errorf("entity: %s: train: %w", e.key, err)
+	}
+	e.model = model
+	s.trainings.Add(1)
+	return nil
+}
+
+// Detector returns the model of key, if it has one.
+func (s *Store) Detector(key string) (detectors.Detector, bool) {
+	s.mu.Lock()
+	e, ok := s.entities.Peek(key)
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.model, e.model != nil
+}
+
+// Remove drops key and its model. It reports whether the Store held it.
+func (s *Store) Remove(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entities.Remove(key)
+	return ok
+}
+
+// Entities returns the keys of the entities held, most recently seen
+// first.
+func (s *Store) Entities() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, s.entities.Len())
+	for key := range s.entities.All() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Stats returns the counters of the Store.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	held := make([]*entity, 0, s.entities.Len())
+	for _, e := range s.entities.All() {
+		held = append(held, e)
+	}
+	s.mu.Unlock()
+
+	st := Stats{
+		Entities:    len(held),
+		Trainings:   s.trainings.Load(),
+		TrainErrors: s.trainErrors.Load(),
+		Evictions:   s.evictions.Load(),
+	}
+	for _, e := range held {
+		e.mu.Lock()
+		if e.model != nil {
+			st.Trained++
+		}
+		e.mu.Unlock()
+	}
+	return st
+}
+
+// Expire drops the entities not seen since now minus the TTL and returns
+// their keys. It does nothing without a TTL.
+func (s *Store) Expire(now time.Time) []string {
+	if s.ttl <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-s.ttl)
+
+	s.mu.Lock()
+	var expired []*entity
+	// The list is ordered by last sighting: stop at the first recent one.
+	for key, e := range s.entities.Backward() {
+		if !e.lastSeen.Before(cutoff) {
+			break
+		}
+		s.entities.Remove(key)
+		expired = append(expired, e)
+	}
+	s.mu.Unlock()
+
+	s.evicted(expired, ReasonExpired)
+	keys := make([]string, len(expired))
+	for i, e := range expired {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// Run expires entities every interval until ctx is done.
+func (s *Store) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			s.Expire(now)
+		}
+	}
+}
+
+// get returns the entity of key, creating it if needed, and marks it
+// seen. It returns the entities evicted to make room for a new one.
+func (s *Store) get(key string) (*entity, []*entity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entities.Get(key); ok {
+		e.lastSeen = s.now()
+		return e, nil
+	}
+
+	var evicted []*entity
+	for s.entities.Len() >= s.maxEntities {
+		oldest, e, _ := s.entities.Oldest()
+		s.entities.Remove(oldest)
+		evicted = append(evicted, e)
+	}
+	e := &entity{key: key, lastSeen: s.now()}
+	s.entities.Push(key, e)
+	return e, evicted
+}
+
+// evicted counts the entities dropped and reports them to the evict
+// handler. The caller holds no locks.
+func (s *Store) evicted(entities []*entity, reason string) {
+	s.evictions.Add(uint64(len(entities)))
+	if s.onEvict == nil {
+		return
+	}
+	for _, e := range entities {
+		e.mu.Lock()
+		model := e.model
+		e.mu.Unlock()
+		s.onEvict(Eviction{Entity: e.key, Detector: model, Reason: reason})
+	}
+}
+
+// record is the saved form of an entity.
+type record struct {
+	Entity   string
+	LastSeen time.Time
+	// Model is the serialized model, nil while learning.
+	Model   []byte
+	Samples [][]float64
+}
+
+// header starts a saved Store, followed by one record per entity.
+type header struct {
+	Format   string
+	Version  int
+	Entities int
+}
+
+const (
+	format  = "goguardml-entities"
+	version = 1
+)
+
+// Save writes every entity to w, least recently seen first: the models of
+// trained entities and the samples of those still learning. Scoring and
+// learning may go on meanwhile; each entity is saved as it was at some
+// point during the call.
+func (s *Store) Save(w io.Writer) error {
+	s.mu.Lock()
+	held := make([]*entity, 0, s.entities.Len())
+	seen := make([]time.Time, 0, s.entities.Len())
+	for _, e := range s.entities.Backward() {
+		held = append(held, e)
+		seen = append(seen, e.lastSeen)
+	}
+	s.mu.Unlock()
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(header{Format: format, Version: version, Entities: len(held)}); err != nil {
+		return fmt.Errorf("entity: %w", err)
+	}
+	for i, e := range held {
+		rec, err := e.record(seen[i])
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("entity: %s: %w", e.key, err)
+		}
+	}
+	return nil
+}
+
+func (e *entity) record(lastSeen time.Time) (record, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rec := record{Entity: e.key, LastSeen: lastSeen, Samples: e.samples}
+	if e.model != nil {
+		data, err := e.model.Save()
+		if err != nil {
+			return record{}, fmt.Errorf("entity: %s: %w", e.key, err)
+		}
+		rec.Model = data
+	}
+	return rec, nil
+}
+
+// Load restores the entities saved by Save, replacing those with the
+// same keys; other entities are kept. Models are restored into detectors
+// created by the Factory. Entities beyond the bound are evicted as usual,
+// the least recently seen first. On error, the entities read before it
+// are kept.
+func (s *Store) Load(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var h header
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("entity: %w", err)
+	}
+	if h.Format != format {
+		return fmt.Errorf("entity: not a saved entity store")
+	}
+	if h.Version > version {
+		return fmt.Errorf("entity: unsupported format version %d", h.Version)
+	}
+
+	for range h.Entities {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			return fmt.Errorf("entity: %w", err)
+		}
+		e := &entity{key: rec.Entity, lastSeen: rec.LastSeen, samples: rec.Samples}
+		if rec.Model != nil {
+			e.model = s.factory(rec.Entity)
+			if err := e.model.Load(rec.Model); err != nil {
+				return fmt.Errorf("entity: %s: %w", rec.Entity, err)
+			}
+		}
+		s.evicted(s.put(e), ReasonCapacity)
+	}
+	return nil
+}
+
+// put adds e, replacing the entity with its key, in last-seen order, and
+// returns the entities evicted to make room for it.
+func (s *Store) put(e *entity) []*entity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities.Remove(e.key)
+
+	var evicted []*entity
+	for s.entities.Len() >= s.maxEntities {
+		key, oldest, _ := s.entities.Oldest()
+		if !oldest.lastSeen.Before(e.lastSeen) {
+			// e is the least recently seen: it does not make the cut.
+			return append(evicted, e)
+		}
+		s.entities.Remove(key)
+		evicted = append(evicted, oldest)
+	}
+
+	// Keep the list ordered by last sighting.
+	s.entities.Insert(e.key, e, func(other *entity) bool { return other.lastSeen.After(e.lastSeen) })
+	return evicted
+}
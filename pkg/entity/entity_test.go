@@ -0,0 +1,261 @@
+package entity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+// baseline scores samples by the distance of their first feature from
+// the training mean.
+type baseline struct {
+	Mean float64
+}
+
+func (b *baseline) Fit(data [][]float64) error {
+	var sum float64
+	for _, row := range data {
+		sum += row[0]
+	}
+	b.Mean = sum / float64(len(data))
+	for _, row := range data {
+		if row[0] != b.Mean {
+			return nil
+		}
+	}
+	return errors.New("constant data")
+}
+
+func (b *baseline) Predict(data [][]float64) ([]float64, error) { return nil, nil }
+
+func (b *baseline) PredictOne(sample []float64) (float64, error) {
+	if len(sample) == 0 {
+		return 0, errors.New("empty sample")
+	}
+	d := math.Abs(sample[0] - b.Mean)
+	return d / (1 + d), nil
+}
+
+func (b *baseline) Save() ([]byte, error)      { return json.Marshal(b) }
+func (b *baseline) Load(data []byte) error     { return json.Unmarshal(data, b) }
+func (b *baseline) SaveTo(w io.Writer) error   { return json.NewEncoder(w).Encode(b) }
+func (b *baseline) LoadFrom(r io.Reader) error { return json.NewDecoder(r).Decode(b) }
+
+func (b *baseline) PredictContext(_ context.Context, data [][]float64) ([]float64, error) {
+	return b.Predict(data)
+}
+
+func newBaseline(string) detectors.Detector { return &baseline{} }
+
+// clock is a settable time source.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func learn(t *testing.T, s *Store, key string, values ...float64) {
+	t.Helper()
+	for _, v := range values {
+		_, scored, err := s.Observe(key, []float64{v})
+		require.NoError(t, err)
+		require.False(t, scored)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	s := New(newBaseline, WithMinSamples(3))
+
+	learn(t, s, "alice", 9, 10, 11)
+	learn(t, s, "bob", 99)
+	_, err := s.Score("bob", []float64{99})
+	assert.ErrorIs(t, err, ErrLearning)
+	_, err = s.Score("carol", []float64{1})
+	assert.ErrorIs(t, err, ErrUnknownEntity)
+
+	score, scored, err := s.Observe("alice", []float64{10})
+	require.NoError(t, err)
+	assert.True(t, scored)
+	assert.False(t, score.IsAnomaly)
+	assert.Equal(t, "alice", score.Metadata["entity"])
+
+	score, err = s.Score("alice", []float64{100})
+	require.NoError(t, err)
+	assert.True(t, score.IsAnomaly, "far from alice's own baseline")
+
+	_, _, err = s.Observe("alice", nil)
+	assert.ErrorContains(t, err, "entity: alice: empty sample")
+
+	assert.Equal(t, Stats{Entities: 2, Trained: 1, Trainings: 1}, s.Stats())
+	assert.Equal(t, []string{"alice", "bob"}, s.Entities())
+}
+
+func TestTrainErrors(t *testing.T) {
+	s := New(newBaseline, WithMinSamples(2))
+	learn(t, s, "flat", 5)
+	_, _, err := s.Observe("flat", []float64{5})
+	assert.ErrorContains(t, err, "entity: flat: train: constant data")
+	assert.Equal(t, uint64(1), s.Stats().TrainErrors)
+
+	// The entity learns afresh.
+	learn(t, s, "flat", 5)
+	_, _, err = s.Observe("flat", []float64{6})
+	require.NoError(t, err)
+	_, ok := s.Detector("flat")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, s.Train("nobody"), ErrUnknownEntity)
+	assert.ErrorContains(t, s.Train("flat"), "0 samples")
+}
+
+func TestTrainEarly(t *testing.T) {
+	s := New(newBaseline, WithMinSamples(100))
+	learn(t, s, "alice", 1, 2)
+	require.NoError(t, s.Train("alice"))
+	d, ok := s.Detector("alice")
+	require.True(t, ok)
+	assert.Equal(t, 1.5, d.(*baseline).Mean)
+}
+
+func TestEviction(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	var evictions []Eviction
+	s := New(newBaseline, WithMinSamples(2), WithMaxEntities(2), WithTTL(time.Hour),
+		WithEvictHandler(func(e Eviction) { evictions = append(evictions, e) }))
+	s.now = c.now
+
+	learn(t, s, "a", 1, 2)
+	c.t = c.t.Add(time.Minute)
+	learn(t, s, "b", 1)
+	c.t = c.t.Add(time.Minute)
+	_, err := s.Score("a", []float64{1})
+	require.NoError(t, err)
+
+	// b is the least recently seen.
+	learn(t, s, "c", 1)
+	require.Len(t, evictions, 1)
+	assert.Equal(t, "b", evictions[0].Entity)
+	assert.Nil(t, evictions[0].Detector)
+	assert.Equal(t, ReasonCapacity, evictions[0].Reason)
+	assert.Equal(t, []string{"c", "a"}, s.Entities())
+
+	c.t = c.t.Add(61 * time.Minute)
+	learn(t, s, "c", 2) // trains c
+	assert.Equal(t, []string{"a"}, s.Expire(c.t))
+	require.Len(t, evictions, 2)
+	assert.Equal(t, Eviction{Entity: "a", Detector: evictions[1].Detector, Reason: ReasonExpired}, evictions[1])
+	assert.NotNil(t, evictions[1].Detector)
+	assert.Equal(t, []string{"c"}, s.Entities())
+	assert.Equal(t, uint64(2), s.Stats().Evictions)
+
+	assert.True(t, s.Remove("c"))
+	assert.False(t, s.Remove("c"))
+	assert.Nil(t, New(newBaseline).Expire(c.t), "no TTL")
+}
+
+func TestSaveLoad(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := New(newBaseline, WithMinSamples(3))
+	s.now = c.now
+	learn(t, s, "alice", 9, 10, 11)
+	c.t = c.t.Add(time.Minute)
+	learn(t, s, "bob", 1, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, s.Save(&buf))
+	saved := buf.Bytes()
+
+	restored := New(newBaseline, WithMinSamples(3))
+	require.NoError(t, restored.Load(bytes.NewReader(saved)))
+	assert.Equal(t, []string{"bob", "alice"}, restored.Entities())
+	score, err := restored.Score("alice", []float64{10})
+	require.NoError(t, err)
+	assert.Zero(t, score.Value)
+
+	// Bob picks up learning where he left off.
+	_, _, err = restored.Observe("bob", []float64{3})
+	require.NoError(t, err)
+	d, ok := restored.Detector("bob")
+	require.True(t, ok)
+	assert.Equal(t, 2.0, d.(*baseline).Mean)
+
+	// Over the bound, the most recently seen entities are kept.
+	small := New(newBaseline, WithMaxEntities(1))
+	require.NoError(t, small.Load(bytes.NewReader(saved)))
+	assert.Equal(t, []string{"bob"}, small.Entities())
+	assert.Equal(t, uint64(1), small.Stats().Evictions)
+
+	assert.Error(t, New(newBaseline).Load(bytes.NewReader([]byte("not a store"))))
+	assert.Error(t, New(newBaseline).Load(bytes.NewReader(saved[:len(saved)-8])))
+}
+
+func TestIsolationForestEntities(t *testing.T) {
+	s := New(func(string) detectors.Detector {
+		return iforest.New(iforest.WithTrees(25), iforest.WithSampleSize(64), iforest.WithSeed(1), iforest.WithContamination(0.01))
+	}, WithMinSamples(200))
+
+	// Traffic normal for one host is anomalous for the other.
+	for i := range 200 {
+		v := float64(i%20) / 20
+		_, _, err := s.Observe("db", []float64{100 + v, 10 + v})
+		require.NoError(t, err)
+		_, _, err = s.Observe("laptop", []float64{1 + v, 1 + v})
+		require.NoError(t, err)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, s.Save(&buf))
+	restored := New(func(string) detectors.Detector { return iforest.New() })
+	require.NoError(t, restored.Load(&buf))
+
+	for _, store := range []*Store{s, restored} {
+		score, err := store.Score("laptop", []float64{100.5, 10.5})
+		require.NoError(t, err)
+		assert.True(t, score.IsAnomaly)
+		score, err = store.Score("db", []float64{100.5, 10.5})
+		require.NoError(t, err)
+		assert.False(t, score.IsAnomaly)
+	}
+}
+
+func TestConcurrentEntities(t *testing.T) {
+	s := New(newBaseline, WithMinSamples(10), WithMaxEntities(8))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				key := fmt.Sprintf("e%d", (w+i)%12)
+				if _, _, err := s.Observe(key, []float64{float64(i)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			require.NoError(t, s.Save(&bytes.Buffer{}))
+			s.Stats()
+		}
+	}()
+	wg.Wait()
+	assert.LessOrEqual(t, len(s.Entities()), 8)
+}
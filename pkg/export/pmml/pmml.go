@@ -0,0 +1,243 @@
+// Package pmml exports trained tree ensembles as PMML 4.4 documents, for
+// scoring engines that consume the Predictive Model Markup Language.
+//
+// Isolation forests become an AnomalyDetectionModel of algorithm type
+// iforest wrapping a MiningModel that averages the path lengths of one
+// TreeModel per tree; the engine normalizes the mean path length into the
+// anomaly score. The document outputs the score as anomalyScore and
+// whether it reaches the detector threshold as anomaly.
+package pmml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+)
+
+// Version is the PMML version of exported documents.
+const Version = "4.4"
+
+// ErrUnsupported is returned for detectors that are not tree ensembles of
+// a kind PMML can describe.
+var ErrUnsupported = errors.New("pmml: detector cannot be exported to PMML")
+
+// Option configures Export.
+type Option func(*exporter)
+
+// WithFeatureNames names the input fields, overriding the feature names of
+// the model card. Fields without a name are called f0, f1, and so on.
+func WithFeatureNames(names []string) Option {
+	return func(e *exporter) {
+		e.names = names
+	}
+}
+
+// WithModelName sets the modelName of the exported model.
+func WithModelName(name string) Option {
+	return func(e *exporter) {
+		e.modelName = name
+	}
+}
+
+// WithNoise perturbs the training record counts of the tree nodes for
+// differential privacy. Each count spends the epsilon of n, and a training
+// record is counted by one node per level of every tree it was drawn
+// for. Split values and leaf scores, which scoring depends on, are kept.
+func WithNoise(n *privacy.Noise) Option {
+	return func(e *exporter) {
+		e.noise = n
+	}
+}
+
+type exporter struct {
+	names     []string
+	modelName string
+	card      detectors.ModelCard
+	noise     *privacy.Noise
+}
+
+// Export writes the trained detector d as a PMML document to w. d must
+// implement detectors.TreeEnsemble; feature names and the header are taken
+// from its model card when it implements detectors.Describer.
+func Export(w io.Writer, d detectors.Detector, opts ...Option) error {
+	te, ok := d.(detectors.TreeEnsemble)
+	if !ok {
+		return ErrUnsupported
+	}
+	ensemble, err := te.Ensemble()
+	if err != nil {
+		return err
+	}
+
+	var e exporter
+	if card, ok := detectors.MetadataOf(d); ok {
+		e.card = card
+		e.names = card.FeatureNames
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	doc, err := e.document(ensemble)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("pmml: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// document builds the PMML document of ensemble.
+func (e *exporter) document(ensemble detectors.Ensemble) (*document, error) {
+	if ensemble.Kind != detectors.EnsembleIsolationForest {
+		return nil, fmt.Errorf("%w: %s ensembles", ErrUnsupported, ensemble.Kind)
+	}
+	fields, err := e.fields(ensemble.Features)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{
+		Version: Version,
+		Header: header{
+			Description: "Isolation Forest exported by goguardml",
+			Application: application{Name: "goguardml", Version: detectors.LibraryVersion()},
+		},
+		DataDictionary: dataDictionary{NumberOfFields: len(fields)},
+	}
+	if !e.card.TrainedAt.IsZero() {
+		doc.Header.Timestamp = e.card.TrainedAt.UTC().Format(time.RFC3339)
+	}
+	if e.card.LibraryVersion != "" {
+		doc.Header.Application.Version = e.card.LibraryVersion
+	}
+
+	schema := miningSchema{Fields: make([]miningField, len(fields))}
+	for i, name := range fields {
+		doc.DataDictionary.Fields = append(doc.DataDictionary.Fields, dataField{Name: name, OpType: "continuous", DataType: "double"})
+		schema.Fields[i] = miningField{Name: name}
+	}
+
+	segments := make([]segment, len(ensemble.Trees))
+	for i, tree := range ensemble.Trees {
+		root, err := treeNodeOf(tree, fields)
+		if err != nil {
+			return nil, fmt.Errorf("pmml: tree %d: %w", i, err)
+		}
+		if e.noise != nil {
+			perturb(&root, e.noise)
+		}
+		root.True = &struct{}{}
+		segments[i] = segment{
+			ID:   strconv.Itoa(i + 1),
+			True: &struct{}{},
+			Tree: treeModel{
+				FunctionName:        "regression",
+				SplitCharacteristic: "binarySplit",
+				MiningSchema:        schema,
+				Node:                root,
+			},
+		}
+	}
+
+	doc.Model = anomalyDetectionModel{
+		ModelName:      e.modelName,
+		FunctionName:   "regression",
+		AlgorithmType:  "iforest",
+		SampleDataSize: ensemble.SampleSize,
+		MiningSchema:   schema,
+		Output: output{Fields: []outputField{
+			{Name: "anomalyScore", OpType: "continuous", DataType: "double", Feature: "predictedValue"},
+			{
+				Name: "anomaly", OpType: "categorical", DataType: "boolean", Feature: "transformedValue",
+				Apply: &apply{
+					Function: "greaterOrEqual",
+					FieldRef: &fieldRef{Field: "anomalyScore"},
+					Constant: &constant{DataType: "double", Value: formatFloat(ensemble.Threshold)},
+				},
+			},
+		}},
+		Forest: miningModel{
+			FunctionName: "regression",
+			MiningSchema: schema,
+			Segmentation: segmentation{MultipleModelMethod: "average", Segments: segments},
+		},
+	}
+	return doc, nil
+}
+
+// fields returns the names of n input fields.
+func (e *exporter) fields(n int) ([]string, error) {
+	if len(e.names) > n {
+		return nil, fmt.Errorf("pmml: %d feature names for %d features", len(e.names), n)
+	}
+	fields := make([]string, n)
+	seen := make(map[string]bool, n)
+	for i := range fields {
+		fields[i] = fmt.Sprintf("f%d", i)
+		if i < len(e.names) && e.names[i] != "" {
+			fields[i] = e.names[i]
+		}
+		if seen[fields[i]] {
+			return nil, fmt.Errorf("pmml: duplicate feature name %q", fields[i])
+		}
+		seen[fields[i]] = true
+	}
+	return fields, nil
+}
+
+// treeNodeOf converts the subtree at n. The children of internal nodes are
+// written right first, selected by a greaterOrEqual predicate, then left,
+// selected by True, so missing values go left as NaN does in Go.
+func treeNodeOf(n *detectors.TreeNode, fields []string) (node, error) {
+	if n == nil {
+		return node{}, errors.New("missing node")
+	}
+	out := node{RecordCount: n.Samples}
+	if n.Feature < 0 {
+		out.Score = formatFloat(n.Value)
+		return out, nil
+	}
+	if n.Feature >= len(fields) {
+		return node{}, fmt.Errorf("split on feature %d of %d", n.Feature, len(fields))
+	}
+	right, err := treeNodeOf(n.Right, fields)
+	if err != nil {
+		return node{}, err
+	}
+	right.Predicate = &simplePredicate{Field: fields[n.Feature], Operator: "greaterOrEqual", Value: formatFloat(n.Split)}
+	left, err := treeNodeOf(n.Left, fields)
+	if err != nil {
+		return node{}, err
+	}
+	left.True = &struct{}{}
+	out.Children = []node{right, left}
+	return out, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// perturb adds noise to the record counts of the subtree at n.
+func perturb(n *node, noise *privacy.Noise) {
+	n.RecordCount = noise.Count(n.RecordCount)
+	for i := range n.Children {
+		perturb(&n.Children[i], noise)
+	}
+}
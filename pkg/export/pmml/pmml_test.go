@@ -0,0 +1,166 @@
+package pmml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+)
+
+func trainingData(n int) [][]float64 {
+	rng := rand.New(rand.NewSource(1))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{rng.NormFloat64(), rng.NormFloat64() * 3, rng.Float64()}
+	}
+	return data
+}
+
+// evaluate scores sample with doc the way a PMML engine does.
+func evaluate(t *testing.T, doc *document, sample []float64) float64 {
+	t.Helper()
+	index := make(map[string]int)
+	for i, f := range doc.DataDictionary.Fields {
+		index[f.Name] = i
+	}
+	// matches evaluates a predicate; comparisons with missing values are
+	// false.
+	matches := func(n node) bool {
+		if n.True != nil {
+			return true
+		}
+		p := n.Predicate
+		require.NotNil(t, p)
+		require.Equal(t, "greaterOrEqual", p.Operator)
+		v, err := strconv.ParseFloat(p.Value, 64)
+		require.NoError(t, err)
+		return sample[index[p.Field]] >= v
+	}
+
+	var total float64
+	for _, s := range doc.Model.Forest.Segmentation.Segments {
+		n := s.Tree.Node
+		for len(n.Children) > 0 {
+			found := false
+			for _, child := range n.Children {
+				if matches(child) {
+					n, found = child, true
+					break
+				}
+			}
+			require.True(t, found)
+		}
+		v, err := strconv.ParseFloat(n.Score, 64)
+		require.NoError(t, err)
+		total += v
+	}
+	mean := total / float64(len(doc.Model.Forest.Segmentation.Segments))
+
+	size := float64(doc.Model.SampleDataSize)
+	c := 2*(math.Log(size-1)+0.5772156649) - 2*(size-1)/size
+	return math.Pow(2, -mean/c)
+}
+
+func exportDocument(t *testing.T, d detectors.Detector, opts ...Option) *document {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, d, opts...))
+	var doc document
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	return &doc
+}
+
+func TestExportIsolationForest(t *testing.T) {
+	for _, bits := range []int{0, 8} {
+		f := iforest.New(iforest.WithTrees(20), iforest.WithSampleSize(64), iforest.WithSeed(3), iforest.WithQuantization(bits))
+		require.NoError(t, f.Fit(trainingData(500)))
+
+		doc := exportDocument(t, f, WithModelName("flows"))
+		assert.Equal(t, "4.4", doc.Version)
+		assert.Equal(t, "http://www.dmg.org/PMML-4_4", doc.XMLName.Space)
+		assert.Equal(t, "flows", doc.Model.ModelName)
+		assert.Equal(t, "iforest", doc.Model.AlgorithmType)
+		assert.Equal(t, 64, doc.Model.SampleDataSize)
+		assert.Len(t, doc.Model.Forest.Segmentation.Segments, 20)
+		assert.Equal(t, 3, doc.DataDictionary.NumberOfFields)
+		assert.Equal(t, "f1", doc.DataDictionary.Fields[1].Name)
+		assert.Equal(t, strconv.FormatFloat(f.Threshold(), 'g', -1, 64), doc.Model.Output.Fields[1].Apply.Constant.Value)
+
+		samples := append(trainingData(50), []float64{8, -20, 5}, []float64{math.NaN(), 0, 0.5})
+		scores, err := f.Predict(samples)
+		require.NoError(t, err)
+		for i, sample := range samples {
+			assert.InDelta(t, scores[i], evaluate(t, doc, sample), 1e-12, "bits %d sample %d", bits, i)
+		}
+	}
+}
+
+func TestExportSmallTrainingSet(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(5), iforest.WithSampleSize(256), iforest.WithSeed(1))
+	require.NoError(t, f.Fit(trainingData(40)))
+
+	doc := exportDocument(t, f)
+	assert.Equal(t, 40, doc.Model.SampleDataSize)
+	scores, err := f.Predict(trainingData(5))
+	require.NoError(t, err)
+	for i, sample := range trainingData(5) {
+		assert.InDelta(t, scores[i], evaluate(t, doc, sample), 1e-12)
+	}
+}
+
+func TestExportFeatureNames(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(3), iforest.WithSeed(1))
+	require.NoError(t, f.Fit(trainingData(100)))
+
+	doc := exportDocument(t, f, WithFeatureNames([]string{"bytes<in>", "", "rate"}))
+	names := make([]string, len(doc.DataDictionary.Fields))
+	for i, field := range doc.DataDictionary.Fields {
+		names[i] = field.Name
+	}
+	assert.Equal(t, []string{"bytes<in>", "f1", "rate"}, names)
+
+	var buf bytes.Buffer
+	assert.Error(t, Export(&buf, f, WithFeatureNames([]string{"a", "a", "b"})))
+	assert.Error(t, Export(&buf, f, WithFeatureNames([]string{"a", "b", "c", "d"})))
+}
+
+func TestExportUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	assert.ErrorIs(t, Export(&buf, struct{ detectors.Detector }{}), ErrUnsupported)
+	assert.Error(t, Export(&buf, iforest.New()), "untrained")
+}
+
+func TestExportNoise(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSampleSize(64), iforest.WithSeed(2))
+	require.NoError(t, f.Fit(trainingData(300)))
+	noise, err := privacy.NewNoise(0.5)
+	require.NoError(t, err)
+
+	exact := exportDocument(t, f)
+	noisy := exportDocument(t, f, WithNoise(noise))
+	changed := 0
+	for i, s := range noisy.Model.Forest.Segmentation.Segments {
+		root := s.Tree.Node
+		assert.GreaterOrEqual(t, root.RecordCount, 0)
+		if root.RecordCount != exact.Model.Forest.Segmentation.Segments[i].Tree.Node.RecordCount {
+			changed++
+		}
+	}
+	assert.Positive(t, changed)
+
+	// Scoring is unaffected.
+	scores, err := f.Predict(trainingData(5))
+	require.NoError(t, err)
+	for i, sample := range trainingData(5) {
+		assert.InDelta(t, scores[i], evaluate(t, noisy, sample), 1e-12)
+	}
+}
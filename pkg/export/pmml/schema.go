@@ -0,0 +1,120 @@
+package pmml
+
+import "encoding/xml"
+
+// The elements of PMML 4.4 written by Export. Child elements are declared
+// in the order the PMML schema requires.
+
+type document struct {
+	XMLName        xml.Name              `xml:"http://www.dmg.org/PMML-4_4 PMML"`
+	Version        string                `xml:"version,attr"`
+	Header         header                `xml:"Header"`
+	DataDictionary dataDictionary        `xml:"DataDictionary"`
+	Model          anomalyDetectionModel `xml:"AnomalyDetectionModel"`
+}
+
+type header struct {
+	Description string      `xml:"description,attr,omitempty"`
+	Application application `xml:"Application"`
+	Timestamp   string      `xml:"Timestamp,omitempty"`
+}
+
+type application struct {
+	Name    string `xml:"name,attr"`
+	Version string `xml:"version,attr,omitempty"`
+}
+
+type dataDictionary struct {
+	NumberOfFields int         `xml:"numberOfFields,attr"`
+	Fields         []dataField `xml:"DataField"`
+}
+
+type dataField struct {
+	Name     string `xml:"name,attr"`
+	OpType   string `xml:"optype,attr"`
+	DataType string `xml:"dataType,attr"`
+}
+
+type miningSchema struct {
+	Fields []miningField `xml:"MiningField"`
+}
+
+type miningField struct {
+	Name string `xml:"name,attr"`
+}
+
+type output struct {
+	Fields []outputField `xml:"OutputField"`
+}
+
+type outputField struct {
+	Name     string `xml:"name,attr"`
+	OpType   string `xml:"optype,attr"`
+	DataType string `xml:"dataType,attr"`
+	Feature  string `xml:"feature,attr"`
+	Apply    *apply `xml:"Apply"`
+}
+
+type apply struct {
+	Function string    `xml:"function,attr"`
+	FieldRef *fieldRef `xml:"FieldRef"`
+	Constant *constant `xml:"Constant"`
+}
+
+type fieldRef struct {
+	Field string `xml:"field,attr"`
+}
+
+type constant struct {
+	DataType string `xml:"dataType,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type anomalyDetectionModel struct {
+	ModelName      string       `xml:"modelName,attr,omitempty"`
+	FunctionName   string       `xml:"functionName,attr"`
+	AlgorithmType  string       `xml:"algorithmType,attr"`
+	SampleDataSize int          `xml:"sampleDataSize,attr"`
+	MiningSchema   miningSchema `xml:"MiningSchema"`
+	Output         output       `xml:"Output"`
+	Forest         miningModel  `xml:"MiningModel"`
+}
+
+type miningModel struct {
+	FunctionName string       `xml:"functionName,attr"`
+	MiningSchema miningSchema `xml:"MiningSchema"`
+	Segmentation segmentation `xml:"Segmentation"`
+}
+
+type segmentation struct {
+	MultipleModelMethod string    `xml:"multipleModelMethod,attr"`
+	Segments            []segment `xml:"Segment"`
+}
+
+type segment struct {
+	ID   string    `xml:"id,attr"`
+	True *struct{} `xml:"True"`
+	Tree treeModel `xml:"TreeModel"`
+}
+
+type treeModel struct {
+	FunctionName        string       `xml:"functionName,attr"`
+	SplitCharacteristic string       `xml:"splitCharacteristic,attr"`
+	MiningSchema        miningSchema `xml:"MiningSchema"`
+	Node                node         `xml:"Node"`
+}
+
+// node is a tree node, selected by True or Predicate.
+type node struct {
+	Score       string           `xml:"score,attr,omitempty"`
+	RecordCount int              `xml:"recordCount,attr"`
+	True        *struct{}        `xml:"True"`
+	Predicate   *simplePredicate `xml:"SimplePredicate"`
+	Children    []node           `xml:"Node"`
+}
+
+type simplePredicate struct {
+	Field    string `xml:"field,attr"`
+	Operator string `xml:"operator,attr"`
+	Value    string `xml:"value,attr"`
+}
@@ -0,0 +1,357 @@
+package feedback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Target holds the thresholds an Adapter adjusts, by key.
+// *router.Router and *manager.Manager are Targets; Single makes one of a
+// detector. Targets that also have a method
+//
+//	Detector(key string) (detectors.Detector, bool)
+//
+// have the weights of their Weighted detectors adapted too.
+type Target interface {
+	Threshold(key string) (float64, error)
+	SetThreshold(key string, t float64) error
+}
+
+// Weighted is implemented by detectors combining the scores of members
+// with adjustable weights.
+type Weighted interface {
+	// Weights returns the member weights, in member order.
+	Weights() []float64
+
+	// SetWeights replaces the member weights.
+	SetWeights(w []float64) error
+}
+
+// ErrNoThreshold is returned by the Target of Single for detectors
+// without an adjustable threshold.
+var ErrNoThreshold = errors.New("feedback: detector has no adjustable threshold")
+
+// Single returns the Target of a single detector, whatever the key.
+func Single(d detectors.Detector) Target {
+	return single{d}
+}
+
+type single struct {
+	d detectors.Detector
+}
+
+func (s single) Threshold(string) (float64, error) {
+	return detectors.ThresholdOf(s.d), nil
+}
+
+func (s single) SetThreshold(_ string, t float64) error {
+	th, ok := s.d.(detectors.Thresholder)
+	if !ok {
+		return ErrNoThreshold
+	}
+	th.SetThreshold(t)
+	return nil
+}
+
+func (s single) Detector(string) (detectors.Detector, bool) {
+	return s.d, true
+}
+
+// Adjustment is the outcome of adapting to feedback.
+type Adjustment struct {
+	Key string `json:"key"`
+	// Feedback is the number of feedback records considered.
+	Feedback int `json:"feedback"`
+	// Previous and Threshold are the thresholds before and after.
+	Previous  float64 `json:"previous"`
+	Threshold float64 `json:"threshold"`
+	// Optimal is the threshold that would have made the fewest mistakes
+	// on the feedback, which Threshold moves toward.
+	Optimal float64 `json:"optimal"`
+	// Weights are the member weights after adapting, if they were.
+	Weights []float64 `json:"weights,omitempty"`
+}
+
+// Changed reports whether the threshold changed.
+func (a Adjustment) Changed() bool {
+	return a.Threshold != a.Previous
+}
+
+// Summary counts the feedback of a key.
+type Summary struct {
+	Key            string `json:"key"`
+	TruePositives  int    `json:"true_positives"`
+	FalsePositives int    `json:"false_positives"`
+	FalseNegatives int    `json:"false_negatives"`
+	// Precision is the share of judged anomalies that were real, 0
+	// without any.
+	Precision float64 `json:"precision"`
+}
+
+// Option configures an Adapter.
+type Option func(*Adapter)
+
+// WithAggressiveness sets the fraction of the way to the optimal
+// threshold an adjustment moves, and the learning rate of the weights, in
+// (0, 1]. Defaults to 0.25.
+func WithAggressiveness(a float64) Option {
+	return func(ad *Adapter) {
+		ad.aggressiveness = a
+	}
+}
+
+// WithMinFeedback sets how many feedback records a key needs before its
+// threshold is adjusted. Defaults to 10.
+func WithMinFeedback(n int) Option {
+	return func(ad *Adapter) {
+		ad.minFeedback = n
+	}
+}
+
+// WithWindow sets how many of the most recent feedback records of a key
+// are considered. Defaults to 500.
+func WithWindow(n int) Option {
+	return func(ad *Adapter) {
+		ad.window = n
+	}
+}
+
+// WithMissCost sets the cost of missing a real anomaly relative to that
+// of a false positive. Above 1, the threshold errs toward flagging more.
+// Defaults to 1.
+func WithMissCost(c float64) Option {
+	return func(ad *Adapter) {
+		ad.missCost = c
+	}
+}
+
+// WithThresholdBounds keeps adjusted thresholds within [lo, hi].
+// Defaults to [0, 1].
+func WithThresholdBounds(lo, hi float64) Option {
+	return func(ad *Adapter) {
+		ad.lo, ad.hi = lo, hi
+	}
+}
+
+// WithMaxStep bounds how far a single adjustment moves a threshold.
+// Defaults to 0.05; values below 0 mean no bound.
+func WithMaxStep(d float64) Option {
+	return func(ad *Adapter) {
+		ad.maxStep = d
+	}
+}
+
+// Adapter stores feedback and adapts thresholds and weights to it. It is
+// safe for concurrent use.
+type Adapter struct {
+	store  Store
+	target Target
+
+	aggressiveness float64
+	minFeedback    int
+	window         int
+	missCost       float64
+	lo, hi         float64
+	maxStep        float64
+
+	// mu serializes adjustments, so concurrent feedback is not lost
+	// between reading and setting a threshold.
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New creates an Adapter storing feedback in store and adjusting the
+// thresholds of target.
+func New(store Store, target Target, opts ...Option) *Adapter {
+	a := &Adapter{
+		store:          store,
+		target:         target,
+		aggressiveness: 0.25,
+		minFeedback:    10,
+		window:         500,
+		missCost:       1,
+		lo:             0,
+		hi:             1,
+		maxStep:        0.05,
+		now:            time.Now,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.aggressiveness = math.Min(math.Max(a.aggressiveness, 0), 1)
+	a.minFeedback = max(a.minFeedback, 1)
+	a.missCost = math.Max(a.missCost, 0)
+	return a
+}
+
+// Submit stores fb and adapts the threshold, and weights, of its key.
+func (a *Adapter) Submit(ctx context.Context, fb Feedback) (Adjustment, error) {
+	if err := fb.Validate(); err != nil {
+		return Adjustment{}, err
+	}
+	if fb.Time.IsZero() {
+		fb.Time = a.now().UTC()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.target.Threshold(fb.Key); err != nil {
+		return Adjustment{}, err
+	}
+	if err := a.store.Add(ctx, fb); err != nil {
+		return Adjustment{}, fmt.Errorf("feedback: store: %w", err)
+	}
+	weights, err := a.adaptWeights(fb)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	adj, err := a.adapt(ctx, fb.Key)
+	adj.Weights = weights
+	return adj, err
+}
+
+// Adapt adjusts the threshold of key to the feedback stored, without new
+// feedback, for instance after the threshold was reset.
+func (a *Adapter) Adapt(ctx context.Context, key string) (Adjustment, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.adapt(ctx, key)
+}
+
+func (a *Adapter) adapt(ctx context.Context, key string) (Adjustment, error) {
+	current, err := a.target.Threshold(key)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	records, err := a.store.Recent(ctx, key, a.window)
+	if err != nil {
+		return Adjustment{}, fmt.Errorf("feedback: store: %w", err)
+	}
+	adj := Adjustment{Key: key, Feedback: len(records), Previous: current, Threshold: current, Optimal: current}
+	if len(records) < a.minFeedback {
+		return adj, nil
+	}
+
+	adj.Optimal = a.optimal(records, current)
+	step := a.aggressiveness * (adj.Optimal - current)
+	if a.maxStep >= 0 {
+		step = math.Max(-a.maxStep, math.Min(a.maxStep, step))
+	}
+	next := math.Max(a.lo, math.Min(a.hi, current+step))
+	if next == current {
+		return adj, nil
+	}
+	if err := a.target.SetThreshold(key, next); err != nil {
+		return adj, err
+	}
+	adj.Threshold = next
+	return adj, nil
+}
+
+// optimal returns the threshold minimizing the cost of the mistakes it
+// would have made on records: false positives at or above it, and real
+// anomalies, at missCost each, below it. Among equally good thresholds it
+// returns the one closest to current.
+func (a *Adapter) optimal(records []Feedback, current float64) float64 {
+	candidates := []float64{current}
+	for _, fb := range records {
+		// Thresholds at a score flag it, just above it do not.
+		candidates = append(candidates, fb.Score, math.Nextafter(fb.Score, math.Inf(1)))
+	}
+
+	best, bestCost := current, math.Inf(1)
+	for _, t := range candidates {
+		var cost float64
+		for _, fb := range records {
+			switch {
+			case fb.anomalous() && fb.Score < t:
+				cost += a.missCost
+			case !fb.anomalous() && fb.Score >= t:
+				cost++
+			}
+		}
+		if cost < bestCost || (cost == bestCost && math.Abs(t-current) < math.Abs(best-current)) {
+			best, bestCost = t, cost
+		}
+	}
+	return best
+}
+
+// adaptWeights updates the member weights of fb's detector, if it is
+// Weighted and fb has a score per member, by multiplicative weights:
+// each member loses weight in proportion to how wrong its score was, its
+// score for false positives and its shortfall from 1 for real anomalies.
+// It returns the new weights, or nil if there were none to update.
+func (a *Adapter) adaptWeights(fb Feedback) ([]float64, error) {
+	if len(fb.Components) == 0 {
+		return nil, nil
+	}
+	withDetector, ok := a.target.(interface {
+		Detector(key string) (detectors.Detector, bool)
+	})
+	if !ok {
+		return nil, nil
+	}
+	d, ok := withDetector.Detector(fb.Key)
+	if !ok {
+		return nil, nil
+	}
+	wd, ok := d.(Weighted)
+	if !ok {
+		return nil, nil
+	}
+	weights := wd.Weights()
+	if len(weights) != len(fb.Components) {
+		return nil, fmt.Errorf("%w: %d component scores for %d members", ErrInvalid, len(fb.Components), len(weights))
+	}
+
+	var sum float64
+	next := make([]float64, len(weights))
+	for i, w := range weights {
+		loss := fb.Components[i]
+		if fb.anomalous() {
+			loss = 1 - loss
+		}
+		next[i] = w * math.Exp(-a.aggressiveness*math.Max(0, math.Min(1, loss)))
+		sum += next[i]
+	}
+	if sum <= 0 {
+		return nil, nil
+	}
+	for i := range next {
+		next[i] /= sum
+	}
+	if err := wd.SetWeights(next); err != nil {
+		return nil, fmt.Errorf("feedback: set weights: %w", err)
+	}
+	return next, nil
+}
+
+// Summary counts the feedback considered for key.
+func (a *Adapter) Summary(ctx context.Context, key string) (Summary, error) {
+	records, err := a.store.Recent(ctx, key, a.window)
+	if err != nil {
+		return Summary{}, fmt.Errorf("feedback: store: %w", err)
+	}
+	s := Summary{Key: key}
+	for _, fb := range records {
+		switch fb.Verdict {
+		case TruePositive:
+			s.TruePositives++
+		case FalsePositive:
+			s.FalsePositives++
+		case FalseNegative:
+			s.FalseNegatives++
+		}
+	}
+	if judged := s.TruePositives + s.FalsePositives; judged > 0 {
+		s.Precision = float64(s.TruePositives) / float64(judged)
+	}
+	return s, nil
+}
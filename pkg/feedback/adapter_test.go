@@ -0,0 +1,219 @@
+package feedback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/manager"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+var (
+	_ Target = (*router.Router)(nil)
+	_ Target = (*manager.Manager)(nil)
+)
+
+// model is a detector with an adjustable threshold and, optionally,
+// member weights.
+type model struct {
+	threshold float64
+	weights   []float64
+}
+
+func (m *model) Fit([][]float64) error                  { return nil }
+func (m *model) Predict([][]float64) ([]float64, error) { return nil, nil }
+func (m *model) PredictOne([]float64) (float64, error)  { return 0, nil }
+func (m *model) Save() ([]byte, error)                  { return nil, nil }
+func (m *model) Load([]byte) error                      { return nil }
+func (m *model) SaveTo(io.Writer) error                 { return nil }
+func (m *model) LoadFrom(io.Reader) error               { return nil }
+func (m *model) Threshold() float64                     { return m.threshold }
+func (m *model) SetThreshold(t float64)                 { m.threshold = t }
+func (m *model) Weights() []float64                     { return m.weights }
+func (m *model) SetWeights(w []float64) error           { m.weights = w; return nil }
+
+func (m *model) PredictContext(_ context.Context, data [][]float64) ([]float64, error) {
+	return m.Predict(data)
+}
+
+func submit(t *testing.T, a *Adapter, fb ...Feedback) Adjustment {
+	t.Helper()
+	var adj Adjustment
+	for _, f := range fb {
+		var err error
+		adj, err = a.Submit(context.Background(), f)
+		require.NoError(t, err)
+	}
+	return adj
+}
+
+func repeat(fb Feedback, n int) []Feedback {
+	out := make([]Feedback, n)
+	for i := range out {
+		out[i] = fb
+	}
+	return out
+}
+
+func TestAdaptRaisesThresholdOnFalsePositives(t *testing.T) {
+	m := &model{threshold: 0.6}
+	a := New(NewMemoryStore(0), Single(m), WithMinFeedback(5), WithAggressiveness(0.5), WithMaxStep(-1))
+
+	adj := submit(t, a, repeat(Feedback{Score: 0.62, Verdict: FalsePositive}, 4)...)
+	assert.False(t, adj.Changed(), "below the minimum feedback")
+	assert.Equal(t, 0.6, m.threshold)
+
+	adj = submit(t, a, Feedback{Score: 0.65, Verdict: FalsePositive})
+	assert.Equal(t, 5, adj.Feedback)
+	assert.InDelta(t, 0.65, adj.Optimal, 1e-9, "just above the highest false positive")
+	assert.Greater(t, adj.Optimal, 0.65)
+	assert.Equal(t, 0.6, adj.Previous)
+	assert.InDelta(t, 0.6+0.5*(adj.Optimal-0.6), m.threshold, 1e-12)
+
+	// Repeated adjustments converge on the optimum, never past it.
+	submit(t, a, Feedback{Score: 0.8, Verdict: TruePositive})
+	for range 20 {
+		_, err := a.Adapt(context.Background(), "")
+		require.NoError(t, err)
+	}
+	assert.InDelta(t, adj.Optimal, m.threshold, 1e-4)
+	assert.LessOrEqual(t, m.threshold, adj.Optimal)
+}
+
+func TestAdaptLowersThresholdOnMisses(t *testing.T) {
+	m := &model{threshold: 0.7}
+	a := New(NewMemoryStore(0), Single(m), WithMinFeedback(2), WithAggressiveness(1), WithMaxStep(0.05))
+
+	adj := submit(t, a,
+		Feedback{Score: 0.55, Verdict: FalseNegative},
+		Feedback{Score: 0.5, Verdict: FalsePositive},
+	)
+	assert.Equal(t, 0.55, adj.Optimal)
+	assert.InDelta(t, 0.65, m.threshold, 1e-12, "bounded by the maximum step")
+}
+
+func TestAdaptMissCost(t *testing.T) {
+	records := []Feedback{
+		{Score: 0.7, Verdict: FalsePositive},
+		{Score: 0.75, Verdict: FalsePositive},
+		{Score: 0.8, Verdict: TruePositive},
+		{Score: 0.65, Verdict: FalseNegative},
+	}
+	for _, tt := range []struct {
+		missCost float64
+		want     float64
+	}{
+		{missCost: 1, want: 0.75},
+		{missCost: 5, want: 0.6},
+	} {
+		m := &model{threshold: 0.6}
+		a := New(NewMemoryStore(0), Single(m), WithMinFeedback(len(records)), WithMissCost(tt.missCost))
+		adj := submit(t, a, records...)
+		assert.InDelta(t, tt.want, adj.Optimal, 1e-9, "miss cost %v", tt.missCost)
+	}
+}
+
+func TestAdaptBounds(t *testing.T) {
+	m := &model{threshold: 0.6}
+	a := New(NewMemoryStore(0), Single(m), WithMinFeedback(1), WithAggressiveness(1), WithMaxStep(-1), WithThresholdBounds(0.45, 1))
+	adj := submit(t, a, Feedback{Score: 0.3, Verdict: FalseNegative})
+	assert.Equal(t, 0.3, adj.Optimal)
+	assert.Equal(t, 0.45, m.threshold)
+}
+
+func TestAdaptWeights(t *testing.T) {
+	m := &model{threshold: 0.5, weights: []float64{0.5, 0.5}}
+	a := New(NewMemoryStore(0), Single(m), WithAggressiveness(1))
+
+	// The first member scored the false positive high: it loses weight.
+	adj := submit(t, a, Feedback{Score: 0.6, Verdict: FalsePositive, Components: []float64{0.9, 0.2}})
+	require.Len(t, adj.Weights, 2)
+	assert.Less(t, m.weights[0], m.weights[1])
+	assert.InDelta(t, 1, m.weights[0]+m.weights[1], 1e-12)
+
+	// The second member missed an anomaly the first caught.
+	before := m.weights[1]
+	submit(t, a, Feedback{Score: 0.4, Verdict: FalseNegative, Components: []float64{0.8, 0.1}})
+	assert.Less(t, m.weights[1], before)
+
+	_, err := a.Submit(context.Background(), Feedback{Score: 0.6, Verdict: TruePositive, Components: []float64{0.9}})
+	assert.ErrorIs(t, err, ErrInvalid, "one score per member")
+
+	// Detectors without weights ignore component scores.
+	plain := New(NewMemoryStore(0), Target(keyed{}))
+	adj, err = plain.Submit(context.Background(), Feedback{Score: 0.6, Verdict: TruePositive, Components: []float64{0.9}})
+	require.NoError(t, err)
+	assert.Nil(t, adj.Weights)
+}
+
+func TestAdaptFallbackRoute(t *testing.T) {
+	m := &model{threshold: 0.6}
+	r := router.New(router.WithFallback(m))
+	a := New(NewMemoryStore(0), r, WithMinFeedback(1), WithAggressiveness(1), WithMaxStep(-1))
+
+	// eth9 has no route of its own: the fallback scores it.
+	adj, err := a.Submit(context.Background(), Feedback{Key: "eth9", Score: 0.65, Verdict: FalsePositive})
+	require.NoError(t, err)
+	require.True(t, adj.Changed())
+	threshold, err := r.Threshold("eth9")
+	require.NoError(t, err)
+	assert.Equal(t, adj.Threshold, threshold)
+	assert.Greater(t, threshold, 0.65)
+}
+
+// keyed is a Target with thresholds for key "known" only.
+type keyed struct{}
+
+func (keyed) Threshold(key string) (float64, error) {
+	if key != "known" && key != "" {
+		return 0, errors.New("unknown key")
+	}
+	return 0.5, nil
+}
+func (keyed) SetThreshold(string, float64) error { return nil }
+
+func TestSubmitErrors(t *testing.T) {
+	store := NewMemoryStore(0)
+	a := New(store, keyed{})
+	_, err := a.Submit(context.Background(), Feedback{Score: 0.6, Verdict: "bogus"})
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = a.Submit(context.Background(), Feedback{Key: "other", Score: 0.6, Verdict: TruePositive})
+	assert.Error(t, err)
+	assert.Empty(t, store.Keys(), "rejected feedback is not stored")
+
+	noThreshold := New(NewMemoryStore(0), Single(noThresholdDetector{}), WithMinFeedback(1))
+	_, err = noThreshold.Submit(context.Background(), Feedback{Score: 0.9, Verdict: FalsePositive})
+	assert.ErrorIs(t, err, ErrNoThreshold)
+}
+
+type noThresholdDetector struct{}
+
+func (noThresholdDetector) Fit([][]float64) error                  { return nil }
+func (noThresholdDetector) Predict([][]float64) ([]float64, error) { return nil, nil }
+func (noThresholdDetector) PredictOne([]float64) (float64, error)  { return 0, nil }
+func (noThresholdDetector) Save() ([]byte, error)                  { return nil, nil }
+func (noThresholdDetector) Load([]byte) error                      { return nil }
+func (noThresholdDetector) SaveTo(io.Writer) error                 { return nil }
+func (noThresholdDetector) LoadFrom(io.Reader) error               { return nil }
+
+func (d noThresholdDetector) PredictContext(_ context.Context, data [][]float64) ([]float64, error) {
+	return d.Predict(data)
+}
+
+func TestSummary(t *testing.T) {
+	a := New(NewMemoryStore(0), keyed{})
+	submit(t, a,
+		Feedback{Key: "known", Score: 0.9, Verdict: TruePositive},
+		Feedback{Key: "known", Score: 0.9, Verdict: TruePositive},
+		Feedback{Key: "known", Score: 0.7, Verdict: FalsePositive},
+		Feedback{Key: "known", Score: 0.3, Verdict: FalseNegative},
+	)
+	s, err := a.Summary(context.Background(), "known")
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Key: "known", TruePositives: 2, FalsePositives: 1, FalseNegatives: 1, Precision: 2.0 / 3}, s)
+}
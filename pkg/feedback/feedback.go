@@ -0,0 +1,191 @@
+// Package feedback closes the loop between analysts and detectors.
+//
+// Analysts mark emitted anomalies as true or false positives, and report
+// the anomalies a detector missed. Feedback is kept in a Store, and an
+// Adapter uses it to move the threshold of the detector it is about
+// toward the one that would have made the fewest mistakes on it, by a
+// configurable fraction per adjustment. For detectors combining weighted
+// members, the weights shift away from the members that scored false
+// positives high and true anomalies low.
+package feedback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Verdict is an analyst's judgment of a sample.
+type Verdict string
+
+// Verdicts.
+const (
+	// TruePositive marks an emitted anomaly as a real one.
+	TruePositive Verdict = "true_positive"
+	// FalsePositive marks an emitted anomaly as normal.
+	FalsePositive Verdict = "false_positive"
+	// FalseNegative marks a sample scored as normal as a real anomaly.
+	FalseNegative Verdict = "false_negative"
+)
+
+// ErrInvalid is returned for feedback that cannot be used.
+var ErrInvalid = errors.New("feedback: invalid")
+
+// Feedback is an analyst's judgment of a scored sample.
+type Feedback struct {
+	// ID identifies the result judged, e.g. an alert ID. Optional.
+	ID string `json:"id,omitempty"`
+	// Key names the detector that scored the sample: a router key or a
+	// tenant; empty for a single detector.
+	Key string `json:"key,omitempty"`
+	// Score is the sample's anomaly score.
+	Score   float64 `json:"score"`
+	Verdict Verdict `json:"verdict"`
+	// Components holds the scores of the members of a weighted detector,
+	// in member order, for adapting their weights. Optional.
+	Components []float64 `json:"components,omitempty"`
+	// Features is the sample. Optional.
+	Features []float64 `json:"features,omitempty"`
+	Analyst  string    `json:"analyst,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	// Time is when the feedback was given; the Adapter stamps it if zero.
+	Time time.Time `json:"time"`
+}
+
+// Validate checks that fb can be used.
+func (fb Feedback) Validate() error {
+	switch fb.Verdict {
+	case TruePositive, FalsePositive, FalseNegative:
+	default:
+		return fmt.Errorf("%w verdict %q", ErrInvalid, fb.Verdict)
+	}
+	if fb.Score < 0 || fb.Score > 1 || fb.Score != fb.Score {
+		return fmt.Errorf("%w score %v: want [0, 1]", ErrInvalid, fb.Score)
+	}
+	return nil
+}
+
+// anomalous reports whether the sample was a real anomaly.
+func (fb Feedback) anomalous() bool {
+	return fb.Verdict != FalsePositive
+}
+
+// Store keeps feedback.
+type Store interface {
+	// Add stores fb.
+	Add(ctx context.Context, fb Feedback) error
+
+	// Recent returns the last n feedback records for key, oldest first;
+	// all of them if n < 1.
+	Recent(ctx context.Context, key string, n int) ([]Feedback, error)
+}
+
+// MemoryStore is a Store in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	maxPerKey int
+	records   map[string][]Feedback
+}
+
+// NewMemoryStore creates an empty MemoryStore keeping the last maxPerKey
+// records of every key; all of them if maxPerKey < 1.
+func NewMemoryStore(maxPerKey int) *MemoryStore {
+	return &MemoryStore{maxPerKey: maxPerKey, records: make(map[string][]Feedback)}
+}
+
+// Add stores fb, dropping the oldest record of its key if it has too
+// many.
+func (s *MemoryStore) Add(_ context.Context, fb Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := append(s.records[fb.Key], fb)
+	if s.maxPerKey > 0 && len(records) > s.maxPerKey {
+		records = append(records[:0:0], records[len(records)-s.maxPerKey:]...)
+	}
+	s.records[fb.Key] = records
+	return nil
+}
+
+// Recent returns the last n records for key, oldest first.
+func (s *MemoryStore) Recent(_ context.Context, key string, n int) ([]Feedback, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := s.records[key]
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+	return append([]Feedback(nil), records...), nil
+}
+
+// Keys returns the keys with feedback.
+func (s *MemoryStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// JSONLStore is a Store appending feedback to a JSON Lines file, with the
+// records indexed in memory. It is safe for concurrent use.
+type JSONLStore struct {
+	*MemoryStore
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenJSONL opens the named feedback file, creating it if needed, and
+// loads the records it holds. maxPerKey bounds the records indexed per
+// key, as for NewMemoryStore; the file keeps them all.
+func OpenJSONL(filename string, maxPerKey int) (*JSONLStore, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &JSONLStore{MemoryStore: NewMemoryStore(maxPerKey), file: file, enc: json.NewEncoder(file)}
+	if err := s.load(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("feedback: %s: %w", filename, err)
+	}
+	return s, nil
+}
+
+func (s *JSONLStore) load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var fb Feedback
+		if err := json.Unmarshal(sc.Bytes(), &fb); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		s.MemoryStore.Add(context.Background(), fb)
+	}
+	return sc.Err()
+}
+
+// Add appends fb to the file and indexes it.
+func (s *JSONLStore) Add(ctx context.Context, fb Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(fb); err != nil {
+		return err
+	}
+	return s.MemoryStore.Add(ctx, fb)
+}
+
+// Close closes the file.
+func (s *JSONLStore) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,78 @@
+package feedback
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		fb      Feedback
+		wantErr bool
+	}{
+		{name: "true positive", fb: Feedback{Score: 0.8, Verdict: TruePositive}},
+		{name: "false negative", fb: Feedback{Score: 0.2, Verdict: FalseNegative}},
+		{name: "unknown verdict", fb: Feedback{Score: 0.8, Verdict: "maybe"}, wantErr: true},
+		{name: "score out of range", fb: Feedback{Score: 1.5, Verdict: FalsePositive}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fb.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalid)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(3)
+	for i := range 5 {
+		require.NoError(t, s.Add(ctx, Feedback{Key: "a", Score: float64(i) / 10}))
+	}
+	require.NoError(t, s.Add(ctx, Feedback{Key: "b", Score: 0.9}))
+
+	records, err := s.Recent(ctx, "a", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3, "bounded per key")
+	assert.Equal(t, 0.2, records[0].Score)
+
+	records, err = s.Recent(ctx, "a", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.3, 0.4}, []float64{records[0].Score, records[1].Score})
+	assert.ElementsMatch(t, []string{"a", "b"}, s.Keys())
+}
+
+func TestJSONLStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	s, err := OpenJSONL(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Add(ctx, Feedback{Key: "eth0", Score: 0.7, Verdict: FalsePositive, Analyst: "ana"}))
+	require.NoError(t, s.Add(ctx, Feedback{Key: "eth0", Score: 0.9, Verdict: TruePositive}))
+	require.NoError(t, s.Close())
+
+	s, err = OpenJSONL(path, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	records, err := s.Recent(ctx, "eth0", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2, "records are reloaded")
+	assert.Equal(t, "ana", records[0].Analyst)
+	require.NoError(t, s.Add(ctx, Feedback{Key: "eth0", Score: 0.8, Verdict: TruePositive}))
+	records, _ = s.Recent(ctx, "eth0", 0)
+	assert.Len(t, records, 3)
+
+	require.NoError(t, os.WriteFile(path, []byte("{not json\n"), 0o600))
+	_, err = OpenJSONL(path, 0)
+	assert.ErrorContains(t, err, "line 1")
+}
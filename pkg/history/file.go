@@ -0,0 +1,205 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileMagic starts history files, followed by the format version.
+const (
+	fileMagic   = "GGHIST"
+	fileVersion = 1
+)
+
+// maxEntity bounds the length of entity names read from a file, to fail
+// on corrupted files instead of allocating their garbage.
+const maxEntity = 64 << 10
+
+// Open opens the named history file, creating it if needed, and loads the
+// points it holds. A record cut short by a crash at the end of the file is
+// dropped.
+func Open(path string, opts ...Option) (*Store, error) {
+	s := New(opts...)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	end, err := s.load(file)
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err == nil {
+		_, err = file.Seek(end, io.SeekStart)
+	}
+	if err == nil && end == 0 {
+		err = writeHeader(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("history: %s: %w", path, err)
+	}
+	s.path, s.file, s.w = path, file, bufio.NewWriter(file)
+	return s, nil
+}
+
+// load reads the points of a history file and returns the offset after
+// the last complete record, or 0 for an empty file.
+func (s *Store) load(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, errors.New("not a history file")
+	}
+	if !bytes.Equal(header[:len(fileMagic)], []byte(fileMagic)) {
+		return 0, errors.New("not a history file")
+	}
+	if header[len(fileMagic)] != fileVersion {
+		return 0, fmt.Errorf("unsupported history file version %d", header[len(fileMagic)])
+	}
+
+	end := int64(len(header))
+	for {
+		p, n, err := readPoint(br)
+		switch {
+		case err == io.EOF:
+			return end, nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// A torn write: keep what was complete.
+			return end, nil
+		case err != nil:
+			return 0, fmt.Errorf("record at offset %d: %w", end, err)
+		}
+		s.insert(p.Entity, entry{time: p.Time.UnixNano(), score: p.Score, anomaly: p.Anomaly})
+		end += int64(n)
+	}
+}
+
+func writeHeader(w io.Writer) error {
+	_, err := w.Write(append([]byte(fileMagic), fileVersion))
+	return err
+}
+
+// writePoint writes a record: the length of the entity as a uvarint, the
+// entity, the time in Unix nanoseconds, the score, and a flags byte, the
+// fixed-width fields little-endian.
+func writePoint(w *bufio.Writer, p Point) error {
+	var buf [binary.MaxVarintLen64 + 17]byte
+	b := binary.AppendUvarint(buf[:0], uint64(len(p.Entity)))
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(p.Entity); err != nil {
+		return err
+	}
+	b = binary.LittleEndian.AppendUint64(buf[:0], uint64(p.Time.UnixNano()))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Score))
+	var flags byte
+	if p.Anomaly {
+		flags = 1
+	}
+	_, err := w.Write(append(b, flags))
+	return err
+}
+
+// readPoint reads a record written by writePoint and returns its length.
+// It returns io.EOF at the end of the records and io.ErrUnexpectedEOF for
+// a record cut short.
+func readPoint(r *bufio.Reader) (Point, int, error) {
+	var p Point
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return p, 0, err
+	}
+	if size > maxEntity {
+		return p, 0, fmt.Errorf("entity of %d bytes", size)
+	}
+	buf := make([]byte, int(size)+17)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return p, 0, err
+	}
+	fixed := buf[size:]
+	p.Entity = string(buf[:size])
+	p.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(fixed)))
+	p.Score = math.Float64frombits(binary.LittleEndian.Uint64(fixed[8:]))
+	p.Anomaly = fixed[16]&1 != 0
+	return p, uvarintLen(size) + len(buf), nil
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return len(binary.AppendUvarint(buf[:0], v))
+}
+
+// Compact drops the points older than the retention period and, for
+// stores opened on a file, rewrites the file with the points left.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	if s.file == nil {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = writeHeader(w)
+	for entity, series := range s.series {
+		for _, e := range series {
+			if err != nil {
+				break
+			}
+			err = writePoint(w, Point{Entity: entity, Time: time.Unix(0, e.time), Score: e.score, Anomaly: e.anomaly})
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("history: compact: %w", err)
+	}
+
+	// The rename replaced the file: append to the new one.
+	s.file.Close()
+	s.file, s.w = tmp, bufio.NewWriter(tmp)
+	return nil
+}
+
+// Close closes the file of stores opened on one.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file, s.w = nil, nil
+	return err
+}
@@ -0,0 +1,267 @@
+// Package history keeps the scores of entities, such as hosts, users or
+// IP addresses, over time, for dashboards and correlation that need more
+// than the live stream: the score trend of a host over the last day, or
+// the entities with the highest anomaly rate this week.
+//
+// A Store holds points in memory, indexed by entity and time. Opened on a
+// file, it also appends every point to it and reloads them on Open, so
+// history survives restarts. Points older than the retention period are
+// dropped by Compact, which also rewrites the file.
+//
+// Typical use:
+//
+//	h, err := history.Open("scores.hist", history.WithRetention(30*24*time.Hour))
+//	...
+//	err = h.Add(history.Point{Entity: "web-1", Time: now, Score: 0.71, Anomaly: true})
+//	trend := h.Trend("web-1", now.Add(-24*time.Hour), now, time.Hour)
+//	top := h.Top(now.Add(-7*24*time.Hour), now, 10, 100)
+package history
+
+import (
+	"bufio"
+	"cmp"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Point is the score of an entity at a time.
+type Point struct {
+	Entity  string    `json:"entity"`
+	Time    time.Time `json:"time"`
+	Score   float64   `json:"score"`
+	Anomaly bool      `json:"anomaly"`
+}
+
+// Bucket summarizes the points of an entity in a time interval.
+type Bucket struct {
+	// Start is the start of the interval; it ends where the next begins.
+	Start     time.Time `json:"start"`
+	Count     int       `json:"count"`
+	Anomalies int       `json:"anomalies"`
+	// MeanScore and MaxScore are 0 for empty buckets.
+	MeanScore float64 `json:"mean_score"`
+	MaxScore  float64 `json:"max_score"`
+}
+
+// EntityStats summarizes the points of an entity in a time range.
+type EntityStats struct {
+	Entity      string  `json:"entity"`
+	Count       int     `json:"count"`
+	Anomalies   int     `json:"anomalies"`
+	AnomalyRate float64 `json:"anomaly_rate"`
+	MeanScore   float64 `json:"mean_score"`
+	MaxScore    float64 `json:"max_score"`
+}
+
+// entry is a point stored under its entity.
+type entry struct {
+	time    int64 // Unix nanoseconds
+	score   float64
+	anomaly bool
+}
+
+// Store is a score history. It is safe for concurrent use.
+type Store struct {
+	mu        sync.RWMutex
+	series    map[string][]entry // by entity, in time order
+	retention time.Duration
+	now       func() time.Time
+
+	// file and w are nil for stores in memory.
+	path string
+	file *os.File
+	w    *bufio.Writer
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithRetention makes Compact drop points older than d. The default, 0,
+// keeps every point.
+func WithRetention(d time.Duration) Option {
+	return func(s *Store) {
+		s.retention = d
+	}
+}
+
+// New creates an empty Store in memory.
+func New(opts ...Option) *Store {
+	s := &Store{series: make(map[string][]entry), now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Add stores points, appending them to the file of stores opened on one.
+// Points may arrive out of time order.
+func (s *Store) Add(points ...Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil {
+		for _, p := range points {
+			if err := writePoint(s.w, p); err != nil {
+				return err
+			}
+		}
+		if err := s.w.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, p := range points {
+		s.insert(p.Entity, entry{time: p.Time.UnixNano(), score: p.Score, anomaly: p.Anomaly})
+	}
+	return nil
+}
+
+// insert adds e to the series of entity, keeping it in time order.
+func (s *Store) insert(entity string, e entry) {
+	series := s.series[entity]
+	if n := len(series); n == 0 || series[n-1].time <= e.time {
+		s.series[entity] = append(series, e)
+		return
+	}
+	i, _ := slices.BinarySearchFunc(series, e.time+1, func(e entry, t int64) int { return cmp.Compare(e.time, t) })
+	s.series[entity] = slices.Insert(series, i, e)
+}
+
+// rangeOf returns the points of entity in [from, to).
+func (s *Store) rangeOf(entity string, from, to time.Time) []entry {
+	series := s.series[entity]
+	search := func(t int64) int {
+		i, _ := slices.BinarySearchFunc(series, t, func(e entry, t int64) int { return cmp.Compare(e.time, t) })
+		return i
+	}
+	lo, hi := search(from.UnixNano()), search(to.UnixNano())
+	if lo >= hi {
+		return nil
+	}
+	return series[lo:hi]
+}
+
+// Points returns the points of entity in [from, to), in time order, with
+// times in UTC.
+func (s *Store) Points(entity string, from, to time.Time) []Point {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := s.rangeOf(entity, from, to)
+	points := make([]Point, len(entries))
+	for i, e := range entries {
+		points[i] = Point{Entity: entity, Time: time.Unix(0, e.time).UTC(), Score: e.score, Anomaly: e.anomaly}
+	}
+	return points
+}
+
+// Trend summarizes the points of entity in [from, to) in consecutive
+// buckets of width step starting at from, empty buckets included.
+func (s *Store) Trend(entity string, from, to time.Time, step time.Duration) []Bucket {
+	if step <= 0 || !from.Before(to) {
+		return nil
+	}
+	n := int((to.Sub(from) + step - 1) / step)
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		buckets[i].Start = from.Add(time.Duration(i) * step)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	start := from.UnixNano()
+	for _, e := range s.rangeOf(entity, from, to) {
+		b := &buckets[(e.time-start)/int64(step)]
+		if b.Count == 0 || e.score > b.MaxScore {
+			b.MaxScore = e.score
+		}
+		b.Count++
+		b.MeanScore += e.score
+		if e.anomaly {
+			b.Anomalies++
+		}
+	}
+	for i := range buckets {
+		if buckets[i].Count > 0 {
+			buckets[i].MeanScore /= float64(buckets[i].Count)
+		}
+	}
+	return buckets
+}
+
+// Top returns up to n entities with at least minCount points in [from,
+// to), by anomaly rate, then number of anomalies, then highest score.
+func (s *Store) Top(from, to time.Time, n, minCount int) []EntityStats {
+	s.mu.RLock()
+	var top []EntityStats
+	for entity := range s.series {
+		entries := s.rangeOf(entity, from, to)
+		if len(entries) == 0 || len(entries) < minCount {
+			continue
+		}
+		st := EntityStats{Entity: entity, Count: len(entries), MaxScore: entries[0].score}
+		for _, e := range entries {
+			st.MeanScore += e.score
+			st.MaxScore = max(st.MaxScore, e.score)
+			if e.anomaly {
+				st.Anomalies++
+			}
+		}
+		st.MeanScore /= float64(st.Count)
+		st.AnomalyRate = float64(st.Anomalies) / float64(st.Count)
+		top = append(top, st)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(top, func(a, b EntityStats) int {
+		return cmp.Or(
+			cmp.Compare(b.AnomalyRate, a.AnomalyRate),
+			cmp.Compare(b.Anomalies, a.Anomalies),
+			cmp.Compare(b.MaxScore, a.MaxScore),
+			cmp.Compare(a.Entity, b.Entity),
+		)
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Entities returns the entities with points, sorted.
+func (s *Store) Entities() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entities := make([]string, 0, len(s.series))
+	for entity := range s.series {
+		entities = append(entities, entity)
+	}
+	slices.Sort(entities)
+	return entities
+}
+
+// Len returns the number of points stored.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, series := range s.series {
+		n += len(series)
+	}
+	return n
+}
+
+// prune drops the points older than the retention period.
+func (s *Store) prune() {
+	if s.retention <= 0 {
+		return
+	}
+	cutoff := s.now().Add(-s.retention).UnixNano()
+	for entity, series := range s.series {
+		i, _ := slices.BinarySearchFunc(series, cutoff, func(e entry, t int64) int { return cmp.Compare(e.time, t) })
+		switch {
+		case i == len(series):
+			delete(s.series, entity)
+		case i > 0:
+			s.series[entity] = slices.Clone(series[i:])
+		}
+	}
+}
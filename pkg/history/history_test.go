@@ -0,0 +1,147 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return start.Add(time.Duration(minutes) * time.Minute)
+}
+
+func TestTrend(t *testing.T) {
+	s := New()
+	require.NoError(t, s.Add(
+		Point{Entity: "web-1", Time: at(5), Score: 0.4},
+		Point{Entity: "web-1", Time: at(70), Score: 0.8, Anomaly: true},
+		Point{Entity: "web-1", Time: at(10), Score: 0.6}, // out of order
+		Point{Entity: "web-2", Time: at(10), Score: 0.9, Anomaly: true},
+		Point{Entity: "web-1", Time: at(200), Score: 0.5}, // after the range
+	))
+
+	points := s.Points("web-1", at(0), at(180))
+	require.Len(t, points, 3)
+	assert.Equal(t, []float64{0.4, 0.6, 0.8}, []float64{points[0].Score, points[1].Score, points[2].Score})
+	assert.True(t, points[2].Time.Equal(at(70)))
+	assert.Equal(t, "web-1", points[0].Entity)
+
+	trend := s.Trend("web-1", at(0), at(150), time.Hour)
+	require.Len(t, trend, 3, "the last bucket is partial")
+	assert.Equal(t, Bucket{Start: at(0), Count: 2, MeanScore: 0.5, MaxScore: 0.6}, trend[0])
+	assert.Equal(t, Bucket{Start: at(60), Count: 1, Anomalies: 1, MeanScore: 0.8, MaxScore: 0.8}, trend[1])
+	assert.Equal(t, Bucket{Start: at(120)}, trend[2])
+
+	assert.Nil(t, s.Trend("web-1", at(10), at(0), time.Hour))
+	assert.Empty(t, s.Points("db-1", at(0), at(180)))
+	assert.Equal(t, []string{"web-1", "web-2"}, s.Entities())
+	assert.Equal(t, 5, s.Len())
+}
+
+func TestTop(t *testing.T) {
+	s := New()
+	add := func(entity string, anomalies, normal int, maxScore float64) {
+		for i := 0; i < anomalies; i++ {
+			require.NoError(t, s.Add(Point{Entity: entity, Time: at(i), Score: maxScore, Anomaly: true}))
+		}
+		for i := 0; i < normal; i++ {
+			require.NoError(t, s.Add(Point{Entity: entity, Time: at(i), Score: 0.1}))
+		}
+	}
+	add("quiet", 0, 10, 0)
+	add("noisy", 5, 5, 0.8)
+	add("worse", 5, 5, 0.9)
+	add("tiny", 1, 0, 0.7)
+	add("busy", 10, 10, 0.8)
+
+	top := s.Top(at(0), at(60), 3, 2)
+	require.Len(t, top, 3)
+	names := []string{top[0].Entity, top[1].Entity, top[2].Entity}
+	assert.Equal(t, []string{"busy", "worse", "noisy"}, names, "tiny has too few points")
+	assert.Equal(t, EntityStats{Entity: "busy", Count: 20, Anomalies: 10, AnomalyRate: 0.5, MeanScore: 0.45, MaxScore: 0.8}, roundStats(top[0]))
+
+	assert.Len(t, s.Top(at(0), at(60), 0, 0), 5)
+	assert.Empty(t, s.Top(at(100), at(200), 0, 0))
+}
+
+func roundStats(st EntityStats) EntityStats {
+	st.MeanScore = float64(int(st.MeanScore*1000+0.5)) / 1000
+	return st
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.hist")
+	s, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Add(Point{Entity: "web-1", Time: at(1), Score: 0.3}, Point{Entity: "10.0.0.7", Time: at(2), Score: 0.9, Anomaly: true}))
+	require.NoError(t, s.Add(Point{Entity: "", Time: at(3), Score: 0.5}))
+	require.NoError(t, s.Close())
+
+	s, err = Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, []Point{{Entity: "10.0.0.7", Time: at(2), Score: 0.9, Anomaly: true}}, s.Points("10.0.0.7", at(0), at(10)))
+	require.NoError(t, s.Add(Point{Entity: "web-1", Time: at(4), Score: 0.4}))
+	require.NoError(t, s.Close())
+
+	// A record cut short by a crash is dropped, and appending resumes
+	// after the last complete one.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:len(data)-5], 0o600))
+	s, err = Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.Len())
+	require.NoError(t, s.Add(Point{Entity: "web-1", Time: at(5), Score: 0.5}))
+	require.NoError(t, s.Close())
+	s, err = Open(path)
+	require.NoError(t, err)
+	assert.Len(t, s.Points("web-1", at(0), at(10)), 2)
+	require.NoError(t, s.Close())
+
+	require.NoError(t, os.WriteFile(path, []byte("entity,score\n"), 0o600))
+	_, err = Open(path)
+	assert.Error(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.hist")
+	s, err := Open(path, WithRetention(time.Hour))
+	require.NoError(t, err)
+	s.now = func() time.Time { return at(90) }
+	require.NoError(t, s.Add(
+		Point{Entity: "old", Time: at(10), Score: 0.1},
+		Point{Entity: "web-1", Time: at(20), Score: 0.2},
+		Point{Entity: "web-1", Time: at(40), Score: 0.4},
+	))
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Compact())
+	assert.Equal(t, []string{"web-1"}, s.Entities())
+	assert.Equal(t, 1, s.Len())
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	// The store appends to the compacted file.
+	require.NoError(t, s.Add(Point{Entity: "web-2", Time: at(80), Score: 0.8}))
+	require.NoError(t, s.Close())
+	s, err = Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-1", "web-2"}, s.Entities())
+	assert.Equal(t, 2, s.Len())
+	require.NoError(t, s.Close())
+
+	// Without a retention period nothing is dropped.
+	mem := New()
+	require.NoError(t, mem.Add(Point{Entity: "old", Time: at(-100000)}))
+	require.NoError(t, mem.Compact())
+	assert.Equal(t, 1, mem.Len())
+}
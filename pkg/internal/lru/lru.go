@@ -0,0 +1,132 @@
+// Package lru keeps values by key in the order they were last used, for
+// the stores that bound what they hold by dropping the least recently
+// used first.
+package lru
+
+import (
+	"container/list"
+	"iter"
+)
+
+// List holds values by key, ordered from the most to the least recently
+// used. It is not safe for concurrent use; of its methods, only Get and
+// those that add or remove values modify it.
+type List[K comparable, V any] struct {
+	items map[K]*list.Element // of *entry[K, V]
+	order *list.List          // most recently used first
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// New returns an empty List.
+func New[K comparable, V any]() *List[K, V] {
+	return &List[K, V]{items: make(map[K]*list.Element), order: list.New()}
+}
+
+// Len returns the number of values held.
+func (l *List[K, V]) Len() int {
+	return l.order.Len()
+}
+
+// Get returns the value of key and marks it used.
+func (l *List[K, V]) Get(key K) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Peek returns the value of key without marking it used.
+func (l *List[K, V]) Peek(key K) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Push adds value as the most recently used, replacing the value of key
+// if it is held.
+func (l *List[K, V]) Push(key K, value V) {
+	l.Remove(key)
+	l.items[key] = l.order.PushFront(&entry[K, V]{key, value})
+}
+
+// Insert adds value after the values newer reports true for, counting
+// from the most recently used, replacing the value of key if it is held.
+// It keeps a List ordered by when its values were last used when value
+// was used before some of them.
+func (l *List[K, V]) Insert(key K, value V, newer func(V) bool) {
+	l.Remove(key)
+	mark := l.order.Front()
+	for mark != nil && newer(mark.Value.(*entry[K, V]).value) {
+		mark = mark.Next()
+	}
+	e := &entry[K, V]{key, value}
+	if mark == nil {
+		l.items[key] = l.order.PushBack(e)
+	} else {
+		l.items[key] = l.order.InsertBefore(e, mark)
+	}
+}
+
+// Remove drops key and returns its value, if it was held.
+func (l *List[K, V]) Remove(key K) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	delete(l.items, key)
+	l.order.Remove(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Oldest returns the least recently used value and its key.
+func (l *List[K, V]) Oldest() (K, V, bool) {
+	el := l.order.Back()
+	if el == nil {
+		var (
+			key   K
+			value V
+		)
+		return key, value, false
+	}
+	e := el.Value.(*entry[K, V])
+	return e.key, e.value, true
+}
+
+// All yields the values held and their keys, the most recently used
+// first.
+func (l *List[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for el := l.order.Front(); el != nil; el = el.Next() {
+			e := el.Value.(*entry[K, V])
+			if !yield(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Backward yields the values held and their keys, the least recently
+// used first. The value yielded may be removed before the next one is.
+func (l *List[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for el := l.order.Back(); el != nil; {
+			prev := el.Prev()
+			e := el.Value.(*entry[K, V])
+			if !yield(e.key, e.value) {
+				return
+			}
+			el = prev
+		}
+	}
+}
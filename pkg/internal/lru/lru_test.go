@@ -0,0 +1,99 @@
+package lru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keys returns the keys of l, the most recently used first.
+func keys(l *List[string, int]) []string {
+	var ks []string
+	for k := range l.All() {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestList(t *testing.T) {
+	l := New[string, int]()
+	l.Push("a", 1)
+	l.Push("b", 2)
+	l.Push("c", 3)
+	assert.Equal(t, []string{"c", "b", "a"}, keys(l))
+
+	v, ok := l.Peek("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, []string{"c", "b", "a"}, keys(l), "Peek does not mark a used")
+
+	v, ok = l.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, []string{"a", "c", "b"}, keys(l))
+
+	l.Push("c", 30)
+	assert.Equal(t, 3, l.Len())
+	assert.Equal(t, []string{"c", "a", "b"}, keys(l))
+
+	k, v, ok := l.Oldest()
+	assert.True(t, ok)
+	assert.Equal(t, "b", k)
+	assert.Equal(t, 2, v)
+
+	v, ok = l.Remove("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+	_, ok = l.Remove("b")
+	assert.False(t, ok)
+	_, ok = l.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"c", "a"}, keys(l))
+
+	_, _, ok = New[string, int]().Oldest()
+	assert.False(t, ok)
+}
+
+func TestInsert(t *testing.T) {
+	l := New[string, int]()
+	for _, k := range []string{"a", "b", "c"} {
+		l.Push(k, len(l.items)*10) // a: 0, b: 10, c: 20
+	}
+	tests := []struct {
+		key   string
+		value int
+		want  []string
+	}{
+		{"d", 15, []string{"c", "d", "b", "a"}},
+		{"e", 25, []string{"e", "c", "d", "b", "a"}},
+		{"f", -5, []string{"e", "c", "d", "b", "a", "f"}},
+		{"a", 17, []string{"e", "c", "a", "d", "b", "f"}},
+	}
+	for _, tt := range tests {
+		l.Insert(tt.key, tt.value, func(v int) bool { return v > tt.value })
+		assert.Equal(t, tt.want, keys(l), "insert %s", tt.key)
+	}
+}
+
+func TestBackward(t *testing.T) {
+	l := New[string, int]()
+	for i, k := range []string{"a", "b", "c", "d"} {
+		l.Push(k, i)
+	}
+	var seen []string
+	for k, v := range l.Backward() {
+		seen = append(seen, k)
+		if v%2 == 0 {
+			l.Remove(k)
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, seen, "removing the value yielded goes on with the next")
+	assert.Equal(t, []string{"d", "b"}, keys(l))
+
+	seen = nil
+	for k := range l.Backward() {
+		seen = append(seen, k)
+		break
+	}
+	assert.Equal(t, []string{"b"}, seen)
+}
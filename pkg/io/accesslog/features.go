@@ -0,0 +1,203 @@
+package accesslog
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func init() {
+	guardio.DefaultExtractors.MustRegister("accesslog", func() (guardio.FeatureExtractor, error) {
+		return NewRecordExtractor(), nil
+	})
+}
+
+// FeatureNames names the features of a request, in vector order. Bytes
+// are log10(1+n), latency is in seconds (zero if not logged), entropy in
+// bits per character and the rate in requests per minute.
+var FeatureNames = []string{"status_class", "bytes", "latency", "path_depth", "ua_entropy", "ip_rate"}
+
+// Option configures a Reader, or the Parser or Extractor it applies to.
+type Option func(*config)
+
+type config struct {
+	format     Format
+	keys       JSONKeys
+	window     time.Duration
+	maxClients int
+}
+
+func newConfig(opts []Option) config {
+	c := config{keys: DefaultJSONKeys, window: time.Minute, maxClients: 100000}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.window = max(c.window, time.Second)
+	c.maxClients = max(c.maxClients, 1)
+	return c
+}
+
+// WithFormat restricts the formats accepted. Defaults to FormatAuto.
+func WithFormat(f Format) Option {
+	return func(c *config) {
+		c.format = f
+	}
+}
+
+// WithJSONKeys sets the keys JSON lines are read from. Defaults to
+// DefaultJSONKeys.
+func WithJSONKeys(keys JSONKeys) Option {
+	return func(c *config) {
+		c.keys = keys
+	}
+}
+
+// WithRateWindow sets the sliding window the per-client request rate is
+// measured over. Defaults to one minute.
+func WithRateWindow(d time.Duration) Option {
+	return func(c *config) {
+		c.window = d
+	}
+}
+
+// WithMaxClients bounds the number of clients whose rate is tracked at
+// once. When a new client would exceed it, the least recently active one
+// is forgotten. Defaults to 100000.
+func WithMaxClients(n int) Option {
+	return func(c *config) {
+		c.maxClients = n
+	}
+}
+
+// Extractor turns entries into feature vectors. It keeps the recent
+// requests of each client to measure its rate, so entries must come in
+// log order. It is not safe for concurrent use.
+type Extractor struct {
+	window     time.Duration
+	maxClients int
+	clients    map[netip.Addr][]time.Time // request times in the window, oldest first
+}
+
+// NewExtractor creates an Extractor. Options of the Parser are ignored.
+func NewExtractor(opts ...Option) *Extractor {
+	c := newConfig(opts)
+	return &Extractor{window: c.window, maxClients: c.maxClients, clients: make(map[netip.Addr][]time.Time)}
+}
+
+// Extract returns the feature vector of e.
+func (x *Extractor) Extract(e Entry) []float64 {
+	var latency float64
+	if e.Latency > 0 {
+		latency = e.Latency.Seconds()
+	}
+	return []float64{
+		float64(e.Status / 100),
+		math.Log10(1 + float64(max(e.Bytes, 0))),
+		latency,
+		float64(PathDepth(e.Path)),
+		Entropy(e.UserAgent),
+		x.rate(e),
+	}
+}
+
+// FeatureNames returns the names of the extracted features.
+func (x *Extractor) FeatureNames() []string {
+	return FeatureNames
+}
+
+// NewRecordExtractor returns a guardio.FeatureExtractor of entries and of
+// log lines, as strings or []byte, parsed as opts configure. It is
+// registered as "accesslog" in guardio.DefaultExtractors.
+func NewRecordExtractor(opts ...Option) guardio.FeatureExtractor {
+	p, x := NewParser(opts...), NewExtractor(opts...)
+	return guardio.NewFuncExtractor(FeatureNames, func(record any) ([]float64, error) {
+		switch v := record.(type) {
+		case Entry:
+			return x.Extract(v), nil
+		case string:
+			e, err := p.Parse(v)
+			if err != nil {
+				return nil, err
+			}
+			return x.Extract(e), nil
+		case []byte:
+			e, err := p.Parse(string(v))
+			if err != nil {
+				return nil, err
+			}
+			return x.Extract(e), nil
+		}
+		return nil, fmt.Errorf("cannot extract %T, want an access log entry or line", record)
+	})
+}
+
+// rate records e and returns the requests per minute of its client over
+// the window, e included. Requests without a client address count alone.
+func (x *Extractor) rate(e Entry) float64 {
+	perMinute := float64(time.Minute) / float64(x.window)
+	if !e.Client.IsValid() {
+		return perMinute
+	}
+
+	times, ok := x.clients[e.Client]
+	if !ok && len(x.clients) >= x.maxClients {
+		x.evict()
+	}
+	from := e.Time.Add(-x.window)
+	i := 0
+	for i < len(times) && !times[i].After(from) {
+		i++
+	}
+	times = append(times[i:], e.Time)
+	x.clients[e.Client] = times
+	return float64(len(times)) * perMinute
+}
+
+// evict forgets the client whose last request is the oldest.
+func (x *Extractor) evict() {
+	var oldest netip.Addr
+	var oldestTime time.Time
+	for addr, times := range x.clients {
+		if last := times[len(times)-1]; !oldest.IsValid() || last.Before(oldestTime) {
+			oldest, oldestTime = addr, last
+		}
+	}
+	delete(x.clients, oldest)
+}
+
+// PathDepth returns the number of segments of the path of a request
+// target, ignoring its query string: 0 for "/", 2 for "/a/b?c=d".
+func PathDepth(target string) int {
+	path, _, _ := strings.Cut(target, "?")
+	depth := 0
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Entropy returns the Shannon entropy of the characters of s in bits.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
@@ -0,0 +1,257 @@
+// Package accesslog reads HTTP access logs, such as those of nginx,
+// Apache or Envoy, and extracts features for web-abuse detection.
+//
+// Lines may be in the Common Log Format, the Combined Log Format, which
+// adds the referer and user agent, or either followed by the request time
+// in seconds as nginx's $request_time; or JSON objects, one per line,
+// whose keys are looked up among common names (see DefaultJSONKeys).
+// Lines that match neither are skipped and counted.
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format selects the log formats a Parser accepts.
+type Format int
+
+const (
+	// FormatAuto accepts both, telling JSON lines by their opening brace.
+	// This is the default.
+	FormatAuto Format = iota
+	// FormatCLF accepts the Common and Combined Log Formats.
+	FormatCLF
+	// FormatJSON accepts JSON objects.
+	FormatJSON
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// clfLine matches a Common or Combined Log Format line, optionally
+// followed by the request time, such as
+// 203.0.113.7 - - [02/Jan/2024:15:04:05 +0000] "GET / HTTP/1.1" 200 512 "-" "curl/8.0" 0.012
+var clfLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?(?: ([\d.]+))?`)
+
+// Entry is one request of an access log.
+type Entry struct {
+	Time time.Time
+	// Client is the address of the client, invalid if the log names a
+	// host instead.
+	Client   netip.Addr
+	Method   string
+	Path     string
+	Protocol string
+	Status   int
+	// Bytes is the size of the response body.
+	Bytes int64
+	// Latency is the time taken to serve the request, negative if the
+	// log does not record it.
+	Latency   time.Duration
+	Referer   string
+	UserAgent string
+}
+
+// JSONKeys lists, for each field of an Entry, the JSON keys it is read
+// from, the first present winning.
+type JSONKeys struct {
+	Time      []string
+	Client    []string
+	Request   []string // "METHOD path protocol", as in the Common Log Format
+	Method    []string
+	Path      []string
+	Status    []string
+	Bytes     []string
+	Referer   []string
+	UserAgent []string
+	// LatencySeconds and LatencyMillis hold the keys of the latency in
+	// seconds and in milliseconds.
+	LatencySeconds []string
+	LatencyMillis  []string
+}
+
+// DefaultJSONKeys covers the usual nginx log_format variable names and
+// Envoy's command operator names.
+var DefaultJSONKeys = JSONKeys{
+	Time:           []string{"time", "timestamp", "@timestamp", "time_iso8601", "time_local", "start_time"},
+	Client:         []string{"remote_addr", "client_ip", "downstream_remote_address", "remote_ip"},
+	Request:        []string{"request"},
+	Method:         []string{"method", "request_method"},
+	Path:           []string{"path", "uri", "request_uri"},
+	Status:         []string{"status", "response_code", "status_code"},
+	Bytes:          []string{"body_bytes_sent", "bytes_sent", "bytes", "response_size"},
+	Referer:        []string{"http_referer", "referer"},
+	UserAgent:      []string{"http_user_agent", "user_agent"},
+	LatencySeconds: []string{"request_time", "latency"},
+	LatencyMillis:  []string{"duration", "duration_ms", "response_time_ms"},
+}
+
+// Parser turns log lines into entries.
+type Parser struct {
+	format Format
+	keys   JSONKeys
+}
+
+// NewParser creates a Parser. Options of the Extractor are ignored.
+func NewParser(opts ...Option) *Parser {
+	c := newConfig(opts)
+	return &Parser{format: c.format, keys: c.keys}
+}
+
+// Parse returns the request line records, or an error if it cannot be
+// parsed.
+func (p *Parser) Parse(line string) (Entry, error) {
+	line = strings.TrimSpace(line)
+	switch {
+	case p.format == FormatJSON || (p.format == FormatAuto && strings.HasPrefix(line, "{")):
+		return p.parseJSON(line)
+	default:
+		return parseCLF(line)
+	}
+}
+
+func parseCLF(line string) (Entry, error) {
+	m := clfLine.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, errors.New("not a common or combined log line")
+	}
+	t, err := time.Parse(clfTime, m[2])
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Time: t, Latency: -1, Referer: unquote(m[6]), UserAgent: unquote(m[7])}
+	e.Client, _ = netip.ParseAddr(m[1])
+	e.Method, e.Path, e.Protocol = splitRequest(unquote(m[3]))
+	e.Status, _ = strconv.Atoi(m[4])
+	if m[5] != "-" {
+		e.Bytes, _ = strconv.ParseInt(m[5], 10, 64)
+	}
+	if m[8] != "" {
+		if s, err := strconv.ParseFloat(m[8], 64); err == nil {
+			e.Latency = seconds(s)
+		}
+	}
+	return e, nil
+}
+
+func (p *Parser) parseJSON(line string) (Entry, error) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return Entry{}, err
+	}
+	k := p.keys
+	e := Entry{Latency: -1}
+
+	switch v := lookup(obj, k.Time).(type) {
+	case string:
+		t, err := parseTime(v)
+		if err != nil {
+			return Entry{}, err
+		}
+		e.Time = t
+	case float64:
+		e.Time = time.Unix(0, int64(v*1e9))
+	default:
+		return Entry{}, errors.New("no timestamp")
+	}
+
+	status, ok := number(lookup(obj, k.Status))
+	if !ok {
+		return Entry{}, errors.New("no status")
+	}
+	e.Status = int(status)
+
+	if s, ok := lookup(obj, k.Client).(string); ok {
+		if ap, err := netip.ParseAddrPort(s); err == nil {
+			e.Client = ap.Addr()
+		} else {
+			e.Client, _ = netip.ParseAddr(s)
+		}
+	}
+	if s, ok := lookup(obj, k.Request).(string); ok {
+		e.Method, e.Path, e.Protocol = splitRequest(s)
+	}
+	if s, ok := lookup(obj, k.Method).(string); ok {
+		e.Method = s
+	}
+	if s, ok := lookup(obj, k.Path).(string); ok {
+		e.Path = s
+	}
+	if n, ok := number(lookup(obj, k.Bytes)); ok {
+		e.Bytes = int64(n)
+	}
+	e.Referer, _ = lookup(obj, k.Referer).(string)
+	e.UserAgent, _ = lookup(obj, k.UserAgent).(string)
+	if s, ok := number(lookup(obj, k.LatencySeconds)); ok {
+		e.Latency = seconds(s)
+	} else if ms, ok := number(lookup(obj, k.LatencyMillis)); ok {
+		e.Latency = seconds(ms / 1000)
+	}
+	return e, nil
+}
+
+// lookup returns the value of the first of keys present in obj.
+func lookup(obj map[string]any, keys []string) any {
+	for _, k := range keys {
+		if v, ok := obj[k]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// number returns v as a number, whether a JSON number or a string holding
+// one, as nginx writes its variables.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// parseTime parses an RFC 3339 or Common Log Format timestamp.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(clfTime, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown timestamp %q", s)
+}
+
+// splitRequest splits a request line into its method, path and protocol.
+func splitRequest(request string) (method, path, protocol string) {
+	method, rest, _ := strings.Cut(request, " ")
+	path, protocol, _ = strings.Cut(rest, " ")
+	return method, path, protocol
+}
+
+// unquote undoes the escaping of a quoted log field, and returns "" for
+// the "-" of a missing one.
+func unquote(s string) string {
+	if s == "-" {
+		return ""
+	}
+	if strings.Contains(s, `\`) {
+		if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
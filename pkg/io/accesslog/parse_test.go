@@ -0,0 +1,96 @@
+package accesslog
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+	client := netip.MustParseAddr("203.0.113.7")
+
+	tests := []struct {
+		name   string
+		line   string
+		format Format
+		want   Entry
+	}{
+		{
+			name: "common",
+			line: `203.0.113.7 - frank [02/Jan/2024:15:04:05 +0000] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			want: Entry{Time: at, Client: client, Method: "GET", Path: "/apache_pb.gif", Protocol: "HTTP/1.0", Status: 200, Bytes: 2326, Latency: -1},
+		},
+		{
+			name: "combined",
+			line: `203.0.113.7 - - [02/Jan/2024:15:04:05 +0000] "POST /login?next=%2F HTTP/1.1" 401 - "https://example.com/" "Mozilla/5.0 \"quoted\""`,
+			want: Entry{
+				Time: at, Client: client, Method: "POST", Path: "/login?next=%2F", Protocol: "HTTP/1.1", Status: 401, Latency: -1,
+				Referer: "https://example.com/", UserAgent: `Mozilla/5.0 "quoted"`,
+			},
+		},
+		{
+			name: "combined with request time",
+			line: `2001:db8::1 - - [02/Jan/2024:17:04:05 +0200] "GET / HTTP/2.0" 304 0 "-" "curl/8.0" 0.012`,
+			want: Entry{
+				Time: at, Client: netip.MustParseAddr("2001:db8::1"), Method: "GET", Path: "/", Protocol: "HTTP/2.0", Status: 304,
+				Latency: 12 * time.Millisecond, UserAgent: "curl/8.0",
+			},
+		},
+		{
+			name: "nginx json",
+			line: `{"time_iso8601":"2024-01-02T15:04:05+00:00","remote_addr":"203.0.113.7","request":"GET /a/b HTTP/1.1","status":"404","body_bytes_sent":"153","request_time":"0.250","http_user_agent":"sqlmap/1.7"}`,
+			want: Entry{Time: at, Client: client, Method: "GET", Path: "/a/b", Protocol: "HTTP/1.1", Status: 404, Bytes: 153, Latency: 250 * time.Millisecond, UserAgent: "sqlmap/1.7"},
+		},
+		{
+			name: "envoy json",
+			line: `{"start_time":"2024-01-02T15:04:05.000Z","method":"GET","path":"/healthz","response_code":200,"bytes_sent":2,"duration":3,"downstream_remote_address":"203.0.113.7:51234","user_agent":"kube-probe/1.29"}`,
+			want: Entry{Time: at, Client: client, Method: "GET", Path: "/healthz", Status: 200, Bytes: 2, Latency: 3 * time.Millisecond, UserAgent: "kube-probe/1.29"},
+		},
+		{
+			name:   "epoch json",
+			line:   `{"timestamp":1704207845.5,"status":500}`,
+			format: FormatJSON,
+			want:   Entry{Time: time.Unix(1704207845, 500000000), Status: 500, Latency: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewParser(WithFormat(tt.format)).Parse(tt.line)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Time.Equal(e.Time), "time %s", e.Time)
+			e.Time = tt.want.Time
+			assert.Equal(t, tt.want, e)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line   string
+		format Format
+	}{
+		{line: "not a log line"},
+		{line: `203.0.113.7 - - [yesterday] "GET / HTTP/1.1" 200 1`},
+		{line: `{"status":200}`},
+		{line: `{"time":"2024-01-02T15:04:05Z"}`},
+		{line: `{"time":"noon","status":200}`},
+		{line: `{"time":`},
+		{line: `{"time":"2024-01-02T15:04:05Z","status":200}`, format: FormatCLF},
+		{line: `203.0.113.7 - - [02/Jan/2024:15:04:05 +0000] "GET / HTTP/1.1" 200 1`, format: FormatJSON},
+	} {
+		_, err := NewParser(WithFormat(tc.format)).Parse(tc.line)
+		assert.Error(t, err, tc.line)
+	}
+}
+
+func TestJSONKeys(t *testing.T) {
+	keys := DefaultJSONKeys
+	keys.Status = []string{"code"}
+	e, err := NewParser(WithJSONKeys(keys)).Parse(`{"time":"2024-01-02T15:04:05Z","code":418,"status":"ignored"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 418, e.Status)
+}
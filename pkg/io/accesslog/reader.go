@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// maxLine bounds the length of a log line.
+const maxLine = 64 * 1024
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Reader reads the requests of an access log, line by line, as entries or
+// feature vectors. Lines that cannot be parsed are skipped.
+type Reader struct {
+	closer    io.Closer
+	scanner   *bufio.Scanner
+	parser    *Parser
+	extractor *Extractor
+	skipped   atomic.Int64
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// NewReader creates a Reader of the log r. Closing the Reader does not
+// close r.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLine)
+	return &Reader{scanner: scanner, parser: NewParser(opts...), extractor: NewExtractor(opts...)}
+}
+
+// NewFileReader creates a Reader of the log file filename.
+func NewFileReader(filename string, opts ...Option) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(file, opts...)
+	r.closer = file
+	return r, nil
+}
+
+// ReadEntries returns all remaining requests of the log.
+func (r *Reader) ReadEntries() ([]Entry, error) {
+	var entries []Entry
+	for {
+		e, ok := r.next()
+		if !ok {
+			return entries, r.scanner.Err()
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Read returns the feature vectors of all remaining requests of the log.
+func (r *Reader) Read() ([][]float64, error) {
+	var data [][]float64
+	for {
+		e, ok := r.next()
+		if !ok {
+			return data, r.scanner.Err()
+		}
+		data = append(data, r.extractor.Extract(e))
+	}
+}
+
+// Stream returns a channel of the feature vectors of the requests of the
+// log, closed at the end of the log, on a read error or when ctx is done.
+// Read the log through a pipe, such as the output of tail -F, to follow
+// it.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(e Entry, _ uint64) []float64 {
+		return r.extractor.Extract(e)
+	}), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the time
+// of its request and its sequence number among the requests emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(e Entry, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: r.extractor.Extract(e), Time: e.Time, Seq: seq}
+	}), nil
+}
+
+// StreamEntries is Stream emitting the requests themselves.
+func (r *Reader) StreamEntries(ctx context.Context) (<-chan Entry, error) {
+	return stream(ctx, r, func(e Entry, _ uint64) Entry { return e }), nil
+}
+
+// stream emits wrap(entry, seq) for every request until the end of the
+// log, a read error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(e Entry, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			e, ok := r.next()
+			if !ok {
+				r.setErr(r.scanner.Err())
+				return
+			}
+			select {
+			case out <- wrap(e, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// next returns the next request of the log, skipping blank lines and
+// lines that cannot be parsed, and false at the end of the log or on a
+// read error.
+func (r *Reader) next() (Entry, bool) {
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, err := r.parser.Parse(line)
+		if err == nil {
+			return e, true
+		}
+		r.skipped.Add(1)
+	}
+	return Entry{}, false
+}
+
+// Skipped returns the number of lines skipped so far because they could
+// not be parsed. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the read error that stopped a stream early, if any. It is
+// only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close releases resources.
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package accesslog
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+const accessLog = `203.0.113.7 - - [02/Jan/2024:15:04:05 +0000] "GET / HTTP/1.1" 200 999 "-" "Mozilla/5.0"
+garbage
+
+203.0.113.7 - - [02/Jan/2024:15:04:35 +0000] "GET /admin/config.php HTTP/1.1" 404 0 "-" "Mozilla/5.0" 0.5
+{"time":"2024-01-02T15:05:30Z","remote_addr":"203.0.113.7","request":"GET /x HTTP/1.1","status":500}
+`
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte(accessLog), 0o600))
+
+	r, err := NewFileReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := r.Read()
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+	assert.Equal(t, 1, r.Skipped(), "blank lines are not counted")
+
+	ua := Entropy("Mozilla/5.0")
+	assert.Equal(t, []float64{2, 3, 0, 0, ua, 1}, data[0])
+	assert.Equal(t, []float64{4, 0, 0.5, 2, ua, 2}, data[1])
+	assert.Equal(t, []float64{5, 0, 0, 1, 0, 2}, data[2], "the first request left the window")
+}
+
+func TestStream(t *testing.T) {
+	r := NewReader(strings.NewReader(accessLog+strings.Repeat("x", maxLine+1)+"\n"), WithRateWindow(2*time.Minute))
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+
+	var rates []float64
+	var last time.Time
+	for s := range samples {
+		rates = append(rates, s.Features[5])
+		last = s.Time
+		assert.Len(t, s.Features, len(FeatureNames))
+	}
+	assert.Equal(t, []float64{0.5, 1, 1.5}, rates, "requests per minute over two minutes")
+	assert.Equal(t, time.Date(2024, time.January, 2, 15, 5, 30, 0, time.UTC), last.UTC())
+	assert.Error(t, r.Err(), "the overlong line stops the stream")
+	assert.NoError(t, r.Close())
+}
+
+func TestRecordExtractor(t *testing.T) {
+	x, err := guardio.NewExtractor("accesslog")
+	require.NoError(t, err)
+	assert.Equal(t, FeatureNames, x.FeatureNames())
+
+	lines := strings.Split(accessLog, "\n")
+	features, err := x.Extract(lines[0])
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2, 3, 0, 0, Entropy("Mozilla/5.0"), 1}, features)
+	features, err = x.Extract([]byte(lines[3]))
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, features[5], "the rate is kept across lines")
+	_, err = x.Extract("garbage")
+	assert.Error(t, err)
+	_, err = x.Extract(3)
+	assert.ErrorContains(t, err, "want an access log entry or line")
+}
+
+func TestStreamEntries(t *testing.T) {
+	r := NewReader(strings.NewReader(accessLog), WithFormat(FormatCLF))
+	entries, err := r.StreamEntries(context.Background())
+	require.NoError(t, err)
+
+	var paths []string
+	for e := range entries {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"/", "/admin/config.php"}, paths)
+	assert.NoError(t, r.Err())
+	assert.Equal(t, 2, r.Skipped())
+}
+
+func TestReadEntries(t *testing.T) {
+	entries, err := NewReader(strings.NewReader(accessLog)).ReadEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, 500, entries[2].Status)
+}
+
+func TestMaxClients(t *testing.T) {
+	x := NewExtractor(WithMaxClients(2))
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		x.Extract(Entry{Time: at.Add(time.Duration(i) * time.Second), Client: netip.AddrFrom4([4]byte{10, 0, 0, byte(i)})})
+	}
+	assert.Len(t, x.clients, 2)
+	assert.Contains(t, x.clients, netip.AddrFrom4([4]byte{10, 0, 0, 4}))
+	assert.Equal(t, 1.0, x.Extract(Entry{Time: at})[5], "requests without a client count alone")
+}
+
+func TestPathDepthEntropy(t *testing.T) {
+	assert.Equal(t, 0, PathDepth("/"))
+	assert.Equal(t, 0, PathDepth(""))
+	assert.Equal(t, 2, PathDepth("/a/b?c=/d/e"))
+	assert.Equal(t, 3, PathDepth("//a//b/c/"))
+	assert.Zero(t, Entropy(""))
+	assert.Zero(t, Entropy("aaaa"))
+	assert.Equal(t, 2.0, Entropy("abcd"))
+}
+
+func TestNewFileReaderMissing(t *testing.T) {
+	_, err := NewFileReader(filepath.Join(t.TempDir(), "missing.log"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,23 @@
+package io
+
+import (
+	"net/netip"
+	"time"
+)
+
+// AuthEvent is one authentication attempt recorded in a host log, such as
+// an sshd line of auth.log: host telemetry for detection profiles.
+type AuthEvent struct {
+	Time time.Time
+	// Host is the host that logged the attempt.
+	Host string
+	// Service is the program that authenticated, such as sshd or su.
+	Service string
+	User    string
+	// Source is the remote address, invalid for local attempts or when
+	// the log names a host instead.
+	Source  netip.Addr
+	Success bool
+	// InvalidUser is set for failures on accounts that do not exist.
+	InvalidUser bool
+}
@@ -0,0 +1,138 @@
+// Package authlog reads authentication attempts from syslog authentication
+// logs, such as /var/log/auth.log or /var/log/secure.
+//
+// Lines may carry a traditional syslog timestamp ("Jan  2 15:04:05") or
+// an RFC 3339 one, as written by rsyslog's high-precision format. The
+// sshd messages for accepted and failed logins are recognized, and PAM
+// authentication failures of other services such as su or login. PAM
+// failures of sshd are skipped, as sshd logs each of them again as a
+// failed login. Other lines are ignored.
+package authlog
+
+import (
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// sshdLogin matches the sshd messages for accepted and failed logins, such
+// as "Failed password for invalid user admin from 203.0.113.7 port 52144
+// ssh2".
+var sshdLogin = regexp.MustCompile(`^(Accepted|Failed) \S+ for (invalid user )?(\S*) from (\S+) port \d+`)
+
+// Parser turns log lines into authentication events.
+type Parser struct {
+	year int
+	loc  *time.Location
+	now  func() time.Time
+}
+
+// ParserOption configures a Parser.
+type ParserOption func(*Parser)
+
+// WithYear sets the year of traditional syslog timestamps, which have
+// none. By default it is the current year, or the previous one for
+// timestamps that would otherwise be more than a day in the future.
+func WithYear(year int) ParserOption {
+	return func(p *Parser) {
+		p.year = year
+	}
+}
+
+// WithLocation sets the time zone of traditional syslog timestamps.
+// Defaults to time.Local.
+func WithLocation(loc *time.Location) ParserOption {
+	return func(p *Parser) {
+		p.loc = loc
+	}
+}
+
+// NewParser creates a Parser.
+func NewParser(opts ...ParserOption) *Parser {
+	p := &Parser{loc: time.Local, now: time.Now}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Parse returns the authentication attempt line records, and false if it
+// records something else or cannot be parsed.
+func (p *Parser) Parse(line string) (guardio.AuthEvent, bool) {
+	t, rest, ok := p.timestamp(line)
+	if !ok {
+		return guardio.AuthEvent{}, false
+	}
+	host, rest, ok := strings.Cut(strings.TrimLeft(rest, " "), " ")
+	if !ok {
+		return guardio.AuthEvent{}, false
+	}
+	tag, msg, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return guardio.AuthEvent{}, false
+	}
+	service, _, _ := strings.Cut(tag, "[")
+	e := guardio.AuthEvent{Time: t, Host: host, Service: service}
+
+	if m := sshdLogin.FindStringSubmatch(msg); m != nil {
+		e.Success = m[1] == "Accepted"
+		e.InvalidUser = m[2] != ""
+		e.User = m[3]
+		e.Source, _ = netip.ParseAddr(m[4])
+		return e, true
+	}
+
+	pam, fields, ok := strings.Cut(msg, ": authentication failure;")
+	if !ok || service == "sshd" {
+		return guardio.AuthEvent{}, false
+	}
+	// pam_unix(su:auth) names the service when the tag does not.
+	if _, inner, found := strings.Cut(pam, "("); found {
+		if name, _, found := strings.Cut(inner, ":"); found && name != "" {
+			e.Service = name
+		}
+	}
+	for _, field := range strings.Fields(fields) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "user":
+			e.User = value
+		case "rhost":
+			e.Source, _ = netip.ParseAddr(value)
+		}
+	}
+	return e, true
+}
+
+// timestamp parses the timestamp at the start of line and returns the
+// rest of the line.
+func (p *Parser) timestamp(line string) (time.Time, string, bool) {
+	if first, rest, ok := strings.Cut(line, " "); ok && strings.Contains(first, "T") {
+		t, err := time.Parse(time.RFC3339Nano, first)
+		return t, rest, err == nil
+	}
+
+	if len(line) < len(time.Stamp) {
+		return time.Time{}, "", false
+	}
+	stamp, err := time.Parse(time.Stamp, line[:len(time.Stamp)])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	rest := line[len(time.Stamp):]
+	inYear := func(year int) time.Time {
+		return time.Date(year, stamp.Month(), stamp.Day(), stamp.Hour(), stamp.Minute(), stamp.Second(), 0, p.loc)
+	}
+	if p.year != 0 {
+		return inYear(p.year), rest, true
+	}
+	now := p.now().In(p.loc)
+	t := inYear(now.Year())
+	if t.After(now.Add(24 * time.Hour)) {
+		t = inYear(now.Year() - 1)
+	}
+	return t, rest, true
+}
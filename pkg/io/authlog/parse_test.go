@@ -0,0 +1,95 @@
+package authlog
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func TestParse(t *testing.T) {
+	p := NewParser(WithYear(2024), WithLocation(time.UTC))
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+	attacker := netip.MustParseAddr("203.0.113.7")
+
+	tests := []struct {
+		name string
+		line string
+		want guardio.AuthEvent
+		ok   bool
+	}{
+		{
+			name: "failed password",
+			line: "Jan  2 15:04:05 web1 sshd[812]: Failed password for root from 203.0.113.7 port 52144 ssh2",
+			want: guardio.AuthEvent{Time: at, Host: "web1", Service: "sshd", User: "root", Source: attacker},
+			ok:   true,
+		},
+		{
+			name: "invalid user",
+			line: "Jan  2 15:04:05 web1 sshd[812]: Failed password for invalid user admin from 203.0.113.7 port 52144 ssh2",
+			want: guardio.AuthEvent{Time: at, Host: "web1", Service: "sshd", User: "admin", Source: attacker, InvalidUser: true},
+			ok:   true,
+		},
+		{
+			name: "accepted publickey",
+			line: "Jan  2 15:04:05 web1 sshd[812]: Accepted publickey for alice from 2001:db8::1 port 40022 ssh2: ED25519 SHA256:abc",
+			want: guardio.AuthEvent{Time: at, Host: "web1", Service: "sshd", User: "alice", Source: netip.MustParseAddr("2001:db8::1"), Success: true},
+			ok:   true,
+		},
+		{
+			name: "rfc3339",
+			line: "2024-01-02T15:04:05.250000+00:00 web1 sshd[812]: Failed password for root from 203.0.113.7 port 52144 ssh2",
+			want: guardio.AuthEvent{Time: at.Add(250 * time.Millisecond), Host: "web1", Service: "sshd", User: "root", Source: attacker},
+			ok:   true,
+		},
+		{
+			name: "pam failure",
+			line: "Jan  2 15:04:05 web1 su: pam_unix(su:auth): authentication failure; logname=bob uid=1000 euid=0 tty=pts/0 ruser=bob rhost=  user=root",
+			want: guardio.AuthEvent{Time: at, Host: "web1", Service: "su", User: "root"},
+			ok:   true,
+		},
+		{
+			name: "pam failure with rhost",
+			line: "Jan  2 15:04:05 web1 login[77]: pam_unix(login:auth): authentication failure; logname= uid=0 euid=0 tty=/dev/pts/1 ruser= rhost=203.0.113.7 user=bob",
+			want: guardio.AuthEvent{Time: at, Host: "web1", Service: "login", User: "bob", Source: attacker},
+			ok:   true,
+		},
+		{
+			name: "sshd pam failure is logged again as a failed login",
+			line: "Jan  2 15:04:05 web1 sshd[812]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=203.0.113.7  user=root",
+		},
+		{
+			name: "other message",
+			line: "Jan  2 15:04:05 web1 sshd[812]: Connection closed by authenticating user root 203.0.113.7 port 52144 [preauth]",
+		},
+		{name: "garbage", line: "not a log line"},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.Parse(tt.line)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.True(t, tt.want.Time.Equal(got.Time), "time %s", got.Time)
+				got.Time = tt.want.Time
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	p := NewParser(WithLocation(time.UTC))
+	p.now = func() time.Time { return time.Date(2024, time.January, 1, 0, 10, 0, 0, time.UTC) }
+
+	e, ok := p.Parse("Dec 31 23:59:00 web1 sshd[1]: Failed password for root from 203.0.113.7 port 1 ssh2")
+	assert.True(t, ok)
+	assert.Equal(t, 2023, e.Time.Year(), "a timestamp in the future belongs to last year")
+
+	e, ok = p.Parse("Jan  1 00:05:00 web1 sshd[1]: Failed password for root from 203.0.113.7 port 1 ssh2")
+	assert.True(t, ok)
+	assert.Equal(t, 2024, e.Time.Year())
+}
@@ -0,0 +1,101 @@
+package authlog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// maxLine bounds the length of a log line.
+const maxLine = 64 * 1024
+
+// Reader reads authentication attempts from a log, line by line.
+type Reader struct {
+	closer  io.Closer
+	scanner *bufio.Scanner
+	parser  *Parser
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// NewReader creates a Reader of the log r. Closing the Reader does not
+// close r.
+func NewReader(r io.Reader, opts ...ParserOption) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLine)
+	return &Reader{scanner: scanner, parser: NewParser(opts...)}
+}
+
+// NewFileReader creates a Reader of the log file filename.
+func NewFileReader(filename string, opts ...ParserOption) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(file, opts...)
+	r.closer = file
+	return r, nil
+}
+
+// Read returns all remaining authentication attempts of the log.
+func (r *Reader) Read() ([]guardio.AuthEvent, error) {
+	var events []guardio.AuthEvent
+	for r.scanner.Scan() {
+		if e, ok := r.parser.Parse(r.scanner.Text()); ok {
+			events = append(events, e)
+		}
+	}
+	return events, r.scanner.Err()
+}
+
+// Stream returns a channel of the authentication attempts of the log,
+// closed at the end of the log, on a read error or when ctx is done. Read
+// the log through a pipe, such as the output of tail -F, to follow it.
+func (r *Reader) Stream(ctx context.Context) (<-chan guardio.AuthEvent, error) {
+	out := make(chan guardio.AuthEvent, 100)
+
+	go func() {
+		defer close(out)
+		for r.scanner.Scan() {
+			e, ok := r.parser.Parse(r.scanner.Text())
+			if !ok {
+				continue
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		r.setErr(r.scanner.Err())
+	}()
+
+	return out, nil
+}
+
+// Err returns the read error that stopped Stream early, if any. It is only
+// meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close releases resources.
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package authlog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const authLog = `Jan  2 15:04:05 web1 sshd[812]: Invalid user admin from 203.0.113.7 port 52144
+Jan  2 15:04:05 web1 sshd[812]: Failed password for invalid user admin from 203.0.113.7 port 52144 ssh2
+Jan  2 15:04:09 web1 sshd[812]: Connection closed by invalid user admin 203.0.113.7 port 52144 [preauth]
+Jan  2 15:05:00 web1 sshd[900]: Accepted password for alice from 10.0.0.4 port 40022 ssh2
+Jan  2 15:05:00 web1 sshd[900]: pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)
+`
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	require.NoError(t, os.WriteFile(path, []byte(authLog), 0o600))
+
+	r, err := NewFileReader(path, WithYear(2024), WithLocation(time.UTC))
+	require.NoError(t, err)
+	defer r.Close()
+
+	events, err := r.Read()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "admin", events[0].User)
+	assert.True(t, events[0].InvalidUser)
+	assert.Equal(t, "alice", events[1].User)
+	assert.True(t, events[1].Success)
+}
+
+func TestStream(t *testing.T) {
+	r := NewReader(strings.NewReader(authLog+strings.Repeat("x", maxLine+1)+"\n"), WithYear(2024))
+	events, err := r.Stream(context.Background())
+	require.NoError(t, err)
+
+	var users []string
+	for e := range events {
+		users = append(users, e.User)
+	}
+	assert.Equal(t, []string{"admin", "alice"}, users)
+	assert.Error(t, r.Err(), "the overlong line stops the stream")
+	assert.NoError(t, r.Close())
+}
+
+func TestNewFileReaderMissing(t *testing.T) {
+	_, err := NewFileReader(filepath.Join(t.TempDir(), "missing.log"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DockerSource reads the counters of running containers from the Docker
+// Engine API, one stats request per container and poll.
+type DockerSource struct {
+	base   string
+	client *http.Client
+}
+
+// DockerOption configures a DockerSource.
+type DockerOption func(*dockerConfig)
+
+type dockerConfig struct {
+	host    string
+	timeout time.Duration
+}
+
+// WithDockerHost sets the address of the Docker daemon, as
+// unix:///var/run/docker.sock, tcp://host:2375 or http://host:2375.
+// Defaults to $DOCKER_HOST, or the local socket if unset.
+func WithDockerHost(host string) DockerOption {
+	return func(c *dockerConfig) {
+		c.host = host
+	}
+}
+
+// WithDockerTimeout bounds each request to the daemon. Defaults to ten
+// seconds.
+func WithDockerTimeout(d time.Duration) DockerOption {
+	return func(c *dockerConfig) {
+		c.timeout = d
+	}
+}
+
+// NewDockerSource creates a DockerSource.
+func NewDockerSource(opts ...DockerOption) (*DockerSource, error) {
+	c := dockerConfig{host: os.Getenv("DOCKER_HOST"), timeout: 10 * time.Second}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.host == "" {
+		c.host = "unix:///var/run/docker.sock"
+	}
+
+	u, err := url.Parse(c.host)
+	if err != nil {
+		return nil, fmt.Errorf("container: docker host: %w", err)
+	}
+	transport := &http.Transport{}
+	s := &DockerSource{client: &http.Client{Transport: transport, Timeout: c.timeout}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		s.base = "http://docker"
+	case "tcp", "http":
+		s.base = "http://" + u.Host
+	case "https":
+		s.base = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("container: unsupported docker host %q", c.host)
+	}
+	return s, nil
+}
+
+// dockerStats holds the fields of a container stats response read.
+type dockerStats struct {
+	Read     time.Time `json:"read"`
+	Name     string    `json:"name"`
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	PIDsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+}
+
+// Collect lists the running containers and reads the stats of each.
+// Containers that stop between the two are left out.
+func (s *DockerSource) Collect(ctx context.Context) ([]Stats, error) {
+	var list []struct {
+		ID string `json:"Id"`
+	}
+	if err := s.get(ctx, "/containers/json", &list); err != nil {
+		return nil, err
+	}
+
+	stats := make([]Stats, 0, len(list))
+	for _, c := range list {
+		var ds dockerStats
+		err := s.get(ctx, "/containers/"+c.ID+"/stats?stream=false&one-shot=true", &ds)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, ds.stats(c.ID))
+	}
+	return stats, nil
+}
+
+// stats converts the response of the container id. Memory excludes the
+// inactive page cache, as docker stats reports it.
+func (ds *dockerStats) stats(id string) Stats {
+	st := Stats{
+		Time: ds.Read,
+		ID:   id,
+		Name: strings.TrimPrefix(ds.Name, "/"),
+		CPU:  time.Duration(ds.CPUStats.CPUUsage.TotalUsage),
+		PIDs: ds.PIDsStats.Current,
+	}
+	if st.Name == "" {
+		st.Name = id
+	}
+	cache, ok := ds.MemoryStats.Stats["inactive_file"] // cgroup v2
+	if !ok {
+		cache = ds.MemoryStats.Stats["total_inactive_file"] // cgroup v1
+	}
+	if cache < ds.MemoryStats.Usage {
+		st.Memory = ds.MemoryStats.Usage - cache
+	}
+	for _, e := range ds.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			st.ReadBytes += e.Value
+		case "write":
+			st.WriteBytes += e.Value
+		}
+	}
+	for _, n := range ds.Networks {
+		st.RxBytes += n.RxBytes
+		st.TxBytes += n.TxBytes
+	}
+	return st
+}
+
+// errNotFound is returned by get for a 404 response.
+var errNotFound = errors.New("container: not found")
+
+// get decodes the JSON response to a GET of path into v.
+func (s *DockerSource) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("container: GET %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("container: GET %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package container
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			w.Write([]byte(`[{"Id":"abc","Names":["/miner"]},{"Id":"gone"}]`))
+		case "/containers/abc/stats":
+			assert.Equal(t, "false", r.URL.Query().Get("stream"))
+			w.Write([]byte(`{
+				"read":"2024-01-02T15:04:05Z","name":"/miner",
+				"cpu_stats":{"cpu_usage":{"total_usage":3000000000}},
+				"memory_stats":{"usage":73400320,"stats":{"inactive_file":10485760}},
+				"blkio_stats":{"io_service_bytes_recursive":[
+					{"major":8,"minor":0,"op":"read","value":100},{"major":8,"minor":0,"op":"write","value":50},
+					{"major":8,"minor":16,"op":"Read","value":1}]},
+				"networks":{"eth0":{"rx_bytes":10,"tx_bytes":20},"eth1":{"rx_bytes":1,"tx_bytes":2}},
+				"pids_stats":{"current":4}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewDockerSource(WithDockerHost(strings.Replace(srv.URL, "http://", "tcp://", 1)))
+	require.NoError(t, err)
+	stats, err := s.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Stats{{
+		Time: time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC), ID: "abc", Name: "miner", CPU: 3 * time.Second,
+		Memory: 60 << 20, ReadBytes: 101, WriteBytes: 50, RxBytes: 11, TxBytes: 22, PIDs: 4,
+	}}, stats, "containers gone before their stats are read are left out")
+}
+
+func TestDockerSourceErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "daemon unavailable", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s, err := NewDockerSource(WithDockerHost(srv.URL))
+	require.NoError(t, err)
+	_, err = s.Collect(context.Background())
+	assert.ErrorContains(t, err, "500")
+
+	_, err = NewDockerSource(WithDockerHost("npipe:////./pipe/docker_engine"))
+	assert.Error(t, err)
+
+	s, err = NewDockerSource(WithDockerHost("unix://" + t.TempDir() + "/docker.sock"))
+	require.NoError(t, err)
+	_, err = s.Collect(context.Background())
+	assert.Error(t, err, "no daemon listens on the socket")
+}
@@ -0,0 +1,271 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// FeatureNames names the features of a container's usage over an
+// interval, in vector order: CPU in cores, memory in MiB, disk and
+// network throughput in KiB per second, and the number of processes.
+var FeatureNames = []string{"cpu", "memory", "io_read", "io_write", "net_rx", "net_tx", "pids"}
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Usage is the resource usage of a container over one polling interval.
+type Usage struct {
+	// Time is the end of the interval.
+	Time     time.Time
+	ID       string
+	Name     string
+	Interval time.Duration
+	// CPU is the average number of cores busy.
+	CPU float64
+	// Memory is the memory in use at the end of the interval, in bytes.
+	Memory uint64
+	// ReadRate, WriteRate, RxRate and TxRate are in bytes per second.
+	ReadRate  float64
+	WriteRate float64
+	RxRate    float64
+	TxRate    float64
+	PIDs      uint64
+}
+
+// Features returns the feature vector of u, named by FeatureNames.
+func (u Usage) Features() []float64 {
+	const kib, mib = 1 << 10, 1 << 20
+	return []float64{
+		u.CPU,
+		float64(u.Memory) / mib,
+		u.ReadRate / kib,
+		u.WriteRate / kib,
+		u.RxRate / kib,
+		u.TxRate / kib,
+		float64(u.PIDs),
+	}
+}
+
+// Reader polls a Source at a fixed interval and emits the usage of every
+// container over each interval. Containers appear from their second poll;
+// one whose CPU counter goes backwards is taken to have restarted and
+// starts over.
+type Reader struct {
+	source      Source
+	interval    time.Duration
+	maxPolls    int
+	maxDuration time.Duration
+	last        map[string]Stats
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// Option configures a Reader.
+type Option func(*Reader)
+
+// WithInterval sets the polling interval, the width of the time buckets.
+// Defaults to ten seconds.
+func WithInterval(d time.Duration) Option {
+	return func(r *Reader) {
+		r.interval = d
+	}
+}
+
+// WithMaxPolls makes the Reader stop after n polls, which yield n-1
+// intervals. Values below 1 mean no limit.
+func WithMaxPolls(n int) Option {
+	return func(r *Reader) {
+		r.maxPolls = n
+	}
+}
+
+// WithMaxDuration makes the Reader stop d after it starts. Values below 1
+// mean no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(r *Reader) {
+		r.maxDuration = d
+	}
+}
+
+// NewReader creates a Reader polling source.
+func NewReader(source Source, opts ...Option) *Reader {
+	r := &Reader{source: source, interval: 10 * time.Second, last: make(map[string]Stats)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.interval = max(r.interval, time.Millisecond)
+	return r
+}
+
+// Read returns the feature vectors of the usage of every container until
+// the poll or duration limit is reached. As polling never ends on its
+// own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.maxPolls < 1 && r.maxDuration < 1 {
+		return nil, errors.New("container: polling needs a poll or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	var data [][]float64
+	err := r.run(ctx, func(u Usage) bool {
+		data = append(data, u.Features())
+		return true
+	})
+	return data, err
+}
+
+// Stream returns a channel of the feature vectors of the usage of every
+// container, closed when a limit is reached, on a polling error or when
+// ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(u Usage, _ uint64) []float64 { return u.Features() }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the end
+// of its interval and its sequence number among the vectors emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(u Usage, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: u.Features(), Time: u.Time, Seq: seq}
+	}), nil
+}
+
+// StreamUsage is Stream emitting the usage itself, which names its
+// container.
+func (r *Reader) StreamUsage(ctx context.Context) (<-chan Usage, error) {
+	return stream(ctx, r, func(u Usage, _ uint64) Usage { return u }), nil
+}
+
+// stream emits wrap(usage, seq) for the usage of every container until a
+// limit is reached, a polling error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(u Usage, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		var seq uint64
+		err := r.run(ctx, func(u Usage) bool {
+			seq++
+			select {
+			case out <- wrap(u, seq):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if ctx.Err() == nil {
+			r.setErr(err)
+		}
+	}()
+
+	return out
+}
+
+// run polls the source until a limit is reached, passing the usage of
+// every container to emit, and returns the polling error or ctx.Err()
+// that stopped it early.
+func (r *Reader) run(ctx context.Context, emit func(Usage) bool) error {
+	var deadline time.Time
+	if r.maxDuration > 0 {
+		deadline = time.Now().Add(r.maxDuration)
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for polls := 0; r.maxPolls < 1 || polls < r.maxPolls; polls++ {
+		if polls > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil
+		}
+		usage, err := r.poll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range usage {
+			if !emit(u) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// poll collects the counters of every container and returns the usage of
+// those seen in the previous poll too. Containers gone are forgotten.
+func (r *Reader) poll(ctx context.Context) ([]Usage, error) {
+	stats, err := r.source.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage []Usage
+	last := make(map[string]Stats, len(stats))
+	for _, st := range stats {
+		last[st.ID] = st
+		prev, ok := r.last[st.ID]
+		if !ok || st.CPU < prev.CPU || !st.Time.After(prev.Time) {
+			continue
+		}
+		usage = append(usage, diff(prev, st))
+	}
+	r.last = last
+	return usage, nil
+}
+
+// diff returns the usage between two polls of a container.
+func diff(prev, cur Stats) Usage {
+	d := cur.Time.Sub(prev.Time)
+	secs := d.Seconds()
+	rate := func(prev, cur uint64) float64 {
+		if cur < prev {
+			return 0
+		}
+		return float64(cur-prev) / secs
+	}
+	return Usage{
+		Time:      cur.Time,
+		ID:        cur.ID,
+		Name:      cur.Name,
+		Interval:  d,
+		CPU:       float64(cur.CPU-prev.CPU) / float64(d),
+		Memory:    cur.Memory,
+		ReadRate:  rate(prev.ReadBytes, cur.ReadBytes),
+		WriteRate: rate(prev.WriteBytes, cur.WriteBytes),
+		RxRate:    rate(prev.RxBytes, cur.RxBytes),
+		TxRate:    rate(prev.TxBytes, cur.TxBytes),
+		PIDs:      cur.PIDs,
+	}
+}
+
+// Err returns the polling error that stopped a stream early, if any. It
+// is only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close releases resources. Sources need no closing.
+func (r *Reader) Close() error {
+	return nil
+}
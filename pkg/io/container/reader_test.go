@@ -0,0 +1,98 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scripted is a Source replaying one poll after another, failing once
+// the script is exhausted.
+type scripted struct {
+	polls [][]Stats
+}
+
+func (s *scripted) Collect(context.Context) ([]Stats, error) {
+	if len(s.polls) == 0 {
+		return nil, errors.New("daemon gone")
+	}
+	stats := s.polls[0]
+	s.polls = s.polls[1:]
+	return stats, nil
+}
+
+func script() *scripted {
+	at := time.Date(2024, time.January, 2, 15, 4, 0, 0, time.UTC)
+	return &scripted{polls: [][]Stats{
+		{
+			{Time: at, ID: "a", Name: "web", CPU: time.Second, RxBytes: 1 << 20},
+			{Time: at, ID: "b", Name: "db", CPU: time.Second},
+		},
+		{
+			{Time: at.Add(10 * time.Second), ID: "a", Name: "web", CPU: 6 * time.Second, Memory: 64 << 20, ReadBytes: 10 << 10, RxBytes: 1<<20 + 20<<10, TxBytes: 5 << 10, PIDs: 3},
+			{Time: at.Add(10 * time.Second), ID: "b", Name: "db", CPU: 0},
+			{Time: at.Add(10 * time.Second), ID: "c", Name: "new", CPU: time.Second},
+		},
+		{
+			{Time: at.Add(20 * time.Second), ID: "b", Name: "db", CPU: 10 * time.Second, WriteBytes: 1 << 20},
+			{Time: at.Add(20 * time.Second), ID: "c", Name: "new", CPU: time.Second},
+		},
+	}}
+}
+
+func TestRead(t *testing.T) {
+	r := NewReader(script(), WithInterval(time.Millisecond), WithMaxPolls(3))
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{
+		{0.5, 64, 1, 0, 2, 0.5, 3},
+		{1, 0, 0, 102.4, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+	}, data, "b restarted in the second poll and c is new")
+
+	_, err = NewReader(script()).Read()
+	assert.Error(t, err, "polling never ends without a limit")
+}
+
+func TestStreamUsage(t *testing.T) {
+	r := NewReader(script(), WithInterval(time.Millisecond))
+	usage, err := r.StreamUsage(context.Background())
+	require.NoError(t, err)
+
+	var names []string
+	for u := range usage {
+		names = append(names, u.Name)
+		assert.Equal(t, 10*time.Second, u.Interval)
+	}
+	assert.Equal(t, []string{"web", "db", "new"}, names)
+	assert.EqualError(t, r.Err(), "daemon gone")
+	assert.NoError(t, r.Close())
+}
+
+func TestStreamSamples(t *testing.T) {
+	r := NewReader(script(), WithInterval(time.Millisecond), WithMaxPolls(2))
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+
+	var got []uint64
+	for s := range samples {
+		got = append(got, s.Seq)
+		assert.Len(t, s.Features, len(FeatureNames))
+		assert.Equal(t, time.Date(2024, time.January, 2, 15, 4, 10, 0, time.UTC), s.Time)
+	}
+	assert.Equal(t, []uint64{1}, got)
+	assert.NoError(t, r.Err(), "reaching the poll limit is not an error")
+}
+
+func TestReadContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := NewReader(script(), WithInterval(time.Hour))
+	data, err := r.ReadContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, data)
+}
@@ -0,0 +1,244 @@
+// Package container reads the resource usage of containers, polled from
+// cgroup v2 files or the Docker Engine API, and turns it into
+// time-bucketed feature vectors for detecting cryptominers and runaway
+// workloads.
+//
+// A Source reports cumulative counters; the Reader polls it at a fixed
+// interval and emits, for every container seen in two consecutive polls,
+// the usage over the interval between them.
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats are the cumulative resource counters of a container at one
+// instant.
+type Stats struct {
+	Time time.Time
+	// ID identifies the container across polls.
+	ID string
+	// Name is a human-readable name, the ID if there is none.
+	Name string
+	// CPU is the CPU time used since the container started.
+	CPU time.Duration
+	// Memory is the memory in use, in bytes.
+	Memory     uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+	RxBytes    uint64
+	TxBytes    uint64
+	PIDs       uint64
+}
+
+// Source reports the current counters of every running container.
+type Source interface {
+	Collect(ctx context.Context) ([]Stats, error)
+}
+
+// DefaultCgroupPattern matches the cgroups of Docker, containerd, CRI-O and
+// Podman containers under either the systemd or the cgroupfs driver, its
+// submatch being the container ID.
+var DefaultCgroupPattern = regexp.MustCompile(`(?:^|/)(?:docker-|cri-containerd-|crio-|libpod-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// CgroupSource reads the counters of containers from the cgroup v2
+// hierarchy. Network counters come from the network namespace of the
+// first process of each cgroup, so a container sharing the host's
+// network reports the traffic of the whole host.
+type CgroupSource struct {
+	root    string
+	proc    string
+	pattern *regexp.Regexp
+	now     func() time.Time
+}
+
+// CgroupOption configures a CgroupSource.
+type CgroupOption func(*CgroupSource)
+
+// WithCgroupRoot sets where the cgroup v2 hierarchy is mounted. Defaults
+// to /sys/fs/cgroup.
+func WithCgroupRoot(dir string) CgroupOption {
+	return func(s *CgroupSource) {
+		s.root = dir
+	}
+}
+
+// WithProcRoot sets where procfs is mounted, for network counters.
+// Defaults to /proc.
+func WithProcRoot(dir string) CgroupOption {
+	return func(s *CgroupSource) {
+		s.proc = dir
+	}
+}
+
+// WithCgroupPattern sets which cgroups are reported: those whose path
+// relative to the root matches re. The first submatch, if any, is their
+// ID, otherwise the path is. Matching cgroups are reported with their
+// descendants, which are not matched themselves. Use it to watch systemd
+// services or other workloads that are not containers. Defaults to
+// DefaultCgroupPattern.
+func WithCgroupPattern(re *regexp.Regexp) CgroupOption {
+	return func(s *CgroupSource) {
+		s.pattern = re
+	}
+}
+
+// NewCgroupSource creates a CgroupSource.
+func NewCgroupSource(opts ...CgroupOption) *CgroupSource {
+	s := &CgroupSource{root: "/sys/fs/cgroup", proc: "/proc", pattern: DefaultCgroupPattern, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Collect walks the hierarchy and reads the counters of every matching
+// cgroup. Cgroups that disappear while being read are left out.
+func (s *CgroupSource) Collect(ctx context.Context) ([]Stats, error) {
+	var stats []Stats
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != s.root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.root, path)
+		m := s.pattern.FindStringSubmatch(filepath.ToSlash(rel))
+		if m == nil || rel == "." {
+			return nil
+		}
+		id := rel
+		if len(m) > 1 && m[1] != "" {
+			id = m[1]
+		}
+		if st, ok := s.read(path, id); ok {
+			stats = append(stats, st)
+		}
+		return fs.SkipDir
+	})
+	return stats, err
+}
+
+// read returns the counters of the cgroup at dir, and false if it has no
+// CPU accounting, such as when it was just removed.
+func (s *CgroupSource) read(dir, id string) (Stats, bool) {
+	st := Stats{Time: s.now(), ID: id, Name: id}
+	usec, ok := keyed(filepath.Join(dir, "cpu.stat"), "usage_usec")
+	if !ok {
+		return Stats{}, false
+	}
+	st.CPU = time.Duration(usec) * time.Microsecond
+	st.Memory, _ = single(filepath.Join(dir, "memory.current"))
+	st.PIDs, _ = single(filepath.Join(dir, "pids.current"))
+	st.ReadBytes, st.WriteBytes = ioStat(filepath.Join(dir, "io.stat"))
+	if pid, ok := firstPID(filepath.Join(dir, "cgroup.procs")); ok {
+		st.RxBytes, st.TxBytes = netDev(filepath.Join(s.proc, pid, "net", "dev"))
+	}
+	return st, true
+}
+
+// single reads a file holding one number, such as memory.current.
+func single(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+	return n, err == nil
+}
+
+// keyed reads the value of key in a flat keyed file, such as cpu.stat.
+func keyed(path, key string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(line, " ")
+		if ok && k == key {
+			n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// ioStat sums the bytes read and written over the devices of io.stat,
+// whose lines are like "8:0 rbytes=1024 wbytes=0 rios=1 wios=0".
+func ioStat(path string) (read, written uint64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+	for _, field := range strings.Fields(string(data)) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseUint(v, 10, 64)
+		switch k {
+		case "rbytes":
+			read += n
+		case "wbytes":
+			written += n
+		}
+	}
+	return read, written
+}
+
+// firstPID returns the first process listed in cgroup.procs.
+func firstPID(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	pid, _, _ := strings.Cut(string(data), "\n")
+	pid = strings.TrimSpace(pid)
+	return pid, pid != ""
+}
+
+// netDev sums the bytes received and sent over the interfaces of a
+// /proc/<pid>/net/dev file, loopback excluded.
+func netDev(path string) (rx, tx uint64) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		iface, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		// Received bytes come first, sent bytes after the eight receive
+		// counters.
+		f := strings.Fields(counters)
+		if len(f) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(f[0], 10, 64)
+		t, _ := strconv.ParseUint(f[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return rx, tx
+}
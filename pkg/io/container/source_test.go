@@ -0,0 +1,74 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates the files of contents, keyed by path relative to dir.
+func writeFiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestCgroupSource(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	root, proc := t.TempDir(), t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"system.slice/docker-" + id + ".scope/cpu.stat":                       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"system.slice/docker-" + id + ".scope/memory.current":                 "104857600\n",
+		"system.slice/docker-" + id + ".scope/pids.current":                   "12\n",
+		"system.slice/docker-" + id + ".scope/io.stat":                        "8:0 rbytes=4096 wbytes=1024 rios=1 wios=1 dbytes=0 dios=0\n8:16 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+		"system.slice/docker-" + id + ".scope/cgroup.procs":                   "4242\n4243\n",
+		"system.slice/docker-" + id + ".scope/init/cpu.stat":                  "usage_usec 1\n",
+		"kubepods.slice/pod1/cri-containerd-" + other + ".scope/cpu.stat":     "usage_usec 10\n",
+		"kubepods.slice/pod1/cri-containerd-" + other + ".scope/cgroup.procs": "",
+		"system.slice/sshd.service/cpu.stat":                                  "usage_usec 99\n",
+	})
+	writeFiles(t, proc, map[string]string{
+		"4242/net/dev": `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    5000      10    0    0    0     0          0         0     5000      10    0    0    0     0       0          0
+  eth0:    2048      20    0    0    0     0          0         0     1024      10    0    0    0     0       0          0
+`,
+	})
+
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+	s := NewCgroupSource(WithCgroupRoot(root), WithProcRoot(proc))
+	s.now = func() time.Time { return at }
+	stats, err := s.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 2, "descendants and other cgroups are not reported")
+
+	byID := map[string]Stats{}
+	for _, st := range stats {
+		byID[st.ID] = st
+	}
+	assert.Equal(t, Stats{
+		Time: at, ID: id, Name: id, CPU: 2500 * time.Millisecond, Memory: 100 << 20,
+		ReadBytes: 8192, WriteBytes: 1024, RxBytes: 2048, TxBytes: 1024, PIDs: 12,
+	}, byID[id])
+	assert.Equal(t, Stats{Time: at, ID: other, Name: other, CPU: 10 * time.Microsecond}, byID[other])
+
+	s = NewCgroupSource(WithCgroupRoot(root), WithCgroupPattern(regexp.MustCompile(`\.service$`)))
+	stats, err = s.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, "system.slice/sshd.service", stats[0].ID)
+
+	_, err = NewCgroupSource(WithCgroupRoot(filepath.Join(root, "missing"))).Collect(context.Background())
+	assert.Error(t, err)
+}
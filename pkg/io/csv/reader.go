@@ -5,17 +5,57 @@ import (
 	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
 )
 
+// RowError describes a row that could not be read. Without strict mode
+// such rows are skipped and reported to the skip handler; in strict mode
+// the first one is returned as the error.
+type RowError struct {
+	// Line is the 1-based line number the row starts on.
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("csv: line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// SkipFunc is called for every skipped row. Handlers run on the reading
+// goroutine, which is Stream's own goroutine when streaming.
+type SkipFunc func(err *RowError)
+
 // Reader reads data from CSV files.
 type Reader struct {
 	file      *os.File
 	reader    *csv.Reader
 	hasHeader bool
 	headers   []string
+	pool      *guardio.SamplePool
+	strict    bool
+	onSkip    SkipFunc
+	skipped   atomic.Int64
+	ragged    RaggedPolicy
+	imputer   data.Imputer
+	width     int // fields per row, from the header or first row
+
+	errMu     sync.Mutex
+	streamErr error
 }
 
 // Option configures a CSV reader.
@@ -28,6 +68,61 @@ func WithHeader(has bool) Option {
 	}
 }
 
+// WithSamplePool makes Stream take row buffers from pool instead of
+// allocating one per row. Rows of another width are allocated as usual.
+// Consumers should Put rows back once done with them.
+func WithSamplePool(pool *guardio.SamplePool) Option {
+	return func(r *Reader) {
+		r.pool = pool
+	}
+}
+
+// RaggedPolicy selects what the reader does with rows whose number of
+// fields differs from the header, or from the first row without one.
+type RaggedPolicy int
+
+const (
+	// RaggedReject treats such rows as malformed: they are skipped, or
+	// fail the read in strict mode. This is the default.
+	RaggedReject RaggedPolicy = iota
+	// RaggedTruncate drops extra trailing fields and rejects short rows.
+	RaggedTruncate
+	// RaggedPad drops extra trailing fields and fills missing ones, and
+	// empty fields, with NaN; see WithImputer to replace them.
+	RaggedPad
+)
+
+// WithRagged sets the policy for rows with missing or extra fields.
+func WithRagged(policy RaggedPolicy) Option {
+	return func(r *Reader) {
+		r.ragged = policy
+	}
+}
+
+// WithImputer applies imp to every row before it is returned, replacing
+// the NaN values left by RaggedPad or present in the file.
+func WithImputer(imp data.Imputer) Option {
+	return func(r *Reader) {
+		r.imputer = imp
+	}
+}
+
+// WithStrict makes the reader fail on the first row it cannot parse
+// instead of skipping it: Read and ReadDataset return a *RowError, and
+// Stream stops and reports it through Err.
+func WithStrict(strict bool) Option {
+	return func(r *Reader) {
+		r.strict = strict
+	}
+}
+
+// WithSkipHandler calls fn for every row the reader skips.
+func WithSkipHandler(fn SkipFunc) Option {
+	return func(r *Reader) {
+		r.onSkip = fn
+	}
+}
+
 // NewReader creates a new CSV reader.
 func NewReader(filename string, opts ...Option) (*Reader, error) {
 	file, err := os.Open(filename)
@@ -52,8 +147,14 @@ func NewReader(filename string, opts ...Option) (*Reader, error) {
 			file.Close()
 			return nil, err
 		}
-		r.headers = headers
+		r.headers = slices.Clone(headers)
+		r.width = len(headers)
 	}
+	// Rows are parsed into floats straight away, so the record slice can be
+	// reused between reads. Field counts are checked by conform, according
+	// to the ragged-row policy.
+	r.reader.ReuseRecord = true
+	r.reader.FieldsPerRecord = -1
 
 	return r, nil
 }
@@ -63,55 +164,129 @@ func (r *Reader) Headers() []string {
 	return r.headers
 }
 
-// Read returns all data as a 2D float slice.
+// Skipped returns the number of rows skipped so far because they could not
+// be parsed. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the error that stopped Stream early, if any: a read error,
+// or in strict mode the first malformed row. It is only meaningful once
+// the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// skip records a row that could not be parsed. In strict mode it returns
+// the row error, which the caller must return; otherwise it returns nil
+// and the caller skips the row.
+func (r *Reader) skip(err error) error {
+	rowErr := &RowError{Err: err}
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		rowErr.Line, rowErr.Err = perr.StartLine, perr.Err
+	} else {
+		rowErr.Line, _ = r.reader.FieldPos(0)
+	}
+
+	if r.strict {
+		return rowErr
+	}
+	r.skipped.Add(1)
+	if r.onSkip != nil {
+		r.onSkip(rowErr)
+	}
+	return nil
+}
+
+// Read returns all data as a 2D float slice. Malformed rows are skipped
+// unless the reader is strict.
 func (r *Reader) Read() ([][]float64, error) {
 	var data [][]float64
-
 	for {
-		record, err := r.reader.Read()
+		row, err := r.next(false)
 		if err == io.EOF {
-			break
+			return data, nil
 		}
 		if err != nil {
 			return nil, err
 		}
+		data = append(data, row)
+	}
+}
 
-		row, err := parseRow(record)
+// ReadDataset returns all data as a contiguous dataset. Column headers,
+// if present, become the feature names. Malformed rows are skipped unless
+// the reader is strict.
+func (r *Reader) ReadDataset() (*data.Dataset, error) {
+	ds := &data.Dataset{}
+	for {
+		row, err := r.next(false)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			continue // Skip malformed rows
+			return nil, err
+		}
+		if err := ds.Append(row); err != nil {
+			return nil, err
 		}
-		data = append(data, row)
 	}
 
-	return data, nil
+	if len(r.headers) == ds.Features() {
+		if err := ds.SetFeatureNames(r.headers); err != nil {
+			return nil, err
+		}
+	}
+	return ds, nil
 }
 
-// Stream returns a channel of rows for real-time processing.
+// Stream returns a channel of rows for real-time processing. Rows that
+// cannot be read are skipped; in strict mode the stream stops at the
+// first one instead and Err reports it.
 func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
-	out := make(chan []float64, 100)
+	return stream(ctx, r, func(row []float64, _ uint64) []float64 { return row }), nil
+}
+
+// StreamSamples is Stream with each row stamped with the time it was read
+// and its sequence number among the rows emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(row []float64, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: row, Time: time.Now(), Seq: seq}
+	}), nil
+}
+
+// stream emits wrap(row, seq) for every row until the end of the file, a
+// read error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(row []float64, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
 
 	go func() {
 		defer close(out)
-		for {
+		for seq := uint64(1); ; seq++ {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				record, err := r.reader.Read()
+				row, err := r.next(true)
 				if err == io.EOF {
 					return
 				}
 				if err != nil {
-					continue
-				}
-
-				row, err := parseRow(record)
-				if err != nil {
-					continue
+					r.setErr(err)
+					return
 				}
 
 				select {
-				case out <- row:
+				case out <- wrap(row, seq):
 				case <-ctx.Done():
 					return
 				}
@@ -119,7 +294,35 @@ func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
 		}
 	}()
 
-	return out, nil
+	return out
+}
+
+// next returns the next well-formed row, skipping malformed ones, or
+// io.EOF at the end of the file. Rows come from the sample pool if pooled
+// is set and the pool has the row width.
+func (r *Reader) next(pooled bool) ([]float64, error) {
+	for {
+		record, err := r.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		var perr *csv.ParseError
+		if err != nil && !errors.As(err, &perr) {
+			// I/O errors persist, so there is nothing to skip to.
+			return nil, err
+		}
+
+		var row []float64
+		if err == nil {
+			row, err = r.parseRecord(record, pooled)
+		}
+		if err == nil {
+			return row, nil
+		}
+		if err := r.skip(err); err != nil {
+			return nil, err
+		}
+	}
 }
 
 // Close releases resources.
@@ -130,19 +333,63 @@ func (r *Reader) Close() error {
 	return nil
 }
 
-// parseRow converts string slice to float slice.
-func parseRow(record []string) ([]float64, error) {
-	if len(record) == 0 {
-		return nil, errors.New("empty row")
+// conform applies the ragged-row policy to record, returning the fields
+// to parse. Fewer fields than the row width are only returned under
+// RaggedPad.
+func (r *Reader) conform(record []string) ([]string, error) {
+	if r.width == 0 {
+		r.width = len(record)
+	}
+	switch {
+	case len(record) == r.width:
+		return record, nil
+	case len(record) > r.width && r.ragged != RaggedReject:
+		return record[:r.width], nil
+	case len(record) < r.width && r.ragged == RaggedPad:
+		return record, nil
+	}
+	return nil, fmt.Errorf("%w: %d fields, expected %d", csv.ErrFieldCount, len(record), r.width)
+}
+
+// parseRecord converts record into a row of the reader's width.
+func (r *Reader) parseRecord(record []string, pooled bool) ([]float64, error) {
+	record, err := r.conform(record)
+	if err != nil {
+		return nil, err
 	}
 
-	row := make([]float64, len(record))
-	for i, val := range record {
-		f, err := strconv.ParseFloat(val, 64)
+	pooled = pooled && r.pool != nil && r.pool.Width() == r.width
+	var row []float64
+	if pooled {
+		row = r.pool.Get()
+	} else {
+		row = make([]float64, r.width)
+	}
+	if err := r.parseInto(row, record); err != nil {
+		if pooled {
+			r.pool.Put(row)
+		}
+		return nil, err
+	}
+	if r.imputer != nil {
+		r.imputer.Impute(row)
+	}
+	return row, nil
+}
+
+// parseInto parses record into row, marking fields missing under
+// RaggedPad as NaN.
+func (r *Reader) parseInto(row []float64, record []string) error {
+	for i := range row {
+		if i >= len(record) || (record[i] == "" && r.ragged == RaggedPad) {
+			row[i] = math.NaN()
+			continue
+		}
+		f, err := strconv.ParseFloat(record[i], 64)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		row[i] = f
 	}
-	return row, nil
+	return nil
 }
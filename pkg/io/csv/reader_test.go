@@ -0,0 +1,217 @@
+package csv
+
+import (
+	"context"
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func TestReadDataset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := "bytes,packets\n100,2\nabc,3\n300,4\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	r, err := NewReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	ds, err := r.ReadDataset()
+	require.NoError(t, err)
+	assert.Equal(t, 2, ds.Len(), "malformed rows are skipped")
+	assert.Equal(t, []float64{100, 2, 300, 4}, ds.Values())
+	assert.Equal(t, []string{"bytes", "packets"}, ds.FeatureNames())
+}
+
+func TestStreamSamplePool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := "bytes,packets\n100,2\nabc,3\n300,4\n5,6,7\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	pool := guardio.NewSamplePool(2, 0)
+	r, err := NewReader(path, WithSamplePool(pool))
+	require.NoError(t, err)
+	defer r.Close()
+
+	samples, err := r.Stream(context.Background())
+	require.NoError(t, err)
+
+	var rows [][]float64
+	for s := range samples {
+		rows = append(rows, slices.Clone(s))
+		pool.Put(s)
+	}
+	assert.Equal(t, [][]float64{{100, 2}, {300, 4}}, rows)
+	assert.Equal(t, []string{"bytes", "packets"}, r.Headers(),
+		"headers survive record reuse")
+}
+
+func TestSkippedRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := "bytes,packets\n100,2\nabc,3\n300,4\n7,x\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	read := map[string]func(r *Reader) (int, error){
+		"Read": func(r *Reader) (int, error) {
+			rows, err := r.Read()
+			return len(rows), err
+		},
+		"ReadDataset": func(r *Reader) (int, error) {
+			ds, err := r.ReadDataset()
+			if err != nil {
+				return 0, err
+			}
+			return ds.Len(), nil
+		},
+		"Stream": func(r *Reader) (int, error) {
+			rows, err := r.Stream(context.Background())
+			require.NoError(t, err)
+			var n int
+			for range rows {
+				n++
+			}
+			return n, r.Err()
+		},
+	}
+
+	for name, fn := range read {
+		t.Run(name, func(t *testing.T) {
+			var lines []int
+			r, err := NewReader(path, WithSkipHandler(func(err *RowError) {
+				lines = append(lines, err.Line)
+			}))
+			require.NoError(t, err)
+			defer r.Close()
+
+			n, err := fn(r)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			assert.Equal(t, 2, r.Skipped())
+			assert.Equal(t, []int{3, 5}, lines)
+		})
+
+		t.Run(name+"/strict", func(t *testing.T) {
+			r, err := NewReader(path, WithStrict(true))
+			require.NoError(t, err)
+			defer r.Close()
+
+			_, err = fn(r)
+			var rowErr *RowError
+			require.ErrorAs(t, err, &rowErr)
+			assert.Equal(t, 3, rowErr.Line)
+			assert.ErrorIs(t, err, strconv.ErrSyntax)
+			assert.Equal(t, 0, r.Skipped())
+		})
+	}
+}
+
+func TestRaggedRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := "a,b,c\n1,2,3\n4,5\n6,7,8,9\n10,,12\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	nan := math.NaN()
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    [][]float64
+		skipped int
+	}{
+		{
+			name:    "reject",
+			want:    [][]float64{{1, 2, 3}},
+			skipped: 3,
+		},
+		{
+			name:    "truncate",
+			opts:    []Option{WithRagged(RaggedTruncate)},
+			want:    [][]float64{{1, 2, 3}, {6, 7, 8}},
+			skipped: 2,
+		},
+		{
+			name: "pad",
+			opts: []Option{WithRagged(RaggedPad)},
+			want: [][]float64{{1, 2, 3}, {4, 5, nan}, {6, 7, 8}, {10, nan, 12}},
+		},
+		{
+			name: "pad and impute",
+			opts: []Option{WithRagged(RaggedPad), WithImputer(data.ConstantImputer(0))},
+			want: [][]float64{{1, 2, 3}, {4, 5, 0}, {6, 7, 8}, {10, 0, 12}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(path, tt.opts...)
+			require.NoError(t, err)
+			defer r.Close()
+
+			rows, err := r.Read()
+			require.NoError(t, err)
+			require.Len(t, rows, len(tt.want))
+			for i := range rows {
+				assert.True(t, slices.EqualFunc(tt.want[i], rows[i], func(a, b float64) bool {
+					return a == b || (math.IsNaN(a) && math.IsNaN(b))
+				}), "row %d: %v", i, rows[i])
+			}
+			assert.Equal(t, tt.skipped, r.Skipped())
+		})
+	}
+
+	t.Run("strict reports the field count", func(t *testing.T) {
+		r, err := NewReader(path, WithStrict(true))
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = r.Read()
+		assert.ErrorIs(t, err, csv.ErrFieldCount)
+		var rowErr *RowError
+		require.ErrorAs(t, err, &rowErr)
+		assert.Equal(t, 3, rowErr.Line)
+	})
+
+	t.Run("width from the first row without a header", func(t *testing.T) {
+		r, err := NewReader(path, WithHeader(false), WithRagged(RaggedTruncate))
+		require.NoError(t, err)
+		defer r.Close()
+
+		rows, err := r.Read()
+		require.NoError(t, err)
+		assert.Equal(t, [][]float64{{1, 2, 3}, {6, 7, 8}}, rows, "the header row does not parse")
+	})
+}
+
+func TestStreamSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := "bytes,packets\n100,2\nabc,3\n300,4\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	r, err := NewReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	before := time.Now()
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+
+	var got []guardio.Sample
+	for s := range samples {
+		got = append(got, s)
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, []float64{300, 4}, got[1].Features)
+	assert.Equal(t, uint64(1), got[0].Seq)
+	assert.Equal(t, uint64(2), got[1].Seq, "skipped rows do not use sequence numbers")
+	assert.False(t, got[0].Time.Before(before))
+}
@@ -0,0 +1,222 @@
+// Package dataset provides the preprocessing that precedes most Fit calls:
+// shuffling, removing duplicate rows, and random or stratified
+// downsampling.
+//
+// Each operation selects rows by index, so the same selection can be
+// applied to parallel slices such as labels with Take. The functions on
+// [][]float64 return new slices sharing the row slices of their input; the
+// *data.Dataset variants copy the selected rows, labels and timestamps
+// into a new dataset.
+package dataset
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"slices"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+)
+
+// Permutation returns the indices 0..n-1 in an order determined by seed.
+func Permutation(n int, seed int64) []int {
+	return rand.New(rand.NewSource(seed)).Perm(n)
+}
+
+// SampleIndices returns k indices drawn from 0..n-1 without replacement,
+// chosen by seed, in increasing order. It returns all indices when k >= n.
+func SampleIndices(n, k int, seed int64) []int {
+	if k >= n {
+		return identity(n)
+	}
+	if k <= 0 {
+		return []int{}
+	}
+	idx := partialShuffle(identity(n), k, rand.New(rand.NewSource(seed)))
+	slices.Sort(idx)
+	return idx
+}
+
+// StratifiedIndices returns k indices into labels, drawn without
+// replacement so every label keeps its share of the rows, in increasing
+// order. Shares are rounded by largest remainder, so a label with a share
+// of at least one row is never dropped. NaN labels form one class. It
+// returns all indices when k >= len(labels).
+func StratifiedIndices(labels []float64, k int, seed int64) []int {
+	n := len(labels)
+	if k >= n {
+		return identity(n)
+	}
+	if k <= 0 {
+		return []int{}
+	}
+
+	// Group rows by label, in order of first appearance.
+	var classes [][]int
+	class := make(map[uint64]int)
+	for i, l := range labels {
+		key := valueKey(l)
+		c, ok := class[key]
+		if !ok {
+			c = len(classes)
+			class[key] = c
+			classes = append(classes, nil)
+		}
+		classes[c] = append(classes[c], i)
+	}
+
+	// Largest remainder: floor the exact shares, then give the rows left
+	// over to the classes with the largest fractions.
+	quota := make([]int, len(classes))
+	order := make([]int, len(classes))
+	left := k
+	for c, rows := range classes {
+		quota[c] = len(rows) * k / n
+		left -= quota[c]
+		order[c] = c
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		// Compare remainders len*k mod n exactly in integers.
+		return (len(classes[b]) * k % n) - (len(classes[a]) * k % n)
+	})
+	for _, c := range order[:left] {
+		quota[c]++
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	idx := make([]int, 0, k)
+	for c, rows := range classes {
+		idx = append(idx, partialShuffle(rows, quota[c], rng)...)
+	}
+	slices.Sort(idx)
+	return idx
+}
+
+// DedupIndices returns the index of the first occurrence of each distinct
+// row, in increasing order. Rows are identical when they have the same
+// length and values; NaN equals NaN and -0 equals 0.
+func DedupIndices(rows [][]float64) []int {
+	seen := make(map[string]struct{}, len(rows))
+	idx := make([]int, 0, len(rows))
+	var key []byte
+	for i, row := range rows {
+		key = key[:0]
+		for _, v := range row {
+			key = binary.LittleEndian.AppendUint64(key, valueKey(v))
+		}
+		if _, dup := seen[string(key)]; dup {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// Take returns the elements of s at idx, in the order of idx.
+func Take[T any](s []T, idx []int) []T {
+	out := make([]T, len(idx))
+	for i, j := range idx {
+		out[i] = s[j]
+	}
+	return out
+}
+
+// Shuffle returns rows in an order determined by seed.
+func Shuffle(rows [][]float64, seed int64) [][]float64 {
+	return Take(rows, Permutation(len(rows), seed))
+}
+
+// Dedup returns rows without repeats of earlier rows, in order.
+func Dedup(rows [][]float64) [][]float64 {
+	return Take(rows, DedupIndices(rows))
+}
+
+// Sample returns k rows drawn at random without replacement, in their
+// original order. It returns all rows when k >= len(rows).
+func Sample(rows [][]float64, k int, seed int64) [][]float64 {
+	return Take(rows, SampleIndices(len(rows), k, seed))
+}
+
+// Stratified returns k rows and their labels, drawn so each label
+// keeps its share of the rows, in their original order. See
+// StratifiedIndices.
+func Stratified(rows [][]float64, labels []float64, k int, seed int64) ([][]float64, []float64) {
+	idx := StratifiedIndices(labels, k, seed)
+	return Take(rows, idx), Take(labels, idx)
+}
+
+// TakeDataset copies the samples of d at idx, with their labels and
+// timestamps, into a new dataset with the same feature names.
+func TakeDataset(d *data.Dataset, idx []int) *data.Dataset {
+	out := data.New(len(idx), d.Features())
+	for i, j := range idx {
+		copy(out.Row(i), d.Row(j))
+	}
+	// The lengths match by construction, so the setters cannot fail.
+	_ = out.SetFeatureNames(d.FeatureNames())
+	if labels := d.Labels(); labels != nil {
+		_ = out.SetLabels(Take(labels, idx))
+	}
+	if ts := d.Timestamps(); ts != nil {
+		_ = out.SetTimestamps(Take(ts, idx))
+	}
+	return out
+}
+
+// ShuffleDataset returns the samples of d in an order determined by seed.
+func ShuffleDataset(d *data.Dataset, seed int64) *data.Dataset {
+	return TakeDataset(d, Permutation(d.Len(), seed))
+}
+
+// DedupDataset returns d without samples whose features repeat an earlier
+// sample.
+func DedupDataset(d *data.Dataset) *data.Dataset {
+	return TakeDataset(d, DedupIndices(d.Rows()))
+}
+
+// SampleDataset returns k samples of d drawn at random without
+// replacement, in their original order.
+func SampleDataset(d *data.Dataset, k int, seed int64) *data.Dataset {
+	return TakeDataset(d, SampleIndices(d.Len(), k, seed))
+}
+
+// StratifiedDataset returns k samples of d drawn so each label keeps
+// its share, or a random sample when d has no labels.
+func StratifiedDataset(d *data.Dataset, k int, seed int64) *data.Dataset {
+	if d.Labels() == nil {
+		return SampleDataset(d, k, seed)
+	}
+	return TakeDataset(d, StratifiedIndices(d.Labels(), k, seed))
+}
+
+// identity returns 0..n-1.
+func identity(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// partialShuffle moves a uniform random choice of k elements of s to its
+// front and returns them.
+func partialShuffle(s []int, k int, rng *rand.Rand) []int {
+	for i := 0; i < k; i++ {
+		j := i + rng.Intn(len(s)-i)
+		s[i], s[j] = s[j], s[i]
+	}
+	return s[:k]
+}
+
+// valueKey maps values that compare identical for deduplication to the
+// same key.
+func valueKey(v float64) uint64 {
+	switch {
+	case math.IsNaN(v):
+		return math.Float64bits(math.NaN())
+	case v == 0:
+		return 0
+	}
+	return math.Float64bits(v)
+}
@@ -0,0 +1,130 @@
+package dataset
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+)
+
+func numbered(n int) [][]float64 {
+	rows := make([][]float64, n)
+	for i := range rows {
+		rows[i] = []float64{float64(i)}
+	}
+	return rows
+}
+
+func TestShuffle(t *testing.T) {
+	rows := numbered(50)
+	shuffled := Shuffle(rows, 7)
+	assert.Equal(t, shuffled, Shuffle(rows, 7), "same seed, same order")
+	assert.NotEqual(t, shuffled, Shuffle(rows, 8))
+	assert.NotEqual(t, rows, shuffled)
+	assert.ElementsMatch(t, rows, shuffled)
+	assert.Equal(t, numbered(50), rows, "input is left alone")
+}
+
+func TestDedup(t *testing.T) {
+	nan := math.NaN()
+	rows := [][]float64{
+		{1, 2}, {1, 2}, {2, 1}, {nan, 0}, {nan, math.Copysign(0, -1)}, {1}, {1, 2, 0}, {1},
+	}
+	assert.Equal(t, []int{0, 2, 3, 5, 6}, DedupIndices(rows))
+	assert.Len(t, Dedup(rows), 5)
+	assert.Empty(t, Dedup(nil))
+}
+
+func TestSample(t *testing.T) {
+	rows := numbered(100)
+	sample := Sample(rows, 10, 3)
+	require.Len(t, sample, 10)
+	assert.Equal(t, sample, Sample(rows, 10, 3))
+	assert.True(t, slices.IsSortedFunc(sample, func(a, b []float64) int { return int(a[0] - b[0]) }), "original order kept")
+	assert.Len(t, Dedup(sample), 10, "drawn without replacement")
+
+	assert.Equal(t, rows, Sample(rows, 100, 3))
+	assert.Equal(t, rows, Sample(rows, 500, 3))
+	assert.Empty(t, Sample(rows, 0, 3))
+}
+
+func TestStratified(t *testing.T) {
+	// 90 normal, 7 anomalies and 3 unlabeled rows.
+	rows := numbered(100)
+	labels := make([]float64, 100)
+	for i := range labels {
+		switch {
+		case i >= 97:
+			labels[i] = math.NaN()
+		case i%13 == 12:
+			labels[i] = 1
+		}
+	}
+
+	count := func(labels []float64) map[string]int {
+		counts := make(map[string]int)
+		for _, l := range labels {
+			switch {
+			case math.IsNaN(l):
+				counts["unlabeled"]++
+			case l == 1:
+				counts["anomaly"]++
+			default:
+				counts["normal"]++
+			}
+		}
+		return counts
+	}
+	require.Equal(t, map[string]int{"normal": 90, "anomaly": 7, "unlabeled": 3}, count(labels))
+
+	sample, sampled := Stratified(rows, labels, 20, 1)
+	require.Len(t, sample, 20)
+	// Shares 18, 1.4 and 0.6: the leftover row goes to the unlabeled rows.
+	assert.Equal(t, map[string]int{"normal": 18, "anomaly": 1, "unlabeled": 1}, count(sampled))
+	for i, row := range sample {
+		assert.Equal(t, labels[int(row[0])] == 1, sampled[i] == 1)
+	}
+
+	idx := StratifiedIndices(labels, 50, 1)
+	assert.Len(t, idx, 50)
+	assert.True(t, slices.IsSorted(idx))
+	assert.Equal(t, map[string]int{"normal": 45, "anomaly": 4, "unlabeled": 1}, count(Take(labels, idx)))
+	assert.Len(t, StratifiedIndices(labels, 200, 1), 100)
+}
+
+func TestDatasetVariants(t *testing.T) {
+	d, err := data.FromRows([][]float64{{1, 1}, {2, 2}, {1, 1}, {3, 3}})
+	require.NoError(t, err)
+	require.NoError(t, d.SetFeatureNames([]string{"a", "b"}))
+	require.NoError(t, d.SetLabels([]float64{0, 1, 0, 0}))
+	start := time.Unix(1700000000, 0)
+	require.NoError(t, d.SetTimestamps([]time.Time{start, start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)}))
+
+	dedup := DedupDataset(d)
+	require.Equal(t, 3, dedup.Len())
+	assert.Equal(t, []float64{1, 1, 2, 2, 3, 3}, dedup.Values())
+	assert.Equal(t, []float64{0, 1, 0}, dedup.Labels())
+	assert.Equal(t, start.Add(3*time.Second), dedup.Timestamps()[2])
+	assert.Equal(t, []string{"a", "b"}, dedup.FeatureNames())
+
+	shuffled := ShuffleDataset(d, 1)
+	assert.Equal(t, 4, shuffled.Len())
+	for i := 0; i < shuffled.Len(); i++ {
+		j := int(shuffled.Timestamps()[i].Sub(start) / time.Second)
+		assert.Equal(t, d.Row(j), shuffled.Row(i))
+		assert.Equal(t, d.Labels()[j], shuffled.Labels()[i])
+	}
+
+	assert.Equal(t, 2, SampleDataset(d, 2, 1).Len())
+	strat := StratifiedDataset(d, 3, 1)
+	assert.ElementsMatch(t, []float64{0, 0, 1}, strat.Labels())
+
+	// Copies do not share the backing array.
+	dedup.Set(0, 0, 9)
+	assert.Equal(t, 1.0, d.At(0, 0))
+}
@@ -0,0 +1,37 @@
+package io
+
+import (
+	"net/netip"
+	"time"
+)
+
+// DNS response codes of DNSMessage.RCode.
+const (
+	RCodeNoError  uint8 = 0
+	RCodeServFail uint8 = 2
+	RCodeNXDomain uint8 = 3
+)
+
+// DNSMessage summarizes a DNS query or response: its first question and,
+// for responses, the outcome. Client is the host that asked and Server
+// the resolver, whichever way the message went.
+type DNSMessage struct {
+	Time   time.Time
+	Client netip.Addr
+	Server netip.Addr
+	ID     uint16
+	// Name is the queried name in lower case, without the trailing dot.
+	Name string
+	// Type is the query type, such as 1 for A or 16 for TXT.
+	Type     uint16
+	Response bool
+	// RCode and Answers are zero for queries.
+	RCode   uint8
+	Answers int
+}
+
+// NXDomain reports whether m is a response saying the name does not
+// exist.
+func (m DNSMessage) NXDomain() bool {
+	return m.Response && m.RCode == RCodeNXDomain
+}
@@ -0,0 +1,25 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSMessageNXDomain(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  DNSMessage
+		want bool
+	}{
+		{name: "nxdomain", msg: DNSMessage{Response: true, RCode: RCodeNXDomain}, want: true},
+		{name: "answered", msg: DNSMessage{Response: true, RCode: RCodeNoError, Answers: 1}},
+		{name: "servfail", msg: DNSMessage{Response: true, RCode: RCodeServFail}},
+		{name: "query", msg: DNSMessage{RCode: RCodeNXDomain}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.msg.NXDomain())
+		})
+	}
+}
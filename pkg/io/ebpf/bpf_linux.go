@@ -0,0 +1,275 @@
+//go:build linux && (amd64 || arm64)
+
+package ebpf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// bpf system call commands.
+const (
+	cmdMapCreate         = 0
+	cmdMapLookupElem     = 1
+	cmdMapDeleteElem     = 3
+	cmdMapGetNextKey     = 4
+	cmdProgLoad          = 5
+	cmdRawTracepointOpen = 17
+)
+
+// Program and map types.
+const (
+	progTypeTracepoint    = 5
+	progTypeRawTracepoint = 17
+	mapTypeHash           = 1
+)
+
+// Helper functions callable from programs.
+const (
+	helperMapLookupElem     = 1
+	helperMapUpdateElem     = 2
+	helperGetCurrentPIDTGID = 14
+)
+
+// flagNoExist makes a map update fail if the key exists.
+const flagNoExist = 1
+
+// The attribute structs mirror union bpf_attr. Pointers are kept as
+// unsafe.Pointer, which is 64 bits wide on the supported architectures,
+// so the garbage collector keeps what they point to alive and in place.
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+type mapElemAttr struct {
+	fd    uint32
+	_     uint32
+	key   unsafe.Pointer
+	value unsafe.Pointer // or the next key
+	flags uint64
+}
+
+type progLoadAttr struct {
+	progType    uint32
+	insnCount   uint32
+	insns       unsafe.Pointer
+	license     unsafe.Pointer
+	logLevel    uint32
+	logSize     uint32
+	logBuf      unsafe.Pointer
+	kernVersion uint32
+	progFlags   uint32
+	name        [16]byte
+}
+
+type rawTracepointAttr struct {
+	name   unsafe.Pointer
+	progFD uint32
+	_      uint32
+}
+
+func bpf(cmd uintptr, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := syscall.Syscall(sysBPF, cmd, uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// hashMap is a BPF hash map of fixed-size keys and uint64 values.
+type hashMap struct {
+	fd      int
+	keySize int
+}
+
+func newHashMap(keySize, maxEntries int) (*hashMap, error) {
+	attr := mapCreateAttr{mapType: mapTypeHash, keySize: uint32(keySize), valueSize: 8, maxEntries: uint32(maxEntries)}
+	fd, err := bpf(cmdMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return nil, fmt.Errorf("ebpf: create map: %w", err)
+	}
+	return &hashMap{fd: fd, keySize: keySize}, nil
+}
+
+// entries returns the map's contents, keys as raw bytes. Entries added
+// while it iterates may be missed until the next call.
+func (m *hashMap) entries() (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	var prev []byte
+	for {
+		next := make([]byte, m.keySize)
+		attr := mapElemAttr{fd: uint32(m.fd), value: unsafe.Pointer(&next[0])}
+		if prev != nil {
+			attr.key = unsafe.Pointer(&prev[0])
+		}
+		_, err := bpf(cmdMapGetNextKey, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		if errors.Is(err, syscall.ENOENT) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ebpf: iterate map: %w", err)
+		}
+
+		var value uint64
+		attr = mapElemAttr{fd: uint32(m.fd), key: unsafe.Pointer(&next[0]), value: unsafe.Pointer(&value)}
+		_, err = bpf(cmdMapLookupElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		switch {
+		case err == nil:
+			out[string(next)] = value
+		case !errors.Is(err, syscall.ENOENT): // deleted meanwhile
+			return nil, fmt.Errorf("ebpf: read map: %w", err)
+		}
+		prev = next
+	}
+}
+
+// delete removes key, if present.
+func (m *hashMap) delete(key []byte) error {
+	attr := mapElemAttr{fd: uint32(m.fd), key: unsafe.Pointer(&key[0])}
+	_, err := bpf(cmdMapDeleteElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("ebpf: delete from map: %w", err)
+	}
+	return nil
+}
+
+func (m *hashMap) Close() error {
+	return syscall.Close(m.fd)
+}
+
+// loadProgram loads a program of progType. On failure it loads it again
+// to include the verifier's log in the error.
+func loadProgram(progType uint32, name string, insns []insn) (int, error) {
+	license := []byte("Dual MIT/GPL\x00")
+	attr := progLoadAttr{
+		progType:  progType,
+		insnCount: uint32(len(insns)),
+		insns:     unsafe.Pointer(&insns[0]),
+		license:   unsafe.Pointer(&license[0]),
+	}
+	copy(attr.name[:len(attr.name)-1], name)
+	fd, err := bpf(cmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == nil {
+		return fd, nil
+	}
+
+	log := make([]byte, 64<<10)
+	attr.logLevel, attr.logSize, attr.logBuf = 1, uint32(len(log)), unsafe.Pointer(&log[0])
+	if fd, err2 := bpf(cmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err2 == nil {
+		return fd, nil
+	}
+	if n := bytes.IndexByte(log, 0); n > 0 {
+		return -1, fmt.Errorf("ebpf: load %s: %w: %s", name, err, bytes.TrimSpace(log[:n]))
+	}
+	return -1, fmt.Errorf("ebpf: load %s: %w", name, err)
+}
+
+// attachRawTracepoint attaches a raw tracepoint program, returning the fd
+// that keeps it attached.
+func attachRawTracepoint(name string, prog int) (int, error) {
+	cname := append([]byte(name), 0)
+	attr := rawTracepointAttr{name: unsafe.Pointer(&cname[0]), progFD: uint32(prog)}
+	fd, err := bpf(cmdRawTracepointOpen, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("ebpf: attach to %s: %w", name, err)
+	}
+	return fd, nil
+}
+
+// insn is one BPF instruction.
+type insn struct {
+	code uint8
+	regs uint8 // destination register in the low nibble, source in the high
+	off  int16
+	imm  int32
+}
+
+// Registers.
+const (
+	r0 uint8 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10 // frame pointer
+)
+
+// Operand sizes of loads and stores.
+const (
+	sizeW  = 0x00
+	sizeH  = 0x08
+	sizeDW = 0x18
+)
+
+// asm assembles a program, resolving jumps to labels.
+type asm struct {
+	insns  []insn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func newAsm() *asm {
+	return &asm{labels: make(map[string]int), jumps: make(map[int]string)}
+}
+
+func (a *asm) emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, insn{code: code, regs: dst | src<<4, off: off, imm: imm})
+}
+
+func (a *asm) mov(dst uint8, imm int32)             { a.emit(0xb7, dst, 0, 0, imm) }
+func (a *asm) movReg(dst, src uint8)                { a.emit(0xbf, dst, src, 0, 0) }
+func (a *asm) add(dst uint8, imm int32)             { a.emit(0x07, dst, 0, 0, imm) }
+func (a *asm) rsh(dst uint8, imm int32)             { a.emit(0x77, dst, 0, 0, imm) }
+func (a *asm) load(size, dst, src uint8, off int16) { a.emit(0x61|size, dst, src, off, 0) }
+func (a *asm) store(size, dst, src uint8, off int16) {
+	a.emit(0x63|size, dst, src, off, 0)
+}
+func (a *asm) storeImm(size, dst uint8, off int16, imm int32) { a.emit(0x62|size, dst, 0, off, imm) }
+func (a *asm) atomicAdd(dst, src uint8, off int16)            { a.emit(0xdb, dst, src, off, 0) }
+func (a *asm) call(helper int32)                              { a.emit(0x85, 0, 0, 0, helper) }
+func (a *asm) exit()                                          { a.emit(0x95, 0, 0, 0, 0) }
+
+// loadMap loads the address of the map fd into dst.
+func (a *asm) loadMap(dst uint8, fd int) {
+	const pseudoMapFD = 1
+	a.emit(0x18, dst, pseudoMapFD, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *asm) jump(code, dst uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(code, dst, 0, 0, imm)
+}
+
+func (a *asm) jeq(dst uint8, imm int32, label string) { a.jump(0x15, dst, imm, label) }
+func (a *asm) jne(dst uint8, imm int32, label string) { a.jump(0x55, dst, imm, label) }
+func (a *asm) ja(label string)                        { a.jump(0x05, 0, 0, label) }
+
+func (a *asm) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+// assemble returns the instructions with the jump offsets filled in.
+func (a *asm) assemble() []insn {
+	for i, label := range a.jumps {
+		target, ok := a.labels[label]
+		if !ok {
+			panic("ebpf: undefined label " + label)
+		}
+		a.insns[i].off = int16(target - i - 1)
+	}
+	return a.insns
+}
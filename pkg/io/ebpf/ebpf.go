@@ -0,0 +1,416 @@
+// Package ebpf streams process-level activity traced in the kernel with
+// eBPF: the system call rate of every process and the outbound TCP
+// connections it opens. Unlike packet capture, every connection is
+// attributed to the process that made it, and no capture interface or
+// libpcap is needed.
+//
+// Tracing needs Linux on amd64 or arm64, tracefs mounted at
+// /sys/kernel/tracing or /sys/kernel/debug/tracing, and the CAP_BPF and
+// CAP_PERFMON capabilities (or root). Elsewhere NewReader returns an
+// error wrapping errors.ErrUnsupported.
+package ebpf
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// FeatureNames names the features of a process's activity over an
+// interval, in vector order: system calls and outbound TCP connections
+// per second, and the distinct remote addresses and ports connected to.
+var FeatureNames = []string{"syscall_rate", "connect_rate", "destinations", "ports"}
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Activity is what a process did over one polling interval.
+type Activity struct {
+	// Time is the end of the interval.
+	Time time.Time
+	PID  int
+	// Comm is the command name of the process, empty if it exited before
+	// it could be read.
+	Comm     string
+	Interval time.Duration
+	Syscalls uint64
+	// Connects counts the outbound TCP connections attempted.
+	Connects uint64
+	// Remotes lists the addresses connected to, each once, in order.
+	Remotes []netip.AddrPort
+}
+
+// Features returns the feature vector of a, named by FeatureNames.
+func (a Activity) Features() []float64 {
+	secs := a.Interval.Seconds()
+	addrs := make(map[netip.Addr]struct{}, len(a.Remotes))
+	ports := make(map[uint16]struct{}, len(a.Remotes))
+	for _, r := range a.Remotes {
+		addrs[r.Addr()] = struct{}{}
+		ports[r.Port()] = struct{}{}
+	}
+	return []float64{
+		float64(a.Syscalls) / secs,
+		float64(a.Connects) / secs,
+		float64(len(addrs)),
+		float64(len(ports)),
+	}
+}
+
+// connKey is the key of the kernel's connection counters, laid out as the
+// BPF program writes it.
+type connKey struct {
+	PID    uint32
+	Family uint16
+	Port   uint16
+	Addr   [16]byte
+}
+
+// remote returns the address connected to.
+func (k connKey) remote() netip.AddrPort {
+	addr := netip.AddrFrom16(k.Addr).Unmap()
+	if k.Family == afInet {
+		addr = netip.AddrFrom4([4]byte(k.Addr[:4]))
+	}
+	return netip.AddrPortFrom(addr, k.Port)
+}
+
+// afInet is the address family of IPv4 connections.
+const afInet = 2
+
+// tracer reads the cumulative counters the kernel keeps.
+type tracer interface {
+	// syscalls returns the system calls made by each process.
+	syscalls() (map[uint32]uint64, error)
+	// connections returns the connections opened by each process to each
+	// remote address.
+	connections() (map[connKey]uint64, error)
+	// forget drops the counters of processes that exited.
+	forget(pids []uint32) error
+	Close() error
+}
+
+// Reader polls the kernel's counters at a fixed interval and emits the
+// activity of every process active over each interval.
+type Reader struct {
+	tracer       tracer
+	interval     time.Duration
+	maxPolls     int
+	maxDuration  time.Duration
+	maxProcesses int
+	maxConns     int
+	proc         string
+
+	lastSys  map[uint32]uint64
+	lastConn map[connKey]uint64
+	comms    map[uint32]string
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// Option configures a Reader.
+type Option func(*Reader)
+
+// WithInterval sets the polling interval, the width of the time buckets.
+// Defaults to ten seconds.
+func WithInterval(d time.Duration) Option {
+	return func(r *Reader) {
+		r.interval = d
+	}
+}
+
+// WithMaxPolls makes the Reader stop after n intervals. Values below 1
+// mean no limit.
+func WithMaxPolls(n int) Option {
+	return func(r *Reader) {
+		r.maxPolls = n
+	}
+}
+
+// WithMaxDuration makes the Reader stop d after it starts. Values below 1
+// mean no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(r *Reader) {
+		r.maxDuration = d
+	}
+}
+
+// WithMaxProcesses bounds the processes whose system calls the kernel
+// counts at once. Processes started once it is reached go uncounted until
+// others exit. Defaults to 16384.
+func WithMaxProcesses(n int) Option {
+	return func(r *Reader) {
+		r.maxProcesses = n
+	}
+}
+
+// WithMaxConnections bounds the distinct process and remote address pairs
+// the kernel counts connections to at once. Pairs beyond it go uncounted
+// until their processes exit. Defaults to 65536.
+func WithMaxConnections(n int) Option {
+	return func(r *Reader) {
+		r.maxConns = n
+	}
+}
+
+func configure(opts []Option) *Reader {
+	r := &Reader{
+		interval:     10 * time.Second,
+		maxProcesses: 16384,
+		maxConns:     65536,
+		proc:         "/proc",
+		lastSys:      make(map[uint32]uint64),
+		lastConn:     make(map[connKey]uint64),
+		comms:        make(map[uint32]string),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.interval = max(r.interval, time.Millisecond)
+	r.maxProcesses = max(r.maxProcesses, 1)
+	r.maxConns = max(r.maxConns, 1)
+	return r
+}
+
+// Read returns the feature vectors of the activity of every process until
+// the poll or duration limit is reached. As tracing never ends on its
+// own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.maxPolls < 1 && r.maxDuration < 1 {
+		return nil, errors.New("ebpf: tracing needs a poll or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	var data [][]float64
+	err := r.run(ctx, func(a Activity) bool {
+		data = append(data, a.Features())
+		return true
+	})
+	return data, err
+}
+
+// Stream returns a channel of the feature vectors of the activity of
+// every process, closed when a limit is reached, on a tracing error or
+// when ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(a Activity, _ uint64) []float64 { return a.Features() }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the end
+// of its interval and its sequence number among the vectors emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(a Activity, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: a.Features(), Time: a.Time, Seq: seq}
+	}), nil
+}
+
+// StreamActivity is Stream emitting the activity itself, which names its
+// process.
+func (r *Reader) StreamActivity(ctx context.Context) (<-chan Activity, error) {
+	return stream(ctx, r, func(a Activity, _ uint64) Activity { return a }), nil
+}
+
+// stream emits wrap(activity, seq) for the activity of every process
+// until a limit is reached, a tracing error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(a Activity, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		var seq uint64
+		err := r.run(ctx, func(a Activity) bool {
+			seq++
+			select {
+			case out <- wrap(a, seq):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if ctx.Err() == nil {
+			r.setErr(err)
+		}
+	}()
+
+	return out
+}
+
+// run polls the counters at every tick until a limit is reached, passing
+// the activity of every process to emit, and returns the tracing error or
+// ctx.Err() that stopped it early.
+func (r *Reader) run(ctx context.Context, emit func(Activity) bool) error {
+	var deadline time.Time
+	if r.maxDuration > 0 {
+		deadline = time.Now().Add(r.maxDuration)
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for polls := 0; r.maxPolls < 1 || polls < r.maxPolls; polls++ {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return nil
+		}
+		activity, err := r.poll(now, now.Sub(last))
+		if err != nil {
+			return err
+		}
+		last = now
+		for _, a := range activity {
+			if !emit(a) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// poll reads the counters and returns the activity of every process since
+// the previous poll, by PID. Processes that exited are forgotten.
+func (r *Reader) poll(now time.Time, interval time.Duration) ([]Activity, error) {
+	sys, err := r.tracer.syscalls()
+	if err != nil {
+		return nil, err
+	}
+	conns, err := r.tracer.connections()
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[uint32]*Activity)
+	get := func(pid uint32) *Activity {
+		a := active[pid]
+		if a == nil {
+			a = &Activity{Time: now, PID: int(pid), Comm: r.comm(pid), Interval: interval}
+			active[pid] = a
+		}
+		return a
+	}
+	for pid, n := range sys {
+		if d := delta(r.lastSys[pid], n); d > 0 {
+			get(pid).Syscalls = d
+		}
+	}
+	keys := make([]connKey, 0, len(conns))
+	for k := range conns {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b connKey) int { return a.remote().Compare(b.remote()) })
+	for _, k := range keys {
+		if d := delta(r.lastConn[k], conns[k]); d > 0 {
+			a := get(k.PID)
+			a.Connects += d
+			a.Remotes = append(a.Remotes, k.remote())
+		}
+	}
+	r.lastSys, r.lastConn = sys, conns
+
+	if err := r.prune(); err != nil {
+		return nil, err
+	}
+
+	activity := make([]Activity, 0, len(active))
+	for _, a := range active {
+		activity = append(activity, *a)
+	}
+	slices.SortFunc(activity, func(a, b Activity) int { return a.PID - b.PID })
+	return activity, nil
+}
+
+// prune forgets the processes that exited, in the kernel too, so that
+// their PIDs start over if reused.
+func (r *Reader) prune() error {
+	gone := make(map[uint32]bool)
+	for pid := range r.lastSys {
+		gone[pid] = !r.alive(pid)
+	}
+	for k := range r.lastConn {
+		gone[k.PID] = !r.alive(k.PID)
+	}
+	var exited []uint32
+	for pid, g := range gone {
+		if g {
+			exited = append(exited, pid)
+			delete(r.lastSys, pid)
+			delete(r.comms, pid)
+		}
+	}
+	if len(exited) == 0 {
+		return nil
+	}
+	if err := r.tracer.forget(exited); err != nil {
+		return err
+	}
+	for k := range r.lastConn {
+		if gone[k.PID] {
+			delete(r.lastConn, k)
+		}
+	}
+	return nil
+}
+
+func (r *Reader) alive(pid uint32) bool {
+	_, err := os.Stat(filepath.Join(r.proc, strconv.FormatUint(uint64(pid), 10)))
+	return err == nil
+}
+
+// comm returns the command name of pid, read once.
+func (r *Reader) comm(pid uint32) string {
+	if c, ok := r.comms[pid]; ok {
+		return c
+	}
+	data, err := os.ReadFile(filepath.Join(r.proc, strconv.FormatUint(uint64(pid), 10), "comm"))
+	if err != nil {
+		return ""
+	}
+	c := strings.TrimSpace(string(data))
+	r.comms[pid] = c
+	return c
+}
+
+// delta returns how much a counter grew, or its value if it started over.
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// Err returns the tracing error that stopped a stream early, if any. It
+// is only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close detaches the programs and releases the kernel's counters.
+func (r *Reader) Close() error {
+	return r.tracer.Close()
+}
@@ -0,0 +1,167 @@
+package ebpf
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTracer replays counter snapshots, one per poll, failing once the
+// script is exhausted.
+type fakeTracer struct {
+	sys       []map[uint32]uint64
+	conns     []map[connKey]uint64
+	forgotten []uint32
+	closed    bool
+}
+
+func (f *fakeTracer) syscalls() (map[uint32]uint64, error) {
+	if len(f.sys) == 0 {
+		return nil, errors.New("maps gone")
+	}
+	s := f.sys[0]
+	f.sys = f.sys[1:]
+	return s, nil
+}
+
+func (f *fakeTracer) connections() (map[connKey]uint64, error) {
+	c := f.conns[0]
+	f.conns = f.conns[1:]
+	return c, nil
+}
+
+func (f *fakeTracer) forget(pids []uint32) error {
+	f.forgotten = append(f.forgotten, pids...)
+	return nil
+}
+
+func (f *fakeTracer) Close() error {
+	f.closed = true
+	return nil
+}
+
+func key(pid uint32, remote string) connKey {
+	ap := netip.MustParseAddrPort(remote)
+	k := connKey{PID: pid, Family: afInet, Port: ap.Port()}
+	if ap.Addr().Is6() {
+		k.Family = 10
+		k.Addr = ap.Addr().As16()
+	} else {
+		a := ap.Addr().As4()
+		copy(k.Addr[:], a[:])
+	}
+	return k
+}
+
+// testReader returns a Reader of f whose processes are 100 (curl) and 200
+// (miner); 300 has exited.
+func testReader(t *testing.T, f *fakeTracer, opts ...Option) *Reader {
+	proc := t.TempDir()
+	for pid, comm := range map[string]string{"100": "curl", "200": "miner"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(proc, pid), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(proc, pid, "comm"), []byte(comm+"\n"), 0o600))
+	}
+	r := configure(append([]Option{WithInterval(time.Millisecond)}, opts...))
+	r.tracer, r.proc = f, proc
+	return r
+}
+
+func script() *fakeTracer {
+	return &fakeTracer{
+		sys: []map[uint32]uint64{
+			{100: 50, 200: 1000, 300: 7},
+			{100: 50, 200: 3000},
+		},
+		conns: []map[connKey]uint64{
+			{key(100, "93.184.216.34:443"): 1, key(200, "198.51.100.1:3333"): 1, key(300, "10.0.0.1:22"): 2},
+			{key(100, "93.184.216.34:443"): 1, key(200, "198.51.100.1:3333"): 3, key(200, "[2001:db8::1]:3333"): 1},
+		},
+	}
+}
+
+func TestStreamActivity(t *testing.T) {
+	f := script()
+	r := testReader(t, f)
+	activity, err := r.StreamActivity(context.Background())
+	require.NoError(t, err)
+
+	var got []Activity
+	for a := range activity {
+		got = append(got, a)
+	}
+	assert.EqualError(t, r.Err(), "maps gone", "the stream ends at the failing poll")
+	require.Len(t, got, 4, "100 was idle in the second interval")
+
+	assert.Equal(t, []int{100, 200, 300, 200}, []int{got[0].PID, got[1].PID, got[2].PID, got[3].PID})
+	assert.Equal(t, "curl", got[0].Comm)
+	assert.Equal(t, []netip.AddrPort{netip.MustParseAddrPort("93.184.216.34:443")}, got[0].Remotes)
+	assert.Equal(t, "", got[2].Comm, "300 exited")
+	assert.Equal(t, uint64(2), got[2].Connects)
+	assert.Equal(t, []uint32{300}, f.forgotten)
+
+	miner := got[3]
+	assert.Equal(t, uint64(2000), miner.Syscalls)
+	assert.Equal(t, uint64(3), miner.Connects, "two more to the pool, one over IPv6")
+	assert.Equal(t, []netip.AddrPort{
+		netip.MustParseAddrPort("198.51.100.1:3333"),
+		netip.MustParseAddrPort("[2001:db8::1]:3333"),
+	}, miner.Remotes)
+}
+
+func TestRead(t *testing.T) {
+	r := testReader(t, script(), WithMaxPolls(2))
+	data, err := r.Read()
+	require.NoError(t, err)
+	require.Len(t, data, 4)
+	for _, v := range data {
+		assert.Len(t, v, len(FeatureNames))
+	}
+
+	_, err = testReader(t, script()).Read()
+	assert.Error(t, err, "tracing never ends without a limit")
+}
+
+func TestStreamSamples(t *testing.T) {
+	r := testReader(t, script(), WithMaxPolls(1))
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+
+	var seqs []uint64
+	for s := range samples {
+		seqs = append(seqs, s.Seq)
+		assert.False(t, s.Time.IsZero())
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, seqs)
+	assert.NoError(t, r.Err())
+}
+
+func TestFeatures(t *testing.T) {
+	a := Activity{
+		Interval: 2 * time.Second, Syscalls: 100, Connects: 4,
+		Remotes: []netip.AddrPort{
+			netip.MustParseAddrPort("10.0.0.1:22"),
+			netip.MustParseAddrPort("10.0.0.1:80"),
+			netip.MustParseAddrPort("10.0.0.2:22"),
+		},
+	}
+	assert.Equal(t, []float64{50, 2, 2, 2}, a.Features())
+}
+
+func TestReadContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := script()
+	r := testReader(t, f, WithInterval(time.Hour))
+	data, err := r.ReadContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, data)
+	assert.NoError(t, r.Close())
+	assert.True(t, f.closed)
+}
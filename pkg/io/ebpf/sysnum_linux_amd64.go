@@ -0,0 +1,4 @@
+package ebpf
+
+// sysBPF is the number of the bpf system call.
+const sysBPF = 321
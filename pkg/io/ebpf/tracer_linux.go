@@ -0,0 +1,390 @@
+//go:build linux && (amd64 || arm64)
+
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// NewReader loads and attaches the tracing programs. Close the Reader to
+// detach them.
+func NewReader(opts ...Option) (*Reader, error) {
+	r := configure(opts)
+	t, err := newBPFTracer(r.maxProcesses, r.maxConns)
+	if err != nil {
+		return nil, err
+	}
+	r.tracer = t
+	return r, nil
+}
+
+// tracefsRoots are where tracefs is usually mounted.
+var tracefsRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// connectTracepoint reports TCP state changes; a change to SYN_SENT is an
+// outbound connection, made in the context of the connecting process.
+const connectTracepoint = "sock/inet_sock_set_state"
+
+// TCP constants of the connect tracepoint.
+const (
+	tcpSynSent  = 2
+	ipprotoTCP  = 6
+	afInet6     = 10
+	connKeySize = int(unsafe.Sizeof(connKey{}))
+)
+
+// bpfTracer counts system calls in a raw tracepoint program on sys_enter
+// and connections in a tracepoint program on inet_sock_set_state, in hash
+// maps read from user space.
+type bpfTracer struct {
+	sysMap  *hashMap
+	connMap *hashMap
+	fds     []int // programs and their attachments
+}
+
+func newBPFTracer(maxProcesses, maxConns int) (_ *bpfTracer, err error) {
+	t := &bpfTracer{}
+	defer func() {
+		if err != nil {
+			t.Close()
+		}
+	}()
+
+	if t.sysMap, err = newHashMap(4, maxProcesses); err != nil {
+		return nil, err
+	}
+	if t.connMap, err = newHashMap(connKeySize, maxConns); err != nil {
+		return nil, err
+	}
+
+	prog, err := loadProgram(progTypeRawTracepoint, "guard_syscalls", syscallProgram(t.sysMap.fd))
+	if err != nil {
+		return nil, err
+	}
+	t.fds = append(t.fds, prog)
+	link, err := attachRawTracepoint("sys_enter", prog)
+	if err != nil {
+		return nil, err
+	}
+	t.fds = append(t.fds, link)
+
+	events, err := tracepointDir(connectTracepoint)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := fieldOffsets(filepath.Join(events, "format"))
+	if err != nil {
+		return nil, err
+	}
+	insns, err := connectProgram(t.connMap.fd, offsets)
+	if err != nil {
+		return nil, err
+	}
+	if prog, err = loadProgram(progTypeTracepoint, "guard_connect", insns); err != nil {
+		return nil, err
+	}
+	t.fds = append(t.fds, prog)
+	perfFDs, err := attachTracepoint(filepath.Join(events, "id"), prog)
+	t.fds = append(t.fds, perfFDs...)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// syscallProgram counts the system calls of each process, by TGID, in the
+// map sysMap.
+func syscallProgram(sysMap int) []insn {
+	a := newAsm()
+	a.call(helperGetCurrentPIDTGID)
+	a.rsh(r0, 32)
+	a.store(sizeW, r10, r0, -4)
+	increment(a, sysMap, -4, -16)
+	return a.assemble()
+}
+
+// connectProgram counts the outbound TCP connections of each process, by
+// TGID and remote address, in the map connMap. offsets are those of the
+// tracepoint's fields.
+func connectProgram(connMap int, offsets map[string]int16) ([]insn, error) {
+	for _, field := range []string{"newstate", "protocol", "family", "dport", "daddr", "daddr_v6"} {
+		if _, ok := offsets[field]; !ok {
+			return nil, fmt.Errorf("ebpf: %s has no %s field", connectTracepoint, field)
+		}
+	}
+
+	a := newAsm()
+	a.movReg(r6, r1)
+	a.load(sizeW, r2, r6, offsets["newstate"])
+	a.jne(r2, tcpSynSent, "out")
+	a.load(sizeH, r2, r6, offsets["protocol"])
+	a.jne(r2, ipprotoTCP, "out")
+
+	// The key, a connKey, is at r10-24.
+	a.storeImm(sizeDW, r10, -24, 0)
+	a.storeImm(sizeDW, r10, -16, 0)
+	a.storeImm(sizeDW, r10, -8, 0)
+	a.call(helperGetCurrentPIDTGID)
+	a.rsh(r0, 32)
+	a.store(sizeW, r10, r0, -24)
+	a.load(sizeH, r2, r6, offsets["family"])
+	a.store(sizeH, r10, r2, -20)
+	a.load(sizeH, r3, r6, offsets["dport"])
+	a.store(sizeH, r10, r3, -18)
+	a.jeq(r2, afInet6, "v6")
+	a.load(sizeW, r3, r6, offsets["daddr"])
+	a.store(sizeW, r10, r3, -16)
+	a.ja("count")
+	a.label("v6")
+	for i := int16(0); i < 16; i += 4 {
+		a.load(sizeW, r3, r6, offsets["daddr_v6"]+i)
+		a.store(sizeW, r10, r3, -16+i)
+	}
+	a.label("count")
+	increment(a, connMap, -24, -32)
+	return a.assemble(), nil
+}
+
+// increment adds one to the value of the key at r10+key in the map, or
+// inserts it with a value of one using the stack slot at r10+value, then
+// exits. Jumps to "out" exit too.
+func increment(a *asm, m int, key, value int16) {
+	a.loadMap(r1, m)
+	a.movReg(r2, r10)
+	a.add(r2, int32(key))
+	a.call(helperMapLookupElem)
+	a.jeq(r0, 0, "insert")
+	a.mov(r1, 1)
+	a.atomicAdd(r0, r1, 0)
+	a.ja("out")
+
+	a.label("insert")
+	a.storeImm(sizeDW, r10, value, 1)
+	a.loadMap(r1, m)
+	a.movReg(r2, r10)
+	a.add(r2, int32(key))
+	a.movReg(r3, r10)
+	a.add(r3, int32(value))
+	a.mov(r4, flagNoExist)
+	a.call(helperMapUpdateElem)
+
+	a.label("out")
+	a.mov(r0, 0)
+	a.exit()
+}
+
+// tracepointDir returns the tracefs directory of the tracepoint
+// category/name.
+func tracepointDir(tracepoint string) (string, error) {
+	for _, root := range tracefsRoots {
+		dir := filepath.Join(root, "events", tracepoint)
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("ebpf: tracepoint %s not found; is tracefs mounted at %s?", tracepoint, tracefsRoots[0])
+}
+
+// formatField matches a field line of a tracepoint format file, such as
+// "field:__u16 dport;	offset:26;	size:2;	signed:0;".
+var formatField = regexp.MustCompile(`field:[^;]*?(\w+)(?:\[\d*\])?;\s*offset:(\d+);`)
+
+// fieldOffsets returns the offset of every field of a tracepoint record,
+// from its format file.
+func fieldOffsets(format string) (map[string]int16, error) {
+	data, err := os.ReadFile(format)
+	if err != nil {
+		return nil, fmt.Errorf("ebpf: %w", err)
+	}
+	offsets := make(map[string]int16)
+	for _, m := range formatField.FindAllStringSubmatch(string(data), -1) {
+		off, err := strconv.ParseInt(m[2], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("ebpf: %s: %w", format, err)
+		}
+		offsets[m[1]] = int16(off)
+	}
+	return offsets, nil
+}
+
+// perfEventAttr is the leading, version 0 part of struct perf_event_attr.
+type perfEventAttr struct {
+	typ          uint32
+	size         uint32
+	config       uint64
+	samplePeriod uint64
+	sampleType   uint64
+	readFormat   uint64
+	flags        uint64
+	wakeupEvents uint32
+	bpType       uint32
+	config1      uint64
+}
+
+// perf_event_open and ioctl constants.
+const (
+	perfTypeTracepoint = 2
+	perfFlagFDCloexec  = 8
+	perfIocEnable      = 0x2400
+	perfIocSetBPF      = 0x40042408
+)
+
+// attachTracepoint attaches prog to the tracepoint whose id file is given,
+// with a perf event on every online CPU, and returns the events' fds.
+func attachTracepoint(idFile string, prog int) ([]int, error) {
+	data, err := os.ReadFile(idFile)
+	if err != nil {
+		return nil, fmt.Errorf("ebpf: %w", err)
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ebpf: %s: %w", idFile, err)
+	}
+	cpus, err := onlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for _, cpu := range cpus {
+		attr := perfEventAttr{typ: perfTypeTracepoint, config: id, samplePeriod: 1, wakeupEvents: 1}
+		attr.size = uint32(unsafe.Sizeof(attr))
+		fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)),
+			^uintptr(0), uintptr(cpu), ^uintptr(0), perfFlagFDCloexec, 0)
+		if errno != 0 {
+			return fds, fmt.Errorf("ebpf: perf_event_open on CPU %d: %w", cpu, errno)
+		}
+		fds = append(fds, int(fd))
+		if err := ioctl(int(fd), perfIocSetBPF, uintptr(prog)); err != nil {
+			return fds, fmt.Errorf("ebpf: attach to tracepoint: %w", err)
+		}
+		if err := ioctl(int(fd), perfIocEnable, 0); err != nil {
+			return fds, fmt.Errorf("ebpf: enable tracepoint: %w", err)
+		}
+	}
+	return fds, nil
+}
+
+func ioctl(fd int, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// onlineCPUs lists the online CPUs, from a list such as "0-3,6".
+func onlineCPUs() ([]int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, fmt.Errorf("ebpf: %w", err)
+	}
+	var cpus []int
+	for _, span := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		lo, hi, found := strings.Cut(span, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("ebpf: online CPUs %q: %w", data, err)
+		}
+		last := first
+		if found {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("ebpf: online CPUs %q: %w", data, err)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+func (t *bpfTracer) syscalls() (map[uint32]uint64, error) {
+	entries, err := t.sysMap.entries()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32]uint64, len(entries))
+	for k, v := range entries {
+		out[binary.NativeEndian.Uint32([]byte(k))] = v
+	}
+	return out, nil
+}
+
+func (t *bpfTracer) connections() (map[connKey]uint64, error) {
+	entries, err := t.connMap.entries()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[connKey]uint64, len(entries))
+	for k, v := range entries {
+		out[decodeConnKey([]byte(k))] = v
+	}
+	return out, nil
+}
+
+func decodeConnKey(b []byte) connKey {
+	k := connKey{
+		PID:    binary.NativeEndian.Uint32(b[0:]),
+		Family: binary.NativeEndian.Uint16(b[4:]),
+		Port:   binary.NativeEndian.Uint16(b[6:]),
+	}
+	copy(k.Addr[:], b[8:])
+	return k
+}
+
+func encodeConnKey(k connKey) []byte {
+	b := make([]byte, connKeySize)
+	binary.NativeEndian.PutUint32(b[0:], k.PID)
+	binary.NativeEndian.PutUint16(b[4:], k.Family)
+	binary.NativeEndian.PutUint16(b[6:], k.Port)
+	copy(b[8:], k.Addr[:])
+	return b
+}
+
+func (t *bpfTracer) forget(pids []uint32) error {
+	exited := make(map[uint32]bool, len(pids))
+	for _, pid := range pids {
+		exited[pid] = true
+		if err := t.sysMap.delete(binary.NativeEndian.AppendUint32(nil, pid)); err != nil {
+			return err
+		}
+	}
+	conns, err := t.connections()
+	if err != nil {
+		return err
+	}
+	for k := range conns {
+		if !exited[k.PID] {
+			continue
+		}
+		if err := t.connMap.delete(encodeConnKey(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close detaches the programs and frees the maps.
+func (t *bpfTracer) Close() error {
+	var errs []error
+	for i := len(t.fds) - 1; i >= 0; i-- {
+		errs = append(errs, syscall.Close(t.fds[i]))
+	}
+	t.fds = nil
+	for _, m := range []*hashMap{t.connMap, t.sysMap} {
+		if m != nil {
+			errs = append(errs, m.Close())
+		}
+	}
+	t.sysMap, t.connMap = nil, nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+//go:build linux && (amd64 || arm64)
+
+package ebpf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldOffsets(t *testing.T) {
+	format := filepath.Join(t.TempDir(), "format")
+	require.NoError(t, os.WriteFile(format, []byte(`name: inet_sock_set_state
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:const void * skaddr;	offset:8;	size:8;	signed:0;
+	field:int newstate;	offset:20;	size:4;	signed:1;
+	field:__u16 dport;	offset:26;	size:2;	signed:0;
+	field:__u8 daddr_v6[16];	offset:56;	size:16;	signed:0;
+`), 0o600))
+	offsets, err := fieldOffsets(format)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int16{"common_type": 0, "skaddr": 8, "newstate": 20, "dport": 26, "daddr_v6": 56}, offsets)
+
+	_, err = connectProgram(0, offsets)
+	assert.ErrorContains(t, err, "protocol", "programs are not built from incomplete formats")
+}
+
+func TestConnKeyEncoding(t *testing.T) {
+	k := connKey{PID: 42, Family: afInet6, Port: 443, Addr: netip.MustParseAddr("2001:db8::1").As16()}
+	assert.Equal(t, k, decodeConnKey(encodeConnKey(k)))
+}
+
+// TestTrace attaches the programs to the running kernel, which needs
+// privileges the test usually runs without.
+func TestTrace(t *testing.T) {
+	r, err := NewReader(WithInterval(200*time.Millisecond), WithMaxPolls(3))
+	if err != nil {
+		t.Skipf("cannot trace: %v", err)
+	}
+	defer r.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	activity, err := r.StreamActivity(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		c.Close()
+	}
+
+	var own []Activity
+	for a := range activity {
+		if a.PID == os.Getpid() {
+			own = append(own, a)
+		}
+	}
+	require.NoError(t, r.Err())
+	require.NotEmpty(t, own, "the test process is traced")
+
+	var syscalls, connects uint64
+	var remotes []netip.AddrPort
+	for _, a := range own {
+		syscalls += a.Syscalls
+		connects += a.Connects
+		remotes = append(remotes, a.Remotes...)
+	}
+	assert.Positive(t, syscalls)
+	assert.Equal(t, uint64(3), connects)
+	assert.True(t, slices.Contains(remotes, netip.MustParseAddrPort(ln.Addr().String())), "remotes %v", remotes)
+	assert.NotEmpty(t, own[0].Comm)
+}
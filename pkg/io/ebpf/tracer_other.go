@@ -0,0 +1,14 @@
+//go:build !linux || !(amd64 || arm64)
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NewReader would attach the tracing programs; eBPF tracing is only
+// supported on Linux on amd64 and arm64.
+func NewReader(opts ...Option) (*Reader, error) {
+	return nil, fmt.Errorf("ebpf: tracing needs Linux on amd64 or arm64: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,273 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// ExtractorFactory creates a FeatureExtractor. Extractors may keep state
+// across records, such as inter-arrival times or per-client rates, so a
+// registry hands out a new one to each user.
+type ExtractorFactory func() (FeatureExtractor, error)
+
+// ExtractorRegistry maps names to extractor factories, so readers and
+// pipelines can pick how records become features by name. It is safe for
+// concurrent use.
+type ExtractorRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]ExtractorFactory
+}
+
+// NewExtractorRegistry creates an empty registry.
+func NewExtractorRegistry() *ExtractorRegistry {
+	return &ExtractorRegistry{factories: make(map[string]ExtractorFactory)}
+}
+
+// DefaultExtractors is the registry RegisterExtractor and NewExtractor
+// use. It holds "json" (JSON objects with inferred fields, see
+// MapExtractor), and the packages of this module register theirs when
+// imported: "packet" (pcap), "accesslog" and "kube".
+var DefaultExtractors = NewExtractorRegistry()
+
+func init() {
+	DefaultExtractors.MustRegister("json", func() (FeatureExtractor, error) {
+		return NewMapExtractor(nil), nil
+	})
+}
+
+// Register adds factory under name. Registering a name twice is an error.
+func (r *ExtractorRegistry) Register(name string, factory ExtractorFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("extractor registry: empty name or nil factory")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("extractor registry: %q already registered", name)
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// MustRegister is Register panicking on error, for init functions.
+func (r *ExtractorRegistry) MustRegister(name string, factory ExtractorFactory) {
+	if err := r.Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Names returns the registered names, sorted.
+func (r *ExtractorRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the extractor registered under name or, given several
+// names, Compose of theirs in order.
+func (r *ExtractorRegistry) New(names ...string) (FeatureExtractor, error) {
+	if len(names) == 0 {
+		return nil, errors.New("extractor registry: no extractor named")
+	}
+	xs := make([]FeatureExtractor, len(names))
+	for i, name := range names {
+		r.mu.RLock()
+		factory, ok := r.factories[name]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("extractor registry: unknown extractor %q (have %v)", name, r.Names())
+		}
+		x, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("extractor registry: %s: %w", name, err)
+		}
+		xs[i] = x
+	}
+	if len(xs) == 1 {
+		return xs[0], nil
+	}
+	return Compose(xs...)
+}
+
+// RegisterExtractor adds factory to DefaultExtractors.
+func RegisterExtractor(name string, factory ExtractorFactory) error {
+	return DefaultExtractors.Register(name, factory)
+}
+
+// NewExtractor creates an extractor from DefaultExtractors.
+func NewExtractor(names ...string) (FeatureExtractor, error) {
+	return DefaultExtractors.New(names...)
+}
+
+// RegisterStruct registers a StructExtractor for T under name in r.
+func RegisterStruct[T any](r *ExtractorRegistry, name string) error {
+	if _, err := NewStructExtractor[T](); err != nil {
+		return err
+	}
+	return r.Register(name, func() (FeatureExtractor, error) {
+		return NewStructExtractor[T]()
+	})
+}
+
+// funcExtractor adapts a typed extraction function to FeatureExtractor.
+type funcExtractor[T any] struct {
+	names   []string
+	extract func(T) ([]float64, error)
+}
+
+// NewFuncExtractor returns a FeatureExtractor calling extract on inputs
+// of type T, and failing on others, with features named by names. It
+// adapts extractors of concrete record types, such as packets or log
+// entries, to the registry.
+func NewFuncExtractor[T any](names []string, extract func(T) ([]float64, error)) FeatureExtractor {
+	return &funcExtractor[T]{names: slices.Clone(names), extract: extract}
+}
+
+func (x *funcExtractor[T]) Extract(data any) ([]float64, error) {
+	v, ok := data.(T)
+	if !ok {
+		return nil, fmt.Errorf("cannot extract %T, want %s", data, reflect.TypeFor[T]())
+	}
+	features, err := x.extract(v)
+	if err == nil && len(features) != len(x.names) {
+		err = fmt.Errorf("extracted %d features for %d names", len(features), len(x.names))
+	}
+	return features, err
+}
+
+func (x *funcExtractor[T]) FeatureNames() []string {
+	return slices.Clone(x.names)
+}
+
+// composite concatenates the features of several extractors.
+type composite struct {
+	xs []FeatureExtractor
+}
+
+// Compose returns an extractor passing each record to every one of xs
+// and concatenating their features, and their names, in order. Feature
+// names known up front must be distinct.
+func Compose(xs ...FeatureExtractor) (FeatureExtractor, error) {
+	seen := make(map[string]bool)
+	for _, x := range xs {
+		for _, name := range x.FeatureNames() {
+			if seen[name] {
+				return nil, fmt.Errorf("compose: feature %q extracted twice", name)
+			}
+			seen[name] = true
+		}
+	}
+	return &composite{xs: slices.Clone(xs)}, nil
+}
+
+func (c *composite) Extract(data any) ([]float64, error) {
+	var features []float64
+	for _, x := range c.xs {
+		f, err := x.Extract(data)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, f...)
+	}
+	return features, nil
+}
+
+func (c *composite) FeatureNames() []string {
+	var names []string
+	for _, x := range c.xs {
+		names = append(names, x.FeatureNames()...)
+	}
+	return names
+}
+
+// MapExtractor extracts features from structured records, such as JSON
+// log lines, with a FieldMapper: one feature per dotted field path.
+type MapExtractor struct {
+	mapper *FieldMapper
+}
+
+// NewMapExtractor creates an extractor reading features from fields, or
+// from the numeric fields of the first record if fields is nil.
+func NewMapExtractor(fields []string) *MapExtractor {
+	return &MapExtractor{mapper: NewFieldMapper(fields, "")}
+}
+
+// Extract returns the features of a map[string]any record, or of a JSON
+// object given as []byte, string or json.RawMessage.
+func (x *MapExtractor) Extract(data any) ([]float64, error) {
+	var record map[string]any
+	switch v := data.(type) {
+	case map[string]any:
+		record = v
+	case []byte:
+		if err := json.Unmarshal(v, &record); err != nil {
+			return nil, err
+		}
+	case json.RawMessage:
+		if err := json.Unmarshal(v, &record); err != nil {
+			return nil, err
+		}
+	case string:
+		if err := json.Unmarshal([]byte(v), &record); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot extract %T, want a JSON object", data)
+	}
+	features, _, err := x.mapper.Map(Flatten(record))
+	return features, err
+}
+
+// FeatureNames returns the field paths features are read from, nil until
+// the first record if they are inferred.
+func (x *MapExtractor) FeatureNames() []string {
+	return x.mapper.Fields()
+}
+
+// NameResults sets the FeatureNames of each result that has features to
+// names, so results carry what their features mean to consumers that
+// never saw the extractor.
+func NameResults(results []Result, names []string) {
+	for i := range results {
+		if len(results[i].Features) > 0 {
+			results[i].FeatureNames = names
+		}
+	}
+}
+
+// namingWriter is a Writer naming features before passing results on.
+type namingWriter struct {
+	Writer
+	names func() []string
+}
+
+// WithFeatureNames returns a Writer setting the FeatureNames of results
+// with features to names() before writing them to w. names is called per
+// write, so extractors whose names are inferred from the first record can
+// pass their FeatureNames method.
+func WithFeatureNames(w Writer, names func() []string) Writer {
+	return &namingWriter{Writer: w, names: names}
+}
+
+func (w *namingWriter) Write(result Result) error {
+	if len(result.Features) > 0 {
+		result.FeatureNames = w.names()
+	}
+	return w.Writer.Write(result)
+}
+
+func (w *namingWriter) WriteAll(results []Result) error {
+	named := slices.Clone(results)
+	NameResults(named, w.names())
+	return w.Writer.WriteAll(named)
+}
@@ -0,0 +1,146 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+type testRecord struct {
+	Bytes float64 `guardml:"feature,name=bytes"`
+}
+
+func TestExtractorRegistry(t *testing.T) {
+	r := NewExtractorRegistry()
+	require.NoError(t, RegisterStruct[testRecord](r, "record"))
+	require.NoError(t, r.Register("double", func() (FeatureExtractor, error) {
+		return NewFuncExtractor([]string{"double"}, func(v testRecord) ([]float64, error) {
+			return []float64{2 * v.Bytes}, nil
+		}), nil
+	}))
+	assert.ErrorContains(t, r.Register("record", nil), "nil factory")
+	assert.ErrorContains(t, RegisterStruct[testRecord](r, "record"), "already registered")
+	assert.Error(t, RegisterStruct[struct{ A int }](r, "untagged"))
+	assert.Equal(t, []string{"double", "record"}, r.Names())
+
+	x, err := r.New("record", "double")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bytes", "double"}, x.FeatureNames())
+	features, err := x.Extract(testRecord{Bytes: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, 6}, features)
+	_, err = x.Extract("three")
+	assert.ErrorContains(t, err, "cannot extract string")
+
+	_, err = r.New("record", "record")
+	assert.ErrorContains(t, err, `feature "bytes" extracted twice`)
+	_, err = r.New("missing")
+	assert.ErrorContains(t, err, `unknown extractor "missing"`)
+	_, err = r.New()
+	assert.Error(t, err)
+
+	assert.Contains(t, DefaultExtractors.Names(), "json")
+}
+
+func TestFuncExtractorWidth(t *testing.T) {
+	x := NewFuncExtractor([]string{"a", "b"}, func(v float64) ([]float64, error) {
+		return []float64{v}, nil
+	})
+	_, err := x.Extract(1.0)
+	assert.ErrorContains(t, err, "1 features for 2 names")
+}
+
+func TestMapExtractor(t *testing.T) {
+	x, err := NewExtractor("json")
+	require.NoError(t, err)
+	assert.Nil(t, x.FeatureNames(), "inferred from the first record")
+
+	features, err := x.Extract([]byte(`{"net":{"bytes":10},"port":443,"host":"a"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []float64{10, 443}, features)
+	assert.Equal(t, []string{"net.bytes", "port"}, x.FeatureNames())
+
+	features, err = x.Extract(map[string]any{"net": map[string]any{"bytes": 5}, "port": "22"})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{5, 22}, features)
+	_, err = x.Extract(`{"port":1}`)
+	assert.ErrorContains(t, err, `lacks "net.bytes"`)
+	_, err = x.Extract(`not json`)
+	assert.Error(t, err)
+	_, err = x.Extract(42)
+	assert.ErrorContains(t, err, "want a JSON object")
+}
+
+// bufferWriter is a Writer encoding results as JSON lines.
+type bufferWriter struct {
+	bytes.Buffer
+}
+
+func (w *bufferWriter) Write(r Result) error { return json.NewEncoder(&w.Buffer).Encode(r) }
+
+func (w *bufferWriter) WriteAll(rs []Result) error {
+	for _, r := range rs {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *bufferWriter) Close() error { return nil }
+
+func TestWithFeatureNames(t *testing.T) {
+	var buf bufferWriter
+	w := WithFeatureNames(&buf, func() []string { return []string{"bytes"} })
+	results := []Result{{Score: 0.9, Features: []float64{10}}, {Score: 0.1}}
+	require.NoError(t, w.WriteAll(results))
+	require.NoError(t, w.Write(Result{Features: []float64{3}}))
+	assert.Nil(t, results[0].FeatureNames, "the caller's results are not modified")
+
+	var got []Result
+	dec := json.NewDecoder(&buf.Buffer)
+	for dec.More() {
+		var r Result
+		require.NoError(t, dec.Decode(&r))
+		got = append(got, r)
+	}
+	require.Len(t, got, 3)
+	assert.Equal(t, []string{"bytes"}, got[0].FeatureNames)
+	assert.Nil(t, got[1].FeatureNames, "results without features are not named")
+	assert.Equal(t, []string{"bytes"}, got[2].FeatureNames)
+}
+
+func TestMultiWriter(t *testing.T) {
+	var a, b bufferWriter
+	w := MultiWriter(&a, &b)
+	require.NoError(t, w.Write(Result{Score: 0.5}))
+	require.NoError(t, w.WriteAll([]Result{{Score: 0.7}}))
+	require.NoError(t, w.Close())
+	assert.Equal(t, a.String(), b.String())
+	assert.Equal(t, 2, bytes.Count(a.Bytes(), []byte("\n")))
+}
+
+func TestSeverityWriters(t *testing.T) {
+	var buf bufferWriter
+	bands := detectors.SeverityBands{Low: 0.5, Medium: 0.6, High: 0.7, Critical: 0.8}
+	w := WithSeverity(MinSeverity(&buf, detectors.SeverityHigh), bands)
+	results := []Result{{Score: 0.2}, {Score: 0.75}, {Score: 0.3, Severity: detectors.SeverityCritical}}
+	require.NoError(t, w.WriteAll(results))
+	require.NoError(t, w.Write(Result{Score: 0.9}))
+	require.NoError(t, w.Write(Result{Score: 0.65}))
+	assert.Equal(t, detectors.SeverityNone, results[1].Severity, "the caller's results are not modified")
+
+	var got []detectors.Severity
+	dec := json.NewDecoder(&buf.Buffer)
+	for dec.More() {
+		var r Result
+		require.NoError(t, dec.Decode(&r))
+		got = append(got, r.Severity)
+	}
+	assert.Equal(t, []detectors.Severity{detectors.SeverityHigh, detectors.SeverityCritical, detectors.SeverityCritical}, got)
+}
@@ -0,0 +1,152 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Flatten returns the scalar leaves of a decoded structured record by
+// dot-separated path, array elements by index:
+// {"motor":{"rpm":1200},"phases":[1,2]} yields motor.rpm, phases.0 and
+// phases.1. Maps must be keyed by strings.
+func Flatten(record map[string]any) map[string]any {
+	leaves := make(map[string]any)
+	flatten(leaves, "", record)
+	return leaves
+}
+
+func flatten(leaves map[string]any, path string, v any) {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			flatten(leaves, join(k), e)
+		}
+	case []any:
+		for i, e := range v {
+			flatten(leaves, join(strconv.Itoa(i)), e)
+		}
+	default:
+		leaves[path] = v
+	}
+}
+
+// FieldValue returns the value of a record leaf as a feature: numbers of
+// any Go numeric type or json.Number, numeric strings, and booleans as 0
+// or 1.
+func FieldValue(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// FieldTime returns the value of a record leaf as a time: a time.Time, an
+// RFC 3339 string, or seconds since the epoch.
+func FieldTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	secs, ok := FieldValue(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(secs*1e9)), true
+}
+
+// FieldMapper maps flattened records to feature vectors, one feature per
+// field path. When no fields are given they are inferred from the first
+// record mapped: its numeric and boolean leaves, in lexical order. A
+// FieldMapper is safe for concurrent use.
+type FieldMapper struct {
+	mu        sync.Mutex
+	fields    []string
+	timeField string
+}
+
+// NewFieldMapper creates a mapper reading features from fields, in order,
+// and record times from timeField if it is not empty.
+func NewFieldMapper(fields []string, timeField string) *FieldMapper {
+	return &FieldMapper{fields: slices.Clone(fields), timeField: timeField}
+}
+
+// Fields returns the field paths features are read from, nil until the
+// first record is mapped if they are inferred.
+func (m *FieldMapper) Fields() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.fields)
+}
+
+// Map returns the features of leaves, and the time in its time field, or
+// the zero time if it has none. Records missing a field, or whose field
+// is not numeric, are an error.
+func (m *FieldMapper) Map(leaves map[string]any) ([]float64, time.Time, error) {
+	var t time.Time
+	if m.timeField != "" {
+		t, _ = FieldTime(leaves[m.timeField])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fields := m.fields
+	if fields == nil {
+		for path, v := range leaves {
+			if _, ok := FieldValue(v); ok && path != m.timeField {
+				fields = append(fields, path)
+			}
+		}
+		if len(fields) == 0 {
+			return nil, t, errors.New("record has no numeric fields")
+		}
+		slices.Sort(fields)
+	}
+
+	features := make([]float64, len(fields))
+	for i, path := range fields {
+		v, ok := leaves[path]
+		if !ok {
+			return nil, t, fmt.Errorf("record lacks %q", path)
+		}
+		if features[i], ok = FieldValue(v); !ok {
+			return nil, t, fmt.Errorf("record field %q is not numeric", path)
+		}
+	}
+	m.fields = fields
+	return features, t, nil
+}
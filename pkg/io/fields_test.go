@@ -0,0 +1,45 @@
+package io
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatten(t *testing.T) {
+	leaves := Flatten(map[string]any{
+		"motor":  map[string]any{"rpm": int64(1200)},
+		"phases": []any{1.5, "2"},
+		"id":     "m1",
+	})
+	assert.Equal(t, map[string]any{"motor.rpm": int64(1200), "phases.0": 1.5, "phases.1": "2", "id": "m1"}, leaves)
+}
+
+func TestFieldMapper(t *testing.T) {
+	m := NewFieldMapper(nil, "ts")
+	f, ts, err := m.Map(map[string]any{"ts": json.Number("1700000000"), "temp": "21.5", "on": false, "id": "x"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"on", "temp"}, m.Fields(), "inferred from the first record")
+	assert.Equal(t, []float64{0, 21.5}, f)
+	assert.Equal(t, time.Unix(1700000000, 0), ts)
+
+	_, _, err = m.Map(map[string]any{"temp": 22})
+	assert.EqualError(t, err, `record lacks "on"`)
+	_, _, err = m.Map(map[string]any{"temp": "hot", "on": true})
+	assert.EqualError(t, err, `record field "temp" is not numeric`)
+
+	f, ts, err = m.Map(map[string]any{"ts": "2024-05-01T10:00:00Z", "temp": uint64(22), "on": true, "extra": 5})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 22}, f)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), ts)
+
+	_, ts, err = NewFieldMapper([]string{"temp"}, "ts").Map(map[string]any{"temp": float32(1)})
+	require.NoError(t, err)
+	assert.True(t, ts.IsZero())
+
+	_, _, err = NewFieldMapper(nil, "").Map(map[string]any{"id": "x"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package fluent
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// event is a record as forwarded, before it is mapped to features.
+type event struct {
+	tag    string
+	time   time.Time
+	record map[string]any
+}
+
+// message is a decoded forward protocol message: its events, and the
+// chunk identifier to acknowledge, if the forwarder asked for one.
+type message struct {
+	events []event
+	chunk  string
+}
+
+// parseMessage interprets a decoded value as a message in any of the
+// forward protocol's modes:
+//
+//	Message:                 [tag, time, record, option?]
+//	Forward:                 [tag, [[time, record], ...], option?]
+//	PackedForward:           [tag, entries, option?]
+//	CompressedPackedForward: [tag, gzip(entries), {"compressed": "gzip"}]
+//
+// where the entries of the packed modes are [time, record] arrays
+// concatenated into a binary or string, decoded within budget bytes.
+func parseMessage(v any, budget int) (message, error) {
+	a, ok := v.([]any)
+	if !ok || len(a) < 2 || len(a) > 4 {
+		return message{}, errors.New("fluent: message is not a forward protocol array")
+	}
+	tag, ok := a[0].(string)
+	if !ok {
+		return message{}, errors.New("fluent: message without a tag")
+	}
+
+	var m message
+	option := func(i int) (map[string]any, error) {
+		if i >= len(a) || a[i] == nil {
+			return nil, nil
+		}
+		opt, ok := a[i].(map[string]any)
+		if !ok {
+			return nil, errors.New("fluent: option is not a map")
+		}
+		if chunk, ok := opt["chunk"].(string); ok {
+			m.chunk = chunk
+		}
+		return opt, nil
+	}
+
+	switch entries := a[1].(type) {
+	case []any:
+		if _, err := option(2); err != nil {
+			return m, err
+		}
+		for _, e := range entries {
+			ev, err := parseEntry(tag, e)
+			if err != nil {
+				return m, err
+			}
+			m.events = append(m.events, ev)
+		}
+	case []byte, string:
+		opt, err := option(2)
+		if err != nil {
+			return m, err
+		}
+		packed := toBytes(entries)
+		var r interface {
+			io.Reader
+			io.ByteReader
+		} = bytes.NewReader(packed)
+		switch compressed := opt["compressed"]; compressed {
+		case nil, "text":
+		case "gzip":
+			zr, err := gzip.NewReader(bytes.NewReader(packed))
+			if err != nil {
+				return m, fmt.Errorf("fluent: entries: %w", err)
+			}
+			r = bufio.NewReader(zr)
+		default:
+			return m, fmt.Errorf("fluent: unsupported compression %v", compressed)
+		}
+		d := newDecoder(r, budget)
+		for {
+			e, err := d.decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return m, fmt.Errorf("fluent: entries: %w", err)
+			}
+			ev, err := parseEntry(tag, e)
+			if err != nil {
+				return m, err
+			}
+			m.events = append(m.events, ev)
+		}
+	default:
+		if len(a) < 3 {
+			return m, errors.New("fluent: message without a record")
+		}
+		if _, err := option(3); err != nil {
+			return m, err
+		}
+		ev, err := parseEntry(tag, []any{a[1], a[2]})
+		if err != nil {
+			return m, err
+		}
+		m.events = []event{ev}
+	}
+	return m, nil
+}
+
+func toBytes(v any) []byte {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	return v.([]byte)
+}
+
+// parseEntry interprets a [time, record] entry.
+func parseEntry(tag string, v any) (event, error) {
+	e, ok := v.([]any)
+	if !ok || len(e) != 2 {
+		return event{}, errors.New("fluent: entry is not a [time, record] array")
+	}
+	ev := event{tag: tag}
+	switch t := e[0].(type) {
+	case time.Time:
+		ev.time = t
+	case int64:
+		ev.time = time.Unix(t, 0)
+	case uint64:
+		ev.time = time.Unix(int64(t), 0)
+	case float64:
+		ev.time = time.Unix(0, int64(t*1e9))
+	default:
+		return event{}, fmt.Errorf("fluent: entry time of type %T", t)
+	}
+	if ev.record, ok = e[1].(map[string]any); !ok {
+		return event{}, errors.New("fluent: entry record is not a map")
+	}
+	return ev, nil
+}
+
+// ack is the response acknowledging chunk.
+func ack(chunk string) []byte {
+	return appendValue(nil, map[string]any{"ack": chunk})
+}
+
+// MatchTag reports whether tag matches a Fluentd match pattern: tags are
+// dot-separated parts, * matches within one part (app.*, *_log), and **
+// matches zero or more parts (app.**).
+func MatchTag(pattern, tag string) bool {
+	return matchParts(strings.Split(pattern, "."), strings.Split(tag, "."))
+}
+
+func matchParts(pattern, tag []string) bool {
+	if len(pattern) == 0 {
+		return len(tag) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(tag); i++ {
+			if matchParts(pattern[1:], tag[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(tag) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], tag[0])
+	return ok && err == nil && matchParts(pattern[1:], tag[1:])
+}
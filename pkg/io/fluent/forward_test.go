@@ -0,0 +1,109 @@
+package fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var at = time.Unix(1700000000, 500)
+
+func entries(records ...map[string]any) []byte {
+	var b []byte
+	for _, r := range records {
+		b = appendValue(b, []any{at, r})
+	}
+	return b
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestParseMessage(t *testing.T) {
+	rec := map[string]any{"status": int64(200)}
+	packed := entries(rec, rec)
+	tests := map[string]struct {
+		msg  []any
+		n    int
+		want string
+	}{
+		"message":         {[]any{"app", at, rec}, 1, ""},
+		"message option":  {[]any{"app", int64(1700000000), rec, map[string]any{"chunk": "c1"}}, 1, "c1"},
+		"forward":         {[]any{"app", []any{[]any{at, rec}, []any{1.5, rec}}}, 2, ""},
+		"packed forward":  {[]any{"app", packed, map[string]any{"chunk": "c2", "size": int64(2)}}, 2, "c2"},
+		"packed as str":   {[]any{"app", string(packed)}, 2, ""},
+		"compressed":      {[]any{"app", gzipped(t, packed), map[string]any{"compressed": "gzip"}}, 2, ""},
+		"empty forwarded": {[]any{"app", []any{}}, 0, ""},
+	}
+	for name, tt := range tests {
+		m, err := parseMessage(tt.msg, 1<<10)
+		require.NoError(t, err, name)
+		assert.Len(t, m.events, tt.n, name)
+		assert.Equal(t, tt.want, m.chunk, name)
+		for _, ev := range m.events {
+			assert.Equal(t, "app", ev.tag, name)
+			assert.Equal(t, rec, ev.record, name)
+		}
+	}
+
+	m, err := parseMessage([]any{"app", at, rec}, 1<<10)
+	require.NoError(t, err)
+	assert.Equal(t, at, m.events[0].time)
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	rec := map[string]any{"x": int64(1)}
+	for name, msg := range map[string]any{
+		"not an array":  map[string]any{},
+		"no tag":        []any{int64(1), at, rec},
+		"no record":     []any{"app", at},
+		"bad option":    []any{"app", at, rec, "opt"},
+		"bad entry":     []any{"app", []any{[]any{at}}},
+		"bad time":      []any{"app", "yesterday", rec},
+		"bad record":    []any{"app", at, []any{}},
+		"bad packed":    []any{"app", []byte{0xc1}},
+		"compression":   []any{"app", entries(rec), map[string]any{"compressed": "zstd"}},
+		"not gzip":      []any{"app", entries(rec), map[string]any{"compressed": "gzip"}},
+		"gzip too long": []any{"app", gzipped(t, entries(rec, rec, rec)), map[string]any{"compressed": "gzip"}},
+	} {
+		_, err := parseMessage(msg, 30)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAck(t *testing.T) {
+	v, err := decodeAll(t, ack("chunk-id"), 100)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"ack": "chunk-id"}, v)
+}
+
+func TestMatchTag(t *testing.T) {
+	tests := []struct {
+		pattern, tag string
+		want         bool
+	}{
+		{"app", "app", true},
+		{"app.*", "app.web", true},
+		{"app.*", "app", false},
+		{"app.*", "app.web.access", false},
+		{"app.**", "app", true},
+		{"app.**", "app.web.access", true},
+		{"**.access", "nginx.access", true},
+		{"*_log", "auth_log", true},
+		{"*_log", "auth", false},
+		{"**", "anything.at.all", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MatchTag(tt.pattern, tt.tag), "%s ~ %s", tt.pattern, tt.tag)
+	}
+}
@@ -0,0 +1,306 @@
+package fluent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// maxDepth bounds the nesting of decoded values.
+const maxDepth = 64
+
+// errTooLarge reports a value exceeding the decoding budget.
+var errTooLarge = errors.New("fluent: message exceeds the size limit")
+
+// extension is a MessagePack extension value other than an EventTime.
+type extension struct {
+	Type int8
+	Data []byte
+}
+
+// decoder decodes MessagePack values into nil, bool, int64, uint64 (above
+// the int64 range), float64, string, []byte, []any, map[string]any,
+// time.Time (EventTime extensions) and extension.
+//
+// budget bounds the bytes a value may take: the length prefixes of strings,
+// arrays and maps are checked against it before anything is allocated, so
+// a hostile peer cannot make the decoder allocate more than it sends.
+type decoder struct {
+	r      io.ByteReader
+	full   io.Reader
+	budget int
+}
+
+func newDecoder(r interface {
+	io.Reader
+	io.ByteReader
+}, budget int) *decoder {
+	return &decoder{r: r, full: r, budget: budget}
+}
+
+func (d *decoder) spend(n int) error {
+	if n < 0 || n > d.budget {
+		return errTooLarge
+	}
+	d.budget -= n
+	return nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	return c, d.spend(1)
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if err := d.spend(n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.full, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.bytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode reads the next value. It returns io.EOF only if the input ends
+// before the value starts.
+func (d *decoder) decode() (any, error) {
+	return d.value(0)
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, errors.New("fluent: message nested too deeply")
+	}
+	c, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.body(c, depth)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// body reads the rest of the value whose first byte is c.
+func (d *decoder) body(c byte, depth int) (any, error) {
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(int(min(n, math.MaxInt32)))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(int(min(n, math.MaxInt32)))
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if n > math.MaxInt64 {
+			return n, err
+		}
+		return int64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(min(n, math.MaxInt32)))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(min(n, math.MaxInt32)), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(min(n, math.MaxInt32)), depth)
+	}
+	return nil, fmt.Errorf("fluent: invalid MessagePack type 0x%02x", c)
+}
+
+func (d *decoder) str(n int) (string, error) {
+	b, err := d.bytes(n)
+	return string(b), err
+}
+
+// ext reads an extension of n data bytes. Type 0 is Fluentd's EventTime:
+// seconds and nanoseconds as big-endian 32-bit integers.
+func (d *decoder) ext(n int) (any, error) {
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	if typ == 0 && n == 8 {
+		sec, nsec := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+	return extension{Type: int8(typ), Data: data}, nil
+}
+
+func (d *decoder) arrayOf(n, depth int) ([]any, error) {
+	// Every element takes at least a byte.
+	if n > d.budget {
+		return nil, errTooLarge
+	}
+	a := make([]any, n)
+	for i := range a {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+// mapOf reads a map of n entries. Keys must be strings or binaries.
+func (d *decoder) mapOf(n, depth int) (map[string]any, error) {
+	if 2*n > d.budget {
+		return nil, errTooLarge
+	}
+	m := make(map[string]any, n)
+	for range n {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case string:
+			m[k] = v
+		case []byte:
+			m[string(k)] = v
+		default:
+			return nil, fmt.Errorf("fluent: map key of type %T", k)
+		}
+	}
+	return m, nil
+}
+
+// appendValue appends the MessagePack encoding of v, which must be of one
+// of the types decoder produces, or an int.
+func appendValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case int:
+		return appendValue(b, int64(v))
+	case int64:
+		if v >= 0 {
+			return appendValue(b, uint64(v))
+		}
+		if v >= -32 {
+			return append(b, byte(v))
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+	case uint64:
+		if v <= 0x7f {
+			return append(b, byte(v))
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+	case string:
+		if len(v) < 32 {
+			b = append(b, 0xa0|byte(len(v)))
+		} else {
+			b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(len(v)))
+		}
+		return append(b, v...)
+	case []byte:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(len(v)))
+		return append(b, v...)
+	case time.Time:
+		b = append(b, 0xd7, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(v.Unix()))
+		return binary.BigEndian.AppendUint32(b, uint32(v.Nanosecond()))
+	case []any:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(len(v)))
+		for _, e := range v {
+			b = appendValue(b, e)
+		}
+		return b
+	case map[string]any:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(len(v)))
+		for k, e := range v {
+			b = appendValue(appendValue(b, k), e)
+		}
+		return b
+	case extension:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc9), uint32(len(v.Data)))
+		return append(append(b, byte(v.Type)), v.Data...)
+	}
+	panic(fmt.Sprintf("fluent: cannot encode %T", v))
+}
@@ -0,0 +1,82 @@
+package fluent
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAll(t *testing.T, data []byte, budget int) (any, error) {
+	t.Helper()
+	return newDecoder(bytes.NewReader(data), budget).decode()
+}
+
+func TestRoundTrip(t *testing.T) {
+	v := map[string]any{
+		"small":  int64(5),
+		"neg":    int64(-3),
+		"big":    int64(-1 << 40),
+		"huge":   uint64(1 << 63),
+		"ratio":  0.25,
+		"on":     true,
+		"off":    false,
+		"none":   nil,
+		"msg":    "a string longer than thirty-one bytes",
+		"raw":    []byte{1, 2, 3},
+		"at":     time.Unix(1700000000, 123456789),
+		"nested": []any{int64(1), map[string]any{"x": "y"}},
+		"ext":    extension{Type: 5, Data: []byte("abc")},
+	}
+	got, err := decodeAll(t, appendValue(nil, v), 1<<10)
+	require.NoError(t, err)
+	assert.Equal(t, v, got)
+}
+
+func TestDecodeCompactForms(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want any
+	}{
+		{[]byte{0xcc, 0xff}, int64(255)},
+		{[]byte{0xcd, 0x01, 0x00}, int64(256)},
+		{[]byte{0xd0, 0x80}, int64(-128)},
+		{[]byte{0xd1, 0xff, 0x00}, int64(-256)},
+		{[]byte{0xd2, 0xff, 0xff, 0xff, 0xfe}, int64(-2)},
+		{[]byte{0xca, 0x3f, 0x80, 0x00, 0x00}, 1.0},
+		{[]byte{0xd9, 0x02, 'h', 'i'}, "hi"},
+		{[]byte{0xc4, 0x01, 0x07}, []byte{7}},
+		{[]byte{0x92, 0x01, 0xa1, 'a'}, []any{int64(1), "a"}},
+		{[]byte{0x81, 0xc4, 0x01, 'k', 0x02}, map[string]any{"k": int64(2)}},
+		{[]byte{0xd7, 0x00, 0, 0, 0, 10, 0, 0, 0, 5}, time.Unix(10, 5)},
+	}
+	for _, tt := range tests {
+		got, err := decodeAll(t, tt.data, 100)
+		require.NoError(t, err, "% x", tt.data)
+		assert.Equal(t, tt.want, got, "% x", tt.data)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := decodeAll(t, nil, 100)
+	assert.Equal(t, io.EOF, err)
+	_, err = decodeAll(t, []byte{0x92, 0x01}, 100)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = decodeAll(t, []byte{0xc1}, 100)
+	assert.ErrorContains(t, err, "invalid MessagePack type 0xc1")
+	_, err = decodeAll(t, []byte{0x81, 0x01, 0x02}, 100)
+	assert.ErrorContains(t, err, "map key")
+
+	// Length prefixes are checked before allocating.
+	_, err = decodeAll(t, []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, 100)
+	assert.ErrorIs(t, err, errTooLarge)
+	_, err = decodeAll(t, []byte{0xdd, 0x10, 0x00, 0x00, 0x00}, 100)
+	assert.ErrorIs(t, err, errTooLarge)
+
+	deep := bytes.Repeat([]byte{0x91}, maxDepth+2)
+	_, err = decodeAll(t, deep, 1<<10)
+	assert.ErrorContains(t, err, "nested too deeply")
+}
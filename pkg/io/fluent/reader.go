@@ -0,0 +1,416 @@
+// Package fluent listens for records pushed by Fluentd and Fluent Bit over
+// the forward protocol, so existing log shipping can feed detectors
+// without intermediate files or a message queue. Point a forward output
+// at the listener:
+//
+//	<match app.**>
+//	  @type forward
+//	  <server>
+//	    host detector.example
+//	    port 24224
+//	  </server>
+//	</match>
+//
+// All four message modes are accepted, including gzip-compressed packed
+// forward, and chunks are acknowledged for forwarders that ask for it
+// (require_ack_response). The shared-key handshake is not supported; use
+// TLS (transport tls) to authenticate peers instead.
+package fluent
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// DefaultAddr is the address forwarders send to by default.
+const DefaultAddr = ":24224"
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Record is a forwarded record mapped to features.
+type Record struct {
+	// Time is the event time the forwarder assigned.
+	Time time.Time
+	Tag  string
+	// Fields holds the leaves of the record by dot-separated path.
+	Fields   map[string]any
+	Features []float64
+}
+
+// Option configures a Reader.
+type Option func(*config)
+
+type config struct {
+	tls         *tls.Config
+	tags        []string
+	fields      []string
+	maxMessage  int
+	maxRecords  int
+	maxDuration time.Duration
+}
+
+// WithTLS serves TLS with cfg, for forwarders using transport tls.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tls = cfg
+	}
+}
+
+// WithTags keeps only the records whose tag matches one of the Fluentd
+// match patterns (see MatchTag). By default every record is kept.
+func WithTags(patterns ...string) Option {
+	return func(c *config) {
+		c.tags = patterns
+	}
+}
+
+// WithFields sets the record fields features are read from, in order, as
+// dot-separated paths: "status", "request.bytes", "latencies.0". Records
+// missing one are skipped. By default the fields are the numeric and
+// boolean leaves of the first record mapped, in lexical order.
+func WithFields(paths ...string) Option {
+	return func(c *config) {
+		c.fields = paths
+	}
+}
+
+// WithMaxMessageSize bounds the size of a forwarded message, decompressed.
+// Connections sending larger ones are closed. Defaults to 16 MiB.
+func WithMaxMessageSize(n int) Option {
+	return func(c *config) {
+		c.maxMessage = n
+	}
+}
+
+// WithMaxRecords makes Read stop after n records. Values below 1 mean no
+// limit.
+func WithMaxRecords(n int) Option {
+	return func(c *config) {
+		c.maxRecords = n
+	}
+}
+
+// WithMaxDuration makes Read stop d after it starts. Values below 1 mean
+// no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(c *config) {
+		c.maxDuration = d
+	}
+}
+
+// Reader accepts forward protocol connections and emits the records they
+// carry. Forwarders are held back while records wait to be read, so a slow
+// consumer slows log shipping down rather than growing memory.
+type Reader struct {
+	l       net.Listener
+	cfg     config
+	mapper  *guardio.FieldMapper
+	records chan Record
+	skipped atomic.Int64
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+	done   chan struct{}
+	closed sync.Once
+
+	// stopped is closed when the listener fails, after acceptErr is set.
+	stopped   chan struct{}
+	acceptErr error
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// Listen creates a Reader listening on the TCP address addr, DefaultAddr
+// if empty.
+func Listen(addr string, opts ...Option) (*Reader, error) {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(l, opts...), nil
+}
+
+// NewReader creates a Reader accepting connections on l, which it closes
+// when closed.
+func NewReader(l net.Listener, opts ...Option) *Reader {
+	cfg := config{maxMessage: 16 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.tls != nil {
+		l = tls.NewListener(l, cfg.tls)
+	}
+	r := &Reader{
+		l:       l,
+		cfg:     cfg,
+		mapper:  guardio.NewFieldMapper(cfg.fields, ""),
+		records: make(chan Record, 100),
+		conns:   make(map[net.Conn]struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go r.serve()
+	return r
+}
+
+// Addr returns the address the Reader listens on.
+func (r *Reader) Addr() net.Addr {
+	return r.l.Addr()
+}
+
+// serve accepts connections until the listener is closed.
+func (r *Reader) serve() {
+	for {
+		c, err := r.l.Accept()
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				r.acceptErr = err
+				close(r.stopped)
+			}
+			return
+		}
+		r.mu.Lock()
+		select {
+		case <-r.done:
+			r.mu.Unlock()
+			c.Close()
+			return
+		default:
+		}
+		r.conns[c] = struct{}{}
+		r.wg.Add(1)
+		r.mu.Unlock()
+
+		go func() {
+			defer r.wg.Done()
+			r.handle(c)
+			r.mu.Lock()
+			delete(r.conns, c)
+			r.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// handle reads the messages of a connection until it is closed or sends
+// something that is not a forward protocol message.
+func (r *Reader) handle(c net.Conn) {
+	br := bufio.NewReader(c)
+	for {
+		d := newDecoder(br, r.cfg.maxMessage)
+		v, err := d.decode()
+		if err != nil {
+			// A forwarder hanging up between messages or the network
+			// failing is not a malformed message.
+			var netErr net.Error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.As(err, &netErr) {
+				r.skipped.Add(1)
+			}
+			return
+		}
+		m, err := parseMessage(v, r.cfg.maxMessage)
+		if err != nil {
+			r.skipped.Add(1)
+			return
+		}
+		for _, ev := range m.events {
+			rec, ok := r.record(ev)
+			if !ok {
+				continue
+			}
+			select {
+			case r.records <- rec:
+			case <-r.done:
+				return
+			}
+		}
+		if m.chunk != "" {
+			if _, err := c.Write(ack(m.chunk)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// record maps an event, reporting false if it is filtered out or does not
+// map to features.
+func (r *Reader) record(ev event) (Record, bool) {
+	if !r.matchesTag(ev.tag) {
+		return Record{}, false
+	}
+	fields := guardio.Flatten(ev.record)
+	features, _, err := r.mapper.Map(fields)
+	if err != nil {
+		r.skipped.Add(1)
+		return Record{}, false
+	}
+	return Record{Time: ev.time, Tag: ev.tag, Fields: fields, Features: features}, true
+}
+
+func (r *Reader) matchesTag(tag string) bool {
+	if len(r.cfg.tags) == 0 {
+		return true
+	}
+	for _, p := range r.cfg.tags {
+		if MatchTag(p, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the next record, or the error that stopped the listener,
+// or ctx.Err().
+func (r *Reader) next(ctx context.Context) (Record, error) {
+	select {
+	case rec := <-r.records:
+		return rec, nil
+	case <-r.stopped:
+		return Record{}, r.acceptErr
+	case <-r.done:
+		return Record{}, net.ErrClosed
+	case <-ctx.Done():
+		return Record{}, ctx.Err()
+	}
+}
+
+// FeatureNames returns the record fields features are read from, nil
+// until the first record is mapped if they are inferred.
+func (r *Reader) FeatureNames() []string {
+	return r.mapper.Fields()
+}
+
+// Read returns the feature vectors of the records received until the
+// record or duration limit is reached. As a listener never ends on its
+// own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.cfg.maxRecords < 1 && r.cfg.maxDuration < 1 {
+		return nil, errors.New("fluent: listening needs a record or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	limit := ctx
+	if r.cfg.maxDuration > 0 {
+		var cancel context.CancelFunc
+		limit, cancel = context.WithTimeout(ctx, r.cfg.maxDuration)
+		defer cancel()
+	}
+
+	var data [][]float64
+	for r.cfg.maxRecords < 1 || len(data) < r.cfg.maxRecords {
+		rec, err := r.next(limit)
+		if err != nil {
+			if ctx.Err() == nil && limit.Err() != nil {
+				return data, nil
+			}
+			return data, err
+		}
+		data = append(data, rec.Features)
+	}
+	return data, nil
+}
+
+// Stream returns a channel of the feature vectors of the records
+// received, closed if the listener fails or when ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(rec Record, _ uint64) []float64 { return rec.Features }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the event
+// time of its record and its sequence number among the records emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(rec Record, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: rec.Features, Time: rec.Time, Seq: seq}
+	}), nil
+}
+
+// StreamRecords is Stream emitting the records themselves, which carry
+// their tag and fields.
+func (r *Reader) StreamRecords(ctx context.Context) (<-chan Record, error) {
+	return stream(ctx, r, func(rec Record, _ uint64) Record { return rec }), nil
+}
+
+// stream emits wrap(record, seq) for every record until the listener
+// fails or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(rec Record, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			rec, err := r.next(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					r.setErr(err)
+				}
+				return
+			}
+			select {
+			case out <- wrap(rec, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Skipped returns the number of records skipped so far because they did
+// not map to features, plus the malformed messages that made the Reader
+// drop their connection. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the listener error that stopped a stream, if any. It is
+// only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close stops listening and drops the connections of the forwarders,
+// which resend the chunks left unacknowledged.
+func (r *Reader) Close() error {
+	var err error
+	r.closed.Do(func() {
+		r.mu.Lock()
+		close(r.done)
+		for c := range r.conns {
+			c.Close()
+		}
+		r.mu.Unlock()
+		err = r.l.Close()
+		r.wg.Wait()
+	})
+	return err
+}
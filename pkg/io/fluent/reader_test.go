@@ -0,0 +1,136 @@
+package fluent
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T, opts ...Option) *Reader {
+	t.Helper()
+	r, err := Listen("127.0.0.1:0", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// forwarder connects to r and sends messages.
+func forwarder(t *testing.T, r *Reader) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", r.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func send(t *testing.T, c net.Conn, msg ...any) {
+	t.Helper()
+	_, err := c.Write(appendValue(nil, []any(msg)))
+	require.NoError(t, err)
+}
+
+func TestStreamRecords(t *testing.T) {
+	r := listen(t, WithTags("nginx.**"), WithFields("status", "request.bytes"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, err := r.StreamRecords(ctx)
+	require.NoError(t, err)
+
+	c := forwarder(t, r)
+	send(t, c, "nginx.access", at, map[string]any{"status": int64(404), "request": map[string]any{"bytes": 512.0}, "path": "/admin"})
+	send(t, c, "app.debug", at, map[string]any{"status": int64(200), "request": map[string]any{"bytes": 1.0}})
+	send(t, c, "nginx.access", entries(
+		map[string]any{"status": "200", "request": map[string]any{"bytes": int64(10)}},
+		map[string]any{"status": int64(500)},
+	), map[string]any{"chunk": "abc"})
+
+	first := <-records
+	assert.Equal(t, "nginx.access", first.Tag)
+	assert.Equal(t, at, first.Time)
+	assert.Equal(t, []float64{404, 512}, first.Features)
+	assert.Equal(t, "/admin", first.Fields["path"])
+
+	second := <-records
+	assert.Equal(t, []float64{200, 10}, second.Features, "app.debug is filtered out by tag")
+
+	v, err := newDecoder(bufio.NewReader(c), 100).decode()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"ack": "abc"}, v, "acknowledged once the chunk is queued")
+	assert.Equal(t, 1, r.Skipped(), "the record lacking request.bytes")
+
+	cancel()
+	for range records {
+	}
+	assert.NoError(t, r.Err())
+}
+
+func TestRead(t *testing.T) {
+	r := listen(t, WithMaxRecords(3))
+	for range 2 {
+		c := forwarder(t, r)
+		send(t, c, "app", []any{[]any{at, map[string]any{"x": int64(1), "y": true}}, []any{at, map[string]any{"x": int64(2), "y": false}}})
+	}
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Len(t, data, 3)
+	assert.Equal(t, []string{"x", "y"}, r.FeatureNames())
+}
+
+func TestReadLimits(t *testing.T) {
+	_, err := listen(t).Read()
+	assert.Error(t, err, "a listener never ends without a limit")
+
+	data, err := listen(t, WithMaxDuration(20*time.Millisecond)).Read()
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = listen(t).ReadContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMalformedConnection(t *testing.T) {
+	r := listen(t, WithMaxMessageSize(64))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data, err := r.Stream(ctx)
+	require.NoError(t, err)
+
+	bad := forwarder(t, r)
+	_, err = bad.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	bad.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = bad.Read(make([]byte, 1))
+	assert.Error(t, err, "the connection is dropped")
+
+	big := forwarder(t, r)
+	send(t, big, "app", at, map[string]any{"x": make([]byte, 100)})
+
+	good := forwarder(t, r)
+	send(t, good, "app", at, map[string]any{"x": int64(7)})
+	assert.Equal(t, []float64{7}, <-data, "other connections are unaffected")
+	assert.Eventually(t, func() bool { return r.Skipped() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestClose(t *testing.T) {
+	r := listen(t)
+	data, err := r.Stream(context.Background())
+	require.NoError(t, err)
+	c := forwarder(t, r)
+	send(t, c, "app", at, map[string]any{"x": int64(1)})
+	assert.Equal(t, []float64{1}, <-data)
+
+	require.NoError(t, r.Close())
+	for range data {
+	}
+	assert.NoError(t, r.Err(), "closing is not a stream error")
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Error(t, err, "forwarders are disconnected")
+}
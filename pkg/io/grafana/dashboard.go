@@ -0,0 +1,108 @@
+package grafana
+
+import (
+	"context"
+	"fmt"
+)
+
+// Panel is a time series panel of a provisioned dashboard, graphing a
+// PromQL expression over the dashboard's Prometheus data source.
+type Panel struct {
+	Title string
+	Expr  string
+	// Unit is a Grafana unit such as "short", "percentunit" or "reqps".
+	// Defaults to "short".
+	Unit string
+}
+
+// Dashboard is the JSON model of a dashboard, as Grafana's dashboard API
+// takes it.
+type Dashboard map[string]any
+
+// NewDashboard returns a starter dashboard titled title: the anomalies
+// posted with tags overlaid on every panel, a list of the most recent
+// ones, and a time series panel per entry of panels. Panels query the
+// Prometheus data source picked in the dashboard's datasource variable.
+// The dashboard's UID is uid, so provisioning it again updates it.
+func NewDashboard(uid, title string, tags []string, panels ...Panel) Dashboard {
+	if len(tags) == 0 {
+		tags = DefaultTags
+	}
+	list := []any{map[string]any{
+		"id":      1,
+		"type":    "annolist",
+		"title":   "Recent anomalies",
+		"gridPos": grid(0, 0, 24, 8),
+		"options": map[string]any{
+			"onlyFromThisDashboard": false,
+			"onlyInTimeRange":       true,
+			"tags":                  tags,
+			"limit":                 50,
+			"showUser":              false,
+			"showTime":              true,
+			"showTags":              true,
+		},
+	}}
+	for i, p := range panels {
+		unit := p.Unit
+		if unit == "" {
+			unit = "short"
+		}
+		list = append(list, map[string]any{
+			"id":         i + 2,
+			"type":       "timeseries",
+			"title":      p.Title,
+			"datasource": map[string]any{"type": "prometheus", "uid": "${datasource}"},
+			"gridPos":    grid((i%2)*12, 8+(i/2)*8, 12, 8),
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": unit},
+				"overrides": []any{},
+			},
+			"targets": []any{map[string]any{"refId": "A", "expr": p.Expr}},
+		})
+	}
+	return Dashboard{
+		"uid":           uid,
+		"title":         title,
+		"tags":          []string{"goguardml"},
+		"schemaVersion": 39,
+		"time":          map[string]any{"from": "now-6h", "to": "now"},
+		"refresh":       "30s",
+		"annotations": map[string]any{"list": []any{map[string]any{
+			"name":       "Anomalies",
+			"datasource": map[string]any{"type": "grafana", "uid": "-- Grafana --"},
+			"enable":     true,
+			"iconColor":  "red",
+			"target": map[string]any{
+				"type":     "tags",
+				"tags":     tags,
+				"matchAny": false,
+				"limit":    500,
+			},
+		}}},
+		"templating": map[string]any{"list": []any{map[string]any{
+			"name":  "datasource",
+			"label": "Prometheus",
+			"type":  "datasource",
+			"query": "prometheus",
+		}}},
+		"panels": list,
+	}
+}
+
+func grid(x, y, w, h int) map[string]int {
+	return map[string]int{"x": x, "y": y, "w": w, "h": h}
+}
+
+// Provision creates dashboard in Grafana, or replaces the one with the
+// same UID, in the General folder, and returns its URL.
+func (w *Writer) Provision(ctx context.Context, dashboard Dashboard) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	body := map[string]any{"dashboard": dashboard, "overwrite": true, "message": "provisioned by goguardml"}
+	if err := w.post(ctx, "/api/dashboards/db", body, &resp); err != nil {
+		return "", fmt.Errorf("provision dashboard: %w", err)
+	}
+	return w.base + resp.URL, nil
+}
@@ -0,0 +1,231 @@
+// Package grafana posts detected anomalies to Grafana as annotations, so
+// they appear on the graphs operators already watch, and provisions a
+// starter dashboard showing them.
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// DefaultTags are the tags annotations are posted with unless WithTags
+// sets others.
+var DefaultTags = []string{"goguardml", "anomaly"}
+
+// Option configures a Writer.
+type Option func(*config)
+
+type config struct {
+	token string
+	hc    *http.Client
+	tags  []string
+	board string
+	panel int
+	text  func(guardio.Result) string
+}
+
+// WithToken authenticates with a service account token or API key.
+func WithToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sends requests with hc instead of a client with a
+// ten-second timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *config) {
+		c.hc = hc
+	}
+}
+
+// WithTags sets the tags of posted annotations, which dashboards filter
+// on. Defaults to DefaultTags.
+func WithTags(tags ...string) Option {
+	return func(c *config) {
+		c.tags = slices.Clone(tags)
+	}
+}
+
+// WithDashboard posts annotations to one dashboard, and one of its panels
+// if panelID is not 0, instead of the organization-wide annotations any
+// dashboard can show.
+func WithDashboard(uid string, panelID int) Option {
+	return func(c *config) {
+		c.board = uid
+		c.panel = panelID
+	}
+}
+
+// WithText sets how a result is described in its annotation. The default
+// gives the score and, when the result carries an explanation, its top
+// features.
+func WithText(text func(guardio.Result) string) Option {
+	return func(c *config) {
+		c.text = text
+	}
+}
+
+// Writer is a guardio.Writer posting anomalous results to Grafana's
+// annotation API; normal results are dropped. It is safe for concurrent
+// use.
+type Writer struct {
+	base string
+	cfg  config
+}
+
+// NewWriter creates a Writer for the Grafana at baseURL, such as
+// http://grafana:3000.
+func NewWriter(baseURL string, opts ...Option) (*Writer, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("grafana: invalid URL %q", baseURL)
+	}
+	cfg := config{tags: DefaultTags, text: Describe}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.hc == nil {
+		cfg.hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Writer{base: strings.TrimSuffix(baseURL, "/"), cfg: cfg}, nil
+}
+
+// annotation is the body of POST /api/annotations.
+type annotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// Write posts result as an annotation at its timestamp, or now if it has
+// none, if it is an anomaly. Graded results are also tagged with their
+// severity, such as "severity:high", for alert rules to route on.
+func (w *Writer) Write(result guardio.Result) error {
+	if !result.IsAnomaly {
+		return nil
+	}
+	t := time.Now()
+	if result.Timestamp != 0 {
+		t = time.Unix(result.Timestamp, 0)
+	}
+	a := annotation{
+		DashboardUID: w.cfg.board,
+		PanelID:      w.cfg.panel,
+		Time:         t.UnixMilli(),
+		Tags:         w.cfg.tags,
+		Text:         w.cfg.text(result),
+	}
+	if result.Severity != detectors.SeverityNone {
+		a.Tags = append(slices.Clip(a.Tags), "severity:"+result.Severity.String())
+	}
+	return w.post(context.Background(), "/api/annotations", a, nil)
+}
+
+// WriteAll posts the anomalies among results, stopping at the first
+// error.
+func (w *Writer) WriteAll(results []guardio.Result) error {
+	for _, r := range results {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases nothing; annotations are posted as they are written.
+func (w *Writer) Close() error {
+	return nil
+}
+
+// post sends body as JSON to path and decodes the response into out, if
+// not nil.
+func (w *Writer) post(ctx context.Context, path string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.base+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.cfg.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.token)
+	}
+	resp, err := w.cfg.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("grafana: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// APIError is a request Grafana refused.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("grafana: HTTP %d: %s", e.Status, e.Message)
+}
+
+// ErrUnauthorized matches APIErrors for missing or rejected credentials.
+var ErrUnauthorized = errors.New("grafana: unauthorized")
+
+// Is reports 401 and 403 responses as ErrUnauthorized.
+func (e *APIError) Is(target error) bool {
+	return target == ErrUnauthorized && (e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden)
+}
+
+// Describe is the default annotation text: the score, its severity if
+// graded, and the top features of the explanation, by name when the
+// result has FeatureNames.
+func Describe(r guardio.Result) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Anomaly score %.3f", r.Score)
+	if r.Severity != detectors.SeverityNone {
+		fmt.Fprintf(&b, " (%s)", r.Severity)
+	}
+	if r.Explanation == nil || len(r.Explanation.Top) == 0 {
+		return b.String()
+	}
+	b.WriteString(": ")
+	for i, c := range r.Explanation.Top {
+		if i == 3 {
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		name := fmt.Sprintf("feature %d", c.Index)
+		if c.Index < len(r.FeatureNames) {
+			name = r.FeatureNames[c.Index]
+		}
+		fmt.Fprintf(&b, "%s=%g (%.0f%%)", name, c.Value, 100*c.Contribution)
+	}
+	return b.String()
+}
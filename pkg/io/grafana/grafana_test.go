@@ -0,0 +1,150 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// fakeGrafana records the bodies posted to each API path.
+type fakeGrafana struct {
+	mu     sync.Mutex
+	posted map[string][]map[string]any
+}
+
+func newFakeGrafana(t *testing.T) (*fakeGrafana, *httptest.Server) {
+	g := &fakeGrafana{posted: make(map[string][]map[string]any)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.mu.Lock()
+		g.posted[r.URL.Path] = append(g.posted[r.URL.Path], body)
+		g.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/annotations":
+			_, _ = w.Write([]byte(`{"id":1,"message":"Annotation added"}`))
+		case "/api/dashboards/db":
+			_, _ = w.Write([]byte(`{"status":"success","url":"/d/guard/anomalies"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return g, srv
+}
+
+func TestWriter(t *testing.T) {
+	g, srv := newFakeGrafana(t)
+	w, err := NewWriter(srv.URL+"/", WithToken("secret"), WithDashboard("guard", 2))
+	require.NoError(t, err)
+
+	require.NoError(t, w.WriteAll([]guardio.Result{
+		{Timestamp: 1700000000, Score: 0.2},
+		{
+			Timestamp:    1700000060,
+			Score:        0.91,
+			IsAnomaly:    true,
+			FeatureNames: []string{"bytes", "dst_port"},
+			Explanation: &detectors.Explanation{Top: []detectors.FeatureContribution{
+				{Index: 1, Contribution: 0.7, Value: 4444},
+				{Index: 0, Contribution: 0.3, Value: 10},
+			}},
+		},
+	}))
+	require.NoError(t, w.Close())
+
+	posted := g.posted["/api/annotations"]
+	require.Len(t, posted, 1, "normal results are not posted")
+	assert.Equal(t, map[string]any{
+		"dashboardUID": "guard",
+		"panelId":      2.0,
+		"time":         1700000060000.0,
+		"tags":         []any{"goguardml", "anomaly"},
+		"text":         "Anomaly score 0.910: dst_port=4444 (70%), bytes=10 (30%)",
+	}, posted[0])
+}
+
+func TestWriterOptions(t *testing.T) {
+	g, srv := newFakeGrafana(t)
+	w, err := NewWriter(srv.URL, WithToken("secret"), WithTags("ids"), WithText(func(r guardio.Result) string {
+		return "custom"
+	}))
+	require.NoError(t, err)
+	before := time.Now().UnixMilli()
+	require.NoError(t, w.Write(guardio.Result{Score: 0.8, IsAnomaly: true}))
+	require.NoError(t, w.Write(guardio.Result{Score: 0.95, IsAnomaly: true, Severity: detectors.SeverityCritical}))
+
+	a := g.posted["/api/annotations"][0]
+	assert.Equal(t, "custom", a["text"])
+	assert.Equal(t, []any{"ids"}, a["tags"])
+	assert.Equal(t, []any{"ids", "severity:critical"}, g.posted["/api/annotations"][1]["tags"])
+	assert.GreaterOrEqual(t, a["time"], float64(before), "results without a timestamp are annotated now")
+	assert.NotContains(t, a, "dashboardUID", "organization-wide by default")
+}
+
+func TestWriterErrors(t *testing.T) {
+	_, err := NewWriter("grafana:3000")
+	assert.Error(t, err)
+
+	_, srv := newFakeGrafana(t)
+	w, err := NewWriter(srv.URL, WithToken("wrong"))
+	require.NoError(t, err)
+	err = w.Write(guardio.Result{IsAnomaly: true})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.Status)
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "Anomaly score 0.500", Describe(guardio.Result{Score: 0.5}))
+	assert.Equal(t, "Anomaly score 0.500 (medium)", Describe(guardio.Result{Score: 0.5, Severity: detectors.SeverityMedium}))
+	r := guardio.Result{Score: 0.5, Explanation: &detectors.Explanation{Top: []detectors.FeatureContribution{
+		{Index: 3, Contribution: 0.5, Value: 1}, {Index: 0, Contribution: 0.2}, {Index: 1, Contribution: 0.2}, {Index: 2, Contribution: 0.1},
+	}}}
+	assert.Equal(t, "Anomaly score 0.500: feature 3=1 (50%), feature 0=0 (20%), feature 1=0 (20%)", Describe(r))
+}
+
+func TestProvision(t *testing.T) {
+	g, srv := newFakeGrafana(t)
+	w, err := NewWriter(srv.URL, WithToken("secret"))
+	require.NoError(t, err)
+
+	d := NewDashboard("guard", "Anomalies", nil,
+		Panel{Title: "Anomaly rate", Expr: "rate(anomalies_total[5m])", Unit: "reqps"},
+		Panel{Title: "Samples", Expr: "rate(samples_total[5m])"},
+	)
+	url, err := w.Provision(context.Background(), d)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/d/guard/anomalies", url)
+
+	body := g.posted["/api/dashboards/db"][0]
+	assert.Equal(t, true, body["overwrite"])
+	board := body["dashboard"].(map[string]any)
+	assert.Equal(t, "guard", board["uid"])
+	panels := board["panels"].([]any)
+	require.Len(t, panels, 3)
+	assert.Equal(t, "annolist", panels[0].(map[string]any)["type"])
+	second := panels[2].(map[string]any)
+	assert.Equal(t, map[string]any{"x": 12.0, "y": 8.0, "w": 12.0, "h": 8.0}, second["gridPos"])
+	assert.Equal(t, "short", second["fieldConfig"].(map[string]any)["defaults"].(map[string]any)["unit"])
+	annotations := board["annotations"].(map[string]any)["list"].([]any)
+	assert.Equal(t, []any{"goguardml", "anomaly"}, annotations[0].(map[string]any)["target"].(map[string]any)["tags"])
+}
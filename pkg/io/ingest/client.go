@@ -0,0 +1,175 @@
+package ingest
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ClientOption configures a Client.
+type ClientOption func(*clientConfig)
+
+type clientConfig struct {
+	tls   *tls.Config
+	token string
+}
+
+// WithClientTLS connects over TLS with cfg.
+func WithClientTLS(cfg *tls.Config) ClientOption {
+	return func(c *clientConfig) {
+		c.tls = cfg
+	}
+}
+
+// WithToken presents token as a bearer token, for Readers with an
+// Authenticator.
+func WithToken(token string) ClientOption {
+	return func(c *clientConfig) {
+		c.token = token
+	}
+}
+
+// Client pushes samples to an ingest service. It is safe for concurrent
+// use; connections are shared by its streams.
+type Client struct {
+	hc    *http.Client
+	url   string
+	token string
+}
+
+// NewClient creates a Client of the ingest service at addr, a host and
+// port. It connects lazily, on the first push.
+func NewClient(addr string, opts ...ClientOption) (*Client, error) {
+	var cfg clientConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	u := url.URL{Scheme: "https", Host: addr, Path: pushPath}
+	transport := &http.Transport{TLSClientConfig: cfg.tls, ForceAttemptHTTP2: true}
+	if cfg.tls == nil {
+		var err error
+		if transport, err = h2cTransport(); err != nil {
+			return nil, err
+		}
+		u.Scheme = "http"
+	}
+	return &Client{hc: &http.Client{Transport: transport}, url: u.String(), token: cfg.token}, nil
+}
+
+// PushStream is a Push call in progress.
+type PushStream struct {
+	w      *io.PipeWriter
+	result chan pushOutcome
+}
+
+type pushOutcome struct {
+	result PushResult
+	err    error
+}
+
+// Push starts streaming samples to the service. Canceling ctx aborts the
+// stream.
+func (c *Client) Push(ctx context.Context) (*PushStream, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	s := &PushStream{w: pw, result: make(chan pushOutcome, 1)}
+	go func() {
+		result, err := c.call(req)
+		// Unblock Send once the call is over, with its error if it
+		// failed early.
+		pr.CloseWithError(cmp.Or(err, io.ErrClosedPipe))
+		s.result <- pushOutcome{result, err}
+	}()
+	return s, nil
+}
+
+// call performs the request and decodes the result.
+func (c *Client) call(req *http.Request) (PushResult, error) {
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return PushResult{}, fmt.Errorf("ingest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PushResult{}, fmt.Errorf("ingest: HTTP status %s", resp.Status)
+	}
+	if err := status(resp.Header); err != nil {
+		return PushResult{}, err
+	}
+
+	msg, err := readFrame(resp.Body, 1<<10, false)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return PushResult{}, fmt.Errorf("ingest: result: %w", err)
+	}
+	// Trailers are only complete once the body is.
+	io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Grpc-Status") == "" {
+		return PushResult{}, errors.New("ingest: call ended without a status")
+	}
+	if err := status(resp.Trailer); err != nil {
+		return PushResult{}, err
+	}
+	if msg == nil {
+		return PushResult{}, errors.New("ingest: call ended without a result")
+	}
+	var result PushResult
+	if err := result.unmarshal(msg); err != nil {
+		return PushResult{}, fmt.Errorf("ingest: result: %w", err)
+	}
+	return result, nil
+}
+
+// status returns the gRPC status in h as an error, nil if it is OK or
+// absent.
+func status(h http.Header) error {
+	s := h.Get("Grpc-Status")
+	if s == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("ingest: malformed status %q", s)
+	}
+	if code == codeOK {
+		return nil
+	}
+	msg, err := url.PathUnescape(h.Get("Grpc-Message"))
+	if err != nil {
+		msg = h.Get("Grpc-Message")
+	}
+	return &StatusError{Code: code, Message: msg}
+}
+
+// Send pushes sample. It blocks while the service holds the stream back, and
+// fails once the call is over, with the call's error if it failed.
+func (s *PushStream) Send(sample Sample) error {
+	return writeFrame(s.w, sample.marshal())
+}
+
+// CloseAndRecv ends the stream and returns the service's result.
+func (s *PushStream) CloseAndRecv() (PushResult, error) {
+	s.w.Close()
+	o := <-s.result
+	return o.result, o.err
+}
+
+// Close releases the idle connections of c.
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
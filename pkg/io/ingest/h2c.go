@@ -0,0 +1,20 @@
+//go:build go1.24
+
+package ingest
+
+import "net/http"
+
+// enableH2C makes srv serve HTTP/2 without TLS, as gRPC clients expect of
+// plaintext servers.
+func enableH2C(srv *http.Server) error {
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return nil
+}
+
+// h2cTransport returns a transport speaking HTTP/2 without TLS.
+func h2cTransport() (*http.Transport, error) {
+	t := &http.Transport{Protocols: new(http.Protocols)}
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t, nil
+}
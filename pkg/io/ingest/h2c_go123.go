@@ -0,0 +1,19 @@
+//go:build !go1.24
+
+package ingest
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errH2C reports that plaintext HTTP/2 is unavailable.
+var errH2C = errors.New("ingest: plaintext gRPC needs Go 1.24 or later; use TLS")
+
+func enableH2C(*http.Server) error {
+	return errH2C
+}
+
+func h2cTransport() (*http.Transport, error) {
+	return nil, errH2C
+}
@@ -0,0 +1,31 @@
+//go:build go1.24
+
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaintext(t *testing.T) {
+	r, err := Listen("127.0.0.1:0", WithMaxSamples(1))
+	require.NoError(t, err)
+	defer r.Close()
+	c, err := NewClient(r.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	go func() {
+		s, err := c.Push(context.Background())
+		if err == nil {
+			s.Send(Sample{Features: []float64{1, 2}})
+			s.CloseAndRecv()
+		}
+	}()
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1, 2}}, data)
+}
@@ -0,0 +1,461 @@
+// Package ingest serves a gRPC service remote agents push data to, so a
+// central node can score what many hosts collect. The service, defined in
+// ingest.proto, takes a client stream of samples: feature vectors, or raw
+// records the node turns into features with its own extractor.
+//
+// The Reader speaks gRPC over net/http's HTTP/2 and the Client pushes to
+// it; neither needs generated code. Clients generated from ingest.proto in
+// any language work too. Plaintext HTTP/2 needs Go 1.24 or later; with
+// older toolchains, serve and connect over TLS.
+package ingest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Message is a sample received by the Reader.
+type Message struct {
+	// Time is the capture time the agent sent, or when the sample was
+	// received if it sent none.
+	Time     time.Time
+	Source   string
+	Features []float64
+}
+
+// Authenticator checks the bearer token a client presents, returning the
+// name of its key. An error with a name means the key is valid but over
+// its rate limit. *server.Authenticator implements it, so the ingest
+// service can share keys and limits with the scoring server.
+type Authenticator interface {
+	Check(key string) (string, error)
+}
+
+// Option configures a Reader.
+type Option func(*config)
+
+type config struct {
+	tls         *tls.Config
+	auth        Authenticator
+	extractor   guardio.FeatureExtractor
+	width       int
+	maxMessage  int
+	maxSamples  int
+	maxDuration time.Duration
+}
+
+// WithTLS serves TLS with cfg.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tls = cfg
+	}
+}
+
+// WithAuthenticator requires clients to present a bearer token a accepts.
+func WithAuthenticator(a Authenticator) Option {
+	return func(c *config) {
+		c.auth = a
+	}
+}
+
+// WithExtractor turns the raw records pushed into feature vectors with x,
+// whose Extract is passed each record as a []byte. Calls are serialized.
+// Without an extractor, records are rejected.
+func WithExtractor(x guardio.FeatureExtractor) Option {
+	return func(c *config) {
+		c.extractor = x
+	}
+}
+
+// WithWidth rejects feature vectors that do not have n features. By
+// default the width is that of the extractor's feature names, or else of
+// the first vector accepted.
+func WithWidth(n int) Option {
+	return func(c *config) {
+		c.width = n
+	}
+}
+
+// WithMaxMessageSize bounds the size of a pushed sample, decompressed.
+// Defaults to 4 MiB, as gRPC does.
+func WithMaxMessageSize(n int) Option {
+	return func(c *config) {
+		c.maxMessage = n
+	}
+}
+
+// WithMaxSamples makes Read stop after n samples. Values below 1 mean no
+// limit.
+func WithMaxSamples(n int) Option {
+	return func(c *config) {
+		c.maxSamples = n
+	}
+}
+
+// WithMaxDuration makes Read stop d after it starts. Values below 1 mean
+// no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(c *config) {
+		c.maxDuration = d
+	}
+}
+
+// Reader serves the ingest service and emits the samples pushed to it.
+// Clients are held back while samples wait to be read, so a slow consumer
+// slows agents down rather than growing memory.
+type Reader struct {
+	srv       *http.Server
+	l         net.Listener
+	cfg       config
+	width     atomic.Int64
+	extractMu sync.Mutex
+	messages  chan Message
+	rejected  atomic.Int64
+
+	done   chan struct{}
+	closed sync.Once
+
+	// stopped is closed when serving fails, after serveErr is set.
+	stopped  chan struct{}
+	serveErr error
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// Listen creates a Reader serving on the TCP address addr.
+func Listen(addr string, opts ...Option) (*Reader, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(l, opts...)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReader creates a Reader serving on l, which it closes when closed.
+func NewReader(l net.Listener, opts ...Option) (*Reader, error) {
+	cfg := config{maxMessage: 4 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	r := &Reader{
+		l:        l,
+		cfg:      cfg,
+		messages: make(chan Message, 100),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	r.width.Store(int64(cfg.width))
+	if cfg.width < 1 && cfg.extractor != nil {
+		r.width.Store(int64(len(cfg.extractor.FeatureNames())))
+	}
+	r.srv = &http.Server{
+		Handler:           http.HandlerFunc(r.serveHTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         cfg.tls,
+	}
+	if cfg.tls == nil {
+		if err := enableH2C(r.srv); err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		var err error
+		if cfg.tls != nil {
+			err = r.srv.ServeTLS(l, "", "")
+		} else {
+			err = r.srv.Serve(l)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			r.serveErr = err
+			close(r.stopped)
+		}
+	}()
+	return r, nil
+}
+
+// Addr returns the address the Reader serves on.
+func (r *Reader) Addr() net.Addr {
+	return r.l.Addr()
+}
+
+// serveHTTP handles a gRPC call.
+func (r *Reader) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	if req.Method != http.MethodPost || req.URL.Path != pushPath {
+		writeStatus(w, codeUnimplemented, "unknown method "+req.URL.Path)
+		return
+	}
+
+	source := req.RemoteAddr
+	if r.cfg.auth != nil {
+		token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		name, err := r.cfg.auth.Check(strings.TrimSpace(token))
+		switch {
+		case err != nil && name != "":
+			writeStatus(w, codeResourceExhausted, err.Error())
+			return
+		case err != nil:
+			writeStatus(w, codeUnauthenticated, err.Error())
+			return
+		}
+		source = name
+	}
+
+	var gzipped bool
+	switch enc := req.Header.Get("Grpc-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		gzipped = true
+	default:
+		w.Header().Set("Grpc-Accept-Encoding", "gzip")
+		writeStatus(w, codeUnimplemented, "unsupported encoding "+enc)
+		return
+	}
+
+	var result PushResult
+	for {
+		msg, err := readFrame(req.Body, r.cfg.maxMessage, gzipped)
+		if errors.Is(err, errFrameTooLarge) {
+			writeStatus(w, codeResourceExhausted, err.Error())
+			return
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			writeStatus(w, codeInvalidArgument, err.Error())
+			return
+		}
+		if err != nil {
+			break
+		}
+		var s Sample
+		if err := s.unmarshal(msg); err != nil {
+			writeStatus(w, codeInvalidArgument, "sample: "+err.Error())
+			return
+		}
+		m, ok := r.message(s, source)
+		if !ok {
+			result.Rejected++
+			r.rejected.Add(1)
+			continue
+		}
+		select {
+		case r.messages <- m:
+			result.Accepted++
+		case <-r.done:
+			writeStatus(w, codeUnavailable, "ingest service closed")
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	if err := writeFrame(w, result.marshal()); err != nil {
+		return
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+}
+
+// message converts a sample, reporting false if it must be rejected.
+func (r *Reader) message(s Sample, source string) (Message, bool) {
+	m := Message{Time: time.Now(), Source: source, Features: s.Features}
+	if s.TimeUnixNano != 0 {
+		m.Time = time.Unix(0, s.TimeUnixNano)
+	}
+	if s.Source != "" {
+		m.Source = s.Source
+	}
+	if len(m.Features) == 0 && len(s.Record) > 0 && r.cfg.extractor != nil {
+		r.extractMu.Lock()
+		features, err := r.cfg.extractor.Extract(s.Record)
+		r.extractMu.Unlock()
+		if err != nil {
+			return m, false
+		}
+		m.Features = features
+	}
+	if len(m.Features) == 0 {
+		return m, false
+	}
+	n := int64(len(m.Features))
+	if !r.width.CompareAndSwap(0, n) && r.width.Load() != n {
+		return m, false
+	}
+	return m, true
+}
+
+// writeStatus ends a call with a gRPC status and no response message.
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", fmt.Sprint(code))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes msg as gRPC requires of the
+// grpc-message header.
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// next returns the next message, or the error that stopped serving, or
+// ctx.Err().
+func (r *Reader) next(ctx context.Context) (Message, error) {
+	select {
+	case m := <-r.messages:
+		return m, nil
+	case <-r.stopped:
+		return Message{}, r.serveErr
+	case <-r.done:
+		return Message{}, net.ErrClosed
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// Read returns the feature vectors of the samples received until the
+// sample or duration limit is reached. As a service never ends on its
+// own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.cfg.maxSamples < 1 && r.cfg.maxDuration < 1 {
+		return nil, errors.New("ingest: serving needs a sample or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	limit := ctx
+	if r.cfg.maxDuration > 0 {
+		var cancel context.CancelFunc
+		limit, cancel = context.WithTimeout(ctx, r.cfg.maxDuration)
+		defer cancel()
+	}
+
+	var data [][]float64
+	for r.cfg.maxSamples < 1 || len(data) < r.cfg.maxSamples {
+		m, err := r.next(limit)
+		if err != nil {
+			if ctx.Err() == nil && limit.Err() != nil {
+				return data, nil
+			}
+			return data, err
+		}
+		data = append(data, m.Features)
+	}
+	return data, nil
+}
+
+// Stream returns a channel of the feature vectors of the samples
+// received, closed if serving fails or when ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(m Message, _ uint64) []float64 { return m.Features }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with its
+// capture time and its sequence number among the samples emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(m Message, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: m.Features, Time: m.Time, Seq: seq}
+	}), nil
+}
+
+// StreamMessages is Stream emitting the messages themselves, which name
+// their source.
+func (r *Reader) StreamMessages(ctx context.Context) (<-chan Message, error) {
+	return stream(ctx, r, func(m Message, _ uint64) Message { return m }), nil
+}
+
+// stream emits wrap(message, seq) for every message until serving fails
+// or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(m Message, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			m, err := r.next(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					r.setErr(err)
+				}
+				return
+			}
+			select {
+			case out <- wrap(m, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Skipped returns the number of samples rejected so far. It is safe to
+// call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.rejected.Load())
+}
+
+// Err returns the serving error that stopped a stream, if any. It is only
+// meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close stops serving and drops the connections of the clients pushing.
+func (r *Reader) Close() error {
+	var err error
+	r.closed.Do(func() {
+		close(r.done)
+		err = r.srv.Close()
+	})
+	return err
+}
@@ -0,0 +1,202 @@
+package ingest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTLS returns a server configuration with a self-signed certificate
+// for 127.0.0.1 and a client configuration trusting it.
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+// serve starts a TLS Reader and returns it with a Client of it.
+func serve(t *testing.T, opts []Option, clientOpts ...ClientOption) (*Reader, *Client) {
+	t.Helper()
+	serverTLS, clientTLS := testTLS(t)
+	r, err := Listen("127.0.0.1:0", append(opts, WithTLS(serverTLS))...)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	c, err := NewClient(r.Addr().String(), append(clientOpts, WithClientTLS(clientTLS))...)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return r, c
+}
+
+func push(t *testing.T, c *Client, samples ...Sample) (PushResult, error) {
+	t.Helper()
+	s, err := c.Push(context.Background())
+	require.NoError(t, err)
+	for _, sample := range samples {
+		if err := s.Send(sample); err != nil {
+			break
+		}
+	}
+	return s.CloseAndRecv()
+}
+
+func TestPush(t *testing.T) {
+	r, c := serve(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := r.StreamMessages(ctx)
+	require.NoError(t, err)
+
+	at := time.Unix(1700000000, 42)
+	result, err := push(t, c,
+		Sample{Features: []float64{1, 2}, TimeUnixNano: at.UnixNano(), Source: "web-1"},
+		Sample{Features: []float64{3, 4}},
+		Sample{Features: []float64{5}},
+		Sample{Record: []byte("no extractor")},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, PushResult{Accepted: 2, Rejected: 2}, result)
+	assert.Equal(t, 2, r.Skipped())
+
+	first := <-messages
+	assert.Equal(t, Message{Time: at, Source: "web-1", Features: []float64{1, 2}}, first)
+	second := <-messages
+	assert.Equal(t, []float64{3, 4}, second.Features)
+	assert.Contains(t, second.Source, "127.0.0.1:", "defaults to the client address")
+	assert.WithinDuration(t, time.Now(), second.Time, time.Minute)
+
+	cancel()
+	for range messages {
+	}
+	assert.NoError(t, r.Err())
+}
+
+// jsonExtractor reads the "n" field of JSON records.
+type jsonExtractor struct{}
+
+func (jsonExtractor) Extract(data any) ([]float64, error) {
+	var v struct{ N *float64 }
+	if err := json.Unmarshal(data.([]byte), &v); err != nil {
+		return nil, err
+	}
+	if v.N == nil {
+		return nil, errors.New("no n")
+	}
+	return []float64{*v.N}, nil
+}
+
+func (jsonExtractor) FeatureNames() []string { return []string{"n"} }
+
+func TestPushRecords(t *testing.T) {
+	r, c := serve(t, []Option{WithExtractor(jsonExtractor{}), WithMaxSamples(2)})
+	go push(t, c,
+		Sample{Record: []byte(`{"n":7}`)},
+		Sample{Record: []byte(`{"m":1}`)},
+		Sample{Features: []float64{1, 2}},
+		Sample{Features: []float64{8}},
+	)
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{7}, {8}}, data, "the width is the extractor's")
+	assert.Eventually(t, func() bool { return r.Skipped() == 2 }, time.Second, time.Millisecond)
+}
+
+type keys map[string]string
+
+func (k keys) Check(key string) (string, error) {
+	switch name := k[key]; {
+	case name == "":
+		return "", errors.New("invalid or missing API key")
+	case key == "limited":
+		return name, errors.New("rate limit exceeded")
+	default:
+		return name, nil
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	auth := keys{"secret": "edge-7", "limited": "noisy"}
+	r, c := serve(t, []Option{WithAuthenticator(auth)}, WithToken("secret"))
+	go push(t, c, Sample{Features: []float64{1}})
+	messages, err := r.StreamMessages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "edge-7", (<-messages).Source, "named by its key")
+
+	for token, code := range map[string]int{"": codeUnauthenticated, "wrong": codeUnauthenticated, "limited": codeResourceExhausted} {
+		c, err := NewClient(r.Addr().String(), WithToken(token), WithClientTLS(c.hc.Transport.(*http.Transport).TLSClientConfig))
+		require.NoError(t, err)
+		_, err = push(t, c, Sample{Features: []float64{1}})
+		var status *StatusError
+		require.ErrorAs(t, err, &status, token)
+		assert.Equal(t, code, status.Code, token)
+	}
+}
+
+func TestPushErrors(t *testing.T) {
+	r, c := serve(t, []Option{WithMaxMessageSize(64)})
+	_, err := push(t, c, Sample{Features: make([]float64, 100)})
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, codeResourceExhausted, status.Code)
+	assert.Equal(t, "message exceeds the size limit", status.Message)
+
+	c.url = "https://" + r.Addr().String() + "/goguardml.ingest.v1.Ingest/Pull"
+	_, err = push(t, c)
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, codeUnimplemented, status.Code)
+}
+
+func TestReadLimits(t *testing.T) {
+	r, _ := serve(t, nil)
+	_, err := r.Read()
+	assert.Error(t, err, "serving never ends without a limit")
+
+	r, _ = serve(t, []Option{WithMaxDuration(20 * time.Millisecond)})
+	data, err := r.Read()
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestClose(t *testing.T) {
+	r, c := serve(t, []Option{WithWidth(1)})
+	data, err := r.Stream(context.Background())
+	require.NoError(t, err)
+	s, err := c.Push(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, s.Send(Sample{Features: []float64{1}}))
+	assert.Equal(t, []float64{1}, <-data)
+
+	require.NoError(t, r.Close())
+	for range data {
+	}
+	assert.NoError(t, r.Err(), "closing is not a stream error")
+	_, err = s.CloseAndRecv()
+	assert.Error(t, err, "the push is cut off")
+}
@@ -0,0 +1,157 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+// pushPath is the HTTP/2 path of the Push method.
+const pushPath = "/goguardml.ingest.v1.Ingest/Push"
+
+// gRPC status codes.
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// StatusError is a gRPC status other than OK.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return "ingest: rpc error: code " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// Sample is a sample pushed to the ingest service: a feature vector or a
+// raw record for the receiving node's extractor.
+type Sample struct {
+	Features []float64
+	// TimeUnixNano is the capture time in nanoseconds since the epoch; 0
+	// means when received.
+	TimeUnixNano int64
+	Record       []byte
+	// Source names the agent or stream; the receiving node defaults it to
+	// the client address.
+	Source string
+}
+
+// PushResult is the outcome of a Push stream.
+type PushResult struct {
+	Accepted uint64
+	// Rejected counts the samples of the wrong width, without features
+	// or record, or whose record the extractor failed on.
+	Rejected uint64
+}
+
+func (s Sample) marshal() []byte {
+	var e pb.Encoder
+	e.Doubles(1, s.Features)
+	e.Int(2, s.TimeUnixNano)
+	e.RawBytes(3, s.Record)
+	e.String(4, s.Source)
+	return e.Bytes()
+}
+
+func (s *Sample) unmarshal(b []byte) error {
+	d := pb.NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			s.Features = d.Doubles(s.Features)
+		case 2:
+			s.TimeUnixNano = d.Int()
+		case 3:
+			s.Record = d.RawBytes()
+		case 4:
+			s.Source = d.String()
+		}
+	}
+	return d.Err()
+}
+
+func (r PushResult) marshal() []byte {
+	var e pb.Encoder
+	e.Uint(1, r.Accepted)
+	e.Uint(2, r.Rejected)
+	return e.Bytes()
+}
+
+func (r *PushResult) unmarshal(b []byte) error {
+	d := pb.NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			r.Accepted = d.Uint()
+		case 2:
+			r.Rejected = d.Uint()
+		}
+	}
+	return d.Err()
+}
+
+// writeFrame writes msg as an uncompressed gRPC length-prefixed message.
+func writeFrame(w io.Writer, msg []byte) error {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
+
+// errFrameTooLarge reports a message over the size limit.
+var errFrameTooLarge = errors.New("message exceeds the size limit")
+
+// readFrame reads a gRPC length-prefixed message of at most max bytes,
+// decompressed, inflating it if it is flagged compressed and gzip is the
+// stream's encoding. It returns io.EOF at the end of the stream.
+func readFrame(r io.Reader, max int, gzipped bool) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if uint64(n) > uint64(max) {
+		return nil, errFrameTooLarge
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	switch prefix[0] {
+	case 0:
+		return msg, nil
+	case 1:
+		if !gzipped {
+			return nil, errors.New("compressed message without an encoding")
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		msg, err = io.ReadAll(io.LimitReader(zr, int64(max)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) > max {
+			return nil, errFrameTooLarge
+		}
+		return msg, nil
+	}
+	return nil, fmt.Errorf("invalid message flag %d", prefix[0])
+}
@@ -0,0 +1,99 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+func TestSampleRoundTrip(t *testing.T) {
+	want := Sample{Features: []float64{1.5, -2, math.Inf(1)}, TimeUnixNano: -5, Record: []byte("raw"), Source: "agent-1"}
+	var got Sample
+	require.NoError(t, got.unmarshal(want.marshal()))
+	assert.Equal(t, want, got)
+
+	var empty Sample
+	require.NoError(t, empty.unmarshal(Sample{}.marshal()))
+	assert.Equal(t, Sample{}, empty)
+}
+
+func TestSampleUnpackedAndUnknownFields(t *testing.T) {
+	var e pb.Encoder
+	e.Double(1, 3)
+	e.Uint(10, 300)
+	e.Double(1, 4)
+	b := append([]byte{0x4d, 7, 0, 0, 0}, e.Bytes()...) // fixed32 field 9
+
+	var s Sample
+	require.NoError(t, s.unmarshal(b))
+	assert.Equal(t, []float64{3, 4}, s.Features)
+
+	for name, bad := range map[string][]byte{
+		"truncated length": {0x1a, 0x05, 'a'},
+		"truncated fixed":  {0x09, 1, 2},
+		"group":            {0x0b},
+		"packed":           {0x0a, 0x03, 1, 2, 3},
+	} {
+		assert.Error(t, new(Sample).unmarshal(bad), name)
+	}
+}
+
+func TestPushResultRoundTrip(t *testing.T) {
+	want := PushResult{Accepted: 1 << 40, Rejected: 3}
+	var got PushResult
+	require.NoError(t, got.unmarshal(want.marshal()))
+	assert.Equal(t, want, got)
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeFrame(&buf, []byte("hello")))
+	require.NoError(t, writeFrame(&buf, nil))
+
+	msg, err := readFrame(&buf, 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), msg)
+	msg, err = readFrame(&buf, 10, false)
+	require.NoError(t, err)
+	assert.Empty(t, msg)
+	_, err = readFrame(&buf, 10, false)
+	assert.Equal(t, io.EOF, err)
+
+	require.NoError(t, writeFrame(&buf, []byte("too long")))
+	_, err = readFrame(&buf, 4, false)
+	assert.ErrorIs(t, err, errFrameTooLarge)
+
+	buf.Reset()
+	buf.Write([]byte{0, 0, 0, 0, 9, 'x'})
+	_, err = readFrame(&buf, 10, false)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestCompressedFrames(t *testing.T) {
+	compressed := func(data []byte) []byte {
+		var z bytes.Buffer
+		zw := gzip.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		b := []byte{1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(z.Len()))
+		return append(b, z.Bytes()...)
+	}
+
+	msg, err := readFrame(bytes.NewReader(compressed([]byte("hello"))), 100, true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), msg)
+
+	_, err = readFrame(bytes.NewReader(compressed([]byte("hello"))), 100, false)
+	assert.Error(t, err, "compressed without an encoding")
+	_, err = readFrame(bytes.NewReader(compressed(make([]byte, 1000))), 100, true)
+	assert.ErrorIs(t, err, errFrameTooLarge, "the limit applies decompressed")
+}
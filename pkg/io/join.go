@@ -0,0 +1,364 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Aggregation combines the samples of one source, key and window of a
+// JoinReader into one vector.
+type Aggregation uint8
+
+// Aggregations, feature by feature.
+const (
+	AggregateMean Aggregation = iota
+	AggregateSum
+	AggregateMax
+	AggregateLast
+)
+
+// JoinSource is an input of a JoinReader.
+type JoinSource struct {
+	// Name prefixes the source's feature names, such as "flow".
+	Name   string
+	Reader Reader
+	// Width is the number of features of the source's samples. Samples
+	// of another width are skipped.
+	Width int
+	// Key returns the join key of a sample, such as the host it concerns.
+	// If nil, all samples of a window share one key.
+	Key func(Sample) string
+	// Aggregate combines the samples of a key in a window, AggregateMean
+	// by default.
+	Aggregate Aggregation
+	// Names names the source's features, for readers that do not
+	// report them with a FeatureNames method.
+	Names []string
+}
+
+// JoinOption configures a JoinReader.
+type JoinOption func(*JoinReader)
+
+// WithJoinFill sets the value of the features of sources without a
+// sample for a key and window, 0 by default.
+func WithJoinFill(v float64) JoinOption {
+	return func(j *JoinReader) {
+		j.fill = v
+	}
+}
+
+// WithJoinCounts appends the number of samples of each source in the key
+// and window to every joined vector.
+func WithJoinCounts() JoinOption {
+	return func(j *JoinReader) {
+		j.counts = true
+	}
+}
+
+// WithJoinMinSources drops keys and windows with samples from fewer than
+// n sources, 1 by default.
+func WithJoinMinSources(n int) JoinOption {
+	return func(j *JoinReader) {
+		j.minSources = n
+	}
+}
+
+// KeyFeature returns a JoinSource key function keying samples by feature
+// i, such as a numeric host or address identifier.
+func KeyFeature(i int) func(Sample) string {
+	return func(s Sample) string {
+		return fmt.Sprint(s.Features[i])
+	}
+}
+
+// JoinReader streams several Readers as one, joining the samples they
+// capture in the same time window and for the same key into a single
+// wider feature vector, such as the flows, metrics and logins of a host
+// per minute. Cross-source features catch behavior no single source
+// reveals.
+//
+// Windows are tumbling, aligned to multiples of the window length, and
+// samples are placed by capture time. Sources are merged in time order as
+// by MultiReader, so each must be in time order; a window is emitted once
+// every open source has moved past it, keys in sorted order. Each joined
+// vector is the aggregated features of every source in order, filled for
+// sources without a sample, then with WithJoinCounts the sample counts.
+// Joined samples carry the window start as their time and are numbered
+// from 1.
+type JoinReader struct {
+	window     time.Duration
+	sources    []JoinSource
+	fill       float64
+	counts     bool
+	minSources int
+
+	skipped atomic.Int64
+}
+
+// NewJoinReader returns a Reader joining sources per window. Closing it
+// closes them.
+func NewJoinReader(window time.Duration, sources []JoinSource, opts ...JoinOption) *JoinReader {
+	j := &JoinReader{window: window, sources: slices.Clone(sources), minSources: 1}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// FeatureNames returns the names of the joined features: each source's
+// feature names prefixed with its name and a dot, then with
+// WithJoinCounts each source's name followed by ".count". Features of
+// sources without names are numbered.
+func (j *JoinReader) FeatureNames() []string {
+	var names []string
+	for i, src := range j.sources {
+		prefix := src.Name
+		if prefix == "" {
+			prefix = fmt.Sprintf("source%d", i)
+		}
+		own := src.Names
+		if n, ok := src.Reader.(interface{ FeatureNames() []string }); ok && own == nil {
+			own = n.FeatureNames()
+		}
+		for f := range src.Width {
+			name := fmt.Sprintf("f%d", f)
+			if f < len(own) {
+				name = own[f]
+			}
+			names = append(names, prefix+"."+name)
+		}
+	}
+	if j.counts {
+		for i, src := range j.sources {
+			prefix := src.Name
+			if prefix == "" {
+				prefix = fmt.Sprintf("source%d", i)
+			}
+			names = append(names, prefix+".count")
+		}
+	}
+	return names
+}
+
+// Read streams the sources to the end and returns the joined vectors.
+func (j *JoinReader) Read() ([][]float64, error) {
+	samples, err := j.StreamSamples(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var data [][]float64
+	for s := range samples {
+		data = append(data, s.Features)
+	}
+	return data, nil
+}
+
+// Stream returns the features of StreamSamples.
+func (j *JoinReader) Stream(ctx context.Context) (<-chan []float64, error) {
+	samples, err := j.StreamSamples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []float64, cap(samples))
+	go func() {
+		defer close(out)
+		for s := range samples {
+			select {
+			case out <- s.Features:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// StreamSamples streams all sources at once and joins their samples. The
+// channel is closed once every source stream is, after the last window,
+// or when ctx is done.
+func (j *JoinReader) StreamSamples(ctx context.Context) (<-chan Sample, error) {
+	if j.window <= 0 {
+		return nil, errors.New("join: window must be positive")
+	}
+	for i, src := range j.sources {
+		if src.Width < 1 {
+			return nil, fmt.Errorf("join: source %d: width must be positive", i)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	in := make([]<-chan Sample, len(j.sources))
+	for i, src := range j.sources {
+		ch, err := src.Reader.StreamSamples(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("source %d: %w", i, err)
+		}
+		in[i] = ch
+	}
+
+	out := make(chan Sample, 100)
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		var (
+			start  time.Time
+			groups = map[string]*joinGroup{}
+			seq    uint64
+		)
+		flush := func() bool {
+			keys := make([]string, 0, len(groups))
+			for k := range groups {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				features, ok := j.joined(groups[k])
+				if !ok {
+					continue
+				}
+				seq++
+				select {
+				case out <- Sample{Features: features, Time: start, Seq: seq}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			clear(groups)
+			return true
+		}
+
+		mergeSources(ctx, in, func(p pending) bool {
+			src := &j.sources[p.source]
+			at := p.sample.Time.Truncate(j.window)
+			if len(p.sample.Features) != src.Width || at.Before(start) {
+				// A sample of the wrong width, or late: its window is
+				// already emitted.
+				j.skipped.Add(1)
+				return true
+			}
+			if at.After(start) {
+				if !flush() {
+					return false
+				}
+				start = at
+			}
+			key := ""
+			if src.Key != nil {
+				key = src.Key(p.sample)
+			}
+			g := groups[key]
+			if g == nil {
+				g = &joinGroup{sources: make([]joinAgg, len(j.sources))}
+				groups[key] = g
+			}
+			g.sources[p.source].add(p.sample.Features, src.Aggregate)
+			return true
+		})
+		if ctx.Err() == nil {
+			flush()
+		}
+	}()
+	return out, nil
+}
+
+// joinGroup holds the samples of one key in the current window.
+type joinGroup struct {
+	sources []joinAgg
+}
+
+// joinAgg aggregates the samples of one source in a group.
+type joinAgg struct {
+	values []float64
+	n      int
+}
+
+func (a *joinAgg) add(features []float64, agg Aggregation) {
+	a.n++
+	if a.values == nil {
+		// Samples may be pooled and reused once forwarded.
+		a.values = slices.Clone(features)
+		return
+	}
+	for i, v := range features {
+		switch agg {
+		case AggregateMean, AggregateSum:
+			a.values[i] += v
+		case AggregateMax:
+			a.values[i] = math.Max(a.values[i], v)
+		case AggregateLast:
+			a.values[i] = v
+		}
+	}
+}
+
+// joined returns the joined vector of g, or false if too few sources have
+// samples in it.
+func (j *JoinReader) joined(g *joinGroup) ([]float64, bool) {
+	present := 0
+	for _, a := range g.sources {
+		if a.n > 0 {
+			present++
+		}
+	}
+	if present < j.minSources {
+		return nil, false
+	}
+	var features []float64
+	for i, a := range g.sources {
+		src := j.sources[i]
+		if a.n == 0 {
+			for range src.Width {
+				features = append(features, j.fill)
+			}
+			continue
+		}
+		for _, v := range a.values {
+			if src.Aggregate == AggregateMean {
+				v /= float64(a.n)
+			}
+			features = append(features, v)
+		}
+	}
+	if j.counts {
+		for _, a := range g.sources {
+			features = append(features, float64(a.n))
+		}
+	}
+	return features, true
+}
+
+// Skipped returns the number of source samples dropped for having the
+// wrong width or arriving after their window was emitted.
+func (j *JoinReader) Skipped() int {
+	return int(j.skipped.Load())
+}
+
+// Err returns the errors that stopped source streams early, for sources
+// that report them.
+func (j *JoinReader) Err() error {
+	var errs []error
+	for i, src := range j.sources {
+		if e, ok := src.Reader.(interface{ Err() error }); ok {
+			if err := e.Err(); err != nil {
+				errs = append(errs, fmt.Errorf("source %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every source.
+func (j *JoinReader) Close() error {
+	var errs []error
+	for _, src := range j.sources {
+		errs = append(errs, src.Reader.Close())
+	}
+	return errors.Join(errs...)
+}
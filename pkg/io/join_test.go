@@ -0,0 +1,89 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinReader(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+
+	flows := &sliceReader{samples: []Sample{
+		{Features: []float64{1, 10}, Time: at(5)},
+		{Features: []float64{2, 20}, Time: at(10)},
+		{Features: []float64{1, 30}, Time: at(20)},
+		{Features: []float64{1, 40}, Time: at(70)},
+		{Features: []float64{9}, Time: at(75)}, // wrong width
+	}}
+	logins := &sliceReader{samples: []Sample{
+		{Features: []float64{1, 3}, Time: at(30)},
+		{Features: []float64{1, 5}, Time: at(40)},
+		{Features: []float64{2, 1}, Time: at(65)},
+	}}
+	j := NewJoinReader(time.Minute, []JoinSource{
+		{Name: "flow", Reader: flows, Width: 2, Key: KeyFeature(0), Names: []string{"host", "bytes"}},
+		{Name: "login", Reader: logins, Width: 2, Key: KeyFeature(0), Aggregate: AggregateMax},
+	}, WithJoinFill(-1), WithJoinCounts())
+
+	assert.Equal(t, []string{
+		"flow.host", "flow.bytes", "login.f0", "login.f1", "flow.count", "login.count",
+	}, j.FeatureNames())
+
+	ch, err := j.StreamSamples(context.Background())
+	require.NoError(t, err)
+	var got []Sample
+	for s := range ch {
+		got = append(got, s)
+	}
+	require.Len(t, got, 4)
+	assert.Equal(t, []float64{1, 20, 1, 5, 2, 2}, got[0].Features, "host 1, first minute")
+	assert.Equal(t, []float64{2, 20, -1, -1, 1, 0}, got[1].Features, "host 2, first minute")
+	assert.Equal(t, []float64{1, 40, -1, -1, 1, 0}, got[2].Features)
+	assert.Equal(t, []float64{-1, -1, 2, 1, 0, 1}, got[3].Features)
+	for i, s := range got {
+		assert.Equal(t, uint64(i+1), s.Seq)
+	}
+	assert.Equal(t, base, got[0].Time)
+	assert.Equal(t, at(60), got[3].Time)
+	assert.Equal(t, 1, j.Skipped())
+
+	require.NoError(t, j.Close())
+	assert.True(t, flows.closed)
+	assert.True(t, logins.closed)
+}
+
+func TestJoinReaderMinSources(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := &sliceReader{samples: []Sample{
+		{Features: []float64{1}, Time: base},
+		{Features: []float64{3}, Time: base.Add(time.Second)},
+		{Features: []float64{5}, Time: base.Add(time.Minute)},
+	}}
+	b := &sliceReader{samples: []Sample{
+		{Features: []float64{7}, Time: base.Add(2 * time.Second)},
+	}}
+	j := NewJoinReader(time.Minute, []JoinSource{
+		{Reader: a, Width: 1, Aggregate: AggregateSum},
+		{Reader: b, Width: 1, Aggregate: AggregateLast},
+	}, WithJoinMinSources(2))
+
+	data, err := j.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{4, 7}}, data)
+	assert.Equal(t, []string{"source0.f0", "source1.f0"}, j.FeatureNames())
+}
+
+func TestJoinReaderInvalid(t *testing.T) {
+	src := []JoinSource{{Reader: &sliceReader{}, Width: 1}}
+	_, err := NewJoinReader(0, src).StreamSamples(context.Background())
+	assert.Error(t, err)
+
+	src[0].Width = 0
+	_, err = NewJoinReader(time.Minute, src).StreamSamples(context.Background())
+	assert.Error(t, err)
+}
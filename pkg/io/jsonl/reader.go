@@ -0,0 +1,37 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// maxLineBytes bounds a single JSON Lines record.
+const maxLineBytes = 16 << 20
+
+// ReadResults reads results written by Writer. Blank lines are skipped.
+func ReadResults(r io.Reader) ([]guardio.Result, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	var results []guardio.Result
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var result guardio.Result
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
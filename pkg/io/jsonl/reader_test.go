@@ -0,0 +1,40 @@
+package jsonl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func TestReadResults(t *testing.T) {
+	want := []guardio.Result{
+		{Timestamp: 1, Score: 0.2, Features: []float64{1, 2}},
+		{
+			Timestamp:   2,
+			Score:       0.9,
+			IsAnomaly:   true,
+			Metadata:    map[string]any{"route": "eth0"},
+			Explanation: &detectors.Explanation{Score: 0.9, Contributions: []float64{0.1, 0.9}},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteAll(want))
+	buf.WriteString("\n")
+
+	got, err := ReadResults(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestReadResultsInvalid(t *testing.T) {
+	_, err := ReadResults(strings.NewReader("{\"score\": 1}\nnot json\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,66 @@
+// Package jsonl provides JSON Lines output for detection results.
+package jsonl
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// Writer writes results as newline-delimited JSON objects.
+type Writer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewWriter creates a writer that outputs to w.
+// If w implements io.Closer, Close closes it.
+func NewWriter(w io.Writer) *Writer {
+	jw := &Writer{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		jw.closer = c
+	}
+	return jw
+}
+
+// NewFileWriter creates a writer that outputs to the named file.
+// The file is created or truncated.
+func NewFileWriter(filename string) (*Writer, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(file), nil
+}
+
+// Write outputs a single result.
+func (w *Writer) Write(result guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(result)
+}
+
+// WriteAll outputs multiple results.
+func (w *Writer) WriteAll(results []guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, result := range results {
+		if err := w.enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases resources.
+func (w *Writer) Close() error {
+	if w.closer != nil {
+		return w.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package kube
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func init() {
+	guardio.DefaultExtractors.MustRegister("kube", func() (guardio.FeatureExtractor, error) {
+		return NewRecordExtractor(), nil
+	})
+}
+
+// FeatureNames names the features of an entry, in vector order.
+//
+// verb is 0 for reads (get, list, watch) and events, 1 for writes
+// (create, update, patch), 2 for deletes and 3 for other verbs such as
+// impersonate, escalate or bind. resource is 3 for exec, attach,
+// port-forward and proxy subresources, 2 for RBAC roles and bindings,
+// certificate signing requests and admission webhook configurations, 1
+// for secrets and service account tokens and 0 otherwise. status_class is
+// the hundreds digit of the response code, 0 if not recorded. ua_rarity
+// is log10 of how many entries were seen per one with the same user agent
+// before it, and principal_rate the entries per minute of the user over
+// the rate window. anonymous, impersonated and warning are 0 or 1.
+var FeatureNames = []string{
+	"verb", "resource", "status_class", "ua_rarity", "principal_rate", "anonymous", "impersonated", "warning",
+}
+
+// Option configures a Reader, or the Extractor it applies to.
+type Option func(*config)
+
+type config struct {
+	stages        []string
+	window        time.Duration
+	maxPrincipals int
+	maxAgents     int
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		stages:        []string{StageResponseComplete, StagePanic},
+		window:        time.Minute,
+		maxPrincipals: 100000,
+		maxAgents:     10000,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.window = max(c.window, time.Second)
+	c.maxPrincipals = max(c.maxPrincipals, 1)
+	return c
+}
+
+// WithStages sets the audit stages a Reader keeps. The API server may
+// record a request at each stage, so keeping several counts it several
+// times. Defaults to ResponseComplete and Panic.
+func WithStages(stages ...string) Option {
+	return func(c *config) {
+		c.stages = stages
+	}
+}
+
+// WithRateWindow sets the sliding window the per-principal rate is
+// measured over. Defaults to one minute.
+func WithRateWindow(d time.Duration) Option {
+	return func(c *config) {
+		c.window = d
+	}
+}
+
+// WithMaxPrincipals bounds the number of principals whose rate is tracked
+// at once. When a new principal would exceed it, the least recently
+// active one is forgotten. Defaults to 100000.
+func WithMaxPrincipals(n int) Option {
+	return func(c *config) {
+		c.maxPrincipals = n
+	}
+}
+
+// WithMaxAgents bounds the number of user agents counted for their
+// rarity. Agents first seen once the limit is reached are not counted,
+// and stay as rare as unseen ones. Defaults to 10000.
+func WithMaxAgents(n int) Option {
+	return func(c *config) {
+		c.maxAgents = n
+	}
+}
+
+// Extractor turns entries into feature vectors. It keeps the recent
+// entries of each principal and counts user agents, so entries must come
+// in log order. It is not safe for concurrent use.
+type Extractor struct {
+	window        time.Duration
+	maxPrincipals int
+	maxAgents     int
+	principals    map[string][]time.Time // entry times in the window, oldest first
+	agents        map[string]int
+	total         int
+}
+
+// NewExtractor creates an Extractor. The stages option is ignored.
+func NewExtractor(opts ...Option) *Extractor {
+	c := newConfig(opts)
+	return &Extractor{
+		window:        c.window,
+		maxPrincipals: c.maxPrincipals,
+		maxAgents:     c.maxAgents,
+		principals:    make(map[string][]time.Time),
+		agents:        make(map[string]int),
+	}
+}
+
+// Extract returns the feature vector of e.
+func (x *Extractor) Extract(e Entry) []float64 {
+	return []float64{
+		verbClass(e.Verb),
+		sensitivity(e.Resource, e.Subresource),
+		float64(e.Code / 100),
+		x.rarity(e.UserAgent),
+		x.rate(e),
+		flag(e.User == "system:anonymous" || slices.Contains(e.Groups, "system:unauthenticated")),
+		flag(e.Impersonated != ""),
+		flag(e.Warning),
+	}
+}
+
+// FeatureNames returns the names of the extracted features.
+func (x *Extractor) FeatureNames() []string {
+	return FeatureNames
+}
+
+// NewRecordExtractor returns a guardio.FeatureExtractor of entries and of
+// audit events, events or watch events as JSON []byte. It is registered
+// as "kube" in guardio.DefaultExtractors.
+func NewRecordExtractor(opts ...Option) guardio.FeatureExtractor {
+	x := NewExtractor(opts...)
+	return guardio.NewFuncExtractor(FeatureNames, func(record any) ([]float64, error) {
+		switch v := record.(type) {
+		case Entry:
+			return x.Extract(v), nil
+		case []byte:
+			e, err := Parse(v)
+			if err != nil {
+				return nil, err
+			}
+			return x.Extract(e), nil
+		}
+		return nil, fmt.Errorf("cannot extract %T, want a Kubernetes audit entry or event", record)
+	})
+}
+
+// rarity returns the rarity of agent among the entries seen so far, then
+// counts it.
+func (x *Extractor) rarity(agent string) float64 {
+	n, ok := x.agents[agent]
+	r := math.Log10(float64(x.total+1) / float64(n+1))
+	if ok || len(x.agents) < x.maxAgents {
+		x.agents[agent] = n + 1
+	}
+	x.total++
+	return r
+}
+
+// rate records e and returns the entries per minute of its principal over
+// the window, e included. Entries without a principal count alone.
+func (x *Extractor) rate(e Entry) float64 {
+	perMinute := float64(time.Minute) / float64(x.window)
+	if e.User == "" {
+		return perMinute
+	}
+
+	times, ok := x.principals[e.User]
+	if !ok && len(x.principals) >= x.maxPrincipals {
+		x.evict()
+	}
+	from := e.Time.Add(-x.window)
+	i := 0
+	for i < len(times) && !times[i].After(from) {
+		i++
+	}
+	times = append(times[i:], e.Time)
+	x.principals[e.User] = times
+	return float64(len(times)) * perMinute
+}
+
+// evict forgets the principal whose last entry is the oldest.
+func (x *Extractor) evict() {
+	var oldest string
+	var oldestTime time.Time
+	found := false
+	for user, times := range x.principals {
+		if last := times[len(times)-1]; !found || last.Before(oldestTime) {
+			oldest, oldestTime, found = user, last, true
+		}
+	}
+	delete(x.principals, oldest)
+}
+
+func verbClass(verb string) float64 {
+	switch verb {
+	case "", "get", "list", "watch":
+		return 0
+	case "create", "update", "patch":
+		return 1
+	case "delete", "deletecollection":
+		return 2
+	default:
+		return 3
+	}
+}
+
+func sensitivity(resource, subresource string) float64 {
+	switch subresource {
+	case "exec", "attach", "portforward", "proxy":
+		return 3
+	}
+	switch resource {
+	case "roles", "rolebindings", "clusterroles", "clusterrolebindings", "certificatesigningrequests",
+		"mutatingwebhookconfigurations", "validatingwebhookconfigurations":
+		return 2
+	case "secrets":
+		return 1
+	case "serviceaccounts":
+		if subresource == "token" {
+			return 1
+		}
+	}
+	return 0
+}
+
+func flag(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
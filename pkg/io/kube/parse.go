@@ -0,0 +1,221 @@
+// Package kube reads Kubernetes API server audit logs and cluster events,
+// and extracts features for detecting API server abuse.
+//
+// Input is a stream of JSON values: audit events (audit.k8s.io), one per
+// line as the log backend writes them or in the EventList batches the
+// webhook backend posts; and events of the events API (v1 or
+// events.k8s.io/v1), as objects, lists such as the output of kubectl get
+// events -o json, or watch events such as the output of kubectl get events
+// --watch -o json. Values of other kinds are skipped and counted.
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Kind tells audit events from cluster events.
+type Kind int
+
+const (
+	// Audit is an API server request recorded by the audit log.
+	Audit Kind = iota
+	// Event is an event of the events API, such as a pod failing to
+	// start.
+	Event
+)
+
+// Audit stages, in the order the API server records them.
+const (
+	StageRequestReceived  = "RequestReceived"
+	StageResponseStarted  = "ResponseStarted"
+	StageResponseComplete = "ResponseComplete"
+	StagePanic            = "Panic"
+)
+
+// Entry is one audited request or one event.
+type Entry struct {
+	Time time.Time
+	Kind Kind
+	// Stage is the audit stage, empty for events.
+	Stage string
+	// User is the principal: the authenticated user of a request, or the
+	// component that reported an event.
+	User   string
+	Groups []string
+	// Impersonated is the user a request acted as, empty if the request
+	// was not impersonated.
+	Impersonated string
+	UserAgent    string
+	// Source is the first client address of a request, invalid for
+	// events.
+	Source netip.Addr
+	// Verb is the verb of a request, such as get or create, empty for
+	// events.
+	Verb string
+	// Resource is the resource of a request, such as pods, or the
+	// lowercased kind of the object an event is about, such as pod.
+	Resource    string
+	Subresource string
+	Namespace   string
+	Name        string
+	// Code is the response status code of a request, zero if not
+	// recorded.
+	Code int
+	// Reason is the reason of an event, such as BackOff.
+	Reason string
+	// Warning is set for events of the Warning type.
+	Warning bool
+}
+
+// object holds the fields of audit events, events and watch events that
+// entries are made of.
+type object struct {
+	Kind       string          `json:"kind"`
+	APIVersion string          `json:"apiVersion"`
+	Object     json.RawMessage `json:"object"`
+
+	// audit.k8s.io Event
+	Stage            string     `json:"stage"`
+	Verb             string     `json:"verb"`
+	User             userInfo   `json:"user"`
+	ImpersonatedUser *userInfo  `json:"impersonatedUser"`
+	SourceIPs        []string   `json:"sourceIPs"`
+	UserAgent        string     `json:"userAgent"`
+	ObjectRef        *objectRef `json:"objectRef"`
+	ResponseStatus   *struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+	RequestReceivedTimestamp string `json:"requestReceivedTimestamp"`
+	StageTimestamp           string `json:"stageTimestamp"`
+
+	// v1 and events.k8s.io/v1 Event
+	Metadata struct {
+		CreationTimestamp string `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Type           string     `json:"type"`
+	Reason         string     `json:"reason"`
+	InvolvedObject *objectRef `json:"involvedObject"`
+	Regarding      *objectRef `json:"regarding"`
+	Source         struct {
+		Component string `json:"component"`
+	} `json:"source"`
+	ReportingComponent      string `json:"reportingComponent"`
+	ReportingController     string `json:"reportingController"`
+	EventTime               string `json:"eventTime"`
+	LastTimestamp           string `json:"lastTimestamp"`
+	DeprecatedLastTimestamp string `json:"deprecatedLastTimestamp"`
+	FirstTimestamp          string `json:"firstTimestamp"`
+}
+
+type userInfo struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+type objectRef struct {
+	Kind        string `json:"kind"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+}
+
+// Parse returns the entry of an audit event, an event, or a watch event
+// carrying either, or an error if data is none of them. Lists are not
+// accepted; the Reader unpacks them.
+func Parse(data []byte) (Entry, error) {
+	var o object
+	if err := json.Unmarshal(data, &o); err != nil {
+		return Entry{}, err
+	}
+	if len(o.Object) > 0 {
+		return Parse(o.Object)
+	}
+	if o.Kind != "Event" {
+		return Entry{}, fmt.Errorf("unsupported kind %q", o.Kind)
+	}
+	if strings.HasPrefix(o.APIVersion, "audit.k8s.io/") {
+		return o.audit()
+	}
+	return o.event()
+}
+
+func (o *object) audit() (Entry, error) {
+	if o.Verb == "" {
+		return Entry{}, errors.New("audit event without a verb")
+	}
+	t, err := firstTime(o.StageTimestamp, o.RequestReceivedTimestamp)
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{
+		Time:      t,
+		Kind:      Audit,
+		Stage:     o.Stage,
+		User:      o.User.Username,
+		Groups:    o.User.Groups,
+		UserAgent: o.UserAgent,
+		Verb:      o.Verb,
+	}
+	if o.ImpersonatedUser != nil {
+		e.Impersonated = o.ImpersonatedUser.Username
+	}
+	for _, ip := range o.SourceIPs {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			e.Source = addr
+			break
+		}
+	}
+	if r := o.ObjectRef; r != nil {
+		e.Resource, e.Subresource, e.Namespace, e.Name = r.Resource, r.Subresource, r.Namespace, r.Name
+	}
+	if o.ResponseStatus != nil {
+		e.Code = o.ResponseStatus.Code
+	}
+	return e, nil
+}
+
+func (o *object) event() (Entry, error) {
+	t, err := firstTime(o.EventTime, o.LastTimestamp, o.DeprecatedLastTimestamp, o.FirstTimestamp, o.Metadata.CreationTimestamp)
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{
+		Time:    t,
+		Kind:    Event,
+		User:    first(o.ReportingController, o.ReportingComponent, o.Source.Component),
+		Reason:  o.Reason,
+		Warning: o.Type == "Warning",
+	}
+	r := o.Regarding
+	if r == nil {
+		r = o.InvolvedObject
+	}
+	if r != nil {
+		e.Resource, e.Namespace, e.Name = strings.ToLower(r.Kind), r.Namespace, r.Name
+	}
+	return e, nil
+}
+
+// firstTime parses the first of the RFC 3339 timestamps that is set.
+func firstTime(timestamps ...string) (time.Time, error) {
+	s := first(timestamps...)
+	if s == "" {
+		return time.Time{}, errors.New("no timestamp")
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package kube
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data string
+		want Entry
+	}{
+		{
+			name: "audit",
+			data: `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete",
+				"requestURI":"/api/v1/namespaces/prod/pods/web-0/exec?command=sh","verb":"create",
+				"user":{"username":"alice","groups":["devs","system:authenticated"]},
+				"impersonatedUser":{"username":"system:serviceaccount:prod:deployer"},
+				"sourceIPs":["not-an-ip","203.0.113.7"],"userAgent":"kubectl/v1.29.0",
+				"objectRef":{"resource":"pods","namespace":"prod","name":"web-0","subresource":"exec","apiVersion":"v1"},
+				"responseStatus":{"metadata":{},"code":101},
+				"requestReceivedTimestamp":"2024-01-02T15:04:04.900000Z","stageTimestamp":"2024-01-02T15:04:05.000000Z"}`,
+			want: Entry{
+				Time: at, Kind: Audit, Stage: StageResponseComplete, User: "alice", Groups: []string{"devs", "system:authenticated"},
+				Impersonated: "system:serviceaccount:prod:deployer", UserAgent: "kubectl/v1.29.0", Source: netip.MustParseAddr("203.0.113.7"),
+				Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "prod", Name: "web-0", Code: 101,
+			},
+		},
+		{
+			name: "audit request received",
+			data: `{"kind":"Event","apiVersion":"audit.k8s.io/v1","stage":"RequestReceived","verb":"list",
+				"user":{"username":"system:anonymous"},"requestReceivedTimestamp":"2024-01-02T15:04:05Z"}`,
+			want: Entry{Time: at, Kind: Audit, Stage: StageRequestReceived, User: "system:anonymous", Verb: "list"},
+		},
+		{
+			name: "core event",
+			data: `{"kind":"Event","apiVersion":"v1","metadata":{"name":"web-0.17a","namespace":"prod","creationTimestamp":"2024-01-02T15:00:00Z"},
+				"involvedObject":{"kind":"Pod","namespace":"prod","name":"web-0"},"reason":"BackOff","type":"Warning",
+				"source":{"component":"kubelet","host":"node-1"},"firstTimestamp":"2024-01-02T15:00:00Z","lastTimestamp":"2024-01-02T15:04:05Z",
+				"eventTime":null,"count":7}`,
+			want: Entry{Time: at, Kind: Event, User: "kubelet", Resource: "pod", Namespace: "prod", Name: "web-0", Reason: "BackOff", Warning: true},
+		},
+		{
+			name: "events.k8s.io event",
+			data: `{"kind":"Event","apiVersion":"events.k8s.io/v1","eventTime":"2024-01-02T15:04:05.000000Z",
+				"reportingController":"default-scheduler","reason":"Scheduled","type":"Normal",
+				"regarding":{"kind":"Pod","namespace":"prod","name":"web-1"}}`,
+			want: Entry{Time: at, Kind: Event, User: "default-scheduler", Resource: "pod", Namespace: "prod", Name: "web-1", Reason: "Scheduled"},
+		},
+		{
+			name: "watch event",
+			data: `{"type":"ADDED","object":{"kind":"Event","apiVersion":"v1","reason":"Killing","type":"Normal",
+				"reportingComponent":"kubelet","lastTimestamp":"2024-01-02T15:04:05Z"}}`,
+			want: Entry{Time: at, Kind: Event, User: "kubelet", Reason: "Killing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse([]byte(tt.data))
+			require.NoError(t, err)
+			assert.True(t, tt.want.Time.Equal(e.Time), "time %s", e.Time)
+			e.Time = tt.want.Time
+			assert.Equal(t, tt.want, e)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`42`,
+		`{"kind":"Pod","apiVersion":"v1"}`,
+		`{"kind":"Event","apiVersion":"audit.k8s.io/v1","stageTimestamp":"2024-01-02T15:04:05Z"}`,
+		`{"kind":"Event","apiVersion":"audit.k8s.io/v1","verb":"get"}`,
+		`{"kind":"Event","apiVersion":"v1","reason":"BackOff","lastTimestamp":"yesterday"}`,
+		`{"kind":"Event","apiVersion":"v1","reason":7,"lastTimestamp":"2024-01-02T15:04:05Z"}`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
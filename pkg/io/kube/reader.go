@@ -0,0 +1,192 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Reader reads the audited requests and events of a stream of JSON values,
+// as entries or feature vectors. Values and list items that are not audit
+// events or events are skipped; a JSON syntax error ends the stream.
+type Reader struct {
+	closer    io.Closer
+	decoder   *json.Decoder
+	stages    []string
+	extractor *Extractor
+	pending   []Entry // entries of the last list, not yet returned
+	readErr   error
+	skipped   atomic.Int64
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// NewReader creates a Reader of r. Closing the Reader does not close r.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	c := newConfig(opts)
+	return &Reader{decoder: json.NewDecoder(r), stages: c.stages, extractor: NewExtractor(opts...)}
+}
+
+// NewFileReader creates a Reader of the file filename.
+func NewFileReader(filename string, opts ...Option) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(file, opts...)
+	r.closer = file
+	return r, nil
+}
+
+// ReadEntries returns all remaining entries.
+func (r *Reader) ReadEntries() ([]Entry, error) {
+	var entries []Entry
+	for {
+		e, ok := r.next()
+		if !ok {
+			return entries, r.readErr
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Read returns the feature vectors of all remaining entries.
+func (r *Reader) Read() ([][]float64, error) {
+	var data [][]float64
+	for {
+		e, ok := r.next()
+		if !ok {
+			return data, r.readErr
+		}
+		data = append(data, r.extractor.Extract(e))
+	}
+}
+
+// Stream returns a channel of the feature vectors of the entries, closed
+// at the end of the input, on a read error or when ctx is done. Read the
+// audit log through a pipe, such as the output of tail -F, or the output
+// of kubectl get events --watch -o json to follow it.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(e Entry, _ uint64) []float64 {
+		return r.extractor.Extract(e)
+	}), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the time
+// of its entry and its sequence number among the entries emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(e Entry, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: r.extractor.Extract(e), Time: e.Time, Seq: seq}
+	}), nil
+}
+
+// StreamEntries is Stream emitting the entries themselves.
+func (r *Reader) StreamEntries(ctx context.Context) (<-chan Entry, error) {
+	return stream(ctx, r, func(e Entry, _ uint64) Entry { return e }), nil
+}
+
+// stream emits wrap(entry, seq) for every entry until the end of the
+// input, a read error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(e Entry, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			e, ok := r.next()
+			if !ok {
+				r.setErr(r.readErr)
+				return
+			}
+			select {
+			case out <- wrap(e, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// next returns the next entry of a kept stage, unpacking lists and
+// skipping values that cannot be parsed, and false at the end of the
+// input or on a read error.
+func (r *Reader) next() (Entry, bool) {
+	for {
+		for len(r.pending) > 0 {
+			e := r.pending[0]
+			r.pending = r.pending[1:]
+			if r.keep(e) {
+				return e, true
+			}
+		}
+
+		var raw json.RawMessage
+		if err := r.decoder.Decode(&raw); err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.readErr = err
+			}
+			return Entry{}, false
+		}
+
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		values := []json.RawMessage{raw}
+		if json.Unmarshal(raw, &list) == nil && list.Items != nil {
+			values = list.Items
+		}
+		for _, v := range values {
+			e, err := Parse(v)
+			if err != nil {
+				r.skipped.Add(1)
+				continue
+			}
+			r.pending = append(r.pending, e)
+		}
+	}
+}
+
+// keep reports whether e is an event or an audit event of a kept stage.
+func (r *Reader) keep(e Entry) bool {
+	return e.Kind == Event || e.Stage == "" || slices.Contains(r.stages, e.Stage)
+}
+
+// Skipped returns the number of values and list items skipped so far
+// because they could not be parsed. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the read error that stopped a stream early, if any. It is
+// only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close releases resources.
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package kube
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditLog has a request recorded at two stages, a webhook batch of two
+// requests, an event, a list item and a line that are not entries.
+const auditLog = `{"kind":"Event","apiVersion":"audit.k8s.io/v1","stage":"RequestReceived","verb":"get","user":{"username":"alice"},"userAgent":"kubectl/v1.29.0","objectRef":{"resource":"pods","namespace":"prod"},"stageTimestamp":"2024-01-02T15:04:05Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","stage":"ResponseComplete","verb":"get","user":{"username":"alice"},"userAgent":"kubectl/v1.29.0","objectRef":{"resource":"pods","namespace":"prod"},"responseStatus":{"code":200},"stageTimestamp":"2024-01-02T15:04:05Z"}
+{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[
+  {"kind":"Event","apiVersion":"audit.k8s.io/v1","stage":"ResponseComplete","verb":"list","user":{"username":"alice"},"userAgent":"kubectl/v1.29.0","objectRef":{"resource":"secrets"},"responseStatus":{"code":403},"stageTimestamp":"2024-01-02T15:04:35Z"},
+  {"kind":"Event","apiVersion":"audit.k8s.io/v1","stage":"ResponseComplete","verb":"create","user":{"username":"system:anonymous","groups":["system:unauthenticated"]},"userAgent":"curl/8.0","objectRef":{"resource":"clusterrolebindings"},"responseStatus":{"code":201},"stageTimestamp":"2024-01-02T15:05:30Z"},
+  {"kind":"Status"}
+]}
+{"kind":"Event","apiVersion":"v1","reason":"BackOff","type":"Warning","source":{"component":"kubelet"},"involvedObject":{"kind":"Pod"},"lastTimestamp":"2024-01-02T15:05:40Z"}
+"garbage"
+`
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte(auditLog), 0o600))
+
+	r, err := NewFileReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := r.Read()
+	require.NoError(t, err)
+	require.Len(t, data, 4, "the RequestReceived stage is not kept")
+	assert.Equal(t, 2, r.Skipped())
+
+	assert.Equal(t, []float64{0, 0, 2, 0, 1, 0, 0, 0}, data[0])
+	assert.Equal(t, []float64{0, 1, 4, math.Log10(2.0 / 2.0), 2, 0, 0, 0}, data[1])
+	assert.Equal(t, []float64{1, 2, 2, math.Log10(3.0 / 1.0), 1, 1, 0, 0}, data[2])
+	assert.Equal(t, []float64{0, 0, 0, math.Log10(4.0 / 1.0), 1, 0, 0, 1}, data[3])
+}
+
+func TestStages(t *testing.T) {
+	entries, err := NewReader(strings.NewReader(auditLog), WithStages(StageRequestReceived)).ReadEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, StageRequestReceived, entries[0].Stage)
+	assert.Equal(t, Event, entries[1].Kind)
+}
+
+func TestStream(t *testing.T) {
+	r := NewReader(strings.NewReader(auditLog+`{"kind":`), WithRateWindow(2*time.Minute))
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+
+	var rates []float64
+	var last time.Time
+	for s := range samples {
+		rates = append(rates, s.Features[4])
+		last = s.Time
+		assert.Len(t, s.Features, len(FeatureNames))
+	}
+	assert.Equal(t, []float64{0.5, 1, 0.5, 0.5}, rates, "entries per minute over two minutes")
+	assert.Equal(t, time.Date(2024, time.January, 2, 15, 5, 40, 0, time.UTC), last.UTC())
+	assert.Error(t, r.Err(), "the truncated value stops the stream")
+	assert.NoError(t, r.Close())
+}
+
+func TestStreamEntries(t *testing.T) {
+	r := NewReader(strings.NewReader(auditLog))
+	entries, err := r.StreamEntries(context.Background())
+	require.NoError(t, err)
+
+	var users []string
+	for e := range entries {
+		users = append(users, e.User)
+	}
+	assert.Equal(t, []string{"alice", "alice", "system:anonymous", "kubelet"}, users)
+	assert.NoError(t, r.Err())
+}
+
+func TestWatchOutput(t *testing.T) {
+	// kubectl get events --watch -o json writes indented objects.
+	watch := `{
+    "type": "ADDED",
+    "object": {
+        "kind": "Event",
+        "apiVersion": "v1",
+        "reason": "Pulled",
+        "reportingComponent": "kubelet",
+        "lastTimestamp": "2024-01-02T15:04:05Z"
+    }
+}
+{
+    "type": "MODIFIED",
+    "object": {
+        "kind": "Event",
+        "apiVersion": "v1",
+        "reason": "Started",
+        "reportingComponent": "kubelet",
+        "lastTimestamp": "2024-01-02T15:04:06Z"
+    }
+}
+`
+	entries, err := NewReader(strings.NewReader(watch)).ReadEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Started", entries[1].Reason)
+}
+
+func TestMaxPrincipalsAgents(t *testing.T) {
+	x := NewExtractor(WithMaxPrincipals(2), WithMaxAgents(1))
+	at := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+	for i, user := range []string{"a", "b", "c", "d", "e"} {
+		x.Extract(Entry{Time: at.Add(time.Duration(i) * time.Second), User: user, UserAgent: user})
+	}
+	assert.Len(t, x.principals, 2)
+	assert.Contains(t, x.principals, "e")
+	assert.Equal(t, map[string]int{"a": 1}, x.agents)
+	assert.Equal(t, 1.0, x.Extract(Entry{Time: at})[4], "entries without a principal count alone")
+}
+
+func TestClasses(t *testing.T) {
+	assert.Equal(t, 0.0, verbClass("watch"))
+	assert.Equal(t, 1.0, verbClass("patch"))
+	assert.Equal(t, 2.0, verbClass("deletecollection"))
+	assert.Equal(t, 3.0, verbClass("impersonate"))
+	assert.Equal(t, 3.0, sensitivity("nodes", "proxy"))
+	assert.Equal(t, 2.0, sensitivity("roles", ""))
+	assert.Equal(t, 1.0, sensitivity("serviceaccounts", "token"))
+	assert.Equal(t, 0.0, sensitivity("serviceaccounts", ""))
+	assert.Equal(t, 0.0, sensitivity("pods", "log"))
+}
+
+func TestNewFileReaderMissing(t *testing.T) {
+	_, err := NewFileReader(filepath.Join(t.TempDir(), "missing.log"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+// Package mqtt subscribes to MQTT topics and turns JSON telemetry into
+// feature vectors, for industrial and IoT deployments where a broker,
+// not packet capture, carries the data.
+//
+// The client speaks MQTT 3.1.1 over TCP or TLS, subscribes at QoS 0 or
+// 1, and keeps the connection alive with pings. Topic filters may use the
+// + and # wildcards.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Control packet types.
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetSubscribe  = 8
+	packetSubAck     = 9
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// connectErrors explains the CONNACK return codes.
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// conn frames control packets over a network connection. Writes are
+// serialized, as the keep-alive pings come from their own goroutine.
+type conn struct {
+	net.Conn
+	r         *bufio.Reader
+	maxPacket int
+	wmu       sync.Mutex
+}
+
+func newConn(c net.Conn, maxPacket int) *conn {
+	return &conn{Conn: c, r: bufio.NewReader(c), maxPacket: maxPacket}
+}
+
+// writePacket sends a control packet of type typ with the flags of its
+// fixed header and body.
+func (c *conn) writePacket(typ, flags byte, body []byte) error {
+	b := make([]byte, 0, 5+len(body))
+	b = append(b, typ<<4|flags)
+	for n := len(body); ; {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	b = append(b, body...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(b)
+	return err
+}
+
+// readPacket receives a control packet.
+func (c *conn) readPacket() (typ, flags byte, body []byte, err error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		digit, err := c.r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(digit&0x7f) * mult
+		mult *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	if n > c.maxPacket {
+		return 0, 0, nil, fmt.Errorf("mqtt: packet of %d bytes exceeds the limit of %d", n, c.maxPacket)
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// connectPacket builds the body of a CONNECT packet for a clean session.
+func connectPacket(clientID, user, password string, keepAliveSecs uint16) []byte {
+	const cleanSession, passwordFlag, userFlag = 0x02, 0x40, 0x80
+	flags := byte(cleanSession)
+	if user != "" {
+		flags |= userFlag
+		if password != "" {
+			flags |= passwordFlag
+		}
+	}
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags) // protocol level 3.1.1
+	b = binary.BigEndian.AppendUint16(b, keepAliveSecs)
+	b = appendString(b, clientID)
+	if flags&userFlag != 0 {
+		b = appendString(b, user)
+	}
+	if flags&passwordFlag != 0 {
+		b = appendString(b, password)
+	}
+	return b
+}
+
+// subscribePacket builds the body of a SUBSCRIBE packet for filters at
+// qos.
+func subscribePacket(id uint16, filters []string, qos byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		b = appendString(b, f)
+		b = append(b, qos)
+	}
+	return b
+}
+
+// publish is a received PUBLISH packet.
+type publish struct {
+	topic    string
+	qos      byte
+	retained bool
+	id       uint16
+	payload  []byte
+}
+
+func parsePublish(flags byte, body []byte) (publish, error) {
+	p := publish{qos: flags >> 1 & 0x03, retained: flags&0x01 != 0}
+	if len(body) < 2 {
+		return p, errors.New("mqtt: short publish")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	if len(body) < n {
+		return p, errors.New("mqtt: short publish topic")
+	}
+	p.topic, body = string(body[:n]), body[n:]
+	if p.qos > 0 {
+		if len(body) < 2 {
+			return p, errors.New("mqtt: publish without packet identifier")
+		}
+		p.id, body = binary.BigEndian.Uint16(body), body[2:]
+	}
+	p.payload = body
+	return p, nil
+}
+
+// ValidFilter reports whether filter is a valid topic filter: + must
+// fill a whole level and # must be the whole last level.
+func ValidFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return false
+		case level != "#" && level != "+" && strings.ContainsAny(level, "#+"):
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether topic matches filter, following the wildcard
+// rules of MQTT: + matches one level, # any number of trailing levels,
+// and wildcards at the first level do not match topics starting with $.
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
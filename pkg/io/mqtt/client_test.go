@@ -0,0 +1,80 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"plant/line1/temp", "plant/line1/temp", true},
+		{"plant/line1/temp", "plant/line2/temp", false},
+		{"plant/+/temp", "plant/line2/temp", true},
+		{"plant/+/temp", "plant/line2/motor/temp", false},
+		{"plant/#", "plant", true},
+		{"plant/#", "plant/line1/motor/rpm", true},
+		{"+/+", "plant/line1", true},
+		{"+", "plant/line1", false},
+		{"#", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.filter, tt.topic), "%s ~ %s", tt.filter, tt.topic)
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	for _, f := range []string{"a/b", "a/+/c", "a/#", "#", "+", "/"} {
+		assert.True(t, ValidFilter(f), f)
+	}
+	for _, f := range []string{"", "a/#/c", "a/b#", "a+/b"} {
+		assert.False(t, ValidFilter(f), f)
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c, s := newConn(client, 1<<20), newConn(server, 200)
+
+	payload := make([]byte, 150) // a two byte remaining length
+	body := append(appendString(nil, "a/b"), payload...)
+	go c.writePacket(packetPublish, 0x01, body)
+	typ, flags, got, err := s.readPacket()
+	require.NoError(t, err)
+	assert.Equal(t, byte(packetPublish), typ)
+	p, err := parsePublish(flags, got)
+	require.NoError(t, err)
+	assert.Equal(t, "a/b", p.topic)
+	assert.True(t, p.retained)
+	assert.Len(t, p.payload, 150)
+
+	go c.writePacket(packetPublish, 0, make([]byte, 300))
+	_, _, _, err = s.readPacket()
+	assert.ErrorContains(t, err, "exceeds the limit")
+}
+
+func TestFlatten(t *testing.T) {
+	leaves, err := Flatten([]byte(`{"motor":{"rpm":1200,"on":true},"phases":[1.5,2],"id":"m1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"motor.rpm": json.Number("1200"),
+		"motor.on":  true,
+		"phases.0":  json.Number("1.5"),
+		"phases.1":  json.Number("2"),
+		"id":        "m1",
+	}, leaves)
+
+	_, err = Flatten([]byte(`[1,2]`))
+	assert.Error(t, err)
+	_, err = Flatten([]byte(`{`))
+	assert.Error(t, err)
+}
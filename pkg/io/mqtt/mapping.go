@@ -0,0 +1,27 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// Flatten decodes a JSON payload into its scalar leaves by dot-separated
+// path, as guardio.Flatten does. Numbers are kept as json.Number, strings,
+// booleans and nulls as decoded.
+func Flatten(payload []byte) (map[string]any, error) {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("mqtt: payload: %w", err)
+	}
+	record, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("mqtt: payload is not a JSON object")
+	}
+	return guardio.Flatten(record), nil
+}
@@ -0,0 +1,481 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// Message is a telemetry message mapped to features.
+type Message struct {
+	// Time is when the message was received, or the time its payload
+	// carries (see WithTimeField).
+	Time     time.Time
+	Topic    string
+	Retained bool
+	Features []float64
+}
+
+// Option configures a Reader.
+type Option func(*config)
+
+type config struct {
+	clientID    string
+	user        string
+	password    string
+	tls         *tls.Config
+	keepAlive   time.Duration
+	timeout     time.Duration
+	qos         byte
+	retained    bool
+	fields      []string
+	timeField   string
+	maxPacket   int
+	maxMessages int
+	maxDuration time.Duration
+}
+
+// WithClientID sets the client identifier. Defaults to a random
+// goguardml-<hex> one.
+func WithClientID(id string) Option {
+	return func(c *config) {
+		c.clientID = id
+	}
+}
+
+// WithCredentials authenticates with a user name and password.
+func WithCredentials(user, password string) Option {
+	return func(c *config) {
+		c.user, c.password = user, password
+	}
+}
+
+// WithTLS sets the TLS configuration of ssl://, tls:// and mqtts://
+// brokers.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tls = cfg
+	}
+}
+
+// WithKeepAlive sets the keep-alive interval announced to the broker. The
+// Reader pings it at half that interval, and gives up on a broker silent
+// for twice as long. Defaults to 30 seconds.
+func WithKeepAlive(d time.Duration) Option {
+	return func(c *config) {
+		c.keepAlive = d
+	}
+}
+
+// WithTimeout bounds connecting and subscribing. Defaults to ten seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithQoS sets the quality of service subscribed at: 0, at most once, or
+// 1, at least once. Defaults to 0.
+func WithQoS(qos byte) Option {
+	return func(c *config) {
+		c.qos = min(qos, 1)
+	}
+}
+
+// WithRetained keeps the retained messages the broker sends on
+// subscribing, which may be arbitrarily old. They are skipped by default.
+func WithRetained(keep bool) Option {
+	return func(c *config) {
+		c.retained = keep
+	}
+}
+
+// WithFields sets the payload fields features are read from, in order, as
+// dot-separated paths into the JSON object, array elements by index:
+// "temp", "motor.rpm", "phases.0". Messages missing one are skipped. By
+// default the fields are the numeric and boolean leaves of the first
+// message mapped, in lexical order.
+func WithFields(paths ...string) Option {
+	return func(c *config) {
+		c.fields = paths
+	}
+}
+
+// WithTimeField stamps messages with the time in a payload field, an RFC
+// 3339 string or seconds since the epoch, instead of the time they were
+// received. Messages whose field is missing keep the receive time.
+func WithTimeField(path string) Option {
+	return func(c *config) {
+		c.timeField = path
+	}
+}
+
+// WithMaxPacket bounds the size of the packets accepted from the broker.
+// Defaults to 1 MiB.
+func WithMaxPacket(n int) Option {
+	return func(c *config) {
+		c.maxPacket = n
+	}
+}
+
+// WithMaxMessages makes Read stop after n messages. Values below 1 mean
+// no limit.
+func WithMaxMessages(n int) Option {
+	return func(c *config) {
+		c.maxMessages = n
+	}
+}
+
+// WithMaxDuration makes Read stop d after it starts. Values below 1 mean
+// no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(c *config) {
+		c.maxDuration = d
+	}
+}
+
+// Reader receives the messages published to a set of topic filters.
+type Reader struct {
+	conn    *conn
+	filters []string
+	cfg     config
+	mapper  *guardio.FieldMapper
+	skipped atomic.Int64
+	done    chan struct{}
+	closed  sync.Once
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// NewReader connects to broker, a URL such as tcp://host:1883 or
+// mqtts://host:8883 (mqtt://, ssl:// and tls:// are accepted too), and
+// subscribes to filters.
+func NewReader(ctx context.Context, broker string, filters []string, opts ...Option) (*Reader, error) {
+	cfg := config{keepAlive: 30 * time.Second, timeout: 10 * time.Second, maxPacket: 1 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.clientID == "" {
+		cfg.clientID = randomClientID()
+	}
+	if len(filters) == 0 {
+		return nil, errors.New("mqtt: no topic filters")
+	}
+	for _, f := range filters {
+		if !ValidFilter(f) {
+			return nil, fmt.Errorf("mqtt: invalid topic filter %q", f)
+		}
+	}
+
+	c, err := dial(ctx, broker, cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{conn: c, filters: filters, cfg: cfg, mapper: guardio.NewFieldMapper(cfg.fields, cfg.timeField), done: make(chan struct{})}
+	if err := r.handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if cfg.keepAlive > 0 {
+		go r.ping()
+	}
+	return r, nil
+}
+
+func randomClientID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "goguardml-" + hex.EncodeToString(b)
+}
+
+// dial opens the network connection to broker.
+func dial(ctx context.Context, broker string, cfg config) (*conn, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: broker: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	var c net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		var d net.Dialer
+		c, err = d.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		d := tls.Dialer{Config: cfg.tls}
+		c, err = d.DialContext(ctx, "tcp", hostPort(u, "8883"))
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	return newConn(c, cfg.maxPacket), nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+// handshake connects and subscribes, within the timeout.
+func (r *Reader) handshake() error {
+	c := r.conn
+	c.SetDeadline(time.Now().Add(r.cfg.timeout))
+	defer c.SetDeadline(time.Time{})
+
+	keepAlive := uint16(min(r.cfg.keepAlive/time.Second, 65535))
+	if err := c.writePacket(packetConnect, 0, connectPacket(r.cfg.clientID, r.cfg.user, r.cfg.password, keepAlive)); err != nil {
+		return fmt.Errorf("mqtt: connect: %w", err)
+	}
+	typ, _, body, err := c.readPacket()
+	if err != nil {
+		return fmt.Errorf("mqtt: connect: %w", err)
+	}
+	if typ != packetConnAck || len(body) != 2 {
+		return errors.New("mqtt: connect: broker did not acknowledge")
+	}
+	if code := body[1]; code != 0 {
+		if reason, ok := connectErrors[code]; ok {
+			return fmt.Errorf("mqtt: connect refused: %s", reason)
+		}
+		return fmt.Errorf("mqtt: connect refused: code %d", code)
+	}
+
+	const subscribeID = 1
+	if err := c.writePacket(packetSubscribe, 0x02, subscribePacket(subscribeID, r.filters, r.cfg.qos)); err != nil {
+		return fmt.Errorf("mqtt: subscribe: %w", err)
+	}
+	typ, _, body, err = c.readPacket()
+	if err != nil {
+		return fmt.Errorf("mqtt: subscribe: %w", err)
+	}
+	if typ != packetSubAck || len(body) != 2+len(r.filters) || binary.BigEndian.Uint16(body) != subscribeID {
+		return errors.New("mqtt: subscribe: broker did not acknowledge")
+	}
+	for i, code := range body[2:] {
+		if code == 0x80 {
+			return fmt.Errorf("mqtt: subscribe: broker refused %q", r.filters[i])
+		}
+	}
+	return nil
+}
+
+// ping keeps the connection alive until the Reader is closed.
+func (r *Reader) ping() {
+	ticker := time.NewTicker(r.cfg.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if r.conn.writePacket(packetPingReq, 0, nil) != nil {
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// next returns the next message mapped to features, skipping retained
+// messages unless kept, messages to topics no filter matches and
+// payloads that do not map.
+func (r *Reader) next() (Message, error) {
+	for {
+		if r.cfg.keepAlive > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(2 * r.cfg.keepAlive))
+		}
+		typ, flags, body, err := r.conn.readPacket()
+		if err != nil {
+			return Message{}, err
+		}
+		if typ != packetPublish {
+			continue // ping responses
+		}
+		p, err := parsePublish(flags, body)
+		if err != nil {
+			return Message{}, err
+		}
+		if p.qos == 1 {
+			if err := r.conn.writePacket(packetPubAck, 0, binary.BigEndian.AppendUint16(nil, p.id)); err != nil {
+				return Message{}, err
+			}
+		}
+		if p.retained && !r.cfg.retained || !r.matches(p.topic) {
+			continue
+		}
+
+		m := Message{Time: time.Now(), Topic: p.topic, Retained: p.retained}
+		leaves, err := Flatten(p.payload)
+		var t time.Time
+		if err == nil {
+			m.Features, t, err = r.mapper.Map(leaves)
+		}
+		if err != nil {
+			r.skipped.Add(1)
+			continue
+		}
+		if !t.IsZero() {
+			m.Time = t
+		}
+		return m, nil
+	}
+}
+
+func (r *Reader) matches(topic string) bool {
+	for _, f := range r.filters {
+		if Match(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// FeatureNames returns the payload fields features are read from, nil
+// until the first message is mapped if they are inferred.
+func (r *Reader) FeatureNames() []string {
+	return r.mapper.Fields()
+}
+
+// Read returns the feature vectors of the messages received until the
+// message or duration limit is reached. As a subscription never ends on
+// its own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.cfg.maxMessages < 1 && r.cfg.maxDuration < 1 {
+		return nil, errors.New("mqtt: subscription needs a message or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	if r.cfg.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.maxDuration)
+		defer cancel()
+	}
+	stop := r.interruptOn(ctx)
+	defer stop()
+
+	var data [][]float64
+	for r.cfg.maxMessages < 1 || len(data) < r.cfg.maxMessages {
+		m, err := r.next()
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.cfg.maxDuration > 0 {
+				return data, nil
+			}
+			if ctx.Err() != nil {
+				return data, ctx.Err()
+			}
+			return data, err
+		}
+		data = append(data, m.Features)
+	}
+	return data, nil
+}
+
+// interruptOn unblocks a pending read when ctx is done. Calling the
+// returned function stops watching ctx.
+func (r *Reader) interruptOn(ctx context.Context) func() bool {
+	return context.AfterFunc(ctx, func() {
+		r.conn.SetReadDeadline(time.Unix(1, 0))
+	})
+}
+
+// Stream returns a channel of the feature vectors of the messages
+// received, closed on a connection error or when ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(m Message, _ uint64) []float64 { return m.Features }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the time
+// of its message and its sequence number among the messages emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(m Message, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: m.Features, Time: m.Time, Seq: seq}
+	}), nil
+}
+
+// StreamMessages is Stream emitting the messages themselves, which name
+// their topic.
+func (r *Reader) StreamMessages(ctx context.Context) (<-chan Message, error) {
+	return stream(ctx, r, func(m Message, _ uint64) Message { return m }), nil
+}
+
+// stream emits wrap(message, seq) for every message until a connection
+// error or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(m Message, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		stop := r.interruptOn(ctx)
+		defer stop()
+		for seq := uint64(1); ; seq++ {
+			m, err := r.next()
+			if err != nil {
+				if ctx.Err() == nil {
+					r.setErr(err)
+				}
+				return
+			}
+			select {
+			case out <- wrap(m, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Skipped returns the number of messages skipped so far because their
+// payload did not map to features. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the connection error that stopped a stream, if any. It is
+// only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close disconnects from the broker.
+func (r *Reader) Close() error {
+	var err error
+	r.closed.Do(func() {
+		close(r.done)
+		r.conn.writePacket(packetDisconnect, 0, nil)
+		err = r.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,230 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// message is a PUBLISH a fakeBroker sends.
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  string
+}
+
+// fakeBroker accepts one client, acknowledges its connection with code
+// connAck and its subscription, sends it messages, and then holds the
+// connection open until the client disconnects, recording the packets it
+// receives.
+type fakeBroker struct {
+	addr     string
+	received chan byte
+	connect  chan []byte
+}
+
+func startBroker(t *testing.T, connAck byte, messages ...message) *fakeBroker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	b := &fakeBroker{addr: "tcp://" + l.Addr().String(), received: make(chan byte, 100), connect: make(chan []byte, 1)}
+
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		c := newConn(nc, 1<<20)
+		_, _, body, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		b.connect <- body
+		c.writePacket(packetConnAck, 0, []byte{0, connAck})
+		if connAck != 0 {
+			return
+		}
+		_, _, body, err = c.readPacket()
+		if err != nil {
+			return
+		}
+		ack := append([]byte(nil), body[:2]...)
+		for rest := body[2:]; len(rest) > 0; {
+			n := int(binary.BigEndian.Uint16(rest))
+			filter := string(rest[2 : 2+n])
+			code := rest[2+n]
+			if filter == "forbidden/#" {
+				code = 0x80
+			}
+			ack = append(ack, code)
+			rest = rest[3+n:]
+		}
+		c.writePacket(packetSubAck, 0, ack)
+
+		for i, m := range messages {
+			flags := m.qos << 1
+			if m.retained {
+				flags |= 0x01
+			}
+			body := appendString(nil, m.topic)
+			if m.qos > 0 {
+				body = binary.BigEndian.AppendUint16(body, uint16(i+1))
+			}
+			c.writePacket(packetPublish, flags, append(body, m.payload...))
+		}
+		for {
+			typ, _, _, err := c.readPacket()
+			if err != nil {
+				return
+			}
+			b.received <- typ
+			if typ == packetPingReq {
+				c.writePacket(packetPingResp, 0, nil)
+			}
+		}
+	}()
+	return b
+}
+
+func telemetry() []message {
+	return []message{
+		{topic: "plant/line1/motor", retained: true, payload: `{"rpm":1}`},
+		{topic: "plant/line1/motor", payload: `{"rpm":1200,"temp":61.5,"ts":"2024-05-01T10:00:00Z"}`},
+		{topic: "plant/line1/motor", payload: `not json`},
+		{topic: "plant/line2/motor", qos: 1, payload: `{"rpm":900,"temp":"58"}`},
+		{topic: "office/printer", payload: `{"rpm":5,"temp":20}`},
+		{topic: "plant/line1/motor", payload: `{"rpm":1300}`},
+	}
+}
+
+func TestStreamMessages(t *testing.T) {
+	b := startBroker(t, 0, telemetry()...)
+	r, err := NewReader(context.Background(), b.addr, []string{"plant/+/motor"},
+		WithClientID("sensor-1"), WithCredentials("user", "secret"), WithQoS(1),
+		WithFields("rpm", "temp"), WithTimeField("ts"))
+	require.NoError(t, err)
+	assert.Contains(t, string(<-b.connect), "sensor-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := r.StreamMessages(ctx)
+	require.NoError(t, err)
+
+	first := <-messages
+	assert.Equal(t, "plant/line1/motor", first.Topic, "the retained message is skipped")
+	assert.Equal(t, []float64{1200, 61.5}, first.Features)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), first.Time)
+
+	second := <-messages
+	assert.Equal(t, "plant/line2/motor", second.Topic)
+	assert.Equal(t, []float64{900, 58}, second.Features)
+	assert.Equal(t, byte(packetPubAck), <-b.received)
+
+	assert.Eventually(t, func() bool { return r.Skipped() == 2 }, time.Second, time.Millisecond,
+		"the malformed payload and the one lacking temp")
+	assert.Equal(t, []string{"rpm", "temp"}, r.FeatureNames())
+
+	cancel()
+	for range messages {
+	}
+	assert.NoError(t, r.Err())
+	require.NoError(t, r.Close())
+	assert.Equal(t, byte(packetDisconnect), <-b.received)
+}
+
+func TestRead(t *testing.T) {
+	b := startBroker(t, 0, telemetry()...)
+	r, err := NewReader(context.Background(), b.addr, []string{"plant/#", "office/+"},
+		WithRetained(true), WithMaxMessages(3))
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}, {1200}, {900}}, data, "fields inferred from the retained message")
+	assert.Equal(t, []string{"rpm"}, r.FeatureNames())
+}
+
+func TestReadLimits(t *testing.T) {
+	b := startBroker(t, 0)
+	r, err := NewReader(context.Background(), b.addr, []string{"#"})
+	require.NoError(t, err)
+	_, err = r.Read()
+	assert.Error(t, err, "a subscription never ends without a limit")
+	r.Close()
+
+	b = startBroker(t, 0)
+	r, err = NewReader(context.Background(), b.addr, []string{"#"}, WithMaxDuration(20*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := r.Read()
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestReadContextCancel(t *testing.T) {
+	b := startBroker(t, 0)
+	r, err := NewReader(context.Background(), b.addr, []string{"#"})
+	require.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = r.ReadContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestKeepAlive(t *testing.T) {
+	b := startBroker(t, 0)
+	r, err := NewReader(context.Background(), b.addr, []string{"#"}, WithKeepAlive(20*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = r.ReadContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "ping responses keep the connection alive")
+	assert.Equal(t, byte(packetPingReq), <-b.received)
+}
+
+func TestStreamError(t *testing.T) {
+	b := startBroker(t, 0, message{topic: "a", payload: `{"x":1}`})
+	r, err := NewReader(context.Background(), b.addr, []string{"a"})
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := r.Stream(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []float64{1}, <-data)
+	// Losing the connection underneath the stream ends it with an error.
+	r.conn.Close()
+	for range data {
+	}
+	assert.Error(t, r.Err())
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewReader(ctx, "tcp://127.0.0.1:1", nil)
+	assert.EqualError(t, err, "mqtt: no topic filters")
+	_, err = NewReader(ctx, "tcp://127.0.0.1:1", []string{"a/#/b"})
+	assert.EqualError(t, err, `mqtt: invalid topic filter "a/#/b"`)
+	_, err = NewReader(ctx, "ws://127.0.0.1:1", []string{"a"})
+	assert.EqualError(t, err, `mqtt: unsupported broker scheme "ws"`)
+
+	b := startBroker(t, 4)
+	_, err = NewReader(ctx, b.addr, []string{"a"})
+	assert.EqualError(t, err, "mqtt: connect refused: bad user name or password")
+
+	b = startBroker(t, 0)
+	_, err = NewReader(ctx, b.addr, []string{"a", "forbidden/#"})
+	assert.EqualError(t, err, `mqtt: subscribe: broker refused "forbidden/#"`)
+}
@@ -0,0 +1,291 @@
+package io
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// MultiReader reads several Readers concurrently as one, such as a
+// directory of rotated captures or the shards of a CSV export.
+//
+// Read reads every source at once and concatenates their datasets in
+// source order, which is time order for rotated files given in order.
+// Streams merge the samples of all sources by capture time: each source
+// is expected to be in time order, and the merge emits the earliest
+// pending sample once every open source has one ready, samples without a
+// time first. Sources that stall, such as live captures, hold the merge
+// back; WithArrivalOrder forwards samples as they arrive instead. Merged
+// samples are renumbered from 1.
+type MultiReader struct {
+	readers     []Reader
+	arrival     bool
+	concurrency int
+}
+
+// MultiOption configures a MultiReader.
+type MultiOption func(*MultiReader)
+
+// WithArrivalOrder makes streams forward samples as the sources produce
+// them rather than in time order.
+func WithArrivalOrder() MultiOption {
+	return func(m *MultiReader) {
+		m.arrival = true
+	}
+}
+
+// WithConcurrency bounds the number of sources Read reads at once.
+// Defaults to GOMAXPROCS. Streams always read all sources at once.
+func WithConcurrency(n int) MultiOption {
+	return func(m *MultiReader) {
+		m.concurrency = n
+	}
+}
+
+// NewMultiReader returns a Reader of all of readers. Closing it closes
+// them.
+func NewMultiReader(readers []Reader, opts ...MultiOption) *MultiReader {
+	m := &MultiReader{readers: append([]Reader(nil), readers...)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.concurrency < 1 {
+		m.concurrency = runtime.GOMAXPROCS(0)
+	}
+	return m
+}
+
+// Readers returns the sources, in order.
+func (m *MultiReader) Readers() []Reader {
+	return m.readers
+}
+
+// Read reads the sources concurrently and returns their datasets one after
+// the other, in source order. It fails with the error of the first source
+// that fails.
+func (m *MultiReader) Read() ([][]float64, error) {
+	results := make([][][]float64, len(m.readers))
+	errs := make([]error, len(m.readers))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, m.concurrency)
+	for i, r := range m.readers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = r.Read()
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("source %d: %w", i, err)
+		}
+		total += len(results[i])
+	}
+	data := make([][]float64, 0, total)
+	for _, rows := range results {
+		data = append(data, rows...)
+	}
+	return data, nil
+}
+
+// Stream returns the features of StreamSamples.
+func (m *MultiReader) Stream(ctx context.Context) (<-chan []float64, error) {
+	samples, err := m.StreamSamples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []float64, cap(samples))
+	go func() {
+		defer close(out)
+		for s := range samples {
+			select {
+			case out <- s.Features:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// StreamSamples streams all sources at once and merges their samples. The
+// channel is closed once every source stream is, or ctx is done.
+func (m *MultiReader) StreamSamples(ctx context.Context) (<-chan Sample, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	in := make([]<-chan Sample, len(m.readers))
+	for i, r := range m.readers {
+		ch, err := r.StreamSamples(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("source %d: %w", i, err)
+		}
+		in[i] = ch
+	}
+
+	out := make(chan Sample, 100)
+	go func() {
+		defer cancel()
+		defer close(out)
+		if m.arrival {
+			fanIn(ctx, in, out)
+		} else {
+			mergeByTime(ctx, in, out)
+		}
+	}()
+	return out, nil
+}
+
+// fanIn forwards the samples of in to out as they arrive.
+func fanIn(ctx context.Context, in []<-chan Sample, out chan<- Sample) {
+	merged := make(chan Sample)
+	var wg sync.WaitGroup
+	for _, ch := range in {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range ch {
+				select {
+				case merged <- s:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	seq := uint64(0)
+	for s := range merged {
+		seq++
+		s.Seq = seq
+		select {
+		case out <- s:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mergeByTime forwards the samples of in to out in time order, assuming
+// each input is in time order.
+func mergeByTime(ctx context.Context, in []<-chan Sample, out chan<- Sample) {
+	seq := uint64(0)
+	mergeSources(ctx, in, func(p pending) bool {
+		seq++
+		p.sample.Seq = seq
+		select {
+		case out <- p.sample:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// mergeSources passes the samples of in to emit in time order, with the
+// index of their input, until the inputs are closed, ctx is done or emit
+// returns false.
+func mergeSources(ctx context.Context, in []<-chan Sample, emit func(pending) bool) {
+	// next receives the next sample of input i into the heap, if any.
+	var h sampleHeap
+	next := func(i int) bool {
+		select {
+		case s, ok := <-in[i]:
+			if ok {
+				heap.Push(&h, pending{s, i})
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for i := range in {
+		if !next(i) {
+			return
+		}
+	}
+
+	for h.Len() > 0 {
+		p := heap.Pop(&h).(pending)
+		if !emit(p) || !next(p.source) {
+			return
+		}
+	}
+}
+
+// pending is the next sample of a source awaiting the merge.
+type pending struct {
+	sample Sample
+	source int
+}
+
+// sampleHeap orders pending samples by time, then source.
+type sampleHeap []pending
+
+func (h sampleHeap) Len() int { return len(h) }
+
+func (h sampleHeap) Less(i, j int) bool {
+	ti, tj := h[i].sample.Time, h[j].sample.Time
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].source < h[j].source
+}
+
+func (h sampleHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *sampleHeap) Push(x any) { *h = append(*h, x.(pending)) }
+
+func (h *sampleHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// Skipped returns the total number of records the sources skipped, for
+// those that count them.
+func (m *MultiReader) Skipped() int {
+	total := 0
+	for _, r := range m.readers {
+		if s, ok := r.(interface{ Skipped() int }); ok {
+			total += s.Skipped()
+		}
+	}
+	return total
+}
+
+// Err returns the errors that stopped source streams early, for sources
+// that report them.
+func (m *MultiReader) Err() error {
+	var errs []error
+	for i, r := range m.readers {
+		if e, ok := r.(interface{ Err() error }); ok {
+			if err := e.Err(); err != nil {
+				errs = append(errs, fmt.Errorf("source %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every source.
+func (m *MultiReader) Close() error {
+	var errs []error
+	for _, r := range m.readers {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
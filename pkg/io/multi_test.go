@@ -0,0 +1,174 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceReader reads a fixed list of samples.
+type sliceReader struct {
+	samples []Sample
+	err     error // returned by Read and StreamSamples
+	skipped int
+	closed  bool
+}
+
+func (r *sliceReader) Read() ([][]float64, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	data := make([][]float64, len(r.samples))
+	for i, s := range r.samples {
+		data[i] = s.Features
+	}
+	return data, nil
+}
+
+func (r *sliceReader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return nil, errors.New("not used")
+}
+
+func (r *sliceReader) StreamSamples(ctx context.Context) (<-chan Sample, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	ch := make(chan Sample)
+	go func() {
+		defer close(ch)
+		for _, s := range r.samples {
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (r *sliceReader) Skipped() int { return r.skipped }
+
+func (r *sliceReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+// timedReader returns a reader of samples with the given values, captured
+// at those many seconds past start.
+func timedReader(start time.Time, values ...float64) *sliceReader {
+	r := &sliceReader{}
+	for i, v := range values {
+		r.samples = append(r.samples, Sample{
+			Features: []float64{v},
+			Time:     start.Add(time.Duration(v * float64(time.Second))),
+			Seq:      uint64(i + 1),
+		})
+	}
+	return r
+}
+
+func collect(t *testing.T, ch <-chan Sample) []Sample {
+	t.Helper()
+	var samples []Sample
+	for s := range ch {
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestMultiReaderRead(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	a, b, c := timedReader(start, 1, 2), timedReader(start, 3), timedReader(start, 4, 5)
+	a.skipped, c.skipped = 2, 1
+	m := NewMultiReader([]Reader{a, b, c}, WithConcurrency(2))
+
+	data, err := m.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}, {2}, {3}, {4}, {5}}, data)
+	assert.Equal(t, 3, m.Skipped())
+
+	b.err = errors.New("truncated")
+	_, err = m.Read()
+	assert.ErrorIs(t, err, b.err)
+	assert.ErrorContains(t, err, "source 1")
+
+	require.NoError(t, m.Close())
+	assert.True(t, a.closed && b.closed && c.closed)
+}
+
+func TestMultiReaderMergesByTime(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	untimed := &sliceReader{samples: []Sample{{Features: []float64{-1}}}}
+	m := NewMultiReader([]Reader{
+		timedReader(start, 1, 4, 6, 7),
+		timedReader(start, 2, 3, 8),
+		timedReader(start),
+		timedReader(start, 4, 5),
+		untimed,
+	})
+
+	samples := collect(t, mustStream(t, m))
+	var values []float64
+	for i, s := range samples {
+		values = append(values, s.Features[0])
+		assert.Equal(t, uint64(i+1), s.Seq)
+	}
+	assert.Equal(t, []float64{-1, 1, 2, 3, 4, 4, 5, 6, 7, 8}, values)
+
+	features, err := m.Stream(context.Background())
+	require.NoError(t, err)
+	n := 0
+	for range features {
+		n++
+	}
+	assert.Equal(t, 10, n)
+}
+
+func TestMultiReaderArrivalOrder(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	m := NewMultiReader([]Reader{timedReader(start, 3, 1), timedReader(start, 2)}, WithArrivalOrder())
+
+	samples := collect(t, mustStream(t, m))
+	require.Len(t, samples, 3)
+	var sum float64
+	for i, s := range samples {
+		sum += s.Features[0]
+		assert.Equal(t, uint64(i+1), s.Seq)
+	}
+	assert.Equal(t, 6.0, sum)
+}
+
+func TestMultiReaderStreamErrors(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	failing := &sliceReader{err: errors.New("no such file")}
+	m := NewMultiReader([]Reader{timedReader(start, 1), failing})
+	_, err := m.StreamSamples(context.Background())
+	assert.ErrorIs(t, err, failing.err)
+
+	// Cancelling the context ends the merge.
+	long := &sliceReader{}
+	for i := 0; i < 1000; i++ {
+		long.samples = append(long.samples, Sample{Features: []float64{float64(i)}})
+	}
+	for _, opts := range [][]MultiOption{nil, {WithArrivalOrder()}} {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := NewMultiReader([]Reader{long, long}, opts...).StreamSamples(ctx)
+		require.NoError(t, err)
+		<-ch
+		cancel()
+		n := len(collect(t, ch))
+		assert.Less(t, n, 1999)
+	}
+}
+
+func mustStream(t *testing.T, m *MultiReader) <-chan Sample {
+	t.Helper()
+	ch, err := m.StreamSamples(context.Background())
+	require.NoError(t, err)
+	return ch
+}
@@ -0,0 +1,56 @@
+package io
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// IP protocol numbers of Packet.Protocol.
+const (
+	ProtoICMP uint8 = 1
+	ProtoTCP  uint8 = 6
+	ProtoUDP  uint8 = 17
+)
+
+// TCP flag bits of Packet.Flags, in the same encoding as the tcp_flags
+// packet feature.
+const (
+	FlagSYN uint8 = 1 << iota
+	FlagACK
+	FlagFIN
+	FlagRST
+	FlagPSH
+	FlagURG
+)
+
+// Packet summarizes the headers of a network packet: what detection
+// profiles, which reason about who talks to whom, need from a capture.
+type Packet struct {
+	Time time.Time
+	// SrcMAC and DstMAC hold the Ethernet addresses, nil for captures
+	// without an Ethernet layer.
+	SrcMAC   net.HardwareAddr
+	DstMAC   net.HardwareAddr
+	Src      netip.Addr
+	Dst      netip.Addr
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+	// Flags holds the TCP flags, zero for other protocols.
+	Flags uint8
+	// Length is the captured size of the whole packet in bytes.
+	Length int
+	// Payload is the size of the application payload in bytes.
+	Payload int
+}
+
+// Has reports whether every flag in mask is set.
+func (p Packet) Has(mask uint8) bool {
+	return p.Flags&mask == mask
+}
+
+// SYNOnly reports whether p opens a TCP connection: SYN set, ACK clear.
+func (p Packet) SYNOnly() bool {
+	return p.Protocol == ProtoTCP && p.Has(FlagSYN) && !p.Has(FlagACK)
+}
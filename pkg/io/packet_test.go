@@ -0,0 +1,29 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  Packet
+		synOnly bool
+	}{
+		{name: "syn", packet: Packet{Protocol: ProtoTCP, Flags: FlagSYN}, synOnly: true},
+		{name: "syn ack", packet: Packet{Protocol: ProtoTCP, Flags: FlagSYN | FlagACK}},
+		{name: "ack", packet: Packet{Protocol: ProtoTCP, Flags: FlagACK}},
+		{name: "udp", packet: Packet{Protocol: ProtoUDP, Flags: FlagSYN}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.synOnly, tt.packet.SYNOnly())
+		})
+	}
+
+	p := Packet{Flags: FlagACK | FlagPSH}
+	assert.True(t, p.Has(FlagACK|FlagPSH))
+	assert.False(t, p.Has(FlagACK|FlagFIN))
+}
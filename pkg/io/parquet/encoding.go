@@ -0,0 +1,260 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Physical types.
+const (
+	typeBoolean = 0
+	typeInt32   = 1
+	typeInt64   = 2
+	typeInt96   = 3
+	typeFloat   = 4
+	typeDouble  = 5
+)
+
+// Encodings.
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+	encodingByteStreamSplit = 9
+)
+
+// Compression codecs.
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+)
+
+// errCorrupt reports page data that does not decode.
+var errCorrupt = errors.New("parquet: corrupt page data")
+
+// decompress returns the uncompressed page data, of size bytes.
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappy(data, size)
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("parquet: %w", err)
+		}
+		out := make([]byte, size)
+		if _, err := io.ReadFull(zr, out); err != nil {
+			return nil, fmt.Errorf("parquet: %w", err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("parquet: unsupported compression codec %d", codec)
+}
+
+// snappy decodes a snappy block whose data is expected to be size bytes.
+func snappy(src []byte, size int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 || n != uint64(size) {
+		return nil, errCorrupt
+	}
+	src = src[k:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag>>2) + 1
+			src = src[1:]
+			if length > 60 {
+				// The length, less one, follows in length-60 bytes.
+				extra := length - 60
+				if len(src) < extra {
+					return nil, errCorrupt
+				}
+				var l uint32
+				for i := range extra {
+					l |= uint32(src[i]) << (8 * i)
+				}
+				length, src = int(l)+1, src[extra:]
+			}
+			if length > len(src) || length > size-len(dst) {
+				return nil, errCorrupt
+			}
+			dst, src = append(dst, src[:length]...), src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, errCorrupt
+		}
+		// Copies may overlap what they append.
+		start := len(dst) - offset
+		for i := range length {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+// bitWidth returns the number of bits that hold values up to max.
+func bitWidth(max int) int {
+	w := 0
+	for ; max > 0; max >>= 1 {
+		w++
+	}
+	return w
+}
+
+// hybrid decodes n values of the given bit width in the RLE/bit-packing
+// hybrid encoding, as definition levels and dictionary indices are.
+func hybrid(data []byte, width, n int) ([]int, error) {
+	if width < 0 || width > 32 {
+		return nil, errCorrupt
+	}
+	values := make([]int, 0, n)
+	size := (width + 7) / 8
+	for len(values) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errCorrupt
+		}
+		data = data[k:]
+		if header&1 == 0 {
+			// A run of one value, stored in size bytes.
+			count := int(min(header>>1, uint64(n-len(values))))
+			if len(data) < size {
+				return nil, errCorrupt
+			}
+			var v int
+			for i := range size {
+				v |= int(data[i]) << (8 * i)
+			}
+			data = data[size:]
+			for range count {
+				values = append(values, v)
+			}
+			continue
+		}
+		// Groups of eight values packed in width bits each, low bits first.
+		groups := header >> 1
+		if groups > uint64(len(data)) {
+			return nil, errCorrupt
+		}
+		packed := int(groups) * width
+		if len(data) < packed {
+			return nil, errCorrupt
+		}
+		count := min(int(groups)*8, n-len(values))
+		for i := range count {
+			var v int
+			for b := range width {
+				bit := i*width + b
+				v |= int(data[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, v)
+		}
+		data = data[packed:]
+	}
+	return values, nil
+}
+
+// plain decodes n values of a physical type in the plain encoding,
+// converting them with conv.
+func plain(data []byte, typ int64, n int, conv func(int64) float64) ([]float64, error) {
+	if typ == typeBoolean {
+		if len(data)*8 < n {
+			return nil, errCorrupt
+		}
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = float64(data[i/8] >> (i % 8) & 1)
+		}
+		return values, nil
+	}
+	size := sizeOf(typ)
+	if len(data) < n*size {
+		return nil, errCorrupt
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = fixed(data[i*size:], typ, conv)
+	}
+	return values, nil
+}
+
+// byteStreamSplit decodes n values of a physical type whose bytes are
+// split into one stream per byte position.
+func byteStreamSplit(data []byte, typ int64, n int, conv func(int64) float64) ([]float64, error) {
+	size := sizeOf(typ)
+	if size == 0 || len(data) < n*size {
+		return nil, errCorrupt
+	}
+	values := make([]float64, n)
+	buf := make([]byte, size)
+	for i := range values {
+		for b := range buf {
+			buf[b] = data[b*n+i]
+		}
+		values[i] = fixed(buf, typ, conv)
+	}
+	return values, nil
+}
+
+// sizeOf returns the size in bytes of a value of a fixed-size physical
+// type, 0 for booleans.
+func sizeOf(typ int64) int {
+	switch typ {
+	case typeInt32, typeFloat:
+		return 4
+	case typeInt64, typeDouble:
+		return 8
+	}
+	return 0
+}
+
+// fixed decodes a little-endian value of a fixed-size physical type.
+func fixed(b []byte, typ int64, conv func(int64) float64) float64 {
+	switch typ {
+	case typeInt32:
+		return conv(int64(int32(binary.LittleEndian.Uint32(b))))
+	case typeInt64:
+		return conv(int64(binary.LittleEndian.Uint64(b)))
+	case typeFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+}
@@ -0,0 +1,498 @@
+// Package parquet reads Parquet files of flat tables as feature vectors.
+//
+// Every numeric column, boolean, integer or floating point, becomes a
+// feature, in schema order; columns of other types, such as strings, are
+// left out, and Headers names the columns read. Nulls read as NaN. Pages
+// may be plain or dictionary encoded, or byte stream split, in data page
+// versions 1 and 2, uncompressed or compressed with snappy or gzip. Files
+// with nested or repeated columns are rejected.
+package parquet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// magic begins and ends every Parquet file.
+const magic = "PAR1"
+
+// Repetition types.
+const (
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+// Page types.
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Converted types read other than as plain integers.
+const (
+	convertedDecimal = 5
+	convertedUint32  = 13
+	convertedUint64  = 14
+)
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// column is a numeric leaf column of the schema.
+type column struct {
+	name string
+	// chunk is the index of the column's chunk in each row group.
+	chunk  int
+	typ    int64
+	maxDef int
+	// conv converts integer values, for unsigned and decimal columns.
+	conv func(int64) float64
+}
+
+// Reader reads the rows of a Parquet file, one row group at a time.
+type Reader struct {
+	file    *os.File
+	size    int64
+	columns []column
+	headers []string
+	groups  []object
+
+	// group is the next row group to decode; values holds the columns of
+	// the current one, of rows rows, and row the next row to return.
+	group  int
+	values [][]float64
+	rows   int
+	row    int
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+// NewReader opens a Parquet file and reads its schema.
+func NewReader(filename string) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{file: file}
+	if err := r.readFooter(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// readFooter reads the file metadata at the end of the file.
+func (r *Reader) readFooter() error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	r.size = info.Size()
+	if r.size < 12 {
+		return errors.New("parquet: file too short")
+	}
+	head := make([]byte, 4)
+	tail := make([]byte, 8)
+	if _, err := r.file.ReadAt(head, 0); err != nil {
+		return err
+	}
+	if _, err := r.file.ReadAt(tail, r.size-8); err != nil {
+		return err
+	}
+	if string(head) != magic || string(tail[4:]) != magic {
+		return errors.New("parquet: not a Parquet file")
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	if length > r.size-12 {
+		return errors.New("parquet: file metadata exceeds the file")
+	}
+	buf := make([]byte, length)
+	if _, err := r.file.ReadAt(buf, r.size-8-length); err != nil {
+		return err
+	}
+	meta, err := (&decoder{buf: buf}).object(0)
+	if err != nil {
+		return fmt.Errorf("parquet: file metadata: %w", err)
+	}
+	if err := r.readSchema(meta.objects(2)); err != nil {
+		return err
+	}
+	r.groups = meta.objects(4)
+	return nil
+}
+
+// readSchema finds the numeric columns of a flat schema: a root whose
+// children are all leaves.
+func (r *Reader) readSchema(schema []object) error {
+	if len(schema) == 0 {
+		return errors.New("parquet: file has no schema")
+	}
+	for i, elem := range schema[1:] {
+		name := elem.string(4)
+		if elem.int(5, 0) > 0 {
+			return fmt.Errorf("parquet: nested column %q is not supported", name)
+		}
+		if elem.int(3, 0) == repetitionRepeated {
+			return fmt.Errorf("parquet: repeated column %q is not supported", name)
+		}
+		typ := elem.int(1, -1)
+		switch typ {
+		case typeBoolean, typeInt32, typeInt64, typeFloat, typeDouble:
+		default:
+			continue
+		}
+		c := column{name: name, chunk: i, typ: typ, conv: converter(elem)}
+		if elem.int(3, 0) == repetitionOptional {
+			c.maxDef = 1
+		}
+		r.columns = append(r.columns, c)
+		r.headers = append(r.headers, name)
+	}
+	return nil
+}
+
+// converter returns how the integer values of a schema element convert
+// to floats: as unsigned integers, scaled decimals, or as they are.
+func converter(elem object) func(int64) float64 {
+	logical := elem.object(10)
+	if integer := logical.object(10); integer != nil && !integer.bool(2, true) {
+		return unsigned(integer.int(1, 64))
+	}
+	if decimal := logical.object(5); decimal != nil {
+		return scaled(decimal.int(1, 0))
+	}
+	switch elem.int(6, -1) {
+	case convertedUint32:
+		return unsigned(32)
+	case convertedUint64:
+		return unsigned(64)
+	case convertedDecimal:
+		return scaled(elem.int(7, 0))
+	}
+	return func(v int64) float64 { return float64(v) }
+}
+
+func unsigned(bits int64) func(int64) float64 {
+	if bits <= 32 {
+		return func(v int64) float64 { return float64(uint32(v)) }
+	}
+	return func(v int64) float64 { return float64(uint64(v)) }
+}
+
+func scaled(scale int64) func(int64) float64 {
+	unit := math.Pow10(-int(scale))
+	return func(v int64) float64 { return float64(v) * unit }
+}
+
+// Headers returns the names of the columns read, one per feature.
+func (r *Reader) Headers() []string {
+	return r.headers
+}
+
+// Err returns the error that stopped Stream early, if any. It is only
+// meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Read returns all rows.
+func (r *Reader) Read() ([][]float64, error) {
+	var data [][]float64
+	for {
+		row, err := r.next()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, row)
+	}
+}
+
+// Stream returns a channel of rows for real-time processing. A read error
+// stops the stream and Err reports it.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(row []float64, _ uint64) []float64 { return row }), nil
+}
+
+// StreamSamples is Stream with each row stamped with the time it was read
+// and its sequence number among the rows emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(row []float64, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: row, Time: time.Now(), Seq: seq}
+	}), nil
+}
+
+// stream emits wrap(row, seq) for every row until the end of the file, a
+// read error, or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(row []float64, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			row, err := r.next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				r.setErr(err)
+				return
+			}
+			select {
+			case out <- wrap(row, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// next returns the next row, decoding the next row group when the current
+// one is exhausted, or io.EOF after the last.
+func (r *Reader) next() ([]float64, error) {
+	for r.row == r.rows {
+		if r.group == len(r.groups) {
+			return nil, io.EOF
+		}
+		if err := r.readGroup(r.groups[r.group]); err != nil {
+			return nil, fmt.Errorf("parquet: row group %d: %w", r.group, err)
+		}
+		r.group++
+	}
+	row := make([]float64, len(r.columns))
+	for j := range row {
+		row[j] = r.values[j][r.row]
+	}
+	r.row++
+	return row, nil
+}
+
+// readGroup decodes the columns of a row group.
+func (r *Reader) readGroup(group object) error {
+	rows := group.int(3, 0)
+	if rows < 0 {
+		return errCorrupt
+	}
+	chunks := group.objects(1)
+	values := make([][]float64, len(r.columns))
+	for j, c := range r.columns {
+		if c.chunk >= len(chunks) {
+			return fmt.Errorf("no chunk for column %q", c.name)
+		}
+		chunk := chunks[c.chunk]
+		if path := chunk.string(1); path != "" {
+			return fmt.Errorf("column %q is in another file, %s", c.name, path)
+		}
+		meta := chunk.object(3)
+		offset, size := meta.int(9, 0), meta.int(7, 0)
+		if dict := meta.int(11, 0); dict > 0 && dict < offset {
+			offset = dict
+		}
+		if offset < 4 || size < 0 || offset+size > r.size {
+			return fmt.Errorf("column %q: chunk exceeds the file", c.name)
+		}
+		buf := make([]byte, size)
+		if _, err := r.file.ReadAt(buf, offset); err != nil {
+			return err
+		}
+		var err error
+		if values[j], err = c.decode(buf, meta.int(4, 0), int(rows)); err != nil {
+			return fmt.Errorf("column %q: %w", c.name, err)
+		}
+	}
+	r.values, r.rows, r.row = values, int(rows), 0
+	return nil
+}
+
+// decode decodes the rows values of a column chunk compressed with
+// codec.
+func (c column) decode(buf []byte, codec int64, rows int) ([]float64, error) {
+	values := make([]float64, 0, min(rows, 1<<16))
+	var dict []float64
+	d := &decoder{buf: buf}
+	for len(values) < rows && d.pos < len(buf) {
+		header, err := d.object(0)
+		if err != nil {
+			return nil, err
+		}
+		page, err := d.bytes(int(header.int(3, -1)))
+		if err != nil {
+			return nil, err
+		}
+		size := int(header.int(2, -1))
+		switch header.int(1, -1) {
+		case pageDictionary:
+			dh := header.object(7)
+			data, err := decompress(codec, page, size)
+			if err != nil {
+				return nil, err
+			}
+			n := int(dh.int(1, 0))
+			if n < 0 || n > len(data)*8 {
+				return nil, errCorrupt
+			}
+			if dict, err = plain(data, c.typ, n, c.conv); err != nil {
+				return nil, err
+			}
+		case pageData:
+			dh := header.object(5)
+			n := int(dh.int(1, 0))
+			if n < 0 || n > rows-len(values) {
+				return nil, errCorrupt
+			}
+			data, err := decompress(codec, page, size)
+			if err != nil {
+				return nil, err
+			}
+			var levels []int
+			if c.maxDef > 0 {
+				if dh.int(3, encodingRLE) != encodingRLE {
+					return nil, fmt.Errorf("unsupported definition level encoding %d", dh.int(3, 0))
+				}
+				if len(data) < 4 {
+					return nil, errCorrupt
+				}
+				length := int(binary.LittleEndian.Uint32(data))
+				if length > len(data)-4 {
+					return nil, errCorrupt
+				}
+				if levels, err = hybrid(data[4:4+length], bitWidth(c.maxDef), n); err != nil {
+					return nil, err
+				}
+				data = data[4+length:]
+			}
+			if values, err = c.append(values, data, dh.int(2, 0), n, levels, dict); err != nil {
+				return nil, err
+			}
+		case pageDataV2:
+			dh := header.object(8)
+			n := int(dh.int(1, 0))
+			if n < 0 || n > rows-len(values) {
+				return nil, errCorrupt
+			}
+			defLength, repLength := int(dh.int(5, 0)), int(dh.int(6, 0))
+			if defLength < 0 || repLength < 0 || defLength+repLength > len(page) {
+				return nil, errCorrupt
+			}
+			var levels []int
+			if c.maxDef > 0 {
+				if levels, err = hybrid(page[repLength:repLength+defLength], bitWidth(c.maxDef), n); err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLength+defLength:]
+			if dh.bool(7, true) {
+				if data, err = decompress(codec, data, size-repLength-defLength); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = c.append(values, data, dh.int(4, 0), n, levels, dict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(values) != rows {
+		return nil, fmt.Errorf("%d values for %d rows", len(values), rows)
+	}
+	return values, nil
+}
+
+// append appends the n values of a data page to values, NaN where the
+// definition levels mark a null. The page holds the values that are not
+// null in the given encoding.
+func (c column) append(values []float64, data []byte, encoding int64, n int, levels []int, dict []float64) ([]float64, error) {
+	present := n
+	if levels != nil {
+		present = 0
+		for _, l := range levels {
+			if l == c.maxDef {
+				present++
+			}
+		}
+	}
+
+	var decoded []float64
+	var err error
+	switch {
+	case present == 0:
+	case encoding == encodingPlain:
+		decoded, err = plain(data, c.typ, present, c.conv)
+	case encoding == encodingPlainDictionary || encoding == encodingRLEDictionary:
+		if dict == nil || len(data) == 0 {
+			return nil, errCorrupt
+		}
+		var indices []int
+		if indices, err = hybrid(data[1:], int(data[0]), present); err != nil {
+			return nil, err
+		}
+		decoded = make([]float64, present)
+		for i, k := range indices {
+			if k >= len(dict) {
+				return nil, errCorrupt
+			}
+			decoded[i] = dict[k]
+		}
+	case encoding == encodingRLE:
+		if c.typ != typeBoolean || len(data) < 4 {
+			return nil, errCorrupt
+		}
+		length := int(binary.LittleEndian.Uint32(data))
+		if length > len(data)-4 {
+			return nil, errCorrupt
+		}
+		var bits []int
+		if bits, err = hybrid(data[4:4+length], 1, present); err != nil {
+			return nil, err
+		}
+		decoded = make([]float64, present)
+		for i, b := range bits {
+			decoded[i] = float64(b)
+		}
+	case encoding == encodingByteStreamSplit:
+		decoded, err = byteStreamSplit(data, c.typ, present, c.conv)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if levels == nil {
+		return append(values, decoded...), nil
+	}
+	for _, l := range levels {
+		if l == c.maxDef {
+			values, decoded = append(values, decoded[0]), decoded[1:]
+		} else {
+			values = append(values, math.NaN())
+		}
+	}
+	return values, nil
+}
+
+// Close releases resources.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,469 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testColumn is a column of a file written by writeFile.
+type testColumn struct {
+	name     string
+	typ      int64
+	optional bool
+	// values holds the column's values, NaN for nulls; byte array
+	// columns write "x" for every row.
+	values []float64
+	dict   bool
+	codec  int64
+	v2     bool
+}
+
+// thriftWriter writes the thrift compact protocol.
+type thriftWriter struct {
+	buf bytes.Buffer
+	ids []int16
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.ids[len(w.ids)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		w.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.uvarint(uint64(id<<1) ^ uint64(id>>15))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) begin()                { w.ids = append(w.ids, 0) }
+func (w *thriftWriter) end()                  { w.buf.WriteByte(0); w.ids = w.ids[:len(w.ids)-1] }
+func (w *thriftWriter) zigzag(v int64)        { w.uvarint(uint64(v<<1) ^ uint64(v>>63)) }
+func (w *thriftWriter) int(id int16, v int64) { w.field(id, compactI64); w.zigzag(v) }
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, compactBinary)
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.field(id, compactTrue)
+	} else {
+		w.field(id, compactFalse)
+	}
+}
+
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, compactList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.buf.WriteByte(0xf0 | typ)
+	w.uvarint(uint64(n))
+}
+
+func (w *thriftWriter) object(id int16) {
+	w.field(id, compactStruct)
+	w.begin()
+}
+
+// runs encodes values as RLE runs of the hybrid encoding.
+func runs(values []int, width int) []byte {
+	var b []byte
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j] == values[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		for k := range (width + 7) / 8 {
+			b = append(b, byte(values[i]>>(8*k)))
+		}
+		i = j
+	}
+	return b
+}
+
+// plainBytes encodes values in the plain encoding of typ.
+func plainBytes(values []float64, typ int64) []byte {
+	var b []byte
+	switch typ {
+	case typeBoolean:
+		b = make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v != 0 {
+				b[i/8] |= 1 << (i % 8)
+			}
+		}
+	case typeInt32:
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint32(b, uint32(int32(v)))
+		}
+	case typeInt64:
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint64(b, uint64(int64(v)))
+		}
+	case typeDouble:
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	default:
+		for range values {
+			b = append(binary.LittleEndian.AppendUint32(b, 1), 'x')
+		}
+	}
+	return b
+}
+
+// compress compresses data with codec; snappy blocks are all literals.
+func compress(t *testing.T, codec int64, data []byte) []byte {
+	switch codec {
+	case codecSnappy:
+		b := binary.AppendUvarint(nil, uint64(len(data)))
+		for len(data) > 0 {
+			n := min(len(data), 60)
+			b = append(append(b, byte(n-1)<<2), data[:n]...)
+			data = data[n:]
+		}
+		return b
+	case codecGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	return data
+}
+
+// page writes a page header of the given type followed by the page.
+func page(t *testing.T, out *bytes.Buffer, codec int64, typ int64, data []byte, header func(w *thriftWriter)) {
+	packed := compress(t, codec, data)
+	var w thriftWriter
+	w.begin()
+	w.int(1, typ)
+	w.int(2, int64(len(data)))
+	w.int(3, int64(len(packed)))
+	header(&w)
+	w.end()
+	out.Write(w.buf.Bytes())
+	out.Write(packed)
+}
+
+// chunk writes the rows lo to hi of column c and returns the offsets of
+// its dictionary page, 0 for none, and first data page.
+func chunk(t *testing.T, out *bytes.Buffer, c testColumn, lo, hi int) (dictOffset, dataOffset int64) {
+	var levels []int
+	var present []float64
+	for _, v := range c.values[lo:hi] {
+		if math.IsNaN(v) {
+			levels = append(levels, 0)
+			continue
+		}
+		levels = append(levels, 1)
+		present = append(present, v)
+	}
+	n := int64(hi - lo)
+
+	encoding := int64(encodingPlain)
+	values := plainBytes(present, c.typ)
+	if c.dict {
+		var dict []float64
+		indices := make([]int, len(present))
+		for i, v := range present {
+			k := 0
+			for k < len(dict) && dict[k] != v {
+				k++
+			}
+			if k == len(dict) {
+				dict = append(dict, v)
+			}
+			indices[i] = k
+		}
+		dictOffset = int64(out.Len())
+		page(t, out, c.codec, pageDictionary, plainBytes(dict, c.typ), func(w *thriftWriter) {
+			w.object(7)
+			w.int(1, int64(len(dict)))
+			w.int(2, encodingPlain)
+			w.end()
+		})
+		width := bitWidth(len(dict) - 1)
+		encoding = encodingRLEDictionary
+		values = append([]byte{byte(width)}, runs(indices, width)...)
+	}
+
+	dataOffset = int64(out.Len())
+	var defs []byte
+	if c.optional {
+		defs = runs(levels, 1)
+	}
+	if c.v2 {
+		packed := compress(t, c.codec, values)
+		var w thriftWriter
+		w.begin()
+		w.int(1, pageDataV2)
+		w.int(2, int64(len(defs)+len(values)))
+		w.int(3, int64(len(defs)+len(packed)))
+		w.object(8)
+		w.int(1, n)
+		w.int(2, n-int64(len(present)))
+		w.int(3, n)
+		w.int(4, encoding)
+		w.int(5, int64(len(defs)))
+		w.int(6, 0)
+		w.end()
+		w.end()
+		out.Write(w.buf.Bytes())
+		out.Write(defs)
+		out.Write(packed)
+		return dictOffset, dataOffset
+	}
+	var data []byte
+	if c.optional {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(defs)))
+		data = append(data, defs...)
+	}
+	page(t, out, c.codec, pageData, append(data, values...), func(w *thriftWriter) {
+		w.object(5)
+		w.int(1, n)
+		w.int(2, encoding)
+		w.int(3, encodingRLE)
+		w.int(4, encodingRLE)
+		w.end()
+	})
+	return dictOffset, dataOffset
+}
+
+// writeFile writes a Parquet file of the columns in row groups of group
+// rows and returns its path.
+func writeFile(t *testing.T, columns []testColumn, group int) string {
+	rows := len(columns[0].values)
+	var out bytes.Buffer
+	out.WriteString(magic)
+
+	var meta thriftWriter
+	meta.begin()
+	meta.int(1, 1)
+	meta.list(2, compactStruct, len(columns)+1)
+	meta.begin()
+	meta.str(4, "schema")
+	meta.int(5, int64(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		meta.begin()
+		meta.int(1, c.typ)
+		if c.optional {
+			meta.int(3, repetitionOptional)
+		} else {
+			meta.int(3, 0)
+		}
+		meta.str(4, c.name)
+		meta.end()
+	}
+	meta.int(3, int64(rows))
+
+	var groups [][]func(w *thriftWriter)
+	for lo := 0; lo < rows; lo += group {
+		hi := min(lo+group, rows)
+		var chunks []func(w *thriftWriter)
+		for _, c := range columns {
+			start := int64(out.Len())
+			dict, data := chunk(t, &out, c, lo, hi)
+			size := int64(out.Len()) - start
+			c := c
+			chunks = append(chunks, func(w *thriftWriter) {
+				w.begin()
+				w.int(2, start)
+				w.object(3)
+				w.int(1, c.typ)
+				w.list(2, compactI32, 1)
+				w.zigzag(encodingPlain)
+				w.list(3, compactBinary, 1)
+				w.uvarint(uint64(len(c.name)))
+				w.buf.WriteString(c.name)
+				w.int(4, c.codec)
+				w.int(5, int64(hi-lo))
+				w.int(6, size)
+				w.int(7, size)
+				w.int(9, data)
+				if dict > 0 {
+					w.int(11, dict)
+				}
+				w.end()
+				w.end()
+			})
+		}
+		groups = append(groups, append(chunks, func(w *thriftWriter) { w.int(3, int64(hi-lo)) }))
+	}
+	meta.list(4, compactStruct, len(groups))
+	for _, g := range groups {
+		meta.begin()
+		meta.list(1, compactStruct, len(g)-1)
+		for _, fn := range g[:len(g)-1] {
+			fn(&meta)
+		}
+		g[len(g)-1](&meta)
+		meta.end()
+	}
+	meta.str(6, "goguardml test")
+	meta.end()
+
+	out.Write(meta.buf.Bytes())
+	out.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	out.WriteString(magic)
+
+	path := filepath.Join(t.TempDir(), "flows.parquet")
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0o600))
+	return path
+}
+
+// flows returns columns of 25 rows covering the encodings and codecs read.
+func flows() []testColumn {
+	nan := math.NaN()
+	src := make([]float64, 25)
+	size := make([]float64, 25)
+	host := make([]float64, 25)
+	ok := make([]float64, 25)
+	total := make([]float64, 25)
+	for i := range src {
+		src[i] = float64(10 + i%3)
+		size[i] = 1.5 * float64(i)
+		if i%4 == 1 {
+			size[i] = nan
+		}
+		ok[i] = float64(i % 2)
+		total[i] = float64(int64(1) << 40 * int64(i))
+	}
+	return []testColumn{
+		{name: "src", typ: typeInt32, values: src, dict: true, codec: codecSnappy},
+		{name: "bytes", typ: typeDouble, optional: true, values: size, codec: codecGzip},
+		{name: "host", typ: 6, values: host},
+		{name: "ok", typ: typeBoolean, values: ok, v2: true},
+		{name: "total", typ: typeInt64, optional: true, values: total, dict: true, codec: codecSnappy, v2: true},
+	}
+}
+
+// rowsOf returns the rows of the numeric columns.
+func rowsOf(columns []testColumn) [][]float64 {
+	var rows [][]float64
+	for i := range columns[0].values {
+		var row []float64
+		for _, c := range columns {
+			if c.typ != 6 {
+				row = append(row, c.values[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestRead(t *testing.T) {
+	columns := flows()
+	r, err := NewReader(writeFile(t, columns, 10))
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, []string{"src", "bytes", "ok", "total"}, r.Headers(), "string columns are left out")
+	data, err := r.Read()
+	require.NoError(t, err)
+	want := rowsOf(columns)
+	require.Len(t, data, len(want))
+	for i := range want {
+		for j := range want[i] {
+			if math.IsNaN(want[i][j]) {
+				assert.True(t, math.IsNaN(data[i][j]), "row %d column %d is null", i, j)
+			} else {
+				assert.Equal(t, want[i][j], data[i][j], "row %d column %d", i, j)
+			}
+		}
+	}
+}
+
+func TestStreamSamples(t *testing.T) {
+	r, err := NewReader(writeFile(t, flows(), 7))
+	require.NoError(t, err)
+	defer r.Close()
+
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+	var seq uint64
+	for s := range samples {
+		seq++
+		assert.Equal(t, seq, s.Seq)
+		assert.Len(t, s.Features, 4)
+	}
+	assert.Equal(t, uint64(25), seq)
+	assert.NoError(t, r.Err())
+}
+
+func TestErrors(t *testing.T) {
+	dir := t.TempDir()
+	csv := filepath.Join(dir, "flows.csv")
+	require.NoError(t, os.WriteFile(csv, []byte("a,b\n1,2\n3,4\n5,6\n"), 0o600))
+	_, err := NewReader(csv)
+	assert.ErrorContains(t, err, "not a Parquet file")
+
+	_, err = NewReader(filepath.Join(dir, "missing.parquet"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	path := writeFile(t, flows(), 10)
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// Metadata cut short.
+	cut := bytes.Clone(valid)
+	binary.LittleEndian.PutUint32(cut[len(cut)-8:], 3)
+	require.NoError(t, os.WriteFile(path, cut, 0o600))
+	_, err = NewReader(path)
+	assert.Error(t, err)
+
+	// A corrupt page fails the read instead of returning wrong rows.
+	corrupt := bytes.Clone(valid)
+	for i := 4; i < 40; i++ {
+		corrupt[i] = 0xff
+	}
+	require.NoError(t, os.WriteFile(path, corrupt, 0o600))
+	r, err := NewReader(path)
+	require.NoError(t, err)
+	_, err = r.Read()
+	assert.ErrorContains(t, err, "row group 0")
+	r.Close()
+}
+
+func TestHybrid(t *testing.T) {
+	// The bit-packed example of the format specification: 0 to 7 in three
+	// bits each, then a run of five 4s.
+	data := []byte{3, 0x88, 0xc6, 0xfa, 10, 4}
+	values, err := hybrid(data, 3, 13)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 4, 4, 4, 4, 4}, values)
+
+	_, err = hybrid(data[:2], 3, 8)
+	assert.ErrorIs(t, err, errCorrupt)
+}
+
+func TestSnappy(t *testing.T) {
+	// A literal "ab" then a copy of 8 bytes at offset 2, overlapping itself.
+	out, err := snappy([]byte{10, 1 << 2, 'a', 'b', 1 | (8-4)<<2, 2}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "ababababab", string(out))
+
+	_, err = snappy([]byte{10, 1 << 2, 'a', 'b', 1 | (8-4)<<2, 3}, 10)
+	assert.ErrorIs(t, err, errCorrupt, "offset beyond the output")
+}
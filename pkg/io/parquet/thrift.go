@@ -0,0 +1,259 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxDepth bounds the nesting of decoded structures.
+const maxDepth = 32
+
+// errShort reports metadata or a page header cut short.
+var errShort = errors.New("parquet: truncated thrift data")
+
+// Thrift compact protocol types.
+const (
+	compactStop   = 0
+	compactTrue   = 1
+	compactFalse  = 2
+	compactByte   = 3
+	compactI16    = 4
+	compactI32    = 5
+	compactI64    = 6
+	compactDouble = 7
+	compactBinary = 8
+	compactList   = 9
+	compactSet    = 10
+	compactMap    = 11
+	compactStruct = 12
+)
+
+// object is a decoded thrift struct: its field values by field id. Values
+// are bool, int64 (every integer type), float64, []byte, []any and
+// object; maps are dropped, the format metadata read has none of
+// interest.
+type object map[int16]any
+
+// decoder decodes thrift compact protocol values from a byte slice. Sizes
+// are checked against the bytes left before anything is allocated, so a
+// corrupt file cannot make it allocate more than the file holds.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errShort
+	}
+	c := d.buf[d.pos]
+	d.pos++
+	return c, nil
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf)-d.pos {
+		return nil, errShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		return 0, errShort
+	}
+	d.pos += n
+	return v, nil
+}
+
+// varint reads a zigzag varint.
+func (d *decoder) varint() (int64, error) {
+	v, err := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// object reads a struct up to its stop field.
+func (d *decoder) object(depth int) (object, error) {
+	if depth > maxDepth {
+		return nil, errors.New("parquet: thrift structures nested too deeply")
+	}
+	obj := make(object)
+	var id int16
+	for {
+		c, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		typ := c & 0x0f
+		if typ == compactStop {
+			return obj, nil
+		}
+		if delta := int16(c >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		v, err := d.value(typ, depth)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			obj[id] = v
+		}
+	}
+}
+
+// value reads a value of the given type.
+func (d *decoder) value(typ byte, depth int) (any, error) {
+	switch typ {
+	case compactTrue:
+		return true, nil
+	case compactFalse:
+		return false, nil
+	case compactByte:
+		c, err := d.byte()
+		return int64(int8(c)), err
+	case compactI16, compactI32, compactI64:
+		return d.varint()
+	case compactDouble:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case compactBinary:
+		n, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.buf)-d.pos) {
+			return nil, errShort
+		}
+		return d.bytes(int(n))
+	case compactList, compactSet:
+		return d.list(depth)
+	case compactMap:
+		return nil, d.skipMap(depth)
+	case compactStruct:
+		return d.object(depth + 1)
+	}
+	return nil, fmt.Errorf("parquet: unknown thrift type %d", typ)
+}
+
+// list reads a list or set. Booleans in lists take a byte each, 1 for
+// true.
+func (d *decoder) list(depth int) ([]any, error) {
+	c, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	n, typ := uint64(c>>4), c&0x0f
+	if n == 15 {
+		if n, err = d.uvarint(); err != nil {
+			return nil, err
+		}
+	}
+	// Every element takes at least a byte.
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errShort
+	}
+	items := make([]any, n)
+	for i := range items {
+		if typ == compactTrue || typ == compactFalse {
+			c, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			items[i] = c == compactTrue
+			continue
+		}
+		if items[i], err = d.value(typ, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// skipMap reads past a map.
+func (d *decoder) skipMap(depth int) error {
+	n, err := d.uvarint()
+	if err != nil || n == 0 {
+		return err
+	}
+	if n > uint64(len(d.buf)-d.pos) {
+		return errShort
+	}
+	types, err := d.byte()
+	if err != nil {
+		return err
+	}
+	for range n {
+		if _, err := d.value(types>>4, depth+1); err != nil {
+			return err
+		}
+		if _, err := d.value(types&0x0f, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// int returns integer field id of o, or def if it is not set.
+func (o object) int(id int16, def int64) int64 {
+	if v, ok := o[id].(int64); ok {
+		return v
+	}
+	return def
+}
+
+// bool returns boolean field id of o, or def if it is not set.
+func (o object) bool(id int16, def bool) bool {
+	if v, ok := o[id].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// string returns binary field id of o as a string.
+func (o object) string(id int16) string {
+	b, _ := o[id].([]byte)
+	return string(b)
+}
+
+// object returns struct field id of o, nil if it is not set.
+func (o object) object(id int16) object {
+	v, _ := o[id].(object)
+	return v
+}
+
+// objects returns the structs of list field id of o.
+func (o object) objects(id int16) []object {
+	items, _ := o[id].([]any)
+	objs := make([]object, 0, len(items))
+	for _, item := range items {
+		if v, ok := item.(object); ok {
+			objs = append(objs, v)
+		}
+	}
+	return objs
+}
+
+// strings returns the strings of list field id of o.
+func (o object) strings(id int16) []string {
+	items, _ := o[id].([]any)
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		b, _ := item.([]byte)
+		strs = append(strs, string(b))
+	}
+	return strs
+}
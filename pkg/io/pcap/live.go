@@ -0,0 +1,28 @@
+//go:build cgo && !nopcap
+
+package pcap
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// LiveCapture reports whether this build can capture live traffic.
+const LiveCapture = true
+
+// NewLiveReader creates a reader for live packet capture.
+func NewLiveReader(iface string, snaplen int32, promisc bool, timeout time.Duration, opts ...Option) (*Reader, error) {
+	handle, err := pcap.OpenLive(iface, snaplen, promisc, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(handle, true, opts), nil
+}
+
+// isTimeout reports whether err is the expiry of the live read timeout.
+func isTimeout(err error) bool {
+	return errors.Is(err, pcap.NextErrorTimeoutExpired)
+}
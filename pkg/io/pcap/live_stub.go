@@ -0,0 +1,19 @@
+//go:build !cgo || nopcap
+
+package pcap
+
+import "time"
+
+// LiveCapture reports whether this build can capture live traffic.
+const LiveCapture = false
+
+// NewLiveReader returns ErrNoLiveCapture: this build has no libpcap.
+func NewLiveReader(string, int32, bool, time.Duration, ...Option) (*Reader, error) {
+	return nil, ErrNoLiveCapture
+}
+
+// isTimeout reports whether err is the expiry of the live read timeout,
+// which file reads do not have.
+func isTimeout(error) bool {
+	return false
+}
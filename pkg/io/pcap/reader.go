@@ -1,65 +1,199 @@
 // Package pcap provides PCAP file reading and network packet feature extraction.
+//
+// Capture files, pcap and pcapng, are read in pure Go. Live capture uses
+// libpcap through cgo; builds with the nopcap tag, or with cgo disabled,
+// leave it out so the package builds without libpcap headers, and
+// NewLiveReader returns ErrNoLiveCapture:
+//
+//	go build -tags nopcap ./...
 package pcap
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
 )
 
+// ErrNoLiveCapture is returned by NewLiveReader in builds without libpcap.
+var ErrNoLiveCapture = errors.New("pcap: live capture needs libpcap; this build has the nopcap tag or cgo disabled")
+
+// packetReader reads the packets of a capture.
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// source is a capture: a file or a live interface.
+type source interface {
+	packetReader
+	Close()
+}
+
 // Reader reads packets from PCAP files or live interfaces.
 type Reader struct {
-	handle    *pcap.Handle
+	handle    source
 	extractor *FeatureExtractor
 	isLive    bool
+	pool      *guardio.SamplePool
+
+	maxPackets  int
+	maxDuration time.Duration
+}
+
+// Option configures a PCAP reader.
+type Option func(*Reader)
+
+// WithSamplePool makes Stream take feature vectors from pool instead of
+// allocating one per packet. The pool must have NumFeatures width.
+// Consumers should Put vectors back once done with them.
+func WithSamplePool(pool *guardio.SamplePool) Option {
+	return func(r *Reader) {
+		r.pool = pool
+	}
+}
+
+// WithMaxPackets makes Read and ReadContext stop after n packets. Values
+// below 1 mean no limit.
+func WithMaxPackets(n int) Option {
+	return func(r *Reader) {
+		r.maxPackets = n
+	}
+}
+
+// WithMaxDuration makes Read and ReadContext stop d after they start.
+// Values below 1 mean no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(r *Reader) {
+		r.maxDuration = d
+	}
 }
 
-// NewFileReader creates a reader for PCAP files.
-func NewFileReader(filename string) (*Reader, error) {
-	handle, err := pcap.OpenOffline(filename)
+// NewFileReader creates a reader for capture files, in pcap format,
+// possibly gzipped, or pcapng.
+func NewFileReader(filename string, opts ...Option) (*Reader, error) {
+	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	handle, err := openFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
 
-	return &Reader{
-		handle:    handle,
-		extractor: NewFeatureExtractor(),
-		isLive:    false,
-	}, nil
+	return newReader(handle, false, opts), nil
 }
 
-// NewLiveReader creates a reader for live packet capture.
-func NewLiveReader(iface string, snaplen int32, promisc bool, timeout time.Duration) (*Reader, error) {
-	handle, err := pcap.OpenLive(iface, snaplen, promisc, timeout)
+// pcapngMagic starts pcapng files, as the type of their first block.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// fileSource is a capture file.
+type fileSource struct {
+	packetReader
+	f *os.File
+}
+
+func (s fileSource) Close() {
+	s.f.Close()
+}
+
+// openFile returns the packets of a pcap or pcapng file.
+func openFile(f *os.File) (source, error) {
+	magic := make([]byte, len(pcapngMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if string(magic) == string(pcapngMagic) {
+		r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, err
+		}
+		return fileSource{r, f}, nil
+	}
+	r, err := pcapgo.NewReader(f)
 	if err != nil {
 		return nil, err
 	}
+	return fileSource{r, f}, nil
+}
 
-	return &Reader{
+func newReader(handle source, isLive bool, opts []Option) *Reader {
+	r := &Reader{
 		handle:    handle,
 		extractor: NewFeatureExtractor(),
-		isLive:    true,
-	}, nil
+		isLive:    isLive,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
-// Read returns all packets as feature vectors.
+// Read returns all packets as feature vectors. Live readers need a packet
+// or duration limit, as a live capture never ends on its own; use
+// ReadContext to stop one on demand.
 func (r *Reader) Read() ([][]float64, error) {
+	if r.isLive && r.maxPackets < 1 && r.maxDuration < 1 {
+		return nil, errors.New("live capture needs a packet or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext returns packets as feature vectors until the capture ends,
+// the packet or duration limit is reached, or ctx is done. On cancellation
+// it returns the packets read so far together with ctx.Err(); reaching a
+// limit is not an error.
+//
+// ctx and the duration limit are checked between packets and whenever the
+// live read timeout expires, so a live reader opened with a negative
+// timeout (pcap.BlockForever) may overrun them until the next packet.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
 	if r.handle == nil {
 		return nil, errors.New("reader not initialized")
 	}
 
+	var deadline time.Time
+	if r.maxDuration > 0 {
+		deadline = time.Now().Add(r.maxDuration)
+	}
+
 	var data [][]float64
 	packetSource := gopacket.NewPacketSource(r.handle, r.handle.LinkType())
+	for r.maxPackets < 1 || len(data) < r.maxPackets {
+		if err := ctx.Err(); err != nil {
+			return data, err
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			break
+		}
 
-	for packet := range packetSource.Packets() {
-		features := r.extractor.Extract(packet)
-		if features != nil {
-			data = append(data, features)
+		packet, err := packetSource.NextPacket()
+		switch {
+		case err == nil:
+		case isTimeout(err):
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return data, nil
+		default:
+			return data, err
 		}
+
+		data = append(data, r.extractor.Extract(packet))
 	}
 
 	return data, nil
@@ -67,16 +201,92 @@ func (r *Reader) Read() ([][]float64, error) {
 
 // Stream returns a channel of feature vectors for real-time processing.
 func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	if err := r.checkPool(); err != nil {
+		return nil, err
+	}
+	return stream(ctx, r, func(packet gopacket.Packet, _ uint64) ([]float64, bool) {
+		return r.extract(packet), true
+	})
+}
+
+// StreamSamples is Stream with each feature vector stamped with its
+// packet's capture time and sequence number.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	if err := r.checkPool(); err != nil {
+		return nil, err
+	}
+	return stream(ctx, r, func(packet gopacket.Packet, seq uint64) (guardio.Sample, bool) {
+		return guardio.Sample{Features: r.extract(packet), Time: timestamp(packet), Seq: seq}, true
+	})
+}
+
+// StreamPackets returns a channel of packet header summaries, for
+// detection profiles. It does not extract feature vectors.
+func (r *Reader) StreamPackets(ctx context.Context) (<-chan guardio.Packet, error) {
+	return stream(ctx, r, func(packet gopacket.Packet, _ uint64) (guardio.Packet, bool) {
+		return Summarize(packet), true
+	})
+}
+
+// StreamDNS returns a channel of the DNS queries and responses in the
+// capture, for detection profiles. Other packets are skipped.
+func (r *Reader) StreamDNS(ctx context.Context) (<-chan guardio.DNSMessage, error) {
+	return stream(ctx, r, func(packet gopacket.Packet, _ uint64) (guardio.DNSMessage, bool) {
+		return DecodeDNS(packet)
+	})
+}
+
+// StreamTLS returns a channel of the TLS handshakes in the capture, for
+// detection profiles, following up to maxFlows handshakes at once. Other
+// packets are skipped.
+func (r *Reader) StreamTLS(ctx context.Context, maxFlows int) (<-chan guardio.TLSHandshake, error) {
+	decoder := NewTLSDecoder(maxFlows)
+	return stream(ctx, r, func(packet gopacket.Packet, _ uint64) (guardio.TLSHandshake, bool) {
+		return decoder.Decode(packet)
+	})
+}
+
+// checkPool verifies that the sample pool, if any, fits the feature vectors.
+func (r *Reader) checkPool() error {
+	if r.pool != nil && r.pool.Width() != NumFeatures {
+		return fmt.Errorf("sample pool width %d, expected %d", r.pool.Width(), NumFeatures)
+	}
+	return nil
+}
+
+// extract returns the feature vector of packet, taken from the sample pool
+// if there is one.
+func (r *Reader) extract(packet gopacket.Packet) []float64 {
+	if r.pool != nil {
+		return r.extractor.ExtractInto(r.pool.Get(), packet)
+	}
+	return r.extractor.Extract(packet)
+}
+
+// timestamp returns the capture time of packet, or the current time if the
+// capture does not record one.
+func timestamp(packet gopacket.Packet) time.Time {
+	if md := packet.Metadata(); md != nil && !md.Timestamp.IsZero() {
+		return md.Timestamp
+	}
+	return time.Now()
+}
+
+// stream emits wrap(packet, seq) for every packet until the capture ends or
+// ctx is done, skipping packets for which wrap returns false. wrap is
+// called from a single goroutine, in capture order, and seq counts every
+// packet.
+func stream[T any](ctx context.Context, r *Reader, wrap func(packet gopacket.Packet, seq uint64) (T, bool)) (<-chan T, error) {
 	if r.handle == nil {
 		return nil, errors.New("reader not initialized")
 	}
 
-	out := make(chan []float64, 1000)
+	out := make(chan T, 1000)
 	packetSource := gopacket.NewPacketSource(r.handle, r.handle.LinkType())
 
 	go func() {
 		defer close(out)
-		for {
+		for seq := uint64(1); ; seq++ {
 			select {
 			case <-ctx.Done():
 				return
@@ -84,13 +294,14 @@ func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
 				if !ok {
 					return
 				}
-				features := r.extractor.Extract(packet)
-				if features != nil {
-					select {
-					case out <- features:
-					case <-ctx.Done():
-						return
-					}
+				v, ok := wrap(packet, seq)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
 				}
 			}
 		}
@@ -107,6 +318,9 @@ func (r *Reader) Close() error {
 	return nil
 }
 
+// NumFeatures is the length of the feature vectors FeatureExtractor produces.
+const NumFeatures = 8
+
 // FeatureExtractor extracts numerical features from network packets.
 type FeatureExtractor struct {
 	lastTimestamp time.Time
@@ -122,8 +336,12 @@ func NewFeatureExtractor() *FeatureExtractor {
 //
 //	tcp_flags, ip_ttl, payload_size]
 func (e *FeatureExtractor) Extract(packet gopacket.Packet) []float64 {
-	features := make([]float64, 8)
+	return e.ExtractInto(make([]float64, NumFeatures), packet)
+}
 
+// ExtractInto is Extract writing into features, which must be zeroed and
+// have NumFeatures elements. It returns features.
+func (e *FeatureExtractor) ExtractInto(features []float64, packet gopacket.Packet) []float64 {
 	// Packet size
 	features[0] = float64(len(packet.Data()))
 
@@ -180,26 +398,106 @@ func (e *FeatureExtractor) FeatureNames() []string {
 	}
 }
 
+// Any adapts e to guardio.FeatureExtractor, extracting gopacket.Packet
+// records. NewFeatureExtractor().Any() is registered as "packet" in
+// guardio.DefaultExtractors.
+func (e *FeatureExtractor) Any() guardio.FeatureExtractor {
+	return guardio.NewFuncExtractor(e.FeatureNames(), func(p gopacket.Packet) ([]float64, error) {
+		return e.Extract(p), nil
+	})
+}
+
+func init() {
+	guardio.DefaultExtractors.MustRegister("packet", func() (guardio.FeatureExtractor, error) {
+		return NewFeatureExtractor().Any(), nil
+	})
+}
+
 // encodeTCPFlags converts TCP flags to a numeric value.
 func encodeTCPFlags(tcp *layers.TCP) float64 {
-	var flags float64
-	if tcp.SYN {
-		flags++
+	return float64(tcpFlags(tcp))
+}
+
+// tcpFlags packs the flags of tcp into the guardio.Flag* bits.
+func tcpFlags(tcp *layers.TCP) uint8 {
+	var flags uint8
+	for _, f := range []struct {
+		set bool
+		bit uint8
+	}{
+		{tcp.SYN, guardio.FlagSYN},
+		{tcp.ACK, guardio.FlagACK},
+		{tcp.FIN, guardio.FlagFIN},
+		{tcp.RST, guardio.FlagRST},
+		{tcp.PSH, guardio.FlagPSH},
+		{tcp.URG, guardio.FlagURG},
+	} {
+		if f.set {
+			flags |= f.bit
+		}
 	}
-	if tcp.ACK {
-		flags += 2
+	return flags
+}
+
+// Summarize returns the header summary of packet. Addresses are left
+// invalid for packets without an IP layer, MAC addresses nil for packets
+// without an Ethernet layer, ports and flags zero for protocols other than
+// TCP and UDP.
+func Summarize(packet gopacket.Packet) guardio.Packet {
+	p := guardio.Packet{Time: timestamp(packet), Length: len(packet.Data())}
+
+	if eth, ok := packet.LinkLayer().(*layers.Ethernet); ok {
+		p.SrcMAC, p.DstMAC = eth.SrcMAC, eth.DstMAC
 	}
-	if tcp.FIN {
-		flags += 4
+
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		p.Src, _ = netip.AddrFromSlice(ip.SrcIP.To4())
+		p.Dst, _ = netip.AddrFromSlice(ip.DstIP.To4())
+		p.Protocol = uint8(ip.Protocol)
+	case *layers.IPv6:
+		p.Src, _ = netip.AddrFromSlice(ip.SrcIP.To16())
+		p.Dst, _ = netip.AddrFromSlice(ip.DstIP.To16())
+		p.Protocol = uint8(ip.NextHeader)
 	}
-	if tcp.RST {
-		flags += 8
+
+	switch t := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		p.Protocol = guardio.ProtoTCP
+		p.SrcPort, p.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+		p.Flags = tcpFlags(t)
+	case *layers.UDP:
+		p.Protocol = guardio.ProtoUDP
+		p.SrcPort, p.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
 	}
-	if tcp.PSH {
-		flags += 16
+
+	if app := packet.ApplicationLayer(); app != nil {
+		p.Payload = len(app.Payload())
 	}
-	if tcp.URG {
-		flags += 32
+	return p
+}
+
+// DecodeDNS returns the summary of the DNS message packet carries, and
+// false if it carries none or one without a question.
+func DecodeDNS(packet gopacket.Packet) (guardio.DNSMessage, bool) {
+	dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || len(dns.Questions) == 0 {
+		return guardio.DNSMessage{}, false
 	}
-	return flags
+	p := Summarize(packet)
+	m := guardio.DNSMessage{
+		Time:     p.Time,
+		Client:   p.Src,
+		Server:   p.Dst,
+		ID:       dns.ID,
+		Name:     strings.TrimSuffix(strings.ToLower(string(dns.Questions[0].Name)), "."),
+		Type:     uint16(dns.Questions[0].Type),
+		Response: dns.QR,
+	}
+	if dns.QR {
+		m.Client, m.Server = p.Dst, p.Src
+		m.RCode = uint8(dns.ResponseCode)
+		m.Answers = len(dns.Answers)
+	}
+	return m, true
 }
@@ -0,0 +1,155 @@
+package pcap
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// testPackets returns n TCP packets from client to server, a second apart.
+func testPackets(t *testing.T, n int) [][]byte {
+	t.Helper()
+	packets := make([][]byte, n)
+	for i := range packets {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: client.AsSlice(), DstIP: server.AsSlice()}
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(50000 + i), DstPort: 443, SYN: true}
+		require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(make([]byte, 10*i))))
+		packets[i] = buf.Bytes()
+	}
+	return packets
+}
+
+func captureInfo(i int, data []byte) gopacket.CaptureInfo {
+	return gopacket.CaptureInfo{Timestamp: now.Add(time.Duration(i) * time.Second), CaptureLength: len(data), Length: len(data)}
+}
+
+// writeCapture writes packets to a capture file in format "pcap",
+// "pcap.gz" or "pcapng", and returns its path.
+func writeCapture(t *testing.T, format string, packets [][]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture."+format)
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	switch format {
+	case "pcapng":
+		w, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		require.NoError(t, err)
+		for i, p := range packets {
+			require.NoError(t, w.WritePacket(captureInfo(i, p), p))
+		}
+		require.NoError(t, w.Flush())
+	default:
+		var out io.Writer = f
+		if format == "pcap.gz" {
+			gz := gzip.NewWriter(f)
+			defer gz.Close()
+			out = gz
+		}
+		w := pcapgo.NewWriter(out)
+		require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+		for i, p := range packets {
+			require.NoError(t, w.WritePacket(captureInfo(i, p), p))
+		}
+	}
+	return path
+}
+
+func TestFileReader(t *testing.T) {
+	packets := testPackets(t, 5)
+	for _, format := range []string{"pcap", "pcap.gz", "pcapng"} {
+		t.Run(format, func(t *testing.T) {
+			path := writeCapture(t, format, packets)
+
+			r, err := NewFileReader(path)
+			require.NoError(t, err)
+			data, err := r.Read()
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Len(t, data, len(packets))
+			for i, row := range data {
+				assert.Len(t, row, NumFeatures)
+				assert.Equal(t, float64(len(packets[i])), row[0], "packet %d size", i)
+			}
+
+			r, err = NewFileReader(path, WithMaxPackets(2))
+			require.NoError(t, err)
+			defer r.Close()
+			data, err = r.Read()
+			require.NoError(t, err)
+			assert.Len(t, data, 2)
+		})
+	}
+}
+
+func TestRegisteredExtractor(t *testing.T) {
+	x, err := guardio.NewExtractor("packet")
+	require.NoError(t, err)
+	assert.Equal(t, NewFeatureExtractor().FeatureNames(), x.FeatureNames())
+
+	data := testPackets(t, 1)[0]
+	features, err := x.Extract(gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default))
+	require.NoError(t, err)
+	assert.Equal(t, float64(len(data)), features[0])
+	_, err = x.Extract(data)
+	assert.Error(t, err, "raw bytes are not packets")
+}
+
+func TestFileReaderStream(t *testing.T) {
+	r, err := NewFileReader(writeCapture(t, "pcapng", testPackets(t, 3)))
+	require.NoError(t, err)
+	defer r.Close()
+
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+	var seq []uint64
+	for s := range samples {
+		assert.True(t, s.Time.Equal(now.Add(time.Duration(len(seq))*time.Second)), "time %v", s.Time)
+		seq = append(seq, s.Seq)
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, seq)
+}
+
+func TestFileReaderInvalid(t *testing.T) {
+	_, err := NewFileReader(filepath.Join(t.TempDir(), "missing.pcap"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	path := filepath.Join(t.TempDir(), "bad.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("not a capture file"), 0o600))
+	_, err = NewFileReader(path)
+	assert.ErrorContains(t, err, path)
+
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	_, err = NewFileReader(path)
+	assert.Error(t, err)
+}
+
+func TestNewLiveReaderWithoutLibpcap(t *testing.T) {
+	if LiveCapture {
+		t.Skip("built with libpcap")
+	}
+	_, err := NewLiveReader("eth0", 1600, false, time.Second)
+	assert.ErrorIs(t, err, ErrNoLiveCapture)
+}
@@ -0,0 +1,423 @@
+package pcap
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// TLS record and handshake message types.
+const (
+	recordChangeCipherSpec = 20
+	recordHandshake        = 22
+
+	handshakeClientHello     = 1
+	handshakeServerHello     = 2
+	handshakeCertificate     = 11
+	handshakeServerHelloDone = 14
+
+	extensionServerName        = 0
+	extensionSupportedVersions = 43
+
+	fallbackSCSV = 0x5600
+)
+
+// maxHandshake bounds the handshake bytes buffered per direction of a
+// connection: enough for a hello and a long certificate chain.
+const maxHandshake = 64 << 10
+
+// downgradeSentinel prefixes the last byte of the server random of RFC
+// 8446 servers negotiating TLS 1.2 (01) or below (00).
+var downgradeSentinel = []byte("DOWNGRD")
+
+// flowKey identifies a TCP connection from the client's side.
+type flowKey struct {
+	client, server         netip.Addr
+	clientPort, serverPort uint16
+}
+
+// handshake is the state of a connection whose handshake is being read.
+type handshake struct {
+	key     flowKey
+	summary guardio.TLSHandshake
+	client  tlsStream
+	server  tlsStream
+	hello   bool // ServerHello read
+}
+
+// tlsStream reassembles the handshake messages sent in one direction.
+type tlsStream struct {
+	next    uint32 // TCP sequence number expected next
+	started bool
+	records []byte // TCP payload not yet split into records
+	data    []byte // handshake bytes not yet split into messages
+	// done is set once nothing more is to be read, as the sender switched
+	// to encryption.
+	done bool
+}
+
+// TLSDecoder follows TCP connections and summarizes their TLS handshakes.
+// It reassembles the handshake across segments, as long as they arrive in
+// order: a connection with a gap in its handshake is dropped. It is not
+// safe for concurrent use.
+type TLSDecoder struct {
+	maxFlows int
+	flows    map[flowKey]*list.Element // of *handshake
+	order    *list.List                // oldest first
+}
+
+// NewTLSDecoder creates a TLSDecoder following up to maxFlows handshakes
+// at once; when a new one would exceed it, the oldest is dropped.
+func NewTLSDecoder(maxFlows int) *TLSDecoder {
+	return &TLSDecoder{
+		maxFlows: max(maxFlows, 1),
+		flows:    make(map[flowKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Decode reads the TLS handshake bytes packet carries and returns the
+// summary of the handshake it completes, if any. A handshake is complete
+// once the server's certificate is read, or once it is known there is
+// none to read: the server chose TLS 1.3, resumed a session or ended its
+// hello without one.
+func (d *TLSDecoder) Decode(packet gopacket.Packet) (guardio.TLSHandshake, bool) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		return guardio.TLSHandshake{}, false
+	}
+	return d.decode(Summarize(packet), tcp.Seq, tcp.Payload)
+}
+
+// decode is Decode on the header summary, sequence number and payload of
+// a TCP segment.
+func (d *TLSDecoder) decode(p guardio.Packet, seq uint32, payload []byte) (guardio.TLSHandshake, bool) {
+	if p.Protocol != guardio.ProtoTCP {
+		return guardio.TLSHandshake{}, false
+	}
+	key := flowKey{p.Src, p.Dst, p.SrcPort, p.DstPort}
+	reverse := flowKey{p.Dst, p.Src, p.DstPort, p.SrcPort}
+
+	if p.Has(guardio.FlagFIN) || p.Has(guardio.FlagRST) {
+		d.drop(key)
+		d.drop(reverse)
+		return guardio.TLSHandshake{}, false
+	}
+	if len(payload) == 0 {
+		return guardio.TLSHandshake{}, false
+	}
+
+	if e, ok := d.flows[reverse]; ok {
+		h := e.Value.(*handshake)
+		if !h.server.feed(seq, payload) {
+			d.drop(reverse)
+			return guardio.TLSHandshake{}, false
+		}
+		return d.serverMessages(h)
+	}
+
+	e, ok := d.flows[key]
+	if !ok {
+		// Only a segment opening with a handshake record can start one.
+		if payload[0] != recordHandshake {
+			return guardio.TLSHandshake{}, false
+		}
+		if d.order.Len() >= d.maxFlows {
+			d.drop(d.order.Front().Value.(*handshake).key)
+		}
+		h := &handshake{key: key}
+		h.summary.Time = p.Time
+		h.summary.Client, h.summary.Server, h.summary.ServerPort = p.Src, p.Dst, p.DstPort
+		e = d.order.PushBack(h)
+		d.flows[key] = e
+	}
+	h := e.Value.(*handshake)
+	if !h.client.feed(seq, payload) {
+		d.drop(key)
+		return guardio.TLSHandshake{}, false
+	}
+	if h.summary.OfferedVersion != 0 {
+		return guardio.TLSHandshake{}, false
+	}
+	if typ, body, ok := h.client.message(); ok {
+		if typ != handshakeClientHello || !parseClientHello(body, &h.summary) {
+			d.drop(key)
+			return guardio.TLSHandshake{}, false
+		}
+		// The rest of the client's flight says nothing of interest.
+		h.client = tlsStream{done: true}
+	}
+	return guardio.TLSHandshake{}, false
+}
+
+// serverMessages reads the server's handshake messages buffered for h
+// and returns its summary once complete.
+func (d *TLSDecoder) serverMessages(h *handshake) (guardio.TLSHandshake, bool) {
+	for {
+		typ, body, ok := h.server.message()
+		if !ok {
+			// A server resuming a session switches to encryption right
+			// after its hello.
+			if h.server.done || len(h.server.records)+len(h.server.data) > maxHandshake {
+				return d.finish(h)
+			}
+			return guardio.TLSHandshake{}, false
+		}
+		switch typ {
+		case handshakeServerHello:
+			if !parseServerHello(body, &h.summary) {
+				d.drop(h.key)
+				return guardio.TLSHandshake{}, false
+			}
+			h.hello = true
+			if h.summary.Version >= tls.VersionTLS13 {
+				return d.finish(h)
+			}
+		case handshakeCertificate:
+			h.summary.Certificate = parseCertificate(body)
+			return d.finish(h)
+		case handshakeServerHelloDone:
+			return d.finish(h)
+		}
+	}
+}
+
+// finish stops following h and returns its summary, if its hellos were
+// read.
+func (d *TLSDecoder) finish(h *handshake) (guardio.TLSHandshake, bool) {
+	d.drop(h.key)
+	return h.summary, h.hello && h.summary.OfferedVersion != 0
+}
+
+// drop stops following the connection with key.
+func (d *TLSDecoder) drop(key flowKey) {
+	if e, ok := d.flows[key]; ok {
+		d.order.Remove(e)
+		delete(d.flows, key)
+	}
+}
+
+// feed appends the TCP payload of the segment with sequence number seq
+// to s and splits the complete records out of it. It skips
+// retransmissions and reports false on a gap or an oversized handshake.
+func (s *tlsStream) feed(seq uint32, payload []byte) bool {
+	if s.done {
+		return true
+	}
+	end := seq + uint32(len(payload))
+	if s.started {
+		offset := int32(s.next - seq)
+		if offset < 0 {
+			return false
+		}
+		if int(offset) >= len(payload) {
+			return true
+		}
+		payload = payload[offset:]
+	}
+	s.started = true
+	s.next = end
+	s.records = append(s.records, payload...)
+	if len(s.records)+len(s.data) > 2*maxHandshake {
+		return false
+	}
+
+	for len(s.records) >= 5 {
+		typ := s.records[0]
+		n := int(binary.BigEndian.Uint16(s.records[3:5]))
+		if len(s.records) < 5+n {
+			break
+		}
+		switch typ {
+		case recordHandshake:
+			s.data = append(s.data, s.records[5:5+n]...)
+		case recordChangeCipherSpec:
+			s.done = true
+		}
+		s.records = s.records[5+n:]
+		if s.done {
+			s.records = nil
+			break
+		}
+	}
+	return true
+}
+
+// message splits the next complete handshake message out of s.
+func (s *tlsStream) message() (typ uint8, body []byte, ok bool) {
+	if len(s.data) < 4 {
+		return 0, nil, false
+	}
+	n := int(s.data[1])<<16 | int(s.data[2])<<8 | int(s.data[3])
+	if len(s.data) < 4+n {
+		return 0, nil, false
+	}
+	typ, body = s.data[0], s.data[4:4+n]
+	s.data = s.data[4+n:]
+	return typ, body, true
+}
+
+// reader reads the fields of a handshake message, failing for good on
+// the first truncated one.
+type reader struct {
+	b   []byte
+	bad bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.bad || len(r.b) < n {
+		r.bad = true
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) uint8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *reader) uint16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+// vector reads a variable-length field with a length prefix of size
+// bytes.
+func (r *reader) vector(size int) *reader {
+	var n int
+	if size == 1 {
+		n = r.uint8()
+	} else {
+		n = r.uint16()
+	}
+	return &reader{b: r.bytes(n), bad: r.bad}
+}
+
+// extensions calls fn with the type and data of each extension in the
+// rest of r, which may hold none.
+func (r *reader) extensions(fn func(typ int, data *reader)) {
+	if r.bad || len(r.b) == 0 {
+		return
+	}
+	exts := r.vector(2)
+	for !exts.bad && len(exts.b) >= 4 {
+		typ := exts.uint16()
+		fn(typ, exts.vector(2))
+	}
+	r.bad = r.bad || exts.bad
+}
+
+// grease reports whether v is a GREASE value (RFC 8701), sent to keep
+// servers tolerant of unknown values and meaning nothing.
+func grease(v int) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// parseClientHello fills the client's side of h from a ClientHello body.
+func parseClientHello(body []byte, h *guardio.TLSHandshake) bool {
+	r := &reader{b: body}
+	version := r.uint16()
+	r.bytes(32) // random
+	r.vector(1) // session ID
+	suites := r.vector(2)
+	for !suites.bad && len(suites.b) >= 2 {
+		if suites.uint16() == fallbackSCSV {
+			h.Fallback = true
+		}
+	}
+	r.vector(1) // compression methods
+	h.OfferedVersion = uint16(version)
+	r.extensions(func(typ int, data *reader) {
+		switch typ {
+		case extensionServerName:
+			names := data.vector(2)
+			for !names.bad && len(names.b) > 0 {
+				kind, name := names.uint8(), names.vector(2)
+				if kind == 0 && !name.bad {
+					h.ServerName = strings.ToLower(strings.TrimSuffix(string(name.b), "."))
+					break
+				}
+			}
+		case extensionSupportedVersions:
+			versions := data.vector(1)
+			for !versions.bad && len(versions.b) >= 2 {
+				if v := versions.uint16(); !grease(v) && v > int(h.OfferedVersion) {
+					h.OfferedVersion = uint16(v)
+				}
+			}
+		}
+	})
+	return !r.bad
+}
+
+// parseServerHello fills the server's choices in h from a ServerHello
+// body.
+func parseServerHello(body []byte, h *guardio.TLSHandshake) bool {
+	r := &reader{b: body}
+	h.Version = uint16(r.uint16())
+	random := r.bytes(32)
+	r.vector(1) // session ID
+	h.CipherSuite = uint16(r.uint16())
+	r.uint8() // compression method
+	r.extensions(func(typ int, data *reader) {
+		if typ == extensionSupportedVersions {
+			if v := data.uint16(); !data.bad {
+				h.Version = uint16(v)
+			}
+		}
+	})
+	if r.bad {
+		return false
+	}
+	h.DowngradeSentinel = bytes.Equal(random[24:31], downgradeSentinel) && random[31] <= 1
+	return true
+}
+
+// parseCertificate returns the summary of the leaf of a TLS 1.2 and below
+// Certificate message, or nil if it has none or it does not parse.
+func parseCertificate(body []byte) *guardio.TLSCertificate {
+	r := &reader{b: body}
+	r.bytes(3) // length of the chain
+	size := r.bytes(3)
+	if size == nil {
+		return nil
+	}
+	der := r.bytes(int(size[0])<<16 | int(size[1])<<8 | int(size[2]))
+	if der == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+
+	c := &guardio.TLSCertificate{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		SelfSigned: bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+			cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil,
+	}
+	c.SANs = append(c.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+	return c
+}
@@ -0,0 +1,254 @@
+package pcap
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+var (
+	client = netip.MustParseAddr("10.0.0.1")
+	server = netip.MustParseAddr("93.184.216.34")
+	now    = time.Unix(1700000000, 0)
+)
+
+// recorder records what is written to a connection.
+type recorder struct {
+	net.Conn
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (r recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	r.buf.Write(b)
+	r.mu.Unlock()
+	return r.Conn.Write(b)
+}
+
+// certificate returns a certificate for example.com, self-signed or
+// issued by a throwaway CA.
+func certificate(t *testing.T, selfSigned bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+		DNSNames:     []string{"example.com", "www.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("93.184.216.34")},
+	}
+	parent, parentKey := leaf, key
+	if !selfSigned {
+		parentKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		parent = &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "Test CA"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(365 * 24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// runHandshake runs a TLS handshake between client and server configs and
+// returns the bytes each side sent.
+func runHandshake(t *testing.T, clientConfig, serverConfig *tls.Config) (fromClient, fromServer []byte) {
+	t.Helper()
+	c, s := net.Pipe()
+	var mu sync.Mutex
+	var cbuf, sbuf bytes.Buffer
+	tc := tls.Client(recorder{c, &mu, &cbuf}, clientConfig)
+	ts := tls.Server(recorder{s, &mu, &sbuf}, serverConfig)
+
+	done := make(chan struct{})
+	go func() {
+		// Fails once the client hangs up, while sending TLS 1.3 session
+		// tickets no one reads.
+		ts.Handshake()
+		close(done)
+	}()
+	require.NoError(t, tc.Handshake())
+	c.Close()
+	<-done
+	return cbuf.Bytes(), sbuf.Bytes()
+}
+
+// decodeAll feeds the bytes each side sent to d as TCP segments of at
+// most size bytes, the client's first, and returns the summaries.
+func decodeAll(d *TLSDecoder, fromClient, fromServer []byte, size int) []guardio.TLSHandshake {
+	var out []guardio.TLSHandshake
+	feed := func(src, dst netip.Addr, sport, dport uint16, data []byte) {
+		for seq := 0; seq < len(data); seq += size {
+			end := min(seq+size, len(data))
+			p := guardio.Packet{Time: now, Src: src, Dst: dst, SrcPort: sport, DstPort: dport, Protocol: guardio.ProtoTCP, Flags: guardio.FlagACK}
+			if h, ok := d.decode(p, uint32(1000+seq), data[seq:end]); ok {
+				out = append(out, h)
+			}
+		}
+	}
+	feed(client, server, 50000, 443, fromClient)
+	feed(server, client, 443, 50000, fromServer)
+	return out
+}
+
+func TestTLSDecoder(t *testing.T) {
+	ca := certificate(t, false)
+	self := certificate(t, true)
+
+	tests := []struct {
+		name        string
+		client      *tls.Config
+		server      *tls.Config
+		version     uint16
+		offered     uint16
+		sentinel    bool
+		certificate bool
+		selfSigned  bool
+	}{
+		{
+			name:    "tls13",
+			client:  &tls.Config{ServerName: "Example.com", InsecureSkipVerify: true},
+			server:  &tls.Config{Certificates: []tls.Certificate{ca}},
+			version: tls.VersionTLS13,
+			offered: tls.VersionTLS13,
+		},
+		{
+			name:        "tls12",
+			client:      &tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+			server:      &tls.Config{Certificates: []tls.Certificate{ca}, MaxVersion: tls.VersionTLS12},
+			version:     tls.VersionTLS12,
+			offered:     tls.VersionTLS13,
+			certificate: true,
+		},
+		{
+			name:        "self-signed",
+			client:      &tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+			server:      &tls.Config{Certificates: []tls.Certificate{self}, MaxVersion: tls.VersionTLS12},
+			version:     tls.VersionTLS12,
+			offered:     tls.VersionTLS13,
+			certificate: true,
+			selfSigned:  true,
+		},
+		{
+			name:        "downgraded",
+			client:      &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12},
+			server:      &tls.Config{Certificates: []tls.Certificate{ca}},
+			version:     tls.VersionTLS12,
+			offered:     tls.VersionTLS12,
+			sentinel:    true,
+			certificate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fromClient, fromServer := runHandshake(t, tt.client, tt.server)
+			for _, size := range []int{100, 1460, 1 << 16} {
+				d := NewTLSDecoder(10)
+				got := decodeAll(d, fromClient, fromServer, size)
+				require.Len(t, got, 1, "segments of %d bytes", size)
+				h := got[0]
+				assert.Equal(t, client, h.Client)
+				assert.Equal(t, server, h.Server)
+				assert.Equal(t, uint16(443), h.ServerPort)
+				assert.Equal(t, "example.com", h.ServerName)
+				assert.Equal(t, tt.offered, h.OfferedVersion)
+				assert.Equal(t, tt.version, h.Version)
+				assert.NotZero(t, h.CipherSuite)
+				assert.False(t, h.WeakCipher())
+				assert.False(t, h.Fallback)
+				assert.Equal(t, tt.sentinel, h.DowngradeSentinel)
+				if !tt.certificate {
+					assert.Nil(t, h.Certificate)
+					continue
+				}
+				require.NotNil(t, h.Certificate)
+				assert.Equal(t, "CN=example.com", h.Certificate.Subject)
+				assert.Equal(t, tt.selfSigned, h.Certificate.SelfSigned)
+				assert.Equal(t, []string{"example.com", "www.example.com", "93.184.216.34"}, h.Certificate.SANs)
+				assert.Equal(t, 90*24*time.Hour+time.Hour, h.Certificate.Validity())
+				assert.Empty(t, d.flows, "finished handshakes are no longer followed")
+			}
+		})
+	}
+}
+
+func TestTLSDecoderGap(t *testing.T) {
+	fromClient, fromServer := runHandshake(t,
+		&tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+		&tls.Config{Certificates: []tls.Certificate{certificate(t, false)}, MaxVersion: tls.VersionTLS12})
+
+	d := NewTLSDecoder(10)
+	decodeAll(d, fromClient, nil, 100)
+	p := guardio.Packet{Time: now, Src: server, Dst: client, SrcPort: 443, DstPort: 50000, Protocol: guardio.ProtoTCP}
+	_, ok := d.decode(p, 1000, fromServer[:50])
+	assert.False(t, ok)
+	_, ok = d.decode(p, 1000, fromServer[:50])
+	assert.False(t, ok, "retransmission")
+	assert.Len(t, d.flows, 1)
+	_, ok = d.decode(p, 1100, fromServer[100:])
+	assert.False(t, ok)
+	assert.Empty(t, d.flows, "a gap drops the handshake")
+}
+
+func TestTLSDecoderFlows(t *testing.T) {
+	fromClient, _ := runHandshake(t,
+		&tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+		&tls.Config{Certificates: []tls.Certificate{certificate(t, false)}})
+
+	d := NewTLSDecoder(2)
+	for port := uint16(1); port <= 3; port++ {
+		p := guardio.Packet{Time: now, Src: client, Dst: server, SrcPort: port, DstPort: 443, Protocol: guardio.ProtoTCP}
+		d.decode(p, 1000, fromClient)
+	}
+	assert.Len(t, d.flows, 2)
+	assert.NotContains(t, d.flows, flowKey{client, server, 1, 443}, "the oldest is dropped")
+
+	fin := guardio.Packet{Time: now, Src: server, Dst: client, SrcPort: 443, DstPort: 2, Protocol: guardio.ProtoTCP, Flags: guardio.FlagFIN}
+	d.decode(fin, 0, nil)
+	assert.Len(t, d.flows, 1)
+
+	plain := guardio.Packet{Time: now, Src: client, Dst: server, SrcPort: 4, DstPort: 80, Protocol: guardio.ProtoTCP}
+	d.decode(plain, 0, []byte("GET / HTTP/1.1\r\n"))
+	assert.Len(t, d.flows, 1, "only TLS handshakes are followed")
+}
+
+func TestTLSHandshake(t *testing.T) {
+	h := guardio.TLSHandshake{OfferedVersion: tls.VersionTLS13, Version: tls.VersionTLS10, CipherSuite: tls.TLS_RSA_WITH_RC4_128_SHA}
+	assert.Equal(t, 3, h.Downgrade())
+	assert.True(t, h.WeakCipher())
+	h.CipherSuite = 0x0000 // TLS_NULL_WITH_NULL_NULL
+	assert.True(t, h.WeakCipher())
+	h.CipherSuite = tls.TLS_AES_128_GCM_SHA256
+	assert.False(t, h.WeakCipher())
+
+	c := guardio.TLSCertificate{NotBefore: now, NotAfter: now.Add(time.Hour)}
+	assert.False(t, c.Expired(now.Add(time.Minute)))
+	assert.True(t, c.Expired(now.Add(2*time.Hour)))
+	assert.True(t, c.Expired(now.Add(-time.Minute)))
+	assert.True(t, grease(0x1a1a))
+	assert.False(t, grease(0x1a2a))
+}
@@ -0,0 +1,55 @@
+package io
+
+// defaultPoolSize is the number of idle buffers a SamplePool keeps when no
+// size is given: enough to cover the channel buffers of the stream readers.
+const defaultPoolSize = 1024
+
+// SamplePool recycles fixed-width feature vectors so streaming readers do
+// not allocate one per sample. Readers take buffers with Get; consumers
+// hand them back with Put once they no longer reference the sample.
+//
+// It is a bounded free list rather than a sync.Pool: putting a slice into a
+// sync.Pool allocates a header for it, which would defeat the purpose.
+// A SamplePool is safe for concurrent use.
+type SamplePool struct {
+	width int
+	free  chan []float64
+}
+
+// NewSamplePool creates a pool of vectors with width features. size bounds
+// the number of idle vectors kept; values below 1 use a default.
+func NewSamplePool(width, size int) *SamplePool {
+	if size < 1 {
+		size = defaultPoolSize
+	}
+	return &SamplePool{width: width, free: make(chan []float64, size)}
+}
+
+// Width returns the length of the vectors in the pool.
+func (p *SamplePool) Width() int {
+	return p.width
+}
+
+// Get returns a zeroed vector of the pool's width.
+func (p *SamplePool) Get() []float64 {
+	select {
+	case s := <-p.free:
+		clear(s)
+		return s
+	default:
+		return make([]float64, p.width)
+	}
+}
+
+// Put returns a vector to the pool. Vectors of another capacity are
+// dropped, as are vectors beyond the pool's size. Callers must not use s
+// afterwards.
+func (p *SamplePool) Put(s []float64) {
+	if cap(s) != p.width {
+		return
+	}
+	select {
+	case p.free <- s[:p.width]:
+	default:
+	}
+}
@@ -0,0 +1,51 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplePool(t *testing.T) {
+	tests := []struct {
+		name   string
+		put    []float64
+		reused bool
+	}{
+		{name: "same width", put: make([]float64, 3), reused: true},
+		{name: "resliced", put: make([]float64, 3)[:1], reused: true},
+		{name: "other width", put: make([]float64, 4), reused: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewSamplePool(3, 1)
+			tt.put[0] = 7
+			p.Put(tt.put)
+
+			s := p.Get()
+			assert.Equal(t, []float64{0, 0, 0}, s, "vectors come back zeroed")
+			assert.Equal(t, tt.reused, &s[0] == &tt.put[0])
+		})
+	}
+}
+
+func TestSamplePoolBounded(t *testing.T) {
+	p := NewSamplePool(2, 1)
+	a, b := p.Get(), p.Get()
+	p.Put(a)
+	p.Put(b) // dropped: the pool is full
+
+	assert.Same(t, &a[0], &p.Get()[0])
+	assert.NotSame(t, &b[0], &p.Get()[0])
+}
+
+func TestSamplePoolAllocs(t *testing.T) {
+	p := NewSamplePool(8, 0)
+	p.Put(p.Get())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Put(p.Get())
+	})
+	assert.Zero(t, allocs)
+}
@@ -0,0 +1,130 @@
+// Package protobuf reads and writes samples and detection results in the
+// Protocol Buffers schema of proto/goguardml/v1/goguardml.proto, for
+// consumers outside Go.
+package protobuf
+
+import (
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+// MarshalSample encodes a goguardml.v1.Sample.
+func MarshalSample(s guardio.Sample) []byte {
+	var e pb.Encoder
+	e.Doubles(1, s.Features)
+	e.Timestamp(2, s.Time)
+	e.Uint(3, s.Seq)
+	return e.Bytes()
+}
+
+// UnmarshalSample decodes a goguardml.v1.Sample.
+func UnmarshalSample(b []byte) (guardio.Sample, error) {
+	var s guardio.Sample
+	d := pb.NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			s.Features = d.Doubles(s.Features)
+		case 2:
+			s.Time = d.Timestamp()
+		case 3:
+			s.Seq = d.Uint()
+		}
+	}
+	return s, d.Err()
+}
+
+// MarshalResult encodes a goguardml.v1.Result. It fails if the metadata
+// cannot be represented in JSON.
+func MarshalResult(r guardio.Result) ([]byte, error) {
+	var e pb.Encoder
+	if err := encodeResult(&e, r); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+func encodeResult(e *pb.Encoder, r guardio.Result) error {
+	e.Int(1, r.Timestamp)
+	e.Uint(2, r.Seq)
+	e.Double(3, r.Score)
+	e.Bool(4, r.IsAnomaly)
+	e.Doubles(5, r.Features)
+	if err := e.Struct(6, r.Metadata); err != nil {
+		return err
+	}
+	if r.Explanation != nil {
+		e.Message(7, func(e *pb.Encoder) { pb.EncodeExplanation(e, r.Explanation) })
+	}
+	e.RepeatedString(8, r.FeatureNames)
+	e.Uint(9, uint64(r.Severity))
+	return nil
+}
+
+// UnmarshalResult decodes a goguardml.v1.Result. Metadata values are JSON
+// values: numbers are float64.
+func UnmarshalResult(b []byte) (guardio.Result, error) {
+	var r guardio.Result
+	d := pb.NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			r.Timestamp = d.Int()
+		case 2:
+			r.Seq = d.Uint()
+		case 3:
+			r.Score = d.Double()
+		case 4:
+			r.IsAnomaly = d.Bool()
+		case 5:
+			r.Features = d.Doubles(r.Features)
+		case 6:
+			r.Metadata = d.Struct()
+		case 7:
+			r.Explanation = pb.DecodeExplanation(d)
+		case 8:
+			r.FeatureNames = append(r.FeatureNames, d.String())
+		case 9:
+			r.Severity = detectors.Severity(d.Uint())
+		}
+	}
+	return r, d.Err()
+}
+
+// MarshalResults encodes a goguardml.v1.Results, the response to a
+// PredictRequest.
+func MarshalResults(results []guardio.Result) ([]byte, error) {
+	var (
+		e   pb.Encoder
+		err error
+	)
+	for _, r := range results {
+		e.Message(1, func(e *pb.Encoder) {
+			if rerr := encodeResult(e, r); rerr != nil && err == nil {
+				err = rerr
+			}
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// UnmarshalResults decodes a goguardml.v1.Results.
+func UnmarshalResults(b []byte) ([]guardio.Result, error) {
+	var results []guardio.Result
+	d := pb.NewDecoder(b)
+	for d.Next() {
+		if d.Field() != 1 {
+			continue
+		}
+		r, err := UnmarshalResult(d.RawBytes())
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, d.Err()
+}
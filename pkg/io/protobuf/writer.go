@@ -0,0 +1,124 @@
+package protobuf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// maxRecordBytes bounds a single delimited record.
+const maxRecordBytes = 16 << 20
+
+// Writer writes results as goguardml.v1.Result messages, each preceded by
+// its size as a varint.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewWriter creates a writer that outputs to w.
+// If w implements io.Closer, Close closes it.
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		pw.closer = c
+	}
+	return pw
+}
+
+// NewFileWriter creates a writer that outputs to the named file.
+// The file is created or truncated.
+func NewFileWriter(filename string) (*Writer, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(file), nil
+}
+
+// Write outputs a single result.
+func (w *Writer) Write(result guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(result); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// WriteAll outputs multiple results.
+func (w *Writer) WriteAll(results []guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			return err
+		}
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) write(result guardio.Result) error {
+	b, err := MarshalResult(result)
+	if err != nil {
+		return err
+	}
+	var size [binary.MaxVarintLen64]byte
+	if _, err := w.w.Write(size[:binary.PutUvarint(size[:], uint64(len(b)))]); err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+// Close releases resources.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.w.Flush()
+	if w.closer != nil {
+		err = errors.Join(err, w.closer.Close())
+	}
+	return err
+}
+
+// ReadResults reads results written by Writer.
+func ReadResults(r io.Reader) ([]guardio.Result, error) {
+	br := bufio.NewReader(r)
+	var (
+		results []guardio.Result
+		buf     []byte
+	)
+	for record := 1; ; record++ {
+		size, err := binary.ReadUvarint(br)
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", record, err)
+		}
+		if size > maxRecordBytes {
+			return nil, fmt.Errorf("record %d: %d bytes exceeds the limit of %d", record, size, maxRecordBytes)
+		}
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("record %d: %w", record, err)
+		}
+		result, err := UnmarshalResult(buf)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", record, err)
+		}
+		results = append(results, result)
+	}
+}
@@ -0,0 +1,93 @@
+package protobuf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/pb"
+)
+
+var results = []guardio.Result{
+	{Timestamp: 1700000000, Seq: 1, Score: 0.3, Features: []float64{1, 2}, FeatureNames: []string{"bytes", "port"}},
+	{
+		Timestamp: 1700000001,
+		Seq:       2,
+		Score:     0.9,
+		IsAnomaly: true,
+		Severity:  detectors.SeverityCritical,
+		Features:  []float64{50, -3},
+		Metadata:  map[string]any{"route": "eth0", "port": 443.0},
+		Explanation: &detectors.Explanation{
+			Score:         0.9,
+			Contributions: []float64{0.9, 0.1},
+			Top:           []detectors.FeatureContribution{{Index: 0, Contribution: 0.9, Value: 50}},
+		},
+	},
+	// A result with only default values encodes to no bytes.
+	{},
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Write(results[0]))
+	require.NoError(t, w.WriteAll(results[1:]))
+	require.NoError(t, w.Close())
+
+	got, err := ReadResults(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, results, got)
+}
+
+func TestFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.pb")
+	w, err := NewFileWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteAll(results))
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	got, err := ReadResults(f)
+	require.NoError(t, err)
+	assert.Equal(t, results, got)
+}
+
+func TestReadResultsMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteAll(results[:2]))
+
+	_, err := ReadResults(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.ErrorContains(t, err, "record 2")
+
+	_, err = ReadResults(bytes.NewReader([]byte{0x02, 0x0a, 0x05}))
+	assert.ErrorIs(t, err, pb.ErrMalformed)
+
+	_, err = ReadResults(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}))
+	assert.ErrorContains(t, err, "exceeds the limit")
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+	b, err := MarshalResults(results)
+	require.NoError(t, err)
+	got, err := UnmarshalResults(b)
+	require.NoError(t, err)
+	assert.Equal(t, results, got)
+}
+
+func TestSampleRoundTrip(t *testing.T) {
+	s := guardio.Sample{Features: []float64{1, 0, -1}, Time: time.Unix(1700000000, 5).UTC(), Seq: 9}
+	got, err := UnmarshalSample(MarshalSample(s))
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+}
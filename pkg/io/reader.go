@@ -1,7 +1,11 @@
 // Package io provides input/output utilities for data ingestion.
 package io
 
-import "context"
+import (
+	"context"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
 
 // Reader is the interface for reading data from various sources.
 type Reader interface {
@@ -11,11 +15,17 @@ type Reader interface {
 	// Stream returns a channel of samples for real-time processing.
 	Stream(ctx context.Context) (<-chan []float64, error)
 
+	// StreamSamples is Stream with each feature vector attributed to its
+	// capture time and sequence number.
+	StreamSamples(ctx context.Context) (<-chan Sample, error)
+
 	// Close releases resources.
 	Close() error
 }
 
-// FeatureExtractor extracts numerical features from raw data.
+// FeatureExtractor extracts numerical features from raw data. Extractors
+// are registered by name in an ExtractorRegistry and combined with
+// Compose; NewFuncExtractor adapts extractors of concrete record types.
 type FeatureExtractor interface {
 	// Extract converts raw input to feature vector.
 	Extract(data any) ([]float64, error)
@@ -38,9 +48,17 @@ type Writer interface {
 
 // Result represents an anomaly detection result.
 type Result struct {
-	Timestamp int64          `json:"timestamp"`
-	Score     float64        `json:"score"`
-	IsAnomaly bool           `json:"is_anomaly"`
-	Features  []float64      `json:"features,omitempty"`
-	Metadata  map[string]any `json:"metadata,omitempty"`
+	Timestamp   int64                  `json:"timestamp"`
+	Seq         uint64                 `json:"seq,omitempty"`
+	Score       float64                `json:"score"`
+	IsAnomaly   bool                   `json:"is_anomaly"`
+	Features    []float64              `json:"features,omitempty"`
+	Metadata    map[string]any         `json:"metadata,omitempty"`
+	Explanation *detectors.Explanation `json:"explanation,omitempty"`
+	// FeatureNames names Features, when the writer knows them (see
+	// WithFeatureNames).
+	FeatureNames []string `json:"feature_names,omitempty"`
+	// Severity grades the score, when severity bands are set (see
+	// WithSeverity).
+	Severity detectors.Severity `json:"severity,omitempty"`
 }
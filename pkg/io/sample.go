@@ -0,0 +1,90 @@
+package io
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Sample is a streamed feature vector with the time it was captured and
+// its position in the stream.
+type Sample struct {
+	Features []float64
+	// Time is when the sample was captured: the packet timestamp for PCAP
+	// input, the time the row was read for CSV input.
+	Time time.Time
+	// Seq numbers the samples of a stream consecutively from 1.
+	Seq uint64
+}
+
+// SampleQueue remembers the samples forwarded by SplitSamples until their
+// scores come back, so results can be attributed to a capture time and
+// sequence number. Stream detectors emit scores in input order and keep
+// the input slice as Score.Features, which is what Match relies on.
+type SampleQueue struct {
+	mu      sync.Mutex
+	pending []Sample
+}
+
+// SplitSamples forwards the features of each sample from in to the
+// returned channel, for a stream detector, and records the sample in the
+// returned queue. The channel is closed when in is closed or ctx is done.
+func SplitSamples(ctx context.Context, in <-chan Sample) (<-chan []float64, *SampleQueue) {
+	q := &SampleQueue{}
+	out := make(chan []float64, cap(in))
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok := <-in:
+				if !ok {
+					return
+				}
+				q.mu.Lock()
+				q.pending = append(q.pending, s)
+				q.mu.Unlock()
+
+				select {
+				case out <- s.Features:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, q
+}
+
+// Match returns the sample whose features are the given slice, dropping
+// older samples that never produced a score. Call it once for every score
+// and every rejected sample, before the features are recycled, so a
+// reused buffer cannot be mistaken for an earlier sample.
+func (q *SampleQueue) Match(features []float64) (Sample, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, s := range q.pending {
+		if sameSlice(s.Features, features) {
+			clear(q.pending[:i+1])
+			q.pending = q.pending[i+1:]
+			return s, true
+		}
+	}
+	return Sample{}, false
+}
+
+// Len returns the number of samples awaiting a match.
+func (q *SampleQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// sameSlice reports whether a and b are the same slice of memory.
+func sameSlice(a, b []float64) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
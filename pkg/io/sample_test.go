@@ -0,0 +1,65 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSamples(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	samples := make([]Sample, 4)
+	for i := range samples {
+		samples[i] = Sample{Features: []float64{float64(i)}, Time: start.Add(time.Duration(i) * time.Second), Seq: uint64(i + 1)}
+	}
+
+	in := make(chan Sample, len(samples))
+	for _, s := range samples {
+		in <- s
+	}
+	close(in)
+
+	features, queue := SplitSamples(context.Background(), in)
+	var forwarded [][]float64
+	for f := range features {
+		forwarded = append(forwarded, f)
+	}
+	require.Len(t, forwarded, len(samples))
+	assert.Equal(t, len(samples), queue.Len())
+
+	// Sample 2 was dropped by the detector without being reported: the
+	// match for sample 3 skips it.
+	for _, i := range []int{0, 1, 3} {
+		s, ok := queue.Match(forwarded[i])
+		require.True(t, ok)
+		assert.Equal(t, samples[i].Seq, s.Seq)
+		assert.Equal(t, samples[i].Time, s.Time)
+	}
+	assert.Zero(t, queue.Len())
+
+	_, ok := queue.Match([]float64{0})
+	assert.False(t, ok, "a copy is not the same sample")
+}
+
+func TestSplitSamplesReusedBuffer(t *testing.T) {
+	buf := []float64{1, 2}
+	in := make(chan Sample, 2)
+	in <- Sample{Features: buf, Seq: 1}
+	in <- Sample{Features: buf, Seq: 2}
+	close(in)
+
+	features, queue := SplitSamples(context.Background(), in)
+	for range features {
+	}
+
+	// Matching each score in turn keeps reused buffers apart.
+	s, ok := queue.Match(buf)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), s.Seq)
+	s, ok = queue.Match(buf)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), s.Seq)
+}
@@ -0,0 +1,298 @@
+// Package snapshot saves the samples around incidents for later labeling
+// and retraining. A Writer keeps the results of the last minutes in
+// memory; when the anomaly rate crosses a trigger it writes them to an
+// incident file, followed by the results until the rate has stayed below
+// the trigger for a while.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+)
+
+// Incident describes a saved incident.
+type Incident struct {
+	// Path is the JSON Lines file holding the incident's results.
+	Path string `json:"path"`
+	// Start and End are the times of the first and last result saved,
+	// Triggered the time the anomaly rate crossed the trigger.
+	Start     time.Time `json:"start"`
+	Triggered time.Time `json:"triggered"`
+	End       time.Time `json:"end"`
+	Samples   int       `json:"samples"`
+	Anomalies int       `json:"anomalies"`
+}
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithTrigger sets the anomaly rate over the rate window that starts an
+// incident, 0.2 by default.
+func WithTrigger(rate float64) Option {
+	return func(w *Writer) {
+		w.trigger = rate
+	}
+}
+
+// WithWindow sets the window the anomaly rate is measured over, one
+// minute by default.
+func WithWindow(d time.Duration) Option {
+	return func(w *Writer) {
+		w.window = d
+	}
+}
+
+// WithMinSamples sets how many results the rate window must hold before
+// an incident can start, 20 by default, so a single anomaly after a quiet
+// period is not a 100% rate.
+func WithMinSamples(n int) Option {
+	return func(w *Writer) {
+		w.minSamples = n
+	}
+}
+
+// WithBefore sets how far back an incident's results go before it is
+// triggered, 5 minutes by default.
+func WithBefore(d time.Duration) Option {
+	return func(w *Writer) {
+		w.before = d
+	}
+}
+
+// WithAfter sets how long an incident keeps being saved once the anomaly
+// rate is back below the trigger, 5 minutes by default.
+func WithAfter(d time.Duration) Option {
+	return func(w *Writer) {
+		w.after = d
+	}
+}
+
+// WithMaxBuffered caps the results kept in memory for the period before
+// an incident, 100000 by default; the oldest are dropped first.
+func WithMaxBuffered(n int) Option {
+	return func(w *Writer) {
+		w.maxBuffered = n
+	}
+}
+
+// WithIncidentHandler sets a function called with each incident once its
+// file is complete.
+func WithIncidentHandler(fn func(Incident)) Option {
+	return func(w *Writer) {
+		w.onIncident = fn
+	}
+}
+
+// Writer is a guardio.Writer saving incidents to a directory. Results are
+// timed by their Timestamp, or the time they are written if it is zero,
+// so replayed captures are snapshotted as they happened. Use it alongside
+// the regular output with guardio.MultiWriter.
+type Writer struct {
+	dir         string
+	trigger     float64
+	window      time.Duration
+	minSamples  int
+	before      time.Duration
+	after       time.Duration
+	maxBuffered int
+	onIncident  func(Incident)
+	now         func() time.Time
+
+	mu sync.Mutex
+	// rates holds the times and flags of the results in the rate window.
+	rates     []rated
+	anomalies int
+	// buffered holds the results of the before period while idle.
+	buffered []timed
+	// current is the incident being saved, if any.
+	current *incident
+}
+
+type rated struct {
+	at      time.Time
+	anomaly bool
+}
+
+type timed struct {
+	at     time.Time
+	result guardio.Result
+}
+
+// incident is an incident file being written.
+type incident struct {
+	Incident
+	out   *jsonl.Writer
+	until time.Time
+}
+
+// New returns a Writer saving incidents to dir, which is created if
+// needed.
+func New(dir string, opts ...Option) (*Writer, error) {
+	w := &Writer{
+		dir:         dir,
+		trigger:     0.2,
+		window:      time.Minute,
+		minSamples:  20,
+		before:      5 * time.Minute,
+		after:       5 * time.Minute,
+		maxBuffered: 100000,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.trigger <= 0 || w.trigger > 1 {
+		return nil, fmt.Errorf("snapshot: trigger rate %g is not in (0, 1]", w.trigger)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write records result and saves it if an incident is in progress or
+// starts with it.
+func (w *Writer) Write(result guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(result)
+}
+
+// WriteAll records results in order.
+func (w *Writer) WriteAll(results []guardio.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) write(result guardio.Result) error {
+	at := w.now()
+	if result.Timestamp != 0 {
+		at = time.Unix(result.Timestamp, 0)
+	}
+	high := w.rate(at, result.IsAnomaly)
+
+	if c := w.current; c != nil {
+		if err := c.add(result, at); err != nil {
+			return err
+		}
+		if high {
+			c.until = at.Add(w.after)
+		} else if !at.Before(c.until) {
+			return w.finish()
+		}
+		return nil
+	}
+
+	// Pooled feature vectors are reused once written, so buffered results
+	// keep copies.
+	result.Features = slices.Clone(result.Features)
+	w.buffered = append(w.buffered, timed{at: at, result: result})
+	cut := 0
+	for cut < len(w.buffered) && (len(w.buffered)-cut > w.maxBuffered || at.Sub(w.buffered[cut].at) > w.before) {
+		cut++
+	}
+	// Reslicing rather than shifting keeps trimming constant-time; append
+	// reallocates the live part once the capacity runs out.
+	w.buffered = w.buffered[cut:]
+	if !high {
+		return nil
+	}
+	return w.start(at)
+}
+
+// rate records a result at time at and reports whether the anomaly rate
+// over the window is at the trigger.
+func (w *Writer) rate(at time.Time, anomaly bool) bool {
+	w.rates = append(w.rates, rated{at: at, anomaly: anomaly})
+	if anomaly {
+		w.anomalies++
+	}
+	cut := 0
+	for cut < len(w.rates) && at.Sub(w.rates[cut].at) > w.window {
+		if w.rates[cut].anomaly {
+			w.anomalies--
+		}
+		cut++
+	}
+	w.rates = w.rates[cut:]
+	n := len(w.rates)
+	return n >= w.minSamples && float64(w.anomalies) >= w.trigger*float64(n)
+}
+
+// start opens an incident file triggered at time at and saves the
+// buffered results to it.
+func (w *Writer) start(at time.Time) error {
+	path := filepath.Join(w.dir, "incident-"+at.UTC().Format("20060102T150405Z")+".jsonl")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		path = filepath.Join(w.dir, fmt.Sprintf("incident-%s-%d.jsonl", at.UTC().Format("20060102T150405Z"), i))
+	}
+	out, err := jsonl.NewFileWriter(path)
+	if err != nil {
+		return err
+	}
+	c := &incident{
+		Incident: Incident{Path: path, Triggered: at},
+		out:      out,
+		until:    at.Add(w.after),
+	}
+	w.current = c
+	for _, b := range w.buffered {
+		if err := c.add(b.result, b.at); err != nil {
+			return err
+		}
+	}
+	w.buffered = w.buffered[:0]
+	return nil
+}
+
+// finish closes the current incident and reports it.
+func (w *Writer) finish() error {
+	c := w.current
+	w.current = nil
+	if err := c.out.Close(); err != nil {
+		return err
+	}
+	if w.onIncident != nil {
+		w.onIncident(c.Incident)
+	}
+	return nil
+}
+
+func (c *incident) add(result guardio.Result, at time.Time) error {
+	if c.Samples == 0 {
+		c.Start = at
+	}
+	c.End = at
+	c.Samples++
+	if result.IsAnomaly {
+		c.Anomalies++
+	}
+	return c.out.Write(result)
+}
+
+// Close completes the incident in progress, if any.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.current == nil {
+		return nil
+	}
+	return w.finish()
+}
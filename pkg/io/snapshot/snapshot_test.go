@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+)
+
+func readIncident(t *testing.T, path string) []guardio.Result {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	results, err := jsonl.ReadResults(f)
+	require.NoError(t, err)
+	return results
+}
+
+func TestWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "incidents")
+	var incidents []Incident
+	w, err := New(dir,
+		WithTrigger(0.5), WithWindow(10*time.Second), WithMinSamples(5),
+		WithBefore(30*time.Second), WithAfter(10*time.Second),
+		WithIncidentHandler(func(i Incident) { incidents = append(incidents, i) }))
+	require.NoError(t, err)
+
+	const t0 = 1700000000
+	sample := []float64{0}
+	for i := range 200 {
+		// A pooled sample, overwritten once written.
+		sample[0] = float64(i)
+		anomaly := i >= 100 && i < 110
+		require.NoError(t, w.Write(guardio.Result{Timestamp: t0 + int64(i), IsAnomaly: anomaly, Features: sample}))
+	}
+	require.Len(t, incidents, 1)
+	inc := incidents[0]
+	assert.Equal(t, time.Unix(t0+75, 0), inc.Start, "30s before the trigger")
+	assert.Equal(t, time.Unix(t0+105, 0), inc.Triggered, "6 anomalies in the last 11 results")
+	assert.Equal(t, time.Unix(t0+124, 0), inc.End, "10s after the rate fell back")
+	assert.Equal(t, 50, inc.Samples)
+	assert.Equal(t, 10, inc.Anomalies)
+	assert.Equal(t, filepath.Join(dir, "incident-20231114T221505Z.jsonl"), inc.Path)
+
+	results := readIncident(t, inc.Path)
+	require.Len(t, results, 50)
+	for i, r := range results {
+		assert.Equal(t, []float64{float64(75 + i)}, r.Features, "buffered samples are copies")
+	}
+
+	// Close completes an incident in progress.
+	require.NoError(t, w.WriteAll([]guardio.Result{
+		{Timestamp: t0 + 300, IsAnomaly: true}, {Timestamp: t0 + 300, IsAnomaly: true},
+		{Timestamp: t0 + 300, IsAnomaly: true}, {Timestamp: t0 + 301, IsAnomaly: true},
+		{Timestamp: t0 + 301, IsAnomaly: true},
+	}))
+	assert.Len(t, incidents, 1)
+	require.NoError(t, w.Close())
+	require.Len(t, incidents, 2)
+	assert.Equal(t, 5, incidents[1].Samples)
+	assert.Len(t, readIncident(t, incidents[1].Path), 5)
+}
+
+func TestWriterMaxBuffered(t *testing.T) {
+	var got Incident
+	w, err := New(t.TempDir(), WithWindow(0), WithMinSamples(1), WithMaxBuffered(3),
+		WithIncidentHandler(func(i Incident) { got = i }))
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, w.Write(guardio.Result{Timestamp: 1700000000 + int64(i), Features: []float64{float64(i)}}))
+	}
+	require.NoError(t, w.Write(guardio.Result{Timestamp: 1700000010, IsAnomaly: true}))
+	require.NoError(t, w.Close())
+	assert.Equal(t, 3, got.Samples)
+	assert.Equal(t, time.Unix(1700000008, 0), got.Start)
+}
+
+func TestNewInvalidTrigger(t *testing.T) {
+	_, err := New(t.TempDir(), WithTrigger(0))
+	assert.Error(t, err)
+}
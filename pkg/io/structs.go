@@ -0,0 +1,371 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StructExtractor maps structs of type T to feature vectors following
+// their guardml struct tags, so applications can score their own records
+// without building float slices by hand:
+//
+//	type Flow struct {
+//		BytesOut uint64        `guardml:"feature,name=bytes_out"`
+//		Duration time.Duration `guardml:"feature"`
+//		Proto    string        `guardml:"feature,categories=tcp|udp|icmp"`
+//		Host     string        `guardml:"feature,buckets=16"`
+//		Peer     Endpoint      `guardml:"feature,name=peer"`
+//		Note     string
+//	}
+//
+// Only fields tagged "feature" become features, in field order, named by
+// the name option or else the field name. Numbers and booleans, as 0 or 1,
+// give one feature each, time.Duration its seconds and time.Time its Unix
+// seconds. Arrays give one feature per element, named name.0, name.1 and
+// so on. Tagged structs and pointers to them contribute their own tagged
+// fields, named with a name. prefix, and exported embedded structs
+// contribute theirs unprefixed, tagged or not. Pointers are followed, and
+// a nil pointer gives zeros.
+//
+// Strings and other categorical fields, any type with a string form, need
+// either categories, listing the values that each get a 0/1 feature
+// (name=tcp, name=udp, ...) with all zeros for other values, or buckets,
+// hashing values into that many 0/1 features (name#0, name#1, ...).
+// Numeric fields may use categories too, for codes such as ports whose
+// magnitude means nothing. A field tagged "-" is skipped.
+type StructExtractor[T any] struct {
+	fields []structField
+	names  []string
+}
+
+// structField is a tagged leaf field and how it maps to features.
+type structField struct {
+	index      []int // path from T through embedded and nested structs
+	kind       fieldKind
+	width      int // number of features
+	categories map[string]int
+}
+
+type fieldKind int
+
+const (
+	kindNumber fieldKind = iota
+	kindBool
+	kindDuration
+	kindTime
+	kindArray
+	kindCategory
+	kindBucket
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// NewStructExtractor returns an extractor for T, which must be a struct
+// with at least one tagged feature. Tags that do not fit their field, such
+// as a string without categories or buckets or a slice, are an error.
+func NewStructExtractor[T any]() (*StructExtractor[T], error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct extractor: %s is not a struct", t)
+	}
+	x := &StructExtractor[T]{}
+	if err := x.compile(t, nil, "", map[reflect.Type]bool{}); err != nil {
+		return nil, fmt.Errorf("struct extractor: %s: %w", t, err)
+	}
+	if len(x.fields) == 0 {
+		return nil, fmt.Errorf("struct extractor: %s has no guardml feature fields", t)
+	}
+	return x, nil
+}
+
+// compile appends the features of struct type t, reached through index
+// and named under prefix. seen guards against recursive types.
+func (x *StructExtractor[T]) compile(t reflect.Type, index []int, prefix string, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return fmt.Errorf("recursive type %s", t)
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := append(index[:len(index):len(index)], i)
+		tag, tagged := sf.Tag.Lookup("guardml")
+		if tag == "-" {
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && !tagged && ft.Kind() == reflect.Struct {
+			if err := x.compile(ft, path, prefix, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if !tagged {
+			continue
+		}
+
+		opts, err := parseFeatureTag(tag)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", sf.Name, err)
+		}
+		name := prefix + sf.Name
+		if opts.name != "" {
+			name = prefix + opts.name
+		}
+		if ft.Kind() == reflect.Struct && ft != timeType && opts.categories == nil && opts.buckets == 0 {
+			nested := name + "."
+			if sf.Anonymous && opts.name == "" {
+				nested = prefix
+			}
+			if err := x.compile(ft, path, nested, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := x.addField(ft, path, name, opts); err != nil {
+			return fmt.Errorf("field %s: %w", sf.Name, err)
+		}
+	}
+	return nil
+}
+
+// addField appends the features of a leaf field of type t, or pointing
+// to t.
+func (x *StructExtractor[T]) addField(t reflect.Type, index []int, name string, opts featureTag) error {
+	f := structField{index: index, width: 1}
+	switch {
+	case opts.categories != nil:
+		f.kind = kindCategory
+		f.width = len(opts.categories)
+		f.categories = make(map[string]int, len(opts.categories))
+		for i, c := range opts.categories {
+			f.categories[c] = i
+			x.names = append(x.names, name+"="+c)
+		}
+	case opts.buckets > 0:
+		f.kind = kindBucket
+		f.width = opts.buckets
+		for i := 0; i < opts.buckets; i++ {
+			x.names = append(x.names, name+"#"+strconv.Itoa(i))
+		}
+	case t == durationType:
+		f.kind = kindDuration
+	case t == timeType:
+		f.kind = kindTime
+	case isNumber(t.Kind()):
+		f.kind = kindNumber
+	case t.Kind() == reflect.Bool:
+		f.kind = kindBool
+	case t.Kind() == reflect.Array && (isNumber(t.Elem().Kind()) || t.Elem().Kind() == reflect.Bool):
+		f.kind = kindArray
+		f.width = t.Len()
+		for i := 0; i < f.width; i++ {
+			x.names = append(x.names, name+"."+strconv.Itoa(i))
+		}
+	case t.Kind() == reflect.String:
+		return errors.New("string features need categories or buckets")
+	default:
+		return fmt.Errorf("unsupported feature type %s", t)
+	}
+	if f.kind != kindCategory && f.kind != kindBucket && f.kind != kindArray {
+		x.names = append(x.names, name)
+	}
+	x.fields = append(x.fields, f)
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64 && k != reflect.Uintptr
+}
+
+// featureTag holds the options of a guardml struct tag.
+type featureTag struct {
+	name       string
+	categories []string
+	buckets    int
+}
+
+func parseFeatureTag(tag string) (featureTag, error) {
+	var opts featureTag
+	parts := strings.Split(tag, ",")
+	if parts[0] != "feature" {
+		return opts, fmt.Errorf("guardml tag %q does not start with feature", tag)
+	}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "name":
+			if value == "" {
+				return opts, errors.New("empty feature name")
+			}
+			opts.name = value
+		case "categories":
+			if value == "" {
+				return opts, errors.New("empty categories")
+			}
+			opts.categories = strings.Split(value, "|")
+		case "buckets":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("invalid buckets %q", value)
+			}
+			opts.buckets = n
+		default:
+			return opts, fmt.Errorf("unknown guardml tag option %q", key)
+		}
+	}
+	if opts.categories != nil && opts.buckets > 0 {
+		return opts, errors.New("categories and buckets are exclusive")
+	}
+	return opts, nil
+}
+
+// FeatureNames returns the names of the extracted features, in order.
+func (x *StructExtractor[T]) FeatureNames() []string {
+	return append([]string(nil), x.names...)
+}
+
+// NumFeatures returns the length of the extracted feature vectors.
+func (x *StructExtractor[T]) NumFeatures() int {
+	return len(x.names)
+}
+
+// Extract implements FeatureExtractor for a T or a non-nil *T.
+func (x *StructExtractor[T]) Extract(data any) ([]float64, error) {
+	switch v := data.(type) {
+	case T:
+		return x.Features(v), nil
+	case *T:
+		if v != nil {
+			return x.Features(*v), nil
+		}
+	}
+	return nil, fmt.Errorf("struct extractor: cannot extract %T, want %s", data, reflect.TypeFor[T]())
+}
+
+// Features returns the feature vector of v.
+func (x *StructExtractor[T]) Features(v T) []float64 {
+	return x.ExtractInto(make([]float64, len(x.names)), v)
+}
+
+// ExtractInto writes the features of v into dst, reusing its storage when
+// it is large enough, and returns the filled vector.
+func (x *StructExtractor[T]) ExtractInto(dst []float64, v T) []float64 {
+	if cap(dst) < len(x.names) {
+		dst = make([]float64, len(x.names))
+	}
+	dst = dst[:len(x.names)]
+	clear(dst)
+
+	rv := reflect.ValueOf(&v).Elem()
+	pos := 0
+	for _, f := range x.fields {
+		out := dst[pos : pos+f.width]
+		pos += f.width
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			continue // nil embedded pointer
+		}
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		f.extract(out, fv)
+	}
+	return dst
+}
+
+// ExtractAll returns the feature vectors of items, ready for Fit.
+func (x *StructExtractor[T]) ExtractAll(items []T) [][]float64 {
+	data := make([][]float64, len(items))
+	for i, item := range items {
+		data[i] = x.Features(item)
+	}
+	return data
+}
+
+// extract writes the features of field value v to out.
+func (f *structField) extract(out []float64, v reflect.Value) {
+	switch f.kind {
+	case kindNumber:
+		out[0] = number(v)
+	case kindBool:
+		if v.Bool() {
+			out[0] = 1
+		}
+	case kindDuration:
+		out[0] = time.Duration(v.Int()).Seconds()
+	case kindTime:
+		if t := v.Interface().(time.Time); !t.IsZero() {
+			out[0] = float64(t.UnixNano()) / 1e9
+		}
+	case kindArray:
+		for i := range out {
+			if e := v.Index(i); e.Kind() == reflect.Bool {
+				if e.Bool() {
+					out[i] = 1
+				}
+			} else {
+				out[i] = number(e)
+			}
+		}
+	case kindCategory:
+		if i, ok := f.categories[categoryOf(v)]; ok {
+			out[i] = 1
+		}
+	case kindBucket:
+		h := fnv.New32a()
+		h.Write([]byte(categoryOf(v)))
+		out[h.Sum32()%uint32(len(out))] = 1
+	}
+}
+
+// number returns the value of a numeric field.
+func number(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+// categoryOf returns the string form of a categorical field value.
+func categoryOf(v reflect.Value) string {
+	switch {
+	case v.Kind() == reflect.String:
+		return v.String()
+	case v.CanInt():
+		return strconv.FormatInt(v.Int(), 10)
+	case v.CanUint():
+		return strconv.FormatUint(v.Uint(), 10)
+	case v.CanFloat():
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case v.Kind() == reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case v.CanInterface():
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,107 @@
+package io
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEndpoint struct {
+	Port uint16 `guardml:"feature,name=port,categories=22|80|443"`
+	Up   bool   `guardml:"feature,name=up"`
+}
+
+type TestMeta struct {
+	Retries int `guardml:"feature,name=retries"`
+}
+
+type testFlow struct {
+	TestMeta
+	BytesOut uint64        `guardml:"feature,name=bytes_out"`
+	Duration time.Duration `guardml:"feature,name=duration"`
+	Start    time.Time     `guardml:"feature,name=start"`
+	Proto    string        `guardml:"feature,name=proto,categories=tcp|udp"`
+	Host     string        `guardml:"feature,name=host,buckets=4"`
+	Peer     *testEndpoint `guardml:"feature,name=peer"`
+	Hist     [2]float32    `guardml:"feature,name=hist"`
+	Note     string
+	Skipped  float64 `guardml:"-"`
+}
+
+func TestStructExtractor(t *testing.T) {
+	x, err := NewStructExtractor[testFlow]()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"retries", "bytes_out", "duration", "start", "proto=tcp", "proto=udp",
+		"host#0", "host#1", "host#2", "host#3",
+		"peer.port=22", "peer.port=80", "peer.port=443", "peer.up", "hist.0", "hist.1",
+	}, x.FeatureNames())
+	assert.Equal(t, 16, x.NumFeatures())
+
+	flow := testFlow{
+		TestMeta: TestMeta{Retries: 2},
+		BytesOut: 1500,
+		Duration: 1500 * time.Millisecond,
+		Start:    time.Unix(1700000000, 0),
+		Proto:    "udp",
+		Host:     "10.0.0.1",
+		Peer:     &testEndpoint{Port: 443, Up: true},
+		Hist:     [2]float32{0.5, 1},
+	}
+	features, err := x.Extract(&flow)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2, 1500, 1.5, 1700000000, 0, 1}, features[:6])
+	assert.Equal(t, 1.0, features[6]+features[7]+features[8]+features[9], "one hashed bucket")
+	assert.Equal(t, []float64{0, 0, 1, 1, 0.5, 1}, features[10:])
+
+	// The same value always hashes to the same bucket, unknown categories
+	// and nil pointers give zeros.
+	again := x.Features(flow)
+	assert.Equal(t, features, again)
+	flow.Proto, flow.Peer = "sctp", nil
+	features = x.ExtractInto(features, flow)
+	assert.Equal(t, []float64{0, 0}, features[4:6])
+	assert.Equal(t, []float64{0, 0, 0, 0}, features[10:14])
+
+	assert.Len(t, x.ExtractAll([]testFlow{flow, flow}), 2)
+	_, err = x.Extract("flow")
+	assert.Error(t, err)
+	_, err = x.Extract((*testFlow)(nil))
+	assert.Error(t, err)
+}
+
+func TestStructExtractorErrors(t *testing.T) {
+	_, err := NewStructExtractor[int]()
+	assert.ErrorContains(t, err, "not a struct")
+	_, err = NewStructExtractor[struct{ A int }]()
+	assert.ErrorContains(t, err, "no guardml feature fields")
+	_, err = NewStructExtractor[struct {
+		A string `guardml:"feature"`
+	}]()
+	assert.ErrorContains(t, err, "need categories or buckets")
+	_, err = NewStructExtractor[struct {
+		A []float64 `guardml:"feature"`
+	}]()
+	assert.ErrorContains(t, err, "unsupported feature type")
+	_, err = NewStructExtractor[struct {
+		A int `guardml:"feature,buckets=0"`
+	}]()
+	assert.ErrorContains(t, err, "invalid buckets")
+	_, err = NewStructExtractor[struct {
+		A int `guardml:"feature,scale=2"`
+	}]()
+	assert.ErrorContains(t, err, "unknown guardml tag option")
+	_, err = NewStructExtractor[struct {
+		A int `guardml:"metric"`
+	}]()
+	assert.ErrorContains(t, err, "does not start with feature")
+
+	type node struct {
+		Value int   `guardml:"feature"`
+		Next  *node `guardml:"feature"`
+	}
+	_, err = NewStructExtractor[node]()
+	assert.ErrorContains(t, err, "recursive type")
+}
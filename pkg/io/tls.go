@@ -0,0 +1,79 @@
+package io
+
+import (
+	"crypto/tls"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// TLSHandshake summarizes the cleartext part of a TLS handshake: what the
+// client offered, what the server chose and, before TLS 1.3, the
+// certificate it presented.
+type TLSHandshake struct {
+	// Time is when the client sent its hello.
+	Time       time.Time
+	Client     netip.Addr
+	Server     netip.Addr
+	ServerPort uint16
+	// ServerName is the SNI the client asked for, in lower case, empty
+	// if it sent none.
+	ServerName string
+	// OfferedVersion is the highest version the client offered, such as
+	// tls.VersionTLS13.
+	OfferedVersion uint16
+	// Fallback is set when the client offered TLS_FALLBACK_SCSV: it is
+	// retrying with a lower version after a failed handshake.
+	Fallback bool
+	// Version and CipherSuite are what the server chose.
+	Version     uint16
+	CipherSuite uint16
+	// DowngradeSentinel is set when the server random ends with the RFC
+	// 8446 marker of a server that supports TLS 1.3 negotiating a lower
+	// version: a client that offered TLS 1.3 seeing it is being
+	// downgraded.
+	DowngradeSentinel bool
+	// Certificate is the leaf certificate of the server, nil for TLS 1.3
+	// and resumed handshakes, whose certificates are encrypted or absent.
+	Certificate *TLSCertificate
+}
+
+// TLSCertificate summarizes an X.509 certificate.
+type TLSCertificate struct {
+	Subject   string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// SelfSigned is set when the certificate is its own issuer and its
+	// signature verifies with its own key.
+	SelfSigned bool
+	// SANs holds the DNS names and IP addresses of the subject
+	// alternative names.
+	SANs []string
+}
+
+// Validity returns how long c is valid for.
+func (c *TLSCertificate) Validity() time.Duration {
+	return c.NotAfter.Sub(c.NotBefore)
+}
+
+// Expired reports whether c is not valid at t, having expired or not yet
+// being valid.
+func (c *TLSCertificate) Expired(t time.Time) bool {
+	return t.Before(c.NotBefore) || t.After(c.NotAfter)
+}
+
+// Downgrade returns how many versions below the one the client offered
+// the server negotiated, zero when it negotiated the highest offered one.
+func (h TLSHandshake) Downgrade() int {
+	return max(0, int(h.OfferedVersion)-int(h.Version))
+}
+
+// WeakCipher reports whether the chosen cipher suite is one Go considers
+// insecure, such as RC4 or 3DES suites, or does not implement at all,
+// such as NULL, export and anonymous suites.
+func (h TLSHandshake) WeakCipher() bool {
+	return !slices.ContainsFunc(tls.CipherSuites(), func(s *tls.CipherSuite) bool {
+		return s.ID == h.CipherSuite
+	})
+}
@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes.
+const (
+	closeNormal        = 1000
+	closeGoingAway     = 1001
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+// acceptGUID is appended to the key of an opening handshake to derive
+// Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// acceptKey returns the Sec-WebSocket-Accept value answering key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// errTooBig reports a message over the size limit.
+var errTooBig = errors.New("websocket: message exceeds the size limit")
+
+// conn frames WebSocket messages over a network connection. Clients mask
+// the frames they send and servers must not; each side rejects frames
+// that break the rule. Writes are serialized, as pings and pongs may
+// come from another goroutine than the data.
+type conn struct {
+	net.Conn
+	br         *bufio.Reader
+	client     bool
+	maxMessage int
+	// idle is how long to wait for a frame, any frame, before giving up
+	// on the peer; zero waits forever.
+	idle time.Duration
+
+	wmu sync.Mutex
+}
+
+// writeFrame sends a single, final frame.
+func (c *conn) writeFrame(op byte, payload []byte) error {
+	b := make([]byte, 0, 14+len(payload))
+	b = append(b, 0x80|op)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = binary.BigEndian.AppendUint16(append(b, maskBit|126), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, maskBit|127), uint64(n))
+	}
+	if c.client {
+		var key [4]byte
+		rand.Read(key[:])
+		b = append(b, key[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		mask(b[start:], key)
+	} else {
+		b = append(b, payload...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(b)
+	return err
+}
+
+func mask(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// closeWith sends a close frame with code and reason.
+func (c *conn) closeWith(code int, reason string) error {
+	return c.writeFrame(opClose, append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...))
+}
+
+// frame is a received frame.
+type frame struct {
+	fin     bool
+	op      byte
+	payload []byte
+}
+
+// readFrame receives a frame. Data frames may carry at most budget
+// payload bytes.
+func (c *conn) readFrame(budget int) (frame, error) {
+	if c.idle > 0 {
+		c.SetReadDeadline(time.Now().Add(c.idle))
+	}
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: h[0]&0x80 != 0, op: h[0] & 0x0f}
+	if h[0]&0x70 != 0 {
+		return f, errors.New("websocket: reserved bits set")
+	}
+	masked := h[1]&0x80 != 0
+	if masked == c.client {
+		return f, errors.New("websocket: frame masking is wrong for the direction")
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.op >= opClose && (n > 125 || !f.fin) {
+		return f, errors.New("websocket: malformed control frame")
+	}
+	if f.op < opClose && n > uint64(budget) {
+		return f, errTooBig
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		mask(f.payload, key)
+	}
+	return f, nil
+}
+
+// readMessage receives the next data message, reassembling fragments and
+// answering the control frames in between. When the peer closes the
+// connection it returns io.EOF; protocol violations and oversized
+// messages are answered with a close frame before the error is returned.
+func (c *conn) readMessage() (op byte, data []byte, err error) {
+	for {
+		f, err := c.readFrame(c.maxMessage - len(data))
+		if err != nil {
+			switch {
+			case errors.Is(err, errTooBig):
+				c.closeWith(closeTooBig, "message too big")
+			case !isNetErr(err):
+				c.closeWith(closeProtocolError, "protocol error")
+			}
+			return 0, nil, err
+		}
+
+		switch f.op {
+		case opPing:
+			if err := c.writeFrame(opPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := closeNormal
+			if len(f.payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(f.payload))
+			}
+			c.closeWith(code, "")
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if op != 0 {
+				c.closeWith(closeProtocolError, "protocol error")
+				return 0, nil, errors.New("websocket: new message within a fragmented one")
+			}
+			op = f.op
+		case opContinuation:
+			if op == 0 {
+				c.closeWith(closeProtocolError, "protocol error")
+				return 0, nil, errors.New("websocket: continuation without a message")
+			}
+		default:
+			c.closeWith(closeProtocolError, "protocol error")
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", f.op)
+		}
+		data = append(data, f.payload...)
+		if f.fin {
+			return op, data, nil
+		}
+	}
+}
+
+// isNetErr reports whether err comes from the connection rather than
+// from what the peer sent.
+func isNetErr(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+// keepAlive pings the peer every interval until done is closed or a ping
+// fails.
+func (c *conn) keepAlive(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.writeFrame(opPing, nil) != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipe returns the client and server ends of an in-memory connection.
+func pipe(t *testing.T) (client, server *conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	client = &conn{Conn: a, br: bufio.NewReader(a), client: true, maxMessage: 1 << 10}
+	server = &conn{Conn: b, br: bufio.NewReader(b), maxMessage: 1 << 10}
+	return client, server
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455, section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestMessages(t *testing.T) {
+	client, server := pipe(t)
+	for _, size := range []int{5, 200, 70000} {
+		client.maxMessage, server.maxMessage = 1<<20, 1<<20
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		go client.writeFrame(opBinary, payload)
+		op, data, err := server.readMessage()
+		require.NoError(t, err)
+		assert.Equal(t, byte(opBinary), op)
+		assert.Equal(t, payload, data, "size %d", size)
+
+		go server.writeFrame(opText, payload)
+		_, data, err = client.readMessage()
+		require.NoError(t, err)
+		assert.Equal(t, payload, data, "size %d", size)
+	}
+}
+
+// rawFrame encodes an unmasked frame as a server sends it.
+func rawFrame(fin bool, op byte, payload string) []byte {
+	b := []byte{op, byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	return append(b, payload...)
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	client, server := pipe(t)
+	go func() {
+		var b []byte
+		b = append(b, rawFrame(false, opText, `{"a":`)...)
+		b = append(b, rawFrame(true, opPing, "hb")...)
+		b = append(b, rawFrame(false, opContinuation, `1`)...)
+		b = append(b, rawFrame(true, opContinuation, `}`)...)
+		server.Write(b)
+	}()
+
+	pong := make(chan frame, 1)
+	go func() {
+		f, _ := server.readFrame(100)
+		pong <- f
+	}()
+	op, data, err := client.readMessage()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opText), op)
+	assert.Equal(t, `{"a":1}`, string(data))
+	assert.Equal(t, frame{fin: true, op: opPong, payload: []byte("hb")}, <-pong)
+}
+
+func TestClose(t *testing.T) {
+	client, server := pipe(t)
+	go client.closeWith(closeGoingAway, "bye")
+	reply := make(chan frame, 1)
+	go func() {
+		f, _ := client.readFrame(100)
+		reply <- f
+	}()
+	_, _, err := server.readMessage()
+	assert.Equal(t, io.EOF, err)
+	f := <-reply
+	assert.Equal(t, byte(opClose), f.op)
+	assert.Equal(t, uint16(closeGoingAway), binary.BigEndian.Uint16(f.payload), "the close is echoed")
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"unmasked client frame": rawFrame(true, opText, "x"),
+		"reserved bits":         {0xc1, 0x80, 0, 0, 0, 0},
+		"fragmented control":    {byte(opPing), 0x80, 0, 0, 0, 0},
+		"orphan continuation":   {0x80, 0x80, 0, 0, 0, 0},
+		"unknown opcode":        {0x83, 0x80, 0, 0, 0, 0},
+		"too big":               {0x81, 0xfe, 0x10, 0x00},
+	}
+	for name, data := range tests {
+		client, server := pipe(t)
+		go client.Write(data)
+		closed := make(chan frame, 1)
+		go func() {
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			f, _ := client.readFrame(100)
+			closed <- f
+		}()
+		_, _, err := server.readMessage()
+		assert.Error(t, err, name)
+		f := <-closed
+		assert.Equal(t, byte(opClose), f.op, name)
+		code := binary.BigEndian.Uint16(f.payload)
+		if name == "too big" {
+			assert.Equal(t, uint16(closeTooBig), code, name)
+		} else {
+			assert.Equal(t, uint16(closeProtocolError), code, name)
+		}
+	}
+}
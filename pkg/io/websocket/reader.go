@@ -0,0 +1,526 @@
+// Package websocket reads JSON records from WebSocket connections, for
+// browser dashboards and third-party feeds that speak nothing else. A
+// Reader either dials an endpoint, reconnecting with backoff when the
+// connection drops, or serves one that clients push records to.
+//
+// Every text or binary message holds a JSON object, or an array of them;
+// records are mapped to features by field path as guardio.FieldMapper
+// does. The package implements the RFC 6455 protocol itself, without
+// extensions.
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+var _ guardio.Reader = (*Reader)(nil)
+
+// handshakeTimeout bounds connecting and the opening handshake.
+const handshakeTimeout = 10 * time.Second
+
+// Record is a JSON record received over a WebSocket, mapped to features.
+type Record struct {
+	// Time is when the record was received, or the time it carries (see
+	// WithTimeField).
+	Time time.Time
+	// Source is the URL dialed, or the address of the client that pushed
+	// the record.
+	Source string
+	// Fields holds the leaves of the record by dot-separated path.
+	Fields   map[string]any
+	Features []float64
+}
+
+// Option configures a Reader.
+type Option func(*config)
+
+type config struct {
+	header        http.Header
+	tls           *tls.Config
+	subscribe     [][]byte
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxReconnects int
+	ping          time.Duration
+	origins       []string
+	fields        []string
+	timeField     string
+	maxMessage    int
+	maxRecords    int
+	maxDuration   time.Duration
+}
+
+// WithHeader sends h with the opening handshake when dialing, e.g. an
+// Authorization header.
+func WithHeader(h http.Header) Option {
+	return func(c *config) {
+		c.header = h
+	}
+}
+
+// WithTLS sets the TLS configuration for dialing wss:// endpoints.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tls = cfg
+	}
+}
+
+// WithSubscribe sends messages, as text, every time a dialed connection
+// opens, for feeds that stream only what is subscribed to.
+func WithSubscribe(messages ...[]byte) Option {
+	return func(c *config) {
+		c.subscribe = messages
+	}
+}
+
+// WithBackoff sets how long to wait before redialing: min after the first
+// failure, doubling up to max, with jitter. Defaults to one second and
+// one minute.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *config) {
+		c.minBackoff, c.maxBackoff = min, max
+	}
+}
+
+// WithMaxReconnects makes a dialing Reader give up after n consecutive
+// failed attempts to connect. Values below 1 mean it never gives up.
+func WithMaxReconnects(n int) Option {
+	return func(c *config) {
+		c.maxReconnects = n
+	}
+}
+
+// WithPingInterval sets how often to ping the peer. A peer silent, pongs
+// included, for two intervals is considered gone. Defaults to 30 seconds;
+// values below 1 disable pings and the silence check.
+func WithPingInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.ping = d
+	}
+}
+
+// WithOrigins sets the origins, such as "https://dash.example.com", that
+// browsers may push records from to a serving Reader; "*" allows any.
+// By default only requests without an Origin header or from the Reader's
+// own host are accepted.
+func WithOrigins(origins ...string) Option {
+	return func(c *config) {
+		c.origins = origins
+	}
+}
+
+// WithFields sets the record fields features are read from, in order, as
+// dot-separated paths: "price", "book.bid.0". Records missing one are
+// skipped. By default the fields are the numeric and boolean leaves of
+// the first record mapped, in lexical order.
+func WithFields(paths ...string) Option {
+	return func(c *config) {
+		c.fields = paths
+	}
+}
+
+// WithTimeField stamps records with the time in a field, an RFC 3339
+// string or seconds since the epoch, instead of the time they were
+// received. Records whose field is missing keep the receive time.
+func WithTimeField(path string) Option {
+	return func(c *config) {
+		c.timeField = path
+	}
+}
+
+// WithMaxMessageSize bounds the size of a message. Connections sending
+// larger ones are closed. Defaults to 1 MiB.
+func WithMaxMessageSize(n int) Option {
+	return func(c *config) {
+		c.maxMessage = n
+	}
+}
+
+// WithMaxRecords makes Read stop after n records. Values below 1 mean no
+// limit.
+func WithMaxRecords(n int) Option {
+	return func(c *config) {
+		c.maxRecords = n
+	}
+}
+
+// WithMaxDuration makes Read stop d after it starts. Values below 1 mean
+// no limit.
+func WithMaxDuration(d time.Duration) Option {
+	return func(c *config) {
+		c.maxDuration = d
+	}
+}
+
+// Reader receives JSON records over WebSocket connections. Peers are held
+// back while records wait to be read, so a slow consumer slows the feed
+// down rather than growing memory.
+type Reader struct {
+	cfg     config
+	mapper  *guardio.FieldMapper
+	records chan Record
+	skipped atomic.Int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	conns  map[*conn]struct{}
+	wg     sync.WaitGroup
+
+	// stopped is closed when a dialing Reader gives up, after failErr is
+	// set.
+	stopped chan struct{}
+	failErr error
+
+	errMu     sync.Mutex
+	streamErr error
+}
+
+func newReader(opts []Option) *Reader {
+	cfg := config{minBackoff: time.Second, maxBackoff: time.Minute, ping: 30 * time.Second, maxMessage: 1 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.minBackoff = max(cfg.minBackoff, time.Millisecond)
+	cfg.maxBackoff = max(cfg.maxBackoff, cfg.minBackoff)
+	r := &Reader{
+		cfg:     cfg,
+		mapper:  guardio.NewFieldMapper(cfg.fields, cfg.timeField),
+		records: make(chan Record, 100),
+		conns:   make(map[*conn]struct{}),
+		stopped: make(chan struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Dial creates a Reader of the ws:// or wss:// endpoint rawURL. It
+// connects in the background, and reconnects whenever the connection
+// drops.
+func Dial(rawURL string, opts ...Option) (*Reader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	r := newReader(opts)
+	r.wg.Add(1)
+	go r.run(u)
+	return r, nil
+}
+
+// NewHandler creates a Reader of the connections clients open to it, as
+// an http.Handler to mount on a server.
+func NewHandler(opts ...Option) *Reader {
+	return newReader(opts)
+}
+
+// run dials u until the Reader is closed, or it gives up.
+func (r *Reader) run(u *url.URL) {
+	defer r.wg.Done()
+	backoff := r.cfg.minBackoff
+	for failures := 0; ; {
+		ctx, cancel := context.WithTimeout(r.ctx, handshakeTimeout)
+		c, err := dial(ctx, u, r.cfg.header, r.cfg.tls)
+		cancel()
+		if err == nil {
+			failures, backoff = 0, r.cfg.minBackoff
+			r.consume(c, u.String(), r.cfg.subscribe)
+		} else {
+			failures++
+		}
+		if r.ctx.Err() != nil {
+			return
+		}
+		if r.cfg.maxReconnects > 0 && failures >= r.cfg.maxReconnects {
+			r.failErr = fmt.Errorf("websocket: giving up after %d attempts: %w", failures, err)
+			close(r.stopped)
+			return
+		}
+
+		// Jitter keeps many readers of one feed from redialing in
+		// lockstep.
+		wait := backoff/2 + rand.N(backoff/2+1)
+		backoff = min(2*backoff, r.cfg.maxBackoff)
+		select {
+		case <-time.After(wait):
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// ServeHTTP accepts a WebSocket connection and reads the records the
+// client sends until it disconnects or the Reader is closed.
+func (r *Reader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.allowOrigin(req) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	r.mu.Lock()
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		http.Error(w, "reader closed", http.StatusServiceUnavailable)
+		return
+	}
+	r.wg.Add(1)
+	r.mu.Unlock()
+	defer r.wg.Done()
+
+	c, err := upgrade(w, req)
+	if err != nil {
+		return
+	}
+	r.consume(c, req.RemoteAddr, nil)
+}
+
+// allowOrigin reports whether a browser at the request's origin may
+// connect.
+func (r *Reader) allowOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || slices.Contains(r.cfg.origins, "*") || slices.Contains(r.cfg.origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
+
+// consume reads the records of c until it closes or fails, after sending
+// it the subscription messages.
+func (r *Reader) consume(c *conn, source string, subscribe [][]byte) {
+	c.maxMessage = r.cfg.maxMessage
+	if r.cfg.ping > 0 {
+		c.idle = 2 * r.cfg.ping
+	}
+	r.mu.Lock()
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		c.Close()
+		return
+	}
+	r.conns[c] = struct{}{}
+	r.mu.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		r.mu.Lock()
+		delete(r.conns, c)
+		r.mu.Unlock()
+		c.Close()
+	}()
+	if r.cfg.ping > 0 {
+		go c.keepAlive(r.cfg.ping, done)
+	}
+
+	for _, msg := range subscribe {
+		if err := c.writeFrame(opText, msg); err != nil {
+			return
+		}
+	}
+	for {
+		_, data, err := c.readMessage()
+		if err != nil {
+			if !isNetErr(err) {
+				r.skipped.Add(1)
+			}
+			return
+		}
+		for _, rec := range r.decode(data, source) {
+			select {
+			case r.records <- rec:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// decode maps the records of a message, skipping those that do not map.
+func (r *Reader) decode(data []byte, source string) []Record {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		r.skipped.Add(1)
+		return nil
+	}
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+
+	now := time.Now()
+	records := make([]Record, 0, len(values))
+	for _, v := range values {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			r.skipped.Add(1)
+			continue
+		}
+		fields := guardio.Flatten(obj)
+		features, t, err := r.mapper.Map(fields)
+		if err != nil {
+			r.skipped.Add(1)
+			continue
+		}
+		if t.IsZero() {
+			t = now
+		}
+		records = append(records, Record{Time: t, Source: source, Fields: fields, Features: features})
+	}
+	return records
+}
+
+// next returns the next record, or the error a dialing Reader gave up
+// with, or ctx.Err().
+func (r *Reader) next(ctx context.Context) (Record, error) {
+	select {
+	case rec := <-r.records:
+		return rec, nil
+	case <-r.stopped:
+		return Record{}, r.failErr
+	case <-r.ctx.Done():
+		return Record{}, net.ErrClosed
+	case <-ctx.Done():
+		return Record{}, ctx.Err()
+	}
+}
+
+// FeatureNames returns the record fields features are read from, nil
+// until the first record is mapped if they are inferred.
+func (r *Reader) FeatureNames() []string {
+	return r.mapper.Fields()
+}
+
+// Read returns the feature vectors of the records received until the
+// record or duration limit is reached. As a WebSocket never ends on its
+// own, it needs one of them; use ReadContext to stop on demand.
+func (r *Reader) Read() ([][]float64, error) {
+	if r.cfg.maxRecords < 1 && r.cfg.maxDuration < 1 {
+		return nil, errors.New("websocket: reading needs a record or duration limit; use ReadContext")
+	}
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is Read stopping when ctx is done too. On cancellation it
+// returns the feature vectors read so far together with ctx.Err();
+// reaching a limit is not an error.
+func (r *Reader) ReadContext(ctx context.Context) ([][]float64, error) {
+	limit := ctx
+	if r.cfg.maxDuration > 0 {
+		var cancel context.CancelFunc
+		limit, cancel = context.WithTimeout(ctx, r.cfg.maxDuration)
+		defer cancel()
+	}
+
+	var data [][]float64
+	for r.cfg.maxRecords < 1 || len(data) < r.cfg.maxRecords {
+		rec, err := r.next(limit)
+		if err != nil {
+			if ctx.Err() == nil && limit.Err() != nil {
+				return data, nil
+			}
+			return data, err
+		}
+		data = append(data, rec.Features)
+	}
+	return data, nil
+}
+
+// Stream returns a channel of the feature vectors of the records
+// received, closed if a dialing Reader gives up or when ctx is done.
+func (r *Reader) Stream(ctx context.Context) (<-chan []float64, error) {
+	return stream(ctx, r, func(rec Record, _ uint64) []float64 { return rec.Features }), nil
+}
+
+// StreamSamples is Stream with each feature vector stamped with the time
+// of its record and its sequence number among the records emitted.
+func (r *Reader) StreamSamples(ctx context.Context) (<-chan guardio.Sample, error) {
+	return stream(ctx, r, func(rec Record, seq uint64) guardio.Sample {
+		return guardio.Sample{Features: rec.Features, Time: rec.Time, Seq: seq}
+	}), nil
+}
+
+// StreamRecords is Stream emitting the records themselves, which carry
+// their source and fields.
+func (r *Reader) StreamRecords(ctx context.Context) (<-chan Record, error) {
+	return stream(ctx, r, func(rec Record, _ uint64) Record { return rec }), nil
+}
+
+// stream emits wrap(record, seq) for every record until a dialing Reader
+// gives up or ctx is done.
+func stream[T any](ctx context.Context, r *Reader, wrap func(rec Record, seq uint64) T) <-chan T {
+	out := make(chan T, 100)
+
+	go func() {
+		defer close(out)
+		for seq := uint64(1); ; seq++ {
+			rec, err := r.next(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					r.setErr(err)
+				}
+				return
+			}
+			select {
+			case out <- wrap(rec, seq):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Skipped returns the number of records skipped so far because they were
+// not JSON objects or did not map to features, plus the connections
+// dropped for breaking the protocol. It is safe to call while streaming.
+func (r *Reader) Skipped() int {
+	return int(r.skipped.Load())
+}
+
+// Err returns the error a dialing Reader gave up with, if it stopped a
+// stream. It is only meaningful once the stream channel has been closed.
+func (r *Reader) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.streamErr
+}
+
+func (r *Reader) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	r.streamErr = err
+}
+
+// Close stops dialing and closes the open connections.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	r.cancel()
+	for c := range r.conns {
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		c.closeWith(closeGoingAway, "")
+		c.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return nil
+}
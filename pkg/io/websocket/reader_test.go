@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsURL returns the ws:// URL of server s.
+func wsURL(s *httptest.Server) *url.URL {
+	u, _ := url.Parse(s.URL)
+	u.Scheme = "ws"
+	return u
+}
+
+// client connects to the handler served by s.
+func client(t *testing.T, s *httptest.Server, header http.Header) *conn {
+	t.Helper()
+	c, err := dial(context.Background(), wsURL(s), header, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	c.maxMessage = 1 << 20
+	return c
+}
+
+func TestHandler(t *testing.T) {
+	r := NewHandler(WithFields("cpu", "mem.used"), WithTimeField("ts"))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, err := r.StreamRecords(ctx)
+	require.NoError(t, err)
+
+	c := client(t, s, nil)
+	require.NoError(t, c.writeFrame(opText, []byte(`{"cpu": 0.5, "mem": {"used": 100}, "ts": "2024-05-01T12:00:00Z", "host": "a"}`)))
+	require.NoError(t, c.writeFrame(opText, []byte(`[{"cpu": 0.7, "mem": {"used": 120}}, {"cpu": 0.9}, 3]`)))
+	require.NoError(t, c.writeFrame(opText, []byte(`not json`)))
+	require.NoError(t, c.writeFrame(opBinary, []byte(`{"cpu": 1, "mem": {"used": true}}`)))
+
+	first := <-records
+	assert.Equal(t, []float64{0.5, 100}, first.Features)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), first.Time.UTC())
+	assert.Equal(t, "a", first.Fields["host"])
+	assert.Equal(t, c.LocalAddr().String(), first.Source)
+	assert.Equal(t, []float64{0.7, 120}, (<-records).Features)
+	assert.Equal(t, []float64{1, 1}, (<-records).Features)
+	assert.Equal(t, 3, r.Skipped(), "the record lacking mem.used, the number and the non-JSON message")
+	assert.Equal(t, []string{"cpu", "mem.used"}, r.FeatureNames())
+}
+
+func TestHandlerOrigins(t *testing.T) {
+	r := NewHandler(WithOrigins("https://dash.example.com"))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	defer r.Close()
+
+	_, err := dial(context.Background(), wsURL(s), http.Header{"Origin": {"https://evil.example.com"}}, nil)
+	require.ErrorContains(t, err, "403")
+	client(t, s, http.Header{"Origin": {"https://dash.example.com"}})
+	client(t, s, http.Header{"Origin": {s.URL}})
+
+	resp, err := http.Get(s.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+}
+
+func TestHandlerMessageSize(t *testing.T) {
+	r := NewHandler(WithMaxMessageSize(64))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	defer r.Close()
+
+	c := client(t, s, nil)
+	require.NoError(t, c.writeFrame(opText, []byte(`{"x": "`+strings.Repeat("a", 100)+`"}`)))
+	_, _, err := c.readMessage()
+	assert.Error(t, err, "closed for the oversized message")
+	assert.Eventually(t, func() bool { return r.Skipped() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+// feed serves the records of messages to every connection, after reading
+// its subscription, then drops it.
+type feed struct {
+	t          *testing.T
+	messages   []string
+	subscribed chan string
+}
+
+func (f *feed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	assert.Equal(f.t, "Bearer token", req.Header.Get("Authorization"))
+	c, err := upgrade(w, req)
+	if !assert.NoError(f.t, err) {
+		return
+	}
+	defer c.Close()
+	c.maxMessage = 1 << 10
+	_, sub, err := c.readMessage()
+	if !assert.NoError(f.t, err) {
+		return
+	}
+	f.subscribed <- string(sub)
+	for _, msg := range f.messages {
+		if c.writeFrame(opText, []byte(msg)) != nil {
+			return
+		}
+	}
+	c.closeWith(closeGoingAway, "")
+}
+
+func TestDialReconnects(t *testing.T) {
+	f := &feed{t: t, messages: []string{`{"price": 10}`, `{"price": 11}`}, subscribed: make(chan string, 10)}
+	s := httptest.NewServer(f)
+	defer s.Close()
+
+	r, err := Dial(wsURL(s).String(), WithHeader(http.Header{"Authorization": {"Bearer token"}}),
+		WithSubscribe([]byte(`{"subscribe": "ticker"}`)), WithBackoff(time.Millisecond, 10*time.Millisecond), WithMaxRecords(5))
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{10}, {11}, {10}, {11}, {10}}, data, "the feed is read again after reconnecting")
+	assert.Equal(t, `{"subscribe": "ticker"}`, <-f.subscribed)
+	assert.Equal(t, `{"subscribe": "ticker"}`, <-f.subscribed, "subscribed again on reconnecting")
+}
+
+func TestDialGivesUp(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+
+	r, err := Dial(wsURL(s).String(), WithBackoff(time.Millisecond, time.Millisecond), WithMaxReconnects(3))
+	require.NoError(t, err)
+	defer r.Close()
+	samples, err := r.StreamSamples(context.Background())
+	require.NoError(t, err)
+	for range samples {
+	}
+	assert.ErrorContains(t, r.Err(), "giving up after 3 attempts")
+
+	_, err = Dial("http://example.com")
+	assert.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	r := NewHandler()
+	defer r.Close()
+	_, err := r.Read()
+	assert.Error(t, err, "without a limit")
+
+	r = NewHandler(WithMaxDuration(20 * time.Millisecond))
+	defer r.Close()
+	data, err := r.Read()
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.ReadContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReaderClose(t *testing.T) {
+	r := NewHandler()
+	s := httptest.NewServer(r)
+	defer s.Close()
+	c := client(t, s, nil)
+	require.NoError(t, c.writeFrame(opText, []byte(`{"x": 1}`)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	samples, err := r.Stream(ctx)
+	require.NoError(t, err)
+	<-samples
+
+	require.NoError(t, r.Close())
+	_, _, err = c.readMessage()
+	assert.Error(t, err, "the client is sent a close frame")
+	require.NoError(t, r.Close())
+	for range samples {
+	}
+	assert.NoError(t, r.Err())
+}
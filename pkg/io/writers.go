@@ -0,0 +1,104 @@
+package io
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// multiWriter duplicates results to several writers.
+type multiWriter struct {
+	ws []Writer
+}
+
+// MultiWriter returns a Writer writing each result to every one of ws,
+// such as a file and an alerting integration. Every writer gets each
+// result even if another fails; the errors are joined.
+func MultiWriter(ws ...Writer) Writer {
+	return &multiWriter{ws: ws}
+}
+
+func (m *multiWriter) Write(result Result) error {
+	var errs []error
+	for _, w := range m.ws {
+		errs = append(errs, w.Write(result))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiWriter) WriteAll(results []Result) error {
+	var errs []error
+	for _, w := range m.ws {
+		errs = append(errs, w.WriteAll(results))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiWriter) Close() error {
+	var errs []error
+	for _, w := range m.ws {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// severityWriter grades results before writing them.
+type severityWriter struct {
+	Writer
+	bands detectors.SeverityBands
+}
+
+// WithSeverity returns a Writer grading results by score with bands
+// before writing them to w. Results already graded keep their severity.
+func WithSeverity(w Writer, bands detectors.SeverityBands) Writer {
+	return &severityWriter{Writer: w, bands: bands}
+}
+
+func (w *severityWriter) Write(result Result) error {
+	if result.Severity == detectors.SeverityNone {
+		result.Severity = w.bands.Grade(result.Score)
+	}
+	return w.Writer.Write(result)
+}
+
+func (w *severityWriter) WriteAll(results []Result) error {
+	graded := slices.Clone(results)
+	for i := range graded {
+		if graded[i].Severity == detectors.SeverityNone {
+			graded[i].Severity = w.bands.Grade(graded[i].Score)
+		}
+	}
+	return w.Writer.WriteAll(graded)
+}
+
+// severityFilter drops results below a severity.
+type severityFilter struct {
+	Writer
+	min detectors.Severity
+}
+
+// MinSeverity returns a Writer writing to w only the results graded at
+// least min, such as a pager integration taking high and critical
+// anomalies. Ungraded results are dropped; grade them first with
+// WithSeverity.
+func MinSeverity(w Writer, min detectors.Severity) Writer {
+	return &severityFilter{Writer: w, min: min}
+}
+
+func (w *severityFilter) Write(result Result) error {
+	if result.Severity < w.min {
+		return nil
+	}
+	return w.Writer.Write(result)
+}
+
+func (w *severityFilter) WriteAll(results []Result) error {
+	kept := make([]Result, 0, len(results))
+	for _, r := range results {
+		if r.Severity >= w.min {
+			kept = append(kept, r)
+		}
+	}
+	return w.Writer.WriteAll(kept)
+}
@@ -0,0 +1,612 @@
+// Package manager owns the detectors of many tenants and handles their
+// lifecycle.
+//
+// A Manager holds one named detector per tenant (customer, segment,
+// site, ...). It trains, calibrates and swaps them while scoring goes on,
+// expires the tenants that have not been scored for a while, and keeps
+// the models it holds within a memory budget by evicting the least
+// recently used ones. Evicted tenants can be brought back on demand by a
+// Loader, typically from a model registry, so a process can serve far
+// more tenants than fit in memory at once.
+//
+// Typical use:
+//
+//	m := manager.New(
+//		manager.WithFactory(func(string) detectors.Detector { return iforest.New() }),
+//		manager.WithMemoryBudget(2<<30),
+//		manager.WithTTL(24*time.Hour),
+//	)
+//	_ = m.Train("acme", history)
+//	score, err := m.Score("acme", features)
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/bundle"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/internal/lru"
+)
+
+// Manager errors.
+var (
+	// ErrUnknownTenant is returned for tenants the Manager does not hold
+	// and cannot load.
+	ErrUnknownTenant = errors.New("manager: unknown tenant")
+	// ErrTenantExists is returned by Add for tenants already held.
+	ErrTenantExists = errors.New("manager: tenant already exists")
+	// ErrOverBudget is returned for models larger than the whole memory
+	// budget.
+	ErrOverBudget = errors.New("manager: model exceeds the memory budget")
+	// ErrNoFactory is returned by Train without WithFactory.
+	ErrNoFactory = errors.New("manager: no detector factory")
+)
+
+// Factory creates the untrained detector of a tenant.
+type Factory func(tenant string) detectors.Detector
+
+// Loader returns the trained detector of a tenant the Manager does not
+// hold, or an error wrapping ErrUnknownTenant if there is none.
+type Loader func(tenant string) (detectors.Detector, error)
+
+// Sizer is implemented by detectors that know how much memory they use.
+// The Manager accounts for other detectors by the size of their
+// serialized model.
+type Sizer interface {
+	// MemorySize returns the approximate size of the model in bytes.
+	MemorySize() int64
+}
+
+// Reasons a tenant is evicted.
+const (
+	// ReasonExpired is the reason of tenants not scored within the TTL.
+	ReasonExpired = "expired"
+	// ReasonBudget is the reason of tenants evicted to make room for
+	// another model.
+	ReasonBudget = "budget"
+)
+
+// Eviction is a tenant the Manager dropped on its own.
+type Eviction struct {
+	Tenant   string
+	Detector detectors.Detector
+	// Reason is ReasonExpired or ReasonBudget.
+	Reason string
+}
+
+// Stats holds the counters and state of a tenant.
+type Stats struct {
+	// Samples is the number of successfully scored samples.
+	Samples uint64 `json:"samples"`
+	// Anomalies is the number of samples at or above the threshold.
+	Anomalies uint64 `json:"anomalies"`
+	// Errors is the number of samples the detector rejected.
+	Errors uint64 `json:"errors"`
+	// Threshold is the tenant's current anomaly threshold.
+	Threshold float64 `json:"threshold"`
+	// Size is the memory accounted to the tenant's model, in bytes.
+	Size int64 `json:"size"`
+	// Since is when the current model was put in service.
+	Since time.Time `json:"since"`
+	// LastUsed is when the tenant was last scored, or put in service if
+	// it never was.
+	LastUsed time.Time `json:"last_used"`
+}
+
+// model is a detector in service with its threshold.
+type model struct {
+	detector  detectors.Detector
+	threshold float64
+	useModel  bool
+	size      int64
+	since     time.Time
+}
+
+func (m *model) currentThreshold() float64 {
+	if m.useModel {
+		return detectors.ThresholdOf(m.detector)
+	}
+	return m.threshold
+}
+
+// tenant is a tenant held by the Manager. Its model is swapped
+// atomically, so scoring never waits for lifecycle operations.
+type tenant struct {
+	name     string
+	model    atomic.Pointer[model]
+	lastUsed atomic.Int64 // Unix nanoseconds
+
+	samples   atomic.Uint64
+	anomalies atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// load is a Loader call in progress, shared by the callers that need the
+// same tenant.
+type load struct {
+	done chan struct{}
+	t    *tenant
+	err  error
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithFactory sets the function creating the detectors Train fits.
+func WithFactory(fn Factory) Option {
+	return func(m *Manager) {
+		m.factory = fn
+	}
+}
+
+// WithLoader sets the function bringing back tenants the Manager does not
+// hold when they are scored, such as evicted ones.
+func WithLoader(fn Loader) Option {
+	return func(m *Manager) {
+		m.loader = fn
+	}
+}
+
+// WithMemoryBudget bounds the memory of the models held, in bytes. When a
+// model would exceed it, the least recently used tenants are evicted to
+// make room. Values below 1 mean no budget.
+func WithMemoryBudget(bytes int64) Option {
+	return func(m *Manager) {
+		m.budget = bytes
+	}
+}
+
+// WithTTL makes Expire evict the tenants not scored for d. Values below 1
+// mean tenants never expire.
+func WithTTL(d time.Duration) Option {
+	return func(m *Manager) {
+		m.ttl = d
+	}
+}
+
+// WithEvictHandler sets a function called with every tenant evicted, for
+// instance to save its model. It is called without locks held, so it may
+// use the Manager.
+func WithEvictHandler(fn func(Eviction)) Option {
+	return func(m *Manager) {
+		m.onEvict = fn
+	}
+}
+
+// Manager holds a detector per tenant. It is safe for concurrent use.
+type Manager struct {
+	factory Factory
+	loader  Loader
+	budget  int64
+	ttl     time.Duration
+	onEvict func(Eviction)
+
+	mu      sync.RWMutex
+	tenants *lru.List[string, *tenant] // most recently used first
+	used    int64
+	loading map[string]*load
+}
+
+// New creates a Manager with no tenants.
+func New(opts ...Option) *Manager {
+	m := &Manager{
+		tenants: lru.New[string, *tenant](),
+		loading: make(map[string]*load),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// newModel puts d in service with its own threshold.
+func newModel(d detectors.Detector) (*model, error) {
+	size, err := sizeOf(d)
+	if err != nil {
+		return nil, err
+	}
+	return &model{detector: d, useModel: true, size: size, since: time.Now()}, nil
+}
+
+// sizeOf returns the memory accounted to d.
+func sizeOf(d detectors.Detector) (int64, error) {
+	if s, ok := d.(Sizer); ok {
+		return s.MemorySize(), nil
+	}
+	data, err := d.Save()
+	if err != nil {
+		return 0, fmt.Errorf("manager: size model: %w", err)
+	}
+	return int64(len(data)), nil
+}
+
+// Add puts the trained detector d in service for a new tenant.
+func (m *Manager) Add(name string, d detectors.Detector) error {
+	md, err := newModel(d)
+	if err != nil {
+		return err
+	}
+	_, err = m.put(name, md, func(exists bool) error {
+		if exists {
+			return fmt.Errorf("%w: %q", ErrTenantExists, name)
+		}
+		return nil
+	})
+	return err
+}
+
+// Swap replaces the detector of a tenant with the trained detector d and
+// returns the previous one. Samples being scored finish on the previous
+// detector; the tenant's counters carry over, its threshold is d's own.
+func (m *Manager) Swap(name string, d detectors.Detector) (detectors.Detector, error) {
+	md, err := newModel(d)
+	if err != nil {
+		return nil, err
+	}
+	return m.put(name, md, func(exists bool) error {
+		if !exists {
+			return fmt.Errorf("%w %q", ErrUnknownTenant, name)
+		}
+		return nil
+	})
+}
+
+// Train fits a detector created by the factory on data and puts it in
+// service for the tenant, adding it or swapping its detector. The tenant
+// keeps scoring on its current detector while the new one trains.
+func (m *Manager) Train(name string, data [][]float64) error {
+	if m.factory == nil {
+		return ErrNoFactory
+	}
+	d := m.factory(name)
+	if err := d.Fit(data); err != nil {
+		return fmt.Errorf("manager: train %q: %w", name, err)
+	}
+	md, err := newModel(d)
+	if err != nil {
+		return err
+	}
+	_, err = m.put(name, md, nil)
+	return err
+}
+
+// put makes md the model of a tenant, once check, if not nil, accepts
+// whether the tenant exists, and evicts tenants to keep within the
+// budget. It returns the tenant's previous detector, if any.
+func (m *Manager) put(name string, md *model, check func(exists bool) error) (detectors.Detector, error) {
+	if m.budget > 0 && md.size > m.budget {
+		return nil, fmt.Errorf("%w: %q needs %d bytes of %d", ErrOverBudget, name, md.size, m.budget)
+	}
+
+	m.mu.Lock()
+	t, exists := m.tenants.Peek(name)
+	if check != nil {
+		if err := check(exists); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+	}
+	var previous detectors.Detector
+	if exists {
+		old := t.model.Swap(md)
+		m.used -= old.size
+		previous = old.detector
+	} else {
+		t = &tenant{name: name}
+		t.model.Store(md)
+		t.lastUsed.Store(md.since.UnixNano())
+		m.tenants.Push(name, t)
+	}
+	m.used += md.size
+	evicted := m.makeRoom(t)
+	m.mu.Unlock()
+
+	m.evicted(evicted)
+	return previous, nil
+}
+
+// makeRoom evicts the least recently used tenants other than keep until
+// the models held fit in the budget. m.mu must be held.
+func (m *Manager) makeRoom(keep *tenant) []Eviction {
+	if m.budget <= 0 || m.used <= m.budget {
+		return nil
+	}
+	var evicted []Eviction
+	for _, t := range m.tenants.Backward() {
+		if m.used <= m.budget {
+			break
+		}
+		if t != keep {
+			evicted = append(evicted, m.drop(t, ReasonBudget))
+		}
+	}
+	return evicted
+}
+
+// drop removes t. m.mu must be held.
+func (m *Manager) drop(t *tenant, reason string) Eviction {
+	m.tenants.Remove(t.name)
+	md := t.model.Load()
+	m.used -= md.size
+	return Eviction{Tenant: t.name, Detector: md.detector, Reason: reason}
+}
+
+func (m *Manager) evicted(evicted []Eviction) {
+	if m.onEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		m.onEvict(e)
+	}
+}
+
+// Remove drops a tenant. It reports whether the tenant was held.
+func (m *Manager) Remove(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tenants.Peek(name)
+	if ok {
+		m.drop(t, "")
+	}
+	return ok
+}
+
+// Expire evicts the tenants not scored within the TTL before now and
+// returns their names, sorted. It does nothing without WithTTL.
+func (m *Manager) Expire(now time.Time) []string {
+	if m.ttl <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-m.ttl).UnixNano()
+
+	m.mu.Lock()
+	var evicted []Eviction
+	for _, t := range m.tenants.Backward() {
+		if t.lastUsed.Load() < cutoff {
+			evicted = append(evicted, m.drop(t, ReasonExpired))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(evicted, func(i, j int) bool { return evicted[i].Tenant < evicted[j].Tenant })
+	m.evicted(evicted)
+	names := make([]string, len(evicted))
+	for i, e := range evicted {
+		names[i] = e.Tenant
+	}
+	return names
+}
+
+// Run calls Expire every interval until ctx is done, and returns
+// ctx.Err().
+func (m *Manager) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.Expire(now)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Calibrate sets the threshold of a tenant so that the given fraction of
+// the samples in data, typically recent normal traffic, score at or above
+// it, and returns the threshold. The tenant's detector is not retrained.
+func (m *Manager) Calibrate(name string, data [][]float64, contamination float64) (float64, error) {
+	t, err := m.held(name)
+	if err != nil {
+		return 0, err
+	}
+	md := t.model.Load()
+	scores, err := md.detector.Predict(data)
+	if err != nil {
+		return 0, fmt.Errorf("manager: calibrate %q: %w", name, err)
+	}
+	c, err := bundle.NewCalibration(scores)
+	if err != nil {
+		return 0, fmt.Errorf("manager: calibrate %q: %w", name, err)
+	}
+	threshold := c.Threshold(contamination)
+
+	calibrated := *md
+	calibrated.threshold, calibrated.useModel = threshold, false
+	if !t.model.CompareAndSwap(md, &calibrated) {
+		return 0, fmt.Errorf("manager: calibrate %q: model swapped during calibration", name)
+	}
+	return threshold, nil
+}
+
+// SetThreshold overrides the threshold of a tenant's current detector.
+func (m *Manager) SetThreshold(name string, threshold float64) error {
+	t, err := m.held(name)
+	if err != nil {
+		return err
+	}
+	for {
+		md := t.model.Load()
+		next := *md
+		next.threshold, next.useModel = threshold, false
+		if t.model.CompareAndSwap(md, &next) {
+			return nil
+		}
+	}
+}
+
+// held returns a tenant the Manager holds, without loading it.
+func (m *Manager) held(name string) (*tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tenants.Peek(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, name)
+	}
+	return t, nil
+}
+
+// lookup returns a tenant to score and marks it used, loading it if the
+// Manager does not hold it and has a Loader. Concurrent lookups of a
+// tenant being loaded wait for the same load.
+func (m *Manager) lookup(name string) (*tenant, error) {
+	m.mu.Lock()
+	if t, ok := m.tenants.Get(name); ok {
+		m.mu.Unlock()
+		return t, nil
+	}
+	if m.loader == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, name)
+	}
+	if l, ok := m.loading[name]; ok {
+		m.mu.Unlock()
+		<-l.done
+		return l.t, l.err
+	}
+	l := &load{done: make(chan struct{})}
+	m.loading[name] = l
+	m.mu.Unlock()
+
+	l.t, l.err = m.load(name)
+	m.mu.Lock()
+	delete(m.loading, name)
+	m.mu.Unlock()
+	close(l.done)
+	return l.t, l.err
+}
+
+// load brings a tenant back with the Loader.
+func (m *Manager) load(name string) (*tenant, error) {
+	d, err := m.loader(name)
+	if err != nil {
+		return nil, fmt.Errorf("manager: load %q: %w", name, err)
+	}
+	md, err := newModel(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.put(name, md, nil); err != nil {
+		return nil, err
+	}
+	return m.held(name)
+}
+
+// Score scores a single sample with the detector of a tenant.
+func (m *Manager) Score(name string, features []float64) (detectors.Score, error) {
+	t, err := m.lookup(name)
+	if err != nil {
+		return detectors.Score{}, err
+	}
+	t.touch()
+	md := t.model.Load()
+	v, err := md.detector.PredictOne(features)
+	if err != nil {
+		t.errors.Add(1)
+		return detectors.Score{}, err
+	}
+	return t.record(v, md.currentThreshold(), features), nil
+}
+
+// ScoreBatch scores many samples of a tenant.
+func (m *Manager) ScoreBatch(name string, data [][]float64) ([]detectors.Score, error) {
+	t, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	t.touch()
+	md := t.model.Load()
+	values, err := md.detector.Predict(data)
+	if err != nil {
+		t.errors.Add(uint64(len(data)))
+		return nil, err
+	}
+
+	threshold := md.currentThreshold()
+	scores := make([]detectors.Score, len(values))
+	for i, v := range values {
+		scores[i] = t.record(v, threshold, data[i])
+	}
+	return scores, nil
+}
+
+func (t *tenant) touch() {
+	t.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (t *tenant) record(value, threshold float64, features []float64) detectors.Score {
+	t.samples.Add(1)
+	isAnomaly := value >= threshold
+	if isAnomaly {
+		t.anomalies.Add(1)
+	}
+	return detectors.Score{
+		Value:     value,
+		IsAnomaly: isAnomaly,
+		Features:  features,
+		Metadata:  map[string]any{"tenant": t.name},
+	}
+}
+
+// Tenants returns the names of the tenants held, sorted.
+func (m *Manager) Tenants() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, m.tenants.Len())
+	for name := range m.tenants.All() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detector returns the detector of a tenant, if the Manager holds it.
+func (m *Manager) Detector(name string) (detectors.Detector, bool) {
+	t, err := m.held(name)
+	if err != nil {
+		return nil, false
+	}
+	return t.model.Load().detector, true
+}
+
+// Threshold returns the threshold applied to the samples of a tenant.
+func (m *Manager) Threshold(name string) (float64, error) {
+	t, err := m.held(name)
+	if err != nil {
+		return 0, err
+	}
+	return t.model.Load().currentThreshold(), nil
+}
+
+// MemoryUsage returns the memory accounted to the models held, in bytes.
+func (m *Manager) MemoryUsage() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.used
+}
+
+// Stats returns a snapshot of the counters and state of every tenant.
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := make(map[string]Stats, m.tenants.Len())
+	for name, t := range m.tenants.All() {
+		md := t.model.Load()
+		stats[name] = Stats{
+			Samples:   t.samples.Load(),
+			Anomalies: t.anomalies.Load(),
+			Errors:    t.errors.Load(),
+			Threshold: md.currentThreshold(),
+			Size:      md.size,
+			Since:     md.since,
+			LastUsed:  time.Unix(0, t.lastUsed.Load()),
+		}
+	}
+	return stats
+}
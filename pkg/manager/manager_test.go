@@ -0,0 +1,284 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func gaussian(center float64, n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{center + rng.NormFloat64(), center + rng.NormFloat64(), center + rng.NormFloat64()}
+	}
+	return data
+}
+
+func forests(string) detectors.Detector {
+	return iforest.New(iforest.WithTrees(30), iforest.WithSeed(42))
+}
+
+// sized is a detector of a fixed size scoring every sample with value.
+type sized struct {
+	size  int64
+	value float64
+}
+
+func (s *sized) Fit([][]float64) error { return nil }
+func (s *sized) Predict(data [][]float64) ([]float64, error) {
+	scores := make([]float64, len(data))
+	for i := range scores {
+		scores[i] = s.value
+	}
+	return scores, nil
+}
+func (s *sized) PredictOne([]float64) (float64, error) { return s.value, nil }
+func (s *sized) Save() ([]byte, error)                 { return nil, nil }
+func (s *sized) Load([]byte) error                     { return nil }
+func (s *sized) SaveTo(io.Writer) error                { return nil }
+func (s *sized) LoadFrom(io.Reader) error              { return nil }
+func (s *sized) MemorySize() int64                     { return s.size }
+
+func (s *sized) PredictContext(_ context.Context, data [][]float64) ([]float64, error) {
+	return s.Predict(data)
+}
+
+func TestTrainAndScore(t *testing.T) {
+	m := New(WithFactory(forests))
+	require.NoError(t, m.Train("acme", gaussian(0, 300, 1)))
+	require.NoError(t, m.Train("globex", gaussian(100, 300, 2)))
+	assert.Equal(t, []string{"acme", "globex"}, m.Tenants())
+
+	tests := []struct {
+		tenant      string
+		sample      []float64
+		wantAnomaly bool
+	}{
+		{"acme", []float64{0, 0, 0}, false},
+		{"acme", []float64{100, 100, 100}, true},
+		{"globex", []float64{100, 100, 100}, false},
+	}
+	for _, tt := range tests {
+		score, err := m.Score(tt.tenant, tt.sample)
+		require.NoError(t, err)
+		assert.Equal(t, tt.wantAnomaly, score.IsAnomaly, "%s %v", tt.tenant, tt.sample)
+		assert.Equal(t, tt.tenant, score.Metadata["tenant"])
+	}
+
+	scores, err := m.ScoreBatch("globex", [][]float64{{100, 100, 100}, {0, 0, 0}})
+	require.NoError(t, err)
+	assert.False(t, scores[0].IsAnomaly)
+	assert.True(t, scores[1].IsAnomaly)
+
+	_, err = m.Score("acme", []float64{1})
+	assert.Error(t, err)
+	_, err = m.Score("initech", []float64{0, 0, 0})
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+
+	stats := m.Stats()
+	assert.Equal(t, uint64(2), stats["acme"].Samples)
+	assert.Equal(t, uint64(1), stats["acme"].Anomalies)
+	assert.Equal(t, uint64(1), stats["acme"].Errors)
+	assert.Equal(t, uint64(3), stats["globex"].Samples)
+	assert.Positive(t, stats["acme"].Size)
+	assert.Equal(t, stats["acme"].Size+stats["globex"].Size, m.MemoryUsage())
+
+	assert.ErrorIs(t, New().Train("acme", nil), ErrNoFactory)
+	assert.Error(t, m.Train("acme", nil), "training fails without data")
+}
+
+func TestAddSwapRemove(t *testing.T) {
+	m := New()
+	first, second := &sized{size: 10, value: 0.2}, &sized{size: 30, value: 0.9}
+	require.NoError(t, m.Add("acme", first))
+	assert.ErrorIs(t, m.Add("acme", second), ErrTenantExists)
+	_, err := m.Swap("globex", second)
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+
+	_, err = m.Score("acme", nil)
+	require.NoError(t, err)
+	previous, err := m.Swap("acme", second)
+	require.NoError(t, err)
+	assert.Same(t, first, previous)
+	d, ok := m.Detector("acme")
+	assert.True(t, ok)
+	assert.Same(t, second, d)
+	assert.Equal(t, int64(30), m.MemoryUsage())
+	score, err := m.Score("acme", nil)
+	require.NoError(t, err)
+	assert.True(t, score.IsAnomaly)
+	assert.Equal(t, uint64(2), m.Stats()["acme"].Samples, "counters carry over a swap")
+
+	assert.True(t, m.Remove("acme"))
+	assert.False(t, m.Remove("acme"))
+	assert.Zero(t, m.MemoryUsage())
+	assert.Empty(t, m.Tenants())
+}
+
+func TestCalibrate(t *testing.T) {
+	m := New(WithFactory(forests))
+	data := gaussian(0, 500, 1)
+	require.NoError(t, m.Train("acme", data))
+
+	threshold, err := m.Calibrate("acme", data, 0.1)
+	require.NoError(t, err)
+	got, err := m.Threshold("acme")
+	require.NoError(t, err)
+	assert.Equal(t, threshold, got)
+
+	scores, err := m.ScoreBatch("acme", data)
+	require.NoError(t, err)
+	anomalies := 0
+	for _, s := range scores {
+		if s.IsAnomaly {
+			anomalies++
+		}
+	}
+	assert.InDelta(t, 50, anomalies, 5)
+
+	require.NoError(t, m.SetThreshold("acme", 2))
+	score, err := m.Score("acme", []float64{100, 100, 100})
+	require.NoError(t, err)
+	assert.False(t, score.IsAnomaly)
+
+	require.NoError(t, m.Train("acme", data))
+	got, err = m.Threshold("acme")
+	require.NoError(t, err)
+	assert.NotEqual(t, 2.0, got, "a new model brings its own threshold")
+
+	_, err = m.Calibrate("globex", data, 0.1)
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+	_, err = m.Calibrate("acme", nil, 0.1)
+	assert.Error(t, err)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	var evicted []Eviction
+	m := New(WithMemoryBudget(100), WithEvictHandler(func(e Eviction) { evicted = append(evicted, e) }))
+	require.NoError(t, m.Add("a", &sized{size: 40}))
+	require.NoError(t, m.Add("b", &sized{size: 40}))
+	time.Sleep(time.Millisecond)
+	_, err := m.Score("a", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Add("c", &sized{size: 40}))
+	assert.Equal(t, []string{"a", "c"}, m.Tenants(), "b was used least recently")
+	require.Len(t, evicted, 1)
+	assert.Equal(t, "b", evicted[0].Tenant)
+	assert.Equal(t, ReasonBudget, evicted[0].Reason)
+	assert.Equal(t, int64(80), m.MemoryUsage())
+
+	_, err = m.Swap("c", &sized{size: 90})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, m.Tenants(), "a swap evicts others, not the tenant swapped")
+
+	assert.ErrorIs(t, m.Add("d", &sized{size: 101}), ErrOverBudget)
+	assert.Equal(t, []string{"c"}, m.Tenants(), "nothing is evicted for a model that cannot fit")
+}
+
+func TestExpire(t *testing.T) {
+	var evicted []Eviction
+	m := New(WithTTL(time.Hour), WithEvictHandler(func(e Eviction) { evicted = append(evicted, e) }))
+	require.NoError(t, m.Add("a", &sized{}))
+	require.NoError(t, m.Add("b", &sized{}))
+	require.NoError(t, m.Add("c", &sized{}))
+
+	assert.Empty(t, m.Expire(time.Now()))
+	later := time.Now().Add(2 * time.Hour)
+	b, _ := m.tenants.Peek("b")
+	b.lastUsed.Store(later.UnixNano())
+	assert.Equal(t, []string{"a", "c"}, m.Expire(later.Add(time.Minute)))
+	assert.Equal(t, []string{"b"}, m.Tenants())
+	require.Len(t, evicted, 2)
+	assert.Equal(t, ReasonExpired, evicted[0].Reason)
+
+	assert.Empty(t, New().Expire(later.Add(time.Hour)), "no TTL")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m = New(WithTTL(time.Nanosecond))
+	require.NoError(t, m.Add("a", &sized{}))
+	assert.ErrorIs(t, m.Run(ctx, 5*time.Millisecond), context.DeadlineExceeded)
+	assert.Empty(t, m.Tenants())
+}
+
+func TestLoader(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	m := New(WithMemoryBudget(10), WithLoader(func(name string) (detectors.Detector, error) {
+		loads.Add(1)
+		<-release
+		if name == "missing" {
+			return nil, fmt.Errorf("no model: %w", ErrUnknownTenant)
+		}
+		return &sized{size: 10, value: 0.9}, nil
+	}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Score("acme", nil)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), loads.Load(), "concurrent scores share one load")
+	assert.Equal(t, uint64(10), m.Stats()["acme"].Samples)
+
+	_, err := m.Score("globex", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"globex"}, m.Tenants(), "acme was evicted to load globex")
+
+	_, err = m.Score("missing", nil)
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+	assert.False(t, errors.Is(err, ErrOverBudget))
+}
+
+func TestConcurrentSwap(t *testing.T) {
+	m := New(WithFactory(forests))
+	data := gaussian(0, 200, 1)
+	require.NoError(t, m.Train("acme", data))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_, err := m.Score("acme", data[0])
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for range 3 {
+		require.NoError(t, m.Train("acme", data))
+		_, err := m.Calibrate("acme", data, 0.05)
+		require.NoError(t, err)
+	}
+	cancel()
+	wg.Wait()
+	assert.Zero(t, m.Stats()["acme"].Errors)
+}
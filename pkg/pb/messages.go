@@ -0,0 +1,212 @@
+package pb
+
+import (
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// MarshalScore encodes a goguardml.v1.Score. It fails if the metadata
+// cannot be represented in JSON.
+func MarshalScore(s detectors.Score) ([]byte, error) {
+	var e Encoder
+	e.Double(1, s.Value)
+	e.Bool(2, s.IsAnomaly)
+	e.Doubles(3, s.Features)
+	if err := e.Struct(4, s.Metadata); err != nil {
+		return nil, err
+	}
+	if s.Explanation != nil {
+		e.Message(5, func(e *Encoder) { EncodeExplanation(e, s.Explanation) })
+	}
+	e.Uint(6, uint64(s.Severity))
+	return e.Bytes(), nil
+}
+
+// UnmarshalScore decodes a goguardml.v1.Score. Metadata values are JSON
+// values: numbers are float64.
+func UnmarshalScore(b []byte) (detectors.Score, error) {
+	var s detectors.Score
+	d := NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			s.Value = d.Double()
+		case 2:
+			s.IsAnomaly = d.Bool()
+		case 3:
+			s.Features = d.Doubles(s.Features)
+		case 4:
+			s.Metadata = d.Struct()
+		case 5:
+			s.Explanation = DecodeExplanation(d)
+		case 6:
+			s.Severity = detectors.Severity(d.Uint())
+		}
+	}
+	return s, d.Err()
+}
+
+// PredictRequest is a goguardml.v1.PredictRequest: the samples to score,
+// by features, whether to explain anomalies, and the entities of the
+// samples.
+type PredictRequest struct {
+	Samples        [][]float64
+	Explain        bool
+	Counterfactual bool
+	Entities       []string
+}
+
+// MarshalPredictRequest encodes a goguardml.v1.PredictRequest.
+func MarshalPredictRequest(req PredictRequest) []byte {
+	var e Encoder
+	for _, s := range req.Samples {
+		e.Message(1, func(e *Encoder) { e.Doubles(1, s) })
+	}
+	e.Bool(2, req.Explain)
+	e.Bool(3, req.Counterfactual)
+	e.RepeatedString(4, req.Entities)
+	return e.Bytes()
+}
+
+// UnmarshalPredictRequest decodes a goguardml.v1.PredictRequest, keeping
+// the features of the samples.
+func UnmarshalPredictRequest(b []byte) (PredictRequest, error) {
+	var req PredictRequest
+	d := NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			var features []float64
+			sd := d.Message()
+			for sd.Next() {
+				if sd.Field() == 1 {
+					features = sd.Doubles(features)
+				}
+			}
+			if err := sd.Err(); err != nil {
+				return req, err
+			}
+			req.Samples = append(req.Samples, features)
+		case 2:
+			req.Explain = d.Bool()
+		case 3:
+			req.Counterfactual = d.Bool()
+		case 4:
+			req.Entities = append(req.Entities, d.String())
+		}
+	}
+	return req, d.Err()
+}
+
+// EncodeExplanation writes the fields of a goguardml.v1.Explanation.
+func EncodeExplanation(e *Encoder, x *detectors.Explanation) {
+	e.Double(1, x.Score)
+	e.Doubles(2, x.Contributions)
+	for _, c := range x.Top {
+		e.Message(3, func(e *Encoder) {
+			e.Int(1, int64(c.Index))
+			e.Double(2, c.Contribution)
+			e.Double(3, c.Value)
+			if c.Typical != nil {
+				e.Message(4, func(e *Encoder) { EncodeRange(e, *c.Typical) })
+			}
+		})
+	}
+	if cf := x.Counterfactual; cf != nil {
+		e.Message(4, func(e *Encoder) {
+			e.Bool(1, cf.Found)
+			e.Double(2, cf.Score)
+			for _, ch := range cf.Changes {
+				e.Message(3, func(e *Encoder) {
+					e.Int(1, int64(ch.Index))
+					e.Double(2, ch.From)
+					e.Double(3, ch.To)
+				})
+			}
+		})
+	}
+}
+
+// DecodeExplanation decodes the current field of d, a
+// goguardml.v1.Explanation.
+func DecodeExplanation(d *Decoder) *detectors.Explanation {
+	x := &detectors.Explanation{}
+	m := d.Message()
+	for m.Next() {
+		switch m.Field() {
+		case 1:
+			x.Score = m.Double()
+		case 2:
+			x.Contributions = m.Doubles(x.Contributions)
+		case 3:
+			var c detectors.FeatureContribution
+			cm := m.Message()
+			for cm.Next() {
+				switch cm.Field() {
+				case 1:
+					c.Index = int(cm.Int())
+				case 2:
+					c.Contribution = cm.Double()
+				case 3:
+					c.Value = cm.Double()
+				case 4:
+					r := DecodeRange(cm)
+					c.Typical = &r
+				}
+			}
+			m.Adopt(cm)
+			x.Top = append(x.Top, c)
+		case 4:
+			cf := &detectors.Counterfactual{}
+			cm := m.Message()
+			for cm.Next() {
+				switch cm.Field() {
+				case 1:
+					cf.Found = cm.Bool()
+				case 2:
+					cf.Score = cm.Double()
+				case 3:
+					var ch detectors.FeatureChange
+					chm := cm.Message()
+					for chm.Next() {
+						switch chm.Field() {
+						case 1:
+							ch.Index = int(chm.Int())
+						case 2:
+							ch.From = chm.Double()
+						case 3:
+							ch.To = chm.Double()
+						}
+					}
+					cm.Adopt(chm)
+					cf.Changes = append(cf.Changes, ch)
+				}
+			}
+			m.Adopt(cm)
+			x.Counterfactual = cf
+		}
+	}
+	d.Adopt(m)
+	return x
+}
+
+// EncodeRange writes the fields of a goguardml.v1.Range.
+func EncodeRange(e *Encoder, r detectors.Range) {
+	e.Double(1, r.Low)
+	e.Double(2, r.High)
+}
+
+// DecodeRange decodes the current field of d, a goguardml.v1.Range.
+func DecodeRange(d *Decoder) detectors.Range {
+	var r detectors.Range
+	m := d.Message()
+	for m.Next() {
+		switch m.Field() {
+		case 1:
+			r.Low = m.Double()
+		case 2:
+			r.High = m.Double()
+		}
+	}
+	d.Adopt(m)
+	return r
+}
@@ -0,0 +1,106 @@
+package pb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+func TestScoreRoundTrip(t *testing.T) {
+	s := detectors.Score{
+		Value:     0.8,
+		IsAnomaly: true,
+		Features:  []float64{1, 2},
+		Metadata:  map[string]any{"route": "eth0"},
+		Explanation: &detectors.Explanation{
+			Score:         0.8,
+			Contributions: []float64{0.75, 0.25},
+			Top: []detectors.FeatureContribution{
+				{Index: 0, Contribution: 0.75, Value: 1, Typical: &detectors.Range{Low: -1, High: 0.5}},
+				{Index: 1, Contribution: 0.25, Value: 2},
+			},
+			Counterfactual: &detectors.Counterfactual{
+				Found:   true,
+				Score:   0.4,
+				Changes: []detectors.FeatureChange{{Index: 0, From: 1, To: 0.4}},
+			},
+		},
+		Severity: detectors.SeverityHigh,
+	}
+	b, err := MarshalScore(s)
+	require.NoError(t, err)
+	got, err := UnmarshalScore(b)
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	_, err = UnmarshalScore([]byte{0x2a, 0x02, 0x1a, 0x09})
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestPredictRequestRoundTrip(t *testing.T) {
+	req := PredictRequest{Samples: [][]float64{{1, 2}, {3, 4}}, Counterfactual: true, Entities: []string{"web-1", ""}}
+	got, err := UnmarshalPredictRequest(MarshalPredictRequest(req))
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+}
+
+func TestModelMessages(t *testing.T) {
+	card := detectors.ModelCard{
+		TrainedAt:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		DataSource:      "train.csv",
+		Rows:            100,
+		Features:        2,
+		FeatureNames:    []string{"a", "b"},
+		Hyperparameters: map[string]string{"trees": "100", "seed": "1"},
+		LibraryVersion:  "v1",
+		DataHash:        "abc",
+	}
+	profile := &stats.Profile{Samples: 100, Features: []stats.FeatureProfile{
+		{Mean: 1, StdDev: 2, Edges: []float64{0, 1}, Fractions: []float64{0.5, 0.25, 0.25}, Quantiles: []float64{-1, 1}},
+	}}
+
+	b := MarshalModel(ModelFieldIsolationForest, func(e *Encoder) {
+		e.Message(1, func(e *Encoder) { EncodeModelCard(e, card) })
+		e.Message(2, func(e *Encoder) { EncodeProfile(e, profile) })
+	})
+	assert.True(t, IsModel(b))
+	assert.False(t, IsModel(b[1:]))
+
+	var (
+		gotCard    detectors.ModelCard
+		gotProfile *stats.Profile
+	)
+	d := NewDecoder(b)
+	for d.Next() {
+		switch d.Field() {
+		case ModelFieldSchema:
+			assert.Equal(t, ModelSchema, d.String())
+		case ModelFieldIsolationForest:
+			m := d.Message()
+			for m.Next() {
+				switch m.Field() {
+				case 1:
+					gotCard = DecodeModelCard(m)
+				case 2:
+					gotProfile = DecodeProfile(m)
+				}
+			}
+			require.NoError(t, m.Err())
+		}
+	}
+	require.NoError(t, d.Err())
+	assert.Equal(t, card, gotCard)
+	assert.Equal(t, profile, gotProfile)
+
+	// Hyperparameters are written sorted, so encodings are reproducible.
+	again := MarshalModel(ModelFieldIsolationForest, func(e *Encoder) {
+		e.Message(1, func(e *Encoder) { EncodeModelCard(e, card) })
+		e.Message(2, func(e *Encoder) { EncodeProfile(e, profile) })
+	})
+	assert.Equal(t, b, again)
+}
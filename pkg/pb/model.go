@@ -0,0 +1,148 @@
+package pb
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// ModelSchema is the schema field of every goguardml.v1.Model.
+const ModelSchema = "goguardml.v1"
+
+// Fields of goguardml.v1.Model.
+const (
+	ModelFieldSchema          = 1
+	ModelFieldIsolationForest = 2
+)
+
+// modelPrefix starts every encoded Model: its schema field.
+var modelPrefix = append([]byte{ModelFieldSchema<<3 | wireLen, byte(len(ModelSchema))}, ModelSchema...)
+
+// IsModel reports whether data is a goguardml.v1.Model, as written by the
+// SaveProto method of detectors.
+func IsModel(data []byte) bool {
+	return bytes.HasPrefix(data, modelPrefix)
+}
+
+// MarshalModel encodes a goguardml.v1.Model, the detector of which is
+// written by fill as field number field.
+func MarshalModel(field int, fill func(*Encoder)) []byte {
+	var e Encoder
+	e.String(ModelFieldSchema, ModelSchema)
+	e.Message(field, fill)
+	return e.Bytes()
+}
+
+// EncodeModelCard writes the fields of a goguardml.v1.ModelCard.
+// Hyperparameters are sorted by name, so encodings are reproducible.
+func EncodeModelCard(e *Encoder, card detectors.ModelCard) {
+	e.Timestamp(1, card.TrainedAt)
+	e.String(2, card.DataSource)
+	e.Int(3, int64(card.Rows))
+	e.Int(4, int64(card.Features))
+	e.RepeatedString(5, card.FeatureNames)
+	names := make([]string, 0, len(card.Hyperparameters))
+	for name := range card.Hyperparameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.Message(6, func(e *Encoder) {
+			e.String(1, name)
+			e.String(2, card.Hyperparameters[name])
+		})
+	}
+	e.String(7, card.LibraryVersion)
+	e.String(8, card.DataHash)
+}
+
+// DecodeModelCard decodes the current field of d, a goguardml.v1.ModelCard.
+func DecodeModelCard(d *Decoder) detectors.ModelCard {
+	var card detectors.ModelCard
+	m := d.Message()
+	for m.Next() {
+		switch m.Field() {
+		case 1:
+			card.TrainedAt = m.Timestamp()
+		case 2:
+			card.DataSource = m.String()
+		case 3:
+			card.Rows = int(m.Int())
+		case 4:
+			card.Features = int(m.Int())
+		case 5:
+			card.FeatureNames = append(card.FeatureNames, m.String())
+		case 6:
+			var name, value string
+			entry := m.Message()
+			for entry.Next() {
+				switch entry.Field() {
+				case 1:
+					name = entry.String()
+				case 2:
+					value = entry.String()
+				}
+			}
+			m.Adopt(entry)
+			if card.Hyperparameters == nil {
+				card.Hyperparameters = map[string]string{}
+			}
+			card.Hyperparameters[name] = value
+		case 7:
+			card.LibraryVersion = m.String()
+		case 8:
+			card.DataHash = m.String()
+		}
+	}
+	d.Adopt(m)
+	return card
+}
+
+// EncodeProfile writes the fields of a goguardml.v1.Profile.
+func EncodeProfile(e *Encoder, p *stats.Profile) {
+	e.Int(1, int64(p.Samples))
+	for _, fp := range p.Features {
+		e.Message(2, func(e *Encoder) {
+			e.Double(1, fp.Mean)
+			e.Double(2, fp.StdDev)
+			e.Doubles(3, fp.Edges)
+			e.Doubles(4, fp.Fractions)
+			e.Doubles(5, fp.Quantiles)
+		})
+	}
+}
+
+// DecodeProfile decodes the current field of d, a goguardml.v1.Profile.
+func DecodeProfile(d *Decoder) *stats.Profile {
+	p := &stats.Profile{}
+	m := d.Message()
+	for m.Next() {
+		switch m.Field() {
+		case 1:
+			p.Samples = int(m.Int())
+		case 2:
+			var fp stats.FeatureProfile
+			fm := m.Message()
+			for fm.Next() {
+				switch fm.Field() {
+				case 1:
+					fp.Mean = fm.Double()
+				case 2:
+					fp.StdDev = fm.Double()
+				case 3:
+					fp.Edges = fm.Doubles(fp.Edges)
+				case 4:
+					fp.Fractions = fm.Doubles(fp.Fractions)
+				case 5:
+					fp.Quantiles = fm.Doubles(fp.Quantiles)
+				}
+			}
+			m.Adopt(fm)
+			p.Features = append(p.Features, fp)
+		}
+	}
+	d.Adopt(m)
+	return p
+}
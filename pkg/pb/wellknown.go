@@ -0,0 +1,195 @@
+package pb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Timestamp writes a google.protobuf.Timestamp field. Zero times are
+// omitted.
+func (e *Encoder) Timestamp(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	e.Message(field, func(e *Encoder) {
+		e.Int(1, t.Unix())
+		e.Int(2, int64(t.Nanosecond()))
+	})
+}
+
+// Timestamp returns the current field, a google.protobuf.Timestamp, in UTC.
+func (d *Decoder) Timestamp() time.Time {
+	var sec, nsec int64
+	m := d.Message()
+	for m.Next() {
+		switch m.Field() {
+		case 1:
+			sec = m.Int()
+		case 2:
+			nsec = m.Int()
+		}
+	}
+	d.Adopt(m)
+	return time.Unix(sec, nsec).UTC()
+}
+
+// Struct writes a google.protobuf.Struct field of the JSON form of v.
+// Nil and empty maps are omitted.
+func (e *Encoder) Struct(field int, v map[string]any) error {
+	if len(v) == 0 {
+		return nil
+	}
+	// Normalize to JSON values: numbers, strings, booleans, null, lists
+	// and objects.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("pb: metadata: %w", err)
+	}
+	var normal map[string]any
+	if err := json.Unmarshal(b, &normal); err != nil {
+		return fmt.Errorf("pb: metadata: %w", err)
+	}
+	e.Message(field, func(e *Encoder) { encodeStruct(e, normal) })
+	return nil
+}
+
+// encodeStruct writes the fields of a Struct, sorted by key so encodings
+// are reproducible.
+func encodeStruct(e *Encoder, m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.Message(1, func(e *Encoder) {
+			e.String(1, k)
+			e.Message(2, func(e *Encoder) { encodeValue(e, m[k]) })
+		})
+	}
+}
+
+// encodeValue writes the fields of a google.protobuf.Value. Unlike scalar
+// fields, the kind of value is a oneof, written even if zero.
+func encodeValue(e *Encoder, v any) {
+	switch v := v.(type) {
+	case nil:
+		e.tag(1, wireVarint)
+		e.b = append(e.b, 0)
+	case float64:
+		e.tag(2, wireI64)
+		e.b = appendFixed64(e.b, math.Float64bits(v))
+	case string:
+		e.tag(3, wireLen)
+		e.b = appendLen(e.b, []byte(v))
+	case bool:
+		e.tag(4, wireVarint)
+		if v {
+			e.b = append(e.b, 1)
+		} else {
+			e.b = append(e.b, 0)
+		}
+	case map[string]any:
+		e.Message(5, func(e *Encoder) { encodeStruct(e, v) })
+	case []any:
+		e.Message(6, func(e *Encoder) {
+			for _, x := range v {
+				e.Message(1, func(e *Encoder) { encodeValue(e, x) })
+			}
+		})
+	}
+}
+
+// Struct returns the current field, a google.protobuf.Struct.
+func (d *Decoder) Struct() map[string]any {
+	m, err := decodeStruct(d.Message(), 0)
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+	return m
+}
+
+// maxValueDepth bounds the nesting of decoded Values.
+const maxValueDepth = 100
+
+func decodeStruct(d *Decoder, depth int) (map[string]any, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("%w: values nested too deep", ErrMalformed)
+	}
+	out := map[string]any{}
+	for d.Next() {
+		if d.Field() != 1 {
+			continue
+		}
+		var (
+			key   string
+			value any
+			err   error
+		)
+		entry := d.Message()
+		for entry.Next() {
+			switch entry.Field() {
+			case 1:
+				key = entry.String()
+			case 2:
+				value, err = decodeValue(entry.Message(), depth+1)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := entry.Err(); err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, d.Err()
+}
+
+func decodeValue(d *Decoder, depth int) (any, error) {
+	var (
+		v   any
+		err error
+	)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			v = nil
+		case 2:
+			v = d.Double()
+		case 3:
+			v = d.String()
+		case 4:
+			v = d.Bool()
+		case 5:
+			v, err = decodeStruct(d.Message(), depth+1)
+		case 6:
+			v, err = decodeList(d.Message(), depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, d.Err()
+}
+
+func decodeList(d *Decoder, depth int) ([]any, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("%w: values nested too deep", ErrMalformed)
+	}
+	out := []any{}
+	for d.Next() {
+		if d.Field() != 1 {
+			continue
+		}
+		v, err := decodeValue(d.Message(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, d.Err()
+}
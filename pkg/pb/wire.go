@@ -0,0 +1,338 @@
+// Package pb encodes models, scores and explanations in the Protocol
+// Buffers schema of proto/goguardml/v1/goguardml.proto, for consumers
+// outside Go. Samples and results are in pkg/io/protobuf.
+//
+// The encoding is written by hand on a minimal wire format codec, Encoder
+// and Decoder, rather than generated, so the module does not depend on
+// the protobuf runtime. Detectors use the codec for their own model
+// messages.
+package pb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireLen    = 2
+	wireI32    = 5
+)
+
+// ErrMalformed is returned when decoding bytes that are not a valid
+// message.
+var ErrMalformed = errors.New("pb: malformed message")
+
+// Encoder appends fields to a message. Scalars equal to their default
+// value are omitted, as proto3 does.
+type Encoder struct {
+	b []byte
+}
+
+// Bytes returns the encoded message.
+func (e *Encoder) Bytes() []byte {
+	return e.b
+}
+
+func (e *Encoder) tag(field, wire int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wire))
+}
+
+// Uint writes an unsigned integer field.
+func (e *Encoder) Uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.b = binary.AppendUvarint(e.b, v)
+}
+
+// Int writes a signed integer field, as int32 and int64 do.
+func (e *Encoder) Int(field int, v int64) {
+	e.Uint(field, uint64(v))
+}
+
+// Bool writes a boolean field.
+func (e *Encoder) Bool(field int, v bool) {
+	if v {
+		e.Uint(field, 1)
+	}
+}
+
+// Double writes a double field.
+func (e *Encoder) Double(field int, v float64) {
+	bits := math.Float64bits(v)
+	if bits == 0 {
+		return
+	}
+	e.tag(field, wireI64)
+	e.b = appendFixed64(e.b, bits)
+}
+
+// String writes a string field.
+func (e *Encoder) String(field int, v string) {
+	if v == "" {
+		return
+	}
+	e.tag(field, wireLen)
+	e.b = appendLen(e.b, []byte(v))
+}
+
+// RepeatedString writes each of v as an element of a repeated string
+// field, empty strings included.
+func (e *Encoder) RepeatedString(field int, v []string) {
+	for _, s := range v {
+		e.tag(field, wireLen)
+		e.b = appendLen(e.b, []byte(s))
+	}
+}
+
+// RawBytes writes a bytes field.
+func (e *Encoder) RawBytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireLen)
+	e.b = appendLen(e.b, v)
+}
+
+// Message writes a message field with the fields fill encodes. Message
+// fields are written even if empty, as their presence is significant.
+func (e *Encoder) Message(field int, fill func(*Encoder)) {
+	var sub Encoder
+	fill(&sub)
+	e.tag(field, wireLen)
+	e.b = appendLen(e.b, sub.b)
+}
+
+// Doubles writes a packed repeated double field.
+func (e *Encoder) Doubles(field int, v []float64) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireLen)
+	e.b = binary.AppendUvarint(e.b, uint64(8*len(v)))
+	for _, x := range v {
+		e.b = appendFixed64(e.b, math.Float64bits(x))
+	}
+}
+
+// Ints writes a packed repeated int32 or int64 field.
+func (e *Encoder) Ints(field int, v []int) {
+	if len(v) == 0 {
+		return
+	}
+	size := 0
+	for _, x := range v {
+		size += uvarintLen(uint64(int64(x)))
+	}
+	e.tag(field, wireLen)
+	e.b = binary.AppendUvarint(e.b, uint64(size))
+	for _, x := range v {
+		e.b = binary.AppendUvarint(e.b, uint64(int64(x)))
+	}
+}
+
+// appendLen appends v preceded by its length.
+func appendLen(b, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// Decoder reads the fields of a message:
+//
+//	d := pb.NewDecoder(b)
+//	for d.Next() {
+//		switch d.Field() {
+//		case 1:
+//			x = d.Double()
+//		}
+//	}
+//	if err := d.Err(); err != nil { ... }
+//
+// Fields not read are skipped. Reading a field as the wrong type is an
+// error, reported by Err.
+type Decoder struct {
+	b     []byte
+	field int
+	wire  int
+	num   uint64
+	raw   []byte
+	err   error
+}
+
+// NewDecoder returns a Decoder of the message b.
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{b: b}
+}
+
+// Next reads the next field, reporting whether there is one. It returns
+// false at the end of the message and on errors.
+func (d *Decoder) Next() bool {
+	if d.err != nil || len(d.b) == 0 {
+		return false
+	}
+	tag, n := binary.Uvarint(d.b)
+	if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+		return d.fail("invalid tag")
+	}
+	d.b = d.b[n:]
+	d.field, d.wire = int(tag>>3), int(tag&7)
+
+	switch d.wire {
+	case wireVarint:
+		if d.num, n = binary.Uvarint(d.b); n <= 0 {
+			return d.fail("truncated varint")
+		}
+		d.b = d.b[n:]
+	case wireI64:
+		if len(d.b) < 8 {
+			return d.fail("truncated fixed64")
+		}
+		d.num, d.b = binary.LittleEndian.Uint64(d.b), d.b[8:]
+	case wireI32:
+		if len(d.b) < 4 {
+			return d.fail("truncated fixed32")
+		}
+		d.num, d.b = uint64(binary.LittleEndian.Uint32(d.b)), d.b[4:]
+	case wireLen:
+		size, n := binary.Uvarint(d.b)
+		if n <= 0 || size > uint64(len(d.b)-n) {
+			return d.fail("truncated length-delimited field")
+		}
+		d.raw, d.b = d.b[n:n+int(size)], d.b[n+int(size):]
+	default:
+		return d.fail(fmt.Sprintf("unsupported wire type %d", d.wire))
+	}
+	return true
+}
+
+func (d *Decoder) fail(msg string) bool {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: field %d: %s", ErrMalformed, d.field, msg)
+	}
+	return false
+}
+
+func (d *Decoder) want(wire int) bool {
+	if d.wire != wire {
+		d.fail(fmt.Sprintf("wire type %d, want %d", d.wire, wire))
+		return false
+	}
+	return true
+}
+
+// Err returns the first error met while decoding.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Field returns the number of the current field.
+func (d *Decoder) Field() int {
+	return d.field
+}
+
+// Uint returns the current field as an unsigned integer.
+func (d *Decoder) Uint() uint64 {
+	if !d.want(wireVarint) {
+		return 0
+	}
+	return d.num
+}
+
+// Int returns the current field as a signed integer.
+func (d *Decoder) Int() int64 {
+	return int64(d.Uint())
+}
+
+// Bool returns the current field as a boolean.
+func (d *Decoder) Bool() bool {
+	return d.Uint() != 0
+}
+
+// Double returns the current field as a double.
+func (d *Decoder) Double() float64 {
+	if !d.want(wireI64) {
+		return 0
+	}
+	return math.Float64frombits(d.num)
+}
+
+// String returns the current field as a string.
+func (d *Decoder) String() string {
+	return string(d.RawBytes())
+}
+
+// RawBytes returns the current field as bytes. They alias the message.
+func (d *Decoder) RawBytes() []byte {
+	if !d.want(wireLen) {
+		return nil
+	}
+	return d.raw
+}
+
+// Message returns a Decoder of the current field, a message. Its errors
+// are not reported by d; see Adopt.
+func (d *Decoder) Message() *Decoder {
+	return NewDecoder(d.RawBytes())
+}
+
+// Adopt makes d report the error of sub, a Decoder of one of its fields,
+// if it has none of its own.
+func (d *Decoder) Adopt(sub *Decoder) {
+	if d.err == nil {
+		d.err = sub.err
+	}
+}
+
+// Doubles appends the current field, a repeated double, packed or not, to
+// dst.
+func (d *Decoder) Doubles(dst []float64) []float64 {
+	if d.wire == wireI64 {
+		return append(dst, math.Float64frombits(d.num))
+	}
+	raw := d.RawBytes()
+	if len(raw)%8 != 0 {
+		d.fail("truncated packed doubles")
+		return dst
+	}
+	for ; len(raw) > 0; raw = raw[8:] {
+		dst = append(dst, math.Float64frombits(binary.LittleEndian.Uint64(raw)))
+	}
+	return dst
+}
+
+// Ints appends the current field, a repeated int32 or int64, packed or
+// not, to dst.
+func (d *Decoder) Ints(dst []int) []int {
+	if d.wire == wireVarint {
+		return append(dst, int(int64(d.num)))
+	}
+	raw := d.RawBytes()
+	for len(raw) > 0 {
+		v, n := binary.Uvarint(raw)
+		if n <= 0 {
+			d.fail("truncated packed varints")
+			return dst
+		}
+		dst = append(dst, int(int64(v)))
+		raw = raw[n:]
+	}
+	return dst
+}
@@ -0,0 +1,194 @@
+package pb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoderWireFormat(t *testing.T) {
+	var e Encoder
+	e.Uint(1, 150)
+	e.Int(2, -1)
+	e.Double(3, 1)
+	e.String(4, "hi")
+	e.Doubles(5, []float64{0.5})
+	e.Ints(6, []int{1, 300})
+	e.Message(7, func(e *Encoder) { e.Bool(1, true) })
+	// Defaults are omitted.
+	e.Uint(8, 0)
+	e.Double(8, 0)
+	e.String(8, "")
+	e.Bool(8, false)
+
+	// Bytes as protoc would encode them.
+	want := []byte{
+		0x08, 0x96, 0x01,
+		0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x19, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+		0x22, 0x02, 'h', 'i',
+		0x2a, 0x08, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f,
+		0x32, 0x03, 0x01, 0xac, 0x02,
+		0x3a, 0x02, 0x08, 0x01,
+	}
+	assert.Equal(t, want, e.Bytes())
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC)
+	var e Encoder
+	e.Uint(1, math.MaxUint64)
+	e.Int(2, -7)
+	e.Bool(3, true)
+	e.Double(4, -2.5)
+	e.String(5, "name")
+	e.RawBytes(6, []byte{1, 2})
+	e.Doubles(7, []float64{1, math.Inf(1)})
+	e.Ints(8, []int{-1, 0, 1 << 40})
+	e.RepeatedString(9, []string{"a", ""})
+	e.Timestamp(10, ts)
+	e.Message(11, func(e *Encoder) { e.Int(1, 3) })
+	// An unknown fixed32 field is skipped.
+	e.tag(12, wireI32)
+	e.b = append(e.b, 1, 2, 3, 4)
+
+	var (
+		strs []string
+		sub  int64
+		seen []int
+	)
+	d := NewDecoder(e.Bytes())
+	for d.Next() {
+		seen = append(seen, d.Field())
+		switch d.Field() {
+		case 1:
+			assert.Equal(t, uint64(math.MaxUint64), d.Uint())
+		case 2:
+			assert.Equal(t, int64(-7), d.Int())
+		case 3:
+			assert.True(t, d.Bool())
+		case 4:
+			assert.Equal(t, -2.5, d.Double())
+		case 5:
+			assert.Equal(t, "name", d.String())
+		case 6:
+			assert.Equal(t, []byte{1, 2}, d.RawBytes())
+		case 7:
+			assert.Equal(t, []float64{1, math.Inf(1)}, d.Doubles(nil))
+		case 8:
+			assert.Equal(t, []int{-1, 0, 1 << 40}, d.Ints(nil))
+		case 9:
+			strs = append(strs, d.String())
+		case 10:
+			assert.Equal(t, ts, d.Timestamp())
+		case 11:
+			m := d.Message()
+			for m.Next() {
+				sub = m.Int()
+			}
+			require.NoError(t, m.Err())
+		}
+	}
+	require.NoError(t, d.Err())
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 9, 10, 11, 12}, seen)
+	assert.Equal(t, []string{"a", ""}, strs)
+	assert.Equal(t, int64(3), sub)
+}
+
+func TestDecoderUnpacked(t *testing.T) {
+	// Repeated scalars may also come one field each.
+	var e Encoder
+	e.Double(1, 1)
+	e.Double(1, 2)
+	e.Int(2, 5)
+	e.Int(2, 6)
+
+	var (
+		doubles []float64
+		ints    []int
+	)
+	d := NewDecoder(e.Bytes())
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			doubles = d.Doubles(doubles)
+		case 2:
+			ints = d.Ints(ints)
+		}
+	}
+	require.NoError(t, d.Err())
+	assert.Equal(t, []float64{1, 2}, doubles)
+	assert.Equal(t, []int{5, 6}, ints)
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"field zero", []byte{0x00, 0x01}},
+		{"truncated varint", []byte{0x08, 0x80}},
+		{"truncated fixed64", []byte{0x09, 1, 2}},
+		{"truncated fixed32", []byte{0x0d, 1}},
+		{"truncated length", []byte{0x0a, 0x05, 1}},
+		{"group", []byte{0x0b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(tt.data)
+			for d.Next() {
+			}
+			assert.ErrorIs(t, d.Err(), ErrMalformed)
+		})
+	}
+
+	// Reading a field as the wrong type.
+	var e Encoder
+	e.String(1, "x")
+	d := NewDecoder(e.Bytes())
+	require.True(t, d.Next())
+	assert.Zero(t, d.Double())
+	assert.ErrorIs(t, d.Err(), ErrMalformed)
+	assert.False(t, d.Next())
+
+	// Packed doubles of a length not a multiple of 8.
+	d = NewDecoder([]byte{0x0a, 0x03, 1, 2, 3})
+	require.True(t, d.Next())
+	d.Doubles(nil)
+	assert.ErrorIs(t, d.Err(), ErrMalformed)
+}
+
+func TestStruct(t *testing.T) {
+	v := map[string]any{
+		"route":  "eth0",
+		"count":  3,
+		"ratio":  0.5,
+		"ok":     true,
+		"none":   nil,
+		"list":   []any{"a", 1.0, false},
+		"nested": map[string]any{"k": "v"},
+	}
+	var e Encoder
+	require.NoError(t, e.Struct(1, v))
+	require.NoError(t, e.Struct(2, nil))
+
+	d := NewDecoder(e.Bytes())
+	require.True(t, d.Next())
+	got := d.Struct()
+	require.NoError(t, d.Err())
+	assert.False(t, d.Next())
+	assert.Equal(t, map[string]any{
+		"route":  "eth0",
+		"count":  3.0,
+		"ratio":  0.5,
+		"ok":     true,
+		"none":   nil,
+		"list":   []any{"a", 1.0, false},
+		"nested": map[string]any{"k": "v"},
+	}, got)
+
+	assert.Error(t, e.Struct(3, map[string]any{"bad": math.NaN()}))
+}
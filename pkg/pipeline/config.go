@@ -0,0 +1,358 @@
+// Package pipeline runs a detection end to end from a declarative YAML
+// config: where samples come from, how they are preprocessed, which
+// detector scores them and with what threshold, and where results go. A
+// new detection is a new config file instead of a new Go program.
+//
+//	name: web-abuse
+//	input:
+//	  path: /var/log/nginx/access.log
+//	train:
+//	  input: {path: baseline.log}
+//	  save: model.bin
+//	preprocess:
+//	  dedup: true
+//	  max_rows: 100000
+//	detector:
+//	  algorithm: iforest
+//	  trees: 200
+//	  contamination: 0.01
+//	threshold:
+//	  quantile: 0.995
+//	severity:
+//	  percentiles: [0.99, 0.995, 0.999, 0.9999]
+//	outputs:
+//	  - type: jsonl
+//	    path: anomalies.jsonl
+//	    anomalies_only: true
+//	  - type: grafana
+//	    min_severity: high
+//	    url: http://grafana:3000
+//	    token_file: grafana.token
+//	  - type: snapshot
+//	    path: incidents/
+//	    trigger: 0.2
+//	    before: 5m
+//	    after: 5m
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// Config is a pipeline definition. Relative paths in it are relative to
+// the directory of the config file.
+type Config struct {
+	// Name identifies the pipeline in errors and the model card.
+	Name string `yaml:"name"`
+	// Input is the data scored.
+	Input SourceConfig `yaml:"input"`
+	// Model is a saved model to load. Exactly one of Model and Train must
+	// be set.
+	Model string `yaml:"model"`
+	// Train fits a new detector instead of loading one.
+	Train      *TrainConfig     `yaml:"train"`
+	Preprocess PreprocessConfig `yaml:"preprocess"`
+	Detector   DetectorConfig   `yaml:"detector"`
+	Threshold  ThresholdConfig  `yaml:"threshold"`
+	Severity   SeverityConfig   `yaml:"severity"`
+	// Outputs receive every result; at least one is required.
+	Outputs []OutputConfig `yaml:"outputs"`
+	// Explain attaches feature attributions to anomalies.
+	Explain bool `yaml:"explain"`
+}
+
+// SourceConfig selects a Reader.
+type SourceConfig struct {
+	// Type is csv, pcap, live (packet capture on Interface), accesslog or
+	// kube (audit log). Defaults to the type of Path's extension: .pcap,
+	// .pcapng and .cap are pcap, .log accesslog, anything else csv.
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+	// Header tells whether CSV input has a header row. Defaults to true.
+	Header *bool `yaml:"header"`
+	// Ragged is the policy for CSV rows with missing or extra fields:
+	// reject (the default), truncate, or pad (with column means).
+	Ragged string `yaml:"ragged"`
+	// Interface, Snaplen and Promisc configure live capture.
+	Interface string `yaml:"interface"`
+	Snaplen   int32  `yaml:"snaplen"`
+	Promisc   bool   `yaml:"promisc"`
+}
+
+// TrainConfig fits the detector on a dataset read in full.
+type TrainConfig struct {
+	Input SourceConfig `yaml:"input"`
+	// Save writes the trained model to this path, if set.
+	Save string `yaml:"save"`
+}
+
+// PreprocessConfig transforms samples before they reach the detector.
+type PreprocessConfig struct {
+	// Columns selects features by name or index, in this order; all of
+	// them when empty.
+	Columns []string `yaml:"columns"`
+	// Impute replaces missing values (NaN) with the running column mean
+	// ("mean") or 0 ("zero"). Missing values are an error by default.
+	Impute string `yaml:"impute"`
+	// Dedup drops repeated training rows.
+	Dedup bool `yaml:"dedup"`
+	// MaxRows trains on a random sample of at most this many rows.
+	MaxRows int `yaml:"max_rows"`
+	// Seed seeds the sample. Defaults to the detector's seed.
+	Seed int64 `yaml:"seed"`
+}
+
+// DetectorConfig configures the detector Train fits. Zero values keep
+// the algorithm's defaults.
+type DetectorConfig struct {
+	// Algorithm is the detector: iforest, the default.
+	Algorithm       string  `yaml:"algorithm"`
+	Trees           int     `yaml:"trees"`
+	SampleSize      int     `yaml:"sample_size"`
+	Contamination   float64 `yaml:"contamination"`
+	Seed            int64   `yaml:"seed"`
+	ExcludeConstant bool    `yaml:"exclude_constant"`
+	Quantize        int     `yaml:"quantize"`
+	// FeatureWeights biases splits toward features, by name or index;
+	// unlisted features weigh 1.
+	FeatureWeights map[string]float64 `yaml:"feature_weights"`
+}
+
+// ThresholdConfig overrides the detector's anomaly threshold. At most
+// one field may be set.
+type ThresholdConfig struct {
+	// Value is a fixed threshold.
+	Value *float64 `yaml:"value"`
+	// Quantile sets the threshold to this quantile of the training
+	// scores, such as 0.99 to flag the top 1%. Needs Train.
+	Quantile *float64 `yaml:"quantile"`
+}
+
+// SeverityConfig grades results info to critical. At most one field may
+// be set; results are not graded without either.
+type SeverityConfig struct {
+	// Bands are the lowest low, medium, high and critical scores.
+	Bands []float64 `yaml:"bands"`
+	// Percentiles are the quantiles of the training scores the bands
+	// start at, such as [0.9, 0.99, 0.999, 0.9999]. Needs Train.
+	Percentiles []float64 `yaml:"percentiles"`
+}
+
+// OutputConfig selects a result Writer.
+type OutputConfig struct {
+	// Type is jsonl, proto, grafana or snapshot.
+	Type string `yaml:"type"`
+	// Path is the file jsonl and proto results are written to, standard
+	// output if empty, or the directory snapshot incidents are saved to.
+	Path string `yaml:"path"`
+	// AnomaliesOnly drops normal results.
+	AnomaliesOnly bool `yaml:"anomalies_only"`
+	// MinSeverity drops results graded below it: info, low, medium, high
+	// or critical. Needs a severity section.
+	MinSeverity string `yaml:"min_severity"`
+	// URL, TokenFile and Tags configure grafana outputs.
+	URL       string   `yaml:"url"`
+	TokenFile string   `yaml:"token_file"`
+	Tags      []string `yaml:"tags"`
+	// Trigger, Before and After configure snapshot outputs, which save
+	// the results around periods when the anomaly rate reaches Trigger;
+	// zero values keep the defaults of package snapshot.
+	Trigger float64       `yaml:"trigger"`
+	Before  time.Duration `yaml:"before"`
+	After   time.Duration `yaml:"after"`
+}
+
+// LoadConfig reads and validates the config file at path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.resolve(filepath.Dir(path))
+	return cfg, nil
+}
+
+// ParseConfig decodes and validates a YAML config. Unknown keys are an
+// error, so typos do not silently fall back to defaults.
+func ParseConfig(b []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("pipeline config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem with the config, joined.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("pipeline config: "+format, args...))
+	}
+	if err := c.Input.validate(); err != nil {
+		bad("input: %v", err)
+	}
+	switch {
+	case c.Model == "" && c.Train == nil:
+		bad("need a model or a train section")
+	case c.Model != "" && c.Train != nil:
+		bad("model and train are exclusive")
+	case c.Train != nil:
+		if err := c.Train.Input.validate(); err != nil {
+			bad("train input: %v", err)
+		}
+		if c.Train.Input.kind() == "live" {
+			bad("train input: live capture cannot be read in full")
+		}
+	}
+	switch c.Preprocess.Impute {
+	case "", "mean", "zero":
+	default:
+		bad("preprocess: unknown impute %q (want mean or zero)", c.Preprocess.Impute)
+	}
+	if c.Preprocess.MaxRows < 0 {
+		bad("preprocess: max_rows must not be negative")
+	}
+	if c.Model != "" && (c.Preprocess.Dedup || c.Preprocess.MaxRows > 0) {
+		bad("preprocess: dedup and max_rows apply to training, and model loads a trained one")
+	}
+	switch c.Detector.Algorithm {
+	case "", "iforest":
+	default:
+		bad("detector: unknown algorithm %q", c.Detector.Algorithm)
+	}
+	if c.Threshold.Value != nil && c.Threshold.Quantile != nil {
+		bad("threshold: value and quantile are exclusive")
+	}
+	if q := c.Threshold.Quantile; q != nil && (*q <= 0 || *q >= 1 || c.Train == nil) {
+		bad("threshold: quantile must be in (0, 1) and needs a train section")
+	}
+	sc := c.Severity
+	switch {
+	case sc.Bands != nil && sc.Percentiles != nil:
+		bad("severity: bands and percentiles are exclusive")
+	case sc.Bands != nil:
+		if len(sc.Bands) != 4 {
+			bad("severity: want 4 bands, low to critical")
+		} else if err := sc.bands().Validate(); err != nil {
+			bad("severity: %v", err)
+		}
+	case sc.Percentiles != nil:
+		if len(sc.Percentiles) != 4 || c.Train == nil {
+			bad("severity: want 4 percentiles, low to critical, and a train section")
+		}
+	}
+	if len(c.Outputs) == 0 {
+		bad("need at least one output")
+	}
+	for i, o := range c.Outputs {
+		switch o.Type {
+		case "jsonl", "proto":
+		case "grafana":
+			if o.URL == "" {
+				bad("outputs[%d]: grafana needs a url", i)
+			}
+		case "snapshot":
+			if o.Path == "" {
+				bad("outputs[%d]: snapshot needs a path", i)
+			}
+			if o.AnomaliesOnly || o.MinSeverity != "" {
+				bad("outputs[%d]: snapshots keep every result; drop anomalies_only and min_severity", i)
+			}
+			if o.Trigger < 0 || o.Trigger > 1 {
+				bad("outputs[%d]: trigger must be in (0, 1]", i)
+			}
+		default:
+			bad("outputs[%d]: unknown type %q (want jsonl, proto, grafana or snapshot)", i, o.Type)
+		}
+		if o.MinSeverity != "" {
+			if _, err := detectors.ParseSeverity(o.MinSeverity); err != nil {
+				bad("outputs[%d]: %v", i, err)
+			} else if sc.Bands == nil && sc.Percentiles == nil {
+				bad("outputs[%d]: min_severity needs a severity section", i)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// bands returns the fixed bands of the config.
+func (c *SeverityConfig) bands() detectors.SeverityBands {
+	return detectors.SeverityBands{Low: c.Bands[0], Medium: c.Bands[1], High: c.Bands[2], Critical: c.Bands[3]}
+}
+
+func (s *SourceConfig) validate() error {
+	switch s.kind() {
+	case "live":
+		if s.Interface == "" {
+			return errors.New("live capture needs an interface")
+		}
+		return nil
+	case "csv", "pcap", "accesslog", "kube":
+	default:
+		return fmt.Errorf("unknown type %q (want csv, pcap, live, accesslog or kube)", s.Type)
+	}
+	if s.Path == "" {
+		return errors.New("need a path")
+	}
+	switch s.Ragged {
+	case "", "reject", "truncate", "pad":
+		return nil
+	default:
+		return fmt.Errorf("unknown ragged policy %q (want reject, truncate or pad)", s.Ragged)
+	}
+}
+
+// kind returns the source type, inferred from the path if not set.
+func (s *SourceConfig) kind() string {
+	if s.Type != "" {
+		return s.Type
+	}
+	if s.Interface != "" && s.Path == "" {
+		return "live"
+	}
+	switch filepath.Ext(s.Path) {
+	case ".pcap", ".pcapng", ".cap":
+		return "pcap"
+	case ".log":
+		return "accesslog"
+	default:
+		return "csv"
+	}
+}
+
+// resolve makes the relative paths of c relative to dir.
+func (c *Config) resolve(dir string) {
+	abs := func(p *string) {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	abs(&c.Input.Path)
+	abs(&c.Model)
+	if c.Train != nil {
+		abs(&c.Train.Input.Path)
+		abs(&c.Train.Save)
+	}
+	for i := range c.Outputs {
+		abs(&c.Outputs[i].Path)
+		abs(&c.Outputs[i].TokenFile)
+	}
+}
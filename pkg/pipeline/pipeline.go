@@ -0,0 +1,575 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/dataset"
+	"github.com/hed1ad/goguardml/pkg/io/grafana"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+	"github.com/hed1ad/goguardml/pkg/io/protobuf"
+	"github.com/hed1ad/goguardml/pkg/io/snapshot"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// Option configures a Pipeline.
+type Option func(*options)
+
+type options struct {
+	stdout     io.Writer
+	signingKey []byte
+}
+
+// WithStdout sets where outputs without a path write, os.Stdout by
+// default.
+func WithStdout(w io.Writer) Option {
+	return func(o *options) {
+		o.stdout = w
+	}
+}
+
+// WithSigningKey signs saved models and verifies loaded ones with key;
+// see iforest.WithSigningKey.
+func WithSigningKey(key []byte) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// Stats counts what a Run did.
+type Stats struct {
+	// Samples is the number of samples scored.
+	Samples int64
+	// Anomalies is the number of them flagged.
+	Anomalies int64
+	// Rejected is the number of samples the detector could not score,
+	// such as ones with missing values and no imputation.
+	Rejected int64
+}
+
+// Pipeline is a detector ready to score the input of its config.
+type Pipeline struct {
+	cfg  *Config
+	opts options
+	d    detectors.StreamDetector
+	// bands grades results, nil without a severity section.
+	bands *detectors.SeverityBands
+	stats struct {
+		samples, anomalies, rejected atomic.Int64
+	}
+}
+
+// Run loads the config file at configFile, trains or loads its detector
+// and scores its input into its outputs until the input ends.
+func Run(configFile string) error {
+	return RunContext(context.Background(), configFile)
+}
+
+// RunContext is Run stopping when ctx is done, which is how pipelines on
+// unbounded inputs such as live capture end.
+func RunContext(ctx context.Context, configFile string, opts ...Option) error {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	p, err := New(cfg, opts...)
+	if err != nil {
+		return err
+	}
+	return p.Run(ctx)
+}
+
+// New prepares the detector of cfg: loads its model, or reads its
+// training data, preprocesses it, fits a detector and saves it if asked,
+// then applies the threshold.
+func New(cfg *Config, opts ...Option) (*Pipeline, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{cfg: cfg, opts: options{stdout: os.Stdout}}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+
+	if cfg.Train == nil {
+		d, err := p.load()
+		if err != nil {
+			return nil, err
+		}
+		p.d = d
+	} else if err := p.train(); err != nil {
+		return nil, err
+	}
+	if v := cfg.Threshold.Value; v != nil {
+		t, ok := p.d.(detectors.Thresholder)
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: detector has no threshold to set", cfg.Name)
+		}
+		t.SetThreshold(*v)
+	}
+	if cfg.Severity.Bands != nil {
+		b := cfg.Severity.bands()
+		p.bands = &b
+	}
+	return p, nil
+}
+
+// Detector returns the trained or loaded detector.
+func (p *Pipeline) Detector() detectors.StreamDetector {
+	return p.d
+}
+
+// Stats returns the counts of the current or last Run.
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Samples:   p.stats.samples.Load(),
+		Anomalies: p.stats.anomalies.Load(),
+		Rejected:  p.stats.rejected.Load(),
+	}
+}
+
+// load reads the model of the config.
+func (p *Pipeline) load() (detectors.StreamDetector, error) {
+	f, err := iforest.OpenMapped(p.cfg.Model, iforest.WithSigningKey(p.opts.signingKey))
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, iforest.ErrNotFlat) {
+		return nil, fmt.Errorf("load model %s: %w", p.cfg.Model, err)
+	}
+	file, err := os.Open(p.cfg.Model)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f = iforest.New(iforest.WithSigningKey(p.opts.signingKey))
+	if err := f.LoadFrom(file); err != nil {
+		return nil, fmt.Errorf("load model %s: %w", p.cfg.Model, err)
+	}
+	return f, nil
+}
+
+// train fits a detector on the training input of the config.
+func (p *Pipeline) train() error {
+	tc := p.cfg.Train
+	r, names, err := openSource(tc.Input)
+	if err != nil {
+		return fmt.Errorf("train input: %w", err)
+	}
+	rows, err := r.Read()
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("train input: %w", err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("train input: no samples in %s", tc.Input.Path)
+	}
+
+	pre, err := p.preprocessor(names, len(rows[0]))
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		rows[i] = pre.apply(nil, row)
+	}
+	pc := p.cfg.Preprocess
+	seed := pc.Seed
+	if seed == 0 {
+		seed = p.cfg.Detector.Seed
+	}
+	if pc.Dedup {
+		rows = dataset.Dedup(rows)
+	}
+	if pc.MaxRows > 0 && len(rows) > pc.MaxRows {
+		rows = dataset.Sample(rows, pc.MaxRows, seed)
+	}
+
+	f, err := p.newForest(pre.names, len(rows[0]))
+	if err != nil {
+		return err
+	}
+	if err := f.Fit(rows); err != nil {
+		return fmt.Errorf("train: %w", err)
+	}
+	q, pct := p.cfg.Threshold.Quantile, p.cfg.Severity.Percentiles
+	if q != nil || pct != nil {
+		scores, err := f.Predict(rows)
+		if err != nil {
+			return err
+		}
+		if q != nil {
+			est := stats.NewQuantileEstimator(len(scores), 0)
+			est.AddAll(scores)
+			f.SetThreshold(est.Quantile(*q))
+		}
+		if pct != nil {
+			b, err := detectors.PercentileBands(scores, pct[0], pct[1], pct[2], pct[3])
+			if err != nil {
+				return fmt.Errorf("severity: %w", err)
+			}
+			p.bands = &b
+		}
+	}
+	if tc.Save != "" {
+		if err := save(f, tc.Save); err != nil {
+			return err
+		}
+	}
+	p.d = f
+	return nil
+}
+
+// newForest creates the isolation forest the detector section describes,
+// over features named names.
+func (p *Pipeline) newForest(names []string, nFeatures int) (*iforest.IsolationForest, error) {
+	dc := p.cfg.Detector
+	source := p.cfg.Name
+	if source == "" {
+		source = p.cfg.Train.Input.Path
+	}
+	opts := []iforest.Option{
+		iforest.WithExcludeConstant(dc.ExcludeConstant),
+		iforest.WithQuantization(dc.Quantize),
+		iforest.WithDataSource(source),
+		iforest.WithFeatureNames(names),
+		iforest.WithSigningKey(p.opts.signingKey),
+	}
+	if dc.Trees > 0 {
+		opts = append(opts, iforest.WithTrees(dc.Trees))
+	}
+	if dc.SampleSize > 0 {
+		opts = append(opts, iforest.WithSampleSize(dc.SampleSize))
+	}
+	if dc.Contamination > 0 {
+		opts = append(opts, iforest.WithContamination(dc.Contamination))
+	}
+	if dc.Seed != 0 {
+		opts = append(opts, iforest.WithSeed(dc.Seed))
+	}
+	if len(dc.FeatureWeights) > 0 {
+		weights := make([]float64, nFeatures)
+		for j := range weights {
+			weights[j] = 1
+		}
+		for key, w := range dc.FeatureWeights {
+			j, err := columnIndex(key, names, nFeatures)
+			if err != nil {
+				return nil, fmt.Errorf("detector: feature_weights: %w", err)
+			}
+			weights[j] = w
+		}
+		opts = append(opts, iforest.WithFeatureWeights(weights))
+	}
+	f := iforest.New(opts...)
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("detector: %w", err)
+	}
+	return f, nil
+}
+
+func save(d detectors.Detector, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := d.SaveTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("save model %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// preprocessor applies imputation and column selection to samples.
+type preprocessor struct {
+	imputer data.Imputer
+	columns []int // nil for all
+	names   []string
+}
+
+// preprocessor returns the preprocessing of the config for samples of
+// nFeatures features named names, if known.
+func (p *Pipeline) preprocessor(names []string, nFeatures int) (*preprocessor, error) {
+	pre := &preprocessor{names: names}
+	switch p.cfg.Preprocess.Impute {
+	case "mean":
+		pre.imputer = data.NewMeanImputer()
+	case "zero":
+		pre.imputer = data.ConstantImputer(0)
+	}
+	if len(p.cfg.Preprocess.Columns) == 0 {
+		return pre, nil
+	}
+	pre.names = nil
+	for _, key := range p.cfg.Preprocess.Columns {
+		j, err := columnIndex(key, names, nFeatures)
+		if err != nil {
+			return nil, fmt.Errorf("preprocess: columns: %w", err)
+		}
+		pre.columns = append(pre.columns, j)
+		if names != nil {
+			pre.names = append(pre.names, names[j])
+		}
+	}
+	return pre, nil
+}
+
+// apply returns the preprocessed row, reusing dst when it is large
+// enough. row is imputed in place.
+func (pre *preprocessor) apply(dst, row []float64) []float64 {
+	if pre.imputer != nil {
+		pre.imputer.Impute(row)
+	}
+	if pre.columns == nil {
+		return row
+	}
+	dst = slices.Grow(dst[:0], len(pre.columns))
+	for _, j := range pre.columns {
+		if j < len(row) {
+			dst = append(dst, row[j])
+		} else {
+			dst = append(dst, 0)
+		}
+	}
+	return dst
+}
+
+// columnIndex resolves a column given by name, or else by index.
+func columnIndex(key string, names []string, n int) (int, error) {
+	if j := slices.Index(names, key); j >= 0 {
+		return j, nil
+	}
+	j, err := strconv.Atoi(key)
+	if err != nil || j < 0 || j >= n {
+		return 0, fmt.Errorf("no feature %q", key)
+	}
+	return j, nil
+}
+
+// Run scores the input into the outputs until the input ends or ctx is
+// done. Stopping because ctx is done is not an error.
+func (p *Pipeline) Run(ctx context.Context) error {
+	p.stats.samples.Store(0)
+	p.stats.anomalies.Store(0)
+	p.stats.rejected.Store(0)
+
+	r, names, err := openSource(p.cfg.Input)
+	if err != nil {
+		return fmt.Errorf("input: %w", err)
+	}
+	defer r.Close()
+	w, err := p.openOutputs()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	in, err := r.StreamSamples(ctx)
+	if err != nil {
+		return fmt.Errorf("input: %w", err)
+	}
+	// The width is only known from the first sample without names.
+	first, ok := <-in
+	if !ok {
+		return readerErr(r)
+	}
+	pre, err := p.preprocessor(names, len(first.Features))
+	if err != nil {
+		return err
+	}
+	if card, ok := detectors.MetadataOf(p.d); ok && len(card.FeatureNames) > 0 && pre.names == nil {
+		pre.names = card.FeatureNames
+	}
+
+	samples := make(chan guardio.Sample, cap(in))
+	go func() {
+		defer close(samples)
+		for s, ok := first, true; ok; s, ok = <-in {
+			s.Features = pre.apply(nil, s.Features)
+			select {
+			case samples <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := p.score(ctx, samples, w, pre.names); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return readerErr(r)
+}
+
+// score scores samples with the detector and writes their results.
+func (p *Pipeline) score(ctx context.Context, samples <-chan guardio.Sample, w guardio.Writer, names []string) error {
+	features, queue := guardio.SplitSamples(ctx, samples)
+	if rr, ok := p.d.(detectors.RejectReporter); ok {
+		rr.SetRejectHandler(func(rej detectors.Rejection) {
+			p.stats.rejected.Add(1)
+			queue.Match(rej.Sample)
+		})
+		defer rr.SetRejectHandler(nil)
+	}
+
+	scores := make(chan detectors.Score, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.d.PredictStream(ctx, features, scores)
+	}()
+
+	var werr error
+	for score := range scores {
+		if werr != nil {
+			continue // drain so PredictStream can return
+		}
+		result := guardio.Result{
+			Timestamp:    time.Now().Unix(),
+			Score:        score.Value,
+			IsAnomaly:    score.IsAnomaly,
+			Features:     score.Features,
+			FeatureNames: names,
+		}
+		if sample, ok := queue.Match(score.Features); ok {
+			result.Timestamp = sample.Time.Unix()
+			result.Seq = sample.Seq
+		}
+		p.stats.samples.Add(1)
+		if score.IsAnomaly {
+			p.stats.anomalies.Add(1)
+			if p.cfg.Explain {
+				exp, err := detectors.Explain(p.d, score.Features)
+				if err == nil {
+					result.Explanation = &exp
+				}
+			}
+		}
+		werr = w.Write(result)
+	}
+	if err := <-errCh; err != nil && ctx.Err() == nil {
+		return fmt.Errorf("score: %w", err)
+	}
+	return werr
+}
+
+// readerErr returns the error that ended a reader's stream, if it keeps
+// one.
+func readerErr(r guardio.Reader) error {
+	if e, ok := r.(interface{ Err() error }); ok && e.Err() != nil {
+		return fmt.Errorf("input: %w", e.Err())
+	}
+	return nil
+}
+
+// openOutputs opens every output of the config as one Writer.
+func (p *Pipeline) openOutputs() (guardio.Writer, error) {
+	var ws []guardio.Writer
+	fail := func(err error) (guardio.Writer, error) {
+		_ = guardio.MultiWriter(ws...).Close()
+		return nil, err
+	}
+	for _, o := range p.cfg.Outputs {
+		var w guardio.Writer
+		switch o.Type {
+		case "jsonl", "proto":
+			dst := io.Writer(nopCloser{p.opts.stdout})
+			if o.Path != "" {
+				file, err := os.Create(o.Path)
+				if err != nil {
+					return fail(err)
+				}
+				dst = file
+			}
+			if o.Type == "jsonl" {
+				w = jsonl.NewWriter(dst)
+			} else {
+				w = protobuf.NewWriter(dst)
+			}
+		case "grafana":
+			var opts []grafana.Option
+			if o.TokenFile != "" {
+				token, err := os.ReadFile(o.TokenFile)
+				if err != nil {
+					return fail(err)
+				}
+				opts = append(opts, grafana.WithToken(string(bytes.TrimSpace(token))))
+			}
+			if len(o.Tags) > 0 {
+				opts = append(opts, grafana.WithTags(o.Tags...))
+			}
+			gw, err := grafana.NewWriter(o.URL, opts...)
+			if err != nil {
+				return fail(err)
+			}
+			w = gw
+		case "snapshot":
+			var opts []snapshot.Option
+			if o.Trigger > 0 {
+				opts = append(opts, snapshot.WithTrigger(o.Trigger))
+			}
+			if o.Before > 0 {
+				opts = append(opts, snapshot.WithBefore(o.Before))
+			}
+			if o.After > 0 {
+				opts = append(opts, snapshot.WithAfter(o.After))
+			}
+			sw, err := snapshot.New(o.Path, opts...)
+			if err != nil {
+				return fail(err)
+			}
+			w = sw
+		}
+		if o.AnomaliesOnly {
+			w = anomalyWriter{w}
+		}
+		if o.MinSeverity != "" {
+			least, _ := detectors.ParseSeverity(o.MinSeverity) // checked by Validate
+			w = guardio.MinSeverity(w, least)
+		}
+		ws = append(ws, w)
+	}
+	w := guardio.MultiWriter(ws...)
+	if p.bands != nil {
+		w = guardio.WithSeverity(w, *p.bands)
+	}
+	return w, nil
+}
+
+// nopCloser keeps outputs from closing standard output.
+type nopCloser struct {
+	io.Writer
+}
+
+// anomalyWriter passes only anomalies on.
+type anomalyWriter struct {
+	guardio.Writer
+}
+
+func (w anomalyWriter) Write(r guardio.Result) error {
+	if !r.IsAnomaly {
+		return nil
+	}
+	return w.Writer.Write(r)
+}
+
+func (w anomalyWriter) WriteAll(results []guardio.Result) error {
+	for _, r := range results {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
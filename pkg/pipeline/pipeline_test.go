@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// writeCSV writes rows of (bytes, port, noise) with a header, the last
+// row an outlier.
+func writeCSV(t *testing.T, path string, n int) {
+	var b strings.Builder
+	b.WriteString("bytes,port,noise\n")
+	for i := range n {
+		fmt.Fprintf(&b, "%d,443,%d\n", 100+i%10, i%7)
+	}
+	b.WriteString("90000,4444,3\n")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+}
+
+func readResults(t *testing.T, path string) []guardio.Result {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var results []guardio.Result
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var r guardio.Result
+		require.NoError(t, dec.Decode(&r))
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCSV(t, filepath.Join(dir, "train.csv"), 300)
+	writeCSV(t, filepath.Join(dir, "live.csv"), 50)
+	config := `
+name: test
+input: {path: live.csv}
+train:
+  input: {path: train.csv}
+  save: model.bin
+preprocess:
+  columns: [bytes, port]
+  dedup: true
+detector:
+  trees: 50
+  seed: 7
+  feature_weights: {bytes: 2}
+threshold:
+  quantile: 0.99
+severity:
+  percentiles: [0.5, 0.9, 0.99, 0.999]
+outputs:
+  - type: jsonl
+    path: all.jsonl
+  - type: jsonl
+    path: anomalies.jsonl
+    anomalies_only: true
+  - type: jsonl
+    path: critical.jsonl
+    min_severity: critical
+  - type: snapshot
+    path: incidents
+    trigger: 0.01
+    before: 1h
+explain: true
+`
+	configFile := filepath.Join(dir, "pipeline.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(config), 0o644))
+	require.NoError(t, Run(configFile))
+
+	all := readResults(t, filepath.Join(dir, "all.jsonl"))
+	require.Len(t, all, 51)
+	assert.Equal(t, []string{"bytes", "port"}, all[0].FeatureNames)
+	assert.Len(t, all[0].Features, 2, "columns are selected before scoring")
+	last := all[50]
+	assert.True(t, last.IsAnomaly)
+	assert.Equal(t, []float64{90000, 4444}, last.Features)
+	require.NotNil(t, last.Explanation)
+	assert.Equal(t, detectors.SeverityCritical, last.Severity)
+	assert.NotEqual(t, detectors.SeverityNone, all[0].Severity, "every result is graded")
+
+	critical := readResults(t, filepath.Join(dir, "critical.jsonl"))
+	assert.NotEmpty(t, critical)
+	for _, r := range critical {
+		assert.Equal(t, detectors.SeverityCritical, r.Severity)
+	}
+
+	incidents, err := filepath.Glob(filepath.Join(dir, "incidents", "incident-*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, incidents, 1, "the outlier crosses the trigger")
+	assert.Len(t, readResults(t, incidents[0]), 51, "with every result before it")
+
+	anomalies := readResults(t, filepath.Join(dir, "anomalies.jsonl"))
+	assert.NotEmpty(t, anomalies)
+	assert.Less(t, len(anomalies), len(all))
+	for _, r := range anomalies {
+		assert.True(t, r.IsAnomaly)
+	}
+
+	// The saved model scores the same input without training again.
+	cfg, err := ParseConfig([]byte(`
+input: {path: live.csv}
+model: model.bin
+preprocess: {columns: [bytes, port]}
+threshold: {value: 0.99}
+outputs: [{type: jsonl}]
+`))
+	require.NoError(t, err)
+	cfg.resolve(dir)
+	var out bytes.Buffer
+	p, err := New(cfg, WithStdout(&out))
+	require.NoError(t, err)
+	assert.Equal(t, 0.99, detectors.ThresholdOf(p.Detector()))
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, Stats{Samples: 51}, p.Stats())
+	assert.Equal(t, 51, bytes.Count(out.Bytes(), []byte("\n")))
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config string
+		errs   []string
+	}{
+		"unknown key": {
+			config: "input: {path: a.csv}\nmodel: m\noutputs: [{type: jsonl}]\ntreshold: {value: 1}\n",
+			errs:   []string{"field treshold not found"},
+		},
+		"model and train": {
+			config: "input: {path: a.csv}\nmodel: m\ntrain: {input: {path: b.csv}}\noutputs: [{type: jsonl}]\n",
+			errs:   []string{"model and train are exclusive"},
+		},
+		"everything": {
+			config: `
+input: {type: live}
+preprocess: {impute: median}
+detector: {algorithm: lof}
+threshold: {value: 0.5, quantile: 0.9}
+severity: {bands: [0.5, 0.6], percentiles: [0.9, 0.99, 0.999, 0.9999]}
+outputs: [{type: grafana}, {type: csv, min_severity: urgent}, {type: snapshot, anomalies_only: true}]
+`,
+			errs: []string{
+				"input: live capture needs an interface",
+				"need a model or a train section",
+				`unknown impute "median"`,
+				`unknown algorithm "lof"`,
+				"value and quantile are exclusive",
+				"quantile must be in (0, 1) and needs a train section",
+				"outputs[0]: grafana needs a url",
+				`outputs[1]: unknown type "csv"`,
+				"severity: bands and percentiles are exclusive",
+				`outputs[1]: unknown severity "urgent"`,
+				"outputs[2]: snapshot needs a path",
+				"outputs[2]: snapshots keep every result",
+			},
+		},
+		"severity": {
+			config: "input: {path: a.csv}\nmodel: m\nseverity: {bands: [0.9, 0.6, 0.7, 0.8]}\noutputs: [{type: jsonl}]\n",
+			errs:   []string{"severity bands must not decrease"},
+		},
+		"min severity without bands": {
+			config: "input: {path: a.csv}\nmodel: m\noutputs: [{type: jsonl, min_severity: high}]\n",
+			errs:   []string{"min_severity needs a severity section"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tc.config))
+			require.Error(t, err)
+			for _, want := range tc.errs {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
+
+func TestSourceKind(t *testing.T) {
+	for path, want := range map[string]string{
+		"a.csv": "csv", "a.pcapng": "pcap", "access.log": "accesslog", "data": "csv",
+	} {
+		s := SourceConfig{Path: path}
+		assert.Equal(t, want, s.kind(), path)
+	}
+	assert.Equal(t, "live", (&SourceConfig{Interface: "eth0"}).kind())
+	assert.Equal(t, "kube", (&SourceConfig{Type: "kube", Path: "audit.log"}).kind())
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/data"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/accesslog"
+	"github.com/hed1ad/goguardml/pkg/io/csv"
+	"github.com/hed1ad/goguardml/pkg/io/kube"
+	"github.com/hed1ad/goguardml/pkg/io/pcap"
+)
+
+// liveTimeout is the read timeout of live capture handles.
+const liveTimeout = 500 * time.Millisecond
+
+// openSource opens the Reader s describes, with the names of its
+// features when known.
+func openSource(s SourceConfig) (guardio.Reader, []string, error) {
+	switch s.kind() {
+	case "pcap":
+		r, err := pcap.NewFileReader(s.Path)
+		return r, pcap.NewFeatureExtractor().FeatureNames(), err
+	case "live":
+		snaplen := s.Snaplen
+		if snaplen == 0 {
+			snaplen = 65535
+		}
+		r, err := pcap.NewLiveReader(s.Interface, snaplen, s.Promisc, liveTimeout)
+		return r, pcap.NewFeatureExtractor().FeatureNames(), err
+	case "accesslog":
+		r, err := accesslog.NewFileReader(s.Path)
+		return r, accesslog.FeatureNames, err
+	case "kube":
+		r, err := kube.NewFileReader(s.Path)
+		return r, kube.FeatureNames, err
+	default:
+		header := s.Header == nil || *s.Header
+		opts := []csv.Option{csv.WithHeader(header)}
+		switch s.Ragged {
+		case "truncate":
+			opts = append(opts, csv.WithRagged(csv.RaggedTruncate))
+		case "pad":
+			// Padded fields are filled with the running column mean, as the
+			// detectors do not accept NaN.
+			opts = append(opts, csv.WithRagged(csv.RaggedPad), csv.WithImputer(data.NewMeanImputer()))
+		default:
+			opts = append(opts, csv.WithRagged(csv.RaggedReject))
+		}
+		r, err := csv.NewReader(s.Path, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, r.Headers(), nil
+	}
+}
@@ -0,0 +1,67 @@
+package privacy
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Noise perturbs released aggregates with the Laplace mechanism: a value
+// whose change from adding or removing one record is at most its
+// sensitivity is released with noise of scale sensitivity/epsilon, which
+// makes it epsilon-differentially private. Releasing several values
+// spends epsilon on each, except for disjoint ones such as the buckets of
+// a histogram. Smaller epsilons hide records better and blur more. It is
+// safe for concurrent use.
+type Noise struct {
+	epsilon float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewNoise returns a Noise with privacy budget epsilon per released
+// value, seeded from the system's secure random source so the noise
+// cannot be predicted and subtracted.
+func NewNoise(epsilon float64) (*Noise, error) {
+	if !(epsilon > 0) || math.IsInf(epsilon, 0) {
+		return nil, fmt.Errorf("privacy: epsilon %g is not positive", epsilon)
+	}
+	var seed [8]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return &Noise{
+		epsilon: epsilon,
+		rng:     rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+	}, nil
+}
+
+// Epsilon returns the privacy budget per released value.
+func (n *Noise) Epsilon() float64 {
+	return n.epsilon
+}
+
+// Laplace returns a draw from the Laplace distribution of scale
+// sensitivity/epsilon.
+func (n *Noise) Laplace(sensitivity float64) float64 {
+	// The difference of two exponential draws is Laplace distributed.
+	n.mu.Lock()
+	d := n.rng.ExpFloat64() - n.rng.ExpFloat64()
+	n.mu.Unlock()
+	return d * sensitivity / n.epsilon
+}
+
+// Value returns v perturbed for the given sensitivity.
+func (n *Noise) Value(v, sensitivity float64) float64 {
+	return v + n.Laplace(sensitivity)
+}
+
+// Count returns a perturbed count, of sensitivity 1, rounded and clamped
+// to be non-negative.
+func (n *Noise) Count(c int) int {
+	return max(0, int(math.Round(n.Value(float64(c), 1))))
+}
@@ -0,0 +1,237 @@
+// Package privacy keeps identifiers and individual records out of what
+// goguardml stores and exports, for networks where results may not hold
+// raw addresses or user names.
+//
+// A Scrubber replaces identifying values before they reach features,
+// alert entities or result metadata: addresses are bucketed to their
+// network prefix, and other identifiers such as user and host names are
+// replaced with keyed pseudonyms. Pseudonyms are stable for a key, so
+// per-entity baselines, join keys and investigations across results keep
+// working, but cannot be reversed or recomputed without it.
+//
+// Noise adds Laplace noise for epsilon-differential privacy to the
+// aggregates of exported models and reports, such as record counts and
+// histograms, so they do not reveal whether any single record was in the
+// data.
+//
+// Typical use:
+//
+//	s, err := privacy.NewScrubber(key, privacy.WithFields("user", "host"))
+//	events = s.AuthEvents(ctx, events)
+//	w = s.Writer(w)
+package privacy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"slices"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// ErrNoKey is returned by NewScrubber for an empty key.
+var ErrNoKey = errors.New("privacy: scrubber key is empty")
+
+// PseudonymPrefix starts every pseudonym, so scrubbed values are told
+// apart from raw ones.
+const PseudonymPrefix = "anon-"
+
+// DefaultFields are the metadata fields scrubbed by default.
+var DefaultFields = []string{"user", "username", "host", "hostname", "entity", "ip", "src_ip", "dst_ip", "source", "client"}
+
+// Option configures a Scrubber.
+type Option func(*Scrubber)
+
+// WithFields sets the names of the metadata fields whose values are
+// scrubbed, DefaultFields by default. Addresses are bucketed wherever
+// they appear.
+func WithFields(names ...string) Option {
+	return func(s *Scrubber) {
+		s.fields = slices.Clone(names)
+	}
+}
+
+// WithAddrPrefix sets the prefix lengths addresses are bucketed to, 24
+// bits for IPv4 and 48 for IPv6 by default. Zero lengths replace
+// addresses with pseudonyms instead.
+func WithAddrPrefix(v4, v6 int) Option {
+	return func(s *Scrubber) {
+		s.v4Bits, s.v6Bits = v4, v6
+	}
+}
+
+// Scrubber replaces identifying values. It is safe for concurrent use.
+type Scrubber struct {
+	key    []byte
+	fields []string
+	v4Bits int
+	v6Bits int
+}
+
+// NewScrubber returns a Scrubber deriving pseudonyms from key, which must
+// be kept secret: anyone holding it can confirm a guessed identifier.
+func NewScrubber(key []byte, opts ...Option) (*Scrubber, error) {
+	if len(key) == 0 {
+		return nil, ErrNoKey
+	}
+	s := &Scrubber{
+		key:    slices.Clone(key),
+		fields: DefaultFields,
+		v4Bits: 24,
+		v6Bits: 48,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Pseudonym returns the pseudonym of id: PseudonymPrefix followed by 16
+// hex digits of its HMAC-SHA256 under the key. Empty ids stay empty, so
+// missing values remain missing.
+func (s *Scrubber) Pseudonym(id string) string {
+	if id == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(id))
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Addr returns a bucketed to its network prefix, such as 10.1.2.0 for
+// 10.1.2.3. Invalid addresses are returned unchanged.
+func (s *Scrubber) Addr(a netip.Addr) netip.Addr {
+	if !a.IsValid() {
+		return a
+	}
+	a = a.Unmap()
+	bits := s.v6Bits
+	if a.Is4() {
+		bits = s.v4Bits
+	}
+	p, err := a.Prefix(bits)
+	if err != nil {
+		return a
+	}
+	return p.Addr()
+}
+
+// Value returns the scrubbed form of an identifier: the bucketed address
+// if it is one, its pseudonym otherwise.
+func (s *Scrubber) Value(id string) string {
+	if a, err := netip.ParseAddr(id); err == nil {
+		if s.bucketing(a) {
+			return s.Addr(a).String()
+		}
+	}
+	return s.Pseudonym(id)
+}
+
+// bucketing reports whether addresses like a are bucketed rather than
+// pseudonymized.
+func (s *Scrubber) bucketing(a netip.Addr) bool {
+	if a.Unmap().Is4() {
+		return s.v4Bits > 0
+	}
+	return s.v6Bits > 0
+}
+
+// Metadata returns a copy of m with the values of the scrubbed fields,
+// and addresses in any field, scrubbed. Nested maps are scrubbed too;
+// non-string values of scrubbed fields are dropped.
+func (s *Scrubber) Metadata(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		scrub := slices.Contains(s.fields, k)
+		switch v := v.(type) {
+		case map[string]any:
+			out[k] = s.Metadata(v)
+		case string:
+			if _, err := netip.ParseAddr(v); scrub || err == nil {
+				out[k] = s.Value(v)
+			} else {
+				out[k] = v
+			}
+		default:
+			if !scrub {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// AuthEvent returns e with its user and host pseudonymized and its source
+// address bucketed, or cleared when addresses are pseudonymized.
+func (s *Scrubber) AuthEvent(e guardio.AuthEvent) guardio.AuthEvent {
+	e.User = s.Pseudonym(e.User)
+	e.Host = s.Pseudonym(e.Host)
+	if e.Source.IsValid() && !s.bucketing(e.Source) {
+		e.Source = netip.Addr{}
+	} else {
+		e.Source = s.Addr(e.Source)
+	}
+	return e
+}
+
+// AuthEvents scrubs the events from in, such as an authlog.Reader stream,
+// before they reach a profile. The returned channel is closed when in is
+// closed or ctx is done.
+func (s *Scrubber) AuthEvents(ctx context.Context, in <-chan guardio.AuthEvent) <-chan guardio.AuthEvent {
+	out := make(chan guardio.AuthEvent, cap(in))
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- s.AuthEvent(e):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Writer returns a guardio.Writer scrubbing the metadata of results
+// before writing them to w.
+func (s *Scrubber) Writer(w guardio.Writer) guardio.Writer {
+	return &writer{w: w, s: s}
+}
+
+type writer struct {
+	w guardio.Writer
+	s *Scrubber
+}
+
+func (w *writer) Write(result guardio.Result) error {
+	result.Metadata = w.s.Metadata(result.Metadata)
+	return w.w.Write(result)
+}
+
+func (w *writer) WriteAll(results []guardio.Result) error {
+	scrubbed := make([]guardio.Result, len(results))
+	for i, result := range results {
+		result.Metadata = w.s.Metadata(result.Metadata)
+		scrubbed[i] = result
+	}
+	return w.w.WriteAll(scrubbed)
+}
+
+func (w *writer) Close() error {
+	return w.w.Close()
+}
@@ -0,0 +1,151 @@
+package privacy
+
+import (
+	"context"
+	"math"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func TestScrubber(t *testing.T) {
+	_, err := NewScrubber(nil)
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	s, err := NewScrubber([]byte("secret"))
+	require.NoError(t, err)
+	other, err := NewScrubber([]byte("other"))
+	require.NoError(t, err)
+
+	p := s.Pseudonym("alice")
+	assert.True(t, strings.HasPrefix(p, PseudonymPrefix))
+	assert.Len(t, p, len(PseudonymPrefix)+16)
+	assert.Equal(t, p, s.Pseudonym("alice"), "stable for a key")
+	assert.NotEqual(t, p, s.Pseudonym("bob"))
+	assert.NotEqual(t, p, other.Pseudonym("alice"))
+	assert.Empty(t, s.Pseudonym(""))
+
+	assert.Equal(t, "10.1.2.0", s.Value("10.1.2.3"))
+	assert.Equal(t, "10.1.2.0", s.Value("::ffff:10.1.2.3"))
+	assert.Equal(t, "2001:db8:1::", s.Value("2001:db8:1:2::5"))
+	assert.Equal(t, p, s.Value("alice"))
+	assert.Equal(t, netip.Addr{}, s.Addr(netip.Addr{}))
+
+	hashing, err := NewScrubber([]byte("secret"), WithAddrPrefix(0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, s.Pseudonym("10.1.2.3"), hashing.Value("10.1.2.3"))
+}
+
+func TestScrubberMetadata(t *testing.T) {
+	s, err := NewScrubber([]byte("secret"), WithFields("user", "uid"))
+	require.NoError(t, err)
+
+	m := map[string]any{
+		"user":   "alice",
+		"uid":    1001.0,
+		"peer":   "192.168.7.9",
+		"route":  "edge",
+		"nested": map[string]any{"user": "bob", "count": 3},
+	}
+	got := s.Metadata(m)
+	assert.Equal(t, map[string]any{
+		"user":   s.Pseudonym("alice"),
+		"peer":   "192.168.7.0",
+		"route":  "edge",
+		"nested": map[string]any{"user": s.Pseudonym("bob"), "count": 3},
+	}, got)
+	assert.Equal(t, "alice", m["user"], "the input is not modified")
+	assert.Nil(t, s.Metadata(nil))
+}
+
+func TestScrubberAuthEvents(t *testing.T) {
+	s, err := NewScrubber([]byte("secret"))
+	require.NoError(t, err)
+
+	in := make(chan guardio.AuthEvent, 2)
+	in <- guardio.AuthEvent{User: "root", Host: "web1", Source: netip.MustParseAddr("203.0.113.77"), Success: true}
+	in <- guardio.AuthEvent{User: "root", Service: "su"}
+	close(in)
+
+	var got []guardio.AuthEvent
+	for e := range s.AuthEvents(context.Background(), in) {
+		got = append(got, e)
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, guardio.AuthEvent{
+		User: s.Pseudonym("root"), Host: s.Pseudonym("web1"),
+		Source: netip.MustParseAddr("203.0.113.0"), Success: true,
+	}, got[0])
+	assert.Equal(t, s.Pseudonym("root"), got[1].User)
+	assert.False(t, got[1].Source.IsValid())
+
+	hashing, err := NewScrubber([]byte("secret"), WithAddrPrefix(0, 48))
+	require.NoError(t, err)
+	e := hashing.AuthEvent(guardio.AuthEvent{Source: netip.MustParseAddr("203.0.113.77")})
+	assert.False(t, e.Source.IsValid(), "addresses that cannot be bucketed are dropped")
+}
+
+type recordWriter struct {
+	results []guardio.Result
+}
+
+func (w *recordWriter) Write(r guardio.Result) error {
+	w.results = append(w.results, r)
+	return nil
+}
+
+func (w *recordWriter) WriteAll(rs []guardio.Result) error {
+	w.results = append(w.results, rs...)
+	return nil
+}
+
+func (w *recordWriter) Close() error { return nil }
+
+func TestScrubberWriter(t *testing.T) {
+	s, err := NewScrubber([]byte("secret"))
+	require.NoError(t, err)
+	rec := &recordWriter{}
+	w := s.Writer(rec)
+
+	results := []guardio.Result{{Score: 0.9, Metadata: map[string]any{"entity": "alice"}}}
+	require.NoError(t, w.WriteAll(results))
+	require.NoError(t, w.Write(guardio.Result{Score: 0.1}))
+	require.NoError(t, w.Close())
+
+	require.Len(t, rec.results, 2)
+	assert.Equal(t, s.Pseudonym("alice"), rec.results[0].Metadata["entity"])
+	assert.Equal(t, "alice", results[0].Metadata["entity"])
+	assert.Nil(t, rec.results[1].Metadata)
+}
+
+func TestNoise(t *testing.T) {
+	for _, eps := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		_, err := NewNoise(eps)
+		assert.Error(t, err, "epsilon %g", eps)
+	}
+
+	n, err := NewNoise(0.5)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, n.Epsilon())
+
+	// The Laplace distribution of scale b has mean 0 and mean absolute
+	// deviation b.
+	const draws = 20000
+	var sum, abs float64
+	for range draws {
+		v := n.Laplace(1)
+		sum += v
+		abs += math.Abs(v)
+	}
+	assert.InDelta(t, 0, sum/draws, 0.1)
+	assert.InDelta(t, 2, abs/draws, 0.1)
+
+	for range 100 {
+		assert.GreaterOrEqual(t, n.Count(0), 0)
+	}
+}
@@ -0,0 +1,458 @@
+// Package beacon detects command-and-control beaconing: malware that calls
+// home at regular intervals with small, similar requests. It follows every
+// conversation from a source to a destination port over hours. A packet
+// after a silence of at least the session gap opens a new session, and
+// each session is one callback. Once a conversation has enough sessions,
+// each new one is scored on the median interval between them, their
+// jitter (coefficient of variation of the intervals), their periodicity
+// (share of intervals close to the median) and the mean and coefficient of
+// variation of the bytes sent per session.
+//
+// The detector is trained on all conversations of the baseline, but only
+// those whose periodicity reaches a minimum can raise an alert, and the
+// threshold is calibrated on the periodic conversations of the baseline:
+// a regular conversation is reported when it is more unusual than any of
+// the legitimate periodic traffic of the network, such as update checks
+// and NTP. Intervals and sizes are scored on a log scale.
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "beacon"
+
+// thresholdMargin is added to the highest score of a periodic baseline
+// session to set the threshold, leaving room for sessions that score a
+// little higher than any seen in training.
+const thresholdMargin = 0.02
+
+// FeatureNames names the features scored, in vector order. Intervals are
+// in seconds, sizes in bytes. Alerts also report the number of sessions
+// considered as "sessions".
+var FeatureNames = []string{"interval_median", "interval_cv", "periodicity", "size_mean", "size_cv"}
+
+// Profile detects beaconing conversations. It is safe for concurrent use.
+type Profile struct {
+	gap            time.Duration
+	history        int
+	minSessions    int
+	minPeriodicity float64
+	tolerance      float64
+	horizon        time.Duration
+	cooldown       time.Duration
+	maxPairs       int
+	detector       detectors.Detector
+
+	mu        sync.Mutex
+	trained   bool
+	pairs     map[pair]*conversation
+	lastSweep time.Time
+}
+
+// pair identifies a conversation.
+type pair struct {
+	src, dst netip.Addr
+	port     uint16
+	proto    uint8
+}
+
+func (k pair) String() string {
+	return fmt.Sprintf("%s->%s", k.src, netip.AddrPortFrom(k.dst, k.port))
+}
+
+// conversation is the recent history of one pair: the start time and the
+// bytes sent of its last sessions, oldest first.
+type conversation struct {
+	starts  []time.Time
+	sizes   []float64
+	last    time.Time
+	alerted time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithSessionGap sets the silence after which a packet opens a new
+// session. Defaults to 5 seconds.
+func WithSessionGap(d time.Duration) Option {
+	return func(p *Profile) {
+		p.gap = d
+	}
+}
+
+// WithHistory sets how many recent sessions of a conversation are kept and
+// scored. Defaults to 32.
+func WithHistory(n int) Option {
+	return func(p *Profile) {
+		p.history = n
+	}
+}
+
+// WithMinSessions sets how many sessions a conversation needs before it is
+// scored. Defaults to 8.
+func WithMinSessions(n int) Option {
+	return func(p *Profile) {
+		p.minSessions = n
+	}
+}
+
+// WithMinPeriodicity sets the share of intervals within the tolerance of
+// the median a conversation needs to raise an alert. Defaults to 0.8.
+func WithMinPeriodicity(f float64) Option {
+	return func(p *Profile) {
+		p.minPeriodicity = f
+	}
+}
+
+// WithTolerance sets how far, relative to the median, an interval may be
+// from the median to count as periodic. Defaults to 0.1, or 10%.
+func WithTolerance(f float64) Option {
+	return func(p *Profile) {
+		p.tolerance = f
+	}
+}
+
+// WithHorizon sets how long an idle conversation is remembered. Defaults
+// to 24 hours; beacons calling home less often need a longer horizon.
+func WithHorizon(d time.Duration) Option {
+	return func(p *Profile) {
+		p.horizon = d
+	}
+}
+
+// WithCooldown sets how long a conversation that raised an alert stays
+// quiet before it can raise another. Defaults to 6 hours.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMaxPairs bounds the number of conversations tracked at once. When a
+// new one would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxPairs(n int) Option {
+	return func(p *Profile) {
+		p.maxPairs = n
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		gap:            5 * time.Second,
+		history:        32,
+		minSessions:    8,
+		minPeriodicity: 0.8,
+		tolerance:      0.1,
+		horizon:        24 * time.Hour,
+		cooldown:       6 * time.Hour,
+		maxPairs:       100000,
+		pairs:          make(map[pair]*conversation),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			iforest.WithContamination(0.01),
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.minSessions = max(p.minSessions, 3)
+	p.history = max(p.history, p.minSessions)
+	p.maxPairs = max(p.maxPairs, 1)
+	return p
+}
+
+// Fit trains the detector on the conversations of packets, a baseline of
+// normal traffic in capture order spanning hours.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var all, periodic [][]float64
+	for _, pkt := range packets {
+		if features, _, ok := p.track(pkt); ok {
+			all = append(all, scaled(features))
+			if features[2] >= p.minPeriodicity {
+				periodic = append(periodic, all[len(all)-1])
+			}
+		}
+	}
+	p.pairs = make(map[pair]*conversation)
+	p.lastSweep = time.Time{}
+
+	if len(all) < 2 {
+		return fmt.Errorf("beacon: baseline has %d scored sessions, need at least 2 (a conversation is scored from its session %d on)", len(all), p.minSessions)
+	}
+	if err := p.detector.Fit(all); err != nil {
+		return fmt.Errorf("beacon: %w", err)
+	}
+	return p.calibrate(periodic)
+}
+
+// calibrate sets the threshold of detectors that implement
+// detectors.Thresholder just above the highest score of a periodic
+// baseline session. Only periodic conversations are scored during
+// detection, and a threshold taken from the contamination of all
+// sessions would be set by the irregular ones.
+func (p *Profile) calibrate(periodic [][]float64) error {
+	t, ok := p.detector.(detectors.Thresholder)
+	if !ok || len(periodic) == 0 {
+		p.trained = true
+		return nil
+	}
+	scores, err := p.detector.Predict(periodic)
+	if err != nil {
+		return fmt.Errorf("beacon: %w", err)
+	}
+	t.SetThreshold(slices.Max(scores) + thresholdMargin)
+	p.trained = true
+	return nil
+}
+
+// Observe tracks pkt and returns an alert if it opens a session of a
+// beaconing conversation.
+func (p *Profile) Observe(pkt guardio.Packet) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, k, ok := p.track(pkt)
+	if !ok || features[2] < p.minPeriodicity {
+		return profiles.Alert{}, false, nil
+	}
+	c := p.pairs[k]
+	if !c.alerted.IsZero() && pkt.Time.Sub(c.alerted) < p.cooldown {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(scaled(features))
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("beacon: %w", err)
+	}
+	if score < detectors.ThresholdOf(p.detector) {
+		return profiles.Alert{}, false, nil
+	}
+	c.alerted = pkt.Time
+
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   k.String(),
+		Start:    c.starts[0],
+		Time:     pkt.Time,
+		Score:    score,
+		Features: map[string]float64{"sessions": float64(len(c.starts))},
+		Message: fmt.Sprintf("%s calls %s every %s (jitter %.0f%%, %.0f bytes per call)",
+			k.src, netip.AddrPortFrom(k.dst, k.port),
+			time.Duration(features[0]*float64(time.Second)).Round(time.Second), 100*features[1], features[3]),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("beacon: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Pairs returns the number of conversations currently tracked.
+func (p *Profile) Pairs() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pairs)
+}
+
+// track records pkt and, if it opens a session of a conversation with
+// enough history, returns the conversation's features. The caller holds
+// p.mu.
+func (p *Profile) track(pkt guardio.Packet) ([]float64, pair, bool) {
+	if !pkt.Src.IsValid() || !pkt.Dst.IsValid() {
+		return nil, pair{}, false
+	}
+	p.sweep(pkt.Time)
+
+	k := pair{src: pkt.Src, dst: pkt.Dst, port: pkt.DstPort, proto: pkt.Protocol}
+	c := p.pairs[k]
+	if c == nil {
+		c = p.newConversation(k)
+	}
+
+	opens := c.last.IsZero() || pkt.Time.Sub(c.last) >= p.gap
+	c.last = pkt.Time
+	if !opens {
+		c.sizes[len(c.sizes)-1] += float64(pkt.Length)
+		return nil, pair{}, false
+	}
+
+	if len(c.starts) == p.history {
+		c.starts = append(c.starts[:0], c.starts[1:]...)
+		c.sizes = append(c.sizes[:0], c.sizes[1:]...)
+	}
+	c.starts = append(c.starts, pkt.Time)
+	c.sizes = append(c.sizes, float64(pkt.Length))
+	if len(c.starts) < p.minSessions {
+		return nil, pair{}, false
+	}
+	return p.features(c), k, true
+}
+
+// features returns the features of c. The size of the session just opened
+// is not known yet, so only earlier sessions count towards the sizes.
+func (p *Profile) features(c *conversation) []float64 {
+	intervals := make([]float64, len(c.starts)-1)
+	for i := range intervals {
+		intervals[i] = c.starts[i+1].Sub(c.starts[i]).Seconds()
+	}
+	mean, sd := meanStdDev(intervals)
+	median := medianOf(intervals)
+
+	periodic := 0
+	for _, v := range intervals {
+		if math.Abs(v-median) <= p.tolerance*median {
+			periodic++
+		}
+	}
+
+	sizeMean, sizeSD := meanStdDev(c.sizes[:len(c.sizes)-1])
+	return []float64{
+		median,
+		ratio(sd, mean),
+		float64(periodic) / float64(len(intervals)),
+		sizeMean,
+		ratio(sizeSD, sizeMean),
+	}
+}
+
+// newConversation starts tracking k, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) newConversation(k pair) *conversation {
+	if len(p.pairs) >= p.maxPairs {
+		var oldest pair
+		var oldestTime time.Time
+		first := true
+		for key, c := range p.pairs {
+			if first || c.last.Before(oldestTime) {
+				oldest, oldestTime, first = key, c.last, false
+			}
+		}
+		delete(p.pairs, oldest)
+	}
+	c := &conversation{
+		starts: make([]time.Time, 0, p.history),
+		sizes:  make([]float64, 0, p.history),
+	}
+	p.pairs[k] = c
+	return c
+}
+
+// sweep forgets conversations idle for longer than the horizon, at most
+// once per hour of capture time. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < time.Hour {
+		return
+	}
+	p.lastSweep = now
+	for k, c := range p.pairs {
+		if now.Sub(c.last) > p.horizon {
+			delete(p.pairs, k)
+		}
+	}
+}
+
+// scaled returns features with the median interval and mean size on a log
+// scale, so the detector reacts to relative differences, and the interval
+// jitter rounded to hundredths, so the clock noise of otherwise identical
+// periodic conversations does not set them apart. Features is left
+// untouched.
+func scaled(features []float64) []float64 {
+	out := slices.Clone(features)
+	out[0] = math.Log1p(out[0])
+	out[1] = math.Round(out[1]*100) / 100
+	out[3] = math.Log1p(out[3])
+	return out
+}
+
+func meanStdDev(values []float64) (mean, sd float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		sd += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sd / float64(len(values)))
+}
+
+func medianOf(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// ratio returns a/b, or 0 if b is 0.
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
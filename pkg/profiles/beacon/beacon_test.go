@@ -0,0 +1,189 @@
+package beacon
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start    = time.Unix(1700000000, 0)
+	infected = netip.AddrFrom4([4]byte{10, 0, 0, 66})
+	c2       = netip.AddrFrom4([4]byte{203, 0, 113, 5})
+)
+
+func client(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + i)})
+}
+
+// session returns the packets of one callback: a request of size bytes
+// split into a few packets within a second.
+func session(t time.Time, src, dst netip.Addr, port uint16, size int) []guardio.Packet {
+	var packets []guardio.Packet
+	for sent := 0; sent < size; sent += 500 {
+		packets = append(packets, guardio.Packet{
+			Time: t.Add(time.Duration(sent) * time.Millisecond / 10), Src: src, Dst: dst,
+			DstPort: port, Protocol: guardio.ProtoTCP, Length: min(500, size-sent),
+		})
+	}
+	return packets
+}
+
+// network returns d of traffic from 20 clients: irregular browsing of a
+// few servers, and NTP queries every 64 seconds.
+func network(rng *rand.Rand, from time.Time, d time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	servers := []netip.Addr{netip.AddrFrom4([4]byte{10, 1, 0, 1}), netip.AddrFrom4([4]byte{10, 1, 0, 2})}
+	for c := 0; c < 20; c++ {
+		src := client(c)
+		for t := from.Add(time.Duration(rng.Intn(60)) * time.Second); t.Before(from.Add(d)); t = t.Add(time.Duration(rng.ExpFloat64()*120) * time.Second) {
+			packets = append(packets, session(t, src, servers[rng.Intn(2)], 443, 300+rng.Intn(20000))...)
+		}
+		for t := from.Add(time.Duration(rng.Intn(64)) * time.Second); t.Before(from.Add(d)); t = t.Add(64*time.Second + time.Duration(rng.Intn(500))*time.Millisecond) {
+			packets = append(packets, guardio.Packet{Time: t, Src: src, Dst: netip.AddrFrom4([4]byte{10, 1, 0, 123}), DstPort: 123, Protocol: guardio.ProtoUDP, Length: 90})
+		}
+	}
+	sortByTime(packets)
+	return packets
+}
+
+// beacon returns callbacks from infected to c2 every period, with up to
+// jitter of random delay.
+func beacon(rng *rand.Rand, from time.Time, d, period, jitter time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	for t := from; t.Before(from.Add(d)); t = t.Add(period + time.Duration(rng.Int63n(int64(jitter)))) {
+		packets = append(packets, session(t, infected, c2, 443, 700)...)
+	}
+	return packets
+}
+
+func sortByTime(packets []guardio.Packet) {
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(network(rand.New(rand.NewSource(1)), start, 6*time.Hour)))
+	assert.Zero(t, p.Pairs(), "training state is not carried into detection")
+	return p
+}
+
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		alert, raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestBeacon(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(2))
+	live := start.Add(24 * time.Hour)
+
+	packets := append(network(rng, live, 4*time.Hour), beacon(rng, live, 4*time.Hour, 5*time.Minute, 3*time.Second)...)
+	sortByTime(packets)
+	alerts := observeAll(t, p, packets)
+
+	require.Len(t, alerts, 1, "one alert within the cooldown")
+	a := alerts[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, "10.0.0.66->203.0.113.5:443", a.Entity)
+	assert.Equal(t, live, a.Start)
+	assert.InDelta(t, 300, a.Features["interval_median"], 3)
+	assert.Less(t, a.Features["interval_cv"], 0.01)
+	assert.Equal(t, 1.0, a.Features["periodicity"])
+	assert.Equal(t, 700.0, a.Features["size_mean"])
+	assert.Zero(t, a.Features["size_cv"])
+	assert.Contains(t, a.Message, "every 5m")
+}
+
+func TestIrregularConversations(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(3))
+	live := start.Add(24 * time.Hour)
+
+	// A host polling the C2 server at random times is not beaconing.
+	var packets []guardio.Packet
+	for tm := live; tm.Before(live.Add(4 * time.Hour)); tm = tm.Add(time.Duration(60+rng.Intn(600)) * time.Second) {
+		packets = append(packets, session(tm, infected, c2, 443, 700)...)
+	}
+	packets = append(packets, network(rng, live, 4*time.Hour)...)
+	sortByTime(packets)
+	assert.Empty(t, observeAll(t, p, packets))
+}
+
+func TestSessions(t *testing.T) {
+	p := New(WithMinSessions(3), WithHistory(4))
+	src, dst := client(0), netip.AddrFrom4([4]byte{10, 1, 0, 1})
+	var packets []guardio.Packet
+	for i := 0; i < 6; i++ {
+		packets = append(packets, session(start.Add(time.Duration(i)*time.Minute), src, dst, 443, 1000*(i+1))...)
+	}
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		if features, _, ok := p.track(pkt); ok {
+			vectors = append(vectors, features)
+		}
+	}
+	require.Len(t, vectors, 4, "sessions 3 to 6 are scored")
+	last := vectors[3]
+	assert.Equal(t, 60.0, last[0])
+	assert.Zero(t, last[1])
+	assert.Equal(t, 1.0, last[2])
+	assert.Equal(t, 4000.0, last[3], "history keeps sessions 3 to 6, of which 3 to 5 sent 3, 4 and 5 kB")
+}
+
+func TestMaxPairs(t *testing.T) {
+	p := New(WithMaxPairs(3))
+	for i := 0; i < 10; i++ {
+		p.track(guardio.Packet{Time: start, Src: client(i), Dst: c2, DstPort: 443})
+	}
+	assert.Equal(t, 3, p.Pairs())
+
+	p.track(guardio.Packet{Time: start.Add(48 * time.Hour), Src: infected, Dst: c2, DstPort: 443})
+	assert.Equal(t, 1, p.Pairs(), "idle conversations beyond the horizon are forgotten")
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(guardio.Packet{Time: start, Src: infected, Dst: c2})
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	assert.Error(t, p.Fit(session(start, infected, c2, 443, 700)), "too little baseline")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	packets := beacon(rand.New(rand.NewSource(4)), start, 2*time.Hour, 5*time.Minute, time.Second)
+	in := make(chan guardio.Packet, len(packets))
+	for _, pkt := range packets {
+		in <- pkt
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, "10.0.0.66->203.0.113.5:443", (<-out).Entity)
+}
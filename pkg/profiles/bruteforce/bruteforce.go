@@ -0,0 +1,571 @@
+// Package bruteforce detects password guessing in authentication logs:
+// brute force against one account, password spraying across many, and
+// distributed attacks on one account from many sources. It tracks, in a
+// sliding window, the failed logins of every remote source and of every
+// user name, and scores a failure from the point of view of one of them:
+// the number of failures, the distinct peers involved (the users a source
+// failed on, or the sources that failed on a user), the mean and
+// coefficient of variation of the intervals between the failures (tools
+// guess at a steady pace) and the share of failures on accounts that do
+// not exist. Both points of view share one detector.
+//
+// A failure is scored from its source's point of view once the source
+// reached the minimum failures within the window, and otherwise from its
+// user's point of view once the user has, so a distributed attack is
+// reported on the user it targets. Failures without a source, such as
+// local su attempts, only count towards their user. A source reported for
+// guessing that then logs in successfully is reported again at once,
+// cooldown or not: the guess worked.
+//
+// Observe scores each attempt as it comes. Run feeds a stream of attempts
+// to the detector's PredictStream, so scoring runs alongside tracking.
+//
+// Typical use:
+//
+//	p := bruteforce.New()
+//	if err := p.Fit(baseline); err != nil { ... }
+//	events, _ := authlog.NewReader(os.Stdin).Stream(ctx) // tail -F auth.log
+//	go p.Run(ctx, events, alerts)
+package bruteforce
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "bruteforce"
+
+// FeatureNames names the features scored, in vector order. Peers are the
+// users of a source or the sources of a user; intervals are in seconds.
+var FeatureNames = []string{"failures", "peers", "interval_mean", "interval_cv", "invalid_ratio"}
+
+// Profile detects password guessing. It is safe for concurrent use.
+type Profile struct {
+	window      time.Duration
+	cooldown    time.Duration
+	minFailures int
+	maxSources  int
+	maxUsers    int
+	detector    detectors.StreamDetector
+
+	mu        sync.Mutex
+	trained   bool
+	sources   map[netip.Addr]*entity
+	users     map[string]*entity
+	lastSweep time.Time
+}
+
+// entity is the recent failed logins of one source or user name.
+type entity struct {
+	failures  *profiles.SumWindow // one value per failure, 1 on a nonexistent account
+	peers     *profiles.DistinctWindow[string]
+	intervals *profiles.SumWindow // between failures
+	squares   *profiles.SumWindow // squared intervals
+	first     time.Time           // first failure of the current burst
+	failed    time.Time           // last failure
+	last      time.Time           // last attempt
+	alerted   time.Time
+	breached  time.Time // successful login reported after the last alert
+}
+
+// candidate is an attempt that may raise an alert, once scored.
+type candidate struct {
+	event    guardio.AuthEvent
+	features []float64
+	entity   *entity
+	bySource bool
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWindow sets the sliding window over which failures are counted.
+// Defaults to ten minutes; slow guessing needs a longer window.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithCooldown sets how long a source or user that raised an alert stays
+// quiet before it can raise another. Defaults to 30 minutes.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMinFailures sets how many failures a source or user must see within
+// the window before it can raise an alert, however unusual its score.
+// Defaults to 5.
+func WithMinFailures(n int) Option {
+	return func(p *Profile) {
+		p.minFailures = n
+	}
+}
+
+// WithMaxSources bounds the number of sources tracked at once. When a new
+// source would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxSources(n int) Option {
+	return func(p *Profile) {
+		p.maxSources = n
+	}
+}
+
+// WithMaxUsers bounds the number of user names tracked at once, like
+// WithMaxSources. Defaults to 100000.
+func WithMaxUsers(n int) Option {
+	return func(p *Profile) {
+		p.maxUsers = n
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.StreamDetector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		window:      10 * time.Minute,
+		cooldown:    30 * time.Minute,
+		minFailures: 5,
+		maxSources:  100000,
+		maxUsers:    100000,
+		sources:     make(map[netip.Addr]*entity),
+		users:       make(map[string]*entity),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// The minimum failures keep ordinary typos quiet; flag the
+			// rarest 1% of the baseline's failure patterns.
+			iforest.WithContamination(0.01),
+			// Baselines often lack failures on nonexistent accounts.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.window = max(p.window, time.Second)
+	p.minFailures = max(p.minFailures, 1)
+	p.maxSources = max(p.maxSources, 1)
+	p.maxUsers = max(p.maxUsers, 1)
+	return p
+}
+
+// Fit trains the detector on the failed logins of events, a baseline of
+// normal logins in time order, seen from both their source and their
+// user.
+func (p *Profile) Fit(events []guardio.AuthEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, e := range events {
+		src, usr := p.track(e)
+		if e.Success {
+			continue
+		}
+		if src != nil {
+			vectors = append(vectors, p.features(src))
+		}
+		vectors = append(vectors, p.features(usr))
+	}
+	p.sources = make(map[netip.Addr]*entity)
+	p.users = make(map[string]*entity)
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("bruteforce: baseline has %d failure patterns, need at least 2", len(vectors))
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("bruteforce: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks e and returns an alert if it reveals password guessing.
+func (p *Profile) Observe(e guardio.AuthEvent) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	c, ok := p.candidate(e)
+	if !ok {
+		return profiles.Alert{}, false, nil
+	}
+	score, err := p.detector.PredictOne(c.features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("bruteforce: %w", err)
+	}
+	alert, raised := p.decide(c, score, score >= detectors.ThresholdOf(p.detector))
+	return alert, raised, nil
+}
+
+// Run observes the events from in and sends alerts to out until in is
+// closed or ctx is done. Attempts are scored by the detector's
+// PredictStream, in order, while later ones are being tracked. It leaves
+// out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.AuthEvent, out chan<- profiles.Alert) error {
+	p.mu.Lock()
+	trained := p.trained
+	p.mu.Unlock()
+	if !trained {
+		return profiles.ErrNotTrained
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	vectors := make(chan []float64, 100)
+	scores := make(chan detectors.Score, 100)
+	pending := &queue{}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.detector.PredictStream(ctx, vectors, scores)
+	}()
+	go func() {
+		defer close(vectors)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				p.mu.Lock()
+				c, ok := p.candidate(e)
+				p.mu.Unlock()
+				if !ok {
+					continue
+				}
+				pending.push(c)
+				select {
+				case vectors <- c.features:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	for s := range scores {
+		c, ok := pending.match(s.Features)
+		if !ok {
+			continue
+		}
+		p.mu.Lock()
+		alert, raised := p.decide(c, s.Value, s.IsAnomaly)
+		p.mu.Unlock()
+		if !raised {
+			continue
+		}
+		select {
+		case out <- alert:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := <-done
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("bruteforce: %w", err)
+	}
+	return nil
+}
+
+// Save serializes the trained detector.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("bruteforce: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Sources returns the number of sources currently tracked.
+func (p *Profile) Sources() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sources)
+}
+
+// Users returns the number of user names currently tracked.
+func (p *Profile) Users() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.users)
+}
+
+// candidate tracks e and returns it for scoring if it may raise an alert:
+// the successful login of a source that reached the minimum failures or
+// was reported, or a failure whose source, or else user, reached the
+// minimum failures and is not in its cooldown.
+// The caller holds p.mu.
+func (p *Profile) candidate(e guardio.AuthEvent) (candidate, bool) {
+	src, usr := p.track(e)
+	if e.Success {
+		// Whether the source was reported is only known once its failures
+		// are scored, so decide checks for a breach.
+		if src == nil || (src.failures.Count() < p.minFailures && !p.cooling(src.alerted, e.Time)) {
+			return candidate{}, false
+		}
+		return candidate{event: e, features: p.features(src), entity: src, bySource: true}, true
+	}
+
+	c := candidate{event: e, entity: usr}
+	if src != nil && src.failures.Count() >= p.minFailures {
+		c.entity, c.bySource = src, true
+	} else if usr.failures.Count() < p.minFailures {
+		return candidate{}, false
+	}
+	if p.cooling(c.entity.alerted, e.Time) {
+		return candidate{}, false
+	}
+	c.features = p.features(c.entity)
+	return c, true
+}
+
+// decide returns the alert raised by c, scored score, if any. The caller
+// holds p.mu.
+func (p *Profile) decide(c candidate, score float64, anomalous bool) (profiles.Alert, bool) {
+	e, f, ent := c.event, c.features, c.entity
+	if e.Success {
+		if !p.cooling(ent.alerted, e.Time) || !ent.breached.Before(ent.alerted) {
+			return profiles.Alert{}, false
+		}
+	} else if !anomalous || p.cooling(ent.alerted, e.Time) {
+		return profiles.Alert{}, false
+	}
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   e.User,
+		Start:    ent.first,
+		Time:     e.Time,
+		Score:    score,
+		Features: make(map[string]float64, len(FeatureNames)),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = f[i]
+	}
+
+	elapsed := e.Time.Sub(ent.first).Round(time.Second)
+	switch {
+	case e.Success:
+		ent.breached = e.Time
+		alert.Entity = e.Source.String()
+		alert.Message = fmt.Sprintf("%s logged in as %s after %d failed logins on %d users",
+			e.Source, e.User, int(f[0]), int(f[1]))
+	case c.bySource:
+		ent.alerted = e.Time
+		alert.Entity = e.Source.String()
+		alert.Message = fmt.Sprintf("%s failed %d logins on %d users in %s", e.Source, int(f[0]), int(f[1]), elapsed)
+	default:
+		ent.alerted = e.Time
+		alert.Message = fmt.Sprintf("logins as %s failed %d times from %d sources in %s", e.User, int(f[0]), int(f[1]), elapsed)
+	}
+	return alert, true
+}
+
+// cooling reports whether an alert raised at alerted is within the
+// cooldown at now.
+func (p *Profile) cooling(alerted, now time.Time) bool {
+	return !alerted.IsZero() && now.Sub(alerted) < p.cooldown
+}
+
+// track records e against its user and, if it has one, its source, and
+// returns them; the source is nil for attempts without one. The caller
+// holds p.mu.
+func (p *Profile) track(e guardio.AuthEvent) (src, usr *entity) {
+	p.sweep(e.Time)
+
+	usr = p.users[e.User]
+	if usr == nil {
+		usr = newEntity(p, p.users, e.User, p.maxUsers)
+	}
+	if e.Source.IsValid() {
+		src = p.sources[e.Source]
+		if src == nil {
+			src = newEntity(p, p.sources, e.Source, p.maxSources)
+		}
+	}
+
+	if e.Success {
+		usr.last = e.Time
+		usr.expire(e.Time)
+		if src != nil {
+			src.last = e.Time
+			src.expire(e.Time)
+		}
+		return src, usr
+	}
+	var peer string
+	if src != nil {
+		peer = e.Source.String()
+		src.fail(e, e.User, p.window)
+	}
+	usr.fail(e, peer, p.window)
+	return src, usr
+}
+
+// features returns the feature vector of ent.
+func (p *Profile) features(ent *entity) []float64 {
+	failures := float64(ent.failures.Count())
+	// Until there are intervals to measure, failures are as far apart as
+	// the window and as irregular as random arrivals.
+	mean, cv := p.window.Seconds(), 1.0
+	n := float64(ent.intervals.Count())
+	if n > 0 {
+		mean = ent.intervals.Sum() / n
+	}
+	if n > 1 && mean > 0 {
+		cv = math.Sqrt(max(ent.squares.Sum()/n-mean*mean, 0)) / mean
+	}
+	var invalidRatio float64
+	if failures > 0 {
+		invalidRatio = ent.failures.Sum() / failures
+	}
+	return []float64{failures, float64(ent.peers.Len()), mean, cv, invalidRatio}
+}
+
+// fail records the failed login e involving peer; an empty peer is not
+// counted.
+func (ent *entity) fail(e guardio.AuthEvent, peer string, window time.Duration) {
+	if gap := e.Time.Sub(ent.failed); gap > window {
+		ent.first = e.Time
+	} else {
+		ent.intervals.Add(e.Time, gap.Seconds())
+		ent.squares.Add(e.Time, gap.Seconds()*gap.Seconds())
+	}
+	ent.failed, ent.last = e.Time, e.Time
+
+	var invalid float64
+	if e.InvalidUser {
+		invalid = 1
+	}
+	ent.failures.Add(e.Time, invalid)
+	if peer != "" {
+		ent.peers.Add(e.Time, peer)
+	}
+	ent.expire(e.Time)
+}
+
+// expire drops the failures of ent older than the window at now.
+func (ent *entity) expire(now time.Time) {
+	ent.failures.Expire(now)
+	ent.peers.Expire(now)
+	ent.intervals.Expire(now)
+	ent.squares.Expire(now)
+}
+
+// newEntity starts tracking key in m, first making room if limit is
+// reached. The caller holds p.mu.
+func newEntity[K comparable](p *Profile, m map[K]*entity, key K, limit int) *entity {
+	if len(m) >= limit {
+		var oldest K
+		var oldestTime time.Time
+		first := true
+		for k, ent := range m {
+			if first || ent.last.Before(oldestTime) {
+				oldest, oldestTime, first = k, ent.last, false
+			}
+		}
+		delete(m, oldest)
+	}
+	ent := &entity{
+		failures:  profiles.NewSumWindow(p.window),
+		peers:     profiles.NewDistinctWindow[string](p.window),
+		intervals: profiles.NewSumWindow(p.window),
+		squares:   profiles.NewSumWindow(p.window),
+	}
+	m[key] = ent
+	return ent
+}
+
+// sweep forgets sources and users idle for longer than the window and past
+// their cooldown, at most once per window. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	forget(p, p.sources, now)
+	forget(p, p.users, now)
+}
+
+// forget deletes the idle entities of m for sweep.
+func forget[K comparable](p *Profile, m map[K]*entity, now time.Time) {
+	for k, ent := range m {
+		if now.Sub(ent.last) > p.window && !p.cooling(ent.alerted, now) {
+			delete(m, k)
+		}
+	}
+}
+
+// queue holds the candidates sent to PredictStream until their scores
+// come back. Stream detectors emit scores in input order and keep the
+// input slice as Score.Features, which is what match relies on.
+type queue struct {
+	mu      sync.Mutex
+	pending []candidate
+}
+
+func (q *queue) push(c candidate) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, c)
+}
+
+// match returns the candidate whose features are the given slice, dropping
+// older candidates the detector rejected.
+func (q *queue) match(features []float64) (candidate, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, c := range q.pending {
+		if len(c.features) == len(features) && len(features) > 0 && &c.features[0] == &features[0] {
+			clear(q.pending[:i+1])
+			q.pending = q.pending[i+1:]
+			return c, true
+		}
+	}
+	return candidate{}, false
+}
@@ -0,0 +1,229 @@
+package bruteforce
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start    = time.Unix(1700000000, 0)
+	attacker = netip.AddrFrom4([4]byte{203, 0, 113, 7})
+)
+
+func login(t time.Time, src netip.Addr, name string, success bool) guardio.AuthEvent {
+	return guardio.AuthEvent{Time: t, Host: "web1", Service: "sshd", User: name, Source: src, Success: success}
+}
+
+// normal returns d of logins by 30 employees from their own addresses,
+// a few an hour, with the odd mistyped password or user name.
+func normal(rng *rand.Rand, from time.Time, d time.Duration) []guardio.AuthEvent {
+	var events []guardio.AuthEvent
+	for u := 0; u < 100; u++ {
+		src := netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + u)})
+		name := fmt.Sprintf("user%d", u)
+		for t := from.Add(time.Duration(rng.Intn(600)) * time.Second); t.Before(from.Add(d)); t = t.Add(time.Duration(rng.ExpFloat64()*1200) * time.Second) {
+			switch r := rng.Intn(200); {
+			case r < 20:
+				events = append(events, login(t, src, name, false))
+				t = t.Add(time.Duration(3+rng.Intn(10)) * time.Second)
+			case r == 20:
+				typo := login(t, src, name[1:], false)
+				typo.InvalidUser = true
+				events = append(events, typo)
+				t = t.Add(time.Duration(3+rng.Intn(10)) * time.Second)
+			}
+			events = append(events, login(t, src, name, true))
+		}
+	}
+	sortByTime(events)
+	return events
+}
+
+// guess returns n failed logins by attacker, one a second, on the users
+// returned by name.
+func guess(from time.Time, n int, name func(i int) string) []guardio.AuthEvent {
+	events := make([]guardio.AuthEvent, n)
+	for i := range events {
+		events[i] = login(from.Add(time.Duration(i)*time.Second), attacker, name(i), false)
+	}
+	return events
+}
+
+func sortByTime(events []guardio.AuthEvent) {
+	slices.SortStableFunc(events, func(a, b guardio.AuthEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(normal(rand.New(rand.NewSource(1)), start, 72*time.Hour)))
+	assert.Zero(t, p.Sources(), "training state is not carried into detection")
+	return p
+}
+
+func observeAll(t *testing.T, p *Profile, events []guardio.AuthEvent) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, e := range events {
+		alert, raised, err := p.Observe(e)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestBruteForce(t *testing.T) {
+	p := trained(t)
+	live := start.Add(24 * time.Hour)
+
+	attack := guess(live.Add(10*time.Minute), 120, func(int) string { return "root" })
+	events := append(normal(rand.New(rand.NewSource(2)), live, time.Hour), attack...)
+	sortByTime(events)
+	alerts := observeAll(t, p, events)
+
+	require.Len(t, alerts, 1, "one alert within the cooldown")
+	a := alerts[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, attacker.String(), a.Entity)
+	assert.Equal(t, live.Add(10*time.Minute), a.Start)
+	assert.GreaterOrEqual(t, a.Features["failures"], 5.0)
+	assert.Equal(t, 1.0, a.Features["peers"])
+	assert.InDelta(t, 1.0, a.Features["interval_mean"], 1e-9)
+	assert.InDelta(t, 0.0, a.Features["interval_cv"], 1e-9, "guessing at a steady pace")
+}
+
+func TestSpraying(t *testing.T) {
+	p := trained(t)
+	live := start.Add(24 * time.Hour)
+
+	attack := guess(live.Add(10*time.Minute), 60, func(i int) string { return fmt.Sprintf("user%d", i%30) })
+	events := append(normal(rand.New(rand.NewSource(3)), live, time.Hour), attack...)
+	sortByTime(events)
+	alerts := observeAll(t, p, events)
+
+	require.Len(t, alerts, 1)
+	assert.Equal(t, attacker.String(), alerts[0].Entity)
+	assert.GreaterOrEqual(t, alerts[0].Features["peers"], 5.0)
+	assert.Contains(t, alerts[0].Message, "logins on")
+}
+
+func TestDistributed(t *testing.T) {
+	p := trained(t)
+	live := start.Add(24 * time.Hour)
+
+	events := normal(rand.New(rand.NewSource(4)), live, time.Hour)
+	// A botnet tries admin from 40 addresses, once each.
+	for i := 0; i < 40; i++ {
+		src := netip.AddrFrom4([4]byte{198, 51, 100, byte(i)})
+		e := login(live.Add(10*time.Minute+time.Duration(i)*5*time.Second), src, "admin", false)
+		e.InvalidUser = true
+		events = append(events, e)
+	}
+	sortByTime(events)
+	alerts := observeAll(t, p, events)
+
+	require.Len(t, alerts, 1)
+	a := alerts[0]
+	assert.Equal(t, "admin", a.Entity)
+	assert.GreaterOrEqual(t, a.Features["failures"], 5.0)
+	assert.Equal(t, a.Features["failures"], a.Features["peers"], "one failure per source")
+	assert.Equal(t, 1.0, a.Features["invalid_ratio"])
+	assert.Contains(t, a.Message, "logins as admin failed")
+}
+
+func TestBreach(t *testing.T) {
+	p := trained(t)
+	live := start.Add(24 * time.Hour)
+
+	events := guess(live, 60, func(int) string { return "root" })
+	events = append(events, login(live.Add(90*time.Second), attacker, "root", true))
+	alerts := observeAll(t, p, events)
+
+	require.Len(t, alerts, 2, "the successful login is reported despite the cooldown")
+	assert.Equal(t, attacker.String(), alerts[1].Entity)
+	assert.Equal(t, live, alerts[1].Start)
+	assert.Contains(t, alerts[1].Message, "logged in as root")
+
+	alerts = observeAll(t, p, []guardio.AuthEvent{login(live.Add(2*time.Minute), attacker, "root", true)})
+	assert.Empty(t, alerts, "a breach is reported once")
+}
+
+func TestLocalAttempts(t *testing.T) {
+	p := trained(t)
+	var events []guardio.AuthEvent
+	for i := 0; i < 50; i++ {
+		e := login(start.Add(time.Duration(i)*time.Second), netip.Addr{}, "root", false)
+		e.Service = "su"
+		events = append(events, e)
+	}
+	alerts := observeAll(t, p, events)
+	require.Len(t, alerts, 1, "attempts without a source count towards their user")
+	assert.Equal(t, "root", alerts[0].Entity)
+	assert.Zero(t, alerts[0].Features["peers"])
+	assert.Zero(t, p.Sources())
+	assert.Equal(t, 1, p.Users())
+}
+
+func TestMaxSources(t *testing.T) {
+	p := New(WithMaxSources(3), WithMaxUsers(2))
+	for i := 0; i < 10; i++ {
+		p.track(login(start, netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}), fmt.Sprintf("user%d", i), false))
+	}
+	assert.Equal(t, 3, p.Sources())
+	assert.Equal(t, 2, p.Users())
+
+	p.track(login(start.Add(time.Hour), attacker, "root", false))
+	assert.Equal(t, 1, p.Sources(), "idle sources are forgotten")
+	assert.Equal(t, 1, p.Users(), "idle users are forgotten")
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(login(start, attacker, "root", false))
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	assert.ErrorIs(t, p.Run(context.Background(), nil, nil), profiles.ErrNotTrained)
+	assert.Error(t, p.Fit([]guardio.AuthEvent{login(start, attacker, "root", true)}), "no failures in the baseline")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	events := guess(start, 60, func(int) string { return "root" })
+	events = append(events, login(start.Add(90*time.Second), attacker, "root", true))
+	in := make(chan guardio.AuthEvent, len(events))
+	for _, e := range events {
+		in <- e
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 2)
+	assert.Contains(t, (<-out).Message, "failed")
+	assert.Contains(t, (<-out).Message, "logged in as root")
+}
+
+func TestRunCanceled(t *testing.T) {
+	p := trained(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.Run(ctx, make(chan guardio.AuthEvent), make(chan profiles.Alert))
+	assert.ErrorIs(t, err, context.Canceled)
+}
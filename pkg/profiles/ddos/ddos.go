@@ -0,0 +1,471 @@
+// Package ddos detects volumetric denial-of-service attacks. It aggregates
+// traffic into fixed intervals, one second by default, and scores each on
+// its packet and byte rates, the share of SYN-only packets in TCP traffic
+// and the entropy of source addresses: floods from one host lower the
+// entropy, spoofed or distributed floods raise it.
+//
+// Rather than scoring packets, the profile reports attacks: an EventStart
+// alert once enough consecutive intervals are anomalous, and an EventEnd
+// alert once enough consecutive intervals are normal again, so short
+// bursts of legitimate traffic do not page anyone.
+package ddos
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "ddos"
+
+// FeatureNames names the features of an interval, in vector order. Rates
+// are per second.
+var FeatureNames = []string{"pps", "bps", "syn_ratio", "source_entropy"}
+
+// maxSources bounds the source addresses counted exactly per interval.
+// Further sources are assumed to send one packet each, which is what
+// spoofed floods that exceed the bound look like.
+const maxSources = 1 << 16
+
+// Profile detects volumetric attacks. It is safe for concurrent use.
+type Profile struct {
+	interval time.Duration
+	sustain  int
+	recovery int
+	detector detectors.Detector
+
+	mu      sync.Mutex
+	trained bool
+	floor   float64 // scaled packet rate below which intervals are not attacks
+	bucket  *bucket
+	hot     int // consecutive anomalous intervals
+	calm    int // consecutive normal intervals during an attack
+	attack  *attack
+}
+
+// bucket accumulates the traffic of one interval.
+type bucket struct {
+	start    time.Time
+	packets  int
+	bytes    int
+	tcp      int
+	syns     int
+	sources  map[netip.Addr]int
+	overflow int // packets from sources beyond maxSources
+	targets  map[netip.Addr]int
+}
+
+// attack is an attack in progress.
+type attack struct {
+	start  time.Time
+	target netip.Addr
+	peak   []float64 // highest value of each feature
+	score  float64   // highest score
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithInterval sets the length of the intervals traffic is aggregated
+// over. Defaults to one second.
+func WithInterval(d time.Duration) Option {
+	return func(p *Profile) {
+		p.interval = d
+	}
+}
+
+// WithSustain sets how many consecutive anomalous intervals start an
+// attack. Defaults to 3.
+func WithSustain(n int) Option {
+	return func(p *Profile) {
+		p.sustain = n
+	}
+}
+
+// WithRecovery sets how many consecutive normal intervals end an attack.
+// Defaults to 5.
+func WithRecovery(n int) Option {
+	return func(p *Profile) {
+		p.recovery = n
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		interval: time.Second,
+		sustain:  3,
+		recovery: 5,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			iforest.WithContamination(0.01),
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.interval = max(p.interval, time.Millisecond)
+	p.sustain = max(p.sustain, 1)
+	p.recovery = max(p.recovery, 1)
+	return p
+}
+
+// Fit trains the detector on the intervals of packets, a baseline of
+// normal traffic in capture order.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		for _, b := range p.add(pkt) {
+			vectors = append(vectors, scaled(p.features(b)))
+		}
+	}
+	p.reset()
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("ddos: baseline spans %d complete intervals, need at least 2", len(vectors))
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("ddos: %w", err)
+	}
+	p.calibrate()
+	p.trained = true
+	return nil
+}
+
+// Observe adds pkt to the current interval and returns the alerts raised
+// by the intervals it completes.
+func (p *Profile) Observe(pkt guardio.Packet) ([]profiles.Alert, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.score(p.add(pkt))
+}
+
+// Flush completes the intervals that end at or before now, as if a packet
+// arrived then, and returns the alerts they raise. Call it when traffic
+// may stop altogether, such as at the end of a capture, so an attack in
+// progress can end.
+func (p *Profile) Flush(now time.Time) ([]profiles.Alert, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.score(p.advance(now))
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, p.Observe)
+}
+
+// Attacking reports whether an attack is in progress.
+func (p *Profile) Attacking() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attack != nil
+}
+
+// Save serializes the trained detector.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("ddos: %w", err)
+	}
+	p.calibrate()
+	p.trained = true
+	return nil
+}
+
+// calibrate sets the packet rate floor to the baseline mean, taken from
+// the detector's training profile if it keeps one. Intervals quieter than
+// the baseline can be unusual, an outage for instance, but are not a
+// volumetric attack. The caller holds p.mu.
+func (p *Profile) calibrate() {
+	p.floor = 0
+	if d, ok := p.detector.(detectors.Profiler); ok {
+		if profile := d.TrainingProfile(); profile != nil && len(profile.Features) > 0 {
+			p.floor = profile.Features[0].Mean
+		}
+	}
+}
+
+// reset forgets the traffic and attack state. The caller holds p.mu.
+func (p *Profile) reset() {
+	p.bucket = nil
+	p.hot, p.calm = 0, 0
+	p.attack = nil
+}
+
+// add records pkt and returns the intervals completed before it. The
+// caller holds p.mu.
+func (p *Profile) add(pkt guardio.Packet) []*bucket {
+	done := p.advance(pkt.Time)
+	b := p.bucket
+	if b == nil {
+		b = p.newBucket(pkt.Time.Truncate(p.interval))
+	}
+
+	b.packets++
+	b.bytes += pkt.Length
+	if pkt.Protocol == guardio.ProtoTCP {
+		b.tcp++
+		if pkt.SYNOnly() {
+			b.syns++
+		}
+	}
+	if pkt.Src.IsValid() {
+		if _, ok := b.sources[pkt.Src]; ok || len(b.sources) < maxSources {
+			b.sources[pkt.Src]++
+		} else {
+			b.overflow++
+		}
+	}
+	if pkt.Dst.IsValid() {
+		if _, ok := b.targets[pkt.Dst]; ok || len(b.targets) < maxSources {
+			b.targets[pkt.Dst]++
+		}
+	}
+	return done
+}
+
+// advance completes the current interval and any empty ones after it that
+// end at or before now, and returns them. After sustain+recovery empty
+// intervals the attack state cannot change any further, so longer gaps
+// are skipped. The caller holds p.mu.
+func (p *Profile) advance(now time.Time) []*bucket {
+	var done []*bucket
+	for p.bucket != nil {
+		end := p.bucket.start.Add(p.interval)
+		if now.Before(end) {
+			break
+		}
+		done = append(done, p.bucket)
+		if len(done) > p.sustain+p.recovery {
+			p.bucket = nil
+			break
+		}
+		p.newBucket(end)
+	}
+	return done
+}
+
+// newBucket makes an empty interval starting at start the current one.
+// The caller holds p.mu.
+func (p *Profile) newBucket(start time.Time) *bucket {
+	p.bucket = &bucket{
+		start:   start,
+		sources: make(map[netip.Addr]int),
+		targets: make(map[netip.Addr]int),
+	}
+	return p.bucket
+}
+
+// features returns the feature vector of b.
+func (p *Profile) features(b *bucket) []float64 {
+	seconds := p.interval.Seconds()
+	var synRatio float64
+	if b.tcp > 0 {
+		synRatio = float64(b.syns) / float64(b.tcp)
+	}
+	return []float64{
+		float64(b.packets) / seconds,
+		8 * float64(b.bytes) / seconds,
+		synRatio,
+		entropy(b.sources, b.overflow),
+	}
+}
+
+// score feeds the completed intervals through the attack state machine.
+// The caller holds p.mu.
+func (p *Profile) score(done []*bucket) ([]profiles.Alert, error) {
+	var alerts []profiles.Alert
+	threshold := detectors.ThresholdOf(p.detector)
+	for _, b := range done {
+		features := p.features(b)
+		x := scaled(features)
+		score, err := p.detector.PredictOne(x)
+		if err != nil {
+			return alerts, fmt.Errorf("ddos: %w", err)
+		}
+		end := b.start.Add(p.interval)
+
+		if score < threshold || x[0] <= p.floor {
+			p.hot = 0
+			if p.attack == nil {
+				continue
+			}
+			p.calm++
+			if p.calm >= p.recovery {
+				alerts = append(alerts, p.endAlert(end))
+				p.attack = nil
+				p.calm = 0
+			}
+			continue
+		}
+
+		p.calm = 0
+		p.hot++
+		if p.attack == nil && p.hot >= p.sustain {
+			p.attack = &attack{
+				start:  b.start.Add(-time.Duration(p.sustain-1) * p.interval),
+				target: top(b.targets),
+				peak:   features,
+				score:  score,
+			}
+			alerts = append(alerts, p.startAlert(end, features, score))
+			continue
+		}
+		if a := p.attack; a != nil {
+			for i, v := range features {
+				a.peak[i] = max(a.peak[i], v)
+			}
+			a.score = max(a.score, score)
+		}
+	}
+	return alerts, nil
+}
+
+func (p *Profile) startAlert(end time.Time, features []float64, score float64) profiles.Alert {
+	a := p.attack
+	return profiles.Alert{
+		Profile:  Name,
+		Event:    profiles.EventStart,
+		Entity:   entity(a.target),
+		Start:    a.start,
+		Time:     end,
+		Score:    score,
+		Features: named(features),
+		Message: fmt.Sprintf("attack on %s started: %.0f packets/s, %s",
+			entity(a.target), features[0], bitrate(features[1])),
+	}
+}
+
+func (p *Profile) endAlert(end time.Time) profiles.Alert {
+	a := p.attack
+	// The attack ended when the calm intervals began.
+	stop := end.Add(-time.Duration(p.recovery) * p.interval)
+	return profiles.Alert{
+		Profile:  Name,
+		Event:    profiles.EventEnd,
+		Entity:   entity(a.target),
+		Start:    a.start,
+		Time:     stop,
+		Score:    a.score,
+		Features: named(a.peak),
+		Message: fmt.Sprintf("attack on %s ended after %s, peak %.0f packets/s, %s",
+			entity(a.target), stop.Sub(a.start), a.peak[0], bitrate(a.peak[1])),
+	}
+}
+
+// scaled returns features with the rates on a log scale, so the detector
+// reacts to relative rather than absolute changes in volume. Features is
+// left untouched.
+func scaled(features []float64) []float64 {
+	out := append([]float64(nil), features...)
+	out[0] = math.Log1p(out[0])
+	out[1] = math.Log1p(out[1])
+	return out
+}
+
+// entropy returns the Shannon entropy, in bits, of the distribution of
+// packets over sources, counting each overflow packet as its own source.
+func entropy(counts map[netip.Addr]int, overflow int) float64 {
+	total := overflow
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	var h float64
+	for _, n := range counts {
+		q := float64(n) / float64(total)
+		h -= q * math.Log2(q)
+	}
+	if overflow > 0 {
+		q := 1 / float64(total)
+		h -= float64(overflow) * q * math.Log2(q)
+	}
+	return h
+}
+
+// top returns the address with the highest count.
+func top(counts map[netip.Addr]int) netip.Addr {
+	var best netip.Addr
+	n := 0
+	for addr, c := range counts {
+		if c > n || (c == n && addr.Less(best)) {
+			best, n = addr, c
+		}
+	}
+	return best
+}
+
+func entity(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return "network"
+	}
+	return addr.String()
+}
+
+func named(features []float64) map[string]float64 {
+	m := make(map[string]float64, len(FeatureNames))
+	for i, name := range FeatureNames {
+		m[name] = features[i]
+	}
+	return m
+}
+
+// bitrate formats bits per second with a decimal unit.
+func bitrate(bps float64) string {
+	for _, unit := range []string{"bit/s", "kbit/s", "Mbit/s", "Gbit/s"} {
+		if bps < 1000 {
+			return fmt.Sprintf("%.1f %s", bps, unit)
+		}
+		bps /= 1000
+	}
+	return fmt.Sprintf("%.1f Tbit/s", bps)
+}
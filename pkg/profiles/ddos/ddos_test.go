@@ -0,0 +1,229 @@
+package ddos
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start  = time.Unix(1700000000, 0)
+	server = netip.AddrFrom4([4]byte{10, 1, 0, 1})
+)
+
+// normal returns d of ordinary traffic towards a few servers: 100 to 300
+// packets a second from 50 clients, a few of them connection attempts.
+func normal(rng *rand.Rand, from time.Time, d time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	for s := time.Duration(0); s < d; s += time.Second {
+		n := 100 + rng.Intn(200)
+		for i := 0; i < n; i++ {
+			p := guardio.Packet{
+				Time:     from.Add(s + time.Duration(i)*time.Second/time.Duration(n)),
+				Src:      netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + rng.Intn(50))}),
+				Dst:      netip.AddrFrom4([4]byte{10, 1, 0, byte(1 + rng.Intn(3))}),
+				Protocol: guardio.ProtoTCP,
+				Flags:    guardio.FlagACK,
+				Length:   200 + rng.Intn(1200),
+			}
+			if rng.Intn(20) == 0 {
+				p.Flags = guardio.FlagSYN
+				p.Length = 60
+			}
+			packets = append(packets, p)
+		}
+	}
+	return packets
+}
+
+// flood returns d of spoofed SYNs towards server at rate packets a second.
+func flood(rng *rand.Rand, from time.Time, d time.Duration, rate int) []guardio.Packet {
+	var packets []guardio.Packet
+	total := int(d.Seconds() * float64(rate))
+	for i := 0; i < total; i++ {
+		packets = append(packets, guardio.Packet{
+			Time:     from.Add(time.Duration(i) * time.Second / time.Duration(rate)),
+			Src:      netip.AddrFrom4([4]byte{byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)), 1}),
+			Dst:      server,
+			Protocol: guardio.ProtoTCP,
+			Flags:    guardio.FlagSYN,
+			Length:   60,
+		})
+	}
+	return packets
+}
+
+func merge(a, b []guardio.Packet) []guardio.Packet {
+	out := make([]guardio.Packet, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].Time.Before(a[0].Time) {
+			out, b = append(out, b[0]), b[1:]
+		} else {
+			out, a = append(out, a[0]), a[1:]
+		}
+	}
+	return append(append(out, a...), b...)
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(normal(rand.New(rand.NewSource(1)), start, 10*time.Minute)))
+	return p
+}
+
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		alerts = append(alerts, raised...)
+	}
+	return alerts
+}
+
+func TestAttack(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(2))
+	live := start.Add(time.Hour)
+	attackStart := live.Add(60 * time.Second)
+
+	packets := merge(normal(rng, live, 3*time.Minute), flood(rng, attackStart, 30*time.Second, 5000))
+	alerts := observeAll(t, p, packets)
+	require.Len(t, alerts, 2, "one start and one end")
+
+	begin, end := alerts[0], alerts[1]
+	assert.Equal(t, profiles.EventStart, begin.Event)
+	assert.Equal(t, Name, begin.Profile)
+	assert.Equal(t, server.String(), begin.Entity)
+	assert.Equal(t, attackStart, begin.Start)
+	assert.Equal(t, attackStart.Add(3*time.Second), begin.Time, "after three anomalous seconds")
+	assert.Greater(t, begin.Features["pps"], 5000.0)
+	assert.Greater(t, begin.Features["syn_ratio"], 0.9)
+	assert.Greater(t, begin.Features["source_entropy"], 10.0, "spoofed sources")
+
+	assert.Equal(t, profiles.EventEnd, end.Event)
+	assert.Equal(t, begin.Start, end.Start)
+	assert.Equal(t, attackStart.Add(30*time.Second), end.Time)
+	assert.GreaterOrEqual(t, end.Features["pps"], begin.Features["pps"], "peak rates")
+	assert.Contains(t, end.Message, "ended after 30s")
+	assert.False(t, p.Attacking())
+}
+
+func TestBurstTolerance(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(3))
+	live := start.Add(time.Hour)
+
+	// Two seconds of flood do not make an attack.
+	packets := merge(normal(rng, live, time.Minute), flood(rng, live.Add(20*time.Second), 2*time.Second, 5000))
+	assert.Empty(t, observeAll(t, p, packets))
+
+	p = trained(t, WithSustain(1))
+	packets = merge(normal(rng, live, time.Minute), flood(rng, live.Add(20*time.Second), 2*time.Second, 5000))
+	alerts := observeAll(t, p, packets)
+	require.Len(t, alerts, 2, "sustain 1 reports the burst")
+	assert.Equal(t, live.Add(20*time.Second), alerts[0].Start)
+}
+
+func TestSingleSourceFlood(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(4))
+	live := start.Add(time.Hour)
+
+	var udp []guardio.Packet
+	for i := 0; i < 20*3000; i++ {
+		udp = append(udp, guardio.Packet{
+			Time:     live.Add(10*time.Second + time.Duration(i)*time.Second/3000),
+			Src:      netip.AddrFrom4([4]byte{203, 0, 113, 9}),
+			Dst:      server,
+			Protocol: guardio.ProtoUDP,
+			Length:   1400,
+		})
+	}
+	alerts := observeAll(t, p, merge(normal(rng, live, time.Minute), udp))
+	require.NotEmpty(t, alerts)
+	assert.Equal(t, profiles.EventStart, alerts[0].Event)
+	assert.Less(t, alerts[0].Features["source_entropy"], 1.0)
+	assert.Contains(t, alerts[0].Message, "Mbit/s")
+}
+
+func TestFlush(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(5))
+	live := start.Add(time.Hour)
+
+	// The capture ends while the attack is still going on.
+	alerts := observeAll(t, p, flood(rng, live, 10*time.Second, 5000))
+	require.Len(t, alerts, 1)
+	assert.True(t, p.Attacking())
+
+	alerts, err := p.Flush(live.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1, "silence is not an attack")
+	assert.Equal(t, profiles.EventEnd, alerts[0].Event)
+	assert.Equal(t, live.Add(10*time.Second), alerts[0].Time)
+}
+
+func TestEntropy(t *testing.T) {
+	a := netip.AddrFrom4([4]byte{1, 1, 1, 1})
+	b := netip.AddrFrom4([4]byte{2, 2, 2, 2})
+	tests := []struct {
+		name     string
+		counts   map[netip.Addr]int
+		overflow int
+		want     float64
+	}{
+		{name: "empty", want: 0},
+		{name: "one source", counts: map[netip.Addr]int{a: 10}, want: 0},
+		{name: "two even", counts: map[netip.Addr]int{a: 5, b: 5}, want: 1},
+		{name: "overflow", counts: map[netip.Addr]int{a: 2}, overflow: 2, want: 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, entropy(tt.counts, tt.overflow), 1e-12)
+		})
+	}
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, err := p.Observe(guardio.Packet{Time: start})
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Flush(start)
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	assert.Error(t, p.Fit(normal(rand.New(rand.NewSource(1)), start, time.Second)), "too short a baseline")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	trainedProfile := trained(t)
+	saved, err := trainedProfile.Save()
+	require.NoError(t, err)
+
+	p := New()
+	require.NoError(t, p.Load(saved))
+	assert.InDelta(t, trainedProfile.floor, p.floor, 1e-12)
+	assert.Greater(t, math.Expm1(p.floor), 100.0, "floor is the baseline packet rate")
+
+	rng := rand.New(rand.NewSource(6))
+	packets := flood(rng, start, 5*time.Second, 5000)
+	in := make(chan guardio.Packet, len(packets))
+	for _, pkt := range packets {
+		in <- pkt
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, profiles.EventStart, (<-out).Event)
+}
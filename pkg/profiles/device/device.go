@@ -0,0 +1,549 @@
+// Package device learns a baseline per device and scores every device
+// against its own history. In heterogeneous networks, such as IoT ones, a
+// camera, a thermostat and a printer behave so differently that a single
+// model of all of them washes out what is normal for each: a burst that
+// is routine for the camera is an anomaly for the thermostat.
+//
+// The Manager aggregates the traffic of every internal device, identified
+// by its MAC address or, for captures without one, its IP address, into
+// fixed intervals, one minute by default. It scores each interval the
+// device was active in on the packets and bytes it sent, the peers and
+// ports it sent them to, and the share of its packets it received. Each
+// device first learns: once it has enough intervals, a detector of its
+// own is trained on them, and its later intervals are scored by it.
+//
+// An isolation forest scores a value beyond the training range like the
+// most extreme training value, and cannot tell a device that only ever
+// talked to one peer from one that talks to fifty if the feature was
+// constant. So an interval also raises an alert when a feature leaves the
+// range the device was trained on by more than the tolerance, whatever
+// its score.
+//
+// The number of devices tracked is bounded. When a new device would
+// exceed the bound, one is evicted according to the eviction policy,
+// along with its model; it learns afresh if it comes back.
+//
+// Typical use:
+//
+//	m := device.New(device.WithMaxDevices(5000))
+//	packets, _ := reader.StreamPackets(ctx) // a pcap.Reader
+//	go m.Run(ctx, packets, alerts)
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/internal/lru"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "device"
+
+// FeatureNames names the features of an interval, in vector order. Counts
+// of packets and bytes are log10(1+n).
+var FeatureNames = []string{"packets", "bytes", "peers", "ports", "inbound_ratio"}
+
+// maxDistinct bounds the peers and ports counted exactly per interval.
+const maxDistinct = 1 << 12
+
+// Eviction policies, choosing the device to evict when the bound is
+// reached.
+const (
+	// EvictLRU evicts the device seen least recently.
+	EvictLRU = iota
+	// EvictLearning evicts the device seen least recently among those
+	// still learning, and the one seen least recently if all have models:
+	// a model takes a baseline to rebuild, a half-learned baseline does
+	// not.
+	EvictLearning
+)
+
+// Manager learns and scores a model per device. It is safe for concurrent
+// use.
+type Manager struct {
+	interval   time.Duration
+	baseline   int
+	tolerance  float64
+	cooldown   time.Duration
+	maxDevices int
+	eviction   int
+	internal   []netip.Prefix
+	severity   profiles.SeverityMap
+	newModel   func() detectors.Detector
+
+	mu      sync.Mutex
+	devices *lru.List[string, *device] // most recently seen first
+}
+
+// device is the state of one device.
+type device struct {
+	key     string
+	model   detectors.Detector // nil while learning
+	low     []float64          // training range of each feature, nil
+	high    []float64          // if the model keeps no profile
+	samples [][]float64
+	bucket  *bucket
+	alerted time.Time
+}
+
+// bucket accumulates the traffic of a device in one interval.
+type bucket struct {
+	start    time.Time
+	sent     int
+	received int
+	bytes    int
+	peers    map[netip.Addr]struct{}
+	ports    map[uint16]struct{}
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithInterval sets the length of the intervals traffic is aggregated
+// over. Defaults to one minute.
+func WithInterval(d time.Duration) Option {
+	return func(m *Manager) {
+		m.interval = d
+	}
+}
+
+// WithBaseline sets how many active intervals a device learns from before
+// its model is trained. Defaults to 240, four hours of constant activity
+// at the default interval.
+func WithBaseline(n int) Option {
+	return func(m *Manager) {
+		m.baseline = n
+	}
+}
+
+// WithTolerance sets how far, in units of the width of its training range
+// but at least 1, a feature may leave that range before the interval
+// raises an alert regardless of its score. With the default of 1, a
+// device sending ten times as many packets as it ever did, or talking to
+// three peers when it only ever talked to one, raises an alert. Zero
+// disables the check.
+func WithTolerance(f float64) Option {
+	return func(m *Manager) {
+		m.tolerance = f
+	}
+}
+
+// WithCooldown sets how long a device that raised an alert stays quiet
+// before it can raise another. Defaults to 15 minutes.
+func WithCooldown(d time.Duration) Option {
+	return func(m *Manager) {
+		m.cooldown = d
+	}
+}
+
+// WithMaxDevices bounds the number of devices tracked at once. Defaults
+// to 10000.
+func WithMaxDevices(n int) Option {
+	return func(m *Manager) {
+		m.maxDevices = n
+	}
+}
+
+// WithEviction sets the eviction policy, EvictLRU or EvictLearning.
+// Defaults to EvictLRU.
+func WithEviction(policy int) Option {
+	return func(m *Manager) {
+		m.eviction = policy
+	}
+}
+
+// WithInternal sets the networks of devices. Repeated options accumulate.
+// Defaults to the private address ranges.
+func WithInternal(prefixes ...netip.Prefix) Option {
+	return func(m *Manager) {
+		for _, prefix := range prefixes {
+			m.internal = append(m.internal, prefix.Masked())
+		}
+	}
+}
+
+// WithSeverity sets how alerts are graded. Defaults to
+// profiles.DefaultSeverityMap.
+func WithSeverity(sm profiles.SeverityMap) Option {
+	return func(m *Manager) {
+		m.severity = sm
+	}
+}
+
+// WithDetector sets the function creating the model of each device.
+// Defaults to an isolation forest tuned for the features, smaller than
+// those of the other profiles as it is trained on a single device.
+func WithDetector(newModel func() detectors.Detector) Option {
+	return func(m *Manager) {
+		m.newModel = newModel
+	}
+}
+
+// New creates a Manager with no devices.
+func New(opts ...Option) *Manager {
+	m := &Manager{
+		interval:   time.Minute,
+		baseline:   240,
+		tolerance:  1,
+		cooldown:   15 * time.Minute,
+		maxDevices: 10000,
+		severity:   profiles.DefaultSeverityMap,
+		devices:    lru.New[string, *device](),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.newModel == nil {
+		m.newModel = func() detectors.Detector {
+			return iforest.New(
+				iforest.WithTrees(100),
+				// Each device is scored every interval: keep it quiet.
+				iforest.WithContamination(0.005),
+				// Devices often never receive, or talk to a single peer.
+				iforest.WithExcludeConstant(true),
+				iforest.WithFeatureNames(FeatureNames),
+			)
+		}
+	}
+	m.interval = max(m.interval, time.Millisecond)
+	m.baseline = max(m.baseline, 2)
+	m.maxDevices = max(m.maxDevices, 1)
+	return m
+}
+
+// Fit learns the devices in packets, a baseline of normal traffic in
+// capture order, as Observe would without raising alerts, then trains the
+// model of every device in it at once rather than waiting for its
+// baseline. The last, partial interval of each device is dropped, and
+// devices with fewer than two complete intervals keep learning. It fails
+// if no device could be learned.
+func (m *Manager) Fit(packets []guardio.Packet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pkt := range packets {
+		for _, done := range m.add(pkt) {
+			if done.device.model == nil {
+				done.device.samples = append(done.device.samples, done.features)
+			}
+		}
+	}
+	trained := 0
+	for _, d := range m.devices.All() {
+		d.bucket = nil
+		if len(d.samples) >= 2 && m.train(d) {
+			trained++
+		}
+	}
+	if trained == 0 {
+		return fmt.Errorf("device: no device could be learned from %d packets", len(packets))
+	}
+	return nil
+}
+
+// Observe adds pkt to the current interval of the devices it involves and
+// returns the alerts raised by the intervals it completes.
+func (m *Manager) Observe(pkt guardio.Packet) ([]profiles.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.score(m.add(pkt))
+}
+
+// Flush completes the intervals that end at or before now, as if a packet
+// arrived then, and returns the alerts they raise. Call it when traffic
+// may stop altogether, such as at the end of a capture.
+func (m *Manager) Flush(now time.Time) ([]profiles.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var done []completed
+	for _, d := range m.devices.All() {
+		if b := d.bucket; b != nil && !now.Before(b.start.Add(m.interval)) {
+			done = append(done, completed{d, b, features(b)})
+			d.bucket = nil
+		}
+	}
+	return m.score(done)
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (m *Manager) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, m.Observe)
+}
+
+// Devices returns the number of devices tracked and how many of them have
+// a model.
+func (m *Manager) Devices() (tracked, trained int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.devices.All() {
+		if d.model != nil {
+			trained++
+		}
+	}
+	return m.devices.Len(), trained
+}
+
+// Save serializes the models of the devices that have one, by device.
+// Devices still learning are not saved.
+func (m *Manager) Save() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	models := make(map[string][]byte)
+	for _, d := range m.devices.All() {
+		if d.model == nil {
+			continue
+		}
+		data, err := d.model.Save()
+		if err != nil {
+			return nil, fmt.Errorf("device: %s: %w", d.key, err)
+		}
+		models[d.key] = data
+	}
+	return json.Marshal(models)
+}
+
+// Load restores the models saved by Save, replacing the models of the
+// devices they belong to. Devices beyond the bound are evicted as usual.
+func (m *Manager) Load(data []byte) error {
+	var models map[string][]byte
+	if err := json.Unmarshal(data, &models); err != nil {
+		return fmt.Errorf("device: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, data := range models {
+		model := m.newModel()
+		if err := model.Load(data); err != nil {
+			return fmt.Errorf("device: %s: %w", key, err)
+		}
+		d := m.device(key)
+		d.model, d.samples = model, nil
+		d.calibrate()
+	}
+	return nil
+}
+
+// Key returns the key of the device with the given addresses: its MAC
+// address if known, its IP address otherwise.
+func Key(mac net.HardwareAddr, addr netip.Addr) string {
+	if len(mac) > 0 {
+		return mac.String()
+	}
+	return addr.Unmap().String()
+}
+
+// Internal reports whether addr belongs to a device.
+func (m *Manager) Internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if len(m.internal) == 0 {
+		return addr.IsPrivate()
+	}
+	for _, prefix := range m.internal {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// completed is an interval of a device that ended.
+type completed struct {
+	device   *device
+	bucket   *bucket
+	features []float64
+}
+
+// add records pkt with the devices it involves and returns their
+// intervals completed before it. The caller holds m.mu.
+func (m *Manager) add(pkt guardio.Packet) []completed {
+	var done []completed
+	if m.Internal(pkt.Src) {
+		b := m.bucket(Key(pkt.SrcMAC, pkt.Src), pkt.Time, &done)
+		b.sent++
+		b.bytes += pkt.Length
+		if len(b.peers) < maxDistinct {
+			b.peers[pkt.Dst] = struct{}{}
+		}
+		if len(b.ports) < maxDistinct {
+			b.ports[pkt.DstPort] = struct{}{}
+		}
+	}
+	if m.Internal(pkt.Dst) {
+		m.bucket(Key(pkt.DstMAC, pkt.Dst), pkt.Time, &done).received++
+	}
+	return done
+}
+
+// bucket returns the current interval of the device with key at t,
+// appending the one it completes, if any, to done. The caller holds m.mu.
+func (m *Manager) bucket(key string, t time.Time, done *[]completed) *bucket {
+	d := m.device(key)
+	if b := d.bucket; b != nil && !t.Before(b.start.Add(m.interval)) {
+		*done = append(*done, completed{d, b, features(b)})
+		d.bucket = nil
+	}
+	if d.bucket == nil {
+		d.bucket = &bucket{
+			start: t.Truncate(m.interval),
+			peers: make(map[netip.Addr]struct{}),
+			ports: make(map[uint16]struct{}),
+		}
+	}
+	return d.bucket
+}
+
+// device returns the device with key, tracking it if it is new and
+// marking it as the most recently seen. The caller holds m.mu.
+func (m *Manager) device(key string) *device {
+	if d, ok := m.devices.Get(key); ok {
+		return d
+	}
+	if m.devices.Len() >= m.maxDevices {
+		m.evict()
+	}
+	d := &device{key: key}
+	m.devices.Push(key, d)
+	return d
+}
+
+// evict forgets a device according to the eviction policy. The caller
+// holds m.mu.
+func (m *Manager) evict() {
+	victim, _, _ := m.devices.Oldest()
+	if m.eviction == EvictLearning {
+		for key, d := range m.devices.Backward() {
+			if d.model == nil {
+				victim = key
+				break
+			}
+		}
+	}
+	m.devices.Remove(victim)
+}
+
+// score learns from the completed intervals of devices still learning and
+// scores those of devices with a model. The caller holds m.mu.
+func (m *Manager) score(done []completed) ([]profiles.Alert, error) {
+	var alerts []profiles.Alert
+	for _, c := range done {
+		d := c.device
+		if d.model == nil {
+			d.samples = append(d.samples, c.features)
+			if len(d.samples) >= m.baseline {
+				m.train(d)
+			}
+			continue
+		}
+
+		score, err := d.model.PredictOne(c.features)
+		if err != nil {
+			return alerts, fmt.Errorf("device: %s: %w", d.key, err)
+		}
+		threshold := detectors.ThresholdOf(d.model)
+		beyond := m.beyond(d, c.features)
+		end := c.bucket.start.Add(m.interval)
+		if (score < threshold && beyond < 0) || (!d.alerted.IsZero() && end.Sub(d.alerted) < m.cooldown) {
+			continue
+		}
+		d.alerted = end
+		alerts = append(alerts, m.alert(d, c, score, threshold, beyond))
+	}
+	return alerts, nil
+}
+
+// train trains the model of d on its samples. A device whose baseline
+// cannot be learned, such as one whose intervals are all alike, starts
+// learning afresh. It reports whether d has a model. The caller holds
+// m.mu.
+func (m *Manager) train(d *device) bool {
+	model := m.newModel()
+	if err := model.Fit(d.samples); err == nil {
+		d.model = model
+		d.calibrate()
+	}
+	d.samples = nil
+	return d.model != nil
+}
+
+// calibrate sets the training range of d from its model's training
+// profile, if it keeps one.
+func (d *device) calibrate() {
+	d.low, d.high = nil, nil
+	p, ok := d.model.(detectors.Profiler)
+	if !ok {
+		return
+	}
+	profile := p.TrainingProfile()
+	if profile == nil || len(profile.Features) != len(FeatureNames) {
+		return
+	}
+	for _, f := range profile.Features {
+		d.low = append(d.low, f.Quantiles[0])
+		d.high = append(d.high, f.Quantiles[len(f.Quantiles)-1])
+	}
+}
+
+// beyond returns the index of the first feature of sample out of the
+// training range of d by more than the tolerance, or -1.
+func (m *Manager) beyond(d *device, sample []float64) int {
+	if m.tolerance <= 0 || d.low == nil {
+		return -1
+	}
+	for i, v := range sample {
+		margin := m.tolerance * max(d.high[i]-d.low[i], 1)
+		if v < d.low[i]-margin || v > d.high[i]+margin {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Manager) alert(d *device, c completed, score, threshold float64, beyond int) profiles.Alert {
+	b := c.bucket
+	why := "unlike its baseline"
+	if beyond >= 0 {
+		why = fmt.Sprintf("%s far outside its baseline", FeatureNames[beyond])
+	}
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   d.key,
+		Start:    b.start,
+		Time:     b.start.Add(m.interval),
+		Score:    score,
+		Severity: m.severity.Grade(score, threshold),
+		Features: make(map[string]float64, len(FeatureNames)),
+		Message: fmt.Sprintf("%s sent %d packets, %d bytes, to %d peers on %d ports and received %d packets in %s, %s",
+			d.key, b.sent, b.bytes, len(b.peers), len(b.ports), b.received, m.interval, why),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = c.features[i]
+	}
+	return alert
+}
+
+// features returns the feature vector of b.
+func features(b *bucket) []float64 {
+	return []float64{
+		math.Log10(1 + float64(b.sent)),
+		math.Log10(1 + float64(b.bytes)),
+		float64(len(b.peers)),
+		float64(len(b.ports)),
+		float64(b.received) / float64(b.sent+b.received),
+	}
+}
@@ -0,0 +1,237 @@
+package device
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start = time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	cameraMAC     = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x10}
+	thermostatMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x20}
+	nvrMAC        = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+
+	camera     = netip.MustParseAddr("10.0.0.10")
+	thermostat = netip.MustParseAddr("10.0.0.20")
+	nvr        = netip.MustParseAddr("10.0.0.2")
+	cloud      = netip.MustParseAddr("52.0.0.1")
+)
+
+func packet(t time.Time, srcMAC net.HardwareAddr, src netip.Addr, dstMAC net.HardwareAddr, dst netip.Addr, port uint16, length int) guardio.Packet {
+	return guardio.Packet{Time: t, SrcMAC: srcMAC, DstMAC: dstMAC, Src: src, Dst: dst, SrcPort: 40000, DstPort: port, Protocol: guardio.ProtoTCP, Length: length}
+}
+
+// traffic returns d of traffic in a small IoT network: a camera streaming
+// to a video recorder, which only receives, and a thermostat reporting to
+// its cloud and receiving its answers.
+func traffic(rng *rand.Rand, from time.Time, d time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	for m := from; m.Before(from.Add(d)); m = m.Add(time.Minute) {
+		for i, n := 0, 80+rng.Intn(40); i < n; i++ {
+			t := m.Add(time.Duration(rng.Intn(60000)) * time.Millisecond)
+			packets = append(packets, packet(t, cameraMAC, camera, nvrMAC, nvr, 554, 1000+rng.Intn(400)))
+		}
+		for i, n := 0, 1+rng.Intn(4); i < n; i++ {
+			t := m.Add(time.Duration(rng.Intn(59000)) * time.Millisecond)
+			packets = append(packets, packet(t, thermostatMAC, thermostat, nil, cloud, 443, 100+rng.Intn(200)))
+			reply := packet(t.Add(500*time.Millisecond), nil, cloud, thermostatMAC, thermostat, 40000, 80+rng.Intn(60))
+			packets = append(packets, reply)
+		}
+	}
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+	return packets
+}
+
+// observeAll feeds packets to m, flushes it after the last one and
+// returns the alerts raised.
+func observeAll(t *testing.T, m *Manager, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		raised, err := m.Observe(pkt)
+		require.NoError(t, err)
+		alerts = append(alerts, raised...)
+	}
+	raised, err := m.Flush(packets[len(packets)-1].Time.Add(time.Minute))
+	require.NoError(t, err)
+	return append(alerts, raised...)
+}
+
+func learned(t *testing.T, opts ...Option) *Manager {
+	t.Helper()
+	m := New(append([]Option{WithBaseline(120)}, opts...)...)
+	alerts := observeAll(t, m, traffic(rand.New(rand.NewSource(1)), start, 2*time.Hour+time.Minute))
+	require.Empty(t, alerts, "devices learning raise no alerts")
+	tracked, trained := m.Devices()
+	require.Equal(t, 3, tracked)
+	require.Equal(t, 2, trained, "the recorder, which never sends, cannot be learned")
+	return m
+}
+
+// tracks reports whether m tracks the device with key.
+func tracks(m *Manager, key string) bool {
+	_, ok := m.devices.Peek(key)
+	return ok
+}
+
+func TestOwnBaseline(t *testing.T) {
+	m := learned(t)
+	live := start.Add(3 * time.Hour)
+
+	// The thermostat streams like the camera: routine for one, not the
+	// other.
+	packets := traffic(rand.New(rand.NewSource(2)), live, 10*time.Minute)
+	for i := 0; i < 100; i++ {
+		t := live.Add(5*time.Minute + time.Duration(i)*500*time.Millisecond)
+		packets = append(packets, packet(t, thermostatMAC, thermostat, nvrMAC, nvr, 554, 1200))
+	}
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	alerts := observeAll(t, m, packets)
+	require.Len(t, alerts, 1, "one alert within the cooldown")
+	a := alerts[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, thermostatMAC.String(), a.Entity)
+	assert.Equal(t, live.Add(5*time.Minute), a.Start)
+	assert.Equal(t, live.Add(6*time.Minute), a.Time)
+	assert.NotEmpty(t, a.Severity)
+	assert.Greater(t, a.Features["packets"], 1.5)
+	assert.Contains(t, a.Message, "packets far outside its baseline")
+}
+
+func TestNewPeers(t *testing.T) {
+	m := learned(t)
+	live := start.Add(3 * time.Hour)
+
+	// The thermostat, which only ever talked to its cloud, probes the
+	// network: a constant feature in its baseline, invisible to the
+	// model alone.
+	packets := traffic(rand.New(rand.NewSource(2)), live, 10*time.Minute)
+	for i := 0; i < 20; i++ {
+		t := live.Add(5*time.Minute + time.Duration(i)*time.Second)
+		packets = append(packets, packet(t, thermostatMAC, thermostat, nil, netip.AddrFrom4([4]byte{10, 0, 2, byte(i)}), 23, 60))
+	}
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	alerts := observeAll(t, m, packets)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, thermostatMAC.String(), alerts[0].Entity)
+	assert.Equal(t, 21.0, alerts[0].Features["peers"])
+	assert.Contains(t, alerts[0].Message, "peers far outside its baseline")
+
+	m = learned(t, WithTolerance(0))
+	assert.Empty(t, observeAll(t, m, packets), "without the range check")
+}
+
+func TestNormalTraffic(t *testing.T) {
+	m := learned(t)
+	alerts := observeAll(t, m, traffic(rand.New(rand.NewSource(3)), start.Add(3*time.Hour), 2*time.Hour))
+	assert.LessOrEqual(t, len(alerts), 1)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "02:00:00:00:00:10", Key(cameraMAC, camera))
+	assert.Equal(t, "10.0.0.10", Key(nil, netip.MustParseAddr("::ffff:10.0.0.10")))
+
+	m := New()
+	m.Observe(packet(start, nil, camera, nil, cloud, 443, 100))
+	m.Observe(packet(start, nil, cloud, nil, thermostat, 443, 100))
+	m.Observe(packet(start, nil, cloud, nil, netip.MustParseAddr("8.8.8.8"), 443, 100))
+	tracked, _ := m.Devices()
+	assert.Equal(t, 2, tracked, "only internal addresses are devices")
+	assert.True(t, tracks(m, "10.0.0.10"))
+	assert.True(t, tracks(m, "10.0.0.20"))
+}
+
+func TestInternal(t *testing.T) {
+	m := New(WithInternal(netip.MustParsePrefix("203.0.113.7/24")))
+	assert.True(t, m.Internal(netip.MustParseAddr("203.0.113.200")))
+	assert.False(t, m.Internal(netip.MustParseAddr("10.1.2.3")), "the networks replace the private ranges")
+	assert.True(t, New().Internal(netip.MustParseAddr("::ffff:10.1.2.3")))
+}
+
+func TestEviction(t *testing.T) {
+	addr := func(i int) netip.Addr {
+		return netip.AddrFrom4([4]byte{10, 0, 1, byte(i)})
+	}
+
+	lru := learned(t, WithMaxDevices(3))
+	lru.Observe(packet(start.Add(3*time.Hour), nil, addr(1), nil, cloud, 443, 100))
+	tracked, trained := lru.Devices()
+	assert.Equal(t, 3, tracked)
+	assert.Equal(t, 1, trained, "the device seen least recently is evicted, model and all")
+
+	learning := learned(t, WithMaxDevices(3), WithEviction(EvictLearning))
+	learning.Observe(packet(start.Add(3*time.Hour), nil, addr(1), nil, cloud, 443, 100))
+	tracked, trained = learning.Devices()
+	assert.Equal(t, 3, tracked)
+	assert.Equal(t, 2, trained, "devices still learning are evicted first")
+	assert.False(t, tracks(learning, nvrMAC.String()))
+
+	learning.Observe(packet(start.Add(3*time.Hour), nil, addr(2), nil, cloud, 443, 100))
+	assert.False(t, tracks(learning, "10.0.1.1"))
+	_, trained = learning.Devices()
+	assert.Equal(t, 2, trained)
+}
+
+func TestFlush(t *testing.T) {
+	m := New()
+	m.Observe(packet(start, nil, camera, nil, nvr, 554, 1000))
+	_, err := m.Flush(start.Add(30 * time.Second))
+	require.NoError(t, err)
+	d, ok := m.devices.Peek("10.0.0.10")
+	require.True(t, ok)
+	assert.NotNil(t, d.bucket, "the interval is not over")
+
+	_, err = m.Flush(start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, d.bucket)
+	assert.Equal(t, [][]float64{features(&bucket{sent: 1, bytes: 1000, peers: map[netip.Addr]struct{}{nvr: {}}, ports: map[uint16]struct{}{554: {}}})}, d.samples)
+}
+
+func TestFitSaveLoadRun(t *testing.T) {
+	m := New()
+	require.NoError(t, m.Fit(traffic(rand.New(rand.NewSource(1)), start, 2*time.Hour)))
+	_, trained := m.Devices()
+	require.Equal(t, 2, trained)
+	assert.Error(t, New().Fit(traffic(rand.New(rand.NewSource(1)), start, time.Minute)), "no complete interval")
+
+	saved, err := m.Save()
+	require.NoError(t, err)
+	loaded := New()
+	require.NoError(t, loaded.Load(saved))
+	tracked, trained := loaded.Devices()
+	assert.Equal(t, 2, tracked)
+	assert.Equal(t, 2, trained)
+	assert.Error(t, loaded.Load([]byte(`{"02:00:00:00:00:10": "AAAA"}`)))
+
+	in := make(chan guardio.Packet, 200)
+	live := start.Add(3 * time.Hour)
+	for i := 0; i < 100; i++ {
+		in <- packet(live.Add(time.Duration(i)*500*time.Millisecond), thermostatMAC, thermostat, nvrMAC, nvr, 554, 1200)
+	}
+	in <- packet(live.Add(2*time.Minute), thermostatMAC, thermostat, nil, cloud, 443, 200)
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, loaded.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, thermostatMAC.String(), (<-out).Entity)
+}
@@ -0,0 +1,442 @@
+// Package dns detects DNS tunneling and domain generation algorithms
+// (DGA). It tracks, for every client, the queries it sent and the
+// responses it received in a sliding window. Each query is scored on the
+// mean character entropy of the names queried, the mean length of their
+// longest label, the share of responses that were NXDOMAIN, the number of
+// unique subdomains queried under the queried domain and the number of
+// unique domains queried. Tunnels encode data in long, random-looking
+// subdomains of one domain; DGA malware looks up many random domains, most
+// of which do not exist. Only clients that sent enough queries, and queried
+// enough unique names under one domain or enough unique domains, within
+// the window can raise an alert.
+//
+// Names at or under an allowlisted domain are ignored. Reverse lookups
+// and multicast DNS are allowlisted by default; add the domains of
+// services that legitimately encode data in names, such as antivirus
+// reputation lookups, with WithAllowlist.
+//
+// Typical use:
+//
+//	p := dns.New(dns.WithAllowlist("sophosxl.net"))
+//	if err := p.Fit(baseline); err != nil { ... }
+//	messages, _ := reader.StreamDNS(ctx) // a pcap.Reader
+//	go p.Run(ctx, messages, alerts)
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "dns"
+
+// FeatureNames names the features scored, in vector order. Entropy is in
+// bits per character, lengths in characters.
+var FeatureNames = []string{"query_entropy", "label_length", "nxdomain_ratio", "unique_subdomains", "unique_domains"}
+
+// DefaultAllowlist holds the domains ignored by every Profile: reverse
+// lookups and multicast DNS.
+var DefaultAllowlist = []string{"in-addr.arpa", "ip6.arpa", "local"}
+
+// Profile detects clients tunneling through DNS or running a DGA. It is
+// safe for concurrent use.
+type Profile struct {
+	window     time.Duration
+	cooldown   time.Duration
+	minQueries int
+	minUnique  int
+	maxClients int
+	allowlist  []string
+	detector   detectors.Detector
+
+	mu        sync.Mutex
+	trained   bool
+	clients   map[netip.Addr]*client
+	lastSweep time.Time
+}
+
+// client is the recent DNS activity of one client address.
+type client struct {
+	entropy    *profiles.SumWindow // one value per query, so Count is the queries
+	lengths    *profiles.SumWindow
+	nxdomains  *profiles.SumWindow // one value per response, 1 for NXDOMAIN
+	domains    *profiles.DistinctWindow[string]
+	subdomains map[string]*profiles.DistinctWindow[string]
+	first      time.Time // first query of the current burst of activity
+	last       time.Time
+	alerted    time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWindow sets the sliding window over which queries are counted.
+// Defaults to five minutes.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithCooldown sets how long a client that raised an alert stays quiet
+// before it can raise another. Defaults to 15 minutes.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMinQueries sets how many queries a client must send within the
+// window before it can raise an alert, however unusual its score.
+// Defaults to 20.
+func WithMinQueries(n int) Option {
+	return func(p *Profile) {
+		p.minQueries = n
+	}
+}
+
+// WithMinUnique sets how many unique names under one domain, or unique
+// domains, a client must query within the window before it can raise an
+// alert, however unusual its score. Defaults to 20.
+func WithMinUnique(n int) Option {
+	return func(p *Profile) {
+		p.minUnique = n
+	}
+}
+
+// WithMaxClients bounds the number of clients tracked at once. When a new
+// client would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxClients(n int) Option {
+	return func(p *Profile) {
+		p.maxClients = n
+	}
+}
+
+// WithAllowlist adds domains whose names, and the names under them, are
+// ignored, in addition to DefaultAllowlist. Repeated options accumulate.
+func WithAllowlist(domains ...string) Option {
+	return func(p *Profile) {
+		for _, d := range domains {
+			if d = normalize(d); d != "" {
+				p.allowlist = append(p.allowlist, d)
+			}
+		}
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		window:     5 * time.Minute,
+		cooldown:   15 * time.Minute,
+		minQueries: 20,
+		minUnique:  20,
+		maxClients: 100000,
+		allowlist:  append([]string(nil), DefaultAllowlist...),
+		clients:    make(map[netip.Addr]*client),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// The minimum unique counts keep ordinary clients quiet;
+			// flag the rarest 1% of the baseline's query patterns.
+			iforest.WithContamination(0.01),
+			// Baselines often lack NXDOMAIN responses.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.window = max(p.window, time.Second)
+	p.maxClients = max(p.maxClients, 1)
+	return p
+}
+
+// Fit trains the detector on the query patterns of messages, a baseline
+// of normal DNS traffic in capture order. As during detection, only the
+// queries of clients that reached the minimum count are scored.
+func (p *Profile) Fit(messages []guardio.DNSMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, m := range messages {
+		if features, c, _, ok := p.track(m); ok && c.entropy.Count() >= p.minQueries {
+			vectors = append(vectors, features)
+		}
+	}
+	p.clients = make(map[netip.Addr]*client)
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("dns: baseline has %d queries from clients with at least %d queries in the window, need at least 2", len(vectors), p.minQueries)
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("dns: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks m and returns an alert if it reveals tunneling or a DGA.
+func (p *Profile) Observe(m guardio.DNSMessage) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, c, domain, ok := p.track(m)
+	if !ok || c.entropy.Count() < p.minQueries || max(features[3], features[4]) < float64(p.minUnique) {
+		return profiles.Alert{}, false, nil
+	}
+	if !c.alerted.IsZero() && m.Time.Sub(c.alerted) < p.cooldown {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("dns: %w", err)
+	}
+	if score < detectors.ThresholdOf(p.detector) {
+		return profiles.Alert{}, false, nil
+	}
+	c.alerted = m.Time
+
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   m.Client.String(),
+		Start:    c.first,
+		Time:     m.Time,
+		Score:    score,
+		Features: map[string]float64{"queries": float64(c.entropy.Count())},
+		Message: fmt.Sprintf("%s queried %d names under %s and %d domains in %s (%.1f bits per character, %.0f%% NXDOMAIN)",
+			m.Client, int(features[3]), domain, int(features[4]), m.Time.Sub(c.first).Round(time.Second), features[0], 100*features[2]),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the messages from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.DNSMessage, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector. The allowlist is configuration
+// and is not saved.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("dns: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Clients returns the number of clients currently tracked.
+func (p *Profile) Clients() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.clients)
+}
+
+// Allowed reports whether name is at or under an allowlisted domain.
+func (p *Profile) Allowed(name string) bool {
+	name = normalize(name)
+	for _, d := range p.allowlist {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// track records m and, if it is a query, returns its client's features
+// and the base domain queried. The caller holds p.mu.
+func (p *Profile) track(m guardio.DNSMessage) ([]float64, *client, string, bool) {
+	if !m.Client.IsValid() || p.Allowed(m.Name) {
+		return nil, nil, "", false
+	}
+	p.sweep(m.Time)
+
+	c := p.clients[m.Client]
+	if c == nil {
+		c = p.newClient(m.Client)
+	}
+	if m.Time.Sub(c.last) > p.window {
+		c.first = m.Time
+	}
+	c.last = m.Time
+
+	if m.Response {
+		var nx float64
+		if m.NXDomain() {
+			nx = 1
+		}
+		c.nxdomains.Add(m.Time, nx)
+		return nil, nil, "", false
+	}
+
+	name := normalize(m.Name)
+	domain, stem := split(name)
+	c.entropy.Add(m.Time, entropy(stem))
+	c.lengths.Add(m.Time, float64(longestLabel(name)))
+	c.domains.Add(m.Time, domain)
+	subs := c.subdomains[domain]
+	if subs == nil {
+		subs = profiles.NewDistinctWindow[string](p.window)
+		c.subdomains[domain] = subs
+	}
+	subs.Add(m.Time, name)
+	c.nxdomains.Expire(m.Time)
+
+	n := float64(c.entropy.Count())
+	var nxRatio float64
+	if r := c.nxdomains.Count(); r > 0 {
+		nxRatio = c.nxdomains.Sum() / float64(r)
+	}
+	features := []float64{
+		c.entropy.Sum() / n,
+		c.lengths.Sum() / n,
+		nxRatio,
+		float64(subs.Len()),
+		float64(c.domains.Len()),
+	}
+	return features, c, domain, true
+}
+
+// newClient starts tracking addr, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) newClient(addr netip.Addr) *client {
+	if len(p.clients) >= p.maxClients {
+		var oldest netip.Addr
+		var oldestTime time.Time
+		for a, c := range p.clients {
+			if !oldest.IsValid() || c.last.Before(oldestTime) {
+				oldest, oldestTime = a, c.last
+			}
+		}
+		delete(p.clients, oldest)
+	}
+	c := &client{
+		entropy:    profiles.NewSumWindow(p.window),
+		lengths:    profiles.NewSumWindow(p.window),
+		nxdomains:  profiles.NewSumWindow(p.window),
+		domains:    profiles.NewDistinctWindow[string](p.window),
+		subdomains: make(map[string]*profiles.DistinctWindow[string]),
+	}
+	p.clients[addr] = c
+	return c
+}
+
+// sweep forgets clients idle for longer than the window and past their
+// cooldown, and the domains active clients no longer query, at most once
+// per window. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	for addr, c := range p.clients {
+		if now.Sub(c.last) > p.window && now.Sub(c.alerted) >= p.cooldown {
+			delete(p.clients, addr)
+			continue
+		}
+		for domain, subs := range c.subdomains {
+			if subs.Expire(now); subs.Len() == 0 {
+				delete(c.subdomains, domain)
+			}
+		}
+	}
+}
+
+// secondLevel holds the labels that, under a two-letter country code,
+// usually form part of the public suffix, as in example.co.uk.
+var secondLevel = map[string]bool{"ac": true, "co": true, "com": true, "edu": true, "gov": true, "net": true, "org": true}
+
+// split returns the base domain of name, the label under its public
+// suffix followed by the suffix, and the stem, name without the suffix
+// and the dots. The public suffix is taken to be the top-level domain, or
+// two labels for the common second-level registries of country codes.
+func split(name string) (domain, stem string) {
+	labels := strings.Split(name, ".")
+	suffix := 1
+	if n := len(labels); n >= 3 && len(labels[n-1]) == 2 && secondLevel[labels[n-2]] {
+		suffix = 2
+	}
+	base := max(len(labels)-suffix-1, 0)
+	keep := max(len(labels)-suffix, 1)
+	return strings.Join(labels[base:], "."), strings.Join(labels[:keep], "")
+}
+
+// entropy returns the Shannon entropy of the characters of s in bits.
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			f := float64(c) / n
+			h -= f * math.Log2(f)
+		}
+	}
+	return h
+}
+
+// longestLabel returns the length of the longest label of name.
+func longestLabel(name string) int {
+	longest := 0
+	for _, label := range strings.Split(name, ".") {
+		longest = max(longest, len(label))
+	}
+	return longest
+}
+
+// normalize returns name in lower case without the trailing dot.
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start    = time.Unix(1700000000, 0)
+	resolver = netip.AddrFrom4([4]byte{10, 0, 0, 53})
+	infected = netip.AddrFrom4([4]byte{10, 0, 0, 66})
+)
+
+var (
+	domains = []string{
+		"google.com", "github.com", "bbc.co.uk", "slack.com", "microsoft.com", "office.net",
+		"amazonaws.com", "wikipedia.org", "cloudflare.net", "apple.com", "zoom.us", "example.org",
+	}
+	hosts = []string{"www", "api", "cdn", "mail", "static", "images", "login", "updates"}
+)
+
+// exchange returns a query for name from src and its response.
+func exchange(t time.Time, src netip.Addr, name string, nx bool) []guardio.DNSMessage {
+	q := guardio.DNSMessage{Time: t, Client: src, Server: resolver, Name: name, Type: 1}
+	r := q
+	r.Time, r.Response, r.Answers = t.Add(20*time.Millisecond), true, 1
+	if nx {
+		r.RCode, r.Answers = guardio.RCodeNXDomain, 0
+	}
+	return []guardio.DNSMessage{q, r}
+}
+
+// normal returns d of lookups from 20 clients of a few hosts of popular
+// domains, with the odd typo.
+func normal(rng *rand.Rand, from time.Time, d time.Duration) []guardio.DNSMessage {
+	var messages []guardio.DNSMessage
+	for c := 0; c < 20; c++ {
+		src := netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + c)})
+		for t := from.Add(time.Duration(rng.Intn(5000)) * time.Millisecond); t.Before(from.Add(d)); t = t.Add(time.Duration(rng.ExpFloat64()*5000) * time.Millisecond) {
+			name := hosts[rng.Intn(len(hosts))] + "." + domains[rng.Intn(len(domains))]
+			nx := rng.Intn(100) == 0
+			if nx {
+				name = "ww." + domains[rng.Intn(len(domains))]
+			}
+			messages = append(messages, exchange(t, src, name, nx)...)
+		}
+	}
+	sortByTime(messages)
+	return messages
+}
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+func random(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// tunnel returns data sent by infected in base32 labels under
+// t.exfil.example, two queries a second.
+func tunnel(rng *rand.Rand, from time.Time, d time.Duration, domain string) []guardio.DNSMessage {
+	var messages []guardio.DNSMessage
+	for i, t := 0, from; t.Before(from.Add(d)); i, t = i+1, t.Add(500*time.Millisecond) {
+		name := fmt.Sprintf("%s.%d.t.%s", random(rng, 50), i, domain)
+		messages = append(messages, exchange(t, infected, name, false)...)
+	}
+	return messages
+}
+
+// dga returns lookups by infected of generated domains, one every two
+// seconds, nearly all of them unregistered.
+func dga(rng *rand.Rand, from time.Time, d time.Duration) []guardio.DNSMessage {
+	var messages []guardio.DNSMessage
+	for t := from; t.Before(from.Add(d)); t = t.Add(2 * time.Second) {
+		name := random(rng, 12+rng.Intn(5)) + []string{".com", ".net", ".info"}[rng.Intn(3)]
+		messages = append(messages, exchange(t, infected, name, rng.Intn(20) != 0)...)
+	}
+	return messages
+}
+
+func sortByTime(messages []guardio.DNSMessage) {
+	slices.SortStableFunc(messages, func(a, b guardio.DNSMessage) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(normal(rand.New(rand.NewSource(1)), start, time.Hour)))
+	assert.Zero(t, p.Clients(), "training state is not carried into detection")
+	return p
+}
+
+func observeAll(t *testing.T, p *Profile, messages []guardio.DNSMessage) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, m := range messages {
+		alert, raised, err := p.Observe(m)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestTunneling(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(2))
+	live := start.Add(24 * time.Hour)
+
+	messages := append(normal(rng, live, 30*time.Minute), tunnel(rng, live.Add(5*time.Minute), 5*time.Minute, "exfil.example")...)
+	sortByTime(messages)
+	alerts := observeAll(t, p, messages)
+
+	require.Len(t, alerts, 1, "one alert within the cooldown")
+	a := alerts[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, infected.String(), a.Entity)
+	assert.Equal(t, live.Add(5*time.Minute), a.Start)
+	assert.Greater(t, a.Features["query_entropy"], 4.0)
+	assert.Greater(t, a.Features["label_length"], 40.0)
+	assert.GreaterOrEqual(t, a.Features["unique_subdomains"], 20.0)
+	assert.Equal(t, 1.0, a.Features["unique_domains"])
+	assert.Contains(t, a.Message, "under exfil.example")
+}
+
+func TestDGA(t *testing.T) {
+	p := trained(t)
+	rng := rand.New(rand.NewSource(3))
+	live := start.Add(24 * time.Hour)
+
+	messages := append(normal(rng, live, 30*time.Minute), dga(rng, live.Add(5*time.Minute), 5*time.Minute)...)
+	sortByTime(messages)
+	alerts := observeAll(t, p, messages)
+
+	require.Len(t, alerts, 1)
+	a := alerts[0]
+	assert.Equal(t, infected.String(), a.Entity)
+	assert.Greater(t, a.Features["nxdomain_ratio"], 0.5)
+	assert.GreaterOrEqual(t, a.Features["unique_domains"], 20.0)
+	assert.Equal(t, 1.0, a.Features["unique_subdomains"])
+}
+
+func TestAllowlist(t *testing.T) {
+	p := trained(t, WithAllowlist("Exfil.Example."))
+	rng := rand.New(rand.NewSource(4))
+	live := start.Add(24 * time.Hour)
+
+	messages := append(normal(rng, live, 15*time.Minute), tunnel(rng, live, 5*time.Minute, "exfil.example")...)
+	sortByTime(messages)
+	assert.Empty(t, observeAll(t, p, messages))
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "exfil.example", want: true},
+		{name: "a.b.EXFIL.example.", want: true},
+		{name: "notexfil.example"},
+		{name: "exfil.example.com"},
+		{name: "4.3.2.10.in-addr.arpa", want: true},
+		{name: "printer.local", want: true},
+		{name: "www.google.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Allowed(tt.name))
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		stem   string
+	}{
+		{name: "www.google.com", domain: "google.com", stem: "wwwgoogle"},
+		{name: "google.com", domain: "google.com", stem: "google"},
+		{name: "news.bbc.co.uk", domain: "bbc.co.uk", stem: "newsbbc"},
+		{name: "co.uk", domain: "co.uk", stem: "co"},
+		{name: "a.b.example.de", domain: "example.de", stem: "abexample"},
+		{name: "wpad", domain: "wpad", stem: "wpad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, stem := split(tt.name)
+			assert.Equal(t, tt.domain, domain)
+			assert.Equal(t, tt.stem, stem)
+		})
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{s: "", want: 0},
+		{s: "aaaa", want: 0},
+		{s: "abab", want: 1},
+		{s: "abcd", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			assert.InDelta(t, tt.want, entropy(tt.s), 1e-12)
+		})
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	p := New(WithMaxClients(3))
+	for i := 0; i < 10; i++ {
+		p.track(guardio.DNSMessage{Time: start, Client: netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}), Name: "www.google.com"})
+	}
+	assert.Equal(t, 3, p.Clients())
+
+	p.track(guardio.DNSMessage{Time: start.Add(time.Hour), Client: infected, Name: "www.google.com"})
+	assert.Equal(t, 1, p.Clients(), "idle clients are forgotten")
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(guardio.DNSMessage{Time: start, Client: infected, Name: "www.google.com"})
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	assert.Error(t, p.Fit(exchange(start, infected, "www.google.com", false)), "too little baseline")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	messages := tunnel(rand.New(rand.NewSource(5)), start, 2*time.Minute, "exfil.example")
+	in := make(chan guardio.DNSMessage, len(messages))
+	for _, m := range messages {
+		in <- m
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, infected.String(), (<-out).Entity)
+}
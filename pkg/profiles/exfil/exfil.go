@@ -0,0 +1,522 @@
+// Package exfil detects data exfiltration: internal hosts sending data out
+// of the network in volumes, to destinations or at times they normally do
+// not. It tracks, for every internal host, the payload bytes it exchanged
+// with external addresses in a sliding window, one hour by default. Once a
+// host sent enough bytes within the window, its outbound traffic is scored,
+// at most once per burst span, on the share of the bytes exchanged that it
+// sent (the asymmetry of uploads), the bytes sent, the share of them sent
+// to destinations it first contacted recently, the share sent outside
+// business hours and the bytes sent in the last burst span. Byte counts are
+// scored on a log scale.
+//
+// Slow exfiltration stays under any burst threshold but shows in the
+// asymmetry, novelty and timing of a host's traffic over the window;
+// smash-and-grab uploads show in the burst. Alerts are graded by how far
+// their score exceeds the threshold, see profiles.SeverityMap.
+//
+// Fit keeps the destinations every host contacted in the baseline, so
+// they are not new during detection. Save and Load only carry the
+// detector: a loaded profile learns destinations afresh.
+//
+// Typical use:
+//
+//	p := exfil.New(exfil.WithBusinessHours(7, 19, loc))
+//	if err := p.Fit(baseline); err != nil { ... }
+//	packets, _ := reader.StreamPackets(ctx) // a pcap.Reader
+//	go p.Run(ctx, packets, alerts)
+package exfil
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "exfil"
+
+// FeatureNames names the features scored, in vector order. Byte counts
+// are log10(1+bytes). Alerts also report the bytes sent in the window as
+// "bytes" and the new destinations they went to as "new_destinations".
+var FeatureNames = []string{"upload_ratio", "bytes_out", "novel_ratio", "after_hours_ratio", "upload_burst"}
+
+// Profile detects exfiltrating hosts. It is safe for concurrent use.
+type Profile struct {
+	window          time.Duration
+	burst           time.Duration
+	novelty         time.Duration
+	history         time.Duration
+	cooldown        time.Duration
+	minBytes        int
+	maxHosts        int
+	maxDestinations int
+	internal        []netip.Prefix
+	openHour        int
+	closeHour       int
+	loc             *time.Location
+	severity        profiles.SeverityMap
+	detector        detectors.Detector
+
+	mu        sync.Mutex
+	trained   bool
+	hosts     map[netip.Addr]*host
+	lastSweep time.Time
+}
+
+// host is the recent external traffic of one internal address.
+type host struct {
+	out          *profiles.SumWindow
+	in           *profiles.SumWindow
+	novel        *profiles.SumWindow                  // bytes out to new destinations
+	afterHours   *profiles.SumWindow                  // bytes out after hours
+	burst        *profiles.SumWindow                  // bytes out in the last burst span
+	fresh        *profiles.DistinctWindow[netip.Addr] // new destinations
+	destinations map[netip.Addr]*contact
+	first        time.Time // first packet of the current burst of activity
+	last         time.Time
+	scored       time.Time
+	alerted      time.Time
+}
+
+// contact is when a host first and last sent data to a destination.
+type contact struct {
+	first time.Time
+	last  time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWindow sets the sliding window over which traffic is counted.
+// Defaults to one hour.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithBurst sets the span of the upload burst feature, which is also the
+// shortest time between two scores of a host. Defaults to one minute.
+func WithBurst(d time.Duration) Option {
+	return func(p *Profile) {
+		p.burst = d
+	}
+}
+
+// WithNovelty sets how long a destination counts as new after a host
+// first sent data to it. Defaults to 24 hours.
+func WithNovelty(d time.Duration) Option {
+	return func(p *Profile) {
+		p.novelty = d
+	}
+}
+
+// WithHistory sets how long a host remembers a destination it no longer
+// sends data to; it is new again afterwards. Defaults to 30 days.
+func WithHistory(d time.Duration) Option {
+	return func(p *Profile) {
+		p.history = d
+	}
+}
+
+// WithCooldown sets how long a host that raised an alert stays quiet
+// before it can raise another. Defaults to one hour.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMinBytes sets how many payload bytes a host must send out within
+// the window before it is scored. Defaults to 1 MiB.
+func WithMinBytes(n int) Option {
+	return func(p *Profile) {
+		p.minBytes = n
+	}
+}
+
+// WithMaxHosts bounds the number of internal hosts tracked at once. When
+// a new host would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxHosts(n int) Option {
+	return func(p *Profile) {
+		p.maxHosts = n
+	}
+}
+
+// WithMaxDestinations bounds the destinations remembered per host, like
+// WithMaxHosts. Defaults to 10000.
+func WithMaxDestinations(n int) Option {
+	return func(p *Profile) {
+		p.maxDestinations = n
+	}
+}
+
+// WithInternal sets the networks of internal hosts. Repeated options
+// accumulate. Defaults to the private address ranges.
+func WithInternal(prefixes ...netip.Prefix) Option {
+	return func(p *Profile) {
+		for _, prefix := range prefixes {
+			p.internal = append(p.internal, prefix.Masked())
+		}
+	}
+}
+
+// WithBusinessHours sets the business hours, from the open hour up to the
+// close hour on weekdays in loc; other times are after hours. Defaults to
+// 8 to 18 in time.Local.
+func WithBusinessHours(open, close int, loc *time.Location) Option {
+	return func(p *Profile) {
+		p.openHour, p.closeHour, p.loc = open, close, loc
+	}
+}
+
+// WithSeverity sets how alerts are graded. Defaults to
+// profiles.DefaultSeverityMap.
+func WithSeverity(m profiles.SeverityMap) Option {
+	return func(p *Profile) {
+		p.severity = m
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		window:          time.Hour,
+		burst:           time.Minute,
+		novelty:         24 * time.Hour,
+		history:         30 * 24 * time.Hour,
+		cooldown:        time.Hour,
+		minBytes:        1 << 20,
+		maxHosts:        100000,
+		maxDestinations: 10000,
+		openHour:        8,
+		closeHour:       18,
+		loc:             time.Local,
+		severity:        profiles.DefaultSeverityMap,
+		hosts:           make(map[netip.Addr]*host),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// The minimum bytes keep light users quiet; flag the rarest
+			// 1% of the baseline's upload patterns.
+			iforest.WithContamination(0.01),
+			// Baselines taken during business hours have no after-hours
+			// traffic; train on a week to score it.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	if p.loc == nil {
+		p.loc = time.Local
+	}
+	p.window = max(p.window, time.Second)
+	p.burst = min(max(p.burst, time.Millisecond), p.window)
+	p.history = max(p.history, p.novelty, p.window)
+	p.maxHosts = max(p.maxHosts, 1)
+	p.maxDestinations = max(p.maxDestinations, 1)
+	return p
+}
+
+// Fit trains the detector on the upload patterns of packets, a baseline
+// of normal traffic in capture order. As during detection, only hosts
+// that sent the minimum bytes are scored. Every destination is new in the
+// first novelty span of the baseline, so it only teaches destinations:
+// the baseline must be longer than that.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		features, _, ok := p.track(pkt)
+		if ok && pkt.Time.Sub(packets[0].Time) >= p.novelty {
+			vectors = append(vectors, features)
+		}
+	}
+	// Keep what the hosts contacted, not their windows.
+	for addr, h := range p.hosts {
+		reset := p.newHost()
+		reset.destinations = h.destinations
+		p.hosts[addr] = reset
+	}
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("exfil: baseline has %d scores of hosts sending at least %d bytes in the window after the first %s, need at least 2", len(vectors), p.minBytes, p.novelty)
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("exfil: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks pkt and returns an alert if the upload it belongs to
+// looks like exfiltration.
+func (p *Profile) Observe(pkt guardio.Packet) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, h, ok := p.track(pkt)
+	if !ok || (!h.alerted.IsZero() && pkt.Time.Sub(h.alerted) < p.cooldown) {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("exfil: %w", err)
+	}
+	threshold := detectors.ThresholdOf(p.detector)
+	if score < threshold {
+		return profiles.Alert{}, false, nil
+	}
+	h.alerted = pkt.Time
+
+	sent := h.out.Sum()
+	span := min(pkt.Time.Sub(h.first), p.window)
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   pkt.Src.String(),
+		Start:    h.first,
+		Time:     pkt.Time,
+		Score:    score,
+		Severity: p.severity.Grade(score, threshold),
+		Features: map[string]float64{"bytes": sent, "new_destinations": float64(h.fresh.Len())},
+		Message: fmt.Sprintf("%s sent %s out in %s, %.0f%% to %d new destinations and %.0f%% after hours (%.0f%% of its traffic outbound)",
+			pkt.Src, formatBytes(sent), span.Round(time.Second), 100*features[2], h.fresh.Len(), 100*features[3], 100*features[0]),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector. Destinations are not saved.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("exfil: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Hosts returns the number of internal hosts currently tracked.
+func (p *Profile) Hosts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.hosts)
+}
+
+// Internal reports whether addr belongs to an internal host.
+func (p *Profile) Internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if len(p.internal) == 0 {
+		return addr.IsPrivate()
+	}
+	for _, prefix := range p.internal {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AfterHours reports whether t is outside business hours.
+func (p *Profile) AfterHours(t time.Time) bool {
+	t = t.In(p.loc)
+	if day := t.Weekday(); day == time.Saturday || day == time.Sunday {
+		return true
+	}
+	return t.Hour() < p.openHour || t.Hour() >= p.closeHour
+}
+
+// track records pkt and, if it is an upload due for scoring, returns the
+// features of its host. The caller holds p.mu.
+func (p *Profile) track(pkt guardio.Packet) ([]float64, *host, bool) {
+	srcIn, dstIn := p.Internal(pkt.Src), p.Internal(pkt.Dst)
+	if srcIn == dstIn || pkt.Payload <= 0 {
+		return nil, nil, false
+	}
+	p.sweep(pkt.Time)
+
+	addr := pkt.Src
+	if dstIn {
+		addr = pkt.Dst
+	}
+	h := p.hosts[addr]
+	if h == nil {
+		h = p.addHost(addr)
+	}
+	if pkt.Time.Sub(h.last) > p.window || h.first.IsZero() {
+		h.first = pkt.Time
+	}
+	h.last = pkt.Time
+	if dstIn {
+		h.in.Add(pkt.Time, float64(pkt.Payload))
+		return nil, nil, false
+	}
+
+	bytes := float64(pkt.Payload)
+	c := h.destinations[pkt.Dst]
+	if c == nil {
+		c = p.addContact(h, pkt.Dst, pkt.Time)
+	}
+	c.last = pkt.Time
+	var novel, afterHours float64
+	if pkt.Time.Sub(c.first) < p.novelty {
+		novel = bytes
+		h.fresh.Add(pkt.Time, pkt.Dst)
+	} else {
+		h.fresh.Expire(pkt.Time)
+	}
+	if p.AfterHours(pkt.Time) {
+		afterHours = bytes
+	}
+	h.out.Add(pkt.Time, bytes)
+	h.novel.Add(pkt.Time, novel)
+	h.afterHours.Add(pkt.Time, afterHours)
+	h.burst.Add(pkt.Time, bytes)
+	h.in.Expire(pkt.Time)
+
+	sent := h.out.Sum()
+	if sent < float64(p.minBytes) || pkt.Time.Sub(h.scored) < p.burst {
+		return nil, nil, false
+	}
+	h.scored = pkt.Time
+
+	features := []float64{
+		sent / (sent + h.in.Sum()),
+		math.Log10(1 + sent),
+		h.novel.Sum() / sent,
+		h.afterHours.Sum() / sent,
+		math.Log10(1 + h.burst.Sum()),
+	}
+	return features, h, true
+}
+
+// newHost returns a host with empty windows.
+func (p *Profile) newHost() *host {
+	return &host{
+		out:          profiles.NewSumWindow(p.window),
+		in:           profiles.NewSumWindow(p.window),
+		novel:        profiles.NewSumWindow(p.window),
+		afterHours:   profiles.NewSumWindow(p.window),
+		burst:        profiles.NewSumWindow(p.burst),
+		fresh:        profiles.NewDistinctWindow[netip.Addr](p.window),
+		destinations: make(map[netip.Addr]*contact),
+	}
+}
+
+// addHost starts tracking addr, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) addHost(addr netip.Addr) *host {
+	if len(p.hosts) >= p.maxHosts {
+		var oldest netip.Addr
+		var oldestTime time.Time
+		for a, h := range p.hosts {
+			if !oldest.IsValid() || h.last.Before(oldestTime) {
+				oldest, oldestTime = a, h.last
+			}
+		}
+		delete(p.hosts, oldest)
+	}
+	h := p.newHost()
+	p.hosts[addr] = h
+	return h
+}
+
+// addContact remembers that h first sent data to dst at t, first
+// forgetting the destination it sent data to least recently if the limit
+// is reached.
+func (p *Profile) addContact(h *host, dst netip.Addr, t time.Time) *contact {
+	if len(h.destinations) >= p.maxDestinations {
+		var oldest netip.Addr
+		var oldestTime time.Time
+		for a, c := range h.destinations {
+			if !oldest.IsValid() || c.last.Before(oldestTime) {
+				oldest, oldestTime = a, c.last
+			}
+		}
+		delete(h.destinations, oldest)
+	}
+	c := &contact{first: t, last: t}
+	h.destinations[dst] = c
+	return c
+}
+
+// sweep forgets destinations hosts have not sent data to within the
+// history, and hosts left with none that are idle and past their
+// cooldown, at most once per window. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	for addr, h := range p.hosts {
+		for dst, c := range h.destinations {
+			if now.Sub(c.last) > p.history {
+				delete(h.destinations, dst)
+			}
+		}
+		if len(h.destinations) == 0 && now.Sub(h.last) > p.window && now.Sub(h.alerted) >= p.cooldown {
+			delete(p.hosts, addr)
+		}
+	}
+}
+
+// formatBytes formats n bytes with a decimal unit, such as 12.3 MB.
+func formatBytes(n float64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	i := 0
+	for ; n >= 1000 && i < len(units)-1; i++ {
+		n /= 1000
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
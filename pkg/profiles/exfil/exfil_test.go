@@ -0,0 +1,296 @@
+package exfil
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	monday   = time.Date(2024, time.January, 8, 0, 0, 0, 0, time.UTC)
+	insider  = netip.AddrFrom4([4]byte{10, 0, 0, 66})
+	conf     = netip.AddrFrom4([4]byte{52, 0, 0, 1})
+	cloud    = netip.AddrFrom4([4]byte{52, 0, 0, 2})
+	backup   = netip.AddrFrom4([4]byte{52, 0, 0, 3})
+	dropzone = netip.AddrFrom4([4]byte{45, 9, 9, 9})
+	sites    = []netip.Addr{
+		netip.AddrFrom4([4]byte{93, 184, 216, 34}), netip.AddrFrom4([4]byte{140, 82, 112, 3}),
+		netip.AddrFrom4([4]byte{151, 101, 1, 69}), netip.AddrFrom4([4]byte{104, 16, 0, 1}),
+		netip.AddrFrom4([4]byte{142, 250, 0, 1}), netip.AddrFrom4([4]byte{13, 107, 0, 1}),
+	}
+)
+
+func workstation(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + i)})
+}
+
+func server(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{10, 0, 1, byte(1 + i)})
+}
+
+func packet(t time.Time, src, dst netip.Addr, payload int) guardio.Packet {
+	return guardio.Packet{Time: t, Src: src, Dst: dst, DstPort: 443, Protocol: guardio.ProtoTCP, Length: payload + 40, Payload: payload}
+}
+
+// transfer returns d of traffic from src to dst, a packet each way every
+// step, sending up bytes and receiving down bytes per step.
+func transfer(from time.Time, d, step time.Duration, src, dst netip.Addr, up, down int) []guardio.Packet {
+	var packets []guardio.Packet
+	for t := from; t.Before(from.Add(d)); t = t.Add(step) {
+		packets = append(packets, packet(t, src, dst, up))
+		if down > 0 {
+			packets = append(packets, packet(t.Add(step/2), dst, src, down))
+		}
+	}
+	return packets
+}
+
+// day returns a working day of 20 workstations browsing, a few video calls,
+// one in the evening, uploads to the cloud, a file shared with a
+// destination new to its sender, and the nightly backups of four servers.
+func day(rng *rand.Rand, date time.Time) []guardio.Packet {
+	var packets []guardio.Packet
+	open := date.Add(8 * time.Hour)
+	call := func(i int, start time.Time) {
+		packets = append(packets, transfer(start, time.Duration(30+rng.Intn(30))*time.Minute, time.Second, workstation(i), conf, 80000+rng.Intn(40000), 80000+rng.Intn(40000))...)
+	}
+	for i := 0; i < 20; i++ {
+		for t := open.Add(time.Duration(rng.Intn(600)) * time.Second); t.Before(open.Add(10 * time.Hour)); t = t.Add(time.Duration(10+rng.Intn(50)) * time.Second) {
+			site := sites[rng.Intn(len(sites))]
+			packets = append(packets, packet(t, workstation(i), site, 500+rng.Intn(2000)), packet(t.Add(time.Second), site, workstation(i), 20000+rng.Intn(80000)))
+		}
+	}
+	for _, i := range rng.Perm(20)[:5] {
+		call(i, open.Add(time.Duration(rng.Intn(8*3600))*time.Second))
+	}
+	call(rng.Intn(20), date.Add(19*time.Hour+time.Duration(rng.Intn(7200))*time.Second))
+	for _, i := range rng.Perm(20)[:3] {
+		start := open.Add(time.Duration(rng.Intn(9*3600)) * time.Second)
+		packets = append(packets, transfer(start, time.Duration(1+rng.Intn(3))*time.Minute, 2*time.Second, workstation(i), cloud, 1000000, 2000)...)
+	}
+	partner := netip.AddrFrom4([4]byte{34, byte(rng.Intn(256)), byte(rng.Intn(256)), 1})
+	start := open.Add(time.Duration(rng.Intn(9*3600)) * time.Second)
+	packets = append(packets, transfer(start, time.Duration(10+rng.Intn(20))*time.Second, time.Second, workstation(rng.Intn(20)), partner, 200000, 2000)...)
+	for i := 0; i < 4; i++ {
+		start := date.Add(time.Duration(1+i) * time.Hour)
+		packets = append(packets, transfer(start, time.Duration(5+rng.Intn(10))*time.Minute, time.Second, server(i), backup, 300000+rng.Intn(50000), 1000)...)
+	}
+	sortByTime(packets)
+	return packets
+}
+
+// allHands returns a call of every workstation, followed by an upload of
+// each to the cloud.
+func allHands(rng *rand.Rand, start time.Time) []guardio.Packet {
+	var packets []guardio.Packet
+	for i := 0; i < 20; i++ {
+		packets = append(packets, transfer(start, 30*time.Minute, time.Second, workstation(i), conf, 80000+rng.Intn(40000), 80000+rng.Intn(40000))...)
+		packets = append(packets, transfer(start.Add(time.Hour), time.Minute, 2*time.Second, workstation(i), cloud, 1000000, 2000)...)
+	}
+	return packets
+}
+
+// week returns days of work, starting with an all-hands call.
+func week(rng *rand.Rand, from time.Time, days int) []guardio.Packet {
+	packets := allHands(rng, from.Add(9*time.Hour))
+	for d := 0; d < days; d++ {
+		packets = append(packets, day(rng, from.AddDate(0, 0, d))...)
+	}
+	sortByTime(packets)
+	return packets
+}
+
+func sortByTime(packets []guardio.Packet) {
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(append([]Option{WithBusinessHours(8, 18, time.UTC)}, opts...)...)
+	require.NoError(t, p.Fit(week(rand.New(rand.NewSource(1)), monday, 4)))
+	return p
+}
+
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		alert, raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestSlowExfiltration(t *testing.T) {
+	p := trained(t)
+	live := monday.AddDate(0, 0, 7)
+
+	// 2 kB every two seconds from 21:00, about 3.5 MB an hour.
+	packets := append(day(rand.New(rand.NewSource(3)), live),
+		transfer(live.Add(21*time.Hour), 3*time.Hour, 2*time.Second, insider, dropzone, 2000, 0)...)
+	sortByTime(packets)
+	alerts := observeAll(t, p, packets)
+
+	var mine []profiles.Alert
+	for _, a := range alerts {
+		if a.Entity == insider.String() {
+			mine = append(mine, a)
+		}
+	}
+	require.NotEmpty(t, mine)
+	a := mine[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, live.Add(21*time.Hour), a.Start)
+	assert.Equal(t, 1.0, a.Features["upload_ratio"])
+	assert.Equal(t, 1.0, a.Features["novel_ratio"])
+	assert.Equal(t, 1.0, a.Features["after_hours_ratio"])
+	assert.Equal(t, 1.0, a.Features["new_destinations"])
+	assert.NotEmpty(t, a.Severity)
+	assert.Contains(t, a.Message, "to 1 new destinations")
+	assert.LessOrEqual(t, len(mine), 3, "one alert per cooldown")
+}
+
+func TestUploadBurst(t *testing.T) {
+	p := trained(t)
+	live := monday.AddDate(0, 0, 7)
+
+	// 600 MB in five minutes during business hours.
+	packets := transfer(live.Add(11*time.Hour), 5*time.Minute, time.Second, insider, dropzone, 2000000, 500)
+	alerts := observeAll(t, p, packets)
+
+	require.Len(t, alerts, 1)
+	a := alerts[0]
+	assert.Equal(t, insider.String(), a.Entity)
+	assert.Equal(t, live.Add(11*time.Hour), a.Start)
+	assert.Equal(t, 1.0, a.Features["novel_ratio"])
+	assert.Zero(t, a.Features["after_hours_ratio"])
+	assert.Contains(t, a.Message, "to 1 new destinations and 0% after hours")
+}
+
+func TestNovelty(t *testing.T) {
+	p := trained(t)
+	live := monday.AddDate(0, 0, 7)
+
+	// A server's nightly backup, and the same upload to a new destination.
+	peak := func(dst netip.Addr) float64 {
+		var peak float64
+		for _, pkt := range transfer(live.Add(2*time.Hour), 10*time.Minute, time.Second, server(1), dst, 320000, 1000) {
+			if features, _, ok := p.track(pkt); ok {
+				score, err := p.detector.PredictOne(features)
+				require.NoError(t, err)
+				peak = max(peak, score)
+			}
+		}
+		return peak
+	}
+	known := peak(backup)
+	p.hosts = make(map[netip.Addr]*host)
+	assert.Greater(t, peak(dropzone), known, "the backup destination was learned in the baseline")
+}
+
+func TestSeverity(t *testing.T) {
+	p := trained(t, WithSeverity(profiles.SeverityMap{Medium: -1, High: -1, Critical: -1}))
+	packets := transfer(monday.AddDate(0, 0, 7).Add(11*time.Hour), 5*time.Minute, time.Second, insider, dropzone, 2000000, 500)
+	alerts := observeAll(t, p, packets)
+	require.NotEmpty(t, alerts)
+	assert.Equal(t, profiles.SeverityCritical, alerts[0].Severity)
+}
+
+func TestInternal(t *testing.T) {
+	p := New()
+	assert.True(t, p.Internal(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, p.Internal(netip.MustParseAddr("::ffff:192.168.1.1")))
+	assert.True(t, p.Internal(netip.MustParseAddr("fd00::1")))
+	assert.False(t, p.Internal(netip.MustParseAddr("8.8.8.8")))
+
+	p = New(WithInternal(netip.MustParsePrefix("203.0.113.7/24")))
+	assert.True(t, p.Internal(netip.MustParseAddr("203.0.113.200")))
+	assert.False(t, p.Internal(netip.MustParseAddr("10.1.2.3")), "the networks replace the private ranges")
+}
+
+func TestAfterHours(t *testing.T) {
+	p := New(WithBusinessHours(8, 18, time.UTC))
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{t: monday.Add(7*time.Hour + 59*time.Minute), want: true},
+		{t: monday.Add(8 * time.Hour)},
+		{t: monday.Add(17*time.Hour + 59*time.Minute)},
+		{t: monday.Add(18 * time.Hour), want: true},
+		{t: monday.AddDate(0, 0, 5).Add(12 * time.Hour), want: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.AfterHours(tt.t), "%s", tt.t)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "999 B", formatBytes(999))
+	assert.Equal(t, "1.5 kB", formatBytes(1500))
+	assert.Equal(t, "3.5 MB", formatBytes(3.5e6))
+	assert.Equal(t, "2000.0 TB", formatBytes(2e15))
+}
+
+func TestMaxHosts(t *testing.T) {
+	p := New(WithMaxHosts(3), WithMaxDestinations(2))
+	for i := 0; i < 10; i++ {
+		p.track(packet(monday, workstation(i), sites[0], 100))
+	}
+	assert.Equal(t, 3, p.Hosts())
+
+	for _, site := range sites {
+		p.track(packet(monday, insider, site, 100))
+	}
+	assert.Len(t, p.hosts[insider].destinations, 2)
+
+	p.track(packet(monday.AddDate(0, 2, 0), insider, sites[0], 100))
+	assert.Equal(t, 1, p.Hosts(), "hosts without destinations in the history are forgotten")
+}
+
+func TestIgnoredTraffic(t *testing.T) {
+	p := New()
+	p.track(packet(monday, workstation(0), workstation(1), 1000))
+	p.track(packet(monday, sites[0], sites[1], 1000))
+	p.track(packet(monday, workstation(0), sites[0], 0))
+	assert.Zero(t, p.Hosts(), "internal, external and empty packets are ignored")
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(packet(monday, insider, dropzone, 1000))
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	assert.Error(t, p.Fit(day(rand.New(rand.NewSource(4)), monday)), "a day is shorter than the novelty span")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+	p := New(WithBusinessHours(8, 18, time.UTC))
+	require.NoError(t, p.Load(saved))
+
+	packets := transfer(monday.Add(11*time.Hour), 5*time.Minute, time.Second, insider, dropzone, 2000000, 500)
+	in := make(chan guardio.Packet, len(packets))
+	for _, pkt := range packets {
+		in <- pkt
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, insider.String(), (<-out).Entity)
+}
@@ -0,0 +1,459 @@
+// Package lateral detects lateral movement: internal hosts connecting to
+// other internal hosts they do not normally reach, especially over the
+// remote administration services attackers use to spread, SMB, RDP and
+// WinRM. Perimeter profiles miss such east-west traffic.
+//
+// The profile tracks the internal connection graph: for every internal
+// host, the internal peers it opened connections to (TCP SYNs without ACK
+// and UDP datagrams) and when it last did. Each attempt that reaches a
+// peer not yet reached in the sliding window, ten minutes by default, or
+// not yet over its service, is scored on whether the peer is new to the
+// host, the new peers and all peers reached in the window (its fan-out),
+// and the peers reached in the window over SMB, RDP and WinRM. Only hosts
+// that reached a few new peers within the window raise alerts: a single
+// new peer is common, a spree of them is not.
+//
+// Fit keeps the peers every host reached in the baseline, so they are not
+// new during detection. Save and Load only carry the detector: a loaded
+// profile learns peers afresh.
+//
+// Typical use:
+//
+//	p := lateral.New(lateral.WithInternal(corp))
+//	if err := p.Fit(baseline); err != nil { ... }
+//	packets, _ := reader.StreamPackets(ctx) // a pcap.Reader
+//	go p.Run(ctx, packets, alerts)
+package lateral
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "lateral"
+
+// FeatureNames names the features scored, in vector order. new_peer is 1
+// if the attempt reaches a peer new to the host, 0 otherwise; the others
+// count distinct peers in the window.
+var FeatureNames = []string{"new_peer", "new_peers", "fan_out", "smb_peers", "rdp_peers", "winrm_peers"}
+
+// Remote administration services, indexing host.services.
+const (
+	serviceSMB = iota
+	serviceRDP
+	serviceWinRM
+	numServices
+)
+
+// service returns the remote administration service listening on port,
+// and false for other ports.
+func service(port uint16) (int, bool) {
+	switch port {
+	case 139, 445:
+		return serviceSMB, true
+	case 3389:
+		return serviceRDP, true
+	case 5985, 5986:
+		return serviceWinRM, true
+	}
+	return 0, false
+}
+
+// Profile detects hosts moving laterally. It is safe for concurrent use.
+type Profile struct {
+	window   time.Duration
+	history  time.Duration
+	warmup   time.Duration
+	cooldown time.Duration
+	minNew   int
+	maxHosts int
+	maxPeers int
+	internal []netip.Prefix
+	detector detectors.Detector
+
+	mu        sync.Mutex
+	trained   bool
+	hosts     map[netip.Addr]*host
+	lastSweep time.Time
+}
+
+// host is the recent internal traffic of one internal address.
+type host struct {
+	peers    map[netip.Addr]time.Time // when each peer was last reached
+	fanOut   *profiles.DistinctWindow[netip.Addr]
+	fresh    *profiles.DistinctWindow[netip.Addr] // peers new when reached
+	services [numServices]*profiles.DistinctWindow[netip.Addr]
+	first    time.Time // first attempt of the current burst of activity
+	last     time.Time
+	alerted  time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWindow sets the sliding window over which peers are counted.
+// Defaults to ten minutes.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithHistory sets how long a host remembers a peer it no longer reaches;
+// the peer is new again afterwards. Defaults to 30 days.
+func WithHistory(d time.Duration) Option {
+	return func(p *Profile) {
+		p.history = d
+	}
+}
+
+// WithWarmup sets the span at the start of a baseline that Fit only
+// learns peers from: every peer is new at first. Defaults to 24 hours.
+func WithWarmup(d time.Duration) Option {
+	return func(p *Profile) {
+		p.warmup = d
+	}
+}
+
+// WithCooldown sets how long a host that raised an alert stays quiet
+// before it can raise another. Defaults to 30 minutes.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMinNewPeers sets how many new peers a host must reach within the
+// window before it can raise an alert, however unusual its score.
+// Defaults to 3.
+func WithMinNewPeers(n int) Option {
+	return func(p *Profile) {
+		p.minNew = n
+	}
+}
+
+// WithMaxHosts bounds the number of internal hosts tracked at once. When
+// a new host would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxHosts(n int) Option {
+	return func(p *Profile) {
+		p.maxHosts = n
+	}
+}
+
+// WithMaxPeers bounds the peers remembered per host, like WithMaxHosts.
+// Defaults to 10000.
+func WithMaxPeers(n int) Option {
+	return func(p *Profile) {
+		p.maxPeers = n
+	}
+}
+
+// WithInternal sets the networks of internal hosts. Repeated options
+// accumulate. Defaults to the private address ranges.
+func WithInternal(prefixes ...netip.Prefix) Option {
+	return func(p *Profile) {
+		for _, prefix := range prefixes {
+			p.internal = append(p.internal, prefix.Masked())
+		}
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		window:   10 * time.Minute,
+		history:  30 * 24 * time.Hour,
+		warmup:   24 * time.Hour,
+		cooldown: 30 * time.Minute,
+		minNew:   3,
+		maxHosts: 100000,
+		maxPeers: 10000,
+		hosts:    make(map[netip.Addr]*host),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// Every new connection between internal hosts is scored;
+			// flag the rarest 0.5% of the baseline's.
+			iforest.WithContamination(0.005),
+			// Many networks have no RDP or WinRM in their baseline.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.window = max(p.window, time.Second)
+	p.history = max(p.history, p.window)
+	p.maxHosts = max(p.maxHosts, 1)
+	p.maxPeers = max(p.maxPeers, 1)
+	return p
+}
+
+// Fit trains the detector on the connection patterns of packets, a
+// baseline of normal traffic in capture order. Attempts within the warm-up
+// span at its start only teach peers: the baseline must be longer than
+// that.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		features, _, ok := p.track(pkt)
+		if ok && pkt.Time.Sub(packets[0].Time) >= p.warmup {
+			vectors = append(vectors, features)
+		}
+	}
+	// Keep whom the hosts reached, not their windows.
+	for addr, h := range p.hosts {
+		reset := p.newHost()
+		reset.peers = h.peers
+		p.hosts[addr] = reset
+	}
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("lateral: baseline has %d attempts reaching internal peers after the first %s, need at least 2", len(vectors), p.warmup)
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("lateral: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks pkt and returns an alert if it reveals lateral movement.
+func (p *Profile) Observe(pkt guardio.Packet) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, h, ok := p.track(pkt)
+	if !ok || features[1] < float64(p.minNew) {
+		return profiles.Alert{}, false, nil
+	}
+	if !h.alerted.IsZero() && pkt.Time.Sub(h.alerted) < p.cooldown {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("lateral: %w", err)
+	}
+	if score < detectors.ThresholdOf(p.detector) {
+		return profiles.Alert{}, false, nil
+	}
+	h.alerted = pkt.Time
+
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   pkt.Src.String(),
+		Start:    h.first,
+		Time:     pkt.Time,
+		Score:    score,
+		Features: make(map[string]float64, len(FeatureNames)),
+		Message: fmt.Sprintf("%s reached %d internal hosts in %s, %d of them new, %d over SMB, %d over RDP and %d over WinRM",
+			pkt.Src, int(features[2]), min(pkt.Time.Sub(h.first), p.window).Round(time.Second),
+			int(features[1]), int(features[3]), int(features[4]), int(features[5])),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector. Peers are not saved.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("lateral: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Hosts returns the number of internal hosts currently tracked.
+func (p *Profile) Hosts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.hosts)
+}
+
+// Internal reports whether addr belongs to an internal host.
+func (p *Profile) Internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if len(p.internal) == 0 {
+		return addr.IsPrivate()
+	}
+	for _, prefix := range p.internal {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// track records pkt and, if it is a connection attempt between internal
+// hosts reaching a peer or service of its source not yet reached in the
+// window, returns the source's features. The caller holds p.mu.
+func (p *Profile) track(pkt guardio.Packet) ([]float64, *host, bool) {
+	if pkt.Protocol != guardio.ProtoUDP && !pkt.SYNOnly() {
+		return nil, nil, false
+	}
+	if pkt.Src == pkt.Dst || !p.Internal(pkt.Src) || !p.Internal(pkt.Dst) {
+		return nil, nil, false
+	}
+	p.sweep(pkt.Time)
+
+	h := p.hosts[pkt.Src]
+	if h == nil {
+		h = p.addHost(pkt.Src)
+	}
+	if pkt.Time.Sub(h.last) > p.window || h.first.IsZero() {
+		h.first = pkt.Time
+	}
+	h.last = pkt.Time
+
+	var newPeer float64
+	if last, ok := h.peers[pkt.Dst]; !ok || pkt.Time.Sub(last) > p.history {
+		newPeer = 1
+		p.addPeer(h, pkt.Dst)
+	}
+	h.peers[pkt.Dst] = pkt.Time
+
+	reached := h.fanOut.Add(pkt.Time, pkt.Dst)
+	if newPeer == 1 {
+		h.fresh.Add(pkt.Time, pkt.Dst)
+	} else {
+		h.fresh.Expire(pkt.Time)
+	}
+	s, admin := service(pkt.DstPort)
+	for i, w := range h.services {
+		if admin && i == s {
+			reached = w.Add(pkt.Time, pkt.Dst) || reached
+		} else {
+			w.Expire(pkt.Time)
+		}
+	}
+	if !reached {
+		return nil, nil, false
+	}
+
+	features := []float64{
+		newPeer,
+		float64(h.fresh.Len()),
+		float64(h.fanOut.Len()),
+		float64(h.services[serviceSMB].Len()),
+		float64(h.services[serviceRDP].Len()),
+		float64(h.services[serviceWinRM].Len()),
+	}
+	return features, h, true
+}
+
+// newHost returns a host with empty windows.
+func (p *Profile) newHost() *host {
+	h := &host{
+		peers:  make(map[netip.Addr]time.Time),
+		fanOut: profiles.NewDistinctWindow[netip.Addr](p.window),
+		fresh:  profiles.NewDistinctWindow[netip.Addr](p.window),
+	}
+	for i := range h.services {
+		h.services[i] = profiles.NewDistinctWindow[netip.Addr](p.window)
+	}
+	return h
+}
+
+// addHost starts tracking addr, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) addHost(addr netip.Addr) *host {
+	if len(p.hosts) >= p.maxHosts {
+		var oldest netip.Addr
+		var oldestTime time.Time
+		for a, h := range p.hosts {
+			if !oldest.IsValid() || h.last.Before(oldestTime) {
+				oldest, oldestTime = a, h.last
+			}
+		}
+		delete(p.hosts, oldest)
+	}
+	h := p.newHost()
+	p.hosts[addr] = h
+	return h
+}
+
+// addPeer makes room in the peers of h for peer, forgetting the one h
+// reached least recently if the limit is reached.
+func (p *Profile) addPeer(h *host, peer netip.Addr) {
+	if _, ok := h.peers[peer]; ok || len(h.peers) < p.maxPeers {
+		return
+	}
+	var oldest netip.Addr
+	var oldestTime time.Time
+	for a, t := range h.peers {
+		if !oldest.IsValid() || t.Before(oldestTime) {
+			oldest, oldestTime = a, t
+		}
+	}
+	delete(h.peers, oldest)
+}
+
+// sweep forgets peers hosts have not reached within the history, and
+// hosts left with none that are idle and past their cooldown, at most
+// once per window. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	for addr, h := range p.hosts {
+		for peer, last := range h.peers {
+			if now.Sub(last) > p.history {
+				delete(h.peers, peer)
+			}
+		}
+		if len(h.peers) == 0 && now.Sub(h.last) > p.window && now.Sub(h.alerted) >= p.cooldown {
+			delete(p.hosts, addr)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package lateral
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start      = time.Unix(1700000000, 0)
+	compromise = workstation(29)
+	manager    = addr(10, 1, 0, 100)
+)
+
+func addr(a, b, c, d byte) netip.Addr {
+	return netip.AddrFrom4([4]byte{a, b, c, d})
+}
+
+func workstation(i int) netip.Addr {
+	return addr(10, 0, 0, byte(1+i))
+}
+
+func server(i int) netip.Addr {
+	return addr(10, 1, 0, byte(1+i))
+}
+
+func syn(t time.Time, src, dst netip.Addr, port uint16) guardio.Packet {
+	return guardio.Packet{Time: t, Src: src, Dst: dst, SrcPort: 50000, DstPort: port, Protocol: guardio.ProtoTCP, Flags: guardio.FlagSYN, Length: 60}
+}
+
+// baseline returns d of traffic in a Windows network: 40 workstations
+// using twelve servers, the internet and now and then a share on another
+// workstation, two administrators on RDP to the servers, a help desk on
+// RDP to the odd workstation and a management server polling the servers
+// over WinRM.
+func baseline(rng *rand.Rand, from time.Time, d time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	services := []struct {
+		server int
+		port   uint16
+	}{
+		{0, 88}, {0, 88}, {0, 389}, {0, 445}, {1, 445}, {1, 445}, {1, 445}, {2, 445}, {3, 445}, {4, 445},
+		{5, 443}, {5, 443}, {6, 443}, {7, 443}, {8, 1433}, {9, 9100}, {10, 443}, {10, 443}, {11, 8530},
+	}
+	for i := 0; i < 40; i++ {
+		for t := from.Add(time.Duration(rng.Intn(300)) * time.Second); t.Before(from.Add(d)); t = t.Add(time.Duration(30+rng.Intn(240)) * time.Second) {
+			switch r := rng.Intn(100); {
+			case r < 30:
+				packets = append(packets, syn(t, workstation(i), addr(93, 184, 216, byte(rng.Intn(50))), 443))
+			case r == 30:
+				packets = append(packets, syn(t, workstation(i), workstation(rng.Intn(40)), 445))
+			default:
+				s := services[rng.Intn(len(services))]
+				packets = append(packets, syn(t, workstation(i), server(s.server), s.port))
+				if rng.Intn(20) == 0 {
+					// An ACK of the same connection, not an attempt.
+					ack := syn(t.Add(time.Millisecond), workstation(i), server(s.server), s.port)
+					ack.Flags = guardio.FlagACK
+					packets = append(packets, ack)
+				}
+			}
+		}
+	}
+	for t := from; t.Before(from.Add(d)); t = t.Add(time.Duration(20+rng.Intn(40)) * time.Minute) {
+		packets = append(packets, syn(t, workstation(rng.Intn(2)), server(rng.Intn(12)), 3389))
+	}
+	for t := from; t.Before(from.Add(d)); t = t.Add(time.Duration(1+rng.Intn(3)) * time.Hour) {
+		packets = append(packets, syn(t, workstation(2), workstation(3+rng.Intn(37)), 3389))
+	}
+	for t := from; t.Before(from.Add(d)); t = t.Add(time.Hour) {
+		for i := 0; i < 12; i++ {
+			packets = append(packets, syn(t.Add(time.Duration(i)*time.Second), manager, server(i), 5985))
+		}
+	}
+	sortByTime(packets)
+	return packets
+}
+
+func sortByTime(packets []guardio.Packet) {
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(baseline(rand.New(rand.NewSource(1)), start, 48*time.Hour)))
+	return p
+}
+
+// observeAll feeds packets to p and returns the alerts raised.
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		alert, raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestLateralMovement(t *testing.T) {
+	live := start.Add(48 * time.Hour)
+
+	tests := []struct {
+		name  string
+		hop   func(i int) guardio.Packet
+		n     int
+		field string
+	}{
+		{
+			name: "smb",
+			hop: func(i int) guardio.Packet {
+				return syn(live.Add(time.Duration(i)*5*time.Second), compromise, workstation(i), 445)
+			},
+			n:     20,
+			field: "smb_peers",
+		},
+		{
+			name: "rdp",
+			hop: func(i int) guardio.Packet {
+				return syn(live.Add(time.Duration(i)*20*time.Second), compromise, workstation(i), 3389)
+			},
+			n:     8,
+			field: "rdp_peers",
+		},
+		{
+			name: "winrm",
+			hop: func(i int) guardio.Packet {
+				return syn(live.Add(time.Duration(i)*10*time.Second), compromise, workstation(i), 5986)
+			},
+			n:     10,
+			field: "winrm_peers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := trained(t)
+			packets := baseline(rand.New(rand.NewSource(2)), live, 10*time.Minute)
+			for i := 0; i < tt.n; i++ {
+				packets = append(packets, tt.hop(i))
+			}
+			sortByTime(packets)
+
+			alerts := observeAll(t, p, packets)
+			require.Len(t, alerts, 1, "one alert within the cooldown")
+			a := alerts[0]
+			assert.Equal(t, Name, a.Profile)
+			assert.Equal(t, compromise.String(), a.Entity)
+			assert.Equal(t, 1.0, a.Features["new_peer"])
+			assert.GreaterOrEqual(t, a.Features[tt.field], 2.0)
+			assert.Contains(t, a.Message, "of them new")
+		})
+	}
+}
+
+func TestNormalTraffic(t *testing.T) {
+	p := trained(t)
+	packets := baseline(rand.New(rand.NewSource(3)), start.Add(48*time.Hour), 2*time.Hour)
+	assert.LessOrEqual(t, len(observeAll(t, p, packets)), 1)
+}
+
+func TestPeers(t *testing.T) {
+	p := trained(t)
+	known, _, ok := p.track(syn(start.Add(48*time.Hour), manager, server(0), 5985))
+	require.True(t, ok)
+	assert.Equal(t, []float64{0, 0, 1, 0, 0, 1}, known, "the baseline's peers are known")
+
+	novel, _, ok := p.track(syn(start.Add(48*time.Hour), manager, workstation(0), 445))
+	require.True(t, ok)
+	assert.Equal(t, []float64{1, 1, 2, 1, 0, 1}, novel)
+
+	_, _, ok = p.track(syn(start.Add(48*time.Hour+time.Second), manager, workstation(0), 445))
+	assert.False(t, ok, "peers already reached in the window are not scored again")
+
+	later, _, ok := p.track(syn(start.Add(48*time.Hour+time.Hour), manager, workstation(0), 445))
+	require.True(t, ok)
+	assert.Zero(t, later[0], "a peer is only new once")
+}
+
+func TestIgnoredTraffic(t *testing.T) {
+	p := New()
+	ack := syn(start, workstation(0), workstation(1), 445)
+	ack.Flags = guardio.FlagACK
+	for _, pkt := range []guardio.Packet{
+		ack,
+		syn(start, workstation(0), addr(8, 8, 8, 8), 445),
+		syn(start, addr(8, 8, 8, 8), workstation(0), 3389),
+		syn(start, workstation(0), workstation(0), 445),
+		{Time: start, Src: workstation(0), Dst: workstation(1), Protocol: guardio.ProtoICMP},
+	} {
+		_, _, ok := p.track(pkt)
+		assert.False(t, ok)
+	}
+	assert.Zero(t, p.Hosts())
+
+	udp := guardio.Packet{Time: start, Src: workstation(0), Dst: server(0), DstPort: 53, Protocol: guardio.ProtoUDP}
+	_, _, ok := p.track(udp)
+	assert.True(t, ok)
+}
+
+func TestInternal(t *testing.T) {
+	p := New(WithInternal(netip.MustParsePrefix("203.0.113.7/24")))
+	assert.True(t, p.Internal(netip.MustParseAddr("203.0.113.200")))
+	assert.False(t, p.Internal(netip.MustParseAddr("10.1.2.3")), "the networks replace the private ranges")
+	assert.True(t, New().Internal(netip.MustParseAddr("::ffff:10.1.2.3")))
+}
+
+func TestMaxHosts(t *testing.T) {
+	p := New(WithMaxHosts(3), WithMaxPeers(2))
+	for i := 0; i < 10; i++ {
+		p.track(syn(start, workstation(i), server(0), 445))
+	}
+	assert.Equal(t, 3, p.Hosts())
+
+	for i := 0; i < 5; i++ {
+		p.track(syn(start, manager, server(i), 5985))
+	}
+	assert.Len(t, p.hosts[manager].peers, 2)
+
+	p.track(syn(start.Add(60*24*time.Hour), manager, server(0), 5985))
+	assert.Equal(t, 1, p.Hosts(), "hosts without peers in the history are forgotten")
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(syn(start, compromise, workstation(0), 445))
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Save()
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+
+	assert.Error(t, p.Fit(baseline(rand.New(rand.NewSource(4)), start, time.Hour)), "shorter than the warm-up")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	in := make(chan guardio.Packet, 20)
+	for i := 0; i < 20; i++ {
+		in <- syn(start.Add(time.Duration(i)*5*time.Second), compromise, workstation(i), 445)
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, compromise.String(), (<-out).Entity)
+}
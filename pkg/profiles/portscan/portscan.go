@@ -0,0 +1,328 @@
+// Package portscan detects port and host scans. It tracks, for every
+// source address, the connection attempts it made in a sliding window:
+// TCP SYNs without ACK and UDP datagrams. Each attempt that reaches a new
+// port or host is scored on the distinct ports and hosts reached, the
+// number of attempts and the share of SYN-only packets in the source's TCP
+// traffic. Vertical scans (many ports on one host), horizontal scans (one
+// port on many hosts) and mixes of both stand out against a baseline of
+// normal traffic.
+//
+// Typical use:
+//
+//	p := portscan.New()
+//	if err := p.Fit(baseline); err != nil { ... }
+//	packets, _ := reader.StreamPackets(ctx) // a pcap.Reader
+//	go p.Run(ctx, packets, alerts)
+package portscan
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "portscan"
+
+// FeatureNames names the features scored, in vector order.
+var FeatureNames = []string{"distinct_ports", "distinct_hosts", "attempts", "syn_ratio"}
+
+// Profile detects scanning sources. It is safe for concurrent use.
+type Profile struct {
+	window      time.Duration
+	cooldown    time.Duration
+	minDistinct int
+	maxSources  int
+	detector    detectors.Detector
+
+	mu        sync.Mutex
+	trained   bool
+	sources   map[netip.Addr]*source
+	lastSweep time.Time
+}
+
+// source is the recent activity of one source address.
+type source struct {
+	ports    *profiles.DistinctWindow[uint16]
+	hosts    *profiles.DistinctWindow[netip.Addr]
+	attempts *profiles.SumWindow
+	tcp      *profiles.SumWindow
+	syns     *profiles.SumWindow
+	first    time.Time // first attempt of the current burst of activity
+	last     time.Time
+	alerted  time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWindow sets the sliding window over which attempts are counted.
+// Defaults to one minute; slow scans need a longer window.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithCooldown sets how long a source that raised an alert stays quiet
+// before it can raise another. Defaults to five minutes.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMinDistinct sets how many distinct ports or hosts a source must
+// reach within the window before it can raise an alert, however unusual
+// its score. Defaults to 10.
+func WithMinDistinct(n int) Option {
+	return func(p *Profile) {
+		p.minDistinct = n
+	}
+}
+
+// WithMaxSources bounds the number of sources tracked at once. When a new
+// source would exceed it, the least recently active one is forgotten.
+// Defaults to 100000.
+func WithMaxSources(n int) Option {
+	return func(p *Profile) {
+		p.maxSources = n
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		window:      time.Minute,
+		cooldown:    5 * time.Minute,
+		minDistinct: 10,
+		maxSources:  100000,
+		sources:     make(map[netip.Addr]*source),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// A baseline of normal traffic holds few scans; flag the
+			// rarest 0.5% of its attempt patterns.
+			iforest.WithContamination(0.005),
+			// Baselines often lack UDP traffic or SYN-only sources.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.window = max(p.window, time.Second)
+	p.maxSources = max(p.maxSources, 1)
+	return p
+}
+
+// Fit trains the detector on the attempt patterns of packets, a baseline
+// of normal traffic in capture order.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		if features, _, ok := p.track(pkt); ok {
+			vectors = append(vectors, features)
+		}
+	}
+	p.sources = make(map[netip.Addr]*source)
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("portscan: baseline has %d connection attempts to new ports or hosts, need at least 2", len(vectors))
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("portscan: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks pkt and returns an alert if it reveals a scan.
+func (p *Profile) Observe(pkt guardio.Packet) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, s, ok := p.track(pkt)
+	if !ok {
+		return profiles.Alert{}, false, nil
+	}
+	if max(features[0], features[1]) < float64(p.minDistinct) {
+		return profiles.Alert{}, false, nil
+	}
+	if !s.alerted.IsZero() && pkt.Time.Sub(s.alerted) < p.cooldown {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("portscan: %w", err)
+	}
+	if score < detectors.ThresholdOf(p.detector) {
+		return profiles.Alert{}, false, nil
+	}
+	s.alerted = pkt.Time
+
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   pkt.Src.String(),
+		Start:    s.first,
+		Time:     pkt.Time,
+		Score:    score,
+		Features: make(map[string]float64, len(FeatureNames)),
+		Message: fmt.Sprintf("%s probed %d ports on %d hosts in %s",
+			pkt.Src, int(features[0]), int(features[1]), pkt.Time.Sub(s.first).Round(time.Second)),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("portscan: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Sources returns the number of sources currently tracked.
+func (p *Profile) Sources() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sources)
+}
+
+// track records pkt and, if it is a connection attempt reaching a new port
+// or host of its source, returns the source's features. The caller holds
+// p.mu.
+func (p *Profile) track(pkt guardio.Packet) ([]float64, *source, bool) {
+	if !pkt.Src.IsValid() || (pkt.Protocol != guardio.ProtoTCP && pkt.Protocol != guardio.ProtoUDP) {
+		return nil, nil, false
+	}
+	p.sweep(pkt.Time)
+
+	s := p.sources[pkt.Src]
+	if s == nil {
+		s = p.newSource(pkt.Src)
+	}
+	if pkt.Time.Sub(s.last) > p.window {
+		s.first = pkt.Time
+	}
+	s.last = pkt.Time
+
+	attempt := pkt.Protocol == guardio.ProtoUDP || pkt.SYNOnly()
+	if pkt.Protocol == guardio.ProtoTCP {
+		s.tcp.Add(pkt.Time, 1)
+		if pkt.SYNOnly() {
+			s.syns.Add(pkt.Time, 1)
+		} else {
+			s.syns.Expire(pkt.Time)
+		}
+	}
+	if !attempt {
+		return nil, nil, false
+	}
+
+	newPort := s.ports.Add(pkt.Time, pkt.DstPort)
+	newHost := s.hosts.Add(pkt.Time, pkt.Dst)
+	s.attempts.Add(pkt.Time, 1)
+	if !newPort && !newHost {
+		return nil, nil, false
+	}
+
+	var synRatio float64
+	if n := s.tcp.Count(); n > 0 {
+		synRatio = s.syns.Sum() / float64(n)
+	}
+	features := []float64{
+		float64(s.ports.Len()),
+		float64(s.hosts.Len()),
+		s.attempts.Sum(),
+		synRatio,
+	}
+	return features, s, true
+}
+
+// newSource starts tracking addr, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) newSource(addr netip.Addr) *source {
+	if len(p.sources) >= p.maxSources {
+		var oldest netip.Addr
+		var oldestTime time.Time
+		for a, s := range p.sources {
+			if !oldest.IsValid() || s.last.Before(oldestTime) {
+				oldest, oldestTime = a, s.last
+			}
+		}
+		delete(p.sources, oldest)
+	}
+	s := &source{
+		ports:    profiles.NewDistinctWindow[uint16](p.window),
+		hosts:    profiles.NewDistinctWindow[netip.Addr](p.window),
+		attempts: profiles.NewSumWindow(p.window),
+		tcp:      profiles.NewSumWindow(p.window),
+		syns:     profiles.NewSumWindow(p.window),
+	}
+	p.sources[addr] = s
+	return s
+}
+
+// sweep forgets sources idle for longer than the window and past their
+// cooldown, at most once per window. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	for addr, s := range p.sources {
+		if now.Sub(s.last) > p.window && now.Sub(s.alerted) >= p.cooldown {
+			delete(p.sources, addr)
+		}
+	}
+}
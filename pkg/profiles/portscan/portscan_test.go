@@ -0,0 +1,201 @@
+package portscan
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var start = time.Unix(1700000000, 0)
+
+func addr(a, b, c, d byte) netip.Addr {
+	return netip.AddrFrom4([4]byte{a, b, c, d})
+}
+
+func syn(t time.Time, src, dst netip.Addr, port uint16) guardio.Packet {
+	return guardio.Packet{Time: t, Src: src, Dst: dst, SrcPort: 40000, DstPort: port, Protocol: guardio.ProtoTCP, Flags: guardio.FlagSYN, Length: 60}
+}
+
+// baseline returns an hour of client traffic: every few seconds a client
+// connects to a web or SSH server and exchanges data, or sends a DNS
+// query.
+func baseline(rng *rand.Rand, from time.Time, d time.Duration) []guardio.Packet {
+	var packets []guardio.Packet
+	servers := []netip.Addr{addr(10, 1, 0, 1), addr(10, 1, 0, 2), addr(10, 1, 0, 3)}
+	ports := []uint16{80, 443, 443, 443, 22, 8080}
+	for t := from; t.Before(from.Add(d)); t = t.Add(time.Duration(200+rng.Intn(800)) * time.Millisecond) {
+		client := addr(10, 0, 0, byte(1+rng.Intn(40)))
+		if rng.Intn(4) == 0 {
+			packets = append(packets, guardio.Packet{Time: t, Src: client, Dst: addr(10, 1, 0, 53), SrcPort: 50000, DstPort: 53, Protocol: guardio.ProtoUDP, Length: 80})
+			continue
+		}
+		dst, port := servers[rng.Intn(len(servers))], ports[rng.Intn(len(ports))]
+		packets = append(packets, syn(t, client, dst, port))
+		for i := 0; i < 3+rng.Intn(10); i++ {
+			packets = append(packets, guardio.Packet{Time: t, Src: client, Dst: dst, SrcPort: 40000, DstPort: port, Protocol: guardio.ProtoTCP, Flags: guardio.FlagACK | guardio.FlagPSH, Length: 600})
+		}
+	}
+	return packets
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(baseline(rand.New(rand.NewSource(1)), start, time.Hour)))
+	assert.Zero(t, p.Sources(), "training state is not carried into detection")
+	return p
+}
+
+// observeAll feeds packets to p and returns the alerts raised.
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		alert, raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestScans(t *testing.T) {
+	scanner := addr(192, 168, 7, 7)
+	live := start.Add(2 * time.Hour)
+
+	tests := []struct {
+		name  string
+		probe func(i int) guardio.Packet
+		n     int
+		field string
+	}{
+		{
+			name: "vertical",
+			probe: func(i int) guardio.Packet {
+				return syn(live.Add(time.Duration(i)*50*time.Millisecond), scanner, addr(10, 1, 0, 1), uint16(1+i))
+			},
+			n:     500,
+			field: "distinct_ports",
+		},
+		{
+			name: "horizontal",
+			probe: func(i int) guardio.Packet {
+				return syn(live.Add(time.Duration(i)*100*time.Millisecond), scanner, addr(10, 2, byte(i/250), byte(i%250)), 445)
+			},
+			n:     300,
+			field: "distinct_hosts",
+		},
+		{
+			name: "udp",
+			probe: func(i int) guardio.Packet {
+				return guardio.Packet{Time: live.Add(time.Duration(i) * 50 * time.Millisecond), Src: scanner, Dst: addr(10, 1, 0, 2), DstPort: uint16(1000 + i), Protocol: guardio.ProtoUDP}
+			},
+			n:     300,
+			field: "distinct_ports",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := trained(t)
+			rng := rand.New(rand.NewSource(2))
+			packets := baseline(rng, live, time.Minute)
+			for i := 0; i < tt.n; i++ {
+				packets = append(packets, tt.probe(i))
+			}
+			sortByTime(packets)
+
+			alerts := observeAll(t, p, packets)
+			require.Len(t, alerts, 1, "one alert per scan within the cooldown")
+			a := alerts[0]
+			assert.Equal(t, Name, a.Profile)
+			assert.Equal(t, scanner.String(), a.Entity)
+			assert.Equal(t, live, a.Start)
+			assert.GreaterOrEqual(t, a.Features[tt.field], 10.0)
+			assert.GreaterOrEqual(t, a.Score, p.detector.(interface{ Threshold() float64 }).Threshold())
+			assert.Contains(t, a.Message, scanner.String())
+		})
+	}
+}
+
+func TestNormalTraffic(t *testing.T) {
+	p := trained(t)
+	packets := baseline(rand.New(rand.NewSource(3)), start.Add(2*time.Hour), 30*time.Minute)
+	assert.Empty(t, observeAll(t, p, packets))
+}
+
+func TestCooldown(t *testing.T) {
+	p := trained(t, WithCooldown(time.Minute))
+	scanner := addr(192, 168, 7, 7)
+	live := start.Add(2 * time.Hour)
+
+	var packets []guardio.Packet
+	for i := 0; i < 600; i++ {
+		// A slow sweep of one port per second over ten minutes.
+		packets = append(packets, syn(live.Add(time.Duration(i)*time.Second), scanner, addr(10, 1, 0, 1), uint16(1+i)))
+	}
+	alerts := observeAll(t, p, packets)
+	require.Greater(t, len(alerts), 1)
+	for i := 1; i < len(alerts); i++ {
+		assert.GreaterOrEqual(t, alerts[i].Time.Sub(alerts[i-1].Time), time.Minute)
+	}
+}
+
+func TestMaxSources(t *testing.T) {
+	p := trained(t, WithMaxSources(5))
+	live := start.Add(2 * time.Hour)
+	for i := 0; i < 20; i++ {
+		_, _, err := p.Observe(syn(live, addr(10, 9, 0, byte(i)), addr(10, 1, 0, 1), 443))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, p.Sources())
+
+	// Sources idle for longer than the window are forgotten.
+	_, _, err := p.Observe(syn(live.Add(time.Hour), addr(10, 9, 1, 1), addr(10, 1, 0, 1), 443))
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.Sources())
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(syn(start, addr(10, 0, 0, 1), addr(10, 1, 0, 1), 80))
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Save()
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+
+	assert.Error(t, p.Fit([]guardio.Packet{syn(start, addr(10, 0, 0, 1), addr(10, 1, 0, 1), 80)}), "too little baseline")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	scanner := addr(192, 168, 7, 7)
+	in := make(chan guardio.Packet, 200)
+	for i := 0; i < 200; i++ {
+		in <- syn(start.Add(time.Duration(i)*10*time.Millisecond), scanner, addr(10, 1, 0, 1), uint16(1+i))
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, scanner.String(), (<-out).Entity)
+}
+
+func sortByTime(packets []guardio.Packet) {
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+}
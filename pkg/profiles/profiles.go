@@ -0,0 +1,88 @@
+// Package profiles holds what the packaged detection profiles share. A
+// profile, such as portscan, bundles the feature engineering, a tuned
+// detector and the alerting rules for one kind of attack: train it on a
+// baseline of normal activity, then feed it events and receive alerts
+// instead of per-sample scores.
+package profiles
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotTrained is returned by profiles asked to observe events before
+// they were trained or loaded.
+var ErrNotTrained = errors.New("profile not trained")
+
+// Events of alerts that mark the start and end of an episode, such as an
+// attack, rather than report it once.
+const (
+	EventStart = "start"
+	EventEnd   = "end"
+)
+
+// Alert reports suspicious activity by one entity, such as a source
+// address.
+type Alert struct {
+	// Profile names the profile that raised the alert.
+	Profile string `json:"profile"`
+	// Event is EventStart or EventEnd for profiles that report episodes,
+	// empty otherwise.
+	Event string `json:"event,omitempty"`
+	// Entity identifies what the alert is about.
+	Entity string `json:"entity"`
+	// Start is the time of the first event considered, Time that of the
+	// event that raised the alert.
+	Start time.Time `json:"start"`
+	Time  time.Time `json:"time"`
+	Score float64   `json:"score"`
+	// Severity grades the alert, one of the Severity constants, for
+	// profiles that grade their alerts.
+	Severity string `json:"severity,omitempty"`
+	// Features holds the engineered features scored, by name.
+	Features map[string]float64 `json:"features,omitempty"`
+	Message  string             `json:"message"`
+}
+
+// ObserveFunc processes one event, returning the alerts it raises.
+type ObserveFunc[T any] func(event T) ([]Alert, error)
+
+// One adapts the Observe method of a profile that raises at most one
+// alert per event.
+func One[T any](observe func(event T) (Alert, bool, error)) ObserveFunc[T] {
+	return func(event T) ([]Alert, error) {
+		alert, raised, err := observe(event)
+		if !raised {
+			return nil, err
+		}
+		return []Alert{alert}, err
+	}
+}
+
+// Run feeds the events from in to observe and sends the alerts raised to
+// out, until in is closed, ctx is done or observe fails. It returns nil
+// when in is closed and leaves out open.
+func Run[T any](ctx context.Context, in <-chan T, out chan<- Alert, observe ObserveFunc[T]) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-in:
+			if !ok {
+				return nil
+			}
+			alerts, err := observe(event)
+			if err != nil {
+				return err
+			}
+			for _, alert := range alerts {
+				select {
+				case out <- alert:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
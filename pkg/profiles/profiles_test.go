@@ -0,0 +1,74 @@
+package profiles
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	errBad := errors.New("bad event")
+	observe := One(func(n int) (Alert, bool, error) {
+		switch {
+		case n < 0:
+			return Alert{}, false, errBad
+		case n%2 == 0:
+			return Alert{Entity: string(rune('a' + n))}, true, nil
+		default:
+			return Alert{}, false, nil
+		}
+	})
+
+	tests := []struct {
+		name    string
+		events  []int
+		want    []string
+		wantErr error
+	}{
+		{name: "alerts only", events: []int{0, 1, 2, 3, 4}, want: []string{"a", "c", "e"}},
+		{name: "no events", events: nil, want: nil},
+		{name: "observe fails", events: []int{0, -1, 2}, want: []string{"a"}, wantErr: errBad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := make(chan int, len(tt.events))
+			for _, e := range tt.events {
+				in <- e
+			}
+			close(in)
+			out := make(chan Alert, len(tt.events))
+
+			err := Run(context.Background(), in, out, observe)
+			require.ErrorIs(t, err, tt.wantErr)
+			close(out)
+			var got []string
+			for a := range out {
+				got = append(got, a.Entity)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("several alerts per event", func(t *testing.T) {
+		in := make(chan int, 2)
+		in <- 2
+		in <- 3
+		close(in)
+		out := make(chan Alert, 5)
+		repeat := func(n int) ([]Alert, error) {
+			return make([]Alert, n), nil
+		}
+		require.NoError(t, Run(context.Background(), in, out, repeat))
+		assert.Len(t, out, 5)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := Run(ctx, make(chan int), make(chan Alert), observe)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
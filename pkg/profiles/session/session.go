@@ -0,0 +1,556 @@
+// Package session detects anomalous SSH and RDP sessions. It follows the
+// TCP connections clients open to SSH and RDP servers, from the SYN to a
+// FIN or RST or until they fall idle, and scores every session that ends
+// on its duration, the bytes sent each way, the hour it started, whether
+// the client is new to the server and how many failed logins from the
+// client to the server preceded it.
+//
+// Failed logins are recognized from the traffic alone: a session that
+// ends shortly after it starts, having sent the client little data, is
+// counted as a failed login rather than scored. A session after a run of
+// failures is what a successful password guess looks like; an unusually
+// long or heavy session, at an odd hour or from a new client, what a
+// stolen account in use looks like.
+//
+// Rather than alerting on packets, the profile alerts on sessions as they
+// end: Observe returns the alerts of the sessions a packet ends, and
+// Flush those of sessions that fell idle when traffic stops.
+//
+// Typical use:
+//
+//	p := session.New(session.WithLocation(loc))
+//	if err := p.Fit(baseline); err != nil { ... }
+//	packets, _ := reader.StreamPackets(ctx) // a pcap.Reader
+//	go p.Run(ctx, packets, alerts)
+package session
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "session"
+
+// FeatureNames names the features of a session, in vector order. Duration
+// is log10(1+seconds) and byte counts log10(1+bytes); hour is the local
+// time of day the session started, in hours; new_source is 1 if the
+// client had no session with the server within the history, 0 otherwise;
+// protocol is 0 for SSH and 1 for RDP.
+var FeatureNames = []string{"duration", "bytes_to_server", "bytes_to_client", "hour", "new_source", "failed_before", "protocol"}
+
+// Protocols of sessions.
+const (
+	protoSSH = iota
+	protoRDP
+)
+
+var protocolNames = []string{"SSH", "RDP"}
+
+// Profile detects anomalous sessions. It is safe for concurrent use.
+type Profile struct {
+	sshPorts      []uint16
+	rdpPorts      []uint16
+	idle          time.Duration
+	window        time.Duration
+	history       time.Duration
+	warmup        time.Duration
+	failSpan      time.Duration
+	failBytes     int
+	maxSessions   int
+	maxPairs      int
+	loc           *time.Location
+	severity      profiles.SeverityMap
+	detector      detectors.Detector
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	trained   bool
+	sessions  map[flow]*session
+	pairs     map[pair]*history
+	lastSweep time.Time
+}
+
+// flow identifies a TCP connection by its client and server ends.
+type flow struct {
+	client, server         netip.Addr
+	clientPort, serverPort uint16
+}
+
+// pair is a client and a server.
+type pair struct {
+	client, server netip.Addr
+}
+
+// session is an open connection to a server.
+type session struct {
+	protocol int
+	start    time.Time
+	last     time.Time
+	toServer int
+	toClient int
+}
+
+// history is what a pair did recently.
+type history struct {
+	last     time.Time           // last packet of a session, failed or not
+	accepted time.Time           // end of the last session not failed
+	failures *profiles.SumWindow // failed logins in the window
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithSSHPorts sets the ports SSH servers listen on. Defaults to 22.
+func WithSSHPorts(ports ...uint16) Option {
+	return func(p *Profile) {
+		p.sshPorts = ports
+	}
+}
+
+// WithRDPPorts sets the ports RDP servers listen on. Defaults to 3389.
+func WithRDPPorts(ports ...uint16) Option {
+	return func(p *Profile) {
+		p.rdpPorts = ports
+	}
+}
+
+// WithIdleTimeout sets how long a session may go without packets before
+// it is considered ended. Defaults to 30 minutes.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(p *Profile) {
+		p.idle = d
+	}
+}
+
+// WithWindow sets how far back failed logins count towards a session.
+// Defaults to one hour.
+func WithWindow(d time.Duration) Option {
+	return func(p *Profile) {
+		p.window = d
+	}
+}
+
+// WithHistory sets how long a client stays known to a server after its
+// last session; it is a new source again afterwards. Defaults to 30 days.
+func WithHistory(d time.Duration) Option {
+	return func(p *Profile) {
+		p.history = d
+	}
+}
+
+// WithWarmup sets the span at the start of a baseline that Fit only
+// learns clients from: every client is new at first. Defaults to 24 hours.
+func WithWarmup(d time.Duration) Option {
+	return func(p *Profile) {
+		p.warmup = d
+	}
+}
+
+// WithFailedLogin sets what a failed login looks like: a session ending
+// within d of its start having sent the client fewer than bytes payload
+// bytes. Defaults to 10 seconds and 8 kB.
+func WithFailedLogin(d time.Duration, bytes int) Option {
+	return func(p *Profile) {
+		p.failSpan, p.failBytes = d, bytes
+	}
+}
+
+// WithMaxSessions bounds the number of open sessions tracked at once.
+// When a new session would exceed it, the least recently active one is
+// dropped unscored. Defaults to 100000.
+func WithMaxSessions(n int) Option {
+	return func(p *Profile) {
+		p.maxSessions = n
+	}
+}
+
+// WithMaxPairs bounds the number of clients and servers remembered
+// together, like WithMaxSessions. Defaults to 100000.
+func WithMaxPairs(n int) Option {
+	return func(p *Profile) {
+		p.maxPairs = n
+	}
+}
+
+// WithLocation sets the time zone of the hour feature. Defaults to
+// time.Local.
+func WithLocation(loc *time.Location) Option {
+	return func(p *Profile) {
+		p.loc = loc
+	}
+}
+
+// WithSeverity sets how alerts are graded. Defaults to
+// profiles.DefaultSeverityMap.
+func WithSeverity(m profiles.SeverityMap) Option {
+	return func(p *Profile) {
+		p.severity = m
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		sshPorts:    []uint16{22},
+		rdpPorts:    []uint16{3389},
+		idle:        30 * time.Minute,
+		window:      time.Hour,
+		history:     30 * 24 * time.Hour,
+		warmup:      24 * time.Hour,
+		failSpan:    10 * time.Second,
+		failBytes:   8000,
+		maxSessions: 100000,
+		maxPairs:    100000,
+		loc:         time.Local,
+		severity:    profiles.DefaultSeverityMap,
+		sessions:    make(map[flow]*session),
+		pairs:       make(map[pair]*history),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// Sessions are few next to packets and each one is scored;
+			// flag the rarest 1% of the baseline's.
+			iforest.WithContamination(0.01),
+			// Baselines often hold a single protocol, or no failures.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	if p.loc == nil {
+		p.loc = time.Local
+	}
+	p.idle = max(p.idle, time.Second)
+	p.window = max(p.window, time.Second)
+	p.history = max(p.history, p.window)
+	p.maxSessions = max(p.maxSessions, 1)
+	p.maxPairs = max(p.maxPairs, 1)
+	p.sweepInterval = min(p.idle, time.Minute)
+	return p
+}
+
+// Fit trains the detector on the sessions in packets, a baseline of normal
+// traffic in capture order. Sessions within the warm-up span at its start
+// only teach clients: the baseline must be longer than that. Sessions
+// still open at its end are not used.
+func (p *Profile) Fit(packets []guardio.Packet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, pkt := range packets {
+		for _, e := range p.track(pkt) {
+			if e.session.start.Sub(packets[0].Time) >= p.warmup {
+				vectors = append(vectors, e.features)
+			}
+		}
+	}
+	// Keep which clients the servers know, not sessions or failures.
+	p.sessions = make(map[flow]*session)
+	for k, h := range p.pairs {
+		h.failures = profiles.NewSumWindow(p.window)
+		if h.accepted.IsZero() {
+			delete(p.pairs, k)
+		}
+	}
+	p.lastSweep = time.Time{}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("session: baseline has %d sessions after the first %s, need at least 2", len(vectors), p.warmup)
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Observe tracks pkt and returns the alerts of the anomalous sessions that
+// ended by the time of pkt.
+func (p *Profile) Observe(pkt guardio.Packet) ([]profiles.Alert, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.score(p.track(pkt))
+}
+
+// Flush ends the sessions idle for longer than the idle timeout at now, as
+// if a packet arrived then, and returns the alerts they raise. Call it
+// when traffic may stop altogether, such as at the end of a capture.
+func (p *Profile) Flush(now time.Time) ([]profiles.Alert, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	p.lastSweep = time.Time{}
+	return p.score(p.sweep(now))
+}
+
+// Run observes the packets from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.Packet, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, p.Observe)
+}
+
+// Save serializes the trained detector. Known clients are not saved.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	p.trained = true
+	return nil
+}
+
+// Sessions returns the number of open sessions currently tracked.
+func (p *Profile) Sessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+// ended is a session that ended and was not a failed login.
+type ended struct {
+	flow     flow
+	session  *session
+	features []float64
+}
+
+// score returns the alerts of the anomalous sessions among done. The
+// caller holds p.mu.
+func (p *Profile) score(done []ended) ([]profiles.Alert, error) {
+	var alerts []profiles.Alert
+	threshold := detectors.ThresholdOf(p.detector)
+	for _, e := range done {
+		score, err := p.detector.PredictOne(e.features)
+		if err != nil {
+			return alerts, fmt.Errorf("session: %w", err)
+		}
+		if score < threshold {
+			continue
+		}
+		alerts = append(alerts, p.alert(e, score, threshold))
+	}
+	return alerts, nil
+}
+
+func (p *Profile) alert(e ended, score, threshold float64) profiles.Alert {
+	s, f := e.session, e.features
+	from := "a known client"
+	if f[4] == 1 {
+		from = "a new client"
+	}
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   e.flow.client.String(),
+		Start:    s.start,
+		Time:     s.last,
+		Score:    score,
+		Severity: p.severity.Grade(score, threshold),
+		Features: make(map[string]float64, len(FeatureNames)),
+		Message: fmt.Sprintf("%s session from %s to %s at %s lasted %s, %d bytes up and %d down, from %s after %d failed logins",
+			protocolNames[s.protocol], e.flow.client, e.flow.server, s.start.In(p.loc).Format("15:04"),
+			s.last.Sub(s.start).Round(time.Second), s.toServer, s.toClient, from, int(f[5])),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = f[i]
+	}
+	return alert
+}
+
+// track records pkt and returns the sessions ended by its time, either
+// idle or closed by pkt. The caller holds p.mu.
+func (p *Profile) track(pkt guardio.Packet) []ended {
+	if pkt.Protocol != guardio.ProtoTCP || !pkt.Src.IsValid() || !pkt.Dst.IsValid() {
+		return nil
+	}
+	done := p.sweep(pkt.Time)
+
+	toServer := flow{client: pkt.Src, server: pkt.Dst, clientPort: pkt.SrcPort, serverPort: pkt.DstPort}
+	k, s, up := toServer, p.sessions[toServer], true
+	if s == nil {
+		k = flow{client: pkt.Dst, server: pkt.Src, clientPort: pkt.DstPort, serverPort: pkt.SrcPort}
+		s, up = p.sessions[k], false
+	}
+	if s == nil {
+		protocol, ok := p.protocol(pkt.DstPort)
+		if !pkt.SYNOnly() || !ok {
+			return done
+		}
+		k, s, up = toServer, p.addSession(toServer, protocol, pkt.Time), true
+	}
+
+	s.last = pkt.Time
+	if up {
+		s.toServer += pkt.Payload
+	} else {
+		s.toClient += pkt.Payload
+	}
+	if pkt.Has(guardio.FlagFIN) || pkt.Has(guardio.FlagRST) {
+		delete(p.sessions, k)
+		if e, ok := p.end(k, s); ok {
+			done = append(done, e)
+		}
+	}
+	return done
+}
+
+// protocol returns the protocol of sessions to port, and false if no
+// tracked server listens on it.
+func (p *Profile) protocol(port uint16) (int, bool) {
+	switch {
+	case slices.Contains(p.sshPorts, port):
+		return protoSSH, true
+	case slices.Contains(p.rdpPorts, port):
+		return protoRDP, true
+	}
+	return 0, false
+}
+
+// end records that s ended and, unless it was a failed login, returns its
+// features. The caller holds p.mu.
+func (p *Profile) end(k flow, s *session) (ended, bool) {
+	pk := pair{client: k.client, server: k.server}
+	h := p.pairs[pk]
+	if h == nil {
+		h = p.addPair(pk)
+	}
+	h.last = s.last
+
+	if s.last.Sub(s.start) < p.failSpan && s.toClient < p.failBytes {
+		h.failures.Add(s.last, 1)
+		return ended{}, false
+	}
+	h.failures.Expire(s.start)
+	var newSource float64
+	if h.accepted.IsZero() || s.start.Sub(h.accepted) > p.history {
+		newSource = 1
+	}
+	h.accepted = s.last
+
+	local := s.start.In(p.loc)
+	features := []float64{
+		math.Log10(1 + s.last.Sub(s.start).Seconds()),
+		math.Log10(1 + float64(s.toServer)),
+		math.Log10(1 + float64(s.toClient)),
+		float64(local.Hour()) + float64(local.Minute())/60,
+		newSource,
+		h.failures.Sum(),
+		float64(s.protocol),
+	}
+	return ended{flow: k, session: s, features: features}, true
+}
+
+// addSession starts tracking k, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) addSession(k flow, protocol int, t time.Time) *session {
+	if len(p.sessions) >= p.maxSessions {
+		var oldest flow
+		var oldestTime time.Time
+		first := true
+		for f, s := range p.sessions {
+			if first || s.last.Before(oldestTime) {
+				oldest, oldestTime, first = f, s.last, false
+			}
+		}
+		delete(p.sessions, oldest)
+	}
+	s := &session{protocol: protocol, start: t, last: t}
+	p.sessions[k] = s
+	return s
+}
+
+// addPair starts remembering k, first making room if the limit is
+// reached. The caller holds p.mu.
+func (p *Profile) addPair(k pair) *history {
+	if len(p.pairs) >= p.maxPairs {
+		var oldest pair
+		var oldestTime time.Time
+		first := true
+		for pk, h := range p.pairs {
+			if first || h.last.Before(oldestTime) {
+				oldest, oldestTime, first = pk, h.last, false
+			}
+		}
+		delete(p.pairs, oldest)
+	}
+	h := &history{failures: profiles.NewSumWindow(p.window)}
+	p.pairs[k] = h
+	return h
+}
+
+// sweep ends the sessions idle for longer than the idle timeout and
+// forgets pairs idle for longer than the history, at most once per sweep
+// interval, and returns the sessions ended. The caller holds p.mu.
+func (p *Profile) sweep(now time.Time) []ended {
+	if now.Sub(p.lastSweep) < p.sweepInterval {
+		return nil
+	}
+	p.lastSweep = now
+
+	var idle []flow
+	for k, s := range p.sessions {
+		if now.Sub(s.last) > p.idle {
+			idle = append(idle, k)
+		}
+	}
+	// End sessions in the order they fell idle, so failures count
+	// towards the sessions after them.
+	slices.SortFunc(idle, func(a, b flow) int {
+		return p.sessions[a].last.Compare(p.sessions[b].last)
+	})
+	var done []ended
+	for _, k := range idle {
+		s := p.sessions[k]
+		delete(p.sessions, k)
+		if e, ok := p.end(k, s); ok {
+			done = append(done, e)
+		}
+	}
+	for k, h := range p.pairs {
+		if now.Sub(h.last) > p.history {
+			delete(p.pairs, k)
+		}
+	}
+	return done
+}
@@ -0,0 +1,287 @@
+package session
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+var (
+	start    = time.Date(2024, time.January, 8, 0, 0, 0, 0, time.UTC)
+	attacker = addr(203, 0, 113, 7)
+	backups  = addr(10, 1, 0, 100)
+)
+
+func addr(a, b, c, d byte) netip.Addr {
+	return netip.AddrFrom4([4]byte{a, b, c, d})
+}
+
+func user(i int) netip.Addr {
+	return addr(10, 0, 0, byte(1+i))
+}
+
+func sshServer(i int) netip.Addr {
+	return addr(10, 1, 0, byte(1+i))
+}
+
+func rdpServer(i int) netip.Addr {
+	return addr(10, 2, 0, byte(1+i))
+}
+
+// connect returns the packets of a session from client to server on port
+// lasting d, sending up and down payload bytes in ten exchanges and
+// closed with a FIN.
+func connect(from time.Time, client, server netip.Addr, port uint16, d time.Duration, up, down int) []guardio.Packet {
+	clientPort := uint16(40000 + from.UnixNano()/int64(time.Millisecond)%20000)
+	out := func(t time.Time, flags uint8, payload int) guardio.Packet {
+		return guardio.Packet{Time: t, Src: client, Dst: server, SrcPort: clientPort, DstPort: port, Protocol: guardio.ProtoTCP, Flags: flags, Payload: payload, Length: payload + 52}
+	}
+	in := func(t time.Time, flags uint8, payload int) guardio.Packet {
+		return guardio.Packet{Time: t, Src: server, Dst: client, SrcPort: port, DstPort: clientPort, Protocol: guardio.ProtoTCP, Flags: flags, Payload: payload, Length: payload + 52}
+	}
+	packets := []guardio.Packet{out(from, guardio.FlagSYN, 0), in(from, guardio.FlagSYN|guardio.FlagACK, 0)}
+	for i := 0; i < 10; i++ {
+		t := from.Add(d * time.Duration(i) / 10)
+		packets = append(packets, out(t, guardio.FlagACK|guardio.FlagPSH, up/10), in(t, guardio.FlagACK|guardio.FlagPSH, down/10))
+	}
+	return append(packets, out(from.Add(d), guardio.FlagFIN|guardio.FlagACK, 0), in(from.Add(d), guardio.FlagFIN|guardio.FlagACK, 0))
+}
+
+// failed returns a failed login from client to server.
+func failed(from time.Time, client, server netip.Addr, port uint16) []guardio.Packet {
+	return connect(from, client, server, port, 2*time.Second, 1500, 3000)
+}
+
+// days returns n working days from from: 30 users on SSH and RDP to the
+// servers they use, now and then after mistyping a password or on a
+// server new to them, and nightly transfers of the backup server.
+func days(rng *rand.Rand, from time.Time, n int) []guardio.Packet {
+	var packets []guardio.Packet
+	for d := 0; d < n; d++ {
+		day := from.AddDate(0, 0, d)
+		for i := 0; i < 30; i++ {
+			for s := 0; s < 2+rng.Intn(4); s++ {
+				t := day.Add(8*time.Hour + time.Duration(rng.Intn(10*3600))*time.Second)
+				server, port := sshServer(i%10), uint16(22)
+				if i%3 == 0 {
+					server, port = rdpServer(i%5), 3389
+				}
+				if rng.Intn(20) == 0 {
+					server = sshServer(rng.Intn(10))
+					port = 22
+				}
+				if rng.Intn(10) == 0 {
+					packets = append(packets, failed(t, user(i), server, port)...)
+					t = t.Add(10 * time.Second)
+				}
+				length := time.Duration(math.Exp(rng.NormFloat64()*0.8+7)) * time.Second
+				up := int(math.Exp(rng.NormFloat64()*0.7 + 11))
+				down := int(math.Exp(rng.NormFloat64()*0.8 + 14))
+				packets = append(packets, connect(t, user(i), server, port, length, up, down)...)
+			}
+		}
+		for s := 0; s < 10; s++ {
+			t := day.Add(2*time.Hour + time.Duration(s)*5*time.Minute)
+			packets = append(packets, connect(t, backups, sshServer(s), 22, time.Duration(60+rng.Intn(180))*time.Second, 200000, 50000000+rng.Intn(50000000))...)
+		}
+	}
+	sortByTime(packets)
+	return packets
+}
+
+func sortByTime(packets []guardio.Packet) {
+	slices.SortStableFunc(packets, func(a, b guardio.Packet) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(append([]Option{WithLocation(time.UTC)}, opts...)...)
+	require.NoError(t, p.Fit(days(rand.New(rand.NewSource(1)), start, 4)))
+	assert.Zero(t, p.Sessions(), "open sessions are not carried into detection")
+	return p
+}
+
+// observeAll feeds packets to p, flushes it after the last and returns
+// the alerts raised.
+func observeAll(t *testing.T, p *Profile, packets []guardio.Packet) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, pkt := range packets {
+		raised, err := p.Observe(pkt)
+		require.NoError(t, err)
+		alerts = append(alerts, raised...)
+	}
+	raised, err := p.Flush(packets[len(packets)-1].Time.Add(24 * time.Hour))
+	require.NoError(t, err)
+	return append(alerts, raised...)
+}
+
+func TestPasswordGuess(t *testing.T) {
+	p := trained(t)
+	live := start.AddDate(0, 0, 7)
+
+	// 40 guesses, three seconds apart, then a login.
+	var packets []guardio.Packet
+	for i := 0; i < 40; i++ {
+		packets = append(packets, failed(live.Add(14*time.Hour+time.Duration(i)*3*time.Second), attacker, sshServer(0), 22)...)
+	}
+	login := live.Add(14*time.Hour + 3*time.Minute)
+	packets = append(packets, connect(login, attacker, sshServer(0), 22, 10*time.Minute, 60000, 500000)...)
+	packets = append(packets, days(rand.New(rand.NewSource(2)), live, 1)...)
+	sortByTime(packets)
+
+	var mine []profiles.Alert
+	for _, a := range observeAll(t, p, packets) {
+		if a.Entity == attacker.String() {
+			mine = append(mine, a)
+		}
+	}
+	require.Len(t, mine, 1, "failed logins are counted, not scored")
+	a := mine[0]
+	assert.Equal(t, Name, a.Profile)
+	assert.Equal(t, login, a.Start)
+	assert.Equal(t, login.Add(10*time.Minute), a.Time)
+	assert.Equal(t, 40.0, a.Features["failed_before"])
+	assert.Equal(t, 1.0, a.Features["new_source"])
+	assert.Zero(t, a.Features["protocol"])
+	assert.NotEmpty(t, a.Severity)
+	assert.Contains(t, a.Message, "SSH session from 203.0.113.7 to 10.1.0.1 at 14:03 lasted 10m0s")
+	assert.Contains(t, a.Message, "from a new client after 40 failed logins")
+}
+
+func TestStolenAccount(t *testing.T) {
+	p := trained(t)
+	live := start.AddDate(0, 0, 7)
+
+	// A user's workstation on a server it never used, at night, for
+	// three hours, pulling 2 GB.
+	packets := connect(live.Add(2*time.Hour+30*time.Minute), user(1), rdpServer(3), 3389, 3*time.Hour, 5000000, 2000000000)
+	alerts := observeAll(t, p, packets)
+	require.Len(t, alerts, 1)
+	a := alerts[0]
+	assert.Equal(t, user(1).String(), a.Entity)
+	assert.Equal(t, 1.0, a.Features["protocol"])
+	assert.InDelta(t, 2.5, a.Features["hour"], 1e-9)
+	assert.Contains(t, a.Message, "RDP session")
+}
+
+func TestNormalDay(t *testing.T) {
+	p := trained(t)
+	packets := days(rand.New(rand.NewSource(3)), start.AddDate(0, 0, 7), 1)
+	assert.LessOrEqual(t, len(observeAll(t, p, packets)), 2)
+}
+
+func TestIdleSession(t *testing.T) {
+	p := trained(t, WithIdleTimeout(time.Hour))
+	live := start.AddDate(0, 0, 7)
+
+	// A night-long session that is never closed.
+	packets := connect(live.Add(time.Hour), user(1), rdpServer(3), 3389, 5*time.Hour, 5000000, 2000000000)
+	packets = packets[:len(packets)-2]
+	for _, pkt := range packets {
+		alerts, err := p.Observe(pkt)
+		require.NoError(t, err)
+		assert.Empty(t, alerts)
+	}
+	assert.Equal(t, 1, p.Sessions())
+
+	last := packets[len(packets)-1].Time
+	alerts, err := p.Flush(last.Add(30 * time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, alerts, "not idle yet")
+
+	alerts, err = p.Flush(last.Add(61 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, last, alerts[0].Time, "the session ended with its last packet")
+	assert.Zero(t, p.Sessions())
+}
+
+func TestTracking(t *testing.T) {
+	p := New(WithLocation(time.UTC))
+	var done []ended
+	for _, pkt := range failed(start, attacker, sshServer(0), 22) {
+		done = append(done, p.track(pkt)...)
+	}
+	assert.Empty(t, done, "a failed login is not scored")
+	assert.Equal(t, 1.0, p.pairs[pair{attacker, sshServer(0)}].failures.Sum())
+
+	// Traffic without a SYN, to other ports or not over TCP is ignored.
+	packets := connect(start, user(0), sshServer(0), 22, time.Minute, 1000, 100000)
+	for _, pkt := range packets[1:] {
+		assert.Empty(t, p.track(pkt))
+	}
+	for _, pkt := range connect(start, user(0), sshServer(0), 443, time.Minute, 1000, 100000) {
+		assert.Empty(t, p.track(pkt))
+	}
+	p.track(guardio.Packet{Time: start, Src: user(0), Dst: sshServer(0), DstPort: 22, Protocol: guardio.ProtoUDP})
+	assert.Zero(t, p.Sessions())
+
+	for _, pkt := range connect(start.Add(time.Minute), attacker, sshServer(0), 22, time.Minute, 1000, 100000) {
+		done = append(done, p.track(pkt)...)
+	}
+	require.Len(t, done, 1)
+	assert.Equal(t, []float64{math.Log10(61), math.Log10(1001), math.Log10(100001), 0 + 1.0/60, 1, 1, 0}, done[0].features)
+}
+
+func TestMaxSessions(t *testing.T) {
+	p := New(WithMaxSessions(3), WithMaxPairs(2))
+	for i := 0; i < 10; i++ {
+		p.track(connect(start.Add(time.Duration(i)*time.Second), user(i), sshServer(0), 22, time.Minute, 0, 0)[0])
+	}
+	assert.Equal(t, 3, p.Sessions())
+
+	for i := 0; i < 5; i++ {
+		for _, pkt := range failed(start.Add(time.Duration(i)*time.Second), user(i), sshServer(1), 22) {
+			p.track(pkt)
+		}
+	}
+	assert.Len(t, p.pairs, 2)
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, err := p.Observe(failed(start, attacker, sshServer(0), 22)[0])
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Flush(start)
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Save()
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+
+	assert.Error(t, p.Fit(days(rand.New(rand.NewSource(4)), start, 1)), "shorter than the warm-up")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+
+	p := New(WithLocation(time.UTC))
+	require.NoError(t, p.Load(saved))
+
+	var packets []guardio.Packet
+	for i := 0; i < 40; i++ {
+		packets = append(packets, failed(start.Add(time.Duration(i)*3*time.Second), attacker, sshServer(0), 22)...)
+	}
+	packets = append(packets, connect(start.Add(3*time.Minute), attacker, sshServer(0), 22, 10*time.Minute, 60000, 500000)...)
+	in := make(chan guardio.Packet, len(packets))
+	for _, pkt := range packets {
+		in <- pkt
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1)
+	assert.Equal(t, attacker.String(), (<-out).Entity)
+}
@@ -0,0 +1,38 @@
+package profiles
+
+// Severities of alerts, from least to most urgent.
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// SeverityMap grades alerts by how far their score exceeds the detector's
+// threshold: an alert exceeding it by at least Critical is critical, by at
+// least High high, by at least Medium medium, and low otherwise.
+type SeverityMap struct {
+	Medium   float64
+	High     float64
+	Critical float64
+}
+
+// DefaultSeverityMap is the SeverityMap of profiles that grade alerts,
+// unless configured otherwise. Isolation forest scores of clear attacks
+// sit 0.1 to 0.2 above the threshold.
+var DefaultSeverityMap = SeverityMap{Medium: 0.03, High: 0.08, Critical: 0.15}
+
+// Grade returns the severity of an alert that scored score against
+// threshold.
+func (m SeverityMap) Grade(score, threshold float64) string {
+	switch margin := score - threshold; {
+	case margin >= m.Critical:
+		return SeverityCritical
+	case margin >= m.High:
+		return SeverityHigh
+	case margin >= m.Medium:
+		return SeverityMedium
+	default:
+		return SeverityLow
+	}
+}
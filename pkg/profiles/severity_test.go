@@ -0,0 +1,26 @@
+package profiles
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeverityMapGrade(t *testing.T) {
+	m := SeverityMap{Medium: 0.0625, High: 0.125, Critical: 0.25}
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{score: 0.4, want: SeverityLow},
+		{score: 0.5, want: SeverityLow},
+		{score: 0.5625, want: SeverityMedium},
+		{score: 0.6, want: SeverityMedium},
+		{score: 0.625, want: SeverityHigh},
+		{score: 0.75, want: SeverityCritical},
+		{score: 1, want: SeverityCritical},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, m.Grade(tt.score, 0.5), "score %v", tt.score)
+	}
+}
@@ -0,0 +1,456 @@
+// Package tls detects TLS handshakes pointing to interception (MITM) or
+// malicious infrastructure. Attackers intercepting traffic present
+// certificates of their own, often self-signed or from an issuer the
+// server never used, and may push clients to weaker versions and cipher
+// suites; their own servers favor self-signed, short-lived or oddly long
+// certificates with few names.
+//
+// Each handshake summarized by pcap.TLSDecoder is scored on the
+// certificate the server presented, if readable: its validity period,
+// whether it is self-signed or expired, its number of subject alternative
+// names, how rare its issuer is and whether the server name was only ever
+// seen with other issuers. It is also scored on the handshake: how many
+// versions below the client's highest offer the server chose, whether
+// either side signalled a downgrade (the client's fallback cipher suite
+// or the server's RFC 8446 sentinel), how rare the chosen cipher suite is
+// and whether it is weak. TLS 1.3 certificates are encrypted, so only the
+// handshake features vary for them.
+//
+// An isolation forest cannot flag a value a feature never took in its
+// training data. So a handshake also raises an alert when it shows an
+// indicator, a self-signed or expired certificate, a changed issuer, a
+// downgrade signal or a weak cipher suite, that no handshake of the
+// baseline showed.
+//
+// Fit keeps the issuers, cipher suites and server names of the baseline,
+// so they are not rare during detection. Save and Load only carry the
+// detector: a loaded profile learns them afresh.
+//
+// Typical use:
+//
+//	p := tls.New()
+//	if err := p.Fit(baseline); err != nil { ... }
+//	handshakes, _ := reader.StreamTLS(ctx, 10000) // a pcap.Reader
+//	go p.Run(ctx, handshakes, alerts)
+package tls
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+// Name identifies the profile in alerts.
+const Name = "tls"
+
+// FeatureNames names the features scored, in vector order. Rarities are
+// log10 of how many handshakes were seen per one with the same issuer or
+// cipher suite. Certificate features are zero for handshakes without a
+// readable certificate.
+var FeatureNames = []string{
+	"certificate", "validity_days", "self_signed", "expired", "san_count", "issuer_rarity", "issuer_changed",
+	"version_downgrade", "downgrade_signal", "cipher_rarity", "weak_cipher",
+}
+
+// indicators holds the indices of the features that alert on their own
+// when no handshake of the baseline showed them.
+var indicators = []int{2, 3, 6, 8, 10}
+
+// maxIssuersPerServer bounds the issuers remembered per server name.
+const maxIssuersPerServer = 16
+
+// Profile detects suspicious TLS handshakes. It is safe for concurrent
+// use.
+type Profile struct {
+	warmup   time.Duration
+	cooldown time.Duration
+	maxNames int
+	severity profiles.SeverityMap
+	detector detectors.Detector
+
+	mu      sync.Mutex
+	trained bool
+	seen    []bool // per feature, whether the baseline ever showed it
+	total   int    // handshakes seen
+	certs   int    // of them with a certificate
+	issuers map[string]*count
+	ciphers map[uint16]int
+	servers map[string]*server
+}
+
+// count is how often an issuer was seen, and when last.
+type count struct {
+	n    int
+	last time.Time
+}
+
+// server is what was seen of one server name.
+type server struct {
+	issuers map[string]struct{}
+	last    time.Time
+	alerted time.Time
+}
+
+// Option configures a Profile.
+type Option func(*Profile)
+
+// WithWarmup sets the span at the start of the baseline whose handshakes
+// only teach the profile issuers, cipher suites and server names: all of
+// them are rare at first. Defaults to one hour.
+func WithWarmup(d time.Duration) Option {
+	return func(p *Profile) {
+		p.warmup = d
+	}
+}
+
+// WithCooldown sets how long a server that raised an alert stays quiet
+// before it can raise another. Defaults to one hour.
+func WithCooldown(d time.Duration) Option {
+	return func(p *Profile) {
+		p.cooldown = d
+	}
+}
+
+// WithMaxNames bounds the server names, and separately the issuers,
+// remembered. When a new one would exceed it, the least recently seen is
+// forgotten. Defaults to 100000.
+func WithMaxNames(n int) Option {
+	return func(p *Profile) {
+		p.maxNames = n
+	}
+}
+
+// WithSeverity sets how alerts are graded. Defaults to
+// profiles.DefaultSeverityMap.
+func WithSeverity(m profiles.SeverityMap) Option {
+	return func(p *Profile) {
+		p.severity = m
+	}
+}
+
+// WithDetector replaces the default detector, an isolation forest tuned
+// for the profile's features.
+func WithDetector(d detectors.Detector) Option {
+	return func(p *Profile) {
+		p.detector = d
+	}
+}
+
+// New creates an untrained Profile.
+func New(opts ...Option) *Profile {
+	p := &Profile{
+		warmup:   time.Hour,
+		cooldown: time.Hour,
+		maxNames: 100000,
+		severity: profiles.DefaultSeverityMap,
+		issuers:  make(map[string]*count),
+		ciphers:  make(map[uint16]int),
+		servers:  make(map[string]*server),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.detector == nil {
+		p.detector = iforest.New(
+			iforest.WithTrees(200),
+			// Every handshake is scored: flag the rarest 0.5% of the
+			// baseline's.
+			iforest.WithContamination(0.005),
+			// Baselines often lack several indicators altogether.
+			iforest.WithExcludeConstant(true),
+			iforest.WithFeatureNames(FeatureNames),
+		)
+	}
+	p.maxNames = max(p.maxNames, 1)
+	return p
+}
+
+// Fit trains the detector on handshakes, a baseline of normal traffic in
+// capture order, after the warm-up, and records which indicators it
+// shows.
+func (p *Profile) Fit(handshakes []guardio.TLSHandshake) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var vectors [][]float64
+	for _, h := range handshakes {
+		features, _ := p.track(h)
+		if h.Time.Sub(handshakes[0].Time) >= p.warmup {
+			vectors = append(vectors, features)
+		}
+	}
+
+	if len(vectors) < 2 {
+		return fmt.Errorf("tls: baseline has %d handshakes after the first %s, need at least 2", len(vectors), p.warmup)
+	}
+	if err := p.detector.Fit(vectors); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	p.calibrate()
+	p.trained = true
+	return nil
+}
+
+// Observe tracks h and returns an alert if it is suspicious.
+func (p *Profile) Observe(h guardio.TLSHandshake) (profiles.Alert, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return profiles.Alert{}, false, profiles.ErrNotTrained
+	}
+	features, s := p.track(h)
+	if !s.alerted.IsZero() && h.Time.Sub(s.alerted) < p.cooldown {
+		return profiles.Alert{}, false, nil
+	}
+
+	score, err := p.detector.PredictOne(features)
+	if err != nil {
+		return profiles.Alert{}, false, fmt.Errorf("tls: %w", err)
+	}
+	threshold := detectors.ThresholdOf(p.detector)
+	unseen := p.unseen(features)
+	if score < threshold && len(unseen) == 0 {
+		return profiles.Alert{}, false, nil
+	}
+	s.alerted = h.Time
+
+	alert := profiles.Alert{
+		Profile:  Name,
+		Entity:   entity(h),
+		Time:     h.Time,
+		Score:    score,
+		Severity: p.severity.Grade(score, threshold),
+		Features: make(map[string]float64, len(FeatureNames)),
+		Message:  message(h, features, unseen),
+	}
+	for i, name := range FeatureNames {
+		alert.Features[name] = features[i]
+	}
+	return alert, true, nil
+}
+
+// Run observes the handshakes from in and sends alerts to out until in is
+// closed or ctx is done. It leaves out open.
+func (p *Profile) Run(ctx context.Context, in <-chan guardio.TLSHandshake, out chan<- profiles.Alert) error {
+	return profiles.Run(ctx, in, out, profiles.One(p.Observe))
+}
+
+// Save serializes the trained detector. Issuers, cipher suites and server
+// names are not saved.
+func (p *Profile) Save() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.trained {
+		return nil, profiles.ErrNotTrained
+	}
+	return p.detector.Save()
+}
+
+// Load restores a detector saved by Save.
+func (p *Profile) Load(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.detector.Load(data); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	p.calibrate()
+	p.trained = true
+	return nil
+}
+
+// Servers returns the number of server names currently remembered.
+func (p *Profile) Servers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.servers)
+}
+
+// calibrate records which features the detector's baseline ever showed,
+// from its training profile if it keeps one; without one, no indicator
+// alerts on its own. The caller holds p.mu.
+func (p *Profile) calibrate() {
+	p.seen = nil
+	d, ok := p.detector.(detectors.Profiler)
+	if !ok {
+		return
+	}
+	profile := d.TrainingProfile()
+	if profile == nil || len(profile.Features) != len(FeatureNames) {
+		return
+	}
+	for _, f := range profile.Features {
+		p.seen = append(p.seen, f.Quantiles[len(f.Quantiles)-1] > 0)
+	}
+}
+
+// unseen returns the names of the indicators features shows that the
+// baseline never did. The caller holds p.mu.
+func (p *Profile) unseen(features []float64) []string {
+	if p.seen == nil {
+		return nil
+	}
+	var names []string
+	for _, i := range indicators {
+		if features[i] > 0 && !p.seen[i] {
+			names = append(names, FeatureNames[i])
+		}
+	}
+	return names
+}
+
+// track records h and returns its features and its server. The caller
+// holds p.mu.
+func (p *Profile) track(h guardio.TLSHandshake) ([]float64, *server) {
+	name := entity(h)
+	s := p.servers[name]
+	if s == nil {
+		s = p.newServer(name)
+	}
+	s.last = h.Time
+
+	features := make([]float64, len(FeatureNames))
+	if c := h.Certificate; c != nil {
+		features[0] = 1
+		features[1] = c.Validity().Hours() / 24
+		features[2] = flag(c.SelfSigned)
+		features[3] = flag(c.Expired(h.Time))
+		features[4] = float64(len(c.SANs))
+
+		issuer := p.issuers[c.Issuer]
+		if issuer == nil {
+			issuer = p.newIssuer(c.Issuer)
+		}
+		features[5] = rarity(p.certs, issuer.n)
+		if _, ok := s.issuers[c.Issuer]; !ok && len(s.issuers) > 0 {
+			features[6] = 1
+		}
+		if len(s.issuers) < maxIssuersPerServer {
+			s.issuers[c.Issuer] = struct{}{}
+		}
+		issuer.n++
+		issuer.last = h.Time
+		p.certs++
+	}
+	features[7] = float64(h.Downgrade())
+	features[8] = flag(h.Fallback || h.DowngradeSentinel)
+	features[9] = rarity(p.total, p.ciphers[h.CipherSuite])
+	features[10] = flag(h.WeakCipher())
+	p.ciphers[h.CipherSuite]++
+	p.total++
+	return features, s
+}
+
+// newServer starts remembering the server with name, first making room
+// if the limit is reached. The caller holds p.mu.
+func (p *Profile) newServer(name string) *server {
+	if len(p.servers) >= p.maxNames {
+		var oldest string
+		var oldestTime time.Time
+		for n, s := range p.servers {
+			if oldest == "" || s.last.Before(oldestTime) {
+				oldest, oldestTime = n, s.last
+			}
+		}
+		delete(p.servers, oldest)
+	}
+	s := &server{issuers: make(map[string]struct{})}
+	p.servers[name] = s
+	return s
+}
+
+// newIssuer starts counting the certificates of issuer, first making room
+// if the limit is reached. The caller holds p.mu.
+func (p *Profile) newIssuer(issuer string) *count {
+	if len(p.issuers) >= p.maxNames {
+		var oldest string
+		var oldestTime time.Time
+		for n, c := range p.issuers {
+			if oldest == "" || c.last.Before(oldestTime) {
+				oldest, oldestTime = n, c.last
+			}
+		}
+		delete(p.issuers, oldest)
+	}
+	c := &count{}
+	p.issuers[issuer] = c
+	return c
+}
+
+// entity returns the server h reached: the name the client asked for, or
+// its address if it asked for none.
+func entity(h guardio.TLSHandshake) string {
+	if h.ServerName != "" {
+		return h.ServerName
+	}
+	return h.Server.String()
+}
+
+// rarity returns log10 of how many items were seen per one like the
+// current, of which n were seen in all.
+func rarity(total, n int) float64 {
+	return math.Log10(float64(total+1) / float64(n+1))
+}
+
+func flag(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// message describes the handshake h of an alert.
+func message(h guardio.TLSHandshake, features []float64, unseen []string) string {
+	var notes []string
+	if c := h.Certificate; c != nil {
+		kind := "certificate"
+		if c.SelfSigned {
+			kind = "self-signed certificate"
+		}
+		notes = append(notes, fmt.Sprintf("%s from %q valid for %.0f days with %d names", kind, c.Issuer, features[1], len(c.SANs)))
+		if features[3] > 0 {
+			notes = append(notes, "expired")
+		}
+		if features[6] > 0 {
+			notes = append(notes, "issuer new for the server")
+		}
+	}
+	notes = append(notes, fmt.Sprintf("%s of %s offered", version(h.Version), version(h.OfferedVersion)))
+	if h.Fallback {
+		notes = append(notes, "client fallback")
+	}
+	if h.DowngradeSentinel {
+		notes = append(notes, "server downgrade sentinel")
+	}
+	cipher := fmt.Sprintf("cipher suite 0x%04x", h.CipherSuite)
+	if features[10] > 0 {
+		cipher = "weak " + cipher
+	}
+	notes = append(notes, cipher)
+	if len(unseen) > 0 {
+		notes = append(notes, "never in baseline: "+strings.Join(unseen, ", "))
+	}
+	return fmt.Sprintf("%s reached %s (%s:%d): %s", h.Client, entity(h), h.Server, h.ServerPort, strings.Join(notes, "; "))
+}
+
+// version names a TLS protocol version.
+func version(v uint16) string {
+	switch {
+	case v == 0x0300:
+		return "SSL 3.0"
+	case v > 0x0300 && v <= 0x0304:
+		return fmt.Sprintf("TLS 1.%d", v-0x0301)
+	default:
+		return fmt.Sprintf("version 0x%04x", v)
+	}
+}
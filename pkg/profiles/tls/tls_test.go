@@ -0,0 +1,280 @@
+package tls
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/profiles"
+)
+
+const (
+	tls10 = 0x0301
+	tls12 = 0x0303
+	tls13 = 0x0304
+)
+
+var (
+	start = time.Unix(1700000000, 0)
+	nas   = netip.MustParseAddr("10.0.1.5")
+)
+
+func clientAddr(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{10, 0, 0, byte(1 + i)})
+}
+
+func serverAddr(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{93, 184, 0, byte(1 + i)})
+}
+
+func certificate(issuer string, days, sans int, at time.Time) *guardio.TLSCertificate {
+	c := &guardio.TLSCertificate{
+		Subject:   "CN=host",
+		Issuer:    issuer,
+		NotBefore: at.Add(-time.Duration(days/2) * 24 * time.Hour),
+		NotAfter:  at.Add(time.Duration(days-days/2) * 24 * time.Hour),
+	}
+	for i := 0; i < sans; i++ {
+		c.SANs = append(c.SANs, fmt.Sprintf("san%d.example", i))
+	}
+	return c
+}
+
+// issuers are the public CAs of the baseline, with their usual validity
+// in days.
+var issuers = []struct {
+	name string
+	days int
+}{
+	{"CN=R3,O=Let's Encrypt", 90},
+	{"CN=DigiCert Global G2,O=DigiCert Inc", 397},
+	{"CN=GTS CA 1C3,O=Google Trust Services LLC", 84},
+	{"CN=Amazon RSA 2048 M02,O=Amazon", 395},
+}
+
+// baseline returns d of handshakes of 30 clients: with 40 sites, mostly
+// over TLS 1.3, some over TLS 1.2 with the certificate of their CA, a
+// few legacy sites stuck on TLS 1.2, and now and then with the self-signed
+// NAS on the internal network.
+func baseline(rng *rand.Rand, from time.Time, d time.Duration) []guardio.TLSHandshake {
+	var handshakes []guardio.TLSHandshake
+	for t := from; t.Before(from.Add(d)); t = t.Add(time.Duration(1+rng.Intn(20)) * time.Second) {
+		site := rng.Intn(40)
+		h := guardio.TLSHandshake{
+			Time:           t,
+			Client:         clientAddr(rng.Intn(30)),
+			Server:         serverAddr(site),
+			ServerPort:     443,
+			ServerName:     fmt.Sprintf("site%d.example", site),
+			OfferedVersion: tls13,
+		}
+		switch r := rng.Intn(100); {
+		case r < 2:
+			h.Server, h.ServerName = nas, "nas.corp"
+			h.Version, h.CipherSuite = tls12, 0xc02f
+			h.Certificate = certificate("CN=nas.corp", 3650, 0, t)
+			h.Certificate.SelfSigned = true
+		case r < 10 || site >= 36:
+			// Legacy sites only speak TLS 1.2.
+			issuer := issuers[site%len(issuers)]
+			h.Version, h.CipherSuite = tls12, []uint16{0xc02f, 0xc030, 0xcca8}[rng.Intn(3)]
+			h.Certificate = certificate(issuer.name, issuer.days, 1+rng.Intn(1+site%8), t)
+		default:
+			h.Version, h.CipherSuite = tls13, []uint16{0x1301, 0x1301, 0x1302, 0x1303}[rng.Intn(4)]
+		}
+		handshakes = append(handshakes, h)
+	}
+	return handshakes
+}
+
+func trained(t *testing.T, opts ...Option) *Profile {
+	t.Helper()
+	p := New(opts...)
+	require.NoError(t, p.Fit(baseline(rand.New(rand.NewSource(1)), start, 12*time.Hour)))
+	return p
+}
+
+// observeAll feeds handshakes to p and returns the alerts raised.
+func observeAll(t *testing.T, p *Profile, handshakes []guardio.TLSHandshake) []profiles.Alert {
+	t.Helper()
+	var alerts []profiles.Alert
+	for _, h := range handshakes {
+		alert, raised, err := p.Observe(h)
+		require.NoError(t, err)
+		if raised {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func TestSuspiciousHandshakes(t *testing.T) {
+	live := start.Add(12 * time.Hour)
+
+	tests := []struct {
+		name      string
+		handshake guardio.TLSHandshake
+		entity    string
+		contains  string
+	}{
+		{
+			name: "interception",
+			handshake: guardio.TLSHandshake{
+				Client: clientAddr(3), Server: serverAddr(38), ServerPort: 443, ServerName: "site38.example",
+				OfferedVersion: tls13, Version: tls12, CipherSuite: 0xc02f,
+				Certificate: certificate("CN=Corp Inspection CA", 397, 1, live),
+			},
+			entity:   "site38.example",
+			contains: "issuer new for the server",
+		},
+		{
+			name: "downgrade",
+			handshake: guardio.TLSHandshake{
+				Client: clientAddr(3), Server: serverAddr(5), ServerPort: 443, ServerName: "site5.example",
+				OfferedVersion: tls13, Version: tls10, CipherSuite: 0x0005, // TLS_RSA_WITH_RC4_128_SHA
+				DowngradeSentinel: true,
+			},
+			entity:   "site5.example",
+			contains: "TLS 1.0 of TLS 1.3 offered",
+		},
+		{
+			name: "malicious server",
+			handshake: guardio.TLSHandshake{
+				Client: clientAddr(3), Server: netip.MustParseAddr("45.9.148.12"), ServerPort: 8443,
+				OfferedVersion: tls13, Version: tls12, CipherSuite: 0xc030,
+				Certificate: certificate("CN=localhost", 3650, 0, live.Add(4000*24*time.Hour)),
+			},
+			entity:   "45.9.148.12",
+			contains: "expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := trained(t)
+			handshakes := baseline(rand.New(rand.NewSource(2)), live, 10*time.Minute)
+			attack := tt.handshake
+			attack.Time = live.Add(5 * time.Minute)
+			at := 0
+			for at < len(handshakes) && handshakes[at].Time.Before(attack.Time) {
+				at++
+			}
+			handshakes = append(handshakes[:at], append([]guardio.TLSHandshake{attack}, handshakes[at:]...)...)
+
+			var alerts []profiles.Alert
+			for _, a := range observeAll(t, p, handshakes) {
+				if a.Entity == tt.entity {
+					alerts = append(alerts, a)
+				}
+			}
+			require.Len(t, alerts, 1)
+			a := alerts[0]
+			assert.Equal(t, Name, a.Profile)
+			assert.Equal(t, attack.Time, a.Time)
+			assert.NotEmpty(t, a.Severity)
+			assert.Contains(t, a.Message, tt.contains)
+			assert.Contains(t, a.Message, "never in baseline")
+		})
+	}
+}
+
+func TestNormalTraffic(t *testing.T) {
+	p := trained(t)
+	alerts := observeAll(t, p, baseline(rand.New(rand.NewSource(3)), start.Add(12*time.Hour), 2*time.Hour))
+	assert.LessOrEqual(t, len(alerts), 3, "of about 700 handshakes")
+}
+
+func TestFeatures(t *testing.T) {
+	p := New()
+	at := start
+	h := guardio.TLSHandshake{
+		Time: at, Server: serverAddr(0), ServerName: "site0.example", OfferedVersion: tls13, Version: tls12, CipherSuite: 0xc02f,
+		Certificate: certificate("CN=CA", 90, 2, at),
+	}
+	features, _ := p.track(h)
+	assert.Equal(t, []float64{1, 90, 0, 0, 2, 0, 0, 1, 0, 0, 0}, features)
+
+	for i := 0; i < 9; i++ {
+		p.track(h)
+	}
+	other := h
+	other.Certificate = certificate("CN=Other CA", 90, 2, at.Add(200*24*time.Hour))
+	other.CipherSuite = 0x000a // TLS_RSA_WITH_3DES_EDE_CBC_SHA
+	other.Fallback = true
+	features, _ = p.track(other)
+	assert.Equal(t, 1.0, features[3], "expired")
+	assert.InDelta(t, 1.04, features[5], 0.01, "an issuer never seen in 10 certificates")
+	assert.Equal(t, 1.0, features[6], "the server only used another issuer")
+	assert.Equal(t, 1.0, features[8])
+	assert.InDelta(t, 1.04, features[9], 0.01)
+	assert.Equal(t, 1.0, features[10])
+
+	resumed := h
+	resumed.Certificate = nil
+	resumed.ServerName = ""
+	features, s := p.track(resumed)
+	assert.Equal(t, []float64{0, 0, 0, 0, 0}, features[:5])
+	assert.Zero(t, features[6])
+	assert.Equal(t, 2, p.Servers(), "servers without a name are tracked by address")
+	assert.Empty(t, s.issuers)
+}
+
+func TestMaxNames(t *testing.T) {
+	p := New(WithMaxNames(3))
+	for i := 0; i < 10; i++ {
+		p.track(guardio.TLSHandshake{
+			Time: start.Add(time.Duration(i) * time.Second), Server: serverAddr(i), ServerName: fmt.Sprintf("site%d.example", i),
+			Certificate: certificate(fmt.Sprintf("CN=CA %d", i), 90, 1, start),
+		})
+	}
+	assert.Equal(t, 3, p.Servers())
+	assert.Len(t, p.issuers, 3)
+	assert.Contains(t, p.servers, "site9.example")
+	assert.NotContains(t, p.servers, "site0.example", "the least recently seen is forgotten")
+}
+
+func TestVersion(t *testing.T) {
+	assert.Equal(t, "SSL 3.0", version(0x0300))
+	assert.Equal(t, "TLS 1.0", version(tls10))
+	assert.Equal(t, "TLS 1.3", version(tls13))
+	assert.Equal(t, "version 0x7f1c", version(0x7f1c))
+}
+
+func TestUntrained(t *testing.T) {
+	p := New()
+	_, _, err := p.Observe(guardio.TLSHandshake{Time: start})
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+	_, err = p.Save()
+	assert.ErrorIs(t, err, profiles.ErrNotTrained)
+
+	assert.Error(t, p.Fit(baseline(rand.New(rand.NewSource(4)), start, 30*time.Minute)), "shorter than the warm-up")
+}
+
+func TestSaveLoadRun(t *testing.T) {
+	saved, err := trained(t).Save()
+	require.NoError(t, err)
+
+	p := New()
+	require.NoError(t, p.Load(saved))
+
+	in := make(chan guardio.TLSHandshake, 2)
+	in <- guardio.TLSHandshake{
+		Time: start, Client: clientAddr(0), Server: serverAddr(1), ServerName: "site1.example",
+		OfferedVersion: tls13, Version: tls10, CipherSuite: 0x0005,
+	}
+	in <- guardio.TLSHandshake{
+		Time: start.Add(time.Second), Client: clientAddr(0), Server: serverAddr(2), ServerName: "site2.example",
+		OfferedVersion: tls13, Version: tls13, CipherSuite: 0x1301,
+	}
+	close(in)
+	out := make(chan profiles.Alert, 10)
+	require.NoError(t, p.Run(context.Background(), in, out))
+	require.Len(t, out, 1, "the weak cipher suite, never in the baseline, alerts after Load")
+	assert.Equal(t, "site1.example", (<-out).Entity)
+}
@@ -0,0 +1,134 @@
+package profiles
+
+import "time"
+
+// DistinctWindow counts the distinct keys added within a sliding time
+// window, such as the ports a source contacted in the last minute. Times
+// are expected in non-decreasing order; an earlier time counts as the
+// latest one seen. A DistinctWindow is not safe for concurrent use.
+type DistinctWindow[K comparable] struct {
+	span   time.Duration
+	latest time.Time
+	last   map[K]time.Time
+	queue  []stamped[K]
+}
+
+type stamped[K any] struct {
+	t time.Time
+	v K
+}
+
+// NewDistinctWindow creates a DistinctWindow spanning span.
+func NewDistinctWindow[K comparable](span time.Duration) *DistinctWindow[K] {
+	return &DistinctWindow[K]{span: span, last: make(map[K]time.Time)}
+}
+
+// Add records k at t, expiring keys not seen since t minus the span, and
+// reports whether k was not in the window before.
+func (w *DistinctWindow[K]) Add(t time.Time, k K) bool {
+	t = w.advance(t)
+	_, seen := w.last[k]
+	w.last[k] = t
+	w.queue = append(w.queue, stamped[K]{t, k})
+	return !seen
+}
+
+// Len returns the number of distinct keys in the window.
+func (w *DistinctWindow[K]) Len() int {
+	return len(w.last)
+}
+
+// Expire drops keys not seen since t minus the span.
+func (w *DistinctWindow[K]) Expire(t time.Time) {
+	w.advance(t)
+}
+
+// advance moves the window to end at t, or at the latest time seen if
+// that is later, and returns the end.
+func (w *DistinctWindow[K]) advance(t time.Time) time.Time {
+	if t.Before(w.latest) {
+		t = w.latest
+	}
+	w.latest = t
+
+	cutoff := t.Add(-w.span)
+	i := 0
+	for ; i < len(w.queue) && !w.queue[i].t.After(cutoff); i++ {
+		e := w.queue[i]
+		if w.last[e.v].Equal(e.t) {
+			delete(w.last, e.v)
+		}
+	}
+	w.queue = compact(w.queue, i)
+	return t
+}
+
+// SumWindow sums values added within a sliding time window, such as the
+// bytes sent in the last second. Times follow the same rules as for
+// DistinctWindow. A SumWindow is not safe for concurrent use.
+type SumWindow struct {
+	span   time.Duration
+	latest time.Time
+	sum    float64
+	queue  []stamped[float64]
+}
+
+// NewSumWindow creates a SumWindow spanning span.
+func NewSumWindow(span time.Duration) *SumWindow {
+	return &SumWindow{span: span}
+}
+
+// Add records v at t, expiring values added at or before t minus the span.
+func (w *SumWindow) Add(t time.Time, v float64) {
+	t = w.advance(t)
+	w.queue = append(w.queue, stamped[float64]{t, v})
+	w.sum += v
+}
+
+// Sum returns the sum of the values in the window.
+func (w *SumWindow) Sum() float64 {
+	return w.sum
+}
+
+// Count returns the number of values in the window.
+func (w *SumWindow) Count() int {
+	return len(w.queue)
+}
+
+// Expire drops values added at or before t minus the span.
+func (w *SumWindow) Expire(t time.Time) {
+	w.advance(t)
+}
+
+func (w *SumWindow) advance(t time.Time) time.Time {
+	if t.Before(w.latest) {
+		t = w.latest
+	}
+	w.latest = t
+
+	cutoff := t.Add(-w.span)
+	i := 0
+	for ; i < len(w.queue) && !w.queue[i].t.After(cutoff); i++ {
+		w.sum -= w.queue[i].v
+	}
+	w.queue = compact(w.queue, i)
+	if len(w.queue) == 0 {
+		// Reset rather than accumulate rounding error.
+		w.sum = 0
+	}
+	return t
+}
+
+// compact drops the first n entries of queue, moving the rest to the front
+// once the dropped part outweighs them so the backing array is reused.
+func compact[T any](queue []T, n int) []T {
+	if n == 0 {
+		return queue
+	}
+	if n < len(queue)-n {
+		return queue[n:]
+	}
+	kept := copy(queue, queue[n:])
+	clear(queue[kept:])
+	return queue[:kept]
+}
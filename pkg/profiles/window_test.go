@@ -0,0 +1,58 @@
+package profiles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistinctWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	w := NewDistinctWindow[string](10 * time.Second)
+
+	assert.True(t, w.Add(at(0), "a"))
+	assert.True(t, w.Add(at(1), "b"))
+	assert.False(t, w.Add(at(5), "a"), "a is still in the window")
+	assert.Equal(t, 2, w.Len())
+
+	w.Expire(at(11))
+	assert.Equal(t, 1, w.Len(), "b expired, a was refreshed at 5")
+
+	assert.True(t, w.Add(at(12), "b"), "b is new again")
+	assert.False(t, w.Add(at(3), "b"), "earlier times count as the latest")
+	w.Expire(at(15))
+	assert.Equal(t, 1, w.Len())
+	w.Expire(at(30))
+	assert.Zero(t, w.Len())
+	assert.Empty(t, w.queue)
+}
+
+func TestSumWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	w := NewSumWindow(time.Second * 3)
+
+	w.Add(at(0), 1.5)
+	w.Add(at(1), 2)
+	w.Add(at(2), 4)
+	assert.Equal(t, 7.5, w.Sum())
+	assert.Equal(t, 3, w.Count())
+
+	w.Add(at(3), 1)
+	assert.Equal(t, 7.0, w.Sum(), "the value added at 0 left the window at 3")
+	assert.Equal(t, 3, w.Count())
+
+	w.Expire(at(100))
+	assert.Zero(t, w.Sum())
+	assert.Zero(t, w.Count())
+}
+
+func TestCompact(t *testing.T) {
+	q := []int{1, 2, 3, 4, 5}
+	assert.Equal(t, []int{2, 3, 4, 5}, compact(q, 1))
+	assert.Equal(t, []int{5}, compact(q, 4))
+	assert.Equal(t, []int{5, 0, 0, 0, 0}, q[:5], "moved to the front and cleared")
+	assert.Empty(t, compact([]int{1}, 1))
+}
@@ -0,0 +1,298 @@
+// Package registry stores trained models with versions, metadata, and tags.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a model, version, or tag does not exist.
+var ErrNotFound = errors.New("registry: not found")
+
+// Backend is the storage used by a Registry.
+// Keys are slash-separated paths.
+type Backend interface {
+	// Put stores data under key, replacing any existing value.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the data stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// List returns all keys with the given prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ModelVersion describes a stored model version.
+type ModelVersion struct {
+	// Name identifies the model lineage.
+	Name string `json:"name"`
+	// Version is assigned by the registry, starting at 1.
+	Version int `json:"version"`
+	// Algorithm is the detector type, e.g. "iforest".
+	Algorithm string `json:"algorithm"`
+	// CreatedAt is when the version was registered.
+	CreatedAt time.Time `json:"created_at"`
+	// Metadata holds free-form training information such as data source.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Metrics holds evaluation results such as AUC or anomaly rate.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	// Tags lists the tags currently pointing at this version.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Registry manages versioned models on a Backend.
+type Registry struct {
+	mu      sync.Mutex
+	backend Backend
+}
+
+// New creates a registry on the given backend.
+func New(backend Backend) *Registry {
+	return &Registry{backend: backend}
+}
+
+// Register stores a new version of the named model and returns its description.
+// The version number is assigned automatically.
+func (r *Registry) Register(ctx context.Context, name string, model []byte, info ModelVersion) (ModelVersion, error) {
+	if name == "" {
+		return ModelVersion{}, errors.New("registry: empty model name")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.versionNumbers(ctx, name)
+	if err != nil {
+		return ModelVersion{}, err
+	}
+
+	info.Name = name
+	info.Version = 1
+	if len(versions) > 0 {
+		info.Version = versions[len(versions)-1] + 1
+	}
+	info.CreatedAt = time.Now().UTC()
+	info.Tags = nil
+
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return ModelVersion{}, err
+	}
+
+	if err := r.backend.Put(ctx, modelKey(name, info.Version), model); err != nil {
+		return ModelVersion{}, err
+	}
+	if err := r.backend.Put(ctx, metaKey(name, info.Version), meta); err != nil {
+		return ModelVersion{}, err
+	}
+
+	return info, nil
+}
+
+// Get returns the model bytes and description of a specific version.
+func (r *Registry) Get(ctx context.Context, name string, version int) ([]byte, ModelVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := r.version(ctx, name, version)
+	if err != nil {
+		return nil, ModelVersion{}, err
+	}
+
+	model, err := r.backend.Get(ctx, modelKey(name, version))
+	if err != nil {
+		return nil, ModelVersion{}, err
+	}
+	return model, info, nil
+}
+
+// Versions returns all versions of the named model, oldest first.
+func (r *Registry) Versions(ctx context.Context, name string) ([]ModelVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	numbers, err := r.versionNumbers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]ModelVersion, 0, len(numbers))
+	for _, n := range numbers {
+		info, err := r.version(ctx, name, n)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, info)
+	}
+	return versions, nil
+}
+
+// Latest returns the version currently carrying tag.
+// An empty tag returns the highest version number.
+func (r *Registry) Latest(ctx context.Context, name, tag string) (ModelVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tag == "" {
+		numbers, err := r.versionNumbers(ctx, name)
+		if err != nil {
+			return ModelVersion{}, err
+		}
+		if len(numbers) == 0 {
+			return ModelVersion{}, ErrNotFound
+		}
+		return r.version(ctx, name, numbers[len(numbers)-1])
+	}
+
+	tags, err := r.loadTags(ctx, name)
+	if err != nil {
+		return ModelVersion{}, err
+	}
+	history := tags[tag]
+	if len(history) == 0 {
+		return ModelVersion{}, ErrNotFound
+	}
+	return r.version(ctx, name, history[len(history)-1])
+}
+
+// Promote points tag at the given version.
+// The previous assignment is kept so it can be restored with Rollback.
+func (r *Registry) Promote(ctx context.Context, name string, version int, tag string) error {
+	if tag == "" {
+		return errors.New("registry: empty tag")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.backend.Get(ctx, metaKey(name, version)); err != nil {
+		return err
+	}
+
+	tags, err := r.loadTags(ctx, name)
+	if err != nil {
+		return err
+	}
+	history := tags[tag]
+	if len(history) > 0 && history[len(history)-1] == version {
+		return nil
+	}
+	tags[tag] = append(history, version)
+
+	return r.saveTags(ctx, name, tags)
+}
+
+// Rollback moves tag back to the version it pointed at before the last Promote
+// and returns that version.
+func (r *Registry) Rollback(ctx context.Context, name, tag string) (ModelVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags, err := r.loadTags(ctx, name)
+	if err != nil {
+		return ModelVersion{}, err
+	}
+	history := tags[tag]
+	if len(history) < 2 {
+		return ModelVersion{}, fmt.Errorf("registry: no earlier version for tag %q", tag)
+	}
+	tags[tag] = history[:len(history)-1]
+
+	if err := r.saveTags(ctx, name, tags); err != nil {
+		return ModelVersion{}, err
+	}
+	return r.version(ctx, name, history[len(history)-2])
+}
+
+// version loads a version description and fills in its current tags.
+func (r *Registry) version(ctx context.Context, name string, version int) (ModelVersion, error) {
+	data, err := r.backend.Get(ctx, metaKey(name, version))
+	if err != nil {
+		return ModelVersion{}, err
+	}
+
+	var info ModelVersion
+	if err := json.Unmarshal(data, &info); err != nil {
+		return ModelVersion{}, fmt.Errorf("registry: decode %s v%d: %w", name, version, err)
+	}
+
+	tags, err := r.loadTags(ctx, name)
+	if err != nil {
+		return ModelVersion{}, err
+	}
+	for tag, history := range tags {
+		if len(history) > 0 && history[len(history)-1] == version {
+			info.Tags = append(info.Tags, tag)
+		}
+	}
+	sort.Strings(info.Tags)
+
+	return info, nil
+}
+
+// versionNumbers returns the stored version numbers in ascending order.
+func (r *Registry) versionNumbers(ctx context.Context, name string) ([]int, error) {
+	keys, err := r.backend.List(ctx, path.Join(name, "versions")+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int
+	for _, key := range keys {
+		if path.Base(key) != "meta.json" {
+			continue
+		}
+		n, err := strconv.Atoi(path.Base(path.Dir(key)))
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// loadTags returns the promotion history of every tag.
+func (r *Registry) loadTags(ctx context.Context, name string) (map[string][]int, error) {
+	tags := make(map[string][]int)
+
+	data, err := r.backend.Get(ctx, tagsKey(name))
+	if errors.Is(err, ErrNotFound) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("registry: decode tags of %s: %w", name, err)
+	}
+	return tags, nil
+}
+
+func (r *Registry) saveTags(ctx context.Context, name string, tags map[string][]int) error {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return r.backend.Put(ctx, tagsKey(name), data)
+}
+
+func modelKey(name string, version int) string {
+	return path.Join(name, "versions", strconv.Itoa(version), "model.bin")
+}
+
+func metaKey(name string, version int) string {
+	return path.Join(name, "versions", strconv.Itoa(version), "meta.json")
+}
+
+func tagsKey(name string) string {
+	return path.Join(name, "tags.json")
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) Backend
+	}{
+		{
+			name: "filesystem",
+			new: func(t *testing.T) Backend {
+				b, err := NewFSBackend(t.TempDir())
+				require.NoError(t, err)
+				return b
+			},
+		},
+		{
+			name: "s3",
+			new: func(t *testing.T) Backend {
+				srv := httptest.NewServer(newFakeS3())
+				t.Cleanup(srv.Close)
+				b, err := NewS3Backend(S3Config{Endpoint: srv.URL, Bucket: "models", Prefix: "registry"})
+				require.NoError(t, err)
+				return b
+			},
+		},
+	}
+
+	for _, bb := range backends {
+		t.Run(bb.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := New(bb.new(t))
+
+			_, err := r.Latest(ctx, "edge", "")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			v1, err := r.Register(ctx, "edge", []byte("model-1"), ModelVersion{
+				Algorithm: "iforest",
+				Metadata:  map[string]string{"source": "eth0"},
+				Metrics:   map[string]float64{"auc": 0.91},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 1, v1.Version)
+
+			v2, err := r.Register(ctx, "edge", []byte("model-2"), ModelVersion{Algorithm: "iforest"})
+			require.NoError(t, err)
+			assert.Equal(t, 2, v2.Version)
+
+			model, info, err := r.Get(ctx, "edge", 1)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("model-1"), model)
+			assert.Equal(t, "eth0", info.Metadata["source"])
+			assert.InDelta(t, 0.91, info.Metrics["auc"], 1e-9)
+
+			latest, err := r.Latest(ctx, "edge", "")
+			require.NoError(t, err)
+			assert.Equal(t, 2, latest.Version)
+
+			require.NoError(t, r.Promote(ctx, "edge", 1, "production"))
+			require.NoError(t, r.Promote(ctx, "edge", 2, "production"))
+
+			prod, err := r.Latest(ctx, "edge", "production")
+			require.NoError(t, err)
+			assert.Equal(t, 2, prod.Version)
+			assert.Equal(t, []string{"production"}, prod.Tags)
+
+			rolled, err := r.Rollback(ctx, "edge", "production")
+			require.NoError(t, err)
+			assert.Equal(t, 1, rolled.Version)
+
+			prod, err = r.Latest(ctx, "edge", "production")
+			require.NoError(t, err)
+			assert.Equal(t, 1, prod.Version)
+
+			_, err = r.Rollback(ctx, "edge", "production")
+			assert.Error(t, err)
+
+			assert.ErrorIs(t, r.Promote(ctx, "edge", 7, "production"), ErrNotFound)
+
+			versions, err := r.Versions(ctx, "edge")
+			require.NoError(t, err)
+			assert.Len(t, versions, 2)
+		})
+	}
+}
+
+func TestFSBackendRejectsEscapingKeys(t *testing.T) {
+	b, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+
+	err = b.Put(context.Background(), "../outside", []byte("x"))
+	assert.Error(t, err)
+}
+
+// fakeS3 is a minimal in-memory S3 server supporting PUT, GET and ListObjectsV2.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/models/")
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[key] = data
+	case r.URL.Query().Get("list-type") == "2":
+		type content struct {
+			Key string `xml:"Key"`
+		}
+		var result struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for k := range s.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, content{Key: k})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		_ = xml.NewEncoder(w).Encode(result)
+	default:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
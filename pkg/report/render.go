@@ -0,0 +1,81 @@
+package report
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// barWidth is the width in characters of the longest Markdown histogram bar.
+const barWidth = 40
+
+var funcs = map[string]any{
+	"percent":     func(v float64) string { return strconv.FormatFloat(100*v, 'f', 1, 64) + "%" },
+	"number":      func(v float64) string { return strconv.FormatFloat(v, 'g', 4, 64) },
+	"maxFraction": maxFraction,
+	"bar":         func(v, max float64) string { return strings.Repeat("#", barLength(v, max)) },
+	"trainingBar": func(v, max float64) string { return strings.Repeat(".", barLength(v, max)) },
+	"width":       func(v, max float64) float64 { return math.Round(1000*scaled(v, max)) / 10 },
+}
+
+var (
+	markdownTemplate = template.Must(template.New("report.md.tmpl").Funcs(funcs).ParseFS(templates, "templates/report.md.tmpl"))
+	htmlTemplate     = htmltemplate.Must(htmltemplate.New("report.html.tmpl").Funcs(funcs).ParseFS(templates, "templates/report.html.tmpl"))
+)
+
+// WriteMarkdown renders the report as Markdown.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	return markdownTemplate.Execute(w, r)
+}
+
+// WriteHTML renders the report as a self-contained HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+// Write renders the report in the named format: "html", "md" or "markdown".
+func (r *Report) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case "html":
+		return r.WriteHTML(w)
+	case "md", "markdown":
+		return r.WriteMarkdown(w)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// maxFraction returns the largest scored or training fraction among bins.
+func maxFraction(bins []Bin) float64 {
+	var m float64
+	for _, b := range bins {
+		m = math.Max(m, math.Max(b.Fraction, b.Training))
+	}
+	return m
+}
+
+// scaled returns v relative to max, in [0, 1].
+func scaled(v, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return math.Min(1, math.Max(0, v/max))
+}
+
+// barLength returns the Markdown bar length for v, at least one character
+// for any non-zero value.
+func barLength(v, max float64) int {
+	n := int(math.Round(barWidth * scaled(v, max)))
+	if n == 0 && v > 0 && max > 0 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+func TestWrite(t *testing.T) {
+	results := []guardio.Result{
+		{Score: 0.3, Features: []float64{1, 2}},
+		{
+			Score:     0.8,
+			IsAnomaly: true,
+			Features:  []float64{1, 90},
+			Explanation: &detectors.Explanation{
+				Top: []detectors.FeatureContribution{
+					{Index: 1, Contribution: 0.9, Value: 90, Typical: &detectors.Range{Low: 1, High: 3}},
+				},
+			},
+		},
+	}
+	r := New(results, nil,
+		WithTitle("Hunt <42>"),
+		WithFeatureNames([]string{"bytes", "dst_port"}),
+		WithTrainingData([][]float64{{1, 2}, {1, 3}}),
+	)
+
+	tests := []struct {
+		format  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			format: "md",
+			want:   []string{"# Hunt <42>", "| 2 | 1 | 50.0% |", "dst_port = 90 (90.0%, typical 1..3)", "### bytes", "#"},
+		},
+		{
+			format: "html",
+			want:   []string{"<title>Hunt &lt;42&gt;</title>", "<strong>dst_port</strong> = 90", "typical 1..3", "bar training"},
+		},
+		{format: "pdf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := r.Write(&buf, tt.format)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, want := range tt.want {
+				assert.Contains(t, buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestBarLength(t *testing.T) {
+	assert.Equal(t, barWidth, barLength(0.5, 0.5))
+	assert.Equal(t, 1, barLength(0.0001, 1))
+	assert.Equal(t, 0, barLength(0, 1))
+	assert.Equal(t, 0, barLength(1, 0))
+}
@@ -0,0 +1,406 @@
+// Package report renders anomaly hunting reports from scored results.
+//
+// A report summarizes the score distribution, lists the top anomalies with
+// their explanations, and compares per-feature histograms of the scored
+// data against training data.
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+)
+
+// Report is the computed content of an anomaly report.
+type Report struct {
+	Title     string
+	Generated time.Time
+	Model     string
+	Threshold float64
+
+	Summary  Summary
+	Scores   []Bin
+	Top      []Anomaly
+	Features []Feature
+	// HasTraining reports whether feature histograms include training data.
+	HasTraining bool
+
+	names    []string
+	training [][]float64
+	topN     int
+	bins     int
+	noise    *privacy.Noise
+}
+
+// Summary describes the scored batch.
+type Summary struct {
+	Samples     int
+	Anomalies   int
+	AnomalyRate float64
+	Mean        float64
+	P50         float64
+	P95         float64
+	P99         float64
+	Max         float64
+}
+
+// Bin is a histogram bucket. Fractions are relative to the total count.
+type Bin struct {
+	Low      float64
+	High     float64
+	Count    int
+	Fraction float64
+	// Training is the fraction of training values in the bucket, if known.
+	Training float64
+}
+
+// Anomaly is one of the highest scoring results.
+type Anomaly struct {
+	Rank      int
+	Timestamp time.Time
+	Score     float64
+	Reasons   []Reason
+	Changes   []Change
+	Metadata  map[string]any
+}
+
+// Reason is a feature that contributed to an anomaly.
+type Reason struct {
+	Feature      string
+	Value        float64
+	Contribution float64
+	Typical      *detectors.Range
+}
+
+// Change is a feature modification that would make an anomaly look normal.
+type Change struct {
+	Feature string
+	From    float64
+	To      float64
+}
+
+// Feature compares one feature's scored values against training.
+type Feature struct {
+	Index      int
+	Name       string
+	Importance float64
+	Bins       []Bin
+}
+
+// Option configures a Report.
+type Option func(*Report)
+
+// WithTitle sets the report title.
+func WithTitle(title string) Option {
+	return func(r *Report) {
+		r.Title = title
+	}
+}
+
+// WithFeatureNames names features in tables and histograms.
+func WithFeatureNames(names []string) Option {
+	return func(r *Report) {
+		r.names = names
+	}
+}
+
+// WithTrainingData adds training distributions to feature histograms.
+func WithTrainingData(data [][]float64) Option {
+	return func(r *Report) {
+		r.training = data
+	}
+}
+
+// WithTopN sets how many of the highest scoring anomalies are listed.
+func WithTopN(n int) Option {
+	return func(r *Report) {
+		r.topN = n
+	}
+}
+
+// WithBins sets the number of histogram buckets.
+func WithBins(n int) Option {
+	return func(r *Report) {
+		r.bins = n
+	}
+}
+
+// WithNoise perturbs the aggregates of the report for differential
+// privacy: the sample and anomaly counts, the histogram counts and
+// fractions of scored and training data, and the mean score. Score
+// quantiles, histogram bounds and the top anomalies describe individual
+// results and are kept; use WithTopN(0) to leave the latter out.
+func WithNoise(n *privacy.Noise) Option {
+	return func(r *Report) {
+		r.noise = n
+	}
+}
+
+// New computes a report for results scored by model. model may be nil;
+// otherwise it provides the threshold, global feature importances, and
+// explanations for anomalies that were scored without one.
+func New(results []guardio.Result, model detectors.Detector, opts ...Option) *Report {
+	r := &Report{
+		Title:     "Anomaly report",
+		Generated: time.Now().UTC(),
+		Threshold: detectors.DefaultConfig().Threshold,
+		topN:      20,
+		bins:      20,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.bins = max(r.bins, 1)
+
+	if model != nil {
+		r.Model = fmt.Sprintf("%T", model)
+		r.Threshold = detectors.ThresholdOf(model)
+	}
+
+	r.HasTraining = len(r.training) > 0
+	r.Summary = summarize(results, r.noise)
+	r.Scores = scoreHistogram(results, r.bins, r.noise)
+	r.Top = r.topAnomalies(results, model)
+	r.Features = r.featureHistograms(results, model)
+
+	return r
+}
+
+func summarize(results []guardio.Result, noise *privacy.Noise) Summary {
+	n := len(results)
+	if n == 0 {
+		return Summary{}
+	}
+
+	scores := make([]float64, n)
+	var sum float64
+	var anomalies int
+	for i, res := range results {
+		scores[i] = res.Score
+		sum += res.Score
+		if res.IsAnomaly {
+			anomalies++
+		}
+	}
+	sort.Float64s(scores)
+
+	s := Summary{
+		Samples:     n,
+		Anomalies:   anomalies,
+		AnomalyRate: float64(anomalies) / float64(n),
+		Mean:        sum / float64(n),
+		P50:         scores[(n-1)*50/100],
+		P95:         scores[(n-1)*95/100],
+		P99:         scores[(n-1)*99/100],
+		Max:         scores[n-1],
+	}
+	if noise != nil {
+		// Scores are in [0, 1], so one result moves the mean by at most 1/n.
+		s.Mean = math.Min(1, math.Max(0, noise.Value(s.Mean, 1/float64(n))))
+		s.Samples = noise.Count(n)
+		s.Anomalies = min(noise.Count(anomalies), s.Samples)
+		s.AnomalyRate = 0
+		if s.Samples > 0 {
+			s.AnomalyRate = float64(s.Anomalies) / float64(s.Samples)
+		}
+	}
+	return s
+}
+
+// scoreHistogram buckets scores over [0, 1].
+func scoreHistogram(results []guardio.Result, bins int, noise *privacy.Noise) []Bin {
+	scores := make([]float64, len(results))
+	for i, res := range results {
+		scores[i] = res.Score
+	}
+	return histogram(scores, nil, 0, 1, bins, noise)
+}
+
+// topAnomalies returns the highest scoring anomalies, explaining them with
+// model when the results do not carry explanations.
+func (r *Report) topAnomalies(results []guardio.Result, model detectors.Detector) []Anomaly {
+	var anomalies []guardio.Result
+	for _, res := range results {
+		if res.IsAnomaly {
+			anomalies = append(anomalies, res)
+		}
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		return anomalies[i].Score > anomalies[j].Score
+	})
+	if len(anomalies) > r.topN {
+		anomalies = anomalies[:r.topN]
+	}
+
+	top := make([]Anomaly, len(anomalies))
+	for i, res := range anomalies {
+		top[i] = Anomaly{
+			Rank:      i + 1,
+			Timestamp: time.Unix(res.Timestamp, 0).UTC(),
+			Score:     res.Score,
+			Metadata:  res.Metadata,
+		}
+
+		exp := res.Explanation
+		if exp == nil && model != nil && len(res.Features) > 0 {
+			if e, err := detectors.Explain(model, res.Features); err == nil {
+				exp = &e
+			}
+		}
+		if exp == nil {
+			continue
+		}
+		for _, fc := range exp.Top {
+			top[i].Reasons = append(top[i].Reasons, Reason{
+				Feature:      r.featureName(fc.Index),
+				Value:        fc.Value,
+				Contribution: fc.Contribution,
+				Typical:      fc.Typical,
+			})
+		}
+		if exp.Counterfactual != nil {
+			for _, c := range exp.Counterfactual.Changes {
+				top[i].Changes = append(top[i].Changes, Change{
+					Feature: r.featureName(c.Index),
+					From:    c.From,
+					To:      c.To,
+				})
+			}
+		}
+	}
+	return top
+}
+
+// featureHistograms compares each feature's scored values with training.
+func (r *Report) featureHistograms(results []guardio.Result, model detectors.Detector) []Feature {
+	nFeatures := 0
+	for _, res := range results {
+		nFeatures = max(nFeatures, len(res.Features))
+	}
+	if nFeatures == 0 {
+		return nil
+	}
+
+	var importances []float64
+	if e, ok := model.(detectors.Explainer); ok {
+		importances = e.FeatureImportances()
+	}
+
+	rows := liveFeatures(results)
+	features := make([]Feature, nFeatures)
+	for j := range features {
+		live := column(rows, j)
+		training := column(r.training, j)
+
+		low, high := bounds(live, training)
+		features[j] = Feature{
+			Index: j,
+			Name:  r.featureName(j),
+			Bins:  histogram(live, training, low, high, r.bins, r.noise),
+		}
+		if j < len(importances) {
+			features[j].Importance = importances[j]
+		}
+	}
+
+	if importances != nil {
+		sort.SliceStable(features, func(a, b int) bool {
+			return features[a].Importance > features[b].Importance
+		})
+	}
+	return features
+}
+
+func (r *Report) featureName(i int) string {
+	if i < len(r.names) && r.names[i] != "" {
+		return r.names[i]
+	}
+	return fmt.Sprintf("f%d", i)
+}
+
+func liveFeatures(results []guardio.Result) [][]float64 {
+	rows := make([][]float64, len(results))
+	for i, res := range results {
+		rows[i] = res.Features
+	}
+	return rows
+}
+
+// column extracts feature j from rows that have it.
+func column(rows [][]float64, j int) []float64 {
+	values := make([]float64, 0, len(rows))
+	for _, row := range rows {
+		if j < len(row) && !math.IsNaN(row[j]) && !math.IsInf(row[j], 0) {
+			values = append(values, row[j])
+		}
+	}
+	return values
+}
+
+// bounds returns the range covering all values.
+func bounds(sets ...[]float64) (low, high float64) {
+	low, high = math.Inf(1), math.Inf(-1)
+	for _, values := range sets {
+		for _, v := range values {
+			low = math.Min(low, v)
+			high = math.Max(high, v)
+		}
+	}
+	if math.IsInf(low, 1) {
+		return 0, 1
+	}
+	return low, high
+}
+
+// histogram buckets values, and optionally training values, into equal-width
+// bins over [low, high], perturbing the counts if noise is set.
+func histogram(values, training []float64, low, high float64, bins int, noise *privacy.Noise) []Bin {
+	out := make([]Bin, bins)
+	width := (high - low) / float64(bins)
+	for i := range out {
+		out[i].Low = low + float64(i)*width
+		out[i].High = low + float64(i+1)*width
+	}
+
+	bucket := func(v float64) int {
+		if width <= 0 {
+			return 0
+		}
+		return min(bins-1, max(0, int((v-low)/width)))
+	}
+
+	for _, v := range values {
+		out[bucket(v)].Count++
+	}
+	counts := make([]int, bins)
+	for _, v := range training {
+		counts[bucket(v)]++
+	}
+
+	total, trainingTotal := len(values), len(training)
+	if noise != nil {
+		// Bins are disjoint, so the histogram as a whole costs one epsilon.
+		total, trainingTotal = 0, 0
+		for i := range out {
+			out[i].Count = noise.Count(out[i].Count)
+			counts[i] = noise.Count(counts[i])
+			total += out[i].Count
+			trainingTotal += counts[i]
+		}
+	}
+	for i := range out {
+		if total > 0 {
+			out[i].Fraction = float64(out[i].Count) / float64(total)
+		}
+		if trainingTotal > 0 {
+			out[i].Training = float64(counts[i]) / float64(trainingTotal)
+		}
+	}
+	return out
+}
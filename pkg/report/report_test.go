@@ -0,0 +1,193 @@
+package report
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/privacy"
+)
+
+func TestNew(t *testing.T) {
+	training := generateTestData(300, 3)
+	model := iforest.New(iforest.WithTrees(50), iforest.WithSeed(42))
+	require.NoError(t, model.Fit(training))
+
+	results := scoreAll(t, model, append(generateTestData(97, 3),
+		[]float64{0, 50, 0},
+		[]float64{0, 0, -40},
+		[]float64{30, 30, 30},
+	))
+
+	r := New(results, model,
+		WithTopN(2),
+		WithBins(10),
+		WithFeatureNames([]string{"bytes", "packets"}),
+		WithTrainingData(training),
+	)
+
+	assert.Equal(t, 100, r.Summary.Samples)
+	assert.GreaterOrEqual(t, r.Summary.Anomalies, 3)
+	assert.Equal(t, model.Threshold(), r.Threshold)
+	assert.True(t, r.HasTraining)
+
+	require.Len(t, r.Scores, 10)
+	total := 0
+	for _, b := range r.Scores {
+		total += b.Count
+	}
+	assert.Equal(t, 100, total)
+
+	require.Len(t, r.Top, 2)
+	assert.Equal(t, 1, r.Top[0].Rank)
+	assert.GreaterOrEqual(t, r.Top[0].Score, r.Top[1].Score)
+	assert.NotEmpty(t, r.Top[0].Reasons, "explanations are computed from the model")
+
+	require.Len(t, r.Features, 3)
+	names := map[string]bool{}
+	for i, f := range r.Features {
+		names[f.Name] = true
+		assert.Len(t, f.Bins, 10)
+		if i > 0 {
+			assert.GreaterOrEqual(t, r.Features[i-1].Importance, f.Importance)
+		}
+	}
+	assert.Equal(t, map[string]bool{"bytes": true, "packets": true, "f2": true}, names)
+}
+
+func TestNewWithNoise(t *testing.T) {
+	results := make([]guardio.Result, 1000)
+	for i := range results {
+		results[i] = guardio.Result{Score: float64(i%10)/10 + 0.05, IsAnomaly: i%10 == 9, Features: []float64{float64(i % 7)}}
+	}
+	noise, err := privacy.NewNoise(1)
+	require.NoError(t, err)
+
+	r := New(results, nil, WithNoise(noise), WithBins(10), WithTrainingData([][]float64{{1}, {2}, {3}}))
+
+	assert.InDelta(t, 1000, r.Summary.Samples, 30)
+	assert.InDelta(t, 100, r.Summary.Anomalies, 30)
+	assert.InDelta(t, 0.1, r.Summary.AnomalyRate, 0.05)
+	assert.InDelta(t, 0.5, r.Summary.Mean, 0.05)
+	assert.Equal(t, results[9].Score, r.Summary.Max, "quantiles are exact")
+
+	var fraction float64
+	for _, b := range r.Scores {
+		assert.GreaterOrEqual(t, b.Count, 0)
+		assert.InDelta(t, 100, b.Count, 30)
+		fraction += b.Fraction
+	}
+	assert.InDelta(t, 1, fraction, 1e-9)
+	require.Len(t, r.Features, 1)
+	for _, b := range r.Features[0].Bins {
+		assert.GreaterOrEqual(t, b.Training, 0.0)
+	}
+}
+
+func TestNewWithoutModel(t *testing.T) {
+	exp := &detectors.Explanation{
+		Top: []detectors.FeatureContribution{{Index: 1, Contribution: 0.8, Value: 9}},
+		Counterfactual: &detectors.Counterfactual{
+			Found:   true,
+			Changes: []detectors.FeatureChange{{Index: 1, From: 9, To: 1}},
+		},
+	}
+	results := []guardio.Result{
+		{Score: 0.2},
+		{Score: 0.9, IsAnomaly: true, Explanation: exp},
+	}
+
+	r := New(results, nil)
+
+	assert.Equal(t, detectors.DefaultConfig().Threshold, r.Threshold)
+	assert.Empty(t, r.Model)
+	assert.Nil(t, r.Features)
+	require.Len(t, r.Top, 1)
+	assert.Equal(t, []Reason{{Feature: "f1", Value: 9, Contribution: 0.8}}, r.Top[0].Reasons)
+	assert.Equal(t, []Change{{Feature: "f1", From: 9, To: 1}}, r.Top[0].Changes)
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []float64
+		training     []float64
+		low, high    float64
+		wantCounts   []int
+		wantTraining []float64
+	}{
+		{
+			name:       "equal width buckets",
+			values:     []float64{0, 0.1, 0.5, 0.99, 1},
+			low:        0,
+			high:       1,
+			wantCounts: []int{2, 3},
+		},
+		{
+			name:         "with training",
+			values:       []float64{1},
+			training:     []float64{0, 0, 0, 2},
+			low:          0,
+			high:         2,
+			wantCounts:   []int{0, 1},
+			wantTraining: []float64{0.75, 0.25},
+		},
+		{
+			name:       "constant values",
+			values:     []float64{3, 3},
+			low:        3,
+			high:       3,
+			wantCounts: []int{2, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bins := histogram(tt.values, tt.training, tt.low, tt.high, 2, nil)
+			counts := make([]int, len(bins))
+			training := make([]float64, len(bins))
+			for i, b := range bins {
+				counts[i] = b.Count
+				training[i] = b.Training
+			}
+			assert.Equal(t, tt.wantCounts, counts)
+			if tt.wantTraining != nil {
+				assert.Equal(t, tt.wantTraining, training)
+			}
+		})
+	}
+}
+
+func scoreAll(t *testing.T, model *iforest.IsolationForest, data [][]float64) []guardio.Result {
+	t.Helper()
+
+	scores, err := model.Predict(data)
+	require.NoError(t, err)
+
+	results := make([]guardio.Result, len(scores))
+	for i, score := range scores {
+		results[i] = guardio.Result{
+			Timestamp: int64(i),
+			Score:     score,
+			IsAnomaly: score >= model.Threshold(),
+			Features:  data[i],
+		}
+	}
+	return results
+}
+
+func generateTestData(n, features int) [][]float64 {
+	data := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		data[i] = make([]float64, features)
+		for j := 0; j < features; j++ {
+			data[i][j] = rand.NormFloat64()
+		}
+	}
+	return data
+}
@@ -0,0 +1,408 @@
+// Package retrain keeps models fresh by retraining them on a schedule.
+//
+// A Retrainer runs on a cron-like Schedule. Every run pulls fresh data
+// from a Reader, fits a new detector on all but a holdout of it, and
+// validates the detector before promoting it: its scores must be sane on
+// the holdout, of the right range and not all alike, and, if configured,
+// it must flag few enough holdout samples, separate a labeled holdout of
+// known anomalies with a minimum AUC, and pass custom checks. Only a
+// detector passing validation reaches the Promoter, which puts it in
+// service: into a model registry, a tenant of a manager.Manager, or
+// anywhere else.
+//
+// Typical use:
+//
+//	schedule, _ := retrain.ParseSchedule("30 2 * * *")
+//	r := retrain.New(schedule, openReader, newDetector,
+//		retrain.ToRegistry(reg, "edge", "iforest", "production"),
+//		retrain.WithMaxAnomalyRate(0.05),
+//	)
+//	go r.Run(ctx)
+package retrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/manager"
+	"github.com/hed1ad/goguardml/pkg/registry"
+)
+
+// ErrValidation is returned by runs whose detector failed validation and
+// was not promoted.
+var ErrValidation = errors.New("retrain: validation failed")
+
+// Source opens the Reader a run pulls its data from. Readers of
+// unbounded sources, such as network listeners, need a record or
+// duration limit so the read ends.
+type Source func(ctx context.Context) (guardio.Reader, error)
+
+// Factory creates the untrained detector a run fits.
+type Factory func() detectors.Detector
+
+// Promoter puts a validated detector in service.
+type Promoter func(ctx context.Context, d detectors.Detector, report Report) error
+
+// Validator is a custom check of a fitted detector against the holdout.
+// A non-nil error rejects the detector.
+type Validator func(d detectors.Detector, holdout [][]float64) error
+
+// Report describes a run.
+type Report struct {
+	// Start and End bound the run.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Samples is the number of samples pulled, Holdout the number kept
+	// out of training for validation.
+	Samples int `json:"samples"`
+	Holdout int `json:"holdout"`
+	// Metrics holds the validation results: "anomaly_rate", the share of
+	// holdout samples at or above the threshold; "score_mean" and
+	// "score_std" over the holdout; and "auc" on the labeled holdout if
+	// one is configured.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	// Promoted reports whether the detector was put in service.
+	Promoted bool `json:"promoted"`
+	// Err is why the run did not promote a detector, if it did not.
+	Err error `json:"-"`
+}
+
+// Option configures a Retrainer.
+type Option func(*Retrainer)
+
+// WithHoldout sets the fraction of the pulled samples, the most recent
+// ones, kept out of training to validate on. Defaults to 0.2.
+func WithHoldout(fraction float64) Option {
+	return func(r *Retrainer) {
+		r.holdout = fraction
+	}
+}
+
+// WithMinSamples sets how many samples a run needs to train at all.
+// Defaults to 100.
+func WithMinSamples(n int) Option {
+	return func(r *Retrainer) {
+		r.minSamples = n
+	}
+}
+
+// WithMaxAnomalyRate rejects detectors flagging more than rate of the
+// holdout, normal recent data, as anomalous. Zero, the default, disables
+// the check.
+func WithMaxAnomalyRate(rate float64) Option {
+	return func(r *Retrainer) {
+		r.maxAnomalyRate = rate
+	}
+}
+
+// WithLabeledHoldout validates detectors on data with known labels, true
+// for anomalies, rejecting those whose AUC is below minAUC. The labeled
+// holdout must hold both normal samples and anomalies.
+func WithLabeledHoldout(data [][]float64, labels []bool, minAUC float64) Option {
+	return func(r *Retrainer) {
+		r.labeled, r.labels, r.minAUC = data, labels, minAUC
+	}
+}
+
+// WithValidator adds a custom check. Repeated options accumulate.
+func WithValidator(v Validator) Option {
+	return func(r *Retrainer) {
+		r.validators = append(r.validators, v)
+	}
+}
+
+// WithReportHandler sets a function called with the report of every run
+// Run makes, successful or not.
+func WithReportHandler(fn func(Report)) Option {
+	return func(r *Retrainer) {
+		r.onReport = fn
+	}
+}
+
+// Retrainer retrains and promotes a detector on a schedule.
+type Retrainer struct {
+	schedule Schedule
+	source   Source
+	factory  Factory
+	promote  Promoter
+
+	holdout        float64
+	minSamples     int
+	maxAnomalyRate float64
+	labeled        [][]float64
+	labels         []bool
+	minAUC         float64
+	validators     []Validator
+	onReport       func(Report)
+
+	now func() time.Time
+}
+
+// New creates a Retrainer running on schedule, pulling data from source,
+// fitting detectors made by factory and handing those that pass
+// validation to promote.
+func New(schedule Schedule, source Source, factory Factory, promote Promoter, opts ...Option) *Retrainer {
+	r := &Retrainer{
+		schedule:   schedule,
+		source:     source,
+		factory:    factory,
+		promote:    promote,
+		holdout:    0.2,
+		minSamples: 100,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.holdout = math.Min(math.Max(r.holdout, 0), 0.9)
+	r.minSamples = max(r.minSamples, 2)
+	return r
+}
+
+// Run retrains at every time of the schedule until ctx is done, and
+// returns ctx.Err(). A failed run does not stop it; its report goes to
+// the report handler.
+func (r *Retrainer) Run(ctx context.Context) error {
+	for {
+		next := r.schedule.Next(r.now())
+		if next.IsZero() {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		timer := time.NewTimer(next.Sub(r.now()))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		report, _ := r.RunOnce(ctx)
+		if r.onReport != nil {
+			r.onReport(report)
+		}
+	}
+}
+
+// RunOnce retrains now: it pulls data, fits a detector, validates it and
+// promotes it if it passes. The error, also in the report, wraps
+// ErrValidation if the detector failed validation.
+func (r *Retrainer) RunOnce(ctx context.Context) (Report, error) {
+	report := Report{Start: r.now()}
+	err := r.run(ctx, &report)
+	report.End = r.now()
+	report.Err = err
+	return report, err
+}
+
+func (r *Retrainer) run(ctx context.Context, report *Report) error {
+	data, err := r.pull(ctx)
+	if err != nil {
+		return err
+	}
+	report.Samples = len(data)
+	if len(data) < r.minSamples {
+		return fmt.Errorf("retrain: %d samples pulled, need %d", len(data), r.minSamples)
+	}
+
+	split := len(data) - int(float64(len(data))*r.holdout)
+	train, holdout := data[:split], data[split:]
+	report.Holdout = len(holdout)
+
+	d := r.factory()
+	if err := d.Fit(train); err != nil {
+		return fmt.Errorf("retrain: fit: %w", err)
+	}
+	if report.Metrics, err = r.validate(d, holdout); err != nil {
+		return err
+	}
+
+	if err := r.promote(ctx, d, *report); err != nil {
+		return fmt.Errorf("retrain: promote: %w", err)
+	}
+	report.Promoted = true
+	return nil
+}
+
+// reader is implemented by Readers that can stop reading on demand.
+type reader interface {
+	ReadContext(ctx context.Context) ([][]float64, error)
+}
+
+// pull reads the samples of a run.
+func (r *Retrainer) pull(ctx context.Context) ([][]float64, error) {
+	src, err := r.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrain: open source: %w", err)
+	}
+	defer src.Close()
+
+	var data [][]float64
+	if rc, ok := src.(reader); ok {
+		data, err = rc.ReadContext(ctx)
+	} else {
+		data, err = src.Read()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("retrain: read: %w", err)
+	}
+	return data, nil
+}
+
+// validate checks d against the holdout and returns the metrics it
+// computed on the way.
+func (r *Retrainer) validate(d detectors.Detector, holdout [][]float64) (map[string]float64, error) {
+	metrics := make(map[string]float64)
+	if len(holdout) > 0 {
+		scores, err := d.Predict(holdout)
+		if err != nil {
+			return metrics, fmt.Errorf("%w: score holdout: %v", ErrValidation, err)
+		}
+		if err := sane(scores); err != nil {
+			return metrics, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+
+		threshold := detectors.ThresholdOf(d)
+		var sum, sumSq float64
+		flagged := 0
+		for _, s := range scores {
+			sum += s
+			sumSq += s * s
+			if s >= threshold {
+				flagged++
+			}
+		}
+		n := float64(len(scores))
+		mean := sum / n
+		metrics["score_mean"] = mean
+		metrics["score_std"] = math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+		metrics["anomaly_rate"] = float64(flagged) / n
+		if r.maxAnomalyRate > 0 && metrics["anomaly_rate"] > r.maxAnomalyRate {
+			return metrics, fmt.Errorf("%w: holdout anomaly rate %.4f above %.4f", ErrValidation, metrics["anomaly_rate"], r.maxAnomalyRate)
+		}
+	}
+
+	if r.labeled != nil {
+		scores, err := d.Predict(r.labeled)
+		if err != nil {
+			return metrics, fmt.Errorf("%w: score labeled holdout: %v", ErrValidation, err)
+		}
+		auc, err := AUC(scores, r.labels)
+		if err != nil {
+			return metrics, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+		metrics["auc"] = auc
+		if auc < r.minAUC {
+			return metrics, fmt.Errorf("%w: AUC %.4f below %.4f", ErrValidation, auc, r.minAUC)
+		}
+	}
+
+	for _, v := range r.validators {
+		if err := v(d, holdout); err != nil {
+			return metrics, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+	}
+	return metrics, nil
+}
+
+// sane checks that scores are in [0, 1] and not all equal, as those of a
+// detector that learned nothing would be.
+func sane(scores []float64) error {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range scores {
+		if math.IsNaN(s) || s < 0 || s > 1 {
+			return fmt.Errorf("holdout score %v outside [0, 1]", s)
+		}
+		lo, hi = math.Min(lo, s), math.Max(hi, s)
+	}
+	if len(scores) > 1 && lo == hi {
+		return fmt.Errorf("all holdout scores are %v", lo)
+	}
+	return nil
+}
+
+// AUC returns the area under the ROC curve of scores against labels, true
+// for anomalies: the probability that an anomaly scores higher than a
+// normal sample, ties counting half.
+func AUC(scores []float64, labels []bool) (float64, error) {
+	if len(scores) != len(labels) {
+		return 0, fmt.Errorf("%d scores for %d labels", len(scores), len(labels))
+	}
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] < scores[order[b]] })
+
+	// Mann-Whitney U: sum the ranks of the anomalies, tied scores sharing
+	// their mean rank.
+	var rankSum float64
+	positives := 0
+	for i := 0; i < len(order); {
+		j := i
+		for j < len(order) && scores[order[j]] == scores[order[i]] {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, k := range order[i:j] {
+			if labels[k] {
+				rankSum += rank
+				positives++
+			}
+		}
+		i = j
+	}
+	negatives := len(labels) - positives
+	if positives == 0 || negatives == 0 {
+		return 0, errors.New("AUC needs both normal samples and anomalies")
+	}
+	p := float64(positives)
+	return (rankSum - p*(p+1)/2) / (p * float64(negatives)), nil
+}
+
+// ToRegistry promotes detectors by registering them as a new version of
+// the named model, with the run's metrics, and pointing tag at it.
+func ToRegistry(reg *registry.Registry, name, algorithm, tag string) Promoter {
+	return func(ctx context.Context, d detectors.Detector, report Report) error {
+		model, err := d.Save()
+		if err != nil {
+			return err
+		}
+		info, err := reg.Register(ctx, name, model, registry.ModelVersion{
+			Algorithm: algorithm,
+			Metadata:  map[string]string{"source": "retrain", "samples": fmt.Sprint(report.Samples)},
+			Metrics:   report.Metrics,
+		})
+		if err != nil {
+			return err
+		}
+		return reg.Promote(ctx, name, info.Version, tag)
+	}
+}
+
+// ToManager promotes detectors by putting them in service for a tenant of
+// m, replacing its current detector or adding the tenant.
+func ToManager(m *manager.Manager, tenant string) Promoter {
+	return func(_ context.Context, d detectors.Detector, _ Report) error {
+		_, err := m.Swap(tenant, d)
+		if errors.Is(err, manager.ErrUnknownTenant) {
+			err = m.Add(tenant, d)
+		}
+		return err
+	}
+}
+
+// ToHandover promotes detectors by staging them on h, which puts them in
+// service once they have warmed up on live traffic and their threshold
+// has been calibrated.
+func ToHandover(h *detectors.Handover) Promoter {
+	return func(_ context.Context, d detectors.Detector, _ Report) error {
+		return h.Stage(d)
+	}
+}
@@ -0,0 +1,293 @@
+package retrain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/manager"
+	"github.com/hed1ad/goguardml/pkg/registry"
+)
+
+// sliceReader reads a fixed dataset.
+type sliceReader struct {
+	data   [][]float64
+	closed bool
+}
+
+func (r *sliceReader) Read() ([][]float64, error) { return r.data, nil }
+func (r *sliceReader) Stream(context.Context) (<-chan []float64, error) {
+	return nil, errors.New("not streamed")
+}
+func (r *sliceReader) StreamSamples(context.Context) (<-chan guardio.Sample, error) {
+	return nil, errors.New("not streamed")
+}
+func (r *sliceReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func gaussian(center float64, n int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{center + rng.NormFloat64(), center + rng.NormFloat64()}
+	}
+	return data
+}
+
+func source(data [][]float64) (Source, *sliceReader) {
+	r := &sliceReader{data: data}
+	return func(context.Context) (guardio.Reader, error) { return r, nil }, r
+}
+
+func forest() detectors.Detector {
+	return iforest.New(iforest.WithTrees(50), iforest.WithSeed(7))
+}
+
+// promotions records the detectors promoted.
+type promotions struct {
+	mu       sync.Mutex
+	promoted []detectors.Detector
+	reports  []Report
+}
+
+func (p *promotions) promote(_ context.Context, d detectors.Detector, report Report) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.promoted = append(p.promoted, d)
+	p.reports = append(p.reports, report)
+	return nil
+}
+
+func labeled() ([][]float64, []bool) {
+	data := gaussian(0, 50, 3)
+	labels := make([]bool, len(data))
+	for _, anomaly := range gaussian(8, 10, 4) {
+		data = append(data, anomaly)
+		labels = append(labels, true)
+	}
+	return data, labels
+}
+
+func TestRunOncePromotes(t *testing.T) {
+	src, reader := source(gaussian(0, 500, 1))
+	data, labels := labeled()
+	var p promotions
+	r := New(every(time.Hour), src, forest, p.promote,
+		WithMaxAnomalyRate(0.3), WithLabeledHoldout(data, labels, 0.9))
+
+	report, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.Promoted)
+	assert.True(t, reader.closed)
+	assert.Equal(t, 500, report.Samples)
+	assert.Equal(t, 100, report.Holdout)
+	assert.Greater(t, report.Metrics["auc"], 0.9)
+	assert.LessOrEqual(t, report.Metrics["anomaly_rate"], 0.3)
+	assert.Positive(t, report.Metrics["score_std"])
+	require.Len(t, p.promoted, 1)
+	assert.Equal(t, report.Metrics, p.reports[0].Metrics)
+}
+
+func TestRunOnceRejects(t *testing.T) {
+	data, labels := labeled()
+	inverted := make([]bool, len(labels))
+	for i, l := range labels {
+		inverted[i] = !l
+	}
+
+	tests := []struct {
+		name    string
+		data    [][]float64
+		factory Factory
+		opts    []Option
+		wantErr error
+	}{
+		{
+			name:    "too few samples",
+			data:    gaussian(0, 50, 1),
+			factory: forest,
+		},
+		{
+			name:    "anomaly rate",
+			data:    gaussian(0, 500, 1),
+			factory: func() detectors.Detector { return iforest.New(iforest.WithTrees(50), iforest.WithContamination(0.4)) },
+			opts:    []Option{WithMaxAnomalyRate(0.1)},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "AUC",
+			data:    gaussian(0, 500, 1),
+			factory: forest,
+			opts:    []Option{WithLabeledHoldout(data, inverted, 0.8)},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "degenerate scores",
+			data:    gaussian(0, 500, 1),
+			factory: func() detectors.Detector { return constant{} },
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "custom validator",
+			data:    gaussian(0, 500, 1),
+			factory: forest,
+			opts: []Option{WithValidator(func(detectors.Detector, [][]float64) error {
+				return errors.New("not on a Friday")
+			})},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "fit fails",
+			data:    append([][]float64{{1}}, gaussian(0, 500, 1)...),
+			factory: forest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _ := source(tt.data)
+			var p promotions
+			report, err := New(every(time.Hour), src, tt.factory, p.promote, tt.opts...).RunOnce(context.Background())
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NotErrorIs(t, err, ErrValidation)
+			}
+			assert.Equal(t, err, report.Err)
+			assert.False(t, report.Promoted)
+			assert.Empty(t, p.promoted)
+		})
+	}
+}
+
+// constant is a detector scoring everything 0.5.
+type constant struct{}
+
+func (constant) Fit([][]float64) error { return nil }
+func (constant) Predict(data [][]float64) ([]float64, error) {
+	scores := make([]float64, len(data))
+	for i := range scores {
+		scores[i] = 0.5
+	}
+	return scores, nil
+}
+func (constant) PredictOne([]float64) (float64, error) { return 0.5, nil }
+func (constant) Save() ([]byte, error)                 { return nil, nil }
+func (constant) Load([]byte) error                     { return nil }
+func (constant) SaveTo(io.Writer) error                { return nil }
+func (constant) LoadFrom(io.Reader) error              { return nil }
+
+func (c constant) PredictContext(_ context.Context, data [][]float64) ([]float64, error) {
+	return c.Predict(data)
+}
+
+func TestRun(t *testing.T) {
+	src, _ := source(gaussian(0, 200, 1))
+	var p promotions
+	reports := make(chan Report, 10)
+	r := New(every(10*time.Millisecond), src, forest, p.promote, WithReportHandler(func(r Report) { reports <- r }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	for range 2 {
+		select {
+		case report := <-reports:
+			assert.True(t, report.Promoted)
+			assert.NoError(t, report.Err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no run")
+		}
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestToRegistry(t *testing.T) {
+	backend, err := registry.NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+	reg := registry.New(backend)
+	src, _ := source(gaussian(0, 300, 1))
+	r := New(every(time.Hour), src, forest, ToRegistry(reg, "edge", "iforest", "production"))
+
+	for range 2 {
+		_, err := r.RunOnce(context.Background())
+		require.NoError(t, err)
+	}
+	info, err := reg.Latest(context.Background(), "edge", "production")
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Version)
+	assert.Equal(t, "iforest", info.Algorithm)
+	assert.Contains(t, info.Metrics, "anomaly_rate")
+
+	model, _, err := reg.Get(context.Background(), "edge", 2)
+	require.NoError(t, err)
+	assert.NoError(t, iforest.New().Load(model))
+}
+
+func TestToManager(t *testing.T) {
+	m := manager.New()
+	src, _ := source(gaussian(0, 300, 1))
+	r := New(every(time.Hour), src, forest, ToManager(m, "acme"))
+
+	_, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	first, ok := m.Detector("acme")
+	require.True(t, ok)
+	_, err = r.RunOnce(context.Background())
+	require.NoError(t, err)
+	second, _ := m.Detector("acme")
+	assert.NotSame(t, first, second)
+}
+
+func TestToHandover(t *testing.T) {
+	live := forest()
+	require.NoError(t, live.Fit(gaussian(0, 300, 2)))
+	h := detectors.NewHandover(live)
+	src, _ := source(gaussian(0, 300, 1))
+	r := New(every(time.Hour), src, forest, ToHandover(h))
+
+	_, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	_, ok := h.Staged()
+	assert.True(t, ok, "the candidate warms up first")
+	assert.Same(t, live, h.Live())
+}
+
+func TestAUC(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float64
+		labels []bool
+		want   float64
+	}{
+		{"perfect", []float64{0.1, 0.2, 0.8, 0.9}, []bool{false, false, true, true}, 1},
+		{"inverted", []float64{0.9, 0.8, 0.2, 0.1}, []bool{false, false, true, true}, 0},
+		{"ties count half", []float64{0.5, 0.5}, []bool{false, true}, 0.5},
+		{"mixed", []float64{0.1, 0.4, 0.35, 0.8}, []bool{false, false, true, true}, 0.75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AUC(tt.scores, tt.labels)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-12)
+		})
+	}
+
+	_, err := AUC([]float64{0.1}, []bool{true})
+	assert.Error(t, err, "no normal sample")
+	_, err = AUC([]float64{0.1}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,341 @@
+// Package router dispatches samples to per-source detectors.
+//
+// A Router holds one detector per key (interface name, tenant ID, protocol,
+// ...) so heterogeneous segments are scored by models trained on their own
+// traffic, each with its own threshold and counters.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// ErrNoRoute is returned when no detector is registered for a key and no
+// fallback is configured.
+var ErrNoRoute = errors.New("router: no detector for key")
+
+// Sample is a feature vector tagged with its routing key.
+type Sample struct {
+	Key      string
+	Features []float64
+}
+
+// Stats holds per-route counters.
+type Stats struct {
+	// Samples is the number of successfully scored samples.
+	Samples uint64 `json:"samples"`
+	// Anomalies is the number of samples at or above the threshold.
+	Anomalies uint64 `json:"anomalies"`
+	// Errors is the number of samples the detector rejected.
+	Errors uint64 `json:"errors"`
+	// Threshold is the route's current anomaly threshold.
+	Threshold float64 `json:"threshold"`
+}
+
+// route is a registered detector with its threshold and counters.
+type route struct {
+	detector detectors.Detector
+	// override is the route's own threshold, nil to use the detector's.
+	override atomic.Pointer[float64]
+
+	samples   atomic.Uint64
+	anomalies atomic.Uint64
+	errors    atomic.Uint64
+}
+
+func (rt *route) currentThreshold() float64 {
+	if t := rt.override.Load(); t != nil {
+		return *t
+	}
+	return detectors.ThresholdOf(rt.detector)
+}
+
+// RouteOption configures a single route.
+type RouteOption func(*route)
+
+// WithThreshold overrides the detector's own threshold for a route.
+func WithThreshold(t float64) RouteOption {
+	return func(rt *route) {
+		rt.override.Store(&t)
+	}
+}
+
+// Router maps keys to detectors.
+type Router struct {
+	mu       sync.RWMutex
+	routes   map[string]*route
+	fallback *route
+	onReject detectors.RejectFunc
+}
+
+// Option configures a Router.
+type Option func(*Router)
+
+// WithFallback sets the detector used for keys without a dedicated route.
+func WithFallback(d detectors.Detector, opts ...RouteOption) Option {
+	return func(r *Router) {
+		r.fallback = newRoute(d, opts)
+	}
+}
+
+// WithRejectHandler makes Stream pass samples it cannot score to fn
+// instead of dropping them.
+func WithRejectHandler(fn detectors.RejectFunc) Option {
+	return func(r *Router) {
+		r.onReject = fn
+	}
+}
+
+// New creates an empty Router.
+func New(opts ...Option) *Router {
+	r := &Router{
+		routes: make(map[string]*route),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func newRoute(d detectors.Detector, opts []RouteOption) *route {
+	rt := &route{detector: d}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	return rt
+}
+
+// Add registers (or replaces) the detector for key.
+// By default the detector's own threshold is used.
+func (r *Router) Add(key string, d detectors.Detector, opts ...RouteOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[key] = newRoute(d, opts)
+}
+
+// Remove unregisters the detector for key.
+func (r *Router) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes, key)
+}
+
+// Keys returns the registered keys in sorted order.
+func (r *Router) Keys() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]string, 0, len(r.routes))
+	for key := range r.routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Detector returns the detector registered for key, if any.
+func (r *Router) Detector(key string) (detectors.Detector, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rt, ok := r.routes[key]
+	if !ok {
+		return nil, false
+	}
+	return rt.detector, true
+}
+
+// Resolve returns the detector that scores key: its own route's detector,
+// otherwise the fallback. It returns ErrNoRoute if neither exists.
+func (r *Router) Resolve(key string) (detectors.Detector, error) {
+	rt, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return rt.detector, nil
+}
+
+func (r *Router) lookup(key string) (*route, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if rt, ok := r.routes[key]; ok {
+		return rt, nil
+	}
+	if r.fallback != nil {
+		return r.fallback, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoRoute, key)
+}
+
+// Threshold returns the threshold applied to samples for key.
+func (r *Router) Threshold(key string) (float64, error) {
+	rt, err := r.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return rt.currentThreshold(), nil
+}
+
+// SetThreshold overrides the threshold of the route for key, as
+// WithThreshold does when it is added. Keys without a route of their own
+// set the fallback's threshold, which Threshold reports for them. It
+// takes effect for samples scored afterwards.
+func (r *Router) SetThreshold(key string, t float64) error {
+	rt, err := r.lookup(key)
+	if err != nil {
+		return err
+	}
+	rt.override.Store(&t)
+	return nil
+}
+
+// Explain explains a sample with the detector registered for key.
+// It returns detectors.ErrNotExplainable if that detector cannot explain scores.
+func (r *Router) Explain(key string, features []float64) (detectors.Explanation, error) {
+	d, err := r.Resolve(key)
+	if err != nil {
+		return detectors.Explanation{}, err
+	}
+	return detectors.Explain(d, features)
+}
+
+// Score scores a single sample with the detector registered for key.
+func (r *Router) Score(key string, features []float64) (detectors.Score, error) {
+	rt, err := r.lookup(key)
+	if err != nil {
+		return detectors.Score{}, err
+	}
+	return rt.score(key, features)
+}
+
+// ScoreBatch scores many samples for the same key.
+func (r *Router) ScoreBatch(key string, data [][]float64) ([]detectors.Score, error) {
+	return r.ScoreBatchContext(context.Background(), key, data)
+}
+
+// ScoreBatchContext is ScoreBatch, returning ctx.Err() if ctx is done
+// before the samples are scored.
+func (r *Router) ScoreBatchContext(ctx context.Context, key string, data [][]float64) ([]detectors.Score, error) {
+	rt, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := rt.detector.PredictContext(ctx, data)
+	if err != nil {
+		if ctx.Err() == nil {
+			rt.errors.Add(uint64(len(data)))
+		}
+		return nil, err
+	}
+
+	threshold := rt.currentThreshold()
+	scores := make([]detectors.Score, len(values))
+	for i, v := range values {
+		scores[i] = rt.record(key, v, threshold, data[i])
+	}
+	return scores, nil
+}
+
+func (rt *route) score(key string, features []float64) (detectors.Score, error) {
+	v, err := rt.detector.PredictOne(features)
+	if err != nil {
+		rt.errors.Add(1)
+		return detectors.Score{}, err
+	}
+	return rt.record(key, v, rt.currentThreshold(), features), nil
+}
+
+func (rt *route) record(key string, value, threshold float64, features []float64) detectors.Score {
+	rt.samples.Add(1)
+	isAnomaly := value >= threshold
+	if isAnomaly {
+		rt.anomalies.Add(1)
+	}
+	return detectors.Score{
+		Value:     value,
+		IsAnomaly: isAnomaly,
+		Features:  features,
+		Metadata:  map[string]any{"route": key},
+	}
+}
+
+var _ detectors.RejectReporter = (*Router)(nil)
+
+// SetRejectHandler sets the handler Stream passes samples it cannot score
+// to. It applies to streams started afterwards.
+func (r *Router) SetRejectHandler(fn detectors.RejectFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReject = fn
+}
+
+// Stream scores samples from input until it is closed or ctx is canceled.
+// Samples without a route, or rejected by their detector, are passed to the
+// reject handler, if any, and skipped; the error names the sample's key.
+// The output channel is closed when Stream returns.
+func (r *Router) Stream(ctx context.Context, input <-chan Sample, output chan<- detectors.Score) error {
+	defer close(output)
+
+	r.mu.RLock()
+	reject := r.onReject
+	r.mu.RUnlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sample, ok := <-input:
+			if !ok {
+				return nil
+			}
+
+			score, err := r.Score(sample.Key, sample.Features)
+			if err != nil {
+				if reject != nil {
+					reject(detectors.Rejection{Sample: sample.Features, Err: fmt.Errorf("route %q: %w", sample.Key, err)})
+				}
+				continue
+			}
+
+			select {
+			case output <- score:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters for every route.
+// The fallback route, if configured, is reported under the empty key.
+func (r *Router) Stats() map[string]Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := make(map[string]Stats, len(r.routes)+1)
+	for key, rt := range r.routes {
+		stats[key] = rt.stats()
+	}
+	if r.fallback != nil {
+		stats[""] = r.fallback.stats()
+	}
+	return stats
+}
+
+func (rt *route) stats() Stats {
+	return Stats{
+		Samples:   rt.samples.Load(),
+		Anomalies: rt.anomalies.Load(),
+		Errors:    rt.errors.Load(),
+		Threshold: rt.currentThreshold(),
+	}
+}
@@ -0,0 +1,209 @@
+package router
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestScore(t *testing.T) {
+	eth0 := trainedForest(t, 0)
+	eth1 := trainedForest(t, 100)
+
+	tests := []struct {
+		name        string
+		router      *Router
+		key         string
+		sample      []float64
+		wantErr     error
+		wantAnomaly bool
+	}{
+		{
+			name:   "routes to matching detector",
+			router: newTestRouter(eth0, eth1),
+			key:    "eth1",
+			sample: []float64{100, 100, 100},
+		},
+		{
+			name:        "other segment's traffic is anomalous",
+			router:      newTestRouter(eth0, eth1),
+			key:         "eth0",
+			sample:      []float64{100, 100, 100},
+			wantAnomaly: true,
+		},
+		{
+			name:    "unknown key without fallback",
+			router:  newTestRouter(eth0, eth1),
+			key:     "eth2",
+			sample:  []float64{0, 0, 0},
+			wantErr: ErrNoRoute,
+		},
+		{
+			name:   "unknown key uses fallback",
+			router: New(WithFallback(eth0)),
+			key:    "eth2",
+			sample: []float64{0, 0, 0},
+		},
+		{
+			name: "per-route threshold override",
+			router: func() *Router {
+				r := New()
+				r.Add("eth0", eth0, WithThreshold(0))
+				return r
+			}(),
+			key:         "eth0",
+			sample:      []float64{0, 0, 0},
+			wantAnomaly: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, err := tt.router.Score(tt.key, tt.sample)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAnomaly, score.IsAnomaly)
+			assert.Equal(t, tt.key, score.Metadata["route"])
+		})
+	}
+}
+
+func TestStreamAndStats(t *testing.T) {
+	r := newTestRouter(trainedForest(t, 0), trainedForest(t, 100))
+
+	input := make(chan Sample, 3)
+	output := make(chan detectors.Score, 4)
+	input <- Sample{Key: "eth0", Features: []float64{0, 0, 0}}
+	input <- Sample{Key: "eth1", Features: []float64{100, 100, 100}}
+	input <- Sample{Key: "unknown", Features: []float64{0, 0, 0}}
+	close(input)
+
+	require.NoError(t, r.Stream(context.Background(), input, output))
+
+	var n int
+	for range output {
+		n++
+	}
+	assert.Equal(t, 2, n)
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(1), stats["eth0"].Samples)
+	assert.Equal(t, uint64(1), stats["eth1"].Samples)
+	assert.Equal(t, []string{"eth0", "eth1"}, r.Keys())
+}
+
+func TestScoreBatchContext(t *testing.T) {
+	r := newTestRouter(trainedForest(t, 0), trainedForest(t, 100))
+	data := [][]float64{{0, 0, 0}, {1, 1, 1}}
+
+	scores, err := r.ScoreBatchContext(context.Background(), "eth0", data)
+	require.NoError(t, err)
+	assert.Len(t, scores, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.ScoreBatchContext(ctx, "eth0", data)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	stats := r.Stats()["eth0"]
+	assert.Equal(t, uint64(2), stats.Samples)
+	assert.Zero(t, stats.Errors, "canceled batches are not the detector's errors")
+}
+
+func TestStreamRejects(t *testing.T) {
+	r := newTestRouter(trainedForest(t, 0), trainedForest(t, 100))
+	var rejected []detectors.Rejection
+	r.SetRejectHandler(func(rej detectors.Rejection) {
+		rejected = append(rejected, rej)
+	})
+
+	input := make(chan Sample, 3)
+	output := make(chan detectors.Score, 3)
+	input <- Sample{Key: "eth0", Features: []float64{0, 0, 0}}
+	input <- Sample{Key: "unknown", Features: []float64{1, 1, 1}}
+	input <- Sample{Key: "eth1", Features: []float64{100, 100}}
+	close(input)
+	require.NoError(t, r.Stream(context.Background(), input, output))
+
+	require.Len(t, rejected, 2)
+	assert.ErrorIs(t, rejected[0].Err, ErrNoRoute)
+	assert.Equal(t, []float64{1, 1, 1}, rejected[0].Sample)
+	assert.ErrorContains(t, rejected[1].Err, `route "eth1"`)
+	assert.Equal(t, uint64(1), r.Stats()["eth1"].Errors)
+}
+
+func newTestRouter(eth0, eth1 detectors.Detector) *Router {
+	r := New()
+	r.Add("eth0", eth0)
+	r.Add("eth1", eth1)
+	return r
+}
+
+func trainedForest(t *testing.T, center float64) *iforest.IsolationForest {
+	t.Helper()
+
+	data := make([][]float64, 300)
+	for i := range data {
+		data[i] = []float64{
+			center + rand.NormFloat64(),
+			center + rand.NormFloat64(),
+			center + rand.NormFloat64(),
+		}
+	}
+
+	f := iforest.New(iforest.WithTrees(30), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(data))
+	return f
+}
+
+func TestResolve(t *testing.T) {
+	eth0 := trainedForest(t, 0)
+	fallback := trainedForest(t, 100)
+
+	r := New(WithFallback(fallback))
+	r.Add("eth0", eth0)
+
+	d, err := r.Resolve("eth0")
+	require.NoError(t, err)
+	assert.Same(t, eth0, d)
+
+	d, err = r.Resolve("eth9")
+	require.NoError(t, err)
+	assert.Same(t, fallback, d)
+
+	_, err = New().Resolve("eth0")
+	assert.ErrorIs(t, err, ErrNoRoute)
+
+	threshold, err := r.Threshold("eth9")
+	require.NoError(t, err)
+	assert.Equal(t, fallback.Threshold(), threshold)
+
+	r.Add("eth1", eth0, WithThreshold(0.9))
+	threshold, err = r.Threshold("eth1")
+	require.NoError(t, err)
+	assert.Equal(t, 0.9, threshold)
+
+	require.NoError(t, r.SetThreshold("eth0", 0.75))
+	threshold, err = r.Threshold("eth0")
+	require.NoError(t, err)
+	assert.Equal(t, 0.75, threshold)
+	assert.Equal(t, 0.75, r.Stats()["eth0"].Threshold)
+	require.NoError(t, r.SetThreshold("eth9", 0.5), "keys without a route set the fallback's")
+	threshold, err = r.Threshold("eth8")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, threshold)
+	assert.ErrorIs(t, New().SetThreshold("eth9", 0.5), ErrNoRoute)
+
+	exp, err := r.Explain("eth0", []float64{0, 0, 50})
+	require.NoError(t, err)
+	assert.Len(t, exp.Contributions, 3)
+}
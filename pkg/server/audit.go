@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/hed1ad/goguardml/pkg/audit"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// WithAuditLog records every prediction served to l. Requests fail with
+// 500 if their predictions cannot be recorded.
+func WithAuditLog(l *audit.Logger) Option {
+	return func(s *Server) {
+		s.audit = l
+	}
+}
+
+// modelVersions caches audit.ModelVersion per detector, since computing it
+// serializes the whole model.
+type modelVersions struct {
+	mu       sync.Mutex
+	versions map[detectors.Detector]string
+}
+
+func (m *modelVersions) get(d detectors.Detector) (string, error) {
+	if d == nil || !reflect.TypeOf(d).Comparable() {
+		return audit.ModelVersion(d)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.versions[d]; ok {
+		return v, nil
+	}
+	v, err := audit.ModelVersion(d)
+	if err != nil {
+		return "", err
+	}
+	if m.versions == nil {
+		m.versions = make(map[detectors.Detector]string)
+	}
+	m.versions[d] = v
+	return v, nil
+}
+
+// auditResults records the results of scoring samples with d. route is
+// the router key, or "" for the default detector.
+func (s *Server) auditResults(r *http.Request, route string, d detectors.Detector, threshold float64, samples [][]float64, results []guardio.Result) error {
+	if s.audit == nil {
+		return nil
+	}
+
+	version, err := s.versions.get(d)
+	if err != nil {
+		return err
+	}
+	client := clientName(r.Context())
+	for i, res := range results {
+		rec := audit.Record{
+			Source:    "http",
+			Route:     route,
+			Client:    client,
+			Model:     version,
+			Threshold: threshold,
+			Score:     res.Score,
+			IsAnomaly: res.IsAnomaly,
+		}
+		if err := s.audit.Log(rec, samples[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/audit"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+func TestAuditLog(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(20), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+	version, err := audit.ModelVersion(f)
+	require.NoError(t, err)
+
+	rt := router.New()
+	rt.Add("eth0", f, router.WithThreshold(0.9))
+
+	var buf bytes.Buffer
+	logger := audit.New(audit.NewJSONLSink(&buf))
+	srv := New(f,
+		WithRouter(rt),
+		WithAuditLog(logger),
+		WithAPIKeys(APIKey{Name: "ci", Key: "secret"}),
+	)
+
+	for _, path := range []string{"/v1/predict", "/v1/predict/eth0"} {
+		body := bytes.NewBufferString(`{"samples": [[0.1, 0.2, 0.3], [100, 100, 100]]}`)
+		req := httptest.NewRequest(http.MethodPost, path, body)
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var records []audit.Record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec audit.Record
+		require.NoError(t, dec.Decode(&rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 4)
+
+	for i, rec := range records {
+		assert.Equal(t, "ci", rec.Client)
+		assert.Equal(t, version, rec.Model)
+		assert.False(t, rec.Time.IsZero())
+		if i < 2 {
+			assert.Empty(t, rec.Route)
+			assert.Equal(t, f.Threshold(), rec.Threshold)
+		} else {
+			assert.Equal(t, "eth0", rec.Route)
+			assert.Equal(t, 0.9, rec.Threshold)
+		}
+	}
+	assert.Equal(t, audit.HashInput([]float64{100, 100, 100}), records[1].InputHash)
+	assert.True(t, records[1].IsAnomaly)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.Header.Set("X-API-Key", "secret")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	var stats AdminStats
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+	require.NotNil(t, stats.Audit)
+	assert.Equal(t, uint64(4), stats.Audit.Logged)
+}
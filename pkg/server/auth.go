@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication errors.
+var (
+	ErrUnauthorized = errors.New("invalid or missing API key")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+)
+
+// APIKey is a credential accepted by the server.
+type APIKey struct {
+	// Name identifies the client in logs and metrics.
+	Name string
+	// Key is the secret presented in the Authorization or X-API-Key header.
+	Key string
+	// RateLimit is the sustained requests per second allowed; 0 means unlimited.
+	RateLimit float64
+	// Burst is the number of requests allowed above the rate; defaults to
+	// the rate rounded up.
+	Burst int
+}
+
+// Authenticator validates API keys and enforces per-key rate limits.
+// It is shared by all transports so limits apply across them.
+type Authenticator struct {
+	keys map[[sha256.Size]byte]*keyState
+	now  func() time.Time
+}
+
+type keyState struct {
+	name string
+
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewAuthenticator creates an Authenticator accepting the given keys.
+func NewAuthenticator(keys ...APIKey) *Authenticator {
+	a := &Authenticator{
+		keys: make(map[[sha256.Size]byte]*keyState, len(keys)),
+		now:  time.Now,
+	}
+	for _, k := range keys {
+		burst := float64(k.Burst)
+		if burst <= 0 {
+			burst = math.Max(1, math.Ceil(k.RateLimit))
+		}
+		a.keys[sha256.Sum256([]byte(k.Key))] = &keyState{
+			name:   k.Name,
+			rate:   k.RateLimit,
+			burst:  burst,
+			tokens: burst,
+		}
+	}
+	return a
+}
+
+// Check validates key and consumes one request from its rate limit.
+// It returns the key's name on success.
+func (a *Authenticator) Check(key string) (string, error) {
+	if key == "" {
+		return "", ErrUnauthorized
+	}
+	// Keys are looked up by digest so lookup time does not depend on
+	// how many leading bytes of a guess match a real key.
+	state, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", ErrUnauthorized
+	}
+	if !state.allow(a.now()) {
+		return state.name, ErrRateLimited
+	}
+	return state.name, nil
+}
+
+// allow implements a token bucket.
+func (k *keyState) allow(now time.Time) bool {
+	if k.rate <= 0 {
+		return true
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.last.IsZero() {
+		k.tokens = math.Min(k.burst, k.tokens+now.Sub(k.last).Seconds()*k.rate)
+	}
+	k.last = now
+
+	if k.tokens < 1 {
+		return false
+	}
+	k.tokens--
+	return true
+}
+
+// WithAPIKeys requires one of the given API keys on every endpoint except
+// the /healthz and /readyz probes and the static files of the dashboard.
+func WithAPIKeys(keys ...APIKey) Option {
+	return WithAuthenticator(NewAuthenticator(keys...))
+}
+
+// WithAuthenticator requires requests to pass a, e.g. one shared with other servers.
+func WithAuthenticator(a *Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// WithTLS serves HTTPS using the given certificate and key files.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// WithClientCA enables mutual TLS: clients must present a certificate
+// signed by a CA in caFile. Requires WithTLS.
+func WithClientCA(caFile string) Option {
+	return func(s *Server) {
+		s.clientCAFile = caFile
+	}
+}
+
+// authMiddleware enforces API keys when an Authenticator is configured.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	if s.auth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || s.dashboardPage(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, err := s.auth.Check(requestKey(r))
+		switch {
+		case errors.Is(err, ErrRateLimited):
+			w.Header().Set("Retry-After", strconv.Itoa(1))
+			writeError(w, http.StatusTooManyRequests, err)
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goguardml"`)
+			writeError(w, http.StatusUnauthorized, err)
+		default:
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, name)))
+		}
+	})
+}
+
+// clientKey is the context key of the authenticated API key name.
+type clientKey struct{}
+
+// clientName returns the name of the API key that authenticated the
+// request, or "" when authentication is disabled.
+func clientName(ctx context.Context) string {
+	name, _ := ctx.Value(clientKey{}).(string)
+	return name
+}
+
+// requestKey extracts the API key from the Authorization or X-API-Key header.
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// tlsConfig builds the server TLS configuration, or nil for plain HTTP.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.certFile == "" && s.keyFile == "" {
+		if s.clientCAFile != "" {
+			return nil, errors.New("client CA requires a server certificate")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.clientCAFile != "" {
+		pem, err := os.ReadFile(s.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
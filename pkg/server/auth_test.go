@@ -0,0 +1,187 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestAPIKeys(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	srv := New(f, WithAPIKeys(APIKey{Name: "soc", Key: "s3cret"}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "missing key", method: http.MethodGet, path: "/admin/stats", wantStatus: http.StatusUnauthorized},
+		{
+			name: "wrong key", method: http.MethodGet, path: "/admin/stats",
+			header:     map[string]string{"Authorization": "Bearer guess"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "bearer token", method: http.MethodGet, path: "/admin/stats",
+			header:     map[string]string{"Authorization": "Bearer s3cret"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "api key header", method: http.MethodGet, path: "/admin/stats",
+			header:     map[string]string{"X-API-Key": "s3cret"},
+			wantStatus: http.StatusOK,
+		},
+		{name: "probes are open", method: http.MethodGet, path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestAuthenticatorRateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewAuthenticator(APIKey{Name: "agent", Key: "k", RateLimit: 2, Burst: 2})
+	a.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		name, err := a.Check("k")
+		require.NoError(t, err)
+		assert.Equal(t, "agent", name)
+	}
+	_, err := a.Check("k")
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	now = now.Add(500 * time.Millisecond)
+	_, err = a.Check("k")
+	assert.NoError(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCA(t)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+	serverCert, serverKey := newTestCert(t, ca, caKey, "localhost")
+	writePEM(t, filepath.Join(dir, "server.pem"), "CERTIFICATE", serverCert.Raw)
+	writeKey(t, filepath.Join(dir, "server-key.pem"), serverKey)
+
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	srv := New(f,
+		WithTLS(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")),
+		WithClientCA(filepath.Join(dir, "ca.pem")),
+	)
+
+	cfg, err := srv.tlsConfig()
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	t.Run("client without certificate is rejected", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+		_, err := client.Get(ts.URL + "/healthz")
+		assert.Error(t, err)
+	})
+
+	t.Run("client with certificate is accepted", func(t *testing.T) {
+		clientCert, clientKey := newTestCert(t, ca, caKey, "agent")
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{clientCert.Raw},
+				PrivateKey:  clientKey,
+			}},
+		}}}
+		resp, err := client.Get(ts.URL + "/healthz")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func newTestCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+func writeKey(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, path, "EC PRIVATE KEY", der)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+const (
+	// dashboardAnomalies is the number of recent anomalies the dashboard
+	// shows.
+	dashboardAnomalies = 50
+	// throughputSeconds is the span of the dashboard's throughput series,
+	// in one-second buckets.
+	throughputSeconds = 60
+)
+
+// WithDashboard serves a web dashboard for live monitoring at /ui/: the
+// score distribution, recent anomalies with explanations, scoring
+// throughput and the model and its threshold, refreshed from
+// GET /ui/state. With API keys the page itself is public, and asks for a
+// key to fetch the state with.
+func WithDashboard() Option {
+	return func(s *Server) {
+		s.dashboard = newDashboard(time.Now)
+	}
+}
+
+// DashboardState is the body of GET /ui/state.
+type DashboardState struct {
+	Model ModelStats `json:"model"`
+	// Card is the model card, if the detector records one.
+	Card         *detectors.ModelCard `json:"card,omitempty"`
+	Scores       WindowStats          `json:"scores"`
+	Distribution stats.Snapshot       `json:"distribution"`
+	// Throughput counts samples scored per second over the last minute,
+	// oldest first.
+	Throughput []ThroughputPoint `json:"throughput"`
+	// Rate is the mean samples per second over the same minute.
+	Rate float64 `json:"rate"`
+	// Anomalies lists the most recent anomalies, newest first.
+	Anomalies []DashboardAnomaly `json:"anomalies"`
+	Uptime    string             `json:"uptime"`
+}
+
+// ThroughputPoint is one second of scoring.
+type ThroughputPoint struct {
+	Time      int64 `json:"time"`
+	Samples   int   `json:"samples"`
+	Anomalies int   `json:"anomalies"`
+}
+
+// DashboardAnomaly is a recent anomaly. Explanation is computed when the
+// dashboard first shows it, if the detector explains its scores.
+type DashboardAnomaly struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route,omitempty"`
+	Score     float64   `json:"score"`
+	Threshold float64   `json:"threshold"`
+	Features  []float64 `json:"features"`
+	// FeatureNames names the features, from the model card.
+	FeatureNames []string               `json:"feature_names,omitempty"`
+	Explanation  *detectors.Explanation `json:"explanation,omitempty"`
+}
+
+// dashboard keeps what the dashboard shows beyond the admin statistics.
+type dashboard struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	anomalies  []recentAnomaly // ring, next is the oldest once full
+	next       int
+	throughput [throughputSeconds]ThroughputPoint // indexed by second
+}
+
+// recentAnomaly is a DashboardAnomaly with the detector to explain it.
+type recentAnomaly struct {
+	DashboardAnomaly
+	detector  detectors.Detector
+	explained bool
+}
+
+func newDashboard(now func() time.Time) *dashboard {
+	return &dashboard{now: now, anomalies: make([]recentAnomaly, 0, dashboardAnomalies)}
+}
+
+// record counts the scores of samples by d with threshold, keeping the
+// anomalous samples. route is the router key, or "" for the default
+// detector.
+func (db *dashboard) record(route string, d detectors.Detector, threshold float64, samples [][]float64, scores []float64) {
+	if db == nil {
+		return
+	}
+	now := db.now()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	sec := now.Unix()
+	p := &db.throughput[sec%throughputSeconds]
+	if p.Time != sec {
+		*p = ThroughputPoint{Time: sec}
+	}
+	p.Samples += len(scores)
+	for i, score := range scores {
+		if score < threshold {
+			continue
+		}
+		p.Anomalies++
+		a := recentAnomaly{
+			DashboardAnomaly: DashboardAnomaly{
+				Time:      now,
+				Route:     route,
+				Score:     score,
+				Threshold: threshold,
+				Features:  slices.Clone(samples[i]),
+			},
+			detector: d,
+		}
+		if len(db.anomalies) < dashboardAnomalies {
+			db.anomalies = append(db.anomalies, a)
+			continue
+		}
+		db.anomalies[db.next] = a
+		db.next = (db.next + 1) % dashboardAnomalies
+	}
+}
+
+// state fills the throughput and anomalies of st, explaining anomalies
+// shown for the first time.
+func (db *dashboard) state(st *DashboardState) {
+	now := db.now().Unix()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	st.Throughput = make([]ThroughputPoint, 0, throughputSeconds)
+	total := 0
+	for sec := now - throughputSeconds + 1; sec <= now; sec++ {
+		p := db.throughput[sec%throughputSeconds]
+		if p.Time != sec {
+			p = ThroughputPoint{Time: sec}
+		}
+		st.Throughput = append(st.Throughput, p)
+		total += p.Samples
+	}
+	st.Rate = float64(total) / throughputSeconds
+
+	st.Anomalies = make([]DashboardAnomaly, 0, len(db.anomalies))
+	for i := range db.anomalies {
+		// Newest first: walk the ring backwards from the last written.
+		a := &db.anomalies[(db.next-1-i+2*len(db.anomalies))%len(db.anomalies)]
+		if !a.explained {
+			a.explained = true
+			if exp, err := detectors.Explain(a.detector, a.Features); err == nil {
+				a.Explanation = &exp
+			}
+			if card, ok := detectors.MetadataOf(a.detector); ok {
+				a.FeatureNames = card.FeatureNames
+			}
+		}
+		st.Anomalies = append(st.Anomalies, a.DashboardAnomaly)
+	}
+}
+
+func (s *Server) handleDashboardState(w http.ResponseWriter, _ *http.Request) {
+	st := DashboardState{
+		Model: ModelStats{
+			Type:    fmt.Sprintf("%T", s.detector),
+			Trained: s.modelReady() == nil,
+		},
+		Distribution: s.scores.Snapshot(),
+		Uptime:       time.Since(s.started).Round(time.Second).String(),
+	}
+	if s.detector != nil {
+		st.Model.Threshold = detectors.ThresholdOf(s.detector)
+		if card, ok := detectors.MetadataOf(s.detector); ok {
+			st.Card = &card
+		}
+	}
+	st.Scores, _ = s.window.stats()
+	s.dashboard.state(&st)
+	writeJSON(w, http.StatusOK, st)
+}
+
+// dashboardPage reports whether r fetches a static dashboard file, which
+// carries no data and so needs no API key.
+func (s *Server) dashboardPage(r *http.Request) bool {
+	return s.dashboard != nil && (r.URL.Path == "/ui" || strings.HasPrefix(r.URL.Path, "/ui/")) && r.URL.Path != "/ui/state"
+}
+
+// dashboardHandler serves the dashboard's static files.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServerFS(files))
+}
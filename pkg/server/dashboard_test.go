@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestDashboard(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42), iforest.WithFeatureNames([]string{"a", "b", "c"}))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	srv := New(f, WithDashboard(), WithAPIKeys(APIKey{Name: "soc", Key: "s3cret"}))
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	require.Equal(t, http.StatusOK, rec.Code, "the page needs no API key")
+	assert.Contains(t, rec.Body.String(), "Recent anomalies")
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/state", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "the state does")
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewBufferString(`{"samples": [[0, 0, 0], [50, 50, 50]]}`))
+	req.Header.Set("X-API-Key", "s3cret")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/ui/state", nil)
+	req.Header.Set("X-API-Key", "s3cret")
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var st DashboardState
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&st))
+	assert.Equal(t, f.Threshold(), st.Model.Threshold)
+	require.NotNil(t, st.Card)
+	assert.Equal(t, []string{"a", "b", "c"}, st.Card.FeatureNames)
+	assert.Equal(t, uint64(2), st.Distribution.Count)
+	assert.Len(t, st.Throughput, throughputSeconds)
+	assert.Equal(t, 2, st.Throughput[throughputSeconds-1].Samples)
+	require.Len(t, st.Anomalies, 1)
+	a := st.Anomalies[0]
+	assert.Equal(t, []float64{50, 50, 50}, a.Features)
+	assert.Equal(t, []string{"a", "b", "c"}, a.FeatureNames)
+	require.NotNil(t, a.Explanation)
+	assert.NotEmpty(t, a.Explanation.Top)
+}
+
+func TestDashboardRecord(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	db := newDashboard(func() time.Time { return now })
+	f := iforest.New()
+	for i := range dashboardAnomalies + 5 {
+		db.record(fmt.Sprint(i), f, 0.5, [][]float64{{float64(i)}}, []float64{0.9})
+	}
+	now = now.Add(2 * time.Second)
+	db.record("", f, 0.5, [][]float64{{1}, {2}}, []float64{0.1, 0.2})
+
+	var st DashboardState
+	db.state(&st)
+	require.Len(t, st.Anomalies, dashboardAnomalies, "only the most recent are kept")
+	assert.Equal(t, fmt.Sprint(dashboardAnomalies+4), st.Anomalies[0].Route, "newest first")
+	assert.Equal(t, "5", st.Anomalies[dashboardAnomalies-1].Route)
+	assert.Nil(t, st.Anomalies[0].Explanation, "untrained detectors do not explain")
+
+	last := st.Throughput[throughputSeconds-1]
+	assert.Equal(t, ThroughputPoint{Time: now.Unix(), Samples: 2}, last)
+	assert.Equal(t, ThroughputPoint{Time: now.Unix() - 1}, st.Throughput[throughputSeconds-2])
+	assert.Equal(t, dashboardAnomalies+5, st.Throughput[throughputSeconds-3].Anomalies)
+	assert.InDelta(t, float64(dashboardAnomalies+7)/throughputSeconds, st.Rate, 1e-9)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hed1ad/goguardml/pkg/feedback"
+	"github.com/hed1ad/goguardml/pkg/manager"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+// WithFeedback accepts analyst feedback at /v1/feedback, adapting
+// thresholds with a. Its Target decides which detectors keys refer to,
+// typically feedback.Single of the server's detector or its router.
+func WithFeedback(a *feedback.Adapter) Option {
+	return func(s *Server) {
+		s.feedback = a
+	}
+}
+
+// handleFeedback stores a feedback.Feedback and responds with the
+// resulting feedback.Adjustment. The analyst defaults to the API key
+// name.
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	var fb feedback.Feedback
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&fb); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if fb.Analyst == "" {
+		fb.Analyst = clientName(r.Context())
+	}
+
+	adj, err := s.feedback.Submit(r.Context(), fb)
+	switch {
+	case errors.Is(err, feedback.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, router.ErrNoRoute), errors.Is(err, manager.ErrUnknownTenant):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err)
+	default:
+		writeJSON(w, http.StatusOK, adj)
+	}
+}
+
+// handleFeedbackSummary responds with the feedback.Summary of the key in
+// the query string.
+func (s *Server) handleFeedbackSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.feedback.Summary(r.Context(), r.URL.Query().Get("key"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
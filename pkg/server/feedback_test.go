@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/feedback"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+func TestFeedback(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	r := router.New()
+	r.Add("eth0", f, router.WithThreshold(0.6))
+	adapter := feedback.New(feedback.NewMemoryStore(0), r, feedback.WithMinFeedback(1), feedback.WithAggressiveness(1))
+	srv := New(f, WithRouter(r), WithFeedback(adapter))
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "false positive", body: `{"key": "eth0", "score": 0.62, "verdict": "false_positive"}`, wantStatus: http.StatusOK},
+		{name: "bad verdict", body: `{"key": "eth0", "score": 0.62, "verdict": "maybe"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown key", body: `{"key": "eth9", "score": 0.62, "verdict": "true_positive"}`, wantStatus: http.StatusNotFound},
+		{name: "malformed body", body: `{"key": `, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/feedback", bytes.NewBufferString(tt.body)))
+			assert.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+		})
+	}
+
+	threshold, err := r.Threshold("eth0")
+	require.NoError(t, err)
+	assert.Greater(t, threshold, 0.62, "the false positive raised the threshold")
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/feedback?key=eth0", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var summary feedback.Summary
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+	assert.Equal(t, feedback.Summary{Key: "eth0", FalsePositives: 1}, summary)
+}
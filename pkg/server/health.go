@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/audit"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/router"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// defaultWindowSize is the number of recent scores kept for admin statistics.
+const defaultWindowSize = 1000
+
+// readinessCheck is a named probe evaluated by /readyz.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// WithReadinessCheck adds a probe to /readyz, e.g. that a reader is connected.
+// The server reports ready only when every check returns nil.
+func WithReadinessCheck(name string, check func() error) Option {
+	return func(s *Server) {
+		s.checks = append(s.checks, readinessCheck{name: name, check: check})
+	}
+}
+
+// trainedReporter is implemented by detectors that know whether they are fitted.
+type trainedReporter interface {
+	Trained() bool
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, _ *http.Request) {
+	failures := make(map[string]string)
+
+	if err := s.modelReady(); err != nil {
+		failures["model"] = err.Error()
+	}
+	for _, c := range s.checks {
+		if err := c.check(); err != nil {
+			failures[c.name] = err.Error()
+		}
+	}
+
+	if len(failures) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"status":   "not ready",
+			"failures": failures,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) modelReady() error {
+	if s.detector == nil {
+		return errors.New("no model loaded")
+	}
+	if t, ok := s.detector.(trainedReporter); ok && !t.Trained() {
+		return detectors.ErrNotTrained
+	}
+	return nil
+}
+
+// AdminStats is the body of the /admin/stats response.
+type AdminStats struct {
+	Model  ModelStats  `json:"model"`
+	Scores WindowStats `json:"scores"`
+	// Distribution summarizes every score since startup.
+	Distribution stats.Snapshot `json:"distribution"`
+	Drift        DriftStats     `json:"drift"`
+	// Telemetry is the latest period of detector internals, with
+	// WithTelemetry.
+	Telemetry *detectors.Telemetry    `json:"telemetry,omitempty"`
+	Load      LoadStats               `json:"load"`
+	Audit     *audit.Stats            `json:"audit,omitempty"`
+	Routes    map[string]router.Stats `json:"routes,omitempty"`
+	Uptime    string                  `json:"uptime"`
+	Started   time.Time               `json:"started"`
+}
+
+// ModelStats describes the served model.
+type ModelStats struct {
+	Type      string  `json:"type"`
+	Trained   bool    `json:"trained"`
+	Threshold float64 `json:"threshold"`
+}
+
+// WindowStats summarizes a window of scores.
+type WindowStats struct {
+	Count       int     `json:"count"`
+	Mean        float64 `json:"mean"`
+	StdDev      float64 `json:"std_dev"`
+	P50         float64 `json:"p50"`
+	P95         float64 `json:"p95"`
+	AnomalyRate float64 `json:"anomaly_rate"`
+}
+
+// DriftStats compares recent scores with the first window observed after startup.
+type DriftStats struct {
+	Baseline         WindowStats `json:"baseline"`
+	MeanShift        float64     `json:"mean_shift"`
+	AnomalyRateDelta float64     `json:"anomaly_rate_delta"`
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, _ *http.Request) {
+	resp := AdminStats{
+		Model: ModelStats{
+			Type:    fmt.Sprintf("%T", s.detector),
+			Trained: s.modelReady() == nil,
+		},
+		Uptime:  time.Since(s.started).Round(time.Second).String(),
+		Started: s.started,
+	}
+	if s.detector != nil {
+		resp.Model.Threshold = detectors.ThresholdOf(s.detector)
+	}
+
+	recent, baseline := s.window.stats()
+	resp.Scores = recent
+	resp.Drift = DriftStats{Baseline: baseline}
+	if baseline.Count > 0 && recent.Count > 0 {
+		resp.Drift.MeanShift = recent.Mean - baseline.Mean
+		resp.Drift.AnomalyRateDelta = recent.AnomalyRate - baseline.AnomalyRate
+	}
+
+	resp.Distribution = s.scores.Snapshot()
+	resp.Load = s.limiter.stats()
+	resp.Telemetry = s.telemetry.latest()
+	if s.audit != nil {
+		a := s.audit.Stats()
+		resp.Audit = &a
+	}
+	if s.router != nil {
+		resp.Routes = s.router.Stats()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// scoreWindow keeps the most recent scores in a ring buffer, plus the first
+// full window seen as a baseline for drift indicators.
+type scoreWindow struct {
+	mu        sync.Mutex
+	scores    []float64
+	anomalies []bool
+	next      int
+	full      bool
+	baseline  *WindowStats
+}
+
+func newScoreWindow(size int) *scoreWindow {
+	return &scoreWindow{
+		scores:    make([]float64, size),
+		anomalies: make([]bool, size),
+	}
+}
+
+func (w *scoreWindow) addAll(scores []float64, threshold float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, score := range scores {
+		w.scores[w.next] = score
+		w.anomalies[w.next] = score >= threshold
+		w.next++
+		if w.next == len(w.scores) {
+			w.next = 0
+			w.full = true
+			if w.baseline == nil {
+				b := summarize(w.scores, w.anomalies)
+				w.baseline = &b
+			}
+		}
+	}
+}
+
+// stats returns statistics of the current window and the baseline.
+func (w *scoreWindow) stats() (recent, baseline WindowStats) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := w.next
+	if w.full {
+		n = len(w.scores)
+	}
+	recent = summarize(w.scores[:n], w.anomalies[:n])
+	if w.baseline != nil {
+		baseline = *w.baseline
+	}
+	return recent, baseline
+}
+
+func summarize(scores []float64, anomalies []bool) WindowStats {
+	n := len(scores)
+	if n == 0 {
+		return WindowStats{}
+	}
+
+	var sum, anomalyCount float64
+	for i, v := range scores {
+		sum += v
+		if anomalies[i] {
+			anomalyCount++
+		}
+	}
+	mean := sum / float64(n)
+
+	var variance float64
+	for _, v := range scores {
+		variance += (v - mean) * (v - mean)
+	}
+
+	sorted := make([]float64, n)
+	copy(sorted, scores)
+	sort.Float64s(sorted)
+
+	return WindowStats{
+		Count:       n,
+		Mean:        mean,
+		StdDev:      math.Sqrt(variance / float64(n)),
+		P50:         sorted[(n-1)*50/100],
+		P95:         sorted[(n-1)*95/100],
+		AnomalyRate: anomalyCount / float64(n),
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	trained := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, trained.Fit(generateTestData(100, 3)))
+
+	tests := []struct {
+		name       string
+		srv        *Server
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "liveness",
+			srv:        New(iforest.New()),
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "untrained model not ready",
+			srv:        New(iforest.New()),
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "trained model ready",
+			srv:        New(trained),
+			path:       "/readyz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "failing reader check",
+			srv: New(trained, WithReadinessCheck("reader", func() error {
+				return errors.New("disconnected")
+			})),
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	srv := New(f)
+
+	body := bytes.NewBufferString(`{"samples": [[0, 0, 0], [50, 50, 50]]}`)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/predict", body))
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var stats AdminStats
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+	assert.True(t, stats.Model.Trained)
+	assert.Equal(t, f.Threshold(), stats.Model.Threshold)
+	assert.Equal(t, 2, stats.Scores.Count)
+	assert.InDelta(t, 0.5, stats.Scores.AnomalyRate, 1e-9)
+	assert.Equal(t, uint64(2), stats.Distribution.Count)
+	assert.Equal(t, uint64(1), stats.Distribution.Anomalies)
+	assert.NotEmpty(t, stats.Distribution.Buckets)
+}
+
+func TestAdminStatsTelemetry(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	// Every request ends a period.
+	srv := New(f, WithTelemetry(0))
+
+	adminStats := func() AdminStats {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+		var stats AdminStats
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+		return stats
+	}
+	assert.Nil(t, adminStats().Telemetry)
+
+	body := bytes.NewBufferString(`{"samples": [[0, 0, 0], [50, 50, 50]]}`)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/predict", body))
+	tel := adminStats().Telemetry
+	require.NotNil(t, tel)
+	assert.Equal(t, 2, tel.Samples)
+	assert.Contains(t, tel.Metrics, iforest.MetricAvgPathLength)
+	assert.NotEmpty(t, tel.Histograms[iforest.HistogramLeafDepth])
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/history"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+)
+
+// defaultHistoryRange is how far back history queries look by default.
+const defaultHistoryRange = 24 * time.Hour
+
+// WithHistory records the scores of samples with an entity to h and
+// serves queries of it at /v1/history. Samples are attributed to the
+// entities of the request or, on /v1/predict/{key}, to the key by default.
+// Requests fail with 500 if their scores cannot be recorded.
+func WithHistory(h *history.Store) Option {
+	return func(s *Server) {
+		s.history = h
+	}
+}
+
+// HistoryTopResponse is the body of GET /v1/history: the entities with
+// the highest anomaly rate.
+type HistoryTopResponse struct {
+	From     time.Time             `json:"from"`
+	To       time.Time             `json:"to"`
+	Entities []history.EntityStats `json:"entities"`
+}
+
+// HistoryEntityResponse is the body of GET /v1/history/{entity}: the
+// scores of the entity, summarized in buckets if the request sets a step.
+type HistoryEntityResponse struct {
+	Entity  string           `json:"entity"`
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Points  []history.Point  `json:"points,omitempty"`
+	Buckets []history.Bucket `json:"buckets,omitempty"`
+}
+
+// recordHistory records the results of scoring req. route is the router
+// key, or "" for the default detector.
+func (s *Server) recordHistory(route string, req PredictRequest, results []guardio.Result) error {
+	if s.history == nil {
+		return nil
+	}
+	points := make([]history.Point, 0, len(results))
+	for i, res := range results {
+		entity := route
+		if len(req.Entities) > 0 && req.Entities[i] != "" {
+			entity = req.Entities[i]
+		}
+		if entity == "" {
+			continue
+		}
+		points = append(points, history.Point{
+			Entity:  entity,
+			Time:    time.Unix(res.Timestamp, 0),
+			Score:   res.Score,
+			Anomaly: res.IsAnomaly,
+		})
+	}
+	return s.history.Add(points...)
+}
+
+// handleHistoryTop responds with the entities with the highest anomaly
+// rate in the range of the query string, up to limit (default 10) with at
+// least min_count scores (default 1).
+func (s *Server) handleHistoryTop(w http.ResponseWriter, r *http.Request) {
+	from, to, err := historyRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(r, "limit", 10)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minCount, err := intParam(r, "min_count", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	top := s.history.Top(from, to, limit, minCount)
+	if top == nil {
+		top = []history.EntityStats{}
+	}
+	writeJSON(w, http.StatusOK, HistoryTopResponse{From: from, To: to, Entities: top})
+}
+
+// handleHistoryEntity responds with the scores of an entity in the range
+// of the query string, in buckets of width step if given.
+func (s *Server) handleHistoryEntity(w http.ResponseWriter, r *http.Request) {
+	from, to, err := historyRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := HistoryEntityResponse{Entity: r.PathValue("entity"), From: from, To: to}
+	if v := r.URL.Query().Get("step"); v != "" {
+		step, err := time.ParseDuration(v)
+		if err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid step %q", v))
+			return
+		}
+		if to.Sub(from)/step > 10000 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("step %s gives too many buckets", step))
+			return
+		}
+		resp.Buckets = s.history.Trend(resp.Entity, from, to, step)
+	} else {
+		resp.Points = s.history.Points(resp.Entity, from, to)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// historyRange parses the time range of a history query: from and to as
+// RFC 3339 times, or since as a duration before to. to defaults to now and
+// the range to the last 24 hours.
+func historyRange(r *http.Request) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	to = time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	since := defaultHistoryRange
+	if v := q.Get("since"); v != "" {
+		if since, err = time.ParseDuration(v); err != nil || since <= 0 {
+			return from, to, fmt.Errorf("invalid since %q", v)
+		}
+	}
+	from = to.Add(-since)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from %s is not before to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+// intParam parses a non-negative integer query parameter.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
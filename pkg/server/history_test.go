@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/history"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+func TestHistory(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	r := router.New()
+	r.Add("eth0", f)
+	h := history.New()
+	srv := New(f, WithRouter(r), WithHistory(h))
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/predict", `{"samples": [[0.1, 0.2, 0.3], [9, 9, 9], [0.2, 0.1, 0.3]], "entities": ["web-1", "web-2", ""]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/v1/predict/eth0", `{"samples": [[9, 9, 9], [0.1, 0.2, 0.3]], "entities": ["", "web-1"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"eth0", "web-1", "web-2"}, h.Entities(), "unattributed samples are not recorded")
+
+	rec = do(http.MethodPost, "/v1/predict", `{"samples": [[1, 2, 3]], "entities": ["a", "b"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodGet, "/v1/history?limit=2", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var top HistoryTopResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&top))
+	require.Len(t, top.Entities, 2)
+	assert.ElementsMatch(t, []string{"web-2", "eth0"}, []string{top.Entities[0].Entity, top.Entities[1].Entity})
+	assert.Equal(t, 1.0, top.Entities[0].AnomalyRate)
+
+	rec = do(http.MethodGet, "/v1/history/web-1?since=1h", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var entity HistoryEntityResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&entity))
+	assert.Equal(t, "web-1", entity.Entity)
+	assert.Len(t, entity.Points, 2)
+
+	rec = do(http.MethodGet, "/v1/history/web-1?since=2h&step=30m", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	entity = HistoryEntityResponse{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&entity))
+	require.Len(t, entity.Buckets, 4)
+	assert.Equal(t, 2, entity.Buckets[3].Count)
+
+	for _, target := range []string{
+		"/v1/history?since=-1h",
+		"/v1/history?limit=x",
+		"/v1/history?from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z",
+		"/v1/history/web-1?step=0s",
+		"/v1/history/web-1?since=720h&step=1s",
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, target, "").Code, target)
+	}
+
+	// Without a history store the endpoints are not served.
+	rec = httptest.NewRecorder()
+	New(f).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/history", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
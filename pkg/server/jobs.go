@@ -0,0 +1,564 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/csv"
+	"github.com/hed1ad/goguardml/pkg/io/jsonl"
+	"github.com/hed1ad/goguardml/pkg/io/parquet"
+	"github.com/hed1ad/goguardml/pkg/io/pcap"
+)
+
+// jobBatchSize is the number of samples scored per Predict call in a job.
+const jobBatchSize = 1024
+
+// maxUploadBytes limits the size of files submitted for batch scoring.
+const maxUploadBytes = 4 << 30
+
+// defaultJobTTL is how long finished jobs are kept by default.
+const defaultJobTTL = 24 * time.Hour
+
+// ErrUnsupportedFormat is returned by an OpenFunc for unknown file types.
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// OpenFunc opens an uploaded file for batch scoring.
+// The name carries the original file extension; it returns
+// ErrUnsupportedFormat for types it cannot read.
+type OpenFunc func(name string) (guardio.Reader, error)
+
+// OpenCSV is an OpenFunc reading CSV files with a header row only.
+func OpenCSV(name string) (guardio.Reader, error) {
+	if strings.ToLower(filepath.Ext(name)) != ".csv" {
+		return nil, ErrUnsupportedFormat
+	}
+	return csv.NewReader(name, csv.WithHeader(true))
+}
+
+// OpenFile is the default OpenFunc, reading CSV files with a header row,
+// PCAP and pcapng captures, and Parquet files.
+func OpenFile(name string) (guardio.Reader, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return csv.NewReader(name, csv.WithHeader(true))
+	case ".pcap", ".pcapng", ".cap":
+		return pcap.NewFileReader(name)
+	case ".parquet":
+		return parquet.NewReader(name)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// WithJobOpener sets how submitted files are read, e.g. to set reader
+// options or read other formats.
+func WithJobOpener(open OpenFunc) Option {
+	return func(s *Server) {
+		s.jobs.open = open
+	}
+}
+
+// WithJobDir sets the directory where uploads and results are stored.
+func WithJobDir(dir string) Option {
+	return func(s *Server) {
+		s.jobs.dir = dir
+	}
+}
+
+// WithJobWorkers sets the number of batch jobs scored concurrently, 2 by
+// default. Values below 1 are raised to 1, so jobs always make progress.
+func WithJobWorkers(n int) Option {
+	return func(s *Server) {
+		s.jobs.slots = make(chan struct{}, max(n, 1))
+	}
+}
+
+// WithJobTTL sets how long finished jobs, with their results, are kept
+// before they are removed, 24 hours by default; 0 keeps them until they
+// are deleted.
+func WithJobTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.jobs.ttl = ttl
+	}
+}
+
+// JobStatus is the lifecycle state of a batch job.
+type JobStatus string
+
+// Job states.
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job describes an asynchronous batch scoring job.
+type Job struct {
+	ID        string     `json:"id"`
+	Status    JobStatus  `json:"status"`
+	Filename  string     `json:"filename"`
+	Submitted time.Time  `json:"submitted"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Samples   int        `json:"samples"`
+	Anomalies int        `json:"anomalies"`
+	Error     string     `json:"error,omitempty"`
+
+	results string
+	cancel  context.CancelFunc
+	// done is closed once the job's goroutine has stopped writing its
+	// results and removed its input.
+	done chan struct{}
+}
+
+// jobManager runs batch jobs in the background.
+type jobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	dir   string
+	open  OpenFunc
+	slots chan struct{}
+	ttl   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobManager() *jobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobManager{
+		jobs:   make(map[string]*Job),
+		dir:    filepath.Join(os.TempDir(), "goguardml-jobs"),
+		open:   OpenFile,
+		slots:  make(chan struct{}, 2),
+		ttl:    defaultJobTTL,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start starts removing expired jobs, if they expire.
+func (m *jobManager) start() {
+	if m.ttl <= 0 {
+		return
+	}
+	m.wg.Add(1)
+	go m.reap(min(m.ttl/2, time.Minute))
+}
+
+// reap removes expired jobs every interval until the manager is closed.
+func (m *jobManager) reap(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.expire(now)
+		}
+	}
+}
+
+// expire removes the jobs that finished at least ttl before now, with
+// their results.
+func (m *jobManager) expire(now time.Time) {
+	m.mu.Lock()
+	var expired []*Job
+	for id, job := range m.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) >= m.ttl {
+			delete(m.jobs, id)
+			expired = append(expired, job)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, job := range expired {
+		m.remove(job)
+	}
+}
+
+// remove cancels a job removed from the map, waits for its goroutine to
+// stop, and removes its results.
+func (m *jobManager) remove(job *Job) {
+	job.cancel()
+	<-job.done
+	os.Remove(job.results)
+}
+
+// close cancels running jobs and waits for them to stop.
+func (m *jobManager) close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *jobManager) get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (m *jobManager) update(id string, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing file: %w", err))
+		return
+	}
+	defer file.Close()
+
+	job, err := s.jobs.submit(s.detector, file, header)
+	if errors.Is(err, ErrUnsupportedFormat) {
+		writeError(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleJobResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	if job.Status != JobDone {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.Status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+".jsonl"))
+	http.ServeFile(w, r, job.results)
+}
+
+func (s *Server) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.jobs.mu.Lock()
+	job, ok := s.jobs.jobs[id]
+	if ok {
+		delete(s.jobs.jobs, id)
+	}
+	s.jobs.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	s.jobs.remove(job)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// submit stores the upload and starts scoring it in the background.
+func (m *jobManager) submit(d detectors.Detector, file multipart.File, header *multipart.FileHeader) (Job, error) {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return Job{}, err
+	}
+	input := filepath.Join(m.dir, id+ext)
+	if err := saveUpload(file, input); err != nil {
+		return Job{}, err
+	}
+
+	reader, err := m.open(input)
+	if err != nil {
+		os.Remove(input)
+		return Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &Job{
+		ID:        id,
+		Status:    JobQueued,
+		Filename:  filepath.Base(header.Filename),
+		Submitted: time.Now().UTC(),
+		results:   filepath.Join(m.dir, id+".jsonl"),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	snapshot := *job
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(job.done)
+		// The upload is not needed once scored, or failed.
+		defer os.Remove(input)
+		defer reader.Close()
+		defer cancel()
+		m.run(ctx, id, d, reader, snapshot.results)
+	}()
+
+	return snapshot, nil
+}
+
+// run waits for a worker slot, then scores the job's input.
+func (m *jobManager) run(ctx context.Context, id string, d detectors.Detector, reader guardio.Reader, results string) {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(id, JobCancelled, nil)
+		return
+	}
+
+	m.update(id, func(j *Job) { j.Status = JobRunning })
+
+	err := m.score(ctx, id, d, reader, results)
+	switch {
+	case ctx.Err() != nil:
+		m.finish(id, JobCancelled, nil)
+	case err != nil:
+		m.finish(id, JobFailed, err)
+	default:
+		m.finish(id, JobDone, nil)
+	}
+}
+
+func (m *jobManager) score(ctx context.Context, id string, d detectors.Detector, reader guardio.Reader, results string) error {
+	w, err := jsonl.NewFileWriter(results)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	samples, err := reader.StreamSamples(ctx)
+	if err != nil {
+		return err
+	}
+	if sd, ok := d.(detectors.StreamDetector); ok && detectors.IsSequential(d) {
+		if err := m.stream(ctx, id, sd, samples, w); err != nil {
+			return err
+		}
+		return readerErr(reader)
+	}
+
+	batch := make([][]float64, 0, jobBatchSize)
+	meta := make([]guardio.Sample, 0, jobBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		scores, err := d.PredictContext(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		threshold := detectors.ThresholdOf(d)
+		anomalies := 0
+		out := make([]guardio.Result, len(scores))
+		for i, score := range scores {
+			out[i] = guardio.Result{
+				Timestamp: meta[i].Time.Unix(),
+				Seq:       meta[i].Seq,
+				Score:     score,
+				IsAnomaly: score >= threshold,
+				Features:  batch[i],
+			}
+			if out[i].IsAnomaly {
+				anomalies++
+			}
+		}
+		if err := w.WriteAll(out); err != nil {
+			return err
+		}
+
+		m.update(id, func(j *Job) {
+			j.Samples += len(scores)
+			j.Anomalies += anomalies
+		})
+		batch = batch[:0]
+		meta = meta[:0]
+		return nil
+	}
+
+	for sample := range samples {
+		batch = append(batch, sample.Features)
+		meta = append(meta, sample)
+		if len(batch) == jobBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return readerErr(reader)
+}
+
+// stream scores samples with a sequential detector as one series through
+// PredictStream, rather than restarting from the training data every
+// batch, writing each result as its score arrives so the input is never
+// held in memory whole. Samples the detector rejects fail the job, as
+// they fail a batch, once the others have been scored.
+func (m *jobManager) stream(ctx context.Context, id string, d detectors.StreamDetector, samples <-chan guardio.Sample, w *jsonl.Writer) error {
+	// read is only used once PredictStream has drained its input, which
+	// closes after the last increment.
+	read := 0
+	counted := make(chan guardio.Sample, cap(samples))
+	go func() {
+		defer close(counted)
+		for sample := range samples {
+			read++
+			select {
+			case counted <- sample:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	features, queue := guardio.SplitSamples(ctx, counted)
+	scores := make(chan detectors.Score, jobBatchSize)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.PredictStream(ctx, features, scores)
+	}()
+
+	scored, pending, anomalies := 0, 0, 0
+	report := func() {
+		m.update(id, func(j *Job) {
+			j.Samples += pending
+			j.Anomalies += anomalies
+		})
+		pending, anomalies = 0, 0
+	}
+	var werr error
+	for score := range scores {
+		if werr != nil {
+			continue // drain so PredictStream can return
+		}
+		result := guardio.Result{
+			Score:     score.Value,
+			IsAnomaly: score.IsAnomaly,
+			Features:  score.Features,
+		}
+		if sample, ok := queue.Match(score.Features); ok {
+			result.Timestamp = sample.Time.Unix()
+			result.Seq = sample.Seq
+		}
+		if werr = w.Write(result); werr != nil {
+			continue
+		}
+		scored++
+		pending++
+		if score.IsAnomaly {
+			anomalies++
+		}
+		if pending == jobBatchSize {
+			report()
+		}
+	}
+	report()
+
+	if err := <-errCh; err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if rejected := read - scored; rejected > 0 {
+		return fmt.Errorf("%d samples could not be scored", rejected)
+	}
+	return nil
+}
+
+// readerErr returns the error that ended a reader's stream, such as a
+// malformed row in strict mode or a truncated file, if it keeps one.
+func readerErr(r guardio.Reader) error {
+	if e, ok := r.(interface{ Err() error }); ok && e.Err() != nil {
+		return fmt.Errorf("input: %w", e.Err())
+	}
+	return nil
+}
+
+func (m *jobManager) finish(id string, status JobStatus, err error) {
+	now := time.Now().UTC()
+	m.update(id, func(j *Job) {
+		j.Status = status
+		j.Finished = &now
+		if err != nil {
+			j.Error = err.Error()
+		}
+	})
+}
+
+func saveUpload(src io.Reader, path string) error {
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return err
+	}
+	return dst.Close()
+}
+
+func newJobID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
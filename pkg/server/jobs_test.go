@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/detectors/sr"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/csv"
+	"github.com/hed1ad/goguardml/pkg/io/pcap"
+)
+
+func TestBatchJobs(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	dir := t.TempDir()
+	srv := New(f, WithJobDir(dir))
+	defer srv.Close()
+
+	t.Run("unsupported format", func(t *testing.T) {
+		rec := submitJob(t, srv, "capture.xlsx", "PK")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("csv job completes", func(t *testing.T) {
+		rec := submitJob(t, srv, "flows.csv", "a,b,c\n0,0,0\n1,1,1\n50,50,50\n")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		var job Job
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		assert.Equal(t, "/v1/jobs/"+job.ID, rec.Header().Get("Location"))
+
+		job = waitJob(t, srv, job.ID)
+		assert.Equal(t, JobDone, job.Status)
+		assert.Equal(t, 3, job.Samples)
+
+		rec = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/jobs/"+job.ID+"/results", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 3, strings.Count(rec.Body.String(), "\n"))
+		var seqs []uint64
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			var result guardio.Result
+			require.NoError(t, json.Unmarshal([]byte(line), &result))
+			seqs = append(seqs, result.Seq)
+		}
+		assert.Equal(t, []uint64{1, 2, 3}, seqs, "results carry sequence numbers")
+
+		rec = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/jobs/"+job.ID, nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the upload and results are removed")
+	})
+
+	t.Run("parquet job completes", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/flows.parquet")
+		require.NoError(t, err)
+		rec := submitJob(t, srv, "flows.parquet", string(data))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		var job Job
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		job = waitJob(t, srv, job.ID)
+		assert.Equal(t, JobDone, job.Status, job.Error)
+		assert.Equal(t, 3, job.Samples)
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/jobs/missing", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestBatchJobsSequential(t *testing.T) {
+	series := make([][]float64, 300)
+	for i := range series {
+		series[i] = []float64{math.Sin(float64(i) / 5)}
+	}
+	d := sr.New(sr.WithWindow(32))
+	require.NoError(t, d.Fit(series[:200]))
+	want, err := d.Predict(series[200:])
+	require.NoError(t, err)
+
+	srv := New(d, WithJobDir(t.TempDir()))
+	defer srv.Close()
+	var csv strings.Builder
+	csv.WriteString("v\n")
+	for _, row := range series[200:] {
+		fmt.Fprintf(&csv, "%v\n", row[0])
+	}
+
+	rec := submitJob(t, srv, "series.csv", csv.String())
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var job Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	job = waitJob(t, srv, job.ID)
+	require.Equal(t, JobDone, job.Status, job.Error)
+	assert.Equal(t, len(want), job.Samples)
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/jobs/"+job.ID+"/results", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got []float64
+	var seqs []uint64
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		var result guardio.Result
+		require.NoError(t, json.Unmarshal([]byte(line), &result))
+		got = append(got, result.Score)
+		seqs = append(seqs, result.Seq)
+	}
+	assert.InDeltaSlice(t, want, got, 1e-12, "one series, as Predict scores it")
+	assert.Equal(t, uint64(1), seqs[0])
+	assert.Equal(t, uint64(len(want)), seqs[len(seqs)-1])
+
+	t.Run("rejected samples fail the job", func(t *testing.T) {
+		rec := submitJob(t, srv, "series.csv", "v,w\n0.1,1\n0.2,2\n")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		var job Job
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		job = waitJob(t, srv, job.ID)
+		assert.Equal(t, JobFailed, job.Status)
+		assert.Contains(t, job.Error, "2 samples could not be scored")
+		assert.Zero(t, job.Samples)
+	})
+}
+
+func TestBatchJobsWorkers(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+
+	for _, n := range []int{-1, 0, 1} {
+		srv := New(f, WithJobDir(t.TempDir()), WithJobWorkers(n))
+		rec := submitJob(t, srv, "flows.csv", "a,b,c\n0,0,0\n")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		var job Job
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		assert.Equal(t, JobDone, waitJob(t, srv, job.ID).Status, "%d workers", n)
+		srv.Close()
+	}
+}
+
+func TestBatchJobsReaderError(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	strict := func(name string) (guardio.Reader, error) {
+		return csv.NewReader(name, csv.WithHeader(true), csv.WithStrict(true))
+	}
+	srv := New(f, WithJobDir(t.TempDir()), WithJobOpener(strict))
+	defer srv.Close()
+
+	rec := submitJob(t, srv, "flows.csv", "a,b,c\n0,0,0\n1,x,1\n2,2,2\n")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var job Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	job = waitJob(t, srv, job.ID)
+	assert.Equal(t, JobFailed, job.Status, "a malformed row fails the job")
+	assert.Contains(t, job.Error, "input:")
+	assert.Equal(t, 1, job.Samples, "rows before it are scored")
+}
+
+func TestBatchJobsPCAP(t *testing.T) {
+	// Without WithJobOpener, the server reads captures itself.
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, pcap.NumFeatures)))
+	srv := New(f, WithJobDir(t.TempDir()))
+	defer srv.Close()
+
+	var capture bytes.Buffer
+	w := pcapgo.NewWriter(&capture)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for i := range 4 {
+		frame := make([]byte, 60+i)
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, w.WritePacket(ci, frame))
+	}
+
+	rec := submitJob(t, srv, "capture.pcap", capture.String())
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var job Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	job = waitJob(t, srv, job.ID)
+	assert.Equal(t, JobDone, job.Status, job.Error)
+	assert.Equal(t, 4, job.Samples)
+}
+
+func TestBatchJobsExpire(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	dir := t.TempDir()
+	srv := New(f, WithJobDir(dir), WithJobTTL(50*time.Millisecond))
+	defer srv.Close()
+
+	rec := submitJob(t, srv, "flows.csv", "a,b,c\n0,0,0\n1,1,1\n")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var job Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	assert.Equal(t, JobDone, waitJob(t, srv, job.ID).Status)
+
+	require.Eventually(t, func() bool {
+		_, ok := srv.jobs.get(job.ID)
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "finished jobs expire")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "expired results are removed")
+}
+
+// waitJob polls a job until it stops running and returns it.
+func waitJob(t *testing.T, srv *Server, id string) Job {
+	t.Helper()
+	var job Job
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/jobs/"+id, nil))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		return job.Finished != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func submitJob(t *testing.T, srv *Server, filename, content string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned to clients by the load limiter.
+var (
+	errOverloaded   = errors.New("server overloaded, retry later")
+	errQueueTimeout = errors.New("request deadline exceeded while queued")
+)
+
+// WithConcurrency bounds scoring to maxInFlight concurrent requests with up to
+// maxQueue more waiting. Requests beyond that are rejected with 429.
+func WithConcurrency(maxInFlight, maxQueue int) Option {
+	return func(s *Server) {
+		maxInFlight = max(maxInFlight, 1)
+		maxQueue = max(maxQueue, 0)
+		s.limiter.slots = make(chan struct{}, maxInFlight)
+		s.limiter.maxQueue = int64(maxQueue)
+	}
+}
+
+// WithRequestTimeout sets the deadline for scoring requests, including time
+// spent queued. Zero disables the deadline.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.limiter.timeout = d
+	}
+}
+
+// LoadStats describes scoring concurrency.
+type LoadStats struct {
+	InFlight    int    `json:"in_flight"`
+	MaxInFlight int    `json:"max_in_flight"`
+	Queued      int64  `json:"queued"`
+	MaxQueue    int64  `json:"max_queue"`
+	Shed        uint64 `json:"shed"`
+	TimedOut    uint64 `json:"timed_out"`
+}
+
+// limiter bounds concurrent scoring and sheds load when its queue is full.
+type limiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	timeout  time.Duration
+
+	queued   atomic.Int64
+	shed     atomic.Uint64
+	timedOut atomic.Uint64
+}
+
+func newLimiter() *limiter {
+	n := runtime.GOMAXPROCS(0)
+	return &limiter{
+		slots:    make(chan struct{}, n),
+		maxQueue: int64(4 * n),
+		timeout:  30 * time.Second,
+	}
+}
+
+// wrap applies the deadline, queue, and concurrency bound to next.
+func (l *limiter) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if l.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, l.timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
+		// Fast path: a free slot means no queueing.
+		select {
+		case l.slots <- struct{}{}:
+			defer func() { <-l.slots }()
+			next(w, r)
+			return
+		default:
+		}
+
+		if l.queued.Add(1) > l.maxQueue {
+			l.queued.Add(-1)
+			l.shed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(1))
+			writeError(w, http.StatusTooManyRequests, errOverloaded)
+			return
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+			l.queued.Add(-1)
+			defer func() { <-l.slots }()
+			next(w, r)
+		case <-ctx.Done():
+			l.queued.Add(-1)
+			l.timedOut.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(1))
+			writeError(w, http.StatusServiceUnavailable, errQueueTimeout)
+		}
+	}
+}
+
+func (l *limiter) stats() LoadStats {
+	return LoadStats{
+		InFlight:    len(l.slots),
+		MaxInFlight: cap(l.slots),
+		Queued:      l.queued.Load(),
+		MaxQueue:    l.maxQueue,
+		Shed:        l.shed.Load(),
+		TimedOut:    l.timedOut.Load(),
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxQueue   int64
+		timeout    time.Duration
+		wantStatus int
+	}{
+		{name: "queue full sheds with 429", maxQueue: 0, wantStatus: http.StatusTooManyRequests},
+		{name: "deadline while queued", maxQueue: 1, timeout: 20 * time.Millisecond, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &limiter{slots: make(chan struct{}, 1), maxQueue: tt.maxQueue, timeout: tt.timeout}
+
+			release := make(chan struct{})
+			started := make(chan struct{})
+			h := l.wrap(func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				<-release
+				w.WriteHeader(http.StatusOK)
+			})
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := httptest.NewRecorder()
+				h(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}()
+			<-started
+
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+			close(release)
+			wg.Wait()
+
+			stats := l.stats()
+			assert.Equal(t, 0, stats.InFlight)
+			assert.Equal(t, int64(0), stats.Queued)
+			assert.Equal(t, uint64(1), stats.Shed+stats.TimedOut)
+		})
+	}
+}
+
+func TestLimiterQueuedRequestProceeds(t *testing.T) {
+	l := &limiter{slots: make(chan struct{}, 1), maxQueue: 1, timeout: time.Second}
+
+	release := make(chan struct{})
+	first := true
+	var mu sync.Mutex
+	h := l.wrap(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		block := first
+		first = false
+		mu.Unlock()
+		if block {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", nil))
+		done <- rec.Code
+	}()
+	require.Eventually(t, func() bool { return l.stats().InFlight == 1 }, time.Second, time.Millisecond)
+
+	go func() {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", nil))
+		done <- rec.Code
+	}()
+	require.Eventually(t, func() bool { return l.stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-done)
+}
@@ -0,0 +1,450 @@
+// Package server exposes trained detectors over HTTP for online scoring.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/audit"
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/feedback"
+	"github.com/hed1ad/goguardml/pkg/history"
+	guardio "github.com/hed1ad/goguardml/pkg/io"
+	"github.com/hed1ad/goguardml/pkg/io/protobuf"
+	"github.com/hed1ad/goguardml/pkg/pb"
+	"github.com/hed1ad/goguardml/pkg/router"
+	"github.com/hed1ad/goguardml/pkg/stats"
+)
+
+// maxBodyBytes limits the size of scoring requests.
+const maxBodyBytes = 32 << 20
+
+// protobufType is the media type of Protocol Buffers scoring requests and
+// responses, goguardml.v1.PredictRequest and goguardml.v1.Results.
+const protobufType = "application/x-protobuf"
+
+// Server serves anomaly scores for a trained detector.
+type Server struct {
+	detector detectors.Detector
+	router   *router.Router
+	addr     string
+
+	mux      *http.ServeMux
+	checks   []readinessCheck
+	window   *scoreWindow
+	scores   *stats.ScoreStats
+	jobs     *jobManager
+	limiter  *limiter
+	audit    *audit.Logger
+	feedback *feedback.Adapter
+	history  *history.Store
+	// dashboard is nil unless WithDashboard is set.
+	dashboard *dashboard
+	// severity grades results when set by WithSeverityBands.
+	severity *detectors.SeverityBands
+	// telemetry is nil unless WithTelemetry is set.
+	telemetry *telemetry
+	versions  modelVersions
+	started   time.Time
+
+	auth         *Authenticator
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithAddr sets the listen address.
+func WithAddr(addr string) Option {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithRouter enables per-key scoring at /v1/predict/{key} using r.
+func WithRouter(r *router.Router) Option {
+	return func(s *Server) {
+		s.router = r
+	}
+}
+
+// WithSeverityBands grades the score of every result with b.
+func WithSeverityBands(b detectors.SeverityBands) Option {
+	return func(s *Server) {
+		s.severity = &b
+	}
+}
+
+// New creates a new Server for the given trained detector.
+func New(detector detectors.Detector, opts ...Option) *Server {
+	s := &Server{
+		detector: detector,
+		addr:     ":8080",
+		mux:      http.NewServeMux(),
+		window:   newScoreWindow(defaultWindowSize),
+		scores:   stats.NewScoreStats(),
+		jobs:     newJobManager(),
+		limiter:  newLimiter(),
+		started:  time.Now(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.telemetry != nil {
+		s.telemetry.watch(detector)
+	}
+	s.jobs.start()
+
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+	s.mux.HandleFunc("GET /admin/stats", s.handleAdminStats)
+	s.mux.HandleFunc("POST /v1/predict", s.limiter.wrap(s.handlePredict))
+	s.mux.HandleFunc("POST /v1/jobs", s.handleSubmitJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleGetJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}/results", s.handleJobResults)
+	s.mux.HandleFunc("DELETE /v1/jobs/{id}", s.handleDeleteJob)
+	if s.router != nil {
+		s.mux.HandleFunc("POST /v1/predict/{key}", s.limiter.wrap(s.handleRoutePredict))
+		s.mux.HandleFunc("GET /v1/routes", s.handleRoutes)
+	}
+	if s.feedback != nil {
+		s.mux.HandleFunc("POST /v1/feedback", s.handleFeedback)
+		s.mux.HandleFunc("GET /v1/feedback", s.handleFeedbackSummary)
+	}
+	if s.history != nil {
+		s.mux.HandleFunc("GET /v1/history", s.handleHistoryTop)
+		s.mux.HandleFunc("GET /v1/history/{entity}", s.handleHistoryEntity)
+	}
+	if s.dashboard != nil {
+		s.mux.Handle("GET /ui/", dashboardHandler())
+		s.mux.HandleFunc("GET /ui/state", s.handleDashboardState)
+	}
+
+	return s
+}
+
+// Handler returns the HTTP handler serving all endpoints.
+func (s *Server) Handler() http.Handler {
+	return s.authMiddleware(s.mux)
+}
+
+// Close cancels running batch jobs and waits for them to stop.
+func (s *Server) Close() {
+	s.jobs.close()
+}
+
+// ListenAndServe serves requests until ctx is canceled, then shuts down gracefully.
+// Running batch jobs are canceled on shutdown.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	defer s.Close()
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.Handler(),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// PredictRequest is the body of a scoring request.
+type PredictRequest struct {
+	Samples [][]float64 `json:"samples"`
+	// Explain attaches feature attributions to results flagged as anomalies.
+	Explain bool `json:"explain,omitempty"`
+	// Counterfactual adds the nearest normal variant to each explanation.
+	// It implies Explain.
+	Counterfactual bool `json:"counterfactual,omitempty"`
+	// Entities names the entity, such as a host or user, of each sample
+	// for the score history, see WithHistory. Empty or one per sample.
+	Entities []string `json:"entities,omitempty"`
+}
+
+// PredictResponse is the body of a scoring response.
+type PredictResponse struct {
+	Results []guardio.Result `json:"results"`
+}
+
+func (s *Server) handlePredict(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePredictRequest(w, r)
+	if !ok {
+		return
+	}
+
+	scores, err := s.detector.PredictContext(r.Context(), req.Samples)
+	if err != nil {
+		writeError(w, predictStatus(err), err)
+		return
+	}
+
+	threshold := detectors.ThresholdOf(s.detector)
+	now := time.Now().Unix()
+	results := make([]guardio.Result, len(scores))
+	for i, score := range scores {
+		results[i] = guardio.Result{
+			Timestamp: now,
+			Score:     score,
+			IsAnomaly: score >= threshold,
+			Severity:  s.grade(score),
+		}
+	}
+	s.window.addAll(scores, threshold)
+	s.scores.AddAll(scores, threshold)
+	s.dashboard.record("", s.detector, threshold, req.Samples, scores)
+
+	if err := s.auditResults(r, "", s.detector, threshold, req.Samples, results); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("audit log: %w", err))
+		return
+	}
+	if err := s.recordHistory("", req, results); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("history: %w", err))
+		return
+	}
+
+	if req.Explain || req.Counterfactual {
+		if err := explainAnomalies(results, req, s.detector); err != nil {
+			writeError(w, explainStatus(err), err)
+			return
+		}
+	}
+
+	writeResults(w, r, results)
+}
+
+func (s *Server) handleRoutePredict(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePredictRequest(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	scores, err := s.router.ScoreBatchContext(r.Context(), key, req.Samples)
+	if errors.Is(err, router.ErrNoRoute) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, predictStatus(err), err)
+		return
+	}
+
+	now := time.Now().Unix()
+	results := make([]guardio.Result, len(scores))
+	for i, score := range scores {
+		results[i] = guardio.Result{
+			Timestamp: now,
+			Score:     score.Value,
+			IsAnomaly: score.IsAnomaly,
+			Metadata:  score.Metadata,
+			Severity:  s.grade(score.Value),
+		}
+	}
+
+	d, err := s.router.Resolve(key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if s.audit != nil || s.dashboard != nil {
+		threshold, err := s.router.Threshold(key)
+		if err == nil {
+			values := make([]float64, len(scores))
+			for i, score := range scores {
+				values[i] = score.Value
+			}
+			s.dashboard.record(key, d, threshold, req.Samples, values)
+			err = s.auditResults(r, key, d, threshold, req.Samples, results)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("audit log: %w", err))
+			return
+		}
+	}
+	if err := s.recordHistory(key, req, results); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("history: %w", err))
+		return
+	}
+
+	if req.Explain || req.Counterfactual {
+		if err := explainAnomalies(results, req, d); err != nil {
+			writeError(w, explainStatus(err), err)
+			return
+		}
+	}
+
+	writeResults(w, r, results)
+}
+
+func (s *Server) handleRoutes(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.router.Stats())
+}
+
+// grade returns the severity of score, SeverityNone without bands.
+func (s *Server) grade(score float64) detectors.Severity {
+	if s.severity == nil {
+		return detectors.SeverityNone
+	}
+	return s.severity.Grade(score)
+}
+
+// explainAnomalies attaches explanations from d to the anomalous results.
+func explainAnomalies(results []guardio.Result, req PredictRequest, d detectors.Detector) error {
+	for i := range results {
+		if !results[i].IsAnomaly {
+			continue
+		}
+		exp, err := detectors.Explain(d, req.Samples[i])
+		if err != nil {
+			return err
+		}
+		if req.Counterfactual {
+			cf, err := detectors.ExplainCounterfactual(d, req.Samples[i])
+			if err != nil {
+				return err
+			}
+			exp.Counterfactual = &cf
+		}
+		results[i].Explanation = &exp
+	}
+	return nil
+}
+
+// predictStatus maps scoring errors to HTTP status codes: requests past
+// their deadline or canceled while scoring are unavailable, like those
+// timing out in the queue, and so are untrained models; other errors are
+// the input's fault.
+func predictStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, detectors.ErrNotTrained) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// explainStatus maps explanation errors to HTTP status codes.
+func explainStatus(err error) int {
+	if errors.Is(err, detectors.ErrNotExplainable) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// decodePredictRequest parses a scoring request, in JSON or, with
+// Content-Type application/x-protobuf, Protocol Buffers, writing an error
+// response on failure.
+func decodePredictRequest(w http.ResponseWriter, r *http.Request) (PredictRequest, bool) {
+	var req PredictRequest
+	body := http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if mediaTypeOf(r.Header.Get("Content-Type")) == protobufType {
+		data, err := io.ReadAll(body)
+		if err == nil {
+			var preq pb.PredictRequest
+			preq, err = pb.UnmarshalPredictRequest(data)
+			req = PredictRequest(preq)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return req, false
+		}
+	} else if err := json.NewDecoder(body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return req, false
+	}
+	if len(req.Samples) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no samples"))
+		return req, false
+	}
+	if len(req.Entities) > 0 && len(req.Entities) != len(req.Samples) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%d entities for %d samples", len(req.Entities), len(req.Samples)))
+		return req, false
+	}
+	return req, true
+}
+
+// writeResults writes a scoring response, as goguardml.v1.Results if the
+// request accepts application/x-protobuf, as PredictResponse JSON
+// otherwise.
+func writeResults(w http.ResponseWriter, r *http.Request, results []guardio.Result) {
+	if !accepts(r, protobufType) {
+		writeJSON(w, http.StatusOK, PredictResponse{Results: results})
+		return
+	}
+	data, err := protobuf.MarshalResults(results)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", protobufType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// accepts reports whether the Accept header of r lists mediaType.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			if mediaTypeOf(t) == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mediaTypeOf returns the media type of a Content-Type or Accept value,
+// without parameters.
+func mediaTypeOf(v string) string {
+	t, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+	"github.com/hed1ad/goguardml/pkg/detectors/iforest"
+	"github.com/hed1ad/goguardml/pkg/io/protobuf"
+	"github.com/hed1ad/goguardml/pkg/pb"
+	"github.com/hed1ad/goguardml/pkg/router"
+)
+
+func TestHandlePredict(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(20), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+	srv := New(f)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantResults int
+	}{
+		{
+			name:        "valid samples",
+			body:        `{"samples": [[0.1, 0.2, 0.3], [100, 100, 100]]}`,
+			wantStatus:  http.StatusOK,
+			wantResults: 2,
+		},
+		{
+			name:       "malformed body",
+			body:       `{"samples": `,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no samples",
+			body:       `{"samples": []}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp PredictResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(t, resp.Results, tt.wantResults)
+			assert.True(t, resp.Results[1].IsAnomaly)
+		})
+	}
+}
+
+func TestHandlePredictSeverity(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(20), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+	srv := New(f, WithSeverityBands(detectors.SeverityBands{Low: 0.5, Medium: 0.6, High: 0.65, Critical: 0.7}))
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewBufferString(`{"samples": [[0, 0, 0], [100, 100, 100]]}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"severity":"critical"`)
+	var resp PredictResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, detectors.SeverityInfo, resp.Results[0].Severity)
+	assert.Equal(t, detectors.SeverityCritical, resp.Results[1].Severity)
+}
+
+func TestHandlePredictProtobuf(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(20), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+	srv := New(f)
+
+	body := pb.MarshalPredictRequest(pb.PredictRequest{Samples: [][]float64{{0.1, 0.2, 0.3}, {100, 100, 100}}, Explain: true})
+	req := httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Accept", "application/json;q=0.5, application/x-protobuf")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/x-protobuf", rec.Header().Get("Content-Type"))
+	results, err := protobuf.UnmarshalResults(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.False(t, results[0].IsAnomaly)
+	assert.True(t, results[1].IsAnomaly)
+	assert.NotNil(t, results[1].Explanation)
+
+	// Protobuf requests get JSON responses unless they accept protobuf.
+	req = httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp PredictResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Len(t, resp.Results, 2)
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewReader([]byte{0x0a, 0x05}))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandlePredictExplain(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(100), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+	// Put the threshold between the two samples so only the second is flagged.
+	scores, err := f.Predict([][]float64{{0.1, 0.2, 0.3}, {0.1, 100, 0.3}})
+	require.NoError(t, err)
+	f.SetThreshold((scores[0] + scores[1]) / 2)
+
+	body := `{"samples": [[0.1, 0.2, 0.3], [0.1, 100, 0.3]], "counterfactual": true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	New(f).Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp PredictResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Results, 2)
+
+	assert.Nil(t, resp.Results[0].Explanation)
+	exp := resp.Results[1].Explanation
+	require.NotNil(t, exp)
+	require.NotEmpty(t, exp.Top)
+	assert.Equal(t, 1, exp.Top[0].Index)
+	assert.Equal(t, 100.0, exp.Top[0].Value)
+	require.NotNil(t, exp.Top[0].Typical)
+	assert.Less(t, exp.Top[0].Typical.High, 100.0)
+
+	require.NotNil(t, exp.Counterfactual)
+	require.NotEmpty(t, exp.Counterfactual.Changes)
+	assert.Equal(t, 1, exp.Counterfactual.Changes[0].Index)
+}
+
+func TestHandleRoutePredict(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(20), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(200, 3)))
+
+	rt := router.New()
+	rt.Add("eth0", f)
+	srv := New(f, WithRouter(rt))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "known route", path: "/v1/predict/eth0", wantStatus: http.StatusOK},
+		{name: "unknown route", path: "/v1/predict/eth9", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.NewBufferString(`{"samples": [[0.1, 0.2, 0.3]]}`)
+			req := httptest.NewRequest(http.MethodPost, tt.path, body)
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/routes", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"eth0"`)
+}
+
+func TestPredictCanceled(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	rt := router.New()
+	rt.Add("eth0", f)
+	srv := New(f, WithRouter(rt))
+
+	for _, path := range []string{"/v1/predict", "/v1/predict/eth0"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{"samples": [[0.1, 0.2, 0.3]]}`))
+		rec := httptest.NewRecorder()
+
+		srv.Handler().ServeHTTP(rec, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), context.Canceled.Error(), path)
+	}
+}
+
+func TestPredictErrors(t *testing.T) {
+	f := iforest.New(iforest.WithTrees(10), iforest.WithSeed(42))
+	predict := func(body string) int {
+		rec := httptest.NewRecorder()
+		New(f).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/predict", bytes.NewBufferString(body)))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, predict(`{"samples": [[0.1, 0.2, 0.3]]}`), "untrained")
+	require.NoError(t, f.Fit(generateTestData(100, 3)))
+	assert.Equal(t, http.StatusUnprocessableEntity, predict(`{"samples": [[0.1, 0.2]]}`), "wrong dimension")
+}
+
+func generateTestData(n, features int) [][]float64 {
+	data := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		data[i] = make([]float64, features)
+		for j := 0; j < features; j++ {
+			data[i][j] = rand.NormFloat64()
+		}
+	}
+	return data
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hed1ad/goguardml/pkg/detectors"
+)
+
+// WithTelemetry collects the internals of the served detector, if it is a
+// detectors.TelemetryReporter, in periods of interval, and reports the
+// latest complete period in /admin/stats.
+func WithTelemetry(interval time.Duration) Option {
+	return func(s *Server) {
+		s.telemetry = &telemetry{interval: interval}
+	}
+}
+
+// telemetry holds the latest telemetry of the served detector.
+type telemetry struct {
+	interval time.Duration
+	last     atomic.Pointer[detectors.Telemetry]
+}
+
+// watch starts collecting telemetry from d.
+func (t *telemetry) watch(d detectors.Detector) {
+	if r, ok := d.(detectors.TelemetryReporter); ok {
+		r.SetTelemetryHandler(t.interval, func(tel detectors.Telemetry) {
+			t.last.Store(&tel)
+		})
+	}
+}
+
+// latest returns the latest complete period, or nil if there is none yet
+// or t is nil.
+func (t *telemetry) latest() *detectors.Telemetry {
+	if t == nil {
+		return nil
+	}
+	return t.last.Load()
+}
@@ -0,0 +1,54 @@
+package stats
+
+import "math"
+
+// scaleOf returns a power of two near the largest magnitude of values, so
+// the values divided by it lie within 2 of zero, or 1 if there is none.
+// Dividing by a power of two is exact, so sums and squares of the scaled
+// values round as those of the values themselves would, but cannot
+// overflow.
+func scaleOf(values []float64) float64 {
+	var largest float64
+	for _, v := range values {
+		largest = max(largest, math.Abs(v))
+	}
+	if largest == 0 || math.IsInf(largest, 0) || math.IsNaN(largest) {
+		return 1
+	}
+	_, exp := math.Frexp(largest)
+	return math.Ldexp(1, exp-1)
+}
+
+// Mean returns the mean of values, NaN if there are none. It does not
+// overflow for finite values, however large.
+func Mean(values []float64) float64 {
+	scale := scaleOf(values)
+	var sum float64
+	for _, v := range values {
+		sum += v / scale
+	}
+	return sum / float64(len(values)) * scale
+}
+
+// MeanStd returns the mean and population standard deviation of values,
+// NaN if there are none. Unlike the textbook formulas it does not overflow
+// for finite values above 1e154, whose squares would.
+func MeanStd(values []float64) (mean, std float64) {
+	scale := scaleOf(values)
+	for _, v := range values {
+		mean += v / scale
+	}
+	mean /= float64(len(values))
+	var ss float64
+	for _, v := range values {
+		d := v/scale - mean
+		ss += d * d
+	}
+	return mean * scale, math.Sqrt(ss/float64(len(values))) * scale
+}
+
+// Standardize returns (v - mean) / std, halving v and mean first so their
+// difference cannot overflow when they are finite.
+func Standardize(v, mean, std float64) float64 {
+	return (v/2 - mean/2) / std * 2
+}
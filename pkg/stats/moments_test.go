@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeanStd(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		mean, std float64
+	}{
+		{name: "small", values: []float64{1, 2, 3, 4}, mean: 2.5, std: math.Sqrt(1.25)},
+		{name: "zeros", values: []float64{0, 0}, mean: 0, std: 0},
+		{name: "squares overflow", values: []float64{-1e160, 1e160}, mean: 0, std: 1e160},
+		{name: "sum overflows", values: []float64{math.MaxFloat64, math.MaxFloat64}, mean: math.MaxFloat64, std: 0},
+		{name: "difference overflows", values: []float64{-math.MaxFloat64, math.MaxFloat64}, mean: 0, std: math.MaxFloat64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, std := MeanStd(tt.values)
+			assert.InEpsilon(t, 1+tt.mean, 1+mean, 1e-15)
+			assert.InEpsilon(t, 1+tt.std, 1+std, 1e-15)
+			assert.InEpsilon(t, 1+tt.mean, 1+Mean(tt.values), 1e-15)
+		})
+	}
+
+	// Powers of two scale exactly: ordinary values give the textbook results.
+	values := []float64{0.1, 0.7, 3.3, -2.9, 12.25}
+	var sum, ss float64
+	for _, v := range values {
+		sum += v
+	}
+	want := sum / float64(len(values))
+	for _, v := range values {
+		ss += (v - want) * (v - want)
+	}
+	mean, std := MeanStd(values)
+	assert.Equal(t, want, mean)
+	assert.Equal(t, math.Sqrt(ss/float64(len(values))), std)
+
+	assert.True(t, math.IsNaN(Mean(nil)))
+}
+
+func TestStandardize(t *testing.T) {
+	assert.Equal(t, 2.0, Standardize(5, 1, 2))
+	assert.Equal(t, 2.0, Standardize(math.MaxFloat64, -math.MaxFloat64, math.MaxFloat64))
+}
@@ -0,0 +1,294 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Default drift thresholds.
+const (
+	// DefaultPSIThreshold is the population stability index above which a
+	// feature is considered drifted; 0.1-0.2 is commonly read as moderate
+	// and above 0.2 as significant shift.
+	DefaultPSIThreshold = 0.2
+	// DefaultKSAlpha is the significance level of the Kolmogorov-Smirnov test.
+	DefaultKSAlpha = 0.01
+)
+
+// profileQuantiles is the number of quantiles kept per feature for the
+// Kolmogorov-Smirnov test: every percentile from 0 to 100.
+const profileQuantiles = 101
+
+// psiEpsilon replaces empty bin fractions so PSI stays finite.
+const psiEpsilon = 1e-4
+
+// Profile is the per-feature distribution of training data. It is stored
+// with a model so live data can be compared against it later.
+type Profile struct {
+	// Samples is the number of training samples profiled.
+	Samples  int
+	Features []FeatureProfile
+}
+
+// FeatureProfile is the training distribution of one feature.
+type FeatureProfile struct {
+	Mean   float64
+	StdDev float64
+	// Edges are the upper bounds of the histogram bins; values above the
+	// last edge fall in a final bin. Bins hold roughly equal training mass.
+	Edges []float64
+	// Fractions is the share of training values in each bin.
+	Fractions []float64
+	// Quantiles are the training percentiles 0 through 100.
+	Quantiles []float64
+}
+
+// NewProfile profiles data with up to bins quantile-based bins per feature.
+// Every row must have the same number of features.
+func NewProfile(data [][]float64, bins int) (*Profile, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty data")
+	}
+	bins = max(bins, 2)
+
+	nFeatures := len(data[0])
+	p := &Profile{Samples: len(data), Features: make([]FeatureProfile, nFeatures)}
+	column := make([]float64, len(data))
+	for j := range p.Features {
+		for i, row := range data {
+			if len(row) != nFeatures {
+				return nil, fmt.Errorf("row %d has %d features, expected %d", i, len(row), nFeatures)
+			}
+			column[i] = row[j]
+		}
+		sort.Float64s(column)
+		p.Features[j] = profileFeature(column, bins)
+	}
+	return p, nil
+}
+
+// profileFeature profiles one sorted column.
+func profileFeature(sorted []float64, bins int) FeatureProfile {
+	n := len(sorted)
+	fp := FeatureProfile{Quantiles: make([]float64, profileQuantiles)}
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	fp.Mean = sum / float64(n)
+	var sq float64
+	for _, v := range sorted {
+		sq += (v - fp.Mean) * (v - fp.Mean)
+	}
+	fp.StdDev = math.Sqrt(sq / float64(n))
+
+	for i := range fp.Quantiles {
+		fp.Quantiles[i] = sorted[(n-1)*i/(profileQuantiles-1)]
+	}
+
+	// Equal-mass bin edges, deduplicated so discrete features get one bin
+	// per distinct value rather than empty bins.
+	for b := 1; b < bins; b++ {
+		edge := sorted[(n-1)*b/bins]
+		if len(fp.Edges) == 0 || edge > fp.Edges[len(fp.Edges)-1] {
+			fp.Edges = append(fp.Edges, edge)
+		}
+	}
+
+	counts := make([]int, len(fp.Edges)+1)
+	for _, v := range sorted {
+		counts[fp.bin(v)]++
+	}
+	fp.Fractions = make([]float64, len(counts))
+	for i, c := range counts {
+		fp.Fractions[i] = float64(c) / float64(n)
+	}
+	return fp
+}
+
+// bin returns the histogram bin of v.
+func (fp *FeatureProfile) bin(v float64) int {
+	return sort.SearchFloat64s(fp.Edges, v)
+}
+
+// cdf estimates the training CDF at v by interpolating the quantiles.
+func (fp *FeatureProfile) cdf(v float64) float64 {
+	q := fp.Quantiles
+	last := len(q) - 1
+	if v < q[0] {
+		return 0
+	}
+	if v >= q[last] {
+		return 1
+	}
+	// Index of the first quantile above v.
+	i := sort.Search(len(q), func(i int) bool { return q[i] > v })
+	lo := q[i-1]
+	frac := 1.0
+	if q[i] > lo {
+		frac = (v - lo) / (q[i] - lo)
+	}
+	return (float64(i-1) + frac) / float64(last)
+}
+
+// cdfBelow estimates the fraction of training values strictly below v.
+// It differs from cdf only where training values have ties.
+func (fp *FeatureProfile) cdfBelow(v float64) float64 {
+	q := fp.Quantiles
+	last := len(q) - 1
+	if v <= q[0] {
+		return 0
+	}
+	if v > q[last] {
+		return 1
+	}
+	// Index of the first quantile at or above v.
+	i := sort.SearchFloat64s(q, v)
+	lo := q[i-1]
+	return (float64(i-1) + (v-lo)/(q[i]-lo)) / float64(last)
+}
+
+// DriftReport compares live data against a training profile.
+type DriftReport struct {
+	// Samples is the number of live samples compared.
+	Samples  int            `json:"samples"`
+	Features []FeatureDrift `json:"features"`
+	// Drifted lists the indices of drifted features, most drifted first.
+	Drifted []int `json:"drifted"`
+}
+
+// FeatureDrift describes the drift of one feature.
+type FeatureDrift struct {
+	Index int `json:"index"`
+	// PSI is the population stability index over the training bins.
+	PSI float64 `json:"psi"`
+	// KS is the Kolmogorov-Smirnov statistic: the largest gap between the
+	// training and live CDFs.
+	KS float64 `json:"ks"`
+	// KSCritical is the KS value above which the distributions differ at
+	// the configured significance level.
+	KSCritical   float64 `json:"ks_critical"`
+	TrainingMean float64 `json:"training_mean"`
+	LiveMean     float64 `json:"live_mean"`
+	Drifted      bool    `json:"drifted"`
+}
+
+// CompareOption configures Profile.Compare.
+type CompareOption func(*compareConfig)
+
+type compareConfig struct {
+	psiThreshold float64
+	ksAlpha      float64
+}
+
+// WithPSIThreshold sets the PSI above which a feature is drifted.
+func WithPSIThreshold(t float64) CompareOption {
+	return func(c *compareConfig) {
+		c.psiThreshold = t
+	}
+}
+
+// WithKSAlpha sets the significance level of the KS test.
+func WithKSAlpha(alpha float64) CompareOption {
+	return func(c *compareConfig) {
+		c.ksAlpha = alpha
+	}
+}
+
+// Compare reports how far live data has drifted from the profile. A feature
+// is drifted if its PSI exceeds the threshold or the KS test rejects that
+// live and training values share a distribution.
+func (p *Profile) Compare(live [][]float64, opts ...CompareOption) (DriftReport, error) {
+	cfg := compareConfig{psiThreshold: DefaultPSIThreshold, ksAlpha: DefaultKSAlpha}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	report := DriftReport{Samples: len(live), Features: make([]FeatureDrift, len(p.Features))}
+	if len(live) == 0 {
+		return report, errors.New("no live samples")
+	}
+
+	// Two-sample KS critical value: c(alpha) * sqrt((n+m)/(n*m)).
+	n, m := float64(p.Samples), float64(len(live))
+	critical := math.Sqrt(-math.Log(cfg.ksAlpha/2)/2) * math.Sqrt((n+m)/(n*m))
+
+	column := make([]float64, len(live))
+	for j := range p.Features {
+		fp := &p.Features[j]
+		var sum float64
+		for i, row := range live {
+			if len(row) != len(p.Features) {
+				return report, fmt.Errorf("sample %d has %d features, profile has %d", i, len(row), len(p.Features))
+			}
+			column[i] = row[j]
+			sum += row[j]
+		}
+		sort.Float64s(column)
+
+		fd := FeatureDrift{
+			Index:        j,
+			PSI:          fp.psi(column),
+			KS:           fp.ks(column),
+			KSCritical:   critical,
+			TrainingMean: fp.Mean,
+			LiveMean:     sum / m,
+		}
+		fd.Drifted = fd.PSI > cfg.psiThreshold || fd.KS > critical
+		report.Features[j] = fd
+	}
+
+	for _, fd := range report.Features {
+		if fd.Drifted {
+			report.Drifted = append(report.Drifted, fd.Index)
+		}
+	}
+	// Rank by how far each statistic exceeds its threshold.
+	strength := func(i int) float64 {
+		fd := report.Features[i]
+		return math.Max(fd.PSI/cfg.psiThreshold, fd.KS/critical)
+	}
+	sort.SliceStable(report.Drifted, func(a, b int) bool {
+		return strength(report.Drifted[a]) > strength(report.Drifted[b])
+	})
+	return report, nil
+}
+
+// psi computes the population stability index of live values over the
+// training bins.
+func (fp *FeatureProfile) psi(live []float64) float64 {
+	counts := make([]int, len(fp.Fractions))
+	for _, v := range live {
+		counts[fp.bin(v)]++
+	}
+
+	var psi float64
+	for i, c := range counts {
+		expected := math.Max(fp.Fractions[i], psiEpsilon)
+		actual := math.Max(float64(c)/float64(len(live)), psiEpsilon)
+		psi += (actual - expected) * math.Log(actual/expected)
+	}
+	return psi
+}
+
+// ks computes the largest gap between the live empirical CDF of the sorted
+// values and the training CDF.
+func (fp *FeatureProfile) ks(sorted []float64) float64 {
+	n := float64(len(sorted))
+	var d float64
+	for i := 0; i < len(sorted); {
+		// Step over ties so the live CDF is evaluated after all of them.
+		j := i
+		for j < len(sorted) && sorted[j] == sorted[i] {
+			j++
+		}
+		v := sorted[i]
+		d = math.Max(d, math.Abs(float64(j)/n-fp.cdf(v)))
+		d = math.Max(d, math.Abs(float64(i)/n-fp.cdfBelow(v)))
+		i = j
+	}
+	return d
+}
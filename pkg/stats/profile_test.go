@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileCompare(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sample := func(n int, shift, scale []float64) [][]float64 {
+		data := make([][]float64, n)
+		for i := range data {
+			data[i] = make([]float64, len(shift))
+			for j := range shift {
+				data[i][j] = shift[j] + scale[j]*rng.NormFloat64()
+			}
+		}
+		return data
+	}
+
+	training := sample(5000, []float64{0, 0, 0}, []float64{1, 1, 1})
+	p, err := NewProfile(training, 10)
+	require.NoError(t, err)
+	require.Len(t, p.Features, 3)
+	assert.Equal(t, 5000, p.Samples)
+
+	tests := []struct {
+		name        string
+		live        [][]float64
+		wantDrifted []int
+	}{
+		{
+			name: "same distribution",
+			live: sample(2000, []float64{0, 0, 0}, []float64{1, 1, 1}),
+		},
+		{
+			name:        "shifted mean and widened spread",
+			live:        sample(2000, []float64{0, 0, 3}, []float64{1, 2, 1}),
+			wantDrifted: []int{2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := p.Compare(tt.live)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.live), report.Samples)
+			assert.Equal(t, tt.wantDrifted, report.Drifted)
+			for _, fd := range report.Features {
+				assert.GreaterOrEqual(t, fd.PSI, 0.0)
+				assert.LessOrEqual(t, fd.KS, 1.0)
+			}
+		})
+	}
+}
+
+func TestProfileDiscreteFeature(t *testing.T) {
+	training := make([][]float64, 1000)
+	for i := range training {
+		training[i] = []float64{float64(i % 2)}
+	}
+	p, err := NewProfile(training, 10)
+	require.NoError(t, err)
+
+	fp := p.Features[0]
+	assert.Equal(t, []float64{0, 1}, fp.Edges)
+	assert.InDeltaSlice(t, []float64{0.5, 0.5, 0}, fp.Fractions, 1e-9)
+
+	report, err := p.Compare(training[:100])
+	require.NoError(t, err)
+	assert.Empty(t, report.Drifted)
+
+	allOnes := [][]float64{{1}, {1}, {1}, {1}}
+	report, err = p.Compare(allOnes, WithPSIThreshold(0.5), WithKSAlpha(0.05))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, report.Drifted)
+}
+
+func TestProfileErrors(t *testing.T) {
+	_, err := NewProfile(nil, 10)
+	assert.Error(t, err)
+
+	_, err = NewProfile([][]float64{{1, 2}, {3}}, 10)
+	assert.Error(t, err)
+
+	p, err := NewProfile([][]float64{{1, 2}, {3, 4}}, 10)
+	require.NoError(t, err)
+
+	_, err = p.Compare(nil)
+	assert.Error(t, err)
+
+	_, err = p.Compare([][]float64{{1}})
+	assert.Error(t, err)
+}
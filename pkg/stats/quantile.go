@@ -0,0 +1,85 @@
+package stats
+
+import "math"
+
+// DefaultExactLimit is the number of values a QuantileEstimator keeps
+// exactly, when given a negative limit, before switching to a t-digest.
+const DefaultExactLimit = 1 << 16
+
+// QuantileEstimator estimates quantiles of a stream of values in bounded
+// memory. It keeps the first values exactly, so quantiles of small inputs
+// are the same values selection over all of them gives, and once more
+// than its limit have been added it moves them into a t-digest and keeps
+// only that. It is not safe for concurrent use.
+type QuantileEstimator struct {
+	limit       int
+	compression float64
+	exact       []float64
+	digest      *TDigest
+}
+
+// NewQuantileEstimator creates an empty estimator keeping up to limit
+// values exactly (DefaultExactLimit if negative) and then a t-digest of
+// the given compression.
+func NewQuantileEstimator(limit int, compression float64) *QuantileEstimator {
+	if limit < 0 {
+		limit = DefaultExactLimit
+	}
+	return &QuantileEstimator{limit: limit, compression: compression}
+}
+
+// Add records a value. NaN values are ignored.
+func (e *QuantileEstimator) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	if e.digest != nil {
+		e.digest.Add(x)
+		return
+	}
+	if len(e.exact) < e.limit {
+		e.exact = append(e.exact, x)
+		return
+	}
+	e.digest = NewTDigest(e.compression)
+	for _, v := range e.exact {
+		e.digest.Add(v)
+	}
+	e.exact = nil
+	e.digest.Add(x)
+}
+
+// AddAll records values.
+func (e *QuantileEstimator) AddAll(values []float64) {
+	for _, v := range values {
+		e.Add(v)
+	}
+}
+
+// Count returns the number of values recorded.
+func (e *QuantileEstimator) Count() int {
+	if e.digest != nil {
+		return int(e.digest.Count())
+	}
+	return len(e.exact)
+}
+
+// Exact reports whether Quantile is still exact, that is whether no more
+// than the limit of values have been added.
+func (e *QuantileEstimator) Exact() bool {
+	return e.digest == nil
+}
+
+// Quantile returns the q-quantile, q in [0, 1], or NaN if empty. While
+// exact, it is the value at index (n-1)*q, rounded down, of the values in
+// sorted order; the values kept are reordered.
+func (e *QuantileEstimator) Quantile(q float64) float64 {
+	if e.digest != nil {
+		return e.digest.Quantile(q)
+	}
+	if len(e.exact) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	q = min(max(q, 0), 1)
+	return Select(e.exact, int(float64(len(e.exact)-1)*q))
+}
@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantileEstimatorExact(t *testing.T) {
+	tests := []struct {
+		name string
+		data []float64
+		q    float64
+		want float64
+	}{
+		{name: "min", data: []float64{3, 1, 2}, q: 0, want: 1},
+		{name: "max", data: []float64{3, 1, 2}, q: 1, want: 3},
+		{name: "ninetieth", data: []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, q: 0.9, want: 9},
+		{name: "rounds down", data: []float64{4, 3, 2, 1}, q: 0.5, want: 2},
+		{name: "ignores NaN", data: []float64{math.NaN(), 2, 1}, q: 1, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewQuantileEstimator(-1, 0)
+			e.AddAll(tt.data)
+			assert.True(t, e.Exact())
+			assert.Equal(t, tt.want, e.Quantile(tt.q))
+		})
+	}
+
+	assert.True(t, math.IsNaN(NewQuantileEstimator(-1, 0).Quantile(0.5)), "empty")
+}
+
+func TestQuantileEstimatorDigest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	e := NewQuantileEstimator(1000, 200)
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = rng.ExpFloat64()
+		e.Add(values[i])
+		if i == 999 {
+			assert.True(t, e.Exact())
+		}
+	}
+	assert.False(t, e.Exact())
+	assert.Equal(t, len(values), e.Count())
+
+	sort.Float64s(values)
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		rank := sort.SearchFloat64s(values, e.Quantile(q))
+		assert.InDelta(t, q, float64(rank)/float64(len(values)), 0.001, "q=%v", q)
+	}
+}
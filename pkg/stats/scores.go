@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"math"
+	"sync"
+)
+
+// ScoreStats accumulates the distribution of anomaly scores: moments,
+// t-digest quantiles, and fixed-width histogram buckets over [0, 1].
+// It is safe for concurrent use.
+type ScoreStats struct {
+	mu sync.Mutex
+
+	digest    *TDigest
+	buckets   []uint64
+	count     uint64
+	anomalies uint64
+	mean      float64
+	m2        float64 // sum of squared deviations, for variance
+}
+
+// Option configures a ScoreStats.
+type Option func(*ScoreStats)
+
+// WithBuckets sets the number of histogram buckets over [0, 1].
+func WithBuckets(n int) Option {
+	return func(s *ScoreStats) {
+		s.buckets = make([]uint64, max(n, 1))
+	}
+}
+
+// WithCompression sets the t-digest compression used for quantiles.
+func WithCompression(c float64) Option {
+	return func(s *ScoreStats) {
+		s.digest = NewTDigest(c)
+	}
+}
+
+// NewScoreStats creates an empty accumulator with 20 buckets.
+func NewScoreStats(opts ...Option) *ScoreStats {
+	s := &ScoreStats{
+		digest:  NewTDigest(DefaultCompression),
+		buckets: make([]uint64, 20),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Add records a score and whether it was flagged as an anomaly.
+func (s *ScoreStats) Add(score float64, anomaly bool) {
+	if math.IsNaN(score) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(score, anomaly)
+}
+
+// AddAll records scores flagged against threshold.
+func (s *ScoreStats) AddAll(scores []float64, threshold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, score := range scores {
+		if !math.IsNaN(score) {
+			s.add(score, score >= threshold)
+		}
+	}
+}
+
+func (s *ScoreStats) add(score float64, anomaly bool) {
+	s.count++
+	if anomaly {
+		s.anomalies++
+	}
+
+	// Welford's online algorithm for mean and variance.
+	delta := score - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (score - s.mean)
+
+	s.digest.Add(score)
+
+	i := int(score * float64(len(s.buckets)))
+	s.buckets[min(len(s.buckets)-1, max(0, i))]++
+}
+
+// Quantile returns the estimated q-quantile of recorded scores, or NaN if
+// none were recorded.
+func (s *ScoreStats) Quantile(q float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.digest.Quantile(q)
+}
+
+// CDF returns the estimated fraction of recorded scores at or below score.
+func (s *ScoreStats) CDF(score float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.digest.CDF(score)
+}
+
+// Reset discards all recorded scores.
+func (s *ScoreStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.digest = NewTDigest(s.digest.compression)
+	s.buckets = make([]uint64, len(s.buckets))
+	s.count, s.anomalies = 0, 0
+	s.mean, s.m2 = 0, 0
+}
+
+// Snapshot is a point-in-time summary of a ScoreStats.
+type Snapshot struct {
+	Count       uint64   `json:"count"`
+	Anomalies   uint64   `json:"anomalies"`
+	AnomalyRate float64  `json:"anomaly_rate"`
+	Mean        float64  `json:"mean"`
+	StdDev      float64  `json:"std_dev"`
+	Min         float64  `json:"min"`
+	Max         float64  `json:"max"`
+	P50         float64  `json:"p50"`
+	P90         float64  `json:"p90"`
+	P95         float64  `json:"p95"`
+	P99         float64  `json:"p99"`
+	P999        float64  `json:"p999"`
+	Buckets     []Bucket `json:"buckets"`
+}
+
+// Bucket is a histogram bucket of scores in [Low, High).
+type Bucket struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Count uint64  `json:"count"`
+}
+
+// Snapshot returns a summary of the recorded scores.
+func (s *ScoreStats) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := Snapshot{
+		Count:     s.count,
+		Anomalies: s.anomalies,
+		Buckets:   make([]Bucket, len(s.buckets)),
+	}
+	width := 1 / float64(len(s.buckets))
+	for i, c := range s.buckets {
+		snap.Buckets[i] = Bucket{Low: float64(i) * width, High: float64(i+1) * width, Count: c}
+	}
+	if s.count == 0 {
+		return snap
+	}
+
+	snap.AnomalyRate = float64(s.anomalies) / float64(s.count)
+	snap.Mean = s.mean
+	snap.StdDev = math.Sqrt(s.m2 / float64(s.count))
+	snap.Min = s.digest.Min()
+	snap.Max = s.digest.Max()
+	snap.P50 = s.digest.Quantile(0.5)
+	snap.P90 = s.digest.Quantile(0.9)
+	snap.P95 = s.digest.Quantile(0.95)
+	snap.P99 = s.digest.Quantile(0.99)
+	snap.P999 = s.digest.Quantile(0.999)
+	return snap
+}
@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreStats(t *testing.T) {
+	s := NewScoreStats(WithBuckets(4))
+	s.AddAll([]float64{0.1, 0.2, 0.3, 0.9, math.NaN()}, 0.5)
+	s.Add(1.0, true)
+
+	snap := s.Snapshot()
+	assert.Equal(t, uint64(5), snap.Count)
+	assert.Equal(t, uint64(2), snap.Anomalies)
+	assert.InDelta(t, 0.4, snap.AnomalyRate, 1e-9)
+	assert.InDelta(t, 0.5, snap.Mean, 1e-9)
+	assert.InDelta(t, math.Sqrt(0.14), snap.StdDev, 1e-9)
+	assert.Equal(t, 0.1, snap.Min)
+	assert.Equal(t, 1.0, snap.Max)
+	assert.InDelta(t, 0.3, snap.P50, 1e-9)
+
+	require.Len(t, snap.Buckets, 4)
+	counts := make([]uint64, len(snap.Buckets))
+	for i, b := range snap.Buckets {
+		counts[i] = b.Count
+	}
+	// A score of exactly 1 falls in the last bucket.
+	assert.Equal(t, []uint64{2, 1, 0, 2}, counts)
+	assert.Equal(t, 0.75, snap.Buckets[3].Low)
+	assert.Equal(t, 1.0, snap.Buckets[3].High)
+
+	assert.InDelta(t, 0.5, s.CDF(0.25), 0.11)
+
+	s.Reset()
+	snap = s.Snapshot()
+	assert.Zero(t, snap.Count)
+	assert.Zero(t, snap.Mean)
+	assert.Len(t, snap.Buckets, 4)
+	assert.True(t, math.IsNaN(s.Quantile(0.5)))
+}
+
+func TestScoreStatsConcurrent(t *testing.T) {
+	s := NewScoreStats()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Add(float64(i)/1000, false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := s.Snapshot()
+	assert.Equal(t, uint64(8000), snap.Count)
+	assert.InDelta(t, 0.5, snap.P50, 0.01)
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// Select returns the k-th smallest value of data (0-based), reordering data
+// in place so that data[k] holds it, values before it are no greater and
+// values after it are no smaller. It runs in expected linear time; if
+// partitioning degrades it falls back to sorting, so the worst case is
+// O(n log n). Select panics if k is out of range.
+func Select(data []float64, k int) float64 {
+	if k < 0 || k >= len(data) {
+		panic("stats: Select index out of range")
+	}
+
+	lo, hi := 0, len(data)
+	budget := 2 * bits.Len(uint(len(data)))
+	for hi-lo > 16 {
+		if budget == 0 {
+			slices.Sort(data[lo:hi])
+			return data[k]
+		}
+		budget--
+
+		lt, gt := partition3(data[lo:hi], medianOfThree(data[lo:hi]))
+		switch {
+		case k < lo+lt:
+			hi = lo + lt
+		case k >= lo+gt:
+			lo += gt
+		default:
+			return data[k]
+		}
+	}
+	slices.Sort(data[lo:hi])
+	return data[k]
+}
+
+// partition3 reorders data into values below, equal to and above pivot,
+// returning the bounds of the equal run. Grouping ties keeps selection
+// linear on data with many repeated values, such as anomaly scores of
+// duplicate samples.
+func partition3(data []float64, pivot float64) (lt, gt int) {
+	lt, i, gt := 0, 0, len(data)
+	for i < gt {
+		switch v := data[i]; {
+		case v < pivot:
+			data[lt], data[i] = data[i], data[lt]
+			lt++
+			i++
+		case v > pivot:
+			gt--
+			data[i], data[gt] = data[gt], data[i]
+		default:
+			i++
+		}
+	}
+	return lt, gt
+}
+
+// medianOfThree picks a pivot from the first, middle and last values.
+func medianOfThree(data []float64) float64 {
+	a, b, c := data[0], data[len(data)/2], data[len(data)-1]
+	if a > b {
+		a, b = b, a
+	}
+	if b > c {
+		b = c
+	}
+	return max(a, b)
+}
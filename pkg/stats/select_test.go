@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]float64, 1000)
+	for i := range random {
+		random[i] = rng.NormFloat64()
+	}
+	ties := make([]float64, 1000)
+	for i := range ties {
+		ties[i] = float64(rng.Intn(3))
+	}
+	ascending := make([]float64, 1000)
+	for i := range ascending {
+		ascending[i] = float64(i)
+	}
+	descending := slices.Clone(ascending)
+	slices.Reverse(descending)
+
+	tests := []struct {
+		name string
+		data []float64
+	}{
+		{name: "single", data: []float64{4}},
+		{name: "small", data: []float64{3, 1, 2, 5, 4}},
+		{name: "random", data: random},
+		{name: "ties", data: ties},
+		{name: "constant", data: make([]float64, 100)},
+		{name: "ascending", data: ascending},
+		{name: "descending", data: descending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := slices.Clone(tt.data)
+			slices.Sort(sorted)
+
+			for _, k := range []int{0, len(sorted) / 3, len(sorted) / 2, len(sorted) - 1} {
+				data := slices.Clone(tt.data)
+				assert.Equal(t, sorted[k], Select(data, k), "k=%d", k)
+				assert.Equal(t, sorted[k], data[k])
+				for i := range data {
+					if i < k {
+						assert.LessOrEqual(t, data[i], data[k])
+					} else {
+						assert.GreaterOrEqual(t, data[i], data[k])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSelectOutOfRange(t *testing.T) {
+	assert.Panics(t, func() { Select([]float64{1}, 1) })
+	assert.Panics(t, func() { Select(nil, 0) })
+}
+
+func BenchmarkSelect(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	src := make([]float64, 1_000_000)
+	for i := range src {
+		src[i] = rng.Float64()
+	}
+	data := make([]float64, len(src))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(data, src)
+		Select(data, len(data)*9/10)
+	}
+}
@@ -0,0 +1,214 @@
+// Package stats provides streaming statistics for anomaly scores.
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression is the t-digest compression used by NewTDigest when
+// given a non-positive value. Higher values keep more centroids and give
+// more accurate quantiles.
+const DefaultCompression = 100
+
+// TDigest estimates quantiles of a stream in bounded memory, with the
+// highest accuracy near the tails (Dunning, "Computing Extremely Accurate
+// Quantiles Using t-Digests"). It is not safe for concurrent use.
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	min, max    float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// NewTDigest creates an empty digest with the given compression.
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add records a value.
+func (t *TDigest) Add(x float64) {
+	t.AddWeighted(x, 1)
+}
+
+// AddWeighted records a value with the given weight. NaN values and
+// non-positive weights are ignored.
+func (t *TDigest) AddWeighted(x, w float64) {
+	if math.IsNaN(x) || w <= 0 {
+		return
+	}
+	t.buffer = append(t.buffer, centroid{mean: x, weight: w})
+	t.count += w
+	t.min = math.Min(t.min, x)
+	t.max = math.Max(t.max, x)
+
+	if len(t.buffer) >= t.bufferLimit() {
+		t.compress()
+	}
+}
+
+// Merge adds all values recorded by other.
+func (t *TDigest) Merge(other *TDigest) {
+	other.compress()
+	for _, c := range other.centroids {
+		t.buffer = append(t.buffer, c)
+		t.count += c.weight
+	}
+	if other.count > 0 {
+		t.min = math.Min(t.min, other.min)
+		t.max = math.Max(t.max, other.max)
+	}
+	t.compress()
+}
+
+// Count returns the total weight recorded.
+func (t *TDigest) Count() float64 {
+	return t.count
+}
+
+// Min returns the smallest value recorded, or NaN if empty.
+func (t *TDigest) Min() float64 {
+	if t.count == 0 {
+		return math.NaN()
+	}
+	return t.min
+}
+
+// Max returns the largest value recorded, or NaN if empty.
+func (t *TDigest) Max() float64 {
+	if t.count == 0 {
+		return math.NaN()
+	}
+	return t.max
+}
+
+// Quantile returns the estimated q-quantile, q in [0, 1], or NaN if empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if t.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return t.min
+	}
+	if q >= 1 {
+		return t.max
+	}
+
+	// Each centroid's weight is centered on its mean; interpolate between
+	// neighboring centers, and between the extremes and the outer centers.
+	index := q * t.count
+	first := t.centroids[0]
+	if index < first.weight/2 {
+		return t.min + (first.mean-t.min)*index/(first.weight/2)
+	}
+
+	cumulative := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, cur := t.centroids[i-1], t.centroids[i]
+		step := (prev.weight + cur.weight) / 2
+		if index < cumulative+step {
+			return prev.mean + (cur.mean-prev.mean)*(index-cumulative)/step
+		}
+		cumulative += step
+	}
+
+	last := t.centroids[len(t.centroids)-1]
+	rest := t.count - cumulative
+	if rest <= 0 {
+		return t.max
+	}
+	return last.mean + (t.max-last.mean)*(index-cumulative)/rest
+}
+
+// CDF returns the estimated fraction of values less than or equal to x,
+// or NaN if empty.
+func (t *TDigest) CDF(x float64) float64 {
+	t.compress()
+	if t.count == 0 || math.IsNaN(x) {
+		return math.NaN()
+	}
+	if x < t.min {
+		return 0
+	}
+	if x >= t.max {
+		return 1
+	}
+
+	first := t.centroids[0]
+	if x < first.mean {
+		if first.mean == t.min {
+			return 0
+		}
+		return (first.weight / 2) * (x - t.min) / (first.mean - t.min) / t.count
+	}
+
+	cumulative := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, cur := t.centroids[i-1], t.centroids[i]
+		step := (prev.weight + cur.weight) / 2
+		if x < cur.mean {
+			return (cumulative + step*(x-prev.mean)/(cur.mean-prev.mean)) / t.count
+		}
+		cumulative += step
+	}
+
+	last := t.centroids[len(t.centroids)-1]
+	rest := t.count - cumulative
+	return (cumulative + rest*(x-last.mean)/(t.max-last.mean)) / t.count
+}
+
+// bufferLimit is the number of unmerged values kept before compressing.
+func (t *TDigest) bufferLimit() int {
+	return int(5 * t.compression)
+}
+
+// compress merges buffered values into the centroids, keeping each
+// centroid within the size allowed by the k1 scale function.
+func (t *TDigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+
+	all := append(t.buffer, t.centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, int(t.compression))
+	cur := all[0]
+	soFar := 0.0
+	limit := t.qLimit(0)
+	for _, c := range all[1:] {
+		if (soFar+cur.weight+c.weight)/t.count <= limit {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+			continue
+		}
+		merged = append(merged, cur)
+		soFar += cur.weight
+		limit = t.qLimit(soFar / t.count)
+		cur = c
+	}
+	merged = append(merged, cur)
+
+	t.centroids = merged
+	t.buffer = t.buffer[:0]
+}
+
+// qLimit returns the largest quantile a centroid starting at q may reach.
+func (t *TDigest) qLimit(q float64) float64 {
+	k := t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+	return (math.Sin((k+1)*2*math.Pi/t.compression) + 1) / 2
+}
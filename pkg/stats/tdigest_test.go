@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTDigestQuantile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name string
+		gen  func() float64
+	}{
+		{name: "uniform", gen: rng.Float64},
+		{name: "normal", gen: rng.NormFloat64},
+		{name: "exponential", gen: rng.ExpFloat64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := NewTDigest(0)
+			values := make([]float64, 50000)
+			for i := range values {
+				values[i] = tt.gen()
+				td.Add(values[i])
+			}
+			sort.Float64s(values)
+
+			assert.Equal(t, float64(len(values)), td.Count())
+			assert.Equal(t, values[0], td.Quantile(0))
+			assert.Equal(t, values[len(values)-1], td.Quantile(1))
+
+			for _, q := range []float64{0.001, 0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+				got := td.Quantile(q)
+				// Compare ranks rather than values so the tolerance does
+				// not depend on the distribution's scale.
+				rank := float64(sort.SearchFloat64s(values, got)) / float64(len(values))
+				assert.InDelta(t, q, rank, 0.005+q*(1-q)*0.02, "q=%v", q)
+				assert.InDelta(t, q, td.CDF(got), 0.005+q*(1-q)*0.02, "cdf at q=%v", q)
+			}
+		})
+	}
+}
+
+func TestTDigestSmall(t *testing.T) {
+	td := NewTDigest(100)
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		td.Add(v)
+	}
+
+	assert.Equal(t, 1.0, td.Min())
+	assert.Equal(t, 5.0, td.Max())
+	assert.InDelta(t, 3, td.Quantile(0.5), 1e-9)
+	assert.Equal(t, 0.0, td.CDF(0))
+	assert.Equal(t, 1.0, td.CDF(5))
+	assert.InDelta(t, 0.5, td.CDF(3), 1e-9)
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	td := NewTDigest(100)
+	td.Add(math.NaN())
+	td.AddWeighted(1, 0)
+
+	assert.Zero(t, td.Count())
+	assert.True(t, math.IsNaN(td.Quantile(0.5)))
+	assert.True(t, math.IsNaN(td.CDF(0.5)))
+	assert.True(t, math.IsNaN(td.Min()))
+}
+
+func TestTDigestMerge(t *testing.T) {
+	a, b, all := NewTDigest(100), NewTDigest(100), NewTDigest(100)
+	for i := 0; i < 10000; i++ {
+		v := float64(i) / 10000
+		all.Add(v)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+
+	a.Merge(b)
+	assert.Equal(t, all.Count(), a.Count())
+	assert.Equal(t, all.Min(), a.Min())
+	assert.Equal(t, all.Max(), a.Max())
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		assert.InDelta(t, all.Quantile(q), a.Quantile(q), 0.005)
+	}
+}
+
+func TestTDigestBoundedSize(t *testing.T) {
+	td := NewTDigest(50)
+	for i := 0; i < 100000; i++ {
+		td.Add(float64(i))
+	}
+	td.compress()
+	assert.LessOrEqual(t, len(td.centroids), 100)
+}